@@ -377,7 +377,8 @@ func DKIMRemove(ctx context.Context, domain, selector dns.Domain) (rerr error) {
 	}
 
 	nd := d
-	nd.DKIM = config.DKIM{Selectors: nsels, Sign: nsign}
+	nd.DKIM.Selectors = nsels
+	nd.DKIM.Sign = nsign
 	nc := c
 	nc.Domains = map[string]config.Domain{}
 	maps.Copy(nc.Domains, c.Domains)
@@ -402,7 +403,10 @@ func DKIMRemove(ctx context.Context, domain, selector dns.Domain) (rerr error) {
 // accountName is used for DMARC/TLS report and potentially for the postmaster address.
 // If the account does not exist, it is created with localpart. Localpart must be
 // set only if the account does not yet exist.
-func DomainAdd(ctx context.Context, disabled bool, domain dns.Domain, accountName string, localpart smtp.Localpart) (rerr error) {
+//
+// If aliasOf is set, the new domain is an alias of that existing domain, and no
+// postmaster address is added to the account, the account must already exist.
+func DomainAdd(ctx context.Context, disabled bool, domain dns.Domain, accountName string, localpart smtp.Localpart, aliasOf dns.Domain) (rerr error) {
 	log := pkglog.WithContext(ctx)
 	defer func() {
 		if rerr != nil {
@@ -410,7 +414,8 @@ func DomainAdd(ctx context.Context, disabled bool, domain dns.Domain, accountNam
 				slog.Any("disabled", disabled),
 				slog.Any("domain", domain),
 				slog.String("account", accountName),
-				slog.Any("localpart", localpart))
+				slog.Any("localpart", localpart),
+				slog.Any("aliasof", aliasOf))
 		}
 	}()
 
@@ -420,6 +425,13 @@ func DomainAdd(ctx context.Context, disabled bool, domain dns.Domain, accountNam
 	if _, ok := c.Domains[domain.Name()]; ok {
 		return fmt.Errorf("%w: domain already present", ErrRequest)
 	}
+	if aliasOf != (dns.Domain{}) {
+		if _, ok := c.Domains[aliasOf.Name()]; !ok {
+			return fmt.Errorf("%w: domain to alias does not exist", ErrRequest)
+		} else if _, ok := c.Accounts[accountName]; !ok || localpart != "" {
+			return fmt.Errorf("%w: alias domain requires an existing account and no localpart", ErrRequest)
+		}
+	}
 
 	// Compose new config without modifying existing data structures. If we fail, we
 	// leave no trace.
@@ -447,6 +459,12 @@ func DomainAdd(ctx context.Context, disabled bool, domain dns.Domain, accountNam
 		}
 	}()
 	confDomain.Disabled = disabled
+	if aliasOf != (dns.Domain{}) {
+		// Localpart handling is determined by the domain we are an alias of.
+		confDomain.AliasOf = aliasOf.Name()
+		confDomain.LocalpartCatchallSeparator = ""
+		confDomain.LocalpartCatchallSeparators = nil
+	}
 
 	if _, ok := c.Accounts[accountName]; ok && localpart != "" {
 		return fmt.Errorf("%w: account already exists (leave localpart empty when using an existing account)", ErrRequest)
@@ -456,7 +474,7 @@ func DomainAdd(ctx context.Context, disabled bool, domain dns.Domain, accountNam
 		return fmt.Errorf("%w: account name is empty", ErrRequest)
 	} else if !ok {
 		nc.Accounts[accountName] = MakeAccountConfig(smtp.NewAddress(localpart, domain))
-	} else if accountName != mox.Conf.Static.Postmaster.Account {
+	} else if accountName != mox.Conf.Static.Postmaster.Account && aliasOf == (dns.Domain{}) {
 		nacc := nc.Accounts[accountName]
 		nd := map[string]config.Destination{}
 		maps.Copy(nd, nacc.Destinations)
@@ -471,7 +489,7 @@ func DomainAdd(ctx context.Context, disabled bool, domain dns.Domain, accountNam
 	if err := mox.WriteDynamicLocked(ctx, log, nc); err != nil {
 		return fmt.Errorf("writing domains.conf: %w", err)
 	}
-	log.Info("domain added", slog.Any("domain", domain), slog.Bool("disabled", disabled))
+	log.Info("domain added", slog.Any("domain", domain), slog.Bool("disabled", disabled), slog.Any("aliasof", aliasOf))
 	cleanupFiles = nil // All good, don't cleanup.
 	return nil
 }
@@ -531,11 +549,23 @@ func DomainRemove(ctx context.Context, domain dns.Domain) (rerr error) {
 	return nil
 }
 
+// dkimKeyPath returns the path of the private key file for a DKIM selector,
+// relative to the config directory, with a "file:" prefix removed. False is
+// returned for "env:" and "vault:" references, which are not files.
+func dkimKeyPath(privateKeyFile string) (string, bool) {
+	if privateKeyFile == "" || mox.IsSecretRef(privateKeyFile) && !strings.HasPrefix(privateKeyFile, "file:") {
+		return "", false
+	}
+	return filepath.Clean(strings.TrimPrefix(privateKeyFile, "file:")), true
+}
+
 func gatherUsedKeysPaths(nc config.Dynamic) map[string]bool {
 	usedKeyPaths := map[string]bool{}
 	for _, dc := range nc.Domains {
 		for _, sel := range dc.DKIM.Selectors {
-			usedKeyPaths[filepath.Clean(sel.PrivateKeyFile)] = true
+			if p, ok := dkimKeyPath(sel.PrivateKeyFile); ok {
+				usedKeyPaths[p] = true
+			}
 		}
 	}
 	return usedKeyPaths
@@ -543,11 +573,12 @@ func gatherUsedKeysPaths(nc config.Dynamic) map[string]bool {
 
 func moveAwayKeys(log mlog.Log, sels map[string]config.Selector, usedKeyPaths map[string]bool) {
 	for _, sel := range sels {
-		if sel.PrivateKeyFile == "" || usedKeyPaths[filepath.Clean(sel.PrivateKeyFile)] {
+		p, ok := dkimKeyPath(sel.PrivateKeyFile)
+		if !ok || usedKeyPaths[p] {
 			continue
 		}
-		src := mox.ConfigDirPath(sel.PrivateKeyFile)
-		dst := mox.ConfigDirPath(filepath.Join(filepath.Dir(sel.PrivateKeyFile), "old", filepath.Base(sel.PrivateKeyFile)))
+		src := mox.ConfigDirPath(p)
+		dst := mox.ConfigDirPath(filepath.Join(filepath.Dir(p), "old", filepath.Base(p)))
 		_, err := os.Stat(dst)
 		if err == nil {
 			err = fmt.Errorf("destination already exists")
@@ -6,12 +6,14 @@ import (
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 	"net/url"
 	"strings"
 
 	"github.com/mjl-/adns"
 
+	"github.com/mjl-/mox/bimi"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dmarc"
@@ -19,19 +21,53 @@ import (
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/spf"
+	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/tlsrpt"
+	"github.com/mjl-/mox/wkd"
 	"slices"
 )
 
 // todo: find a way to automatically create the dns records as it would greatly simplify setting up email for a domain. we could also dynamically make changes, e.g. providing grace periods after disabling a dkim key, only automatically removing the dkim dns key after a few days. but this requires some kind of api and authentication to the dns server. there doesn't appear to be a single commonly used api for dns management. each of the numerous cloud providers have their own APIs and rather large SKDs to use them. we don't want to link all of them in.
 
+// SubdomainMXNames returns the DNS names, in ASCII without trailing dot, that
+// need an MX record for accepting messages for subdomains of the domain, possibly
+// wildcards. Nil is returned if the domain does not accept messages for
+// subdomains.
+func SubdomainMXNames(domConf config.Domain, domain dns.Domain) []string {
+	if domConf.Subdomains == nil {
+		return nil
+	}
+	if len(domConf.Subdomains.Routes) == 0 {
+		return []string{"*." + domain.ASCII}
+	}
+	var names []string
+	for _, r := range domConf.Subdomains.Routes {
+		var name string
+		if r.Wildcard && r.SubdomainASCII == "" {
+			name = "*." + domain.ASCII
+		} else if r.Wildcard {
+			name = "*." + r.SubdomainASCII + "." + domain.ASCII
+		} else {
+			name = r.SubdomainASCII + "." + domain.ASCII
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 // DomainRecords returns text lines describing DNS records required for configuring
 // a domain.
 //
 // If certIssuerDomainName is set, CAA records to limit TLS certificate issuance to
 // that caID will be suggested. If acmeAccountURI is also set, CAA records also
 // restricting issuance to that account ID will be suggested.
-func DomainRecords(domConf config.Domain, domain dns.Domain, hasDNSSEC bool, certIssuerDomainName, acmeAccountURI string) ([]string, error) {
+//
+// OPENPGPKEY records are returned for openpgpKeys, the OpenPGP keys of accounts
+// for addresses in the domain. If openpgpKeys is nil, the keys are not known and a
+// note is added instead.
+func DomainRecords(domConf config.Domain, domain dns.Domain, hasDNSSEC bool, certIssuerDomainName, acmeAccountURI string, openpgpKeys []store.OpenPGPKey) ([]string, error) {
 	d := domain.ASCII
 	h := mox.Conf.Static.HostnameDomain.ASCII
 	csd := h
@@ -131,6 +167,16 @@ func DomainRecords(domConf config.Domain, domain dns.Domain, hasDNSSEC bool, cer
 		"; Deliver email for the domain to this host.",
 		fmt.Sprintf("%s.                    MX 10 %s.", d, h),
 		"",
+	)
+	if names := SubdomainMXNames(domConf, domain); len(names) > 0 {
+		records = append(records, "; Deliver email for subdomains of the domain to this host.")
+		for _, name := range names {
+			records = append(records, fmt.Sprintf("%s.                    MX 10 %s.", name, h))
+		}
+		records = append(records, "")
+	}
+
+	records = append(records,
 
 		"; Outgoing messages will be signed with the first two DKIM keys. The other two",
 		"; configured for backup, switching to them is just a config change.",
@@ -170,6 +216,9 @@ func DomainRecords(domConf config.Domain, domain dns.Domain, hasDNSSEC bool, cer
 	}
 	dmarcr := dmarc.DefaultRecord
 	dmarcr.Policy = "reject"
+	if domConf.Subdomains != nil {
+		dmarcr.SubdomainPolicy = dmarc.Policy(domConf.Subdomains.DMARCPolicy)
+	}
 	if domConf.DMARC != nil {
 		uri := url.URL{
 			Scheme: "mailto",
@@ -242,6 +291,60 @@ func DomainRecords(domConf config.Domain, domain dns.Domain, hasDNSSEC bool, cer
 		)
 	}
 
+	if b := domConf.BIMI; b != nil {
+		sel := b.Selector
+		if sel == "" {
+			sel = bimi.DefaultSelector
+		}
+		bimir := bimi.DefaultRecord
+		bimir.Location = b.Location
+		bimir.Authority = b.Authority
+		records = append(records,
+			"; Brand logo that receiving mail clients may show for messages passing DMARC.",
+			"; Requires an enforcing DMARC policy (p=quarantine or p=reject).",
+			fmt.Sprintf(`%s._bimi.%s.      TXT "%s"`, sel, d, bimir.String()),
+			"",
+		)
+	}
+
+	var haveWKD bool
+	for _, l := range mox.Conf.Static.Listeners {
+		if l.WKDHTTPS.Enabled {
+			haveWKD = true
+			break
+		}
+	}
+	if haveWKD {
+		records = append(records,
+			"; OpenPGP keys of accounts are published through the Web Key Directory (WKD).",
+			fmt.Sprintf(`openpgpkey.%s.         CNAME %s.`, d, h),
+			"",
+		)
+	}
+	if openpgpKeys == nil {
+		records = append(records,
+			"; Note: OpenPGP keys of accounts are not known, OPENPGPKEY records are not included.",
+			"; Run this command while mox is running to include them.",
+			"",
+		)
+	} else if len(openpgpKeys) > 0 {
+		// ../rfc/7929
+		records = append(records,
+			"; OpenPGP keys of accounts, for discovery by correspondents. Only useful with DNSSEC.",
+		)
+		for _, pk := range openpgpKeys {
+			addr, err := smtp.ParseAddress(pk.Address)
+			if err != nil {
+				return nil, fmt.Errorf("parsing address of openpgp key: %v", err)
+			}
+			records = append(records,
+				"; "+pk.Address,
+				fmt.Sprintf(`%s OPENPGPKEY %s`, wkd.DNSName(addr.Localpart, domain), base64.StdEncoding.EncodeToString(pk.KeyData)),
+			)
+		}
+		records = append(records, "")
+	}
+
 	if csd != h {
 		records = append(records,
 			"; Client settings will reference a subdomain of the hosted domain, making it",
@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/publicsuffix"
+	"github.com/mjl-/mox/rejectdb"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// RejectedDeliver delivers the retained rejected message id to the accounts of
+// its recipients, without the checks that caused the rejection. Recipients that
+// are aliases are expanded to their members. Recipients that no longer are an
+// account or alias address are skipped. The rulesets of the destinations are
+// applied. The addresses the message was delivered to are returned.
+func RejectedDeliver(ctx context.Context, id int64) (delivered []string, rerr error) {
+	log := pkglog.WithContext(ctx)
+
+	rm, data, err := rejectdb.Get(ctx, id)
+	if err == bstore.ErrAbsent {
+		return nil, fmt.Errorf("%w: no such message", ErrRequest)
+	} else if err != nil {
+		return nil, fmt.Errorf("get message: %v", err)
+	}
+
+	type target struct {
+		accountName string
+		dest        config.Destination
+		addr        smtp.Address
+	}
+	var targets []target
+	seen := map[string]bool{}
+	add := func(accountName string, dest config.Destination, addr smtp.Address) {
+		if !seen[addr.String()] {
+			seen[addr.String()] = true
+			targets = append(targets, target{accountName, dest, addr})
+		}
+	}
+	for _, s := range rm.RcptTo {
+		addr, err := smtp.ParseAddress(s)
+		if err != nil {
+			log.Infox("parsing recipient of rejected message", err, slog.String("rcptto", s))
+			continue
+		}
+		accountName, alias, _, dest, err := mox.LookupAddress(addr.Localpart, addr.Domain, false, true, false)
+		if err != nil {
+			log.Infox("looking up recipient of rejected message", err, slog.Any("rcptto", addr))
+			continue
+		}
+		if alias != nil {
+			for _, aa := range alias.ParsedAddresses {
+				add(aa.AccountName, aa.Destination, aa.Address)
+			}
+		} else {
+			add(accountName, dest, addr)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%w: no recipients with accounts", ErrRequest)
+	}
+
+	msgFile, err := store.CreateMessageTemp(log, "rejected-deliver")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary message file: %v", err)
+	}
+	defer store.CloseRemoveTempFile(log, msgFile, "rejected message for delivery")
+	if _, err := msgFile.Write(data); err != nil {
+		return nil, fmt.Errorf("writing temporary message file: %v", err)
+	}
+
+	// Fields for the message, for use in rulesets and the junk filter.
+	var ehloDomain string
+	if d, err := dns.ParseDomain(rm.EHLO); err == nil {
+		ehloDomain = d.Name()
+	}
+	var mailFrom smtp.Address
+	if rm.MailFrom != "" {
+		mailFrom, err = smtp.ParseAddress(rm.MailFrom)
+		log.Check(err, "parsing mail from of rejected message", slog.String("mailfrom", rm.MailFrom))
+	}
+	var msgFrom smtp.Address
+	if rm.MsgFrom != "" {
+		msgFrom, err = smtp.ParseAddress(rm.MsgFrom)
+		log.Check(err, "parsing message from of rejected message", slog.String("msgfrom", rm.MsgFrom))
+	}
+
+	for _, t := range targets {
+		m := store.Message{
+			Received:          rm.Received,
+			RemoteIP:          rm.RemoteIP,
+			EHLODomain:        ehloDomain,
+			MailFrom:          rm.MailFrom,
+			MailFromLocalpart: mailFrom.Localpart,
+			MailFromDomain:    mailFrom.Domain.Name(),
+			RcptToLocalpart:   t.addr.Localpart,
+			RcptToDomain:      t.addr.Domain.Name(),
+			MsgFromLocalpart:  msgFrom.Localpart,
+			MsgFromDomain:     msgFrom.Domain.Name(),
+			MsgFromOrgDomain:  publicsuffix.Lookup(ctx, log.Logger, msgFrom.Domain).Name(),
+			Size:              int64(len(data)),
+		}
+
+		acc, err := store.OpenAccount(log, t.accountName, false)
+		if err != nil {
+			return delivered, fmt.Errorf("open account %s: %v", t.accountName, err)
+		}
+		acc.WithWLock(func() {
+			err = acc.DeliverDestination(log, t.dest, &m, msgFile)
+		})
+		xerr := acc.Close()
+		log.Check(xerr, "closing account after delivering rejected message")
+		if errors.Is(err, store.ErrOverQuota) {
+			return delivered, fmt.Errorf("%w: delivering to %s: account over quota", ErrRequest, t.addr)
+		} else if err != nil {
+			return delivered, fmt.Errorf("delivering to %s: %v", t.addr, err)
+		}
+		log.Info("delivered retained rejected message", slog.Int64("id", id), slog.Any("rcptto", t.addr), slog.String("account", t.accountName))
+		delivered = append(delivered, t.addr.String())
+	}
+
+	if err := rejectdb.MarkDelivered(ctx, id, time.Now()); err != nil {
+		return delivered, fmt.Errorf("marking message as delivered: %v", err)
+	}
+	return delivered, nil
+}
@@ -0,0 +1,179 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/mjl-/sconf"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+)
+
+// DomainRetire starts decommissioning a domain with the settings of retire, or
+// stops it if retire is nil. Logins of accounts with the domain as their default
+// domain are disabled immediately if the grace period is already over.
+func DomainRetire(ctx context.Context, d dns.Domain, retire *config.DomainRetire) error {
+	err := DomainSave(ctx, d.Name(), func(domain *config.Domain) error {
+		domain.Retire = retire
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return RetireApply(ctx, time.Now())
+}
+
+// retiredAccounts returns the names of accounts with domain d as their default
+// domain, in sorted order.
+func retiredAccounts(d dns.Domain) []string {
+	var l []string
+	for _, name := range mox.Conf.Accounts() {
+		if acc, ok := mox.Conf.Account(name); ok && acc.Domain == d.Name() {
+			l = append(l, name)
+		}
+	}
+	slices.Sort(l)
+	return l
+}
+
+// RetireApply disables logins of accounts at retired domains whose grace period
+// for logins ended before now. Accounts that already have logins disabled are
+// left as is.
+func RetireApply(ctx context.Context, now time.Time) error {
+	log := pkglog.WithContext(ctx)
+
+	for _, name := range mox.Conf.Domains() {
+		d, err := dns.ParseDomain(name)
+		if err != nil {
+			log.Errorx("parsing domain from config", err)
+			continue
+		}
+		dc, ok := mox.Conf.Domain(d)
+		if !ok || dc.Retire == nil || dc.Retire.LoginUntilTime.IsZero() || now.Before(dc.Retire.LoginUntilTime) {
+			continue
+		}
+		for _, accName := range retiredAccounts(dc.Domain) {
+			if acc, ok := mox.Conf.Account(accName); !ok || acc.LoginDisabled != "" {
+				continue
+			}
+			err := AccountSave(ctx, accName, func(acc *config.Account) {
+				acc.LoginDisabled = fmt.Sprintf("domain %s has been retired", dc.Domain.Name())
+			})
+			if err != nil {
+				return fmt.Errorf("disabling login for account %s: %v", accName, err)
+			}
+			log.Info("disabled login for account of retired domain", slog.String("account", accName), slog.Any("domain", dc.Domain))
+		}
+	}
+	return nil
+}
+
+// StartRetire starts a goroutine that periodically disables logins for accounts
+// of retired domains after their grace period.
+func StartRetire() {
+	log := mlog.New("admin", nil)
+
+	go func() {
+		defer func() {
+			x := recover()
+			if x == nil {
+				return
+			}
+
+			log.Error("unhandled panic in applying domain retirements", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Admin)
+		}()
+
+		t := time.NewTicker(time.Hour)
+		defer t.Stop()
+		for {
+			err := RetireApply(mox.Shutdown, time.Now())
+			log.Check(err, "applying domain retirements")
+
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// prefixArchiver adds files to an archiver under a directory.
+type prefixArchiver struct {
+	store.Archiver
+	prefix string
+}
+
+func (a prefixArchiver) Create(name string, size int64, mtime time.Time) (io.WriteCloser, error) {
+	return a.Archiver.Create(a.prefix+name, size, mtime)
+}
+
+// DomainExport writes a bundle for the domain to archiver, e.g. before removing
+// a retired domain: A file "domains.conf" with the configuration of the domain
+// and of the accounts with the domain as their default domain, and the messages
+// of those accounts in maildir format, under "accounts/<name>/". The archiver is
+// not closed.
+func DomainExport(ctx context.Context, log mlog.Log, d dns.Domain, archiver store.Archiver) error {
+	dc, ok := mox.Conf.Domain(d)
+	if !ok {
+		return fmt.Errorf("%w: domain not present", ErrRequest)
+	}
+
+	accounts := retiredAccounts(d)
+	dyn := config.Dynamic{
+		Domains:  map[string]config.Domain{d.Name(): dc},
+		Accounts: map[string]config.Account{},
+	}
+	for _, name := range accounts {
+		acc, _ := mox.Conf.Account(name)
+		dyn.Accounts[name] = acc
+	}
+	var b bytes.Buffer
+	if err := sconf.Write(&b, dyn); err != nil {
+		return fmt.Errorf("writing config: %v", err)
+	}
+	w, err := archiver.Create("domains.conf", int64(b.Len()), time.Now())
+	if err != nil {
+		return fmt.Errorf("adding config to archive: %v", err)
+	}
+	if _, err := w.Write(b.Bytes()); err != nil {
+		w.Close()
+		return fmt.Errorf("writing config to archive: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("closing config in archive: %v", err)
+	}
+
+	for _, name := range accounts {
+		if err := exportAccount(ctx, log, name, prefixArchiver{archiver, path.Join("accounts", name) + "/"}); err != nil {
+			return fmt.Errorf("exporting account %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func exportAccount(ctx context.Context, log mlog.Log, name string, archiver store.Archiver) (rerr error) {
+	acc, err := store.OpenAccount(log, name, false)
+	if err != nil {
+		return fmt.Errorf("open account: %v", err)
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account after export")
+	}()
+	return store.ExportMessages(ctx, log, acc.DB, acc.Dir, archiver, true, "", nil, true)
+}
@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mjl-/mox/mox-"
+)
+
+// Serializes replacing of static TLS keys/certificates.
+var tlsKeyCertLock sync.Mutex
+
+// TLSKeyCertReplace replaces the certificate and private key of KeyCerts entry
+// index in the TLS config of the listener, with PEM-encoded certificate chain
+// certPEM and private key keyPEM. The configured files are replaced, and new TLS
+// connections use the new certificate immediately.
+//
+// The files must be writable by the mox process. Key and certificate files are
+// typically opened by the privileged root process at startup, and may not be
+// writable.
+func TLSKeyCertReplace(ctx context.Context, listenerName string, index int, certPEM, keyPEM []byte) (rerr error) {
+	log := pkglog.WithContext(ctx)
+	defer func() {
+		if rerr != nil {
+			log.Errorx("replacing tls key and certificate", rerr, slog.String("listener", listenerName), slog.Int("index", index))
+		}
+	}()
+
+	l, ok := mox.Conf.Static.Listeners[listenerName]
+	if !ok || l.TLS == nil || l.TLS.KeyCertsLoaded == nil || index < 0 || index >= len(l.TLS.KeyCerts) {
+		return fmt.Errorf("%w: no such tls key/certificate", ErrRequest)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("%w: parsing certificate and key: %v", ErrRequest, err)
+	}
+	if time.Now().After(cert.Leaf.NotAfter) {
+		return fmt.Errorf("%w: certificate has expired", ErrRequest)
+	}
+
+	tlsKeyCertLock.Lock()
+	defer tlsKeyCertLock.Unlock()
+
+	// Write new files next to the current files, and only rename when both have been
+	// written.
+	kc := l.TLS.KeyCerts[index]
+	certPath := mox.ConfigDirPath(kc.CertFile)
+	keyPath := mox.ConfigDirPath(kc.KeyFile)
+	var tmpPaths []string
+	defer func() {
+		for _, p := range tmpPaths {
+			err := os.Remove(p)
+			log.Check(err, "removing temporary file", slog.String("path", p))
+		}
+	}()
+	for _, t := range [][2]string{{keyPath, string(keyPEM)}, {certPath, string(certPEM)}} {
+		p := t[0] + ".new"
+		os.Remove(p) // In case of earlier failure.
+		if err := writeFile(log, p, []byte(t[1])); err != nil {
+			return err
+		}
+		tmpPaths = append(tmpPaths, p)
+	}
+	for _, p := range []string{keyPath, certPath} {
+		if err := os.Rename(p+".new", p); err != nil {
+			return fmt.Errorf("replacing file: %v", err)
+		}
+		tmpPaths = tmpPaths[1:]
+	}
+
+	certs := slices.Clone(*l.TLS.KeyCertsLoaded.Load())
+	certs[index] = cert
+	l.TLS.KeyCertsLoaded.Store(&certs)
+
+	log.Info("tls key and certificate replaced",
+		slog.String("listener", listenerName),
+		slog.String("certfile", certPath),
+		slog.Time("notafter", cert.Leaf.NotAfter))
+	return nil
+}
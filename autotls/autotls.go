@@ -22,8 +22,10 @@ import (
 	"io"
 	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"runtime/debug"
 	"sort"
 	"strings"
 	"sync"
@@ -37,6 +39,7 @@ import (
 	"github.com/mjl-/autocert"
 
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/moxvar"
 )
@@ -76,8 +79,30 @@ type Manager struct {
 
 	shutdown <-chan struct{}
 
+	limiter *orderLimiter
+
 	sync.Mutex
-	hosts map[dns.Domain]struct{}
+	hosts      map[dns.Domain]struct{}
+	delegates  map[dns.Domain]*Manager     // Hosts for which certificates come from another manager.
+	hostStates map[string]HostState        // By ASCII host name.
+	renewed    map[string]*tls.Certificate // By cache key, certificates from Renew until autocert uses them.
+}
+
+// HostState is the state of certificate requests for a host name.
+type HostState struct {
+	Renewing      bool   // Whether a renewal started with Renew is in progress.
+	LastError     string // Last error requesting a certificate, cleared when a request succeeds.
+	LastErrorTime time.Time
+	Failures      int       // Consecutive failed certificate requests.
+	NextAttempt   time.Time // After failures, no new certificate is requested before this time.
+}
+
+// failureBackoff returns how long to wait with a new certificate request for a
+// host after n consecutive failures. ACME providers typically limit the number of
+// failed validations per host name, e.g. 5 per hour for Let's Encrypt.
+func failureBackoff(n int) time.Duration {
+	n = min(max(n, 1), 7)
+	return min(5*time.Minute<<(n-1), 3*time.Hour)
 }
 
 // Load returns an initialized autotls manager for "name" (used for the ACME key
@@ -157,6 +182,7 @@ func Load(log mlog.Log, name, acmeDir, contactEmail, directoryURL string, eabKey
 		}
 	}
 
+	limiter := &orderLimiter{directoryURL: directoryURL, transport: http.DefaultTransport}
 	m := &autocert.Manager{
 		Cache:  dirCache(filepath.Join(acmeDir, "keycerts", name)),
 		Prompt: autocert.AcceptTOS,
@@ -165,6 +191,8 @@ func Load(log mlog.Log, name, acmeDir, contactEmail, directoryURL string, eabKey
 			DirectoryURL: directoryURL,
 			Key:          key,
 			UserAgent:    "mox/" + moxvar.Version,
+			HTTPClient:   &http.Client{Transport: limiter},
+			RetryBackoff: retryBackoff,
 		},
 		GetPrivateKey: getPrivateKey,
 		// HostPolicy set below.
@@ -179,9 +207,13 @@ func Load(log mlog.Log, name, acmeDir, contactEmail, directoryURL string, eabKey
 	}
 
 	a := &Manager{
-		Manager:  m,
-		shutdown: shutdown,
-		hosts:    map[dns.Domain]struct{}{},
+		Manager:    m,
+		shutdown:   shutdown,
+		limiter:    limiter,
+		hosts:      map[dns.Domain]struct{}{},
+		delegates:  map[dns.Domain]*Manager{},
+		hostStates: map[string]HostState{},
+		renewed:    map[string]*tls.Certificate{},
 	}
 	m.HostPolicy = a.HostPolicy
 	acmeTLSConfig := *m.TLSConfig()
@@ -192,6 +224,31 @@ func Load(log mlog.Log, name, acmeDir, contactEmail, directoryURL string, eabKey
 	return a, nil
 }
 
+// SetClientConfig configures the HTTPS connections to the ACME provider, and
+// should be called before the manager is used.
+//
+// If rootCAs is not nil, it is used for verifying the TLS certificate of the ACME
+// provider instead of the system CAs, e.g. for an internal ACME provider such as
+// Pebble or step-ca.
+//
+// If orderLimit is larger than zero, at most orderLimit new certificate orders are
+// made within orderLimitPeriod. Requests for certificates beyond the limit fail
+// until enough time has passed. This helps stay below rate limits of the ACME
+// provider. Regardless of the limit, new orders are paused when the ACME provider
+// responds with a rate limit error.
+func (m *Manager) SetClientConfig(rootCAs *x509.CertPool, orderLimit int, orderLimitPeriod time.Duration) {
+	m.limiter.Lock()
+	defer m.limiter.Unlock()
+
+	if rootCAs != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+		m.limiter.transport = t
+	}
+	m.limiter.limit = orderLimit
+	m.limiter.period = orderLimitPeriod
+}
+
 // loggingGetCertificate is a helper to implement crypto/tls.Config.GetCertificate,
 // optionally falling back to a certificate for fallbackHostname in case SNI is
 // absent or for an unknown hostname.
@@ -246,6 +303,22 @@ func (m *Manager) loggingGetCertificate(hello *tls.ClientHelloInfo, fallbackHost
 		}
 	}
 
+	host := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if d := m.delegate(host); d != nil {
+		// Host is configured to use another ACME provider, including for tls-alpn-01
+		// validation. The fallback hostname is not for that manager.
+		log.Debug("certificate from other acme provider")
+		return d.loggingGetCertificate(hello, dns.Domain{}, false, false)
+	}
+
+	isToken := len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+	if !isToken {
+		if err := m.checkBackoff(hello.Context(), host); err != nil {
+			log.Debugx("not requesting certificate", err)
+			return nil, err
+		}
+	}
+
 	cert, err := m.Manager.GetCertificate(hello)
 	if err != nil && errors.Is(err, errHostNotAllowed) {
 		if !fallbackUnknownSNI {
@@ -274,7 +347,83 @@ func (m *Manager) loggingGetCertificate(hello *tls.ClientHelloInfo, fallbackHost
 		metricCertRequestErrors.Inc()
 		log.Errorx("requesting certificate", err)
 	}
-	return cert, err
+	if isToken {
+		// Certificate for tls-alpn-01 validation.
+		return cert, err
+	}
+	return m.certResult(host, cert, err), err
+}
+
+// delegate returns the manager that host is delegated to with SetDelegates, or
+// nil.
+func (m *Manager) delegate(host string) *Manager {
+	d, err := dns.ParseDomain(host)
+	if err != nil {
+		return nil
+	}
+	m.Lock()
+	defer m.Unlock()
+	return m.delegates[d]
+}
+
+// checkBackoff returns an error if earlier certificate requests for host failed,
+// and it is too soon for a new request, unless a valid certificate is cached and
+// no new certificate request is needed.
+func (m *Manager) checkBackoff(ctx context.Context, host string) error {
+	m.Lock()
+	st := m.hostStates[host]
+	m.Unlock()
+	if st.Failures == 0 || time.Now().After(st.NextAttempt) {
+		return nil
+	}
+	d, err := dns.ParseDomain(host)
+	if err != nil {
+		return nil
+	}
+	if cert, err := m.CachedCertificate(ctx, d, false); err == nil && cert != nil && time.Now().Before(cert.NotAfter) {
+		return nil
+	}
+	return fmt.Errorf("certificate request postponed until %s after %d failed attempts", st.NextAttempt.Format(time.RFC3339), st.Failures)
+}
+
+// certResult records the result of a certificate request for host for
+// HostState, and returns the certificate to use. That is a certificate from Renew
+// if it is newer than the certificate from autocert, which keeps using its
+// previous certificate until its own renewal timer fires.
+func (m *Manager) certResult(host string, cert *tls.Certificate, err error) *tls.Certificate {
+	m.Lock()
+	defer m.Unlock()
+
+	st := m.hostStates[host]
+	if err != nil {
+		st.LastError = err.Error()
+		st.LastErrorTime = time.Now()
+		st.Failures++
+		st.NextAttempt = st.LastErrorTime.Add(failureBackoff(st.Failures))
+		m.hostStates[host] = st
+		return cert
+	} else if st.LastError != "" || st.Failures > 0 {
+		st.LastError = ""
+		st.LastErrorTime = time.Time{}
+		st.Failures = 0
+		st.NextAttempt = time.Time{}
+		m.hostStates[host] = st
+	}
+
+	if cert == nil || cert.Leaf == nil {
+		return cert
+	}
+	key := host
+	if _, ok := cert.PrivateKey.(*rsa.PrivateKey); ok {
+		key += "+rsa"
+	}
+	if nc, ok := m.renewed[key]; ok {
+		if nc.Leaf.NotAfter.After(cert.Leaf.NotAfter) {
+			return nc
+		}
+		delete(m.renewed, key)
+	}
+	return cert
 }
 
 // TLSConfig returns a TLS server config that optionally returns a certificate for
@@ -298,35 +447,174 @@ func (m *Manager) TLSConfig(fallbackHostname dns.Domain, fallbackNoSNI, fallback
 // CertAvailable checks whether a non-expired ECDSA certificate is available in the
 // cache for host. No other checks than expiration are done.
 func (m *Manager) CertAvailable(ctx context.Context, log mlog.Log, host dns.Domain) (bool, error) {
-	ck := host.ASCII // Would be "+rsa" for rsa keys.
+	cert, err := m.CachedCertificate(ctx, host, false)
+	if err != nil || cert == nil {
+		return false, err
+	}
+	// We assume the certificate has a matching hostname, and is properly CA-signed. We
+	// only check the expiration time.
+	if time.Until(cert.NotBefore) > 0 || time.Since(cert.NotAfter) > 0 {
+		return false, nil
+	}
+	return true, nil
+}
+
+// CachedCertificate returns the leaf certificate for host from the cache, for an
+// ECDSA key, or an RSA key if rsaKey is set. If no certificate is present, nil is
+// returned without error. The certificate may have expired.
+func (m *Manager) CachedCertificate(ctx context.Context, host dns.Domain, rsaKey bool) (*x509.Certificate, error) {
+	ck := host.ASCII
+	if rsaKey {
+		ck += "+rsa"
+	}
 	data, err := m.Manager.Cache.Get(ctx, ck)
 	if err != nil && errors.Is(err, autocert.ErrCacheMiss) {
-		return false, nil
+		return nil, nil
 	} else if err != nil {
-		return false, fmt.Errorf("attempt to get certificate from cache: %v", err)
+		return nil, fmt.Errorf("attempt to get certificate from cache: %v", err)
 	}
 
 	// The cached keycert is of the form: private key, leaf certificate, intermediate certificates...
 	privb, rem := pem.Decode(data)
 	if privb == nil {
-		return false, fmt.Errorf("missing private key in cached keycert file")
+		return nil, fmt.Errorf("missing private key in cached keycert file")
 	}
 	pubb, _ := pem.Decode(rem)
 	if pubb == nil {
-		return false, fmt.Errorf("missing certificate in cached keycert file")
+		return nil, fmt.Errorf("missing certificate in cached keycert file")
 	} else if pubb.Type != "CERTIFICATE" {
-		return false, fmt.Errorf("second pem block is %q, expected CERTIFICATE", pubb.Type)
+		return nil, fmt.Errorf("second pem block is %q, expected CERTIFICATE", pubb.Type)
 	}
 	cert, err := x509.ParseCertificate(pubb.Bytes)
 	if err != nil {
-		return false, fmt.Errorf("parsing certificate from cached keycert file: %v", err)
+		return nil, fmt.Errorf("parsing certificate from cached keycert file: %v", err)
 	}
-	// We assume the certificate has a matching hostname, and is properly CA-signed. We
-	// only check the expiration time.
-	if time.Until(cert.NotBefore) > 0 || time.Since(cert.NotAfter) > 0 {
-		return false, nil
+	return cert, nil
+}
+
+// HostState returns the state of certificate requests for host.
+func (m *Manager) HostState(host dns.Domain) HostState {
+	m.Lock()
+	defer m.Unlock()
+	return m.hostStates[host.ASCII]
+}
+
+// Renew starts requesting new certificates for host in the background, for an
+// ECDSA key, and for an RSA key if a certificate for an RSA key is present in the
+// cache, regardless of the expiration time of the current certificates. New TLS
+// connections use the new certificates once issued. Progress and errors are
+// available through HostState.
+func (m *Manager) Renew(log mlog.Log, host dns.Domain) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.hosts[host]; !ok {
+		return fmt.Errorf("%w: %q", errHostNotAllowed, host)
 	}
-	return true, nil
+	st := m.hostStates[host.ASCII]
+	if st.Renewing {
+		return fmt.Errorf("renewal already in progress")
+	}
+	st.Renewing = true
+	m.hostStates[host.ASCII] = st
+
+	go m.renew(log, host)
+	return nil
+}
+
+func (m *Manager) renew(log mlog.Log, host dns.Domain) {
+	log = log.With(slog.Any("host", host))
+
+	var rerr error
+	defer func() {
+		x := recover()
+		if x != nil {
+			log.Error("renewing certificate", slog.Any("panic", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Autotls)
+			rerr = fmt.Errorf("panic: %v", x)
+		}
+
+		m.Lock()
+		defer m.Unlock()
+		st := m.hostStates[host.ASCII]
+		st.Renewing = false
+		if rerr != nil {
+			st.LastError = rerr.Error()
+			st.LastErrorTime = time.Now()
+		} else {
+			st.LastError = ""
+			st.LastErrorTime = time.Time{}
+			st.Failures = 0
+			st.NextAttempt = time.Time{}
+		}
+		m.hostStates[host.ASCII] = st
+	}()
+
+	ctx := context.Background()
+	rsaKeys := []bool{false}
+	if _, err := m.Manager.Cache.Get(ctx, host.ASCII+"+rsa"); err == nil {
+		rsaKeys = append(rsaKeys, true)
+	}
+	for _, rsaKey := range rsaKeys {
+		key := host.ASCII
+		hello := &tls.ClientHelloInfo{ServerName: host.ASCII, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}}
+		if rsaKey {
+			key += "+rsa"
+			hello.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+		}
+
+		// We use a separate autocert manager, with a cache that doesn't return the current
+		// certificate once, to make it request a new certificate. Tokens for ACME
+		// validation are stored in the shared cache, where the regular autocert manager
+		// finds them for incoming validation requests. Like the regular autocert manager,
+		// the separate manager keeps a renewal timer for the host. Whichever fires first
+		// renews, the other finds the new certificate in the cache.
+		am := &autocert.Manager{
+			Cache:       &renewCache{m.Manager.Cache, key, false},
+			Prompt:      m.Manager.Prompt,
+			Email:       m.Manager.Email,
+			RenewBefore: m.Manager.RenewBefore,
+			Client: &acme.Client{
+				DirectoryURL: m.Manager.Client.DirectoryURL,
+				Key:          m.Manager.Client.Key,
+				UserAgent:    m.Manager.Client.UserAgent,
+				HTTPClient:   m.Manager.Client.HTTPClient,
+				RetryBackoff: m.Manager.Client.RetryBackoff,
+			},
+			GetPrivateKey:          m.Manager.GetPrivateKey,
+			HostPolicy:             m.HostPolicy,
+			ExternalAccountBinding: m.Manager.ExternalAccountBinding,
+		}
+		log.Info("requesting new certificate", slog.Bool("rsa", rsaKey))
+		cert, err := am.GetCertificate(hello)
+		if err != nil {
+			metricCertRequestErrors.Inc()
+			log.Errorx("requesting new certificate", err, slog.Bool("rsa", rsaKey))
+			rerr = err
+			return
+		}
+		log.Info("new certificate", slog.Bool("rsa", rsaKey), slog.Time("notafter", cert.Leaf.NotAfter))
+		m.Lock()
+		m.renewed[key] = cert
+		m.Unlock()
+	}
+}
+
+// renewCache is a cache that returns a cache miss for the first get of key, to
+// make autocert request a new certificate.
+type renewCache struct {
+	autocert.Cache
+	key    string
+	missed bool
+}
+
+func (c *renewCache) Get(ctx context.Context, name string) ([]byte, error) {
+	if name == c.key && !c.missed {
+		c.missed = true
+		return nil, autocert.ErrCacheMiss
+	}
+	return c.Cache.Get(ctx, name)
 }
 
 // SetAllowedHostnames sets a new list of allowed hostnames for automatic TLS.
@@ -392,6 +680,35 @@ func (m *Manager) SetAllowedHostnames(log mlog.Log, resolver dns.Resolver, hostn
 	}
 }
 
+// SetDelegates sets host names for which TLS certificates are managed by another
+// manager, e.g. for domains configured to use another ACME provider than the
+// listener. TLS connections for these hosts, including for tls-alpn-01
+// validation, and http-01 validation requests through HTTPHandler are passed to
+// the other manager, which must have the hosts in its allowed host names.
+func (m *Manager) SetDelegates(delegates map[dns.Domain]*Manager) {
+	m.Lock()
+	defer m.Unlock()
+	m.delegates = delegates
+}
+
+// HTTPHandler returns a handler for http-01 validation requests, like
+// autocert.Manager.HTTPHandler, but passing requests for delegated hosts to their
+// manager.
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	h := m.Manager.HTTPHandler(fallback)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if xhost, _, err := net.SplitHostPort(host); err == nil {
+			host = xhost
+		}
+		if d := m.delegate(strings.ToLower(host)); d != nil {
+			d.Manager.HTTPHandler(fallback).ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
 // Hostnames returns the allowed host names for use with ACME.
 func (m *Manager) Hostnames() []dns.Domain {
 	m.Lock()
@@ -3,11 +3,15 @@ package autotls
 import (
 	"context"
 	"crypto"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/mjl-/autocert"
 
@@ -75,6 +79,33 @@ func TestAutotls(t *testing.T) {
 		t.Fatalf("cache get for absent entry: got err %v, expected autocert.ErrCacheMiss", err)
 	}
 
+	if cert, err := m.CachedCertificate(ctx, dns.Domain{ASCII: "mox.example"}, false); err != nil || cert != nil {
+		t.Fatalf("cached certificate for absent entry: got cert %v, err %v, expected nil, nil", cert, err)
+	}
+
+	m.certResult("mox.example", nil, errors.New("test error"))
+	if st := m.HostState(dns.Domain{ASCII: "mox.example"}); st.LastError != "test error" || st.LastErrorTime.IsZero() || st.Failures != 1 || !st.NextAttempt.After(time.Now()) {
+		t.Fatalf("host state after error: got %#v, expected error", st)
+	}
+	// No cached certificate, so new requests are postponed.
+	if err := m.checkBackoff(ctx, "mox.example"); err == nil {
+		t.Fatalf("check backoff after failure, got nil, expected error")
+	}
+	m.certResult("mox.example", nil, errors.New("test error"))
+	if st := m.HostState(dns.Domain{ASCII: "mox.example"}); st.Failures != 2 || time.Until(st.NextAttempt) <= 5*time.Minute {
+		t.Fatalf("host state after second error: got %#v, expected longer backoff", st)
+	}
+	m.certResult("mox.example", &tls.Certificate{}, nil)
+	if st := m.HostState(dns.Domain{ASCII: "mox.example"}); st.LastError != "" || st.Failures != 0 || !st.NextAttempt.IsZero() {
+		t.Fatalf("host state after success: got %#v, expected no error", st)
+	}
+	if err := m.checkBackoff(ctx, "mox.example"); err != nil {
+		t.Fatalf("check backoff after success, got %v, expected nil", err)
+	}
+	if err := m.Renew(log, dns.Domain{ASCII: "other.mox.example"}); err == nil || !errors.Is(err, errHostNotAllowed) {
+		t.Fatalf("renew, got err %v, expected errHostNotAllowed", err)
+	}
+
 	close(shutdown)
 	if err := m.HostPolicy(context.Background(), "mox.example"); err == nil {
 		t.Fatalf("hostpolicy, got err %v, expected error due to shutdown", err)
@@ -103,6 +134,98 @@ func TestAutotls(t *testing.T) {
 		t.Fatalf("private key reused between managers")
 	}
 
+	m.SetDelegates(map[dns.Domain]*Manager{{ASCII: "other.mox.example"}: m2})
+	if d := m.delegate("other.mox.example"); d != m2 {
+		t.Fatalf("delegate, got %v, expected other manager", d)
+	}
+	if d := m.delegate("mox.example"); d != nil {
+		t.Fatalf("delegate, got %v, expected nil", d)
+	}
+
 	// Only remove in case of success.
 	os.RemoveAll("../testdata/autotls")
 }
+
+func TestOrderLimiter(t *testing.T) {
+	var status int
+	var orders int
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dir":
+			fmt.Fprintf(w, `{"newOrder": "%s/new-order"}`, srv.URL)
+		case "/new-order":
+			orders++
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "3600")
+			}
+			w.WriteHeader(status)
+		}
+	}))
+	defer srv.Close()
+
+	l := &orderLimiter{directoryURL: srv.URL + "/dir", transport: http.DefaultTransport, limit: 2, period: time.Hour}
+	client := &http.Client{Transport: l}
+
+	order := func(expErr bool) {
+		t.Helper()
+		resp, err := client.Post(srv.URL+"/new-order", "application/jose+json", nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if expErr != (err != nil) || err != nil && !errors.Is(err, errOrderLimit) {
+			t.Fatalf("new order, got err %v, expected error %v", err, expErr)
+		}
+	}
+
+	// Before fetching the directory, orders are not recognized.
+	status = http.StatusCreated
+	order(false)
+	order(false)
+	order(false)
+
+	resp, err := client.Get(srv.URL + "/dir")
+	if err != nil {
+		t.Fatalf("get directory: %v", err)
+	}
+	resp.Body.Close()
+	if l.newOrderURL != srv.URL+"/new-order" {
+		t.Fatalf("new order url, got %q", l.newOrderURL)
+	}
+
+	orders = 0
+	order(false)
+	order(false)
+	order(true)
+	if orders != 2 {
+		t.Fatalf("got %d orders, expected 2", orders)
+	}
+
+	// Orders are allowed again after the period.
+	l.orders[0] = l.orders[0].Add(-time.Hour)
+	order(false)
+
+	// Rate limit response from the server pauses new orders.
+	l.limit = 0
+	status = http.StatusTooManyRequests
+	order(false)
+	if !l.pausedUntil.After(time.Now().Add(59 * time.Minute)) {
+		t.Fatalf("paused until %v, expected an hour from now", l.pausedUntil)
+	}
+	status = http.StatusCreated
+	order(true)
+
+	req := httptest.NewRequest("POST", "/new-order", nil)
+	resp = &http.Response{Header: http.Header{"Retry-After": []string{"3600"}}}
+	if d := retryBackoff(1, req, resp); d != 0 {
+		t.Fatalf("retry backoff for long retry-after, got %v, expected 0", d)
+	}
+	resp.Header.Set("Retry-After", "2")
+	if d := retryBackoff(1, req, resp); d != 2*time.Second {
+		t.Fatalf("retry backoff for short retry-after, got %v, expected 2s", d)
+	}
+	resp.Header.Del("Retry-After")
+	if d := retryBackoff(10, req, resp); d != 10*time.Second {
+		t.Fatalf("retry backoff, got %v, expected 10s", d)
+	}
+}
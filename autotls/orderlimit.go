@@ -0,0 +1,142 @@
+package autotls
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var metricOrderPostponed = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "mox_autotls_order_postponed_total",
+		Help: "Number of new certificate orders not sent to the ACME provider due to a configured order limit or an earlier rate limit response.",
+	},
+)
+
+var errOrderLimit = errors.New("autotls: new certificate order postponed due to rate limit")
+
+// orderLimiter is an http.RoundTripper for the ACME client that limits the number
+// of new certificate orders, so we stay below the rate limits of an ACME
+// provider. When the ACME provider responds to a new order with a rate limit
+// error, new orders are paused until the time in its Retry-After header. The URL
+// for new orders is learned from the ACME directory, which the ACME client
+// fetches before its first order. ../rfc/8555
+type orderLimiter struct {
+	directoryURL string
+
+	sync.Mutex
+	transport   http.RoundTripper
+	limit       int // Max new orders within period, 0 for no limit.
+	period      time.Duration
+	newOrderURL string
+	orders      []time.Time // Times of recent new orders, oldest first.
+	pausedUntil time.Time
+}
+
+// RoundTrip implements http.RoundTripper.
+func (l *orderLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	l.Lock()
+	transport := l.transport
+	isNewOrder := req.Method == "POST" && l.newOrderURL != "" && req.URL.String() == l.newOrderURL
+	if isNewOrder {
+		if err := l.reserve(time.Now()); err != nil {
+			l.Unlock()
+			metricOrderPostponed.Inc()
+			return nil, err
+		}
+	}
+	l.Unlock()
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if req.Method == "GET" && req.URL.String() == l.directoryURL && resp.StatusCode == http.StatusOK {
+		buf, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading acme directory: %v", err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(buf))
+		var dir struct {
+			NewOrder string `json:"newOrder"`
+		}
+		if err := json.Unmarshal(buf, &dir); err == nil && dir.NewOrder != "" {
+			l.Lock()
+			l.newOrderURL = dir.NewOrder
+			l.Unlock()
+		}
+	} else if isNewOrder && resp.StatusCode == http.StatusTooManyRequests {
+		l.Lock()
+		l.pause(time.Now(), resp.Header.Get("Retry-After"))
+		l.Unlock()
+	}
+	return resp, nil
+}
+
+// reserve registers a new order at time now, or returns an error if a new order
+// cannot be made due to the limit or an earlier rate limit response. Must be
+// called with lock held.
+func (l *orderLimiter) reserve(now time.Time) error {
+	if now.Before(l.pausedUntil) {
+		return fmt.Errorf("%w: acme provider rate limit, retry after %s", errOrderLimit, l.pausedUntil.Format(time.RFC3339))
+	}
+	if l.limit <= 0 {
+		return nil
+	}
+	for len(l.orders) > 0 && now.Sub(l.orders[0]) >= l.period {
+		l.orders = l.orders[1:]
+	}
+	if len(l.orders) >= l.limit {
+		return fmt.Errorf("%w: %d new orders within %s, retry after %s", errOrderLimit, len(l.orders), l.period, l.orders[0].Add(l.period).Format(time.RFC3339))
+	}
+	l.orders = append(l.orders, now)
+	return nil
+}
+
+// pause prevents new orders until the time indicated by the Retry-After header
+// value retryAfter, or an hour if absent or invalid. Must be called with lock
+// held.
+func (l *orderLimiter) pause(now time.Time, retryAfter string) {
+	until := now.Add(time.Hour)
+	if secs, err := strconv.ParseInt(retryAfter, 10, 64); err == nil && secs >= 0 {
+		until = now.Add(time.Duration(secs) * time.Second)
+	} else if t, err := http.ParseTime(retryAfter); err == nil {
+		until = t
+	}
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// retryBackoff is used as acme.Client.RetryBackoff. It is like the default, with
+// an exponential backoff of at most 10 seconds or the Retry-After header. But we
+// don't retry if the ACME provider asks to wait for more than a minute, e.g. for
+// rate limits: Waiting would block the TLS connection that needs the certificate.
+func retryBackoff(n int, r *http.Request, resp *http.Response) time.Duration {
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		var d time.Duration
+		if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+			d = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(v); err == nil {
+			d = time.Until(t)
+		}
+		if d > time.Minute {
+			return 0
+		} else if d > 0 {
+			return d
+		}
+	}
+	n = min(max(n, 1), 5)
+	return min(time.Duration(1<<(n-1))*time.Second, 10*time.Second)
+}
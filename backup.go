@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -18,37 +19,126 @@ import (
 
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/backupdest"
 	"github.com/mjl-/mox/dmarcdb"
+	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxvar"
 	"github.com/mjl-/mox/mtastsdb"
 	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/rejectdb"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/tlsrptdb"
 )
 
+// backupManifest is stored as backup.json in the backup directory, to find the
+// starting point for a next incremental backup.
+type backupManifest struct {
+	Version     int
+	Time        time.Time
+	Incremental bool
+	Previous    string                  // For incremental backups, directory of previous backup.
+	Accounts    map[string]store.ModSeq // Last modseq per account included in the backup.
+	LinkDest    string                  // Directory of previous backup that unchanged files were hardlinked to, if any.
+
+	// Modification times of database files just before their snapshot was made, for
+	// recognizing unchanged databases in a later backup with a link directory.
+	// Databases that were modified shortly before their snapshot are absent.
+	Databases map[string]time.Time
+}
+
+func readBackupManifest(dir string) (backupManifest, error) {
+	var m backupManifest
+	buf, err := os.ReadFile(filepath.Join(dir, "backup.json"))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return m, fmt.Errorf("parsing backup manifest: %v", err)
+	}
+	if m.Version != 1 {
+		return m, fmt.Errorf("unknown backup manifest version %d", m.Version)
+	}
+	return m, nil
+}
+
+func writeBackupManifest(dir string, m backupManifest) error {
+	buf, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "backup.json"), buf, 0660)
+}
+
+// writeRemoteFile stores a file with contents buf at path in a remote backup
+// destination.
+func writeRemoteFile(ctx context.Context, dest backupdest.Dest, path string, buf []byte) error {
+	f, err := dest.Create(ctx, path, int64(len(buf)))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 func xbackupctl(ctx context.Context, xctl *ctl) {
 	/* protocol:
 	> "backup"
 	> destdir
 	> "verbose" or ""
+	> previous backup dir for incremental backup, or ""
+	> previous backup dir to hardlink unchanged files to, or ""
+	> json with settings and credentials for remote destination, or ""
 	< stream
 	< "ok" or error
 	*/
 
+	var opts backupOptions
+	opts.DestDir = xctl.xread()
+	opts.Verbose = xctl.xread() == "verbose"
+	opts.PrevDir = xctl.xread()
+	opts.LinkDir = xctl.xread()
+	opts.RemoteConfig = xctl.xread()
+
+	// We'll be writing output, and logging both to mox and the ctl stream.
+	xwriter := xctl.writer()
+	incomplete := backup(ctx, xctl.log, xwriter, opts)
+	xwriter.xclose()
+
+	if incomplete {
+		xctl.xwrite("errors were encountered during backup")
+	} else {
+		xctl.xwriteok()
+	}
+}
+
+// backupOptions are the parameters for making a backup.
+type backupOptions struct {
+	DestDir      string
+	Verbose      bool   // Whether to also write informational messages to out.
+	PrevDir      string // Previous backup for incremental backup, or empty.
+	LinkDir      string // Previous backup to hardlink unchanged files to, or empty.
+	RemoteConfig string // JSON with settings and credentials for remote destination, or empty.
+}
+
+// backup makes a backup of the config and data directory to opts.DestDir.
+// Errors and warnings are logged, and written to out. The returned incomplete is
+// set if errors were encountered, i.e. the backup is not complete.
+func backup(ctx context.Context, log mlog.Log, out io.Writer, opts backupOptions) (incomplete bool) {
 	// Convention in this function: variables containing "src" or "dst" are file system
 	// paths that can be passed to os.Open and such. Variables with dirs/paths without
 	// "src" or "dst" are incomplete paths relative to the source or destination data
-	// directories.
-
-	dstDir := xctl.xread()
-	verbose := xctl.xread() == "verbose"
+	// directories. For remote destinations, the "dst" variables are not used, files
+	// are created through the remote destination with the relative paths.
 
-	// Set when an error is encountered. At the end, we warn if set.
-	var incomplete bool
-
-	// We'll be writing output, and logging both to mox and the ctl stream.
-	xwriter := xctl.writer()
+	dstDir := opts.DestDir
+	verbose := opts.Verbose
+	prevDir := opts.PrevDir
+	linkDir := opts.LinkDir
+	remoteConfig := opts.RemoteConfig
 
 	// Format easily readable output for the user.
 	formatLog := func(prefix, text string, err error, attrs ...slog.Attr) []byte {
@@ -67,8 +157,8 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 
 	// Log an error to both the mox service as the user running "mox backup".
 	pkglogx := func(prefix, text string, err error, attrs ...slog.Attr) {
-		xctl.log.Errorx(text, err, attrs...)
-		xwriter.Write(formatLog(prefix, text, err, attrs...))
+		log.Errorx(text, err, attrs...)
+		out.Write(formatLog(prefix, text, err, attrs...))
 	}
 
 	// Log an error but don't mark backup as failed.
@@ -85,27 +175,113 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 
 	// If verbose is enabled, log to the cli command. Always log as info level.
 	xvlog := func(text string, attrs ...slog.Attr) {
-		xctl.log.Info(text, attrs...)
+		log.Info(text, attrs...)
 		if verbose {
-			xwriter.Write(formatLog("", text, nil, attrs...))
+			out.Write(formatLog("", text, nil, attrs...))
+		}
+	}
+
+	// For incremental backups, we need the last modseqs of accounts in the previous
+	// backup.
+	manifest := backupManifest{
+		Version:     1,
+		Time:        time.Now(),
+		Incremental: prevDir != "",
+		Previous:    prevDir,
+		Accounts:    map[string]store.ModSeq{},
+		LinkDest:    linkDir,
+		Databases:   map[string]time.Time{},
+	}
+	var prevManifest backupManifest
+	if prevDir != "" {
+		var err error
+		prevManifest, err = readBackupManifest(prevDir)
+		if err != nil {
+			xerrx("reading manifest of previous backup (make a full backup first)", err, slog.String("dir", prevDir))
+			return
+		}
+	}
+
+	// With a link directory, message files and databases that are unchanged since
+	// that previous backup are hardlinked to the files in that backup instead of
+	// copied. The result is a full backup that can be restored directly.
+	var linkManifest backupManifest
+	if linkDir != "" {
+		var err error
+		if prevDir != "" {
+			err = errors.New("incremental backup cannot be combined with linking to previous backup")
+		} else {
+			linkManifest, err = readBackupManifest(linkDir)
+		}
+		if err != nil {
+			xerrx("reading manifest of previous backup to link to", err, slog.String("dir", linkDir))
+			return
 		}
 	}
+	dstLinkDataDir := filepath.Join(linkDir, "data")
+
+	// With a remote destination, like S3 or SFTP, files are written directly to the
+	// destination.
+	var remote backupdest.Dest
+	if backupdest.IsRemote(dstDir) {
+		var config backupdest.Config
+		var err error
+		if prevDir != "" || linkDir != "" {
+			err = errors.New("incremental backups and linking to previous backups not supported with remote destinations")
+		} else if err = json.Unmarshal([]byte(remoteConfig), &config); err != nil {
+			err = fmt.Errorf("parsing remote destination config: %v", err)
+		} else {
+			remote, err = backupdest.Open(ctx, log, dstDir, config)
+		}
+		if err != nil {
+			xerrx("opening remote backup destination", err, slog.String("dest", dstDir))
+			return
+		}
+		defer func() {
+			err := remote.Close()
+			log.Check(err, "closing remote backup destination")
+		}()
+	}
 
 	dstConfigDir := filepath.Join(dstDir, "config")
 	dstDataDir := filepath.Join(dstDir, "data")
 
-	// Warn if directories already exist, will likely cause failures when trying to
-	// write files that already exist.
-	if _, err := os.Stat(dstConfigDir); err == nil {
-		xwarnx("destination config directory already exists", nil, slog.String("configdir", dstConfigDir))
-	}
-	if _, err := os.Stat(dstDataDir); err == nil {
-		xwarnx("destination data directory already exists", nil, slog.String("datadir", dstDataDir))
+	if remote == nil {
+		// Warn if directories already exist, will likely cause failures when trying to
+		// write files that already exist.
+		if _, err := os.Stat(dstConfigDir); err == nil {
+			xwarnx("destination config directory already exists", nil, slog.String("configdir", dstConfigDir))
+		}
+		if _, err := os.Stat(dstDataDir); err == nil {
+			xwarnx("destination data directory already exists", nil, slog.String("datadir", dstDataDir))
+		}
+
+		os.MkdirAll(dstDir, 0770)
+		os.MkdirAll(dstConfigDir, 0770)
+		os.MkdirAll(dstDataDir, 0770)
 	}
 
-	os.MkdirAll(dstDir, 0770)
-	os.MkdirAll(dstConfigDir, 0770)
-	os.MkdirAll(dstDataDir, 0770)
+	// Copy the contents of file sf to path, relative to the backup directory, in the
+	// remote destination.
+	copyRemote := func(path string, sf *os.File) error {
+		fi, err := sf.Stat()
+		if err != nil {
+			return fmt.Errorf("stat: %v", err)
+		}
+		df, err := remote.Create(ctx, filepath.ToSlash(path), fi.Size())
+		if err != nil {
+			return fmt.Errorf("create: %v", err)
+		}
+		// Files that are still growing are stored with their size at the start.
+		if _, err := io.CopyN(df, sf, fi.Size()); err != nil {
+			df.Close()
+			return fmt.Errorf("copy: %v", err)
+		}
+		if err := df.Close(); err != nil {
+			return fmt.Errorf("close: %v", err)
+		}
+		return nil
+	}
 
 	// Copy all files in the config dir.
 	srcConfigDir := filepath.Clean(mox.ConfigDirPath("."))
@@ -123,6 +299,35 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 
 		destPath := filepath.Join(dstConfigDir, relPath)
 
+		if remote != nil {
+			if d.IsDir() {
+				return nil
+			}
+			// Remote destinations don't have symlinks, we store the contents of the file
+			// linked to instead.
+			if d.Type()&fs.ModeSymlink != 0 {
+				if info, err := os.Stat(srcPath); err != nil || !info.Mode().IsRegular() {
+					xwarnx("skipping symlink in config dir that does not point to a regular file", err, slog.String("path", srcPath))
+					return nil
+				}
+			} else if !d.Type().IsRegular() {
+				xwarnx("skipping non-regular/dir/symlink file in config dir", nil, slog.String("path", srcPath))
+				return nil
+			}
+			sf, err := os.Open(srcPath)
+			if err != nil {
+				return fmt.Errorf("open config file %s: %v", srcPath, err)
+			}
+			defer func() {
+				err := sf.Close()
+				log.Check(err, "closing file")
+			}()
+			if err := copyRemote(filepath.Join("config", relPath), sf); err != nil {
+				return fmt.Errorf("storing config file %s: %v", srcPath, err)
+			}
+			return nil
+		}
+
 		if d.IsDir() {
 			if info, err := os.Stat(srcPath); err != nil {
 				return fmt.Errorf("stat config dir %s: %v", srcPath, err)
@@ -161,12 +366,12 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 		defer func() {
 			if df != nil {
 				err := df.Close()
-				xctl.log.Check(err, "closing file")
+				log.Check(err, "closing file")
 			}
 		}()
 		defer func() {
 			err := sf.Close()
-			xctl.log.Check(err, "closing file")
+			log.Check(err, "closing file")
 		}()
 		if _, err := io.Copy(df, sf); err != nil {
 			return fmt.Errorf("copying config file %s to %s: %v", srcPath, destPath, err)
@@ -210,9 +415,18 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 		}
 		defer func() {
 			err := sf.Close()
-			xctl.log.Check(err, "closing source file")
+			log.Check(err, "closing source file")
 		}()
 
+		if remote != nil {
+			if err := copyRemote(filepath.Join("data", path), sf); err != nil {
+				xerrx("storing file (not backed up properly)", err, slog.String("srcpath", srcpath), slog.String("path", path))
+				return
+			}
+			xvlog("backed up file", slog.String("path", path), slog.Duration("duration", time.Since(tmFile)))
+			return
+		}
+
 		ensureDestDir(dstpath)
 		df, err := os.OpenFile(dstpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
 		if err != nil {
@@ -222,7 +436,7 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 		defer func() {
 			if df != nil {
 				err := df.Close()
-				xctl.log.Check(err, "closing destination file")
+				log.Check(err, "closing destination file")
 			}
 		}()
 		if _, err := io.Copy(df, sf); err != nil {
@@ -264,22 +478,82 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 		xvlog("backed up directory", slog.String("dir", dir), slog.Duration("duration", time.Since(tmDir)))
 	}
 
-	// Backup a database by copying it in a readonly transaction. Wrapped by backupDB
-	// which logs and returns just a bool.
-	backupDB0 := func(db *bstore.DB, path string) error {
+	// Backup a database by copying it in a readonly transaction. If the database is
+	// unchanged since the backup in the link directory, it is hardlinked instead, and
+	// fn, if not nil, is called with the transaction, which has the same contents as
+	// the linked database file. For remote destinations, fn is also called with the
+	// transaction, after writing the database. Wrapped by backupDB which logs and
+	// returns just a bool.
+	backupDB0 := func(db *bstore.DB, path string, fn func(tx *bstore.Tx) error) (linked bool, rerr error) {
+		srcpath := filepath.Join(srcDataDir, path)
 		dstpath := filepath.Join(dstDataDir, path)
-		ensureDestDir(dstpath)
-		df, err := os.OpenFile(dstpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
-		if err != nil {
-			return fmt.Errorf("creating destination file: %v", err)
+
+		// The modification time from before the snapshot is stored in the manifest. Any
+		// change that isn't in the snapshot is written after, updating the modification
+		// time. With file systems with coarse timestamps, a change could be written with
+		// the same modification time, so we don't store recent modification times.
+		if fi, err := os.Stat(srcpath); err != nil {
+			xwarnx("stat database file", err, slog.String("srcpath", srcpath))
+		} else if time.Since(fi.ModTime()) > 2*time.Second {
+			defer func() {
+				if rerr == nil {
+					manifest.Databases[path] = fi.ModTime()
+				}
+			}()
+		}
+
+		if remote == nil {
+			ensureDestDir(dstpath)
 		}
+		var df *os.File
 		defer func() {
 			if df != nil {
 				err := df.Close()
-				xctl.log.Check(err, "closing destination database file")
+				log.Check(err, "closing destination database file")
 			}
 		}()
-		err = db.Read(ctx, func(tx *bstore.Tx) error {
+		err := db.Read(ctx, func(tx *bstore.Tx) error {
+			if remote != nil {
+				rf, err := remote.Create(ctx, filepath.ToSlash(filepath.Join("data", path)), -1)
+				if err != nil {
+					return fmt.Errorf("creating remote file: %v", err)
+				}
+				if _, err := tx.WriteTo(rf); err != nil {
+					rf.Close()
+					return err
+				}
+				if err := rf.Close(); err != nil {
+					return fmt.Errorf("closing remote file: %v", err)
+				}
+				if fn != nil {
+					return fn(tx)
+				}
+				return nil
+			}
+
+			// Changes visible in the transaction have been written before, so if the
+			// modification time is still the same as just before the snapshot in the previous
+			// backup, the database is unchanged since.
+			if mtime, ok := linkManifest.Databases[path]; ok {
+				if fi, err := os.Stat(srcpath); err == nil && fi.ModTime().Equal(mtime) {
+					if err := os.Link(filepath.Join(dstLinkDataDir, path), dstpath); err != nil {
+						xwarnx("linking unchanged database to previous backup, copying instead", err, slog.String("path", path))
+					} else {
+						linked = true
+						if fn != nil {
+							return fn(tx)
+						}
+						return nil
+					}
+				}
+			}
+
+			var err error
+			df, err = os.OpenFile(dstpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+			if err != nil {
+				return fmt.Errorf("creating destination file: %v", err)
+			}
+
 			// Using regular WriteTo seems fine, and fast. It just copies pages.
 			//
 			// bolt.Compact is slower, it writes all key/value pairs, building up new data
@@ -290,34 +564,61 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 			// Tests with WriteTo and os.O_DIRECT were slower than without O_DIRECT, but
 			// probably because everything fit in the page cache. It may be better to use
 			// O_DIRECT when copying many large or inactive databases.
-			_, err := tx.WriteTo(df)
+			_, err = tx.WriteTo(df)
 			return err
 		})
 		if err != nil {
-			return fmt.Errorf("copying database: %v", err)
+			return false, fmt.Errorf("copying database: %v", err)
+		}
+		if linked || remote != nil {
+			return linked, nil
 		}
 		err = df.Close()
 		df = nil
 		if err != nil {
-			return fmt.Errorf("closing destination database after copy: %v", err)
+			return false, fmt.Errorf("closing destination database after copy: %v", err)
 		}
-		return nil
+		return false, nil
 	}
 
-	backupDB := func(db *bstore.DB, path string) bool {
+	backupDB := func(db *bstore.DB, path string, fn func(tx *bstore.Tx) error) (linked, ok bool) {
 		start := time.Now()
-		err := backupDB0(db, path)
+		linked, err := backupDB0(db, path, fn)
 		if err != nil {
 			xerrx("backing up database", err, slog.String("path", path), slog.Duration("duration", time.Since(start)))
-			return false
+			return false, false
 		}
-		xvlog("backed up database file", slog.String("path", path), slog.Duration("duration", time.Since(start)))
-		return true
+		if linked {
+			xvlog("linked unchanged database file to previous backup", slog.String("path", path), slog.Duration("duration", time.Since(start)))
+		} else {
+			xvlog("backed up database file", slog.String("path", path), slog.Duration("duration", time.Since(start)))
+		}
+		return linked, true
 	}
 
-	// Try to create a hardlink. Fall back to copying the file (e.g. when on different file system).
+	// Try to create a hardlink. If that fails (e.g. when on different file system),
+	// try to hardlink to the same file in the link directory if its size and
+	// modification time are the same. Fall back to copying the file, keeping the
+	// modification time, so a next backup with this backup as link directory can
+	// recognize the file.
 	warnedHardlink := false // We warn once about failing to hardlink.
-	linkOrCopy := func(srcpath, dstpath string) (bool, error) {
+	warnedLinkDest := false
+	linkOrCopy := func(path string) (bool, error) {
+		srcpath := filepath.Join(srcDataDir, path)
+		dstpath := filepath.Join(dstDataDir, path)
+
+		if remote != nil {
+			sf, err := os.Open(srcpath)
+			if err != nil {
+				return false, fmt.Errorf("open source path %s: %v", srcpath, err)
+			}
+			defer func() {
+				err := sf.Close()
+				log.Check(err, "closing copied source file")
+			}()
+			return false, copyRemote(filepath.Join("data", path), sf)
+		}
+
 		ensureDestDir(dstpath)
 
 		if err := os.Link(srcpath, dstpath); err == nil {
@@ -334,15 +635,32 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 			warnedHardlink = true
 		}
 
-		// Fall back to copying.
 		sf, err := os.Open(srcpath)
 		if err != nil {
 			return false, fmt.Errorf("open source path %s: %v", srcpath, err)
 		}
 		defer func() {
 			err := sf.Close()
-			xctl.log.Check(err, "closing copied source file")
+			log.Check(err, "closing copied source file")
 		}()
+		sfi, err := sf.Stat()
+		if err != nil {
+			return false, fmt.Errorf("stat source path %s: %v", srcpath, err)
+		}
+
+		if linkDir != "" {
+			linkpath := filepath.Join(dstLinkDataDir, path)
+			if fi, err := os.Stat(linkpath); err == nil && fi.Mode().IsRegular() && fi.Size() == sfi.Size() && fi.ModTime().Equal(sfi.ModTime()) {
+				if err := os.Link(linkpath, dstpath); err == nil {
+					return true, nil
+				} else if !warnedLinkDest {
+					xwarnx("creating hardlink to message in previous backup failed, will be doing regular file copies and not warn again", err, slog.String("linkpath", linkpath), slog.String("dstpath", dstpath))
+					warnedLinkDest = true
+				}
+			}
+		}
+
+		// Fall back to copying.
 
 		df, err := os.OpenFile(dstpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
 		if err != nil {
@@ -351,7 +669,7 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 		defer func() {
 			if df != nil {
 				err := df.Close()
-				xctl.log.Check(err, "closing partial destination file")
+				log.Check(err, "closing partial destination file")
 			}
 		}()
 		if _, err := io.Copy(df, sf); err != nil {
@@ -362,27 +680,37 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 		if err != nil {
 			return false, fmt.Errorf("closing destination file: %v", err)
 		}
+		if err := os.Chtimes(dstpath, sfi.ModTime(), sfi.ModTime()); err != nil {
+			return false, fmt.Errorf("setting modification time of destination file: %v", err)
+		}
 		return false, nil
 	}
 
 	// Start making the backup.
 	tmStart := time.Now()
 
-	xctl.log.Print("making backup", slog.String("destdir", dstDataDir))
+	log.Print("making backup", slog.String("destdir", dstDataDir))
 
-	if err := os.MkdirAll(dstDataDir, 0770); err != nil {
-		xerrx("creating destination data directory", err)
-	}
+	if remote != nil {
+		if err := writeRemoteFile(ctx, remote, "data/moxversion", []byte(moxvar.Version)); err != nil {
+			xerrx("writing moxversion", err)
+		}
+	} else {
+		if err := os.MkdirAll(dstDataDir, 0770); err != nil {
+			xerrx("creating destination data directory", err)
+		}
 
-	if err := os.WriteFile(filepath.Join(dstDataDir, "moxversion"), []byte(moxvar.Version), 0660); err != nil {
-		xerrx("writing moxversion", err)
+		if err := os.WriteFile(filepath.Join(dstDataDir, "moxversion"), []byte(moxvar.Version), 0660); err != nil {
+			xerrx("writing moxversion", err)
+		}
 	}
-	backupDB(store.AuthDB, "auth.db")
-	backupDB(dmarcdb.ReportsDB, "dmarcrpt.db")
-	backupDB(dmarcdb.EvalDB, "dmarceval.db")
-	backupDB(mtastsdb.DB, "mtasts.db")
-	backupDB(tlsrptdb.ReportDB, "tlsrpt.db")
-	backupDB(tlsrptdb.ResultDB, "tlsrptresult.db")
+	backupDB(store.AuthDB, "auth.db", nil)
+	backupDB(dmarcdb.ReportsDB, "dmarcrpt.db", nil)
+	backupDB(dmarcdb.EvalDB, "dmarceval.db", nil)
+	backupDB(mtastsdb.DB, "mtasts.db", nil)
+	backupDB(tlsrptdb.ReportDB, "tlsrpt.db", nil)
+	backupDB(tlsrptdb.ResultDB, "tlsrptresult.db", nil)
+	backupDB(rejectdb.DB, "rejected.db", nil)
 	backupFile("receivedid.key")
 
 	// Acme directory is optional.
@@ -397,24 +725,38 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 	backupQueue := func(path string) {
 		tmQueue := time.Now()
 
-		if !backupDB(queue.DB, path) {
-			return
+		// Message IDs in the database snapshot. Gathered from the copied database, or from
+		// the transaction if the database was linked to the previous backup, because
+		// opening the database file would modify it, or was written to a remote
+		// destination.
+		var msgIDs []int64
+		gatherIDs := func(tx *bstore.Tx) error {
+			return bstore.QueryTx[queue.Msg](tx).ForEach(func(m queue.Msg) error {
+				msgIDs = append(msgIDs, m.ID)
+				return nil
+			})
 		}
 
-		dstdbpath := filepath.Join(dstDataDir, path)
-		opts := bstore.Options{MustExist: true, RegisterLogger: xctl.log.Logger}
-		db, err := bstore.Open(ctx, dstdbpath, &opts, queue.DBTypes...)
-		if err != nil {
-			xerrx("open copied queue database", err, slog.String("dstpath", dstdbpath), slog.Duration("duration", time.Since(tmQueue)))
+		linked, ok := backupDB(queue.DB, path, gatherIDs)
+		if !ok {
 			return
 		}
 
-		defer func() {
-			if db != nil {
-				err := db.Close()
-				xctl.log.Check(err, "closing new queue db")
+		if !linked && remote == nil {
+			dstdbpath := filepath.Join(dstDataDir, path)
+			opts := bstore.Options{MustExist: true, RegisterLogger: log.Logger}
+			db, err := bstore.Open(ctx, dstdbpath, &opts, queue.DBTypes...)
+			if err != nil {
+				xerrx("open copied queue database", err, slog.String("dstpath", dstdbpath), slog.Duration("duration", time.Since(tmQueue)))
+				return
 			}
-		}()
+			err = db.Read(ctx, gatherIDs)
+			if err != nil {
+				xerrx("listing queue messages (not backed up properly)", err)
+			}
+			err = db.Close()
+			log.Check(err, "closing new queue db")
+		}
 
 		// Link/copy known message files. If a message has been removed while we read the
 		// database, our backup is not consistent and the backup will be marked failed.
@@ -422,38 +764,30 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 		seen := map[string]struct{}{}
 		var nlinked, ncopied int
 		var maxID int64
-		err = bstore.QueryDB[queue.Msg](ctx, db).ForEach(func(m queue.Msg) error {
-			if m.ID > maxID {
-				maxID = m.ID
-			}
-			mp := store.MessagePath(m.ID)
+		for _, id := range msgIDs {
+			maxID = max(maxID, id)
+			mp := store.MessagePath(id)
 			seen[mp] = struct{}{}
-			srcpath := filepath.Join(srcDataDir, "queue", mp)
-			dstpath := filepath.Join(dstDataDir, "queue", mp)
-			if linked, err := linkOrCopy(srcpath, dstpath); err != nil {
-				xerrx("linking/copying queue message", err, slog.String("srcpath", srcpath), slog.String("dstpath", dstpath))
+			qmp := filepath.Join("queue", mp)
+			if linked, err := linkOrCopy(qmp); err != nil {
+				xerrx("linking/copying queue message", err, slog.String("path", qmp))
 			} else if linked {
 				nlinked++
 			} else {
 				ncopied++
 			}
-			return nil
-		})
-		if err != nil {
-			xerrx("processing queue messages (not backed up properly)", err, slog.Duration("duration", time.Since(tmMsgs)))
-		} else {
-			xvlog("queue message files linked/copied",
-				slog.Int("linked", nlinked),
-				slog.Int("copied", ncopied),
-				slog.Duration("duration", time.Since(tmMsgs)))
 		}
+		xvlog("queue message files linked/copied",
+			slog.Int("linked", nlinked),
+			slog.Int("copied", ncopied),
+			slog.Duration("duration", time.Since(tmMsgs)))
 
 		// Read through all files in queue directory and warn about anything we haven't
 		// handled yet. Message files that are newer than we expect from our consistent
 		// database snapshot are ignored.
 		tmWalk := time.Now()
 		srcqdir := filepath.Join(srcDataDir, "queue")
-		err = filepath.WalkDir(srcqdir, func(srcqpath string, d fs.DirEntry, err error) error {
+		err := filepath.WalkDir(srcqdir, func(srcqpath string, d fs.DirEntry, err error) error {
 			if err != nil {
 				xerrx("walking files in queue", err, slog.String("srcpath", srcqpath))
 				return nil
@@ -489,91 +823,181 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 	}
 	backupQueue(filepath.FromSlash("queue/index.db"))
 
+	// Write a journal with changes to the account database since the previous backup,
+	// and link/copy message files added since.
+	backupJournal := func(acc *store.Account, since store.ModSeq) bool {
+		tmJournal := time.Now()
+		jpath := filepath.Join("accounts", acc.Name, "journal.jsonl")
+		dstpath := filepath.Join(dstDataDir, jpath)
+		ensureDestDir(dstpath)
+		df, err := os.OpenFile(dstpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+		if err != nil {
+			xerrx("creating journal file", err, slog.String("dstpath", dstpath))
+			return false
+		}
+		defer func() {
+			if df != nil {
+				err := df.Close()
+				log.Check(err, "closing journal file")
+			}
+		}()
+
+		// Journal and message files are from the same snapshot.
+		var nlinked, ncopied int
+		err = acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+			h, err := store.ExportJournal(tx, df, acc.Name, since)
+			if err != nil {
+				return err
+			}
+			manifest.Accounts[acc.Name] = h.ModSeq
+
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterGreater("CreateSeq", since)
+			q.FilterEqual("Expunged", false)
+			return q.ForEach(func(m store.Message) error {
+				amp := filepath.Join("accounts", acc.Name, "msg", store.MessagePath(m.ID))
+				if linked, err := linkOrCopy(amp); err != nil {
+					xerrx("linking/copying account message", err, slog.String("path", amp))
+				} else if linked {
+					nlinked++
+				} else {
+					ncopied++
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			xerrx("writing account journal (not backed up properly)", err, slog.String("dstpath", dstpath), slog.Duration("duration", time.Since(tmJournal)))
+			return false
+		}
+		err = df.Close()
+		df = nil
+		if err != nil {
+			xerrx("closing journal file (not backed up properly)", err, slog.String("dstpath", dstpath))
+			return false
+		}
+		xvlog("account journal written, new message files linked/copied",
+			slog.String("path", jpath),
+			slog.Int("linked", nlinked),
+			slog.Int("copied", ncopied),
+			slog.Duration("duration", time.Since(tmJournal)))
+		return true
+	}
+
 	backupAccount := func(acc *store.Account) {
 		defer func() {
 			err := acc.Close()
-			xctl.log.Check(err, "closing account")
+			log.Check(err, "closing account")
 		}()
 
 		tmAccount := time.Now()
 
+		// With an incremental backup, we write a journal with changes since the previous
+		// backup instead of copying the database. Accounts not in the previous backup are
+		// backed up in full.
+		since, incremental := prevManifest.Accounts[acc.Name]
+
+		// Message IDs, erased message IDs and last modseq of the database snapshot.
+		// Gathered from the copied database, or from the transaction if the database was
+		// linked to the previous backup, because opening the database file would modify
+		// it, or was written to a remote destination.
+		var msgIDs []int64
+		eraseIDs := map[int64]struct{}{}
+		gather := func(tx *bstore.Tx) error {
+			err := bstore.QueryTx[store.Message](tx).FilterEqual("Expunged", false).ForEach(func(m store.Message) error {
+				msgIDs = append(msgIDs, m.ID)
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("listing messages: %v", err)
+			}
+			err = bstore.QueryTx[store.MessageErase](tx).ForEach(func(me store.MessageErase) error {
+				eraseIDs[me.ID] = struct{}{}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("listing erased messages: %v", err)
+			}
+			modseq, err := store.LastModSeq(tx)
+			if err != nil {
+				return fmt.Errorf("get last modseq of account: %v", err)
+			}
+			manifest.Accounts[acc.Name] = modseq
+			return nil
+		}
+
 		// Copy database file.
 		dbpath := filepath.Join("accounts", acc.Name, "index.db")
-		backupDB(acc.DB, dbpath)
+		var dbLinked, dbOK bool
+		if !incremental {
+			dbLinked, dbOK = backupDB(acc.DB, dbpath, gather)
+		}
 
 		// todo: should document/check not taking a rlock on account.
 
 		// Copy junkfilter files, if configured.
-		if jf, _, err := acc.OpenJunkFilter(ctx, xctl.log); err != nil {
+		if jf, _, err := acc.OpenJunkFilter(ctx, log); err != nil {
 			if !errors.Is(err, store.ErrNoJunkFilter) {
 				xerrx("opening junk filter for account (not backed up)", err)
 			}
 		} else {
 			db := jf.DB()
 			jfpath := filepath.Join("accounts", acc.Name, "junkfilter.db")
-			backupDB(db, jfpath)
+			backupDB(db, jfpath, nil)
 			bloompath := filepath.Join("accounts", acc.Name, "junkfilter.bloom")
 			backupFile(bloompath)
 			err := jf.Close()
-			xctl.log.Check(err, "closing junkfilter")
-		}
-
-		dstdbpath := filepath.Join(dstDataDir, dbpath)
-		opts := bstore.Options{MustExist: true, RegisterLogger: xctl.log.Logger}
-		db, err := bstore.Open(ctx, dstdbpath, &opts, store.DBTypes...)
-		if err != nil {
-			xerrx("open copied account database", err, slog.String("dstpath", dstdbpath), slog.Duration("duration", time.Since(tmAccount)))
-			return
+			log.Check(err, "closing junkfilter")
 		}
 
-		defer func() {
-			if db != nil {
-				err := db.Close()
-				xctl.log.Check(err, "close account database")
-			}
-		}()
-
-		// Link/copy known message files.
-		tmMsgs := time.Now()
 		seen := map[string]struct{}{}
 		var maxID int64
-		var nlinked, ncopied int
-		err = bstore.QueryDB[store.Message](ctx, db).FilterEqual("Expunged", false).ForEach(func(m store.Message) error {
-			if m.ID > maxID {
-				maxID = m.ID
+		if incremental {
+			if !backupJournal(acc, since) {
+				return
 			}
-			mp := store.MessagePath(m.ID)
-			seen[mp] = struct{}{}
-			amp := filepath.Join("accounts", acc.Name, "msg", mp)
-			srcpath := filepath.Join(srcDataDir, amp)
-			dstpath := filepath.Join(dstDataDir, amp)
-			if linked, err := linkOrCopy(srcpath, dstpath); err != nil {
-				xerrx("linking/copying account message", err, slog.String("srcpath", srcpath), slog.String("dstpath", dstpath))
-			} else if linked {
-				nlinked++
-			} else {
-				ncopied++
-			}
-			return nil
-		})
-		if err != nil {
-			xerrx("processing account messages (not backed up properly)", err, slog.Duration("duration", time.Since(tmMsgs)))
 		} else {
+			if !dbOK {
+				return
+			}
+			if !dbLinked && remote == nil {
+				dstdbpath := filepath.Join(dstDataDir, dbpath)
+				opts := bstore.Options{MustExist: true, RegisterLogger: log.Logger}
+				db, err := bstore.Open(ctx, dstdbpath, &opts, store.DBTypes...)
+				if err != nil {
+					xerrx("open copied account database", err, slog.String("dstpath", dstdbpath), slog.Duration("duration", time.Since(tmAccount)))
+					return
+				}
+				err = db.Read(ctx, gather)
+				if err != nil {
+					xerrx("reading copied account database", err)
+				}
+				err = db.Close()
+				log.Check(err, "close account database")
+			}
+
+			// Link/copy known message files.
+			tmMsgs := time.Now()
+			var nlinked, ncopied int
+			for _, id := range msgIDs {
+				maxID = max(maxID, id)
+				mp := store.MessagePath(id)
+				seen[mp] = struct{}{}
+				amp := filepath.Join("accounts", acc.Name, "msg", mp)
+				if linked, err := linkOrCopy(amp); err != nil {
+					xerrx("linking/copying account message", err, slog.String("path", amp))
+				} else if linked {
+					nlinked++
+				} else {
+					ncopied++
+				}
+			}
 			xvlog("account message files linked/copied",
 				slog.Int("linked", nlinked),
 				slog.Int("copied", ncopied),
 				slog.Duration("duration", time.Since(tmMsgs)))
 		}
 
-		eraseIDs := map[int64]struct{}{}
-		err = bstore.QueryDB[store.MessageErase](ctx, db).ForEach(func(me store.MessageErase) error {
-			eraseIDs[me.ID] = struct{}{}
-			return nil
-		})
-		if err != nil {
-			xerrx("listing erased messages", err)
-		}
-
 		// Read through all files in queue directory and warn about anything we haven't
 		// handled yet. Message files that are newer than we expect from our consistent
 		// database snapshot are ignored.
@@ -589,7 +1013,10 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 			}
 			p := srcapath[len(srcadir)+1:]
 			l := strings.Split(p, string(filepath.Separator))
-			if l[0] == "msg" {
+			if l[0] == "msg" && incremental {
+				// Only new messages are in an incremental backup.
+				return nil
+			} else if l[0] == "msg" {
 				mp := filepath.Join(l[1:]...)
 				if _, ok := seen[mp]; ok {
 					return nil
@@ -632,7 +1059,7 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 	// account directories when handling "all other files" below.
 	accounts := map[string]struct{}{}
 	for _, accName := range mox.Conf.Accounts() {
-		acc, err := store.OpenAccount(xctl.log, accName, false)
+		acc, err := store.OpenAccount(log, accName, false)
 		if err != nil {
 			xerrx("opening account for copying (will try to copy as regular files later)", err, slog.String("account", accName))
 			continue
@@ -653,7 +1080,9 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 			return nil
 		}
 		p := srcpath[len(srcDataDir)+1:]
-		if p == "queue" || p == "acme" || p == "tmp" {
+		// Deduplicated message files are also in the account message directories.
+		// Deduplication starts anew after restoring.
+		if p == "queue" || p == "acme" || p == "tmp" || p == store.DedupDir {
 			return fs.SkipDir
 		}
 		l := strings.Split(p, string(filepath.Separator))
@@ -670,7 +1099,7 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 		}
 
 		switch p {
-		case "auth.db", "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "receivedid.key", "ctl":
+		case "auth.db", "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "rejected.db", "receivedid.key", "ctl":
 			// Already handled.
 			return nil
 		case "lastknownversion": // Optional file, not yet handled.
@@ -686,13 +1115,17 @@ func xbackupctl(ctx context.Context, xctl *ctl) {
 		xvlog("walking other files finished", slog.Duration("duration", time.Since(tmWalk)))
 	}
 
-	xvlog("backup finished", slog.Duration("duration", time.Since(tmStart)))
+	if remote != nil {
+		if buf, err := json.MarshalIndent(manifest, "", "\t"); err != nil {
+			xerrx("marshal backup manifest", err)
+		} else if err := writeRemoteFile(ctx, remote, "backup.json", buf); err != nil {
+			xerrx("writing backup manifest", err)
+		}
+	} else if err := writeBackupManifest(dstDir, manifest); err != nil {
+		xerrx("writing backup manifest", err)
+	}
 
-	xwriter.xclose()
+	xvlog("backup finished", slog.Duration("duration", time.Since(tmStart)))
 
-	if incomplete {
-		xctl.xwrite("errors were encountered during backup")
-	} else {
-		xctl.xwriteok()
-	}
+	return
 }
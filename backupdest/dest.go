@@ -0,0 +1,72 @@
+// Package backupdest implements remote destinations for backups, writing
+// files directly to S3-compatible object storage or to an SFTP server, without
+// needing local space for staging.
+package backupdest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+var ErrUnsupported = errors.New("unsupported backup destination")
+
+// Dest is a remote destination for backup files.
+type Dest interface {
+	// Create starts writing a new file at path, a slash-separated path relative to
+	// the destination URL. Size is the number of bytes that will be written, or -1
+	// if not known in advance. The file is only stored after a successful Close.
+	Create(ctx context.Context, path string, size int64) (io.WriteCloser, error)
+
+	// Close releases resources, such as connections.
+	Close() error
+}
+
+// Config holds the settings and credentials for connecting to a destination.
+// Only the fields for the type of the destination are used.
+type Config struct {
+	// For S3. Region defaults to us-east-1. With an empty Endpoint, AWS is used
+	// with virtual-hosted style URLs, otherwise path-style URLs on the endpoint
+	// (e.g. https://minio.example:9000). SessionToken is optional.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+	Endpoint        string
+
+	// For SFTP. PrivateKey is an unencrypted private key in PEM/OpenSSH format.
+	// KnownHosts has the contents of a known_hosts file, for verifying the host key
+	// of the server.
+	PrivateKey []byte
+	KnownHosts []byte
+}
+
+// IsRemote returns whether s looks like a remote destination URL instead of a
+// local directory.
+func IsRemote(s string) bool {
+	scheme, _, ok := strings.Cut(s, "://")
+	return ok && scheme != "" && !strings.ContainsAny(scheme, `/\`)
+}
+
+// Open connects to the destination at target, e.g. "s3://bucket/prefix" or
+// "sftp://user@host:port/path".
+func Open(ctx context.Context, log mlog.Log, target string, config Config) (Dest, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("parsing destination url: %v", err)
+	}
+	switch u.Scheme {
+	case "s3":
+		return openS3(log, u, config)
+	case "sftp":
+		return openSFTP(ctx, log, u, config)
+	case "rsync":
+		return nil, fmt.Errorf("%w: rsync, use sftp instead", ErrUnsupported)
+	}
+	return nil, fmt.Errorf("%w: scheme %q, must be s3 or sftp", ErrUnsupported, u.Scheme)
+}
@@ -0,0 +1,412 @@
+package backupdest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moxvar"
+)
+
+// Files larger than this, or of unknown size and larger than a part, are
+// uploaded with a multipart upload.
+const s3MaxPut = 5 * 1024 * 1024 * 1024
+
+// Minimum size of parts in a multipart upload. Must be at least 5MB, except for
+// the last part.
+const s3PartSize = 16 * 1024 * 1024
+
+// Maximum number of parts in a multipart upload.
+const s3MaxParts = 10000
+
+type s3Dest struct {
+	log    mlog.Log
+	config Config
+	bucket string
+	prefix string // Without leading/trailing slash.
+	client *http.Client
+}
+
+func openS3(log mlog.Log, u *url.URL, config Config) (*s3Dest, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing bucket in s3 url")
+	}
+	if config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return nil, fmt.Errorf("missing access key id or secret access key for s3")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint != "" {
+		eu, err := url.Parse(config.Endpoint)
+		if err != nil || eu.Scheme != "http" && eu.Scheme != "https" || eu.Host == "" {
+			return nil, fmt.Errorf("s3 endpoint must be an http or https url")
+		}
+	}
+	d := &s3Dest{
+		log:    log,
+		config: config,
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+		client: &http.Client{},
+	}
+	return d, nil
+}
+
+func (d *s3Dest) Close() error {
+	d.client.CloseIdleConnections()
+	return nil
+}
+
+// objectURL returns the URL for the object for path, with the key, without the
+// query string.
+func (d *s3Dest) objectURL(path string) string {
+	key := strings.TrimPrefix(d.prefix+"/"+path, "/")
+	if d.config.Endpoint != "" {
+		return strings.TrimRight(d.config.Endpoint, "/") + "/" + d.bucket + "/" + s3Escape(key, false)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", d.bucket, d.config.Region, s3Escape(key, false))
+}
+
+func (d *s3Dest) Create(ctx context.Context, path string, size int64) (io.WriteCloser, error) {
+	w := &s3Writer{ctx: ctx, d: d, url: d.objectURL(path), size: size}
+	if size > 0 && size <= s3MaxPut {
+		// Stream directly in a single request.
+		pr, pw := io.Pipe()
+		w.pw = pw
+		w.done = make(chan error, 1)
+		go func() {
+			err := d.do(ctx, "PUT", w.url, nil, pr, size, "UNSIGNED-PAYLOAD", nil)
+			pr.CloseWithError(err)
+			w.done <- err
+		}()
+	} else if size > s3MaxPut {
+		w.partSize = max(s3PartSize, (size+s3MaxParts-1)/s3MaxParts)
+	} else {
+		w.partSize = s3PartSize
+	}
+	return w, nil
+}
+
+// s3Writer writes a single object with a PUT request when the size is known and
+// small enough, and otherwise buffers data into parts of a multipart upload. Data
+// of unknown size that fits in a single part is written with a single PUT request
+// too.
+type s3Writer struct {
+	ctx  context.Context
+	d    *s3Dest
+	url  string
+	size int64
+
+	// For a single PUT request.
+	pw      *io.PipeWriter
+	done    chan error
+	written int64
+
+	// For a multipart upload.
+	partSize int64
+	buf      bytes.Buffer
+	uploadID string
+	etags    []string
+
+	err error
+}
+
+func (w *s3Writer) Write(buf []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.pw != nil {
+		n, err := w.pw.Write(buf)
+		w.written += int64(n)
+		if err != nil {
+			w.err = err
+		}
+		return n, err
+	}
+
+	n := len(buf)
+	for len(buf) > 0 {
+		k := min(int64(len(buf)), w.partSize-int64(w.buf.Len()))
+		w.buf.Write(buf[:k])
+		buf = buf[k:]
+		if int64(w.buf.Len()) == w.partSize {
+			if err := w.uploadPart(); err != nil {
+				w.abort()
+				w.err = err
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// uploadPart uploads the buffered data as the next part, starting the multipart
+// upload if needed.
+func (w *s3Writer) uploadPart() error {
+	if w.uploadID == "" {
+		var result struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := w.d.do(w.ctx, "POST", w.url, url.Values{"uploads": {""}}, nil, 0, "", &result); err != nil {
+			return fmt.Errorf("starting multipart upload: %w", err)
+		} else if result.UploadID == "" {
+			return fmt.Errorf("starting multipart upload: missing upload id in response")
+		}
+		w.uploadID = result.UploadID
+	}
+
+	if len(w.etags) >= s3MaxParts {
+		return fmt.Errorf("too many parts for multipart upload")
+	}
+	part := len(w.etags) + 1
+	qs := url.Values{"partNumber": {fmt.Sprintf("%d", part)}, "uploadId": {w.uploadID}}
+	var etag string
+	hashsum := sha256.Sum256(w.buf.Bytes())
+	err := w.d.doHeader(w.ctx, "PUT", w.url, qs, bytes.NewReader(w.buf.Bytes()), int64(w.buf.Len()), hex.EncodeToString(hashsum[:]), nil, func(h http.Header) {
+		etag = h.Get("ETag")
+	})
+	if err != nil {
+		return fmt.Errorf("uploading part %d: %w", part, err)
+	}
+	w.etags = append(w.etags, etag)
+	w.buf.Reset()
+	return nil
+}
+
+// abort aborts a multipart upload, to release the storage of uploaded parts.
+func (w *s3Writer) abort() {
+	if w.uploadID == "" {
+		return
+	}
+	err := w.d.do(context.Background(), "DELETE", w.url, url.Values{"uploadId": {w.uploadID}}, nil, 0, "", nil)
+	w.d.log.Check(err, "aborting multipart upload", slog.String("url", w.url))
+	w.uploadID = ""
+}
+
+func (w *s3Writer) Close() error {
+	if w.err != nil {
+		if w.pw != nil {
+			w.pw.CloseWithError(w.err)
+			<-w.done
+		}
+		return w.err
+	}
+
+	if w.pw != nil {
+		if w.written != w.size {
+			err := fmt.Errorf("wrote %d bytes, expected %d", w.written, w.size)
+			w.pw.CloseWithError(err)
+			<-w.done
+			return err
+		}
+		w.pw.Close()
+		return <-w.done
+	}
+
+	// Small files of unknown size, and empty files, are stored with a single request.
+	if w.uploadID == "" {
+		hashsum := sha256.Sum256(w.buf.Bytes())
+		return w.d.do(w.ctx, "PUT", w.url, nil, bytes.NewReader(w.buf.Bytes()), int64(w.buf.Len()), hex.EncodeToString(hashsum[:]), nil)
+	}
+
+	if w.buf.Len() > 0 {
+		if err := w.uploadPart(); err != nil {
+			w.abort()
+			return err
+		}
+	}
+
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for i, etag := range w.etags {
+		complete.Parts = append(complete.Parts, part{i + 1, etag})
+	}
+	buf, err := xml.Marshal(complete)
+	if err != nil {
+		w.abort()
+		return fmt.Errorf("marshal multipart upload completion: %v", err)
+	}
+	hashsum := sha256.Sum256(buf)
+	if err := w.d.do(w.ctx, "POST", w.url, url.Values{"uploadId": {w.uploadID}}, bytes.NewReader(buf), int64(len(buf)), hex.EncodeToString(hashsum[:]), nil); err != nil {
+		w.abort()
+		return fmt.Errorf("completing multipart upload: %w", err)
+	}
+	return nil
+}
+
+// s3Error is the body of error responses.
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+}
+
+func (d *s3Dest) do(ctx context.Context, method, rawURL string, qs url.Values, body io.Reader, size int64, payloadHash string, result any) error {
+	return d.doHeader(ctx, method, rawURL, qs, body, size, payloadHash, result, nil)
+}
+
+// doHeader makes a signed request. If result is not nil, the XML response body
+// is parsed into it. If fn is not nil, it is called with the response headers
+// after a successful request.
+func (d *s3Dest) doHeader(ctx context.Context, method, rawURL string, qs url.Values, body io.Reader, size int64, payloadHash string, result any, fn func(h http.Header)) error {
+	if payloadHash == "" {
+		hashsum := sha256.Sum256(nil)
+		payloadHash = hex.EncodeToString(hashsum[:])
+	}
+	if body == nil || size == 0 {
+		// Otherwise an empty body would be sent with chunked transfer encoding.
+		body = http.NoBody
+	}
+
+	u := rawURL
+	if len(qs) > 0 {
+		u += "?" + s3CanonicalQuery(qs)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+	req.ContentLength = size
+	req.Header.Set("User-Agent", "mox/"+moxvar.Version)
+	s3Sign(req, d.config, payloadHash, time.Now())
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http request: %w", err)
+	}
+	defer func() {
+		err := resp.Body.Close()
+		d.log.Check(err, "closing response body")
+	}()
+	d.log.Debug("s3 request", slog.String("method", method), slog.String("url", rawURL), slog.Int("status", resp.StatusCode), slog.Duration("duration", time.Since(start)))
+
+	respBuf, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return fmt.Errorf("reading response: %v", err)
+	}
+	// Some requests, like completing a multipart upload, can fail with status 200
+	// and an error in the body.
+	var xerr s3Error
+	isErr := len(respBuf) > 0 && xml.Unmarshal(respBuf, &xerr) == nil && xerr.Code != ""
+	if isErr {
+		return fmt.Errorf("%w: %s: %s (status %d)", errS3, xerr.Code, xerr.Message, resp.StatusCode)
+	} else if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%w: status %d", errS3, resp.StatusCode)
+	}
+	if result != nil {
+		if err := xml.Unmarshal(respBuf, result); err != nil {
+			return fmt.Errorf("parsing response: %v", err)
+		}
+	}
+	if fn != nil {
+		fn(resp.Header)
+	}
+	return nil
+}
+
+var errS3 = errors.New("s3 error")
+
+// s3Sign adds an authorization header to req with AWS signature version 4.
+func s3Sign(req *http.Request, config Config, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", config.SessionToken)
+	}
+
+	// Sign the host header and all x-amz- headers.
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path, false),
+		s3CanonicalQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + config.Region + "/s3/aws4_request"
+	canonHash := sha256.Sum256([]byte(canonRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonHash[:])
+
+	hmacsha256 := func(key []byte, data string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(data))
+		return mac.Sum(nil)
+	}
+	key := hmacsha256([]byte("AWS4"+config.SecretAccessKey), date)
+	key = hmacsha256(key, config.Region)
+	key = hmacsha256(key, "s3")
+	key = hmacsha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacsha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// s3CanonicalQuery returns the query string with keys sorted and keys and
+// values escaped, as required for signing.
+func s3CanonicalQuery(qs url.Values) string {
+	var l []string
+	for k, vl := range qs {
+		for _, v := range vl {
+			l = append(l, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	slices.Sort(l)
+	return strings.Join(l, "&")
+}
+
+// s3Escape percent-encodes all but unreserved characters, and slashes if
+// escapeSlash is set.
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !escapeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,512 @@
+package backupdest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+// SFTP version 3, as implemented by OpenSSH.
+// https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-02
+
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpWrite    = 6
+	sftpMkdir    = 14
+	sftpStat     = 17
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpAttrs    = 105
+	sftpStatusOK = 0
+
+	sftpOpenWrite  = 0x02
+	sftpOpenCreate = 0x08
+	sftpOpenExcl   = 0x20
+)
+
+// Data per write request. OpenSSH accepts up to 256KB, but 32KB is the minimum
+// servers must support.
+const sftpChunkSize = 32 * 1024
+
+// Maximum number of write requests in flight, so we aren't limited by latency.
+const sftpMaxPending = 64
+
+type sftpDest struct {
+	log     mlog.Log
+	conn    *ssh.Client
+	session *ssh.Session
+	client  *sftpClient
+	dir     string
+}
+
+func openSFTP(ctx context.Context, log mlog.Log, u *url.URL, config Config) (*sftpDest, error) {
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("missing user in sftp url")
+	}
+	if _, ok := u.User.Password(); ok {
+		return nil, fmt.Errorf("password in sftp url not supported, use a private key")
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("missing host in sftp url")
+	}
+	if len(config.PrivateKey) == 0 {
+		return nil, fmt.Errorf("missing private key for sftp")
+	}
+	signer, err := ssh.ParsePrivateKey(config.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %v", err)
+	}
+	hostKeyCallback, err := knownHostsCallback(config.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("parsing known hosts: %v", err)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	nc, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %v", err)
+	}
+	sshConfig := &ssh.ClientConfig{
+		User:            u.User.Username(),
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}
+	sc, chans, reqs, err := ssh.NewClientConn(nc, addr, sshConfig)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("ssh handshake: %v", err)
+	}
+	d := &sftpDest{log: log, conn: ssh.NewClient(sc, chans, reqs), dir: u.Path}
+	if err := d.start(); err != nil {
+		d.Close()
+		return nil, err
+	}
+	log.Debug("sftp session started", slog.String("addr", addr))
+	return d, nil
+}
+
+func (d *sftpDest) start() error {
+	var err error
+	d.session, err = d.conn.NewSession()
+	if err != nil {
+		return fmt.Errorf("new ssh session: %v", err)
+	}
+	w, err := d.session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("stdin for ssh session: %v", err)
+	}
+	r, err := d.session.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("stdout for ssh session: %v", err)
+	}
+	if err := d.session.RequestSubsystem("sftp"); err != nil {
+		return fmt.Errorf("requesting sftp subsystem: %v", err)
+	}
+	d.client, err = newSFTPClient(r, w)
+	return err
+}
+
+func (d *sftpDest) Create(ctx context.Context, p string, size int64) (io.WriteCloser, error) {
+	f, err := d.client.create(path.Join(d.dir, p))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (d *sftpDest) Close() error {
+	if d.session != nil {
+		err := d.session.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
+			d.log.Check(err, "closing ssh session")
+		}
+	}
+	return d.conn.Close()
+}
+
+// knownHostsCallback returns a callback that verifies the host key of the
+// server is in the known_hosts file contents. Plain and hashed host names are
+// supported, wildcard patterns are not.
+func knownHostsCallback(knownHosts []byte) (ssh.HostKeyCallback, error) {
+	type entry struct {
+		marker string
+		hosts  []string
+		key    ssh.PublicKey
+	}
+	var entries []entry
+	rest := knownHosts
+	for len(bytes.TrimSpace(rest)) > 0 {
+		marker, hosts, key, _, r, err := ssh.ParseKnownHosts(rest)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		rest = r
+		entries = append(entries, entry{marker, hosts, key})
+	}
+
+	matchHost := func(pattern, host string) bool {
+		if s, ok := strings.CutPrefix(pattern, "|1|"); ok {
+			salt64, hash64, ok := strings.Cut(s, "|")
+			if !ok {
+				return false
+			}
+			salt, err0 := base64.StdEncoding.DecodeString(salt64)
+			hash, err1 := base64.StdEncoding.DecodeString(hash64)
+			if err0 != nil || err1 != nil {
+				return false
+			}
+			mac := hmac.New(sha1.New, salt)
+			mac.Write([]byte(host))
+			return hmac.Equal(mac.Sum(nil), hash)
+		}
+		return pattern == host
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		// Hosts on the default port are listed without port.
+		host, port, err := net.SplitHostPort(hostname)
+		if err != nil {
+			return fmt.Errorf("parsing hostname: %v", err)
+		}
+		if port != "22" {
+			host = "[" + host + "]:" + port
+		}
+		var known bool
+		for _, e := range entries {
+			var match bool
+			for _, h := range e.hosts {
+				if matchHost(h, host) {
+					match = true
+					break
+				}
+			}
+			if !match {
+				continue
+			}
+			sameKey := bytes.Equal(e.key.Marshal(), key.Marshal())
+			switch e.marker {
+			case "":
+				known = known || sameKey
+			case "revoked":
+				if sameKey {
+					return fmt.Errorf("host key for %s is revoked", host)
+				}
+			}
+		}
+		if !known {
+			return fmt.Errorf("host key %s for %s not in known hosts", ssh.FingerprintSHA256(key), host)
+		}
+		return nil
+	}, nil
+}
+
+// sftpClient is a minimal SFTP client, for creating directories and writing
+// files. Requests are made one at a time, except for writes which are
+// pipelined.
+type sftpClient struct {
+	r      io.Reader
+	w      io.WriteCloser
+	nextID uint32
+
+	pending map[uint32]*sftpFile // Write requests without response yet.
+	dirs    map[string]struct{}  // Directories known to exist.
+}
+
+func newSFTPClient(r io.Reader, w io.WriteCloser) (*sftpClient, error) {
+	c := &sftpClient{r: r, w: w, pending: map[uint32]*sftpFile{}, dirs: map[string]struct{}{}}
+
+	var b sftpBuf
+	b.uint32(3)
+	if err := c.writePacket(sftpInit, b); err != nil {
+		return nil, fmt.Errorf("writing sftp init: %v", err)
+	}
+	typ, data, err := c.readPacket()
+	if err != nil {
+		return nil, fmt.Errorf("reading sftp version: %v", err)
+	}
+	if typ != sftpVersion || len(data) < 4 {
+		return nil, fmt.Errorf("unexpected sftp response type %d to init", typ)
+	}
+	if v := binary.BigEndian.Uint32(data); v < 3 {
+		return nil, fmt.Errorf("unsupported sftp version %d", v)
+	}
+	return c, nil
+}
+
+// sftpBuf is the payload of a packet being built.
+type sftpBuf []byte
+
+func (b *sftpBuf) uint32(v uint32) {
+	*b = binary.BigEndian.AppendUint32(*b, v)
+}
+
+func (b *sftpBuf) uint64(v uint64) {
+	*b = binary.BigEndian.AppendUint64(*b, v)
+}
+
+func (b *sftpBuf) string(s []byte) {
+	b.uint32(uint32(len(s)))
+	*b = append(*b, s...)
+}
+
+func (c *sftpClient) writePacket(typ byte, payload []byte) error {
+	buf := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(1+len(payload)))
+	buf[4] = typ
+	_, err := c.w.Write(append(buf, payload...))
+	return err
+}
+
+func (c *sftpClient) readPacket() (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:4])
+	if n == 0 || n > 256*1024 {
+		return 0, nil, fmt.Errorf("bad sftp packet length %d", n)
+	}
+	data := make([]byte, n-1)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return 0, nil, err
+	}
+	return hdr[4], data, nil
+}
+
+// sftpStatusError is an error status from the server.
+type sftpStatusError struct {
+	Code uint32
+	Msg  string
+}
+
+func (e sftpStatusError) Error() string {
+	return fmt.Sprintf("sftp status %d: %s", e.Code, e.Msg)
+}
+
+// parseStatus returns the error for a status response, or nil for OK.
+func parseStatus(data []byte) error {
+	if len(data) < 4 {
+		return fmt.Errorf("short sftp status")
+	}
+	code := binary.BigEndian.Uint32(data)
+	if code == sftpStatusOK {
+		return nil
+	}
+	var msg string
+	if len(data) >= 8 {
+		n := binary.BigEndian.Uint32(data[4:])
+		if uint64(n) <= uint64(len(data)-8) {
+			msg = string(data[8 : 8+n])
+		}
+	}
+	return sftpStatusError{code, msg}
+}
+
+// readResponse reads a response, returning its type and the data after the
+// request id. Responses to pending writes are handled first.
+func (c *sftpClient) readResponse() (uint32, byte, []byte, error) {
+	typ, data, err := c.readPacket()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if len(data) < 4 {
+		return 0, 0, nil, fmt.Errorf("short sftp response")
+	}
+	id := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if f, ok := c.pending[id]; ok {
+		delete(c.pending, id)
+		if typ != sftpStatus {
+			f.err = fmt.Errorf("unexpected sftp response type %d to write", typ)
+		} else if err := parseStatus(data); err != nil && f.err == nil {
+			f.err = err
+		}
+	}
+	return id, typ, data, nil
+}
+
+// drain reads responses to all pending writes.
+func (c *sftpClient) drain() error {
+	for len(c.pending) > 0 {
+		if _, _, _, err := c.readResponse(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// request sends a request and waits for its response.
+func (c *sftpClient) request(typ byte, b sftpBuf) (byte, []byte, error) {
+	if err := c.drain(); err != nil {
+		return 0, nil, err
+	}
+	c.nextID++
+	id := c.nextID
+	payload := binary.BigEndian.AppendUint32(nil, id)
+	if err := c.writePacket(typ, append(payload, b...)); err != nil {
+		return 0, nil, err
+	}
+	rid, rtyp, data, err := c.readResponse()
+	if err != nil {
+		return 0, nil, err
+	} else if rid != id {
+		return 0, nil, fmt.Errorf("sftp response for id %d, expected %d", rid, id)
+	}
+	return rtyp, data, nil
+}
+
+// mkdirAll creates directory p and its parents, if they don't exist yet.
+func (c *sftpClient) mkdirAll(p string) error {
+	if p == "" || p == "/" || p == "." {
+		return nil
+	}
+	if _, ok := c.dirs[p]; ok {
+		return nil
+	}
+	if err := c.mkdirAll(path.Dir(p)); err != nil {
+		return err
+	}
+
+	var b sftpBuf
+	b.string([]byte(p))
+	b.uint32(0) // No attributes.
+	typ, data, err := c.request(sftpMkdir, b)
+	if err != nil {
+		return err
+	} else if typ != sftpStatus {
+		return fmt.Errorf("unexpected sftp response type %d to mkdir", typ)
+	}
+	if mkdirErr := parseStatus(data); mkdirErr != nil {
+		// Servers typically return a generic failure if the directory already exists.
+		var b sftpBuf
+		b.string([]byte(p))
+		typ, _, err := c.request(sftpStat, b)
+		if err != nil {
+			return err
+		} else if typ != sftpAttrs {
+			return fmt.Errorf("mkdir %s: %w", p, mkdirErr)
+		}
+	}
+	c.dirs[p] = struct{}{}
+	return nil
+}
+
+func (c *sftpClient) create(p string) (*sftpFile, error) {
+	if err := c.mkdirAll(path.Dir(p)); err != nil {
+		return nil, fmt.Errorf("creating directory: %w", err)
+	}
+
+	// Like local backups, we don't overwrite existing files.
+	var b sftpBuf
+	b.string([]byte(p))
+	b.uint32(sftpOpenWrite | sftpOpenCreate | sftpOpenExcl)
+	b.uint32(0) // No attributes.
+	typ, data, err := c.request(sftpOpen, b)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case sftpHandle:
+		if len(data) < 4 || uint64(binary.BigEndian.Uint32(data)) > uint64(len(data)-4) {
+			return nil, fmt.Errorf("bad sftp handle")
+		}
+		handle := data[4 : 4+binary.BigEndian.Uint32(data)]
+		return &sftpFile{c: c, path: p, handle: handle}, nil
+	case sftpStatus:
+		if err := parseStatus(data); err != nil {
+			return nil, fmt.Errorf("open %s: %w", p, err)
+		}
+	}
+	return nil, fmt.Errorf("unexpected sftp response type %d to open", typ)
+}
+
+// sftpFile is a file opened for writing.
+type sftpFile struct {
+	c      *sftpClient
+	path   string
+	handle []byte
+	offset uint64
+	err    error // From write response.
+}
+
+func (f *sftpFile) Write(buf []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	n := len(buf)
+	for len(buf) > 0 {
+		for len(f.c.pending) >= sftpMaxPending {
+			if _, _, _, err := f.c.readResponse(); err != nil {
+				return 0, err
+			}
+		}
+		if f.err != nil {
+			return 0, f.err
+		}
+
+		chunk := buf[:min(len(buf), sftpChunkSize)]
+		buf = buf[len(chunk):]
+
+		f.c.nextID++
+		id := f.c.nextID
+		var b sftpBuf
+		b.uint32(id)
+		b.string(f.handle)
+		b.uint64(f.offset)
+		b.string(chunk)
+		if err := f.c.writePacket(sftpWrite, b); err != nil {
+			return 0, err
+		}
+		f.c.pending[id] = f
+		f.offset += uint64(len(chunk))
+	}
+	return n, nil
+}
+
+func (f *sftpFile) Close() error {
+	if err := f.c.drain(); err != nil {
+		return err
+	}
+	var b sftpBuf
+	b.string(f.handle)
+	typ, data, err := f.c.request(sftpClose, b)
+	if err != nil {
+		return err
+	} else if typ != sftpStatus {
+		return fmt.Errorf("unexpected sftp response type %d to close", typ)
+	}
+	if f.err != nil {
+		return fmt.Errorf("write %s: %w", f.path, f.err)
+	}
+	if err := parseStatus(data); err != nil {
+		return fmt.Errorf("close %s: %w", f.path, err)
+	}
+	return nil
+}
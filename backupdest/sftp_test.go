@@ -0,0 +1,275 @@
+package backupdest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeSFTPServer serves SFTP requests for creating directories and writing files
+// in memory.
+func fakeSFTPServer(r io.Reader, w io.WriteCloser, dirs map[string]bool, files map[string][]byte) error {
+	defer w.Close()
+
+	handles := map[string]string{} // Handle to path.
+	for {
+		var hdr [5]byte
+		if _, err := io.ReadFull(r, hdr[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		data := make([]byte, binary.BigEndian.Uint32(hdr[:4])-1)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		typ := hdr[4]
+
+		xstring := func() string {
+			n := binary.BigEndian.Uint32(data)
+			s := string(data[4 : 4+n])
+			data = data[4+n:]
+			return s
+		}
+		respond := func(typ byte, b sftpBuf) {
+			buf := binary.BigEndian.AppendUint32(nil, uint32(1+len(b)))
+			buf = append(buf, typ)
+			w.Write(append(buf, b...))
+		}
+		status := func(id, code uint32) {
+			var b sftpBuf
+			b.uint32(id)
+			b.uint32(code)
+			b.string([]byte("status"))
+			b.string(nil)
+			respond(sftpStatus, b)
+		}
+
+		if typ == sftpInit {
+			var b sftpBuf
+			b.uint32(3)
+			respond(sftpVersion, b)
+			continue
+		}
+		id := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		switch typ {
+		case sftpMkdir:
+			p := xstring()
+			if dirs[p] {
+				status(id, 4) // Failure.
+			} else {
+				dirs[p] = true
+				status(id, sftpStatusOK)
+			}
+		case sftpStat:
+			if dirs[xstring()] {
+				var b sftpBuf
+				b.uint32(id)
+				b.uint32(0)
+				respond(sftpAttrs, b)
+			} else {
+				status(id, 2) // No such file.
+			}
+		case sftpOpen:
+			p := xstring()
+			if _, ok := files[p]; ok {
+				status(id, 4)
+				continue
+			}
+			files[p] = nil
+			h := fmt.Sprintf("h%d", id)
+			handles[h] = p
+			var b sftpBuf
+			b.uint32(id)
+			b.string([]byte(h))
+			respond(sftpHandle, b)
+		case sftpWrite:
+			p := handles[xstring()]
+			offset := binary.BigEndian.Uint64(data)
+			data = data[8:]
+			buf := xstring()
+			if uint64(len(files[p])) != offset {
+				status(id, 4)
+				continue
+			}
+			files[p] = append(files[p], buf...)
+			status(id, sftpStatusOK)
+		case sftpClose:
+			delete(handles, xstring())
+			status(id, sftpStatusOK)
+		default:
+			status(id, 8) // Unsupported.
+		}
+	}
+}
+
+// bufPipe is a pipe with an unbounded buffer.
+type bufPipe struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (p *bufPipe) Write(buf []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buf.Write(buf)
+	p.cond.Broadcast()
+	return len(buf), nil
+}
+
+func (p *bufPipe) Read(buf []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.buf.Len() == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if p.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return p.buf.Read(buf)
+}
+
+func (p *bufPipe) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.cond.Broadcast()
+	return nil
+}
+
+func TestSFTPClient(t *testing.T) {
+	dirs := map[string]bool{"/backups": true}
+	files := map[string][]byte{}
+
+	// Responses are buffered, like an ssh channel does, so the client can have
+	// multiple writes in flight.
+	sw := &bufPipe{}
+	sw.cond = sync.NewCond(&sw.mu)
+	cr := sw
+	sr, cw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- fakeSFTPServer(sr, sw, dirs, files)
+	}()
+
+	c, err := newSFTPClient(cr, cw)
+	tcheck(t, err, "new sftp client")
+
+	write := func(p string, data []byte) error {
+		t.Helper()
+		f, err := c.create(p)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	err = write("/backups/data/auth.db", []byte("hello"))
+	tcheck(t, err, "write")
+	big := bytes.Repeat([]byte("x"), sftpChunkSize*(sftpMaxPending+10)+10)
+	err = write("/backups/data/accounts/mjl/msg/a/1", big)
+	tcheck(t, err, "write big")
+
+	// Files aren't overwritten.
+	err = write("/backups/data/auth.db", []byte("hello"))
+	if err == nil {
+		t.Fatalf("overwriting file succeeded")
+	}
+
+	// Two files open at the same time.
+	f1, err := c.create("/backups/data/accounts/mjl/journal.jsonl")
+	tcheck(t, err, "create")
+	err = write("/backups/data/accounts/mjl/msg/a/2", big)
+	tcheck(t, err, "write while other file open")
+	_, err = f1.Write([]byte("{}\n"))
+	tcheck(t, err, "write")
+	err = f1.Close()
+	tcheck(t, err, "close")
+
+	cw.Close()
+	err = <-done
+	tcheck(t, err, "sftp server")
+
+	for _, d := range []string{"/backups/data", "/backups/data/accounts/mjl/msg/a"} {
+		if !dirs[d] {
+			t.Fatalf("missing dir %s", d)
+		}
+	}
+	check := func(p string, exp []byte) {
+		t.Helper()
+		if !bytes.Equal(files[p], exp) {
+			t.Fatalf("file %s: got %d bytes, expected %d", p, len(files[p]), len(exp))
+		}
+	}
+	check("/backups/data/auth.db", []byte("hello"))
+	check("/backups/data/accounts/mjl/msg/a/1", big)
+	check("/backups/data/accounts/mjl/msg/a/2", big)
+	check("/backups/data/accounts/mjl/journal.jsonl", []byte("{}\n"))
+}
+
+func TestKnownHosts(t *testing.T) {
+	newKey := func(seed byte) ssh.PublicKey {
+		t.Helper()
+		pk := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize)).Public()
+		key, err := ssh.NewPublicKey(pk)
+		tcheck(t, err, "new public key")
+		return key
+	}
+	key1 := newKey(1)
+	key2 := newKey(2)
+
+	line := func(hosts string, key ssh.PublicKey) string {
+		return hosts + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	}
+	hashed := func(host string) string {
+		salt := bytes.Repeat([]byte{3}, sha1.Size)
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(host))
+		return "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	knownHosts := strings.Join([]string{
+		"# comment",
+		line("backup.example,192.0.2.1", key1),
+		line("[backup.example]:2222", key2),
+		line(hashed("hashed.example"), key1),
+		"@revoked " + line("revoked.example", key2),
+		line("revoked.example", key2),
+	}, "\n") + "\n"
+
+	cb, err := knownHostsCallback([]byte(knownHosts))
+	tcheck(t, err, "parse known hosts")
+
+	test := func(hostname string, key ssh.PublicKey, expOK bool) {
+		t.Helper()
+		err := cb(hostname, &net.TCPAddr{}, key)
+		if (err == nil) != expOK {
+			t.Fatalf("host key for %s: got err %v, expected ok %v", hostname, err, expOK)
+		}
+	}
+	test("backup.example:22", key1, true)
+	test("192.0.2.1:22", key1, true)
+	test("backup.example:22", key2, false)
+	test("backup.example:2222", key2, true)
+	test("backup.example:2222", key1, false)
+	test("hashed.example:22", key1, true)
+	test("other.example:22", key1, false)
+	test("revoked.example:22", key2, false)
+}
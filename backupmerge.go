@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/store"
+)
+
+func cmdBackupmerge(c *cmd) {
+	c.params = "basedir incrementaldir destdir"
+	c.help = `Merge an incremental backup with the backup it was based on, into a full backup.
+
+Incremental backups, made with "mox backup -incremental", don't have copies of
+account databases, but journals with changes to the account databases since the
+previous backup, and only the message files added since. This command makes a
+full backup in destdir (which must not yet exist) by copying the account
+databases from basedir and applying the journals from incrementaldir, and
+copying all other files from incrementaldir. Message files are hardlinked if
+possible. The base backup and incremental backup are not modified.
+
+The base backup must be the backup that the incremental backup was made
+against. That can be a full backup, or an earlier incremental backup that has
+been merged. The resulting backup can be restored as a regular backup, and be
+used as base for merging a next incremental backup.
+
+Mox does not have to be running for this command.
+`
+	args := c.Parse()
+	if len(args) != 3 {
+		c.Usage()
+	}
+	baseDir, incrDir, dstDir := args[0], args[1], args[2]
+
+	if err := backupMerge(context.Background(), c, baseDir, incrDir, dstDir); err != nil {
+		log.Fatalf("merging backups: %v", err)
+	}
+}
+
+func backupMerge(ctx context.Context, c *cmd, baseDir, incrDir, dstDir string) error {
+	baseManifest, err := readBackupManifest(baseDir)
+	if err != nil {
+		return fmt.Errorf("reading manifest of base backup: %v", err)
+	}
+	incrManifest, err := readBackupManifest(incrDir)
+	if err != nil {
+		return fmt.Errorf("reading manifest of incremental backup: %v", err)
+	}
+	if !incrManifest.Incremental {
+		return fmt.Errorf("backup %s is not an incremental backup", incrDir)
+	}
+	if _, err := os.Stat(dstDir); err == nil {
+		return fmt.Errorf("destination %s already exists", dstDir)
+	}
+
+	// Message files are hardlinked. All other files are copied, databases can be
+	// modified when opened.
+	isMessageFile := func(relpath string) bool {
+		l := strings.Split(relpath, string(filepath.Separator))
+		return len(l) > 4 && l[0] == "data" && l[1] == "accounts" && l[3] == "msg" || len(l) > 2 && l[0] == "data" && l[1] == "queue" && l[2] != "index.db"
+	}
+
+	// Copy all files from the incremental backup, except the journals.
+	journals := map[string]string{} // Account name to journal path.
+	err = filepath.WalkDir(incrDir, func(srcpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relpath, err := filepath.Rel(incrDir, srcpath)
+		if err != nil {
+			return err
+		}
+		if relpath == "backup.json" {
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			linkDest, err := os.Readlink(srcpath)
+			if err != nil {
+				return err
+			}
+			dstpath := filepath.Join(dstDir, relpath)
+			if err := os.MkdirAll(filepath.Dir(dstpath), 0770); err != nil {
+				return err
+			}
+			return os.Symlink(linkDest, dstpath)
+		}
+		l := strings.Split(relpath, string(filepath.Separator))
+		if len(l) == 4 && l[0] == "data" && l[1] == "accounts" && l[3] == "journal.jsonl" {
+			journals[l[2]] = srcpath
+			return nil
+		}
+		return backupMergeFile(srcpath, filepath.Join(dstDir, relpath), isMessageFile(relpath))
+	})
+	if err != nil {
+		return fmt.Errorf("copying files from incremental backup: %v", err)
+	}
+
+	for accName, journalPath := range journals {
+		if _, ok := baseManifest.Accounts[accName]; !ok {
+			return fmt.Errorf("account %q with journal not in base backup", accName)
+		}
+		accDir := filepath.Join("data", "accounts", accName)
+		dbpath := filepath.Join(dstDir, accDir, "index.db")
+		if err := backupMergeFile(filepath.Join(baseDir, accDir, "index.db"), dbpath, false); err != nil {
+			return fmt.Errorf("copying account database from base backup: %v", err)
+		}
+		if err := backupMergeJournal(ctx, c, dbpath, journalPath); err != nil {
+			return fmt.Errorf("applying journal for account %q: %v", accName, err)
+		}
+
+		// Link the message files that were already in the base backup.
+		opts := bstore.Options{MustExist: true, RegisterLogger: c.log.Logger}
+		db, err := bstore.Open(ctx, dbpath, &opts, store.DBTypes...)
+		if err != nil {
+			return fmt.Errorf("open account database: %v", err)
+		}
+		err = bstore.QueryDB[store.Message](ctx, db).FilterEqual("Expunged", false).ForEach(func(m store.Message) error {
+			mp := filepath.Join(accDir, "msg", store.MessagePath(m.ID))
+			dstpath := filepath.Join(dstDir, mp)
+			if _, err := os.Stat(dstpath); err == nil {
+				return nil
+			}
+			return backupMergeFile(filepath.Join(baseDir, mp), dstpath, true)
+		})
+		if xerr := db.Close(); xerr != nil && err == nil {
+			err = fmt.Errorf("closing account database: %v", xerr)
+		}
+		if err != nil {
+			return fmt.Errorf("linking message files from base backup for account %q: %v", accName, err)
+		}
+	}
+
+	m := backupManifest{
+		Version:  1,
+		Time:     incrManifest.Time,
+		Accounts: incrManifest.Accounts,
+	}
+	if err := writeBackupManifest(dstDir, m); err != nil {
+		return fmt.Errorf("writing backup manifest: %v", err)
+	}
+	return nil
+}
+
+func backupMergeJournal(ctx context.Context, c *cmd, dbpath, journalPath string) error {
+	f, err := os.Open(journalPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	opts := bstore.Options{MustExist: true, RegisterLogger: c.log.Logger}
+	db, err := bstore.Open(ctx, dbpath, &opts, store.DBTypes...)
+	if err != nil {
+		return fmt.Errorf("open account database: %v", err)
+	}
+	_, err = store.ApplyJournal(ctx, db, f)
+	if xerr := db.Close(); xerr != nil && err == nil {
+		err = fmt.Errorf("closing account database: %v", xerr)
+	}
+	return err
+}
+
+// backupMergeFile hardlinks (if link is set) or copies srcpath to dstpath,
+// creating the parent directory.
+func backupMergeFile(srcpath, dstpath string, link bool) error {
+	if err := os.MkdirAll(filepath.Dir(dstpath), 0770); err != nil {
+		return err
+	}
+	if link {
+		if err := os.Link(srcpath, dstpath); err == nil {
+			return nil
+		}
+	}
+	sf, err := os.Open(srcpath)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	info, err := sf.Stat()
+	if err != nil {
+		return err
+	}
+	df, err := os.OpenFile(dstpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode()&0777)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(df, sf); err != nil {
+		df.Close()
+		return fmt.Errorf("copying %s: %v", srcpath, err)
+	}
+	return df.Close()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+var (
+	metricBackupLastSuccess = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mox_backup_last_success_timestamp_seconds",
+			Help: "Start time of the most recent successful scheduled backup, as unix timestamp.",
+		},
+	)
+	metricBackupScheduled = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_backup_scheduled_total",
+			Help: "Scheduled backups, by result.",
+		},
+		[]string{
+			"result", // ok, error
+		},
+	)
+	metricBackupDuration = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mox_backup_last_duration_seconds",
+			Help: "Duration of the most recent scheduled backup, successful or not.",
+		},
+	)
+)
+
+// Name of directories for scheduled backups, the local start time.
+const backupNameFormat = "20060102-150405"
+
+// scheduledBackups returns the names of successful scheduled backups in dir, most
+// recent first. Backups are recognized by their name and a backup.json. Names of
+// directories of backups that did not complete, e.g. due to a crash, are returned
+// in incomplete.
+func scheduledBackups(dir string) (names, incomplete []string, rerr error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := time.ParseInLocation(backupNameFormat, e.Name(), time.Local); err != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, e.Name(), "backup.json")); err != nil {
+			incomplete = append(incomplete, e.Name())
+		} else {
+			names = append(names, e.Name())
+		}
+	}
+	// Names sort chronologically.
+	slices.Sort(names)
+	slices.Reverse(names)
+	return names, incomplete, nil
+}
+
+// backupsExpired returns the names of backups to remove, keeping the most recent
+// backup for each of the last keepDaily days with a backup, and for each of the
+// last keepWeekly weeks with a backup. Names must be sorted most recent first.
+func backupsExpired(names []string, keepDaily, keepWeekly int) (expired []string) {
+	days := map[string]bool{}
+	type week struct{ year, week int }
+	weeks := map[week]bool{}
+	for _, name := range names {
+		t, err := time.ParseInLocation(backupNameFormat, name, time.Local)
+		if err != nil {
+			continue
+		}
+		var keep bool
+		day := t.Format("2006-01-02")
+		if !days[day] && len(days) < keepDaily {
+			days[day] = true
+			keep = true
+		}
+		var w week
+		w.year, w.week = t.ISOWeek()
+		if !weeks[w] && len(weeks) < keepWeekly {
+			weeks[w] = true
+			keep = true
+		}
+		if !keep {
+			expired = append(expired, name)
+		}
+	}
+	return expired
+}
+
+// scheduledBackup makes a backup in a new directory in dir, and removes old
+// backups according to the retention settings.
+func scheduledBackup(ctx context.Context, log mlog.Log, conf config.BackupSchedule, dir string, start time.Time) {
+	names, incomplete, err := scheduledBackups(dir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorx("listing earlier backups", err, slog.String("dir", dir))
+	}
+
+	opts := backupOptions{DestDir: filepath.Join(dir, start.Format(backupNameFormat))}
+	if conf.LinkDest && len(names) > 0 {
+		opts.LinkDir = filepath.Join(dir, names[0])
+	}
+	log.Info("starting scheduled backup", slog.String("destdir", opts.DestDir), slog.String("linkdir", opts.LinkDir))
+	failed := backup(ctx, log, io.Discard, opts)
+	metricBackupDuration.Set(time.Since(start).Seconds())
+	if failed {
+		metricBackupScheduled.WithLabelValues("error").Inc()
+		log.Error("scheduled backup failed, removing incomplete backup", slog.String("destdir", opts.DestDir))
+		err := os.RemoveAll(opts.DestDir)
+		log.Check(err, "removing incomplete backup", slog.String("destdir", opts.DestDir))
+		return
+	}
+	metricBackupScheduled.WithLabelValues("ok").Inc()
+	metricBackupLastSuccess.Set(float64(start.Unix()))
+	log.Info("scheduled backup finished", slog.String("destdir", opts.DestDir), slog.Duration("duration", time.Since(start)))
+
+	names = append([]string{filepath.Base(opts.DestDir)}, names...)
+	for _, name := range append(incomplete, backupsExpired(names, conf.KeepDaily, conf.KeepWeekly)...) {
+		p := filepath.Join(dir, name)
+		if err := os.RemoveAll(p); err != nil {
+			log.Errorx("removing old backup", err, slog.String("dir", p))
+		} else {
+			log.Info("removed old backup", slog.String("dir", p))
+		}
+	}
+}
+
+// startBackupSchedule starts a goroutine that makes backups according to the
+// schedule in conf.
+func startBackupSchedule(conf config.BackupSchedule) {
+	log := mlog.New("backup", nil)
+
+	sch, err := mox.ParseSchedule(conf.Schedule)
+	if err != nil {
+		// Should not happen, the config was validated.
+		log.Errorx("parsing backup schedule, not making scheduled backups", err)
+		return
+	}
+	dir := mox.DataDirPath(conf.Directory)
+
+	// Initialize the metric, so monitoring works across restarts.
+	if names, _, err := scheduledBackups(dir); err == nil && len(names) > 0 {
+		if t, err := time.ParseInLocation(backupNameFormat, names[0], time.Local); err == nil {
+			metricBackupLastSuccess.Set(float64(t.Unix()))
+		}
+	}
+
+	go func() {
+		defer func() {
+			x := recover()
+			if x == nil {
+				return
+			}
+
+			log.Error("unhandled panic in scheduled backups", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Backup)
+		}()
+
+		for {
+			next := sch.Next(time.Now())
+			if next.IsZero() {
+				log.Error("backup schedule has no next time, stopping scheduled backups", slog.String("schedule", conf.Schedule))
+				return
+			}
+			log.Debug("waiting for next scheduled backup", slog.Time("next", next))
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-mox.Shutdown.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			scheduledBackup(mox.Shutdown, log, conf, dir, time.Now())
+		}
+	}()
+}
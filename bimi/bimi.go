@@ -0,0 +1,186 @@
+// Package bimi implements BIMI (Brand Indicators for Message Identification)
+// DNS records, for looking up and validating a brand logo for a domain.
+//
+// A domain publishes a BIMI DNS TXT record at "<selector>._bimi.<domain>", with
+// an HTTPS URL to an SVG logo, and optionally an HTTPS URL to a "Verified Mark
+// Certificate" (VMC) for the logo. Receiving mail clients may show the logo for
+// messages that pass DMARC with an enforcing policy (quarantine or reject).
+// Senders can request a selector other than "default" with a BIMI-Selector
+// message header. Mox does not show BIMI logos for incoming messages.
+package bimi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/publicsuffix"
+)
+
+// See https://datatracker.ietf.org/doc/html/draft-brand-indicators-for-message-identification
+
+// Lookup errors.
+var (
+	ErrNoRecord        = errors.New("bimi: no bimi dns record")
+	ErrMultipleRecords = errors.New("bimi: multiple bimi dns records")
+	ErrDNS             = errors.New("bimi: dns lookup")
+	ErrSyntax          = errors.New("bimi: malformed bimi dns record")
+)
+
+// DefaultSelector is used when a message does not have a BIMI-Selector header.
+const DefaultSelector = "default"
+
+// Record is a BIMI DNS TXT record, e.g.:
+//
+//	v=BIMI1; l=https://example.com/logo.svg; a=https://example.com/vmc.pem
+type Record struct {
+	Version   string // "BIMI1"
+	Location  string // "l=", HTTPS URL to SVG logo. Empty for a "declination to publish" record.
+	Authority string // "a=", optional HTTPS URL to PEM-encoded Verified Mark Certificate.
+}
+
+// DefaultRecord holds the defaults for a BIMI record.
+var DefaultRecord = Record{
+	Version: "BIMI1",
+}
+
+// String returns the record in DNS TXT form.
+func (r Record) String() string {
+	s := "v=" + r.Version + "; l=" + r.Location
+	if r.Authority != "" {
+		s += "; a=" + r.Authority
+	}
+	return s
+}
+
+// ParseRecord parses a BIMI DNS TXT record.
+//
+// If the record does not start with "v=BIMI1", isbimi is false. Unknown tags are
+// ignored.
+func ParseRecord(s string) (record *Record, isbimi bool, rerr error) {
+	r := DefaultRecord
+	tags := strings.Split(s, ";")
+	// Version tag must come first, and its value is case-sensitive.
+	if k, v, _ := strings.Cut(tags[0], "="); strings.TrimSpace(k) != "v" || strings.TrimSpace(v) != "BIMI1" {
+		return nil, false, fmt.Errorf("%w: record must start with v=BIMI1", ErrSyntax)
+	}
+	for i, t := range tags[1:] {
+		t = strings.TrimSpace(t)
+		if t == "" && i == len(tags)-2 {
+			// Trailing semicolon.
+			break
+		}
+		k, v, ok := strings.Cut(t, "=")
+		if !ok {
+			return nil, true, fmt.Errorf("%w: tag without value: %q", ErrSyntax, t)
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		switch k {
+		case "l":
+			if err := checkURL(v); err != nil {
+				return nil, true, fmt.Errorf("%w: location: %s", ErrSyntax, err)
+			}
+			r.Location = v
+		case "a":
+			if err := checkURL(v); err != nil {
+				return nil, true, fmt.Errorf("%w: authority: %s", ErrSyntax, err)
+			}
+			r.Authority = v
+		case "v":
+			return nil, true, fmt.Errorf("%w: duplicate version", ErrSyntax)
+		}
+	}
+	return &r, true, nil
+}
+
+// checkURL checks an l= or a= value, which must be empty or an https URL.
+// The draft allows for a comma-separated list, but only a single URL is
+// meaningful in practice, so we only accept that.
+func checkURL(s string) error {
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("url must be https, not %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("url must have host")
+	}
+	return nil
+}
+
+// Lookup looks up the BIMI TXT record at "<selector>._bimi.<domain>". An empty
+// selector means DefaultSelector.
+//
+// If no record is found, another lookup is done at the organizational domain of
+// the domain (if different), with the same selector. The returned domain is the
+// domain with the BIMI record.
+//
+// rauthentic indicates if the DNS results were DNSSEC-verified.
+func Lookup(ctx context.Context, elog *slog.Logger, resolver dns.Resolver, selector string, domain dns.Domain) (rdomain dns.Domain, record *Record, txt string, rauthentic bool, rerr error) {
+	log := mlog.New("bimi", elog)
+	start := time.Now()
+	defer func() {
+		log.Debugx("bimi lookup result", rerr,
+			slog.String("selector", selector),
+			slog.Any("domain", rdomain),
+			slog.Any("record", record),
+			slog.Duration("duration", time.Since(start)))
+	}()
+
+	if selector == "" {
+		selector = DefaultSelector
+	}
+
+	record, txt, authentic, err := lookupRecord(ctx, resolver, selector, domain)
+	if !errors.Is(err, ErrNoRecord) {
+		return domain, record, txt, authentic, err
+	}
+	orgDom := publicsuffix.Lookup(ctx, log.Logger, domain)
+	if orgDom == domain {
+		return domain, nil, "", authentic, err
+	}
+	record, txt, xauth, err := lookupRecord(ctx, resolver, selector, orgDom)
+	return orgDom, record, txt, authentic && xauth, err
+}
+
+func lookupRecord(ctx context.Context, resolver dns.Resolver, selector string, domain dns.Domain) (*Record, string, bool, error) {
+	name := selector + "._bimi." + domain.ASCII + "."
+	txts, result, err := dns.WithPackage(resolver, "bimi").LookupTXT(ctx, name)
+	if dns.IsNotFound(err) {
+		return nil, "", result.Authentic, ErrNoRecord
+	} else if err != nil {
+		return nil, "", result.Authentic, fmt.Errorf("%w: %s", ErrDNS, err)
+	}
+	var record *Record
+	var text string
+	for _, txt := range txts {
+		r, isbimi, err := ParseRecord(txt)
+		if !isbimi {
+			continue
+		}
+		if err != nil {
+			return nil, txt, result.Authentic, err
+		}
+		if record != nil {
+			return nil, "", result.Authentic, ErrMultipleRecords
+		}
+		record = r
+		text = txt
+	}
+	if record == nil {
+		return nil, "", result.Authentic, ErrNoRecord
+	}
+	return record, text, result.Authentic, nil
+}
@@ -0,0 +1,90 @@
+package bimi
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+)
+
+func TestParseRecord(t *testing.T) {
+	test := func(s string, exp *Record, expIsBIMI bool, expErr error) {
+		t.Helper()
+		r, isbimi, err := ParseRecord(s)
+		if (err == nil) != (expErr == nil) || err != nil && !errors.Is(err, expErr) {
+			t.Fatalf("parse %q: got err %v, expected %v", s, err, expErr)
+		}
+		if isbimi != expIsBIMI {
+			t.Fatalf("parse %q: got isbimi %v, expected %v", s, isbimi, expIsBIMI)
+		}
+		if !reflect.DeepEqual(r, exp) {
+			t.Fatalf("parse %q: got %#v, expected %#v", s, r, exp)
+		}
+	}
+
+	test("v=BIMI1; l=https://example.com/logo.svg", &Record{"BIMI1", "https://example.com/logo.svg", ""}, true, nil)
+	test("v=BIMI1;l=https://example.com/logo.svg;a=https://example.com/vmc.pem;", &Record{"BIMI1", "https://example.com/logo.svg", "https://example.com/vmc.pem"}, true, nil)
+	test("v=BIMI1; l=; a=", &Record{Version: "BIMI1"}, true, nil)
+	test("v=BIMI1; L=https://example.com/logo.svg; x=unknown", &Record{"BIMI1", "https://example.com/logo.svg", ""}, true, nil)
+
+	test("", nil, false, ErrSyntax)
+	test("v=DMARC1; p=reject", nil, false, ErrSyntax)
+	test("v=bimi1; l=https://example.com/logo.svg", nil, false, ErrSyntax)
+	test("l=https://example.com/logo.svg; v=BIMI1", nil, false, ErrSyntax)
+	test("v=BIMI1; l=http://example.com/logo.svg", nil, true, ErrSyntax)
+	test("v=BIMI1; a=https:///vmc.pem", nil, true, ErrSyntax)
+	test("v=BIMI1; l", nil, true, ErrSyntax)
+	test("v=BIMI1; v=BIMI1", nil, true, ErrSyntax)
+
+	r := Record{"BIMI1", "https://example.com/logo.svg", "https://example.com/vmc.pem"}
+	xr, _, err := ParseRecord(r.String())
+	if err != nil || !reflect.DeepEqual(*xr, r) {
+		t.Fatalf("parsing packed record: got %v %v, expected %v", xr, err, r)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	log := mlog.New("bimi", nil)
+	resolver := dns.MockResolver{
+		TXT: map[string][]string{
+			"default._bimi.simple.example.":    {"v=BIMI1; l=https://simple.example/logo.svg"},
+			"default._bimi.one.example.":       {"v=BIMI1; l=https://one.example/logo.svg", "other"},
+			"brand._bimi.one.example.":         {"v=BIMI1; l=https://one.example/brand.svg"},
+			"default._bimi.multiple.example.":  {"v=BIMI1; l=", "v=BIMI1; l="},
+			"default._bimi.malformed.example.": {"v=BIMI1; l=http://malformed.example/logo.svg"},
+			"default._bimi.example.com.":       {"v=BIMI1; l=https://example.com/logo.svg"},
+		},
+		Fail: []string{
+			"txt default._bimi.temperror.example.",
+		},
+	}
+
+	test := func(selector, d string, expDomain string, expRecord *Record, expErr error) {
+		t.Helper()
+
+		domain, record, _, _, err := Lookup(context.Background(), log.Logger, resolver, selector, dns.Domain{ASCII: d})
+		if (err == nil) != (expErr == nil) || err != nil && !errors.Is(err, expErr) {
+			t.Fatalf("lookup: got err %v, expected %v", err, expErr)
+		}
+		if domain.ASCII != expDomain {
+			t.Fatalf("lookup: got domain %q, expected %q", domain.ASCII, expDomain)
+		}
+		if !reflect.DeepEqual(record, expRecord) {
+			t.Fatalf("lookup: got record %#v, expected %#v", record, expRecord)
+		}
+	}
+
+	test("", "simple.example", "simple.example", &Record{"BIMI1", "https://simple.example/logo.svg", ""}, nil)
+	test("default", "one.example", "one.example", &Record{"BIMI1", "https://one.example/logo.svg", ""}, nil)
+	test("brand", "one.example", "one.example", &Record{"BIMI1", "https://one.example/brand.svg", ""}, nil)
+	test("brand", "simple.example", "simple.example", nil, ErrNoRecord)
+	test("", "multiple.example", "multiple.example", nil, ErrMultipleRecords)
+	test("", "malformed.example", "malformed.example", nil, ErrSyntax)
+	test("", "temperror.example", "temperror.example", nil, ErrDNS)
+	test("", "absent.example", "absent.example", nil, ErrNoRecord)
+	// Fallback to organizational domain.
+	test("", "sub.example.com", "example.com", &Record{"BIMI1", "https://example.com/logo.svg", ""}, nil)
+}
@@ -0,0 +1,314 @@
+// Package canary periodically sends probe messages through the queue, as
+// configured in domains.conf, and checks they arrive within a deadline with
+// passing SPF, DKIM and DMARC. Results are exported as prometheus metrics, for
+// detecting problems with outgoing delivery that would otherwise go unnoticed.
+//
+// Probes arrive through the SMTP server, which calls Received for messages with
+// a canary token instead of delivering them.
+package canary
+
+import (
+	"bytes"
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/textproto"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/store"
+)
+
+var (
+	metricProbes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_canary_probes_total",
+			Help: "Canary probes, by result.",
+		},
+		[]string{
+			"canary",
+			"result", // ok, authfail, timeout, late, error
+		},
+	)
+	metricOK = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mox_canary_ok",
+			Help: "Whether the last canary probe arrived within the deadline with passing SPF, DKIM and DMARC (1), or not (0).",
+		},
+		[]string{
+			"canary",
+		},
+	)
+	metricLastOK = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mox_canary_last_ok_timestamp_seconds",
+			Help: "Time of the last canary probe that arrived within the deadline with passing SPF, DKIM and DMARC.",
+		},
+		[]string{
+			"canary",
+		},
+	)
+	metricDelay = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_canary_delay_seconds",
+			Help:    "Time between sending and receiving canary probes.",
+			Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 900, 1800, 3600},
+		},
+		[]string{
+			"canary",
+		},
+	)
+)
+
+// Header is the message header with the token of a probe.
+const Header = "X-Mox-Canary"
+
+// Probes are sent with this subject prefix, followed by the token. Replies from
+// echo services may only keep the subject.
+const subjectPrefix = "mox canary "
+
+// How long we recognize tokens of probes that didn't arrive in time.
+const lateWindow = 24 * time.Hour
+
+type probe struct {
+	canary   string
+	sent     time.Time
+	deadline time.Time
+	queueID  int64
+	timedOut bool
+}
+
+// Probes that were sent, by token.
+var pending = struct {
+	sync.Mutex
+	probes map[string]probe
+}{probes: map[string]probe{}}
+
+// Start starts a goroutine that sends probes for the configured canaries and
+// checks their deadlines.
+func Start() {
+	log := mlog.New("canary", nil)
+	go func() {
+		defer func() {
+			// On error, don't bring down the entire server.
+			x := recover()
+			if x != nil {
+				log.Error("canary panic", slog.Any("panic", x))
+				debug.PrintStack()
+				metrics.PanicInc(metrics.Canary)
+			}
+		}()
+
+		last := map[string]time.Time{} // Last probe sent, by canary name.
+		for {
+			expire(log)
+
+			canaries := mox.Conf.DynamicConfig().Canaries
+			for name := range last {
+				if _, ok := canaries[name]; !ok {
+					delete(last, name)
+					metricOK.DeleteLabelValues(name)
+					metricLastOK.DeleteLabelValues(name)
+				}
+			}
+			for name, c := range canaries {
+				interval := c.Interval
+				if interval == 0 {
+					interval = time.Hour
+				}
+				if time.Since(last[name]) < interval {
+					continue
+				}
+				last[name] = time.Now()
+				if _, err := Probe(mox.Shutdown, log, name, c); err != nil {
+					log.Errorx("sending canary probe", err, slog.String("canary", name))
+					metricProbes.WithLabelValues(name, "error").Inc()
+					metricOK.WithLabelValues(name).Set(0)
+				}
+			}
+
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-time.After(time.Minute):
+			}
+		}
+	}()
+}
+
+// expire removes probes that did not arrive in time from the queue, and forgets
+// about old probes.
+func expire(log mlog.Log) {
+	var timedOut []probe
+	pending.Lock()
+	for token, p := range pending.probes {
+		if time.Since(p.sent) >= lateWindow {
+			delete(pending.probes, token)
+		} else if !p.timedOut && time.Now().After(p.deadline) {
+			p.timedOut = true
+			pending.probes[token] = p
+			timedOut = append(timedOut, p)
+		}
+	}
+	pending.Unlock()
+
+	for _, p := range timedOut {
+		log.Error("canary probe did not arrive in time", slog.String("canary", p.canary), slog.Time("sent", p.sent))
+		metricProbes.WithLabelValues(p.canary, "timeout").Inc()
+		metricOK.WithLabelValues(p.canary).Set(0)
+		// Prevent further delivery attempts, and a DSN for a probe we already gave up on.
+		if p.queueID != 0 {
+			_, err := queue.Drop(context.Background(), log, queue.Filter{IDs: []int64{p.queueID}})
+			log.Check(err, "removing canary probe from queue")
+		}
+	}
+}
+
+// Probe composes a probe message for canary c, and adds it to the queue.
+func Probe(ctx context.Context, log mlog.Log, name string, c config.Canary) (token string, rerr error) {
+	buf := make([]byte, 16)
+	cryptorand.Read(buf)
+	token = base64.RawURLEncoding.EncodeToString(buf)
+
+	from := c.FromAddress
+	to := c.ToAddress
+	smtputf8 := from.Localpart.IsInternational() || to.Localpart.IsInternational()
+
+	var b bytes.Buffer
+	xc := message.NewComposer(&b, 100*1024, smtputf8)
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(error); ok && errors.Is(err, message.ErrCompose) {
+			rerr = err
+			return
+		}
+		panic(x)
+	}()
+
+	now := time.Now()
+	subject := subjectPrefix + token
+	xc.HeaderAddrs("From", []message.NameAddress{{Address: from}})
+	xc.HeaderAddrs("To", []message.NameAddress{{Address: to}})
+	xc.Subject(subject)
+	messageID := fmt.Sprintf("<%s>", mox.MessageIDGen(xc.SMTPUTF8))
+	xc.Header("Message-Id", messageID)
+	xc.Header("Date", now.Format(message.RFC5322Z))
+	xc.Header(Header, token)
+	xc.Header("Auto-Submitted", "auto-generated")
+	xc.Header("User-Agent", "mox/"+moxvar.Version)
+	xc.Header("MIME-Version", "1.0")
+	text := fmt.Sprintf("This is a probe message for canary %q, sent by mox to check outgoing\ndelivery. It can be ignored.\n", name)
+	textBody, ct, cte := xc.TextPart("plain", text)
+	xc.Header("Content-Type", ct)
+	xc.Header("Content-Transfer-Encoding", cte)
+	xc.Line()
+	xc.Write(textBody)
+	xc.Flush()
+
+	dkimHeader, err := mox.DKIMSign(ctx, log, from.Path(), smtputf8, b.Bytes())
+	log.Check(err, "dkim signing canary probe")
+
+	f, err := store.CreateMessageTemp(log, "canary")
+	if err != nil {
+		return "", fmt.Errorf("creating temp file: %w", err)
+	}
+	defer store.CloseRemoveTempFile(log, f, "canary probe")
+	if _, err := f.Write(b.Bytes()); err != nil {
+		return "", fmt.Errorf("writing probe: %w", err)
+	}
+
+	// Register the probe before queueing, it could arrive before queue.Add returns.
+	deadline := c.Deadline
+	if deadline == 0 {
+		deadline = 15 * time.Minute
+	}
+	pending.Lock()
+	pending.probes[token] = probe{name, now, now.Add(deadline), 0, false}
+	pending.Unlock()
+
+	size := int64(len(dkimHeader) + b.Len())
+	qm := queue.MakeMsg(from.Path(), to.Path(), xc.Has8bit, smtputf8, size, messageID, []byte(dkimHeader), nil, now, subject)
+	err = queue.Add(ctx, log, c.Account, f, qm)
+	pending.Lock()
+	if err != nil {
+		delete(pending.probes, token)
+	} else if p, ok := pending.probes[token]; ok {
+		p.queueID = qm.ID
+		pending.probes[token] = p
+	}
+	pending.Unlock()
+	if err != nil {
+		return "", fmt.Errorf("queueing probe: %w", err)
+	}
+
+	log.Debug("canary probe queued", slog.String("canary", name), slog.Any("to", to))
+	return token, nil
+}
+
+// Token returns the canary token of a message from its headers, or from its
+// subject for replies from echo services. Returns an empty string if there is
+// no token.
+func Token(h textproto.MIMEHeader, subject string) string {
+	if v := strings.TrimSpace(h.Get(Header)); v != "" {
+		return v
+	}
+	if i := strings.Index(subject, subjectPrefix); i >= 0 {
+		if t := strings.Fields(subject[i+len(subjectPrefix):]); len(t) > 0 {
+			return t[0]
+		}
+	}
+	return ""
+}
+
+// Received registers the arrival of a probe with token, and whether SPF, DKIM
+// and DMARC passed. If the token is not of a probe we sent, false is returned
+// and the message should be processed like any other message.
+func Received(log mlog.Log, token string, spfPass, dkimPass, dmarcPass bool) bool {
+	pending.Lock()
+	p, ok := pending.probes[token]
+	if ok {
+		delete(pending.probes, token)
+	}
+	pending.Unlock()
+	if !ok {
+		return false
+	}
+
+	delay := time.Since(p.sent)
+	log = log.With(slog.String("canary", p.canary), slog.Duration("delay", delay))
+	metricDelay.WithLabelValues(p.canary).Observe(delay.Seconds())
+	if p.timedOut {
+		log.Info("canary probe arrived after deadline")
+		metricProbes.WithLabelValues(p.canary, "late").Inc()
+		return true
+	}
+	if !spfPass || !dkimPass || !dmarcPass {
+		log.Error("canary probe arrived without passing spf, dkim and dmarc", slog.Bool("spf", spfPass), slog.Bool("dkim", dkimPass), slog.Bool("dmarc", dmarcPass))
+		metricProbes.WithLabelValues(p.canary, "authfail").Inc()
+		metricOK.WithLabelValues(p.canary).Set(0)
+		return true
+	}
+	log.Info("canary probe arrived")
+	metricProbes.WithLabelValues(p.canary, "ok").Inc()
+	metricOK.WithLabelValues(p.canary).Set(1)
+	metricLastOK.WithLabelValues(p.canary).SetToCurrentTime()
+	return true
+}
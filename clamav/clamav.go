@@ -0,0 +1,129 @@
+// Package clamav scans messages for viruses with clamd, the ClamAV daemon.
+//
+// Messages are streamed to clamd with the INSTREAM command, over a unix domain
+// socket or TCP connection.
+package clamav
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+var (
+	metricScan = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_clamav_scan_duration_seconds",
+			Help:    "Duration of message scans by clamd, with result.",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.100, 0.5, 1, 5, 10, 20, 30, 60},
+		},
+		[]string{
+			"result", // clean, virus, error
+		},
+	)
+	metricVirus = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mox_clamav_virus_total",
+			Help: "Number of scanned messages in which clamd found a virus.",
+		},
+	)
+)
+
+// ErrScan indicates clamd returned an error or unrecognized response, e.g. when
+// the message exceeds the configured maximum stream length.
+var ErrScan = errors.New("clamd scan error")
+
+// Scan sends the message from r to clamd at address on network ("unix" or
+// "tcp"), and returns the name of the virus found, or an empty string if the
+// message is clean. The timeout applies to connecting and the entire scan.
+func Scan(ctx context.Context, log mlog.Log, network, address string, timeout time.Duration, r io.Reader) (virus string, rerr error) {
+	start := time.Now()
+	defer func() {
+		result := "clean"
+		if rerr != nil {
+			result = "error"
+		} else if virus != "" {
+			result = "virus"
+			metricVirus.Inc()
+		}
+		metricScan.WithLabelValues(result).Observe(float64(time.Since(start)) / float64(time.Second))
+		log.Debugx("clamav scan result", rerr,
+			slog.String("result", result),
+			slog.String("virus", virus),
+			slog.Duration("duration", time.Since(start)))
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return "", fmt.Errorf("dial clamd: %v", err)
+	}
+	defer func() {
+		err := conn.Close()
+		log.Check(err, "closing clamd connection")
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return "", fmt.Errorf("set deadline: %v", err)
+		}
+	}
+
+	// The z-prefix makes clamd use nul-terminated commands and responses. Data is sent
+	// in chunks prefixed with their size, a zero size chunk ends the data.
+	bw := bufio.NewWriter(conn)
+	if _, err := bw.WriteString("zINSTREAM\x00"); err != nil {
+		return "", fmt.Errorf("write command: %v", err)
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			bw.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+			if _, err := bw.Write(buf[:n]); err != nil {
+				return "", fmt.Errorf("write data: %v", err)
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("read message: %v", err)
+		}
+	}
+	bw.Write([]byte{0, 0, 0, 0})
+	if err := bw.Flush(); err != nil {
+		return "", fmt.Errorf("write data: %v", err)
+	}
+
+	resp, err := io.ReadAll(io.LimitReader(conn, 1024))
+	if err != nil {
+		return "", fmt.Errorf("read response: %v", err)
+	}
+	return parseResponse(string(resp))
+}
+
+// parseResponse parses a response like "stream: OK" or "stream: Eicar-Signature
+// FOUND".
+func parseResponse(s string) (string, error) {
+	s = strings.TrimRight(s, "\x00\n")
+	s = strings.TrimPrefix(s, "stream: ")
+	if s == "OK" {
+		return "", nil
+	} else if virus, ok := strings.CutSuffix(s, " FOUND"); ok && virus != "" {
+		return virus, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrScan, s)
+}
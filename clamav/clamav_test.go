@@ -0,0 +1,110 @@
+package clamav
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+// fakeClamd reads an INSTREAM command and data, and responds with a virus if the
+// data contains "EICAR".
+func fakeClamd(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	cmd := make([]byte, len("zINSTREAM\x00"))
+	if _, err := io.ReadFull(conn, cmd); err != nil || string(cmd) != "zINSTREAM\x00" {
+		t.Errorf("reading command: %v, %q", err, cmd)
+		return
+	}
+	var data []byte
+	for {
+		var sizebuf [4]byte
+		if _, err := io.ReadFull(conn, sizebuf[:]); err != nil {
+			t.Errorf("reading chunk size: %v", err)
+			return
+		}
+		n := binary.BigEndian.Uint32(sizebuf[:])
+		if n == 0 {
+			break
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Errorf("reading chunk: %v", err)
+			return
+		}
+		data = append(data, buf...)
+	}
+	switch {
+	case bytes.Contains(data, []byte("EICAR")):
+		conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+	case bytes.Contains(data, []byte("LARGE")):
+		conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+	default:
+		conn.Write([]byte("stream: OK\x00"))
+	}
+}
+
+func TestScan(t *testing.T) {
+	log := mlog.New("clamav", nil)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go fakeClamd(t, conn)
+		}
+	}()
+
+	test := func(msg string, expVirus string, expErr error) {
+		t.Helper()
+		virus, err := Scan(context.Background(), log, "tcp", ln.Addr().String(), 5*time.Second, strings.NewReader(msg))
+		if expErr == nil && err != nil || expErr != nil && !errors.Is(err, expErr) {
+			t.Fatalf("got err %v, expected %v", err, expErr)
+		}
+		if virus != expVirus {
+			t.Fatalf("got virus %q, expected %q", virus, expVirus)
+		}
+	}
+
+	test("Subject: hi\r\n\r\nclean\r\n", "", nil)
+	test("Subject: hi\r\n\r\n"+strings.Repeat("x", 100*1024)+"EICAR\r\n", "Eicar-Signature", nil)
+	test("Subject: hi\r\n\r\nLARGE\r\n", "", ErrScan)
+
+	// Clamd not running.
+	ln.Close()
+	_, err = Scan(context.Background(), log, "tcp", ln.Addr().String(), 5*time.Second, strings.NewReader("test"))
+	if err == nil || errors.Is(err, ErrScan) {
+		t.Fatalf("got err %v, expected connection error", err)
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	test := func(s, expVirus string, expErr bool) {
+		t.Helper()
+		virus, err := parseResponse(s)
+		if (err != nil) != expErr || virus != expVirus {
+			t.Fatalf("parse %q: got %q, %v, expected %q, error %v", s, virus, err, expVirus, expErr)
+		}
+	}
+
+	test("stream: OK\x00", "", false)
+	test("stream: OK\n", "", false)
+	test("stream: Win.Test.EICAR_HDB-1 FOUND\x00", "Win.Test.EICAR_HDB-1", false)
+	test("stream:  FOUND\x00", "", true)
+	test("INSTREAM size limit exceeded. ERROR\x00", "", true)
+	test("", "", true)
+}
@@ -4,11 +4,15 @@ import (
 	"crypto"
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"net"
 	"net/http"
 	"net/url"
 	"reflect"
 	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/mjl-/mox/autotls"
@@ -51,10 +55,31 @@ type Static struct {
 		} `sconf:"optional"`
 		CertPool *x509.CertPool `sconf:"-" json:"-"`
 	} `sconf:"optional" sconf-doc:"Global TLS configuration, e.g. for additional Certificate Authorities. Used for outgoing SMTP connections, HTTPS requests."`
-	ACME              map[string]ACME     `sconf:"optional" sconf-doc:"Automatic TLS configuration with ACME, e.g. through Let's Encrypt. The key is a name referenced in TLS configs, e.g. letsencrypt."`
-	AdminPasswordFile string              `sconf:"optional" sconf-doc:"File containing hash of admin password, for authentication in the web admin pages (if enabled)."`
-	Listeners         map[string]Listener `sconf-doc:"Listeners are groups of IP addresses and services enabled on those IP addresses, such as SMTP/IMAP or internal endpoints for administration or Prometheus metrics. All listeners with SMTP/IMAP services enabled will serve all configured domains. If the listener is named 'public', it will get a few helpful additional configuration checks, for acme automatic tls certificates and monitoring of ips in dnsbls if those are configured."`
-	Postmaster        struct {
+	SMIME struct {
+		CA *struct {
+			AdditionalToSystem bool     `sconf:"optional"`
+			CertFiles          []string `sconf:"optional"`
+		} `sconf:"optional" sconf-doc:"Certificate Authorities for verifying signer certificates. If absent, the system CA certificates are used."`
+		CertPool *x509.CertPool `sconf:"-" json:"-"`
+	} `sconf:"optional" sconf-doc:"S/MIME signatures of incoming messages are verified at delivery. The result is added to the Authentication-Results header, stored with the message, and shown in the webmail. PGP/MIME signatures are verified at delivery with the keys in the OpenPGP keyring of the receiving account and the published OpenPGP keys of local accounts. Signed and encrypted messages get IMAP keywords $Signed and $Encrypted, and $SignatureValid or $SignatureInvalid for the verification result."`
+	OAuth              *OAuth              `sconf:"optional" sconf-doc:"External OAuth 2.0/OpenID Connect identity provider, for validating bearer tokens used with the OAUTHBEARER and XOAUTH2 authentication mechanisms for IMAP and SMTP submission. Tokens issued by mox itself, created on the account web page, are always accepted and don't need this configuration."`
+	ACME               map[string]ACME     `sconf:"optional" sconf-doc:"Automatic TLS configuration with ACME, e.g. through Let's Encrypt. The key is a name referenced in TLS configs, e.g. letsencrypt."`
+	AdminPasswordFile  string              `sconf:"optional" sconf-doc:"File containing hash of admin password, for authentication in the web admin pages (if enabled). Can also be an env: or vault: secret reference, see \"Secrets\" in the config documentation, in which case the password cannot be changed with \"mox setadminpassword\"."`
+	AdminTOTPFile      string              `sconf:"optional" sconf-doc:"File containing the secret for two-factor authentication with time-based one-time passwords (TOTP) and hashes of recovery codes, for the web admin pages. Managed with \"mox setadmintotp\". If the file exists, a TOTP code is required when logging in to the web admin pages."`
+	AdminRequireTOTP   bool                `sconf:"optional" sconf-doc:"If set, logging in to the web admin pages is only possible with two-factor authentication, i.e. the AdminTOTPFile must exist."`
+	AuthLockout        *AuthLockout        `sconf:"optional" sconf-doc:"Lock out IPs and accounts after many failed authentication attempts, for all protocols and web interfaces. Failed attempts and lockouts are stored in the database, and persist across restarts. This is in addition to the always-enabled rate limiting of failed authentication attempts per IP, which is only kept in memory. Lockouts can be listed and cleared with \"mox authlockout list\" and \"mox authlockout clear\" and in the admin web interface."`
+	AuthEvents         *AuthEvents         `sconf:"optional" sconf-doc:"Write authentication events in a stable, machine-parseable format to a file and/or unix domain socket, for external tools like fail2ban and CrowdSec that block IPs of attackers. Each event is a single line with space-separated key=value pairs, with values quoted if needed: time, event (authfail or authok), ip, protocol, mech, result, account, address, useragent. The ip field always comes before any client-provided data. See \"mox config example fail2ban\" for an example fail2ban configuration."`
+	MetricsPush        *MetricsPush        `sconf:"optional" sconf-doc:"Periodically push metrics to an external monitoring service, for installations where Prometheus cannot scrape the metrics endpoint, e.g. when behind NAT. Metrics are also still available on the MetricsHTTP endpoint of listeners, if enabled."`
+	BackupSchedule     *BackupSchedule     `sconf:"optional" sconf-doc:"Make backups from the running instance on a schedule, like \"mox backup\", with consistent snapshots of the databases. Each backup is made in a new directory. Old backups are removed according to the retention settings. Metrics with the time of the last successful backup are exported, for monitoring."`
+	ClamAV             *ClamAV             `sconf:"optional" sconf-doc:"Scan incoming messages for viruses with clamd, the ClamAV daemon. Messages with a virus are rejected or quarantined. Scanning can be enabled or disabled per domain with VirusScan in the domain configuration."`
+	Rspamd             *Rspamd             `sconf:"optional" sconf-doc:"Classify incoming messages as junk with rspamd, through its HTTP protocol, instead of or in addition to the builtin junk filter of accounts. Like the builtin junk filter, rspamd is only consulted for messages from senders without a conclusive reputation. Rspamd actions reject, soft reject, add header and rewrite subject cause the message to be treated as junk, i.e. rejected and stored in the Rejects mailbox. Actions no action and greylist cause the message to be accepted, mox does not do greylisting."`
+	MessageLimits      *MessageLimits      `sconf:"optional" sconf-doc:"Limits for parsing messages, protecting against excessive memory and CPU use for malicious messages, e.g. when analyzing incoming messages and for IMAP BODYSTRUCTURE. Incoming SMTP messages exceeding the limits are rejected with a 552 response. Other messages exceeding the limits, e.g. already stored or imported, are treated as a single non-multipart part. The defaults are well above what legitimate messages use."`
+	IMAPClientQuirks   []IMAPClientQuirk   `sconf:"optional" sconf-doc:"Compatibility workarounds for IMAP clients, selected by the client name and version sent with the IMAP ID command. The first matching entry is applied to the connection. Clients that do not send an ID command, or send it only after the workaround would matter, are not affected. Client names and versions are logged, and sessions are counted per client name in metric mox_imap_client_sessions_total."`
+	LoopDetection      *LoopDetection      `sconf:"optional" sconf-doc:"Detection of incoming messages that loop between mail servers, e.g. due to forwarding rules at two mail servers pointing at each other. Looping messages are rejected with a 554 5.4.6 response. When absent, loop detection is enabled with default limits."`
+	MessageCompression *MessageCompression `sconf:"optional" sconf-doc:"If set, files of newly delivered messages are stored compressed with zstd. Messages are compressed in independent blocks of 128KB, and only the blocks that are read are decompressed, one block at a time. Message sizes and quota are based on the uncompressed size. Existing messages can be compressed with \"mox compressmessages\"."`
+	MessageDedup       bool                `sconf:"optional" sconf-doc:"If set, message files with identical contents, e.g. for a message delivered to multiple local recipients or imported multiple times, are stored only once. Message files are hard links to files in the msgdedup directory in the data directory, named after the SHA-256 hash of their contents. Files no longer used by any message are removed daily. Requires a file system with hard links, and on Windows unused files are not removed."`
+	Listeners          map[string]Listener `sconf-doc:"Listeners are groups of IP addresses and services enabled on those IP addresses, such as SMTP/IMAP or internal endpoints for administration or Prometheus metrics. All listeners with SMTP/IMAP services enabled will serve all configured domains. If the listener is named 'public', it will get a few helpful additional configuration checks, for acme automatic tls certificates and monitoring of ips in dnsbls if those are configured."`
+	Postmaster         struct {
 		Account string
 		Mailbox string `sconf-doc:"E.g. Postmaster or Inbox."`
 	} `sconf-doc:"Destination for emails delivered to postmaster addresses: a plain 'postmaster' without domain, 'postmaster@<hostname>' (also for each listener with SMTP enabled), and as fallback for each domain without explicitly configured postmaster destination."`
@@ -86,6 +111,10 @@ type Static struct {
 	// To switch to after initialization as root.
 	UID uint32 `sconf:"-" json:"-"`
 	GID uint32 `sconf:"-" json:"-"`
+
+	// Bcrypt hash of admin password, if AdminPasswordFile is a secret reference
+	// instead of a file.
+	AdminPasswordHash string `sconf:"-" json:"-"`
 }
 
 // InitialMailboxes are mailboxes created for a new account.
@@ -109,39 +138,188 @@ type SpecialUseMailboxes struct {
 type Dynamic struct {
 	Domains            map[string]Domain  `sconf-doc:"NOTE: This config file is in 'sconf' format. Indent with tabs. Comments must be on their own line, they don't end a line. Do not escape or quote strings. Details: https://pkg.go.dev/github.com/mjl-/sconf.\n\n\nDomains for which email is accepted. For internationalized domains, use their IDNA names in UTF-8."`
 	Accounts           map[string]Account `sconf-doc:"Accounts represent mox users, each with a password and email address(es) to which email can be delivered (possibly at different domains). Each account has its own on-disk directory holding its messages and index database. An account name is not an email address."`
+	Tenants            map[string]Tenant  `sconf:"optional" sconf-doc:"Tenants group domains, and the accounts at those domains, for delegating administration, e.g. per customer of a hosting provider. Each tenant has its own admin password, set with \"mox tenant setadminpassword\", for logging in to the admin web interface with the tenant name as username, and only managing the domains and accounts of the tenant. Tenants can have their own sending limits and IPs for outgoing connections. Metrics about messages are kept per tenant. Keys are tenant names, consisting of lower-case letters, digits and dashes."`
 	WebDomainRedirects map[string]string  `sconf:"optional" sconf-doc:"Redirect all requests from domain (key) to domain (value). Always redirects to HTTPS. For plain HTTP redirects, use a WebHandler with a WebRedirect."`
 	WebHandlers        []WebHandler       `sconf:"optional" sconf-doc:"Handle webserver requests by serving static files, redirecting, reverse-proxying HTTP(s) or passing the request to an internal service. The first matching WebHandler will handle the request. Built-in system handlers, e.g. for ACME validation, autoconfig and mta-sts always run first. Built-in handlers for admin, account, webmail and webapi are evaluated after all handlers, including webhandlers (allowing for overrides of internal services for some domains). If no handler matches, the response status code is file not found (404). If webserver features are missing, forward the requests to an application that provides the needed functionality itself."`
 	Routes             []Route            `sconf:"optional" sconf-doc:"Routes for delivering outgoing messages through the queue. Each delivery attempt evaluates account routes, domain routes and finally these global routes. The transport of the first matching route is used in the delivery attempt. If no routes match, which is the default with no configured routes, messages are delivered directly from the queue."`
 	MonitorDNSBLs      []string           `sconf:"optional" sconf-doc:"DNS blocklists to periodically check with if IPs we send from are present, without using them for checking incoming deliveries.. Also see DNSBLs in SMTP listeners in mox.conf, which specifies DNSBLs to use both for incoming deliveries and for checking our IPs against. Example DNSBLs: sbl.spamhaus.org, bl.spamcop.net."`
+	Canaries           map[string]Canary  `sconf:"optional" sconf-doc:"Self-tests of outgoing delivery. For each canary, a probe message is periodically sent through the queue, typically from one of our domains to another. The probe must arrive within a deadline with passing SPF, DKIM and DMARC. Results are exported as prometheus metrics, e.g. mox_canary_ok, to detect delivery problems that would otherwise go unnoticed. Keys are names of canaries, used in metrics."`
 
 	WebDNSDomainRedirects map[dns.Domain]dns.Domain `sconf:"-" json:"-"`
 	MonitorDNSBLZones     []dns.Domain              `sconf:"-"`
 	ClientSettingDomains  map[dns.Domain]struct{}   `sconf:"-" json:"-"`
 }
 
+// Canary is a periodic self-test of outgoing delivery, see Dynamic.Canaries.
+type Canary struct {
+	From     string        `sconf-doc:"Address to send probes from. Must be an address of an account, at a domain with DKIM configured, so probes can pass DKIM and DMARC."`
+	To       string        `sconf-doc:"Address to send probes to. Typically an address of an account at another of our domains. Probes are delivered through the queue and SMTP like any outgoing message, but are not stored in the mailbox of the recipient. Can also be an external address that replies to the From address keeping the subject, such as an echo service; SPF, DKIM and DMARC are then checked for the reply."`
+	Interval time.Duration `sconf:"optional" sconf-doc:"How often to send a probe. Default 1h, minimum 5m."`
+	Deadline time.Duration `sconf:"optional" sconf-doc:"How long after sending a probe must arrive. Default 15m. Must be smaller than Interval. Probes not arrived in time are removed from the queue."`
+
+	FromAddress smtp.Address `sconf:"-" json:"-"`
+	ToAddress   smtp.Address `sconf:"-" json:"-"`
+	Account     string       `sconf:"-" json:"-"` // Of From address, used for the queue.
+}
+
 type ACME struct {
-	DirectoryURL           string                  `sconf-doc:"For letsencrypt, use https://acme-v02.api.letsencrypt.org/directory."`
+	DirectoryURL           string                  `sconf-doc:"For letsencrypt, use https://acme-v02.api.letsencrypt.org/directory. For ZeroSSL, which requires external account binding, use https://acme.zerossl.com/v2/DV90. For Buypass, use https://api.buypass.com/acme/directory."`
 	RenewBefore            time.Duration           `sconf:"optional" sconf-doc:"How long before expiration to renew the certificate. Default is 30 days."`
 	ContactEmail           string                  `sconf-doc:"Email address to register at ACME provider. The provider can email you when certificates are about to expire. If you configure an address for which email is delivered by this server, keep in mind that TLS misconfigurations could result in such notification emails not arriving."`
 	Port                   int                     `sconf:"optional" sconf-doc:"TLS port for ACME validation, 443 by default. You should only override this if you cannot listen on port 443 directly. ACME will make requests to port 443, so you'll have to add an external mechanism to get the tls connection here, e.g. by configuring firewall-level port forwarding. Validation over the https port uses tls-alpn-01 with application-layer protocol negotiation, which essentially means the original tls connection must make it here unmodified, an https reverse proxy will not work."`
-	IssuerDomainName       string                  `sconf:"optional" sconf-doc:"If set, used for suggested CAA DNS records, for restricting TLS certificate issuance to a Certificate Authority. If empty and DirectyURL is for Let's Encrypt, this value is set automatically to letsencrypt.org."`
+	IssuerDomainName       string                  `sconf:"optional" sconf-doc:"If set, used for suggested CAA DNS records, for restricting TLS certificate issuance to a Certificate Authority. If empty and DirectyURL is for Let's Encrypt, ZeroSSL or Buypass, this value is set automatically to letsencrypt.org, sectigo.com or buypass.com."`
+	CACertFile             string                  `sconf:"optional" sconf-doc:"File with PEM-encoded CA certificates to verify the TLS certificate of the ACME provider with, instead of the system CA certificates. For internal ACME providers, such as Pebble or step-ca, with a certificate from a private CA. File is evaluated relative to the directory of mox.conf."`
+	OrderLimit             int                     `sconf:"optional" sconf-doc:"If non-zero, maximum number of new certificate orders within OrderLimitPeriod. Requests for new certificates beyond the limit fail until enough time has passed. Useful to stay below rate limits of the ACME provider, e.g. Let's Encrypt allows 300 new orders per 3 hours per account. Regardless of this limit, new orders are paused when the ACME provider responds with a rate limit error, until the time the provider indicates."`
+	OrderLimitPeriod       time.Duration           `sconf:"optional" sconf-doc:"Period for OrderLimit. Default is 3 hours."`
 	ExternalAccountBinding *ExternalAccountBinding `sconf:"optional" sconf-doc:"ACME providers can require that a request for a new ACME account reference an existing non-ACME account known to the provider. External account binding references that account by a key id, and authorizes new ACME account requests by signing it with a key known both by the ACME client and ACME provider."`
 	// ../rfc/8555:2111
 
 	Manager *autotls.Manager `sconf:"-" json:"-"`
 }
 
+type AuthLockout struct {
+	IPFailures      int           `sconf:"optional" sconf-doc:"Number of failed authentication attempts from an IP address within IPWindow after which authentication attempts from the IP are refused for IPDuration. IPv6 addresses are grouped per /64 network. Zero disables IP lockouts."`
+	IPWindow        time.Duration `sconf:"optional" sconf-doc:"Period in which failed attempts from an IP are counted. Default 1 hour."`
+	IPDuration      time.Duration `sconf:"optional" sconf-doc:"How long an IP is locked out. Default 24 hours."`
+	AccountFailures int           `sconf:"optional" sconf-doc:"Number of failed authentication attempts for an account, from any IP, within AccountWindow after which all authentication attempts for the account fail for AccountDuration, even with valid credentials. Attempts for the admin web interface count for the account \"(admin)\", or \"(tenant <name>)\" for tenant admins. Zero disables account lockouts. Keep in mind that account lockouts also lock out the legitimate user, and can be triggered by anyone who knows an email address of the account."`
+	AccountWindow   time.Duration `sconf:"optional" sconf-doc:"Period in which failed attempts for an account are counted. Default 1 hour."`
+	AccountDuration time.Duration `sconf:"optional" sconf-doc:"How long an account is locked out. Default 1 hour."`
+}
+
+// AuthEvents configures writing authentication events for external tools.
+type AuthEvents struct {
+	File       string `sconf:"optional" sconf-doc:"File to append events to. Relative paths are relative to the data directory. The file is opened for each batch of events, so it can be rotated by moving it away. When an absolute path is used, e.g. in /var/log/, the file or directory must be writable by the mox user."`
+	UnixSocket string `sconf:"optional" sconf-doc:"Path of unix domain socket to listen on. Relative paths are relative to the data directory. Clients that connect receive events as lines as they happen. Events are dropped for clients that do not keep up."`
+	Successes  bool   `sconf:"optional" sconf-doc:"Also write successful authentications, with event authok. By default, only failed attempts are written, with event authfail."`
+}
+
+// MessageLimits configures limits for parsing messages. Zero values use the
+// default.
+type MessageLimits struct {
+	MaxHeaderFields int `sconf:"optional" sconf-doc:"Maximum number of header fields in the header of a message or MIME part. Default 1000."`
+	MaxHeaderSize   int `sconf:"optional" sconf-doc:"Maximum size in bytes of the header of a message or MIME part. Default 1MB."`
+	MaxDepth        int `sconf:"optional" sconf-doc:"Maximum nesting depth of multiparts and embedded messages. Default 50."`
+	MaxParts        int `sconf:"optional" sconf-doc:"Maximum number of MIME parts in a message, including parts of embedded messages. Default 10000."`
+	MaxDecodedSize  int `sconf:"optional" sconf-doc:"Maximum total size in bytes of decoded embedded messages (e.g. forwarded messages, or returned messages in DSNs) that are held in memory while parsing a message. Embedded messages with base64 or quoted-printable transfer encoding are decoded for parsing. Default 100MB."`
+}
+
+// LoopDetection configures detection of looping incoming messages. Zero values
+// use the default.
+type LoopDetection struct {
+	MaxHops    int `sconf:"optional" sconf-doc:"Maximum number of Received headers in an incoming message, each added by a mail server the message passed through. Default 100."`
+	MaxOwnHops int `sconf:"optional" sconf-doc:"Maximum number of earlier incoming deliveries of a message through this host, recognized by the IDs in Received headers added by mox. Received headers added during submission are not counted. Default 2."`
+}
+
+// MessageCompression configures compression of message files.
+type MessageCompression struct {
+	MinSize int64 `sconf:"optional" sconf-doc:"Only compress message files of at least this many bytes. Small messages hardly compress, and reading them would require decompression. Default 0, compressing all messages."`
+}
+
+// HELOChecks configures checks on the EHLO/HELO command of incoming SMTP
+// connections. Each check is disabled when empty, or is "log" or "enforce".
+type HELOChecks struct {
+	RequireFQDN       string `sconf:"optional" sconf-doc:"Check that the EHLO/HELO hostname is a fully qualified domain name, i.e. has a dot. IP address literals are allowed. Empty (disabled), log or enforce."`
+	RejectOwnHostname string `sconf:"optional" sconf-doc:"Check that the EHLO/HELO hostname is not the hostname of the listener or mail server, or an IP address literal with the IP the connection was made to. Spammers sometimes claim to be the receiving server. Empty (disabled), log or enforce."`
+	RequireFCrDNS     string `sconf:"optional" sconf-doc:"Check that the remote IP has forward-confirmed reverse DNS: a reverse DNS name that resolves back to the remote IP. Temporary DNS errors never fail the check. Empty (disabled), log or enforce."`
+}
+
+// XCLIENT configures upstream SMTP servers, e.g. a load balancer or filtering
+// appliance, that are trusted to pass details about the original client with the
+// XCLIENT and XFORWARD extensions.
+type XCLIENT struct {
+	TrustedIPs []string `sconf-doc:"IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64, of upstream servers that are allowed to use XCLIENT and XFORWARD. The extensions are only announced to connections from these IPs."`
+
+	TrustedNets []*net.IPNet `sconf:"-" json:"-"` // Parsed from TrustedIPs.
+}
+
+// Trusted returns whether ip is a trusted upstream server.
+func (x *XCLIENT) Trusted(ip net.IP) bool {
+	for _, n := range x.TrustedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClamAV is the configuration for scanning incoming messages for viruses with clamd.
+type ClamAV struct {
+	Address         string        `sconf-doc:"Address of clamd: \"unix:/path/to/clamd.sock\" for a unix domain socket, or \"inet:host:port\" for TCP."`
+	Timeout         time.Duration `sconf:"optional" sconf-doc:"Timeout for connecting to clamd and scanning a message. Default 1 minute."`
+	Action          string        `sconf:"optional" sconf-doc:"What to do with messages with a virus: \"reject\" (default) refuses the message during the SMTP transaction, \"quarantine\" delivers the message to the Junk mailbox, with an X-Mox-Virus header."`
+	MaxSize         int64         `sconf:"optional" sconf-doc:"Messages larger than this size in bytes are not scanned. Should not be larger than StreamMaxLength in clamd.conf, or scans fail. Default 25MB, the clamd default."`
+	FailOpen        bool          `sconf:"optional" sconf-doc:"If set, messages that could not be scanned, e.g. because clamd is not running, are accepted. By default, such messages are refused with a temporary SMTP error, so the remote server tries again later."`
+	DefaultDisabled bool          `sconf:"optional" sconf-doc:"If set, only messages for domains with VirusScan \"enabled\" are scanned. By default, messages for all domains are scanned, except domains with VirusScan \"disabled\"."`
+
+	Network     string `sconf:"-" json:"-"` // "unix" or "tcp", from Address.
+	NetworkAddr string `sconf:"-" json:"-"` // Path or host:port, from Address.
+}
+
+// Rspamd is the configuration for classifying incoming messages with rspamd.
+type Rspamd struct {
+	URL      string        `sconf-doc:"Base URL of the rspamd normal worker, e.g. http://localhost:11333. Messages are checked with a POST request to /checkv2."`
+	Password string        `sconf:"optional" sconf-doc:"If set, sent in the Password header, for the password configured in rspamd for the worker. Can be a secret reference like env:NAME, see \"Secrets\" in the config documentation."`
+	Timeout  time.Duration `sconf:"optional" sconf-doc:"Timeout for checking a message. Default 30s."`
+	Mode     string        `sconf:"optional" sconf-doc:"How rspamd is combined with the builtin junk filter of accounts: \"replace\" (default) uses only rspamd, \"combine\" uses both, treating a message as junk if either classifies it as junk."`
+	FailOpen bool          `sconf:"optional" sconf-doc:"If set, messages that could not be checked, e.g. because rspamd is not running, are analyzed as if rspamd was not configured, using the builtin junk filter if configured. By default, such messages are refused with a temporary SMTP error, so the remote server tries again later."`
+
+	EffectivePassword string `sconf:"-" json:"-"` // Password, with secret reference resolved.
+}
+
+// MetricsPush configures pushing metrics to Prometheus remote-write and/or StatsD.
+type MetricsPush struct {
+	Interval    time.Duration       `sconf:"optional" sconf-doc:"Interval between pushes. Default 1 minute."`
+	Labels      map[string]string   `sconf:"optional" sconf-doc:"Labels added to all metrics. Labels job with value mox and instance with the hostname are always added, but can be overridden here."`
+	RemoteWrite *MetricsRemoteWrite `sconf:"optional" sconf-doc:"Push with the Prometheus remote-write protocol, as supported by Prometheus, Grafana Cloud, Mimir, VictoriaMetrics and others."`
+	StatsD      *MetricsStatsD      `sconf:"optional" sconf-doc:"Send metrics to a StatsD server over UDP. Prometheus counters are sent as StatsD counters with the increase since the previous push, gauges as gauges. Histograms and summaries are sent as their count and sum."`
+}
+
+type BackupSchedule struct {
+	Schedule   string `sconf-doc:"When to make backups, in cron-like syntax with 5 space-separated fields: minute (0-59), hour (0-23), day of month (1-31), month (1-12) and day of week (0-6, 0 is Sunday). Each field is a \"*\", or a comma-separated list of numbers and ranges like \"1-5\", optionally with a step like \"0-59/15\" for every 15 minutes. In local time. E.g. \"30 3 * * *\" for every day at 03:30."`
+	Directory  string `sconf-doc:"Local directory in which a new directory is created for each backup, named after the start time of the backup, e.g. 20261017-033000. Relative paths are relative to the data directory. Within the data directory, only directories under \"tmp\" are allowed, e.g. \"tmp/backups\", where message files can be hardlinked instead of copied. Remote destinations are not supported."`
+	KeepDaily  int    `sconf:"optional" sconf-doc:"Number of days for which the most recent successful backup of the day is kept. Default 7."`
+	KeepWeekly int    `sconf:"optional" sconf-doc:"Number of weeks, starting on Monday, for which the most recent successful backup of the week is kept, in addition to the daily backups. Default 0."`
+	LinkDest   bool   `sconf:"optional" sconf-doc:"If set, message and database files that are unchanged since the most recent successful backup are hardlinked to the files in that backup instead of copied, like \"mox backup -linkdest\". Useful when Directory is on another file system than the data directory."`
+}
+
+type IMAPClientQuirk struct {
+	ClientName           string   `sconf-doc:"Case-insensitive substring of the client name from the IMAP ID command, e.g. \"Outlook\" or \"iPhone Mail\"."`
+	ClientVersion        string   `sconf:"optional" sconf-doc:"If set, only clients with a version starting with this value match, e.g. \"16.\"."`
+	CapabilitiesDisabled []string `sconf:"optional" sconf-doc:"IMAP capabilities (upper-case) to disable for matching clients, e.g. CONDSTORE, QRESYNC, UTF8=ACCEPT. Like IMAPCapabilitiesDisabled for accounts, the capabilities are no longer announced and cannot be enabled with the ENABLE command."`
+	NoUnsolicitedFlags   bool     `sconf:"optional" sconf-doc:"Do not send untagged FETCH responses with flag changes made by other sessions while a mailbox is selected. For clients that get confused by unsolicited FETCH responses. Flags are synchronized the next time the client fetches them."`
+}
+
+type MetricsRemoteWrite struct {
+	URL         string `sconf-doc:"URL of remote-write endpoint, e.g. https://prometheus.example.org/api/v1/write."`
+	Username    string `sconf:"optional" sconf-doc:"For HTTP basic authentication."`
+	Password    string `sconf:"optional" sconf-doc:"For HTTP basic authentication. Can be a secret reference like env:NAME, see \"Secrets\" in the config documentation."`
+	BearerToken string `sconf:"optional" sconf-doc:"For authentication with an Authorization header with bearer token, instead of HTTP basic authentication. Can be a secret reference like env:NAME, see \"Secrets\" in the config documentation."`
+
+	EffectivePassword    string `sconf:"-" json:"-"` // With secret references resolved.
+	EffectiveBearerToken string `sconf:"-" json:"-"`
+}
+
+type MetricsStatsD struct {
+	Address string `sconf-doc:"Host and UDP port of StatsD server, e.g. localhost:8125."`
+	Prefix  string `sconf:"optional" sconf-doc:"Prefix for metric names, e.g. mox. with a trailing dot."`
+	Tags    bool   `sconf:"optional" sconf-doc:"Send labels as DogStatsD-style tags, as understood by DogStatsD, Telegraf and others. By default, label values are appended to the metric name, separated by dots."`
+}
+
 type ExternalAccountBinding struct {
 	KeyID   string `sconf-doc:"Key identifier, from ACME provider."`
 	KeyFile string `sconf-doc:"File containing the base64url-encoded key used to sign account requests with external account binding. The ACME provider will verify the account request is correctly signed by the key. File is evaluated relative to the directory of mox.conf."`
 }
 
 type Listener struct {
-	IPs            []string   `sconf-doc:"Use 0.0.0.0 to listen on all IPv4 and/or :: to listen on all IPv6 addresses, but it is better to explicitly specify the IPs you want to use for email, as mox will make sure outgoing connections will only be made from one of those IPs. If both outgoing IPv4 and IPv6 connectivity is possible, and only one family has explicitly configured addresses, both address families are still used for outgoing connections. Use the \"direct\" transport to limit address families for outgoing connections."`
-	NATIPs         []string   `sconf:"optional" sconf-doc:"If set, the mail server is configured behind a NAT and field IPs are internal instead of the public IPs, while NATIPs lists the public IPs. Used during IP-related DNS self-checks, such as for iprev, mx, spf, autoconfig, autodiscover, and for autotls."`
-	IPsNATed       bool       `sconf:"optional" sconf-doc:"Deprecated, use NATIPs instead. If set, IPs are not the public IPs, but are NATed. Skips IP-related DNS self-checks."`
-	Hostname       string     `sconf:"optional" sconf-doc:"If empty, the config global Hostname is used. The internal services webadmin, webaccount, webmail and webapi only match requests to IPs, this hostname, \"localhost\". All except webadmin also match for any client settings domain."`
-	HostnameDomain dns.Domain `sconf:"-" json:"-"` // Set when parsing config.
+	IPs             []string   `sconf-doc:"Use 0.0.0.0 to listen on all IPv4 and/or :: to listen on all IPv6 addresses, but it is better to explicitly specify the IPs you want to use for email, as mox will make sure outgoing connections will only be made from one of those IPs. If both outgoing IPv4 and IPv6 connectivity is possible, and only one family has explicitly configured addresses, both address families are still used for outgoing connections. Use the \"direct\" transport to limit address families for outgoing connections."`
+	UnixSocketDir   string     `sconf:"optional" sconf-doc:"If set, each enabled service of this listener also listens on a unix domain socket in this directory, e.g. for a proxy in front of mox on the same machine. Sockets are named after the protocol and port, e.g. smtp-25.sock, submission-587.sock, imaps-993.sock, http-80.sock and https-443.sock. The directory is created if needed, relative paths are relative to the data directory. Sockets are owned by the mox user and group, with mode 0660. Connections on unix domain sockets are treated as coming from 127.0.0.1, including for access control, rate limiting and reputation of incoming messages."`
+	NATIPs          []string   `sconf:"optional" sconf-doc:"If set, the mail server is configured behind a NAT and field IPs are internal instead of the public IPs, while NATIPs lists the public IPs. Used during IP-related DNS self-checks, such as for iprev, mx, spf, autoconfig, autodiscover, and for autotls."`
+	IPsNATed        bool       `sconf:"optional" sconf-doc:"Deprecated, use NATIPs instead. If set, IPs are not the public IPs, but are NATed. Skips IP-related DNS self-checks."`
+	Hostname        string     `sconf:"optional" sconf-doc:"If empty, the config global Hostname is used. The internal services webadmin, webaccount, webmail and webapi only match requests to IPs, this hostname, \"localhost\". All except webadmin also match for any client settings domain."`
+	HostnameDomain  dns.Domain `sconf:"-" json:"-"` // Set when parsing config.
+	IPAccess        *IPAccess  `sconf:"optional" sconf-doc:"Restrict which remote IPs can connect to any of the services of this listener. Connections from other IPs are closed immediately after accepting, e.g. before the SMTP banner is sent. For HTTP services behind a reverse proxy, this applies to the IP of the proxy. See IPAccess of individual services for restricting a single service."`
+	NoPlaintextAuth bool       `sconf:"optional" sconf-doc:"Refuse authentication mechanisms that send the password, or a weakly protected derivation of it, over the connection: PLAIN, LOGIN and CRAM-MD5, including the IMAP LOGIN and POP3 USER/PASS commands. Applies to SMTP submission, IMAP, POP3 and ManageSieve of this listener. Only SCRAM, OAUTHBEARER/XOAUTH2 and EXTERNAL (TLS client certificates) are announced and accepted. Login to the web interfaces is not affected. If set for all listeners with services that accept password authentication, CRAM-MD5 secrets are no longer stored for accounts, and existing CRAM-MD5 secrets are removed from account databases."`
+	Greeting        *Greeting  `sconf:"optional" sconf-doc:"Customize the greetings sent to clients of SMTP, IMAP, POP3 and ManageSieve on this listener. E.g. when compliance scans flag disclosure of the software name or version, or when a specific host name must be announced in the SMTP greeting."`
 
 	TLS                *TLS  `sconf:"optional" sconf-doc:"For SMTP/IMAP STARTTLS, direct TLS and HTTPS connections."`
 	SMTPMaxMessageSize int64 `sconf:"optional" sconf-doc:"Maximum size in bytes for incoming and outgoing messages. Default is 100MB."`
@@ -154,34 +332,76 @@ type Listener struct {
 		// Reoriginated messages (such as messages sent to mailing list subscribers) should
 		// keep REQUIRETLS. ../rfc/8689:412
 
-		DNSBLs []string `sconf:"optional" sconf-doc:"Addresses of DNS block lists for incoming messages. Block lists are only consulted for connections/messages without enough reputation to make an accept/reject decision. This prevents sending IPs of all communications to the block list provider. If any of the listed DNSBLs contains a requested IP address, the message is rejected as spam. The DNSBLs are checked for healthiness before use, at most once per 4 hours. IPs we can send from are periodically checked for being in the configured DNSBLs. See MonitorDNSBLs in domains.conf to only monitor IPs we send from, without using those DNSBLs for incoming messages. Example DNSBLs: sbl.spamhaus.org, bl.spamcop.net. See https://www.spamhaus.org/sbl/ and https://www.spamcop.net/ for more information and terms of use."`
+		DNSBLs []string `sconf:"optional" sconf-doc:"Addresses of DNS block lists for incoming messages. Block lists are only consulted for connections/messages without enough reputation to make an accept/reject decision. This prevents sending IPs of all communications to the block list provider. If any of the listed DNSBLs contains a requested IP address, the message is rejected as spam, unless DNSBLThreshold is set. The DNSBLs are checked for healthiness before use, at most once per 4 hours. IPs we can send from are periodically checked for being in the configured DNSBLs. See MonitorDNSBLs in domains.conf to only monitor IPs we send from, without using those DNSBLs for incoming messages. Example DNSBLs: sbl.spamhaus.org, bl.spamcop.net. See https://www.spamhaus.org/sbl/ and https://www.spamcop.net/ for more information and terms of use."`
+
+		DNSBLWeights   map[string]float64 `sconf:"optional" sconf-doc:"Weights for DNSBLs, keyed by zone as listed in DNSBLs, for use with DNSBLThreshold. DNSBLs without weight have weight 1. A weight of 0 can be used to evaluate a DNSBL, with its hits visible in metrics, without it affecting deliveries."`
+		DNSBLThreshold float64            `sconf:"optional" sconf-doc:"If set, all DNSBLs are consulted and a message is only rejected if the sum of the weights of the DNSBLs listing the remote IP is at least this threshold. E.g. with threshold 2 and the default weight 1, an IP must be listed in at least two DNSBLs. Default 0, rejecting on the first DNSBL listing the IP."`
+
+		URIBLs []string `sconf:"optional" sconf-doc:"Zones of domain-based block lists for domains in URLs in incoming messages, e.g. dbl.spamhaus.org or multi.surbl.org. The hosts of http and https URLs in text and html parts of a message are reduced to their organizational domain, and up to 10 domains are looked up as domain prefixed to the zone. If any domain is listed, the message is rejected as spam. Like DNSBLs, URI block lists are only consulted for messages without enough reputation to make an accept/reject decision, and when the content is not already classified as junk. Many block lists require queries through your own resolver, not through public resolvers. See the terms of use of the block lists."`
 
 		FirstTimeSenderDelay *time.Duration `sconf:"optional" sconf-doc:"Delay before accepting a message from a first-time sender for the destination account. Default: 15s."`
 
 		TLSSessionTicketsDisabled *bool `sconf:"optional" sconf-doc:"Override default setting for enabling TLS session tickets. Disabling session tickets may work around TLS interoperability issues."`
 
-		DNSBLZones []dns.Domain `sconf:"-"`
+		MaxRecipients               int     `sconf:"optional" sconf-doc:"Maximum number of recipients (RCPT TO commands) for a single message transaction. Additional recipients are rejected with a temporary error, the remote server will deliver to them in a next transaction. Announced with the LIMITS extension. RFC 5321 requires at least 100. Default 1000."`
+		MaxRecipientsPerConnection  int     `sconf:"optional" sconf-doc:"Maximum number of recipients (RCPT TO commands) over all message transactions in a single connection. Additional recipients are rejected with a temporary error, the remote server has to reconnect. Default 0, no limit."`
+		MaxUnknownRecipientsPerHour []int64 `sconf:"optional" sconf-doc:"Maximum number of unknown recipients per hour from a remote IP address, its /26 network and its /21 network (for IPv6: /64, /48 and /32). Many unknown recipients indicate a dictionary attack trying to find valid addresses. When a limit is reached, further connections from the IP or network are refused until the hour has passed. If set, exactly three values must be specified. Default 50, 150, 450."`
+
+		HELOChecks *HELOChecks `sconf:"optional" sconf-doc:"Classic checks at EHLO/HELO time for incoming connections: on the hostname in the EHLO/HELO command, and on the reverse DNS of the remote IP. Each check can be set to log, to only log failures and count them in metrics, or enforce, to reject the EHLO/HELO command. Use log first to measure the effect before enforcing."`
+
+		XCLIENT *XCLIENT `sconf:"optional" sconf-doc:"Accept the XCLIENT and XFORWARD extensions (as implemented by Postfix) from trusted upstream servers in front of mox, e.g. a load balancer or filtering appliance. The IP address and EHLO/HELO hostname of the original client they pass on are used instead of those of the upstream server for SPF, iprev, DNSBL and EHLO/HELO checks, reputation, rate limiting, the Received header and logging. XCLIENT applies to the remainder of the connection, and resets the session: the upstream server must send EHLO/HELO again, for which a HELO from XCLIENT takes precedence. XFORWARD applies to the next message transaction only. Only the ADDR and HELO attributes are used, others like NAME and PORT are accepted and ignored."`
+
+		IPAccess *IPAccess `sconf:"optional" sconf-doc:"Restrict which remote IPs can connect for SMTP, in addition to IPAccess of the listener. E.g. to drop connections from known-abusive networks before the SMTP banner is sent."`
+
+		PolicyHook *PolicyHook `sconf:"optional" sconf-doc:"Policy, as embedded WebAssembly module or external service, to consult during incoming SMTP transactions, for local policies the built-in checks don't cover. The hook can reject, temporarily fail, quarantine or add headers to messages."`
+
+		Milters []Milter `sconf:"optional" sconf-doc:"External mail filters speaking the milter protocol, e.g. rspamd, clamav-milter or a custom filter, to inspect and modify incoming messages during the SMTP transaction. Milters are called in order at connect, EHLO/HELO, MAIL FROM, RCPT TO and after the message data, before mox's own analysis. They can reject, temporarily fail, discard or quarantine messages, add, change and delete message header fields, and replace the message body. Changes to the envelope sender and recipients are not supported."`
+
+		DNSBLZones       []dns.Domain           `sconf:"-"`
+		DNSBLZoneWeights map[dns.Domain]float64 `sconf:"-"`
+		URIBLZones       []dns.Domain           `sconf:"-"`
 	} `sconf:"optional"`
 	Submission struct {
 		Enabled           bool
-		Port              int  `sconf:"optional" sconf-doc:"Default 587."`
-		NoRequireSTARTTLS bool `sconf:"optional" sconf-doc:"Do not require STARTTLS. Since users must login, this means password may be sent without encryption. Not recommended."`
+		Port              int       `sconf:"optional" sconf-doc:"Default 587."`
+		NoRequireSTARTTLS bool      `sconf:"optional" sconf-doc:"Do not require STARTTLS. Since users must login, this means password may be sent without encryption. Not recommended."`
+		IPAccess          *IPAccess `sconf:"optional" sconf-doc:"Restrict which remote IPs can connect, in addition to IPAccess of the listener."`
 	} `sconf:"optional" sconf-doc:"SMTP for submitting email, e.g. by email applications. Starts out in plain text, can be upgraded to TLS with the STARTTLS command. Prefer using Submissions which is always a TLS connection."`
 	Submissions struct {
 		Enabled        bool
-		Port           int  `sconf:"optional" sconf-doc:"Default 465."`
-		EnabledOnHTTPS bool `sconf:"optional" sconf-doc:"Additionally enable submission on HTTPS port 443 via TLS ALPN. TLS Application Layer Protocol Negotiation allows clients to request a specific protocol from the server as part of the TLS connection setup. When this setting is enabled and a client requests the 'smtp' protocol after TLS, it will be able to talk SMTP to Mox on port 443. This is meant to be useful as a censorship circumvention technique for Delta Chat."`
+		Port           int       `sconf:"optional" sconf-doc:"Default 465."`
+		EnabledOnHTTPS bool      `sconf:"optional" sconf-doc:"Additionally enable submission on HTTPS port 443 via TLS ALPN. TLS Application Layer Protocol Negotiation allows clients to request a specific protocol from the server as part of the TLS connection setup. When this setting is enabled and a client requests the 'smtp' protocol after TLS, it will be able to talk SMTP to Mox on port 443. This is meant to be useful as a censorship circumvention technique for Delta Chat."`
+		IPAccess       *IPAccess `sconf:"optional" sconf-doc:"Restrict which remote IPs can connect, in addition to IPAccess of the listener. Also applies to submission on HTTPS."`
 	} `sconf:"optional" sconf-doc:"SMTP over TLS for submitting email, by email applications. Requires a TLS config."`
 	IMAP struct {
 		Enabled           bool
-		Port              int  `sconf:"optional" sconf-doc:"Default 143."`
-		NoRequireSTARTTLS bool `sconf:"optional" sconf-doc:"Enable this only when the connection is otherwise encrypted (e.g. through a VPN)."`
+		Port              int       `sconf:"optional" sconf-doc:"Default 143."`
+		NoRequireSTARTTLS bool      `sconf:"optional" sconf-doc:"Enable this only when the connection is otherwise encrypted (e.g. through a VPN)."`
+		IPAccess          *IPAccess `sconf:"optional" sconf-doc:"Restrict which remote IPs can connect, in addition to IPAccess of the listener."`
 	} `sconf:"optional" sconf-doc:"IMAP for reading email, by email applications. Starts out in plain text, can be upgraded to TLS with the STARTTLS command. Prefer using IMAPS instead which is always a TLS connection."`
 	IMAPS struct {
 		Enabled        bool
-		Port           int  `sconf:"optional" sconf-doc:"Default 993."`
-		EnabledOnHTTPS bool `sconf:"optional" sconf-doc:"Additionally enable IMAP on HTTPS port 443 via TLS ALPN. TLS Application Layer Protocol Negotiation allows clients to request a specific protocol from the server as part of the TLS connection setup. When this setting is enabled and a client requests the 'imap' protocol after TLS, it will be able to talk IMAP to Mox on port 443. This is meant to be useful as a censorship circumvention technique for Delta Chat."`
+		Port           int       `sconf:"optional" sconf-doc:"Default 993."`
+		EnabledOnHTTPS bool      `sconf:"optional" sconf-doc:"Additionally enable IMAP on HTTPS port 443 via TLS ALPN. TLS Application Layer Protocol Negotiation allows clients to request a specific protocol from the server as part of the TLS connection setup. When this setting is enabled and a client requests the 'imap' protocol after TLS, it will be able to talk IMAP to Mox on port 443. This is meant to be useful as a censorship circumvention technique for Delta Chat."`
+		IPAccess       *IPAccess `sconf:"optional" sconf-doc:"Restrict which remote IPs can connect, in addition to IPAccess of the listener. Also applies to IMAP on HTTPS."`
 	} `sconf:"optional" sconf-doc:"IMAP over TLS for reading email, by email applications. Requires a TLS config."`
+	POP3 struct {
+		Enabled           bool
+		Port              int       `sconf:"optional" sconf-doc:"Default 110."`
+		NoRequireSTARTTLS bool      `sconf:"optional" sconf-doc:"Enable this only when the connection is otherwise encrypted (e.g. through a VPN)."`
+		IPAccess          *IPAccess `sconf:"optional" sconf-doc:"Restrict which remote IPs can connect, in addition to IPAccess of the listener."`
+	} `sconf:"optional" sconf-doc:"POP3 for retrieving email from the Inbox, for legacy devices and simple scripts. Starts out in plain text, can be upgraded to TLS with the STLS command. Prefer using POP3S instead which is always a TLS connection, or IMAP."`
+	POP3S struct {
+		Enabled  bool
+		Port     int       `sconf:"optional" sconf-doc:"Default 995."`
+		IPAccess *IPAccess `sconf:"optional" sconf-doc:"Restrict which remote IPs can connect, in addition to IPAccess of the listener."`
+	} `sconf:"optional" sconf-doc:"POP3 over TLS for retrieving email from the Inbox. Requires a TLS config."`
+	ManageSieve struct {
+		Enabled           bool
+		Port              int       `sconf:"optional" sconf-doc:"Default 4190."`
+		NoRequireSTARTTLS bool      `sconf:"optional" sconf-doc:"Enable this only when the connection is otherwise encrypted (e.g. through a VPN)."`
+		IPAccess          *IPAccess `sconf:"optional" sconf-doc:"Restrict which remote IPs can connect, in addition to IPAccess of the listener."`
+	} `sconf:"optional" sconf-doc:"ManageSieve for uploading and activating Sieve filtering scripts, by email applications. Starts out in plain text, can be upgraded to TLS with the STARTTLS command. Scripts are validated when stored. The active script of an account is evaluated for incoming messages delivered over SMTP that don't match a ruleset of the destination, and can file messages into mailboxes, set flags, redirect, reject, discard and send vacation replies."`
 	AccountHTTP  WebService `sconf:"optional" sconf-doc:"Account web interface, for email users wanting to change their accounts, e.g. set new password, set new delivery rulesets. Default path is /."`
 	AccountHTTPS WebService `sconf:"optional" sconf-doc:"Account web interface listener like AccountHTTP, but for HTTPS. Requires a TLS config."`
 	AdminHTTP    WebService `sconf:"optional" sconf-doc:"Admin web interface, for managing domains, accounts, etc. Default path is /admin/. Preferably only enable on non-public IPs. Hint: use 'ssh -L 8080:localhost:80 you@yourmachine' and open http://localhost:8080/admin/, or set up a tunnel (e.g. WireGuard) and add its IP to the mox 'internal' listener."`
@@ -210,6 +430,12 @@ type Listener struct {
 		NonTLS    bool `sconf:"optional" sconf-doc:"If set, plain HTTP instead of HTTPS is spoken on the configured port. Can be useful when the mta-sts domain is reverse proxied."`
 		Forwarded bool `sconf:"optional" sconf-doc:"If set, X-Forwarded-* headers are used for the remote IP address for rate limiting and logging."`
 	} `sconf:"optional" sconf-doc:"Serve MTA-STS policies describing SMTP TLS requirements. Requires a TLS config."`
+	WKDHTTPS struct {
+		Enabled   bool
+		Port      int  `sconf:"optional" sconf-doc:"TLS port, 443 by default. You should only override this if you cannot listen on port 443 directly. WKD requests will be made to port 443, so you'll have to add an external mechanism to get the connection here, e.g. by configuring port forwarding."`
+		NonTLS    bool `sconf:"optional" sconf-doc:"If set, plain HTTP instead of HTTPS is spoken on the configured port. Can be useful when the openpgpkey domain is reverse proxied."`
+		Forwarded bool `sconf:"optional" sconf-doc:"If set, X-Forwarded-* headers are used for the remote IP address for rate limiting and logging."`
+	} `sconf:"optional" sconf-doc:"Serve OpenPGP public keys uploaded by accounts through the Web Key Directory (WKD), at https://openpgpkey.<domain>/.well-known/openpgpkey/, so email applications can find keys of correspondents. Requires a TLS config."`
 	WebserverHTTP struct {
 		Enabled           bool
 		Port              int  `sconf:"optional" sconf-doc:"Port for plain HTTP (non-TLS) webserver."`
@@ -225,9 +451,78 @@ type Listener struct {
 // WebService is an internal web interface: webmail, webaccount, webadmin, webapi.
 type WebService struct {
 	Enabled   bool
-	Port      int    `sconf:"optional" sconf-doc:"Default 80 for HTTP and 443 for HTTPS. See Hostname at Listener for hostname matching behaviour."`
-	Path      string `sconf:"optional" sconf-doc:"Path to serve requests on. Should end with a slash, related to cookie paths."`
-	Forwarded bool   `sconf:"optional" sconf-doc:"If set, X-Forwarded-* headers are used for the remote IP address for rate limiting and for the \"secure\" status of cookies."`
+	Port      int       `sconf:"optional" sconf-doc:"Default 80 for HTTP and 443 for HTTPS. See Hostname at Listener for hostname matching behaviour."`
+	Path      string    `sconf:"optional" sconf-doc:"Path to serve requests on. Should end with a slash, related to cookie paths."`
+	Forwarded bool      `sconf:"optional" sconf-doc:"If set, X-Forwarded-* headers are used for the remote IP address for rate limiting and for the \"secure\" status of cookies."`
+	IPAccess  *IPAccess `sconf:"optional" sconf-doc:"Restrict which remote IPs can use this web interface, in addition to IPAccess of the listener. Requests from other IPs get a \"403 - forbidden\" response. If Forwarded is set, the IP from the X-Forwarded-For header is checked. E.g. to only allow access to the admin web interface from a VPN."`
+}
+
+// PolicyHook is an embedded WebAssembly module or an HTTP endpoint that is
+// consulted at stages of an incoming SMTP transaction. See package policyhook for
+// the request and response format.
+type PolicyHook struct {
+	WASM          string        `sconf:"optional" sconf-doc:"Path to a WebAssembly module with the policy, executed by mox itself. Relative paths are relative to the config directory. At each of the Stages, the module is called with a JSON request describing the connection, transaction and for stage data the authentication results and message headers. The JSON response holds the decision: accept, reject, tempfail or quarantine, and optionally message headers to add. The module runs in a sandbox, without access to files, network or environment. See package policyhook for the format and the functions the module must export. Exactly one of WASM and URL must be set."`
+	URL           string        `sconf:"optional" sconf-doc:"URL of an external policy service. Instead of calling a WebAssembly module, an HTTP POST request is made with the JSON request as body, and the service responds with the JSON response."`
+	Authorization string        `sconf:"optional" sconf-doc:"For URL, if set, value for the Authorization header in requests, e.g. \"Bearer ...\". Can be a secret reference like env:NAME, see \"Secrets\" in the config documentation."`
+	Stages        []string      `sconf:"optional" sconf-doc:"Stages at which the hook is called: connect, mail, rcpt, data. Default all stages."`
+	Timeout       time.Duration `sconf:"optional" sconf-doc:"Timeout for a call of the WebAssembly module or request to the policy service. Default 10s."`
+	FailOpen      bool          `sconf:"optional" sconf-doc:"If set, failures to get a valid response from the policy hook are ignored, continuing as if the hook accepted. By default, such failures result in a temporary SMTP error, so the remote server tries again later."`
+
+	WASMPath               string `sconf:"-" json:"-"` // WASM, resolved to absolute path.
+	EffectiveAuthorization string `sconf:"-" json:"-"` // Authorization, with secret reference resolved.
+}
+
+// Milter is an external mail filter, reached over a unix domain socket or TCP.
+// See package milter.
+type Milter struct {
+	Address  string        `sconf-doc:"Address of the milter: \"unix:/path/to/socket\" for a unix domain socket, or \"inet:host:port\" for TCP."`
+	Timeout  time.Duration `sconf:"optional" sconf-doc:"Timeout for connecting to the milter, and for each of its responses. Default 30s."`
+	FailOpen bool          `sconf:"optional" sconf-doc:"If set, failures to connect or talk to the milter are ignored, continuing the connection without the milter. By default, failures result in a temporary SMTP error, so the remote server tries again later."`
+
+	Network     string `sconf:"-" json:"-"` // "unix" or "tcp", from Address.
+	NetworkAddr string `sconf:"-" json:"-"` // Path or host:port, from Address.
+}
+
+// Greeting customizes the greetings of the services of a listener.
+type Greeting struct {
+	Hostname     string `sconf:"optional" sconf-doc:"Host name in the SMTP greeting, instead of the hostname of the listener. Host names in EHLO responses and Received headers are not changed."`
+	SMTP         string `sconf:"optional" sconf-doc:"Text in the SMTP greeting after the host name and ESMTP. Default \"mox\", or empty with HideSoftware."`
+	IMAP         string `sconf:"optional" sconf-doc:"Text in the IMAP greeting after the capabilities. Default \"mox imap\", or \"imap ready\" with HideSoftware."`
+	POP3         string `sconf:"optional" sconf-doc:"Text in the POP3 greeting. Default \"mox pop3\", or \"pop3 ready\" with HideSoftware."`
+	ManageSieve  string `sconf:"optional" sconf-doc:"Text in the ManageSieve greeting after the capabilities. Default \"mox managesieve ready\", or \"managesieve ready\" with HideSoftware."`
+	HideSoftware bool   `sconf:"optional" sconf-doc:"Do not reveal the software name and version. Default greetings become generic, IMAP ID commands get an empty response instead of the name and, for authenticated clients, the version, and the IMPLEMENTATION capability of POP3 is left out and of ManageSieve is generic."`
+
+	HostnameDomain dns.Domain `sconf:"-" json:"-"`
+}
+
+// IPAccess restricts which remote IPs can connect to a listener or service.
+type IPAccess struct {
+	Allow []string `sconf:"optional" sconf-doc:"IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64, that are allowed to connect. If empty, all IPs not matching Deny are allowed."`
+	Deny  []string `sconf:"optional" sconf-doc:"IP addresses or networks in CIDR notation that are refused. Takes precedence over Allow."`
+
+	AllowNets []*net.IPNet `sconf:"-" json:"-"` // Parsed from Allow.
+	DenyNets  []*net.IPNet `sconf:"-" json:"-"` // Parsed from Deny.
+}
+
+// Allowed returns whether ip is allowed. A nil IPAccess allows all IPs.
+func (a *IPAccess) Allowed(ip net.IP) bool {
+	if a == nil {
+		return true
+	}
+	for _, n := range a.DenyNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.AllowNets) == 0 {
+		return true
+	}
+	for _, n := range a.AllowNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
 
 // Transport is a method to delivery a message. At most one of the fields can
@@ -246,29 +541,56 @@ type Transport struct {
 // authenticated) to the queue of a remote host (smarthost), or by relaying
 // (SMTP, typically unauthenticated).
 type TransportSMTP struct {
-	Host                       string    `sconf-doc:"Host name to connect to and for verifying its TLS certificate."`
-	Port                       int       `sconf:"optional" sconf-doc:"If unset or 0, the default port for submission(s)/smtp is used: 25 for SMTP, 465 for submissions (with TLS), 587 for submission (possibly with STARTTLS)."`
-	STARTTLSInsecureSkipVerify bool      `sconf:"optional" sconf-doc:"If set an unverifiable remote TLS certificate during STARTTLS is accepted."`
-	NoSTARTTLS                 bool      `sconf:"optional" sconf-doc:"If set for submission or smtp transport, do not attempt STARTTLS on the connection. Authentication credentials and messages will be transferred in clear text."`
-	Auth                       *SMTPAuth `sconf:"optional" sconf-doc:"If set, authentication credentials for the remote server."`
+	Host                       string              `sconf-doc:"Host name to connect to and for verifying its TLS certificate."`
+	Port                       int                 `sconf:"optional" sconf-doc:"If unset or 0, the default port for submission(s)/smtp is used: 25 for SMTP, 465 for submissions (with TLS), 587 for submission (possibly with STARTTLS)."`
+	STARTTLSInsecureSkipVerify bool                `sconf:"optional" sconf-doc:"If set an unverifiable remote TLS certificate during STARTTLS is accepted."`
+	NoSTARTTLS                 bool                `sconf:"optional" sconf-doc:"If set for submission or smtp transport, do not attempt STARTTLS on the connection. Authentication credentials and messages will be transferred in clear text."`
+	Auth                       *SMTPAuth           `sconf:"optional" sconf-doc:"If set, authentication credentials for the remote server."`
+	Weight                     int                 `sconf:"optional" sconf-doc:"Weight of Host when choosing among hosts with the same priority, see Hosts. Hosts with a higher weight are chosen more often. Default 1 if 0."`
+	Hosts                      []TransportSMTPHost `sconf:"optional" sconf-doc:"Additional hosts, for failover and load balancing. Hosts are tried in order of priority, lowest first, with Host having priority 0. Among hosts with the same priority, a host is chosen randomly, taking weights into account. If a connection to a host cannot be made, the next host is tried. The health of each host is tracked: a host that consistently fails is skipped for a while, and probed again later. All hosts must accept the same authentication credentials and present a TLS certificate valid for their host name."`
 
 	DNSHost dns.Domain `sconf:"-" json:"-"`
 }
 
+// TransportSMTPHost is an additional host for a submission/smtp transport.
+type TransportSMTPHost struct {
+	Host     string `sconf-doc:"Host name to connect to and for verifying its TLS certificate."`
+	Port     int    `sconf:"optional" sconf-doc:"If unset or 0, the port of the transport is used."`
+	Priority int    `sconf:"optional" sconf-doc:"Hosts with a lower priority are tried first. Host of the transport has priority 0."`
+	Weight   int    `sconf:"optional" sconf-doc:"Weight when choosing among hosts with the same priority. Default 1 if 0."`
+
+	DNSHost dns.Domain `sconf:"-" json:"-"`
+}
+
+// OAuth is an external identity provider that validates OAuth 2.0 bearer tokens
+// through token introspection (RFC 7662).
+type OAuth struct {
+	IntrospectionURL    string   `sconf-doc:"URL of token introspection endpoint of the identity provider. Bearer tokens not issued by mox are sent to this endpoint for validation."`
+	ClientID            string   `sconf-doc:"Client ID for authenticating to the introspection endpoint with HTTP basic authentication."`
+	ClientSecret        string   `sconf-doc:"Client secret for authenticating to the introspection endpoint. Can be a secret reference like env:NAME, see \"Secrets\" in the config documentation."`
+	UsernameClaim       string   `sconf:"optional" sconf-doc:"Field in the introspection response with the email address of the user, which must be an address of an account. Default: email."`
+	Audiences           []string `sconf:"optional" sconf-doc:"Accepted values for the audience (aud) or client ID (client_id) of tokens, in addition to ClientID. Tokens are only accepted if they were issued for mox: their audience must include ClientID or one of these values, or they must have been requested by a client with one of these IDs. E.g. the identifier of mox as resource at the identity provider, or the client IDs of email applications that request tokens."`
+	OpenIDConfiguration string   `sconf:"optional" sconf-doc:"URL of the OpenID Connect discovery document of the identity provider, e.g. https://id.example.org/.well-known/openid-configuration. Sent to clients in authentication failure responses, so they know where to request a new token."`
+
+	EffectiveClientSecret string `sconf:"-" json:"-"` // ClientSecret, with secret reference resolved.
+}
+
 // SMTPAuth hold authentication credentials used when delivering messages
 // through a smarthost.
 type SMTPAuth struct {
 	Username   string
-	Password   string
+	Password   string   `sconf-doc:"Can be a secret reference like env:NAME, see \"Secrets\" in the config documentation."`
 	Mechanisms []string `sconf:"optional" sconf-doc:"Allowed authentication mechanisms. Defaults to SCRAM-SHA-256-PLUS, SCRAM-SHA-256, SCRAM-SHA-1-PLUS, SCRAM-SHA-1, CRAM-MD5. Not included by default: PLAIN. Specify the strongest mechanism known to be implemented by the server to prevent mechanism downgrade attacks."`
 
 	EffectiveMechanisms []string `sconf:"-" json:"-"`
+	EffectivePassword   string   `sconf:"-" json:"-"` // Password, with secret reference resolved.
 }
 
 type TransportSocks struct {
 	Address        string   `sconf-doc:"Address of SOCKS proxy, of the form host:port or ip:port."`
 	RemoteIPs      []string `sconf-doc:"IP addresses connections from the SOCKS server will originate from. This IP addresses should be configured in the SPF record (keep in mind DNS record time to live (TTL) when adding a SOCKS proxy). Reverse DNS should be set up for these address, resolving to RemoteHostname. These are typically the IPv4 and IPv6 address for the host in the Address field."`
 	RemoteHostname string   `sconf-doc:"Hostname belonging to RemoteIPs. This name is used during in SMTP EHLO. This is typically the hostname of the host in the Address field."`
+	RemoteResolve  bool     `sconf:"optional" sconf-doc:"If set, the recipient domain is not looked up in DNS (no MX, CNAME, IP or TLSA records, and no MTA-STS policy). Instead, the SOCKS proxy is asked to connect to port 25 of the recipient domain by name, letting the proxy resolve the name. For domains that can only be resolved through the proxy, like .onion domains of the Tor network. Typically used with a route for a top-level domain, e.g. ToDomain .onion. TLS is opportunistic and not verified."`
 
 	// todo: add authentication credentials?
 
@@ -280,6 +602,8 @@ type TransportDirect struct {
 	DisableIPv4 bool `sconf:"optional" sconf-doc:"If set, outgoing SMTP connections will *NOT* use IPv4 addresses to connect to remote SMTP servers."`
 	DisableIPv6 bool `sconf:"optional" sconf-doc:"If set, outgoing SMTP connections will *NOT* use IPv6 addresses to connect to remote SMTP servers."`
 
+	RequireVerifiedTLS bool `sconf:"optional" sconf-doc:"If set, delivery requires STARTTLS with a verified certificate, through DANE or otherwise PKIX/WebPKI verification of the MX host name, also when the recipient domain has no MTA-STS or DANE policy. Delivery attempts fail if verified TLS is not possible, and a DSN is sent to the sender after the last attempt. A \"TLS-Required: No\" header is ignored. Typically used with a route with ToDomain, requiring verified TLS for specific destination domains."`
+
 	IPFamily string `sconf:"-" json:"-"`
 }
 
@@ -293,22 +617,44 @@ type TransportFail struct {
 	Message string `sconf:"-"`
 }
 
+// Tenant is a group of domains and their accounts, with delegated administration.
+type Tenant struct {
+	Description                  string   `sconf:"optional" sconf-doc:"Free-form description of tenant, e.g. the name of the customer."`
+	MaxOutgoingMessagesPerDay    int      `sconf:"optional" sconf-doc:"Default and maximum for MaxOutgoingMessagesPerDay of accounts of this tenant. Accounts with a higher or no configured limit get this limit. If zero, the account limits apply."`
+	MaxFirstTimeRecipientsPerDay int      `sconf:"optional" sconf-doc:"Default and maximum for MaxFirstTimeRecipientsPerDay of accounts of this tenant. Accounts with a higher or no configured limit get this limit. If zero, the account limits apply."`
+	OutgoingIPs                  []string `sconf:"optional" sconf-doc:"IPs to use as source address for direct delivery of messages from accounts of this tenant, instead of the IPs of the SMTP listeners. For each delivery attempt, an IP of the address family of the remote host is randomly selected from this pool. The IPs must be configured on the machine. Make sure the IPs have matching reverse DNS and are included in the SPF records of the domains of the tenant."`
+
+	IPs []net.IP `sconf:"-" json:"-"`
+}
+
 type Domain struct {
-	Disabled                    bool             `sconf:"optional" sconf-doc:"Disabled domains can be useful during/before migrations. Domains that are disabled can still be configured like normal, including adding addresses using the domain to accounts. However, disabled domains: 1. Do not try to fetch ACME certificates. TLS connections to host names involving the email domain will fail. A TLS certificate for the hostname (that wil be used as MX) itself will be requested. 2. Incoming deliveries over SMTP are rejected with a temporary error '450 4.2.1 recipient domain temporarily disabled'. 3. Submissions over SMTP using an (envelope) SMTP MAIL FROM address or message 'From' address of a disabled domain will be rejected with a temporary error '451 4.3.0 sender domain temporarily disabled'. Note that accounts with addresses at disabled domains can still log in and read email (unless the account itself is disabled)."`
-	Description                 string           `sconf:"optional" sconf-doc:"Free-form description of domain."`
-	ClientSettingsDomain        string           `sconf:"optional" sconf-doc:"Hostname for client settings instead of the mail server hostname. E.g. mail.<domain>. For future migration to another mail operator without requiring all clients to update their settings, it is convenient to have client settings that reference a subdomain of the hosted domain instead of the hostname of the server where the mail is currently hosted. If empty, the hostname of the mail server is used for client configurations. Unicode name."`
-	LocalpartCatchallSeparator  string           `sconf:"optional" sconf-doc:"If not empty, only the string before the separator is used to for email delivery decisions. For example, if set to \"+\", you+anything@example.com will be delivered to you@example.com."`
-	LocalpartCatchallSeparators []string         `sconf:"optional" sconf-doc:"Similar to LocalpartCatchallSeparator, but in case multiple are needed. For example both \"+\" and \"-\". Only of one LocalpartCatchallSeparator or LocalpartCatchallSeparators can be set. If set, the first separator is used to make unique addresses for outgoing SMTP connections with FromIDLoginAddresses."`
-	LocalpartCaseSensitive      bool             `sconf:"optional" sconf-doc:"If set, upper/lower case is relevant for email delivery."`
-	DKIM                        DKIM             `sconf:"optional" sconf-doc:"With DKIM signing, a domain is taking responsibility for (content of) emails it sends, letting receiving mail servers build up a (hopefully positive) reputation of the domain, which can help with mail delivery."`
-	DMARC                       *DMARC           `sconf:"optional" sconf-doc:"With DMARC, a domain publishes, in DNS, a policy on how other mail servers should handle incoming messages with the From-header matching this domain and/or subdomain (depending on the configured alignment). Receiving mail servers use this to build up a reputation of this domain, which can help with mail delivery. A domain can also publish an email address to which reports about DMARC verification results can be sent by verifying mail servers, useful for monitoring. Incoming DMARC reports are automatically parsed, validated, added to metrics and stored in the reporting database for later display in the admin web pages."`
-	MTASTS                      *MTASTS          `sconf:"optional" sconf-doc:"MTA-STS is a mechanism that allows publishing a policy with requirements for WebPKI-verified SMTP STARTTLS connections for email delivered to a domain. Existence of a policy is announced in a DNS TXT record (often unprotected/unverified, MTA-STS's weak spot). If a policy exists, it is fetched with a WebPKI-verified HTTPS request. The policy can indicate that WebPKI-verified SMTP STARTTLS is required, and which MX hosts (optionally with a wildcard pattern) are allowd. MX hosts to deliver to are still taken from DNS (again, not necessarily protected/verified), but messages will only be delivered to domains matching the MX hosts from the published policy. Mail servers look up the MTA-STS policy when first delivering to a domain, then keep a cached copy, periodically checking the DNS record if a new policy is available, and fetching and caching it if so. To update a policy, first serve a new policy with an updated policy ID, then update the DNS record (not the other way around). To remove an enforced policy, publish an updated policy with mode \"none\" for a long enough period so all cached policies have been refreshed (taking DNS TTL and policy max age into account), then remove the policy from DNS, wait for TTL to expire, and stop serving the policy."`
-	TLSRPT                      *TLSRPT          `sconf:"optional" sconf-doc:"With TLSRPT a domain specifies in DNS where reports about encountered SMTP TLS behaviour should be sent. Useful for monitoring. Incoming TLS reports are automatically parsed, validated, added to metrics and stored in the reporting database for later display in the admin web pages."`
-	Routes                      []Route          `sconf:"optional" sconf-doc:"Routes for delivering outgoing messages through the queue. Each delivery attempt evaluates account routes, these domain routes and finally global routes. The transport of the first matching route is used in the delivery attempt. If no routes match, which is the default with no configured routes, messages are delivered directly from the queue."`
-	Aliases                     map[string]Alias `sconf:"optional" sconf-doc:"Aliases that cause messages to be delivered to one or more locally configured addresses. Keys are localparts (encoded, as they appear in email addresses)."`
+	Disabled                    bool                 `sconf:"optional" sconf-doc:"Disabled domains can be useful during/before migrations. Domains that are disabled can still be configured like normal, including adding addresses using the domain to accounts. However, disabled domains: 1. Do not try to fetch ACME certificates. TLS connections to host names involving the email domain will fail. A TLS certificate for the hostname (that wil be used as MX) itself will be requested. 2. Incoming deliveries over SMTP are rejected with a temporary error '450 4.2.1 recipient domain temporarily disabled'. 3. Submissions over SMTP using an (envelope) SMTP MAIL FROM address or message 'From' address of a disabled domain will be rejected with a temporary error '451 4.3.0 sender domain temporarily disabled'. Note that accounts with addresses at disabled domains can still log in and read email (unless the account itself is disabled)."`
+	Description                 string               `sconf:"optional" sconf-doc:"Free-form description of domain."`
+	ClientSettingsDomain        string               `sconf:"optional" sconf-doc:"Hostname for client settings instead of the mail server hostname. E.g. mail.<domain>. For future migration to another mail operator without requiring all clients to update their settings, it is convenient to have client settings that reference a subdomain of the hosted domain instead of the hostname of the server where the mail is currently hosted. If empty, the hostname of the mail server is used for client configurations. Unicode name."`
+	ACME                        string               `sconf:"optional" sconf-doc:"Name of ACME provider from the static configuration to request TLS certificates with for the host names of this domain, i.e. autoconfig, mta-sts, openpgpkey and the client settings domain. If empty, the ACME provider of the listener is used. Useful for requesting certificates for some domains from another certificate authority, e.g. one that requires external account binding."`
+	AliasOf                     string               `sconf:"optional" sconf-doc:"If set, this domain is an alias of the named (unicode) domain: messages to an address at this domain are delivered as if sent to the same localpart at the other domain, and accounts can log in and send messages with addresses at this domain. No addresses, aliases or destination patterns can be configured for this domain, and the localpart catchall separators and case-sensitivity of the other domain are used. This domain still has its own DKIM, DMARC, MTA-STS and TLSRPT configuration, e.g. for signing messages sent with this domain, and needs its own DNS records."`
+	LocalpartCatchallSeparator  string               `sconf:"optional" sconf-doc:"If not empty, only the string before the separator is used to for email delivery decisions. For example, if set to \"+\", you+anything@example.com will be delivered to you@example.com."`
+	LocalpartCatchallSeparators []string             `sconf:"optional" sconf-doc:"Similar to LocalpartCatchallSeparator, but in case multiple are needed. For example both \"+\" and \"-\". Only of one LocalpartCatchallSeparator or LocalpartCatchallSeparators can be set. If set, the first separator is used to make unique addresses for outgoing SMTP connections with FromIDLoginAddresses."`
+	LocalpartCaseSensitive      bool                 `sconf:"optional" sconf-doc:"If set, upper/lower case is relevant for email delivery."`
+	DKIM                        DKIM                 `sconf:"optional" sconf-doc:"With DKIM signing, a domain is taking responsibility for (content of) emails it sends, letting receiving mail servers build up a (hopefully positive) reputation of the domain, which can help with mail delivery."`
+	DMARC                       *DMARC               `sconf:"optional" sconf-doc:"With DMARC, a domain publishes, in DNS, a policy on how other mail servers should handle incoming messages with the From-header matching this domain and/or subdomain (depending on the configured alignment). Receiving mail servers use this to build up a reputation of this domain, which can help with mail delivery. A domain can also publish an email address to which reports about DMARC verification results can be sent by verifying mail servers, useful for monitoring. Incoming DMARC reports are automatically parsed, validated, added to metrics and stored in the reporting database for later display in the admin web pages."`
+	MTASTS                      *MTASTS              `sconf:"optional" sconf-doc:"MTA-STS is a mechanism that allows publishing a policy with requirements for WebPKI-verified SMTP STARTTLS connections for email delivered to a domain. Existence of a policy is announced in a DNS TXT record (often unprotected/unverified, MTA-STS's weak spot). If a policy exists, it is fetched with a WebPKI-verified HTTPS request. The policy can indicate that WebPKI-verified SMTP STARTTLS is required, and which MX hosts (optionally with a wildcard pattern) are allowd. MX hosts to deliver to are still taken from DNS (again, not necessarily protected/verified), but messages will only be delivered to domains matching the MX hosts from the published policy. Mail servers look up the MTA-STS policy when first delivering to a domain, then keep a cached copy, periodically checking the DNS record if a new policy is available, and fetching and caching it if so. To update a policy, first serve a new policy with an updated policy ID, then update the DNS record (not the other way around). To remove an enforced policy, publish an updated policy with mode \"none\" for a long enough period so all cached policies have been refreshed (taking DNS TTL and policy max age into account), then remove the policy from DNS, wait for TTL to expire, and stop serving the policy."`
+	TLSRPT                      *TLSRPT              `sconf:"optional" sconf-doc:"With TLSRPT a domain specifies in DNS where reports about encountered SMTP TLS behaviour should be sent. Useful for monitoring. Incoming TLS reports are automatically parsed, validated, added to metrics and stored in the reporting database for later display in the admin web pages."`
+	BIMI                        *BIMI                `sconf:"optional" sconf-doc:"BIMI (Brand Indicators for Message Identification) lets a domain publish a logo in DNS, that receiving mail clients may show for messages that pass DMARC. Only used for generating the suggested DNS records, the DNS check, and optionally for adding a BIMI-Selector header to outgoing messages. BIMI requires a DMARC policy of quarantine or reject. Some mail providers only show logos with a Verified Mark Certificate (VMC)."`
+	Routes                      []Route              `sconf:"optional" sconf-doc:"Routes for delivering outgoing messages through the queue. Each delivery attempt evaluates account routes, these domain routes and finally global routes. The transport of the first matching route is used in the delivery attempt. If no routes match, which is the default with no configured routes, messages are delivered directly from the queue."`
+	Aliases                     map[string]Alias     `sconf:"optional" sconf-doc:"Aliases that cause messages to be delivered to one or more locally configured addresses. Keys are localparts (encoded, as they appear in email addresses)."`
+	Lists                       map[string]List      `sconf:"optional" sconf-doc:"Mailing lists with subscribers managed by mox. Keys are localparts (encoded, as they appear in email addresses). Messages to the list address are sent to all subscribers. Commands to subscribe and unsubscribe are sent to the request address of a list: its localpart with \"-request\" appended, e.g. discuss-request@example.org. Send a message with \"help\" as subject to the request address for the available commands."`
+	DestinationPatterns         []DestinationPattern `sconf:"optional" sconf-doc:"Destinations for localparts matching a pattern, for addresses that are not explicitly configured as account destination or alias. Patterns are evaluated in order, the first match is used. A catchall destination for the domain is only used if no pattern matches. Useful for delivering many similar addresses, e.g. invoice-*@, to an account without configuring each address."`
+	Tenant                      string               `sconf:"optional" sconf-doc:"Name of tenant this domain belongs to. Accounts with this domain as their Domain belong to the same tenant, and can only have addresses at domains of the tenant. If empty, the domain is not part of a tenant and can only be managed by the admin."`
+	VirusScan                   string               `sconf:"optional" sconf-doc:"Whether to scan incoming messages for this domain for viruses, if ClamAV is configured in mox.conf: \"enabled\" or \"disabled\". If empty, the default from the ClamAV configuration applies."`
+	Subdomains                  *Subdomains          `sconf:"optional" sconf-doc:"If set, messages for addresses at subdomains of this domain are accepted for delivery, e.g. for user@sales.example.org with example.org configured, without configuring each subdomain as a domain. The routing rules determine which address at this domain receives the message. Subdomains that are configured as domain themselves are not affected. Subdomains need an MX record, typically a wildcard, see the DNS records for the domain. Addresses at subdomains cannot be used for logging in or sending messages."`
+	RejectedRetention           *RejectedRetention   `sconf:"optional" sconf-doc:"If set, the full contents of incoming messages for this domain that were rejected with a permanent error (5xx) after the DATA command, e.g. due to a DMARC reject policy or the junk filter, are retained for review by admins. Retained messages are stored separately from the Rejects mailboxes of accounts and are not visible to users. Admins can inspect them in the admin web interface, for finding false positives, and deliver them to their recipients after adjusting the policy that caused the rejection."`
+	RejectText                  *RejectText          `sconf:"optional" sconf-doc:"Custom text in SMTP responses for incoming messages to this domain that are rejected by the delivery policy, e.g. due to a bad sender reputation, junk content, DNS blocklists or SPF. By default, such messages are rejected with a generic \"error processing\", which does not reveal how messages are evaluated, but can confuse legitimate senders and support teams."`
+	Retire                      *DomainRetire        `sconf:"optional" sconf-doc:"If set, the domain is being decommissioned, e.g. after moving to another domain. Incoming messages are rejected with a permanent error referring to the new address, optionally after a notice period in which messages are still delivered and senders get an automatic reply with the new address. Accounts with this domain as their default domain can still log in until the end of the grace period, e.g. to retrieve their messages over IMAP. An export of the messages of those accounts can be made with \"mox config domain export\". Configure with \"mox config domain retire\"."`
 
 	Domain                  dns.Domain `sconf:"-"`
 	ClientSettingsDNSDomain dns.Domain `sconf:"-" json:"-"`
+	AliasOfDomain           dns.Domain `sconf:"-" json:"-"` // Parsed AliasOf, zero if not an alias.
 
 	// Set when DMARC and TLSRPT (when set) has an address with different domain (we're
 	// hosting the reporting), and there are no destination addresses configured for
@@ -332,6 +678,125 @@ type Alias struct {
 	ParsedAddresses []AliasAddress `sconf:"-"` // Matches addresses.
 }
 
+type List struct {
+	Account       string `sconf-doc:"Account of the list owner. Notifications about messages held for moderation, and delivery failures and other messages with a null reverse path sent to the list and request addresses are delivered to the Inbox of this account. Outgoing list messages are queued on behalf of this account."`
+	Description   string `sconf:"optional" sconf-doc:"Free-form description of the list, used in the List-Id header and messages to subscribers."`
+	SubjectPrefix string `sconf:"optional" sconf-doc:"Prefix for the subject of messages sent to subscribers, e.g. \"[discuss]\". Not added if the subject already contains the prefix."`
+	PostPublic    bool   `sconf:"optional" sconf-doc:"If true, anyone can post messages to the list. Otherwise only subscribers can post, based on the message From address, which must be DMARC-like-verified. Messages from others are held for moderation."`
+	Moderated     bool   `sconf:"optional" sconf-doc:"If true, all messages to the list are held for moderation by the list owner."`
+
+	LocalpartStr string     `sconf:"-"` // In encoded form.
+	Domain       dns.Domain `sconf:"-"`
+}
+
+// Addresses returns the address of the list, and its request address for
+// subscription commands.
+func (l List) Addresses() (list, request smtp.Address) {
+	lp := smtp.Localpart(l.LocalpartStr)
+	if xlp, err := smtp.ParseLocalpart(l.LocalpartStr); err == nil {
+		lp = xlp
+	}
+	return smtp.NewAddress(lp, l.Domain), smtp.NewAddress(lp+"-request", l.Domain)
+}
+
+type DestinationPattern struct {
+	Localpart       string `sconf:"optional" sconf-doc:"Wildcard pattern for the localpart. A * matches zero or more characters, a ? matches a single character. For example \"invoice-*\". Patterns are matched against the localpart with catchall separators and anything after removed, and lower-cased unless the domain has case-sensitive localparts. Exactly one of Localpart and LocalpartRegexp must be set."`
+	LocalpartRegexp string `sconf:"optional" sconf-doc:"Regular expression for the localpart, it must match the entire localpart. For example \"(invoice|receipt)-[0-9]+\". Matched against the same localpart as Localpart, so should be lower-case unless the domain has case-sensitive localparts."`
+	Account         string `sconf-doc:"Account to deliver matching messages to."`
+	Mailbox         string `sconf:"optional" sconf-doc:"Mailbox to deliver to. If empty, messages are delivered to Inbox."`
+
+	LocalpartRegexpCompiled *regexp.Regexp `sconf:"-" json:"-"` // Compiled from Localpart or LocalpartRegexp.
+}
+
+type Subdomains struct {
+	Routes      []SubdomainRoute `sconf:"optional" sconf-doc:"Rules for delivering messages for addresses at subdomains. Evaluated in order, the first matching rule is used. If no rule matches, the subdomain is treated as an unknown domain. If no rules are configured, all subdomains are accepted, with messages delivered to the same localpart at this domain."`
+	DMARCPolicy string           `sconf:"optional" sconf-doc:"DMARC policy for subdomains: none, quarantine or reject. Used for the sp= subdomain policy in the suggested DMARC DNS record, and compared against the published record in the domain check. If empty, no sp= is suggested and the policy of this domain also applies to its subdomains."`
+}
+
+type RejectedRetention struct {
+	MaxAge  time.Duration `sconf:"optional" sconf-doc:"Retained messages older than this period are removed. Default 336h (14 days)."`
+	MaxSize int64         `sconf:"optional" sconf-doc:"Maximum total size in bytes of retained messages for this domain. When exceeded, the oldest messages are removed. Messages larger than a tenth of this size are not retained. Default 104857600 (100MB)."`
+}
+
+type RejectText struct {
+	ContactURL string            `sconf:"optional" sconf-doc:"URL where senders can get help with rejected messages, for placeholder {contact}, e.g. https://mox.example/mailhelp."`
+	Default    string            `sconf:"optional" sconf-doc:"Template for rejections that would otherwise get the generic \"error processing\" text. Placeholders: {reason} for the reason of the rejection, as in the X-Mox-Reason header of messages in the Rejects mailbox, e.g. junk-content, dns-blocklisted, or a reputation method like msgfromfull or ip1 for rejections based on earlier messages; {contact} for ContactURL; {subjectpass} for the hint to resend with a token in the subject, only for reason give-subjectpass. E.g. \"message refused ({reason}), see {contact}\". Must be ASCII without control characters, and smaller than 256 bytes."`
+	Reasons    map[string]string `sconf:"optional" sconf-doc:"Templates for specific reasons, keyed by reason, e.g. junk-content, dns-blocklisted, uri-blocklisted, dmarc-policy, spf-policy, iprev, rspamd, msgfromfull, ip1, give-subjectpass, high-rate. Overrides Default, and also applies to rejections with a more specific text than \"error processing\". For give-subjectpass, the hint is appended if the template does not contain {subjectpass}."`
+}
+
+// RejectTextPlaceholders are the placeholders allowed in RejectText templates.
+var RejectTextPlaceholders = []string{"{reason}", "{contact}", "{subjectpass}"}
+
+// Text returns the text for an SMTP rejection with reason. Errmsg is the text
+// that would be used without customization. For reason "give-subjectpass",
+// errmsg holds the hint with the token. If no template applies, errmsg is
+// returned.
+func (t RejectText) Text(reason, errmsg string) string {
+	tmpl, ok := t.Reasons[reason]
+	if !ok {
+		if t.Default == "" || errmsg != "error processing" {
+			return errmsg
+		}
+		tmpl = t.Default
+	}
+	var subjectpass string
+	if reason == "give-subjectpass" {
+		subjectpass = errmsg
+		if !strings.Contains(tmpl, "{subjectpass}") {
+			tmpl += " {subjectpass}"
+		}
+	}
+	r := strings.NewReplacer("{reason}", reason, "{contact}", t.ContactURL, "{subjectpass}", subjectpass)
+	return r.Replace(tmpl)
+}
+
+type DomainRetire struct {
+	NewDomain   string `sconf:"optional" sconf-doc:"Domain that addresses of this domain have moved to. The new address for an address is the same localpart at this domain. Referenced in rejections and automatic replies. Rejections are sent with code 551 if set, and 550 otherwise."`
+	Message     string `sconf:"optional" sconf-doc:"Text for SMTP rejections and automatic replies, instead of the default text. Any \"{newaddress}\" is replaced with the new address of the recipient. Must be ASCII without control characters, and smaller than 256 bytes."`
+	RejectAfter string `sconf:"optional" sconf-doc:"Date, as 2006-01-02 or RFC3339 timestamp, from which incoming messages are rejected. Before, messages are delivered as usual, and senders get an automatic reply about the address change, at most once per week per sender. If empty, messages are rejected immediately."`
+	LoginUntil  string `sconf:"optional" sconf-doc:"Date, as 2006-01-02 or RFC3339 timestamp, from which accounts with this domain as their default domain can no longer log in. Logins are disabled by setting LoginDisabled for those accounts, within an hour after this time. If empty, logins are not disabled."`
+
+	NewDomainParsed dns.Domain `sconf:"-" json:"-"` // Zero if no NewDomain.
+	RejectAfterTime time.Time  `sconf:"-" json:"-"` // Zero if no RejectAfter.
+	LoginUntilTime  time.Time  `sconf:"-" json:"-"` // Zero if no LoginUntil.
+}
+
+// Rejecting returns whether incoming messages are rejected at t.
+func (r DomainRetire) Rejecting(t time.Time) bool {
+	return r.RejectAfterTime.IsZero() || !t.Before(r.RejectAfterTime)
+}
+
+// NewAddress returns the new address for localpart lp, or the zero address if
+// no new domain is configured.
+func (r DomainRetire) NewAddress(lp smtp.Localpart) smtp.Address {
+	if r.NewDomainParsed.IsZero() {
+		return smtp.Address{}
+	}
+	return smtp.NewAddress(lp, r.NewDomainParsed)
+}
+
+// Text returns the text for rejections and automatic replies for localpart lp.
+func (r DomainRetire) Text(lp smtp.Localpart) string {
+	newAddr := r.NewAddress(lp)
+	if r.Message != "" {
+		return strings.ReplaceAll(r.Message, "{newaddress}", newAddr.Pack(true))
+	}
+	if newAddr.IsZero() {
+		return "domain no longer accepts email"
+	}
+	// ../rfc/5321:2490
+	return fmt.Sprintf("user not local; please try <%s>", newAddr.Pack(true))
+}
+
+type SubdomainRoute struct {
+	Subdomain string `sconf-doc:"Subdomain to match, relative to this domain. For example \"sales\" matches sales.example.org, and \"*.eu\" matches all subdomains of eu.example.org (but not eu.example.org itself). A single \"*\" matches all subdomains."`
+	Deliver   string `sconf:"optional" sconf-doc:"Where to deliver messages for matching subdomains. \"localpart\" (default) delivers to the same localpart at this domain, e.g. user@sales.example.org to user@example.org. \"subdomain\" delivers to the first label of the subdomain as localpart at this domain, e.g. anything@john.example.org to john@example.org. Otherwise, an email address at a configured domain to deliver all messages to."`
+
+	SubdomainASCII string       `sconf:"-" json:"-"` // Lower-case ASCII form of Subdomain, without "*." prefix.
+	Wildcard       bool         `sconf:"-" json:"-"` // Whether Subdomain starts with "*", or is "*".
+	DeliverAddress smtp.Address `sconf:"-" json:"-"` // Parsed Deliver, if an address.
+}
+
 type AliasAddress struct {
 	Address     smtp.Address // Parsed address.
 	AccountName string       // Looked up.
@@ -366,6 +831,13 @@ type TLSRPT struct {
 	DNSDomain       dns.Domain     `sconf:"-"` // Effective domain, always set based on Domain field or Domain where this is configured.
 }
 
+type BIMI struct {
+	Selector       string `sconf:"optional" sconf-doc:"Selector for the BIMI DNS record, at <selector>._bimi.<domain>. Default: default."`
+	Location       string `sconf-doc:"HTTPS URL of the logo, in SVG Tiny Portable/Secure format."`
+	Authority      string `sconf:"optional" sconf-doc:"HTTPS URL of the PEM-encoded Verified Mark Certificate (VMC) for the logo."`
+	SelectorHeader bool   `sconf:"optional" sconf-doc:"If set, a BIMI-Selector header with the selector is added to outgoing DKIM-signed messages from this domain, and the header is included in the DKIM signatures. Only needed when the selector is not \"default\"."`
+}
+
 type Canonicalization struct {
 	HeaderRelaxed bool `sconf-doc:"If set, some modifications to the headers (mostly whitespace) are allowed."`
 	BodyRelaxed   bool `sconf-doc:"If set, some whitespace modifications to the message body are allowed."`
@@ -379,7 +851,7 @@ type Selector struct {
 	HeadersEffective []string         `sconf:"-"` // Used when signing. Based on Headers from config, or the reasonable default.
 	DontSealHeaders  bool             `sconf:"optional" sconf-doc:"If set, don't prevent duplicate headers from being added. Not recommended."`
 	Expiration       string           `sconf:"optional" sconf-doc:"Period a signature is valid after signing, as duration, e.g. 72h. The period should be enough for delivery at the final destination, potentially with several hops/relays. In the order of days at least."`
-	PrivateKeyFile   string           `sconf-doc:"Either an RSA or ed25519 private key file in PKCS8 PEM form."`
+	PrivateKeyFile   string           `sconf-doc:"Either an RSA or ed25519 private key file in PKCS8 PEM form. Can also be an env: or vault: secret reference to the PEM data, see \"Secrets\" in the config documentation."`
 
 	Algorithm         string        `sconf:"-"`          // "ed25519", "rsa-*", based on private key.
 	ExpirationSeconds int           `sconf:"-" json:"-"` // Parsed from Expiration.
@@ -388,13 +860,16 @@ type Selector struct {
 }
 
 type DKIM struct {
-	Selectors map[string]Selector `sconf-doc:"Emails can be DKIM signed. Config parameters are per selector. A DNS record must be created for each selector. Add the name to Sign to use the selector for signing messages."`
+	Selectors map[string]Selector `sconf:"optional" sconf-doc:"Emails can be DKIM signed. Config parameters are per selector. A DNS record must be created for each selector. Add the name to Sign to use the selector for signing messages."`
 	Sign      []string            `sconf:"optional" sconf-doc:"List of selectors that emails will be signed with."`
+
+	SignMailFrom bool `sconf:"optional" sconf-doc:"If set, messages submitted by an account with a message From address in this domain, but with an SMTP MAIL FROM address in another domain configured on this server, are also signed with the DKIM selectors of the MAIL FROM domain, in addition to those of this domain. Receivers can then find a DKIM signature aligned with the envelope domain, e.g. for DSNs and reputation tracking of the MAIL FROM domain. By default, submitted messages are only signed for the message From domain."`
+	SignHostname bool `sconf:"optional" sconf-doc:"If set, messages submitted by an account with a message From address in this domain are also signed with the DKIM selectors of the domain of the hostname of this mail server (or its closest configured parent domain), as an additional signature by the sending host. Useful when relaying for domains whose reputation shouldn't be the only signal. The hostname domain must be configured on this server with DKIM selectors."`
 }
 
 type Route struct {
 	FromDomain      []string `sconf:"optional" sconf-doc:"Matches if the envelope from domain matches one of the configured domains, or if the list is empty. If a domain starts with a dot, prefixes of the domain also match."`
-	ToDomain        []string `sconf:"optional" sconf-doc:"Like FromDomain, but matching against the envelope to domain."`
+	ToDomain        []string `sconf:"optional" sconf-doc:"Like FromDomain, but matching against the envelope to domain. Can also hold address literals, e.g. [192.0.2.1] or [IPv6:2001:db8::1], to match recipient addresses with that IP. An empty address literal, [], matches all recipient addresses with an IP address instead of a domain. A top-level domain with a leading dot, like .onion, matches all domains under it, e.g. for routing to a SOCKS transport with RemoteResolve."`
 	MinimumAttempts int      `sconf:"optional" sconf-doc:"Matches if at least this many deliveries have already been attempted. This can be used to attempt sending through a smarthost when direct delivery has failed for several times."`
 	Transport       string   `sconf:"The transport used for delivering the message that matches requirements of the above fields."`
 
@@ -424,6 +899,11 @@ type SubjectPass struct {
 	Period time.Duration `sconf-doc:"How long unique values are accepted after generating, e.g. 12h."` // todo: have a reasonable default for this?
 }
 
+type AttachmentLinks struct {
+	MinSize      int64 `sconf-doc:"Attachments of this size in bytes or larger are replaced with a link. E.g. 10485760 for 10MB."`
+	ValidityDays int   `sconf:"optional" sconf-doc:"Number of days the links remain valid, after which the attachments are removed. Default 14, maximum 90."`
+}
+
 type AutomaticJunkFlags struct {
 	Enabled              bool   `sconf-doc:"If enabled, junk/nonjunk flags will be set automatically if they match some of the regular expressions. When two of the three mailbox regular expressions are set, the remaining one will match all unmatched messages. Messages are matched in the order 'junk', 'neutral', 'not junk', and the search stops on the first match. Mailboxes are lowercased before matching."`
 	JunkMailboxRegexp    string `sconf:"optional" sconf-doc:"Example: ^(junk|spam)."`
@@ -437,8 +917,16 @@ type Account struct {
 	FromIDLoginAddresses     []string         `sconf:"optional" sconf-doc:"Login addresses that cause outgoing email to be sent with SMTP MAIL FROM addresses with a unique id after the localpart catchall separator (which must be enabled when addresses are specified here). Any delivery status notifications (DSN, e.g. for bounces), can be related to the original message and recipient with unique id's. You can login to an account with any valid email address, including variants with the localpart catchall separator. You can use this mechanism to both send outgoing messages with and without unique fromid for a given email address. With the webapi and webmail, a unique id will be generated. For submission, the id from the SMTP MAIL FROM command is used if present, and a unique id is generated otherwise."`
 	KeepRetiredMessagePeriod time.Duration    `sconf:"optional" sconf-doc:"Period to keep messages retired from the queue (delivered or failed) around. Keeping retired messages is useful for maintaining the suppression list for transactional email, for matching incoming DSNs to sent messages, and for debugging. The time at which to clean up (remove) is calculated at retire time. E.g. 168h (1 week)."`
 	KeepRetiredWebhookPeriod time.Duration    `sconf:"optional" sconf-doc:"Period to keep webhooks retired from the queue (delivered or failed) around. Useful for debugging. The time at which to clean up (remove) is calculated at retire time. E.g. 168h (1 week)."`
+	DeliveryPriority         int              `sconf:"optional" sconf-doc:"Default priority for delivery of outgoing messages from this account, from -9 (lowest, e.g. bulk/newsletters) to 9 (highest, e.g. transactional messages like password resets). When the queue is limited by its maximum number of concurrent deliveries, messages with a higher priority are delivered first. Submissions over SMTP can override the priority per message with the MT-PRIORITY extension. Default 0."`
+	AttachmentLinks          *AttachmentLinks `sconf:"optional" sconf-doc:"If set, large attachments of messages composed in webmail are not included in the outgoing message, but stored on this server and replaced with an expiring link to download them, added to the message text. Keeps large files out of the mailboxes of recipients, and prevents rejections by remote servers with a lower maximum message size. The copy in the Sent mailbox also only has the links. Links are to webmail at https with the hostname of this server. Messages submitted over SMTP or through the webapi are not changed, their attachments are sent as is."`
+	WeeklyDigest             bool             `sconf:"optional" sconf-doc:"If set, a digest message with statistics about the past week is delivered to the Inbox shortly after the start of each week (Monday 00:00 UTC): the number of received messages and messages marked as junk, new senders, top senders, and storage used."`
+	SubaddressMailboxes      bool             `sconf:"optional" sconf-doc:"If set, incoming messages for an address with a subaddress, e.g. user+folder@example.org with \"+\" as catchall separator of the domain, are delivered to a mailbox named after the subaddress, e.g. \"folder\", creating the mailbox if needed. Only for top-level mailbox names that are not Inbox, the rejects mailbox or a special-use mailbox like Sent or Trash, otherwise the regular mailbox is used. Rulesets of the destination that match still take precedence. Messages rejected as junk don't create mailboxes."`
+	Notifications            []Notification   `sconf:"optional" sconf-doc:"Services to send a short notification to about incoming messages delivered over SMTP, with the sender, subject and mailbox, e.g. to get notified on a phone without keeping an IMAP connection open. Each notification can be limited to messages matching rules. Messages marked as junk or delivered to the rejects mailbox are not notified. Notifications are best-effort, failed requests are not retried."`
 
 	LoginDisabled                string                 `sconf:"optional" sconf-doc:"If non-empty, login attempts on all protocols (e.g. SMTP/IMAP, web interfaces) is rejected with this error message. Useful during migrations. Incoming deliveries for addresses of this account are still accepted as normal."`
+	ReadOnly                     bool                   `sconf:"optional" sconf-doc:"If set, email clients only get read-only access to the mailboxes and messages of this account over IMAP and POP3. Mailboxes are opened read-only, and changing flags, expunging/deleting, appending, copying/moving messages and changing mailboxes is refused. Useful for litigation holds, archived accounts of former employees, and freezes during a migration. Incoming deliveries are still accepted, and the web interfaces are not affected."`
+	SubmissionDisabled           string                 `sconf:"optional" sconf-doc:"If non-empty, submitting messages for delivery (SMTP submission, webmail and webapi) is refused with this error message. Logins are still allowed, e.g. for reading email."`
+	IMAPReferralHost             string                 `sconf:"optional" sconf-doc:"If non-empty, the account has moved to the mail server with this hostname, e.g. during a staged migration. IMAP logins with valid credentials are refused with a referral to the same username at this host (RFC 2221), so email clients can switch to the new server instead of failing. Incoming deliveries for addresses of this account are still accepted. To refer SMTP deliveries to a new address, configure an SMTPError with a 551 response on destinations."`
 	Domain                       string                 `sconf-doc:"Default domain for account. Deprecated behaviour: If a destination is not a full address but only a localpart, this domain is added to form a full address."`
 	Description                  string                 `sconf:"optional" sconf-doc:"Free form description, e.g. full name or alternative contact info."`
 	FullName                     string                 `sconf:"optional" sconf-doc:"Full name, to use in message From header when composing messages in webmail. Can be overridden per destination."`
@@ -447,18 +935,24 @@ type Account struct {
 	QuotaMessageSize             int64                  `sconf:"optional" sconf-doc:"Default maximum total message size in bytes for the account, overriding any globally configured default maximum size if non-zero. A negative value can be used to have no limit in case there is a limit by default. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage."`
 	RejectsMailbox               string                 `sconf:"optional" sconf-doc:"Mail that looks like spam will be rejected, but a copy can be stored temporarily in a mailbox, e.g. Rejects. If mail isn't coming in when you expect, you can look there. The mail still isn't accepted, so the remote mail server may retry (hopefully, if legitimate), or give up (hopefully, if indeed a spammer). Messages are automatically removed from this mailbox, so do not set it to a mailbox that has messages you want to keep."`
 	KeepRejects                  bool                   `sconf:"optional" sconf-doc:"Don't automatically delete mail in the RejectsMailbox listed above. This can be useful, e.g. for future spam training. It can also cause storage to fill up."`
+	RejectsRescueAllow           string                 `sconf:"optional" sconf-doc:"When a message is moved out of the RejectsMailbox, automatically add a sender allow entry for the message From address (value \"address\") or its domain (value \"domain\"). Later messages from allowed senders with a verified From address (SPF and/or DKIM aligned per DMARC) are accepted without reputation and content analysis. If empty, no allow entry is added automatically, but the webmail offers to add one for messages in the rejects mailbox. Allow entries can be managed in the account web interface."`
+	MailboxRetention             []MailboxRetention     `sconf:"optional" sconf-doc:"Remove messages from mailboxes after they have been in the account for a while, e.g. from Trash after 30 days. Checked periodically in the background. Messages in the RejectsMailbox are always removed after 14 days unless KeepRejects is set."`
+	AutoArchive                  *AutoArchive           `sconf:"optional" sconf-doc:"If set, messages older than a configured period are automatically moved from the Inbox to a per-year archive mailbox, e.g. Archive/2024. Checked periodically in the background."`
 	AutomaticJunkFlags           AutomaticJunkFlags     `sconf:"optional" sconf-doc:"Automatically set $Junk and $NotJunk flags based on mailbox messages are delivered/moved/copied to. Email clients typically have too limited functionality to conveniently set these flags, especially $NonJunk, but they can all move messages to a different mailbox, so this helps them."`
 	JunkFilter                   *JunkFilter            `sconf:"optional" sconf-doc:"Content-based filtering, using the junk-status of individual messages to rank words in such messages as spam or ham. It is recommended you always set the applicable (non)-junk status on messages, and that you do not empty your Trash because those messages contain valuable ham/spam training information."` // todo: sane defaults for junkfilter
 	MaxOutgoingMessagesPerDay    int                    `sconf:"optional" sconf-doc:"Maximum number of outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 1000."`
 	MaxFirstTimeRecipientsPerDay int                    `sconf:"optional" sconf-doc:"Maximum number of first-time recipients in outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 200."`
+	HoldOverSendLimits           bool                   `sconf:"optional" sconf-doc:"If set, messages submitted while MaxOutgoingMessagesPerDay or MaxFirstTimeRecipientsPerDay is reached are accepted and held in the queue for review by the admin, instead of being refused. Held messages have a hold reason, and can be inspected, released for delivery or failed (sending a DSN) by the admin. Useful for handling a possibly compromised account gently, without refusing legitimate bursts of messages. If too many messages of the account are already held for review (1000), messages are refused again."`
 	NoFirstTimeSenderDelay       bool                   `sconf:"optional" sconf-doc:"Do not apply a delay to SMTP connections before accepting an incoming message from a first-time sender. Can be useful for accounts that sends automated responses and want instant replies."`
+	NoAutoCreateMailboxes        bool                   `sconf:"optional" sconf-doc:"If set, incoming messages for a mailbox that does not exist, e.g. configured in a ruleset or destination, or through SubaddressMailboxes, are delivered to the Inbox instead. By default, such mailboxes are created, with a special-use flag if the mailbox name is configured as special-use mailbox in InitialMailboxes in mox.conf (and no other mailbox has the flag)."`
 	NoCustomPassword             bool                   `sconf:"optional" sconf-doc:"If set, this account cannot set a password of their own choice, but can only set a new randomly generated password, preventing password reuse across services and use of weak passwords. Custom account passwords can be set by the admin."`
 	IMAPCapabilitiesDisabled     []string               `sconf:"optional" sconf-doc:"IMAP capabilities (upper-case) to disable on the connection after authentication. Useful if the account uses an email client with an incompatible implementation for a capability/extension."`
 	// We will not work around client incompatibilities based on client software. ../rfc/2971:93
 
 	Routes []Route `sconf:"optional" sconf-doc:"Routes for delivering outgoing messages through the queue. Each delivery attempt evaluates these account routes, domain routes and finally global routes. The transport of the first matching route is used in the delivery attempt. If no routes match, which is the default with no configured routes, messages are delivered directly from the queue."`
 
-	DNSDomain                  dns.Domain     `sconf:"-"` // Parsed form of Domain.
+	DNSDomain                  dns.Domain     `sconf:"-"`          // Parsed form of Domain.
+	IMAPReferralHostDNS        dns.Domain     `sconf:"-" json:"-"` // Parsed form of IMAPReferralHost.
 	JunkMailbox                *regexp.Regexp `sconf:"-" json:"-"`
 	NeutralMailbox             *regexp.Regexp `sconf:"-" json:"-"`
 	NotJunkMailbox             *regexp.Regexp `sconf:"-" json:"-"`
@@ -472,6 +966,34 @@ type AddressAlias struct {
 	MemberAddresses     []string // Only if allowed to see.
 }
 
+// Notification is a push notification service to send notifications about
+// incoming deliveries to. See package notify.
+type Notification struct {
+	Type          string   `sconf-doc:"Kind of service: ntfy, gotify or webhook."`
+	URL           string   `sconf-doc:"For ntfy, the URL of the topic, e.g. https://ntfy.sh/mytopic. For gotify, the base URL of the server, e.g. https://gotify.example.org, messages are posted to /message. For webhook, the URL to POST a JSON object to, with fields Account, Mailbox, From, FromName, Subject, MessageID and Received."`
+	Token         string   `sconf:"optional" sconf-doc:"For ntfy, optional access token, sent as bearer token. For gotify, the application token, required. For webhook, optional value for the Authorization header. Can be a secret reference like env:NAME, see \"Secrets\" in the config documentation."`
+	Mailboxes     []string `sconf:"optional" sconf-doc:"If non-empty, only notify about messages delivered to one of these mailboxes, e.g. Inbox."`
+	FromRegexp    string   `sconf:"optional" sconf-doc:"If non-empty, only notify about messages with a message From address (lower-cased) matching this regular expression, e.g. @example\\.com$."`
+	SubjectRegexp string   `sconf:"optional" sconf-doc:"If non-empty, only notify about messages with a subject matching this regular expression, e.g. (?i)urgent."`
+
+	EffectiveToken        string         `sconf:"-" json:"-"` // Token, with secret reference resolved.
+	FromRegexpCompiled    *regexp.Regexp `sconf:"-" json:"-"`
+	SubjectRegexpCompiled *regexp.Regexp `sconf:"-" json:"-"`
+}
+
+// MailboxRetention is a policy for removing old messages from a mailbox.
+type MailboxRetention struct {
+	Mailbox string        `sconf-doc:"Name of mailbox to remove old messages from, e.g. Trash. Child mailboxes are not included."`
+	Period  time.Duration `sconf-doc:"Messages received longer ago than this period are removed, e.g. 720h for 30 days. At least 1h."`
+}
+
+// AutoArchive is a policy for moving old messages from the Inbox to archive
+// mailboxes.
+type AutoArchive struct {
+	Period  time.Duration `sconf-doc:"Messages in the Inbox received longer ago than this period are moved to the archive mailbox for the year they were received in, e.g. 2160h for 90 days. At least 24h."`
+	Mailbox string        `sconf:"optional" sconf-doc:"Parent mailbox of the per-year archive mailboxes, which are created as needed. Default: Archive."`
+}
+
 type JunkFilter struct {
 	Threshold float64 `sconf-doc:"Approximate spaminess score between 0 and 1 above which emails are rejected as spam. Each delivery attempt adds a little noise to make it slightly harder for spammers to identify words that strongly indicate non-spaminess and use it to bypass the filter. E.g. 0.95."`
 	junk.Params
@@ -480,9 +1002,11 @@ type JunkFilter struct {
 type Destination struct {
 	Mailbox                      string    `sconf:"optional" sconf-doc:"Mailbox to deliver to if none of Rulesets match. Default: Inbox."`
 	Rulesets                     []Ruleset `sconf:"optional" sconf-doc:"Delivery rules based on message and SMTP transaction. You may want to match each mailing list by SMTP MailFrom address, VerifiedDomain and/or List-ID header (typically <listname.example.org> if the list address is listname@example.org), delivering them to their own mailbox."`
-	SMTPError                    string    `sconf:"optional" sconf-doc:"If non-empty, incoming delivery attempts to this destination will be rejected during SMTP RCPT TO with this error response line. Useful when a catchall address is configured for the domain and messages to some addresses should be rejected. The response line must start with an error code. Currently the following error resonse codes are allowed: 421 (temporary local error), 550 (user not found). If the line consists of only an error code, an appropriate error message is added. Rejecting messages with a 4xx code invites later retries by the remote, while 5xx codes should prevent further delivery attempts."`
+	SMTPError                    string    `sconf:"optional" sconf-doc:"If non-empty, incoming delivery attempts to this destination will be rejected during SMTP RCPT TO with this error response line. Useful when a catchall address is configured for the domain and messages to some addresses should be rejected. The response line must start with an error code. Currently the following error resonse codes are allowed: 421 (temporary local error), 550 (user not found), 551 (user not local, the message must be the new email address, e.g. '551 user@example.org', and is referenced in the response, useful when an account has moved). If the line consists of only an error code, an appropriate error message is added. Rejecting messages with a 4xx code invites later retries by the remote, while 5xx codes should prevent further delivery attempts."`
 	MessageAuthRequiredSMTPError string    `sconf:"optional" sconf-doc:"If non-empty, an additional DMARC-like message authentication check is done for incoming messages, validating the domain in the From-header of the message. Messages without either an aligned SPF or aligned DKIM pass are rejected during the SMTP DATA command with a permanent error code followed by the message in this field. The domain in the message 'From' header is matched in relaxed or strict mode according to the domain's DMARC policy if present, or relaxed mode (organizational instead of exact domain match) otherwise. Useful for autoresponders that don't want to accept messages they don't want to send an automated reply to."`
 	FullName                     string    `sconf:"optional" sconf-doc:"Full name to use in message From header when composing messages coming from this address with webmail."`
+	ForwardTo                    []string  `sconf:"optional" sconf-doc:"Forward incoming messages for this address to these email addresses, typically at other domains. The SMTP MAIL FROM of forwarded messages is rewritten with SRS (Sender Rewriting Scheme) to an address at the domain of this destination, so SPF checks at the receiving mail server can pass, and delivery status notifications (DSNs) for forwarded messages are sent on to the original sender. Messages classified as junk are not forwarded. Forwarded messages are delivered through the queue, like other outgoing messages. Forwarding loops between local addresses (including through aliases and rulesets) are configuration errors. Messages with a Delivered-To header for this address, i.e. that were forwarded for this address before and came back, are delivered locally instead of forwarded again."`
+	ForwardKeepCopy              bool      `sconf:"optional" sconf-doc:"If set, forwarded messages are also delivered to the mailbox of this destination. By default, forwarded messages are not kept."`
 
 	DMARCReports     bool `sconf:"-" json:"-"`
 	HostTLSReports   bool `sconf:"-" json:"-"`
@@ -495,7 +1019,7 @@ type Destination struct {
 
 // Equal returns whether d and o are equal, only looking at their user-changeable fields.
 func (d Destination) Equal(o Destination) bool {
-	if d.Mailbox != o.Mailbox || len(d.Rulesets) != len(o.Rulesets) {
+	if d.Mailbox != o.Mailbox || len(d.Rulesets) != len(o.Rulesets) || !slices.Equal(d.ForwardTo, o.ForwardTo) || d.ForwardKeepCopy != o.ForwardKeepCopy {
 		return false
 	}
 	for i, rs := range d.Rulesets {
@@ -511,6 +1035,14 @@ type Ruleset struct {
 	MsgFromRegexp      string            `sconf:"optional" sconf-doc:"Matches if this regular expression matches (a substring of) the single address in the message From header."`
 	VerifiedDomain     string            `sconf:"optional" sconf-doc:"Matches if this domain matches an SPF- and/or DKIM-verified (sub)domain."`
 	HeadersRegexp      map[string]string `sconf:"optional" sconf-doc:"Matches if these header field/value regular expressions all match (substrings of) the message headers. Header fields and valuees are converted to lower case before matching. Whitespace is trimmed from the value before matching. A header field can occur multiple times in a message, only one instance has to match. For mailing lists, you could match on ^list-id$ with the value typically the mailing list address in angled brackets with @ replaced with a dot, e.g. <name\\.lists\\.example\\.org>."`
+	MsgFromPatterns    []string          `sconf:"optional" sconf-doc:"Matches if the single address in the message From header matches one of these patterns. A '*' matches any sequence of characters, all other characters match literally. Matching is case-insensitive. E.g. '*@example.org' or 'notifications-*@*.example.org'."`
+	SPFResult          string            `sconf:"optional" sconf-doc:"Matches if the SPF result for the SMTP MAIL FROM domain is this value: pass, fail, softfail, neutral, none, temperror or permerror."`
+	DKIMResult         string            `sconf:"optional" sconf-doc:"Matches if the message has at least one verified DKIM signature (value pass), or none (value none)."`
+	DMARCResult        string            `sconf:"optional" sconf-doc:"Matches if the domain of the message From header is authenticated with an aligned SPF and/or DKIM pass, as used by DMARC (value pass), or not (value fail)."`
+	MinSize            int64             `sconf:"optional" sconf-doc:"Matches if the size of the message in bytes is at least this value."`
+	MaxSize            int64             `sconf:"optional" sconf-doc:"Matches if the size of the message in bytes is at most this value."`
+	Attachments        string            `sconf:"optional" sconf-doc:"Matches if the message has attachments (value yes), or not (value no). Message parts with a Content-Disposition of attachment, or with a filename, are considered attachments."`
+	ListID             string            `sconf:"optional" sconf-doc:"Matches if the List-Id header of the message has this list identifier, without angle brackets, e.g. name.lists.example.org. Matching is case-insensitive."`
 	// todo: add a SMTPRcptTo check
 
 	// todo: once we implement ARC, we can use dkim domains that we cannot verify but that the arc-verified forwarding mail server was able to verify.
@@ -518,11 +1050,15 @@ type Ruleset struct {
 	ListAllowDomain        string `sconf:"optional" sconf-doc:"Influences spam filtering only, this option does not change whether a message matches this ruleset. If this domain matches an SPF- and/or DKIM-verified (sub)domain, the message is accepted without further spam checks, such as a junk filter or DMARC reject evaluation. DMARC rejects should not apply for mailing lists that are not configured to rewrite the From-header of messages that don't have a passing DKIM signature of the From-domain. Otherwise, by rejecting messages, you may be automatically unsubscribed from the mailing list. The assumption is that mailing lists do their own spam filtering/moderation."`
 	AcceptRejectsToMailbox string `sconf:"optional" sconf-doc:"Influences spam filtering only, this option does not change whether a message matches this ruleset. If a message is classified as spam, it isn't rejected during the SMTP transaction (the normal behaviour), but accepted during the SMTP transaction and delivered to the specified mailbox. The specified mailbox is not automatically cleaned up like the account global Rejects mailbox, unless set to that Rejects mailbox."`
 
-	Mailbox string `sconf-doc:"Mailbox to deliver to if this ruleset matches."`
-	Comment string `sconf:"optional" sconf-doc:"Free-form comments."`
+	Mailbox   string   `sconf:"optional" sconf-doc:"Mailbox to deliver to if this ruleset matches. Required unless Discard is set."`
+	MarkSeen  bool     `sconf:"optional" sconf-doc:"Mark the delivered message as read."`
+	ForwardTo []string `sconf:"optional" sconf-doc:"Addresses to forward a copy of the message to, through the queue, with the address of this destination as SMTP MAIL FROM. The message is forwarded unmodified: A DKIM signature of the original sender may still verify, but SPF will not pass for the original MAIL FROM domain, so forwarded messages may fail DMARC checks at the recipient. Messages that are rejected or accepted as reject, and DSNs, are not forwarded."`
+	Discard   bool     `sconf:"optional" sconf-doc:"Accept the message during the SMTP transaction, but don't deliver it to a mailbox. Can be combined with ForwardTo, but not with Mailbox, MarkSeen or AcceptRejectsToMailbox."`
+	Comment   string   `sconf:"optional" sconf-doc:"Free-form comments."`
 
 	SMTPMailFromRegexpCompiled *regexp.Regexp      `sconf:"-" json:"-"`
 	MsgFromRegexpCompiled      *regexp.Regexp      `sconf:"-" json:"-"`
+	MsgFromPatternsCompiled    []*regexp.Regexp    `sconf:"-" json:"-"`
 	VerifiedDNSDomain          dns.Domain          `sconf:"-"`
 	HeadersRegexpCompiled      [][2]*regexp.Regexp `sconf:"-" json:"-"`
 	ListAllowDNSDomain         dns.Domain          `sconf:"-"`
@@ -533,7 +1069,10 @@ func (r Ruleset) Equal(o Ruleset) bool {
 	if r.SMTPMailFromRegexp != o.SMTPMailFromRegexp || r.MsgFromRegexp != o.MsgFromRegexp || r.VerifiedDomain != o.VerifiedDomain || r.IsForward != o.IsForward || r.ListAllowDomain != o.ListAllowDomain || r.AcceptRejectsToMailbox != o.AcceptRejectsToMailbox || r.Mailbox != o.Mailbox || r.Comment != o.Comment {
 		return false
 	}
-	if !reflect.DeepEqual(r.HeadersRegexp, o.HeadersRegexp) {
+	if r.SPFResult != o.SPFResult || r.DKIMResult != o.DKIMResult || r.DMARCResult != o.DMARCResult || r.MinSize != o.MinSize || r.MaxSize != o.MaxSize || r.Attachments != o.Attachments || r.ListID != o.ListID || r.MarkSeen != o.MarkSeen || r.Discard != o.Discard {
+		return false
+	}
+	if !reflect.DeepEqual(r.HeadersRegexp, o.HeadersRegexp) || !slices.Equal(r.MsgFromPatterns, o.MsgFromPatterns) || !slices.Equal(r.ForwardTo, o.ForwardTo) {
 		return false
 	}
 	return true
@@ -551,11 +1090,19 @@ type TLS struct {
 	HostPrivateKeyFiles []string  `sconf:"optional" sconf-doc:"Private keys used for ACME certificates. Specified explicitly so DANE TLSA DNS records can be generated, even before the certificates are requested. DANE is a mechanism to authenticate remote TLS certificates based on a public key or certificate specified in DNS, protected with DNSSEC. DANE is opportunistic and attempted when delivering SMTP with STARTTLS. The private key files must be in PEM format. PKCS8 is recommended, but PKCS1 and EC private keys are recognized as well. Only RSA 2048 bit and ECDSA P-256 keys are currently used. The first of each is used when requesting new certificates through ACME."`
 	ClientAuthDisabled  bool      `sconf:"optional" sconf-doc:"Disable TLS client authentication with certificates/keys, preventing the TLS server from requesting a TLS certificate from clients. Useful for working around clients that don't handle TLS client authentication well."`
 
+	CipherSuites             []string      `sconf:"optional" sconf-doc:"Cipher suites allowed for TLS 1.2 and lower, in Go naming, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. The order is not significant, the TLS implementation orders the cipher suites. Only cipher suites without known security issues can be configured. Default: the secure cipher suites of the Go TLS implementation. Cipher suites for TLS 1.3 cannot be configured."`
+	ALPNProtocols            []string      `sconf:"optional" sconf-doc:"For HTTPS connections, the protocols that can be negotiated with TLS Application Layer Protocol Negotiation. Valid values: h2 (HTTP/2), http/1.1, smtp and imap (if enabled on HTTPS, see Submissions and IMAPS). For example, only http/1.1 disables HTTP/2. Protocol acme-tls/1 for ACME certificate requests is always allowed. Default: all enabled protocols."`
+	SessionTicketsDisabled   bool          `sconf:"optional" sconf-doc:"Disable TLS session tickets for resuming TLS sessions. See also TLSSessionTicketsDisabled under SMTP, which overrides this setting for incoming SMTP connections."`
+	SessionTicketKeyRotation time.Duration `sconf:"optional" sconf-doc:"Interval for rotating the keys that encrypt TLS session tickets. The previous 7 keys remain valid for resuming sessions. Keys are only kept in memory, a restart invalidates existing session tickets. Default: 24h."`
+	OCSPStaplingDisabled     bool          `sconf:"optional" sconf-doc:"Disable OCSP stapling. By default, for certificates with an OCSP responder, a signed OCSP response with the certificate status is fetched periodically and included in the TLS handshake, so clients don't have to contact the OCSP responder of the certificate authority. Responses are fetched in the background, TLS handshakes never wait for them."`
+
 	Config                   *tls.Config     `sconf:"-" json:"-"` // TLS config for non-ACME-verification connections, i.e. SMTP and IMAP, and not port 443. Connections without SNI will use a certificate for the hostname of the listener, connections with an SNI hostname that isn't allowed will be rejected.
 	ConfigFallback           *tls.Config     `sconf:"-" json:"-"` // Like Config, but uses the certificate for the listener hostname when the requested SNI hostname is not allowed, instead of causing the connection to fail.
 	ACMEConfig               *tls.Config     `sconf:"-" json:"-"` // TLS config that handles ACME verification, for serving on port 443.
 	HostPrivateRSA2048Keys   []crypto.Signer `sconf:"-" json:"-"` // Private keys for new TLS certificates for listener host name, for new certificates with ACME, and for DANE records.
 	HostPrivateECDSAP256Keys []crypto.Signer `sconf:"-" json:"-"`
+
+	KeyCertsLoaded *atomic.Pointer[[]tls.Certificate] `sconf:"-" json:"-"` // Certificates for KeyCerts, in same order, used by Config. Replaced when a new certificate is uploaded through the admin web interface.
 }
 
 // todo: we could implement matching WebHandler.Domain as IPs too
@@ -32,6 +32,26 @@ The config files are in "sconf" format. Properties of sconf files:
 
 See https://pkg.go.dev/github.com/mjl-/sconf for details.
 
+# Secrets
+
+Some fields holding secrets can reference a value stored outside the config
+file, so config files can be kept in version control without containing
+secrets. These fields are the transport SMTP authentication passwords, the
+OAuth client secret and the MetricsPush remote-write password and bearer token.
+DKIM private keys and the admin password hash are stored in separate files, but
+the PrivateKeyFile and AdminPasswordFile fields can also reference a value with
+env: or vault:. References are resolved when the config file is loaded.
+
+  - file:<path> for the contents of a file, without trailing newlines. Relative
+    paths are interpreted relative to the directory of the config file. The file
+    must be readable by the mox user.
+  - env:<name> for the value of an environment variable.
+  - vault:<path>#<field> for a field from a HashiCorp Vault secret, fetched from
+    $VAULT_ADDR/v1/<path> with the token from $VAULT_TOKEN. Both KV version 1
+    and 2 secrets are supported, e.g. vault:secret/data/mox#smtppassword.
+
+Values starting with one of these prefixes are always treated as references.
+
 # mox.conf
 
 	# NOTE: This config file is in 'sconf' format. Indent with tabs. Comments must be
@@ -93,12 +113,72 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			CertFiles:
 				-
 
+	# S/MIME signatures of incoming messages are verified at delivery. The result is
+	# added to the Authentication-Results header, stored with the message, and shown
+	# in the webmail. PGP/MIME signatures are verified at delivery with the keys in
+	# the OpenPGP keyring of the receiving account and the published OpenPGP keys of
+	# local accounts. Signed and encrypted messages get IMAP keywords $Signed and
+	# $Encrypted, and $SignatureValid or $SignatureInvalid for the verification
+	# result. (optional)
+	SMIME:
+
+		# Certificate Authorities for verifying signer certificates. If absent, the system
+		# CA certificates are used. (optional)
+		CA:
+
+			# (optional)
+			AdditionalToSystem: false
+
+			# (optional)
+			CertFiles:
+				-
+
+	# External OAuth 2.0/OpenID Connect identity provider, for validating bearer
+	# tokens used with the OAUTHBEARER and XOAUTH2 authentication mechanisms for IMAP
+	# and SMTP submission. Tokens issued by mox itself, created on the account web
+	# page, are always accepted and don't need this configuration. (optional)
+	OAuth:
+
+		# URL of token introspection endpoint of the identity provider. Bearer tokens not
+		# issued by mox are sent to this endpoint for validation.
+		IntrospectionURL:
+
+		# Client ID for authenticating to the introspection endpoint with HTTP basic
+		# authentication.
+		ClientID:
+
+		# Client secret for authenticating to the introspection endpoint. Can be a secret
+		# reference like env:NAME, see "Secrets" in the config documentation.
+		ClientSecret:
+
+		# Field in the introspection response with the email address of the user, which
+		# must be an address of an account. Default: email. (optional)
+		UsernameClaim:
+
+		# Accepted values for the audience (aud) or client ID (client_id) of tokens, in
+		# addition to ClientID. Tokens are only accepted if they were issued for mox:
+		# their audience must include ClientID or one of these values, or they must have
+		# been requested by a client with one of these IDs. E.g. the identifier of mox as
+		# resource at the identity provider, or the client IDs of email applications that
+		# request tokens. (optional)
+		Audiences:
+			-
+
+		# URL of the OpenID Connect discovery document of the identity provider, e.g.
+		# https://id.example.org/.well-known/openid-configuration. Sent to clients in
+		# authentication failure responses, so they know where to request a new token.
+		# (optional)
+		OpenIDConfiguration:
+
 	# Automatic TLS configuration with ACME, e.g. through Let's Encrypt. The key is a
 	# name referenced in TLS configs, e.g. letsencrypt. (optional)
 	ACME:
 		x:
 
-			# For letsencrypt, use https://acme-v02.api.letsencrypt.org/directory.
+			# For letsencrypt, use https://acme-v02.api.letsencrypt.org/directory. For
+			# ZeroSSL, which requires external account binding, use
+			# https://acme.zerossl.com/v2/DV90. For Buypass, use
+			# https://api.buypass.com/acme/directory.
 			DirectoryURL:
 
 			# How long before expiration to renew the certificate. Default is 30 days.
@@ -122,9 +202,27 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 
 			# If set, used for suggested CAA DNS records, for restricting TLS certificate
 			# issuance to a Certificate Authority. If empty and DirectyURL is for Let's
-			# Encrypt, this value is set automatically to letsencrypt.org. (optional)
+			# Encrypt, ZeroSSL or Buypass, this value is set automatically to letsencrypt.org,
+			# sectigo.com or buypass.com. (optional)
 			IssuerDomainName:
 
+			# File with PEM-encoded CA certificates to verify the TLS certificate of the ACME
+			# provider with, instead of the system CA certificates. For internal ACME
+			# providers, such as Pebble or step-ca, with a certificate from a private CA. File
+			# is evaluated relative to the directory of mox.conf. (optional)
+			CACertFile:
+
+			# If non-zero, maximum number of new certificate orders within OrderLimitPeriod.
+			# Requests for new certificates beyond the limit fail until enough time has
+			# passed. Useful to stay below rate limits of the ACME provider, e.g. Let's
+			# Encrypt allows 300 new orders per 3 hours per account. Regardless of this limit,
+			# new orders are paused when the ACME provider responds with a rate limit error,
+			# until the time the provider indicates. (optional)
+			OrderLimit: 0
+
+			# Period for OrderLimit. Default is 3 hours. (optional)
+			OrderLimitPeriod: 0s
+
 			# ACME providers can require that a request for a new ACME account reference an
 			# existing non-ACME account known to the provider. External account binding
 			# references that account by a key id, and authorizes new ACME account requests by
@@ -142,9 +240,324 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				KeyFile:
 
 	# File containing hash of admin password, for authentication in the web admin
-	# pages (if enabled). (optional)
+	# pages (if enabled). Can also be an env: or vault: secret reference, see
+	# "Secrets" in the config documentation, in which case the password cannot be
+	# changed with "mox setadminpassword". (optional)
 	AdminPasswordFile:
 
+	# File containing the secret for two-factor authentication with time-based
+	# one-time passwords (TOTP) and hashes of recovery codes, for the web admin pages.
+	# Managed with "mox setadmintotp". If the file exists, a TOTP code is required
+	# when logging in to the web admin pages. (optional)
+	AdminTOTPFile:
+
+	# If set, logging in to the web admin pages is only possible with two-factor
+	# authentication, i.e. the AdminTOTPFile must exist. (optional)
+	AdminRequireTOTP: false
+
+	# Lock out IPs and accounts after many failed authentication attempts, for all
+	# protocols and web interfaces. Failed attempts and lockouts are stored in the
+	# database, and persist across restarts. This is in addition to the always-enabled
+	# rate limiting of failed authentication attempts per IP, which is only kept in
+	# memory. Lockouts can be listed and cleared with "mox authlockout list" and "mox
+	# authlockout clear" and in the admin web interface. (optional)
+	AuthLockout:
+
+		# Number of failed authentication attempts from an IP address within IPWindow
+		# after which authentication attempts from the IP are refused for IPDuration. IPv6
+		# addresses are grouped per /64 network. Zero disables IP lockouts. (optional)
+		IPFailures: 0
+
+		# Period in which failed attempts from an IP are counted. Default 1 hour.
+		# (optional)
+		IPWindow: 0s
+
+		# How long an IP is locked out. Default 24 hours. (optional)
+		IPDuration: 0s
+
+		# Number of failed authentication attempts for an account, from any IP, within
+		# AccountWindow after which all authentication attempts for the account fail for
+		# AccountDuration, even with valid credentials. Attempts for the admin web
+		# interface count for the account "(admin)", or "(tenant <name>)" for tenant
+		# admins. Zero disables account lockouts. Keep in mind that account lockouts also
+		# lock out the legitimate user, and can be triggered by anyone who knows an email
+		# address of the account. (optional)
+		AccountFailures: 0
+
+		# Period in which failed attempts for an account are counted. Default 1 hour.
+		# (optional)
+		AccountWindow: 0s
+
+		# How long an account is locked out. Default 1 hour. (optional)
+		AccountDuration: 0s
+
+	# Write authentication events in a stable, machine-parseable format to a file
+	# and/or unix domain socket, for external tools like fail2ban and CrowdSec that
+	# block IPs of attackers. Each event is a single line with space-separated
+	# key=value pairs, with values quoted if needed: time, event (authfail or authok),
+	# ip, protocol, mech, result, account, address, useragent. The ip field always
+	# comes before any client-provided data. See "mox config example fail2ban" for an
+	# example fail2ban configuration. (optional)
+	AuthEvents:
+
+		# File to append events to. Relative paths are relative to the data directory. The
+		# file is opened for each batch of events, so it can be rotated by moving it away.
+		# When an absolute path is used, e.g. in /var/log/, the file or directory must be
+		# writable by the mox user. (optional)
+		File:
+
+		# Path of unix domain socket to listen on. Relative paths are relative to the data
+		# directory. Clients that connect receive events as lines as they happen. Events
+		# are dropped for clients that do not keep up. (optional)
+		UnixSocket:
+
+		# Also write successful authentications, with event authok. By default, only
+		# failed attempts are written, with event authfail. (optional)
+		Successes: false
+
+	# Periodically push metrics to an external monitoring service, for installations
+	# where Prometheus cannot scrape the metrics endpoint, e.g. when behind NAT.
+	# Metrics are also still available on the MetricsHTTP endpoint of listeners, if
+	# enabled. (optional)
+	MetricsPush:
+
+		# Interval between pushes. Default 1 minute. (optional)
+		Interval: 0s
+
+		# Labels added to all metrics. Labels job with value mox and instance with the
+		# hostname are always added, but can be overridden here. (optional)
+		Labels:
+			x:
+
+		# Push with the Prometheus remote-write protocol, as supported by Prometheus,
+		# Grafana Cloud, Mimir, VictoriaMetrics and others. (optional)
+		RemoteWrite:
+
+			# URL of remote-write endpoint, e.g. https://prometheus.example.org/api/v1/write.
+			URL:
+
+			# For HTTP basic authentication. (optional)
+			Username:
+
+			# For HTTP basic authentication. Can be a secret reference like env:NAME, see
+			# "Secrets" in the config documentation. (optional)
+			Password:
+
+			# For authentication with an Authorization header with bearer token, instead of
+			# HTTP basic authentication. Can be a secret reference like env:NAME, see
+			# "Secrets" in the config documentation. (optional)
+			BearerToken:
+
+		# Send metrics to a StatsD server over UDP. Prometheus counters are sent as StatsD
+		# counters with the increase since the previous push, gauges as gauges. Histograms
+		# and summaries are sent as their count and sum. (optional)
+		StatsD:
+
+			# Host and UDP port of StatsD server, e.g. localhost:8125.
+			Address:
+
+			# Prefix for metric names, e.g. mox. with a trailing dot. (optional)
+			Prefix:
+
+			# Send labels as DogStatsD-style tags, as understood by DogStatsD, Telegraf and
+			# others. By default, label values are appended to the metric name, separated by
+			# dots. (optional)
+			Tags: false
+
+	# Make backups from the running instance on a schedule, like "mox backup", with
+	# consistent snapshots of the databases. Each backup is made in a new directory.
+	# Old backups are removed according to the retention settings. Metrics with the
+	# time of the last successful backup are exported, for monitoring. (optional)
+	BackupSchedule:
+
+		# When to make backups, in cron-like syntax with 5 space-separated fields: minute
+		# (0-59), hour (0-23), day of month (1-31), month (1-12) and day of week (0-6, 0
+		# is Sunday). Each field is a "*", or a comma-separated list of numbers and ranges
+		# like "1-5", optionally with a step like "0-59/15" for every 15 minutes. In local
+		# time. E.g. "30 3 * * *" for every day at 03:30.
+		Schedule:
+
+		# Local directory in which a new directory is created for each backup, named after
+		# the start time of the backup, e.g. 20261017-033000. Relative paths are relative
+		# to the data directory. Within the data directory, only directories under "tmp"
+		# are allowed, e.g. "tmp/backups", where message files can be hardlinked instead
+		# of copied. Remote destinations are not supported.
+		Directory:
+
+		# Number of days for which the most recent successful backup of the day is kept.
+		# Default 7. (optional)
+		KeepDaily: 0
+
+		# Number of weeks, starting on Monday, for which the most recent successful backup
+		# of the week is kept, in addition to the daily backups. Default 0. (optional)
+		KeepWeekly: 0
+
+		# If set, message and database files that are unchanged since the most recent
+		# successful backup are hardlinked to the files in that backup instead of copied,
+		# like "mox backup -linkdest". Useful when Directory is on another file system
+		# than the data directory. (optional)
+		LinkDest: false
+
+	# Scan incoming messages for viruses with clamd, the ClamAV daemon. Messages with
+	# a virus are rejected or quarantined. Scanning can be enabled or disabled per
+	# domain with VirusScan in the domain configuration. (optional)
+	ClamAV:
+
+		# Address of clamd: "unix:/path/to/clamd.sock" for a unix domain socket, or
+		# "inet:host:port" for TCP.
+		Address:
+
+		# Timeout for connecting to clamd and scanning a message. Default 1 minute.
+		# (optional)
+		Timeout: 0s
+
+		# What to do with messages with a virus: "reject" (default) refuses the message
+		# during the SMTP transaction, "quarantine" delivers the message to the Junk
+		# mailbox, with an X-Mox-Virus header. (optional)
+		Action:
+
+		# Messages larger than this size in bytes are not scanned. Should not be larger
+		# than StreamMaxLength in clamd.conf, or scans fail. Default 25MB, the clamd
+		# default. (optional)
+		MaxSize: 0
+
+		# If set, messages that could not be scanned, e.g. because clamd is not running,
+		# are accepted. By default, such messages are refused with a temporary SMTP error,
+		# so the remote server tries again later. (optional)
+		FailOpen: false
+
+		# If set, only messages for domains with VirusScan "enabled" are scanned. By
+		# default, messages for all domains are scanned, except domains with VirusScan
+		# "disabled". (optional)
+		DefaultDisabled: false
+
+	# Classify incoming messages as junk with rspamd, through its HTTP protocol,
+	# instead of or in addition to the builtin junk filter of accounts. Like the
+	# builtin junk filter, rspamd is only consulted for messages from senders without
+	# a conclusive reputation. Rspamd actions reject, soft reject, add header and
+	# rewrite subject cause the message to be treated as junk, i.e. rejected and
+	# stored in the Rejects mailbox. Actions no action and greylist cause the message
+	# to be accepted, mox does not do greylisting. (optional)
+	Rspamd:
+
+		# Base URL of the rspamd normal worker, e.g. http://localhost:11333. Messages are
+		# checked with a POST request to /checkv2.
+		URL:
+
+		# If set, sent in the Password header, for the password configured in rspamd for
+		# the worker. Can be a secret reference like env:NAME, see "Secrets" in the config
+		# documentation. (optional)
+		Password:
+
+		# Timeout for checking a message. Default 30s. (optional)
+		Timeout: 0s
+
+		# How rspamd is combined with the builtin junk filter of accounts: "replace"
+		# (default) uses only rspamd, "combine" uses both, treating a message as junk if
+		# either classifies it as junk. (optional)
+		Mode:
+
+		# If set, messages that could not be checked, e.g. because rspamd is not running,
+		# are analyzed as if rspamd was not configured, using the builtin junk filter if
+		# configured. By default, such messages are refused with a temporary SMTP error,
+		# so the remote server tries again later. (optional)
+		FailOpen: false
+
+	# Limits for parsing messages, protecting against excessive memory and CPU use for
+	# malicious messages, e.g. when analyzing incoming messages and for IMAP
+	# BODYSTRUCTURE. Incoming SMTP messages exceeding the limits are rejected with a
+	# 552 response. Other messages exceeding the limits, e.g. already stored or
+	# imported, are treated as a single non-multipart part. The defaults are well
+	# above what legitimate messages use. (optional)
+	MessageLimits:
+
+		# Maximum number of header fields in the header of a message or MIME part. Default
+		# 1000. (optional)
+		MaxHeaderFields: 0
+
+		# Maximum size in bytes of the header of a message or MIME part. Default 1MB.
+		# (optional)
+		MaxHeaderSize: 0
+
+		# Maximum nesting depth of multiparts and embedded messages. Default 50.
+		# (optional)
+		MaxDepth: 0
+
+		# Maximum number of MIME parts in a message, including parts of embedded messages.
+		# Default 10000. (optional)
+		MaxParts: 0
+
+		# Maximum total size in bytes of decoded embedded messages (e.g. forwarded
+		# messages, or returned messages in DSNs) that are held in memory while parsing a
+		# message. Embedded messages with base64 or quoted-printable transfer encoding are
+		# decoded for parsing. Default 100MB. (optional)
+		MaxDecodedSize: 0
+
+	# Compatibility workarounds for IMAP clients, selected by the client name and
+	# version sent with the IMAP ID command. The first matching entry is applied to
+	# the connection. Clients that do not send an ID command, or send it only after
+	# the workaround would matter, are not affected. Client names and versions are
+	# logged, and sessions are counted per client name in metric
+	# mox_imap_client_sessions_total. (optional)
+	IMAPClientQuirks:
+		-
+
+			# Case-insensitive substring of the client name from the IMAP ID command, e.g.
+			# "Outlook" or "iPhone Mail".
+			ClientName:
+
+			# If set, only clients with a version starting with this value match, e.g. "16.".
+			# (optional)
+			ClientVersion:
+
+			# IMAP capabilities (upper-case) to disable for matching clients, e.g. CONDSTORE,
+			# QRESYNC, UTF8=ACCEPT. Like IMAPCapabilitiesDisabled for accounts, the
+			# capabilities are no longer announced and cannot be enabled with the ENABLE
+			# command. (optional)
+			CapabilitiesDisabled:
+				-
+
+			# Do not send untagged FETCH responses with flag changes made by other sessions
+			# while a mailbox is selected. For clients that get confused by unsolicited FETCH
+			# responses. Flags are synchronized the next time the client fetches them.
+			# (optional)
+			NoUnsolicitedFlags: false
+
+	# Detection of incoming messages that loop between mail servers, e.g. due to
+	# forwarding rules at two mail servers pointing at each other. Looping messages
+	# are rejected with a 554 5.4.6 response. When absent, loop detection is enabled
+	# with default limits. (optional)
+	LoopDetection:
+
+		# Maximum number of Received headers in an incoming message, each added by a mail
+		# server the message passed through. Default 100. (optional)
+		MaxHops: 0
+
+		# Maximum number of earlier incoming deliveries of a message through this host,
+		# recognized by the IDs in Received headers added by mox. Received headers added
+		# during submission are not counted. Default 2. (optional)
+		MaxOwnHops: 0
+
+	# If set, files of newly delivered messages are stored compressed with zstd.
+	# Messages are compressed in independent blocks of 128KB, and only the blocks that
+	# are read are decompressed, one block at a time. Message sizes and quota are
+	# based on the uncompressed size. Existing messages can be compressed with "mox
+	# compressmessages". (optional)
+	MessageCompression:
+
+		# Only compress message files of at least this many bytes. Small messages hardly
+		# compress, and reading them would require decompression. Default 0, compressing
+		# all messages. (optional)
+		MinSize: 0
+
+	# If set, message files with identical contents, e.g. for a message delivered to
+	# multiple local recipients or imported multiple times, are stored only once.
+	# Message files are hard links to files in the msgdedup directory in the data
+	# directory, named after the SHA-256 hash of their contents. Files no longer used
+	# by any message are removed daily. Requires a file system with hard links, and on
+	# Windows unused files are not removed. (optional)
+	MessageDedup: false
+
 	# Listeners are groups of IP addresses and services enabled on those IP addresses,
 	# such as SMTP/IMAP or internal endpoints for administration or Prometheus
 	# metrics. All listeners with SMTP/IMAP services enabled will serve all configured
@@ -164,6 +577,17 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			IPs:
 				-
 
+			# If set, each enabled service of this listener also listens on a unix domain
+			# socket in this directory, e.g. for a proxy in front of mox on the same machine.
+			# Sockets are named after the protocol and port, e.g. smtp-25.sock,
+			# submission-587.sock, imaps-993.sock, http-80.sock and https-443.sock. The
+			# directory is created if needed, relative paths are relative to the data
+			# directory. Sockets are owned by the mox user and group, with mode 0660.
+			# Connections on unix domain sockets are treated as coming from 127.0.0.1,
+			# including for access control, rate limiting and reputation of incoming messages.
+			# (optional)
+			UnixSocketDir:
+
 			# If set, the mail server is configured behind a NAT and field IPs are internal
 			# instead of the public IPs, while NATIPs lists the public IPs. Used during
 			# IP-related DNS self-checks, such as for iprev, mx, spf, autoconfig,
@@ -181,6 +605,66 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			# (optional)
 			Hostname:
 
+			# Restrict which remote IPs can connect to any of the services of this listener.
+			# Connections from other IPs are closed immediately after accepting, e.g. before
+			# the SMTP banner is sent. For HTTP services behind a reverse proxy, this applies
+			# to the IP of the proxy. See IPAccess of individual services for restricting a
+			# single service. (optional)
+			IPAccess:
+
+				# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+				# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+				# (optional)
+				Allow:
+					-
+
+				# IP addresses or networks in CIDR notation that are refused. Takes precedence
+				# over Allow. (optional)
+				Deny:
+					-
+
+			# Refuse authentication mechanisms that send the password, or a weakly protected
+			# derivation of it, over the connection: PLAIN, LOGIN and CRAM-MD5, including the
+			# IMAP LOGIN and POP3 USER/PASS commands. Applies to SMTP submission, IMAP, POP3
+			# and ManageSieve of this listener. Only SCRAM, OAUTHBEARER/XOAUTH2 and EXTERNAL
+			# (TLS client certificates) are announced and accepted. Login to the web
+			# interfaces is not affected. If set for all listeners with services that accept
+			# password authentication, CRAM-MD5 secrets are no longer stored for accounts, and
+			# existing CRAM-MD5 secrets are removed from account databases. (optional)
+			NoPlaintextAuth: false
+
+			# Customize the greetings sent to clients of SMTP, IMAP, POP3 and ManageSieve on
+			# this listener. E.g. when compliance scans flag disclosure of the software name
+			# or version, or when a specific host name must be announced in the SMTP greeting.
+			# (optional)
+			Greeting:
+
+				# Host name in the SMTP greeting, instead of the hostname of the listener. Host
+				# names in EHLO responses and Received headers are not changed. (optional)
+				Hostname:
+
+				# Text in the SMTP greeting after the host name and ESMTP. Default "mox", or empty
+				# with HideSoftware. (optional)
+				SMTP:
+
+				# Text in the IMAP greeting after the capabilities. Default "mox imap", or "imap
+				# ready" with HideSoftware. (optional)
+				IMAP:
+
+				# Text in the POP3 greeting. Default "mox pop3", or "pop3 ready" with
+				# HideSoftware. (optional)
+				POP3:
+
+				# Text in the ManageSieve greeting after the capabilities. Default "mox
+				# managesieve ready", or "managesieve ready" with HideSoftware. (optional)
+				ManageSieve:
+
+				# Do not reveal the software name and version. Default greetings become generic,
+				# IMAP ID commands get an empty response instead of the name and, for
+				# authenticated clients, the version, and the IMPLEMENTATION capability of POP3 is
+				# left out and of ManageSieve is generic. (optional)
+				HideSoftware: false
+
 			# For SMTP/IMAP STARTTLS, direct TLS and HTTPS connections. (optional)
 			TLS:
 
@@ -222,6 +706,39 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# clients that don't handle TLS client authentication well. (optional)
 				ClientAuthDisabled: false
 
+				# Cipher suites allowed for TLS 1.2 and lower, in Go naming, e.g.
+				# TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. The order is not significant, the TLS
+				# implementation orders the cipher suites. Only cipher suites without known
+				# security issues can be configured. Default: the secure cipher suites of the Go
+				# TLS implementation. Cipher suites for TLS 1.3 cannot be configured. (optional)
+				CipherSuites:
+					-
+
+				# For HTTPS connections, the protocols that can be negotiated with TLS Application
+				# Layer Protocol Negotiation. Valid values: h2 (HTTP/2), http/1.1, smtp and imap
+				# (if enabled on HTTPS, see Submissions and IMAPS). For example, only http/1.1
+				# disables HTTP/2. Protocol acme-tls/1 for ACME certificate requests is always
+				# allowed. Default: all enabled protocols. (optional)
+				ALPNProtocols:
+					-
+
+				# Disable TLS session tickets for resuming TLS sessions. See also
+				# TLSSessionTicketsDisabled under SMTP, which overrides this setting for incoming
+				# SMTP connections. (optional)
+				SessionTicketsDisabled: false
+
+				# Interval for rotating the keys that encrypt TLS session tickets. The previous 7
+				# keys remain valid for resuming sessions. Keys are only kept in memory, a restart
+				# invalidates existing session tickets. Default: 24h. (optional)
+				SessionTicketKeyRotation: 0s
+
+				# Disable OCSP stapling. By default, for certificates with an OCSP responder, a
+				# signed OCSP response with the certificate status is fetched periodically and
+				# included in the TLS handshake, so clients don't have to contact the OCSP
+				# responder of the certificate authority. Responses are fetched in the background,
+				# TLS handshakes never wait for them. (optional)
+				OCSPStaplingDisabled: false
+
 			# Maximum size in bytes for incoming and outgoing messages. Default is 100MB.
 			# (optional)
 			SMTPMaxMessageSize: 0
@@ -253,16 +770,41 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# consulted for connections/messages without enough reputation to make an
 				# accept/reject decision. This prevents sending IPs of all communications to the
 				# block list provider. If any of the listed DNSBLs contains a requested IP
-				# address, the message is rejected as spam. The DNSBLs are checked for healthiness
-				# before use, at most once per 4 hours. IPs we can send from are periodically
-				# checked for being in the configured DNSBLs. See MonitorDNSBLs in domains.conf to
-				# only monitor IPs we send from, without using those DNSBLs for incoming messages.
-				# Example DNSBLs: sbl.spamhaus.org, bl.spamcop.net. See
-				# https://www.spamhaus.org/sbl/ and https://www.spamcop.net/ for more information
-				# and terms of use. (optional)
+				# address, the message is rejected as spam, unless DNSBLThreshold is set. The
+				# DNSBLs are checked for healthiness before use, at most once per 4 hours. IPs we
+				# can send from are periodically checked for being in the configured DNSBLs. See
+				# MonitorDNSBLs in domains.conf to only monitor IPs we send from, without using
+				# those DNSBLs for incoming messages. Example DNSBLs: sbl.spamhaus.org,
+				# bl.spamcop.net. See https://www.spamhaus.org/sbl/ and https://www.spamcop.net/
+				# for more information and terms of use. (optional)
 				DNSBLs:
 					-
 
+				# Weights for DNSBLs, keyed by zone as listed in DNSBLs, for use with
+				# DNSBLThreshold. DNSBLs without weight have weight 1. A weight of 0 can be used
+				# to evaluate a DNSBL, with its hits visible in metrics, without it affecting
+				# deliveries. (optional)
+				DNSBLWeights:
+					x: 0.000000
+
+				# If set, all DNSBLs are consulted and a message is only rejected if the sum of
+				# the weights of the DNSBLs listing the remote IP is at least this threshold. E.g.
+				# with threshold 2 and the default weight 1, an IP must be listed in at least two
+				# DNSBLs. Default 0, rejecting on the first DNSBL listing the IP. (optional)
+				DNSBLThreshold: 0.000000
+
+				# Zones of domain-based block lists for domains in URLs in incoming messages, e.g.
+				# dbl.spamhaus.org or multi.surbl.org. The hosts of http and https URLs in text
+				# and html parts of a message are reduced to their organizational domain, and up
+				# to 10 domains are looked up as domain prefixed to the zone. If any domain is
+				# listed, the message is rejected as spam. Like DNSBLs, URI block lists are only
+				# consulted for messages without enough reputation to make an accept/reject
+				# decision, and when the content is not already classified as junk. Many block
+				# lists require queries through your own resolver, not through public resolvers.
+				# See the terms of use of the block lists. (optional)
+				URIBLs:
+					-
+
 				# Delay before accepting a message from a first-time sender for the destination
 				# account. Default: 15s. (optional)
 				FirstTimeSenderDelay: 0s
@@ -271,6 +813,146 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# tickets may work around TLS interoperability issues. (optional)
 				TLSSessionTicketsDisabled: false
 
+				# Maximum number of recipients (RCPT TO commands) for a single message
+				# transaction. Additional recipients are rejected with a temporary error, the
+				# remote server will deliver to them in a next transaction. Announced with the
+				# LIMITS extension. RFC 5321 requires at least 100. Default 1000. (optional)
+				MaxRecipients: 0
+
+				# Maximum number of recipients (RCPT TO commands) over all message transactions in
+				# a single connection. Additional recipients are rejected with a temporary error,
+				# the remote server has to reconnect. Default 0, no limit. (optional)
+				MaxRecipientsPerConnection: 0
+
+				# Maximum number of unknown recipients per hour from a remote IP address, its /26
+				# network and its /21 network (for IPv6: /64, /48 and /32). Many unknown
+				# recipients indicate a dictionary attack trying to find valid addresses. When a
+				# limit is reached, further connections from the IP or network are refused until
+				# the hour has passed. If set, exactly three values must be specified. Default 50,
+				# 150, 450. (optional)
+				MaxUnknownRecipientsPerHour:
+					- 0
+
+				# Classic checks at EHLO/HELO time for incoming connections: on the hostname in
+				# the EHLO/HELO command, and on the reverse DNS of the remote IP. Each check can
+				# be set to log, to only log failures and count them in metrics, or enforce, to
+				# reject the EHLO/HELO command. Use log first to measure the effect before
+				# enforcing. (optional)
+				HELOChecks:
+
+					# Check that the EHLO/HELO hostname is a fully qualified domain name, i.e. has a
+					# dot. IP address literals are allowed. Empty (disabled), log or enforce.
+					# (optional)
+					RequireFQDN:
+
+					# Check that the EHLO/HELO hostname is not the hostname of the listener or mail
+					# server, or an IP address literal with the IP the connection was made to.
+					# Spammers sometimes claim to be the receiving server. Empty (disabled), log or
+					# enforce. (optional)
+					RejectOwnHostname:
+
+					# Check that the remote IP has forward-confirmed reverse DNS: a reverse DNS name
+					# that resolves back to the remote IP. Temporary DNS errors never fail the check.
+					# Empty (disabled), log or enforce. (optional)
+					RequireFCrDNS:
+
+				# Accept the XCLIENT and XFORWARD extensions (as implemented by Postfix) from
+				# trusted upstream servers in front of mox, e.g. a load balancer or filtering
+				# appliance. The IP address and EHLO/HELO hostname of the original client they
+				# pass on are used instead of those of the upstream server for SPF, iprev, DNSBL
+				# and EHLO/HELO checks, reputation, rate limiting, the Received header and
+				# logging. XCLIENT applies to the remainder of the connection, and resets the
+				# session: the upstream server must send EHLO/HELO again, for which a HELO from
+				# XCLIENT takes precedence. XFORWARD applies to the next message transaction only.
+				# Only the ADDR and HELO attributes are used, others like NAME and PORT are
+				# accepted and ignored. (optional)
+				XCLIENT:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64, of
+					# upstream servers that are allowed to use XCLIENT and XFORWARD. The extensions
+					# are only announced to connections from these IPs.
+					TrustedIPs:
+						-
+
+				# Restrict which remote IPs can connect for SMTP, in addition to IPAccess of the
+				# listener. E.g. to drop connections from known-abusive networks before the SMTP
+				# banner is sent. (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
+				# Policy, as embedded WebAssembly module or external service, to consult during
+				# incoming SMTP transactions, for local policies the built-in checks don't cover.
+				# The hook can reject, temporarily fail, quarantine or add headers to messages.
+				# (optional)
+				PolicyHook:
+
+					# Path to a WebAssembly module with the policy, executed by mox itself. Relative
+					# paths are relative to the config directory. At each of the Stages, the module is
+					# called with a JSON request describing the connection, transaction and for stage
+					# data the authentication results and message headers. The JSON response holds the
+					# decision: accept, reject, tempfail or quarantine, and optionally message headers
+					# to add. The module runs in a sandbox, without access to files, network or
+					# environment. See package policyhook for the format and the functions the module
+					# must export. Exactly one of WASM and URL must be set. (optional)
+					WASM:
+
+					# URL of an external policy service. Instead of calling a WebAssembly module, an
+					# HTTP POST request is made with the JSON request as body, and the service
+					# responds with the JSON response. (optional)
+					URL:
+
+					# For URL, if set, value for the Authorization header in requests, e.g. "Bearer
+					# ...". Can be a secret reference like env:NAME, see "Secrets" in the config
+					# documentation. (optional)
+					Authorization:
+
+					# Stages at which the hook is called: connect, mail, rcpt, data. Default all
+					# stages. (optional)
+					Stages:
+						-
+
+					# Timeout for a call of the WebAssembly module or request to the policy service.
+					# Default 10s. (optional)
+					Timeout: 0s
+
+					# If set, failures to get a valid response from the policy hook are ignored,
+					# continuing as if the hook accepted. By default, such failures result in a
+					# temporary SMTP error, so the remote server tries again later. (optional)
+					FailOpen: false
+
+				# External mail filters speaking the milter protocol, e.g. rspamd, clamav-milter
+				# or a custom filter, to inspect and modify incoming messages during the SMTP
+				# transaction. Milters are called in order at connect, EHLO/HELO, MAIL FROM, RCPT
+				# TO and after the message data, before mox's own analysis. They can reject,
+				# temporarily fail, discard or quarantine messages, add, change and delete message
+				# header fields, and replace the message body. Changes to the envelope sender and
+				# recipients are not supported. (optional)
+				Milters:
+					-
+
+						# Address of the milter: "unix:/path/to/socket" for a unix domain socket, or
+						# "inet:host:port" for TCP.
+						Address:
+
+						# Timeout for connecting to the milter, and for each of its responses. Default
+						# 30s. (optional)
+						Timeout: 0s
+
+						# If set, failures to connect or talk to the milter are ignored, continuing the
+						# connection without the milter. By default, failures result in a temporary SMTP
+						# error, so the remote server tries again later. (optional)
+						FailOpen: false
+
 			# SMTP for submitting email, e.g. by email applications. Starts out in plain text,
 			# can be upgraded to TLS with the STARTTLS command. Prefer using Submissions which
 			# is always a TLS connection. (optional)
@@ -284,6 +966,21 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# without encryption. Not recommended. (optional)
 				NoRequireSTARTTLS: false
 
+				# Restrict which remote IPs can connect, in addition to IPAccess of the listener.
+				# (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
 			# SMTP over TLS for submitting email, by email applications. Requires a TLS
 			# config. (optional)
 			Submissions:
@@ -300,6 +997,21 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# technique for Delta Chat. (optional)
 				EnabledOnHTTPS: false
 
+				# Restrict which remote IPs can connect, in addition to IPAccess of the listener.
+				# Also applies to submission on HTTPS. (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
 			# IMAP for reading email, by email applications. Starts out in plain text, can be
 			# upgraded to TLS with the STARTTLS command. Prefer using IMAPS instead which is
 			# always a TLS connection. (optional)
@@ -313,6 +1025,21 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# VPN). (optional)
 				NoRequireSTARTTLS: false
 
+				# Restrict which remote IPs can connect, in addition to IPAccess of the listener.
+				# (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
 			# IMAP over TLS for reading email, by email applications. Requires a TLS config.
 			# (optional)
 			IMAPS:
@@ -329,6 +1056,103 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# technique for Delta Chat. (optional)
 				EnabledOnHTTPS: false
 
+				# Restrict which remote IPs can connect, in addition to IPAccess of the listener.
+				# Also applies to IMAP on HTTPS. (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
+			# POP3 for retrieving email from the Inbox, for legacy devices and simple scripts.
+			# Starts out in plain text, can be upgraded to TLS with the STLS command. Prefer
+			# using POP3S instead which is always a TLS connection, or IMAP. (optional)
+			POP3:
+				Enabled: false
+
+				# Default 110. (optional)
+				Port: 0
+
+				# Enable this only when the connection is otherwise encrypted (e.g. through a
+				# VPN). (optional)
+				NoRequireSTARTTLS: false
+
+				# Restrict which remote IPs can connect, in addition to IPAccess of the listener.
+				# (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
+			# POP3 over TLS for retrieving email from the Inbox. Requires a TLS config.
+			# (optional)
+			POP3S:
+				Enabled: false
+
+				# Default 995. (optional)
+				Port: 0
+
+				# Restrict which remote IPs can connect, in addition to IPAccess of the listener.
+				# (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
+			# ManageSieve for uploading and activating Sieve filtering scripts, by email
+			# applications. Starts out in plain text, can be upgraded to TLS with the STARTTLS
+			# command. Scripts are validated when stored. The active script of an account is
+			# evaluated for incoming messages delivered over SMTP that don't match a ruleset
+			# of the destination, and can file messages into mailboxes, set flags, redirect,
+			# reject, discard and send vacation replies. (optional)
+			ManageSieve:
+				Enabled: false
+
+				# Default 4190. (optional)
+				Port: 0
+
+				# Enable this only when the connection is otherwise encrypted (e.g. through a
+				# VPN). (optional)
+				NoRequireSTARTTLS: false
+
+				# Restrict which remote IPs can connect, in addition to IPAccess of the listener.
+				# (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
 			# Account web interface, for email users wanting to change their accounts, e.g.
 			# set new password, set new delivery rulesets. Default path is /. (optional)
 			AccountHTTP:
@@ -346,6 +1170,23 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# limiting and for the "secure" status of cookies. (optional)
 				Forwarded: false
 
+				# Restrict which remote IPs can use this web interface, in addition to IPAccess of
+				# the listener. Requests from other IPs get a "403 - forbidden" response. If
+				# Forwarded is set, the IP from the X-Forwarded-For header is checked. E.g. to
+				# only allow access to the admin web interface from a VPN. (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
 			# Account web interface listener like AccountHTTP, but for HTTPS. Requires a TLS
 			# config. (optional)
 			AccountHTTPS:
@@ -363,6 +1204,23 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# limiting and for the "secure" status of cookies. (optional)
 				Forwarded: false
 
+				# Restrict which remote IPs can use this web interface, in addition to IPAccess of
+				# the listener. Requests from other IPs get a "403 - forbidden" response. If
+				# Forwarded is set, the IP from the X-Forwarded-For header is checked. E.g. to
+				# only allow access to the admin web interface from a VPN. (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
 			# Admin web interface, for managing domains, accounts, etc. Default path is
 			# /admin/. Preferably only enable on non-public IPs. Hint: use 'ssh -L
 			# 8080:localhost:80 you@yourmachine' and open http://localhost:8080/admin/, or set
@@ -383,6 +1241,23 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# limiting and for the "secure" status of cookies. (optional)
 				Forwarded: false
 
+				# Restrict which remote IPs can use this web interface, in addition to IPAccess of
+				# the listener. Requests from other IPs get a "403 - forbidden" response. If
+				# Forwarded is set, the IP from the X-Forwarded-For header is checked. E.g. to
+				# only allow access to the admin web interface from a VPN. (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
 			# Admin web interface listener like AdminHTTP, but for HTTPS. Requires a TLS
 			# config. (optional)
 			AdminHTTPS:
@@ -400,6 +1275,23 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# limiting and for the "secure" status of cookies. (optional)
 				Forwarded: false
 
+				# Restrict which remote IPs can use this web interface, in addition to IPAccess of
+				# the listener. Requests from other IPs get a "403 - forbidden" response. If
+				# Forwarded is set, the IP from the X-Forwarded-For header is checked. E.g. to
+				# only allow access to the admin web interface from a VPN. (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
 			# Webmail client, for reading email. Default path is /webmail/. (optional)
 			WebmailHTTP:
 				Enabled: false
@@ -416,6 +1308,23 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# limiting and for the "secure" status of cookies. (optional)
 				Forwarded: false
 
+				# Restrict which remote IPs can use this web interface, in addition to IPAccess of
+				# the listener. Requests from other IPs get a "403 - forbidden" response. If
+				# Forwarded is set, the IP from the X-Forwarded-For header is checked. E.g. to
+				# only allow access to the admin web interface from a VPN. (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
 			# Webmail client, like WebmailHTTP, but for HTTPS. Requires a TLS config.
 			# (optional)
 			WebmailHTTPS:
@@ -433,6 +1342,23 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# limiting and for the "secure" status of cookies. (optional)
 				Forwarded: false
 
+				# Restrict which remote IPs can use this web interface, in addition to IPAccess of
+				# the listener. Requests from other IPs get a "403 - forbidden" response. If
+				# Forwarded is set, the IP from the X-Forwarded-For header is checked. E.g. to
+				# only allow access to the admin web interface from a VPN. (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
 			# Like WebAPIHTTPS, but with plain HTTP, without TLS. (optional)
 			WebAPIHTTP:
 				Enabled: false
@@ -449,6 +1375,23 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# limiting and for the "secure" status of cookies. (optional)
 				Forwarded: false
 
+				# Restrict which remote IPs can use this web interface, in addition to IPAccess of
+				# the listener. Requests from other IPs get a "403 - forbidden" response. If
+				# Forwarded is set, the IP from the X-Forwarded-For header is checked. E.g. to
+				# only allow access to the admin web interface from a VPN. (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
 			# WebAPI, a simple HTTP/JSON-based API for email, with HTTPS (requires a TLS
 			# config). Default path is /webapi/. (optional)
 			WebAPIHTTPS:
@@ -466,6 +1409,23 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# limiting and for the "secure" status of cookies. (optional)
 				Forwarded: false
 
+				# Restrict which remote IPs can use this web interface, in addition to IPAccess of
+				# the listener. Requests from other IPs get a "403 - forbidden" response. If
+				# Forwarded is set, the IP from the X-Forwarded-For header is checked. E.g. to
+				# only allow access to the admin web interface from a VPN. (optional)
+				IPAccess:
+
+					# IP addresses or networks in CIDR notation, e.g. 10.8.0.0/24 or 2001:db8::/64,
+					# that are allowed to connect. If empty, all IPs not matching Deny are allowed.
+					# (optional)
+					Allow:
+						-
+
+					# IP addresses or networks in CIDR notation that are refused. Takes precedence
+					# over Allow. (optional)
+					Deny:
+						-
+
 			# Serve prometheus metrics, for monitoring. You should not enable this on a public
 			# IP. (optional)
 			MetricsHTTP:
@@ -520,6 +1480,26 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# limiting and logging. (optional)
 				Forwarded: false
 
+			# Serve OpenPGP public keys uploaded by accounts through the Web Key Directory
+			# (WKD), at https://openpgpkey.<domain>/.well-known/openpgpkey/, so email
+			# applications can find keys of correspondents. Requires a TLS config. (optional)
+			WKDHTTPS:
+				Enabled: false
+
+				# TLS port, 443 by default. You should only override this if you cannot listen on
+				# port 443 directly. WKD requests will be made to port 443, so you'll have to add
+				# an external mechanism to get the connection here, e.g. by configuring port
+				# forwarding. (optional)
+				Port: 0
+
+				# If set, plain HTTP instead of HTTPS is spoken on the configured port. Can be
+				# useful when the openpgpkey domain is reverse proxied. (optional)
+				NonTLS: false
+
+				# If set, X-Forwarded-* headers are used for the remote IP address for rate
+				# limiting and logging. (optional)
+				Forwarded: false
+
 			# All configured WebHandlers will serve on an enabled listener. (optional)
 			WebserverHTTP:
 				Enabled: false
@@ -638,6 +1618,9 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# If set, authentication credentials for the remote server. (optional)
 				Auth:
 					Username:
+
+					# Can be a secret reference like env:NAME, see "Secrets" in the config
+					# documentation.
 					Password:
 
 					# Allowed authentication mechanisms. Defaults to SCRAM-SHA-256-PLUS,
@@ -647,6 +1630,34 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					Mechanisms:
 						-
 
+				# Weight of Host when choosing among hosts with the same priority, see Hosts.
+				# Hosts with a higher weight are chosen more often. Default 1 if 0. (optional)
+				Weight: 0
+
+				# Additional hosts, for failover and load balancing. Hosts are tried in order of
+				# priority, lowest first, with Host having priority 0. Among hosts with the same
+				# priority, a host is chosen randomly, taking weights into account. If a
+				# connection to a host cannot be made, the next host is tried. The health of each
+				# host is tracked: a host that consistently fails is skipped for a while, and
+				# probed again later. All hosts must accept the same authentication credentials
+				# and present a TLS certificate valid for their host name. (optional)
+				Hosts:
+					-
+
+						# Host name to connect to and for verifying its TLS certificate.
+						Host:
+
+						# If unset or 0, the port of the transport is used. (optional)
+						Port: 0
+
+						# Hosts with a lower priority are tried first. Host of the transport has priority
+						# 0. (optional)
+						Priority: 0
+
+						# Weight when choosing among hosts with the same priority. Default 1 if 0.
+						# (optional)
+						Weight: 0
+
 			# Submission SMTP over a plain TCP connection (possibly with STARTTLS) to submit
 			# email to a remote queue. (optional)
 			Submission:
@@ -671,6 +1682,9 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# If set, authentication credentials for the remote server. (optional)
 				Auth:
 					Username:
+
+					# Can be a secret reference like env:NAME, see "Secrets" in the config
+					# documentation.
 					Password:
 
 					# Allowed authentication mechanisms. Defaults to SCRAM-SHA-256-PLUS,
@@ -680,6 +1694,34 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					Mechanisms:
 						-
 
+				# Weight of Host when choosing among hosts with the same priority, see Hosts.
+				# Hosts with a higher weight are chosen more often. Default 1 if 0. (optional)
+				Weight: 0
+
+				# Additional hosts, for failover and load balancing. Hosts are tried in order of
+				# priority, lowest first, with Host having priority 0. Among hosts with the same
+				# priority, a host is chosen randomly, taking weights into account. If a
+				# connection to a host cannot be made, the next host is tried. The health of each
+				# host is tracked: a host that consistently fails is skipped for a while, and
+				# probed again later. All hosts must accept the same authentication credentials
+				# and present a TLS certificate valid for their host name. (optional)
+				Hosts:
+					-
+
+						# Host name to connect to and for verifying its TLS certificate.
+						Host:
+
+						# If unset or 0, the port of the transport is used. (optional)
+						Port: 0
+
+						# Hosts with a lower priority are tried first. Host of the transport has priority
+						# 0. (optional)
+						Priority: 0
+
+						# Weight when choosing among hosts with the same priority. Default 1 if 0.
+						# (optional)
+						Weight: 0
+
 			# SMTP over a plain connection (possibly with STARTTLS), typically for
 			# old-fashioned unauthenticated relaying to a remote queue. (optional)
 			SMTP:
@@ -704,6 +1746,9 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# If set, authentication credentials for the remote server. (optional)
 				Auth:
 					Username:
+
+					# Can be a secret reference like env:NAME, see "Secrets" in the config
+					# documentation.
 					Password:
 
 					# Allowed authentication mechanisms. Defaults to SCRAM-SHA-256-PLUS,
@@ -713,6 +1758,34 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					Mechanisms:
 						-
 
+				# Weight of Host when choosing among hosts with the same priority, see Hosts.
+				# Hosts with a higher weight are chosen more often. Default 1 if 0. (optional)
+				Weight: 0
+
+				# Additional hosts, for failover and load balancing. Hosts are tried in order of
+				# priority, lowest first, with Host having priority 0. Among hosts with the same
+				# priority, a host is chosen randomly, taking weights into account. If a
+				# connection to a host cannot be made, the next host is tried. The health of each
+				# host is tracked: a host that consistently fails is skipped for a while, and
+				# probed again later. All hosts must accept the same authentication credentials
+				# and present a TLS certificate valid for their host name. (optional)
+				Hosts:
+					-
+
+						# Host name to connect to and for verifying its TLS certificate.
+						Host:
+
+						# If unset or 0, the port of the transport is used. (optional)
+						Port: 0
+
+						# Hosts with a lower priority are tried first. Host of the transport has priority
+						# 0. (optional)
+						Priority: 0
+
+						# Weight when choosing among hosts with the same priority. Default 1 if 0.
+						# (optional)
+						Weight: 0
+
 			# Like regular direct delivery, but makes outgoing connections through a SOCKS
 			# proxy. (optional)
 			Socks:
@@ -732,6 +1805,14 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# typically the hostname of the host in the Address field.
 				RemoteHostname:
 
+				# If set, the recipient domain is not looked up in DNS (no MX, CNAME, IP or TLSA
+				# records, and no MTA-STS policy). Instead, the SOCKS proxy is asked to connect to
+				# port 25 of the recipient domain by name, letting the proxy resolve the name. For
+				# domains that can only be resolved through the proxy, like .onion domains of the
+				# Tor network. Typically used with a route for a top-level domain, e.g. ToDomain
+				# .onion. TLS is opportunistic and not verified. (optional)
+				RemoteResolve: false
+
 			# Like regular direct delivery, but allows to tweak outgoing connections.
 			# (optional)
 			Direct:
@@ -744,6 +1825,14 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# remote SMTP servers. (optional)
 				DisableIPv6: false
 
+				# If set, delivery requires STARTTLS with a verified certificate, through DANE or
+				# otherwise PKIX/WebPKI verification of the MX host name, also when the recipient
+				# domain has no MTA-STS or DANE policy. Delivery attempts fail if verified TLS is
+				# not possible, and a DSN is sent to the sender after the last attempt. A
+				# "TLS-Required: No" header is ignored. Typically used with a route with ToDomain,
+				# requiring verified TLS for specific destination domains. (optional)
+				RequireVerifiedTLS: false
+
 			# Immediately fails the delivery attempt. (optional)
 			Fail:
 
@@ -821,6 +1910,23 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			# server is used for client configurations. Unicode name. (optional)
 			ClientSettingsDomain:
 
+			# Name of ACME provider from the static configuration to request TLS certificates
+			# with for the host names of this domain, i.e. autoconfig, mta-sts, openpgpkey and
+			# the client settings domain. If empty, the ACME provider of the listener is used.
+			# Useful for requesting certificates for some domains from another certificate
+			# authority, e.g. one that requires external account binding. (optional)
+			ACME:
+
+			# If set, this domain is an alias of the named (unicode) domain: messages to an
+			# address at this domain are delivered as if sent to the same localpart at the
+			# other domain, and accounts can log in and send messages with addresses at this
+			# domain. No addresses, aliases or destination patterns can be configured for this
+			# domain, and the localpart catchall separators and case-sensitivity of the other
+			# domain are used. This domain still has its own DKIM, DMARC, MTA-STS and TLSRPT
+			# configuration, e.g. for signing messages sent with this domain, and needs its
+			# own DNS records. (optional)
+			AliasOf:
+
 			# If not empty, only the string before the separator is used to for email delivery
 			# decisions. For example, if set to "+", you+anything@example.com will be
 			# delivered to you@example.com. (optional)
@@ -844,7 +1950,7 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 
 				# Emails can be DKIM signed. Config parameters are per selector. A DNS record must
 				# be created for each selector. Add the name to Sign to use the selector for
-				# signing messages.
+				# signing messages. (optional)
 				Selectors:
 					x:
 
@@ -874,13 +1980,32 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 						# hops/relays. In the order of days at least. (optional)
 						Expiration:
 
-						# Either an RSA or ed25519 private key file in PKCS8 PEM form.
+						# Either an RSA or ed25519 private key file in PKCS8 PEM form. Can also be an env:
+						# or vault: secret reference to the PEM data, see "Secrets" in the config
+						# documentation.
 						PrivateKeyFile:
 
 				# List of selectors that emails will be signed with. (optional)
 				Sign:
 					-
 
+				# If set, messages submitted by an account with a message From address in this
+				# domain, but with an SMTP MAIL FROM address in another domain configured on this
+				# server, are also signed with the DKIM selectors of the MAIL FROM domain, in
+				# addition to those of this domain. Receivers can then find a DKIM signature
+				# aligned with the envelope domain, e.g. for DSNs and reputation tracking of the
+				# MAIL FROM domain. By default, submitted messages are only signed for the message
+				# From domain. (optional)
+				SignMailFrom: false
+
+				# If set, messages submitted by an account with a message From address in this
+				# domain are also signed with the DKIM selectors of the domain of the hostname of
+				# this mail server (or its closest configured parent domain), as an additional
+				# signature by the sending host. Useful when relaying for domains whose reputation
+				# shouldn't be the only signal. The hostname domain must be configured on this
+				# server with DKIM selectors. (optional)
+				SignHostname: false
+
 			# With DMARC, a domain publishes, in DNS, a policy on how other mail servers
 			# should handle incoming messages with the From-header matching this domain and/or
 			# subdomain (depending on the configured alignment). Receiving mail servers use
@@ -981,6 +2106,30 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# Mailbox to deliver to, e.g. TLSRPT.
 				Mailbox:
 
+			# BIMI (Brand Indicators for Message Identification) lets a domain publish a logo
+			# in DNS, that receiving mail clients may show for messages that pass DMARC. Only
+			# used for generating the suggested DNS records, the DNS check, and optionally for
+			# adding a BIMI-Selector header to outgoing messages. BIMI requires a DMARC policy
+			# of quarantine or reject. Some mail providers only show logos with a Verified
+			# Mark Certificate (VMC). (optional)
+			BIMI:
+
+				# Selector for the BIMI DNS record, at <selector>._bimi.<domain>. Default:
+				# default. (optional)
+				Selector:
+
+				# HTTPS URL of the logo, in SVG Tiny Portable/Secure format.
+				Location:
+
+				# HTTPS URL of the PEM-encoded Verified Mark Certificate (VMC) for the logo.
+				# (optional)
+				Authority:
+
+				# If set, a BIMI-Selector header with the selector is added to outgoing
+				# DKIM-signed messages from this domain, and the header is included in the DKIM
+				# signatures. Only needed when the selector is not "default". (optional)
+				SelectorHeader: false
+
 			# Routes for delivering outgoing messages through the queue. Each delivery attempt
 			# evaluates account routes, these domain routes and finally global routes. The
 			# transport of the first matching route is used in the delivery attempt. If no
@@ -995,7 +2144,12 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					FromDomain:
 						-
 
-					# Like FromDomain, but matching against the envelope to domain. (optional)
+					# Like FromDomain, but matching against the envelope to domain. Can also hold
+					# address literals, e.g. [192.0.2.1] or [IPv6:2001:db8::1], to match recipient
+					# addresses with that IP. An empty address literal, [], matches all recipient
+					# addresses with an IP address instead of a domain. A top-level domain with a
+					# leading dot, like .onion, matches all domains under it, e.g. for routing to a
+					# SOCKS transport with RemoteResolve. (optional)
 					ToDomain:
 						-
 
@@ -1030,6 +2184,192 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					# message From header. (optional)
 					AllowMsgFrom: false
 
+			# Mailing lists with subscribers managed by mox. Keys are localparts (encoded, as
+			# they appear in email addresses). Messages to the list address are sent to all
+			# subscribers. Commands to subscribe and unsubscribe are sent to the request
+			# address of a list: its localpart with "-request" appended, e.g.
+			# discuss-request@example.org. Send a message with "help" as subject to the
+			# request address for the available commands. (optional)
+			Lists:
+				x:
+
+					# Account of the list owner. Notifications about messages held for moderation, and
+					# delivery failures and other messages with a null reverse path sent to the list
+					# and request addresses are delivered to the Inbox of this account. Outgoing list
+					# messages are queued on behalf of this account.
+					Account:
+
+					# Free-form description of the list, used in the List-Id header and messages to
+					# subscribers. (optional)
+					Description:
+
+					# Prefix for the subject of messages sent to subscribers, e.g. "[discuss]". Not
+					# added if the subject already contains the prefix. (optional)
+					SubjectPrefix:
+
+					# If true, anyone can post messages to the list. Otherwise only subscribers can
+					# post, based on the message From address, which must be DMARC-like-verified.
+					# Messages from others are held for moderation. (optional)
+					PostPublic: false
+
+					# If true, all messages to the list are held for moderation by the list owner.
+					# (optional)
+					Moderated: false
+
+			# Destinations for localparts matching a pattern, for addresses that are not
+			# explicitly configured as account destination or alias. Patterns are evaluated in
+			# order, the first match is used. A catchall destination for the domain is only
+			# used if no pattern matches. Useful for delivering many similar addresses, e.g.
+			# invoice-*@, to an account without configuring each address. (optional)
+			DestinationPatterns:
+				-
+
+					# Wildcard pattern for the localpart. A * matches zero or more characters, a ?
+					# matches a single character. For example "invoice-*". Patterns are matched
+					# against the localpart with catchall separators and anything after removed, and
+					# lower-cased unless the domain has case-sensitive localparts. Exactly one of
+					# Localpart and LocalpartRegexp must be set. (optional)
+					Localpart:
+
+					# Regular expression for the localpart, it must match the entire localpart. For
+					# example "(invoice|receipt)-[0-9]+". Matched against the same localpart as
+					# Localpart, so should be lower-case unless the domain has case-sensitive
+					# localparts. (optional)
+					LocalpartRegexp:
+
+					# Account to deliver matching messages to.
+					Account:
+
+					# Mailbox to deliver to. If empty, messages are delivered to Inbox. (optional)
+					Mailbox:
+
+			# Name of tenant this domain belongs to. Accounts with this domain as their Domain
+			# belong to the same tenant, and can only have addresses at domains of the tenant.
+			# If empty, the domain is not part of a tenant and can only be managed by the
+			# admin. (optional)
+			Tenant:
+
+			# Whether to scan incoming messages for this domain for viruses, if ClamAV is
+			# configured in mox.conf: "enabled" or "disabled". If empty, the default from the
+			# ClamAV configuration applies. (optional)
+			VirusScan:
+
+			# If set, messages for addresses at subdomains of this domain are accepted for
+			# delivery, e.g. for user@sales.example.org with example.org configured, without
+			# configuring each subdomain as a domain. The routing rules determine which
+			# address at this domain receives the message. Subdomains that are configured as
+			# domain themselves are not affected. Subdomains need an MX record, typically a
+			# wildcard, see the DNS records for the domain. Addresses at subdomains cannot be
+			# used for logging in or sending messages. (optional)
+			Subdomains:
+
+				# Rules for delivering messages for addresses at subdomains. Evaluated in order,
+				# the first matching rule is used. If no rule matches, the subdomain is treated as
+				# an unknown domain. If no rules are configured, all subdomains are accepted, with
+				# messages delivered to the same localpart at this domain. (optional)
+				Routes:
+					-
+
+						# Subdomain to match, relative to this domain. For example "sales" matches
+						# sales.example.org, and "*.eu" matches all subdomains of eu.example.org (but not
+						# eu.example.org itself). A single "*" matches all subdomains.
+						Subdomain:
+
+						# Where to deliver messages for matching subdomains. "localpart" (default)
+						# delivers to the same localpart at this domain, e.g. user@sales.example.org to
+						# user@example.org. "subdomain" delivers to the first label of the subdomain as
+						# localpart at this domain, e.g. anything@john.example.org to john@example.org.
+						# Otherwise, an email address at a configured domain to deliver all messages to.
+						# (optional)
+						Deliver:
+
+				# DMARC policy for subdomains: none, quarantine or reject. Used for the sp=
+				# subdomain policy in the suggested DMARC DNS record, and compared against the
+				# published record in the domain check. If empty, no sp= is suggested and the
+				# policy of this domain also applies to its subdomains. (optional)
+				DMARCPolicy:
+
+			# If set, the full contents of incoming messages for this domain that were
+			# rejected with a permanent error (5xx) after the DATA command, e.g. due to a
+			# DMARC reject policy or the junk filter, are retained for review by admins.
+			# Retained messages are stored separately from the Rejects mailboxes of accounts
+			# and are not visible to users. Admins can inspect them in the admin web
+			# interface, for finding false positives, and deliver them to their recipients
+			# after adjusting the policy that caused the rejection. (optional)
+			RejectedRetention:
+
+				# Retained messages older than this period are removed. Default 336h (14 days).
+				# (optional)
+				MaxAge: 0s
+
+				# Maximum total size in bytes of retained messages for this domain. When exceeded,
+				# the oldest messages are removed. Messages larger than a tenth of this size are
+				# not retained. Default 104857600 (100MB). (optional)
+				MaxSize: 0
+
+			# Custom text in SMTP responses for incoming messages to this domain that are
+			# rejected by the delivery policy, e.g. due to a bad sender reputation, junk
+			# content, DNS blocklists or SPF. By default, such messages are rejected with a
+			# generic "error processing", which does not reveal how messages are evaluated,
+			# but can confuse legitimate senders and support teams. (optional)
+			RejectText:
+
+				# URL where senders can get help with rejected messages, for placeholder
+				# {contact}, e.g. https://mox.example/mailhelp. (optional)
+				ContactURL:
+
+				# Template for rejections that would otherwise get the generic "error processing"
+				# text. Placeholders: {reason} for the reason of the rejection, as in the
+				# X-Mox-Reason header of messages in the Rejects mailbox, e.g. junk-content,
+				# dns-blocklisted, or a reputation method like msgfromfull or ip1 for rejections
+				# based on earlier messages; {contact} for ContactURL; {subjectpass} for the hint
+				# to resend with a token in the subject, only for reason give-subjectpass. E.g.
+				# "message refused ({reason}), see {contact}". Must be ASCII without control
+				# characters, and smaller than 256 bytes. (optional)
+				Default:
+
+				# Templates for specific reasons, keyed by reason, e.g. junk-content,
+				# dns-blocklisted, uri-blocklisted, dmarc-policy, spf-policy, iprev, rspamd,
+				# msgfromfull, ip1, give-subjectpass, high-rate. Overrides Default, and also
+				# applies to rejections with a more specific text than "error processing". For
+				# give-subjectpass, the hint is appended if the template does not contain
+				# {subjectpass}. (optional)
+				Reasons:
+					x:
+
+			# If set, the domain is being decommissioned, e.g. after moving to another domain.
+			# Incoming messages are rejected with a permanent error referring to the new
+			# address, optionally after a notice period in which messages are still delivered
+			# and senders get an automatic reply with the new address. Accounts with this
+			# domain as their default domain can still log in until the end of the grace
+			# period, e.g. to retrieve their messages over IMAP. An export of the messages of
+			# those accounts can be made with "mox config domain export". Configure with "mox
+			# config domain retire". (optional)
+			Retire:
+
+				# Domain that addresses of this domain have moved to. The new address for an
+				# address is the same localpart at this domain. Referenced in rejections and
+				# automatic replies. Rejections are sent with code 551 if set, and 550 otherwise.
+				# (optional)
+				NewDomain:
+
+				# Text for SMTP rejections and automatic replies, instead of the default text. Any
+				# "{newaddress}" is replaced with the new address of the recipient. Must be ASCII
+				# without control characters, and smaller than 256 bytes. (optional)
+				Message:
+
+				# Date, as 2006-01-02 or RFC3339 timestamp, from which incoming messages are
+				# rejected. Before, messages are delivered as usual, and senders get an automatic
+				# reply about the address change, at most once per week per sender. If empty,
+				# messages are rejected immediately. (optional)
+				RejectAfter:
+
+				# Date, as 2006-01-02 or RFC3339 timestamp, from which accounts with this domain
+				# as their default domain can no longer log in. Logins are disabled by setting
+				# LoginDisabled for those accounts, within an hour after this time. If empty,
+				# logins are not disabled. (optional)
+				LoginUntil:
+
 	# Accounts represent mox users, each with a password and email address(es) to
 	# which email can be delivered (possibly at different domains). Each account has
 	# its own on-disk directory holding its messages and index database. An account
@@ -1086,12 +2426,111 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			# retire time. E.g. 168h (1 week). (optional)
 			KeepRetiredWebhookPeriod: 0s
 
+			# Default priority for delivery of outgoing messages from this account, from -9
+			# (lowest, e.g. bulk/newsletters) to 9 (highest, e.g. transactional messages like
+			# password resets). When the queue is limited by its maximum number of concurrent
+			# deliveries, messages with a higher priority are delivered first. Submissions
+			# over SMTP can override the priority per message with the MT-PRIORITY extension.
+			# Default 0. (optional)
+			DeliveryPriority: 0
+
+			# If set, large attachments of messages composed in webmail are not included in
+			# the outgoing message, but stored on this server and replaced with an expiring
+			# link to download them, added to the message text. Keeps large files out of the
+			# mailboxes of recipients, and prevents rejections by remote servers with a lower
+			# maximum message size. The copy in the Sent mailbox also only has the links.
+			# Links are to webmail at https with the hostname of this server. Messages
+			# submitted over SMTP or through the webapi are not changed, their attachments are
+			# sent as is. (optional)
+			AttachmentLinks:
+
+				# Attachments of this size in bytes or larger are replaced with a link. E.g.
+				# 10485760 for 10MB.
+				MinSize: 0
+
+				# Number of days the links remain valid, after which the attachments are removed.
+				# Default 14, maximum 90. (optional)
+				ValidityDays: 0
+
+			# If set, a digest message with statistics about the past week is delivered to the
+			# Inbox shortly after the start of each week (Monday 00:00 UTC): the number of
+			# received messages and messages marked as junk, new senders, top senders, and
+			# storage used. (optional)
+			WeeklyDigest: false
+
+			# If set, incoming messages for an address with a subaddress, e.g.
+			# user+folder@example.org with "+" as catchall separator of the domain, are
+			# delivered to a mailbox named after the subaddress, e.g. "folder", creating the
+			# mailbox if needed. Only for top-level mailbox names that are not Inbox, the
+			# rejects mailbox or a special-use mailbox like Sent or Trash, otherwise the
+			# regular mailbox is used. Rulesets of the destination that match still take
+			# precedence. Messages rejected as junk don't create mailboxes. (optional)
+			SubaddressMailboxes: false
+
+			# Services to send a short notification to about incoming messages delivered over
+			# SMTP, with the sender, subject and mailbox, e.g. to get notified on a phone
+			# without keeping an IMAP connection open. Each notification can be limited to
+			# messages matching rules. Messages marked as junk or delivered to the rejects
+			# mailbox are not notified. Notifications are best-effort, failed requests are not
+			# retried. (optional)
+			Notifications:
+				-
+
+					# Kind of service: ntfy, gotify or webhook.
+					Type:
+
+					# For ntfy, the URL of the topic, e.g. https://ntfy.sh/mytopic. For gotify, the
+					# base URL of the server, e.g. https://gotify.example.org, messages are posted to
+					# /message. For webhook, the URL to POST a JSON object to, with fields Account,
+					# Mailbox, From, FromName, Subject, MessageID and Received.
+					URL:
+
+					# For ntfy, optional access token, sent as bearer token. For gotify, the
+					# application token, required. For webhook, optional value for the Authorization
+					# header. Can be a secret reference like env:NAME, see "Secrets" in the config
+					# documentation. (optional)
+					Token:
+
+					# If non-empty, only notify about messages delivered to one of these mailboxes,
+					# e.g. Inbox. (optional)
+					Mailboxes:
+						-
+
+					# If non-empty, only notify about messages with a message From address
+					# (lower-cased) matching this regular expression, e.g. @example\.com$. (optional)
+					FromRegexp:
+
+					# If non-empty, only notify about messages with a subject matching this regular
+					# expression, e.g. (?i)urgent. (optional)
+					SubjectRegexp:
+
 			# If non-empty, login attempts on all protocols (e.g. SMTP/IMAP, web interfaces)
 			# is rejected with this error message. Useful during migrations. Incoming
 			# deliveries for addresses of this account are still accepted as normal.
 			# (optional)
 			LoginDisabled:
 
+			# If set, email clients only get read-only access to the mailboxes and messages of
+			# this account over IMAP and POP3. Mailboxes are opened read-only, and changing
+			# flags, expunging/deleting, appending, copying/moving messages and changing
+			# mailboxes is refused. Useful for litigation holds, archived accounts of former
+			# employees, and freezes during a migration. Incoming deliveries are still
+			# accepted, and the web interfaces are not affected. (optional)
+			ReadOnly: false
+
+			# If non-empty, submitting messages for delivery (SMTP submission, webmail and
+			# webapi) is refused with this error message. Logins are still allowed, e.g. for
+			# reading email. (optional)
+			SubmissionDisabled:
+
+			# If non-empty, the account has moved to the mail server with this hostname, e.g.
+			# during a staged migration. IMAP logins with valid credentials are refused with a
+			# referral to the same username at this host (RFC 2221), so email clients can
+			# switch to the new server instead of failing. Incoming deliveries for addresses
+			# of this account are still accepted. To refer SMTP deliveries to a new address,
+			# configure an SMTPError with a 551 response on destinations. (optional)
+			IMAPReferralHost:
+
 			# Default domain for account. Deprecated behaviour: If a destination is not a full
 			# address but only a localpart, this domain is added to form a full address.
 			Domain:
@@ -1145,6 +2584,42 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 							HeadersRegexp:
 								x:
 
+							# Matches if the single address in the message From header matches one of these
+							# patterns. A '*' matches any sequence of characters, all other characters match
+							# literally. Matching is case-insensitive. E.g. '*@example.org' or
+							# 'notifications-*@*.example.org'. (optional)
+							MsgFromPatterns:
+								-
+
+							# Matches if the SPF result for the SMTP MAIL FROM domain is this value: pass,
+							# fail, softfail, neutral, none, temperror or permerror. (optional)
+							SPFResult:
+
+							# Matches if the message has at least one verified DKIM signature (value pass), or
+							# none (value none). (optional)
+							DKIMResult:
+
+							# Matches if the domain of the message From header is authenticated with an
+							# aligned SPF and/or DKIM pass, as used by DMARC (value pass), or not (value
+							# fail). (optional)
+							DMARCResult:
+
+							# Matches if the size of the message in bytes is at least this value. (optional)
+							MinSize: 0
+
+							# Matches if the size of the message in bytes is at most this value. (optional)
+							MaxSize: 0
+
+							# Matches if the message has attachments (value yes), or not (value no). Message
+							# parts with a Content-Disposition of attachment, or with a filename, are
+							# considered attachments. (optional)
+							Attachments:
+
+							# Matches if the List-Id header of the message has this list identifier, without
+							# angle brackets, e.g. name.lists.example.org. Matching is case-insensitive.
+							# (optional)
+							ListID:
+
 							# Influences spam filtering only, this option does not change whether a message
 							# matches this ruleset. Can only be used together with SMTPMailFromRegexp and
 							# VerifiedDomain. SMTPMailFromRegexp must be set to the address used to deliver
@@ -1178,9 +2653,27 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 							# that Rejects mailbox. (optional)
 							AcceptRejectsToMailbox:
 
-							# Mailbox to deliver to if this ruleset matches.
+							# Mailbox to deliver to if this ruleset matches. Required unless Discard is set.
+							# (optional)
 							Mailbox:
 
+							# Mark the delivered message as read. (optional)
+							MarkSeen: false
+
+							# Addresses to forward a copy of the message to, through the queue, with the
+							# address of this destination as SMTP MAIL FROM. The message is forwarded
+							# unmodified: A DKIM signature of the original sender may still verify, but SPF
+							# will not pass for the original MAIL FROM domain, so forwarded messages may fail
+							# DMARC checks at the recipient. Messages that are rejected or accepted as reject,
+							# and DSNs, are not forwarded. (optional)
+							ForwardTo:
+								-
+
+							# Accept the message during the SMTP transaction, but don't deliver it to a
+							# mailbox. Can be combined with ForwardTo, but not with Mailbox, MarkSeen or
+							# AcceptRejectsToMailbox. (optional)
+							Discard: false
+
 							# Free-form comments. (optional)
 							Comment:
 
@@ -1189,10 +2682,12 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					# address is configured for the domain and messages to some addresses should be
 					# rejected. The response line must start with an error code. Currently the
 					# following error resonse codes are allowed: 421 (temporary local error), 550
-					# (user not found). If the line consists of only an error code, an appropriate
-					# error message is added. Rejecting messages with a 4xx code invites later retries
-					# by the remote, while 5xx codes should prevent further delivery attempts.
-					# (optional)
+					# (user not found), 551 (user not local, the message must be the new email
+					# address, e.g. '551 user@example.org', and is referenced in the response, useful
+					# when an account has moved). If the line consists of only an error code, an
+					# appropriate error message is added. Rejecting messages with a 4xx code invites
+					# later retries by the remote, while 5xx codes should prevent further delivery
+					# attempts. (optional)
 					SMTPError:
 
 					# If non-empty, an additional DMARC-like message authentication check is done for
@@ -1210,6 +2705,24 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					# address with webmail. (optional)
 					FullName:
 
+					# Forward incoming messages for this address to these email addresses, typically
+					# at other domains. The SMTP MAIL FROM of forwarded messages is rewritten with SRS
+					# (Sender Rewriting Scheme) to an address at the domain of this destination, so
+					# SPF checks at the receiving mail server can pass, and delivery status
+					# notifications (DSNs) for forwarded messages are sent on to the original sender.
+					# Messages classified as junk are not forwarded. Forwarded messages are delivered
+					# through the queue, like other outgoing messages. Forwarding loops between local
+					# addresses (including through aliases and rulesets) are configuration errors.
+					# Messages with a Delivered-To header for this address, i.e. that were forwarded
+					# for this address before and came back, are delivered locally instead of
+					# forwarded again. (optional)
+					ForwardTo:
+						-
+
+					# If set, forwarded messages are also delivered to the mailbox of this
+					# destination. By default, forwarded messages are not kept. (optional)
+					ForwardKeepCopy: false
+
 			# If configured, messages classified as weakly spam are rejected with instructions
 			# to retry delivery, but this time with a signed token added to the subject.
 			# During the next delivery attempt, the signed token will bypass the spam filter.
@@ -1240,6 +2753,44 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			# (optional)
 			KeepRejects: false
 
+			# When a message is moved out of the RejectsMailbox, automatically add a sender
+			# allow entry for the message From address (value "address") or its domain (value
+			# "domain"). Later messages from allowed senders with a verified From address (SPF
+			# and/or DKIM aligned per DMARC) are accepted without reputation and content
+			# analysis. If empty, no allow entry is added automatically, but the webmail
+			# offers to add one for messages in the rejects mailbox. Allow entries can be
+			# managed in the account web interface. (optional)
+			RejectsRescueAllow:
+
+			# Remove messages from mailboxes after they have been in the account for a while,
+			# e.g. from Trash after 30 days. Checked periodically in the background. Messages
+			# in the RejectsMailbox are always removed after 14 days unless KeepRejects is
+			# set. (optional)
+			MailboxRetention:
+				-
+
+					# Name of mailbox to remove old messages from, e.g. Trash. Child mailboxes are not
+					# included.
+					Mailbox:
+
+					# Messages received longer ago than this period are removed, e.g. 720h for 30
+					# days. At least 1h.
+					Period: 0s
+
+			# If set, messages older than a configured period are automatically moved from the
+			# Inbox to a per-year archive mailbox, e.g. Archive/2024. Checked periodically in
+			# the background. (optional)
+			AutoArchive:
+
+				# Messages in the Inbox received longer ago than this period are moved to the
+				# archive mailbox for the year they were received in, e.g. 2160h for 90 days. At
+				# least 24h.
+				Period: 0s
+
+				# Parent mailbox of the per-year archive mailboxes, which are created as needed.
+				# Default: Archive. (optional)
+				Mailbox:
+
 			# Automatically set $Junk and $NotJunk flags based on mailbox messages are
 			# delivered/moved/copied to. Email clients typically have too limited
 			# functionality to conveniently set these flags, especially $NonJunk, but they can
@@ -1311,11 +2862,27 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			# this mail server in case of account compromise. Default 200. (optional)
 			MaxFirstTimeRecipientsPerDay: 0
 
+			# If set, messages submitted while MaxOutgoingMessagesPerDay or
+			# MaxFirstTimeRecipientsPerDay is reached are accepted and held in the queue for
+			# review by the admin, instead of being refused. Held messages have a hold reason,
+			# and can be inspected, released for delivery or failed (sending a DSN) by the
+			# admin. Useful for handling a possibly compromised account gently, without
+			# refusing legitimate bursts of messages. If too many messages of the account are
+			# already held for review (1000), messages are refused again. (optional)
+			HoldOverSendLimits: false
+
 			# Do not apply a delay to SMTP connections before accepting an incoming message
 			# from a first-time sender. Can be useful for accounts that sends automated
 			# responses and want instant replies. (optional)
 			NoFirstTimeSenderDelay: false
 
+			# If set, incoming messages for a mailbox that does not exist, e.g. configured in
+			# a ruleset or destination, or through SubaddressMailboxes, are delivered to the
+			# Inbox instead. By default, such mailboxes are created, with a special-use flag
+			# if the mailbox name is configured as special-use mailbox in InitialMailboxes in
+			# mox.conf (and no other mailbox has the flag). (optional)
+			NoAutoCreateMailboxes: false
+
 			# If set, this account cannot set a password of their own choice, but can only set
 			# a new randomly generated password, preventing password reuse across services and
 			# use of weak passwords. Custom account passwords can be set by the admin.
@@ -1342,7 +2909,12 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					FromDomain:
 						-
 
-					# Like FromDomain, but matching against the envelope to domain. (optional)
+					# Like FromDomain, but matching against the envelope to domain. Can also hold
+					# address literals, e.g. [192.0.2.1] or [IPv6:2001:db8::1], to match recipient
+					# addresses with that IP. An empty address literal, [], matches all recipient
+					# addresses with an IP address instead of a domain. A top-level domain with a
+					# leading dot, like .onion, matches all domains under it, e.g. for routing to a
+					# SOCKS transport with RemoteResolve. (optional)
 					ToDomain:
 						-
 
@@ -1352,6 +2924,39 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					MinimumAttempts: 0
 					Transport:
 
+	# Tenants group domains, and the accounts at those domains, for delegating
+	# administration, e.g. per customer of a hosting provider. Each tenant has its own
+	# admin password, set with "mox tenant setadminpassword", for logging in to the
+	# admin web interface with the tenant name as username, and only managing the
+	# domains and accounts of the tenant. Tenants can have their own sending limits
+	# and IPs for outgoing connections. Metrics about messages are kept per tenant.
+	# Keys are tenant names, consisting of lower-case letters, digits and dashes.
+	# (optional)
+	Tenants:
+		x:
+
+			# Free-form description of tenant, e.g. the name of the customer. (optional)
+			Description:
+
+			# Default and maximum for MaxOutgoingMessagesPerDay of accounts of this tenant.
+			# Accounts with a higher or no configured limit get this limit. If zero, the
+			# account limits apply. (optional)
+			MaxOutgoingMessagesPerDay: 0
+
+			# Default and maximum for MaxFirstTimeRecipientsPerDay of accounts of this tenant.
+			# Accounts with a higher or no configured limit get this limit. If zero, the
+			# account limits apply. (optional)
+			MaxFirstTimeRecipientsPerDay: 0
+
+			# IPs to use as source address for direct delivery of messages from accounts of
+			# this tenant, instead of the IPs of the SMTP listeners. For each delivery
+			# attempt, an IP of the address family of the remote host is randomly selected
+			# from this pool. The IPs must be configured on the machine. Make sure the IPs
+			# have matching reverse DNS and are included in the SPF records of the domains of
+			# the tenant. (optional)
+			OutgoingIPs:
+				-
+
 	# Redirect all requests from domain (key) to domain (value). Always redirects to
 	# HTTPS. For plain HTTP redirects, use a WebHandler with a WebRedirect. (optional)
 	WebDomainRedirects:
@@ -1503,7 +3108,12 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			FromDomain:
 				-
 
-			# Like FromDomain, but matching against the envelope to domain. (optional)
+			# Like FromDomain, but matching against the envelope to domain. Can also hold
+			# address literals, e.g. [192.0.2.1] or [IPv6:2001:db8::1], to match recipient
+			# addresses with that IP. An empty address literal, [], matches all recipient
+			# addresses with an IP address instead of a domain. A top-level domain with a
+			# leading dot, like .onion, matches all domains under it, e.g. for routing to a
+			# SOCKS transport with RemoteResolve. (optional)
 			ToDomain:
 				-
 
@@ -1521,6 +3131,33 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 	MonitorDNSBLs:
 		-
 
+	# Self-tests of outgoing delivery. For each canary, a probe message is
+	# periodically sent through the queue, typically from one of our domains to
+	# another. The probe must arrive within a deadline with passing SPF, DKIM and
+	# DMARC. Results are exported as prometheus metrics, e.g. mox_canary_ok, to detect
+	# delivery problems that would otherwise go unnoticed. Keys are names of canaries,
+	# used in metrics. (optional)
+	Canaries:
+		x:
+
+			# Address to send probes from. Must be an address of an account, at a domain with
+			# DKIM configured, so probes can pass DKIM and DMARC.
+			From:
+
+			# Address to send probes to. Typically an address of an account at another of our
+			# domains. Probes are delivered through the queue and SMTP like any outgoing
+			# message, but are not stored in the mailbox of the recipient. Can also be an
+			# external address that replies to the From address keeping the subject, such as
+			# an echo service; SPF, DKIM and DMARC are then checked for the reply.
+			To:
+
+			# How often to send a probe. Default 1h, minimum 5m. (optional)
+			Interval: 0s
+
+			# How long after sending a probe must arrive. Default 15m. Must be smaller than
+			# Interval. Probes not arrived in time are removed from the queue. (optional)
+			Deadline: 0s
+
 # Examples
 
 Mox includes configuration files to illustrate common setups. You can see these
@@ -1653,6 +3290,35 @@ config example <name>". Below are all examples included in mox.
 	Routes:
 		-
 			Transport: Example
+
+# Example fail2ban
+
+	# Snippet for mox.conf, writing failed authentication attempts to a file in the
+	# data directory:
+
+	AuthEvents:
+		File: authevents.log
+
+
+	# Fail2ban filter, e.g. /etc/fail2ban/filter.d/mox.conf:
+
+	[Definition]
+	failregex = ^time=\S+ event=authfail ip=<HOST>
+	ignoreregex =
+	datepattern = ^time=%%Y-%%m-%%dT%%H:%%M:%%S%%z
+
+
+	# Fail2ban jail, e.g. /etc/fail2ban/jail.d/mox.conf. Adjust logpath to the data
+	# directory of mox.
+
+	[mox]
+	enabled = true
+	filter = mox
+	logpath = /home/mox/data/authevents.log
+	port = 25,465,587,143,993,110,995,4190,80,443
+	maxretry = 10
+	findtime = 1h
+	bantime = 1d
 */
 package config
 
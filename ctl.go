@@ -1,9 +1,12 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -322,6 +325,25 @@ func servectlcmd(ctx context.Context, xctl *ctl, cid int64, shutdown func()) {
 		shutdown()
 		os.Exit(0)
 
+	case "drain":
+		/* The protocol, double quoted are literals.
+
+		> "drain"
+		> deadline, as go duration
+		< status line, multiple times
+		< "ok"
+		(connection is closed at exit after shutdown)
+		*/
+
+		deadline, err := time.ParseDuration(xctl.xread())
+		xctl.xcheck(err, "parsing deadline")
+		drain(log, deadline, func(msg string) {
+			xctl.xwrite(msg)
+		})
+		xctl.xwriteok()
+		shutdown()
+		os.Exit(0)
+
 	case "deliver":
 		/* The protocol, double quoted are literals.
 
@@ -482,6 +504,9 @@ func servectlcmd(ctx context.Context, xctl *ctl, cid int64, shutdown func()) {
 				lastAttempt = time.Since(*qm.LastAttempt).Round(time.Second).String()
 			}
 			fmt.Fprintf(xw, "%5d %s from:%s to:%s next %s last %s error %q\n", qm.ID, qm.Queued.Format(time.RFC3339), qm.Sender().LogString(), qm.Recipient().LogString(), -time.Since(qm.NextAttempt).Round(time.Second), lastAttempt, qm.LastResult().Error)
+			if qm.HoldReason != "" {
+				fmt.Fprintf(xw, "      held for review: %s\n", qm.HoldReason)
+			}
 		}
 		if len(qmsgs) == 0 {
 			fmt.Fprint(xw, "(none)\n")
@@ -581,6 +606,24 @@ func servectlcmd(ctx context.Context, xctl *ctl, cid int64, shutdown func()) {
 		xctl.xwriteok()
 		xctl.xwrite(fmt.Sprintf("%d", count))
 
+	case "queuerequireverifiedtls":
+		/* protocol:
+		> "queuerequireverifiedtls"
+		> queuefilters as json
+		> "true" or "false"
+		< "ok" or error
+		< count
+		*/
+
+		filterline := xctl.xread()
+		required := xctl.xread() == "true"
+		var f queue.Filter
+		xparseJSON(xctl, filterline, &f)
+		count, err := queue.RequireVerifiedTLSSet(ctx, f, required)
+		xctl.xcheck(err, "setting verified tls requirement on messages in queue")
+		xctl.xwriteok()
+		xctl.xwrite(fmt.Sprintf("%d", count))
+
 	case "queuefail":
 		/* protocol:
 		> "queuefail"
@@ -967,6 +1010,29 @@ func servectlcmd(ctx context.Context, xctl *ctl, cid int64, shutdown func()) {
 		mbox := cmd == "importmbox"
 		ximportctl(ctx, xctl, mbox)
 
+	case "exportimap":
+		/* protocol:
+		> "exportimap"
+		> account
+		> export options as json
+		< "ok" or error
+		< message id
+		*/
+		account := xctl.xread()
+		optsline := xctl.xread()
+		var opts store.ExportOpts
+		xparseJSON(xctl, optsline, &opts)
+		acc, err := store.OpenAccount(log, account, false)
+		xctl.xcheck(err, "open account")
+		defer func() {
+			err := acc.Close()
+			log.Check(err, "closing account after export")
+		}()
+		m, err := acc.ExportToMailbox(ctx, log, opts)
+		xctl.xcheck(err, "exporting to mailbox")
+		xctl.xwriteok()
+		xctl.xwrite(fmt.Sprintf("%d", m.ID))
+
 	case "domainadd":
 		/* protocol:
 		> "domainadd"
@@ -974,6 +1040,7 @@ func servectlcmd(ctx context.Context, xctl *ctl, cid int64, shutdown func()) {
 		> domain
 		> account
 		> localpart
+		> aliasof, domain or empty
 		< "ok" or error
 		*/
 		var disabled bool
@@ -989,9 +1056,15 @@ func servectlcmd(ctx context.Context, xctl *ctl, cid int64, shutdown func()) {
 		domain := xctl.xread()
 		account := xctl.xread()
 		localpart := xctl.xread()
+		aliasOf := xctl.xread()
 		d, err := dns.ParseDomain(domain)
 		xctl.xcheck(err, "parsing domain")
-		err = admin.DomainAdd(ctx, disabled, d, account, smtp.Localpart(localpart))
+		var aliasOfDomain dns.Domain
+		if aliasOf != "" {
+			aliasOfDomain, err = dns.ParseDomain(aliasOf)
+			xctl.xcheck(err, "parsing alias of domain")
+		}
+		err = admin.DomainAdd(ctx, disabled, d, account, smtp.Localpart(localpart), aliasOfDomain)
 		xctl.xcheck(err, "adding domain")
 		xctl.xwriteok()
 
@@ -1032,6 +1105,52 @@ func servectlcmd(ctx context.Context, xctl *ctl, cid int64, shutdown func()) {
 		xctl.xcheck(err, "saving domain")
 		xctl.xwriteok()
 
+	case "domainretire":
+		/* protocol:
+		> "domainretire"
+		> domain
+		> retire settings as json, "null" to stop retiring
+		< "ok" or error
+		*/
+		domain := xctl.xread()
+		line := xctl.xread()
+		d, err := dns.ParseDomain(domain)
+		xctl.xcheck(err, "parsing domain")
+		var retire *config.DomainRetire
+		err = json.Unmarshal([]byte(line), &retire)
+		xctl.xcheck(err, "parsing retire settings")
+		err = admin.DomainRetire(ctx, d, retire)
+		xctl.xcheck(err, "saving domain")
+		xctl.xwriteok()
+
+	case "domainexport":
+		/* protocol:
+		> "domainexport"
+		> domain
+		< "ok" or error
+		< stream, gzipped tar file
+		*/
+		domain := xctl.xread()
+		d, err := dns.ParseDomain(domain)
+		xctl.xcheck(err, "parsing domain")
+		if _, ok := mox.Conf.Domain(d); !ok {
+			xctl.xerror("domain not present")
+		}
+		xctl.xwriteok()
+		xw := xctl.writer()
+		bw := bufio.NewWriterSize(xw, 256*1024)
+		gzw := gzip.NewWriter(bw)
+		tw := tar.NewWriter(gzw)
+		err = admin.DomainExport(ctx, log, d, store.TarArchiver{Writer: tw})
+		xctl.xcheck(err, "exporting domain")
+		err = tw.Close()
+		xctl.xcheck(err, "closing tar")
+		err = gzw.Close()
+		xctl.xcheck(err, "closing gzip")
+		err = bw.Flush()
+		xctl.xcheck(err, "flushing export")
+		xw.xclose()
+
 	case "accountadd":
 		/* protocol:
 		> "accountadd"
@@ -1115,6 +1234,191 @@ func servectlcmd(ctx context.Context, xctl *ctl, cid int64, shutdown func()) {
 		xctl.xcheck(err, "enabling account")
 		xctl.xwriteok()
 
+	case "adminwebauthnlist":
+		/* protocol:
+		> "adminwebauthnlist"
+		< "ok" or error
+		< stream
+		*/
+		creds, err := store.AdminWebAuthnCredentialList(ctx)
+		xctl.xcheck(err, "list admin webauthn credentials")
+		xctl.xwriteok()
+		xw := xctl.writer()
+		fmt.Fprintf(xw, "# id, host name, created, last used, name (%d)\n", len(creds))
+		for _, c := range creds {
+			var lastUsed string
+			if !c.LastUsed.IsZero() {
+				lastUsed = c.LastUsed.Format(time.RFC3339)
+			}
+			fmt.Fprintf(xw, "%d\t%s\t%s\t%s\t%q\n", c.ID, c.RPID, c.Created.Format(time.RFC3339), lastUsed, c.Name)
+		}
+		xw.xclose()
+
+	case "adminwebauthnreset":
+		/* protocol:
+		> "adminwebauthnreset"
+		< "ok" or error
+		< number of removed credentials
+		*/
+		n, err := store.AdminWebAuthnCredentialRemoveAll(ctx)
+		xctl.xcheck(err, "removing admin webauthn credentials")
+		log.Info("admin webauthn credentials reset", slog.Int("removed", n))
+		xctl.xwriteok()
+		xctl.xwrite(fmt.Sprintf("%d", n))
+
+	case "authlockoutlist":
+		/* protocol:
+		> "authlockoutlist"
+		< "ok" or error
+		< stream
+		*/
+		l, err := store.AuthLockoutList(ctx)
+		xctl.xcheck(err, "list auth lockouts")
+		xctl.xwriteok()
+		xw := xctl.writer()
+		now := time.Now()
+		fmt.Fprintf(xw, "# ip or account, failures, last failure, locked until (%d)\n", len(l))
+		for _, lo := range l {
+			name := lo.IP
+			if name == "" {
+				name = lo.Account
+			}
+			var until string
+			if now.Before(lo.Until) {
+				until = lo.Until.Format(time.RFC3339)
+			}
+			fmt.Fprintf(xw, "%s\t%d\t%s\t%s\n", name, lo.Failures, lo.Last.Format(time.RFC3339), until)
+		}
+		xw.xclose()
+
+	case "authlockoutclear":
+		/* protocol:
+		> "authlockoutclear"
+		> ip or account, empty for all
+		< "ok" or error
+		< number of cleared records
+		*/
+		ipOrAccount := xctl.xread()
+		n, err := store.AuthLockoutClear(ctx, ipOrAccount)
+		xctl.xcheck(err, "clearing auth lockouts")
+		log.Info("auth lockouts cleared", slog.String("iporaccount", ipOrAccount), slog.Int("removed", n))
+		xctl.xwriteok()
+		xctl.xwrite(fmt.Sprintf("%d", n))
+
+	case "capturestart":
+		/* protocol:
+		> "capturestart"
+		> ip or account
+		> protocols, comma-separated, empty for all
+		> "true" or "false" for recording message data
+		> duration
+		> max connections
+		< "ok" or error
+		< path of capture file
+		*/
+		ipOrAccount := xctl.xread()
+		protocolstr := xctl.xread()
+		datastr := xctl.xread()
+		durationstr := xctl.xread()
+		maxconnsstr := xctl.xread()
+		var protocols []string
+		if protocolstr != "" {
+			protocols = strings.Split(protocolstr, ",")
+		}
+		duration, err := time.ParseDuration(durationstr)
+		xctl.xcheck(err, "parsing duration")
+		maxConns, err := strconv.Atoi(maxconnsstr)
+		xctl.xcheck(err, "parsing max connections")
+		c, err := mox.CaptureStart(log, ipOrAccount, protocols, datastr == "true", duration, maxConns)
+		xctl.xcheck(err, "starting capture")
+		xctl.xwriteok()
+		xctl.xwrite(c.Path)
+
+	case "capturelist":
+		/* protocol:
+		> "capturelist"
+		< "ok"
+		< stream
+		*/
+		l := mox.CaptureList()
+		xctl.xwriteok()
+		xw := xctl.writer()
+		fmt.Fprintf(xw, "# id, ip or account, protocols, until, connections, size, path (%d)\n", len(l))
+		for _, c := range l {
+			name := c.IP
+			if name == "" {
+				name = c.Account
+			}
+			protocols := strings.Join(c.Protocols, ",")
+			if protocols == "" {
+				protocols = "all"
+			}
+			fmt.Fprintf(xw, "%d\t%s\t%s\t%s\t%d/%d\t%d\t%s\n", c.ID, name, protocols, c.Until.Format(time.RFC3339), c.Conns, c.MaxConns, c.Size, c.Path)
+		}
+		xw.xclose()
+
+	case "capturestop":
+		/* protocol:
+		> "capturestop"
+		> id, 0 for all
+		< "ok" or error
+		< number of stopped captures
+		*/
+		id, err := strconv.ParseInt(xctl.xread(), 10, 64)
+		xctl.xcheck(err, "parsing id")
+		n := mox.CaptureStop(log, id)
+		xctl.xwriteok()
+		xctl.xwrite(fmt.Sprintf("%d", n))
+
+	case "listsubscribers", "listsubscribe", "listunsubscribe":
+		/* protocol:
+		> "listsubscribers", "listsubscribe" or "listunsubscribe"
+		> list address
+		> subscriber address, only for subscribe and unsubscribe
+		< "ok" or error
+		< stream, only for listsubscribers
+		*/
+		listAddress := xctl.xread()
+		var address string
+		if cmd != "listsubscribers" {
+			address = xctl.xread()
+		}
+		addr, err := smtp.ParseAddress(listAddress)
+		xctl.xcheck(err, "parsing list address")
+		l, request, ok := mox.LookupList(addr.Localpart, addr.Domain)
+		if !ok || request {
+			xctl.xcheck(errors.New("no such list"), "looking up list")
+		}
+		laddr, _ := l.Addresses()
+		list := laddr.Pack(true)
+		switch cmd {
+		case "listsubscribers":
+			subs, err := store.ListSubscriberList(ctx, list)
+			xctl.xcheck(err, "listing subscribers")
+			xctl.xwriteok()
+			xw := xctl.writer()
+			for _, s := range subs {
+				fmt.Fprintf(xw, "%s\t%s\n", s.Address, s.Created.Format(time.RFC3339))
+			}
+			xw.xclose()
+		case "listsubscribe":
+			saddr, err := smtp.ParseAddress(address)
+			xctl.xcheck(err, "parsing subscriber address")
+			added, err := store.ListSubscribe(ctx, list, saddr.Pack(true))
+			xctl.xcheck(err, "subscribing")
+			if !added {
+				xctl.xcheck(errors.New("address already subscribed"), "subscribing")
+			}
+			xctl.xwriteok()
+		case "listunsubscribe":
+			removed, err := store.ListUnsubscribe(ctx, list, address)
+			xctl.xcheck(err, "unsubscribing")
+			if !removed {
+				xctl.xcheck(errors.New("address not subscribed"), "unsubscribing")
+			}
+			xctl.xwriteok()
+		}
+
 	case "tlspubkeylist":
 		/* protocol:
 		> "tlspubkeylist"
@@ -1203,6 +1507,25 @@ func servectlcmd(ctx context.Context, xctl *ctl, cid int64, shutdown func()) {
 		xctl.xcheck(err, "removing tls public key")
 		xctl.xwriteok()
 
+	case "openpgpkeylist":
+		/* protocol:
+		> "openpgpkeylist"
+		> domain
+		< "ok" or error
+		< stream
+		*/
+		domain := xctl.xread()
+		d, err := dns.ParseDomain(domain)
+		xctl.xcheck(err, "parsing domain")
+		keys, err := store.OpenPGPKeyListDomain(ctx, d.ASCII)
+		xctl.xcheck(err, "list openpgp keys")
+		xctl.xwriteok()
+		xw := xctl.writer()
+		for _, k := range keys {
+			fmt.Fprintf(xw, "%s\t%s\t%s\t%s\n", k.Address, k.Account, k.Fingerprint, base64.StdEncoding.EncodeToString(k.KeyData))
+		}
+		xw.xclose()
+
 	case "addressadd":
 		/* protocol:
 		> "addressadd"
@@ -1517,6 +1840,73 @@ func servectlcmd(ctx context.Context, xctl *ctl, cid int64, shutdown func()) {
 		}
 		xctl.xwriteok()
 
+	case "junkexplain":
+		/* protocol:
+		> "junkexplain"
+		> account
+		> message id
+		< "ok" or error
+		< stream
+		*/
+		account := xctl.xread()
+		idstr := xctl.xread()
+		id, err := strconv.ParseInt(idstr, 10, 64)
+		xctl.xcheck(err, "parsing message id")
+
+		acc, err := store.OpenAccount(log, account, false)
+		xctl.xcheck(err, "open account")
+		defer func() {
+			err := acc.Close()
+			log.Check(err, "closing account")
+		}()
+
+		m := store.Message{ID: id}
+		err = acc.DB.Get(ctx, &m)
+		if err == bstore.ErrAbsent || err == nil && m.Expunged {
+			err = errors.New("no such message")
+		}
+		xctl.xcheck(err, "looking up message")
+
+		// Messages delivered before classifications were stored, or messages that were
+		// not classified, are classified with the current junk filter.
+		jc := m.JunkClassification
+		var current bool
+		if jc == nil {
+			f, jf, err := acc.OpenJunkFilter(ctx, log)
+			xctl.xcheck(err, "open junk filter")
+			defer func() {
+				err := f.Close()
+				log.Check(err, "closing junk filter")
+			}()
+			result, err := f.ClassifyMessageReader(ctx, acc.MessageReader(m), m.Size)
+			xctl.xcheck(err, "classifying message")
+			jc = store.NewJunkClassification(result.Probability > jf.Threshold, result, jf.Threshold, "")
+			current = true
+		}
+
+		xctl.xwriteok()
+		xw := xctl.writer()
+		if current {
+			fmt.Fprintln(xw, "# no classification stored with message, classified with current junk filter")
+		} else {
+			fmt.Fprintln(xw, "# classification during delivery")
+		}
+		fmt.Fprintf(xw, "junk flag: %v, notjunk flag: %v\n", m.Junk, m.Notjunk)
+		fmt.Fprint(xw, jc.String())
+		xw.xclose()
+
+	case "doctor":
+		/* protocol:
+		> "doctor"
+		< "ok"
+		< findings as json
+		*/
+		findings := doctorServer(ctx, log)
+		buf, err := json.Marshal(findings)
+		xctl.xcheck(err, "marshal findings")
+		xctl.xwriteok()
+		xctl.xwrite(string(buf))
+
 	case "recalculatemailboxcounts":
 		/* protocol:
 		> "recalculatemailboxcounts"
@@ -1625,7 +2015,7 @@ func servectlcmd(ctx context.Context, xctl *ctl, cid int64, shutdown func()) {
 							n++
 
 							p := acc.MessagePath(m.ID)
-							st, err := os.Stat(p)
+							filesize, err := store.MessageFileSize(p)
 							if err != nil {
 								mb := store.Mailbox{ID: m.MailboxID}
 								if xerr := tx.Get(&mb); xerr != nil {
@@ -1634,7 +2024,6 @@ func servectlcmd(ctx context.Context, xctl *ctl, cid int64, shutdown func()) {
 								fmt.Fprintf(xw, "checking file %s for message %d in mailbox %q (id %d): %v (continuing)\n", p, m.ID, mb.Name, mb.ID, err)
 								return nil
 							}
-							filesize := st.Size()
 							correctSize := int64(len(m.MsgPrefix)) + filesize
 							if m.Size == correctSize {
 								return nil
@@ -1713,6 +2102,48 @@ func servectlcmd(ctx context.Context, xctl *ctl, cid int64, shutdown func()) {
 
 		xw.xclose()
 
+	case "compressmessages":
+		/* protocol:
+		> "compressmessages"
+		> minsize
+		> account or empty
+		< "ok" or error
+		< stream
+		*/
+
+		minSize, err := strconv.ParseInt(xctl.xread(), 10, 64)
+		xctl.xcheck(err, "parsing minsize")
+		accountOpt := xctl.xread()
+		xctl.xwriteok()
+		xw := xctl.writer()
+
+		xcompress := func(accName string) {
+			acc, err := store.OpenAccount(log, accName, false)
+			xctl.xcheck(err, "open account")
+			defer func() {
+				err := acc.Close()
+				log.Check(err, "closing account after compressing messages")
+			}()
+
+			n, saved, err := acc.CompressMessages(ctx, log.With(slog.String("account", accName)), minSize)
+			xctl.xcheck(err, "compressing messages")
+			fmt.Fprintf(xw, "%d message file(s) compressed for account %s, %d bytes saved\n", n, accName, saved)
+		}
+
+		if accountOpt != "" {
+			xcompress(accountOpt)
+		} else {
+			for i, accName := range mox.Conf.Accounts() {
+				var line string
+				if i > 0 {
+					line = "\n"
+				}
+				fmt.Fprintf(xw, "%sCompressing messages in account %s...\n", line, accName)
+				xcompress(accName)
+			}
+		}
+		xw.xclose()
+
 	case "reparse":
 		/* protocol:
 		> "reparse"
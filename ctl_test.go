@@ -3,20 +3,29 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/ed25519"
 	cryptorand "crypto/rand"
 	"crypto/x509"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/mjl-/mox/backupdest"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/dns"
@@ -25,6 +34,7 @@ import (
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/mtastsdb"
 	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/rejectdb"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/tlsrptdb"
@@ -160,6 +170,11 @@ func TestCtl(t *testing.T) {
 		ctlcmdQueueRequireTLS(xctl, queue.Filter{}, nil)
 	})
 
+	// "queuerequireverifiedtls"
+	testctl(func(xctl *ctl) {
+		ctlcmdQueueRequireVerifiedTLS(xctl, queue.Filter{}, true)
+	})
+
 	// "queuedump"
 	testctl(func(xctl *ctl) {
 		ctlcmdQueueDump(xctl, fmt.Sprintf("%d", qmid))
@@ -274,7 +289,12 @@ func TestCtl(t *testing.T) {
 
 	// "domainadd"
 	testctl(func(xctl *ctl) {
-		ctlcmdConfigDomainAdd(xctl, false, dns.Domain{ASCII: "mox2.example"}, "mjl", "")
+		ctlcmdConfigDomainAdd(xctl, false, dns.Domain{ASCII: "mox2.example"}, "mjl", "", dns.Domain{})
+	})
+
+	// "domainadd" with alias of another domain.
+	testctl(func(xctl *ctl) {
+		ctlcmdConfigDomainAdd(xctl, false, dns.Domain{ASCII: "mox3.example"}, "mjl", "", dns.Domain{ASCII: "mox.example"})
 	})
 
 	// "accountadd"
@@ -296,6 +316,19 @@ func TestCtl(t *testing.T) {
 		ctlcmdRetrain(xctl, "mjl2")
 	})
 
+	// "junkexplain", classifying the delivered message with the current filter.
+	testctl(func(xctl *ctl) {
+		ctlcmdJunkExplain(xctl, "mjl2", 1)
+	})
+
+	// "doctor"
+	testctl(func(xctl *ctl) {
+		findings := ctlcmdDoctor(xctl)
+		if len(findings) == 0 {
+			t.Fatalf("no findings from doctor")
+		}
+	})
+
 	// "addressrm"
 	testctl(func(xctl *ctl) {
 		ctlcmdConfigAddressRemove(xctl, "mjl3@mox2.example")
@@ -315,6 +348,40 @@ func TestCtl(t *testing.T) {
 		ctlcmdConfigAccountDisabled(xctl, "mjl2", "")
 	})
 
+	// "domainretire", with grace period for logins over.
+	testctl(func(xctl *ctl) {
+		ctlcmdConfigDomainRetire(xctl, dns.Domain{ASCII: "mox2.example"}, &config.DomainRetire{NewDomain: "mox.example", RejectAfter: "2000-01-01", LoginUntil: "2000-01-01"})
+	})
+	if acc, _ := mox.Conf.Account("mjl2"); acc.LoginDisabled == "" {
+		t.Fatalf("login not disabled for account of retired domain")
+	}
+
+	// "domainexport"
+	testctl(func(xctl *ctl) {
+		var b bytes.Buffer
+		ctlcmdConfigDomainExport(xctl, dns.Domain{ASCII: "mox2.example"}, &b)
+		gzr, err := gzip.NewReader(&b)
+		tcheck(t, err, "gzip reader")
+		tr := tar.NewReader(gzr)
+		var names []string
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			tcheck(t, err, "reading tar")
+			names = append(names, h.Name)
+		}
+		if !slices.Contains(names, "domains.conf") || !slices.Contains(names, "accounts/mjl2/Inbox/cur/") {
+			t.Fatalf("missing files in export, got %v", names)
+		}
+	})
+
+	// "domainretire", stopping retirement.
+	testctl(func(xctl *ctl) {
+		ctlcmdConfigDomainRetire(xctl, dns.Domain{ASCII: "mox2.example"}, nil)
+	})
+
 	// "accountrm"
 	testctl(func(xctl *ctl) {
 		ctlcmdConfigAccountRemove(xctl, "mjl2")
@@ -332,6 +399,9 @@ func TestCtl(t *testing.T) {
 	testctl(func(xctl *ctl) {
 		ctlcmdConfigDomainRemove(xctl, dns.Domain{ASCII: "mox2.example"})
 	})
+	testctl(func(xctl *ctl) {
+		ctlcmdConfigDomainRemove(xctl, dns.Domain{ASCII: "mox3.example"})
+	})
 
 	// "aliasadd"
 	testctl(func(xctl *ctl) {
@@ -368,6 +438,21 @@ func TestCtl(t *testing.T) {
 		ctlcmdConfigAliasRemove(xctl, "support@mox.example")
 	})
 
+	// "listsubscribe"
+	testctl(func(xctl *ctl) {
+		ctlcmdListSubscribe(xctl, "listsubscribe", "discuss@mox.example", "remote@example.org")
+	})
+
+	// "listsubscribers"
+	testctl(func(xctl *ctl) {
+		ctlcmdListSubscribers(xctl, "discuss@mox.example")
+	})
+
+	// "listunsubscribe"
+	testctl(func(xctl *ctl) {
+		ctlcmdListSubscribe(xctl, "listunsubscribe", "discuss@mox.example", "remote@example.org")
+	})
+
 	// accounttlspubkeyadd
 	certDER := fakeCert(t)
 	testctl(func(xctl *ctl) {
@@ -405,6 +490,69 @@ func TestCtl(t *testing.T) {
 		t.Fatalf("got %d tls public keys, expected 0", len(tpkl))
 	}
 
+	// "adminwebauthnlist"
+	err = store.AdminWebAuthnCredentialAdd(ctxbg, &store.AdminWebAuthnCredential{Name: "test", RPID: "localhost", CredentialID: "AAAA", PublicKey: []byte{0}})
+	tcheck(t, err, "add admin webauthn credential")
+	testctl(func(xctl *ctl) {
+		ctlcmdAdminWebauthnList(xctl)
+	})
+
+	// "adminwebauthnreset"
+	testctl(func(xctl *ctl) {
+		ctlcmdAdminWebauthnReset(xctl)
+	})
+	creds, err := store.AdminWebAuthnCredentialList(ctxbg)
+	tcheck(t, err, "list admin webauthn credentials")
+	if len(creds) != 0 {
+		t.Fatalf("got %d admin webauthn credentials, expected 0", len(creds))
+	}
+
+	// "authlockoutlist"
+	err = store.AuthDB.Insert(ctxbg, &store.AuthLockout{Key: "ip 10.0.0.1", IP: "10.0.0.1", Failures: 5, WindowStart: time.Now(), Last: time.Now(), Until: time.Now().Add(time.Hour)})
+	tcheck(t, err, "add auth lockout")
+	testctl(func(xctl *ctl) {
+		ctlcmdAuthLockoutList(xctl)
+	})
+
+	// "authlockoutclear"
+	testctl(func(xctl *ctl) {
+		ctlcmdAuthLockoutClear(xctl, "10.0.0.1")
+	})
+	lockouts, err := store.AuthLockoutList(ctxbg)
+	tcheck(t, err, "list auth lockouts")
+	if len(lockouts) != 0 {
+		t.Fatalf("got %d auth lockouts, expected 0", len(lockouts))
+	}
+
+	// "capturestart"
+	testctl(func(xctl *ctl) {
+		ctlcmdCaptureStart(xctl, "10.0.0.1", "smtp,imap", false, time.Minute, 2)
+	})
+	if captures := mox.CaptureList(); len(captures) != 1 || captures[0].IP != "10.0.0.1" {
+		t.Fatalf("got captures %v, expected 1 for 10.0.0.1", captures)
+	}
+
+	// "capturelist"
+	testctl(func(xctl *ctl) {
+		ctlcmdCaptureList(xctl)
+	})
+
+	// "capturestop"
+	testctl(func(xctl *ctl) {
+		ctlcmdCaptureStop(xctl, 0)
+	})
+	if captures := mox.CaptureList(); len(captures) != 0 {
+		t.Fatalf("got %d captures, expected 0", len(captures))
+	}
+
+	// "openpgpkeylist"
+	testctl(func(xctl *ctl) {
+		keys := ctlcmdOpenPGPKeyList(xctl, dns.Domain{ASCII: "mox.example"})
+		if len(keys) != 0 {
+			t.Fatalf("got %d openpgp keys, expected 0", len(keys))
+		}
+	})
+
 	// "loglevels"
 	testctl(func(xctl *ctl) {
 		ctlcmdLoglevels(xctl)
@@ -428,6 +576,14 @@ func TestCtl(t *testing.T) {
 		ctlcmdImport(xctl, false, "mjl", "inbox", filepath.FromSlash("testdata/ctl/data/tmp/export/maildir/Inbox"))
 	})
 
+	// "exportimap"
+	testctl(func(xctl *ctl) {
+		ctlcmdExportIMAP(xctl, "mjl", store.ExportOpts{Mailboxes: []string{"Inbox"}, Since: time.Now().Add(-time.Hour)})
+	})
+	testctl(func(xctl *ctl) {
+		ctlcmdExportIMAP(xctl, "mjl", store.ExportOpts{Maildir: true})
+	})
+
 	// "recalculatemailboxcounts"
 	testctl(func(xctl *ctl) {
 		ctlcmdRecalculateMailboxCounts(xctl, "mjl")
@@ -495,6 +651,14 @@ func TestCtl(t *testing.T) {
 		ctlcmdReparse(xctl, "")
 	})
 
+	// "compressmessages"
+	testctl(func(xctl *ctl) {
+		ctlcmdCompressmessages(xctl, 0, "mjl")
+	})
+	testctl(func(xctl *ctl) {
+		ctlcmdCompressmessages(xctl, 1024, "")
+	})
+
 	// "reassignthreads"
 	testctl(func(xctl *ctl) {
 		ctlcmdReassignthreads(xctl, "mjl")
@@ -513,11 +677,18 @@ func TestCtl(t *testing.T) {
 	err = tlsrptdb.Init()
 	tcheck(t, err, "tlsrptdb init")
 	defer tlsrptdb.Close()
+	err = rejectdb.Init()
+	tcheck(t, err, "rejectdb init")
+	defer rejectdb.Close()
+	// Recently modified databases can't be linked to by a next backup.
+	old := time.Now().Add(-time.Hour)
+	err = os.Chtimes(filepath.FromSlash("testdata/ctl/data/mtasts.db"), old, old)
+	tcheck(t, err, "set modification time of database")
 	testctl(func(xctl *ctl) {
 		os.RemoveAll("testdata/ctl/data/tmp/backup")
 		err := os.WriteFile("testdata/ctl/data/receivedid.key", make([]byte, 16), 0600)
 		tcheck(t, err, "writing receivedid.key")
-		ctlcmdBackup(xctl, filepath.FromSlash("testdata/ctl/data/tmp/backup"), false)
+		ctlcmdBackup(xctl, filepath.FromSlash("testdata/ctl/data/tmp/backup"), false, "", "", nil)
 	})
 
 	// Verify the backup.
@@ -527,6 +698,143 @@ func TestCtl(t *testing.T) {
 	}
 	cmdVerifydata(&xcmd)
 
+	// Verify accounts in parallel, and a single account, without message files.
+	xcmd = cmd{
+		flag:     flag.NewFlagSet("", flag.ExitOnError),
+		flagArgs: []string{"-parallel", "2", filepath.FromSlash("testdata/ctl/data/tmp/backup/data")},
+	}
+	cmdVerifydata(&xcmd)
+	xcmd = cmd{
+		flag:     flag.NewFlagSet("", flag.ExitOnError),
+		flagArgs: []string{"-account", "mjl", "-skip-message-files", "-json", filepath.FromSlash("testdata/ctl/data/tmp/backup/data")},
+	}
+	cmdVerifydata(&xcmd)
+
+	// Incremental backup, with a new message, and merged into a full backup.
+	var newMsg store.Message
+	func() {
+		acc, err := store.OpenAccount(pkglog, "mjl", false)
+		tcheck(t, err, "open account")
+		defer func() {
+			acc.Close()
+			acc.WaitClosed()
+		}()
+		content := []byte("Subject: hi\r\n\r\nbody\r\n")
+		newMsg.Size = int64(len(content))
+		msgf, err := store.CreateMessageTemp(pkglog, "ctltest")
+		tcheck(t, err, "create temp file")
+		defer os.Remove(msgf.Name())
+		defer msgf.Close()
+		_, err = msgf.Write(content)
+		tcheck(t, err, "write message file")
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(pkglog, "Inbox", &newMsg, msgf)
+			tcheck(t, err, "deliver message")
+		})
+	}()
+	testctl(func(xctl *ctl) {
+		os.RemoveAll("testdata/ctl/data/tmp/backup-incr")
+		ctlcmdBackup(xctl, filepath.FromSlash("testdata/ctl/data/tmp/backup-incr"), false, filepath.FromSlash("testdata/ctl/data/tmp/backup"), "", nil)
+	})
+	_, err = os.Stat(filepath.FromSlash("testdata/ctl/data/tmp/backup-incr/data/accounts/mjl/journal.jsonl"))
+	tcheck(t, err, "stat journal in incremental backup")
+	os.RemoveAll("testdata/ctl/data/tmp/backup-merged")
+	xcmd = cmd{flag: flag.NewFlagSet("", flag.ExitOnError), log: pkglog}
+	err = backupMerge(ctxbg, &xcmd, filepath.FromSlash("testdata/ctl/data/tmp/backup"), filepath.FromSlash("testdata/ctl/data/tmp/backup-incr"), filepath.FromSlash("testdata/ctl/data/tmp/backup-merged"))
+	tcheck(t, err, "merge incremental backup")
+	_, err = os.Stat(filepath.Join(filepath.FromSlash("testdata/ctl/data/tmp/backup-merged/data/accounts/mjl/msg"), store.MessagePath(newMsg.ID)))
+	tcheck(t, err, "stat new message in merged backup")
+	xcmd = cmd{
+		flag:     flag.NewFlagSet("", flag.ExitOnError),
+		flagArgs: []string{filepath.FromSlash("testdata/ctl/data/tmp/backup-merged/data")},
+	}
+	cmdVerifydata(&xcmd)
+
+	// Full backup with unchanged files hardlinked to the previous backup.
+	testctl(func(xctl *ctl) {
+		os.RemoveAll("testdata/ctl/data/tmp/backup-link")
+		ctlcmdBackup(xctl, filepath.FromSlash("testdata/ctl/data/tmp/backup-link"), false, "", filepath.FromSlash("testdata/ctl/data/tmp/backup"), nil)
+	})
+	prevfi, err := os.Stat(filepath.FromSlash("testdata/ctl/data/tmp/backup/data/mtasts.db"))
+	tcheck(t, err, "stat database in previous backup")
+	linkfi, err := os.Stat(filepath.FromSlash("testdata/ctl/data/tmp/backup-link/data/mtasts.db"))
+	tcheck(t, err, "stat database in linked backup")
+	if !os.SameFile(prevfi, linkfi) {
+		t.Fatalf("unchanged database not hardlinked to previous backup")
+	}
+	linkManifest, err := readBackupManifest(filepath.FromSlash("testdata/ctl/data/tmp/backup-link"))
+	tcheck(t, err, "read manifest of linked backup")
+	if mtime := linkManifest.Databases["mtasts.db"]; !mtime.Equal(old) {
+		t.Fatalf("got modification time %v for database in manifest, expected %v", mtime, old)
+	}
+	xcmd = cmd{
+		flag:     flag.NewFlagSet("", flag.ExitOnError),
+		flagArgs: []string{filepath.FromSlash("testdata/ctl/data/tmp/backup-link/data")},
+	}
+	cmdVerifydata(&xcmd)
+
+	// Full backup to remote S3 destination. The fake S3 server stores objects as
+	// files, so the backup can be verified.
+	s3srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := strings.CutPrefix(r.URL.Path, "/bucket/backup/")
+		if r.Method != "PUT" || !ok || r.URL.RawQuery != "" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		dstpath := filepath.Join(filepath.FromSlash("testdata/ctl/data/tmp/backup-s3"), filepath.FromSlash(p))
+		buf, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(dstpath), 0770)
+		}
+		if err == nil {
+			err = os.WriteFile(dstpath, buf, 0660)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+	defer s3srv.Close()
+	testctl(func(xctl *ctl) {
+		os.RemoveAll("testdata/ctl/data/tmp/backup-s3")
+		config := backupdest.Config{AccessKeyID: "test", SecretAccessKey: "test", Endpoint: s3srv.URL}
+		ctlcmdBackup(xctl, "s3://bucket/backup", false, "", "", &config)
+	})
+	_, err = readBackupManifest(filepath.FromSlash("testdata/ctl/data/tmp/backup-s3"))
+	tcheck(t, err, "read manifest of remote backup")
+	xcmd = cmd{
+		flag:     flag.NewFlagSet("", flag.ExitOnError),
+		flagArgs: []string{filepath.FromSlash("testdata/ctl/data/tmp/backup-s3/data")},
+	}
+	cmdVerifydata(&xcmd)
+
+	// Scheduled backups, with retention of the most recent backup per day. Older
+	// backups are faked.
+	schedDir := filepath.FromSlash("testdata/ctl/data/tmp/backup-sched")
+	os.RemoveAll(schedDir)
+	now := time.Now()
+	for _, tm := range []time.Time{now.AddDate(0, 0, -2), now.AddDate(0, 0, -1).Add(-time.Minute), now.AddDate(0, 0, -1)} {
+		dir := filepath.Join(schedDir, tm.Format(backupNameFormat))
+		err := os.MkdirAll(dir, 0770)
+		tcheck(t, err, "creating fake backup")
+		err = writeBackupManifest(dir, backupManifest{Version: 1})
+		tcheck(t, err, "writing manifest for fake backup")
+	}
+	incomplete := now.AddDate(0, 0, -3).Format(backupNameFormat)
+	err = os.MkdirAll(filepath.Join(schedDir, incomplete), 0770)
+	tcheck(t, err, "creating fake incomplete backup")
+	schedConf := config.BackupSchedule{Schedule: "0 3 * * *", Directory: schedDir, KeepDaily: 2}
+	scheduledBackup(ctxbg, pkglog, schedConf, schedDir, now)
+	names, incompletes, err := scheduledBackups(schedDir)
+	tcheck(t, err, "listing scheduled backups")
+	if len(incompletes) != 0 || len(names) != 2 || names[0] != now.Format(backupNameFormat) || names[1] != now.AddDate(0, 0, -1).Format(backupNameFormat) {
+		t.Fatalf("got backups %v, incomplete %v, expected new backup and most recent of previous day", names, incompletes)
+	}
+	xcmd = cmd{
+		flag:     flag.NewFlagSet("", flag.ExitOnError),
+		flagArgs: []string{filepath.Join(schedDir, names[0], "data")},
+	}
+	cmdVerifydata(&xcmd)
+
 	// IMAP connection.
 	testctl(func(xctl *ctl) {
 		a, b := net.Pipe()
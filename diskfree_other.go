@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly
+
+package main
+
+import (
+	"errors"
+)
+
+// diskFree is not implemented on this platform.
+func diskFree(path string) (free, total uint64, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -19,10 +19,11 @@ type MockResolver struct {
 	MX           map[string][]*net.MX
 	TLSA         map[string][]adns.TLSA // Keys are e.g. _25._tcp.<host>.
 	CNAME        map[string]string
-	Fail         []string // Records of the form "type name", e.g. "cname localhost." that will return a servfail.
-	AllAuthentic bool     // Default value for authentic in responses. Overridden with Authentic and Inauthentic
-	Authentic    []string // Like Fail, but records that cause the response to be authentic.
-	Inauthentic  []string // Like Authentic, but making response inauthentic.
+	Fail         []string          // Records of the form "type name", e.g. "cname localhost." that will return a servfail.
+	AllAuthentic bool              // Default value for authentic in responses. Overridden with Authentic and Inauthentic
+	Authentic    []string          // Like Fail, but records that cause the response to be authentic.
+	Inauthentic  []string          // Like Authentic, but making response inauthentic.
+	TTL          map[string]uint32 // Keys like Fail, e.g. "mx mox.example.", TTL recorded for lookups with a context from WithTTL. For not found results, the negative caching TTL.
 }
 
 type mockReq struct {
@@ -43,6 +44,7 @@ func (r MockResolver) result(ctx context.Context, mr mockReq) (string, adns.Resu
 		return "", result, err
 	}
 
+	ttl := ttlFromContext(ctx)
 	updateAuthentic := func(mock string) {
 		if slices.Contains(r.Authentic, mock) {
 			result.Authentic = true
@@ -50,6 +52,9 @@ func (r MockResolver) result(ctx context.Context, mr mockReq) (string, adns.Resu
 		if slices.Contains(r.Inauthentic, mock) {
 			result.Authentic = false
 		}
+		if v, ok := r.TTL[mock]; ok && ttl != nil {
+			ttl.Record(v)
+		}
 	}
 
 	for {
@@ -0,0 +1,139 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/mjl-/adns"
+)
+
+// OverlayResolver answers requests for names and record types present in
+// Records, and passes all other requests to Resolver. It is used to evaluate
+// proposed DNS changes before publishing them. Records for a name and type
+// replace all records of that type for the name. CNAME records in Records are
+// followed, with the target looked up in Records, or in Resolver if Records has
+// no records of the requested type for the target.
+//
+// Responses from Records are marked authentic if Resolver gives an authentic
+// response (e.g. a DNSSEC-verified record or denial of existence) for the same
+// request, assuming the proposed records are published in the same signed zone.
+type OverlayResolver struct {
+	Resolver Resolver
+	Records  MockResolver
+}
+
+var _ Resolver = OverlayResolver{}
+
+// overlay returns the overlay response if has is true, with authentic status from
+// the base response, and the base response otherwise.
+func overlay[T any](has bool, overlayFn, baseFn func() (T, adns.Result, error)) (T, adns.Result, error) {
+	if !has {
+		return baseFn()
+	}
+	v, result, err := overlayFn()
+	_, bresult, _ := baseFn()
+	result.Authentic = bresult.Authentic
+	return v, result, err
+}
+
+// target returns name after following CNAME records in Records.
+func (r OverlayResolver) target(name string) string {
+	// Limit, to prevent loops.
+	for range 10 {
+		t, ok := r.Records.CNAME[name]
+		if !ok {
+			break
+		}
+		name = t
+	}
+	return name
+}
+
+func has[T any](m map[string]T, name string) bool {
+	_, ok := m[name]
+	return ok
+}
+
+func (r OverlayResolver) LookupPort(ctx context.Context, network, service string) (port int, err error) {
+	return r.Resolver.LookupPort(ctx, network, service)
+}
+
+func (r OverlayResolver) LookupAddr(ctx context.Context, addr string) ([]string, adns.Result, error) {
+	return overlay(has(r.Records.PTR, addr),
+		func() ([]string, adns.Result, error) { return r.Records.LookupAddr(ctx, addr) },
+		func() ([]string, adns.Result, error) { return r.Resolver.LookupAddr(ctx, addr) },
+	)
+}
+
+func (r OverlayResolver) LookupCNAME(ctx context.Context, host string) (string, adns.Result, error) {
+	return overlay(has(r.Records.CNAME, host),
+		func() (string, adns.Result, error) { return r.Records.LookupCNAME(ctx, host) },
+		func() (string, adns.Result, error) { return r.Resolver.LookupCNAME(ctx, host) },
+	)
+}
+
+func (r OverlayResolver) LookupHost(ctx context.Context, host string) ([]string, adns.Result, error) {
+	t := r.target(host)
+	return overlay(has(r.Records.A, t) || has(r.Records.AAAA, t),
+		func() ([]string, adns.Result, error) { return r.Records.LookupHost(ctx, t) },
+		func() ([]string, adns.Result, error) { return r.Resolver.LookupHost(ctx, t) },
+	)
+}
+
+func (r OverlayResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, adns.Result, error) {
+	t := r.target(host)
+	return overlay(has(r.Records.A, t) || has(r.Records.AAAA, t),
+		func() ([]net.IP, adns.Result, error) { return r.Records.LookupIP(ctx, network, t) },
+		func() ([]net.IP, adns.Result, error) { return r.Resolver.LookupIP(ctx, network, t) },
+	)
+}
+
+func (r OverlayResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, adns.Result, error) {
+	t := r.target(host)
+	return overlay(has(r.Records.A, t) || has(r.Records.AAAA, t),
+		func() ([]net.IPAddr, adns.Result, error) { return r.Records.LookupIPAddr(ctx, t) },
+		func() ([]net.IPAddr, adns.Result, error) { return r.Resolver.LookupIPAddr(ctx, t) },
+	)
+}
+
+func (r OverlayResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, adns.Result, error) {
+	t := r.target(name)
+	return overlay(has(r.Records.MX, t),
+		func() ([]*net.MX, adns.Result, error) { return r.Records.LookupMX(ctx, t) },
+		func() ([]*net.MX, adns.Result, error) { return r.Resolver.LookupMX(ctx, t) },
+	)
+}
+
+func (r OverlayResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, adns.Result, error) {
+	return r.Resolver.LookupNS(ctx, name)
+}
+
+func (r OverlayResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, adns.Result, error) {
+	return r.Resolver.LookupSRV(ctx, service, proto, name)
+}
+
+func (r OverlayResolver) LookupTXT(ctx context.Context, name string) ([]string, adns.Result, error) {
+	t := r.target(name)
+	return overlay(has(r.Records.TXT, t),
+		func() ([]string, adns.Result, error) { return r.Records.LookupTXT(ctx, t) },
+		func() ([]string, adns.Result, error) { return r.Resolver.LookupTXT(ctx, t) },
+	)
+}
+
+func (r OverlayResolver) LookupTLSA(ctx context.Context, port int, protocol, host string) ([]adns.TLSA, adns.Result, error) {
+	name := host
+	if port != 0 || protocol != "" {
+		name = fmt.Sprintf("_%d._%s.%s", port, protocol, host)
+	}
+	t := r.target(name)
+	return overlay(has(r.Records.TLSA, t),
+		func() ([]adns.TLSA, adns.Result, error) { return r.Records.LookupTLSA(ctx, 0, "", t) },
+		func() ([]adns.TLSA, adns.Result, error) {
+			if t == name {
+				return r.Resolver.LookupTLSA(ctx, port, protocol, host)
+			}
+			return r.Resolver.LookupTLSA(ctx, 0, "", t)
+		},
+	)
+}
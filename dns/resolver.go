@@ -101,7 +101,10 @@ func (r StrictResolver) WithPackage(name string) Resolver {
 	return nr
 }
 
-func (r StrictResolver) resolver() Resolver {
+func (r StrictResolver) resolver(ctx context.Context) Resolver {
+	if t := ttlFromContext(ctx); t != nil {
+		return ttlResolver(r.Resolver, t)
+	}
 	if r.Resolver == nil {
 		return adns.DefaultResolver
 	}
@@ -138,7 +141,7 @@ func (r StrictResolver) LookupPort(ctx context.Context, network, service string)
 	}()
 	defer resolveErrorHint(&err)
 
-	resp, err = r.resolver().LookupPort(ctx, network, service)
+	resp, err = r.resolver(ctx).LookupPort(ctx, network, service)
 	return
 }
 
@@ -156,7 +159,7 @@ func (r StrictResolver) LookupAddr(ctx context.Context, addr string) (resp []str
 	}()
 	defer resolveErrorHint(&err)
 
-	resp, result, err = r.resolver().LookupAddr(ctx, addr)
+	resp, result, err = r.resolver(ctx).LookupAddr(ctx, addr)
 	// For addresses from /etc/hosts without dot, we add the missing trailing dot.
 	for i, s := range resp {
 		if !strings.HasSuffix(s, ".") {
@@ -185,7 +188,7 @@ func (r StrictResolver) LookupCNAME(ctx context.Context, host string) (resp stri
 	if !strings.HasSuffix(host, ".") {
 		return "", result, ErrRelativeDNSName
 	}
-	resp, result, err = r.resolver().LookupCNAME(ctx, host)
+	resp, result, err = r.resolver(ctx).LookupCNAME(ctx, host)
 	if err == nil && resp == host {
 		return "", result, &adns.DNSError{
 			Err:        "no cname record",
@@ -214,7 +217,7 @@ func (r StrictResolver) LookupHost(ctx context.Context, host string) (resp []str
 	if !strings.HasSuffix(host, ".") {
 		return nil, result, ErrRelativeDNSName
 	}
-	resp, result, err = r.resolver().LookupHost(ctx, host)
+	resp, result, err = r.resolver(ctx).LookupHost(ctx, host)
 	return
 }
 
@@ -236,7 +239,7 @@ func (r StrictResolver) LookupIP(ctx context.Context, network, host string) (res
 	if !strings.HasSuffix(host, ".") {
 		return nil, result, ErrRelativeDNSName
 	}
-	resp, result, err = r.resolver().LookupIP(ctx, network, host)
+	resp, result, err = r.resolver(ctx).LookupIP(ctx, network, host)
 	return
 }
 
@@ -257,7 +260,7 @@ func (r StrictResolver) LookupIPAddr(ctx context.Context, host string) (resp []n
 	if !strings.HasSuffix(host, ".") {
 		return nil, result, ErrRelativeDNSName
 	}
-	resp, result, err = r.resolver().LookupIPAddr(ctx, host)
+	resp, result, err = r.resolver(ctx).LookupIPAddr(ctx, host)
 	return
 }
 
@@ -278,7 +281,7 @@ func (r StrictResolver) LookupMX(ctx context.Context, name string) (resp []*net.
 	if !strings.HasSuffix(name, ".") {
 		return nil, result, ErrRelativeDNSName
 	}
-	resp, result, err = r.resolver().LookupMX(ctx, name)
+	resp, result, err = r.resolver(ctx).LookupMX(ctx, name)
 	return
 }
 
@@ -299,7 +302,7 @@ func (r StrictResolver) LookupNS(ctx context.Context, name string) (resp []*net.
 	if !strings.HasSuffix(name, ".") {
 		return nil, result, ErrRelativeDNSName
 	}
-	resp, result, err = r.resolver().LookupNS(ctx, name)
+	resp, result, err = r.resolver(ctx).LookupNS(ctx, name)
 	return
 }
 
@@ -323,7 +326,7 @@ func (r StrictResolver) LookupSRV(ctx context.Context, service, proto, name stri
 	if !strings.HasSuffix(name, ".") {
 		return "", nil, result, ErrRelativeDNSName
 	}
-	resp0, resp1, result, err = r.resolver().LookupSRV(ctx, service, proto, name)
+	resp0, resp1, result, err = r.resolver(ctx).LookupSRV(ctx, service, proto, name)
 	return
 }
 
@@ -344,7 +347,7 @@ func (r StrictResolver) LookupTXT(ctx context.Context, name string) (resp []stri
 	if !strings.HasSuffix(name, ".") {
 		return nil, result, ErrRelativeDNSName
 	}
-	resp, result, err = r.resolver().LookupTXT(ctx, name)
+	resp, result, err = r.resolver(ctx).LookupTXT(ctx, name)
 	return
 }
 
@@ -367,6 +370,6 @@ func (r StrictResolver) LookupTLSA(ctx context.Context, port int, protocol, host
 	if !strings.HasSuffix(host, ".") {
 		return nil, result, ErrRelativeDNSName
 	}
-	resp, result, err = r.resolver().LookupTLSA(ctx, port, protocol, host)
+	resp, result, err = r.resolver(ctx).LookupTLSA(ctx, port, protocol, host)
 	return
 }
@@ -0,0 +1,184 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/mjl-/adns"
+)
+
+// The resolver doesn't return the TTLs of records. For callers that need them,
+// e.g. for caching, the StrictResolver can record the TTLs in the DNS responses it
+// receives for a lookup. The responses are parsed from the connections to the
+// recursive resolvers, for lookups with a context from WithTTL.
+
+// TTL holds the lowest TTL seen in DNS responses for lookups, see WithTTL.
+type TTL struct {
+	sync.Mutex
+	seen bool
+	ttl  uint32
+}
+
+type ttlKey struct{}
+
+// WithTTL returns a context that records the lowest TTL of the DNS responses
+// received during lookups made with the context. For responses with records, the
+// lowest TTL of the records in the answer section is used, including followed
+// CNAMEs. For responses without records in the answer section (e.g. not found),
+// the negative caching TTL is used: the lowest of the TTL and "minimum" field of
+// the SOA record in the authority section, ../rfc/2308:370.
+func WithTTL(ctx context.Context) (context.Context, *TTL) {
+	t := &TTL{}
+	return context.WithValue(ctx, ttlKey{}, t), t
+}
+
+func ttlFromContext(ctx context.Context) *TTL {
+	t, _ := ctx.Value(ttlKey{}).(*TTL)
+	return t
+}
+
+// Record registers a TTL in seconds, keeping the lowest.
+func (t *TTL) Record(ttl uint32) {
+	t.Lock()
+	defer t.Unlock()
+	if !t.seen || ttl < t.ttl {
+		t.ttl = ttl
+	}
+	t.seen = true
+}
+
+// TTL returns the lowest TTL recorded, and false if no TTL was recorded, e.g.
+// because the response came from /etc/hosts, or a negative response did not
+// have an SOA record.
+func (t *TTL) TTL() (time.Duration, bool) {
+	t.Lock()
+	defer t.Unlock()
+	return time.Duration(t.ttl) * time.Second, t.seen
+}
+
+// recordMessage parses a DNS response and records its TTL. Invalid messages
+// are ignored.
+func (t *TTL) recordMessage(buf []byte) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(buf); err != nil {
+		return
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+	var answers int
+	for {
+		h, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		} else if err != nil {
+			return
+		}
+		t.Record(h.TTL)
+		answers++
+		if err := p.SkipAnswer(); err != nil {
+			return
+		}
+	}
+	if answers > 0 {
+		return
+	}
+	for {
+		h, err := p.AuthorityHeader()
+		if err != nil {
+			return
+		}
+		if h.Type != dnsmessage.TypeSOA {
+			if err := p.SkipAuthority(); err != nil {
+				return
+			}
+			continue
+		}
+		soa, err := p.SOAResource()
+		if err != nil {
+			return
+		}
+		t.Record(min(h.TTL, soa.MinTTL))
+		return
+	}
+}
+
+// ttlResolver returns a resolver like base (possibly nil), that records the TTLs
+// of DNS responses in t.
+func ttlResolver(base *adns.Resolver, t *TTL) *adns.Resolver {
+	var dial func(ctx context.Context, network, address string) (net.Conn, error)
+	if base != nil {
+		dial = base.Dial
+	}
+	r := &adns.Resolver{
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var conn net.Conn
+			var err error
+			if dial != nil {
+				conn, err = dial(ctx, network, address)
+			} else {
+				var d net.Dialer
+				conn, err = d.DialContext(ctx, network, address)
+			}
+			if err != nil {
+				return nil, err
+			}
+			// The resolver does packet-based reads for connections that are a PacketConn. We
+			// only know how to wrap UDP connections, other packet connections from a custom
+			// dialer are used as is, without recording TTLs.
+			if uc, ok := conn.(*net.UDPConn); ok {
+				return ttlPacketConn{uc, t}, nil
+			} else if _, ok := conn.(net.PacketConn); ok {
+				return conn, nil
+			}
+			return &ttlStreamConn{Conn: conn, t: t}, nil
+		},
+	}
+	if base != nil {
+		r.PreferGo = base.PreferGo
+		r.StrictErrors = base.StrictErrors
+	}
+	return r
+}
+
+// ttlPacketConn records the TTL of each DNS message read.
+type ttlPacketConn struct {
+	*net.UDPConn
+	t *TTL
+}
+
+func (c ttlPacketConn) Read(buf []byte) (int, error) {
+	n, err := c.UDPConn.Read(buf)
+	if n > 0 {
+		c.t.recordMessage(buf[:n])
+	}
+	return n, err
+}
+
+// ttlStreamConn records the TTL of the 2-byte length-prefixed DNS messages read.
+type ttlStreamConn struct {
+	net.Conn
+	t   *TTL
+	buf []byte
+}
+
+func (c *ttlStreamConn) Read(buf []byte) (int, error) {
+	n, err := c.Conn.Read(buf)
+	if n > 0 {
+		c.buf = append(c.buf, buf[:n]...)
+		for len(c.buf) >= 2 {
+			size := int(binary.BigEndian.Uint16(c.buf))
+			if len(c.buf) < 2+size {
+				break
+			}
+			c.t.recordMessage(c.buf[2 : 2+size])
+			c.buf = c.buf[2+size:]
+		}
+	}
+	return n, err
+}
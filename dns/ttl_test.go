@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/mjl-/adns"
+)
+
+func ttlResponse(t *testing.T, req []byte) []byte {
+	t.Helper()
+
+	var p dnsmessage.Parser
+	h, err := p.Start(req)
+	if err != nil {
+		t.Fatalf("parsing request: %v", err)
+	}
+	q, err := p.Question()
+	if err != nil {
+		t.Fatalf("parsing question: %v", err)
+	}
+
+	rh := dnsmessage.Header{ID: h.ID, Response: true, RecursionDesired: h.RecursionDesired, RecursionAvailable: true}
+	mxName := dnsmessage.MustNewName("mox.example.")
+	hostName := dnsmessage.MustNewName("mail.mox.example.")
+	exists := q.Name.String() == "mox.example." && q.Type == dnsmessage.TypeMX
+	if !exists {
+		rh.RCode = dnsmessage.RCodeNameError
+	}
+	b := dnsmessage.NewBuilder(nil, rh)
+	b.EnableCompression()
+	err = b.StartQuestions()
+	if err == nil {
+		err = b.Question(q)
+	}
+	if err == nil {
+		err = b.StartAnswers()
+	}
+	if err == nil && exists {
+		rrh := dnsmessage.ResourceHeader{Name: mxName, Class: dnsmessage.ClassINET, TTL: 300}
+		err = b.MXResource(rrh, dnsmessage.MXResource{Pref: 10, MX: hostName})
+		if err == nil {
+			rrh.TTL = 200
+			err = b.MXResource(rrh, dnsmessage.MXResource{Pref: 20, MX: hostName})
+		}
+	}
+	if err == nil {
+		err = b.StartAuthorities()
+	}
+	if err == nil && !exists {
+		rrh := dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("example."), Class: dnsmessage.ClassINET, TTL: 3600}
+		err = b.SOAResource(rrh, dnsmessage.SOAResource{NS: hostName, MBox: hostName, Serial: 1, Refresh: 3600, Retry: 600, Expire: 86400, MinTTL: 120})
+	}
+	if err != nil {
+		t.Fatalf("building response: %v", err)
+	}
+	buf, err := b.Finish()
+	if err != nil {
+		t.Fatalf("finishing response: %v", err)
+	}
+	return buf
+}
+
+func TestTTL(t *testing.T) {
+	// Local DNS server over UDP.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(ttlResponse(t, buf[:n]), addr)
+		}
+	}()
+
+	resolver := StrictResolver{
+		Resolver: &adns.Resolver{
+			StrictErrors: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "udp", pc.LocalAddr().String())
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Lowest TTL of the records.
+	xctx, ttl := WithTTL(ctx)
+	l, _, err := resolver.LookupMX(xctx, "mox.example.")
+	if err != nil || len(l) != 2 {
+		t.Fatalf("lookup mx: got %v, %v", l, err)
+	}
+	if d, ok := ttl.TTL(); !ok || d != 200*time.Second {
+		t.Fatalf("got ttl %v %v, expected 200s", d, ok)
+	}
+
+	// Negative caching TTL from SOA record.
+	xctx, ttl = WithTTL(ctx)
+	_, _, err = resolver.LookupMX(xctx, "absent.example.")
+	if !IsNotFound(err) {
+		t.Fatalf("lookup mx: got err %v, expected not found", err)
+	}
+	if d, ok := ttl.TTL(); !ok || d != 120*time.Second {
+		t.Fatalf("got ttl %v %v, expected 120s", d, ok)
+	}
+
+	// Without TTL context, nothing is recorded.
+	_, _, err = resolver.LookupMX(ctx, "mox.example.")
+	if err != nil {
+		t.Fatalf("lookup mx: %v", err)
+	}
+
+	// Messages over stream connections are length-prefixed, and can arrive in parts.
+	client, server := net.Pipe()
+	defer client.Close()
+	ttl = &TTL{}
+	sc := &ttlStreamConn{Conn: client, t: ttl}
+	msg := ttlResponse(t, ttlRequest(t))
+	buf := binary.BigEndian.AppendUint16(nil, uint16(len(msg)))
+	buf = append(buf, msg...)
+	go func() {
+		defer server.Close()
+		for _, chunk := range [][]byte{buf[:1], buf[1:10], buf[10:]} {
+			server.Write(chunk)
+		}
+	}()
+	rbuf := make([]byte, len(buf))
+	var n int
+	for n < len(buf) {
+		nn, err := sc.Read(rbuf[n:])
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		n += nn
+	}
+	if d, ok := ttl.TTL(); !ok || d != 200*time.Second {
+		t.Fatalf("got ttl %v %v over stream, expected 200s", d, ok)
+	}
+}
+
+func ttlRequest(t *testing.T) []byte {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, RecursionDesired: true})
+	err := b.StartQuestions()
+	if err == nil {
+		err = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName("mox.example."), Type: dnsmessage.TypeMX, Class: dnsmessage.ClassINET})
+	}
+	if err != nil {
+		t.Fatalf("building request: %v", err)
+	}
+	buf, err := b.Finish()
+	if err != nil {
+		t.Fatalf("finishing request: %v", err)
+	}
+	return buf
+}
@@ -0,0 +1,282 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mjl-/adns"
+)
+
+var ErrZoneSyntax = errors.New("zone file syntax error")
+
+// ParseZone parses DNS records in zone file format (RFC 1035) from r, e.g. as
+// printed by "mox config dnsrecords", and returns them in a MockResolver, for use
+// with an OverlayResolver. Relative names are interpreted relative to origin,
+// until a $ORIGIN directive. Records of types A, AAAA, MX, TXT, CNAME and TLSA
+// are parsed. The types of other records, e.g. SOA, NS, CAA and SRV, are returned
+// in ignored. Names must be in ASCII form.
+// ../rfc/1035:1202
+func ParseZone(r io.Reader, origin Domain) (records MockResolver, ignored []string, rerr error) {
+	records = MockResolver{
+		A:     map[string][]string{},
+		AAAA:  map[string][]string{},
+		TXT:   map[string][]string{},
+		MX:    map[string][]*net.MX{},
+		TLSA:  map[string][]adns.TLSA{},
+		CNAME: map[string]string{},
+	}
+
+	originName := origin.ASCII + "."
+	var prevName string
+
+	absName := func(s string) string {
+		s = strings.ToLower(s)
+		if s == "@" {
+			return originName
+		} else if strings.HasSuffix(s, ".") {
+			return s
+		}
+		return s + "." + originName
+	}
+
+	// Lines, with continuation lines in parentheses joined.
+	br := bufio.NewReader(r)
+	linenum := 0
+	readLine := func() (line string, eof bool, err error) {
+		s, err := br.ReadString('\n')
+		if err == io.EOF {
+			if s == "" {
+				return "", true, nil
+			}
+			err = nil
+		}
+		linenum++
+		return strings.TrimRight(s, "\r\n"), false, err
+	}
+
+	for {
+		line, eof, err := readLine()
+		if err != nil {
+			return records, ignored, fmt.Errorf("reading zone: %v", err)
+		} else if eof {
+			break
+		}
+		startLine := linenum
+		tokens, depth, err := zoneTokens(line, 0)
+		for err == nil && depth > 0 {
+			var next string
+			next, eof, err = readLine()
+			if err == nil && eof {
+				err = fmt.Errorf("%w: unclosed parenthesis", ErrZoneSyntax)
+			}
+			if err == nil {
+				var more []zoneToken
+				more, depth, err = zoneTokens(next, depth)
+				tokens = append(tokens, more...)
+			}
+		}
+		if err != nil {
+			return records, ignored, fmt.Errorf("line %d: %w", startLine, err)
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+
+		xerrorf := func(format string, args ...any) error {
+			return fmt.Errorf("line %d: %w: %s", startLine, ErrZoneSyntax, fmt.Sprintf(format, args...))
+		}
+
+		if strings.HasPrefix(tokens[0].s, "$") && !tokens[0].quoted {
+			switch strings.ToUpper(tokens[0].s) {
+			case "$ORIGIN":
+				if len(tokens) != 2 {
+					return records, ignored, xerrorf("$ORIGIN requires a single parameter")
+				}
+				originName = absName(tokens[1].s)
+			case "$TTL":
+			default:
+				ignored = append(ignored, tokens[0].s)
+			}
+			continue
+		}
+
+		// Name, possibly inherited from the previous record.
+		var name string
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if prevName == "" {
+				return records, ignored, xerrorf("missing name")
+			}
+			name = prevName
+		} else {
+			name = absName(tokens[0].s)
+			tokens = tokens[1:]
+		}
+		prevName = name
+
+		// Optional TTL and class, in either order.
+		for len(tokens) > 0 && !tokens[0].quoted {
+			s := strings.ToUpper(tokens[0].s)
+			if s == "IN" || s[0] >= '0' && s[0] <= '9' {
+				tokens = tokens[1:]
+				continue
+			}
+			break
+		}
+		if len(tokens) == 0 {
+			return records, ignored, xerrorf("missing record type")
+		}
+		typ := strings.ToUpper(tokens[0].s)
+		params := tokens[1:]
+
+		switch typ {
+		case "A", "AAAA":
+			if len(params) != 1 {
+				return records, ignored, xerrorf("%s record requires a single IP address", typ)
+			}
+			ip := net.ParseIP(params[0].s)
+			if ip == nil || (typ == "A") != (ip.To4() != nil) {
+				return records, ignored, xerrorf("invalid ip address %q for %s record", params[0].s, typ)
+			}
+			if typ == "A" {
+				records.A[name] = append(records.A[name], ip.String())
+			} else {
+				records.AAAA[name] = append(records.AAAA[name], ip.String())
+			}
+		case "MX":
+			if len(params) != 2 {
+				return records, ignored, xerrorf("MX record requires preference and host")
+			}
+			pref, err := strconv.ParseUint(params[0].s, 10, 16)
+			if err != nil {
+				return records, ignored, xerrorf("parsing MX preference: %v", err)
+			}
+			records.MX[name] = append(records.MX[name], &net.MX{Host: absName(params[1].s), Pref: uint16(pref)})
+		case "TXT":
+			if len(params) == 0 {
+				return records, ignored, xerrorf("TXT record requires a value")
+			}
+			var b strings.Builder
+			for _, p := range params {
+				b.WriteString(p.s)
+			}
+			records.TXT[name] = append(records.TXT[name], b.String())
+		case "CNAME":
+			if len(params) != 1 {
+				return records, ignored, xerrorf("CNAME record requires a single target")
+			}
+			if _, ok := records.CNAME[name]; ok {
+				return records, ignored, xerrorf("duplicate CNAME record for %s", name)
+			}
+			records.CNAME[name] = absName(params[0].s)
+		case "TLSA":
+			if len(params) < 4 {
+				return records, ignored, xerrorf("TLSA record requires usage, selector, matching type and data")
+			}
+			var v [3]uint8
+			for i := range v {
+				x, err := strconv.ParseUint(params[i].s, 10, 8)
+				if err != nil {
+					return records, ignored, xerrorf("parsing TLSA field: %v", err)
+				}
+				v[i] = uint8(x)
+			}
+			var data strings.Builder
+			for _, p := range params[3:] {
+				data.WriteString(p.s)
+			}
+			buf, err := hex.DecodeString(data.String())
+			if err != nil {
+				return records, ignored, xerrorf("parsing TLSA data: %v", err)
+			}
+			tlsa := adns.TLSA{Usage: adns.TLSAUsage(v[0]), Selector: adns.TLSASelector(v[1]), MatchType: adns.TLSAMatchType(v[2]), CertAssoc: buf}
+			records.TLSA[name] = append(records.TLSA[name], tlsa)
+		default:
+			ignored = append(ignored, typ+" "+name)
+		}
+	}
+	return records, ignored, nil
+}
+
+type zoneToken struct {
+	s      string
+	quoted bool
+}
+
+// zoneTokens splits line into tokens, with quoted strings unescaped. Depth is
+// the parenthesis nesting at the start of the line, and the returned depth at
+// the end of the line.
+func zoneTokens(line string, depth int) ([]zoneToken, int, error) {
+	var l []zoneToken
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == ';':
+			return l, depth, nil
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			if depth == 0 {
+				return nil, depth, fmt.Errorf("%w: unbalanced closing parenthesis", ErrZoneSyntax)
+			}
+			depth--
+			i++
+		case c == '"':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(line) {
+					return nil, depth, fmt.Errorf("%w: unterminated quoted string", ErrZoneSyntax)
+				}
+				c := line[i]
+				if c == '"' {
+					i++
+					break
+				} else if c != '\\' {
+					b.WriteByte(c)
+					i++
+					continue
+				}
+				// ../rfc/1035:1212
+				if i+3 < len(line) && isDigits(line[i+1:i+4]) {
+					x, err := strconv.ParseUint(line[i+1:i+4], 10, 8)
+					if err != nil {
+						return nil, depth, fmt.Errorf("%w: invalid escape: %v", ErrZoneSyntax, err)
+					}
+					b.WriteByte(byte(x))
+					i += 4
+				} else if i+1 < len(line) {
+					b.WriteByte(line[i+1])
+					i += 2
+				} else {
+					return nil, depth, fmt.Errorf("%w: unterminated escape", ErrZoneSyntax)
+				}
+			}
+			l = append(l, zoneToken{b.String(), true})
+		default:
+			o := i
+			for i < len(line) && !strings.ContainsRune(" \t;()\"", rune(line[i])) {
+				i++
+			}
+			l = append(l, zoneToken{line[o:i], false})
+		}
+	}
+	return l, depth, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
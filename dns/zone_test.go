@@ -0,0 +1,109 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/adns"
+)
+
+func TestParseZone(t *testing.T) {
+	const zone = `$TTL 300
+; Comment line.
+@                 IN MX 10 mail
+                  TXT "v=spf1 mx " "-all" ; Inherited name, concatenated strings.
+mail 3600 IN      A 10.0.0.1
+mail              AAAA 2001:db8::1
+_dmarc            TXT "v=DMARC1;p=reject;rua=mailto:\"dmarc\"@example.org"
+sel._domainkey    TXT ( "v=DKIM1;k=ed25519;"
+	"p=abc" )
+mta-sts           CNAME mail.example.org.
+_25._tcp.mail     TLSA 3 1 1 ( 0102
+	0304 )
+@                 NS ns.example.org.
+$ORIGIN other.example.
+@                 TXT "escaped\032value" "\\"
+`
+	records, ignored, err := ParseZone(strings.NewReader(zone), Domain{ASCII: "example.org"})
+	if err != nil {
+		t.Fatalf("parse zone: %v", err)
+	}
+	tcompare := func(got, exp any) {
+		t.Helper()
+		if !reflect.DeepEqual(got, exp) {
+			t.Fatalf("got %#v, expected %#v", got, exp)
+		}
+	}
+	tcompare(records.MX["example.org."], []*net.MX{{Host: "mail.example.org.", Pref: 10}})
+	tcompare(records.TXT["example.org."], []string{"v=spf1 mx -all"})
+	tcompare(records.A["mail.example.org."], []string{"10.0.0.1"})
+	tcompare(records.AAAA["mail.example.org."], []string{"2001:db8::1"})
+	tcompare(records.TXT["_dmarc.example.org."], []string{`v=DMARC1;p=reject;rua=mailto:"dmarc"@example.org`})
+	tcompare(records.TXT["sel._domainkey.example.org."], []string{"v=DKIM1;k=ed25519;p=abc"})
+	tcompare(records.CNAME["mta-sts.example.org."], "mail.example.org.")
+	tcompare(records.TLSA["_25._tcp.mail.example.org."], []adns.TLSA{{Usage: 3, Selector: 1, MatchType: 1, CertAssoc: []byte{1, 2, 3, 4}}})
+	tcompare(records.TXT["other.example."], []string{`escaped value\`})
+	tcompare(ignored, []string{"NS example.org."})
+
+	bad := func(s string) {
+		t.Helper()
+		_, _, err := ParseZone(strings.NewReader(s), Domain{ASCII: "example.org"})
+		if !errors.Is(err, ErrZoneSyntax) {
+			t.Fatalf("parse zone %q: got err %v, expected %v", s, err, ErrZoneSyntax)
+		}
+	}
+	bad("@ TXT (\n")
+	bad("@ TXT )\n")
+	bad("@ TXT \"unterminated\n")
+	bad(" A 10.0.0.1\n")
+	bad("@ A 2001:db8::1\n")
+	bad("@ MX mail\n")
+	bad("@ TLSA 3 1 1 xyz\n")
+	bad("@ CNAME a\n@ CNAME b\n")
+	bad("@\n")
+}
+
+func TestOverlayResolver(t *testing.T) {
+	ctx := context.Background()
+	base := MockResolver{
+		TXT: map[string][]string{
+			"example.org.":                 {"v=spf1 -all"},
+			"_dmarc.example.org.":          {"v=DMARC1;p=none"},
+			"sel._domainkey.dkim.example.": {"v=DKIM1;p=abc"},
+		},
+		A: map[string][]string{
+			"mail.example.org.": {"10.0.0.1"},
+		},
+		Authentic: []string{"txt example.org.", "txt _dmarc.example.org."},
+	}
+	records, _, err := ParseZone(strings.NewReader(`_dmarc TXT "v=DMARC1;p=reject"
+sel._domainkey CNAME sel._domainkey.dkim.example.
+`), Domain{ASCII: "example.org"})
+	if err != nil {
+		t.Fatalf("parse zone: %v", err)
+	}
+	r := OverlayResolver{Resolver: base, Records: records}
+
+	test := func(name string, exp []string, expAuthentic bool) {
+		t.Helper()
+		l, result, err := r.LookupTXT(ctx, name)
+		if err != nil {
+			t.Fatalf("lookup txt %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(l, exp) || result.Authentic != expAuthentic {
+			t.Fatalf("lookup txt %s: got %v, authentic %v, expected %v, authentic %v", name, l, result.Authentic, exp, expAuthentic)
+		}
+	}
+	test("example.org.", []string{"v=spf1 -all"}, true)
+	test("_dmarc.example.org.", []string{"v=DMARC1;p=reject"}, true)
+	test("sel._domainkey.example.org.", []string{"v=DKIM1;p=abc"}, false)
+
+	ips, _, err := r.LookupIP(ctx, "ip", "mail.example.org.")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("lookup ip: got %v, %v", ips, err)
+	}
+}
@@ -8,7 +8,9 @@
 // address is returned, the IP is listed. If an IP is listed, an additional TXT
 // lookup is done for more information about the block. IPv6 addresses are also
 // looked up with an DNS "A" lookup of a name similar to an IPv4 address, but with
-// 4-bit hexadecimal dot-separated characters, in reverse.
+// 4-bit hexadecimal dot-separated characters, in reverse. Domain-based block
+// lists, e.g. for domains in URLs, are queried with the domain prefixed to the
+// zone.
 //
 // The health of a DNSBL "zone" can be checked through a lookup of 127.0.0.1
 // (must not be present) and 127.0.0.2 (must be present).
@@ -84,8 +86,32 @@ func Lookup(ctx context.Context, elog *slog.Logger, resolver dns.Resolver, zone
 		}
 	}
 	b.WriteString("." + zone.ASCII + ".")
-	addr := b.String()
+	return lookup(ctx, log, resolver, b.String())
+}
+
+// LookupDomain checks if "domain" occurs in the domain-based DNS block list
+// "zone", e.g. a list of domains found in URLs of junk messages. The name looked
+// up is the domain prefixed to the zone.
+func LookupDomain(ctx context.Context, elog *slog.Logger, resolver dns.Resolver, zone, domain dns.Domain) (rstatus Status, rexplanation string, rerr error) {
+	log := mlog.New("dnsbl", elog)
+	start := time.Now()
+	defer func() {
+		MetricLookup.ObserveLabels(float64(time.Since(start))/float64(time.Second), zone.Name(), string(rstatus))
+		log.Debugx("dnsbl domain lookup result", rerr,
+			slog.Any("zone", zone),
+			slog.Any("domain", domain),
+			slog.Any("status", rstatus),
+			slog.String("explanation", rexplanation),
+			slog.Duration("duration", time.Since(start)))
+	}()
+
+	// ../rfc/5782:310
+	return lookup(ctx, log, resolver, domain.ASCII+"."+zone.ASCII+".")
+}
 
+// lookup checks if addr, a name composed from the IP or domain to check and the
+// zone, exists, and if so, fetches the explanation.
+func lookup(ctx context.Context, log mlog.Log, resolver dns.Resolver, addr string) (Status, string, error) {
 	// ../rfc/5782:175
 	_, _, err := dns.WithPackage(resolver, "dnsbl").LookupIP(ctx, "ip4", addr)
 	if dns.IsNotFound(err) {
@@ -47,6 +47,25 @@ func TestDNSBL(t *testing.T) {
 		t.Fatalf("lookup, got status %v, expected pass", status)
 	}
 
+	domainResolver := dns.MockResolver{
+		A: map[string][]string{
+			"spam.example.uribl.example.": {"127.0.0.2"},
+		},
+		TXT: map[string][]string{
+			"spam.example.uribl.example.": {"listed!"},
+		},
+	}
+	if status, expl, err := LookupDomain(ctx, log.Logger, domainResolver, dns.Domain{ASCII: "uribl.example"}, dns.Domain{ASCII: "spam.example"}); err != nil {
+		t.Fatalf("lookup domain: %v", err)
+	} else if status != StatusFail || expl != "listed!" {
+		t.Fatalf("lookup domain, got status %v, explanation %q, expected fail", status, expl)
+	}
+	if status, _, err := LookupDomain(ctx, log.Logger, domainResolver, dns.Domain{ASCII: "uribl.example"}, dns.Domain{ASCII: "ham.example"}); err != nil {
+		t.Fatalf("lookup domain: %v", err)
+	} else if status != StatusPass {
+		t.Fatalf("lookup domain, got status %v, expected pass", status)
+	}
+
 	// ../rfc/5782:357
 	if err := CheckHealth(ctx, log.Logger, resolver, dns.Domain{ASCII: "example.com"}); err != nil {
 		t.Fatalf("dnsbl not healthy: %v", err)
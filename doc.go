@@ -22,8 +22,21 @@ any parameters. Followed by the help and usage information for each command.
 	mox serve
 	mox quickstart [-skipdial] [-existing-webserver] [-hostname host] user@domain [user | uid]
 	mox stop
+	mox drain [-deadline duration]
 	mox setaccountpassword account
 	mox setadminpassword
+	mox setadmintotp
+	mox tenant setadminpassword tenant
+	mox admin webauthn list
+	mox admin webauthn reset
+	mox authlockout list
+	mox authlockout clear [ip | account]
+	mox capture start [-duration duration] [-conns n] [-protocols smtp,submission,imap] [-data] ip | account
+	mox capture list
+	mox capture stop [id]
+	mox list subscribers list@domain
+	mox list subscribe list@domain address
+	mox list unsubscribe list@domain address
 	mox loglevels [level [pkg]]
 	mox queue holdrules list
 	mox queue holdrules add [ruleflags]
@@ -34,6 +47,7 @@ any parameters. Followed by the help and usage information for each command.
 	mox queue schedule [filterflags] [-now] duration
 	mox queue transport [filterflags] transport
 	mox queue requiretls [filterflags] {yes | no | default}
+	mox queue requireverifiedtls [filterflags] {yes | no}
 	mox queue fail [filterflags]
 	mox queue drop [filterflags]
 	mox queue dump id
@@ -53,13 +67,15 @@ any parameters. Followed by the help and usage information for each command.
 	mox import mbox accountname mailboxname mbox
 	mox export maildir [-single] dst-dir account-path [mailbox]
 	mox export mbox [-single] dst-dir account-path [mailbox]
+	mox export imap [-maildir] [-recursive] [-since date] [-before date] account [mailbox ...]
 	mox localserve
 	mox help [command ...]
 	mox backup destdir
 	mox verifydata data-dir
+	mox backupmerge basedir incrementaldir destdir
 	mox licenses
 	mox config test
-	mox config dnscheck domain
+	mox config dnscheck [-zone file] domain
 	mox config dnsrecords domain
 	mox config describe-domains >domains.conf
 	mox config describe-static >mox.conf
@@ -70,10 +86,13 @@ any parameters. Followed by the help and usage information for each command.
 	mox config account enable account
 	mox config address add address account
 	mox config address rm address
-	mox config domain add [-disabled] domain account [localpart]
+	mox config domain add [-disabled] [-aliasof domain] domain account [localpart]
 	mox config domain rm domain
 	mox config domain disable domain
 	mox config domain enable domain
+	mox config domain retire [-newdomain domain] [-message text] [-rejectafter date] [-loginuntil date] domain
+	mox config domain unretire domain
+	mox config domain export domain >export.tgz
 	mox config tlspubkey list [account]
 	mox config tlspubkey get fingerprint
 	mox config tlspubkey add address [name] < cert.pem
@@ -88,10 +107,13 @@ any parameters. Followed by the help and usage information for each command.
 	mox config alias rmaddr alias@domain rcpt1@domain ...
 	mox config describe-sendmail >/etc/moxsubmit.conf
 	mox config printservice >mox.service
+	mox config webserver-snippets [-watch] [-proxy url] dir
 	mox config ensureacmehostprivatekeys
 	mox config example [name]
 	mox admin imapserve preauth-address
+	mox bimi lookup [-selector selector] domain
 	mox checkupdate
+	mox update
 	mox cid cid
 	mox clientconfig domain
 	mox dane dial host:port
@@ -110,6 +132,9 @@ any parameters. Followed by the help and usage information for each command.
 	mox dmarc checkreportaddrs domain
 	mox dnsbl check zone ip
 	mox dnsbl checkhealth zone
+	mox doctor [-probe url] [-nodns]
+	mox genapi [-format typescript|openapi|go] [-baseurl url] [-package name] admin|account|webmail
+	mox junk explain account msgid
 	mox mtasts lookup domain
 	mox rdap domainage domain
 	mox retrain [accountname]
@@ -125,8 +150,9 @@ any parameters. Followed by the help and usage information for each command.
 	mox example [name]
 	mox bumpuidvalidity account [mailbox]
 	mox reassignuids account [mailboxid]
-	mox fixuidmeta account
+	mox fixuidmeta [-dryrun] account
 	mox fixmsgsize [account]
+	mox compressmessages [-minsize bytes] [account]
 	mox reparse [account]
 	mox ensureparsed account
 	mox recalculatemailboxcounts account
@@ -142,6 +168,15 @@ IMAP. HTTP listeners are started for the admin/account web interfaces, and for
 automated TLS configuration. Missing essential TLS certificates are immediately
 requested, other TLS certificates are requested on demand.
 
+Mox must be started as root. It binds the sockets for the listeners, and then
+starts itself as unprivileged user. With systemd socket activation, the sockets
+passed by systemd are used for listeners with a matching address (IP and port,
+or path for unix domain sockets), instead of binding them. Systemd keeps the
+sockets open while mox restarts, so incoming connections wait instead of being
+refused. Sockets must match the addresses configured for the listeners exactly,
+e.g. a systemd socket listening on port 25 of all IPv6 addresses matches IP "::"
+in a listener, not "0.0.0.0".
+
 Only implemented on unix systems, not Windows.
 
 	usage: mox serve
@@ -201,8 +236,29 @@ period to finish their transaction and shut down. Under normal circumstances,
 only IMAP has long-living connections, with the IDLE command to get notified of
 new mail deliveries.
 
+For planned maintenance, "mox drain" first lets SMTP transactions and queue
+deliveries complete before shutting down.
+
 	usage: mox stop
 
+# mox drain
+
+Drain mox for planned maintenance, then shut it down.
+
+New incoming SMTP connections, and new transactions on existing connections, are
+refused with a temporary error (421), so remote servers retry later or at
+another MX host. Transactions in progress are completed. All messages in the
+outgoing queue that are not on hold are scheduled for immediate delivery.
+
+When no SMTP transactions and queue deliveries are in progress anymore, and no
+queued messages are due for delivery, or when the deadline has passed, mox shuts
+down like with "mox stop". Messages that could not be delivered remain in the
+queue, and delivery is retried after starting mox again.
+
+	usage: mox drain [-deadline duration]
+	  -deadline duration
+	    	maximum time to wait for transactions and deliveries before shutting down (default 5m0s)
+
 # mox setaccountpassword
 
 Set new password an account.
@@ -223,10 +279,143 @@ for an account.
 Set a new admin password, for the web interface.
 
 The password is read from stdin. Its bcrypt hash is stored in a file named
-"adminpasswd" in the configuration directory.
+"adminpasswd" in the configuration directory. Existing admin sessions are no
+longer valid after the password change.
 
 	usage: mox setadminpassword
 
+# mox setadmintotp
+
+Enable or disable two-factor authentication for the admin web interface.
+
+A new secret for time-based one-time passwords (TOTP) is generated and printed,
+to be added to an authenticator app. A code from the app is read from stdin to
+verify the app is set up correctly. Then the secret and hashes of newly
+generated recovery codes are written to the AdminTOTPFile in the configuration
+directory, and the recovery codes are printed. A recovery code can be used once
+instead of a TOTP code, for when the authenticator app is not available.
+
+With -disable, the TOTP file is removed, disabling two-factor authentication.
+
+	usage: mox setadmintotp
+	  -disable
+	    	disable two-factor authentication by removing the totp file
+
+# mox tenant setadminpassword
+
+Set a new admin password for a tenant, for the web interface.
+
+Tenant admins log in to the admin web interface with the tenant name as
+username, and can only manage the domains and accounts of the tenant. Tenants
+are configured in domains.conf.
+
+The password is read from stdin. Its bcrypt hash is stored in a file named
+"adminpasswd-<tenant>" in the configuration directory. Existing sessions of the
+tenant admin are no longer valid after the password change.
+
+	usage: mox tenant setadminpassword tenant
+
+# mox admin webauthn list
+
+List security keys and passkeys registered for logging in to the admin web interface.
+
+Credentials are registered in the admin web interface, for the host name the
+admin web interface was accessed at.
+
+	usage: mox admin webauthn list
+
+# mox admin webauthn reset
+
+Remove all security keys and passkeys registered for the admin web interface.
+
+For when a security key is lost and you are locked out. Logging in with the admin
+password remains possible, and new credentials can be registered after logging
+in.
+
+	usage: mox admin webauthn reset
+
+# mox authlockout list
+
+List IPs and accounts with recent failed authentication attempts.
+
+Only tracked when AuthLockout is configured in mox.conf. IPs or accounts with a
+"locked until" time in the future cannot authenticate until that time. IPv6
+addresses are tracked per /64 network.
+
+	usage: mox authlockout list
+
+# mox authlockout clear
+
+Clear failed authentication attempts and lockout for an IP or account.
+
+Without parameter, all failed attempts and lockouts are cleared.
+
+	usage: mox authlockout clear [ip | account]
+
+# mox capture start
+
+Start capturing raw SMTP/IMAP protocol traffic for an IP or account.
+
+For debugging interoperability problems with a specific client or server.
+Protocol data of new connections from the IP, or of connections authenticating
+as the account, is written to a file in data/captures/, with timestamps and
+connection IDs. Capturing stops after the duration, after the maximum number of
+connections has been captured, or when the file reaches 10MB.
+
+Credentials in authentication exchanges are redacted. Message data is not
+recorded, only its size, unless -data is specified.
+
+For accounts, capturing starts after authentication. Captures are kept in memory
+only, they do not persist across restarts.
+
+	usage: mox capture start [-duration duration] [-conns n] [-protocols smtp,submission,imap] [-data] ip | account
+	  -conns int
+	    	stop capturing after number of connections (default 10)
+	  -data
+	    	record message data, which may contain sensitive information
+	  -duration duration
+	    	stop capturing after duration (default 1m0s)
+	  -protocols string
+	    	comma-separated protocols to capture, default all: smtp, submission, imap
+
+# mox capture list
+
+List active protocol captures.
+
+	usage: mox capture list
+
+# mox capture stop
+
+Stop an active protocol capture.
+
+Without id, all active captures are stopped.
+
+	usage: mox capture stop [id]
+
+# mox list subscribers
+
+List subscribers of a mailing list.
+
+Mailing lists are configured in the domain configuration, see the Lists field in
+domains.conf. Prints the address and subscription time of each subscriber.
+
+	usage: mox list subscribers list@domain
+
+# mox list subscribe
+
+Subscribe an address to a mailing list, without confirmation.
+
+Subscribers can also subscribe themselves by sending a message with subject
+"subscribe" to the request address of the list, e.g. list-request@domain.
+
+	usage: mox list subscribe list@domain address
+
+# mox list unsubscribe
+
+Unsubscribe an address from a mailing list.
+
+	usage: mox list unsubscribe list@domain address
+
 # mox loglevels
 
 Print the log levels, or set a new default log level, or a level for the given package.
@@ -454,6 +643,39 @@ TLS.
 	  -transport value
 	    	transport to use for messages, empty string sets the default behaviour
 
+# mox queue requireverifiedtls
+
+Set whether verified TLS is required for delivery of matching messages.
+
+With value "yes", direct delivery requires STARTTLS with a certificate verified
+through DANE or otherwise PKIX/WebPKI, also when the recipient domain has no
+MTA-STS or DANE policy. Delivery attempts fail if verified TLS is not possible,
+with a DSN to the sender after the last attempt. A "TLS-Required: No" header is
+ignored.
+
+Value "no" restores the default behaviour. Verified TLS can still be required
+through the direct transport used for delivery.
+
+	usage: mox queue requireverifiedtls [filterflags] {yes | no}
+	  -account string
+	    	account that queued the message
+	  -from string
+	    	from address of message, use "@example.com" to match all messages for a domain
+	  -hold value
+	    	true or false, whether to match only messages that are (not) on hold
+	  -ids value
+	    	comma-separated list of message IDs
+	  -n int
+	    	number of messages to return
+	  -nextattempt string
+	    	filter by time of next delivery attempt relative to now, value must start with "<" (before now) or ">" (after now)
+	  -submitted string
+	    	filter by time of submission relative to now, value must start with "<" (before now) or ">" (after now)
+	  -to string
+	    	recipient address of message, use "@example.com" to match all messages for a domain
+	  -transport value
+	    	transport to use for messages, empty string sets the default behaviour
+
 # mox queue fail
 
 Fail delivery of matching messages, delivering DSNs.
@@ -778,6 +1000,30 @@ otherwise reconstructing the original could lose a ">".
 	  -single
 	    	export single mailbox, without any children. disabled if mailbox isn't specified.
 
+# mox export imap
+
+Export messages into a zip file delivered to the "Exports" mailbox of the account.
+
+The zip file is attached to a message in the "Exports" mailbox, which is created
+if needed. Users can retrieve it with their IMAP client, e.g. users that cannot
+use the accounts web page or webmail for exports.
+
+Without mailboxes, all mailboxes are exported, except the "Exports" mailbox. Only
+messages received in the period between -since and -before are exported, if
+specified. Dates are in the format yyyy-mm-dd (UTC) or RFC3339.
+
+The export counts towards the quota of the account.
+
+	usage: mox export imap [-maildir] [-recursive] [-since date] [-before date] account [mailbox ...]
+	  -before string
+	    	only export messages received before this date
+	  -maildir
+	    	export in maildir format instead of mbox
+	  -recursive
+	    	also export child mailboxes of the specified mailboxes
+	  -since string
+	    	only export messages received at or after this date
+
 # mox localserve
 
 Start a local SMTP/IMAP server that accepts all messages, useful when testing/developing software that sends email.
@@ -808,7 +1054,20 @@ Recipient addresses with the following localpart suffixes are handled specially:
 If the localpart begins with "mailfrom" or "rcptto", the error is returned
 during those commands instead of during "data".
 
+With -acme, a new configuration requests TLS certificates through ACME from the
+directory URL instead of using a self-signed certificate, for host name
+mox.localhost. This is useful for testing ACME with a local ACME provider such as
+Pebble, e.g. configured with "httpPort" 1080 and "tlsPort" 1443 for validation,
+or started with PEBBLE_VA_ALWAYS_VALID=1. The CA certificate for the HTTPS
+connection to the ACME provider, e.g. Pebble's test/certs/pebble.minica.pem, can
+be specified with -acmecacert. Pebble also supports external account binding,
+which can be configured in the generated mox.conf.
+
 	usage: mox localserve
+	  -acme string
+	    	acme directory url to request tls certificates from, e.g. https://localhost:14000/dir for a local pebble. only used when writing configuration, at first launch.
+	  -acmecacert string
+	    	file with pem-encoded ca certificate for the https connection to the acme provider. only used with -acme.
 	  -dir string
 	    	configuration storage directory (default "$userconfigdir/mox-localserve")
 	  -initonly
@@ -851,10 +1110,55 @@ are stored, but with a warning.
 Remove files in the destination directory before doing another backup. The
 backup command will not overwrite files, but print and return errors.
 
+A file backup.json is written to destdir, with the modification sequence of
+each account at the time of the backup. With the -incremental flag and the
+directory of a previous backup, an incremental backup is made: account
+databases are not copied, instead a journal with changes to an account since
+the previous backup is written to "data/accounts/<account>/journal.jsonl". A
+change of message flags only adds a small record to the journal. Only message
+files added since the previous backup are hardlinked/copied. Other databases
+and files are copied in full. An incremental backup cannot be restored
+directly, it must first be merged with the previous backup with "mox
+backupmerge", resulting in a full backup.
+
+With the -linkdest flag and the directory of a previous (full) backup, a full
+backup is made, but message files and database files that are unchanged since
+that previous backup are hardlinked to the files in the previous backup instead
+of copied. Useful for frequent backups to a file system other than that of the
+data directory, where message files cannot be hardlinked to the data directory.
+Message files are recognized as unchanged by their size and modification time,
+which are kept when copying. Databases are recognized as unchanged by their
+modification time, which is recorded in backup.json. Such backups can be restored
+directly. Previous backups can be removed independently, but keep in mind that
+changing a hardlinked file (e.g. with "mox verifydata -fix") also changes it in
+other backups.
+
+The destination can also be a remote location, where files are written to
+directly, without needing local space for staging. Incremental backups and
+-linkdest are not supported for remote destinations. Use a new destination for
+each backup. Supported remote destinations:
+
+  - s3://<bucket>/<prefix>, for S3-compatible object storage. Credentials are
+    read from environment variables AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY,
+    and optional AWS_SESSION_TOKEN. The region is read from AWS_REGION (default
+    us-east-1). For storage other than AWS, set AWS_ENDPOINT_URL to an http or
+    https URL, e.g. https://minio.example:9000, for which path-style requests are
+    made. Existing objects are overwritten.
+  - sftp://<user>@<host>[:<port>]/<dir>, for SFTP over SSH. Authentication is with
+    the private key from the file in $MOX_BACKUP_SSH_KEY, or ~/.ssh/id_ed25519,
+    id_ecdsa or id_rsa. The key must not be encrypted with a passphrase. The host
+    key of the server must be in the file in $MOX_BACKUP_SSH_KNOWN_HOSTS, or
+    ~/.ssh/known_hosts. Existing files are not overwritten.
+
+The rsync protocol is not supported, use sftp instead.
+
 Exit code 0 indicates the backup was successful. A clean successful backup does
 not print any output, but may print warnings. Use the -verbose flag for
 details, including timing.
 
+Backups can also be made by "mox serve" on a schedule, with retention of old
+backups, see BackupSchedule in mox.conf.
+
 To restore a backup, first shut down mox, move away the old data directory and
 move an earlier backed up directory in its place, run "mox verifydata
 <datadir>", possibly with the "-fix" option, and restart mox. After the
@@ -869,6 +1173,10 @@ unrecognized message files), so you should make a new backup before actually
 upgrading.
 
 	usage: mox backup destdir
+	  -incremental string
+	    	directory of previous backup to make an incremental backup against
+	  -linkdest string
+	    	directory of previous backup to hardlink unchanged message and database files to
 	  -verbose
 	    	print progress
 
@@ -883,7 +1191,8 @@ specified, unrecognized message files are moved away. This may be needed after
 a restore, because messages enqueued or delivered in the future may get those
 message sequence numbers assigned and writing the message file would fail.
 Consistency of message/mailbox UID, UIDNEXT and UIDVALIDITY is verified as
-well.
+well. Deduplicated message files are verified to have contents matching their
+hash.
 
 Because verifydata opens the database files, schema upgrades may automatically
 be applied. This can happen if you use a new mox release. It is useful to run
@@ -892,12 +1201,48 @@ copy of the database files, as made with "mox backup". Before upgrading, make a
 new backup again since "mox verifydata" may have upgraded the database files,
 possibly making them potentially no longer readable by the previous version.
 
+For large data directories, accounts can be verified in parallel with
+-parallel, a single account can be verified with -account (skipping the other
+databases and files), and checks of message files (existence, sizes and
+unrecognized files) can be skipped with -skip-message-files, only verifying the
+databases. With -json, a summary is printed in JSON format instead of a single
+line.
+
 	usage: mox verifydata data-dir
+	  -account string
+	    	only verify this account, not the other databases and files
 	  -fix
 	    	fix fixable problems, such as moving away message files not referenced by their database
+	  -json
+	    	print summary in JSON format
+	  -parallel int
+	    	number of accounts to verify in parallel (default 1)
+	  -skip-message-files
+	    	skip checks of message files, including existence, size and unrecognized files, and contents of deduplicated message files
 	  -skip-size-check
 	    	skip the check for message size
 
+# mox backupmerge
+
+Merge an incremental backup with the backup it was based on, into a full backup.
+
+Incremental backups, made with "mox backup -incremental", don't have copies of
+account databases, but journals with changes to the account databases since the
+previous backup, and only the message files added since. This command makes a
+full backup in destdir (which must not yet exist) by copying the account
+databases from basedir and applying the journals from incrementaldir, and
+copying all other files from incrementaldir. Message files are hardlinked if
+possible. The base backup and incremental backup are not modified.
+
+The base backup must be the backup that the incremental backup was made
+against. That can be a full backup, or an earlier incremental backup that has
+been merged. The resulting backup can be restored as a regular backup, and be
+used as base for merging a next incremental backup.
+
+Mox does not have to be running for this command.
+
+	usage: mox backupmerge basedir incrementaldir destdir
+
 # mox licenses
 
 Print licenses of mox source code and dependencies.
@@ -917,7 +1262,18 @@ are printed.
 
 Check the DNS records with the configuration for the domain, and print any errors/warnings.
 
-	usage: mox config dnscheck domain
+With -zone, the checks are done as if the DNS records from the file were
+published, so proposed DNS changes can be validated before publishing them.
+The file is in zone file format, e.g. as printed by "mox config dnsrecords",
+with names relative to the domain. Use "-" to read the records from stdin.
+Records in the file replace all published records of the same name and type.
+Records of types A, AAAA, MX, TXT, CNAME and TLSA are evaluated, other records
+are ignored. Records that are looked up but not in the file are looked up in
+DNS.
+
+	usage: mox config dnscheck [-zone file] domain
+	  -zone string
+	    	file with proposed dns records in zone file format
 
 # mox config dnsrecords
 
@@ -1033,7 +1389,14 @@ The domain can be created in disabled mode, preventing automatically requesting
 TLS certificates with ACME, and rejecting incoming/outgoing messages involving
 the domain, but allowing further configuration of the domain.
 
-	usage: mox config domain add [-disabled] domain account [localpart]
+With -aliasof, the new domain is an alias of an existing domain: all addresses
+of the existing domain are also valid at the new domain, for delivery, login and
+sending. The account must already exist. The new domain gets its own DKIM keys
+and needs its own DNS records.
+
+	usage: mox config domain add [-disabled] [-aliasof domain] domain account [localpart]
+	  -aliasof string
+	    	make the new domain an alias of this existing domain
 	  -disabled
 	    	disable the new domain
 
@@ -1063,6 +1426,57 @@ Incoming/outgoing messages involving this domain will be accepted again.
 
 	usage: mox config domain enable domain
 
+# mox config domain retire
+
+Start decommissioning a domain and reload the configuration.
+
+A gradual alternative to "mox config domain rm". Until the date of
+-rejectafter, incoming messages for the domain are delivered as usual, and
+senders get an automatic reply, at most once per week per sender, that the
+address will stop accepting messages. From that date, incoming messages are
+rejected with a permanent error. If -newdomain is set, the error is a 551 that
+refers to the same localpart at the new domain, otherwise a 550. The text for
+rejections and automatic replies can be set with -message, where
+"{newaddress}" is replaced with the new address.
+
+Accounts with the domain as their default domain can still log in, e.g. to
+fetch their messages over IMAP, until the date of -loginuntil. After, logins
+are disabled for those accounts. Without -loginuntil, logins are not disabled.
+
+Dates are of the form 2006-01-02, or RFC3339 timestamps. A bundle with the
+configuration and messages of the accounts of the domain can be made with
+"mox config domain export", e.g. before removing the domain.
+
+	usage: mox config domain retire [-newdomain domain] [-message text] [-rejectafter date] [-loginuntil date] domain
+	  -loginuntil string
+	    	date from which logins of accounts of the domain are disabled
+	  -message string
+	    	text for rejections and automatic replies instead of default
+	  -newdomain string
+	    	domain addresses have moved to
+	  -rejectafter string
+	    	date from which messages are rejected, with automatic replies until then; default is to reject immediately
+
+# mox config domain unretire
+
+Stop decommissioning a domain and reload the configuration.
+
+Incoming messages are accepted again. Logins of accounts that were disabled
+after the grace period of the retired domain are not enabled again.
+
+	usage: mox config domain unretire domain
+
+# mox config domain export
+
+Export a bundle with the configuration and messages for a domain.
+
+The gzipped tar file written to stdout contains a file "domains.conf" with the
+configuration of the domain and of the accounts with the domain as their
+default domain, and the messages of those accounts in maildir format, in
+directory "accounts/<name>/". Useful before removing a retired domain.
+
+	usage: mox config domain export domain >export.tgz
+
 # mox config tlspubkey list
 
 List TLS public keys for TLS client certificate authentication.
@@ -1186,6 +1600,33 @@ date version.
 
 	usage: mox config printservice >mox.service
 
+# mox config webserver-snippets
+
+Export configuration snippets and static files for an existing webserver.
+
+For setups where an existing webserver handles ports 80 and 443 (see "mox
+quickstart -existing-webserver"), the MTA-STS policies and autoconfig files of
+all configured domains can be served as static files by that webserver, instead
+of forwarding the requests to mox.
+
+Static files are written to dir/static/<hostname>/, for the mta-sts.<domain>
+and autoconfig.<domain> hostnames. Configuration snippets with a virtual host
+for each hostname are written to dir/nginx.conf, dir/Caddyfile and
+dir/apache.conf, for including in the webserver configuration. Paths to TLS
+certificates must still be configured for nginx and apache. Autodiscover
+requests include the email address and are forwarded to mox at the proxy URL,
+by default the address of the listener serving autoconfig without TLS.
+
+With -watch, the command keeps running and regenerates the files when the
+domains configuration changes. The webserver may need a reload to pick up new
+virtual hosts.
+
+	usage: mox config webserver-snippets [-watch] [-proxy url] dir
+	  -proxy string
+	    	url to forward autodiscover requests to, e.g. http://127.0.0.1:81; default is based on the listener config
+	  -watch
+	    	keep running and regenerate files when domains.conf changes
+
 # mox config ensureacmehostprivatekeys
 
 Ensure host private keys exist for TLS listeners with ACME.
@@ -1229,6 +1670,18 @@ does not require TLS.
 	  -fd0
 	    	write IMAP to file descriptor 0 instead of stdout
 
+# mox bimi lookup
+
+Lookup BIMI record for domain, a DNS TXT record at <selector>._bimi.<domain>, validate and print it.
+
+If no record exists at the domain, the organizational domain is checked. The
+DMARC policy of the domain is checked too: BIMI logos are only shown for
+messages from domains with an enforcing DMARC policy.
+
+	usage: mox bimi lookup [-selector selector] domain
+	  -selector string
+	    	selector of bimi record (default "default")
+
 # mox checkupdate
 
 Check if a newer version of mox is available.
@@ -1240,6 +1693,50 @@ printed.
 
 	usage: mox checkupdate
 
+# mox update
+
+Update the mox binary to the latest version.
+
+Like "mox checkupdate", the latest version is looked up in DNS and the signed
+changelog is fetched and printed. If a newer version is available for this
+platform, it is installed:
+
+1. The binary is downloaded, by default from https://updates.xmox.nl/binary. Its
+size and SHA-256 hash must match a description signed with the public key that
+is also used for the changelog, for the expected version and platform. Releases
+are reproducible builds, so the signed hash can be compared against your own
+build of the release.
+2. The binary is staged next to the current binary, with suffix ".new", and must
+report the expected version.
+3. A backup is made of the running mox instance (in the "tmp" directory of the
+data directory), and the new binary runs "mox verifydata" on the backup, which
+applies any database upgrades. The backup is removed afterwards.
+4. The current binary is kept with suffix ".old", and the new binary is moved in
+its place. If the new binary does not start, the old binary is restored.
+
+Mox itself is not restarted, do that afterwards, e.g. with "systemctl restart
+mox". To go back to the previous version, stop mox and move the ".old" binary
+back, but keep in mind the database files may have been upgraded after the
+restart. The mox binary must be writable by the user running this command.
+
+The domain for looking up the latest version, the URLs for the changelog and
+binaries, and the public key can be changed with flags, e.g. for updating from
+your own builds. The update server must serve a signed binary description at
+"<binaryurl>/<version>/<goos>-<goarch>.json", and the binary at the same path
+without ".json".
+
+	usage: mox update
+	  -binaryurl string
+	    	base url to fetch signed binary descriptions and binaries from (default "https://updates.xmox.nl/binary")
+	  -changelogurl string
+	    	url to fetch changelog from (default "https://updates.xmox.nl/changelog")
+	  -domain string
+	    	domain to look up latest version for in DNS, at _updates.<domain> (default "xmox.nl")
+	  -pubkey string
+	    	base64-encoded ed25519 public key for verifying the changelog and binary descriptions (default "sPNiTDQzvb4FrytNEiebJhgyQzn57RwEjNbGWMM/bDY=")
+	  -yes
+	    	do not ask for confirmation before installing
+
 # mox cid
 
 Turn an ID from a Received header into a cid, for looking up in logs.
@@ -1453,8 +1950,90 @@ Check the health of the DNS blocklist represented by zone, e.g. bl.spamcop.net.
 The health of a DNS blocklist can be checked by querying for 127.0.0.1 and
 127.0.0.2. The second must and the first must not be present.
 
+A running mox periodically checks the health of all configured DNSBLs, and
+whether its IPs are listed, and exports the results as prometheus metrics
+mox_dnsbl_zone_healthy, mox_dnsbl_zone_ips_listed and mox_dnsbl_ips_success.
+
 	usage: mox dnsbl checkhealth zone
 
+# mox doctor
+
+Check the mox installation and its environment, and print prioritized actions.
+
+Checks done through the running mox instance: TLS certificates of listeners
+(expiration, failed ACME requests), health of the databases, free disk space in
+the data directory, backlog in the delivery queue, and the rate of logged errors
+and unhandled panics since startup.
+
+Checks done by this command: the DNS records of all enabled domains, as with
+"mox config dnscheck", including reverse DNS (iprev) of the IPs, and clock skew
+of this machine. The clock is compared with the Date header of HTTP responses
+from the probe service, or otherwise from the directory URLs of the configured
+ACME providers.
+
+With -probe, ports of public IPs of listeners are checked for reachability from
+the internet through an external probe service. The URL must contain "{host}"
+and "{port}", which are replaced for each IP (or listener host name when
+listening on all IPs) and port. A response with HTTP status 2xx indicates the
+port is reachable, any other status that it is not. Example:
+
+	mox doctor -probe 'https://probe.example/tcp?host={host}&port={port}'
+
+Findings are printed with errors first, followed by warnings, informational
+findings and checks that passed. The exit status is 1 if any errors were found.
+
+	usage: mox doctor [-probe url] [-nodns]
+	  -nodns
+	    	skip checking dns records of domains
+	  -probe string
+	    	url of external service for probing reachability of ports, with {host} and {port} placeholders
+
+# mox genapi
+
+Generate client definitions for a web API.
+
+The web interfaces for admin, account and webmail are implemented with sherpa
+APIs: Functions are called with an HTTP POST to <baseurl><function>, with a JSON
+request body {"params": [...]}, and respond with {"result": ...} or {"error":
+{"code": ..., "message": ...}}. Function calls require the authentication
+cookie and CSRF token (in the x-mox-csrf header) from a login. The API
+documentation, in sherpadoc JSON, is served without authentication at
+<baseurl>sherpadoc.json, e.g. https://mail.example.org/admin/api/sherpadoc.json.
+
+This command generates definitions for the API from the documentation built
+into this version of mox:
+
+- typescript: a client, like the one used by the mox web interfaces.
+- openapi: an OpenAPI 3.1 specification, in JSON.
+- go: a Go package with types and a client.
+
+The base URL is used in the generated client or specification. For
+typescript, the base URL defaults to "api", which is resolved relative to the
+current page.
+
+	usage: mox genapi [-format typescript|openapi|go] [-baseurl url] [-package name] admin|account|webmail
+	  -baseurl string
+	    	base url of api, ending with a slash, e.g. https://mail.example.org/admin/api/
+	  -format string
+	    	format of generated definitions: typescript, openapi or go (default "typescript")
+	  -package string
+	    	package name for go client, default is the api name
+
+# mox junk explain
+
+Explain the junk classification of a message.
+
+Prints the spam probability, threshold and most significant ham and spam words
+of the junk filter classification that was done during delivery of the message,
+as also shown in the X-Mox-Reason header and in the webmail. If no
+classification was stored with the message, e.g. because the sender had a good
+reputation, the message is classified with the current junk filter.
+
+The message ID can be found in the webmail, e.g. in the URL when a message is
+opened.
+
+	usage: mox junk explain account msgid
+
 # mox mtasts lookup
 
 Lookup the MTASTS record and policy for the domain.
@@ -1665,10 +2244,16 @@ Each mailbox has a UIDVALIDITY sequence number, which should always be lower
 than the per-account next UIDVALIDITY to use. If it is not, the account next
 UIDVALIDITY is updated.
 
+These are the inconsistencies reported by "mox verifydata" that refer to this
+command. Mailboxes that have been removed are checked as well. With -dryrun, the
+changes are printed but not made.
+
 Opens account database file directly. Ensure mox does not have the account
 open, or is not running.
 
-	usage: mox fixuidmeta account
+	usage: mox fixuidmeta [-dryrun] account
+	  -dryrun
+	    	only print the changes that would be made
 
 # mox fixmsgsize
 
@@ -1682,6 +2267,22 @@ refetch messages.
 
 	usage: mox fixmsgsize [account]
 
+# mox compressmessages
+
+Compress the on-disk files of existing messages with zstd.
+
+Only messages not yet compressed and with a file size of at least minsize bytes
+are compressed. Files that would not become smaller are left alone. Compressed
+messages are decompressed transparently when read. New messages are only stored
+compressed if MessageCompression is configured in mox.conf.
+
+Messages are compressed in batches, so other access to the mailboxes/messages
+is not blocked while compressing.
+
+	usage: mox compressmessages [-minsize bytes] [account]
+	  -minsize int
+	    	only compress message files of at least this many bytes
+
 # mox reparse
 
 Parse all messages in the account or all accounts again.
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+	"github.com/mjl-/sherpa"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dmarcdb"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/mtastsdb"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/rejectdb"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/tlsrptdb"
+	"github.com/mjl-/mox/webadmin"
+)
+
+// doctorFinding is the result of a single check by "mox doctor". Findings from
+// the running mox instance are sent as JSON over the ctl connection.
+type doctorFinding struct {
+	Severity string // "error", "warning", "info" or "ok".
+	Check    string // Area of the check, e.g. "dns", "tls", "disk", "queue".
+	Message  string
+	Action   string `json:",omitempty"` // Suggested action for the operator, if any.
+}
+
+// Severities of findings, in order of priority.
+var doctorSeverities = []string{"error", "warning", "info", "ok"}
+
+func cmdDoctor(c *cmd) {
+	c.params = "[-probe url] [-nodns]"
+	c.help = `Check the mox installation and its environment, and print prioritized actions.
+
+Checks done through the running mox instance: TLS certificates of listeners
+(expiration, failed ACME requests), health of the databases, free disk space in
+the data directory, backlog in the delivery queue, and the rate of logged errors
+and unhandled panics since startup.
+
+Checks done by this command: the DNS records of all enabled domains, as with
+"mox config dnscheck", including reverse DNS (iprev) of the IPs, and clock skew
+of this machine. The clock is compared with the Date header of HTTP responses
+from the probe service, or otherwise from the directory URLs of the configured
+ACME providers.
+
+With -probe, ports of public IPs of listeners are checked for reachability from
+the internet through an external probe service. The URL must contain "{host}"
+and "{port}", which are replaced for each IP (or listener host name when
+listening on all IPs) and port. A response with HTTP status 2xx indicates the
+port is reachable, any other status that it is not. Example:
+
+	mox doctor -probe 'https://probe.example/tcp?host={host}&port={port}'
+
+Findings are printed with errors first, followed by warnings, informational
+findings and checks that passed. The exit status is 1 if any errors were found.
+`
+	var probe string
+	var nodns bool
+	c.flag.StringVar(&probe, "probe", "", "url of external service for probing reachability of ports, with {host} and {port} placeholders")
+	c.flag.BoolVar(&nodns, "nodns", false, "skip checking dns records of domains")
+	args := c.Parse()
+	if len(args) != 0 {
+		c.Usage()
+	}
+	if probe != "" && (!strings.Contains(probe, "{host}") || !strings.Contains(probe, "{port}")) {
+		c.Usage()
+	}
+
+	mustLoadConfig()
+
+	var findings []doctorFinding
+	add := func(severity, check, action, format string, args ...any) {
+		findings = append(findings, doctorFinding{severity, check, fmt.Sprintf(format, args...), action})
+	}
+
+	if conn, err := net.Dial("unix", mox.DataDirPath("ctl")); err != nil {
+		add("error", "serve", "Start mox, e.g. with \"systemctl start mox\", and check its logs.", "mox does not appear to be running, cannot connect to control socket: %v", err)
+	} else {
+		conn.Close()
+		findings = append(findings, ctlcmdDoctor(xctl())...)
+	}
+
+	var dates []time.Time
+	if probe != "" {
+		l, probeDates := doctorProbe(probe)
+		findings = append(findings, l...)
+		dates = append(dates, probeDates...)
+	} else {
+		add("info", "ports", "Use -probe with an external probe service to check reachability.", "reachability of ports from the internet not checked")
+	}
+	if len(dates) == 0 {
+		for _, name := range slices.Sorted(maps.Keys(mox.Conf.Static.ACME)) {
+			if t, err := doctorHTTPDate(mox.Conf.Static.ACME[name].DirectoryURL); err != nil {
+				add("warning", "clock", "", "fetching date from acme provider %s: %v", name, err)
+			} else {
+				dates = append(dates, t)
+			}
+		}
+	}
+	findings = append(findings, doctorClock(dates)...)
+
+	if !nodns {
+		for _, d := range mox.Conf.Domains() {
+			if dc, _ := mox.Conf.Domain(xparseDomain(d, "domain")); dc.Disabled {
+				continue
+			}
+			findings = append(findings, doctorDNS(d)...)
+		}
+	}
+
+	slices.SortStableFunc(findings, func(a, b doctorFinding) int {
+		return slices.Index(doctorSeverities, a.Severity) - slices.Index(doctorSeverities, b.Severity)
+	})
+	counts := map[string]int{}
+	for _, f := range findings {
+		counts[f.Severity]++
+		fmt.Printf("%-7s %-8s %s\n", f.Severity, f.Check, f.Message)
+		if f.Action != "" {
+			fmt.Printf("%-16s action: %s\n", "", f.Action)
+		}
+	}
+	fmt.Printf("\n%d errors, %d warnings, %d info, %d ok\n", counts["error"], counts["warning"], counts["info"], counts["ok"])
+	if counts["error"] > 0 {
+		os.Exit(1)
+	}
+}
+
+func ctlcmdDoctor(ctl *ctl) []doctorFinding {
+	ctl.xwrite("doctor")
+	ctl.xreadok()
+	var l []doctorFinding
+	xparseJSON(ctl, ctl.xread(), &l)
+	return l
+}
+
+// doctorDNS checks the DNS records of a domain, like "mox config dnscheck".
+func doctorDNS(domain string) (l []doctorFinding) {
+	action := fmt.Sprintf("Fix the DNS records, see \"mox config dnsrecords %s\" and \"mox config dnscheck %s\".", domain, domain)
+
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		err, ok := x.(*sherpa.Error)
+		if !ok {
+			panic(x)
+		}
+		l = append(l, doctorFinding{"error", "dns", fmt.Sprintf("%s: checking dns records: %s", domain, err.Message), action})
+	}()
+
+	r := webadmin.CheckDomainResolver(context.Background(), dns.StrictResolver{Pkg: "doctor"}, domain)
+	results := []struct {
+		name string
+		r    webadmin.Result
+	}{
+		{"DNSSEC", r.DNSSEC.Result},
+		{"reverse DNS (iprev)", r.IPRev.Result},
+		{"MX", r.MX.Result},
+		{"TLS", r.TLS.Result},
+		{"DANE", r.DANE.Result},
+		{"SPF", r.SPF.Result},
+		{"DKIM", r.DKIM.Result},
+		{"DMARC", r.DMARC.Result},
+		{"host TLSRPT", r.HostTLSRPT.Result},
+		{"domain TLSRPT", r.DomainTLSRPT.Result},
+		{"MTA-STS", r.MTASTS.Result},
+		{"BIMI", r.BIMI.Result},
+		{"SRV conf", r.SRVConf.Result},
+		{"autoconfig", r.Autoconf.Result},
+		{"autodiscover", r.Autodiscover.Result},
+	}
+	for _, res := range results {
+		for _, s := range res.r.Errors {
+			l = append(l, doctorFinding{"error", "dns", fmt.Sprintf("%s: %s: %s", domain, res.name, s), action})
+		}
+		for _, s := range res.r.Warnings {
+			l = append(l, doctorFinding{"warning", "dns", fmt.Sprintf("%s: %s: %s", domain, res.name, s), action})
+		}
+	}
+	if len(l) == 0 {
+		l = append(l, doctorFinding{"ok", "dns", fmt.Sprintf("%s: dns records", domain), ""})
+	}
+	return l
+}
+
+// doctorProbe checks reachability of the ports of public IPs of listeners through
+// an external probe service. Dates of the probe responses are returned for
+// checking clock skew.
+func doctorProbe(probe string) (l []doctorFinding, dates []time.Time) {
+	for _, name := range slices.Sorted(maps.Keys(mox.Conf.Static.Listeners)) {
+		lis := mox.Conf.Static.Listeners[name]
+
+		addrs := lis.IPs
+		if len(lis.NATIPs) > 0 {
+			addrs = lis.NATIPs
+		}
+		hostname := mox.Conf.Static.HostnameDomain
+		if lis.Hostname != "" {
+			hostname = lis.HostnameDomain
+		}
+		var hosts []string
+		for _, s := range addrs {
+			ip := net.ParseIP(s)
+			if ip.IsUnspecified() {
+				hosts = append(hosts, hostname.ASCII)
+			} else if !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() {
+				hosts = append(hosts, ip.String())
+			}
+		}
+		hosts = slices.Compact(hosts)
+
+		for _, host := range hosts {
+			for _, p := range doctorListenerPorts(lis) {
+				u := strings.ReplaceAll(probe, "{host}", url.QueryEscape(host))
+				u = strings.ReplaceAll(u, "{port}", strconv.Itoa(p.port))
+				addr := net.JoinHostPort(host, strconv.Itoa(p.port))
+
+				severity := "warning"
+				if p.port == 25 {
+					// Without incoming SMTP, no email can be received.
+					severity = "error"
+				}
+				resp, err := doctorHTTPClient.Get(u)
+				if err != nil {
+					l = append(l, doctorFinding{"warning", "ports", fmt.Sprintf("listener %s: %s (%s): probe failed: %v", name, addr, p.service, err), "Check the probe URL."})
+					continue
+				}
+				resp.Body.Close()
+				if t, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+					dates = append(dates, t)
+				}
+				if resp.StatusCode/100 != 2 {
+					l = append(l, doctorFinding{severity, "ports", fmt.Sprintf("listener %s: %s (%s) not reachable from internet according to probe, status %s", name, addr, p.service, resp.Status), "Check firewalls and port forwarding, and whether the hosting provider blocks the port."})
+				} else {
+					l = append(l, doctorFinding{"ok", "ports", fmt.Sprintf("listener %s: %s (%s) reachable from internet", name, addr, p.service), ""})
+				}
+			}
+		}
+	}
+	return
+}
+
+var doctorHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+type doctorPort struct {
+	service string
+	port    int
+}
+
+// doctorListenerPorts returns the TCP ports of enabled services of a listener.
+func doctorListenerPorts(lis config.Listener) (l []doctorPort) {
+	add := func(enabled bool, service string, port, defaultPort int) {
+		if !enabled {
+			return
+		}
+		if port == 0 {
+			port = defaultPort
+		}
+		if !slices.ContainsFunc(l, func(p doctorPort) bool { return p.port == port }) {
+			l = append(l, doctorPort{service, port})
+		}
+	}
+	add(lis.SMTP.Enabled, "smtp", lis.SMTP.Port, 25)
+	add(lis.Submission.Enabled, "submission", lis.Submission.Port, 587)
+	add(lis.Submissions.Enabled, "submissions", lis.Submissions.Port, 465)
+	add(lis.IMAP.Enabled, "imap", lis.IMAP.Port, 143)
+	add(lis.IMAPS.Enabled, "imaps", lis.IMAPS.Port, 993)
+	add(lis.POP3.Enabled, "pop3", lis.POP3.Port, 110)
+	add(lis.POP3S.Enabled, "pop3s", lis.POP3S.Port, 995)
+	add(lis.ManageSieve.Enabled, "managesieve", lis.ManageSieve.Port, 4190)
+	add(lis.AccountHTTPS.Enabled, "https", lis.AccountHTTPS.Port, 443)
+	add(lis.WebmailHTTPS.Enabled, "https", lis.WebmailHTTPS.Port, 443)
+	add(lis.WebAPIHTTPS.Enabled, "https", lis.WebAPIHTTPS.Port, 443)
+	add(lis.AutoconfigHTTPS.Enabled, "https", lis.AutoconfigHTTPS.Port, 443)
+	add(lis.MTASTSHTTPS.Enabled, "https", lis.MTASTSHTTPS.Port, 443)
+	add(lis.WebserverHTTPS.Enabled, "https", lis.WebserverHTTPS.Port, 443)
+	add(lis.WebserverHTTP.Enabled, "http", lis.WebserverHTTP.Port, 80)
+	return l
+}
+
+// doctorHTTPDate returns the time from the Date header of a response for u.
+func doctorHTTPDate(u string) (time.Time, error) {
+	resp, err := doctorHTTPClient.Get(u)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp.Body.Close()
+	return http.ParseTime(resp.Header.Get("Date"))
+}
+
+// doctorClock checks the local clock against dates from remote HTTP servers.
+func doctorClock(dates []time.Time) []doctorFinding {
+	if len(dates) == 0 {
+		return []doctorFinding{{"info", "clock", "clock skew not checked, no probe service or acme provider to compare with", ""}}
+	}
+	// Date headers have second resolution, and requests take some time.
+	var skew time.Duration
+	for _, t := range dates {
+		d := time.Since(t)
+		if d < 0 {
+			d = -d
+		}
+		skew = max(skew, d)
+	}
+	action := "Synchronize the clock, e.g. with ntp or systemd-timesyncd."
+	if skew > 5*time.Minute {
+		return []doctorFinding{{"error", "clock", fmt.Sprintf("clock skew of %s, breaking tls certificate, dkim signature and totp verification", skew.Round(time.Second)), action}}
+	} else if skew > time.Minute {
+		return []doctorFinding{{"warning", "clock", fmt.Sprintf("clock skew of %s", skew.Round(time.Second)), action}}
+	}
+	return []doctorFinding{{"ok", "clock", "no clock skew", ""}}
+}
+
+// doctorServer runs the checks of "mox doctor" that need state of the running
+// mox instance.
+func doctorServer(ctx context.Context, log mlog.Log) []doctorFinding {
+	var l []doctorFinding
+	add := func(severity, check, action, format string, args ...any) {
+		l = append(l, doctorFinding{severity, check, fmt.Sprintf(format, args...), action})
+	}
+
+	// TLS certificates.
+	for _, c := range (webadmin.Admin{}).TLSCertificates(ctx) {
+		var what string
+		action := "Check the certificate files and replace the certificate."
+		if c.ACME != "" {
+			what = fmt.Sprintf("acme %s certificate for %s (%s)", c.ACME, c.Hostname, c.KeyType)
+			action = "Check that the host name resolves to this machine and port 443 is reachable, see the TLS page in the admin web interface."
+		} else {
+			what = fmt.Sprintf("listener %s certificate file %s", c.Listener, c.CertFile)
+		}
+		now := time.Now()
+		switch {
+		case c.Error != "":
+			add("error", "tls", action, "%s: %s", what, c.Error)
+		case !c.Present && c.ACME != "" && c.LastError != "":
+			add("error", "tls", action, "%s: not present, last request failed at %s: %s", what, c.LastErrorTime.Format(time.RFC3339), c.LastError)
+		case !c.Present && c.ACME != "":
+			add("info", "tls", "", "%s: not yet requested, certificates are requested when first needed", what)
+		case !c.Present:
+			add("error", "tls", action, "%s: not loaded", what)
+		case now.After(c.NotAfter):
+			add("error", "tls", action, "%s: expired at %s", what, c.NotAfter.Format(time.RFC3339))
+		case c.ACME != "" && c.LastError != "" && now.After(c.RenewAfter):
+			add("error", "tls", action, "%s: expires at %s, renewal failed at %s: %s", what, c.NotAfter.Format(time.RFC3339), c.LastErrorTime.Format(time.RFC3339), c.LastError)
+		case c.ACME == "" && c.NotAfter.Sub(now) < 14*24*time.Hour:
+			add("warning", "tls", action, "%s: expires soon, at %s", what, c.NotAfter.Format(time.RFC3339))
+		default:
+			add("ok", "tls", "", "%s: valid until %s", what, c.NotAfter.Format(time.RFC3339))
+		}
+	}
+
+	// Databases. We only check whether they can be read.
+	checkDB := func(name string, db *bstore.DB) {
+		if db == nil {
+			return
+		}
+		err := db.Read(ctx, func(tx *bstore.Tx) error {
+			_, err := tx.Types()
+			return err
+		})
+		if err != nil {
+			add("error", "database", "Check the logs, and consider running \"mox verifydata\" on a backup.", "%s: reading database: %v", name, err)
+		} else {
+			add("ok", "database", "", "%s: readable", name)
+		}
+	}
+	checkDB("auth.db", store.AuthDB)
+	checkDB("queue.db", queue.DB)
+	checkDB("dmarcrpt.db", dmarcdb.ReportsDB)
+	checkDB("dmarceval.db", dmarcdb.EvalDB)
+	checkDB("mtasts.db", mtastsdb.DB)
+	checkDB("tlsrpt.db", tlsrptdb.ReportDB)
+	checkDB("tlsrptresult.db", tlsrptdb.ResultDB)
+	checkDB("rejected.db", rejectdb.DB)
+	for _, name := range slices.Sorted(slices.Values(mox.Conf.Accounts())) {
+		acc, err := store.OpenAccount(log, name, false)
+		if err != nil {
+			add("error", "database", "Check the logs and the account directory.", "account %s: open: %v", name, err)
+			continue
+		}
+		checkDB(fmt.Sprintf("account %s", name), acc.DB)
+		err = acc.Close()
+		log.Check(err, "closing account")
+	}
+
+	// Disk space in data directory.
+	dataDir := mox.DataDirPath("")
+	if free, total, err := diskFree(dataDir); err != nil {
+		add("info", "disk", "", "free disk space not checked: %v", err)
+	} else {
+		pct := float64(free) * 100 / float64(max(total, 1))
+		msg := fmt.Sprintf("%s: %.1f GiB free of %.1f GiB (%.1f%%)", dataDir, float64(free)/(1<<30), float64(total)/(1<<30), pct)
+		action := "Free up disk space or grow the file system, mox cannot accept messages without space."
+		if free < 1<<30 || pct < 5 {
+			add("error", "disk", action, "%s", msg)
+		} else if pct < 10 {
+			add("warning", "disk", action, "%s", msg)
+		} else {
+			add("ok", "disk", "", "%s", msg)
+		}
+	}
+
+	// Queue backlog.
+	if queue.DB != nil {
+		var total, held, old, failing int
+		var oldest time.Time
+		err := bstore.QueryDB[queue.Msg](ctx, queue.DB).ForEach(func(m queue.Msg) error {
+			total++
+			if m.Hold {
+				held++
+			}
+			if time.Since(m.Queued) > 24*time.Hour {
+				old++
+			}
+			if m.Attempts > 0 {
+				failing++
+			}
+			if oldest.IsZero() || m.Queued.Before(oldest) {
+				oldest = m.Queued
+			}
+			return nil
+		})
+		if err != nil {
+			add("error", "queue", "", "listing queue: %v", err)
+		} else {
+			msg := fmt.Sprintf("%d messages in queue, %d on hold, %d with failed delivery attempts", total, held, failing)
+			if total > 0 {
+				msg += fmt.Sprintf(", oldest queued %s ago", time.Since(oldest).Round(time.Minute))
+			}
+			action := "Inspect the queue with \"mox queue list\" or in the admin web interface."
+			if old > 0 || total > 100 {
+				add("warning", "queue", action, "%s", msg)
+			} else if held > 0 {
+				add("info", "queue", action, "%s", msg)
+			} else {
+				add("ok", "queue", "", "%s", msg)
+			}
+		}
+	}
+
+	// Errors since startup, from the prometheus metrics.
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		add("warning", "errors", "", "gathering metrics: %v", err)
+	} else {
+		sum := func(name string) (v float64) {
+			for _, mf := range families {
+				if mf.GetName() != name {
+					continue
+				}
+				for _, m := range mf.Metric {
+					switch mf.GetType() {
+					case dto.MetricType_COUNTER:
+						v += m.GetCounter().GetValue()
+					case dto.MetricType_GAUGE:
+						v += m.GetGauge().GetValue()
+					}
+				}
+			}
+			return v
+		}
+
+		var uptime time.Duration
+		if start := sum("process_start_time_seconds"); start > 0 {
+			uptime = time.Since(time.Unix(int64(start), 0))
+		}
+		since := "since startup"
+		if uptime > 0 {
+			since = fmt.Sprintf("since startup %s ago", uptime.Round(time.Minute))
+		}
+
+		if n := sum("mox_panic_total"); n > 0 {
+			add("error", "errors", "Look for \"panic\" in the logs, and report a bug with the stack trace.", "%d unhandled panics %s", int64(n), since)
+		}
+		nerr := sum("mox_logging_level_error_total")
+		msg := fmt.Sprintf("%d errors logged %s", int64(nerr), since)
+		// Rates over short uptimes are not meaningful.
+		if uptime > 10*time.Minute && nerr/uptime.Hours() > 10 {
+			add("warning", "errors", "Look for level=error in the logs.", "%s, %.1f per hour", msg, nerr/uptime.Hours())
+		} else {
+			add("ok", "errors", "", "%s", msg)
+		}
+	}
+
+	log.Debug("doctor checks done", slog.Int("findings", len(l)))
+	return l
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtpserver"
+)
+
+// drain prepares for a planned shutdown. New incoming SMTP connections and
+// transactions are refused with a temporary error, and all messages in the queue
+// that are not on hold are scheduled for immediate delivery. Drain returns when no
+// SMTP transactions and deliveries are in progress and no queued messages are due,
+// or when the deadline has passed. Status is called with progress updates.
+func drain(log mlog.Log, deadline time.Duration, status func(msg string)) {
+	mox.Draining.Store(true)
+
+	n, err := queue.Flush(mox.Shutdown)
+	if err != nil {
+		log.Errorx("flushing queue for drain", err)
+		status(fmt.Sprintf("flushing queue: %v", err))
+	}
+	log.Info("draining", slog.Duration("deadline", deadline), slog.Int("flushed", n))
+	status(fmt.Sprintf("draining, refusing new smtp transactions, %d messages in queue scheduled for delivery", n))
+
+	end := time.Now().Add(deadline)
+	var lastStatus time.Time
+	for {
+		transactions := smtpserver.Transactions()
+		active, due, err := queue.Pending(mox.Shutdown)
+		if err != nil {
+			log.Errorx("checking pending queue deliveries for drain", err)
+			status(fmt.Sprintf("checking queue: %v", err))
+			return
+		}
+		if transactions == 0 && active == 0 && due == 0 {
+			log.Info("drained")
+			status("drained")
+			return
+		}
+		if time.Now().After(end) {
+			log.Info("deadline for drain passed", slog.Int64("smtptransactions", transactions), slog.Int64("deliveries", active), slog.Int("due", due))
+			status(fmt.Sprintf("deadline passed, %d smtp transactions and %d deliveries in progress, %d queued messages due", transactions, active, due))
+			return
+		}
+		if time.Since(lastStatus) >= 5*time.Second {
+			lastStatus = time.Now()
+			status(fmt.Sprintf("waiting for %d smtp transactions and %d deliveries in progress, %d queued messages due", transactions, active, due))
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
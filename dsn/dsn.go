@@ -11,6 +11,7 @@ import (
 	"io"
 	"mime/multipart"
 	"net/textproto"
+	"slices"
 	"strings"
 	"time"
 
@@ -75,6 +76,12 @@ type Message struct {
 	// Original message or headers to include in DSN as third MIME part.
 	// Optional. Only used for generating DSNs, not set for parsed DNSs.
 	Original []byte
+
+	// If set, Original is a full message that is included as is, instead of only its
+	// headers, e.g. for the RET=FULL parameter of the SMTP DSN extension. Ignored when
+	// the original message requires smtputf8 but the DSN is composed without smtputf8.
+	// ../rfc/3461
+	OriginalFull bool
 }
 
 // Action is a field in a DSN.
@@ -144,7 +151,6 @@ func (m *Message) Compose(log mlog.Log, smtputf8 bool) ([]byte, error) {
 	// - 2. message/delivery-status;
 	// - 3. (optional) original message (either in full, or only headers).
 
-	// todo future: add option to send full message. but only do so if the message is <100kb.
 	// todo future: possibly write to a file directly, instead of building up message in memory.
 
 	// If message does not require smtputf8, we are never generating a utf-8 DSN.
@@ -222,7 +228,7 @@ func (m *Message) Compose(log mlog.Log, smtputf8 bool) ([]byte, error) {
 	}
 
 	// Per-message fields first. ../rfc/3464:575
-	// todo future: once we support the smtp dsn extension, the envid should be saved/set as OriginalEnvelopeID. ../rfc/3464:583 ../rfc/3461:1139
+	// ../rfc/3464:583 ../rfc/3461:1139
 	if m.OriginalEnvelopeID != "" {
 		status("Original-Envelope-ID", m.OriginalEnvelopeID)
 	}
@@ -302,9 +308,29 @@ func (m *Message) Compose(log mlog.Log, smtputf8 bool) ([]byte, error) {
 		}
 	}
 
-	// We include only the header of the original message.
-	// todo: add the textual version of the original message, if it exists and isn't too large.
-	if m.Original != nil {
+	if m.Original != nil && m.OriginalFull && (smtputf8 || !m.SMTPUTF8) {
+		// ../rfc/3462:175 ../rfc/6533
+		origHdr := textproto.MIMEHeader{}
+		if smtputf8 {
+			origHdr.Set("Content-Type", "message/global")
+		} else {
+			origHdr.Set("Content-Type", "message/rfc822")
+		}
+		// Only 7bit, 8bit or binary are allowed for message/rfc822. ../rfc/2046
+		cte := "7BIT"
+		if slices.ContainsFunc(m.Original, func(b byte) bool { return b >= 0x80 }) {
+			cte = "8BIT"
+		}
+		origHdr.Set("Content-Transfer-Encoding", cte)
+		origp, err := mp.CreatePart(origHdr)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := origp.Write(m.Original); err != nil {
+			return nil, err
+		}
+	} else if m.Original != nil {
+		// We include only the header of the original message.
 		headers, err := message.ReadHeaders(bufio.NewReader(bytes.NewReader(m.Original)))
 		if err != nil && errors.Is(err, message.ErrHeaderSeparator) {
 			// Whole data is a header.
@@ -312,7 +338,7 @@ func (m *Message) Compose(log mlog.Log, smtputf8 bool) ([]byte, error) {
 		} else if err != nil {
 			return nil, err
 		}
-		// Else, this is a whole message. We still only include the headers. todo: include the whole body.
+		// Else, this is a whole message. We still only include the headers.
 
 		origHdr := textproto.MIMEHeader{}
 		if smtputf8 {
@@ -129,6 +129,22 @@ func TestDSN(t *testing.T) {
 	tcompareReader(t, part.Parts[2].Reader(), m.Original)
 	tcompare(t, pmsg.Recipients[0].FinalRecipient, m.Recipients[0].FinalRecipient)
 
+	// Full original message, with envelope id and original recipient from the smtp dsn
+	// extension.
+	m.Original = []byte("Subject: test\r\n\r\nbody\r\n")
+	m.OriginalFull = true
+	m.OriginalEnvelopeID = "envid123"
+	m.Recipients[0].OriginalRecipient = smtp.Path{Localpart: "orig", IPDomain: xparseIPDomain("remote.example")}
+	msgbuf, err = m.Compose(log, false)
+	if err != nil {
+		t.Fatalf("composing dsn with full original: %v", err)
+	}
+	pmsg, part = tparseMessage(t, msgbuf, 3)
+	tcheckType(t, &part.Parts[2], "message", "rfc822", "7bit")
+	tcompareReader(t, part.Parts[2].Reader(), m.Original)
+	tcompare(t, pmsg.OriginalEnvelopeID, m.OriginalEnvelopeID)
+	tcompare(t, pmsg.Recipients[0].OriginalRecipient, m.Recipients[0].OriginalRecipient)
+
 	// An utf-8 message.
 	m = Message{
 		SMTPUTF8: true,
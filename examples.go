@@ -228,6 +228,45 @@ Routes:
 			return moxconf + "\n\n" + domainsconf
 		},
 	},
+	{
+		"fail2ban",
+		func() string {
+			const moxconf = `# Snippet for mox.conf, writing failed authentication attempts to a file in the
+# data directory:
+
+AuthEvents:
+	File: authevents.log
+`
+
+			const fail2ban = `# Fail2ban filter, e.g. /etc/fail2ban/filter.d/mox.conf:
+
+[Definition]
+failregex = ^time=\S+ event=authfail ip=<HOST> 
+ignoreregex =
+datepattern = ^time=%%Y-%%m-%%dT%%H:%%M:%%S%%z
+
+
+# Fail2ban jail, e.g. /etc/fail2ban/jail.d/mox.conf. Adjust logpath to the data
+# directory of mox.
+
+[mox]
+enabled = true
+filter = mox
+logpath = /home/mox/data/authevents.log
+port = 25,465,587,143,993,110,995,4190,80,443
+maxretry = 10
+findtime = 1h
+bantime = 1d
+`
+
+			var static struct {
+				AuthEvents *config.AuthEvents
+			}
+			err := sconf.Parse(strings.NewReader(moxconf), &static)
+			xcheckf(err, "parsing moxconf example")
+			return moxconf + "\n\n" + fail2ban
+		},
+	},
 }
 
 var exampleTime = time.Date(2024, time.March, 27, 0, 0, 0, 0, time.UTC)
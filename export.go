@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
 	"path/filepath"
 	"time"
@@ -77,3 +79,62 @@ func xcmdExport(mbox, single bool, args []string, c *cmd) {
 	err = a.Close()
 	xcheckf(err, "closing archiver")
 }
+
+func cmdExportIMAP(c *cmd) {
+	c.params = "[-maildir] [-recursive] [-since date] [-before date] account [mailbox ...]"
+	c.help = `Export messages into a zip file delivered to the "Exports" mailbox of the account.
+
+The zip file is attached to a message in the "Exports" mailbox, which is created
+if needed. Users can retrieve it with their IMAP client, e.g. users that cannot
+use the accounts web page or webmail for exports.
+
+Without mailboxes, all mailboxes are exported, except the "Exports" mailbox. Only
+messages received in the period between -since and -before are exported, if
+specified. Dates are in the format yyyy-mm-dd (UTC) or RFC3339.
+
+The export counts towards the quota of the account.
+`
+	var maildir, recursive bool
+	var since, before string
+	c.flag.BoolVar(&maildir, "maildir", false, "export in maildir format instead of mbox")
+	c.flag.BoolVar(&recursive, "recursive", false, "also export child mailboxes of the specified mailboxes")
+	c.flag.StringVar(&since, "since", "", "only export messages received at or after this date")
+	c.flag.StringVar(&before, "before", "", "only export messages received before this date")
+	args := c.Parse()
+	if len(args) < 1 {
+		c.Usage()
+	}
+
+	opts := store.ExportOpts{
+		Mailboxes: args[1:],
+		Recursive: recursive,
+		Since:     xparseExportDate(since),
+		Before:    xparseExportDate(before),
+		Maildir:   maildir,
+	}
+	mustLoadConfig()
+	id := ctlcmdExportIMAP(xctl(), args[0], opts)
+	fmt.Printf("export delivered as message %s in mailbox %s\n", id, store.ExportsMailbox)
+}
+
+func xparseExportDate(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	xcheckf(err, "parsing date %q", s)
+	return t
+}
+
+func ctlcmdExportIMAP(ctl *ctl, account string, opts store.ExportOpts) string {
+	optsbuf, err := json.Marshal(opts)
+	xcheckf(err, "marshal export options")
+	ctl.xwrite("exportimap")
+	ctl.xwrite(account)
+	ctl.xwrite(string(optsbuf))
+	ctl.xreadok()
+	return ctl.xread()
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mjl-/sherpadoc"
+	"github.com/mjl-/sherpats"
+
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/webaccount"
+	"github.com/mjl-/mox/webadmin"
+	"github.com/mjl-/mox/webmail"
+)
+
+func cmdGenapi(c *cmd) {
+	c.params = "[-format typescript|openapi|go] [-baseurl url] [-package name] admin|account|webmail"
+	c.help = `Generate client definitions for a web API.
+
+The web interfaces for admin, account and webmail are implemented with sherpa
+APIs: Functions are called with an HTTP POST to <baseurl><function>, with a JSON
+request body {"params": [...]}, and respond with {"result": ...} or {"error":
+{"code": ..., "message": ...}}. Function calls require the authentication
+cookie and CSRF token (in the x-mox-csrf header) from a login. The API
+documentation, in sherpadoc JSON, is served without authentication at
+<baseurl>sherpadoc.json, e.g. https://mail.example.org/admin/api/sherpadoc.json.
+
+This command generates definitions for the API from the documentation built
+into this version of mox:
+
+- typescript: a client, like the one used by the mox web interfaces.
+- openapi: an OpenAPI 3.1 specification, in JSON.
+- go: a Go package with types and a client.
+
+The base URL is used in the generated client or specification. For
+typescript, the base URL defaults to "api", which is resolved relative to the
+current page.
+`
+	var apiFormat, baseURL, pkg string
+	c.flag.StringVar(&apiFormat, "format", "typescript", "format of generated definitions: typescript, openapi or go")
+	c.flag.StringVar(&baseURL, "baseurl", "", "base url of api, ending with a slash, e.g. https://mail.example.org/admin/api/")
+	c.flag.StringVar(&pkg, "package", "", "package name for go client, default is the api name")
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+
+	buf, err := genapiDoc(args[0])
+	xcheckf(err, "api documentation")
+	err = genapi(os.Stdout, buf, args[0], apiFormat, baseURL, pkg)
+	xcheckf(err, "generating api definitions")
+}
+
+// genapiDoc returns the sherpadoc JSON for the named API.
+func genapiDoc(name string) ([]byte, error) {
+	switch name {
+	case "admin":
+		return webadmin.APIDocJSON(), nil
+	case "account":
+		return webaccount.APIDocJSON(), nil
+	case "webmail":
+		return webmail.APIDocJSON(), nil
+	}
+	return nil, fmt.Errorf("unknown api %q, must be admin, account or webmail", name)
+}
+
+func genapi(w io.Writer, buf []byte, name, apiFormat, baseURL, pkg string) error {
+	switch apiFormat {
+	case "typescript":
+		apiNameBaseURL := baseURL
+		if apiNameBaseURL == "" {
+			apiNameBaseURL = "api"
+		}
+		// Same options as used for the mox web interfaces.
+		opts := sherpats.Options{
+			Namespace:        "api",
+			SlicesNullable:   true,
+			MapsNullable:     true,
+			NullableOptional: true,
+			BytesToString:    true,
+		}
+		// sherpats.Generate reads the documentation from os.Stdin instead of its
+		// reader parameter, so we provide it through a pipe.
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return fmt.Errorf("pipe for api documentation: %v", err)
+		}
+		defer pr.Close()
+		go func() {
+			defer pw.Close()
+			pw.Write(buf)
+		}()
+		stdin := os.Stdin
+		os.Stdin = pr
+		defer func() {
+			os.Stdin = stdin
+		}()
+		return sherpats.Generate(pr, w, apiNameBaseURL, opts)
+	case "openapi", "go":
+	default:
+		return fmt.Errorf("unknown format %q, must be typescript, openapi or go", apiFormat)
+	}
+
+	var doc sherpadoc.Section
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		return fmt.Errorf("parsing api documentation: %v", err)
+	}
+	// Flatten subsections, their functions and types are part of the same API.
+	var merge func(s *sherpadoc.Section)
+	merge = func(s *sherpadoc.Section) {
+		for _, sub := range s.Sections {
+			merge(sub)
+			s.Functions = append(s.Functions, sub.Functions...)
+			s.Structs = append(s.Structs, sub.Structs...)
+			s.Ints = append(s.Ints, sub.Ints...)
+			s.Strings = append(s.Strings, sub.Strings...)
+		}
+		s.Sections = nil
+	}
+	merge(&doc)
+
+	if apiFormat == "openapi" {
+		return genapiOpenAPI(w, doc, baseURL)
+	}
+	if pkg == "" {
+		pkg = name
+	}
+	return genapiGo(w, doc, baseURL, pkg)
+}
+
+// genapiOpenAPI writes an OpenAPI 3.1 specification for a sherpa API. Each
+// function is a POST operation on its own path.
+func genapiOpenAPI(w io.Writer, doc sherpadoc.Section, baseURL string) error {
+	type obj = map[string]any
+
+	ref := func(name string) obj {
+		return obj{"$ref": "#/components/schemas/" + name}
+	}
+	var schema func(tw []string) (obj, error)
+	schema = func(tw []string) (obj, error) {
+		if len(tw) == 0 {
+			return nil, fmt.Errorf("missing type")
+		}
+		switch tw[0] {
+		case "nullable":
+			s, err := schema(tw[1:])
+			if err != nil {
+				return nil, err
+			}
+			return obj{"oneOf": []obj{s, {"type": "null"}}}, nil
+		case "[]", "{}":
+			s, err := schema(tw[1:])
+			if err != nil {
+				return nil, err
+			}
+			// Go marshals nil slices and maps as null.
+			if tw[0] == "[]" {
+				return obj{"type": []string{"array", "null"}, "items": s}, nil
+			}
+			return obj{"type": []string{"object", "null"}, "additionalProperties": s}, nil
+		}
+		if len(tw) != 1 {
+			return nil, fmt.Errorf("unexpected type words %v", tw)
+		}
+		switch tw[0] {
+		case "any":
+			return obj{}, nil
+		case "bool":
+			return obj{"type": "boolean"}, nil
+		case "int8", "uint8", "int16", "uint16", "int32", "uint32":
+			return obj{"type": "integer", "format": "int32"}, nil
+		case "int64", "uint64":
+			return obj{"type": "integer", "format": "int64"}, nil
+		case "int64s", "uint64s":
+			return obj{"type": "string", "format": "int64"}, nil
+		case "float32":
+			return obj{"type": "number", "format": "float"}, nil
+		case "float64":
+			return obj{"type": "number", "format": "double"}, nil
+		case "string":
+			return obj{"type": "string"}, nil
+		case "timestamp":
+			return obj{"type": "string", "format": "date-time"}, nil
+		}
+		return ref(tw[0]), nil
+	}
+	tuple := func(args []sherpadoc.Arg) (obj, error) {
+		items := []obj{}
+		for _, a := range args {
+			s, err := schema(a.Typewords)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", a.Name, err)
+			}
+			s["title"] = a.Name
+			items = append(items, s)
+		}
+		return obj{"type": "array", "prefixItems": items, "minItems": len(items), "maxItems": len(items)}, nil
+	}
+
+	schemas := obj{
+		"SherpaError": obj{
+			"type":     "object",
+			"required": []string{"code", "message"},
+			"properties": obj{
+				"code":    obj{"type": "string"},
+				"message": obj{"type": "string"},
+			},
+		},
+	}
+	for _, st := range doc.Structs {
+		props := obj{}
+		var required []string
+		for _, f := range st.Fields {
+			s, err := schema(f.Typewords)
+			if err != nil {
+				return fmt.Errorf("struct %s field %s: %v", st.Name, f.Name, err)
+			}
+			if f.Docs != "" {
+				s["description"] = f.Docs
+			}
+			props[f.Name] = s
+			required = append(required, f.Name)
+		}
+		schemas[st.Name] = obj{"type": "object", "description": st.Docs, "properties": props, "required": required}
+	}
+	for _, e := range doc.Ints {
+		var values []int64
+		for _, v := range e.Values {
+			values = append(values, v.Value)
+		}
+		schemas[e.Name] = obj{"type": "integer", "description": e.Docs, "enum": values}
+	}
+	for _, e := range doc.Strings {
+		var values []string
+		for _, v := range e.Values {
+			values = append(values, v.Value)
+		}
+		schemas[e.Name] = obj{"type": "string", "description": e.Docs, "enum": values}
+	}
+
+	paths := obj{}
+	for _, fn := range doc.Functions {
+		params, err := tuple(fn.Params)
+		if err != nil {
+			return fmt.Errorf("function %s: %v", fn.Name, err)
+		}
+		// Sherpa returns no value as null, a single value as is, and multiple values as array.
+		var result obj
+		switch len(fn.Returns) {
+		case 0:
+			result = obj{"type": "null"}
+		case 1:
+			result, err = schema(fn.Returns[0].Typewords)
+		default:
+			result, err = tuple(fn.Returns)
+		}
+		if err != nil {
+			return fmt.Errorf("function %s result: %v", fn.Name, err)
+		}
+		paths["/"+fn.Name] = obj{
+			"post": obj{
+				"operationId": fn.Name,
+				"description": fn.Docs,
+				"requestBody": obj{
+					"required": true,
+					"content": obj{
+						"application/json": obj{
+							"schema": obj{
+								"type":       "object",
+								"required":   []string{"params"},
+								"properties": obj{"params": params},
+							},
+						},
+					},
+				},
+				"responses": obj{
+					"200": obj{
+						"description": "Result of the call, or an error.",
+						"content": obj{
+							"application/json": obj{
+								"schema": obj{
+									"type": "object",
+									"properties": obj{
+										"result": result,
+										"error":  ref("SherpaError"),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	spec := obj{
+		"openapi": "3.1.0",
+		"info": obj{
+			"title":       doc.Name,
+			"description": doc.Docs,
+			"version":     moxvar.Version,
+		},
+		"paths":      paths,
+		"components": obj{"schemas": schemas},
+	}
+	if baseURL != "" {
+		spec["servers"] = []obj{{"url": strings.TrimSuffix(baseURL, "/")}}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(spec)
+}
+
+// genapiGo writes a Go package with the types of a sherpa API and a client for
+// calling its functions.
+func genapiGo(w io.Writer, doc sherpadoc.Section, baseURL, pkg string) error {
+	if !token.IsIdentifier(pkg) {
+		return fmt.Errorf("invalid package name %q", pkg)
+	}
+
+	var b bytes.Buffer
+	pf := func(format string, args ...any) {
+		fmt.Fprintf(&b, format, args...)
+	}
+	comment := func(indent, s string) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return
+		}
+		for _, line := range strings.Split(s, "\n") {
+			pf("%s// %s\n", indent, line)
+		}
+	}
+	var gotype func(tw []string) (string, error)
+	gotype = func(tw []string) (string, error) {
+		if len(tw) == 0 {
+			return "", fmt.Errorf("missing type")
+		}
+		switch tw[0] {
+		case "nullable":
+			t, err := gotype(tw[1:])
+			if err != nil || tw[1] == "[]" || tw[1] == "{}" || tw[1] == "any" {
+				return t, err
+			}
+			return "*" + t, nil
+		case "[]":
+			t, err := gotype(tw[1:])
+			return "[]" + t, err
+		case "{}":
+			t, err := gotype(tw[1:])
+			return "map[string]" + t, err
+		}
+		if len(tw) != 1 {
+			return "", fmt.Errorf("unexpected type words %v", tw)
+		}
+		switch tw[0] {
+		case "any", "bool", "int8", "uint8", "int16", "uint16", "int32", "uint32", "int64", "uint64", "float32", "float64", "string":
+			return tw[0], nil
+		case "int64s", "uint64s":
+			return "string", nil
+		case "timestamp":
+			return "time.Time", nil
+		}
+		return tw[0], nil
+	}
+	// Parameter names are prefixed to prevent clashes with keywords and other names.
+	paramName := func(s string) string {
+		return "p" + strings.ToUpper(s[:1]) + s[1:]
+	}
+
+	pf("// Code generated by \"mox genapi\"; DO NOT EDIT.\n\n")
+	pf("// Package %s is a client for the %s API of mox %s.\n", pkg, doc.Name, moxvar.Version)
+	pf("//\n")
+	comment("", doc.Docs)
+	pf("package %s\n\n", pkg)
+	pf("import (\n\t\"bytes\"\n\t\"context\"\n\t\"encoding/json\"\n\t\"fmt\"\n\t\"net/http\"\n\t\"time\"\n)\n\n")
+	pf("var _ time.Time // In case no type uses time.\n\n")
+
+	pf("// DefaultBaseURL is the base URL of the API when Client.BaseURL is empty.\n")
+	pf("const DefaultBaseURL = %q\n\n", baseURL)
+	pf(`// Client calls functions of the API.
+type Client struct {
+	BaseURL    string       // Base URL of API, ending with a slash. If empty, DefaultBaseURL is used.
+	HTTPClient *http.Client // If nil, http.DefaultClient is used.
+	Header     http.Header  // Added to each request, e.g. for the authentication cookie and x-mox-csrf token.
+}
+
+// Error is an error returned by the API.
+type Error struct {
+	Code    string ` + "`json:\"code\"`" + `
+	Message string ` + "`json:\"message\"`" + `
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func (c *Client) call(ctx context.Context, fn string, params []any, results ...any) error {
+	baseURL := c.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	if params == nil {
+		params = []any{}
+	}
+	reqBuf, err := json.Marshal(map[string]any{"params": params})
+	if err != nil {
+		return fmt.Errorf("marshal request: %%v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", baseURL+fn, bytes.NewReader(reqBuf))
+	if err != nil {
+		return fmt.Errorf("new request: %%v", err)
+	}
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("http request: %%v", err)
+	}
+	defer resp.Body.Close()
+	var response struct {
+		Result json.RawMessage ` + "`json:\"result\"`" + `
+		Error  *Error          ` + "`json:\"error\"`" + `
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("parsing response (http status %%s): %%v", resp.Status, err)
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	switch len(results) {
+	case 0:
+		return nil
+	case 1:
+		return json.Unmarshal(response.Result, results[0])
+	}
+	var l []json.RawMessage
+	if err := json.Unmarshal(response.Result, &l); err != nil {
+		return err
+	}
+	if len(l) != len(results) {
+		return fmt.Errorf("got %%d results, expected %%d", len(l), len(results))
+	}
+	for i, r := range results {
+		if err := json.Unmarshal(l[i], r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+`)
+
+	for _, fn := range doc.Functions {
+		var params, args, rtypes, rnames []string
+		for _, a := range fn.Params {
+			t, err := gotype(a.Typewords)
+			if err != nil {
+				return fmt.Errorf("function %s param %s: %v", fn.Name, a.Name, err)
+			}
+			params = append(params, paramName(a.Name)+" "+t)
+			args = append(args, paramName(a.Name))
+		}
+		for i, a := range fn.Returns {
+			t, err := gotype(a.Typewords)
+			if err != nil {
+				return fmt.Errorf("function %s result %s: %v", fn.Name, a.Name, err)
+			}
+			rtypes = append(rtypes, fmt.Sprintf("r%d %s", i, t))
+			rnames = append(rnames, fmt.Sprintf("&r%d", i))
+		}
+		rtypes = append(rtypes, "rerr error")
+		comment("", fn.Docs)
+		pf("func (c *Client) %s(ctx context.Context", fn.Name)
+		for _, p := range params {
+			pf(", %s", p)
+		}
+		pf(") (%s) {\n", strings.Join(rtypes, ", "))
+		pf("\trerr = c.call(ctx, %q, []any{%s}", fn.Name, strings.Join(args, ", "))
+		for _, r := range rnames {
+			pf(", %s", r)
+		}
+		pf(")\n\treturn\n}\n\n")
+	}
+
+	for _, st := range doc.Structs {
+		comment("", st.Docs)
+		pf("type %s struct {\n", st.Name)
+		for _, f := range st.Fields {
+			t, err := gotype(f.Typewords)
+			if err != nil {
+				return fmt.Errorf("struct %s field %s: %v", st.Name, f.Name, err)
+			}
+			comment("\t", f.Docs)
+			pf("\t%s %s `json:%q`\n", f.Name, t, f.Name)
+		}
+		pf("}\n\n")
+	}
+	// Enum values become constants, prefixed with the type name.
+	enum := func(name, docs, basetype string, names, values []string) {
+		comment("", docs)
+		pf("type %s %s\n\n", name, basetype)
+		if len(names) == 0 {
+			return
+		}
+		pf("const (\n")
+		for i, n := range names {
+			pf("\t%s %s = %s\n", n, name, values[i])
+		}
+		pf(")\n\n")
+	}
+	for _, e := range doc.Ints {
+		var names, values []string
+		for _, v := range e.Values {
+			names = append(names, e.Name+v.Name)
+			values = append(values, fmt.Sprintf("%d", v.Value))
+		}
+		enum(e.Name, e.Docs, "int64", names, values)
+	}
+	for _, e := range doc.Strings {
+		// Values can have the same name, e.g. for empty values. Only use unique names.
+		seen := map[string]bool{}
+		var names, values []string
+		for _, v := range e.Values {
+			n := e.Name + v.Name
+			if !token.IsIdentifier(n) || seen[n] {
+				continue
+			}
+			seen[n] = true
+			names = append(names, n)
+			values = append(values, fmt.Sprintf("%q", v.Value))
+		}
+		enum(e.Name, e.Docs, "string", names, values)
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("formatting generated go code: %v", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
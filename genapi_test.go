@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGenapi(t *testing.T) {
+	for _, name := range []string{"admin", "account", "webmail"} {
+		buf, err := genapiDoc(name)
+		tcheck(t, err, "api doc")
+
+		var ts bytes.Buffer
+		err = genapi(&ts, buf, name, "typescript", "", "")
+		tcheck(t, err, "generate typescript")
+		if name == "admin" {
+			// Should match the client generated for the web interface.
+			exp, err := os.ReadFile("webadmin/api.ts")
+			tcheck(t, err, "read api.ts")
+			if ts.String() != string(exp) {
+				t.Fatalf("generated typescript differs from webadmin/api.ts")
+			}
+		} else if !strings.Contains(ts.String(), "namespace api {") {
+			t.Fatalf("unexpected typescript output for %s", name)
+		}
+
+		var openapi bytes.Buffer
+		err = genapi(&openapi, buf, name, "openapi", "https://mox.example/"+name+"/api/", "")
+		tcheck(t, err, "generate openapi")
+		var spec struct {
+			OpenAPI string
+			Paths   map[string]any
+		}
+		err = json.Unmarshal(openapi.Bytes(), &spec)
+		tcheck(t, err, "parse openapi")
+		if spec.Paths["/Version"] == nil {
+			t.Fatalf("missing path for Version function in openapi for %s", name)
+		}
+
+		var gosrc bytes.Buffer
+		err = genapi(&gosrc, buf, name, "go", "", "")
+		tcheck(t, err, "generate go")
+		_, err = parser.ParseFile(token.NewFileSet(), "client.go", gosrc.Bytes(), 0)
+		tcheck(t, err, "parse generated go")
+	}
+
+	err := genapi(&bytes.Buffer{}, nil, "admin", "bogus", "", "")
+	if err == nil {
+		t.Fatalf("expected error for unknown format")
+	}
+	_, err = genapiDoc("bogus")
+	if err == nil {
+		t.Fatalf("expected error for unknown api")
+	}
+}
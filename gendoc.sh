@@ -76,6 +76,26 @@ The config files are in "sconf" format. Properties of sconf files:
 
 See https://pkg.go.dev/github.com/mjl-/sconf for details.
 
+# Secrets
+
+Some fields holding secrets can reference a value stored outside the config
+file, so config files can be kept in version control without containing
+secrets. These fields are the transport SMTP authentication passwords, the
+OAuth client secret and the MetricsPush remote-write password and bearer token.
+DKIM private keys and the admin password hash are stored in separate files, but
+the PrivateKeyFile and AdminPasswordFile fields can also reference a value with
+env: or vault:. References are resolved when the config file is loaded.
+
+- file:<path> for the contents of a file, without trailing newlines. Relative
+  paths are interpreted relative to the directory of the config file. The file
+  must be readable by the mox user.
+- env:<name> for the value of an environment variable.
+- vault:<path>#<field> for a field from a HashiCorp Vault secret, fetched from
+  \$VAULT_ADDR/v1/<path> with the token from \$VAULT_TOKEN. Both KV version 1
+  and 2 secrets are supported, e.g. vault:secret/data/mox#smtppassword.
+
+Values starting with one of these prefixes are always treated as references.
+
 
 # mox.conf
 
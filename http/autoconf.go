@@ -1,6 +1,7 @@
 package http
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
 	"log/slog"
@@ -87,6 +88,20 @@ func autoconfHandle(w http.ResponseWriter, r *http.Request) {
 		domain = addr.Domain
 	}
 
+	buf, err := AutoconfigXML(domain, email)
+	if err != nil {
+		http.Error(w, "400 - bad request - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	_, err = w.Write(buf)
+	log.Check(err, "write autoconfig xml response")
+}
+
+// AutoconfigXML returns the Thunderbird autoconfig XML file for domain. If email
+// is "%EMAILADDRESS%", clients fill in the email address of the user, making the
+// file suitable for serving as static file.
+func AutoconfigXML(domain dns.Domain, email string) ([]byte, error) {
 	socketType := func(tlsMode admin.TLSMode) (string, error) {
 		switch tlsMode {
 		case admin.TLSModeImmediate:
@@ -109,8 +124,7 @@ func autoconfHandle(w http.ResponseWriter, r *http.Request) {
 		submissionTLS, err = socketType(config.Submission.TLSMode)
 	}
 	if err != nil {
-		http.Error(w, "400 - bad request - "+err.Error(), http.StatusBadRequest)
-		return
+		return nil, err
 	}
 
 	// Thunderbird doesn't seem to allow U-labels, always return ASCII names.
@@ -171,12 +185,14 @@ func autoconfHandle(w http.ResponseWriter, r *http.Request) {
 	// todo: should we put the email address in the URL?
 	resp.ClientConfigUpdate.URL = fmt.Sprintf("https://autoconfig.%s/mail/config-v1.1.xml", domain.ASCII)
 
-	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
-	enc := xml.NewEncoder(w)
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	enc := xml.NewEncoder(&b)
 	enc.Indent("", "\t")
-	fmt.Fprint(w, xml.Header)
-	err = enc.Encode(resp)
-	log.Check(err, "write autoconfig xml response")
+	if err := enc.Encode(resp); err != nil {
+		return nil, fmt.Errorf("encoding autoconfig xml: %v", err)
+	}
+	return b.Bytes(), nil
 }
 
 // Autodiscover from Microsoft, also used by Thunderbird.
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
@@ -36,11 +37,28 @@ func mtastsPolicyHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	policy, err := MTASTSPolicy(domain)
+	if err != nil {
+		log().Errorx("mtasts policy request", err, slog.Any("domain", domain))
+		http.Error(w, "500 - internal server error - invalid domain in configuration", http.StatusInternalServerError)
+		return
+	} else if policy == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0")
+	_, _ = w.Write([]byte(policy.String()))
+}
+
+// MTASTSPolicy returns the MTA-STS policy for domain as served at
+// https://mta-sts.<domain>/.well-known/mta-sts.txt, or nil if the domain does not
+// have MTA-STS configured.
+func MTASTSPolicy(domain dns.Domain) (*mtasts.Policy, error) {
 	conf, _ := mox.Conf.Domain(domain)
 	sts := conf.MTASTS
 	if sts == nil {
-		http.NotFound(w, r)
-		return
+		return nil, nil
 	}
 
 	var mxs []mtasts.MX
@@ -52,9 +70,7 @@ func mtastsPolicyHandle(w http.ResponseWriter, r *http.Request) {
 		}
 		d, err := dns.ParseDomain(s)
 		if err != nil {
-			log().Errorx("bad domain in mtasts config", err, slog.String("domain", s))
-			http.Error(w, "500 - internal server error - invalid domain in configuration", http.StatusInternalServerError)
-			return
+			return nil, fmt.Errorf("bad domain %q in mtasts config: %v", s, err)
 		}
 		mx.Domain = d
 		mxs = append(mxs, mx)
@@ -63,13 +79,11 @@ func mtastsPolicyHandle(w http.ResponseWriter, r *http.Request) {
 		mxs = []mtasts.MX{{Domain: mox.Conf.Static.HostnameDomain}}
 	}
 
-	policy := mtasts.Policy{
+	policy := &mtasts.Policy{
 		Version:       "STSv1",
 		Mode:          sts.Mode,
 		MaxAgeSeconds: int(sts.MaxAge / time.Second),
 		MX:            mxs,
 	}
-	w.Header().Set("Content-Type", "text/plain")
-	w.Header().Set("Cache-Control", "no-cache, max-age=0")
-	_, _ = w.Write([]byte(policy.String()))
+	return policy, nil
 }
@@ -41,6 +41,7 @@ import (
 	"github.com/mjl-/mox/webaccount"
 	"github.com/mjl-/mox/webadmin"
 	"github.com/mjl-/mox/webapisrv"
+	"github.com/mjl-/mox/webauth"
 	"github.com/mjl-/mox/webmail"
 )
 
@@ -584,8 +585,16 @@ func Listen() {
 		ports := slices.Sorted(maps.Keys(portServe))
 		for _, port := range ports {
 			srv := portServe[port]
-			for _, ip := range l.IPs {
-				listen1(ip, port, srv.TLSConfig, name, srv.Kinds, srv, srv.NextProto)
+			if srv.TLSConfig != nil {
+				// Config is shared by the listeners for each IP.
+				mox.StartTLSSessionTicketKeyRefresher(mox.Shutdown, pkglog, srv.TLSConfig, l.TLS.SessionTicketKeyRotation)
+			}
+			protocol := "http"
+			if srv.TLSConfig != nil {
+				protocol = "https"
+			}
+			for _, la := range mox.ListenAddresses(l, protocol, port) {
+				listen1(la.Network, la.Address, srv.TLSConfig, name, srv.Kinds, srv, srv.NextProto)
 			}
 		}
 	}
@@ -645,13 +654,20 @@ func portServes(name string, l config.Listener) map[int]*serve {
 		return s
 	}
 
+	// Restrict web interfaces to allowed IPs, if configured.
+	ipAccessHandler := func(a *config.IPAccess, kind string, forwarded bool, handler http.Handler) http.Handler {
+		return mox.IPAccessHandler(a, kind, func(r *http.Request) net.IP {
+			return webauth.ClientIP(pkglog, forwarded, r)
+		}, handler)
+	}
+
 	// If TLS with ACME is enabled on this plain HTTP port, and it hasn't been enabled
 	// yet, add http-01 validation mechanism handler to server.
 	ensureACMEHTTP01 := func(srv *serve) {
 		if l.TLS != nil && l.TLS.ACME != "" && !slices.Contains(srv.Kinds, "acme-http-01") {
 			m := mox.Conf.Static.ACME[l.TLS.ACME].Manager
 			srv.Kinds = append(srv.Kinds, "acme-http-01")
-			srv.SystemHandle("acme-http-01", nil, "/.well-known/acme-challenge/", m.Manager.HTTPHandler(nil))
+			srv.SystemHandle("acme-http-01", nil, "/.well-known/acme-challenge/", m.HTTPHandler(nil))
 		}
 	}
 
@@ -673,13 +689,21 @@ func portServes(name string, l config.Listener) map[int]*serve {
 		requireTLS := !l.SMTP.NoRequireTLS
 
 		s.NextProto["smtp"] = func(_ *http.Server, conn *tls.Conn, _ http.Handler) {
-			smtpserver.ServeTLSConn(name, hostname, conn, s.TLSConfig, true, true, maxMsgSize, requireTLS)
+			if !mox.IPAccessAllowedConn(conn, "submissions", l.Submissions.IPAccess) {
+				conn.Close()
+				return
+			}
+			smtpserver.ServeTLSConn(name, hostname, conn, s.TLSConfig, true, true, l.NoPlaintextAuth, maxMsgSize, requireTLS)
 		}
 	}
 	if l.IMAPS.Enabled && l.IMAPS.EnabledOnHTTPS {
 		s := ensureServe(true, false, false, 443, "imap-https", false)
 		s.NextProto["imap"] = func(_ *http.Server, conn *tls.Conn, _ http.Handler) {
-			imapserver.ServeTLSConn(name, conn, s.TLSConfig)
+			if !mox.IPAccessAllowedConn(conn, "imaps", l.IMAPS.IPAccess) {
+				conn.Close()
+				return
+			}
+			imapserver.ServeTLSConn(name, conn, s.TLSConfig, l.NoPlaintextAuth)
 		}
 	}
 	if l.AccountHTTP.Enabled {
@@ -690,6 +714,7 @@ func portServes(name string, l config.Listener) map[int]*serve {
 		}
 		srv := ensureServe(false, l.AccountHTTP.Forwarded, false, port, "account-http at "+path, true)
 		handler := mox.SafeHeaders(http.StripPrefix(strings.TrimRight(path, "/"), http.HandlerFunc(webaccount.Handler(path, l.AccountHTTP.Forwarded))))
+		handler = ipAccessHandler(l.AccountHTTP.IPAccess, "account", l.AccountHTTP.Forwarded, handler)
 		srv.ServiceHandle("account", accountHostMatch, path, handler)
 		redirectToTrailingSlash(srv, accountHostMatch, "account", path)
 		ensureACMEHTTP01(srv)
@@ -702,6 +727,7 @@ func portServes(name string, l config.Listener) map[int]*serve {
 		}
 		srv := ensureServe(true, l.AccountHTTPS.Forwarded, false, port, "account-https at "+path, true)
 		handler := mox.SafeHeaders(http.StripPrefix(strings.TrimRight(path, "/"), http.HandlerFunc(webaccount.Handler(path, l.AccountHTTPS.Forwarded))))
+		handler = ipAccessHandler(l.AccountHTTPS.IPAccess, "account", l.AccountHTTPS.Forwarded, handler)
 		srv.ServiceHandle("account", accountHostMatch, path, handler)
 		redirectToTrailingSlash(srv, accountHostMatch, "account", path)
 	}
@@ -714,6 +740,7 @@ func portServes(name string, l config.Listener) map[int]*serve {
 		}
 		srv := ensureServe(false, l.AdminHTTP.Forwarded, false, port, "admin-http at "+path, true)
 		handler := mox.SafeHeaders(http.StripPrefix(strings.TrimRight(path, "/"), http.HandlerFunc(webadmin.Handler(path, l.AdminHTTP.Forwarded))))
+		handler = ipAccessHandler(l.AdminHTTP.IPAccess, "admin", l.AdminHTTP.Forwarded, handler)
 		srv.ServiceHandle("admin", listenerHostMatch, path, handler)
 		redirectToTrailingSlash(srv, listenerHostMatch, "admin", path)
 		ensureACMEHTTP01(srv)
@@ -726,6 +753,7 @@ func portServes(name string, l config.Listener) map[int]*serve {
 		}
 		srv := ensureServe(true, l.AdminHTTPS.Forwarded, false, port, "admin-https at "+path, true)
 		handler := mox.SafeHeaders(http.StripPrefix(strings.TrimRight(path, "/"), http.HandlerFunc(webadmin.Handler(path, l.AdminHTTPS.Forwarded))))
+		handler = ipAccessHandler(l.AdminHTTPS.IPAccess, "admin", l.AdminHTTPS.Forwarded, handler)
 		srv.ServiceHandle("admin", listenerHostMatch, path, handler)
 		redirectToTrailingSlash(srv, listenerHostMatch, "admin", path)
 	}
@@ -743,6 +771,7 @@ func portServes(name string, l config.Listener) map[int]*serve {
 		}
 		srv := ensureServe(false, l.WebAPIHTTP.Forwarded, false, port, "webapi-http at "+path, true)
 		handler := mox.SafeHeaders(http.StripPrefix(strings.TrimRight(path, "/"), webapisrv.NewServer(maxMsgSize, path, l.WebAPIHTTP.Forwarded)))
+		handler = ipAccessHandler(l.WebAPIHTTP.IPAccess, "webapi", l.WebAPIHTTP.Forwarded, handler)
 		srv.ServiceHandle("webapi", accountHostMatch, path, handler)
 		redirectToTrailingSlash(srv, accountHostMatch, "webapi", path)
 		ensureACMEHTTP01(srv)
@@ -755,6 +784,7 @@ func portServes(name string, l config.Listener) map[int]*serve {
 		}
 		srv := ensureServe(true, l.WebAPIHTTPS.Forwarded, false, port, "webapi-https at "+path, true)
 		handler := mox.SafeHeaders(http.StripPrefix(strings.TrimRight(path, "/"), webapisrv.NewServer(maxMsgSize, path, l.WebAPIHTTPS.Forwarded)))
+		handler = ipAccessHandler(l.WebAPIHTTPS.IPAccess, "webapi", l.WebAPIHTTPS.Forwarded, handler)
 		srv.ServiceHandle("webapi", accountHostMatch, path, handler)
 		redirectToTrailingSlash(srv, accountHostMatch, "webapi", path)
 	}
@@ -774,6 +804,7 @@ func portServes(name string, l config.Listener) map[int]*serve {
 			}
 		}
 		handler := http.StripPrefix(strings.TrimRight(path, "/"), http.HandlerFunc(webmail.Handler(maxMsgSize, path, l.WebmailHTTP.Forwarded, accountPath)))
+		handler = ipAccessHandler(l.WebmailHTTP.IPAccess, "webmail", l.WebmailHTTP.Forwarded, handler)
 		srv.ServiceHandle("webmail", accountHostMatch, path, handler)
 		redirectToTrailingSlash(srv, accountHostMatch, "webmail", path)
 		ensureACMEHTTP01(srv)
@@ -793,6 +824,7 @@ func portServes(name string, l config.Listener) map[int]*serve {
 			}
 		}
 		handler := http.StripPrefix(strings.TrimRight(path, "/"), http.HandlerFunc(webmail.Handler(maxMsgSize, path, l.WebmailHTTPS.Forwarded, accountPath)))
+		handler = ipAccessHandler(l.WebmailHTTPS.IPAccess, "webmail", l.WebmailHTTPS.Forwarded, handler)
 		srv.ServiceHandle("webmail", accountHostMatch, path, handler)
 		redirectToTrailingSlash(srv, accountHostMatch, "webmail", path)
 	}
@@ -859,6 +891,21 @@ func portServes(name string, l config.Listener) map[int]*serve {
 		}
 		srv.SystemHandle("mtasts", mtastsMatch, "/.well-known/mta-sts.txt", mox.SafeHeaders(http.HandlerFunc(mtastsPolicyHandle)))
 	}
+	if l.WKDHTTPS.Enabled {
+		port := config.Port(l.WKDHTTPS.Port, 443)
+		srv := ensureServe(!l.WKDHTTPS.NonTLS, l.WKDHTTPS.Forwarded, false, port, "wkd-https", false)
+		if l.WKDHTTPS.NonTLS {
+			ensureACMEHTTP01(srv)
+		}
+		wkdMatch := func(ipdom dns.IPDomain) bool {
+			dom := ipdom.Domain
+			if dom.IsZero() {
+				return false
+			}
+			return strings.HasPrefix(dom.ASCII, "openpgpkey.")
+		}
+		srv.SystemHandle("wkd", wkdMatch, "/.well-known/openpgpkey/", mox.SafeHeaders(http.HandlerFunc(wkdHandle)))
+	}
 	if l.PprofHTTP.Enabled {
 		// Importing net/http/pprof registers handlers on the default serve mux.
 		port := config.Port(l.PprofHTTP.Port, 8011)
@@ -899,9 +946,12 @@ func portServes(name string, l config.Listener) map[int]*serve {
 
 		if l.AutoconfigHTTPS.Enabled && !l.AutoconfigHTTPS.NonTLS {
 			for _, name := range mox.Conf.Domains() {
-				if dom, err := dns.ParseDomain(name); err != nil {
+				dom, err := dns.ParseDomain(name)
+				if err != nil {
 					pkglog.Errorx("parsing domain from config", err)
-				} else if d, _ := mox.Conf.Domain(dom); d.ReportsOnly || d.Disabled {
+				}
+				d, _ := mox.Conf.Domain(dom)
+				if d.ReportsOnly || d.Disabled {
 					// Do not gather autoconfig name if we aren't accepting email for this domain or when it is disabled.
 					continue
 				}
@@ -909,6 +959,12 @@ func portServes(name string, l config.Listener) map[int]*serve {
 				autoconfdom, err := dns.ParseDomain("autoconfig." + name)
 				if err != nil {
 					pkglog.Errorx("parsing domain from config for autoconfig", err)
+				} else if dm := mox.Conf.Static.ACME[d.ACME].Manager; d.ACME != "" && dm != nil && dm != m {
+					// Domain has its own ACME provider.
+					if ensureManagerHosts[dm] == nil {
+						ensureManagerHosts[dm] = map[dns.Domain]struct{}{}
+					}
+					ensureManagerHosts[dm][autoconfdom] = struct{}{}
 				} else {
 					hosts[autoconfdom] = struct{}{}
 				}
@@ -954,9 +1010,7 @@ var ensureManagerHosts = map[*autotls.Manager]map[dns.Domain]struct{}{}
 type tlsNextProtoMap = map[string]func(*http.Server, *tls.Conn, http.Handler)
 
 // listen prepares a listener, and adds it to "servers", to be launched (if not running as root) through Serve.
-func listen1(ip string, port int, tlsConfig *tls.Config, name string, kinds []string, handler http.Handler, nextProto tlsNextProtoMap) {
-	addr := net.JoinHostPort(ip, fmt.Sprintf("%d", port))
-
+func listen1(network, addr string, tlsConfig *tls.Config, name string, kinds []string, handler http.Handler, nextProto tlsNextProtoMap) {
 	var protocol string
 	var ln net.Listener
 	var err error
@@ -968,10 +1022,11 @@ func listen1(ip string, port int, tlsConfig *tls.Config, name string, kinds []st
 				slog.String("kinds", strings.Join(kinds, ",")),
 				slog.String("address", addr))
 		}
-		ln, err = mox.Listen(mox.Network(ip), addr)
+		ln, err = mox.Listen(network, addr)
 		if err != nil {
 			pkglog.Fatalx("http: listen", err, slog.Any("addr", addr))
 		}
+		ln = mox.IPAccessListener(ln, protocol, mox.Conf.Static.Listeners[name].IPAccess)
 	} else {
 		protocol = "https"
 		if os.Getuid() == 0 {
@@ -980,10 +1035,11 @@ func listen1(ip string, port int, tlsConfig *tls.Config, name string, kinds []st
 				slog.String("kinds", strings.Join(kinds, ",")),
 				slog.String("address", addr))
 		}
-		ln, err = mox.Listen(mox.Network(ip), addr)
+		ln, err = mox.Listen(network, addr)
 		if err != nil {
 			pkglog.Fatalx("https: listen", err, slog.String("addr", addr))
 		}
+		ln = mox.IPAccessListener(ln, protocol, mox.Conf.Static.Listeners[name].IPAccess)
 		ln = tls.NewListener(ln, tlsConfig)
 	}
 
@@ -1003,6 +1059,9 @@ func listen1(ip string, port int, tlsConfig *tls.Config, name string, kinds []st
 	if err != nil {
 		pkglog.Fatalx("https: unable to configure http2", err)
 	}
+	if tlsConfig != nil {
+		tlsConfig.NextProtos = mox.FilterALPNProtocols(mox.Conf.Static.Listeners[name].TLS, tlsConfig.NextProtos)
+	}
 	serve := func() {
 		err := server.Serve(ln)
 		pkglog.Fatalx(protocol+": serve", err)
@@ -0,0 +1,267 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// WebserverExport writes static files and configuration snippets for nginx,
+// caddy and apache to dir, for serving the MTA-STS policies and autoconfig files
+// of all configured domains from an existing webserver instead of mox.
+//
+// Static files are written to dir/static/<hostname>/, one directory per
+// mta-sts.<domain> and autoconfig.<domain> virtual host. Directories of domains
+// that are no longer configured are removed. The configuration snippets are
+// written to dir/nginx.conf, dir/Caddyfile and dir/apache.conf. Autodiscover
+// requests need the email address in the request, they are forwarded to mox at
+// proxyURL. If proxyURL is empty, the address of a listener with a non-TLS
+// autoconfig endpoint is used.
+//
+// Files are replaced atomically, so a webserver never reads partially written
+// files.
+func WebserverExport(log mlog.Log, dir, proxyURL string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("absolute path for export directory: %v", err)
+	}
+	if proxyURL == "" {
+		proxyURL = autoconfigProxyURL()
+	}
+	proxyURL = strings.TrimSuffix(proxyURL, "/")
+
+	names := mox.Conf.Domains()
+	slices.Sort(names)
+
+	var nginx, caddy, apache bytes.Buffer
+	header := fmt.Sprintf("Generated by \"mox config webserver-snippets\", do not edit, changes will be overwritten.\nTLS certificates for the hostnames must be configured. Autodiscover requests are\nforwarded to mox at %s.\n", proxyURL)
+	for _, l := range strings.Split(strings.TrimSpace(header), "\n") {
+		fmt.Fprintf(&nginx, "# %s\n", l)
+		fmt.Fprintf(&caddy, "# %s\n", l)
+		fmt.Fprintf(&apache, "# %s\n", l)
+	}
+
+	// Hostnames we wrote static files for, to remove old directories.
+	hosts := map[string]bool{}
+
+	for _, name := range names {
+		d, err := dns.ParseDomain(name)
+		if err != nil {
+			return fmt.Errorf("parsing domain %q: %v", name, err)
+		}
+		domConf, _ := mox.Conf.Domain(d)
+		if domConf.Disabled {
+			continue
+		}
+
+		policy, err := MTASTSPolicy(d)
+		if err != nil {
+			return fmt.Errorf("mta-sts policy for domain %s: %v", d, err)
+		}
+		if policy != nil {
+			host := "mta-sts." + d.ASCII
+			root := filepath.Join(dir, "static", host)
+			if err := writeFileAtomic(filepath.Join(root, ".well-known", "mta-sts.txt"), []byte(policy.String())); err != nil {
+				return err
+			}
+			hosts[host] = true
+
+			fmt.Fprintf(&nginx, `
+server {
+	listen 443 ssl;
+	listen [::]:443 ssl;
+	server_name %[1]s;
+	# ssl_certificate /path/to/%[1]s-chain.crt.pem;
+	# ssl_certificate_key /path/to/%[1]s.key.pem;
+	root %[2]s;
+	location = /.well-known/mta-sts.txt {
+		default_type text/plain;
+		add_header Cache-Control "no-cache, max-age=0";
+	}
+	location / {
+		return 404;
+	}
+}
+`, host, root)
+
+			fmt.Fprintf(&caddy, `
+%[1]s {
+	root * %[2]s
+	header /.well-known/mta-sts.txt Content-Type text/plain
+	header /.well-known/mta-sts.txt Cache-Control "no-cache, max-age=0"
+	file_server
+}
+`, host, root)
+
+			fmt.Fprintf(&apache, `
+<VirtualHost *:443>
+	ServerName %[1]s
+	SSLEngine on
+	# SSLCertificateFile /path/to/%[1]s-chain.crt.pem
+	# SSLCertificateKeyFile /path/to/%[1]s.key.pem
+	DocumentRoot "%[2]s"
+	<Location "/.well-known/mta-sts.txt">
+		ForceType text/plain
+		Header set Cache-Control "no-cache, max-age=0"
+	</Location>
+</VirtualHost>
+`, host, root)
+		}
+
+		if domConf.ReportsOnly {
+			continue
+		}
+		buf, err := AutoconfigXML(d, "%EMAILADDRESS%")
+		if err != nil {
+			// E.g. no IMAP/submission listeners.
+			log.Errorx("generating autoconfig file, skipping", err, slog.Any("domain", d))
+			continue
+		}
+		host := "autoconfig." + d.ASCII
+		root := filepath.Join(dir, "static", host)
+		if err := writeFileAtomic(filepath.Join(root, "mail", "config-v1.1.xml"), buf); err != nil {
+			return err
+		}
+		hosts[host] = true
+
+		fmt.Fprintf(&nginx, `
+server {
+	listen 443 ssl;
+	listen [::]:443 ssl;
+	server_name %[1]s;
+	# ssl_certificate /path/to/%[1]s-chain.crt.pem;
+	# ssl_certificate_key /path/to/%[1]s.key.pem;
+	root %[2]s;
+	location = /mail/config-v1.1.xml {
+		default_type "application/xml; charset=utf-8";
+	}
+	location = /autodiscover/autodiscover.xml {
+		proxy_pass %[3]s;
+		proxy_set_header Host $host;
+		proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+		proxy_set_header X-Forwarded-Proto $scheme;
+	}
+	location / {
+		return 404;
+	}
+}
+`, host, root, proxyURL)
+
+		fmt.Fprintf(&caddy, `
+%[1]s {
+	reverse_proxy /autodiscover/autodiscover.xml %[3]s
+	root * %[2]s
+	header /mail/config-v1.1.xml Content-Type "application/xml; charset=utf-8"
+	file_server
+}
+`, host, root, proxyURL)
+
+		fmt.Fprintf(&apache, `
+<VirtualHost *:443>
+	ServerName %[1]s
+	SSLEngine on
+	# SSLCertificateFile /path/to/%[1]s-chain.crt.pem
+	# SSLCertificateKeyFile /path/to/%[1]s.key.pem
+	DocumentRoot "%[2]s"
+	ProxyPreserveHost On
+	ProxyPass "/autodiscover/autodiscover.xml" "%[3]s/autodiscover/autodiscover.xml"
+	<Location "/mail/config-v1.1.xml">
+		ForceType "application/xml; charset=utf-8"
+	</Location>
+</VirtualHost>
+`, host, root, proxyURL)
+	}
+
+	for name, buf := range map[string][]byte{"nginx.conf": nginx.Bytes(), "Caddyfile": caddy.Bytes(), "apache.conf": apache.Bytes()} {
+		if err := writeFileAtomic(filepath.Join(dir, name), buf); err != nil {
+			return err
+		}
+	}
+
+	// Remove static files for domains that are gone.
+	entries, err := os.ReadDir(filepath.Join(dir, "static"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading static files directory: %v", err)
+	}
+	for _, e := range entries {
+		if hosts[e.Name()] || !e.IsDir() || !strings.HasPrefix(e.Name(), "mta-sts.") && !strings.HasPrefix(e.Name(), "autoconfig.") {
+			continue
+		}
+		log.Info("removing static files for domain no longer configured", slog.String("host", e.Name()))
+		if err := os.RemoveAll(filepath.Join(dir, "static", e.Name())); err != nil {
+			return fmt.Errorf("removing static files for %s: %v", e.Name(), err)
+		}
+	}
+	return nil
+}
+
+// autoconfigProxyURL returns the URL of the first listener (by name) serving
+// autoconfig without TLS, typically behind an existing webserver acting as
+// reverse proxy. Falls back to the default port used by quickstart.
+func autoconfigProxyURL() string {
+	var names []string
+	for name := range mox.Conf.Static.Listeners {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		l := mox.Conf.Static.Listeners[name]
+		if !l.AutoconfigHTTPS.Enabled || !l.AutoconfigHTTPS.NonTLS || len(l.IPs) == 0 {
+			continue
+		}
+		ip := net.ParseIP(l.IPs[0])
+		if ip == nil {
+			continue
+		} else if ip.IsUnspecified() && ip.To4() != nil {
+			ip = net.IPv4(127, 0, 0, 1)
+		} else if ip.IsUnspecified() {
+			ip = net.IPv6loopback
+		}
+		return "http://" + net.JoinHostPort(ip.String(), fmt.Sprintf("%d", config.Port(l.AutoconfigHTTPS.Port, 443)))
+	}
+	return "http://127.0.0.1:81"
+}
+
+// writeFileAtomic writes buf to a temporary file and renames it to path, creating
+// parent directories as needed.
+func writeFileAtomic(path string, buf []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating directory for %s: %v", path, err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file for %s: %v", path, err)
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(buf); err != nil {
+		return fmt.Errorf("writing %s: %v", path, err)
+	}
+	if err := f.Chmod(0644); err != nil {
+		return fmt.Errorf("setting permissions on %s: %v", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing %s: %v", path, err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		f = nil
+		return fmt.Errorf("renaming %s: %v", path, err)
+	}
+	f = nil
+	return nil
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestWebserverExport(t *testing.T) {
+	os.RemoveAll("../testdata/web/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/web/mox.conf")
+	mox.ConfigDynamicPath = filepath.Join(filepath.Dir(mox.ConfigStaticPath), "domains.conf")
+	mox.MustLoadConfig(true, false)
+
+	// Autoconfig needs IMAP and submission listeners.
+	l := mox.Conf.Static.Listeners["local"]
+	l.IMAPS.Enabled = true
+	l.Submissions.Enabled = true
+	l.AutoconfigHTTPS.Enabled = true
+	l.AutoconfigHTTPS.NonTLS = true
+	l.AutoconfigHTTPS.Port = 81
+	mox.Conf.Static.Listeners["local"] = l
+
+	log := mlog.New("http", nil)
+	dir := t.TempDir()
+
+	// Static files of a removed domain must be cleaned up.
+	err := os.MkdirAll(filepath.Join(dir, "static", "mta-sts.gone.example"), 0755)
+	tcheck(t, err, "mkdir")
+
+	err = WebserverExport(log, dir, "")
+	tcheck(t, err, "export")
+
+	readFile := func(path string) string {
+		t.Helper()
+		buf, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		tcheck(t, err, "read file")
+		return string(buf)
+	}
+	contains := func(s, sub string) {
+		t.Helper()
+		if !strings.Contains(s, sub) {
+			t.Fatalf("missing %q in:\n%s", sub, s)
+		}
+	}
+
+	contains(readFile("static/mta-sts.mox.example/.well-known/mta-sts.txt"), "mode: enforce")
+	contains(readFile("static/autoconfig.mox.example/mail/config-v1.1.xml"), "<username>%EMAILADDRESS%</username>")
+	// Domain other.example has no mta-sts, and no addresses so no autoconfig.
+	if _, err := os.Stat(filepath.Join(dir, "static", "mta-sts.other.example")); err == nil {
+		t.Fatalf("mta-sts files written for domain without mta-sts")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "static", "autoconfig.other.example")); err == nil {
+		t.Fatalf("autoconfig files written for reports-only domain")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "static", "mta-sts.gone.example")); err == nil {
+		t.Fatalf("static files of removed domain still present")
+	}
+
+	nginx := readFile("nginx.conf")
+	contains(nginx, "server_name mta-sts.mox.example;")
+	contains(nginx, "proxy_pass http://127.0.0.1:81;")
+	contains(readFile("Caddyfile"), "reverse_proxy /autodiscover/autodiscover.xml http://127.0.0.1:81")
+	contains(readFile("apache.conf"), "ServerName autoconfig.mox.example")
+}
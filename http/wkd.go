@@ -0,0 +1,105 @@
+package http
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// wkdHandle serves OpenPGP keys of accounts through the Web Key Directory, using
+// the "advanced" method, at the openpgpkey subdomain.
+func wkdHandle(w http.ResponseWriter, r *http.Request) {
+	log := func() mlog.Log {
+		return pkglog.WithContext(r.Context())
+	}
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "405 - method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	host := strings.ToLower(r.Host)
+	if !strings.HasPrefix(host, "openpgpkey.") {
+		http.NotFound(w, r)
+		return
+	}
+	host = strings.TrimPrefix(host, "openpgpkey.")
+	nhost, _, err := net.SplitHostPort(host)
+	if err == nil {
+		// Only relevant for when host has a port.
+		host = nhost
+	}
+	domain, err := dns.ParseDomain(host)
+	if err != nil {
+		log().Debugx("wkd request: bad domain", err, slog.String("host", host))
+		http.NotFound(w, r)
+		return
+	}
+	if conf, ok := mox.Conf.Domain(domain); !ok || conf.ReportsOnly || conf.Disabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The path contains the domain again, it must match.
+	t := strings.Split(strings.TrimPrefix(r.URL.Path, "/.well-known/openpgpkey/"), "/")
+	if len(t) < 2 || !strings.EqualFold(t[0], domain.ASCII) {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0")
+
+	// An empty policy file signals support for WKD.
+	if len(t) == 2 && t[1] == "policy" {
+		w.Header().Set("Content-Type", "text/plain")
+		return
+	}
+
+	if len(t) != 3 || t[1] != "hu" || t[2] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	keys, err := store.OpenPGPKeysWKD(r.Context(), domain.ASCII, t[2])
+	if err != nil {
+		log().Errorx("looking up openpgp keys for wkd", err)
+		http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Multiple addresses can have the same hash, their keys are returned
+	// concatenated, clients select the key with a user id for the address they are
+	// looking up. Only keys are served for addresses that still belong to the
+	// account.
+	var keyData []byte
+	for _, pk := range keys {
+		addr, err := smtp.ParseAddress(pk.Address)
+		if err != nil {
+			log().Errorx("parsing address of openpgp key", err, slog.String("address", pk.Address))
+			continue
+		}
+		accName, _, _, _, err := mox.LookupAddress(addr.Localpart, addr.Domain, false, false, true)
+		if err != nil || accName != pk.Account {
+			if err != nil && !errors.Is(err, mox.ErrAddressNotFound) && !errors.Is(err, mox.ErrDomainNotFound) && !errors.Is(err, mox.ErrDomainDisabled) {
+				log().Errorx("looking up address of openpgp key", err, slog.String("address", pk.Address))
+			}
+			continue
+		}
+		keyData = append(keyData, pk.KeyData...)
+	}
+	if len(keyData) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(keyData)
+}
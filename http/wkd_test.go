@@ -0,0 +1,87 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/wkd"
+)
+
+const testKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEatIr1BYJKwYBBAHaRw8BAQdACT6ZOfByvHCGzG46c1KvGooxU/iLaqLNobd7
+/t55c4a0Gk1veCBUZXN0IDxtamxAbW94LmV4YW1wbGU+iJAEExYIADgWIQQxfqgp
+TePUQKB4BaUy6/B0f8dsBQUCatIr1AIbAwULCQgHAgYVCgkICwIEFgIDAQIeAQIX
+gAAKCRAy6/B0f8dsBdM3AQDJM5gvUwbiGZcQwzOL9F2UpKRIOxgilICHQPckfpwz
+0QD+K7Po425muVXm4F8pz1zwQ1Q8Smn968j8A6R0zWIlxAk=
+=JHsk
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+func TestWKD(t *testing.T) {
+	os.RemoveAll("../testdata/web/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/web/mox.conf")
+	mox.ConfigDynamicPath = filepath.Join(filepath.Dir(mox.ConfigStaticPath), "domains.conf")
+	mox.MustLoadConfig(true, false)
+
+	ctx := context.Background()
+	err := store.Init(ctx)
+	if err != nil {
+		t.Fatalf("store init: %v", err)
+	}
+	defer store.Close()
+
+	addr, err := smtp.ParseAddress("mjl@mox.example")
+	if err != nil {
+		t.Fatalf("parse address: %v", err)
+	}
+	pk, err := store.ParseOpenPGPKey(addr, []byte(testKey))
+	if err != nil {
+		t.Fatalf("parse key: %v", err)
+	}
+	pk.Account = "mjl"
+	if err := store.OpenPGPKeySet(ctx, &pk); err != nil {
+		t.Fatalf("set key: %v", err)
+	}
+
+	test := func(method, target string, expCode int, expData []byte) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, nil)
+		rw := httptest.NewRecorder()
+		wkdHandle(rw, req)
+		if rw.Code != expCode {
+			t.Fatalf("got status %d, expected %d", rw.Code, expCode)
+		}
+		if expData != nil && rw.Body.String() != string(expData) {
+			t.Fatalf("got data %q, expected %q", rw.Body.Bytes(), expData)
+		}
+	}
+
+	hash := wkd.Hash("mjl")
+	test("GET", "http://openpgpkey.mox.example/.well-known/openpgpkey/mox.example/hu/"+hash, http.StatusOK, pk.KeyData)
+	test("GET", "http://openpgpkey.mox.example:443/.well-known/openpgpkey/mox.example/hu/"+hash+"?l=mjl", http.StatusOK, pk.KeyData)
+	test("GET", "http://openpgpkey.mox.example/.well-known/openpgpkey/mox.example/policy", http.StatusOK, []byte{})
+	test("POST", "http://openpgpkey.mox.example/.well-known/openpgpkey/mox.example/hu/"+hash, http.StatusMethodNotAllowed, nil)
+	test("GET", "http://openpgpkey.mox.example/.well-known/openpgpkey/mox.example/hu/"+wkd.Hash("other"), http.StatusNotFound, nil)
+	test("GET", "http://openpgpkey.mox.example/.well-known/openpgpkey/other.example/hu/"+hash, http.StatusNotFound, nil) // Domain mismatch.
+	test("GET", "http://openpgpkey.other.example/.well-known/openpgpkey/other.example/hu/"+hash, http.StatusNotFound, nil)
+	test("GET", "http://openpgpkey.unknown.example/.well-known/openpgpkey/unknown.example/policy", http.StatusNotFound, nil)
+	test("GET", "http://mox.example/.well-known/openpgpkey/mox.example/hu/"+hash, http.StatusNotFound, nil) // Direct method not supported.
+
+	// Addresses with localparts that only differ in case have the same hash, all
+	// their keys are returned.
+	pk2 := pk
+	pk2.ID = 0
+	pk2.Address = "Mjl@mox.example"
+	if err := store.OpenPGPKeySet(ctx, &pk2); err != nil {
+		t.Fatalf("set key: %v", err)
+	}
+	test("GET", "http://openpgpkey.mox.example/.well-known/openpgpkey/mox.example/hu/"+hash, http.StatusOK, append(append([]byte{}, pk2.KeyData...), pk.KeyData...))
+}
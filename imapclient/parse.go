@@ -626,6 +626,26 @@ func (p *Proto) xuntagged() Untagged {
 		p.xcrlf()
 		return UntaggedID(params)
 
+	// ../rfc/4467
+	case "GENURLAUTH":
+		var l []string
+		for p.space() {
+			l = append(l, p.xastring())
+		}
+		p.xcrlf()
+		return UntaggedGenURLAuth(l)
+
+	// ../rfc/4467
+	case "URLFETCH":
+		var l []URLFetchData
+		for p.space() {
+			url := p.xastring()
+			p.xspace()
+			l = append(l, URLFetchData{url, p.xnilptrString()})
+		}
+		p.xcrlf()
+		return UntaggedURLFetch(l)
+
 	// ../rfc/7162:2623
 	case "VANISHED":
 		p.xspace()
@@ -41,6 +41,7 @@ const (
 	CapCondstore           Capability = "CONDSTORE"          // ../rfc/7162:411
 	CapQresync             Capability = "QRESYNC"            // ../rfc/7162:1376
 	CapID                  Capability = "ID"                 // ../rfc/2971:80
+	CapLoginReferrals      Capability = "LOGIN-REFERRALS"    // ../rfc/2221
 	CapMetadata            Capability = "METADATA"           // ../rfc/5464:124
 	CapMetadataServer      Capability = "METADATA-SERVER"    // ../rfc/5464:124
 	CapSaveDate            Capability = "SAVEDATE"           // ../rfc/8514
@@ -53,6 +54,8 @@ const (
 	CapMultiSearch         Capability = "MULTISEARCH"        // ../rfc/7377:187
 	CapNotify              Capability = "NOTIFY"             // ../rfc/5465:195
 	CapUIDOnly             Capability = "UIDONLY"            // ../rfc/9586:129
+	CapCatenate            Capability = "CATENATE"           // ../rfc/4469
+	CapURLAuth             Capability = "URLAUTH"            // ../rfc/4467
 )
 
 // Status is the tagged final result of a command.
@@ -507,6 +510,20 @@ type QuotaResource struct {
 
 type UntaggedID map[string]string
 
+// UntaggedGenURLAuth is the response to GENURLAUTH, with URLAUTH-authorized
+// URLs. ../rfc/4467
+type UntaggedGenURLAuth []string
+
+// UntaggedURLFetch is the response to URLFETCH. ../rfc/4467
+type UntaggedURLFetch []URLFetchData
+
+// URLFetchData is the data for an URL in an URLFETCH response. Data is nil
+// if the URL could not be fetched.
+type URLFetchData struct {
+	URL  string
+	Data *string
+}
+
 // Extended data in an ESEARCH response.
 type EsearchDataExt struct {
 	Tag   string
@@ -104,6 +104,55 @@ func TestLoginDisabled(t *testing.T) {
 	tc.xcodeWord("AUTHENTICATIONFAILED")
 }
 
+func TestLoginReferral(t *testing.T) {
+	tc := start(t, false)
+	defer tc.close()
+
+	acc, err := store.OpenAccount(pkglog, "moved", false)
+	tcheck(t, err, "open account")
+	err = acc.SetPassword(pkglog, "test1234")
+	tcheck(t, err, "set password")
+	err = acc.Close()
+	tcheck(t, err, "close account")
+
+	referral := imapclient.CodeParams{Code: "REFERRAL", Args: []string{"imap://moved%40mox.example;AUTH=*@mail.example.org/"}}
+	tc.transactf("no", "authenticate plain %s", base64.StdEncoding.EncodeToString([]byte("\u0000moved@mox.example\u0000test1234")))
+	tc.xcode(referral)
+	tc.transactf("no", "login moved@mox.example test1234")
+	tc.xcode(referral)
+
+	// Only after valid credentials.
+	tc.transactf("no", "login moved@mox.example bogus")
+	tc.xcodeWord("AUTHENTICATIONFAILED")
+}
+
+func TestLoginCapture(t *testing.T) {
+	tc0 := start(t, false)
+	defer tc0.close()
+
+	// Started after setting up the data directory, for the next connection.
+	c, err := mox.CaptureStart(pkglog, "127.0.0.10", []string{"imap"}, false, time.Minute, 1)
+	tcheck(t, err, "start capture")
+	defer mox.CaptureStop(pkglog, c.ID)
+
+	tc := startNoSwitchboard(t, false)
+	defer tc.closeNoWait()
+
+	// Password as synchronizing literal, read after the command line.
+	tc.cmdf("", "login mjl@mox.example {%d}", len(password0))
+	tc.readprefixline("+ ")
+	tc.writelinef("%s", password0)
+	tc.readstatus("ok")
+	tc.transactf("ok", "noop")
+
+	buf, err := os.ReadFile(c.Path)
+	tcheck(t, err, "read capture file")
+	s := string(buf)
+	if strings.Contains(s, password0) || !strings.Contains(s, "C: x001 login ***") || !strings.Contains(s, "C: x002 noop") {
+		t.Fatalf("capture file with unexpected contents:\n%s", s)
+	}
+}
+
 func TestAuthenticateSCRAMSHA1(t *testing.T) {
 	testAuthenticateSCRAM(t, false, "SCRAM-SHA-1", sha1.New)
 }
@@ -234,8 +283,115 @@ func TestAuthenticateCRAMMD5(t *testing.T) {
 	tc.close()
 }
 
+func TestAuthenticateNoPlaintext(t *testing.T) {
+	tc := startArgsMore(t, false, true, false, nil, nil, true, true, true, "mjl", nil)
+	defer tc.close()
+
+	var caps []imapclient.Capability
+	for _, s := range serverCapabilitiesList {
+		if s != "AUTH=CRAM-MD5" {
+			caps = append(caps, imapclient.Capability(strings.ToUpper(s)))
+		}
+	}
+	caps = append(caps, "STARTTLS", "AUTH=OAUTHBEARER", "AUTH=XOAUTH2", "LOGINDISABLED")
+	tc.transactf("ok", "capability")
+	tc.xuntagged(imapclient.UntaggedCapability(caps))
+
+	tc.transactf("no", "login mjl@mox.example test1234")
+	tc.transactf("no", "authenticate plain %s", base64.StdEncoding.EncodeToString([]byte("\u0000mjl@mox.example\u0000"+password0)))
+	tc.transactf("no", "authenticate CRAM-MD5")
+	_, err := tc.client.AuthenticateSCRAM("SCRAM-SHA-256", sha256.New, "mjl@mox.example", password0)
+	tcheck(t, err, "scram authentication")
+}
+
+func TestAuthenticateOAuth(t *testing.T) {
+	tc := start(t, false)
+
+	token, err := store.OAuthTokenAdd(ctxbg, &store.OAuthToken{Account: "mjl", Name: "test", LoginAddress: "mjl@mox.example"})
+	tcheck(t, err, "add oauth token")
+
+	b64 := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	bearer := func(authz, token string) string {
+		return b64(fmt.Sprintf("n,%s,\x01auth=Bearer %s\x01\x01", authz, token))
+	}
+
+	tc.transactf("bad", "authenticate oauthbearer %s", b64("n,,\x01\x01"))                                     // Missing token.
+	tc.transactf("bad", "authenticate oauthbearer %s", b64("p=tls-unique,,\x01auth=Bearer "+token+"\x01\x01")) // Channel binding not supported.
+	tc.transactf("bad", "authenticate xoauth2 %s", b64("auth=Bearer "+token+"\x01\x01"))                       // Missing user.
+
+	// Bad token, and token for other address. Error details are sent in a
+	// continuation, the client responds with a dummy response.
+	fail := func(authz, token string) {
+		t.Helper()
+		tc.cmdf("", "authenticate oauthbearer %s", bearer(authz, token))
+		tc.readprefixline("+ ")
+		tc.writelinef("%s", b64("\x01"))
+		tc.readstatus("no")
+		tc.xcodeWord("AUTHENTICATIONFAILED")
+	}
+	fail("", token+"x")
+	fail("a=other@mox.example", token)
+
+	tc.transactf("ok", "authenticate oauthbearer %s", bearer("a=mjl@mox.example", token))
+	defer tc.close()
+
+	tc2 := startNoSwitchboard(t, false)
+	defer tc2.closeNoWait()
+	tc2.transactf("ok", "authenticate xoauth2 %s", b64("user=mjl@mox.example\x01auth=Bearer "+token+"\x01\x01"))
+
+	// Token no longer valid after removal.
+	tl, err := store.OAuthTokenList(ctxbg, "mjl")
+	tcheck(t, err, "list oauth tokens")
+	if len(tl) != 1 {
+		t.Fatalf("got %d oauth tokens, expected 1", len(tl))
+	}
+	err = store.OAuthTokenRemove(ctxbg, "mjl", tl[0].ID)
+	tcheck(t, err, "remove oauth token")
+	tc3 := startNoSwitchboard(t, false)
+	defer tc3.closeNoWait()
+	tc3.cmdf("", "authenticate xoauth2 %s", b64("user=mjl@mox.example\x01auth=Bearer "+token+"\x01\x01"))
+	tc3.readprefixline("+ ")
+	tc3.writelinef("")
+	tc3.readstatus("no")
+}
+
+func TestAuthenticateAPIToken(t *testing.T) {
+	tc := start(t, false)
+	defer tc.close()
+
+	readToken, err := store.APITokenAdd(ctxbg, &store.APIToken{Account: "mjl", Name: "read", Scope: store.APITokenScopeRead})
+	tcheck(t, err, "add api token")
+	sendToken, err := store.APITokenAdd(ctxbg, &store.APIToken{Account: "mjl", Name: "send", Scope: store.APITokenScopeSend})
+	tcheck(t, err, "add api token")
+
+	tc.transactf("no", `login mjl@mox.example "%s"`, readToken+"x")
+	tc.xcodeWord("AUTHENTICATIONFAILED")
+	tc.transactf("no", `login mjl@mox.example "%s"`, sendToken) // No imap access.
+	tc.xcodeWord("AUTHENTICATIONFAILED")
+	tc.transactf("no", "authenticate plain %s", base64.StdEncoding.EncodeToString([]byte("\u0000mjl@mox.example\u0000"+sendToken)))
+	tc.xcodeWord("AUTHENTICATIONFAILED")
+
+	// Read-only access with token of read scope.
+	tc.transactf("ok", `login mjl@mox.example "%s"`, readToken)
+	tc.transactf("ok", "select inbox")
+	tc.xcodeWord("READ-ONLY")
+	tc.transactf("no", "append inbox {1+}\r\nx")
+	tc.xcodeWord("NOPERM")
+	tc.transactf("no", "create newbox")
+	tc.xcodeWord("NOPERM")
+
+	tc2 := startNoSwitchboard(t, false)
+	defer tc2.closeNoWait()
+	tc2.transactf("no", "authenticate plain %s", base64.StdEncoding.EncodeToString([]byte("\u0000other@mox.example\u0000"+readToken))) // Other account.
+	tc2.xcodeWord("AUTHENTICATIONFAILED")
+	tc2.transactf("ok", "authenticate plain %s", base64.StdEncoding.EncodeToString([]byte("\u0000sub1@mox.example\u0000"+readToken)))
+	tc2.transactf("ok", "examine inbox")
+}
+
 func TestAuthenticateTLSClientCert(t *testing.T) {
-	tc := startArgsMore(t, false, true, true, nil, nil, true, true, "mjl", nil)
+	tc := startArgsMore(t, false, true, true, nil, nil, true, false, true, "mjl", nil)
 	tc.transactf("no", "authenticate external ") // No TLS auth.
 	tc.close()
 
@@ -258,7 +414,7 @@ func TestAuthenticateTLSClientCert(t *testing.T) {
 	}
 
 	// No preauth, explicit authenticate with TLS.
-	tc = startArgsMore(t, false, true, true, nil, &clientConfig, false, true, "mjl", addClientCert)
+	tc = startArgsMore(t, false, true, true, nil, &clientConfig, false, false, true, "mjl", addClientCert)
 	if tc.client.Preauth {
 		t.Fatalf("preauthentication while not configured for tls public key")
 	}
@@ -266,7 +422,7 @@ func TestAuthenticateTLSClientCert(t *testing.T) {
 	tc.close()
 
 	// External with explicit username.
-	tc = startArgsMore(t, false, true, true, nil, &clientConfig, false, true, "mjl", addClientCert)
+	tc = startArgsMore(t, false, true, true, nil, &clientConfig, false, false, true, "mjl", addClientCert)
 	if tc.client.Preauth {
 		t.Fatalf("preauthentication while not configured for tls public key")
 	}
@@ -274,12 +430,12 @@ func TestAuthenticateTLSClientCert(t *testing.T) {
 	tc.close()
 
 	// No preauth, also allow other mechanisms.
-	tc = startArgsMore(t, false, true, true, nil, &clientConfig, false, true, "mjl", addClientCert)
+	tc = startArgsMore(t, false, true, true, nil, &clientConfig, false, false, true, "mjl", addClientCert)
 	tc.transactf("ok", "authenticate plain %s", base64.StdEncoding.EncodeToString([]byte("\u0000mjl@mox.example\u0000"+password0)))
 	tc.close()
 
 	// No preauth, also allow other username for same account.
-	tc = startArgsMore(t, false, true, true, nil, &clientConfig, false, true, "mjl", addClientCert)
+	tc = startArgsMore(t, false, true, true, nil, &clientConfig, false, false, true, "mjl", addClientCert)
 	tc.transactf("ok", "authenticate plain %s", base64.StdEncoding.EncodeToString([]byte("\u0000móx@mox.example\u0000"+password0)))
 	tc.close()
 
@@ -290,12 +446,12 @@ func TestAuthenticateTLSClientCert(t *testing.T) {
 	tcheck(t, err, "set password")
 	err = acc.Close()
 	tcheck(t, err, "close account")
-	tc = startArgsMore(t, false, true, true, nil, &clientConfig, false, true, "mjl", addClientCert)
+	tc = startArgsMore(t, false, true, true, nil, &clientConfig, false, false, true, "mjl", addClientCert)
 	tc.transactf("no", "authenticate plain %s", base64.StdEncoding.EncodeToString([]byte("\u0000other@mox.example\u0000test1234")))
 	tc.close()
 
 	// Starttls and external auth.
-	tc = startArgsMore(t, false, true, false, nil, &clientConfig, false, true, "mjl", addClientCert)
+	tc = startArgsMore(t, false, true, false, nil, &clientConfig, false, false, true, "mjl", addClientCert)
 	tc.client.StartTLS(&clientConfig)
 	tc.transactf("ok", "authenticate external =")
 	tc.close()
@@ -311,9 +467,9 @@ func TestAuthenticateTLSClientCert(t *testing.T) {
 	}
 	ctx, cancel := context.WithCancel(ctxbg)
 	defer cancel()
-	mox.StartTLSSessionTicketKeyRefresher(ctx, pkglog, &serverConfig)
+	mox.StartTLSSessionTicketKeyRefresher(ctx, pkglog, &serverConfig, 0)
 	clientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(10)
-	tc = startArgsMore(t, false, true, true, &serverConfig, &clientConfig, false, true, "mjl", addClientCert)
+	tc = startArgsMore(t, false, true, true, &serverConfig, &clientConfig, false, false, true, "mjl", addClientCert)
 	if !tc.client.Preauth {
 		t.Fatalf("not preauthentication while configured for tls public key")
 	}
@@ -325,7 +481,7 @@ func TestAuthenticateTLSClientCert(t *testing.T) {
 	tc.close()
 
 	// Authentication works with TLS resumption.
-	tc = startArgsMore(t, false, true, true, &serverConfig, &clientConfig, false, true, "mjl", addClientCert)
+	tc = startArgsMore(t, false, true, true, &serverConfig, &clientConfig, false, false, true, "mjl", addClientCert)
 	if !tc.client.Preauth {
 		t.Fatalf("not preauthentication while configured for tls public key")
 	}
@@ -362,7 +518,7 @@ func TestAuthenticateTLSClientCert(t *testing.T) {
 	cid := connCounter
 	go func() {
 		defer serverConn.Close()
-		serve("test", cid, &serverConfig, serverConn, true, false, false, false, "")
+		serve("test", cid, &serverConfig, serverConn, true, false, false, false, false, "")
 		close(done)
 	}()
 
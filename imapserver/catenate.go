@@ -0,0 +1,96 @@
+package imapserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/mjl-/mox/imapurl"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+)
+
+// xappendCatenate reads the parts of a CATENATE append-data, starting after
+// "CATENATE (", writing the message to f. If f is nil, the message is discarded,
+// because an error will be returned later on. Before the continuation for a
+// synchronizing literal is written, xcheckSyncLiteral is called, which can still
+// abort the command. URLs that cannot be resolved are stored in badURL and
+// badURLErr, and the remaining message data discarded. The returned parser is for
+// the remainder of the command line.
+func (c *conn) xappendCatenate(p *parser, f *os.File, xcheckSyncLiteral func(), badURL *string, badURLErr *error) (*parser, *message.Writer) {
+	// Request syntax: ../rfc/4469
+
+	var w io.Writer = io.Discard
+	if f != nil {
+		w = f
+	}
+	mw := message.NewWriter(w)
+
+	for {
+		if p.take("TEXT ") {
+			// Always allow literal8, for binary extension. ../rfc/4466:486
+			size, synclit := p.xliteralSize(true, false)
+			if synclit {
+				xcheckSyncLiteral()
+				c.xwritelinef("+ ")
+			}
+
+			// Copy the literal, or discard it if we failed to resolve an earlier URL.
+			xw := io.Writer(mw)
+			if *badURL != "" {
+				xw = io.Discard
+			}
+			defer c.xtracewrite(mlog.LevelTracedata)()
+			n, err := io.Copy(xw, io.LimitReader(c.br, size))
+			c.xtracewrite(mlog.LevelTrace) // Restore.
+			if err != nil {
+				// Cannot use xcheckf due to %w handling of errIO.
+				c.xbrokenf("reading literal message: %s (%w)", err, errIO)
+			}
+			if n != size {
+				c.xbrokenf("read %d bytes for message, expected %d (%w)", n, size, errIO)
+			}
+
+			line := c.xreadline(false)
+			p = newParser(line, c)
+		} else if p.take("URL ") {
+			url := p.xastring()
+			if f != nil && *badURL == "" {
+				if err := c.catenateURL(url, mw); err != nil {
+					c.log.Debugx("resolving url for catenate", err)
+					*badURL = url
+					*badURLErr = err
+				}
+			}
+		} else {
+			xsyntaxErrorf("expected TEXT or URL")
+		}
+
+		if p.take(")") {
+			return p, mw
+		}
+		p.xspace()
+	}
+}
+
+// catenateURL writes the data referenced by URL to w. The URL can be
+// URLAUTH-authorized, possibly for another account. Otherwise, the URL must be
+// for this account, e.g. relative URL starting with a slash.
+func (c *conn) catenateURL(s string, w io.Writer) error {
+	u, err := imapurl.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Access != "" {
+		_, err := imapurl.FetchAuthorized(context.TODO(), c.log, u, c.account.Name, false, w)
+		return err
+	}
+	if u.User != "" {
+		if accName, err := imapurl.AccountName(u.User); err != nil || accName != c.account.Name {
+			return errors.New("url is for another user and not urlauth-authorized")
+		}
+	}
+	_, err = imapurl.Fetch(context.TODO(), c.log, c.account, u, false, w)
+	return err
+}
@@ -0,0 +1,83 @@
+package imapserver
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mox-"
+)
+
+var metricIMAPClientSessions = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_imap_client_sessions_total",
+		Help: "IMAP sessions with a client name sent with the ID command.",
+	},
+	[]string{
+		"client", // Lower-case name from ID command, or "other" when too many different names were seen.
+	},
+)
+
+// Client names are chosen by clients, we limit the number of distinct label values.
+const maxClientMetricNames = 50
+
+var clientMetricNames = struct {
+	sync.Mutex
+	names map[string]struct{}
+}{names: map[string]struct{}{}}
+
+// clientMetricName returns the label value for the client name in metrics.
+func clientMetricName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || r >= 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	if name == "" {
+		return "other"
+	}
+
+	clientMetricNames.Lock()
+	defer clientMetricNames.Unlock()
+	if _, ok := clientMetricNames.names[name]; !ok {
+		if len(clientMetricNames.names) >= maxClientMetricNames {
+			return "other"
+		}
+		clientMetricNames.names[name] = struct{}{}
+	}
+	return name
+}
+
+// clientQuirk returns the first configured IMAP client quirk matching the client
+// name and version, or nil.
+func clientQuirk(name, version string) *config.IMAPClientQuirk {
+	lname := strings.ToLower(name)
+	for i, q := range mox.Conf.Static.IMAPClientQuirks {
+		if strings.Contains(lname, strings.ToLower(q.ClientName)) && strings.HasPrefix(version, q.ClientVersion) {
+			return &mox.Conf.Static.IMAPClientQuirks[i]
+		}
+	}
+	return nil
+}
+
+// capabilitiesDisabled returns the capabilities disabled for the account and
+// through a client quirk.
+func (c *conn) capabilitiesDisabled() []string {
+	var l []string
+	if c.account != nil {
+		conf, _ := c.account.Conf()
+		l = append(l, conf.IMAPCapabilitiesDisabled...)
+	}
+	if c.quirk != nil {
+		l = append(l, c.quirk.CapabilitiesDisabled...)
+	}
+	return l
+}
@@ -3,12 +3,10 @@ package imapserver
 import (
 	"bytes"
 	"fmt"
-	"sort"
 	"strings"
 
 	"github.com/mjl-/bstore"
 
-	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/store"
 )
 
@@ -130,96 +128,55 @@ func (c *conn) cmdList(tag, cmd string, p *parser) {
 		}
 		patterns = n
 	}
-	re := xmailboxPatternMatcher(reference, patterns)
+	matcher := xmailboxPatternMatcher(reference, patterns)
 	var responseLines []string
 	var respMetadata []concatspace
 
 	c.account.WithRLock(func() {
 		c.xdbread(func(tx *bstore.Tx) {
-			type info struct {
-				mailbox    *store.Mailbox
-				subscribed bool
-			}
-			names := map[string]info{}
-			hasSubscribedChild := map[string]bool{}
-			hasChild := map[string]bool{}
-			var nameList []string
-
-			q := bstore.QueryTx[store.Mailbox](tx)
-			q.FilterEqual("Expunged", false)
-			err := q.ForEach(func(mb store.Mailbox) error {
-				names[mb.Name] = info{mailbox: &mb}
-				nameList = append(nameList, mb.Name)
-				for p := mox.ParentMailboxName(mb.Name); p != ""; p = mox.ParentMailboxName(p) {
-					hasChild[p] = true
-				}
-				return nil
-			})
-			xcheckf(err, "listing mailboxes")
-
-			qs := bstore.QueryTx[store.Subscription](tx)
-			err = qs.ForEach(func(sub store.Subscription) error {
-				info, ok := names[sub.Name]
-				info.subscribed = true
-				names[sub.Name] = info
-				if !ok {
-					nameList = append(nameList, sub.Name)
-				}
-				for p := mox.ParentMailboxName(sub.Name); p != ""; p = mox.ParentMailboxName(p) {
-					hasSubscribedChild[p] = true
-				}
-				return nil
-			})
-			xcheckf(err, "listing subscriptions")
+			tree, err := c.account.MailboxTree(tx)
+			xcheckf(err, "listing mailboxes and subscriptions")
 
-			sort.Strings(nameList) // For predictable order in tests.
-
-			for _, name := range nameList {
-				if !re.MatchString(name) {
-					continue
-				}
-				info := names[name]
+			for _, name := range matcher.Names(tree) {
+				info := tree.Entries[name]
 
 				var flags listspace
 				var extended listspace
-				if listRecursive && hasSubscribedChild[name] {
+				if listRecursive && info.HasSubscribedChildren {
 					extended = listspace{bare("CHILDINFO"), listspace{dquote("SUBSCRIBED")}}
 				}
-				if listSubscribed && info.subscribed {
+				if listSubscribed && info.Subscribed {
 					flags = append(flags, bare(`\Subscribed`))
-					if info.mailbox == nil {
+					if info.MailboxID == 0 {
 						flags = append(flags, bare(`\NonExistent`))
 					}
 				}
-				if (info.mailbox == nil || listSubscribed) && flags == nil && extended == nil {
+				if (info.MailboxID == 0 || listSubscribed) && flags == nil && extended == nil {
 					continue
 				}
 
 				if retChildren {
 					var f string
-					if hasChild[name] {
+					if info.HasChildren {
 						f = `\HasChildren`
 					} else {
 						f = `\HasNoChildren`
 					}
 					flags = append(flags, bare(f))
 				}
-				if !listSubscribed && retSubscribed && info.subscribed {
+				if !listSubscribed && retSubscribed && info.Subscribed {
 					flags = append(flags, bare(`\Subscribed`))
 				}
-				if info.mailbox != nil {
-					add := func(b bool, v string) {
-						if b {
-							flags = append(flags, bare(v))
-						}
+				add := func(b bool, v string) {
+					if b {
+						flags = append(flags, bare(v))
 					}
-					mb := info.mailbox
-					add(mb.Archive, `\Archive`)
-					add(mb.Draft, `\Drafts`)
-					add(mb.Junk, `\Junk`)
-					add(mb.Sent, `\Sent`)
-					add(mb.Trash, `\Trash`)
 				}
+				add(info.Archive, `\Archive`)
+				add(info.Draft, `\Drafts`)
+				add(info.Junk, `\Junk`)
+				add(info.Sent, `\Sent`)
+				add(info.Trash, `\Trash`)
 
 				var extStr string
 				if extended != nil {
@@ -228,16 +185,20 @@ func (c *conn) cmdList(tag, cmd string, p *parser) {
 				line := fmt.Sprintf(`* LIST %s "/" %s%s`, flags.pack(c), mailboxt(name).pack(c), extStr)
 				responseLines = append(responseLines, line)
 
-				if retStatusAttrs != nil && info.mailbox != nil {
-					responseLines = append(responseLines, c.xstatusLine(tx, *info.mailbox, retStatusAttrs))
+				// Mailbox counts are not in the tree, they change with each message.
+				if retStatusAttrs != nil && info.MailboxID != 0 {
+					mb := store.Mailbox{ID: info.MailboxID}
+					err := tx.Get(&mb)
+					xcheckf(err, "get mailbox")
+					responseLines = append(responseLines, c.xstatusLine(tx, mb, retStatusAttrs))
 				}
 
 				// ../rfc/9590:101
-				if info.mailbox != nil && len(retMetadata) > 0 {
+				if info.MailboxID != 0 && len(retMetadata) > 0 {
 					var meta listspace
 					for _, k := range retMetadata {
 						q := bstore.QueryTx[store.Annotation](tx)
-						q.FilterNonzero(store.Annotation{MailboxID: info.mailbox.ID, Key: k})
+						q.FilterNonzero(store.Annotation{MailboxID: info.MailboxID, Key: k})
 						q.FilterEqual("Expunged", false)
 						a, err := q.Get()
 						var v token
@@ -253,7 +214,7 @@ func (c *conn) cmdList(tag, cmd string, p *parser) {
 						}
 						meta = append(meta, astring(k), v)
 					}
-					line := concatspace{bare("*"), bare("METADATA"), mailboxt(info.mailbox.Name), meta}
+					line := concatspace{bare("*"), bare("METADATA"), mailboxt(name), meta}
 					respMetadata = append(respMetadata, line)
 				}
 			}
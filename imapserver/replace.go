@@ -53,7 +53,7 @@ func (c *conn) cmdxReplace(isUID bool, tag, cmd string, p *parser) {
 	}
 
 	// todo: only with utf8 should we we accept message headers with utf-8. we currently always accept them.
-	// todo: this is only relevant if we also support the CATENATE extension?
+	// todo: support CATENATE for REPLACE. ../rfc/8508
 	// ../rfc/6855:204
 	utf8 := p.take("UTF8 (")
 	if utf8 {
@@ -68,6 +68,9 @@ func (c *conn) cmdxReplace(isUID bool, tag, cmd string, p *parser) {
 	// function aborts handling this command.
 	var uidOld store.UID
 	checkMessage := func(tx *bstore.Tx) func() {
+		if c.accountReadOnly() {
+			return func() { xusercodeErrorf("NOPERM", "account is read-only") }
+		}
 		if c.readonly {
 			return func() { xuserErrorf("mailbox open in read-only mode") }
 		}
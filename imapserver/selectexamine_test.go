@@ -83,3 +83,24 @@ func testSelectExamine(t *testing.T, examine, uidonly bool) {
 	tc.xuntagged(uclosed, uflags, upermflags, uexists1, uuidval1, uuidnext2, ulist)
 	tc.xcodeWord(okcode)
 }
+
+// TestSelectReadOnlyAccount checks mailboxes of read-only accounts are opened
+// read-only, and changes are refused.
+func TestSelectReadOnlyAccount(t *testing.T) {
+	tc := startArgs(t, false, true, false, true, true, "readonly")
+	defer tc.close()
+	tc.login("readonly@mox.example", password0)
+
+	tc.transactf("ok", "select inbox")
+	tc.xcodeWord("READ-ONLY")
+	tc.transactf("ok", "status inbox (messages)")
+
+	tc.transactf("no", "append inbox {1+}\r\nx")
+	tc.xcodeWord("NOPERM")
+	tc.transactf("no", "create newbox")
+	tc.xcodeWord("NOPERM")
+	tc.transactf("no", "subscribe inbox")
+	tc.xcodeWord("NOPERM")
+	tc.transactf("no", "uid expunge 1")
+	tc.xcodeWord("NOPERM")
+}
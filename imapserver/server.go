@@ -47,6 +47,7 @@ import (
 	"maps"
 	"math"
 	"net"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -75,7 +76,9 @@ import (
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxio"
 	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/oauth"
 	"github.com/mjl-/mox/ratelimit"
+	"github.com/mjl-/mox/sasl"
 	"github.com/mjl-/mox/scram"
 	"github.com/mjl-/mox/store"
 )
@@ -168,6 +171,7 @@ var serverCapabilitiesList = []string{
 	"AUTH=SCRAM-SHA-1",                //
 	"AUTH=CRAM-MD5",                   // ../rfc/2195
 	"ID",                              // ../rfc/2971
+	"LOGIN-REFERRALS",                 // ../rfc/2221
 	"APPENDLIMIT=9223372036854775807", // ../rfc/7889:129, we support the max possible size, 1<<63 - 1
 	"CONDSTORE",                       // ../rfc/7162:411
 	"QRESYNC",                         // ../rfc/7162:1323
@@ -186,6 +190,8 @@ var serverCapabilitiesList = []string{
 	"MULTISEARCH",                     // ../rfc/7377:187
 	"NOTIFY",                          // ../rfc/5465:195
 	"UIDONLY",                         // ../rfc/9586:127
+	"CATENATE",                        // ../rfc/4469
+	"URLAUTH",                         // ../rfc/4467
 	// "COMPRESS=DEFLATE", // ../rfc/4978, disabled for interoperability issues: The flate reader (inflate) still blocks on partial flushes, preventing progress.
 }
 var serverCapabilities = strings.Join(serverCapabilitiesList, " ")
@@ -198,12 +204,15 @@ type conn struct {
 	tls               bool // Whether TLS has been initialized.
 	viaHTTPS          bool // Whether this connection came in via HTTPS (using TLS ALPN).
 	noTLSClientAuth   bool
+	noPlaintextAuth   bool               // Refuse LOGIN, and AUTHENTICATE with PLAIN and CRAM-MD5.
+	greeting          *config.Greeting   // Of listener, can be nil.
 	br                *bufio.Reader      // From remote, with TLS unwrapped in case of TLS, and possibly wrapping inflate.
 	tr                *moxio.TraceReader // Kept to change trace level when reading/writing cmd/auth/data.
 	line              chan lineErr       // If set, instead of reading from br, a line is read from this channel. For reading a line in IDLE while also waiting for mailbox/account updates.
 	lastLine          string             // For detecting if syntax error is fatal, i.e. if this ends with a literal. Without crlf.
 	xbw               *bufio.Writer      // To remote, with TLS added in case of TLS, and possibly wrapping deflate, see conn.xflateWriter. Writes go through xtw to conn.Write, which panics on errors, hence the "x".
 	xtw               *moxio.TraceWriter
+	capture           *mox.ConnCapture   // If set, protocol data is recorded for an admin-started capture.
 	xflateWriter      *moxio.FlateWriter // For flushing output after flushing conn.xbw, and for closing.
 	xflateBW          *bufio.Writer      // Wraps raw connection writes, xflateWriter writes here, also needs flushing.
 	slow              bool               // If set, reads are done with a 1 second sleep, and writes are done 1 byte at a time, to keep spammers busy.
@@ -231,6 +240,11 @@ type conn struct {
 	// userAgent is set by the ID command, which can happen at any time (before or
 	// after the authentication attempt we want to log it with).
 	userAgent string
+	// Client name and version from the first ID command with a name, for logging.
+	// Quirk is the configured compatibility workaround for the client, if any.
+	clientName    string
+	clientVersion string
+	quirk         *config.IMAPClientQuirk
 	// loginAttempt is set during authentication, typically picked up by the ID command
 	// that soon follows, or it will be flushed within 1s, or on connection teardown.
 	loginAttempt     *store.LoginAttempt
@@ -244,6 +258,7 @@ type conn struct {
 	noPreauth  bool   // If set, don't switch connection to "authenticated" after TLS handshake with client certificate authentication.
 	username   string // Full username as used during login.
 	account    *store.Account
+	tokenRead  bool        // Authenticated with an API token with read scope, no changes allowed.
 	comm       *store.Comm // For sending/receiving changes on mailboxes in account, e.g. from messages incoming on smtp, or another imap client.
 
 	mailboxID int64       // Only for StateSelected.
@@ -292,8 +307,13 @@ func stateCommands(cmds ...string) map[string]struct{} {
 var (
 	commandsStateAny              = stateCommands("capability", "noop", "logout", "id")
 	commandsStateNotAuthenticated = stateCommands("starttls", "authenticate", "login")
-	commandsStateAuthenticated    = stateCommands("enable", "select", "examine", "create", "delete", "rename", "subscribe", "unsubscribe", "list", "namespace", "status", "append", "idle", "lsub", "getquotaroot", "getquota", "getmetadata", "setmetadata", "compress", "esearch", "notify")
+	commandsStateAuthenticated    = stateCommands("enable", "select", "examine", "create", "delete", "rename", "subscribe", "unsubscribe", "list", "namespace", "status", "append", "idle", "lsub", "getquotaroot", "getquota", "getmetadata", "setmetadata", "compress", "esearch", "notify", "genurlauth", "resetkey", "urlfetch")
 	commandsStateSelected         = stateCommands("close", "unselect", "expunge", "search", "fetch", "store", "copy", "move", "uid expunge", "uid search", "uid fetch", "uid store", "uid copy", "uid move", "replace", "uid replace", "esearch")
+
+	// Commands that change mailboxes or messages, refused for read-only accounts.
+	// Append and replace check for read-only accounts themselves, because they have to
+	// consume literals.
+	commandsModify = stateCommands("create", "delete", "rename", "subscribe", "unsubscribe", "setmetadata", "expunge", "store", "copy", "move", "uid expunge", "uid store", "uid copy", "uid move")
 )
 
 // Commands that use sequence numbers. Cannot be used when UIDONLY is enabled.
@@ -334,6 +354,9 @@ var commands = map[string]func(c *conn, tag, cmd string, p *parser){
 	"compress":     (*conn).cmdCompress,
 	"esearch":      (*conn).cmdEsearch,
 	"notify":       (*conn).cmdNotify, // Connection does not have to be in selected state. ../rfc/5465:792 ../rfc/5465:921
+	"genurlauth":   (*conn).cmdGenurlauth,
+	"resetkey":     (*conn).cmdResetkey,
+	"urlfetch":     (*conn).cmdUrlfetch,
 
 	// Selected.
 	"check":       (*conn).cmdCheck,
@@ -395,15 +418,15 @@ func Listen() {
 
 		if listener.IMAP.Enabled {
 			port := config.Port(listener.IMAP.Port, 143)
-			for _, ip := range listener.IPs {
-				listen1("imap", name, ip, port, tlsConfig, false, noTLSClientAuth, listener.IMAP.NoRequireSTARTTLS)
+			for _, la := range mox.ListenAddresses(listener, "imap", port) {
+				listen1("imap", name, la.Network, la.Address, tlsConfig, false, noTLSClientAuth, listener.NoPlaintextAuth, listener.IMAP.NoRequireSTARTTLS, listener.IPAccess, listener.IMAP.IPAccess)
 			}
 		}
 
 		if listener.IMAPS.Enabled {
 			port := config.Port(listener.IMAPS.Port, 993)
-			for _, ip := range listener.IPs {
-				listen1("imaps", name, ip, port, tlsConfig, true, noTLSClientAuth, false)
+			for _, la := range mox.ListenAddresses(listener, "imaps", port) {
+				listen1("imaps", name, la.Network, la.Address, tlsConfig, true, noTLSClientAuth, listener.NoPlaintextAuth, false, listener.IPAccess, listener.IMAPS.IPAccess)
 			}
 		}
 	}
@@ -411,20 +434,19 @@ func Listen() {
 
 var servers []func()
 
-func listen1(protocol, listenerName, ip string, port int, tlsConfig *tls.Config, xtls, noTLSClientAuth, noRequireSTARTTLS bool) {
+func listen1(protocol, listenerName, network, addr string, tlsConfig *tls.Config, xtls, noTLSClientAuth, noPlaintextAuth, noRequireSTARTTLS bool, ipAccess ...*config.IPAccess) {
 	log := mlog.New("imapserver", nil)
-	addr := net.JoinHostPort(ip, fmt.Sprintf("%d", port))
 	if os.Getuid() == 0 {
 		log.Print("listening for imap",
 			slog.String("listener", listenerName),
 			slog.String("addr", addr),
 			slog.String("protocol", protocol))
 	}
-	network := mox.Network(ip)
 	ln, err := mox.Listen(network, addr)
 	if err != nil {
 		log.Fatalx("imap: listen for imap", err, slog.String("protocol", protocol), slog.String("listener", listenerName))
 	}
+	ln = mox.IPAccessListener(ln, protocol, ipAccess...)
 
 	// Each listener gets its own copy of the config, so session keys between different
 	// ports on same listener aren't shared. We rotate session keys explicitly in this
@@ -432,7 +454,7 @@ func listen1(protocol, listenerName, ip string, port int, tlsConfig *tls.Config,
 	// base TLS config would never get automatically managed/rotated session keys.
 	if tlsConfig != nil {
 		tlsConfig = tlsConfig.Clone()
-		mox.StartTLSSessionTicketKeyRefresher(mox.Shutdown, log, tlsConfig)
+		mox.StartTLSSessionTicketKeyRefresher(mox.Shutdown, log, tlsConfig, mox.Conf.Static.Listeners[listenerName].TLS.SessionTicketKeyRotation)
 	}
 
 	serve := func() {
@@ -444,7 +466,7 @@ func listen1(protocol, listenerName, ip string, port int, tlsConfig *tls.Config,
 			}
 
 			metricIMAPConnection.WithLabelValues(protocol).Inc()
-			go serve(listenerName, mox.Cid(), tlsConfig, conn, xtls, noTLSClientAuth, noRequireSTARTTLS, false, "")
+			go serve(listenerName, mox.Cid(), tlsConfig, conn, xtls, noTLSClientAuth, noPlaintextAuth, noRequireSTARTTLS, false, "")
 		}
 	}
 
@@ -452,12 +474,12 @@ func listen1(protocol, listenerName, ip string, port int, tlsConfig *tls.Config,
 }
 
 // ServeTLSConn serves IMAP on a TLS connection.
-func ServeTLSConn(listenerName string, conn *tls.Conn, tlsConfig *tls.Config) {
-	serve(listenerName, mox.Cid(), tlsConfig, conn, true, true, false, true, "")
+func ServeTLSConn(listenerName string, conn *tls.Conn, tlsConfig *tls.Config, noPlaintextAuth bool) {
+	serve(listenerName, mox.Cid(), tlsConfig, conn, true, true, noPlaintextAuth, false, true, "")
 }
 
 func ServeConnPreauth(listenerName string, cid int64, conn net.Conn, preauthAddress string) {
-	serve(listenerName, cid, nil, conn, false, true, true, false, preauthAddress)
+	serve(listenerName, cid, nil, conn, false, true, false, true, false, preauthAddress)
 }
 
 // Serve starts serving on all listeners, launching a goroutine per listener.
@@ -541,6 +563,20 @@ func (c *conn) flushChanges(changes []store.Change) {
 	}
 }
 
+// setCapture starts recording protocol data of the connection for a capture
+// started by the admin, if cc is not nil and no capture is active yet. With a nil
+// cc, an active capture is applied again, needed after replacing the trace
+// reader/writer for TLS or compression.
+func (c *conn) setCapture(cc *mox.ConnCapture) {
+	if cc != nil && c.capture == nil {
+		c.capture = cc
+	}
+	if c.capture != nil {
+		c.tr.SetCapture(c.capture)
+		c.xtw.SetCapture(c.capture)
+	}
+}
+
 func (c *conn) setSlow(on bool) {
 	if on && !c.slow {
 		c.log.Debug("connection changed to slow")
@@ -771,7 +807,7 @@ var cleanClose struct{} // Sentinel value for panic/recover indicating clean clo
 // preauthenticated.
 //
 // The connection is closed before returning.
-func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, xtls, noTLSClientAuth, noRequireSTARTTLS, viaHTTPS bool, preauthAddress string) {
+func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, xtls, noTLSClientAuth, noPlaintextAuth, noRequireSTARTTLS, viaHTTPS bool, preauthAddress string) {
 	var remoteIP net.IP
 	if a, ok := nc.RemoteAddr().(*net.TCPAddr); ok {
 		remoteIP = a.IP
@@ -786,6 +822,8 @@ func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, x
 		tls:               xtls,
 		viaHTTPS:          viaHTTPS,
 		noTLSClientAuth:   noTLSClientAuth,
+		noPlaintextAuth:   noPlaintextAuth,
+		greeting:          mox.Conf.Static.Listeners[listenerName].Greeting,
 		lastlog:           time.Now(),
 		baseTLSConfig:     tlsConfig,
 		remoteIP:          remoteIP,
@@ -808,6 +846,9 @@ func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, x
 		if c.username != "" {
 			l = append(l, slog.String("username", c.username))
 		}
+		if c.clientName != "" {
+			l = append(l, slog.String("client", c.clientName), slog.String("clientversion", c.clientVersion))
+		}
 		return l
 	})
 	c.tr = moxio.NewTraceReader(c.log, "C: ", c.conn)
@@ -815,6 +856,7 @@ func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, x
 	c.br = bufio.NewReader(c.tr)
 	c.xtw = moxio.NewTraceWriter(c.log, "S: ", c)
 	c.xbw = bufio.NewWriter(c.xtw)
+	c.setCapture(mox.CaptureConn("imap", c.cid, c.remoteIP, ""))
 
 	// Many IMAP connections use IDLE to wait for new incoming messages. We'll enable
 	// keepalive to get a higher chance of the connection staying alive, or otherwise
@@ -889,7 +931,7 @@ func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, x
 	}
 
 	// If remote IP/network resulted in too many authentication failures, refuse to serve.
-	if !mox.LimiterFailedAuth.CanAdd(c.remoteIP, time.Now(), 1) {
+	if !mox.LimiterFailedAuth.CanAdd(c.remoteIP, time.Now(), 1) || store.AuthLockedOut(c.remoteIP, "") {
 		metrics.AuthenticationRatelimitedInc("imap")
 		c.log.Debug("refusing connection due to many auth failures", slog.Any("remoteip", c.remoteIP))
 		c.xwritelinef("* BYE too many auth failures")
@@ -918,13 +960,24 @@ func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, x
 		c.username = preauthAddress
 		c.account = acc
 		c.comm = store.RegisterComm(c.account)
+		if c.capture == nil {
+			c.setCapture(mox.CaptureConn("imap", c.cid, nil, c.account.Name))
+		}
 	}
 
+	greetText := "mox imap"
+	if g := c.greeting; g != nil {
+		if g.IMAP != "" {
+			greetText = g.IMAP
+		} else if g.HideSoftware {
+			greetText = "imap ready"
+		}
+	}
 	if c.account != nil && !c.noPreauth {
 		c.state = stateAuthenticated
-		c.xwritelinef("* PREAUTH [CAPABILITY %s] mox imap welcomes %s", c.capabilities(), c.username)
+		c.xwritelinef("* PREAUTH [CAPABILITY %s] %s welcomes %s", c.capabilities(), greetText, c.username)
 	} else {
-		c.xwritelinef("* OK [CAPABILITY %s] mox imap", c.capabilities())
+		c.xwritelinef("* OK [CAPABILITY %s] %s", c.capabilities(), greetText)
 	}
 
 	// Ensure any pending loginAttempt is written before we stop.
@@ -1023,7 +1076,7 @@ func (c *conn) tlsClientAuthVerifyPeerCert(rawCerts [][]byte, verifiedChains [][
 	// If we had too many authentication failures from this IP, don't attempt
 	// authentication. If this is a new incoming connetion, it is closed after the TLS
 	// handshake.
-	if !mox.LimiterFailedAuth.CanAdd(c.remoteIP, time.Now(), 1) {
+	if !mox.LimiterFailedAuth.CanAdd(c.remoteIP, time.Now(), 1) || store.AuthLockedOut(c.remoteIP, "") {
 		return nil
 	}
 
@@ -1136,6 +1189,9 @@ func (c *conn) tlsClientAuthVerifyPeerCertParsed(cert *x509.Certificate) error {
 	acc = nil // Prevent cleanup by defer.
 	c.username = pubKey.LoginAddress
 	c.comm = store.RegisterComm(c.account)
+	if c.capture == nil {
+		c.setCapture(mox.CaptureConn("imap", c.cid, nil, c.account.Name))
+	}
 	c.log.Debug("tls client authenticated with client certificate",
 		slog.String("fingerprint", fp),
 		slog.String("username", c.username),
@@ -1151,6 +1207,7 @@ func (c *conn) xtlsHandshakeAndAuthenticate(conn net.Conn) {
 	c.conn = tlsConn
 	c.tr = moxio.NewTraceReader(c.log, "C: ", c.conn)
 	c.br = bufio.NewReader(c.tr)
+	c.setCapture(nil)
 
 	cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
 	ctx, cancel := context.WithTimeout(cidctx, time.Minute)
@@ -1369,6 +1426,10 @@ func (c *conn) command() {
 		xserverErrorf("unrecognized command")
 	}
 
+	if _, ok := commandsModify[cmdlow]; ok && c.accountReadOnly() {
+		xusercodeErrorf("NOPERM", "account is read-only")
+	}
+
 	// ../rfc/9586:172
 	if _, ok := commandsSequence[cmdlow]; ok && c.uidonly {
 		xsyntaxCodeErrorf("UIDREQUIRED", "cannot use message sequence numbers with uidonly")
@@ -1377,6 +1438,20 @@ func (c *conn) command() {
 	fn(c, tag, cmd, p)
 }
 
+// accountReadOnly returns whether the account has been configured for read-only
+// access, or the connection was authenticated with a read-only API token. The
+// configuration is checked for each command, so changes apply to existing
+// connections.
+func (c *conn) accountReadOnly() bool {
+	if c.account == nil {
+		return false
+	} else if c.tokenRead {
+		return true
+	}
+	accConf, _ := c.account.Conf()
+	return accConf.ReadOnly
+}
+
 func (c *conn) broadcast(changes []store.Change) {
 	if len(changes) == 0 {
 		return
@@ -1385,26 +1460,45 @@ func (c *conn) broadcast(changes []store.Change) {
 	c.comm.Broadcast(changes)
 }
 
-// matchStringer matches a string against reference + mailbox patterns.
-type matchStringer interface {
-	MatchString(s string) bool
+// mailboxMatcher matches mailbox names against reference + mailbox patterns.
+type mailboxMatcher struct {
+	re *regexp.Regexp // Nil if nothing can match.
+
+	// Literal prefixes of the patterns, up to the first wildcard. Matching names
+	// always start with one of the prefixes. Sorted, without prefixes that start with
+	// another prefix.
+	prefixes []string
 }
 
-type noMatch struct{}
+// MatchString returns whether name matches any of the patterns.
+func (m mailboxMatcher) MatchString(name string) bool {
+	return m.re != nil && m.re.MatchString(name)
+}
 
-// MatchString for noMatch always returns false.
-func (noMatch) MatchString(s string) bool {
-	return false
+// Names returns the sorted names from the mailbox tree matching the patterns. Only
+// the names starting with a literal prefix of the patterns are evaluated, so
+// patterns like "Archive/2024/%" don't cause a scan of all mailboxes.
+func (m mailboxMatcher) Names(t *store.MailboxTree) []string {
+	var l []string
+	for _, prefix := range m.prefixes {
+		for _, name := range t.WithPrefix(prefix) {
+			if m.re.MatchString(name) {
+				l = append(l, name)
+			}
+		}
+	}
+	return l
 }
 
 // xmailboxPatternMatcher returns a matcher for mailbox names given the reference and patterns.
 // Patterns can include "%" and "*", matching any character excluding and including a slash respectively.
-func xmailboxPatternMatcher(ref string, patterns []string) matchStringer {
+func xmailboxPatternMatcher(ref string, patterns []string) mailboxMatcher {
 	if strings.HasPrefix(ref, "/") {
-		return noMatch{}
+		return mailboxMatcher{}
 	}
 
 	var subs []string
+	var prefixes []string
 	for _, pat := range patterns {
 		if strings.HasPrefix(pat, "/") {
 			continue
@@ -1421,6 +1515,12 @@ func xmailboxPatternMatcher(ref string, patterns []string) matchStringer {
 			s = "Inbox" + s[len("Inbox"):]
 		}
 
+		if i := strings.IndexAny(s, "%*"); i >= 0 {
+			prefixes = append(prefixes, s[:i])
+		} else {
+			prefixes = append(prefixes, s)
+		}
+
 		// ../rfc/9051:2361
 		var rs string
 		for _, c := range s {
@@ -1436,12 +1536,22 @@ func xmailboxPatternMatcher(ref string, patterns []string) matchStringer {
 	}
 
 	if len(subs) == 0 {
-		return noMatch{}
+		return mailboxMatcher{}
 	}
 	rs := "^(" + strings.Join(subs, "|") + ")$"
 	re, err := regexp.Compile(rs)
 	xcheckf(err, "compiling regexp for mailbox patterns")
-	return re
+
+	// Remove prefixes covered by a shorter prefix, so names are evaluated only once,
+	// and in sorted order.
+	sort.Strings(prefixes)
+	var l []string
+	for _, prefix := range prefixes {
+		if len(l) == 0 || !strings.HasPrefix(prefix, l[len(l)-1]) {
+			l = append(l, prefix)
+		}
+	}
+	return mailboxMatcher{re, l}
 }
 
 func (c *conn) sequence(uid store.UID) msgseq {
@@ -1837,6 +1947,9 @@ func (c *conn) xapplyChanges(overflow bool, changes []store.Change, sendDelayed
 		case store.ChangeRemoveUIDs:
 			mbID = ch.MailboxID
 		case store.ChangeFlags:
+			if c.quirk != nil && c.quirk.NoUnsolicitedFlags {
+				continue
+			}
 			mbID = ch.MailboxID
 		case store.ChangeRemoveMailbox, store.ChangeAddMailbox, store.ChangeRenameMailbox, store.ChangeAddSubscription, store.ChangeRemoveSubscription:
 			n = append(n, change)
@@ -2391,17 +2504,14 @@ func (c *conn) cmdCapability(tag, cmd string, p *parser) {
 // For use in cmdCapability and untagged OK responses on connection start, login and authenticate.
 func (c *conn) capabilities() string {
 	caps := serverCapabilities
-	if c.account != nil {
-		conf, _ := c.account.Conf()
-		if len(conf.IMAPCapabilitiesDisabled) > 0 {
-			l := make([]string, 0, len(serverCapabilitiesList))
-			for _, cap := range serverCapabilitiesList {
-				if !slices.Contains(conf.IMAPCapabilitiesDisabled, strings.ToUpper(cap)) {
-					l = append(l, cap)
-				}
+	if disabled := c.capabilitiesDisabled(); len(disabled) > 0 {
+		l := make([]string, 0, len(serverCapabilitiesList))
+		for _, cap := range serverCapabilitiesList {
+			if !slices.Contains(disabled, strings.ToUpper(cap)) {
+				l = append(l, cap)
 			}
-			caps = strings.Join(l, " ")
 		}
+		caps = strings.Join(l, " ")
 	}
 
 	// ../rfc/9051:1238
@@ -2409,9 +2519,16 @@ func (c *conn) capabilities() string {
 	if !c.tls && c.baseTLSConfig != nil {
 		caps += " STARTTLS"
 	}
+	if c.noPlaintextAuth {
+		caps = strings.Replace(caps, " AUTH=CRAM-MD5", "", 1)
+	}
 	if c.tls || c.noRequireSTARTTLS {
-		caps += " AUTH=PLAIN"
-	} else {
+		if !c.noPlaintextAuth {
+			caps += " AUTH=PLAIN"
+		}
+		caps += " AUTH=OAUTHBEARER AUTH=XOAUTH2"
+	}
+	if !c.tls && !c.noRequireSTARTTLS || c.noPlaintextAuth {
 		caps += " LOGINDISABLED"
 	}
 	if c.tls && len(c.conn.(*tls.Conn).ConnectionState().PeerCertificates) > 0 && !c.viaHTTPS && !c.noTLSClientAuth {
@@ -2491,12 +2608,25 @@ func (c *conn) cmdID(tag, cmd string, p *parser) {
 		c.loginAttemptTime = time.Time{}
 	}
 
-	// We just log the client id.
+	// The first ID with a client name sets the client for logging, metrics and
+	// compatibility workarounds.
+	if c.clientName == "" && params["name"] != "" {
+		c.clientName = params["name"]
+		c.clientVersion = params["version"]
+		metricIMAPClientSessions.WithLabelValues(clientMetricName(c.clientName)).Inc()
+		c.quirk = clientQuirk(c.clientName, c.clientVersion)
+		if c.quirk != nil {
+			c.log.Info("applying compatibility workarounds for client", slog.String("quirkclientname", c.quirk.ClientName))
+		}
+	}
 	c.log.Info("client id", slog.Any("params", params))
 
 	// Response syntax: ../rfc/2971:243
 	// We send our name, and only the version for authenticated users. ../rfc/2971:193
-	if c.state == stateAuthenticated || c.state == stateSelected {
+	// Unless the listener is configured to hide the software.
+	if c.greeting != nil && c.greeting.HideSoftware {
+		c.xbwritelinef(`* ID NIL`)
+	} else if c.state == stateAuthenticated || c.state == stateSelected {
 		c.xbwritelinef(`* ID ("name" "mox" "version" %s)`, string0(moxvar.Version).pack(c))
 	} else {
 		c.xbwritelinef(`* ID ("name" "mox")`)
@@ -2553,6 +2683,7 @@ func (c *conn) cmdCompress(tag, cmd string, p *parser) {
 	fr := flate.NewReaderPartial(rc)
 	c.tr = moxio.NewTraceReader(c.log, "C: ", fr)
 	c.br = bufio.NewReader(c.tr)
+	c.setCapture(nil)
 }
 
 // STARTTLS enables TLS on the connection, after a plain text start.
@@ -2632,6 +2763,12 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 	p.xspace()
 	authType := p.xatom()
 
+	if c.noPlaintextAuth && slices.Contains([]string{"PLAIN", "CRAM-MD5"}, strings.ToUpper(authType)) {
+		c.loginAttempt.AuthMech = strings.ToLower(authType)
+		c.loginAttempt.Result = store.AuthBadProtocol
+		xuserErrorf("method not allowed, plaintext authentication is disabled")
+	}
+
 	xreadInitial := func() []byte {
 		var line string
 		if p.empty() {
@@ -2676,6 +2813,7 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 	// check that the account is the same.
 	var account *store.Account
 	var username string
+	var scope store.APITokenScope
 	defer func() {
 		if account != nil {
 			err := account.Close()
@@ -2710,7 +2848,7 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 		}
 
 		var err error
-		account, c.loginAttempt.AccountName, err = store.OpenEmailAuth(c.log, username, password, false)
+		account, c.loginAttempt.AccountName, scope, err = store.OpenEmailAuthToken(c.log, username, password, false)
 		if err != nil {
 			if errors.Is(err, store.ErrUnknownCredentials) {
 				c.loginAttempt.Result = store.AuthBadCredentials
@@ -2719,6 +2857,11 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 			}
 			xusercodeErrorf("", "error")
 		}
+		if scope == store.APITokenScopeSend {
+			c.loginAttempt.Result = store.AuthBadCredentials
+			c.log.Info("authentication with api token without imap access", slog.String("username", username))
+			xusercodeErrorf("AUTHENTICATIONFAILED", "bad credentials")
+		}
 
 	case "CRAM-MD5":
 		c.loginAttempt.AuthMech = strings.ToLower(authType)
@@ -2884,6 +3027,49 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 		// The message should be empty. todo: should we require it is empty?
 		xreadContinuation()
 
+	case "OAUTHBEARER", "XOAUTH2":
+		c.loginAttempt.AuthMech = strings.ToLower(authType)
+
+		if !c.noRequireSTARTTLS && !c.tls {
+			// ../rfc/9051:5194
+			xusercodeErrorf("PRIVACYREQUIRED", "tls required for login")
+		}
+
+		// Bearer token is in plain text, mark as traceauth.
+		defer c.xtraceread(mlog.LevelTraceauth)()
+		buf := xreadInitial()
+		c.xtraceread(mlog.LevelTrace) // Restore.
+		var authz, token string
+		var err error
+		if strings.ToUpper(authType) == "OAUTHBEARER" {
+			authz, token, err = sasl.ParseOAuthBearer(buf)
+		} else {
+			authz, token, err = sasl.ParseXOAuth2(buf)
+		}
+		if err != nil {
+			c.loginAttempt.Result = store.AuthBadProtocol
+			xsyntaxErrorf("%s", err)
+		}
+		c.loginAttempt.LoginAddress = authz
+
+		username, err = oauth.Verify(context.TODO(), c.log, token)
+		if err == nil && authz != "" && !strings.EqualFold(authz, username) {
+			err = fmt.Errorf("%w: token is for other user", oauth.ErrInvalidToken)
+		}
+		if err != nil && errors.Is(err, oauth.ErrInvalidToken) {
+			c.loginAttempt.Result = store.AuthBadCredentials
+			c.log.Infox("failed authentication attempt", err, slog.String("username", authz), slog.Any("remote", c.remoteIP))
+			// Error details are sent as continuation, client must respond with a dummy
+			// response before we send the final failure. ../rfc/7628
+			c.xwritelinef("+ %s", base64.StdEncoding.EncodeToString(oauth.ErrorResponse()))
+			c.xreadline(false)
+			xusercodeErrorf("AUTHENTICATIONFAILED", "bad token")
+		}
+		xcheckf(err, "verifying token")
+		c.loginAttempt.LoginAddress = username
+		account, c.loginAttempt.AccountName, _, err = store.OpenEmail(c.log, username, false)
+		xcheckf(err, "looking up address for token")
+
 	case "EXTERNAL":
 		c.loginAttempt.AuthMech = "external"
 
@@ -2914,11 +3100,17 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 
 	if accConf, ok := account.Conf(); !ok {
 		xserverErrorf("cannot get account config")
+	} else if store.AuthLockedOut(nil, account.Name) {
+		c.loginAttempt.Result = store.AuthBadCredentials
+		c.log.Info("account locked out after failed authentication attempts", slog.String("username", username))
+		xusercodeErrorf("AUTHENTICATIONFAILED", "bad credentials")
 	} else if accConf.LoginDisabled != "" {
 		c.loginAttempt.Result = store.AuthLoginDisabled
 		c.log.Info("account login disabled", slog.String("username", username))
 		// No AUTHENTICATIONFAILED code, clients could prompt users for different password.
 		xuserErrorf("%w: %s", store.ErrLoginDisabled, accConf.LoginDisabled)
+	} else if accConf.IMAPReferralHost != "" {
+		c.xreferral(accConf, username)
 	}
 
 	// We may already have TLS credentials. They won't have been enabled, or we could
@@ -2948,9 +3140,13 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 		account = nil // Prevent cleanup.
 	}
 	c.username = username
+	c.tokenRead = scope == store.APITokenScopeRead
 	if c.comm == nil {
 		c.comm = store.RegisterComm(c.account)
 	}
+	if c.capture == nil {
+		c.setCapture(mox.CaptureConn("imap", c.cid, nil, c.account.Name))
+	}
 
 	c.setSlow(false)
 	c.loginAttempt.AccountName = c.account.Name
@@ -2976,7 +3172,11 @@ func (c *conn) cmdLogin(tag, cmd string, p *parser) {
 		}
 	}()
 
-	// todo: get this line logged with traceauth. the plaintext password is included on the command line, which we've already read (before dispatching to this function).
+	// todo: get this line logged with traceauth. the plaintext password is included on the command line, which we've already read (before dispatching to this function). captures redact it.
+
+	// Username and password can be sent as literals, read while parsing, mark them as
+	// traceauth.
+	defer c.xtraceread(mlog.LevelTraceauth)()
 
 	// Request syntax: ../rfc/9051:6667 ../rfc/3501:4804
 	p.xspace()
@@ -2985,11 +3185,16 @@ func (c *conn) cmdLogin(tag, cmd string, p *parser) {
 	p.xspace()
 	password := p.xastring()
 	p.xempty()
+	c.xtraceread(mlog.LevelTrace) // Restore.
 
 	if !c.noRequireSTARTTLS && !c.tls {
 		// ../rfc/9051:5194
 		xusercodeErrorf("PRIVACYREQUIRED", "tls required for login")
 	}
+	if c.noPlaintextAuth {
+		c.loginAttempt.Result = store.AuthBadProtocol
+		xuserErrorf("login command not allowed, plaintext authentication is disabled")
+	}
 
 	// For many failed auth attempts, slow down verification attempts.
 	if c.authFailed > 3 && authFailDelay > 0 {
@@ -3004,7 +3209,7 @@ func (c *conn) cmdLogin(tag, cmd string, p *parser) {
 		}
 	}()
 
-	account, accName, err := store.OpenEmailAuth(c.log, username, password, true)
+	account, accName, scope, err := store.OpenEmailAuthToken(c.log, username, password, true)
 	c.loginAttempt.AccountName = accName
 	if err != nil {
 		var code string
@@ -3029,6 +3234,17 @@ func (c *conn) cmdLogin(tag, cmd string, p *parser) {
 			c.xsanity(err, "close account")
 		}
 	}()
+	if scope == store.APITokenScopeSend {
+		c.loginAttempt.Result = store.AuthBadCredentials
+		c.log.Info("login with api token without imap access", slog.String("username", username))
+		xusercodeErrorf("AUTHENTICATIONFAILED", "login failed")
+	}
+
+	if accConf, ok := account.Conf(); !ok {
+		xserverErrorf("cannot get account config")
+	} else if accConf.IMAPReferralHost != "" {
+		c.xreferral(accConf, username)
+	}
 
 	// We may already have TLS credentials. They won't have been enabled, or we could
 	// get here due to the state machine that doesn't allow authentication while being
@@ -3057,9 +3273,13 @@ func (c *conn) cmdLogin(tag, cmd string, p *parser) {
 		account = nil // Prevent cleanup.
 	}
 	c.username = username
+	c.tokenRead = scope == store.APITokenScopeRead
 	if c.comm == nil {
 		c.comm = store.RegisterComm(c.account)
 	}
+	if c.capture == nil {
+		c.setCapture(mox.CaptureConn("imap", c.cid, nil, c.account.Name))
+	}
 	c.loginAttempt.LoginAddress = c.username
 	c.loginAttempt.AccountName = c.account.Name
 	c.loginAttempt.Result = store.AuthSuccess
@@ -3069,6 +3289,16 @@ func (c *conn) cmdLogin(tag, cmd string, p *parser) {
 	c.xwriteresultf("%s OK [CAPABILITY %s] login done", tag, c.capabilities())
 }
 
+// xreferral refuses a login with valid credentials for an account that has moved
+// to another host, pointing the client at the same username on the new host.
+func (c *conn) xreferral(accConf config.Account, username string) {
+	// ../rfc/2221:132
+	c.loginAttempt.Result = store.AuthReferral
+	c.log.Info("login for account that has moved, sending referral", slog.String("username", username), slog.String("host", accConf.IMAPReferralHostDNS.ASCII))
+	user := strings.ReplaceAll(url.QueryEscape(username), "+", "%20")
+	xusercodeErrorf("REFERRAL imap://"+user+";AUTH=*@"+accConf.IMAPReferralHostDNS.ASCII+"/", "account has moved to %s", accConf.IMAPReferralHostDNS.ASCII)
+}
+
 // Enable explicitly opts in to an extension. A server can typically send new kinds
 // of responses to a client. Most extensions do not require an ENABLE because a
 // client implicitly opts in to new response syntax by making a requests that uses
@@ -3092,13 +3322,9 @@ func (c *conn) cmdEnable(tag, cmd string, p *parser) {
 	var enabled string
 	var qresync bool
 
-	// Accounts can suppress capabilities, we ignore them when the client tries to
-	// enable them.
-	var disabled []string
-	if c.account != nil {
-		conf, _ := c.account.Conf()
-		disabled = conf.IMAPCapabilitiesDisabled
-	}
+	// Accounts and client quirks can suppress capabilities, we ignore them when the
+	// client tries to enable them.
+	disabled := c.capabilitiesDisabled()
 
 	for _, s := range caps {
 		cap := capability(strings.ToUpper(s))
@@ -3481,7 +3707,9 @@ func (c *conn) cmdSelectExamine(isselect bool, tag, cmd string, p *parser) {
 		})
 	})
 
-	if isselect {
+	// Mailboxes of read-only accounts are always opened read-only, so fetching message
+	// contents doesn't set the \Seen flag, and close doesn't expunge.
+	if isselect && !c.accountReadOnly() {
 		c.xbwriteresultf("%s OK [READ-WRITE] x", tag)
 		c.readonly = false
 	} else {
@@ -3812,50 +4040,36 @@ func (c *conn) cmdLsub(tag, cmd string, p *parser) {
 	pattern := p.xlistMailbox()
 	p.xempty()
 
-	re := xmailboxPatternMatcher(ref, []string{pattern})
+	matcher := xmailboxPatternMatcher(ref, []string{pattern})
 
 	var lines []string
-	c.xdbread(func(tx *bstore.Tx) {
-		q := bstore.QueryTx[store.Subscription](tx)
-		q.SortAsc("Name")
-		subscriptions, err := q.List()
-		xcheckf(err, "querying subscriptions")
-
-		have := map[string]bool{}
-		subscribedKids := map[string]bool{}
-		ispercent := strings.HasSuffix(pattern, "%")
-		for _, sub := range subscriptions {
-			name := sub.Name
-			if ispercent {
-				for p := mox.ParentMailboxName(name); p != ""; p = mox.ParentMailboxName(p) {
-					subscribedKids[p] = true
+	c.account.WithRLock(func() {
+		c.xdbread(func(tx *bstore.Tx) {
+			tree, err := c.account.MailboxTree(tx)
+			xcheckf(err, "listing mailboxes and subscriptions")
+			names := matcher.Names(tree)
+
+			for _, name := range names {
+				if !tree.Entries[name].Subscribed {
+					continue
 				}
+				line := fmt.Sprintf(`* LSUB () "/" %s`, mailboxt(name).pack(c))
+				lines = append(lines, line)
 			}
-			if !re.MatchString(name) {
-				continue
-			}
-			have[name] = true
-			line := fmt.Sprintf(`* LSUB () "/" %s`, mailboxt(name).pack(c))
-			lines = append(lines, line)
-
-		}
 
-		// ../rfc/3501:2394
-		if !ispercent {
-			return
-		}
-		qmb := bstore.QueryTx[store.Mailbox](tx)
-		qmb.FilterEqual("Expunged", false)
-		qmb.SortAsc("Name")
-		err = qmb.ForEach(func(mb store.Mailbox) error {
-			if have[mb.Name] || !subscribedKids[mb.Name] || !re.MatchString(mb.Name) {
-				return nil
+			// ../rfc/3501:2394
+			if !strings.HasSuffix(pattern, "%") {
+				return
+			}
+			for _, name := range names {
+				e := tree.Entries[name]
+				if e.Subscribed || e.MailboxID == 0 || !e.HasSubscribedChildren {
+					continue
+				}
+				line := fmt.Sprintf(`* LSUB (\NoSelect) "/" %s`, mailboxt(name).pack(c))
+				lines = append(lines, line)
 			}
-			line := fmt.Sprintf(`* LSUB (\NoSelect) "/" %s`, mailboxt(mb.Name).pack(c))
-			lines = append(lines, line)
-			return nil
 		})
-		xcheckf(err, "querying mailboxes")
 	})
 
 	// Response syntax: ../rfc/3501:4833 ../rfc/3501:4837
@@ -3986,7 +4200,8 @@ func flaglist(fl store.Flags, keywords []string) listspace {
 
 // Append adds a message to a mailbox.
 // The MULTIAPPEND extension is implemented, allowing multiple flags/datetime/data
-// sets.
+// sets. The CATENATE extension is implemented, for composing a message from
+// literal text and (parts of) existing messages referenced by URL.
 //
 // State: Authenticated and selected.
 func (c *conn) cmdAppend(tag, cmd string, p *parser) {
@@ -4042,6 +4257,39 @@ func (c *conn) cmdAppend(tag, cmd string, p *parser) {
 
 	var overQuota bool // For response code.
 	var cancel bool    // In case we've seen zero-sized message append.
+	readOnly := c.accountReadOnly()
+	var badURL string // For CATENATE, URL that could not be resolved, for response code.
+	var badURLErr error
+
+	// Checks before we let the client send the data of a synchronizing literal. Until
+	// then, we can still return an error.
+	xcheckSyncLiteral := func() {
+		// Check for mailbox on first iteration.
+		if len(appends) <= 1 {
+			name = xcheckmailboxname(name, true)
+			c.xdbread(func(tx *bstore.Tx) {
+				c.xmailbox(tx, name, "TRYCREATE")
+			})
+		}
+
+		if readOnly {
+			xusercodeErrorf("NOPERM", "account is read-only")
+		}
+		if overQuota {
+			// ../rfc/9051:5155 ../rfc/9208:472
+			xusercodeErrorf("OVERQUOTA", "account over maximum total message size %d", quotaMsgMax)
+		}
+
+		// ../rfc/3502:140
+		if cancel {
+			xuserErrorf("empty message, cancelling append")
+		}
+
+		if badURL != "" {
+			// ../rfc/4469
+			xusercodeErrorf("BADURL "+badURL, "%s", badURLErr)
+		}
+	}
 
 	for {
 		// Append msg early, for potential cleanup.
@@ -4063,8 +4311,34 @@ func (c *conn) cmdAppend(tag, cmd string, p *parser) {
 		} else {
 			a.time = time.Now()
 		}
+		if p.take("CATENATE (") {
+			// Message composed of literal text and (parts of) messages referenced by URL.
+			// ../rfc/4469
+			if !(readOnly || overQuota || cancel || badURL != "") {
+				var err error
+				a.file, err = store.CreateMessageTemp(c.log, "imap-append")
+				xcheckf(err, "creating temp file for message")
+				defer store.CloseRemoveTempFile(c.log, a.file, "temporary message file")
+			}
+			p, a.mw = c.xappendCatenate(p, a.file, xcheckSyncLiteral, &badURL, &badURLErr)
+			msgSize := a.mw.Size
+			if !quotaUnlimited && !overQuota {
+				quotaAvail -= msgSize
+				overQuota = quotaAvail < 0
+			}
+			if msgSize == 0 && a.file != nil {
+				cancel = true
+			}
+			totalSize += msgSize
+
+			// The MULTIAPPEND extension allows more appends.
+			if !p.space() {
+				break
+			}
+			continue
+		}
+
 		// todo: only with utf8 should we we accept message headers with utf-8. we currently always accept them.
-		// todo: this is only relevant if we also support the CATENATE extension?
 		// ../rfc/6855:204
 		utf8 := p.take("UTF8 (")
 		if utf8 {
@@ -4084,23 +4358,7 @@ func (c *conn) cmdAppend(tag, cmd string, p *parser) {
 
 		var f io.Writer
 		if synclit {
-			// Check for mailbox on first iteration.
-			if len(appends) <= 1 {
-				name = xcheckmailboxname(name, true)
-				c.xdbread(func(tx *bstore.Tx) {
-					c.xmailbox(tx, name, "TRYCREATE")
-				})
-			}
-
-			if overQuota {
-				// ../rfc/9051:5155 ../rfc/9208:472
-				xusercodeErrorf("OVERQUOTA", "account over maximum total message size %d", quotaMsgMax)
-			}
-
-			// ../rfc/3502:140
-			if cancel {
-				xuserErrorf("empty message, cancelling append")
-			}
+			xcheckSyncLiteral()
 
 			// Read the message into a temporary file.
 			var err error
@@ -4113,7 +4371,7 @@ func (c *conn) cmdAppend(tag, cmd string, p *parser) {
 		} else {
 			// We'll discard the message and return an error as soon as we can (possible
 			// synchronizing literal of next message, or after we've seen all messages).
-			if overQuota || cancel {
+			if readOnly || overQuota || cancel || badURL != "" {
 				f = io.Discard
 			} else {
 				var err error
@@ -4152,6 +4410,9 @@ func (c *conn) cmdAppend(tag, cmd string, p *parser) {
 
 	name = xcheckmailboxname(name, true)
 
+	if readOnly {
+		xusercodeErrorf("NOPERM", "account is read-only")
+	}
 	if overQuota {
 		// ../rfc/9208:472
 		xusercodeErrorf("OVERQUOTA", "account over maximum total message size %d", quotaMsgMax)
@@ -4162,6 +4423,11 @@ func (c *conn) cmdAppend(tag, cmd string, p *parser) {
 		xuserErrorf("empty message, cancelling append")
 	}
 
+	if badURL != "" {
+		// ../rfc/4469
+		xusercodeErrorf("BADURL "+badURL, "%s", badURLErr)
+	}
+
 	var mb store.Mailbox
 	var overflow bool
 	var pendingChanges []store.Change
@@ -4791,6 +5057,8 @@ func (c *conn) cmdxCopy(isUID bool, tag, cmd string, p *parser) {
 					// is used for reputation calculation during future deliveries.
 					m.MailboxOrigID = m.MailboxDestinedID
 					m.IsReject = false
+					err := store.SenderAllowRescued(tx, conf.RejectsRescueAllow, m)
+					xcheckf(err, "adding sender allow entry")
 				}
 				m.TrainedJunk = nil
 				m.JunkFlagsForMailbox(mbDst, conf)
@@ -5054,6 +5322,8 @@ func (c *conn) xmoveMessages(tx *bstore.Tx, q *bstore.Query[store.Message], expe
 			nm.MailboxOrigID = nm.MailboxDestinedID
 			nm.IsReject = false
 			nm.Seen = false
+			err := store.SenderAllowRescued(tx, accConf.RejectsRescueAllow, nm)
+			xcheckf(err, "adding sender allow entry")
 		}
 
 		nm.JunkFlagsForMailbox(*mbDst, accConf)
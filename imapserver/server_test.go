@@ -21,6 +21,7 @@ import (
 
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/imapclient"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
@@ -455,7 +456,7 @@ const password0 = "te\u0301st \u00a0\u2002\u200a" // NFD and various unicode spa
 const password1 = "tést    "                      // PRECIS normalized, with NFC.
 
 func startArgs(t *testing.T, uidonly, first, immediateTLS bool, allowLoginWithoutTLS, setPassword bool, accname string) *testconn {
-	return startArgsMore(t, uidonly, first, immediateTLS, nil, nil, allowLoginWithoutTLS, setPassword, accname, nil)
+	return startArgsMore(t, uidonly, first, immediateTLS, nil, nil, allowLoginWithoutTLS, false, setPassword, accname, nil)
 }
 
 // namedConn wraps a conn so it can return a RemoteAddr with a non-empty name.
@@ -470,7 +471,7 @@ func (c namedConn) RemoteAddr() net.Addr {
 }
 
 // todo: the parameters and usage are too much now. change to scheme similar to smtpserver, with params in a struct, and a separate method for init and making a connection.
-func startArgsMore(t *testing.T, uidonly, first, immediateTLS bool, serverConfig, clientConfig *tls.Config, allowLoginWithoutTLS, setPassword bool, accname string, afterInit func() error) *testconn {
+func startArgsMore(t *testing.T, uidonly, first, immediateTLS bool, serverConfig, clientConfig *tls.Config, allowLoginWithoutTLS, noPlaintextAuth, setPassword bool, accname string, afterInit func() error) *testconn {
 	limitersInit() // Reset rate limiters.
 
 	switchStop := func() {}
@@ -556,7 +557,7 @@ func startArgsMore(t *testing.T, uidonly, first, immediateTLS bool, serverConfig
 	cid := connCounter - 1
 	go func() {
 		const viaHTTPS = false
-		serve("test", cid, serverConfig, serverConn, immediateTLS, false, allowLoginWithoutTLS, viaHTTPS, "")
+		serve("test", cid, serverConfig, serverConn, immediateTLS, false, noPlaintextAuth, allowLoginWithoutTLS, viaHTTPS, "")
 		close(done)
 	}()
 	var tc *testconn
@@ -898,6 +899,20 @@ func TestID(t *testing.T) {
 	tc.transactf("bad", `id ("name" "mox" "name" "mox")`) // Duplicate field.
 }
 
+// Test the software name and version are not revealed when configured to hide them.
+func TestIDHideSoftware(t *testing.T) {
+	tc := startArgsMore(t, false, true, false, nil, nil, true, false, true, "mjl", func() error {
+		mox.Conf.Static.Listeners["test"] = config.Listener{Greeting: &config.Greeting{HideSoftware: true}}
+		return nil
+	})
+	defer tc.close()
+	defer delete(mox.Conf.Static.Listeners, "test")
+	tc.login("mjl@mox.example", password0)
+
+	tc.transactf("ok", "id nil")
+	tc.xuntagged(imapclient.UntaggedID(nil))
+}
+
 func TestSequence(t *testing.T) {
 	testSequence(t, false)
 }
@@ -1002,8 +1017,8 @@ func TestCapabilitiesDisabled(t *testing.T) {
 			capsx = append(capsx, imapclient.Capability(s))
 		}
 	}
-	caps = append(caps, "STARTTLS", "AUTH=PLAIN")
-	capsx = append(capsx, "STARTTLS", "AUTH=PLAIN")
+	caps = append(caps, "STARTTLS", "AUTH=PLAIN", "AUTH=OAUTHBEARER", "AUTH=XOAUTH2")
+	capsx = append(capsx, "STARTTLS", "AUTH=PLAIN", "AUTH=OAUTHBEARER", "AUTH=XOAUTH2")
 
 	// Initially, all transactions are announced.
 	tc.transactf("ok", "capability")
@@ -1016,3 +1031,52 @@ func TestCapabilitiesDisabled(t *testing.T) {
 	tc.transactf("ok", "enable condstore uidonly")
 	tc.xuntagged(imapclient.UntaggedEnabled{imapclient.CapCondstore}) // Not UIDONLY.
 }
+
+// Test that compatibility workarounds are applied to clients matching the name and
+// version from the ID command.
+func TestClientQuirk(t *testing.T) {
+	tc := start(t, false)
+	defer tc.close()
+
+	tc2 := startNoSwitchboard(t, false)
+	defer tc2.closeNoWait()
+
+	orig := mox.Conf.Static.IMAPClientQuirks
+	defer func() {
+		mox.Conf.Static.IMAPClientQuirks = orig
+	}()
+	mox.Conf.Static.IMAPClientQuirks = []config.IMAPClientQuirk{
+		{ClientName: "outlook", ClientVersion: "15.", CapabilitiesDisabled: []string{"IDLE"}},
+		{ClientName: "outlook", CapabilitiesDisabled: []string{"CONDSTORE", "QRESYNC"}, NoUnsolicitedFlags: true},
+	}
+
+	var caps []imapclient.Capability
+	for _, s := range serverCapabilitiesList {
+		s = strings.ToUpper(s)
+		if s != "CONDSTORE" && s != "QRESYNC" {
+			caps = append(caps, imapclient.Capability(s))
+		}
+	}
+	caps = append(caps, "STARTTLS", "AUTH=PLAIN", "AUTH=OAUTHBEARER", "AUTH=XOAUTH2")
+
+	tc.login("mjl@mox.example", password0)
+	tc.transactf("ok", `id ("name" "Microsoft Outlook" "version" "16.0")`)
+	tc.transactf("ok", "capability")
+	tc.xuntagged(imapclient.UntaggedCapability(caps))
+	tc.transactf("ok", "enable condstore")
+	tc.xuntagged(imapclient.UntaggedEnabled(nil))
+
+	// A later ID doesn't change the client.
+	tc.transactf("ok", `id ("name" "Thunderbird")`)
+	tc.transactf("ok", "capability")
+	tc.xuntagged(imapclient.UntaggedCapability(caps))
+
+	// Flag changes from other sessions are not sent.
+	tc.client.Append("inbox", makeAppend(exampleMsg))
+	tc.client.Select("inbox")
+	tc2.login("mjl@mox.example", password0)
+	tc2.client.Select("inbox")
+	tc2.client.MSNStoreFlagsAdd("1", true, `\Seen`)
+	tc.transactf("ok", "noop")
+	tc.xuntagged()
+}
@@ -0,0 +1,158 @@
+package imapserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/imapurl"
+	"github.com/mjl-/mox/store"
+)
+
+// GenURLAuth generates URLAUTH-authorized URLs, giving access to a message (part)
+// to those matching the access identifier in the URL, e.g. a submission server
+// for BURL.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdGenurlauth(tag, cmd string, p *parser) {
+	// Command: ../rfc/4467
+
+	// Request syntax: ../rfc/4467
+	type genurl struct {
+		url  imapurl.URL
+		rump string
+	}
+	var l []genurl
+	for {
+		p.xspace()
+		rump := p.xastring()
+		p.xspace()
+		mech := p.xatom()
+		if !strings.EqualFold(mech, "INTERNAL") {
+			xuserErrorf("unsupported urlauth mechanism %q", mech)
+		}
+
+		u, err := imapurl.Parse(rump)
+		if err != nil {
+			xusercodeErrorf("BADURL "+rump, "%s", err)
+		}
+		if u.User == "" || u.Access == "" || u.Mechanism != "" {
+			xusercodeErrorf("BADURL "+rump, "url must be absolute, with urlauth access identifier and without mechanism")
+		}
+		if accName, err := imapurl.AccountName(u.User); err != nil || accName != c.account.Name {
+			xusercodeErrorf("BADURL "+rump, "url must be for authenticated user")
+		}
+		if u.Access == "anonymous" {
+			xusercodeErrorf("BADURL "+rump, "anonymous access not supported")
+		}
+		name, _, err := store.CheckMailboxName(u.Mailbox, true)
+		if err != nil {
+			xusercodeErrorf("BADURL "+rump, "%s", err)
+		}
+		u.Mailbox = name
+		l = append(l, genurl{u, rump})
+
+		if p.empty() {
+			break
+		}
+	}
+
+	var urls []string
+	c.account.WithWLock(func() {
+		c.xdbwrite(func(tx *bstore.Tx) {
+			for _, g := range l {
+				mb := c.xmailbox(tx, g.url.Mailbox, "")
+				if g.url.UIDValidity != 0 && g.url.UIDValidity != mb.UIDValidity {
+					xusercodeErrorf("BADURL "+g.rump, "uidvalidity does not match")
+				}
+				key, err := store.URLAuthKeyGet(tx, mb.ID, true)
+				xcheckf(err, "get mailbox access key")
+				urls = append(urls, g.rump+":INTERNAL:"+imapurl.Token(key, g.rump))
+			}
+		})
+	})
+
+	var resp string
+	for _, s := range urls {
+		resp += " " + astring(s).pack(c)
+	}
+	c.xbwritelinef("* GENURLAUTH%s", resp)
+	c.ok(tag, cmd)
+}
+
+// ResetKey removes the mailbox access keys for a mailbox or all mailboxes,
+// invalidating the URLAUTH-authorized URLs generated earlier.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdResetkey(tag, cmd string, p *parser) {
+	// Command: ../rfc/4467
+
+	// Request syntax: ../rfc/4467
+	var name string
+	if p.space() {
+		name = p.xmailbox()
+		for p.space() {
+			mech := p.xatom()
+			if !strings.EqualFold(mech, "INTERNAL") {
+				xuserErrorf("unsupported urlauth mechanism %q", mech)
+			}
+		}
+		name = xcheckmailboxname(name, true)
+	}
+	p.xempty()
+
+	c.account.WithWLock(func() {
+		c.xdbwrite(func(tx *bstore.Tx) {
+			var mailboxID int64
+			if name != "" {
+				mailboxID = c.xmailbox(tx, name, "").ID
+			}
+			err := store.URLAuthKeysReset(tx, mailboxID)
+			xcheckf(err, "reset mailbox access keys")
+		})
+	})
+
+	c.ok(tag, cmd)
+}
+
+// URLFetch returns the data for URLAUTH-authorized URLs. The access identifier of
+// the URLs must match the authenticated user.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdUrlfetch(tag, cmd string, p *parser) {
+	// Command: ../rfc/4467
+
+	// Request syntax: ../rfc/4467
+	var urls []string
+	for {
+		p.xspace()
+		urls = append(urls, p.xastring())
+		if p.empty() {
+			break
+		}
+	}
+
+	resp := concatspace{bare("URLFETCH")}
+	for _, s := range urls {
+		var data token = nilt
+		var b strings.Builder
+		u, err := imapurl.Parse(s)
+		if err == nil {
+			_, err = imapurl.FetchAuthorized(context.TODO(), c.log, u, c.account.Name, false, &b)
+		}
+		if err != nil {
+			c.log.Debugx("fetching url", err, slog.String("url", s))
+		} else {
+			data = syncliteral(b.String())
+		}
+		resp = append(resp, astring(s), data)
+	}
+
+	fmt.Fprint(c.xbw, "* ")
+	resp.xwriteTo(c, c.xbw)
+	c.xbw.Write([]byte("\r\n"))
+	c.ok(tag, cmd)
+}
@@ -0,0 +1,108 @@
+package imapserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/imapclient"
+)
+
+func TestURLAuth(t *testing.T) {
+	defer mockUIDValidity()()
+
+	tc := start(t, false)
+	defer tc.close()
+
+	tc.login("mjl@mox.example", password0)
+	tc.client.Append("inbox", makeAppend(exampleMsg))
+
+	const rump = "imap://mjl%40mox.example@mox.example/INBOX;UIDVALIDITY=1/;UID=1;URLAUTH=user+mjl%40mox.example"
+	const partialRump = "imap://mjl%40mox.example@mox.example/INBOX/;UID=1/;PARTIAL=0.4;URLAUTH=authuser"
+	const submitRump = "imap://mjl%40mox.example@mox.example/INBOX/;UID=1;URLAUTH=submit+mjl%40mox.example"
+
+	tc.transactf("bad", "genurlauth")                        // Missing params.
+	tc.transactf("bad", `genurlauth "%s"`, rump)             // Missing mechanism.
+	tc.transactf("no", `genurlauth "%s" OTHER`, rump)        // Unsupported mechanism.
+	tc.transactf("no", `genurlauth "imap://bogus" INTERNAL`) // Bad URL.
+	tc.xcode(imapclient.CodeParams{Code: "BADURL", Args: []string{"imap://bogus"}})
+	tc.transactf("no", `genurlauth "/INBOX/;UID=1" INTERNAL`)                                         // Not an absolute URL with access identifier.
+	tc.transactf("no", `genurlauth "%s" INTERNAL`, rump+":INTERNAL:0123456789abcdef0123456789abcdef") // Already has token.
+	tc.transactf("no", `genurlauth "%s" INTERNAL`, strings.Replace(rump, "user+mjl%40mox.example", "anonymous", 1))
+	tc.transactf("no", `genurlauth "%s" INTERNAL`, strings.Replace(rump, "mjl%40mox.example@", "other%40mox.example@", 1)) // Other user.
+	tc.transactf("no", `genurlauth "%s" INTERNAL`, strings.Replace(rump, "INBOX", "nobox", 1))
+	tc.transactf("no", `genurlauth "%s" INTERNAL`, strings.Replace(rump, "UIDVALIDITY=1", "UIDVALIDITY=2", 1))
+
+	tc.transactf("ok", `genurlauth "%s" INTERNAL "%s" INTERNAL "%s" INTERNAL`, rump, partialRump, submitRump)
+	var gen imapclient.UntaggedGenURLAuth
+	tuntagged(t, tc.lastResponse.Untagged[0], &gen)
+	if len(gen) != 3 || !strings.HasPrefix(gen[0], rump+":INTERNAL:") || !strings.HasPrefix(gen[1], partialRump+":INTERNAL:") || !strings.HasPrefix(gen[2], submitRump+":INTERNAL:") {
+		t.Fatalf("unexpected genurlauth response %v", gen)
+	}
+	url, partialURL, submitURL := gen[0], gen[1], gen[2]
+
+	// Same key is used for the mailbox, so the same URL is generated again.
+	tc.transactf("ok", `genurlauth "%s" INTERNAL`, rump)
+	tc.xuntagged(imapclient.UntaggedGenURLAuth{url})
+
+	// URLFETCH of a submit+ URL is not allowed, only through BURL.
+	badToken := rump + ":INTERNAL:" + strings.Repeat("0", 64)
+	tc.transactf("ok", `urlfetch "%s" "%s" "%s" "%s" "/INBOX/;UID=1"`, url, partialURL, submitURL, badToken)
+	msg, partial := exampleMsg, "Date"
+	tc.xuntagged(imapclient.UntaggedURLFetch{
+		{URL: url, Data: &msg},
+		{URL: partialURL, Data: &partial},
+		{URL: submitURL},
+		{URL: badToken},
+		{URL: "/INBOX/;UID=1"},
+	})
+
+	tc.transactf("no", "resetkey inbox other") // Unsupported mechanism.
+	tc.transactf("no", "resetkey nobox")
+	tc.transactf("ok", "resetkey inbox INTERNAL")
+	tc.transactf("ok", `urlfetch "%s"`, url)
+	tc.xuntagged(imapclient.UntaggedURLFetch{{URL: url}})
+
+	tc.transactf("ok", `genurlauth "%s" INTERNAL`, rump)
+	tuntagged(t, tc.lastResponse.Untagged[0], &gen)
+	if gen[0] == url {
+		t.Fatalf("url after resetkey is the same as before")
+	}
+	url = gen[0]
+	tc.transactf("ok", "resetkey")
+	tc.transactf("ok", `urlfetch "%s"`, url)
+	tc.xuntagged(imapclient.UntaggedURLFetch{{URL: url}})
+}
+
+func TestCatenate(t *testing.T) {
+	defer mockUIDValidity()()
+
+	tc := start(t, false)
+	defer tc.close()
+
+	tc.login("mjl@mox.example", password0)
+	tc.client.Append("inbox", makeAppend(exampleMsg))
+
+	tc.transactf("bad", "append inbox catenate ()")
+	tc.transactf("bad", "append inbox catenate (BOGUS)")
+
+	// Message composed of text and the body of the message already in the inbox.
+	body := exampleMsg[strings.Index(exampleMsg, "\r\n\r\n")+4:]
+	tc.transactf("ok", "append inbox catenate (TEXT {5+}\r\nhello URL \"/INBOX/;UID=1/;SECTION=TEXT\" TEXT {2+}\r\nxx)")
+	tc.xcode(imapclient.CodeAppendUID{UIDValidity: 1, UIDs: xparseUIDRange("2")})
+
+	tc.transactf("ok", `urlfetch "/INBOX/;UID=2"`) // Relative URLs cannot be fetched.
+	tc.client.Select("inbox")
+	tc.transactf("ok", "uid fetch 2 rfc822.size")
+	tc.xuntagged(tc.untaggedFetch(2, 2, imapclient.FetchRFC822Size(len("hello")+len(body)+len("xx"))))
+
+	// Multiple messages, with catenate and regular append.
+	tc.transactf("ok", "append inbox catenate (URL \"/INBOX/;UID=1\") {1+}\r\nx")
+	tc.xcode(imapclient.CodeAppendUID{UIDValidity: 1, UIDs: xparseUIDRange("3:4")})
+
+	// Unknown message, entire command fails.
+	tc.transactf("no", "append inbox catenate (TEXT {1+}\r\nx URL \"/INBOX/;UID=99\" TEXT {1+}\r\nx) {1+}\r\nx")
+	tc.xcode(imapclient.CodeParams{Code: "BADURL", Args: []string{"/INBOX/;UID=99"}})
+	tc.transactf("no", `append inbox catenate (URL "%s")`, "imap://other%40mox.example@mox.example/INBOX/;UID=1") // Other user without urlauth.
+	tc.transactf("ok", "uid fetch 5 uid")
+	tc.xuntagged()
+}
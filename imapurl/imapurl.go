@@ -0,0 +1,543 @@
+// Package imapurl parses IMAP URLs referencing messages and message parts, and
+// implements URLAUTH-authorized URLs.
+//
+// IMAP URLs are used by the IMAP CATENATE extension for composing a message from
+// parts of existing messages, and by the IMAP URLAUTH extension for giving access
+// to a message (part) through an URL, which is used by the SMTP BURL extension
+// for submitting a message without downloading and uploading it again.
+//
+// The only URLAUTH mechanism is INTERNAL, with tokens that are a HMAC-SHA256 of
+// the "rump" URL with a randomly generated per-mailbox "mailbox access key".
+package imapurl
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+var (
+	ErrSyntax     = errors.New("imapurl: invalid url")
+	ErrAccess     = errors.New("imapurl: access not allowed")
+	ErrMechanism  = errors.New("imapurl: unsupported urlauth mechanism")
+	ErrVerify     = errors.New("imapurl: urlauth verification failed")
+	ErrExpired    = errors.New("imapurl: url expired")
+	ErrUnresolved = errors.New("imapurl: url does not resolve to message data")
+)
+
+// URL is a parsed IMAP URL referencing a message or message part.
+type URL struct {
+	// User and Host are empty for relative URLs, which start with a slash and are
+	// relative to the user of an IMAP session.
+	User string
+	Host string // With optional port.
+
+	Mailbox     string // In UTF-8, not modified UTF-7.
+	UIDValidity uint32 // Zero if absent.
+	UID         uint32
+	Section     string   // Upper case, e.g. "1.2", "HEADER", "1.MIME". Empty for the whole message.
+	Partial     *Partial // Optional.
+
+	// URLAUTH.
+	Expire    time.Time // Zero if absent.
+	Access    string    // Access identifier, e.g. "submit+mjl@mox.example", "authuser". Empty if URL has no URLAUTH.
+	Mechanism string    // Upper case, e.g. "INTERNAL". Empty for a "rump" URL without token.
+	Token     string    // Hexadecimal.
+
+	rump string // URL as parsed up to and including the access identifier, over which the token is calculated.
+}
+
+// Partial is a byte range in the referenced data.
+type Partial struct {
+	Offset int64
+	Length int64 // Zero means until the end.
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// take a number from the start of s, returning the remainder.
+func takeNumber(s string, nonzero bool) (int64, string, error) {
+	var i int
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	v, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil || nonzero && v == 0 {
+		return 0, s, fmt.Errorf("%w: bad number %q", ErrSyntax, s[:i])
+	}
+	return v, s[i:], nil
+}
+
+func takeUint32(s string) (uint32, string, error) {
+	v, rest, err := takeNumber(s, true)
+	if err == nil && v > 0xffffffff {
+		err = fmt.Errorf("%w: number %d too large", ErrSyntax, v)
+	}
+	return uint32(v), rest, err
+}
+
+func decode(s, what string) (string, error) {
+	v, err := url.PathUnescape(s)
+	if err != nil {
+		return "", fmt.Errorf("%w: decoding %s: %v", ErrSyntax, what, err)
+	}
+	return v, nil
+}
+
+// Parse parses an absolute IMAP URL ("imap://...") referencing a message or
+// message part, or a relative URL starting with a slash (for use with CATENATE).
+// Both may have URLAUTH parameters. Scheme and parameter names are
+// case-insensitive.
+func Parse(s string) (URL, error) {
+	// Syntax: ../rfc/5092 ../rfc/4467
+	var u URL
+	rest := s
+	if hasPrefixFold(s, "imap://") {
+		rest = s[len("imap://"):]
+		i := strings.Index(rest, "/")
+		if i < 0 {
+			return URL{}, fmt.Errorf("%w: missing path", ErrSyntax)
+		}
+		authority := rest[:i]
+		rest = rest[i:]
+		if i := strings.LastIndex(authority, "@"); i >= 0 {
+			userinfo := authority[:i]
+			authority = authority[i+1:]
+			// We ignore any authentication mechanism.
+			if j := indexFold(userinfo, ";auth="); j >= 0 {
+				userinfo = userinfo[:j]
+			}
+			var err error
+			u.User, err = decode(userinfo, "user")
+			if err != nil {
+				return URL{}, err
+			}
+		}
+		if authority == "" {
+			return URL{}, fmt.Errorf("%w: missing host", ErrSyntax)
+		}
+		u.Host = authority
+	} else if !strings.HasPrefix(s, "/") {
+		return URL{}, fmt.Errorf("%w: must be absolute imap url or start with a slash", ErrSyntax)
+	}
+
+	rest = rest[1:]
+	i := indexFold(rest, "/;uid=")
+	if i < 0 {
+		return URL{}, fmt.Errorf("%w: missing uid", ErrSyntax)
+	}
+	mailbox := rest[:i]
+	rest = rest[i+len("/;uid="):]
+	if j := indexFold(mailbox, ";uidvalidity="); j >= 0 {
+		v, xrest, err := takeUint32(mailbox[j+len(";uidvalidity="):])
+		if err != nil {
+			return URL{}, err
+		} else if xrest != "" {
+			return URL{}, fmt.Errorf("%w: bad uidvalidity", ErrSyntax)
+		}
+		u.UIDValidity = v
+		mailbox = mailbox[:j]
+	}
+	var err error
+	u.Mailbox, err = decode(mailbox, "mailbox")
+	if err != nil {
+		return URL{}, err
+	} else if u.Mailbox == "" {
+		return URL{}, fmt.Errorf("%w: missing mailbox", ErrSyntax)
+	}
+
+	u.UID, rest, err = takeUint32(rest)
+	if err != nil {
+		return URL{}, err
+	}
+
+	if hasPrefixFold(rest, "/;section=") {
+		rest = rest[len("/;section="):]
+		end := len(rest)
+		for _, k := range []string{"/;partial=", ";expire=", ";urlauth="} {
+			if j := indexFold(rest, k); j >= 0 && j < end {
+				end = j
+			}
+		}
+		section, err := decode(rest[:end], "section")
+		if err != nil {
+			return URL{}, err
+		} else if section == "" {
+			return URL{}, fmt.Errorf("%w: empty section", ErrSyntax)
+		}
+		u.Section = strings.ToUpper(section)
+		rest = rest[end:]
+	}
+
+	if hasPrefixFold(rest, "/;partial=") {
+		var p Partial
+		p.Offset, rest, err = takeNumber(rest[len("/;partial="):], false)
+		if err != nil {
+			return URL{}, err
+		}
+		if strings.HasPrefix(rest, ".") {
+			p.Length, rest, err = takeNumber(rest[1:], true)
+			if err != nil {
+				return URL{}, err
+			}
+		}
+		u.Partial = &p
+	}
+
+	if hasPrefixFold(rest, ";expire=") {
+		rest = rest[len(";expire="):]
+		end := indexFold(rest, ";urlauth=")
+		if end < 0 {
+			return URL{}, fmt.Errorf("%w: expire without urlauth", ErrSyntax)
+		}
+		s, err := decode(rest[:end], "expire")
+		if err != nil {
+			return URL{}, err
+		}
+		u.Expire, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return URL{}, fmt.Errorf("%w: parsing expire: %v", ErrSyntax, err)
+		}
+		rest = rest[end:]
+	}
+
+	if hasPrefixFold(rest, ";urlauth=") {
+		rest = rest[len(";urlauth="):]
+		end := strings.Index(rest, ":")
+		if end < 0 {
+			end = len(rest)
+		}
+		access, err := decode(rest[:end], "access identifier")
+		if err != nil {
+			return URL{}, err
+		}
+		lower := strings.ToLower(access)
+		switch {
+		case lower == "anonymous" || lower == "authuser":
+			access = lower
+		case strings.HasPrefix(lower, "submit+") && len(access) > len("submit+"):
+			access = "submit+" + access[len("submit+"):]
+		case strings.HasPrefix(lower, "user+") && len(access) > len("user+"):
+			access = "user+" + access[len("user+"):]
+		default:
+			return URL{}, fmt.Errorf("%w: unknown access identifier %q", ErrSyntax, access)
+		}
+		u.Access = access
+		rest = rest[end:]
+		u.rump = s[:len(s)-len(rest)]
+
+		if rest != "" {
+			t := strings.Split(rest[1:], ":")
+			if len(t) != 2 || t[0] == "" {
+				return URL{}, fmt.Errorf("%w: bad urlauth mechanism and token", ErrSyntax)
+			}
+			if len(t[1]) < 32 {
+				return URL{}, fmt.Errorf("%w: urlauth token too short", ErrSyntax)
+			} else if _, err := hex.DecodeString(t[1]); err != nil {
+				return URL{}, fmt.Errorf("%w: urlauth token: %v", ErrSyntax, err)
+			}
+			u.Mechanism = strings.ToUpper(t[0])
+			u.Token = strings.ToLower(t[1])
+			rest = ""
+		}
+	}
+
+	if rest != "" {
+		return URL{}, fmt.Errorf("%w: unexpected trailing data %q", ErrSyntax, rest)
+	}
+	return u, nil
+}
+
+// Rump returns the URL up to and including the URLAUTH access identifier, as
+// parsed. Empty if the URL has no URLAUTH.
+func (u URL) Rump() string {
+	return u.rump
+}
+
+// Token returns the token for mechanism INTERNAL for the rump URL, given the
+// mailbox access key.
+func Token(key []byte, rump string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(rump))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify verifies the URLAUTH token of the URL with the mailbox access key, and
+// checks the URL has not expired.
+func (u URL) Verify(key []byte, now time.Time) error {
+	if u.Mechanism != "INTERNAL" {
+		return fmt.Errorf("%w: %q", ErrMechanism, u.Mechanism)
+	}
+	exp, err := hex.DecodeString(Token(key, u.rump))
+	if err != nil {
+		return err
+	}
+	got, err := hex.DecodeString(u.Token)
+	if err != nil || !hmac.Equal(exp, got) {
+		return ErrVerify
+	}
+	if !u.Expire.IsZero() && now.After(u.Expire) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// AccountName returns the account name for a user in an URL or access
+// identifier, an email address.
+func AccountName(user string) (string, error) {
+	addr, err := smtp.ParseAddress(user)
+	if err != nil {
+		return "", fmt.Errorf("parsing user as email address: %v", err)
+	}
+	accName, _, _, _, err := mox.LookupAddress(addr.Localpart, addr.Domain, false, false, false)
+	return accName, err
+}
+
+// Access checks whether the URLAUTH access identifier allows access to a session
+// authenticated for accountName. If submit is set, the session is the message
+// submission server acting on behalf of the account.
+func Access(access, accountName string, submit bool) error {
+	// ../rfc/4467
+	var user string
+	switch {
+	case access == "anonymous" || access == "authuser":
+		return nil
+	case strings.HasPrefix(access, "submit+") && submit:
+		user = access[len("submit+"):]
+	case strings.HasPrefix(access, "user+") && !submit:
+		user = access[len("user+"):]
+	default:
+		return fmt.Errorf("%w: access identifier %q", ErrAccess, access)
+	}
+	if accName, err := AccountName(user); err != nil || accName != accountName {
+		return fmt.Errorf("%w: for user in access identifier", ErrAccess)
+	}
+	return nil
+}
+
+// FetchAuthorized writes the data for an URLAUTH-authorized URL to w, after
+// checking the access identifier against the session authenticated for
+// accountName, and verifying the token.
+func FetchAuthorized(ctx context.Context, log mlog.Log, u URL, accountName string, submit bool, w io.Writer) (int64, error) {
+	if u.User == "" || u.Access == "" || u.Mechanism == "" {
+		return 0, fmt.Errorf("%w: not an urlauth-authorized url", ErrAccess)
+	}
+	if err := Access(u.Access, accountName, submit); err != nil {
+		return 0, err
+	}
+	acc, _, _, err := store.OpenEmail(log, u.User, false)
+	if err != nil {
+		return 0, fmt.Errorf("%w: opening account for user: %v", ErrUnresolved, err)
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	return Fetch(ctx, log, acc, u, true, w)
+}
+
+// Fetch writes the message or message part referenced by the URL from the
+// account to w. If verify is set, the URLAUTH token is verified.
+func Fetch(ctx context.Context, log mlog.Log, acc *store.Account, u URL, verify bool, w io.Writer) (n int64, rerr error) {
+	name, _, err := store.CheckMailboxName(u.Mailbox, true)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrUnresolved, err)
+	}
+
+	acc.WithRLock(func() {
+		var m store.Message
+		err := acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+			mb, err := acc.MailboxFind(tx, name)
+			if err != nil {
+				return err
+			} else if mb == nil {
+				return fmt.Errorf("%w: no such mailbox", ErrUnresolved)
+			}
+			if verify {
+				key, err := store.URLAuthKeyGet(tx, mb.ID, false)
+				if err == bstore.ErrAbsent {
+					return ErrVerify
+				} else if err != nil {
+					return fmt.Errorf("get mailbox access key: %v", err)
+				}
+				if err := u.Verify(key, time.Now()); err != nil {
+					return err
+				}
+			}
+			if u.UIDValidity != 0 && u.UIDValidity != mb.UIDValidity {
+				return fmt.Errorf("%w: uidvalidity does not match", ErrUnresolved)
+			}
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterNonzero(store.Message{MailboxID: mb.ID, UID: store.UID(u.UID)})
+			q.FilterEqual("Expunged", false)
+			m, err = q.Get()
+			if err == bstore.ErrAbsent {
+				return fmt.Errorf("%w: no such message", ErrUnresolved)
+			}
+			return err
+		})
+		if err != nil {
+			rerr = err
+			return
+		}
+
+		mr := acc.MessageReader(m)
+		defer func() {
+			err := mr.Close()
+			log.Check(err, "closing message reader")
+		}()
+
+		var r io.Reader = &moxio.AtReader{R: mr}
+		if u.Section != "" {
+			p, err := m.LoadPart(mr)
+			if err != nil {
+				rerr = fmt.Errorf("load parsed message: %v", err)
+				return
+			}
+			r, err = section(&p, u.Section)
+			if err != nil {
+				rerr = err
+				return
+			}
+		}
+		if u.Partial != nil {
+			if _, err := io.CopyN(io.Discard, r, u.Partial.Offset); err == io.EOF {
+				r = strings.NewReader("")
+			} else if err != nil {
+				rerr = fmt.Errorf("skipping to partial offset: %v", err)
+				return
+			} else if u.Partial.Length > 0 {
+				r = io.LimitReader(r, u.Partial.Length)
+			}
+		}
+		n, rerr = io.Copy(w, r)
+	})
+	return
+}
+
+// section returns a reader for the IMAP section of a message, e.g. "1.2",
+// "1.MIME", "HEADER", "2.TEXT" or "HEADER.FIELDS (SUBJECT)".
+func section(p *message.Part, s string) (io.Reader, error) {
+	// Part numbers, like for FETCH BODY[...]. ../rfc/9051:4481
+	var nums []int
+	for s != "" {
+		num, rest, _ := strings.Cut(s, ".")
+		v, err := strconv.ParseUint(num, 10, 32)
+		if err != nil || v == 0 {
+			break
+		}
+		nums = append(nums, int(v))
+		s = rest
+	}
+	if len(nums) > 0 && !(len(p.Parts) == 0 && p.Message == nil && len(nums) == 1 && nums[0] == 1) {
+		for i := range nums {
+			if p.Message != nil {
+				if err := p.SetMessageReaderAt(); err != nil {
+					return nil, fmt.Errorf("preparing embedded message: %v", err)
+				}
+				p = p.Message
+			}
+			index := nums[i] - 1
+			if index >= len(p.Parts) {
+				return nil, fmt.Errorf("%w: no such part", ErrUnresolved)
+			}
+			p = &p.Parts[index]
+		}
+	} else {
+		nums = nil
+	}
+
+	if s == "" {
+		return p.RawReader(), nil
+	} else if s == "MIME" {
+		return filterHeader(p, func(k string) bool { return strings.HasPrefix(k, "Content-") })
+	}
+
+	// HEADER, TEXT are for messages, i.e. the top-level or an embedded message.
+	if nums != nil {
+		if p.Message == nil {
+			return nil, fmt.Errorf("%w: part is not a message", ErrUnresolved)
+		}
+		if err := p.SetMessageReaderAt(); err != nil {
+			return nil, fmt.Errorf("preparing embedded message: %v", err)
+		}
+		p = p.Message
+	}
+	switch {
+	case s == "HEADER":
+		return p.HeaderReader(), nil
+	case s == "TEXT":
+		return p.RawReader(), nil
+	case strings.HasPrefix(s, "HEADER.FIELDS "), strings.HasPrefix(s, "HEADER.FIELDS.NOT "):
+		kind, list, _ := strings.Cut(s, " ")
+		if !strings.HasPrefix(list, "(") || !strings.HasSuffix(list, ")") {
+			return nil, fmt.Errorf("%w: bad header fields list", ErrSyntax)
+		}
+		fields := map[string]bool{}
+		for _, f := range strings.Fields(list[1 : len(list)-1]) {
+			fields[textproto.CanonicalMIMEHeaderKey(f)] = true
+		}
+		not := kind == "HEADER.FIELDS.NOT"
+		return filterHeader(p, func(k string) bool { return fields[k] != not })
+	}
+	return nil, fmt.Errorf("%w: unknown section %q", ErrSyntax, s)
+}
+
+// filterHeader returns the header of p with only the fields for which match
+// returns true, and the empty line ending the header.
+func filterHeader(p *message.Part, match func(key string) bool) (io.Reader, error) {
+	h, err := io.ReadAll(p.HeaderReader())
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+	var matched bool
+	hb := &bytes.Buffer{}
+	for len(h) > 0 {
+		line := h
+		if i := bytes.Index(line, []byte("\r\n")); i >= 0 {
+			line = line[:i+2]
+		}
+		h = h[len(line):]
+
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			// Continuation line, keep matched from previous field.
+		} else {
+			k := textproto.CanonicalMIMEHeaderKey(string(bytes.TrimRight(bytes.SplitN(line, []byte(":"), 2)[0], " \t")))
+			matched = match(k)
+		}
+		if matched || len(line) == 2 {
+			hb.Write(line)
+		}
+	}
+	return hb, nil
+}
@@ -0,0 +1,124 @@
+package imapurl
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/message"
+)
+
+func TestParse(t *testing.T) {
+	test := func(s string, exp URL, expErr error) {
+		t.Helper()
+		u, err := Parse(s)
+		if (err == nil) != (expErr == nil) || err != nil && !errors.Is(err, expErr) {
+			t.Fatalf("parse %q: got err %v, expected %v", s, err, expErr)
+		}
+		if err != nil {
+			return
+		}
+		u.rump = ""
+		if !reflect.DeepEqual(u, exp) {
+			t.Fatalf("parse %q: got %#v, expected %#v", s, u, exp)
+		}
+	}
+
+	test("/INBOX/;UID=1", URL{Mailbox: "INBOX", UID: 1}, nil)
+	test("/Other%20box;uidvalidity=10/;uid=2/;section=1.mime/;partial=10.20", URL{Mailbox: "Other box", UIDValidity: 10, UID: 2, Section: "1.MIME", Partial: &Partial{10, 20}}, nil)
+	test("/INBOX/;UID=1/;SECTION=HEADER.FIELDS%20(SUBJECT)", URL{Mailbox: "INBOX", UID: 1, Section: "HEADER.FIELDS (SUBJECT)"}, nil)
+	test("IMAP://mjl%40mox.example;AUTH=*@mox.example:143/INBOX/;UID=1/;PARTIAL=5", URL{User: "mjl@mox.example", Host: "mox.example:143", Mailbox: "INBOX", UID: 1, Partial: &Partial{5, 0}}, nil)
+	test("imap://mjl@mox.example@mox.example/INBOX/;UID=1;EXPIRE=2024-01-02T03:04:05Z;URLAUTH=SUBMIT+mjl@mox.example:internal:0123456789ABCDEF0123456789abcdef",
+		URL{User: "mjl@mox.example", Host: "mox.example", Mailbox: "INBOX", UID: 1, Expire: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Access: "submit+mjl@mox.example", Mechanism: "INTERNAL", Token: "0123456789abcdef0123456789abcdef"}, nil)
+	test("imap://mox.example/INBOX/;UID=1;URLAUTH=authuser", URL{Host: "mox.example", Mailbox: "INBOX", UID: 1, Access: "authuser"}, nil)
+
+	test("", URL{}, ErrSyntax)
+	test("INBOX/;UID=1", URL{}, ErrSyntax)
+	test("imap://mox.example", URL{}, ErrSyntax)
+	test("imap:///INBOX/;UID=1", URL{}, ErrSyntax)
+	test("/INBOX", URL{}, ErrSyntax)
+	test("//;UID=1", URL{}, ErrSyntax)
+	test("/INBOX/;UID=0", URL{}, ErrSyntax)
+	test("/INBOX/;UID=4294967296", URL{}, ErrSyntax)
+	test("/INBOX;UIDVALIDITY=x/;UID=1", URL{}, ErrSyntax)
+	test("/INBOX/;UID=1/;SECTION=", URL{}, ErrSyntax)
+	test("/INBOX/;UID=1/;PARTIAL=1.0", URL{}, ErrSyntax)
+	test("/INBOX/;UID=1;EXPIRE=2024-01-02T03:04:05Z", URL{}, ErrSyntax)
+	test("/INBOX/;UID=1;URLAUTH=bogus", URL{}, ErrSyntax)
+	test("/INBOX/;UID=1;URLAUTH=submit+", URL{}, ErrSyntax)
+	test("/INBOX/;UID=1;URLAUTH=authuser:INTERNAL:0123", URL{}, ErrSyntax)
+	test("/INBOX/;UID=1;URLAUTH=authuser:INTERNAL:0123456789abcdef0123456789abcdeg", URL{}, ErrSyntax)
+	test("/INBOX/;UID=1;URLAUTH=authuser:INTERNAL", URL{}, ErrSyntax)
+	test("/INBOX/;UID=1x", URL{}, ErrSyntax)
+}
+
+func TestVerify(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	rump := "imap://mjl%40mox.example@mox.example/INBOX/;UID=1;EXPIRE=2024-01-02T03:04:05Z;URLAUTH=authuser"
+	u, err := Parse(rump + ":INTERNAL:" + Token(key, rump))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if u.Rump() != rump {
+		t.Fatalf("got rump %q, expected %q", u.Rump(), rump)
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := u.Verify(key, now); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := u.Verify([]byte("other"), now); !errors.Is(err, ErrVerify) {
+		t.Fatalf("verify with other key: got %v, expected %v", err, ErrVerify)
+	}
+	if err := u.Verify(key, now.AddDate(1, 0, 0)); !errors.Is(err, ErrExpired) {
+		t.Fatalf("verify after expiration: got %v, expected %v", err, ErrExpired)
+	}
+	u.Mechanism = "OTHER"
+	if err := u.Verify(key, now); !errors.Is(err, ErrMechanism) {
+		t.Fatalf("verify with other mechanism: got %v, expected %v", err, ErrMechanism)
+	}
+}
+
+func TestSection(t *testing.T) {
+	const msg = "Subject: test\r\nContent-Type: multipart/mixed; boundary=x\r\n\r\n--x\r\nContent-Type: text/plain\r\nX-Other: 1\r\n\r\nhello\r\n--x\r\nContent-Type: message/rfc822\r\n\r\nSubject: inner\r\n\r\ninner body\r\n--x--\r\n"
+
+	test := func(s, exp string, expErr error) {
+		t.Helper()
+		p, err := message.Parse(nil, false, strings.NewReader(msg))
+		if err == nil {
+			err = p.Walk(nil, nil)
+		}
+		if err != nil {
+			t.Fatalf("parse message: %v", err)
+		}
+		r, err := section(&p, s)
+		if (err == nil) != (expErr == nil) || err != nil && !errors.Is(err, expErr) {
+			t.Fatalf("section %q: got err %v, expected %v", s, err, expErr)
+		}
+		if err != nil {
+			return
+		}
+		buf, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("reading section %q: %v", s, err)
+		}
+		if string(buf) != exp {
+			t.Fatalf("section %q: got %q, expected %q", s, buf, exp)
+		}
+	}
+
+	test("HEADER", "Subject: test\r\nContent-Type: multipart/mixed; boundary=x\r\n\r\n", nil)
+	test("HEADER.FIELDS (SUBJECT)", "Subject: test\r\n\r\n", nil)
+	test("HEADER.FIELDS.NOT (SUBJECT)", "Content-Type: multipart/mixed; boundary=x\r\n\r\n", nil)
+	test("1", "hello", nil)
+	test("1.MIME", "Content-Type: text/plain\r\n\r\n", nil)
+	test("2.HEADER", "Subject: inner\r\n\r\n", nil)
+	test("2.TEXT", "inner body", nil)
+	test("3", "", ErrUnresolved)
+	test("1.HEADER", "", ErrUnresolved)
+	test("BOGUS", "", ErrSyntax)
+	test("HEADER.FIELDS SUBJECT", "", ErrSyntax)
+}
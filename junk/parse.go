@@ -88,33 +88,25 @@ func (f *Filter) ParseMessage(p message.Part) (map[string]struct{}, error) {
 	return textWords, nil
 }
 
-// mailParse looks through the mail for the first text and html parts, and tokenizes their words.
+// mailParse looks through the mail for text and html parts, including those of
+// nested messages (e.g. when forwarding), and tokenizes their words.
 func (f *Filter) mailParse(p message.Part, metaWords, textWords, htmlWords map[string]struct{}) error {
-	ct := p.MediaType + "/" + p.MediaSubType
+	return p.WalkContent(func(p *message.Part, r io.Reader) error {
+		ct := p.MediaType + "/" + p.MediaSubType
+		r = message.DecodeReader(p.ContentTypeParams["charset"], r)
 
-	if ct == "TEXT/HTML" {
-		err := f.tokenizeHTML(p.ReaderUTF8OrBinary(), metaWords, htmlWords)
-		// log.Printf("html parsed, words %v", htmlWords)
-		return err
-	}
-	if ct == "" || strings.HasPrefix(ct, "TEXT/") {
-		err := f.tokenizeText(p.ReaderUTF8OrBinary(), textWords)
-		// log.Printf("text parsed, words %v", textWords)
-		return err
-	}
-	if p.Message != nil {
-		// Nested message, happens for forwarding.
-		if err := p.SetMessageReaderAt(); err != nil {
-			return fmt.Errorf("setting reader on nested message: %w", err)
+		if ct == "TEXT/HTML" {
+			err := f.tokenizeHTML(r, metaWords, htmlWords)
+			// log.Printf("html parsed, words %v", htmlWords)
+			return err
 		}
-		return f.mailParse(*p.Message, metaWords, textWords, htmlWords)
-	}
-	for _, sp := range p.Parts {
-		if err := f.mailParse(sp, metaWords, textWords, htmlWords); err != nil {
+		if ct == "" || strings.HasPrefix(ct, "TEXT/") {
+			err := f.tokenizeText(r, textWords)
+			// log.Printf("text parsed, words %v", textWords)
 			return err
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
 func looksRandom(s string) bool {
@@ -66,6 +66,15 @@ Recipient addresses with the following localpart suffixes are handled specially:
 
 If the localpart begins with "mailfrom" or "rcptto", the error is returned
 during those commands instead of during "data".
+
+With -acme, a new configuration requests TLS certificates through ACME from the
+directory URL instead of using a self-signed certificate, for host name
+mox.localhost. This is useful for testing ACME with a local ACME provider such as
+Pebble, e.g. configured with "httpPort" 1080 and "tlsPort" 1443 for validation,
+or started with PEBBLE_VA_ALWAYS_VALID=1. The CA certificate for the HTTPS
+connection to the ACME provider, e.g. Pebble's test/certs/pebble.minica.pem, can
+be specified with -acmecacert. Pebble also supports external account binding,
+which can be configured in the generated mox.conf.
 `
 	golog.SetFlags(0)
 
@@ -79,10 +88,12 @@ during those commands instead of during "data".
 		userConfDir = "$userconfigdir"
 	}
 
-	var dir, ip string
+	var dir, ip, acmeURL, acmeCACert string
 	var initOnly bool
 	c.flag.StringVar(&dir, "dir", filepath.Join(userConfDir, "mox-localserve"), "configuration storage directory")
 	c.flag.StringVar(&ip, "ip", "", "serve on this ip instead of default 127.0.0.1 and ::1. only used when writing configuration, at first launch.")
+	c.flag.StringVar(&acmeURL, "acme", "", "acme directory url to request tls certificates from, e.g. https://localhost:14000/dir for a local pebble. only used when writing configuration, at first launch.")
+	c.flag.StringVar(&acmeCACert, "acmecacert", "", "file with pem-encoded ca certificate for the https connection to the acme provider. only used with -acme.")
 	c.flag.BoolVar(&initOnly, "initonly", false, "write configuration files and exit")
 	args := c.Parse()
 	if len(args) != 0 {
@@ -97,7 +108,7 @@ during those commands instead of during "data".
 			log.Print("warning: directory for configuration files already exists, continuing")
 		}
 		log.Print("creating mox localserve config", slog.String("dir", dir))
-		err := writeLocalConfig(log, dir, ip)
+		err := writeLocalConfig(log, dir, ip, acmeURL, acmeCACert)
 		if err != nil {
 			log.Fatalx("creating mox localserve config", err, slog.String("dir", dir))
 		}
@@ -107,7 +118,7 @@ during those commands instead of during "data".
 	// Load config, creating a new one if needed.
 	var existingConfig bool
 	if _, err := os.Stat(dir); err != nil && os.IsNotExist(err) {
-		err := writeLocalConfig(log, dir, ip)
+		err := writeLocalConfig(log, dir, ip, acmeURL, acmeCACert)
 		if err != nil {
 			log.Fatalx("creating mox localserve config", err, slog.String("dir", dir))
 		}
@@ -115,8 +126,8 @@ during those commands instead of during "data".
 		log.Fatalx("stat config dir", err, slog.String("dir", dir))
 	} else if err := localLoadConfig(log, dir); err != nil {
 		log.Fatalx("loading mox localserve config (hint: when creating a new config with -dir, the directory must not yet exist)", err, slog.String("dir", dir))
-	} else if ip != "" {
-		log.Fatal("can only use -ip when writing a new config file")
+	} else if ip != "" || acmeURL != "" {
+		log.Fatal("can only use -ip and -acme when writing a new config file")
 	} else {
 		existingConfig = true
 	}
@@ -167,7 +178,11 @@ during those commands instead of during "data".
 	golog.Printf("the default user is mox@localhost, with password moxmoxmox")
 	golog.Printf("the default admin password is moxadmin")
 	golog.Printf("port numbers are those common for the services + 1000")
-	golog.Printf("tls uses generated self-signed certificate %s", filepath.Join(dir, "localhost.crt"))
+	if tlsConf := mox.Conf.Static.Listeners["local"].TLS; tlsConf != nil && tlsConf.ACME != "" {
+		golog.Printf("tls uses certificates from acme directory %s, for host name mox.localhost", mox.Conf.Static.ACME[tlsConf.ACME].DirectoryURL)
+	} else {
+		golog.Printf("tls uses generated self-signed certificate %s", filepath.Join(dir, "localhost.crt"))
+	}
 	golog.Printf("all incoming email to any address is accepted (if checks pass), unless the recipient localpart ends with:")
 	golog.Print("")
 	golog.Printf(`- "temperror": fail with a temporary error code.`)
@@ -232,7 +247,7 @@ during those commands instead of during "data".
 	}
 }
 
-func writeLocalConfig(log mlog.Log, dir, ip string) (rerr error) {
+func writeLocalConfig(log mlog.Log, dir, ip, acmeURL, acmeCACert string) (rerr error) {
 	defer func() {
 		x := recover()
 		if x != nil {
@@ -365,6 +380,24 @@ func writeLocalConfig(log mlog.Log, dir, ip string) (rerr error) {
 	local.WebserverHTTPS.Enabled = true
 	local.WebserverHTTPS.Port = 1443
 
+	var acmes map[string]config.ACME
+	if acmeURL != "" {
+		// Names without dot, like localhost, are not used for ACME.
+		local.Hostname = "mox.localhost"
+		local.TLS = &config.TLS{ACME: "localserve"}
+
+		acme := config.ACME{
+			DirectoryURL: acmeURL,
+			ContactEmail: "mox@localhost",
+			Port:         1443,
+		}
+		if acmeCACert != "" {
+			acme.CACertFile, err = filepath.Abs(acmeCACert)
+			xcheck(err, "absolute path for acme ca certificate")
+		}
+		acmes = map[string]config.ACME{"localserve": acme}
+	}
+
 	uid := os.Getuid()
 	if uid < 0 {
 		uid = 1 // For windows.
@@ -379,6 +412,7 @@ func writeLocalConfig(log mlog.Log, dir, ip string) (rerr error) {
 		Listeners: map[string]config.Listener{
 			"local": local,
 		},
+		ACME: acmes,
 	}
 	tlsca := struct {
 		AdditionalToSystem bool     `sconf:"optional"`
@@ -29,6 +29,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
 	"runtime"
@@ -48,6 +49,8 @@ import (
 	"github.com/mjl-/sherpa"
 
 	"github.com/mjl-/mox/admin"
+	"github.com/mjl-/mox/backupdest"
+	"github.com/mjl-/mox/bimi"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dane"
 	"github.com/mjl-/mox/dkim"
@@ -71,14 +74,17 @@ import (
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/tlsrpt"
 	"github.com/mjl-/mox/tlsrptdb"
+	"github.com/mjl-/mox/totp"
 	"github.com/mjl-/mox/updates"
 	"github.com/mjl-/mox/webadmin"
 	"github.com/mjl-/mox/webapi"
+	"github.com/mjl-/mox/webauth"
 )
 
 var (
 	changelogDomain = "xmox.nl"
 	changelogURL    = "https://updates.xmox.nl/changelog"
+	binaryURL       = "https://updates.xmox.nl/binary"
 	changelogPubKey = base64Decode("sPNiTDQzvb4FrytNEiebJhgyQzn57RwEjNbGWMM/bDY=")
 )
 
@@ -105,8 +111,21 @@ var commands = []struct {
 	{"serve", cmdServe},
 	{"quickstart", cmdQuickstart},
 	{"stop", cmdStop},
+	{"drain", cmdDrain},
 	{"setaccountpassword", cmdSetaccountpassword},
 	{"setadminpassword", cmdSetadminpassword},
+	{"setadmintotp", cmdSetadmintotp},
+	{"tenant setadminpassword", cmdTenantSetadminpassword},
+	{"admin webauthn list", cmdAdminWebauthnList},
+	{"admin webauthn reset", cmdAdminWebauthnReset},
+	{"authlockout list", cmdAuthLockoutList},
+	{"authlockout clear", cmdAuthLockoutClear},
+	{"capture start", cmdCaptureStart},
+	{"capture list", cmdCaptureList},
+	{"capture stop", cmdCaptureStop},
+	{"list subscribers", cmdListSubscribers},
+	{"list subscribe", cmdListSubscribe},
+	{"list unsubscribe", cmdListUnsubscribe},
 	{"loglevels", cmdLoglevels},
 	{"queue holdrules list", cmdQueueHoldrulesList},
 	{"queue holdrules add", cmdQueueHoldrulesAdd},
@@ -117,6 +136,7 @@ var commands = []struct {
 	{"queue schedule", cmdQueueSchedule},
 	{"queue transport", cmdQueueTransport},
 	{"queue requiretls", cmdQueueRequireTLS},
+	{"queue requireverifiedtls", cmdQueueRequireVerifiedTLS},
 	{"queue fail", cmdQueueFail},
 	{"queue drop", cmdQueueDrop},
 	{"queue dump", cmdQueueDump},
@@ -136,10 +156,12 @@ var commands = []struct {
 	{"import mbox", cmdImportMbox},
 	{"export maildir", cmdExportMaildir},
 	{"export mbox", cmdExportMbox},
+	{"export imap", cmdExportIMAP},
 	{"localserve", cmdLocalserve},
 	{"help", cmdHelp},
 	{"backup", cmdBackup},
 	{"verifydata", cmdVerifydata},
+	{"backupmerge", cmdBackupmerge},
 	{"licenses", cmdLicenses},
 
 	{"config test", cmdConfigTest},
@@ -158,6 +180,9 @@ var commands = []struct {
 	{"config domain rm", cmdConfigDomainRemove},
 	{"config domain disable", cmdConfigDomainDisable},
 	{"config domain enable", cmdConfigDomainEnable},
+	{"config domain retire", cmdConfigDomainRetire},
+	{"config domain unretire", cmdConfigDomainUnretire},
+	{"config domain export", cmdConfigDomainExport},
 	{"config tlspubkey list", cmdConfigTlspubkeyList},
 	{"config tlspubkey get", cmdConfigTlspubkeyGet},
 	{"config tlspubkey add", cmdConfigTlspubkeyAdd},
@@ -173,12 +198,15 @@ var commands = []struct {
 
 	{"config describe-sendmail", cmdConfigDescribeSendmail},
 	{"config printservice", cmdConfigPrintservice},
+	{"config webserver-snippets", cmdConfigWebserverSnippets},
 	{"config ensureacmehostprivatekeys", cmdConfigEnsureACMEHostprivatekeys},
 	{"config example", cmdConfigExample},
 
 	{"admin imapserve", cmdIMAPServe},
 
+	{"bimi lookup", cmdBIMILookup},
 	{"checkupdate", cmdCheckupdate},
+	{"update", cmdUpdate},
 	{"cid", cmdCid},
 	{"clientconfig", cmdClientConfig},
 	{"deliver", cmdDeliver},
@@ -199,6 +227,9 @@ var commands = []struct {
 	{"dmarc checkreportaddrs", cmdDMARCCheckreportaddrs},
 	{"dnsbl check", cmdDNSBLCheck},
 	{"dnsbl checkhealth", cmdDNSBLCheckhealth},
+	{"doctor", cmdDoctor},
+	{"genapi", cmdGenapi},
+	{"junk explain", cmdJunkExplain},
 	{"mtasts lookup", cmdMTASTSLookup},
 	{"rdap domainage", cmdRDAPDomainage},
 	{"retrain", cmdRetrain},
@@ -217,6 +248,7 @@ var commands = []struct {
 	{"reassignuids", cmdReassignUIDs},
 	{"fixuidmeta", cmdFixUIDMeta},
 	{"fixmsgsize", cmdFixmsgsize},
+	{"compressmessages", cmdCompressmessages},
 	{"reparse", cmdReparse},
 	{"ensureparsed", cmdEnsureParsed},
 	{"recalculatemailboxcounts", cmdRecalculateMailboxCounts},
@@ -693,7 +725,7 @@ date version.
 }
 
 func cmdConfigDomainAdd(c *cmd) {
-	c.params = "[-disabled] domain account [localpart]"
+	c.params = "[-disabled] [-aliasof domain] domain account [localpart]"
 	c.help = `Adds a new domain to the configuration and reloads the configuration.
 
 The account is used for the postmaster mailboxes the domain, including as DMARC and
@@ -703,15 +735,26 @@ must be set if and only if account does not yet exist.
 The domain can be created in disabled mode, preventing automatically requesting
 TLS certificates with ACME, and rejecting incoming/outgoing messages involving
 the domain, but allowing further configuration of the domain.
+
+With -aliasof, the new domain is an alias of an existing domain: all addresses
+of the existing domain are also valid at the new domain, for delivery, login and
+sending. The account must already exist. The new domain gets its own DKIM keys
+and needs its own DNS records.
 `
 	var disabled bool
+	var aliasOf string
 	c.flag.BoolVar(&disabled, "disabled", false, "disable the new domain")
+	c.flag.StringVar(&aliasOf, "aliasof", "", "make the new domain an alias of this existing domain")
 	args := c.Parse()
 	if len(args) != 2 && len(args) != 3 {
 		c.Usage()
 	}
 
 	d := xparseDomain(args[0], "domain")
+	var aliasOfDomain dns.Domain
+	if aliasOf != "" {
+		aliasOfDomain = xparseDomain(aliasOf, "alias of domain")
+	}
 	mustLoadConfig()
 	var localpart smtp.Localpart
 	if len(args) == 3 {
@@ -719,10 +762,10 @@ the domain, but allowing further configuration of the domain.
 		localpart, err = smtp.ParseLocalpart(args[2])
 		xcheckf(err, "parsing localpart")
 	}
-	ctlcmdConfigDomainAdd(xctl(), disabled, d, args[1], localpart)
+	ctlcmdConfigDomainAdd(xctl(), disabled, d, args[1], localpart, aliasOfDomain)
 }
 
-func ctlcmdConfigDomainAdd(ctl *ctl, disabled bool, domain dns.Domain, account string, localpart smtp.Localpart) {
+func ctlcmdConfigDomainAdd(ctl *ctl, disabled bool, domain dns.Domain, account string, localpart smtp.Localpart, aliasOf dns.Domain) {
 	ctl.xwrite("domainadd")
 	if disabled {
 		ctl.xwrite("true")
@@ -732,6 +775,7 @@ func ctlcmdConfigDomainAdd(ctl *ctl, disabled bool, domain dns.Domain, account s
 	ctl.xwrite(domain.Name())
 	ctl.xwrite(account)
 	ctl.xwrite(string(localpart))
+	ctl.xwrite(aliasOf.Name()) // Empty for zero domain.
 	ctl.xreadok()
 	fmt.Printf("domain added, remember to add dns records, see:\n\nmox config dnsrecords %s\nmox config dnscheck %s\n", domain.Name(), domain.Name())
 }
@@ -805,6 +849,95 @@ func ctlcmdConfigDomainDisabled(ctl *ctl, d dns.Domain, disabled bool) {
 	ctl.xreadok()
 }
 
+func cmdConfigDomainRetire(c *cmd) {
+	c.params = "[-newdomain domain] [-message text] [-rejectafter date] [-loginuntil date] domain"
+	c.help = `Start decommissioning a domain and reload the configuration.
+
+A gradual alternative to "mox config domain rm". Until the date of
+-rejectafter, incoming messages for the domain are delivered as usual, and
+senders get an automatic reply, at most once per week per sender, that the
+address will stop accepting messages. From that date, incoming messages are
+rejected with a permanent error. If -newdomain is set, the error is a 551 that
+refers to the same localpart at the new domain, otherwise a 550. The text for
+rejections and automatic replies can be set with -message, where
+"{newaddress}" is replaced with the new address.
+
+Accounts with the domain as their default domain can still log in, e.g. to
+fetch their messages over IMAP, until the date of -loginuntil. After, logins
+are disabled for those accounts. Without -loginuntil, logins are not disabled.
+
+Dates are of the form 2006-01-02, or RFC3339 timestamps. A bundle with the
+configuration and messages of the accounts of the domain can be made with
+"mox config domain export", e.g. before removing the domain.
+`
+	var retire config.DomainRetire
+	c.flag.StringVar(&retire.NewDomain, "newdomain", "", "domain addresses have moved to")
+	c.flag.StringVar(&retire.Message, "message", "", "text for rejections and automatic replies instead of default")
+	c.flag.StringVar(&retire.RejectAfter, "rejectafter", "", "date from which messages are rejected, with automatic replies until then; default is to reject immediately")
+	c.flag.StringVar(&retire.LoginUntil, "loginuntil", "", "date from which logins of accounts of the domain are disabled")
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+
+	d := xparseDomain(args[0], "domain")
+	mustLoadConfig()
+	ctlcmdConfigDomainRetire(xctl(), d, &retire)
+	fmt.Printf("domain retired\n")
+}
+
+func cmdConfigDomainUnretire(c *cmd) {
+	c.params = "domain"
+	c.help = `Stop decommissioning a domain and reload the configuration.
+
+Incoming messages are accepted again. Logins of accounts that were disabled
+after the grace period of the retired domain are not enabled again.
+`
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+
+	d := xparseDomain(args[0], "domain")
+	mustLoadConfig()
+	ctlcmdConfigDomainRetire(xctl(), d, nil)
+}
+
+func ctlcmdConfigDomainRetire(ctl *ctl, d dns.Domain, retire *config.DomainRetire) {
+	buf, err := json.Marshal(retire)
+	xcheckf(err, "marshal retire settings")
+	ctl.xwrite("domainretire")
+	ctl.xwrite(d.Name())
+	ctl.xwrite(string(buf))
+	ctl.xreadok()
+}
+
+func cmdConfigDomainExport(c *cmd) {
+	c.params = "domain >export.tgz"
+	c.help = `Export a bundle with the configuration and messages for a domain.
+
+The gzipped tar file written to stdout contains a file "domains.conf" with the
+configuration of the domain and of the accounts with the domain as their
+default domain, and the messages of those accounts in maildir format, in
+directory "accounts/<name>/". Useful before removing a retired domain.
+`
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+
+	d := xparseDomain(args[0], "domain")
+	mustLoadConfig()
+	ctlcmdConfigDomainExport(xctl(), d, os.Stdout)
+}
+
+func ctlcmdConfigDomainExport(ctl *ctl, d dns.Domain, w io.Writer) {
+	ctl.xwrite("domainexport")
+	ctl.xwrite(d.Name())
+	ctl.xreadok()
+	ctl.xstreamto(w)
+}
+
 func cmdConfigAliasList(c *cmd) {
 	c.params = "domain"
 	c.help = `Show aliases (lists) for domain.`
@@ -1336,7 +1469,11 @@ configured.
 	var certIssuerDomainName, acmeAccountURI string
 	public := mox.Conf.Static.Listeners["public"]
 	if public.TLS != nil && public.TLS.ACME != "" {
-		acme, ok := mox.Conf.Static.ACME[public.TLS.ACME]
+		acmeName := public.TLS.ACME
+		if domConf.ACME != "" {
+			acmeName = domConf.ACME
+		}
+		acme, ok := mox.Conf.Static.ACME[acmeName]
 		if ok && acme.Manager.Manager.Client != nil {
 			certIssuerDomainName = acme.IssuerDomainName
 			acc, err := acme.Manager.Manager.Client.GetReg(context.Background(), "")
@@ -1347,14 +1484,53 @@ configured.
 		}
 	}
 
-	records, err := admin.DomainRecords(domConf, d, result.Authentic, certIssuerDomainName, acmeAccountURI)
+	// OpenPGP keys are in the database, which we can only get through mox, if it is
+	// running.
+	var openpgpKeys []store.OpenPGPKey
+	if conn, err := net.Dial("unix", mox.DataDirPath("ctl")); err == nil {
+		conn.Close()
+		openpgpKeys = ctlcmdOpenPGPKeyList(xctl(), d)
+	}
+
+	records, err := admin.DomainRecords(domConf, d, result.Authentic, certIssuerDomainName, acmeAccountURI, openpgpKeys)
 	xcheckf(err, "records")
 	fmt.Print(strings.Join(records, "\n") + "\n")
 }
 
+func ctlcmdOpenPGPKeyList(ctl *ctl, domain dns.Domain) []store.OpenPGPKey {
+	ctl.xwrite("openpgpkeylist")
+	ctl.xwrite(domain.Name())
+	ctl.xreadok()
+	var b bytes.Buffer
+	ctl.xstreamto(&b)
+	keys := []store.OpenPGPKey{}
+	for line := range strings.Lines(b.String()) {
+		t := strings.Split(strings.TrimSuffix(line, "\n"), "\t")
+		if len(t) != 4 {
+			log.Fatalf("bad line from mox: %q", line)
+		}
+		buf, err := base64.StdEncoding.DecodeString(t[3])
+		xcheckf(err, "decoding openpgp key")
+		keys = append(keys, store.OpenPGPKey{Address: t[0], Account: t[1], Fingerprint: t[2], KeyData: buf})
+	}
+	return keys
+}
+
 func cmdConfigDNSCheck(c *cmd) {
-	c.params = "domain"
-	c.help = "Check the DNS records with the configuration for the domain, and print any errors/warnings."
+	c.params = "[-zone file] domain"
+	c.help = `Check the DNS records with the configuration for the domain, and print any errors/warnings.
+
+With -zone, the checks are done as if the DNS records from the file were
+published, so proposed DNS changes can be validated before publishing them.
+The file is in zone file format, e.g. as printed by "mox config dnsrecords",
+with names relative to the domain. Use "-" to read the records from stdin.
+Records in the file replace all published records of the same name and type.
+Records of types A, AAAA, MX, TXT, CNAME and TLSA are evaluated, other records
+are ignored. Records that are looked up but not in the file are looked up in
+DNS.
+`
+	var zonefile string
+	c.flag.StringVar(&zonefile, "zone", "", "file with proposed dns records in zone file format")
 	args := c.Parse()
 	if len(args) != 1 {
 		c.Usage()
@@ -1367,6 +1543,23 @@ func cmdConfigDNSCheck(c *cmd) {
 		log.Fatalf("unknown domain")
 	}
 
+	var resolver dns.Resolver = dns.StrictResolver{Pkg: "check"}
+	if zonefile != "" {
+		var r io.Reader = os.Stdin
+		if zonefile != "-" {
+			f, err := os.Open(zonefile)
+			xcheckf(err, "open zone file")
+			defer f.Close()
+			r = f
+		}
+		records, ignored, err := dns.ParseZone(r, d)
+		xcheckf(err, "parsing zone file")
+		for _, s := range ignored {
+			fmt.Printf("ignoring record %s\n", s)
+		}
+		resolver = dns.OverlayResolver{Resolver: resolver, Records: records}
+	}
+
 	// todo future: move http.Admin.CheckDomain to mox- and make it return a regular error.
 	defer func() {
 		x := recover()
@@ -1393,7 +1586,7 @@ func cmdConfigDNSCheck(c *cmd) {
 		}
 	}
 
-	result := webadmin.Admin{}.CheckDomain(context.Background(), args[0])
+	result := webadmin.CheckDomainResolver(context.Background(), resolver, args[0])
 	printResult("DNSSEC", result.DNSSEC.Result)
 	printResult("IPRev", result.IPRev.Result)
 	printResult("MX", result.MX.Result)
@@ -1405,6 +1598,7 @@ func cmdConfigDNSCheck(c *cmd) {
 	printResult("Host TLSRPT", result.HostTLSRPT.Result)
 	printResult("Domain TLSRPT", result.DomainTLSRPT.Result)
 	printResult("MTASTS", result.MTASTS.Result)
+	printResult("BIMI", result.BIMI.Result)
 	printResult("SRV conf", result.SRVConf.Result)
 	printResult("Autoconf", result.Autoconf.Result)
 	printResult("Autodiscover", result.Autodiscover.Result)
@@ -1678,6 +1872,9 @@ indicating temporary unavailability. Existing connections will get a 3 second
 period to finish their transaction and shut down. Under normal circumstances,
 only IMAP has long-living connections, with the IDLE command to get notified of
 new mail deliveries.
+
+For planned maintenance, "mox drain" first lets SMTP transactions and queue
+deliveries complete before shutting down.
 `
 	if len(c.Parse()) != 0 {
 		c.Usage()
@@ -1697,6 +1894,48 @@ new mail deliveries.
 	fmt.Println("mox stopped")
 }
 
+func cmdDrain(c *cmd) {
+	c.params = "[-deadline duration]"
+	c.help = `Drain mox for planned maintenance, then shut it down.
+
+New incoming SMTP connections, and new transactions on existing connections, are
+refused with a temporary error (421), so remote servers retry later or at
+another MX host. Transactions in progress are completed. All messages in the
+outgoing queue that are not on hold are scheduled for immediate delivery.
+
+When no SMTP transactions and queue deliveries are in progress anymore, and no
+queued messages are due for delivery, or when the deadline has passed, mox shuts
+down like with "mox stop". Messages that could not be delivered remain in the
+queue, and delivery is retried after starting mox again.
+`
+	var deadline time.Duration
+	c.flag.DurationVar(&deadline, "deadline", 5*time.Minute, "maximum time to wait for transactions and deliveries before shutting down")
+	if len(c.Parse()) != 0 {
+		c.Usage()
+	}
+	mustLoadConfig()
+
+	xctl := xctl()
+	xctl.xwrite("drain")
+	xctl.xwrite(deadline.String())
+	for {
+		line := xctl.xread()
+		if line == "ok" {
+			break
+		}
+		fmt.Println(line)
+	}
+	// Read will hang until remote has shut down.
+	buf := make([]byte, 128)
+	n, err := xctl.conn.Read(buf)
+	if err == nil {
+		log.Fatalf("expected eof after graceful shutdown, got data %q", buf[:n])
+	} else if err != io.EOF {
+		log.Fatalf("expected eof after graceful shutdown, got error %v", err)
+	}
+	fmt.Println("mox stopped")
+}
+
 func cmdBackup(c *cmd) {
 	c.params = "destdir"
 	c.help = `Creates a backup of the config and data directory.
@@ -1723,10 +1962,55 @@ are stored, but with a warning.
 Remove files in the destination directory before doing another backup. The
 backup command will not overwrite files, but print and return errors.
 
+A file backup.json is written to destdir, with the modification sequence of
+each account at the time of the backup. With the -incremental flag and the
+directory of a previous backup, an incremental backup is made: account
+databases are not copied, instead a journal with changes to an account since
+the previous backup is written to "data/accounts/<account>/journal.jsonl". A
+change of message flags only adds a small record to the journal. Only message
+files added since the previous backup are hardlinked/copied. Other databases
+and files are copied in full. An incremental backup cannot be restored
+directly, it must first be merged with the previous backup with "mox
+backupmerge", resulting in a full backup.
+
+With the -linkdest flag and the directory of a previous (full) backup, a full
+backup is made, but message files and database files that are unchanged since
+that previous backup are hardlinked to the files in the previous backup instead
+of copied. Useful for frequent backups to a file system other than that of the
+data directory, where message files cannot be hardlinked to the data directory.
+Message files are recognized as unchanged by their size and modification time,
+which are kept when copying. Databases are recognized as unchanged by their
+modification time, which is recorded in backup.json. Such backups can be restored
+directly. Previous backups can be removed independently, but keep in mind that
+changing a hardlinked file (e.g. with "mox verifydata -fix") also changes it in
+other backups.
+
+The destination can also be a remote location, where files are written to
+directly, without needing local space for staging. Incremental backups and
+-linkdest are not supported for remote destinations. Use a new destination for
+each backup. Supported remote destinations:
+
+- s3://<bucket>/<prefix>, for S3-compatible object storage. Credentials are
+  read from environment variables AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY,
+  and optional AWS_SESSION_TOKEN. The region is read from AWS_REGION (default
+  us-east-1). For storage other than AWS, set AWS_ENDPOINT_URL to an http or
+  https URL, e.g. https://minio.example:9000, for which path-style requests are
+  made. Existing objects are overwritten.
+- sftp://<user>@<host>[:<port>]/<dir>, for SFTP over SSH. Authentication is with
+  the private key from the file in $MOX_BACKUP_SSH_KEY, or ~/.ssh/id_ed25519,
+  id_ecdsa or id_rsa. The key must not be encrypted with a passphrase. The host
+  key of the server must be in the file in $MOX_BACKUP_SSH_KNOWN_HOSTS, or
+  ~/.ssh/known_hosts. Existing files are not overwritten.
+
+The rsync protocol is not supported, use sftp instead.
+
 Exit code 0 indicates the backup was successful. A clean successful backup does
 not print any output, but may print warnings. Use the -verbose flag for
 details, including timing.
 
+Backups can also be made by "mox serve" on a schedule, with retention of old
+backups, see BackupSchedule in mox.conf.
+
 To restore a backup, first shut down mox, move away the old data directory and
 move an earlier backed up directory in its place, run "mox verifydata
 <datadir>", possibly with the "-fix" option, and restart mox. After the
@@ -1742,20 +2026,79 @@ upgrading.
 `
 
 	var verbose bool
+	var incremental, linkDest string
 	c.flag.BoolVar(&verbose, "verbose", false, "print progress")
+	c.flag.StringVar(&incremental, "incremental", "", "directory of previous backup to make an incremental backup against")
+	c.flag.StringVar(&linkDest, "linkdest", "", "directory of previous backup to hardlink unchanged message and database files to")
 	args := c.Parse()
-	if len(args) != 1 {
+	if len(args) != 1 || incremental != "" && linkDest != "" {
 		c.Usage()
 	}
 	mustLoadConfig()
 
+	if backupdest.IsRemote(args[0]) {
+		if incremental != "" || linkDest != "" {
+			log.Fatalf("-incremental and -linkdest not supported for remote destinations")
+		}
+		config := backupRemoteConfig(args[0])
+		ctlcmdBackup(xctl(), args[0], verbose, "", "", &config)
+		return
+	}
+
 	dstDataDir, err := filepath.Abs(args[0])
 	xcheckf(err, "making path absolute")
-
-	ctlcmdBackup(xctl(), dstDataDir, verbose)
+	if incremental != "" {
+		incremental, err = filepath.Abs(incremental)
+		xcheckf(err, "making path absolute")
+	}
+	if linkDest != "" {
+		linkDest, err = filepath.Abs(linkDest)
+		xcheckf(err, "making path absolute")
+	}
+
+	ctlcmdBackup(xctl(), dstDataDir, verbose, incremental, linkDest, nil)
+}
+
+// backupRemoteConfig returns the settings and credentials for a remote backup
+// destination, from the environment of the user running the backup command.
+func backupRemoteConfig(dst string) backupdest.Config {
+	var config backupdest.Config
+	switch {
+	case strings.HasPrefix(dst, "s3://"):
+		config.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		config.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		config.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+		config.Region = os.Getenv("AWS_REGION")
+		config.Endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	case strings.HasPrefix(dst, "sftp://"):
+		home, err := os.UserHomeDir()
+		xcheckf(err, "finding home directory")
+		keyFile := os.Getenv("MOX_BACKUP_SSH_KEY")
+		if keyFile == "" {
+			for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+				p := filepath.Join(home, ".ssh", name)
+				if _, err := os.Stat(p); err == nil {
+					keyFile = p
+					break
+				}
+			}
+		}
+		if keyFile == "" {
+			log.Fatalf("no ssh private key found, set $MOX_BACKUP_SSH_KEY")
+		}
+		config.PrivateKey, err = os.ReadFile(keyFile)
+		xcheckf(err, "reading ssh private key")
+		knownHostsFile := os.Getenv("MOX_BACKUP_SSH_KNOWN_HOSTS")
+		if knownHostsFile == "" {
+			knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		config.KnownHosts, err = os.ReadFile(knownHostsFile)
+		xcheckf(err, "reading ssh known hosts")
+	}
+	return config
 }
 
-func ctlcmdBackup(ctl *ctl, dstDataDir string, verbose bool) {
+func ctlcmdBackup(ctl *ctl, dstDataDir string, verbose bool, prevDir, linkDir string, remoteConfig *backupdest.Config) {
 	ctl.xwrite("backup")
 	ctl.xwrite(dstDataDir)
 	if verbose {
@@ -1763,6 +2106,15 @@ func ctlcmdBackup(ctl *ctl, dstDataDir string, verbose bool) {
 	} else {
 		ctl.xwrite("")
 	}
+	ctl.xwrite(prevDir)
+	ctl.xwrite(linkDir)
+	if remoteConfig != nil {
+		buf, err := json.Marshal(remoteConfig)
+		xcheckf(err, "marshal remote destination config")
+		ctl.xwrite(string(buf))
+	} else {
+		ctl.xwrite("")
+	}
 	ctl.xstreamto(os.Stdout)
 	ctl.xreadok()
 }
@@ -1771,7 +2123,8 @@ func cmdSetadminpassword(c *cmd) {
 	c.help = `Set a new admin password, for the web interface.
 
 The password is read from stdin. Its bcrypt hash is stored in a file named
-"adminpasswd" in the configuration directory.
+"adminpasswd" in the configuration directory. Existing admin sessions are no
+longer valid after the password change.
 `
 	if len(c.Parse()) != 0 {
 		c.Usage()
@@ -1781,6 +2134,8 @@ The password is read from stdin. Its bcrypt hash is stored in a file named
 	path := mox.ConfigDirPath(mox.Conf.Static.AdminPasswordFile)
 	if path == "" {
 		log.Fatal("no admin password file configured")
+	} else if mox.IsSecretRef(mox.Conf.Static.AdminPasswordFile) {
+		log.Fatal("admin password file is a secret reference, change the referenced value instead")
 	}
 
 	pw := xreadpassword()
@@ -1792,6 +2147,315 @@ The password is read from stdin. Its bcrypt hash is stored in a file named
 	xcheckf(err, "writing hash to admin password file")
 }
 
+func cmdTenantSetadminpassword(c *cmd) {
+	c.params = "tenant"
+	c.help = `Set a new admin password for a tenant, for the web interface.
+
+Tenant admins log in to the admin web interface with the tenant name as
+username, and can only manage the domains and accounts of the tenant. Tenants
+are configured in domains.conf.
+
+The password is read from stdin. Its bcrypt hash is stored in a file named
+"adminpasswd-<tenant>" in the configuration directory. Existing sessions of the
+tenant admin are no longer valid after the password change.
+`
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+	mustLoadConfig()
+
+	if _, ok := mox.Conf.Tenant(args[0]); !ok {
+		log.Fatalf("tenant %q not found", args[0])
+	}
+
+	pw := xreadpassword()
+	pw, err := precis.OpaqueString.String(pw)
+	xcheckf(err, `checking password with "precis" requirements`)
+	hash, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+	xcheckf(err, "generating hash for password")
+	err = os.WriteFile(mox.TenantAdminPasswordFile(args[0]), hash, 0660)
+	xcheckf(err, "writing hash to tenant admin password file")
+}
+
+func cmdSetadmintotp(c *cmd) {
+	c.help = `Enable or disable two-factor authentication for the admin web interface.
+
+A new secret for time-based one-time passwords (TOTP) is generated and printed,
+to be added to an authenticator app. A code from the app is read from stdin to
+verify the app is set up correctly. Then the secret and hashes of newly
+generated recovery codes are written to the AdminTOTPFile in the configuration
+directory, and the recovery codes are printed. A recovery code can be used once
+instead of a TOTP code, for when the authenticator app is not available.
+
+With -disable, the TOTP file is removed, disabling two-factor authentication.
+`
+	var disable bool
+	c.flag.BoolVar(&disable, "disable", false, "disable two-factor authentication by removing the totp file")
+	if len(c.Parse()) != 0 {
+		c.Usage()
+	}
+	mustLoadConfig()
+
+	if mox.Conf.Static.AdminTOTPFile == "" {
+		log.Fatal("no admin totp file configured, set AdminTOTPFile in mox.conf")
+	}
+	path := mox.ConfigDirPath(mox.Conf.Static.AdminTOTPFile)
+
+	if disable {
+		err := os.Remove(path)
+		xcheckf(err, "removing admin totp file")
+		return
+	}
+
+	secret := totp.NewSecret()
+	fmt.Printf("Add the following secret to your authenticator app:\n\n\t%s\n\nOr the otpauth URI, e.g. by converting it to a QR code:\n\n\t%s\n\ncode: ", secret, totp.URI(mox.Conf.Static.HostnameDomain.Name(), "admin", secret))
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Scan()
+	xcheckf(scanner.Err(), "reading stdin")
+	if _, ok, err := totp.Verify(secret, strings.TrimSpace(scanner.Text()), time.Now(), 0); err != nil {
+		xcheckf(err, "verifying code")
+	} else if !ok {
+		log.Fatal("invalid code, check the authenticator app and the time on this machine")
+	}
+
+	codes := totp.NewRecoveryCodes(10)
+	t := webauth.AdminTOTP{Secret: secret}
+	for _, code := range codes {
+		t.RecoveryCodeHashes = append(t.RecoveryCodeHashes, totp.RecoveryCodeHash(code))
+	}
+	err := webauth.AdminTOTPWrite(path, t)
+	xcheckf(err, "writing admin totp file")
+	fmt.Printf("\nTwo-factor authentication enabled. Store these recovery codes in a safe place, each can be used once instead of a code:\n\n")
+	for _, code := range codes {
+		fmt.Printf("\t%s\n", code)
+	}
+}
+
+func cmdAdminWebauthnList(c *cmd) {
+	c.help = `List security keys and passkeys registered for logging in to the admin web interface.
+
+Credentials are registered in the admin web interface, for the host name the
+admin web interface was accessed at.
+`
+	if len(c.Parse()) != 0 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdAdminWebauthnList(xctl())
+}
+
+func ctlcmdAdminWebauthnList(ctl *ctl) {
+	ctl.xwrite("adminwebauthnlist")
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
+func cmdAdminWebauthnReset(c *cmd) {
+	c.help = `Remove all security keys and passkeys registered for the admin web interface.
+
+For when a security key is lost and you are locked out. Logging in with the admin
+password remains possible, and new credentials can be registered after logging
+in.
+`
+	if len(c.Parse()) != 0 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdAdminWebauthnReset(xctl())
+}
+
+func ctlcmdAdminWebauthnReset(ctl *ctl) {
+	ctl.xwrite("adminwebauthnreset")
+	ctl.xreadok()
+	fmt.Printf("removed %s credential(s)\n", ctl.xread())
+}
+
+func cmdAuthLockoutList(c *cmd) {
+	c.help = `List IPs and accounts with recent failed authentication attempts.
+
+Only tracked when AuthLockout is configured in mox.conf. IPs or accounts with a
+"locked until" time in the future cannot authenticate until that time. IPv6
+addresses are tracked per /64 network.
+`
+	if len(c.Parse()) != 0 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdAuthLockoutList(xctl())
+}
+
+func ctlcmdAuthLockoutList(ctl *ctl) {
+	ctl.xwrite("authlockoutlist")
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
+func cmdAuthLockoutClear(c *cmd) {
+	c.params = "[ip | account]"
+	c.help = `Clear failed authentication attempts and lockout for an IP or account.
+
+Without parameter, all failed attempts and lockouts are cleared.
+`
+	args := c.Parse()
+	if len(args) > 1 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	var ipOrAccount string
+	if len(args) == 1 {
+		ipOrAccount = args[0]
+	}
+	ctlcmdAuthLockoutClear(xctl(), ipOrAccount)
+}
+
+func ctlcmdAuthLockoutClear(ctl *ctl, ipOrAccount string) {
+	ctl.xwrite("authlockoutclear")
+	ctl.xwrite(ipOrAccount)
+	ctl.xreadok()
+	fmt.Printf("cleared %s record(s)\n", ctl.xread())
+}
+
+func cmdCaptureStart(c *cmd) {
+	c.params = "[-duration duration] [-conns n] [-protocols smtp,submission,imap] [-data] ip | account"
+	c.help = `Start capturing raw SMTP/IMAP protocol traffic for an IP or account.
+
+For debugging interoperability problems with a specific client or server.
+Protocol data of new connections from the IP, or of connections authenticating
+as the account, is written to a file in data/captures/, with timestamps and
+connection IDs. Capturing stops after the duration, after the maximum number of
+connections has been captured, or when the file reaches 10MB.
+
+Credentials in authentication exchanges are redacted. Message data is not
+recorded, only its size, unless -data is specified.
+
+For accounts, capturing starts after authentication. Captures are kept in memory
+only, they do not persist across restarts.
+`
+	var duration time.Duration
+	var conns int
+	var protocols string
+	var data bool
+	c.flag.DurationVar(&duration, "duration", time.Minute, "stop capturing after duration")
+	c.flag.IntVar(&conns, "conns", 10, "stop capturing after number of connections")
+	c.flag.StringVar(&protocols, "protocols", "", "comma-separated protocols to capture, default all: smtp, submission, imap")
+	c.flag.BoolVar(&data, "data", false, "record message data, which may contain sensitive information")
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdCaptureStart(xctl(), args[0], protocols, data, duration, conns)
+}
+
+func ctlcmdCaptureStart(ctl *ctl, ipOrAccount, protocols string, data bool, duration time.Duration, conns int) {
+	ctl.xwrite("capturestart")
+	ctl.xwrite(ipOrAccount)
+	ctl.xwrite(protocols)
+	ctl.xwrite(fmt.Sprintf("%v", data))
+	ctl.xwrite(duration.String())
+	ctl.xwrite(fmt.Sprintf("%d", conns))
+	ctl.xreadok()
+	fmt.Printf("capturing to %s\n", ctl.xread())
+}
+
+func cmdCaptureList(c *cmd) {
+	c.help = `List active protocol captures.`
+	if len(c.Parse()) != 0 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdCaptureList(xctl())
+}
+
+func ctlcmdCaptureList(ctl *ctl) {
+	ctl.xwrite("capturelist")
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
+func cmdCaptureStop(c *cmd) {
+	c.params = "[id]"
+	c.help = `Stop an active protocol capture.
+
+Without id, all active captures are stopped.
+`
+	args := c.Parse()
+	if len(args) > 1 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	var id int64
+	if len(args) == 1 {
+		var err error
+		id, err = strconv.ParseInt(args[0], 10, 64)
+		xcheckf(err, "parsing id")
+	}
+	ctlcmdCaptureStop(xctl(), id)
+}
+
+func ctlcmdCaptureStop(ctl *ctl, id int64) {
+	ctl.xwrite("capturestop")
+	ctl.xwrite(fmt.Sprintf("%d", id))
+	ctl.xreadok()
+	fmt.Printf("stopped %s capture(s)\n", ctl.xread())
+}
+
+func cmdListSubscribers(c *cmd) {
+	c.params = "list@domain"
+	c.help = `List subscribers of a mailing list.
+
+Mailing lists are configured in the domain configuration, see the Lists field in
+domains.conf. Prints the address and subscription time of each subscriber.
+`
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdListSubscribers(xctl(), args[0])
+}
+
+func ctlcmdListSubscribers(ctl *ctl, list string) {
+	ctl.xwrite("listsubscribers")
+	ctl.xwrite(list)
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
+func cmdListSubscribe(c *cmd) {
+	c.params = "list@domain address"
+	c.help = `Subscribe an address to a mailing list, without confirmation.
+
+Subscribers can also subscribe themselves by sending a message with subject
+"subscribe" to the request address of the list, e.g. list-request@domain.
+`
+	args := c.Parse()
+	if len(args) != 2 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdListSubscribe(xctl(), "listsubscribe", args[0], args[1])
+}
+
+func cmdListUnsubscribe(c *cmd) {
+	c.params = "list@domain address"
+	c.help = `Unsubscribe an address from a mailing list.`
+	args := c.Parse()
+	if len(args) != 2 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdListSubscribe(xctl(), "listunsubscribe", args[0], args[1])
+}
+
+func ctlcmdListSubscribe(ctl *ctl, cmd, list, address string) {
+	ctl.xwrite(cmd)
+	ctl.xwrite(list)
+	ctl.xwrite(address)
+	ctl.xreadok()
+}
+
 func xreadpassword() string {
 	fmt.Printf(`
 Type new password. Password WILL echo.
@@ -2860,6 +3524,38 @@ func cmdDMARCLookup(c *cmd) {
 	fmt.Printf("(%s)\n", dnssecStatus(authentic))
 }
 
+func cmdBIMILookup(c *cmd) {
+	c.params = "[-selector selector] domain"
+	c.help = `Lookup BIMI record for domain, a DNS TXT record at <selector>._bimi.<domain>, validate and print it.
+
+If no record exists at the domain, the organizational domain is checked. The
+DMARC policy of the domain is checked too: BIMI logos are only shown for
+messages from domains with an enforcing DMARC policy.
+`
+	var selector string
+	c.flag.StringVar(&selector, "selector", bimi.DefaultSelector, "selector of bimi record")
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+
+	fromdomain := xparseDomain(args[0], "domain")
+	domain, record, txt, authentic, err := bimi.Lookup(context.Background(), c.log.Logger, dns.StrictResolver{}, selector, fromdomain)
+	xcheckf(err, "bimi lookup domain %s", fromdomain)
+	fmt.Printf("bimi record at domain %s: %s\n", domain, txt)
+	fmt.Printf("(%s)\n", dnssecStatus(authentic))
+	if record.Location == "" {
+		fmt.Println("warning: record has no logo location, domain declines to publish a logo")
+	}
+
+	_, _, dmarcRecord, _, _, err := dmarc.Lookup(context.Background(), c.log.Logger, dns.StrictResolver{}, fromdomain)
+	if err != nil {
+		fmt.Printf("warning: looking up dmarc record: %v\n", err)
+	} else if dmarcRecord.Policy == dmarc.PolicyNone || dmarcRecord.Percentage < 100 {
+		fmt.Println("warning: dmarc policy is not enforcing for all messages, bimi logos will not be shown")
+	}
+}
+
 func dnssecStatus(v bool) string {
 	if v {
 		return "with dnssec"
@@ -3315,6 +4011,38 @@ func ctlcmdRetrain(ctl *ctl, account string) {
 	ctl.xreadok()
 }
 
+func cmdJunkExplain(c *cmd) {
+	c.params = "account msgid"
+	c.help = `Explain the junk classification of a message.
+
+Prints the spam probability, threshold and most significant ham and spam words
+of the junk filter classification that was done during delivery of the message,
+as also shown in the X-Mox-Reason header and in the webmail. If no
+classification was stored with the message, e.g. because the sender had a good
+reputation, the message is classified with the current junk filter.
+
+The message ID can be found in the webmail, e.g. in the URL when a message is
+opened.
+`
+	args := c.Parse()
+	if len(args) != 2 {
+		c.Usage()
+	}
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	xcheckf(err, "parsing message id")
+
+	mustLoadConfig()
+	ctlcmdJunkExplain(xctl(), args[0], id)
+}
+
+func ctlcmdJunkExplain(ctl *ctl, account string, id int64) {
+	ctl.xwrite("junkexplain")
+	ctl.xwrite(account)
+	ctl.xwrite(fmt.Sprintf("%d", id))
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
 func cmdTLSRPTDBAddReport(c *cmd) {
 	c.unlisted = true
 	c.params = "< message"
@@ -3380,6 +4108,10 @@ func cmdDNSBLCheckhealth(c *cmd) {
 
 The health of a DNS blocklist can be checked by querying for 127.0.0.1 and
 127.0.0.2. The second must and the first must not be present.
+
+A running mox periodically checks the health of all configured DNSBLs, and
+whether its IPs are listed, and exports the results as prometheus metrics
+mox_dnsbl_zone_healthy, mox_dnsbl_zone_ips_listed and mox_dnsbl_ips_success.
 `
 	args := c.Parse()
 	if len(args) != 1 {
@@ -3430,6 +4162,138 @@ printed.
 	}
 }
 
+func cmdUpdate(c *cmd) {
+	c.help = `Update the mox binary to the latest version.
+
+Like "mox checkupdate", the latest version is looked up in DNS and the signed
+changelog is fetched and printed. If a newer version is available for this
+platform, it is installed:
+
+1. The binary is downloaded, by default from https://updates.xmox.nl/binary. Its
+size and SHA-256 hash must match a description signed with the public key that
+is also used for the changelog, for the expected version and platform. Releases
+are reproducible builds, so the signed hash can be compared against your own
+build of the release.
+2. The binary is staged next to the current binary, with suffix ".new", and must
+report the expected version.
+3. A backup is made of the running mox instance (in the "tmp" directory of the
+data directory), and the new binary runs "mox verifydata" on the backup, which
+applies any database upgrades. The backup is removed afterwards.
+4. The current binary is kept with suffix ".old", and the new binary is moved in
+its place. If the new binary does not start, the old binary is restored.
+
+Mox itself is not restarted, do that afterwards, e.g. with "systemctl restart
+mox". To go back to the previous version, stop mox and move the ".old" binary
+back, but keep in mind the database files may have been upgraded after the
+restart. The mox binary must be writable by the user running this command.
+
+The domain for looking up the latest version, the URLs for the changelog and
+binaries, and the public key can be changed with flags, e.g. for updating from
+your own builds. The update server must serve a signed binary description at
+"<binaryurl>/<version>/<goos>-<goarch>.json", and the binary at the same path
+without ".json".
+`
+	var yes bool
+	var domain, clURL, binURL, pubKeyStr string
+	c.flag.BoolVar(&yes, "yes", false, "do not ask for confirmation before installing")
+	c.flag.StringVar(&domain, "domain", changelogDomain, "domain to look up latest version for in DNS, at _updates.<domain>")
+	c.flag.StringVar(&clURL, "changelogurl", changelogURL, "url to fetch changelog from")
+	c.flag.StringVar(&binURL, "binaryurl", binaryURL, "base url to fetch signed binary descriptions and binaries from")
+	c.flag.StringVar(&pubKeyStr, "pubkey", base64.StdEncoding.EncodeToString(changelogPubKey), "base64-encoded ed25519 public key for verifying the changelog and binary descriptions")
+	if len(c.Parse()) != 0 {
+		c.Usage()
+	}
+	pubKey, err := base64.StdEncoding.DecodeString(pubKeyStr)
+	if err != nil || len(pubKey) != ed25519.PublicKeySize {
+		log.Fatalf("invalid public key %q: must be base64 of %d bytes", pubKeyStr, ed25519.PublicKeySize)
+	}
+	dom := xparseDomain(domain, "domain")
+	mustLoadConfig()
+
+	ctx := context.Background()
+	current, _, _, err := store.LastKnown()
+	xcheckf(err, "getting current version")
+	fmt.Printf("current version: %s\n", current)
+	latest, _, err := updates.Lookup(ctx, c.log.Logger, dns.StrictResolver{}, dom)
+	xcheckf(err, "lookup of latest version")
+	fmt.Printf("latest version: %s\n", latest)
+	if !latest.After(current) {
+		fmt.Println("already at latest version")
+		return
+	}
+
+	changelog, err := updates.FetchChangelog(ctx, c.log.Logger, clURL, current, pubKey)
+	xcheckf(err, "fetching changelog")
+	fmt.Println("Changelog")
+	for _, c := range changelog.Changes {
+		fmt.Println("\n" + strings.TrimSpace(c.Text))
+	}
+	fmt.Println()
+
+	exe, err := os.Executable()
+	xcheckf(err, "finding path of mox binary")
+	exe, err = filepath.EvalSymlinks(exe)
+	xcheckf(err, "evaluating symlinks for mox binary")
+
+	if !yes {
+		fmt.Printf("Install %s to %s? [y/N] ", latest, exe)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		xcheckf(err, "reading confirmation")
+		if strings.TrimSpace(strings.ToLower(line)) != "y" {
+			log.Fatalf("not updating")
+		}
+	}
+
+	staged, err := updates.StageBinary(ctx, c.log.Logger, binURL, latest, runtime.GOOS, runtime.GOARCH, pubKey, exe)
+	xcheckf(err, "fetching new binary")
+	fmt.Printf("new binary verified and staged at %s\n", staged)
+
+	// Check that the binary starts and is the expected version.
+	checkVersion := func(path string) error {
+		out, err := exec.Command(path, "version").Output()
+		if err != nil {
+			return fmt.Errorf("running %s version: %v", path, err)
+		}
+		v, err := updates.ParseVersion(strings.TrimSpace(strings.Split(string(out), "\n")[0]))
+		if err != nil {
+			return fmt.Errorf("parsing version of %s: %v", path, err)
+		}
+		if v != latest {
+			return fmt.Errorf("binary %s has version %s, expected %s", path, v, latest)
+		}
+		return nil
+	}
+	removeStaged := func() {
+		err := os.Remove(staged)
+		c.log.Check(err, "removing staged binary", slog.String("path", staged))
+	}
+	if err := checkVersion(staged); err != nil {
+		removeStaged()
+		log.Fatalf("checking new binary: %v", err)
+	}
+
+	// Make a backup of the running instance, and let the new binary verify, and
+	// possibly upgrade, it.
+	backupDir := mox.DataDirPath(filepath.Join("tmp", "update-backup-"+time.Now().Format("20060102-150405")))
+	fmt.Printf("making backup in %s\n", backupDir)
+	ctlcmdBackup(xctl(), backupDir, false, "", "", nil)
+	cmd := exec.Command(staged, "verifydata", filepath.Join(backupDir, "data"))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		removeStaged()
+		log.Fatalf("verifydata with new binary on backup failed, not updating, backup kept at %s: %v", backupDir, err)
+	}
+	err = os.RemoveAll(backupDir)
+	c.log.Check(err, "removing backup", slog.String("path", backupDir))
+
+	// Keep the current binary, then replace it atomically.
+	old, err := updates.ReplaceBinary(c.log.Logger, exe, staged, checkVersion)
+	xcheckf(err, "installing new binary")
+	fmt.Printf("mox updated to %s, previous binary kept at %s\n", latest, old)
+	fmt.Println("restart mox to start using the new version, e.g. with systemctl restart mox")
+}
+
 func cmdCid(c *cmd) {
 	c.params = "cid"
 	c.help = `Turn an ID from a Received header into a cid, for looking up in logs.
@@ -3713,7 +4577,7 @@ open, or is not running.
 }
 
 func cmdFixUIDMeta(c *cmd) {
-	c.params = "account"
+	c.params = "[-dryrun] account"
 	c.help = `Fix inconsistent UIDVALIDITY and UIDNEXT in messages/mailboxes/account.
 
 The next UID to use for a message in a mailbox should always be higher than any
@@ -3724,9 +4588,15 @@ Each mailbox has a UIDVALIDITY sequence number, which should always be lower
 than the per-account next UIDVALIDITY to use. If it is not, the account next
 UIDVALIDITY is updated.
 
+These are the inconsistencies reported by "mox verifydata" that refer to this
+command. Mailboxes that have been removed are checked as well. With -dryrun, the
+changes are printed but not made.
+
 Opens account database file directly. Ensure mox does not have the account
 open, or is not running.
 `
+	var dryrun bool
+	c.flag.BoolVar(&dryrun, "dryrun", false, "only print the changes that would be made")
 	args := c.Parse()
 	if len(args) != 1 {
 		c.Usage()
@@ -3741,12 +4611,18 @@ open, or is not running.
 		}
 	}()
 
+	action := "fixing"
+	if dryrun {
+		action = "would fix"
+	}
+
 	var maxUIDValidity uint32
+	var changes int
 
-	err = a.DB.Write(context.Background(), func(tx *bstore.Tx) error {
+	fix := func(tx *bstore.Tx) error {
 		// We look at each mailbox, retrieve its max UID and compare against the mailbox
 		// UIDNEXT.
-		err := bstore.QueryTx[store.Mailbox](tx).FilterEqual("Expunged", false).ForEach(func(mb store.Mailbox) error {
+		err := bstore.QueryTx[store.Mailbox](tx).ForEach(func(mb store.Mailbox) error {
 			if mb.UIDValidity > maxUIDValidity {
 				maxUIDValidity = mb.UIDValidity
 			}
@@ -3756,9 +4632,13 @@ open, or is not running.
 			} else if err != nil {
 				return fmt.Errorf("finding message with max uid in mailbox: %w", err)
 			}
+			changes++
 			olduidnext := mb.UIDNext
 			mb.UIDNext = m.UID + 1
-			log.Printf("fixing uidnext to %d (max uid is %d, old uidnext was %d) for mailbox %q (id %d)", mb.UIDNext, m.UID, olduidnext, mb.Name, mb.ID)
+			log.Printf("%s uidnext to %d (max uid is %d, old uidnext was %d) for mailbox %q (id %d)", action, mb.UIDNext, m.UID, olduidnext, mb.Name, mb.ID)
+			if dryrun {
+				return nil
+			}
 			if err := tx.Update(&mb); err != nil {
 				return fmt.Errorf("updating mailbox uidnext: %v", err)
 			}
@@ -3773,7 +4653,11 @@ open, or is not running.
 			return fmt.Errorf("reading account next uidvalidity: %v", err)
 		}
 		if maxUIDValidity >= uidvalidity.Next {
-			log.Printf("account next uidvalidity %d <= highest uidvalidity %d found in mailbox, resetting account next uidvalidity to %d", uidvalidity.Next, maxUIDValidity, maxUIDValidity+1)
+			changes++
+			log.Printf("account next uidvalidity %d <= highest uidvalidity %d found in mailbox, %s by resetting account next uidvalidity to %d", uidvalidity.Next, maxUIDValidity, action, maxUIDValidity+1)
+			if dryrun {
+				return nil
+			}
 			uidvalidity.Next = maxUIDValidity + 1
 			if err := tx.Update(&uidvalidity); err != nil {
 				return fmt.Errorf("updating account next uidvalidity: %v", err)
@@ -3781,8 +4665,16 @@ open, or is not running.
 		}
 
 		return nil
-	})
+	}
+	if dryrun {
+		err = a.DB.Read(context.Background(), fix)
+	} else {
+		err = a.DB.Write(context.Background(), fix)
+	}
 	xcheckf(err, "updating database")
+	if changes == 0 {
+		fmt.Println("no inconsistencies found")
+	}
 }
 
 func cmdFixmsgsize(c *cmd) {
@@ -3815,6 +4707,41 @@ func ctlcmdFixmsgsize(ctl *ctl, account string) {
 	ctl.xstreamto(os.Stdout)
 }
 
+func cmdCompressmessages(c *cmd) {
+	c.params = "[-minsize bytes] [account]"
+	c.help = `Compress the on-disk files of existing messages with zstd.
+
+Only messages not yet compressed and with a file size of at least minsize bytes
+are compressed. Files that would not become smaller are left alone. Compressed
+messages are decompressed transparently when read. New messages are only stored
+compressed if MessageCompression is configured in mox.conf.
+
+Messages are compressed in batches, so other access to the mailboxes/messages
+is not blocked while compressing.
+`
+	var minSize int64
+	c.flag.Int64Var(&minSize, "minsize", 0, "only compress message files of at least this many bytes")
+	args := c.Parse()
+	if len(args) > 1 || minSize < 0 {
+		c.Usage()
+	}
+
+	mustLoadConfig()
+	var account string
+	if len(args) == 1 {
+		account = args[0]
+	}
+	ctlcmdCompressmessages(xctl(), minSize, account)
+}
+
+func ctlcmdCompressmessages(ctl *ctl, minSize int64, account string) {
+	ctl.xwrite("compressmessages")
+	ctl.xwrite(fmt.Sprintf("%d", minSize))
+	ctl.xwrite(account)
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
 func cmdReparse(c *cmd) {
 	c.params = "[account]"
 	c.help = `Parse all messages in the account or all accounts again.
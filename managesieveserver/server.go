@@ -0,0 +1,1368 @@
+// Package managesieveserver implements a ManageSieve server (RFC 5804), for
+// managing the Sieve scripts of an account.
+package managesieveserver
+
+/*
+Implementation notes
+
+- Scripts are stored in the account database, see store.SieveScript. Scripts
+  are parsed and validated with package sieve before they are stored or activated.
+  The active script is evaluated by the SMTP server during incoming delivery.
+- Authentication shares the failed authentication rate limiter and account
+  authentication with the IMAP, SMTP submission and POP3 servers. The same SASL
+  mechanisms are supported. Login attempts are recorded.
+- Clients can send strings as quoted strings or as literals, both synchronizing
+  ({n}) and non-synchronizing ({n+}). We don't send continuation responses for
+  synchronizing literals, as ManageSieve does not have them.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/ratelimit"
+	"github.com/mjl-/mox/scram"
+	"github.com/mjl-/mox/sieve"
+	"github.com/mjl-/mox/store"
+)
+
+var (
+	metricConnection = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mox_managesieve_connection_total",
+			Help: "Incoming ManageSieve connections.",
+		},
+	)
+	metricCommands = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_managesieve_command_duration_seconds",
+			Help:    "ManageSieve command duration and result codes in seconds.",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.100, 0.5, 1, 5, 10, 20},
+		},
+		[]string{
+			"cmd",
+			"result", // ok, ioerror, servererror, usererror, panic
+		},
+	)
+)
+
+var limiterConnectionrate, limiterConnections *ratelimit.Limiter
+
+func init() {
+	// Also called by tests, so they don't trigger the rate limiter.
+	limitersInit()
+}
+
+func limitersInit() {
+	mox.LimitersInit()
+	limiterConnectionrate = &ratelimit.Limiter{
+		WindowLimits: []ratelimit.WindowLimit{
+			{
+				Window: time.Minute,
+				Limits: [...]int64{300, 900, 2700},
+			},
+		},
+	}
+	limiterConnections = &ratelimit.Limiter{
+		WindowLimits: []ratelimit.WindowLimit{
+			{
+				Window: time.Duration(math.MaxInt64), // All of time.
+				Limits: [...]int64{30, 90, 270},
+			},
+		},
+	}
+}
+
+// Delay after bad/suspicious behaviour. Tests set these to zero.
+var badClientDelay = time.Second // Before reads and after 1-byte writes for probably spammers.
+var authFailDelay = time.Second  // After authentication failure.
+
+// Listen initializes all managesieve listeners for the configuration, and stores
+// them for Serve to start them.
+func Listen() {
+	names := slices.Sorted(maps.Keys(mox.Conf.Static.Listeners))
+	for _, name := range names {
+		listener := mox.Conf.Static.Listeners[name]
+
+		var tlsConfig *tls.Config
+		if listener.TLS != nil {
+			tlsConfig = listener.TLS.Config
+		}
+
+		if listener.ManageSieve.Enabled {
+			port := config.Port(listener.ManageSieve.Port, 4190)
+			for _, la := range mox.ListenAddresses(listener, "managesieve", port) {
+				listen1(name, la.Network, la.Address, tlsConfig, listener.ManageSieve.NoRequireSTARTTLS, listener.NoPlaintextAuth, listener.IPAccess, listener.ManageSieve.IPAccess)
+			}
+		}
+	}
+}
+
+var servers []func()
+
+func listen1(listenerName, network, addr string, tlsConfig *tls.Config, noRequireSTARTTLS, noPlaintextAuth bool, ipAccess ...*config.IPAccess) {
+	log := mlog.New("managesieveserver", nil)
+	if os.Getuid() == 0 {
+		log.Print("listening for managesieve",
+			slog.String("listener", listenerName),
+			slog.String("addr", addr))
+	}
+	ln, err := mox.Listen(network, addr)
+	if err != nil {
+		log.Fatalx("managesieve: listen for managesieve", err, slog.String("listener", listenerName))
+	}
+	ln = mox.IPAccessListener(ln, "managesieve", ipAccess...)
+
+	// Each listener gets its own copy of the config, so session keys between different
+	// ports on same listener aren't shared.
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+		mox.StartTLSSessionTicketKeyRefresher(mox.Shutdown, log, tlsConfig, mox.Conf.Static.Listeners[listenerName].TLS.SessionTicketKeyRotation)
+	}
+
+	serve := func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Infox("managesieve: accept", err, slog.String("listener", listenerName))
+				continue
+			}
+
+			metricConnection.Inc()
+			go serve(listenerName, mox.Cid(), tlsConfig, conn, noRequireSTARTTLS, noPlaintextAuth)
+		}
+	}
+
+	servers = append(servers, serve)
+}
+
+// Serve starts serving on all listeners, launching a goroutine per listener.
+func Serve() {
+	for _, serve := range servers {
+		go serve()
+	}
+	servers = nil
+}
+
+type conn struct {
+	cid               int64
+	conn              net.Conn
+	tls               bool // Whether TLS has been initialized.
+	baseTLSConfig     *tls.Config
+	noRequireSTARTTLS bool
+	noPlaintextAuth   bool             // Refuse PLAIN and CRAM-MD5 authentication.
+	greeting          *config.Greeting // Of listener, can be nil.
+	remoteIP          net.IP
+	tr                *moxio.TraceReader // Kept to change trace level when reading/writing cmd/auth/data.
+	br                *bufio.Reader
+	tw                *moxio.TraceWriter
+	bw                *bufio.Writer
+	lastlog           time.Time // For printing time since previous log line.
+	log               mlog.Log
+	slow              bool // If set, reads are done with a 1 second sleep, and writes are done 1 byte at a time, to keep spammers busy.
+	cmd               string
+	cmdStart          time.Time
+
+	// Authentication state.
+	authFailed   int // Number of failed authentication attempts.
+	loginAttempt *store.LoginAttempt
+
+	// Set after authentication.
+	username string
+	account  *store.Account
+}
+
+var bufpool = moxio.NewBufpool(8, 16*1024)
+
+// Errors are raised with panics, and handled per command. For user errors, the
+// session continues. For i/o errors, the connection is closed.
+var errIO = errors.New("io error")
+
+// Response codes. ../rfc/5804
+const (
+	codeNonexistent   = "NONEXISTENT"
+	codeAlreadyExists = "ALREADYEXISTS"
+	codeActive        = "ACTIVE"
+	codeMaxScripts    = "QUOTA/MAXSCRIPTS"
+	codeMaxSize       = "QUOTA/MAXSIZE"
+	codeAuthTooWeak   = "AUTH-TOO-WEAK"
+	codeEncryptNeeded = "ENCRYPT-NEEDED"
+	codeTryLater      = "TRYLATER"
+)
+
+type userError struct {
+	code string // Optional response code, like NONEXISTENT.
+	err  error
+}
+
+func (e userError) Error() string { return e.err.Error() }
+func (e userError) Unwrap() error { return e.err }
+
+type serverError struct{ err error }
+
+func (e serverError) Error() string { return e.err.Error() }
+func (e serverError) Unwrap() error { return e.err }
+
+func xuserErrorf(format string, args ...any) {
+	panic(userError{err: fmt.Errorf(format, args...)})
+}
+
+func xusercodeErrorf(code, format string, args ...any) {
+	panic(userError{code: code, err: fmt.Errorf(format, args...)})
+}
+
+func xserverErrorf(format string, args ...any) {
+	panic(serverError{fmt.Errorf(format, args...)})
+}
+
+func xcheckf(err error, format string, args ...any) {
+	if err != nil {
+		xserverErrorf("%s: %w", fmt.Sprintf(format, args...), err)
+	}
+}
+
+func (c *conn) xbrokenf(format string, args ...any) {
+	panic(fmt.Errorf(format, args...))
+}
+
+// Write makes a connection an io.Writer. It panics for i/o errors. These errors
+// are handled in the connection command loop.
+func (c *conn) Write(buf []byte) (int, error) {
+	chunk := len(buf)
+	if c.slow {
+		chunk = 1
+	}
+
+	var n int
+	for len(buf) > 0 {
+		err := c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		c.log.Check(err, "setting write deadline")
+
+		nn, err := c.conn.Write(buf[:chunk])
+		if err != nil {
+			c.xbrokenf("write: %s (%w)", err, errIO)
+		}
+		n += nn
+		buf = buf[chunk:]
+		if len(buf) > 0 && badClientDelay > 0 {
+			mox.Sleep(mox.Context, badClientDelay)
+		}
+	}
+	return n, nil
+}
+
+func (c *conn) xtraceread(level slog.Level) func() {
+	c.tr.SetTrace(level)
+	return func() {
+		c.tr.SetTrace(mlog.LevelTrace)
+	}
+}
+
+func (c *conn) xflush() {
+	if err := c.bw.Flush(); err != nil {
+		c.xbrokenf("flush: %s (%w)", err, errIO)
+	}
+}
+
+func (c *conn) xwritelinef(format string, args ...any) {
+	fmt.Fprintf(c.bw, format+"\r\n", args...)
+	c.xflush()
+}
+
+// xreadline reads a line without the trailing CRLF.
+func (c *conn) xreadline() string {
+	if c.slow && badClientDelay > 0 {
+		mox.Sleep(mox.Context, badClientDelay)
+	}
+
+	d := 30 * time.Minute
+	if c.account == nil {
+		d = time.Minute
+	}
+	err := c.conn.SetReadDeadline(time.Now().Add(d))
+	c.log.Check(err, "setting read deadline")
+
+	line, err := bufpool.Readline(c.log, c.br)
+	if err != nil {
+		c.xbrokenf("read: %s (%w)", err, errIO)
+	}
+	return line
+}
+
+// quoted returns s as quoted string, or as literal if it cannot be represented as
+// quoted string. ../rfc/5804
+func quoted(s string) string {
+	if strings.ContainsAny(s, "\r\n\x00") || len(s) > 1024 {
+		return fmt.Sprintf("{%d}\r\n%s", len(s), s)
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// xwriteResult writes an OK, NO or BYE response, with optional response code
+// (without parenthesis) and optional human-readable text.
+func (c *conn) xwriteResult(result, code, text string) {
+	s := result
+	if code != "" {
+		s += " (" + code + ")"
+	}
+	if text != "" {
+		s += " " + quoted(text)
+	}
+	c.xwritelinef("%s", s)
+}
+
+// serve handles a single ManageSieve connection on nc. TLS can be started with
+// STARTTLS if tlsConfig is set.
+//
+// If noRequireSTARTTLS is set, TLS is not required for authentication with
+// plain text passwords. If noPlaintextAuth is set, plain text passwords and
+// CRAM-MD5 are refused altogether.
+//
+// The connection is closed before returning.
+func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, noRequireSTARTTLS, noPlaintextAuth bool) {
+	var remoteIP net.IP
+	if a, ok := nc.RemoteAddr().(*net.TCPAddr); ok {
+		remoteIP = a.IP
+	} else {
+		// For tests.
+		remoteIP = net.ParseIP("127.0.0.10")
+	}
+
+	c := &conn{
+		cid:               cid,
+		conn:              nc,
+		baseTLSConfig:     tlsConfig,
+		noRequireSTARTTLS: noRequireSTARTTLS,
+		noPlaintextAuth:   noPlaintextAuth,
+		greeting:          mox.Conf.Static.Listeners[listenerName].Greeting,
+		remoteIP:          remoteIP,
+		lastlog:           time.Now(),
+		cmd:               "(greeting)",
+		cmdStart:          time.Now(),
+	}
+	var logmutex sync.Mutex
+	c.log = mlog.New("managesieveserver", nil).WithFunc(func() []slog.Attr {
+		logmutex.Lock()
+		defer logmutex.Unlock()
+		now := time.Now()
+		l := []slog.Attr{
+			slog.Int64("cid", c.cid),
+			slog.Duration("delta", now.Sub(c.lastlog)),
+		}
+		c.lastlog = now
+		if c.username != "" {
+			l = append(l, slog.String("username", c.username))
+		}
+		return l
+	})
+	c.tr = moxio.NewTraceReader(c.log, "C: ", c.conn)
+	c.br = bufio.NewReader(c.tr)
+	c.tw = moxio.NewTraceWriter(c.log, "S: ", c)
+	c.bw = bufio.NewWriter(c.tw)
+
+	c.log.Info("new connection",
+		slog.Any("remote", c.conn.RemoteAddr()),
+		slog.Any("local", c.conn.LocalAddr()),
+		slog.String("listener", listenerName))
+
+	defer func() {
+		err := c.conn.Close()
+		if err != nil {
+			c.log.Debugx("closing connection", err)
+		}
+
+		if c.account != nil {
+			err := c.account.Close()
+			c.log.Check(err, "close account")
+			c.account = nil
+		}
+
+		x := recover()
+		if x == nil {
+			c.log.Info("connection closed")
+		} else if err, ok := x.(error); ok && (errors.Is(err, errIO) || mlog.IsClosed(err)) {
+			c.log.Infox("connection closed", err)
+		} else {
+			c.log.Error("unhandled panic", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Managesieveserver)
+		}
+	}()
+
+	select {
+	case <-mox.Shutdown.Done():
+		c.xwriteResult("BYE", codeTryLater, "mox shutting down")
+		return
+	default:
+	}
+
+	if !limiterConnectionrate.Add(c.remoteIP, time.Now(), 1) {
+		c.xwriteResult("BYE", codeTryLater, "connection rate from your ip or network too high, slow down please")
+		return
+	}
+
+	// If remote IP/network resulted in too many authentication failures, refuse to serve.
+	if !mox.LimiterFailedAuth.CanAdd(c.remoteIP, time.Now(), 1) || store.AuthLockedOut(c.remoteIP, "") {
+		metrics.AuthenticationRatelimitedInc("managesieve")
+		c.log.Debug("refusing connection due to many auth failures", slog.Any("remoteip", c.remoteIP))
+		c.xwriteResult("BYE", codeTryLater, "too many auth failures")
+		return
+	}
+
+	if !limiterConnections.Add(c.remoteIP, time.Now(), 1) {
+		c.log.Debug("refusing connection due to many open connections", slog.Any("remoteip", c.remoteIP))
+		c.xwriteResult("BYE", codeTryLater, "too many open connections from your ip or network")
+		return
+	}
+	defer limiterConnections.Add(c.remoteIP, time.Now(), -1)
+
+	// We register and unregister the original connection, in case c.conn is replaced
+	// with a TLS connection later on.
+	mox.Connections.Register(nc, "managesieve", listenerName)
+	defer mox.Connections.Unregister(nc)
+
+	// ../rfc/5804
+	greetText := "mox managesieve ready"
+	if g := c.greeting; g != nil {
+		if g.ManageSieve != "" {
+			greetText = g.ManageSieve
+		} else if g.HideSoftware {
+			greetText = "managesieve ready"
+		}
+	}
+	c.xwriteCapabilities(greetText)
+
+	for {
+		if c.command() {
+			return
+		}
+	}
+}
+
+func (c *conn) xtlsHandshake() {
+	tlsConn := tls.Server(c.conn, c.baseTLSConfig)
+	c.conn = tlsConn
+	c.tr = moxio.NewTraceReader(c.log, "C: ", c.conn)
+	c.br = bufio.NewReader(c.tr)
+
+	cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
+	ctx, cancel := context.WithTimeout(cidctx, time.Minute)
+	defer cancel()
+	c.log.Debug("starting tls server handshake")
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		c.xbrokenf("tls handshake: %s (%w)", err, errIO)
+	}
+	cancel()
+
+	cs := tlsConn.ConnectionState()
+	version, ciphersuite := moxio.TLSInfo(cs)
+	c.log.Debug("tls handshake completed",
+		slog.String("version", version),
+		slog.String("ciphersuite", ciphersuite),
+		slog.String("sni", cs.ServerName),
+		slog.Bool("resumed", cs.DidResume))
+}
+
+var commands = map[string]func(c *conn, args []string){
+	// Allowed before authentication.
+	"capability":   (*conn).cmdCapability,
+	"starttls":     (*conn).cmdStarttls,
+	"authenticate": (*conn).cmdAuthenticate,
+	"noop":         (*conn).cmdNoop,
+	"logout":       (*conn).cmdLogout,
+
+	// After authentication.
+	"havespace":    (*conn).cmdHavespace,
+	"putscript":    (*conn).cmdPutscript,
+	"checkscript":  (*conn).cmdCheckscript,
+	"listscripts":  (*conn).cmdListscripts,
+	"setactive":    (*conn).cmdSetactive,
+	"getscript":    (*conn).cmdGetscript,
+	"deletescript": (*conn).cmdDeletescript,
+	"renamescript": (*conn).cmdRenamescript,
+}
+
+// Commands allowed without authentication.
+var unauthenticatedCommands = map[string]bool{"capability": true, "starttls": true, "authenticate": true, "noop": true, "logout": true}
+
+// errLogout is raised by LOGOUT to close the connection after the response.
+var errLogout = errors.New("logout")
+
+// command reads and executes a single command. It returns true if the connection
+// should be closed.
+func (c *conn) command() (logout bool) {
+	var cmdl string
+	defer func() {
+		x := recover()
+		var result string
+		defer func() {
+			metricCommands.WithLabelValues(cmdl, result).Observe(float64(time.Since(c.cmdStart)) / float64(time.Second))
+		}()
+
+		logFields := []slog.Attr{
+			slog.String("cmd", c.cmd),
+			slog.Duration("duration", time.Since(c.cmdStart)),
+		}
+		c.cmd = ""
+
+		if x == nil {
+			result = "ok"
+			c.log.Debug("managesieve command done", logFields...)
+			return
+		}
+		err, ok := x.(error)
+		if !ok {
+			result = "panic"
+			c.log.Error("managesieve command panic", append([]slog.Attr{slog.Any("panic", x)}, logFields...)...)
+			panic(x)
+		}
+
+		var uerr userError
+		var serr serverError
+		if errors.Is(err, errLogout) {
+			result = "ok"
+			logout = true
+			c.log.Debug("managesieve command done", logFields...)
+		} else if errors.Is(err, errIO) {
+			result = "ioerror"
+			c.log.Infox("managesieve command ioerror", err, logFields...)
+			panic(err)
+		} else if errors.As(err, &uerr) {
+			result = "usererror"
+			c.log.Debugx("managesieve command user error", err, logFields...)
+			c.xwriteResult("NO", uerr.code, uerr.err.Error())
+		} else if errors.As(err, &serr) {
+			result = "servererror"
+			c.log.Errorx("managesieve command server error", err, logFields...)
+			c.xwriteResult("NO", codeTryLater, fmt.Sprintf("processing command: %v", serr.err))
+		} else {
+			// Other type of panic, we pass it on, aborting the connection.
+			result = "panic"
+			c.log.Errorx("managesieve command panic", err, logFields...)
+			panic(err)
+		}
+	}()
+
+	cmd, args := c.xreadCommand()
+	c.cmdStart = time.Now()
+
+	cmdl = strings.ToLower(cmd)
+	c.cmd = cmdl
+
+	fn, ok := commands[cmdl]
+	if !ok {
+		cmdl = "(unknown)"
+		xuserErrorf("unknown command")
+	}
+	if c.account == nil && !unauthenticatedCommands[cmdl] {
+		xuserErrorf("authentication required")
+	}
+	fn(c, args)
+	return false
+}
+
+// token is a word from a command line, an atom or a string.
+type token struct {
+	s    string
+	atom bool // Whether s was an atom (e.g. a command name or number), not a quoted string or literal.
+}
+
+// xreadTokens reads a line with atoms and strings. Strings can be quoted or
+// literals, which can span multiple lines. ../rfc/5804
+func (c *conn) xreadTokens() []token {
+	// Limits sizes of literals. A script with some overhead.
+	maxLiteral := int64(store.SieveScriptMaxSize + 1024)
+
+	line := c.xreadline()
+	var l []token
+	for {
+		line = strings.TrimLeft(line, " ")
+		if line == "" {
+			return l
+		}
+		switch line[0] {
+		case '"':
+			var b strings.Builder
+			i := 1
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				b.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				xuserErrorf("unterminated quoted string")
+			}
+			l = append(l, token{s: b.String()})
+			line = line[i+1:]
+		case '{':
+			// Literal, must be last on line, with data on following lines.
+			if !strings.HasSuffix(line, "}") {
+				xuserErrorf("literal must be at end of line")
+			}
+			s := strings.TrimSuffix(strings.TrimSuffix(line[1:], "}"), "+")
+			size, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || size < 0 {
+				xuserErrorf("bad literal size")
+			}
+			if size > maxLiteral {
+				// We can't continue, the connection is out of sync.
+				c.xwriteResult("BYE", codeMaxSize, "literal too large")
+				c.xbrokenf("literal of %d bytes too large (%w)", size, errIO)
+			}
+			buf := make([]byte, size)
+			if _, err := io.ReadFull(c.br, buf); err != nil {
+				c.xbrokenf("reading literal: %s (%w)", err, errIO)
+			}
+			l = append(l, token{s: string(buf)})
+			line = c.xreadline()
+		default:
+			i := strings.IndexAny(line, " \"{")
+			if i < 0 {
+				i = len(line)
+			}
+			l = append(l, token{s: line[:i], atom: true})
+			line = line[i:]
+		}
+	}
+}
+
+// xreadCommand reads a command, returning the command name and its string and
+// number arguments.
+func (c *conn) xreadCommand() (string, []string) {
+	l := c.xreadTokens()
+	if len(l) == 0 || !l[0].atom {
+		xuserErrorf("missing command")
+	}
+	args := make([]string, len(l)-1)
+	for i, t := range l[1:] {
+		args[i] = t.s
+	}
+	return l[0].s, args
+}
+
+func xargs(args []string, n int) {
+	if len(args) != n {
+		xuserErrorf("expected %d parameter(s), got %d", n, len(args))
+	}
+}
+
+// plaintextAllowed returns whether plain text passwords may be sent.
+func (c *conn) plaintextAllowed() bool {
+	return (c.tls || c.noRequireSTARTTLS) && !c.noPlaintextAuth
+}
+
+func (c *conn) saslMechanisms() []string {
+	var l []string
+	if c.plaintextAllowed() {
+		l = append(l, "PLAIN")
+	}
+	if !c.noPlaintextAuth {
+		l = append(l, "CRAM-MD5")
+	}
+	l = append(l, "SCRAM-SHA-1", "SCRAM-SHA-256")
+	if c.tls {
+		l = append(l, "SCRAM-SHA-1-PLUS", "SCRAM-SHA-256-PLUS")
+	}
+	return l
+}
+
+// xwriteCapabilities writes the capabilities, followed by OK with text.
+func (c *conn) xwriteCapabilities(text string) {
+	// ../rfc/5804
+	// IMPLEMENTATION is required, we send a generic value when hiding the software.
+	impl := "mox"
+	if c.greeting != nil && c.greeting.HideSoftware {
+		impl = "managesieve"
+	}
+	fmt.Fprintf(c.bw, "\"IMPLEMENTATION\" %s\r\n", quoted(impl))
+	if c.account == nil {
+		fmt.Fprintf(c.bw, "\"SASL\" %s\r\n", quoted(strings.Join(c.saslMechanisms(), " ")))
+		if !c.tls && c.baseTLSConfig != nil {
+			fmt.Fprintf(c.bw, "\"STARTTLS\"\r\n")
+		}
+	}
+	fmt.Fprintf(c.bw, "\"SIEVE\" %s\r\n", quoted(strings.Join(sieve.Extensions, " ")))
+	fmt.Fprintf(c.bw, "\"VERSION\" \"1.0\"\r\n")
+	if c.account != nil {
+		fmt.Fprintf(c.bw, "\"OWNER\" %s\r\n", quoted(c.username))
+	}
+	c.xwriteResult("OK", "", text)
+}
+
+// Capability lists the capabilities of the server.
+func (c *conn) cmdCapability(args []string) {
+	// ../rfc/5804
+	xargs(args, 0)
+	c.xwriteCapabilities("")
+}
+
+// Starttls starts TLS on the connection, and sends the capabilities again.
+func (c *conn) cmdStarttls(args []string) {
+	// ../rfc/5804
+	xargs(args, 0)
+
+	if c.tls {
+		xuserErrorf("tls already active")
+	}
+	if c.baseTLSConfig == nil {
+		xuserErrorf("starttls not available")
+	}
+	if c.account != nil {
+		xuserErrorf("starttls not allowed after authentication")
+	}
+
+	c.xwriteResult("OK", "", "begin tls negotiation")
+
+	// We don't want to do TLS on top of c.br. Some of the TLS messages may already be
+	// in the buffer and will be lost. So we read whatever is in the buffer and let
+	// the TLS handshake read from it first, and then continue on the connection.
+	conn := c.conn
+	if n := c.br.Buffered(); n > 0 {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(c.br, buf)
+		xcheckf(err, "reading buffered data for tls handshake")
+		conn = &prefixConn{buf, conn}
+	}
+	c.conn = conn
+	c.xtlsHandshake()
+	c.tls = true
+
+	c.xwriteCapabilities("tls active")
+}
+
+// prefixConn is a net.Conn with a buffer from which the first reads are satisfied.
+type prefixConn struct {
+	prefix []byte
+	net.Conn
+}
+
+func (c *prefixConn) Read(buf []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := min(len(buf), len(c.prefix))
+		copy(buf[:n], c.prefix[:n])
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(buf)
+}
+
+// Noop does nothing, but can echo a tag.
+func (c *conn) cmdNoop(args []string) {
+	// ../rfc/5804
+	if len(args) > 1 {
+		xuserErrorf("expected optional tag")
+	}
+	var code string
+	if len(args) == 1 {
+		code = "TAG " + quoted(args[0])
+	}
+	c.xwriteResult("OK", code, "done")
+}
+
+// Logout ends the session.
+func (c *conn) cmdLogout(args []string) {
+	// ../rfc/5804
+	xargs(args, 0)
+	c.xwriteResult("OK", "", "mox managesieve signing off")
+	panic(errLogout)
+}
+
+// Authenticate authenticates with a SASL mechanism.
+func (c *conn) cmdAuthenticate(args []string) {
+	// ../rfc/5804
+	if len(args) == 0 || len(args) > 2 {
+		xuserErrorf("expected mechanism and optional initial response")
+	}
+	if c.account != nil {
+		xuserErrorf("already authenticated")
+	}
+
+	// If authentication fails due to missing derived secrets, we don't hold it against
+	// the connection.
+	var missingDerivedSecrets bool
+
+	c.newLoginAttempt("")
+	defer func() {
+		c.finishLoginAttempt(missingDerivedSecrets)
+		if missingDerivedSecrets {
+			c.authFailed--
+		}
+	}()
+
+	c.authDelay()
+	c.authFailed++ // Compensated on success.
+
+	// Responses from the client are strings, quoted or literal.
+	xreadResponse := func() []byte {
+		l := c.xreadTokens()
+		if len(l) != 1 || l[0].atom {
+			xuserErrorf("expected a single string as sasl response")
+		}
+		return c.xdecodeSASL(l[0].s)
+	}
+	xreadInitial := func() []byte {
+		if len(args) == 2 {
+			return c.xdecodeSASL(args[1])
+		}
+		c.xwritelinef("\"\"")
+		return xreadResponse()
+	}
+
+	var account *store.Account
+	var username string
+	defer func() {
+		if account != nil {
+			err := account.Close()
+			c.log.Check(err, "close account")
+		}
+	}()
+
+	// Optional final server data, sent in the OK response.
+	var saslFinal string
+
+	mech := strings.ToUpper(args[0])
+	if c.noPlaintextAuth && (mech == "PLAIN" || mech == "CRAM-MD5") {
+		c.loginAttempt.AuthMech = strings.ToLower(mech)
+		c.loginAttempt.Result = store.AuthBadProtocol
+		xuserErrorf("mechanism not allowed, plaintext authentication is disabled")
+	}
+	switch mech {
+	case "PLAIN":
+		c.loginAttempt.AuthMech = "plain"
+
+		if !c.plaintextAllowed() {
+			xusercodeErrorf(codeEncryptNeeded, "tls required for login")
+		}
+
+		// Plain text passwords, mark as traceauth.
+		defer c.xtraceread(mlog.LevelTraceauth)()
+		buf := xreadInitial()
+		c.xtraceread(mlog.LevelTrace) // Restore.
+		plain := bytes.Split(buf, []byte{0})
+		if len(plain) != 3 {
+			xuserErrorf("bad plain auth data, expected 3 nul-separated tokens, got %d tokens", len(plain))
+		}
+		authz := norm.NFC.String(string(plain[0]))
+		username = norm.NFC.String(string(plain[1]))
+		password := string(plain[2])
+		c.loginAttempt.LoginAddress = username
+
+		if authz != "" && authz != username {
+			xuserErrorf("cannot assume role")
+		}
+
+		var err error
+		account, c.loginAttempt.AccountName, err = store.OpenEmailAuth(c.log, username, password, false)
+		if err != nil {
+			if errors.Is(err, store.ErrUnknownCredentials) {
+				c.loginAttempt.Result = store.AuthBadCredentials
+				c.log.Info("authentication failed", slog.String("username", username))
+				xuserErrorf("bad credentials")
+			}
+			xserverErrorf("login: %w", err)
+		}
+
+	case "CRAM-MD5":
+		c.loginAttempt.AuthMech = "cram-md5"
+
+		if len(args) != 1 {
+			xuserErrorf("unexpected initial response")
+		}
+
+		// ../rfc/2195
+		chal := fmt.Sprintf("<%d.%d@%s>", uint64(mox.CryptoRandInt()), time.Now().UnixNano(), mox.Conf.Static.HostnameDomain.ASCII)
+		c.xwritelinef("%s", quoted(base64.StdEncoding.EncodeToString([]byte(chal))))
+
+		resp := xreadResponse()
+		t := strings.Split(string(resp), " ")
+		if len(t) != 2 || len(t[1]) != 2*md5.Size {
+			xuserErrorf("malformed cram-md5 response")
+		}
+		username = norm.NFC.String(t[0])
+		c.loginAttempt.LoginAddress = username
+		c.log.Debug("cram-md5 auth", slog.String("address", username))
+		var err error
+		account, c.loginAttempt.AccountName, _, err = store.OpenEmail(c.log, username, false)
+		if err != nil {
+			if errors.Is(err, store.ErrUnknownCredentials) {
+				c.loginAttempt.Result = store.AuthBadCredentials
+				c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xuserErrorf("bad credentials")
+			}
+			xserverErrorf("looking up address: %v", err)
+		}
+		password := c.xpassword(account, username)
+		ipadhash := password.CRAMMD5.Ipad
+		opadhash := password.CRAMMD5.Opad
+		if ipadhash == nil || opadhash == nil {
+			c.log.Info("cram-md5 auth attempt without derived secrets set, save password again to store secrets", slog.String("username", username))
+			missingDerivedSecrets = true
+			xuserErrorf("bad credentials")
+		}
+
+		// ../rfc/2104
+		ipadhash.Write([]byte(chal))
+		opadhash.Write(ipadhash.Sum(nil))
+		digest := fmt.Sprintf("%x", opadhash.Sum(nil))
+		if digest != t[1] {
+			c.loginAttempt.Result = store.AuthBadCredentials
+			c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+			xuserErrorf("bad credentials")
+		}
+
+	case "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1":
+		// No plaintext credentials, we can log these normally.
+
+		c.loginAttempt.AuthMech = strings.ToLower(mech)
+		var h func() hash.Hash
+		if strings.HasPrefix(mech, "SCRAM-SHA-1") {
+			h = sha1.New
+		} else {
+			h = sha256.New
+		}
+
+		var cs *tls.ConnectionState
+		requireChannelBinding := strings.HasSuffix(mech, "-PLUS")
+		if requireChannelBinding && !c.tls {
+			xuserErrorf("cannot use plus variant with tls channel binding without tls")
+		}
+		if c.tls {
+			xcs := c.conn.(*tls.Conn).ConnectionState()
+			cs = &xcs
+		}
+		c0 := xreadInitial()
+		ss, err := scram.NewServer(h, c0, cs, requireChannelBinding)
+		if err != nil {
+			c.log.Infox("scram protocol error", err, slog.Any("remote", c.remoteIP))
+			xuserErrorf("scram protocol error: %s", err)
+		}
+		username = ss.Authentication
+		c.loginAttempt.LoginAddress = username
+		c.log.Debug("scram auth", slog.String("authentication", username))
+		account, c.loginAttempt.AccountName, _, err = store.OpenEmail(c.log, username, false)
+		if err != nil {
+			xuserErrorf("scram not possible")
+		}
+		if ss.Authorization != "" && ss.Authorization != username {
+			xuserErrorf("authentication with authorization for different user not supported")
+		}
+		password := c.xpassword(account, username)
+		xscram := password.SCRAMSHA256
+		if strings.HasPrefix(mech, "SCRAM-SHA-1") {
+			xscram = password.SCRAMSHA1
+		}
+		if len(xscram.Salt) == 0 || xscram.Iterations == 0 || len(xscram.SaltedPassword) == 0 {
+			missingDerivedSecrets = true
+			c.log.Info("scram auth attempt without derived secrets set, save password again to store secrets", slog.String("username", username))
+			xuserErrorf("scram not possible")
+		}
+		s1, err := ss.ServerFirst(xscram.Iterations, xscram.Salt)
+		xcheckf(err, "scram first server step")
+		c.xwritelinef("%s", quoted(base64.StdEncoding.EncodeToString([]byte(s1))))
+		c2 := xreadResponse()
+		s3, err := ss.Finish(c2, xscram.SaltedPassword)
+		if err != nil {
+			if errors.Is(err, scram.ErrInvalidProof) {
+				c.loginAttempt.Result = store.AuthBadCredentials
+				c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xuserErrorf("bad credentials")
+			} else if errors.Is(err, scram.ErrChannelBindingsDontMatch) {
+				c.loginAttempt.Result = store.AuthBadChannelBinding
+				c.log.Warn("bad channel binding during authentication, potential mitm", slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xuserErrorf("channel bindings do not match, potential mitm")
+			} else if errors.Is(err, scram.ErrInvalidEncoding) {
+				c.loginAttempt.Result = store.AuthBadProtocol
+				c.log.Infox("bad scram protocol message", err, slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xuserErrorf("bad scram protocol message: %s", err)
+			}
+			xuserErrorf("server final: %w", err)
+		}
+		// The server final message is sent with the OK response. ../rfc/5804
+		saslFinal = base64.StdEncoding.EncodeToString([]byte(s3))
+
+	default:
+		c.loginAttempt.AuthMech = "(unrecognized)"
+		xuserErrorf("mechanism not supported")
+	}
+
+	if accConf, ok := account.Conf(); !ok {
+		xserverErrorf("cannot get account config")
+	} else if store.AuthLockedOut(nil, account.Name) {
+		c.loginAttempt.Result = store.AuthBadCredentials
+		c.log.Info("account locked out after failed authentication attempts", slog.String("username", username))
+		xuserErrorf("bad credentials")
+	} else if accConf.LoginDisabled != "" {
+		c.loginAttempt.Result = store.AuthLoginDisabled
+		c.log.Info("account login disabled", slog.String("username", username))
+		xuserErrorf("%s: %s", store.ErrLoginDisabled, accConf.LoginDisabled)
+	}
+
+	c.account = account
+	account = nil // Prevent cleanup.
+	c.username = username
+	c.loginAttempt.AccountName = c.account.Name
+	c.loginAttempt.Result = store.AuthSuccess
+	c.authFailed = 0
+	c.setSlow(false)
+	var code string
+	if saslFinal != "" {
+		code = "SASL " + quoted(saslFinal)
+	}
+	c.xwriteResult("OK", code, "authenticated")
+}
+
+// xdecodeSASL decodes a base64 SASL response, handling aborts by the client.
+func (c *conn) xdecodeSASL(s string) []byte {
+	if s == "*" {
+		// ../rfc/5804
+		c.loginAttempt.Result = store.AuthAborted
+		xuserErrorf("authentication aborted by client")
+	}
+	buf, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		c.loginAttempt.Result = store.AuthBadProtocol
+		xuserErrorf("parsing base64: %v", err)
+	}
+	return buf
+}
+
+// xpassword returns the stored password with derived secrets for account.
+func (c *conn) xpassword(account *store.Account, username string) (password store.Password) {
+	account.WithRLock(func() {
+		err := account.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+			var err error
+			password, err = bstore.QueryTx[store.Password](tx).Get()
+			return err
+		})
+		if err == bstore.ErrAbsent {
+			c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+			xuserErrorf("bad credentials")
+		}
+		xcheckf(err, "fetching credentials")
+	})
+	return
+}
+
+// authDelay slows down authentication after many failed attempts.
+func (c *conn) authDelay() {
+	if c.authFailed > 3 && authFailDelay > 0 {
+		mox.Sleep(mox.Context, time.Duration(c.authFailed-3)*authFailDelay)
+	}
+	// On the 3rd failed authentication, start responding slowly. Successful auth will
+	// cause fast responses again.
+	if c.authFailed >= 3 {
+		c.setSlow(true)
+	}
+}
+
+func (c *conn) setSlow(on bool) {
+	if on && !c.slow {
+		c.log.Debug("connection changed to slow")
+	} else if !on && c.slow {
+		c.log.Debug("connection restored to regular pace")
+	}
+	c.slow = on
+}
+
+// newLoginAttempt initializes a c.loginAttempt, for adding to the store after
+// filling in the results and other details.
+func (c *conn) newLoginAttempt(authMech string) {
+	var state *tls.ConnectionState
+	if tc, ok := c.conn.(*tls.Conn); ok {
+		v := tc.ConnectionState()
+		state = &v
+	}
+
+	localAddr := c.conn.LocalAddr().String()
+	localIP, _, _ := net.SplitHostPort(localAddr)
+	if localIP == "" {
+		localIP = localAddr
+	}
+
+	c.loginAttempt = &store.LoginAttempt{
+		RemoteIP: c.remoteIP.String(),
+		LocalIP:  localIP,
+		TLS:      store.LoginAttemptTLS(state),
+		Protocol: "managesieve",
+		AuthMech: authMech,
+		Result:   store.AuthError, // Replaced by caller.
+	}
+}
+
+// finishLoginAttempt updates the failed authentication rate limiter and stores the
+// login attempt.
+func (c *conn) finishLoginAttempt(missingDerivedSecrets bool) {
+	if c.loginAttempt.Result == store.AuthSuccess {
+		mox.LimiterFailedAuth.Reset(c.remoteIP, time.Now())
+	} else if !missingDerivedSecrets {
+		mox.LimiterFailedAuth.Add(c.remoteIP, time.Now(), 1)
+	}
+	store.LoginAttemptAdd(context.Background(), c.log, *c.loginAttempt)
+	c.loginAttempt = nil
+}
+
+// xscriptName checks if name is a valid script name. ../rfc/5804
+func xscriptName(name string) string {
+	if name == "" {
+		xuserErrorf("empty script name")
+	}
+	if !utf8.ValidString(name) {
+		xuserErrorf("script name not valid utf-8")
+	}
+	if utf8.RuneCountInString(name) > store.SieveScriptNameMax {
+		xuserErrorf("script name too long, max %d characters", store.SieveScriptNameMax)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) || r == 0x2028 || r == 0x2029 {
+			xuserErrorf("script name cannot contain control characters")
+		}
+	}
+	return norm.NFC.String(name)
+}
+
+// xcheckScript parses and validates a script.
+func xcheckScript(script string) {
+	if len(script) > store.SieveScriptMaxSize {
+		xusercodeErrorf(codeMaxSize, "script too large, max %d bytes", store.SieveScriptMaxSize)
+	}
+	if _, err := sieve.Parse(script); err != nil {
+		xuserErrorf("invalid script: %s", err)
+	}
+}
+
+// xscript returns the script with name, or a user error if it does not exist.
+func xscript(tx *bstore.Tx, name string) store.SieveScript {
+	ss, err := bstore.QueryTx[store.SieveScript](tx).FilterNonzero(store.SieveScript{Name: name}).Get()
+	if err == bstore.ErrAbsent {
+		xusercodeErrorf(codeNonexistent, "no such script")
+	}
+	xcheckf(err, "get script")
+	return ss
+}
+
+// xdbwrite runs fn in a write transaction on the account database.
+func (c *conn) xdbwrite(fn func(tx *bstore.Tx)) {
+	c.account.WithWLock(func() {
+		err := c.account.DB.Write(context.TODO(), func(tx *bstore.Tx) error {
+			fn(tx)
+			return nil
+		})
+		xcheckf(err, "transaction")
+	})
+}
+
+// xdbread runs fn in a read transaction on the account database.
+func (c *conn) xdbread(fn func(tx *bstore.Tx)) {
+	c.account.WithRLock(func() {
+		err := c.account.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+			fn(tx)
+			return nil
+		})
+		xcheckf(err, "transaction")
+	})
+}
+
+// Havespace checks if a script with the name and size can be stored.
+func (c *conn) cmdHavespace(args []string) {
+	// ../rfc/5804
+	xargs(args, 2)
+	name := xscriptName(args[0])
+	size, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || size < 0 {
+		xuserErrorf("bad size")
+	}
+	if size > store.SieveScriptMaxSize {
+		xusercodeErrorf(codeMaxSize, "script too large, max %d bytes", store.SieveScriptMaxSize)
+	}
+	c.xdbread(func(tx *bstore.Tx) {
+		exists, err := bstore.QueryTx[store.SieveScript](tx).FilterNonzero(store.SieveScript{Name: name}).Exists()
+		xcheckf(err, "checking if script exists")
+		if exists {
+			return
+		}
+		n, err := bstore.QueryTx[store.SieveScript](tx).Count()
+		xcheckf(err, "counting scripts")
+		if n >= store.SieveScriptsMax {
+			xusercodeErrorf(codeMaxScripts, "too many scripts, max %d", store.SieveScriptsMax)
+		}
+	})
+	c.xwriteResult("OK", "", "")
+}
+
+// Putscript validates and stores a script, replacing an existing script with the
+// same name.
+func (c *conn) cmdPutscript(args []string) {
+	// ../rfc/5804
+	xargs(args, 2)
+	name := xscriptName(args[0])
+	script := args[1]
+	xcheckScript(script)
+
+	c.xdbwrite(func(tx *bstore.Tx) {
+		ss, err := bstore.QueryTx[store.SieveScript](tx).FilterNonzero(store.SieveScript{Name: name}).Get()
+		if err == bstore.ErrAbsent {
+			n, err := bstore.QueryTx[store.SieveScript](tx).Count()
+			xcheckf(err, "counting scripts")
+			if n >= store.SieveScriptsMax {
+				xusercodeErrorf(codeMaxScripts, "too many scripts, max %d", store.SieveScriptsMax)
+			}
+			ss = store.SieveScript{Name: name, Script: script}
+			err = tx.Insert(&ss)
+			xcheckf(err, "inserting script")
+			return
+		}
+		xcheckf(err, "get script")
+		ss.Script = script
+		ss.Modified = time.Now()
+		err = tx.Update(&ss)
+		xcheckf(err, "updating script")
+	})
+	c.log.Info("sieve script stored", slog.String("name", name))
+	c.xwriteResult("OK", "", "")
+}
+
+// Checkscript validates a script without storing it.
+func (c *conn) cmdCheckscript(args []string) {
+	// ../rfc/5804
+	xargs(args, 1)
+	xcheckScript(args[0])
+	c.xwriteResult("OK", "", "")
+}
+
+// Listscripts lists all scripts, marking the active script.
+func (c *conn) cmdListscripts(args []string) {
+	// ../rfc/5804
+	xargs(args, 0)
+	var l []store.SieveScript
+	c.xdbread(func(tx *bstore.Tx) {
+		var err error
+		l, err = bstore.QueryTx[store.SieveScript](tx).SortAsc("Name").List()
+		xcheckf(err, "listing scripts")
+	})
+	for _, ss := range l {
+		if ss.Active {
+			fmt.Fprintf(c.bw, "%s ACTIVE\r\n", quoted(ss.Name))
+		} else {
+			fmt.Fprintf(c.bw, "%s\r\n", quoted(ss.Name))
+		}
+	}
+	c.xwriteResult("OK", "", "")
+}
+
+// Setactive makes a script the active script. An empty name deactivates all
+// scripts.
+func (c *conn) cmdSetactive(args []string) {
+	// ../rfc/5804
+	xargs(args, 1)
+	var name string
+	if args[0] != "" {
+		name = xscriptName(args[0])
+	}
+
+	c.xdbwrite(func(tx *bstore.Tx) {
+		var ss store.SieveScript
+		if name != "" {
+			ss = xscript(tx, name)
+			// Scripts are validated when stored, but our validation may have become stricter.
+			xcheckScript(ss.Script)
+		}
+		_, err := bstore.QueryTx[store.SieveScript](tx).FilterEqual("Active", true).FilterNotEqual("ID", ss.ID).UpdateField("Active", false)
+		xcheckf(err, "deactivating scripts")
+		if name != "" && !ss.Active {
+			ss.Active = true
+			err := tx.Update(&ss)
+			xcheckf(err, "activating script")
+		}
+	})
+	c.log.Info("sieve script activated", slog.String("name", name))
+	c.xwriteResult("OK", "", "")
+}
+
+// Getscript returns a script.
+func (c *conn) cmdGetscript(args []string) {
+	// ../rfc/5804
+	xargs(args, 1)
+	name := xscriptName(args[0])
+	var ss store.SieveScript
+	c.xdbread(func(tx *bstore.Tx) {
+		ss = xscript(tx, name)
+	})
+	fmt.Fprintf(c.bw, "{%d}\r\n%s\r\n", len(ss.Script), ss.Script)
+	c.xwriteResult("OK", "", "")
+}
+
+// Deletescript removes a script, which must not be active.
+func (c *conn) cmdDeletescript(args []string) {
+	// ../rfc/5804
+	xargs(args, 1)
+	name := xscriptName(args[0])
+	c.xdbwrite(func(tx *bstore.Tx) {
+		ss := xscript(tx, name)
+		if ss.Active {
+			xusercodeErrorf(codeActive, "cannot delete active script")
+		}
+		err := tx.Delete(&ss)
+		xcheckf(err, "deleting script")
+	})
+	c.log.Info("sieve script deleted", slog.String("name", name))
+	c.xwriteResult("OK", "", "")
+}
+
+// Renamescript renames a script, keeping its active status.
+func (c *conn) cmdRenamescript(args []string) {
+	// ../rfc/5804
+	xargs(args, 2)
+	oldName := xscriptName(args[0])
+	newName := xscriptName(args[1])
+	c.xdbwrite(func(tx *bstore.Tx) {
+		ss := xscript(tx, oldName)
+		exists, err := bstore.QueryTx[store.SieveScript](tx).FilterNonzero(store.SieveScript{Name: newName}).Exists()
+		xcheckf(err, "checking if script with new name exists")
+		if exists {
+			xusercodeErrorf(codeAlreadyExists, "script with new name already exists")
+		}
+		ss.Name = newName
+		ss.Modified = time.Now()
+		err = tx.Update(&ss)
+		xcheckf(err, "renaming script")
+	})
+	c.xwriteResult("OK", "", "")
+}
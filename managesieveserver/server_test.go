@@ -0,0 +1,378 @@
+package managesieveserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/sasl"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+var pkglog = mlog.New("managesieveserver", nil)
+
+const password0 = "tést    " // NFD and various unicode spaces.
+
+func init() {
+	badClientDelay = 0
+	authFailDelay = 0
+}
+
+func TestMain(m *testing.M) {
+	m.Run()
+	if metrics.Panics.Load() > 0 {
+		fmt.Println("unhandled panics encountered")
+		os.Exit(2)
+	}
+}
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(exp) {
+		t.Fatalf("got %v, expected %v", got, exp)
+	}
+}
+
+type testconn struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+	done chan struct{}
+}
+
+// setup loads the config and initializes a fresh store with a password for
+// account mjl.
+func setup(t *testing.T) func() {
+	limitersInit() // Reset rate limiters.
+
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/managesieve/mox.conf")
+	mox.ConfigDynamicPath = filepath.Join(filepath.Dir(mox.ConfigStaticPath), "domains.conf")
+	mox.MustLoadConfig(true, false)
+	store.Close() // May not be open, we ignore error.
+	os.RemoveAll("../testdata/managesieve/data")
+	err := store.Init(ctxbg)
+	tcheck(t, err, "store init")
+	switchStop := store.Switchboard()
+
+	acc, err := store.OpenAccount(pkglog, "mjl", false)
+	tcheck(t, err, "open account")
+	err = acc.SetPassword(pkglog, password0)
+	tcheck(t, err, "set password")
+
+	return func() {
+		err := acc.Close()
+		pkglog.Check(err, "close account")
+		acc.WaitClosed()
+		switchStop()
+		err = store.Close()
+		tcheck(t, err, "store close")
+	}
+}
+
+func startConn(t *testing.T, tlsConfig *tls.Config, noRequireSTARTTLS, noPlaintextAuth bool) (*testconn, []string) {
+	serverConn, clientConn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve("test", mox.Cid(), tlsConfig, serverConn, noRequireSTARTTLS, noPlaintextAuth)
+	}()
+	tc := &testconn{t: t, conn: clientConn, br: bufio.NewReader(clientConn), done: done}
+	return tc, tc.readresp("OK", "")
+}
+
+func (tc *testconn) close() {
+	tc.conn.Close()
+	<-tc.done
+}
+
+func (tc *testconn) readline() string {
+	tc.t.Helper()
+	err := tc.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	tcheck(tc.t, err, "set read deadline")
+	line, err := tc.br.ReadString('\n')
+	tcheck(tc.t, err, "read line")
+	if !strings.HasSuffix(line, "\r\n") {
+		tc.t.Fatalf("line %q does not end with crlf", line)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+
+	// Read literal, adding it to the line.
+	if strings.HasSuffix(line, "}") {
+		i := strings.LastIndex(line, "{")
+		if i >= 0 {
+			size, err := strconv.ParseInt(line[i+1:len(line)-1], 10, 64)
+			tcheck(tc.t, err, "parse literal size")
+			buf := make([]byte, size)
+			_, err = io.ReadFull(tc.br, buf)
+			tcheck(tc.t, err, "read literal")
+			line = line[:i] + string(buf) + tc.readline()
+		}
+	}
+	return line
+}
+
+func (tc *testconn) writelinef(format string, args ...any) {
+	tc.t.Helper()
+	_, err := fmt.Fprintf(tc.conn, format+"\r\n", args...)
+	tcheck(tc.t, err, "write line")
+}
+
+// readresp reads response lines until OK/NO/BYE, checking the result and
+// optional response code. The lines before the result are returned.
+func (tc *testconn) readresp(result, code string) []string {
+	tc.t.Helper()
+	var l []string
+	for {
+		line := tc.readline()
+		for _, r := range []string{"OK", "NO", "BYE"} {
+			if line == r || strings.HasPrefix(line, r+" ") {
+				if r != result || code != "" && !strings.HasPrefix(line, r+" ("+code+")") {
+					tc.t.Fatalf("got %q, expected %s with code %q", line, result, code)
+				}
+				return l
+			}
+		}
+		l = append(l, line)
+	}
+}
+
+func (tc *testconn) cmdok(format string, args ...any) []string {
+	tc.t.Helper()
+	tc.writelinef(format, args...)
+	return tc.readresp("OK", "")
+}
+
+func (tc *testconn) cmdno(code, format string, args ...any) {
+	tc.t.Helper()
+	tc.writelinef(format, args...)
+	tc.readresp("NO", code)
+}
+
+// auth runs a sasl authentication, returning the final line from the server.
+func (tc *testconn) auth(client sasl.Client) string {
+	tc.t.Helper()
+	name, _ := client.Info()
+	toServer, last, err := client.Next(nil)
+	tcheck(tc.t, err, "sasl next")
+	if toServer == nil {
+		tc.writelinef("AUTHENTICATE %q", name)
+	} else {
+		tc.writelinef("AUTHENTICATE %q %q", name, base64.StdEncoding.EncodeToString(toServer))
+	}
+	for {
+		line := tc.readline()
+		if !strings.HasPrefix(line, `"`) {
+			if strings.HasPrefix(line, `OK (SASL "`) {
+				s := strings.TrimPrefix(line, `OK (SASL "`)
+				s = s[:strings.Index(s, `"`)]
+				fromServer, err := base64.StdEncoding.DecodeString(s)
+				tcheck(tc.t, err, "decode sasl server final")
+				_, _, err = client.Next(fromServer)
+				tcheck(tc.t, err, "sasl server final")
+			}
+			return line
+		}
+		if last {
+			tc.t.Fatalf("server wants more after last sasl client message")
+		}
+		fromServer, err := base64.StdEncoding.DecodeString(strings.Trim(line, `"`))
+		tcheck(tc.t, err, "decode sasl server message")
+		toServer, last, err = client.Next(fromServer)
+		if err != nil {
+			// Likely a scram error from the server, abort.
+			tc.writelinef(`"*"`)
+			continue
+		}
+		tc.writelinef("%q", base64.StdEncoding.EncodeToString(toServer))
+	}
+}
+
+func (tc *testconn) login() {
+	tc.t.Helper()
+	line := tc.auth(sasl.NewClientPlain("mjl@mox.example", password0))
+	tcompare(tc.t, strings.HasPrefix(line, "OK"), true)
+}
+
+func TestSession(t *testing.T) {
+	cleanup := setup(t)
+	defer cleanup()
+
+	tc, caps := startConn(t, nil, true, false)
+	defer tc.close()
+
+	tcompare(t, caps[0], `"IMPLEMENTATION" "mox"`)
+	tcompare(t, caps[1], `"SASL" "PLAIN CRAM-MD5 SCRAM-SHA-1 SCRAM-SHA-256"`)
+	tcompare(t, strings.HasPrefix(caps[2], `"SIEVE" "body `), true)
+	tcompare(t, caps[3], `"VERSION" "1.0"`)
+	tcompare(t, len(tc.cmdok("CAPABILITY")), 4)
+
+	tc.cmdno("", "LISTSCRIPTS")   // Not authenticated.
+	tc.cmdno("", "NOSUCHCOMMAND") // Unknown command.
+	tc.cmdno("", `"CAPABILITY"`)  // Command must be an atom.
+	tc.cmdok(`NOOP`)
+	tc.writelinef(`NOOP "tag"`)
+	line := tc.readline()
+	tcompare(t, strings.HasPrefix(line, `OK (TAG "tag")`), true)
+
+	tc.login()
+	tc.cmdno("", "AUTHENTICATE \"PLAIN\"") // Already authenticated.
+	caps = tc.cmdok("CAPABILITY")
+	tcompare(t, caps[len(caps)-1], `"OWNER" "mjl@mox.example"`)
+
+	tcompare(t, tc.cmdok("LISTSCRIPTS"), []string{})
+
+	script0 := "require \"fileinto\";\r\nif header :contains \"subject\" \"test\" {\r\n\tfileinto \"Test\";\r\n}\r\n"
+	tc.cmdok(`HAVESPACE "a" 100`)
+	tc.cmdno("QUOTA/MAXSIZE", `HAVESPACE "a" 1000000`)
+	tc.cmdok("PUTSCRIPT \"a\" {%d+}\r\n%s", len(script0), script0)
+	tc.cmdok(`PUTSCRIPT "b" "keep;"`)
+	tc.cmdno("", `PUTSCRIPT "c" "fileinto \"x\";"`) // Missing require.
+	tc.cmdno("", `PUTSCRIPT "" "keep;"`)            // Empty name.
+	tc.cmdok(`CHECKSCRIPT "discard;"`)
+	tc.cmdno("", `CHECKSCRIPT "bogus;"`)
+	tcompare(t, tc.cmdok("LISTSCRIPTS"), []string{`"a"`, `"b"`})
+
+	tc.cmdok(`SETACTIVE "a"`)
+	tcompare(t, tc.cmdok("LISTSCRIPTS"), []string{`"a" ACTIVE`, `"b"`})
+	tc.cmdok(`SETACTIVE "b"`)
+	tcompare(t, tc.cmdok("LISTSCRIPTS"), []string{`"a"`, `"b" ACTIVE`})
+	tc.cmdno("NONEXISTENT", `SETACTIVE "x"`)
+
+	tcompare(t, tc.cmdok(`GETSCRIPT "a"`), []string{script0})
+	tc.cmdno("NONEXISTENT", `GETSCRIPT "x"`)
+
+	// Replace existing script.
+	tc.cmdok(`PUTSCRIPT "a" "stop;"`)
+	tcompare(t, tc.cmdok(`GETSCRIPT "a"`), []string{"stop;"})
+
+	tc.cmdno("ACTIVE", `DELETESCRIPT "b"`)
+	tc.cmdno("NONEXISTENT", `DELETESCRIPT "x"`)
+	tc.cmdno("ALREADYEXISTS", `RENAMESCRIPT "a" "b"`)
+	tc.cmdno("NONEXISTENT", `RENAMESCRIPT "x" "y"`)
+	tc.cmdok(`RENAMESCRIPT "b" "c"`)
+	tcompare(t, tc.cmdok("LISTSCRIPTS"), []string{`"a"`, `"c" ACTIVE`})
+	tc.cmdok(`SETACTIVE ""`)
+	tc.cmdok(`DELETESCRIPT "c"`)
+	tcompare(t, tc.cmdok("LISTSCRIPTS"), []string{`"a"`})
+
+	// Too large literal closes the connection.
+	tc.writelinef("PUTSCRIPT \"big\" {%d+}", store.SieveScriptMaxSize*2)
+	tc.readresp("BYE", "QUOTA/MAXSIZE")
+	tc.close()
+
+	tc, _ = startConn(t, nil, true, false)
+	tc.login()
+	tcompare(t, tc.cmdok("LISTSCRIPTS"), []string{`"a"`})
+	tc.cmdok("LOGOUT")
+	tc.close()
+}
+
+func TestAuthenticate(t *testing.T) {
+	cleanup := setup(t)
+	defer cleanup()
+
+	// Plain text authentication is refused without TLS.
+	tc, caps := startConn(t, nil, false, false)
+	tcompare(t, caps[1], `"SASL" "CRAM-MD5 SCRAM-SHA-1 SCRAM-SHA-256"`)
+	tc.cmdno("ENCRYPT-NEEDED", `AUTHENTICATE "PLAIN" %q`, base64.StdEncoding.EncodeToString([]byte("\u0000mjl@mox.example\u0000"+password0)))
+	tc.cmdno("", `AUTHENTICATE "BOGUS"`)
+	tc.cmdno("", `AUTHENTICATE "CRAM-MD5" "bogus"`) // Initial response not allowed.
+	tc.cmdno("", "STARTTLS")                        // No TLS config.
+
+	// Aborted authentication.
+	tc.writelinef(`AUTHENTICATE "SCRAM-SHA-256"`)
+	tcompare(t, tc.readline(), `""`)
+	tc.writelinef(`"*"`)
+	tc.readresp("NO", "")
+
+	line := tc.auth(sasl.NewClientSCRAMSHA256("mjl@mox.example", "bad", false))
+	tcompare(t, strings.HasPrefix(line, "NO"), true)
+	tc.close()
+
+	for _, client := range []sasl.Client{
+		sasl.NewClientCRAMMD5("mjl@mox.example", password0),
+		sasl.NewClientSCRAMSHA1("mjl@mox.example", password0, false),
+		sasl.NewClientSCRAMSHA256("mjl@mox.example", password0, false),
+	} {
+		tc, _ := startConn(t, nil, false, false)
+		line := tc.auth(client)
+		if !strings.HasPrefix(line, "OK") {
+			t.Fatalf("auth failed: %q", line)
+		}
+		tc.cmdok("LISTSCRIPTS")
+		tc.close()
+	}
+
+	// Disabled login.
+	tc, _ = startConn(t, nil, true, false)
+	line = tc.auth(sasl.NewClientPlain("disabled@mox.example", "test"))
+	tcompare(t, strings.HasPrefix(line, "NO"), true)
+	tc.close()
+
+	// With STARTTLS, PLAIN and channel binding are allowed.
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{fakeCert(t)}}
+	tc, caps = startConn(t, tlsConfig, false, false)
+	tcompare(t, caps[len(caps)-1], `"VERSION" "1.0"`)
+	tcompare(t, caps[2], `"STARTTLS"`)
+	tc.cmdok("STARTTLS")
+	tlsConn := tls.Client(tc.conn, &tls.Config{InsecureSkipVerify: true})
+	tc.conn = tlsConn
+	tc.br = bufio.NewReader(tlsConn)
+	caps = tc.readresp("OK", "")
+	tcompare(t, caps[1], `"SASL" "PLAIN CRAM-MD5 SCRAM-SHA-1 SCRAM-SHA-256 SCRAM-SHA-1-PLUS SCRAM-SHA-256-PLUS"`)
+	tc.cmdno("", "STARTTLS")
+	cs := tlsConn.ConnectionState()
+	line = tc.auth(sasl.NewClientSCRAMSHA256PLUS("mjl@mox.example", password0, cs))
+	tcompare(t, strings.HasPrefix(line, "OK"), true)
+	tc.close()
+
+	// With plaintext authentication disabled, only SCRAM is allowed.
+	tc, caps = startConn(t, nil, true, true)
+	tcompare(t, caps[1], `"SASL" "SCRAM-SHA-1 SCRAM-SHA-256"`)
+	tc.cmdno("", `AUTHENTICATE "PLAIN" %q`, base64.StdEncoding.EncodeToString([]byte("\u0000mjl@mox.example\u0000"+password0)))
+	tc.cmdno("", `AUTHENTICATE "CRAM-MD5"`)
+	line = tc.auth(sasl.NewClientSCRAMSHA256("mjl@mox.example", password0, false))
+	tcompare(t, strings.HasPrefix(line, "OK"), true)
+	tc.close()
+}
+
+func fakeCert(t *testing.T) tls.Certificate {
+	privKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)) // Fake key, don't use this for real!
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1), // Required field...
+	}
+	localCertBuf, err := x509.CreateCertificate(cryptorand.Reader, template, template, privKey.Public(), privKey)
+	tcheck(t, err, "making certificate")
+	cert, err := x509.ParseCertificate(localCertBuf)
+	tcheck(t, err, "parsing generated certificate")
+	return tls.Certificate{
+		Certificate: [][]byte{localCertBuf},
+		PrivateKey:  privKey,
+		Leaf:        cert,
+	}
+}
@@ -0,0 +1,47 @@
+package message
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// FuzzParse parses and walks messages, reading all parts, which must not panic.
+// The seed corpus includes the messages from testdata.
+func FuzzParse(f *testing.F) {
+	f.Add([]byte(""))
+	f.Add([]byte("Subject: test\r\n\r\nbody\r\n"))
+	f.Add([]byte("Content-Type: multipart/mixed; boundary=x\r\n\r\n--x\r\nContent-Type: message/rfc822\r\n\r\nSubject: inner\r\n\r\ninner body\r\n--x\r\nContent-Transfer-Encoding: base64\r\n\r\naGk=\r\n--x--\r\n"))
+	f.Add([]byte("Content-Type: text/plain; charset=\"iso-8859-1\"\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nh=E9llo=\r\n\r\n"))
+	for _, dir := range []string{"../testdata/message", "../testdata/importtest.maildir/cur", "../testdata/importtest.maildir/new"} {
+		names, _ := filepath.Glob(filepath.Join(dir, "*"))
+		for _, name := range names {
+			if buf, err := os.ReadFile(name); err == nil {
+				f.Add(bytes.ReplaceAll(buf, []byte("\n"), []byte("\r\n")))
+			}
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		for _, strict := range []bool{false, true} {
+			p, _ := EnsurePart(pkglog.Logger, strict, bytes.NewReader(buf), int64(len(buf)))
+			fuzzReadPart(&p)
+		}
+	})
+}
+
+func fuzzReadPart(p *Part) {
+	p.Header()
+	p.DispositionFilename()
+	io.Copy(io.Discard, p.Reader())
+	io.Copy(io.Discard, p.RawReader())
+	if p.Message != nil {
+		p.SetMessageReaderAt()
+		fuzzReadPart(p.Message)
+	}
+	for i := range p.Parts {
+		fuzzReadPart(&p.Parts[i])
+	}
+}
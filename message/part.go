@@ -47,11 +47,42 @@ var (
 	errBareLF                 = errors.New("invalid bare line feed")
 	errBareCR                 = errors.New("invalid bare carriage return")
 	errUnexpectedEOF          = errors.New("unexpected eof")
+	errTooManyHeaderFields    = fmt.Errorf("%w: too many header fields", ErrLimitExceeded)
+	errHeaderTooLarge         = fmt.Errorf("%w: header too large", ErrLimitExceeded)
+	errTooDeep                = fmt.Errorf("%w: parts nested too deeply", ErrLimitExceeded)
+	errTooManyParts           = fmt.Errorf("%w: too many parts", ErrLimitExceeded)
+	errDecodedTooLarge        = fmt.Errorf("%w: decoded embedded messages too large", ErrLimitExceeded)
 )
 
+// ErrLimitExceeded is wrapped by errors for messages that exceed one of the
+// parsing limits, like MaxParts.
+var ErrLimitExceeded = errors.New("message exceeds parsing limits")
+
 // If set, during tests, attempts to reparse a part will cause an error, because sequentially reading parts should not lead to reparsing.
 var enforceSequential bool
 
+// Default limits for parsing messages.
+const (
+	DefaultMaxHeaderFields = 1000
+	DefaultMaxHeaderSize   = 1024 * 1024
+	DefaultMaxDepth        = 50
+	DefaultMaxParts        = 10000
+	DefaultMaxDecodedSize  = 100 * 1024 * 1024
+)
+
+// Limits for parsing messages, protecting against excessive resource use for
+// malicious messages. Legitimate messages are well within these limits. Messages
+// exceeding the limits fail to parse with an error wrapping ErrLimitExceeded,
+// EnsurePart treats them as a single application/octet-stream part. Can be
+// changed through the configuration file.
+var (
+	MaxHeaderFields = DefaultMaxHeaderFields // Per part header.
+	MaxHeaderSize   = DefaultMaxHeaderSize   // Per part header, in bytes.
+	MaxDepth        = DefaultMaxDepth        // Of nested multiparts and embedded messages.
+	MaxParts        = DefaultMaxParts        // Total, including parts of embedded messages.
+	MaxDecodedSize  = DefaultMaxDecodedSize  // Total of decoded embedded messages held in memory while parsing, in bytes.
+)
+
 // Part represents a whole mail message, or a part of a multipart message. It
 // is designed to handle IMAP requirements efficiently.
 type Part struct {
@@ -88,6 +119,15 @@ type Part struct {
 	parent          *Part                // Parent part, for getting bound from, and setting nextBoundOffset when a part has finished reading. Only for subparts, not top-level parts.
 	bound           []byte               // Only set if valid multipart with boundary, includes leading --, excludes \r\n.
 	strict          bool                 // If set, valid crlf line endings are verified when reading body.
+	depth           int                  // Nesting of this part, 0 for top-level message.
+	counts          *parseCounts         // Shared for all parts of a top-level message, for enforcing limits.
+}
+
+// parseCounts keeps track of resources used while parsing a message, for
+// enforcing the limits.
+type parseCounts struct {
+	parts   int   // Number of parts parsed, for MaxParts.
+	decoded int64 // Size of decoded embedded messages read into memory, for MaxDecodedSize.
 }
 
 // todo: have all Content* fields in Part?
@@ -188,9 +228,15 @@ func (p *Part) SetReaderAt(r io.ReaderAt) {
 	}
 }
 
-// SetMessageReaderAt sets a reader on p.Message, which must be non-nil.
+// SetMessageReaderAt sets a reader on p.Message, which must be non-nil. If the
+// embedded message is stored as is, without base64 or quoted-printable
+// content-transfer-encoding, the reader reads from the reader of p. Otherwise the
+// embedded message is decoded and held in memory.
 func (p *Part) SetMessageReaderAt() error {
-	// todo: if p.Message does not contain any non-identity content-transfer-encoding, we should set an offsetReader of p.Message, recursively.
+	if p.identityEncoded() && p.EndOffset >= 0 {
+		p.Message.SetReaderAt(io.NewSectionReader(p.r, p.BodyOffset, p.EndOffset-p.BodyOffset))
+		return nil
+	}
 	buf, err := io.ReadAll(p.Reader())
 	if err != nil {
 		return err
@@ -205,22 +251,45 @@ func (p *Part) Walk(elog *slog.Logger, parent *Part) error {
 
 	if len(p.bound) == 0 {
 		if p.MediaType == "MESSAGE" && (p.MediaSubType == "RFC822" || p.MediaSubType == "GLOBAL") {
-			// todo: don't read whole submessage in memory...
-			buf, err := io.ReadAll(p.Reader())
-			if err != nil {
-				return err
+			if p.depth+1 > MaxDepth {
+				return errTooDeep
+			}
+			if p.counts == nil {
+				p.counts = &parseCounts{}
+			}
+			// If the embedded message is stored as is, we parse it in place. Otherwise we
+			// decode it in memory.
+			var br io.ReaderAt
+			var size int64
+			if p.identityEncoded() {
+				n, err := io.Copy(io.Discard, p.Reader())
+				if err != nil {
+					return err
+				}
+				br, size = io.NewSectionReader(p.r, p.BodyOffset, n), n
+			} else {
+				buf, err := io.ReadAll(io.LimitReader(p.Reader(), int64(MaxDecodedSize)-p.counts.decoded+1))
+				if err != nil {
+					return err
+				}
+				p.counts.decoded += int64(len(buf))
+				if p.counts.decoded > int64(MaxDecodedSize) {
+					return errDecodedTooLarge
+				}
+				br, size = bytes.NewReader(buf), int64(len(buf))
 			}
-			br := bytes.NewReader(buf)
 			mp, err := Parse(log.Logger, p.strict, br)
 			if err != nil {
 				return fmt.Errorf("parsing embedded message: %w", err)
 			}
+			mp.depth = p.depth + 1
+			mp.counts = p.counts
 			if err := mp.Walk(log.Logger, nil); err != nil {
 				// If this is a DSN and we are not in pedantic mode, accept unexpected end of
 				// message. This is quite common because MTA's sometimes just truncate the original
 				// message in a place that makes the message invalid.
 				if errors.Is(err, errUnexpectedEOF) && !Pedantic && parent != nil && len(parent.Parts) >= 3 && p == &parent.Parts[2] && parent.MediaType == "MULTIPART" && parent.MediaSubType == "REPORT" {
-					mp, err = fallbackPart(mp, br, int64(len(buf)))
+					mp, err = fallbackPart(mp, br, size)
 					if err != nil {
 						return fmt.Errorf("parsing invalid embedded message: %w", err)
 					}
@@ -228,7 +297,6 @@ func (p *Part) Walk(elog *slog.Logger, parent *Part) error {
 					return fmt.Errorf("parsing parts of embedded message: %w", err)
 				}
 			}
-			// todo: if mp does not contain any non-identity content-transfer-encoding, we should set an offsetReader of p.r on mp, recursively.
 			p.Message = &mp
 			return nil
 		}
@@ -269,6 +337,12 @@ func newPart(log mlog.Log, strict bool, r io.ReaderAt, offset int64, parent *Par
 		parent:         parent,
 		strict:         strict,
 	}
+	if parent != nil {
+		p.depth = parent.depth + 1
+		p.counts = parent.counts
+	} else {
+		p.counts = &parseCounts{}
+	}
 
 	b := &bufAt{strict: strict, r: r, offset: offset}
 
@@ -287,6 +361,7 @@ func newPart(log mlog.Log, strict bool, r io.ReaderAt, offset int64, parent *Par
 	p.HeaderOffset = b.offset
 	p.BodyOffset = b.offset
 	hb := &bytes.Buffer{}
+	var nfields int
 	for {
 		line, _, err := b.ReadLine(true)
 		if err == io.EOF {
@@ -300,6 +375,16 @@ func newPart(log mlog.Log, strict bool, r io.ReaderAt, offset int64, parent *Par
 		if len(line) == 2 {
 			break // crlf
 		}
+		if hb.Len() > MaxHeaderSize {
+			return p, errHeaderTooLarge
+		}
+		// Continuation lines start with whitespace.
+		if len(line) > 0 && line[0] != ' ' && line[0] != '\t' {
+			nfields++
+			if nfields > MaxHeaderFields {
+				return p, errTooManyHeaderFields
+			}
+		}
 	}
 	p.BodyOffset = b.offset
 
@@ -606,6 +691,16 @@ func (p *Part) ParseNextPart(elog *slog.Logger) (*Part, error) {
 	} else if !crlf {
 		return nil, fmt.Errorf("non-finishing bound without crlf: %w", errUnexpectedEOF)
 	}
+	if p.depth+1 > MaxDepth {
+		return nil, errTooDeep
+	}
+	if p.counts == nil {
+		p.counts = &parseCounts{}
+	}
+	if p.counts.parts >= MaxParts {
+		return nil, errTooManyParts
+	}
+	p.counts.parts++
 	boundOffset := p.nextBoundOffset
 	p.lastBoundOffset = boundOffset
 	p.nextBoundOffset = -1
@@ -2,7 +2,10 @@ package message
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -597,6 +600,74 @@ func TestEmbedded2(t *testing.T) {
 	tfail(t, err, nil)
 }
 
+func TestWalkContent(t *testing.T) {
+	const message = `Content-Type: multipart/mixed; boundary=x
+
+--x
+Content-Type: text/plain
+
+text
+--x
+Content-Type: application/octet-stream
+Content-Transfer-Encoding: base64
+
+YXR0YWNobWVudA==
+--x
+Content-Type: message/rfc822
+
+Content-Type: multipart/alternative; boundary=y
+
+--y
+Content-Type: text/plain
+Content-Transfer-Encoding: quoted-printable
+
+embedded=20text
+--y
+Content-Type: text/html
+
+<p>embedded</p>
+--y--
+--x--
+`
+	buf := []byte(strings.ReplaceAll(message, "\n", "\r\n"))
+
+	walk := func(p *Part, stop int) []string {
+		t.Helper()
+		var l []string
+		err := p.WalkContent(func(p *Part, r io.Reader) error {
+			if len(l) == stop {
+				return ErrStopWalk
+			}
+			data, err := io.ReadAll(r)
+			tcheck(t, err, "read content")
+			l = append(l, p.MediaType+"/"+p.MediaSubType+": "+string(data))
+			return nil
+		})
+		tcheck(t, err, "walk content")
+		return l
+	}
+	exp := []string{
+		"TEXT/PLAIN: text",
+		"APPLICATION/OCTET-STREAM: attachment",
+		"TEXT/PLAIN: embedded text",
+		"TEXT/HTML: <p>embedded</p>",
+	}
+
+	p, err := EnsurePart(pkglog.Logger, false, bytes.NewReader(buf), int64(len(buf)))
+	tcheck(t, err, "parse")
+	tcompare(t, walk(&p, -1), exp)
+	tcompare(t, walk(&p, 2), exp[:2])
+
+	// As with a part stored in the database.
+	pbuf, err := json.Marshal(p)
+	tcheck(t, err, "marshal part")
+	var np Part
+	err = json.Unmarshal(pbuf, &np)
+	tcheck(t, err, "unmarshal part")
+	np.SetReaderAt(bytes.NewReader(buf))
+	tcompare(t, walk(&np, -1), exp)
+}
+
 func TestNetMailAddress(t *testing.T) {
 	const s = "From: \" \"@example.com\r\n\r\nbody\r\n"
 	p, err := EnsurePart(pkglog.Logger, false, strings.NewReader(s), int64(len(s)))
@@ -610,3 +681,80 @@ func TestParseQuotedCharset(t *testing.T) {
 	tcheck(t, err, "parse")
 	tcompare(t, p.Envelope.From, []Address{{"Kristýna", "k", "example.com"}})
 }
+
+func TestLimits(t *testing.T) {
+	walkAll := func(msg string) error {
+		p, err := Parse(pkglog.Logger, false, strings.NewReader(msg))
+		if err != nil {
+			return err
+		}
+		return p.Walk(pkglog.Logger, nil)
+	}
+
+	// Header fields, continuation lines are not counted separately.
+	hdr := strings.Repeat("X-Test: a\r\n b\r\n", MaxHeaderFields)
+	tfail(t, walkAll(hdr+"\r\nbody\r\n"), nil)
+	tfail(t, walkAll(hdr+"X-Test: a\r\n\r\nbody\r\n"), errTooManyHeaderFields)
+
+	// Nested multiparts.
+	nested := func(depth int) string {
+		var b strings.Builder
+		b.WriteString("Content-Type: multipart/mixed; boundary=b0\r\n\r\n")
+		for i := 1; i < depth; i++ {
+			fmt.Fprintf(&b, "--b%d\r\nContent-Type: multipart/mixed; boundary=b%d\r\n\r\n", i-1, i)
+		}
+		fmt.Fprintf(&b, "--b%d\r\n\r\ntext\r\n", depth-1)
+		for i := depth - 1; i >= 0; i-- {
+			fmt.Fprintf(&b, "--b%d--\r\n", i)
+		}
+		return b.String()
+	}
+	tfail(t, walkAll(nested(MaxDepth)), nil)
+	tfail(t, walkAll(nested(MaxDepth+1)), errTooDeep)
+
+	// Number of parts.
+	omaxParts := MaxParts
+	defer func() {
+		MaxParts = omaxParts
+	}()
+	MaxParts = 3
+	parts := func(n int) string {
+		return "Content-Type: multipart/mixed; boundary=b\r\n\r\n" + strings.Repeat("--b\r\n\r\ntext\r\n", n) + "--b--\r\n"
+	}
+	tfail(t, walkAll(parts(3)), nil)
+	tfail(t, walkAll(parts(4)), errTooManyParts)
+	tfail(t, walkAll(parts(4)), ErrLimitExceeded)
+
+	// Header size.
+	omaxHeaderSize := MaxHeaderSize
+	defer func() {
+		MaxHeaderSize = omaxHeaderSize
+	}()
+	MaxHeaderSize = 100
+	tfail(t, walkAll("Subject: "+strings.Repeat("a", 50)+"\r\n\r\nbody\r\n"), nil)
+	tfail(t, walkAll("Subject: "+strings.Repeat("a", 100)+"\r\n\r\nbody\r\n"), errHeaderTooLarge)
+
+	// Size of decoded embedded messages, counted for all nesting levels. Only
+	// embedded messages with base64 or quoted-printable encoding are decoded in
+	// memory, others are parsed in place.
+	omaxDecodedSize := MaxDecodedSize
+	defer func() {
+		MaxDecodedSize = omaxDecodedSize
+	}()
+	encodeEmbedded := func(s string) string {
+		return "Content-Type: message/rfc822\r\nContent-Transfer-Encoding: base64\r\n\r\n" + base64.StdEncoding.EncodeToString([]byte(s)) + "\r\n"
+	}
+	inner := "Subject: test\r\n\r\n" + strings.Repeat("a", 25) + "\r\n" // 44 bytes decoded.
+	embedded := encodeEmbedded(inner)
+	MaxDecodedSize = len(embedded) + len(inner) - 1
+	tfail(t, walkAll(embedded), nil)
+	tfail(t, walkAll(encodeEmbedded(embedded)), errDecodedTooLarge)
+	MaxDecodedSize = 10
+	tfail(t, walkAll("Content-Type: message/rfc822\r\n\r\nContent-Type: message/rfc822\r\n\r\n"+inner), nil)
+
+	// EnsurePart falls back to a single part.
+	msg := parts(4)
+	p, err := EnsurePart(pkglog.Logger, false, strings.NewReader(msg), int64(len(msg)))
+	tfail(t, err, errTooManyParts)
+	tcompare(t, p.MediaType+"/"+p.MediaSubType, "APPLICATION/OCTET-STREAM")
+}
@@ -0,0 +1,59 @@
+package message
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrStopWalk can be returned by a ContentFunc to stop WalkContent without
+// returning an error.
+var ErrStopWalk = errors.New("stop walk")
+
+// ContentFunc is called by WalkContent for each part with content. Reading from r
+// returns the decoded content of part p, streamed from the underlying message. The
+// function does not have to read r.
+type ContentFunc func(p *Part, r io.Reader) error
+
+// WalkContent calls fn for each part with content, i.e. each non-multipart part,
+// in order, descending into multiparts and embedded messages (e.g. forwarded
+// messages, or the original message in DSNs). The part must have been parsed
+// with Walk, or must have a reader set with SetReaderAt.
+//
+// Content is streamed from the underlying reader, and is not held in memory,
+// also for very large parts. Only embedded messages with a content-transfer-encoding
+// like base64, which is rare, are decoded in memory, see SetMessageReaderAt.
+//
+// An error from fn stops the walk and is returned, except ErrStopWalk, for which
+// nil is returned.
+func (p *Part) WalkContent(fn ContentFunc) error {
+	err := p.walkContent(fn)
+	if err == ErrStopWalk {
+		return nil
+	}
+	return err
+}
+
+func (p *Part) walkContent(fn ContentFunc) error {
+	if p.Message != nil {
+		if err := p.SetMessageReaderAt(); err != nil {
+			return err
+		}
+		return p.Message.walkContent(fn)
+	}
+	if p.MediaType != "MULTIPART" {
+		return fn(p, p.Reader())
+	}
+	for i := range p.Parts {
+		if err := p.Parts[i].walkContent(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// identityEncoded returns whether the decoded content of the part is identical to
+// its raw content, i.e. it has no base64 or quoted-printable
+// content-transfer-encoding.
+func (p *Part) identityEncoded() bool {
+	return p.ContentTransferEncoding == nil || *p.ContentTransferEncoding != "BASE64" && *p.ContentTransferEncoding != "QUOTED-PRINTABLE"
+}
@@ -20,41 +20,55 @@ var metricPanic = promauto.NewCounterVec(
 type Panic string
 
 const (
-	Ctl              Panic = "ctl"
-	Import           Panic = "import"
-	Serve            Panic = "serve"
-	Imapserver       Panic = "imapserver"
-	Dmarcdb          Panic = "dmarcdb"
-	Mtastsdb         Panic = "mtastsdb"
-	Queue            Panic = "queue"
-	Smtpclient       Panic = "smtpclient"
-	Smtpserver       Panic = "smtpserver"
-	Tlsrptdb         Panic = "tlsrptdb"
-	Dkimverify       Panic = "dkimverify"
-	Spfverify        Panic = "spfverify"
-	Upgradethreads   Panic = "upgradethreads"
-	Importmanage     Panic = "importmanage"
-	Importmessages   Panic = "importmessages"
-	Store            Panic = "store"
-	Webadmin         Panic = "webadmin"
-	Webapi           Panic = "webapi"
-	Webmailsendevent Panic = "webmailsendevent"
-	Webmail          Panic = "webmail"
-	Webmailrequest   Panic = "webmailrequest"
-	Webmailquery     Panic = "webmailquery"
-	Webmailhandle    Panic = "webmailhandle"
+	Admin             Panic = "admin"
+	Autotls           Panic = "autotls"
+	Backup            Panic = "backup"
+	Canary            Panic = "canary"
+	Ctl               Panic = "ctl"
+	Import            Panic = "import"
+	Serve             Panic = "serve"
+	Imapserver        Panic = "imapserver"
+	Pop3server        Panic = "pop3server"
+	Managesieveserver Panic = "managesieveserver"
+	Dmarcdb           Panic = "dmarcdb"
+	Mtastsdb          Panic = "mtastsdb"
+	Queue             Panic = "queue"
+	Rejectdb          Panic = "rejectdb"
+	Smtpclient        Panic = "smtpclient"
+	Smtpserver        Panic = "smtpserver"
+	Tlsrptdb          Panic = "tlsrptdb"
+	Dkimverify        Panic = "dkimverify"
+	Spfverify         Panic = "spfverify"
+	Upgradethreads    Panic = "upgradethreads"
+	Importmanage      Panic = "importmanage"
+	Importmessages    Panic = "importmessages"
+	Store             Panic = "store"
+	Webadmin          Panic = "webadmin"
+	Webapi            Panic = "webapi"
+	Webmailsendevent  Panic = "webmailsendevent"
+	Webmail           Panic = "webmail"
+	Webmailrequest    Panic = "webmailrequest"
+	Webmailquery      Panic = "webmailquery"
+	Webmailhandle     Panic = "webmailhandle"
 )
 
 func init() {
 	// Ensure the panic counts are initialized to 0, so the query for change also picks
 	// up the first panic.
 	names := []Panic{
+		Admin,
+		Autotls,
+		Backup,
+		Canary,
 		Ctl,
 		Import,
 		Serve,
 		Imapserver,
+		Pop3server,
+		Managesieveserver,
 		Mtastsdb,
 		Queue,
+		Rejectdb,
 		Smtpclient,
 		Smtpserver,
 		Dkimverify,
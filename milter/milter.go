@@ -0,0 +1,735 @@
+// Package milter implements the MTA side of the milter protocol, for letting
+// external mail filters inspect and modify incoming messages during an SMTP
+// transaction.
+//
+// The milter protocol originates in sendmail, and is also implemented by postfix.
+// Filters like rspamd, clamav-milter and opendkim speak it. There is no formal
+// specification, this package implements version 6 of the protocol as used by
+// libmilter.
+//
+// A milter is called at stages of the SMTP transaction: connect, HELO, MAIL FROM,
+// RCPT TO and after the message has been received. At each stage, the milter can
+// continue, accept, reject, temporarily fail or discard. After the message, a
+// milter can also quarantine the message, add, insert, change or delete header
+// fields, and replace the message body. Changes to the envelope sender and
+// recipients are not supported, and not offered to milters during negotiation.
+package milter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+var (
+	metricCommand = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_milter_command_duration_seconds",
+			Help:    "Duration of milter commands, with resulting action.",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.100, 0.5, 1, 5, 10, 20, 30},
+		},
+		[]string{
+			"stage",  // connect, helo, mail, rcpt, message
+			"action", // continue, accept, reject, tempfail, discard, error
+		},
+	)
+)
+
+// Commands sent to the milter.
+const (
+	cmdAbort   = 'A'
+	cmdBody    = 'B'
+	cmdConnect = 'C'
+	cmdMacro   = 'D'
+	cmdBodyEOB = 'E'
+	cmdHelo    = 'H'
+	cmdHeader  = 'L'
+	cmdMail    = 'M'
+	cmdEOH     = 'N'
+	cmdOptneg  = 'O'
+	cmdQuit    = 'Q'
+	cmdRcpt    = 'R'
+	cmdData    = 'T'
+)
+
+// Responses from the milter.
+const (
+	respAddRcpt    = '+'
+	respDelRcpt    = '-'
+	respAccept     = 'a'
+	respReplBody   = 'b'
+	respContinue   = 'c'
+	respDiscard    = 'd'
+	respChgFrom    = 'e'
+	respAddHeader  = 'h'
+	respInsHeader  = 'i'
+	respChgHeader  = 'm'
+	respProgress   = 'p'
+	respQuarantine = 'q'
+	respReject     = 'r'
+	respSkip       = 's'
+	respTempfail   = 't'
+	respReplyCode  = 'y'
+	respOptneg     = 'O'
+)
+
+// Actions the milter may take, we only offer these.
+const (
+	actAddHeaders = 0x01
+	actChgBody    = 0x02
+	actChgHeaders = 0x10
+	actQuarantine = 0x20
+
+	actOffered = actAddHeaders | actChgBody | actChgHeaders | actQuarantine
+)
+
+// Protocol flags, for steps the milter doesn't want to see, or doesn't reply to.
+const (
+	protoNoConnect = 0x01
+	protoNoHelo    = 0x02
+	protoNoMail    = 0x04
+	protoNoRcpt    = 0x08
+	protoNoBody    = 0x10
+	protoNoHeaders = 0x20
+	protoNoEOH     = 0x40
+	protoNRHeader  = 0x80
+	protoNoUnknown = 0x100
+	protoNoData    = 0x200
+	protoSkip      = 0x400
+	protoNRConnect = 0x1000
+	protoNRHelo    = 0x2000
+	protoNRMail    = 0x4000
+	protoNRRcpt    = 0x8000
+	protoNRData    = 0x10000
+	protoNRUnknown = 0x20000
+	protoNREOH     = 0x40000
+	protoNRBody    = 0x80000
+
+	protoOffered = protoNoConnect | protoNoHelo | protoNoMail | protoNoRcpt | protoNoBody | protoNoHeaders | protoNoEOH | protoNRHeader | protoNoUnknown | protoNoData | protoSkip | protoNRConnect | protoNRHelo | protoNRMail | protoNRRcpt | protoNRData | protoNRUnknown | protoNREOH | protoNRBody
+)
+
+const (
+	version      = 6
+	maxPacket    = 1 << 20 // Larger than the 64KB chunks milters send.
+	bodyChunk    = 65535
+	maxReplyText = 200
+)
+
+// ErrProtocol indicates the milter sent an invalid or unexpected response.
+var ErrProtocol = errors.New("milter protocol error")
+
+// Action is the decision of the milter at a stage.
+type Action string
+
+const (
+	// Continue processing as usual, the milter wants to see the next stages.
+	ActionContinue Action = "continue"
+
+	// Accept the connection (at stages connect and helo) or message, the milter is
+	// not called anymore for the connection or message. At stage rcpt, only the
+	// recipient is accepted.
+	ActionAccept Action = "accept"
+
+	// Reject with a permanent error. At stage rcpt, only the recipient is rejected.
+	ActionReject Action = "reject"
+
+	// Reject with a temporary error. At stage rcpt, only the recipient is rejected.
+	ActionTempfail Action = "tempfail"
+
+	// Accept the message, but silently drop it.
+	ActionDiscard Action = "discard"
+
+	// The milter doesn't want to see more of the message body. Only used internally.
+	actionSkip Action = "skip"
+)
+
+// Response is a decision of the milter.
+type Response struct {
+	Action Action
+
+	// For reject and tempfail, optional custom SMTP response set by the milter. Code
+	// is zero if not set. EnhancedCode, e.g. "5.7.1", and Message can be empty.
+	Code         int
+	EnhancedCode string
+	Message      string
+}
+
+// Secode returns the enhanced code without the leading class, as used by the
+// smtp package.
+func (r Response) Secode() string {
+	_, s, _ := strings.Cut(r.EnhancedCode, ".")
+	return s
+}
+
+// ChangeKind is the kind of message modification requested by a milter.
+type ChangeKind string
+
+const (
+	ChangeAddHeader    ChangeKind = "addheader"    // Add header field at the end of the header.
+	ChangeInsertHeader ChangeKind = "insertheader" // Insert header field at Index, 0 is first.
+	ChangeHeader       ChangeKind = "changeheader" // Change the Index'th (starting at 1) field with Name, or delete it if Value is empty.
+	ChangeBody         ChangeKind = "body"         // Replace body with Body. Multiple changes are concatenated.
+)
+
+// Change is a modification of the message requested by the milter.
+type Change struct {
+	Kind  ChangeKind
+	Index int
+	Name  string
+	Value string // With "\n" line endings for multi-line values.
+	Body  []byte
+}
+
+// MessageResult is the result of the milter evaluating a message.
+type MessageResult struct {
+	Response
+
+	// Whether the milter requested to quarantine the message, with reason.
+	Quarantine       bool
+	QuarantineReason string
+
+	// Modifications to apply to the message, in order.
+	Changes []Change
+}
+
+// Client is a connection to a milter.
+type Client struct {
+	log      mlog.Log
+	conn     net.Conn
+	br       *bufio.Reader
+	timeout  time.Duration
+	actions  uint32 // Negotiated actions the milter may take.
+	protocol uint32 // Negotiated protocol flags.
+}
+
+// Dial connects to a milter at address, with network "unix" or "tcp", and
+// negotiates the protocol options.
+func Dial(log mlog.Log, network, address string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial milter: %v", err)
+	}
+	c, err := NewClient(log, conn, timeout)
+	if err != nil {
+		err := conn.Close()
+		log.Check(err, "closing milter connection after negotiation error")
+	}
+	return c, err
+}
+
+// NewClient negotiates protocol options with the milter on conn and returns a
+// client. Timeout applies to each write and response.
+func NewClient(log mlog.Log, conn net.Conn, timeout time.Duration) (*Client, error) {
+	c := &Client{log: log, conn: conn, br: bufio.NewReader(conn), timeout: timeout}
+
+	var buf []byte
+	buf = binary.BigEndian.AppendUint32(buf, version)
+	buf = binary.BigEndian.AppendUint32(buf, actOffered)
+	buf = binary.BigEndian.AppendUint32(buf, protoOffered)
+	if err := c.write(cmdOptneg, buf); err != nil {
+		return nil, err
+	}
+	cmd, data, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	// Milters can append the macros they want to see, we ignore them.
+	if cmd != respOptneg || len(data) < 12 {
+		return nil, fmt.Errorf("%w: unexpected negotiation response %q", ErrProtocol, cmd)
+	}
+	v := binary.BigEndian.Uint32(data[0:4])
+	if v < 2 || v > version {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrProtocol, v)
+	}
+	c.actions = binary.BigEndian.Uint32(data[4:8])
+	c.protocol = binary.BigEndian.Uint32(data[8:12])
+	if c.actions&^actOffered != 0 || c.protocol&^protoOffered != 0 {
+		log.Debug("milter requested unsupported actions or protocol flags, ignoring",
+			slog.Any("actions", fmt.Sprintf("%#x", c.actions)),
+			slog.Any("protocol", fmt.Sprintf("%#x", c.protocol)))
+		c.actions &= actOffered
+		c.protocol &= protoOffered
+	}
+	return c, nil
+}
+
+// Close sends a quit command to the milter and closes the connection.
+func (c *Client) Close() error {
+	err := c.write(cmdQuit, nil)
+	c.log.Check(err, "writing quit to milter")
+	return c.conn.Close()
+}
+
+// Abort tells the milter the current message transaction was aborted, e.g. by
+// an SMTP RSET. The connection stays usable for a next transaction.
+func (c *Client) Abort() error {
+	return c.write(cmdAbort, nil)
+}
+
+// Connect sends details of the SMTP connection. Hostname is the name of the
+// remote, or an IP address literal like "[192.0.2.1]".
+func (c *Client) Connect(hostname string, ip net.IP, port int, macros map[string]string) (Response, error) {
+	family := byte('4')
+	if ip.To4() == nil {
+		family = '6'
+	}
+	var buf []byte
+	buf = append(buf, hostname...)
+	buf = append(buf, 0, family)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(port))
+	buf = append(buf, ip.String()...)
+	buf = append(buf, 0)
+	return c.command("connect", cmdConnect, protoNoConnect, protoNRConnect, macros, buf)
+}
+
+// Helo sends the name from the EHLO or HELO command.
+func (c *Client) Helo(name string, macros map[string]string) (Response, error) {
+	return c.command("helo", cmdHelo, protoNoHelo, protoNRHelo, macros, cstrings(name))
+}
+
+// Mail sends the MAIL FROM address, without angle brackets, and optional
+// parameters like "BODY=8BITMIME".
+func (c *Client) Mail(from string, params []string, macros map[string]string) (Response, error) {
+	return c.command("mail", cmdMail, protoNoMail, protoNRMail, macros, cstrings(append([]string{"<" + from + ">"}, params...)...))
+}
+
+// Rcpt sends a RCPT TO address, without angle brackets, and optional parameters.
+func (c *Client) Rcpt(to string, params []string, macros map[string]string) (Response, error) {
+	return c.command("rcpt", cmdRcpt, protoNoRcpt, protoNRRcpt, macros, cstrings(append([]string{"<" + to + ">"}, params...)...))
+}
+
+// Message sends the message in msg, which must have CRLF line endings, and
+// returns the decision of the milter, with requested modifications. Macros are
+// sent with the end of message, the milter sees them for all message stages.
+func (c *Client) Message(msg io.Reader, macros map[string]string) (result MessageResult, rerr error) {
+	start := time.Now()
+	defer func() {
+		action := string(result.Action)
+		if rerr != nil {
+			action = "error"
+		}
+		metricCommand.WithLabelValues("message", action).Observe(float64(time.Since(start)) / float64(time.Second))
+		c.log.Debugx("milter message result", rerr,
+			slog.String("action", string(result.Action)),
+			slog.Int("changes", len(result.Changes)),
+			slog.Bool("quarantine", result.Quarantine),
+			slog.Duration("duration", time.Since(start)))
+	}()
+
+	resp, err := c.step(cmdData, protoNoData, protoNRData, nil, nil)
+	if err != nil || resp.Action != ActionContinue {
+		return MessageResult{Response: resp}, err
+	}
+
+	br := bufio.NewReader(msg)
+	fields, err := readHeader(br)
+	if err != nil {
+		return MessageResult{}, fmt.Errorf("reading message header: %v", err)
+	}
+	for _, f := range fields {
+		if c.protocol&protoNoHeaders != 0 {
+			break
+		}
+		name, value := f.nameValue()
+		resp, err := c.step(cmdHeader, 0, protoNRHeader, nil, cstrings(name, value))
+		if err != nil || resp.Action != ActionContinue {
+			return MessageResult{Response: resp}, err
+		}
+	}
+	resp, err = c.step(cmdEOH, protoNoEOH, protoNREOH, nil, nil)
+	if err != nil || resp.Action != ActionContinue {
+		return MessageResult{Response: resp}, err
+	}
+
+	buf := make([]byte, bodyChunk)
+	for c.protocol&protoNoBody == 0 {
+		n, err := io.ReadFull(br, buf)
+		if n > 0 {
+			resp, xerr := c.step(cmdBody, 0, protoNRBody, nil, buf[:n])
+			if xerr != nil {
+				return MessageResult{}, xerr
+			} else if resp.Action == actionSkip {
+				break
+			} else if resp.Action != ActionContinue {
+				return MessageResult{Response: resp}, nil
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return MessageResult{}, fmt.Errorf("reading message body: %v", err)
+		}
+	}
+
+	if err := c.writeMacros(cmdBodyEOB, macros); err != nil {
+		return MessageResult{}, err
+	}
+	if err := c.write(cmdBodyEOB, nil); err != nil {
+		return MessageResult{}, err
+	}
+	return c.readEOB()
+}
+
+// command sends a command for a connection or envelope stage and returns the
+// response.
+func (c *Client) command(stage string, cmd byte, noFlag, noReplyFlag uint32, macros map[string]string, data []byte) (resp Response, rerr error) {
+	start := time.Now()
+	defer func() {
+		action := string(resp.Action)
+		if rerr != nil {
+			action = "error"
+		}
+		metricCommand.WithLabelValues(stage, action).Observe(float64(time.Since(start)) / float64(time.Second))
+		c.log.Debugx("milter result", rerr,
+			slog.String("stage", stage),
+			slog.String("action", string(resp.Action)),
+			slog.Duration("duration", time.Since(start)))
+	}()
+	resp, rerr = c.step(cmd, noFlag, noReplyFlag, macros, data)
+	if rerr == nil && resp.Action == actionSkip {
+		return Response{}, fmt.Errorf("%w: unexpected skip response", ErrProtocol)
+	}
+	return
+}
+
+// step sends a command, unless the milter negotiated it shouldn't be sent, and
+// reads the response, unless the milter negotiated it won't reply.
+func (c *Client) step(cmd byte, noFlag, noReplyFlag uint32, macros map[string]string, data []byte) (Response, error) {
+	if c.protocol&noFlag != 0 {
+		return Response{Action: ActionContinue}, nil
+	}
+	if err := c.writeMacros(cmd, macros); err != nil {
+		return Response{}, err
+	}
+	if err := c.write(cmd, data); err != nil {
+		return Response{}, err
+	}
+	if c.protocol&noReplyFlag != 0 {
+		return Response{Action: ActionContinue}, nil
+	}
+	for {
+		rcmd, rdata, err := c.read()
+		if err != nil {
+			return Response{}, err
+		}
+		if rcmd == respProgress {
+			continue
+		}
+		return parseResponse(rcmd, rdata)
+	}
+}
+
+// readEOB reads the modifications and final response after the end of the
+// message.
+func (c *Client) readEOB() (MessageResult, error) {
+	var r MessageResult
+	for {
+		cmd, data, err := c.read()
+		if err != nil {
+			return MessageResult{}, err
+		}
+		switch cmd {
+		case respProgress:
+		case respAddHeader, respInsHeader, respChgHeader:
+			var index int
+			if cmd != respAddHeader {
+				if len(data) < 4 {
+					return MessageResult{}, fmt.Errorf("%w: short header modification", ErrProtocol)
+				}
+				index = int(binary.BigEndian.Uint32(data[:4]))
+				data = data[4:]
+			}
+			t := splitCStrings(data)
+			if len(t) != 2 || t[0] == "" {
+				return MessageResult{}, fmt.Errorf("%w: invalid header modification", ErrProtocol)
+			}
+			if c.actions&actAddHeaders == 0 && cmd != respChgHeader || c.actions&actChgHeaders == 0 && cmd == respChgHeader {
+				return MessageResult{}, fmt.Errorf("%w: header modification not negotiated", ErrProtocol)
+			}
+			kind := map[byte]ChangeKind{respAddHeader: ChangeAddHeader, respInsHeader: ChangeInsertHeader, respChgHeader: ChangeHeader}[cmd]
+			r.Changes = append(r.Changes, Change{Kind: kind, Index: index, Name: t[0], Value: t[1]})
+		case respReplBody:
+			if c.actions&actChgBody == 0 {
+				return MessageResult{}, fmt.Errorf("%w: body replacement not negotiated", ErrProtocol)
+			}
+			r.Changes = append(r.Changes, Change{Kind: ChangeBody, Body: data})
+		case respQuarantine:
+			if c.actions&actQuarantine == 0 {
+				return MessageResult{}, fmt.Errorf("%w: quarantine not negotiated", ErrProtocol)
+			}
+			r.Quarantine = true
+			r.QuarantineReason = strings.TrimRight(string(data), "\x00")
+		case respAddRcpt, respDelRcpt, respChgFrom:
+			return MessageResult{}, fmt.Errorf("%w: envelope modification %q not supported", ErrProtocol, cmd)
+		default:
+			resp, err := parseResponse(cmd, data)
+			if err != nil {
+				return MessageResult{}, err
+			} else if resp.Action == actionSkip {
+				return MessageResult{}, fmt.Errorf("%w: unexpected skip response", ErrProtocol)
+			}
+			r.Response = resp
+			return r, nil
+		}
+	}
+}
+
+// parseResponse parses a response with a decision.
+func parseResponse(cmd byte, data []byte) (Response, error) {
+	switch cmd {
+	case respContinue:
+		return Response{Action: ActionContinue}, nil
+	case respAccept:
+		return Response{Action: ActionAccept}, nil
+	case respReject:
+		return Response{Action: ActionReject}, nil
+	case respTempfail:
+		return Response{Action: ActionTempfail}, nil
+	case respDiscard:
+		return Response{Action: ActionDiscard}, nil
+	case respSkip:
+		return Response{Action: actionSkip}, nil
+	case respReplyCode:
+		return parseReplyCode(strings.TrimRight(string(data), "\x00"))
+	}
+	return Response{}, fmt.Errorf("%w: unexpected response %q", ErrProtocol, cmd)
+}
+
+// parseReplyCode parses a custom SMTP response like "550 5.7.1 Spam". Only the
+// first line of multi-line responses is used.
+func parseReplyCode(s string) (Response, error) {
+	s, _, _ = strings.Cut(s, "\n")
+	s = strings.TrimSuffix(s, "\r")
+	if len(s) < 3 {
+		return Response{}, fmt.Errorf("%w: invalid reply code %q", ErrProtocol, s)
+	}
+	code, err := strconv.Atoi(s[:3])
+	if err != nil || code/100 != 4 && code/100 != 5 {
+		return Response{}, fmt.Errorf("%w: invalid reply code %q", ErrProtocol, s)
+	}
+	resp := Response{Action: ActionReject, Code: code}
+	if code/100 == 4 {
+		resp.Action = ActionTempfail
+	}
+	s = strings.TrimLeft(s[3:], " -")
+	if t := strings.SplitN(s, " ", 2); validEnhancedCode(t[0], code/100) {
+		resp.EnhancedCode = t[0]
+		s = ""
+		if len(t) == 2 {
+			s = t[1]
+		}
+	}
+	msg := []byte(s)
+	for i, b := range msg {
+		if b < ' ' || b >= 0x7f {
+			msg[i] = ' '
+		}
+	}
+	if len(msg) > maxReplyText {
+		msg = msg[:maxReplyText]
+	}
+	resp.Message = string(msg)
+	return resp, nil
+}
+
+// validEnhancedCode returns whether s is a valid enhanced status code with the
+// given class. ../rfc/3463:119
+func validEnhancedCode(s string, class int) bool {
+	t := strings.Split(s, ".")
+	if len(t) != 3 || t[0] != strconv.Itoa(class) {
+		return false
+	}
+	for _, e := range t[1:] {
+		if e == "" || len(e) > 3 || strings.Trim(e, "0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Client) writeMacros(cmd byte, macros map[string]string) error {
+	if len(macros) == 0 {
+		return nil
+	}
+	buf := []byte{cmd}
+	names := make([]string, 0, len(macros))
+	for name := range macros {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		buf = append(buf, cstrings(name, macros[name])...)
+	}
+	return c.write(cmdMacro, buf)
+}
+
+// write sends a packet: a 4-byte length, command byte and data.
+func (c *Client) write(cmd byte, data []byte) error {
+	buf := binary.BigEndian.AppendUint32(make([]byte, 0, 5+len(data)), uint32(1+len(data)))
+	buf = append(buf, cmd)
+	buf = append(buf, data...)
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return fmt.Errorf("set write deadline: %v", err)
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return fmt.Errorf("write to milter: %v", err)
+	}
+	return nil
+}
+
+// read reads a packet from the milter.
+func (c *Client) read() (byte, []byte, error) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, nil, fmt.Errorf("set read deadline: %v", err)
+	}
+	var lenbuf [4]byte
+	if _, err := io.ReadFull(c.br, lenbuf[:]); err != nil {
+		return 0, nil, fmt.Errorf("read from milter: %v", err)
+	}
+	n := binary.BigEndian.Uint32(lenbuf[:])
+	if n == 0 || n > maxPacket {
+		return 0, nil, fmt.Errorf("%w: invalid packet size %d", ErrProtocol, n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.br, buf); err != nil {
+		return 0, nil, fmt.Errorf("read from milter: %v", err)
+	}
+	return buf[0], buf[1:], nil
+}
+
+// cstrings returns the strings as nul-terminated strings.
+func cstrings(l ...string) []byte {
+	var buf []byte
+	for _, s := range l {
+		buf = append(buf, s...)
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+func splitCStrings(buf []byte) []string {
+	if len(buf) == 0 || buf[len(buf)-1] != 0 {
+		return nil
+	}
+	return strings.Split(string(buf[:len(buf)-1]), "\x00")
+}
+
+// headerField is a header field as read from a message, including the CRLF
+// line endings.
+type headerField []byte
+
+// nameValue returns the name and value for sending to a milter: Leading
+// whitespace of the value and the final line ending are removed, and multi-line
+// values have "\n" line endings, as milters expect.
+func (f headerField) nameValue() (string, string) {
+	s := strings.TrimSuffix(string(f), "\r\n")
+	name, value, _ := strings.Cut(s, ":")
+	value = strings.TrimLeft(value, " \t")
+	return strings.TrimRight(name, " \t"), strings.ReplaceAll(value, "\r\n", "\n")
+}
+
+// readHeader reads the header fields of a message from br, leaving br at the
+// start of the body. The empty line separating header and body is consumed.
+func readHeader(br *bufio.Reader) ([]headerField, error) {
+	var fields []headerField
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return fields, nil
+		} else if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if bytes.Equal(line, []byte("\r\n")) {
+			return fields, nil
+		}
+		if len(fields) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			fields[len(fields)-1] = append(fields[len(fields)-1], line...)
+		} else {
+			fields = append(fields, headerField(line))
+		}
+		if err == io.EOF {
+			return fields, nil
+		}
+	}
+}
+
+// Apply writes the message from msg to w with the changes applied, in order.
+// Header field changes with an index beyond the existing fields add the field at
+// the end, except for deletions, which are ignored.
+func Apply(w io.Writer, msg io.Reader, changes []Change) error {
+	br := bufio.NewReader(msg)
+	fields, err := readHeader(br)
+	if err != nil {
+		return fmt.Errorf("reading message header: %v", err)
+	}
+
+	newField := func(name, value string) headerField {
+		value = strings.ReplaceAll(value, "\r\n", "\n")
+		value = strings.ReplaceAll(value, "\n", "\r\n")
+		if value != "" && value[0] != ' ' && value[0] != '\t' {
+			value = " " + value
+		}
+		return headerField(name + ":" + value + "\r\n")
+	}
+
+	var body []byte
+	var replaceBody bool
+	for _, ch := range changes {
+		switch ch.Kind {
+		case ChangeAddHeader:
+			fields = append(fields, newField(ch.Name, ch.Value))
+		case ChangeInsertHeader:
+			fields = slices.Insert(fields, min(max(ch.Index, 0), len(fields)), newField(ch.Name, ch.Value))
+		case ChangeHeader:
+			var n int
+			i := slices.IndexFunc(fields, func(f headerField) bool {
+				name, _ := f.nameValue()
+				if strings.EqualFold(name, ch.Name) {
+					n++
+				}
+				return n == max(ch.Index, 1)
+			})
+			if i < 0 && ch.Value != "" {
+				fields = append(fields, newField(ch.Name, ch.Value))
+			} else if i >= 0 && ch.Value == "" {
+				fields = slices.Delete(fields, i, i+1)
+			} else if i >= 0 {
+				fields[i] = newField(ch.Name, ch.Value)
+			}
+		case ChangeBody:
+			replaceBody = true
+			body = append(body, ch.Body...)
+		default:
+			return fmt.Errorf("unknown change %q", ch.Kind)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range fields {
+		bw.Write(f)
+	}
+	bw.WriteString("\r\n")
+	if replaceBody {
+		// Milters may replace the body with bare newlines, we store messages with CRLF.
+		body = bytes.ReplaceAll(body, []byte("\r\n"), []byte("\n"))
+		body = bytes.ReplaceAll(body, []byte("\n"), []byte("\r\n"))
+		bw.Write(body)
+	} else if _, err := io.Copy(bw, br); err != nil {
+		return fmt.Errorf("copying message body: %v", err)
+	}
+	return bw.Flush()
+}
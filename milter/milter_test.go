@@ -0,0 +1,280 @@
+package milter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", got, exp)
+	}
+}
+
+// fakeMilter reads packets from conn and writes the responses from respond.
+func fakeMilter(conn net.Conn, respond func(cmd byte, data []byte) [][]byte) {
+	defer conn.Close()
+	for {
+		var lenbuf [4]byte
+		if _, err := io.ReadFull(conn, lenbuf[:]); err != nil {
+			return
+		}
+		buf := make([]byte, binary.BigEndian.Uint32(lenbuf[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		for _, resp := range respond(buf[0], buf[1:]) {
+			var out []byte
+			out = binary.BigEndian.AppendUint32(out, uint32(len(resp)))
+			out = append(out, resp...)
+			if _, err := conn.Write(out); err != nil {
+				return
+			}
+		}
+		if buf[0] == cmdQuit {
+			return
+		}
+	}
+}
+
+func packet(cmd byte, data ...[]byte) []byte {
+	return append([]byte{cmd}, bytes.Join(data, nil)...)
+}
+
+func uint32buf(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func TestClient(t *testing.T) {
+	log := mlog.New("milter", nil)
+
+	var commands []byte
+	var macros []string
+	var headers []string
+	var body []byte
+	respond := func(cmd byte, data []byte) [][]byte {
+		commands = append(commands, cmd)
+		switch cmd {
+		case cmdOptneg:
+			tcompare(t, binary.BigEndian.Uint32(data[0:4]), uint32(version))
+			opts := concat(uint32buf(version), uint32buf(actOffered|0x04), uint32buf(protoNoHelo|protoNRHeader))
+			return [][]byte{packet(respOptneg, opts)}
+		case cmdMacro:
+			macros = append(macros, splitCStrings(data[1:])...)
+			return nil
+		case cmdHeader:
+			headers = append(headers, splitCStrings(data)...)
+			return nil
+		case cmdRcpt:
+			if strings.Contains(string(data), "bad@") {
+				return [][]byte{packet(respReplyCode, []byte("550 5.1.1 no such user\x00"))}
+			}
+		case cmdBody:
+			body = append(body, data...)
+			return [][]byte{packet(respProgress), packet(respContinue)}
+		case cmdBodyEOB:
+			return [][]byte{
+				packet(respAddHeader, cstrings("X-Spam", "yes")),
+				packet(respChgHeader, uint32buf(1), cstrings("Subject", "[spam] test")),
+				packet(respQuarantine, cstrings("suspicious")),
+				packet(respAccept),
+			}
+		case cmdAbort, cmdQuit:
+			return nil
+		}
+		return [][]byte{packet(respContinue)}
+	}
+
+	clientConn, serverConn := net.Pipe()
+	go fakeMilter(serverConn, respond)
+
+	c, err := NewClient(log, clientConn, 5*time.Second)
+	tcheck(t, err, "new client")
+	tcompare(t, c.actions, uint32(actOffered))
+
+	resp, err := c.Connect("[192.0.2.1]", net.ParseIP("192.0.2.1"), 25, map[string]string{"j": "mox.example"})
+	tcheck(t, err, "connect")
+	tcompare(t, resp, Response{Action: ActionContinue})
+
+	// Helo is not sent due to negotiated protocol flags.
+	resp, err = c.Helo("remote.example", nil)
+	tcheck(t, err, "helo")
+	tcompare(t, resp, Response{Action: ActionContinue})
+
+	resp, err = c.Mail("remote@example.org", []string{"BODY=8BITMIME"}, nil)
+	tcheck(t, err, "mail")
+	tcompare(t, resp, Response{Action: ActionContinue})
+
+	resp, err = c.Rcpt("bad@mox.example", nil, nil)
+	tcheck(t, err, "rcpt")
+	tcompare(t, resp, Response{Action: ActionReject, Code: 550, EnhancedCode: "5.1.1", Message: "no such user"})
+
+	resp, err = c.Rcpt("mjl@mox.example", nil, nil)
+	tcheck(t, err, "rcpt")
+	tcompare(t, resp, Response{Action: ActionContinue})
+
+	msg := "From: <remote@example.org>\r\nSubject: test\r\nX-Folded: a\r\n b\r\n\r\nhi\r\n"
+	result, err := c.Message(strings.NewReader(msg), map[string]string{"i": "queueid"})
+	tcheck(t, err, "message")
+	expResult := MessageResult{
+		Response:         Response{Action: ActionAccept},
+		Quarantine:       true,
+		QuarantineReason: "suspicious",
+		Changes: []Change{
+			{Kind: ChangeAddHeader, Name: "X-Spam", Value: "yes"},
+			{Kind: ChangeHeader, Index: 1, Name: "Subject", Value: "[spam] test"},
+		},
+	}
+	tcompare(t, result, expResult)
+
+	tcompare(t, string(commands), "ODCMRRTLLLNBDE")
+	tcompare(t, macros, []string{"j", "mox.example", "i", "queueid"})
+	tcompare(t, headers, []string{"From", "<remote@example.org>", "Subject", "test", "X-Folded", "a\n b"})
+	tcompare(t, string(body), "hi\r\n")
+
+	var out bytes.Buffer
+	err = Apply(&out, strings.NewReader(msg), result.Changes)
+	tcheck(t, err, "apply")
+	tcompare(t, out.String(), "From: <remote@example.org>\r\nSubject: [spam] test\r\nX-Folded: a\r\n b\r\nX-Spam: yes\r\n\r\nhi\r\n")
+
+	err = c.Abort()
+	tcheck(t, err, "abort")
+	err = c.Close()
+	tcheck(t, err, "close")
+}
+
+func concat(l ...[]byte) []byte {
+	return bytes.Join(l, nil)
+}
+
+func TestClientErrors(t *testing.T) {
+	log := mlog.New("milter", nil)
+
+	test := func(respond func(cmd byte, data []byte) [][]byte, fn func(c *Client) error, expErr error) {
+		t.Helper()
+		clientConn, serverConn := net.Pipe()
+		go fakeMilter(serverConn, respond)
+		defer clientConn.Close()
+		c, err := NewClient(log, clientConn, time.Second)
+		if err == nil {
+			err = fn(c)
+		}
+		if err == nil || expErr != nil && !errors.Is(err, expErr) {
+			t.Fatalf("got err %v, expected %v", err, expErr)
+		}
+	}
+
+	negotiate := func(fn func(cmd byte, data []byte) [][]byte) func(cmd byte, data []byte) [][]byte {
+		return func(cmd byte, data []byte) [][]byte {
+			if cmd == cmdOptneg {
+				return [][]byte{packet(respOptneg, uint32buf(version), uint32buf(actAddHeaders), uint32buf(0))}
+			}
+			return fn(cmd, data)
+		}
+	}
+	connect := func(c *Client) error {
+		_, err := c.Connect("[192.0.2.1]", net.ParseIP("192.0.2.1"), 25, nil)
+		return err
+	}
+	message := func(c *Client) error {
+		_, err := c.Message(strings.NewReader("Subject: test\r\n\r\nhi\r\n"), nil)
+		return err
+	}
+
+	// Bad negotiation.
+	test(func(cmd byte, data []byte) [][]byte {
+		return [][]byte{packet(respOptneg, uint32buf(1), uint32buf(0), uint32buf(0))}
+	}, nil, ErrProtocol)
+
+	// Unknown response.
+	test(negotiate(func(cmd byte, data []byte) [][]byte {
+		return [][]byte{packet('z')}
+	}), connect, ErrProtocol)
+
+	// Invalid reply code.
+	test(negotiate(func(cmd byte, data []byte) [][]byte {
+		return [][]byte{packet(respReplyCode, []byte("250 ok\x00"))}
+	}), connect, ErrProtocol)
+
+	// Body replacement without negotiating it.
+	test(negotiate(func(cmd byte, data []byte) [][]byte {
+		if cmd == cmdBodyEOB {
+			return [][]byte{packet(respReplBody, []byte("new")), packet(respContinue)}
+		}
+		return [][]byte{packet(respContinue)}
+	}), message, ErrProtocol)
+
+	// Envelope changes are not supported.
+	test(negotiate(func(cmd byte, data []byte) [][]byte {
+		if cmd == cmdBodyEOB {
+			return [][]byte{packet(respAddRcpt, cstrings("<other@example.org>")), packet(respContinue)}
+		}
+		return [][]byte{packet(respContinue)}
+	}), message, ErrProtocol)
+
+	// Timeout.
+	test(negotiate(func(cmd byte, data []byte) [][]byte {
+		return nil
+	}), connect, nil)
+}
+
+func TestParseReplyCode(t *testing.T) {
+	test := func(s string, exp Response, expErr bool) {
+		t.Helper()
+		r, err := parseReplyCode(s)
+		if (err != nil) != expErr {
+			t.Fatalf("got err %v, expected error %v", err, expErr)
+		}
+		if err == nil {
+			tcompare(t, r, exp)
+		}
+	}
+
+	test("550 5.7.1 Spam detected", Response{Action: ActionReject, Code: 550, EnhancedCode: "5.7.1", Message: "Spam detected"}, false)
+	test("451 4.7.1 Try again later", Response{Action: ActionTempfail, Code: 451, EnhancedCode: "4.7.1", Message: "Try again later"}, false)
+	test("554 no enhanced code", Response{Action: ActionReject, Code: 554, Message: "no enhanced code"}, false)
+	test("550 4.7.1 class mismatch", Response{Action: ActionReject, Code: 550, Message: "4.7.1 class mismatch"}, false)
+	test("550-5.7.1 first\r\n550 5.7.1 second", Response{Action: ActionReject, Code: 550, EnhancedCode: "5.7.1", Message: "first"}, false)
+	test("550 5.7.1 bad\tchar", Response{Action: ActionReject, Code: 550, EnhancedCode: "5.7.1", Message: "bad char"}, false)
+	test("250 ok", Response{}, true)
+	test("55", Response{}, true)
+	test("abc", Response{}, true)
+}
+
+func TestApply(t *testing.T) {
+	msg := "Received: a\r\nSubject: test\r\nX-Score: 1\r\nX-Score: 2\r\n\r\nbody\r\n"
+
+	test := func(changes []Change, exp string) {
+		t.Helper()
+		var out bytes.Buffer
+		err := Apply(&out, strings.NewReader(msg), changes)
+		tcheck(t, err, "apply")
+		tcompare(t, out.String(), exp)
+	}
+
+	test(nil, msg)
+	test([]Change{{Kind: ChangeInsertHeader, Index: 0, Name: "X-First", Value: "1"}}, "X-First: 1\r\n"+msg)
+	test([]Change{{Kind: ChangeInsertHeader, Index: 100, Name: "X-Last", Value: "multi\n line"}}, "Received: a\r\nSubject: test\r\nX-Score: 1\r\nX-Score: 2\r\nX-Last: multi\r\n line\r\n\r\nbody\r\n")
+	test([]Change{{Kind: ChangeHeader, Index: 2, Name: "x-score", Value: ""}}, "Received: a\r\nSubject: test\r\nX-Score: 1\r\n\r\nbody\r\n")
+	test([]Change{{Kind: ChangeHeader, Index: 2, Name: "X-Score", Value: "3"}}, "Received: a\r\nSubject: test\r\nX-Score: 1\r\nX-Score: 3\r\n\r\nbody\r\n")
+	test([]Change{{Kind: ChangeHeader, Index: 3, Name: "X-Score", Value: ""}}, msg)
+	test([]Change{{Kind: ChangeHeader, Index: 1, Name: "X-New", Value: "new"}}, "Received: a\r\nSubject: test\r\nX-Score: 1\r\nX-Score: 2\r\nX-New: new\r\n\r\nbody\r\n")
+	test([]Change{{Kind: ChangeBody, Body: []byte("new\n")}, {Kind: ChangeBody, Body: []byte("body\r\n")}}, "Received: a\r\nSubject: test\r\nX-Score: 1\r\nX-Score: 2\r\n\r\nnew\r\nbody\r\n")
+}
@@ -0,0 +1,349 @@
+package mox
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+// CaptureMaxSize is the maximum size of a capture file, the capture is stopped
+// when reached.
+var CaptureMaxSize int64 = 10 * 1024 * 1024
+
+// Capture records the raw protocol data of connections from an IP or
+// authenticated to an account to a file, for debugging. Data of authentication
+// exchanges is redacted. Message data is only recorded if requested.
+type Capture struct {
+	ID        int64
+	IP        string   // If set, connections from this IP are captured.
+	Account   string   // If set, connections are captured after authenticating as this account.
+	Protocols []string // E.g. "smtp", "submission", "imap". Empty for all.
+	Data      bool     // Whether to record message data, otherwise only its size.
+	Start     time.Time
+	Until     time.Time
+	MaxConns  int    // Maximum number of connections to capture.
+	Conns     int    // Number of connections captured so far.
+	Size      int64  // Bytes written to file.
+	Path      string // Of file with captured data.
+
+	ip    net.IP
+	f     *os.File
+	timer *time.Timer
+}
+
+var captures = struct {
+	sync.Mutex
+	active []*Capture
+	nextID int64
+}{nextID: 1}
+
+// CaptureStart starts a capture for connections from an IP, or authenticating as
+// an account. The capture stops after duration. At most maxConns connections are
+// captured.
+func CaptureStart(log mlog.Log, ipOrAccount string, protocols []string, data bool, duration time.Duration, maxConns int) (Capture, error) {
+	if duration <= 0 || maxConns <= 0 {
+		return Capture{}, fmt.Errorf("duration and max connections must be positive")
+	}
+	for _, p := range protocols {
+		switch p {
+		case "smtp", "submission", "imap":
+		default:
+			return Capture{}, fmt.Errorf("unknown protocol %q, must be smtp, submission or imap", p)
+		}
+	}
+
+	c := &Capture{
+		Protocols: protocols,
+		Data:      data,
+		Start:     time.Now(),
+		Until:     time.Now().Add(duration),
+		MaxConns:  maxConns,
+	}
+	if ip := net.ParseIP(ipOrAccount); ip != nil {
+		c.IP = ip.String()
+		c.ip = ip
+	} else if _, ok := Conf.Account(ipOrAccount); ok {
+		c.Account = ipOrAccount
+	} else {
+		return Capture{}, fmt.Errorf("%q is not an ip address or account", ipOrAccount)
+	}
+
+	captures.Lock()
+	defer captures.Unlock()
+
+	c.ID = captures.nextID
+	captures.nextID++
+	c.Path = DataDirPath(filepath.Join("captures", fmt.Sprintf("%s-%d.txt", c.Start.Format("20060102T150405"), c.ID)))
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0770); err != nil {
+		return Capture{}, fmt.Errorf("creating captures directory: %v", err)
+	}
+	f, err := os.OpenFile(c.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return Capture{}, fmt.Errorf("creating capture file: %v", err)
+	}
+	c.f = f
+
+	what := "ip " + c.IP
+	if c.Account != "" {
+		what = "account " + c.Account
+	}
+	c.writeLocked(fmt.Sprintf("# capture %d for %s, protocols %v, until %s, max %d connections\n", c.ID, what, protocols, c.Until.Format(time.RFC3339), maxConns))
+
+	id := c.ID
+	c.timer = time.AfterFunc(duration, func() {
+		captures.Lock()
+		defer captures.Unlock()
+		if c := captureFindLocked(id); c != nil {
+			c.stopLocked(log, "duration passed")
+		}
+	})
+	captures.active = append(captures.active, c)
+	log.Info("capture started", slog.Int64("id", c.ID), slog.String("ip", c.IP), slog.String("account", c.Account), slog.String("path", c.Path))
+	return c.copyLocked(), nil
+}
+
+// CaptureStop stops an active capture, or all captures if id is 0. The number of
+// stopped captures is returned.
+func CaptureStop(log mlog.Log, id int64) int {
+	captures.Lock()
+	defer captures.Unlock()
+
+	var n int
+	for _, c := range slices.Clone(captures.active) {
+		if id == 0 || c.ID == id {
+			c.stopLocked(log, "stopped by admin")
+			n++
+		}
+	}
+	return n
+}
+
+// CaptureList returns the active captures.
+func CaptureList() []Capture {
+	captures.Lock()
+	defer captures.Unlock()
+
+	var l []Capture
+	for _, c := range captures.active {
+		l = append(l, c.copyLocked())
+	}
+	return l
+}
+
+func captureFindLocked(id int64) *Capture {
+	for _, c := range captures.active {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (c *Capture) copyLocked() Capture {
+	x := *c
+	x.Protocols = slices.Clone(c.Protocols)
+	x.f = nil
+	x.timer = nil
+	return x
+}
+
+func (c *Capture) stopLocked(log mlog.Log, reason string) {
+	c.writeLocked(fmt.Sprintf("# capture stopped: %s\n", reason))
+	c.timer.Stop()
+	err := c.f.Close()
+	log.Check(err, "closing capture file")
+	c.f = nil
+	captures.active = slices.DeleteFunc(captures.active, func(x *Capture) bool { return x == c })
+	log.Info("capture stopped", slog.Int64("id", c.ID), slog.String("reason", reason), slog.Int("conns", c.Conns), slog.Int64("size", c.Size), slog.String("path", c.Path))
+}
+
+func (c *Capture) writeLocked(s string) {
+	if c.f == nil {
+		return
+	}
+	n, err := c.f.Write([]byte(s))
+	c.Size += int64(n)
+	if err != nil {
+		mlog.New("mox", nil).Errorx("writing to capture file", err, slog.String("path", c.Path))
+	}
+}
+
+// Credentials on command lines are not marked with trace level traceauth, so we
+// redact them explicitly.
+var (
+	captureRedactSMTP = regexp.MustCompile(`(?i)^(AUTH +[^ ]+) .*$`)
+	captureRedactIMAP = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)^([^ ]+ +LOGIN) .*$`),
+		regexp.MustCompile(`(?i)^([^ ]+ +AUTHENTICATE +[^ ]+) .*$`),
+	}
+)
+
+// IMAP literal at the end of a line, synchronizing or not. ../rfc/9051:6734 ../rfc/7888
+var captureLiteralIMAP = regexp.MustCompile(`\{([0-9]{1,9})\+?\}$`)
+
+// Maximum size of partial line kept until the remainder of the line is read.
+// Longer lines are written redacted as is.
+const captureMaxPartial = 16 * 1024
+
+func captureRedact(protocol string, line []byte) []byte {
+	if protocol == "imap" {
+		for _, re := range captureRedactIMAP {
+			line = re.ReplaceAll(line, []byte("$1 ***"))
+		}
+		return line
+	}
+	return captureRedactSMTP.ReplaceAll(line, []byte("$1 ***"))
+}
+
+// ConnCapture records protocol data of a single connection to a capture. It
+// implements moxio.Capturer.
+type ConnCapture struct {
+	c        *Capture
+	cid      int64
+	protocol string
+
+	// Per direction (prefix), the partial line not yet recorded, and the state for
+	// redacting literals of redacted commands. Data arrives as read from the
+	// connection, so lines can be split over reads, and literals can arrive in the
+	// same read as their command line.
+	streams map[string]*captureStream
+}
+
+type captureStream struct {
+	partial []byte
+	redact  int64
+	cont    bool // Whether the next line continues a redacted command.
+}
+
+// CaptureConn returns a ConnCapture for a connection with protocol (e.g. "smtp",
+// "submission", "imap") and cid, from remoteIP (for a new connection) or
+// authenticated as account (after authentication). If no active capture matches
+// the connection, nil is returned.
+func CaptureConn(protocol string, cid int64, remoteIP net.IP, account string) *ConnCapture {
+	captures.Lock()
+	defer captures.Unlock()
+
+	for _, c := range captures.active {
+		if c.Conns >= c.MaxConns || len(c.Protocols) > 0 && !slices.Contains(c.Protocols, protocol) {
+			continue
+		}
+		if remoteIP != nil && c.ip != nil && c.ip.Equal(remoteIP) || account != "" && c.Account == account {
+			c.Conns++
+			what := "from ip " + remoteIP.String()
+			if account != "" {
+				what = "authenticated as account " + account
+			}
+			c.writeLocked(fmt.Sprintf("%s cid=%x # %s connection %s\n", time.Now().UTC().Format(time.RFC3339Nano), cid, protocol, what))
+			return &ConnCapture{c, cid, protocol, map[string]*captureStream{}}
+		}
+	}
+	return nil
+}
+
+// Capture records data read or written on the connection. Authentication data is
+// redacted, message data only recorded if the capture was started with data.
+func (cc *ConnCapture) Capture(prefix string, level slog.Level, buf []byte) {
+	captures.Lock()
+	defer captures.Unlock()
+
+	c := cc.c
+	if c.f == nil {
+		return
+	}
+
+	st := cc.streams[prefix]
+	if st == nil {
+		st = &captureStream{}
+		cc.streams[prefix] = st
+	}
+
+	var lines [][]byte
+	if level == mlog.LevelTracedata {
+		// Message data, e.g. SMTP DATA or an IMAP APPEND literal, is not split in lines.
+		if len(st.partial) > 0 {
+			lines = append(lines, captureRedact(cc.protocol, st.partial))
+			st.partial = nil
+		}
+		st.redact = max(0, st.redact-int64(len(buf)))
+		if !c.Data {
+			lines = append(lines, fmt.Appendf(nil, "... (%d bytes)", len(buf)))
+		} else {
+			for _, line := range bytes.Split(bytes.TrimSuffix(buf, []byte("\n")), []byte("\n")) {
+				lines = append(lines, captureRedact(cc.protocol, bytes.TrimSuffix(line, []byte("\r"))))
+			}
+		}
+	} else {
+		lines = cc.lines(st, buf)
+		if level == mlog.LevelTraceauth {
+			// Authentication data is still parsed above, to keep track of literals. The
+			// remainder of a partial line is redacted when it arrives.
+			lines = [][]byte{[]byte("***")}
+			if len(st.partial) > 0 {
+				st.partial = nil
+				st.cont = true
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	ts := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, line := range lines {
+		fmt.Fprintf(&b, "%s cid=%x %s%s\n", ts, cc.cid, prefix, line)
+	}
+	c.writeLocked(b.String())
+	if c.Size >= CaptureMaxSize {
+		c.stopLocked(mlog.New("mox", nil), "maximum size reached")
+	}
+}
+
+// lines returns the redacted complete lines of data read or written, keeping
+// track of partial lines and literals for redaction.
+func (cc *ConnCapture) lines(st *captureStream, buf []byte) (lines [][]byte) {
+	data := append(st.partial, buf...)
+	st.partial = nil
+	for len(data) > 0 {
+		if st.redact > 0 {
+			n := min(st.redact, int64(len(data)))
+			data = data[n:]
+			st.redact -= n
+			lines = append(lines, []byte("***"))
+			continue
+		}
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 && len(data) <= captureMaxPartial {
+			st.partial = slices.Clone(data)
+			break
+		} else if i < 0 {
+			i = len(data)
+		}
+		line := bytes.TrimSuffix(data[:i], []byte("\r"))
+		data = data[min(i+1, len(data)):]
+		var rline []byte
+		if st.cont {
+			rline = []byte("***")
+			st.cont = false
+		} else {
+			rline = captureRedact(cc.protocol, line)
+		}
+		// A literal of a redacted command, e.g. the password for LOGIN, is redacted as
+		// well, and so is the remainder of the command after the literal.
+		if cc.protocol == "imap" && !bytes.Equal(rline, line) {
+			if m := captureLiteralIMAP.FindSubmatch(line); m != nil {
+				st.redact, _ = strconv.ParseInt(string(m[1]), 10, 64)
+				st.cont = true
+			}
+		}
+		lines = append(lines, rline)
+	}
+	return lines
+}
@@ -0,0 +1,86 @@
+package mox
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+func TestCapture(t *testing.T) {
+	log := mlog.New("mox", nil)
+
+	odataDir := Conf.Static.DataDir
+	defer func() {
+		Conf.Static.DataDir = odataDir
+	}()
+	Conf.Static.DataDir = t.TempDir()
+
+	if _, err := CaptureStart(log, "10.0.0.1", []string{"pop3"}, false, time.Minute, 1); err == nil {
+		t.Fatalf("capture with unknown protocol succeeded")
+	}
+	if _, err := CaptureStart(log, "10.0.0.1", nil, false, 0, 1); err == nil {
+		t.Fatalf("capture with zero duration succeeded")
+	}
+
+	c, err := CaptureStart(log, "10.0.0.1", []string{"smtp", "imap"}, false, time.Minute, 1)
+	if err != nil {
+		t.Fatalf("start capture: %v", err)
+	}
+	defer CaptureStop(log, 0)
+
+	if cc := CaptureConn("imap", 1, net.ParseIP("10.0.0.2"), ""); cc != nil {
+		t.Fatalf("capture for other ip")
+	}
+	if cc := CaptureConn("submission", 1, net.ParseIP("10.0.0.1"), ""); cc != nil {
+		t.Fatalf("capture for other protocol")
+	}
+	cc := CaptureConn("imap", 1, net.ParseIP("10.0.0.1"), "")
+	if cc == nil {
+		t.Fatalf("no capture for matching connection")
+	}
+	if cc := CaptureConn("imap", 2, net.ParseIP("10.0.0.1"), ""); cc != nil {
+		t.Fatalf("capture beyond max connections")
+	}
+
+	cc.Capture("C: ", mlog.LevelTrace, []byte("a1 LOGIN mjl secret\r\n"))
+	cc.Capture("C: ", mlog.LevelTrace, []byte("a2 AUTHENTICATE PLAIN AG1qbABzZWNyZXQ=\r\n"))
+	cc.Capture("C: ", mlog.LevelTraceauth, []byte("AG1qbABzZWNyZXQ=\r\n"))
+	cc.Capture("C: ", mlog.LevelTracedata, []byte("Subject: hi\r\n\r\nsecret body\r\n"))
+	cc.Capture("S: ", mlog.LevelTrace, []byte("a3 OK done\r\n"))
+	// Line split over reads.
+	cc.Capture("C: ", mlog.LevelTrace, []byte("a4 LOG"))
+	cc.Capture("C: ", mlog.LevelTrace, []byte("IN mjl secret\r\n"))
+	// Non-synchronizing literals in same read as command line.
+	cc.Capture("C: ", mlog.LevelTrace, []byte("a5 LOGIN {3+}\r\nmjl {6+}\r\nsecret\r\na6 NOOP\r\n"))
+	// Synchronizing literal, read as authentication data.
+	cc.Capture("C: ", mlog.LevelTrace, []byte("a7 LOGIN mjl {6}\r\n"))
+	cc.Capture("C: ", mlog.LevelTraceauth, []byte("secret\r\n"))
+	cc.Capture("C: ", mlog.LevelTrace, []byte("a8 NOOP\r\n"))
+
+	if n := CaptureStop(log, c.ID); n != 1 {
+		t.Fatalf("stopped %d captures, expected 1", n)
+	}
+	if len(CaptureList()) != 0 {
+		t.Fatalf("capture still active after stop")
+	}
+	// Further data is ignored.
+	cc.Capture("C: ", mlog.LevelTrace, []byte("a4 LOGOUT\r\n"))
+
+	buf, err := os.ReadFile(c.Path)
+	if err != nil {
+		t.Fatalf("reading capture file: %v", err)
+	}
+	s := string(buf)
+	if strings.Contains(s, "secret") || strings.Contains(s, "AG1qbABzZWNyZXQ") || strings.Contains(s, "LOGOUT") {
+		t.Fatalf("capture file contains redacted or late data:\n%s", s)
+	}
+	for _, exp := range []string{"C: a4 LOGIN ***", "C: a5 LOGIN ***", "C: a6 NOOP", "C: a7 LOGIN ***", "C: a8 NOOP", "C: a1 LOGIN ***", "C: a2 AUTHENTICATE PLAIN ***", "C: ***", "C: ... (28 bytes)", "S: a3 OK done", "# capture stopped"} {
+		if !strings.Contains(s, exp) {
+			t.Fatalf("capture file does not contain %q:\n%s", exp, s)
+		}
+	}
+}
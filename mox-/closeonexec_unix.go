@@ -0,0 +1,13 @@
+//go:build unix
+
+package mox
+
+import (
+	"syscall"
+)
+
+// closeOnExec marks a file descriptor as close-on-exec, so it isn't inherited by
+// the unprivileged child process.
+func closeOnExec(fd int) {
+	syscall.CloseOnExec(fd)
+}
@@ -0,0 +1,5 @@
+package mox
+
+// closeOnExec is a no-op on windows, there is no systemd socket activation.
+func closeOnExec(fd int) {
+}
@@ -30,6 +30,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/text/unicode/norm"
@@ -39,8 +40,10 @@ import (
 	"github.com/mjl-/sconf"
 
 	"github.com/mjl-/mox/autotls"
+	"github.com/mjl-/mox/bimi"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dkim"
+	"github.com/mjl-/mox/dmarc"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/mlog"
@@ -64,6 +67,9 @@ var (
 
 var ErrConfig = errors.New("config error")
 
+// Tenant names are used in file names for admin passwords and in metric labels.
+var tenantNameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
 // Set by packages webadmin, webaccount, webmail, webapisrv to prevent cyclic dependencies.
 var NewWebadminHandler = func(basePath string, isForwarded bool) http.Handler { return nopHandler }
 var NewWebaccountHandler = func(basePath string, isForwarded bool) http.Handler { return nopHandler }
@@ -276,6 +282,37 @@ func (c *Config) Account(name string) (acc config.Account, ok bool) {
 	return
 }
 
+// TenantAdminPasswordFile returns the path of the file with the hash of the admin
+// password of the tenant, in the config directory.
+func TenantAdminPasswordFile(tenant string) string {
+	return ConfigDirPath("adminpasswd-" + tenant)
+}
+
+// Tenant returns the configuration of the named tenant.
+func (c *Config) Tenant(name string) (t config.Tenant, ok bool) {
+	c.withDynamicLock(func() {
+		t, ok = c.Dynamic.Tenants[name]
+	})
+	return
+}
+
+// AccountTenant returns the name and configuration of the tenant of the account,
+// based on the domain of the account. If the account is not part of a tenant, ok
+// is false.
+func (c *Config) AccountTenant(accountName string) (name string, t config.Tenant, ok bool) {
+	c.withDynamicLock(func() {
+		acc, xok := c.Dynamic.Accounts[accountName]
+		if !xok {
+			return
+		}
+		name = c.Dynamic.Domains[acc.DNSDomain.Name()].Tenant
+		if name != "" {
+			t, ok = c.Dynamic.Tenants[name]
+		}
+	})
+	return
+}
+
 func (c *Config) AccountDestination(addr string) (accDest AccountDestination, alias *config.Alias, ok bool) {
 	c.withDynamicLock(func() {
 		accDest, ok = c.AccountDestinationsLocked[addr]
@@ -310,14 +347,46 @@ func (c *Config) IsClientSettingsDomain(d dns.Domain) (is bool) {
 	return
 }
 
+// CRAMMD5Disabled returns whether none of the listeners accept CRAM-MD5
+// authentication, i.e. NoPlaintextAuth is set for all listeners with services
+// that accept password authentication. Accounts then don't need CRAM-MD5 secrets.
+func (c *Config) CRAMMD5Disabled() bool {
+	var n int
+	for _, l := range c.Static.Listeners {
+		if !l.Submission.Enabled && !l.Submissions.Enabled && !l.IMAP.Enabled && !l.IMAPS.Enabled && !l.POP3.Enabled && !l.POP3S.Enabled && !l.ManageSieve.Enabled {
+			continue
+		}
+		if !l.NoPlaintextAuth {
+			return false
+		}
+		n++
+	}
+	return n > 0
+}
+
 func (c *Config) allowACMEHosts(log mlog.Log, checkACMEHosts bool) {
+	// Host names per manager, gathered over all listeners. Host names of domains
+	// configured with their own ACME provider are added to the manager of that
+	// provider, and delegated to it by the manager of the listener.
+	managerHosts := map[*autotls.Manager]map[dns.Domain]struct{}{}
+	delegates := map[*autotls.Manager]map[dns.Domain]*autotls.Manager{}
+	for _, acme := range c.Static.ACME {
+		if acme.Manager != nil {
+			managerHosts[acme.Manager] = map[dns.Domain]struct{}{}
+			delegates[acme.Manager] = map[dns.Domain]*autotls.Manager{}
+		}
+	}
+
 	for _, l := range c.Static.Listeners {
 		if l.TLS == nil || l.TLS.ACME == "" {
 			continue
 		}
 
 		m := c.Static.ACME[l.TLS.ACME].Manager
-		hostnames := map[dns.Domain]struct{}{}
+		if m == nil {
+			continue
+		}
+		hostnames := managerHosts[m]
 
 		hostnames[c.Static.HostnameDomain] = struct{}{}
 		if l.HostnameDomain.ASCII != "" {
@@ -337,11 +406,21 @@ func (c *Config) allowACMEHosts(log mlog.Log, checkACMEHosts bool) {
 				continue
 			}
 
+			addHost := func(d dns.Domain) {
+				hostnames[d] = struct{}{}
+			}
+			if dm := c.Static.ACME[dom.ACME].Manager; dom.ACME != "" && dm != nil && dm != m {
+				addHost = func(d dns.Domain) {
+					managerHosts[dm][d] = struct{}{}
+					delegates[m][d] = dm
+				}
+			}
+
 			if l.AutoconfigHTTPS.Enabled && !l.AutoconfigHTTPS.NonTLS {
 				if d, err := dns.ParseDomain("autoconfig." + dom.Domain.ASCII); err != nil {
 					log.Errorx("parsing autoconfig domain", err, slog.Any("domain", dom.Domain))
 				} else {
-					hostnames[d] = struct{}{}
+					addHost(d)
 				}
 			}
 
@@ -350,12 +429,20 @@ func (c *Config) allowACMEHosts(log mlog.Log, checkACMEHosts bool) {
 				if err != nil {
 					log.Errorx("parsing mta-sts domain", err, slog.Any("domain", dom.Domain))
 				} else {
-					hostnames[d] = struct{}{}
+					addHost(d)
+				}
+			}
+
+			if l.WKDHTTPS.Enabled && !l.WKDHTTPS.NonTLS {
+				if d, err := dns.ParseDomain("openpgpkey." + dom.Domain.ASCII); err != nil {
+					log.Errorx("parsing openpgpkey domain", err, slog.Any("domain", dom.Domain))
+				} else {
+					addHost(d)
 				}
 			}
 
 			if dom.ClientSettingsDomain != "" {
-				hostnames[dom.ClientSettingsDNSDomain] = struct{}{}
+				addHost(dom.ClientSettingsDNSDomain)
 			}
 		}
 
@@ -367,16 +454,22 @@ func (c *Config) allowACMEHosts(log mlog.Log, checkACMEHosts bool) {
 				hostnames[wh.DNSDomain] = struct{}{}
 			}
 		}
+	}
 
-		public := c.Static.Listeners["public"]
-		ips := public.IPs
-		if len(public.NATIPs) > 0 {
-			ips = public.NATIPs
-		}
-		if public.IPsNATed {
-			ips = nil
+	public := c.Static.Listeners["public"]
+	ips := public.IPs
+	if len(public.NATIPs) > 0 {
+		ips = public.NATIPs
+	}
+	if public.IPsNATed {
+		ips = nil
+	}
+	for m, hostnames := range managerHosts {
+		for d := range delegates[m] {
+			delete(hostnames, d)
 		}
 		m.SetAllowedHostnames(log, dns.StrictResolver{Pkg: "autotls", Log: log.Logger}, hostnames, ips, checkACMEHosts)
+		m.SetDelegates(delegates[m])
 	}
 }
 
@@ -491,6 +584,26 @@ func SetConfig(c *Config) {
 	}
 
 	SetPedantic(c.Static.Pedantic)
+	setMessageLimits(c.Static.MessageLimits)
+}
+
+// setMessageLimits sets the limits for parsing messages, with defaults for
+// unset values.
+func setMessageLimits(l *config.MessageLimits) {
+	if l == nil {
+		l = &config.MessageLimits{}
+	}
+	orDefault := func(v, def int) int {
+		if v > 0 {
+			return v
+		}
+		return def
+	}
+	message.MaxHeaderFields = orDefault(l.MaxHeaderFields, message.DefaultMaxHeaderFields)
+	message.MaxHeaderSize = orDefault(l.MaxHeaderSize, message.DefaultMaxHeaderSize)
+	message.MaxDepth = orDefault(l.MaxDepth, message.DefaultMaxDepth)
+	message.MaxParts = orDefault(l.MaxParts, message.DefaultMaxParts)
+	message.MaxDecodedSize = orDefault(l.MaxDecodedSize, message.DefaultMaxDecodedSize)
 }
 
 // Set pedantic in all packages.
@@ -714,6 +827,26 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 			}
 		}
 
+		var rootCAs *x509.CertPool
+		if acme.CACertFile != "" {
+			p := configDirPath(configFile, acme.CACertFile)
+			buf, err := os.ReadFile(p)
+			if err != nil {
+				addAcmeErrorf("reading ca certificate file: %s", err)
+			} else {
+				rootCAs = x509.NewCertPool()
+				if !rootCAs.AppendCertsFromPEM(buf) {
+					addAcmeErrorf("no ca certificates found in %s", p)
+				}
+			}
+		}
+		if acme.OrderLimit < 0 {
+			addAcmeErrorf("order limit cannot be negative")
+		}
+		if acme.OrderLimitPeriod == 0 {
+			acme.OrderLimitPeriod = 3 * time.Hour
+		}
+
 		if checkOnly {
 			continue
 		}
@@ -723,12 +856,22 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 		manager, err := autotls.Load(log, name, acmeDir, acme.ContactEmail, acme.DirectoryURL, eabKeyID, eabKey, makeGetPrivateKey(name), Shutdown.Done())
 		if err != nil {
 			addAcmeErrorf("loading ACME identity: %s", err)
+		} else {
+			manager.Manager.RenewBefore = acme.RenewBefore
+			manager.SetClientConfig(rootCAs, acme.OrderLimit, acme.OrderLimitPeriod)
 		}
 		acme.Manager = manager
 
-		// Help configurations from older quickstarts.
-		if acme.IssuerDomainName == "" && acme.DirectoryURL == "https://acme-v02.api.letsencrypt.org/directory" {
-			acme.IssuerDomainName = "letsencrypt.org"
+		// Help configurations from older quickstarts, and for other well-known providers.
+		if acme.IssuerDomainName == "" {
+			switch acme.DirectoryURL {
+			case "https://acme-v02.api.letsencrypt.org/directory":
+				acme.IssuerDomainName = "letsencrypt.org"
+			case "https://acme.zerossl.com/v2/DV90":
+				acme.IssuerDomainName = "sectigo.com"
+			case "https://api.buypass.com/acme/directory":
+				acme.IssuerDomainName = "buypass.com"
+			}
 		}
 
 		c.ACME[name] = acme
@@ -747,6 +890,23 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 			}
 			l.HostnameDomain = d
 		}
+		if g := l.Greeting; g != nil {
+			if g.Hostname != "" {
+				d, err := dns.ParseDomain(g.Hostname)
+				if err != nil {
+					addListenerErrorf("parsing greeting hostname %q: %s", g.Hostname, err)
+				}
+				g.HostnameDomain = d
+			}
+			for _, s := range []string{g.SMTP, g.IMAP, g.POP3, g.ManageSieve} {
+				for _, c := range s {
+					if c < ' ' || c >= 0x7f || c == '"' || c == '\\' {
+						addListenerErrorf("greeting %q must only have printable ascii characters, without double quotes and backslashes", s)
+						break
+					}
+				}
+			}
+		}
 		if l.TLS != nil {
 			if l.TLS.ACME != "" && len(l.TLS.KeyCerts) != 0 {
 				addListenerErrorf("cannot have ACME and static key/certificates")
@@ -774,7 +934,8 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 					// listener hostname instead of causing the TLS connection to fail.
 					tlsconfig = acme.Manager.TLSConfig(hostname, true, false)
 					tlsconfigFallback = acme.Manager.TLSConfig(hostname, true, true)
-					l.TLS.ACMEConfig = acme.Manager.ACMETLSConfig
+					// Cloned, the TLS policy of the listener is applied to it below.
+					l.TLS.ACMEConfig = acme.Manager.ACMETLSConfig.Clone()
 				}
 				l.TLS.Config = tlsconfig
 				l.TLS.ConfigFallback = tlsconfigFallback
@@ -837,14 +998,27 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 				}
 				minVersion = v
 			}
-			if l.TLS.Config != nil {
-				l.TLS.Config.MinVersion = minVersion
+			cipherSuites, err := parseTLSCipherSuites(l.TLS.CipherSuites)
+			if err != nil {
+				addListenerErrorf("tls: %v", err)
 			}
-			if l.TLS.ConfigFallback != nil {
-				l.TLS.ConfigFallback.MinVersion = minVersion
+			for _, p := range l.TLS.ALPNProtocols {
+				if !slices.Contains(tlsALPNProtocols, p) {
+					addListenerErrorf("tls: unknown ALPN protocol %q, must be one of %s", p, strings.Join(tlsALPNProtocols, ", "))
+				}
 			}
-			if l.TLS.ACMEConfig != nil {
-				l.TLS.ACMEConfig.MinVersion = minVersion
+			if l.TLS.SessionTicketKeyRotation < 0 {
+				addListenerErrorf("tls: SessionTicketKeyRotation must be >= 0")
+			} else if l.TLS.SessionTicketKeyRotation == 0 {
+				l.TLS.SessionTicketKeyRotation = 24 * time.Hour
+			}
+			// Config and ConfigFallback can be the same.
+			seen := map[*tls.Config]bool{}
+			for _, tlsConfig := range []*tls.Config{l.TLS.Config, l.TLS.ConfigFallback, l.TLS.ACMEConfig} {
+				if tlsConfig != nil && !seen[tlsConfig] {
+					seen[tlsConfig] = true
+					applyTLSPolicy(name, l.TLS, tlsConfig, minVersion, cipherSuites)
+				}
 			}
 		} else {
 			var needsTLS []string
@@ -854,6 +1028,8 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 				}
 			}
 			needtls("IMAPS", l.IMAPS.Enabled)
+			needtls("POP3S", l.POP3S.Enabled)
+			needtls("ManageSieve", l.ManageSieve.Enabled && !l.ManageSieve.NoRequireSTARTTLS)
 			needtls("SMTP", l.SMTP.Enabled && !l.SMTP.NoSTARTTLS)
 			needtls("Submissions", l.Submissions.Enabled)
 			needtls("Submission", l.Submission.Enabled && !l.Submission.NoRequireSTARTTLS)
@@ -861,6 +1037,7 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 			needtls("AdminHTTPS", l.AdminHTTPS.Enabled)
 			needtls("AutoconfigHTTPS", l.AutoconfigHTTPS.Enabled && !l.AutoconfigHTTPS.NonTLS)
 			needtls("MTASTSHTTPS", l.MTASTSHTTPS.Enabled && !l.MTASTSHTTPS.NonTLS)
+			needtls("WKDHTTPS", l.WKDHTTPS.Enabled && !l.WKDHTTPS.NonTLS)
 			needtls("WebserverHTTPS", l.WebserverHTTPS.Enabled)
 			if len(needsTLS) > 0 {
 				addListenerErrorf("no tls config specified, but requires tls for %s", strings.Join(needsTLS, ", "))
@@ -892,6 +1069,30 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 				}
 			}
 		}
+		if l.SMTP.MaxRecipients < 0 {
+			addListenerErrorf("SMTP MaxRecipients must be >= 0")
+		} else if l.SMTP.MaxRecipients > 0 && l.SMTP.MaxRecipients < 100 {
+			log.Warn("smtp MaxRecipients below 100, the minimum required by rfc 5321", slog.String("listener", name), slog.Int("maxrecipients", l.SMTP.MaxRecipients))
+		}
+		if l.SMTP.MaxRecipientsPerConnection < 0 {
+			addListenerErrorf("SMTP MaxRecipientsPerConnection must be >= 0")
+		}
+		if n := len(l.SMTP.MaxUnknownRecipientsPerHour); n != 0 && n != 3 {
+			addListenerErrorf("SMTP MaxUnknownRecipientsPerHour must have 3 values, for ip and networks, not %d", n)
+		}
+		for _, v := range l.SMTP.MaxUnknownRecipientsPerHour {
+			if v <= 0 {
+				addListenerErrorf("SMTP MaxUnknownRecipientsPerHour values must be > 0")
+				break
+			}
+		}
+		if hc := l.SMTP.HELOChecks; hc != nil {
+			for k, v := range map[string]string{"RequireFQDN": hc.RequireFQDN, "RejectOwnHostname": hc.RejectOwnHostname, "RequireFCrDNS": hc.RequireFCrDNS} {
+				if v != "" && v != "log" && v != "enforce" {
+					addListenerErrorf("SMTP HELOChecks %s must be empty, log or enforce, not %q", k, v)
+				}
+			}
+		}
 		for _, s := range l.SMTP.DNSBLs {
 			d, err := dns.ParseDomain(s)
 			if err != nil {
@@ -900,6 +1101,78 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 			}
 			l.SMTP.DNSBLZones = append(l.SMTP.DNSBLZones, d)
 		}
+		l.SMTP.DNSBLZoneWeights = map[dns.Domain]float64{}
+		for s, w := range l.SMTP.DNSBLWeights {
+			d, err := dns.ParseDomain(s)
+			if err != nil {
+				addListenerErrorf("parsing DNSBL zone %q in DNSBLWeights: %s", s, err)
+				continue
+			}
+			if !slices.Contains(l.SMTP.DNSBLZones, d) {
+				addListenerErrorf("zone %q in DNSBLWeights not in DNSBLs", s)
+			}
+			if w < 0 {
+				addListenerErrorf("weight for DNSBL zone %q must be >= 0", s)
+			}
+			l.SMTP.DNSBLZoneWeights[d] = w
+		}
+		if l.SMTP.DNSBLThreshold < 0 {
+			addListenerErrorf("SMTP DNSBLThreshold must be >= 0")
+		} else if l.SMTP.DNSBLThreshold == 0 && len(l.SMTP.DNSBLWeights) > 0 {
+			log.Warn("smtp DNSBLWeights has no effect without DNSBLThreshold", slog.String("listener", name))
+		}
+		for _, s := range l.SMTP.URIBLs {
+			d, err := dns.ParseDomain(s)
+			if err != nil {
+				addListenerErrorf("parsing URIBL zone %q: %s", s, err)
+				continue
+			}
+			l.SMTP.URIBLZones = append(l.SMTP.URIBLZones, d)
+		}
+		if h := l.SMTP.PolicyHook; h != nil {
+			if (h.WASM == "") == (h.URL == "") {
+				addListenerErrorf("SMTP PolicyHook: exactly one of WASM and URL must be set")
+			} else if h.WASM != "" {
+				h.WASMPath = configDirPath(configFile, h.WASM)
+				if _, err := os.Stat(h.WASMPath); err != nil {
+					addListenerErrorf("SMTP PolicyHook WASM: %v", err)
+				}
+				if h.Authorization != "" {
+					addListenerErrorf("SMTP PolicyHook Authorization only applies to URL")
+				}
+			} else if u, err := url.Parse(h.URL); err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+				addListenerErrorf("SMTP PolicyHook URL %q must be an http or https url", h.URL)
+			}
+			for _, s := range h.Stages {
+				switch s {
+				case "connect", "mail", "rcpt", "data":
+				default:
+					addListenerErrorf("SMTP PolicyHook: unknown stage %q, must be connect, mail, rcpt or data", s)
+				}
+			}
+			if h.Timeout < 0 {
+				addListenerErrorf("SMTP PolicyHook Timeout must be >= 0")
+			} else if h.Timeout == 0 {
+				h.Timeout = 10 * time.Second
+			}
+			var err error
+			h.EffectiveAuthorization, err = resolveSecret(ctx, configFile, h.Authorization)
+			if err != nil {
+				addListenerErrorf("SMTP PolicyHook Authorization: %v", err)
+			}
+		}
+		for i := range l.SMTP.Milters {
+			m := &l.SMTP.Milters[i]
+			m.Network, m.NetworkAddr, err = parseSocketAddress(m.Address)
+			if err != nil {
+				addListenerErrorf("SMTP Milter: %v", err)
+			}
+			if m.Timeout < 0 {
+				addListenerErrorf("SMTP Milter Timeout must be >= 0")
+			} else if m.Timeout == 0 {
+				m.Timeout = 30 * time.Second
+			}
+		}
 		if l.IPsNATed && len(l.NATIPs) > 0 {
 			addListenerErrorf("both IPsNATed and NATIPs configued (remove deprecated IPsNATed)")
 		}
@@ -911,6 +1184,58 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 				addListenerErrorf("NAT ip that is the unspecified or loopback address %s", ipstr)
 			}
 		}
+		parseNets := func(kind string, l []string) []*net.IPNet {
+			var nets []*net.IPNet
+			for _, v := range l {
+				_, ipnet, err := net.ParseCIDR(v)
+				if err != nil {
+					ip := net.ParseIP(v)
+					if ip == nil {
+						addListenerErrorf("%s: invalid ip or network %q", kind, v)
+						continue
+					}
+					bits := 128
+					if ip.To4() != nil {
+						ip = ip.To4()
+						bits = 32
+					}
+					ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+				}
+				nets = append(nets, ipnet)
+			}
+			return nets
+		}
+		checkIPAccess := func(kind string, a *config.IPAccess) {
+			if a == nil {
+				return
+			}
+			a.AllowNets = parseNets(kind, a.Allow)
+			a.DenyNets = parseNets(kind, a.Deny)
+		}
+		checkIPAccess("IPAccess", l.IPAccess)
+		checkIPAccess("SMTP IPAccess", l.SMTP.IPAccess)
+		checkIPAccess("Submission IPAccess", l.Submission.IPAccess)
+		checkIPAccess("Submissions IPAccess", l.Submissions.IPAccess)
+		checkIPAccess("IMAP IPAccess", l.IMAP.IPAccess)
+		checkIPAccess("IMAPS IPAccess", l.IMAPS.IPAccess)
+		checkIPAccess("POP3 IPAccess", l.POP3.IPAccess)
+		checkIPAccess("POP3S IPAccess", l.POP3S.IPAccess)
+		checkIPAccess("ManageSieve IPAccess", l.ManageSieve.IPAccess)
+		checkIPAccess("AccountHTTP IPAccess", l.AccountHTTP.IPAccess)
+		checkIPAccess("AccountHTTPS IPAccess", l.AccountHTTPS.IPAccess)
+		checkIPAccess("AdminHTTP IPAccess", l.AdminHTTP.IPAccess)
+		checkIPAccess("AdminHTTPS IPAccess", l.AdminHTTPS.IPAccess)
+		checkIPAccess("WebmailHTTP IPAccess", l.WebmailHTTP.IPAccess)
+		checkIPAccess("WebmailHTTPS IPAccess", l.WebmailHTTPS.IPAccess)
+		checkIPAccess("WebAPIHTTP IPAccess", l.WebAPIHTTP.IPAccess)
+		checkIPAccess("WebAPIHTTPS IPAccess", l.WebAPIHTTPS.IPAccess)
+		if x := l.SMTP.XCLIENT; x != nil {
+			if len(x.TrustedIPs) == 0 {
+				addListenerErrorf("SMTP XCLIENT: TrustedIPs must not be empty")
+			}
+			x.TrustedNets = parseNets("SMTP XCLIENT TrustedIPs", x.TrustedIPs)
+		}
+
 		cleanPath := func(kind string, enabled bool, path string) string {
 			if !enabled {
 				return path
@@ -987,6 +1312,23 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 			addTransportErrorf("bad host %s: %v", t.Host, err)
 		}
 
+		if t.Weight < 0 {
+			addTransportErrorf("weight cannot be negative")
+		}
+		for i := range t.Hosts {
+			h := &t.Hosts[i]
+			h.DNSHost, err = dns.ParseDomain(h.Host)
+			if err != nil {
+				addTransportErrorf("bad host %s: %v", h.Host, err)
+			}
+			if h.Port < 0 || h.Port > 65535 {
+				addTransportErrorf("host %s: bad port %d", h.Host, h.Port)
+			}
+			if h.Weight < 0 {
+				addTransportErrorf("host %s: weight cannot be negative", h.Host)
+			}
+		}
+
 		if isTLS && t.STARTTLSInsecureSkipVerify {
 			addTransportErrorf("cannot have STARTTLSInsecureSkipVerify with immediate TLS")
 		}
@@ -1019,6 +1361,12 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 		if len(t.Auth.EffectiveMechanisms) == 0 {
 			t.Auth.EffectiveMechanisms = []string{"SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1", "CRAM-MD5"}
 		}
+
+		pw, err := resolveSecret(ctx, configFile, t.Auth.Password)
+		if err != nil {
+			addTransportErrorf("auth password: %v", err)
+		}
+		t.Auth.EffectivePassword = pw
 	}
 
 	checkTransportSocks := func(name string, t *config.TransportSocks) {
@@ -1146,6 +1494,206 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 			}
 		}
 	}
+
+	// Load S/MIME CA certificate pool. If not set, the system pool is used.
+	if c.SMIME.CA != nil {
+		if c.SMIME.CA.AdditionalToSystem {
+			var err error
+			c.SMIME.CertPool, err = x509.SystemCertPool()
+			if err != nil {
+				addErrorf("fetching system CA cert pool for s/mime: %v", err)
+			}
+		} else {
+			c.SMIME.CertPool = x509.NewCertPool()
+		}
+		for _, certfile := range c.SMIME.CA.CertFiles {
+			p := configDirPath(configFile, certfile)
+			pemBuf, err := os.ReadFile(p)
+			if err != nil {
+				addErrorf("reading S/MIME CA cert file: %v", err)
+				continue
+			} else if !c.SMIME.CertPool.AppendCertsFromPEM(pemBuf) {
+				addErrorf("no S/MIME CA certs added from %q", p)
+			}
+		}
+	}
+
+	if c.OAuth != nil {
+		if u, err := url.Parse(c.OAuth.IntrospectionURL); err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+			addErrorf("oauth introspection url %q must be an http or https url", c.OAuth.IntrospectionURL)
+		}
+		if c.OAuth.ClientID == "" {
+			addErrorf("oauth client id required")
+		}
+		if c.OAuth.UsernameClaim == "" {
+			c.OAuth.UsernameClaim = "email"
+		}
+		c.OAuth.EffectiveClientSecret, err = resolveSecret(ctx, configFile, c.OAuth.ClientSecret)
+		if err != nil {
+			addErrorf("oauth client secret: %v", err)
+		}
+	}
+
+	if IsSecretRef(c.AdminPasswordFile) {
+		buf, err := resolveSecretFile(ctx, configFile, c.AdminPasswordFile)
+		if err != nil {
+			addErrorf("admin password file: %v", err)
+		}
+		c.AdminPasswordHash = strings.TrimSpace(string(buf))
+	}
+
+	if c.AdminRequireTOTP && c.AdminTOTPFile == "" {
+		addErrorf("AdminRequireTOTP requires AdminTOTPFile")
+	}
+
+	if ae := c.AuthEvents; ae != nil && ae.File == "" && ae.UnixSocket == "" {
+		addErrorf("AuthEvents requires File and/or UnixSocket")
+	}
+
+	if mp := c.MetricsPush; mp != nil {
+		if mp.Interval < 0 {
+			addErrorf("MetricsPush interval cannot be negative")
+		} else if mp.Interval == 0 {
+			mp.Interval = time.Minute
+		}
+		if mp.RemoteWrite == nil && mp.StatsD == nil {
+			addErrorf("MetricsPush requires RemoteWrite and/or StatsD")
+		}
+		if rw := mp.RemoteWrite; rw != nil {
+			if u, err := url.Parse(rw.URL); err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+				addErrorf("MetricsPush remote-write url %q must be an http or https url", rw.URL)
+			}
+			if rw.BearerToken != "" && (rw.Username != "" || rw.Password != "") {
+				addErrorf("MetricsPush remote-write cannot have both bearer token and username/password")
+			}
+			rw.EffectivePassword, err = resolveSecret(ctx, configFile, rw.Password)
+			if err != nil {
+				addErrorf("MetricsPush remote-write password: %v", err)
+			}
+			rw.EffectiveBearerToken, err = resolveSecret(ctx, configFile, rw.BearerToken)
+			if err != nil {
+				addErrorf("MetricsPush remote-write bearer token: %v", err)
+			}
+		}
+		if sd := mp.StatsD; sd != nil {
+			if _, _, err := net.SplitHostPort(sd.Address); err != nil {
+				addErrorf("MetricsPush statsd address %q must be host:port: %v", sd.Address, err)
+			}
+		}
+	}
+
+	if bs := c.BackupSchedule; bs != nil {
+		if _, err := ParseSchedule(bs.Schedule); err != nil {
+			addErrorf("BackupSchedule schedule: %v", err)
+		}
+		if bs.Directory == "" {
+			addErrorf("BackupSchedule directory must be set")
+		} else if strings.Contains(bs.Directory, "://") {
+			addErrorf("BackupSchedule directory must be a local directory, remote destinations are not supported")
+		} else {
+			// Backups in the data directory would be included in later backups.
+			dataDir := filepath.Clean(configDirPath(configFile, c.DataDir))
+			dir := filepath.Clean(dataDirPath(configFile, c.DataDir, bs.Directory))
+			if rel, err := filepath.Rel(dataDir, dir); err == nil && filepath.IsLocal(rel) && !strings.HasPrefix(rel, "tmp"+string(filepath.Separator)) {
+				addErrorf("BackupSchedule directory within the data directory must be in its tmp directory, e.g. tmp/backups")
+			}
+		}
+		if bs.KeepDaily < 0 || bs.KeepWeekly < 0 {
+			addErrorf("BackupSchedule KeepDaily and KeepWeekly cannot be negative")
+		}
+		if bs.KeepDaily == 0 {
+			bs.KeepDaily = 7
+		}
+	}
+
+	if cl := c.ClamAV; cl != nil {
+		cl.Network, cl.NetworkAddr, err = parseSocketAddress(cl.Address)
+		if err != nil {
+			addErrorf("ClamAV: %v", err)
+		}
+		if cl.Timeout < 0 || cl.MaxSize < 0 {
+			addErrorf("ClamAV Timeout and MaxSize cannot be negative")
+		}
+		if cl.Timeout == 0 {
+			cl.Timeout = time.Minute
+		}
+		if cl.MaxSize == 0 {
+			cl.MaxSize = 25 * 1024 * 1024
+		}
+		switch cl.Action {
+		case "":
+			cl.Action = "reject"
+		case "reject", "quarantine":
+		default:
+			addErrorf("ClamAV Action %q must be reject or quarantine", cl.Action)
+		}
+	}
+
+	if r := c.Rspamd; r != nil {
+		if u, err := url.Parse(r.URL); err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+			addErrorf("Rspamd url %q must be an http or https url", r.URL)
+		}
+		r.EffectivePassword, err = resolveSecret(ctx, configFile, r.Password)
+		if err != nil {
+			addErrorf("Rspamd password: %v", err)
+		}
+		if r.Timeout < 0 {
+			addErrorf("Rspamd Timeout cannot be negative")
+		} else if r.Timeout == 0 {
+			r.Timeout = 30 * time.Second
+		}
+		switch r.Mode {
+		case "":
+			r.Mode = "replace"
+		case "replace", "combine":
+		default:
+			addErrorf("Rspamd Mode %q must be replace or combine", r.Mode)
+		}
+	}
+
+	if l := c.MessageLimits; l != nil {
+		if l.MaxHeaderFields < 0 || l.MaxHeaderSize < 0 || l.MaxDepth < 0 || l.MaxParts < 0 || l.MaxDecodedSize < 0 {
+			addErrorf("MessageLimits fields cannot be negative")
+		}
+	}
+
+	for i := range c.IMAPClientQuirks {
+		q := &c.IMAPClientQuirks[i]
+		if q.ClientName == "" {
+			addErrorf("IMAPClientQuirks %d: ClientName is required", i)
+		}
+		for j, cap := range q.CapabilitiesDisabled {
+			q.CapabilitiesDisabled[j] = strings.ToUpper(cap)
+		}
+	}
+
+	if l := c.LoopDetection; l != nil {
+		if l.MaxHops < 0 || l.MaxOwnHops < 0 {
+			addErrorf("LoopDetection fields cannot be negative")
+		}
+	}
+
+	if mc := c.MessageCompression; mc != nil && mc.MinSize < 0 {
+		addErrorf("MessageCompression MinSize cannot be negative")
+	}
+
+	if l := c.AuthLockout; l != nil {
+		if l.IPFailures < 0 || l.AccountFailures < 0 || l.IPWindow < 0 || l.IPDuration < 0 || l.AccountWindow < 0 || l.AccountDuration < 0 {
+			addErrorf("AuthLockout fields cannot be negative")
+		}
+		if l.IPWindow == 0 {
+			l.IPWindow = time.Hour
+		}
+		if l.IPDuration == 0 {
+			l.IPDuration = 24 * time.Hour
+		}
+		if l.AccountWindow == 0 {
+			l.AccountWindow = time.Hour
+		}
+		if l.AccountDuration == 0 {
+			l.AccountDuration = time.Hour
+		}
+	}
 	return
 }
 
@@ -1227,10 +1775,21 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 		parseRouteDomains := func(l []string) []string {
 			var r []string
 			for _, e := range l {
-				if e == "." {
+				if e == "." || e == "[]" {
 					r = append(r, e)
 					continue
 				}
+				if strings.HasPrefix(e, "[") && strings.HasSuffix(e, "]") {
+					// Address literal, ../rfc/5321:2304
+					ipstr := strings.TrimPrefix(e[1:len(e)-1], "IPv6:")
+					ip := net.ParseIP(ipstr)
+					if ip == nil {
+						addErrorf("%s: invalid address literal %s", descr, e)
+					} else {
+						r = append(r, "["+ip.String()+"]")
+					}
+					continue
+				}
 				prefix := ""
 				if strings.HasPrefix(e, ".") {
 					prefix = "."
@@ -1258,6 +1817,43 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 
 	checkRoutes("global routes", c.Routes)
 
+	// Validate tenants.
+	for name, t := range c.Tenants {
+		addTenantErrorf := func(format string, args ...any) {
+			addErrorf("tenant %q: %s", name, fmt.Sprintf(format, args...))
+		}
+
+		if !tenantNameRegexp.MatchString(name) {
+			addTenantErrorf("invalid name, must consist of lower-case letters, digits and dashes")
+		}
+		if t.MaxOutgoingMessagesPerDay < 0 || t.MaxFirstTimeRecipientsPerDay < 0 {
+			addTenantErrorf("limits cannot be negative")
+		}
+		t.IPs = nil
+		for _, s := range t.OutgoingIPs {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				addTenantErrorf("invalid outgoing ip %q", s)
+				continue
+			}
+			t.IPs = append(t.IPs, ip)
+		}
+		c.Tenants[name] = t
+	}
+
+	// tenantOf returns the tenant of the (unicode) domain, or an empty string.
+	tenantOf := func(d string) string {
+		return c.Domains[d].Tenant
+	}
+	// accountTenant returns the tenant of the domain of the account.
+	accountTenant := func(accName string) string {
+		acc := c.Accounts[accName]
+		if d, err := dns.ParseDomain(acc.Domain); err == nil {
+			return tenantOf(d.Name())
+		}
+		return ""
+	}
+
 	// Validate domains.
 	c.ClientSettingDomains = map[dns.Domain]struct{}{}
 	for d, domain := range c.Domains {
@@ -1283,6 +1879,47 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 			c.ClientSettingDomains[csd] = struct{}{}
 		}
 
+		if _, ok := static.ACME[domain.ACME]; domain.ACME != "" && !ok {
+			addDomainErrorf("unknown ACME provider %q", domain.ACME)
+		}
+
+		if domain.AliasOf != "" {
+			ad, err := dns.ParseDomain(domain.AliasOf)
+			if err != nil {
+				addDomainErrorf("bad AliasOf domain %q: %s", domain.AliasOf, err)
+			} else if ad.Name() != domain.AliasOf {
+				addDomainErrorf("AliasOf domain must be specified in unicode form, %s", ad.Name())
+			} else if ad == dnsdomain {
+				addDomainErrorf("domain cannot be an alias of itself")
+			} else if target, ok := c.Domains[ad.Name()]; !ok {
+				addDomainErrorf("AliasOf domain %s does not exist", ad.Name())
+			} else if target.AliasOf != "" {
+				addDomainErrorf("AliasOf domain %s is itself an alias", ad.Name())
+			}
+			domain.AliasOfDomain = ad
+			if len(domain.Aliases) > 0 || len(domain.DestinationPatterns) > 0 {
+				addDomainErrorf("domain that is an alias cannot have aliases or destination patterns, configure them at domain %s", domain.AliasOf)
+			}
+			if domain.LocalpartCatchallSeparator != "" || len(domain.LocalpartCatchallSeparators) > 0 || domain.LocalpartCaseSensitive {
+				addDomainErrorf("domain that is an alias uses the localpart catchall separators and case-sensitivity of domain %s", domain.AliasOf)
+			}
+		}
+
+		if domain.Tenant != "" {
+			if _, ok := c.Tenants[domain.Tenant]; !ok {
+				addDomainErrorf("unknown tenant %q", domain.Tenant)
+			}
+		}
+		if domain.AliasOf != "" && domain.Tenant != tenantOf(domain.AliasOf) {
+			addDomainErrorf("domain must have the same tenant as AliasOf domain %s", domain.AliasOf)
+		}
+
+		switch domain.VirusScan {
+		case "", "enabled", "disabled":
+		default:
+			addDomainErrorf("VirusScan %q must be empty, enabled or disabled", domain.VirusScan)
+		}
+
 		if domain.LocalpartCatchallSeparator != "" && len(domain.LocalpartCatchallSeparators) != 0 {
 			addDomainErrorf("cannot have both LocalpartCatchallSeparator and LocalpartCatchallSeparators")
 		}
@@ -1336,7 +1973,7 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 				addSelectorErrorf("unsupported hash %q", sel.HashEffective)
 			}
 
-			pemBuf, err := os.ReadFile(configDirPath(dynamicPath, sel.PrivateKeyFile))
+			pemBuf, err := resolveSecretFile(ctx, dynamicPath, sel.PrivateKeyFile)
 			if err != nil {
 				addSelectorErrorf("reading private key: %s", err)
 				continue
@@ -1411,11 +2048,167 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 			}
 		}
 
+		if domain.BIMI != nil {
+			b := domain.BIMI
+			if b.Selector != "" {
+				if _, err := dns.ParseDomain(b.Selector); err != nil || strings.Contains(b.Selector, ".") {
+					addDomainErrorf("invalid BIMI selector %q", b.Selector)
+				}
+			}
+			if b.Location == "" {
+				addDomainErrorf("BIMI location must be set")
+			}
+			r := bimi.DefaultRecord
+			r.Location = b.Location
+			r.Authority = b.Authority
+			if _, _, err := bimi.ParseRecord(r.String()); err != nil {
+				addDomainErrorf("invalid BIMI configuration: %s", err)
+			}
+		}
+
+		if r := domain.RejectedRetention; r != nil {
+			if r.MaxAge < 0 {
+				addDomainErrorf("rejected retention: max age must be positive")
+			}
+			if r.MaxSize < 0 {
+				addDomainErrorf("rejected retention: max size must be positive")
+			}
+		}
+
+		if rt := domain.RejectText; rt != nil {
+			checkTemplate := func(what, tmpl string) {
+				if len(tmpl) >= 256 {
+					addDomainErrorf("reject text: %s must be smaller than 256 bytes", what)
+				}
+				for _, c := range tmpl {
+					if c < ' ' || c >= 0x7f {
+						addDomainErrorf("reject text: %s cannot contain control characters (including newlines) or non-ascii", what)
+						break
+					}
+				}
+				s := tmpl
+				for _, p := range config.RejectTextPlaceholders {
+					s = strings.ReplaceAll(s, p, "")
+				}
+				if strings.Contains(s, "{") {
+					addDomainErrorf("reject text: %s has unknown placeholder, only %s are allowed", what, strings.Join(config.RejectTextPlaceholders, ", "))
+				}
+			}
+			checkTemplate("default", rt.Default)
+			for reason, tmpl := range rt.Reasons {
+				if reason == "" {
+					addDomainErrorf("reject text: empty reason")
+				}
+				checkTemplate(fmt.Sprintf("reason %q", reason), tmpl)
+			}
+			checkTemplate("contact url", rt.ContactURL)
+			if rt.ContactURL != "" {
+				if u, err := url.Parse(rt.ContactURL); err != nil || u.Scheme == "" {
+					addDomainErrorf("reject text: contact url must be an absolute url")
+				}
+			}
+		}
+
+		if r := domain.Retire; r != nil {
+			if r.NewDomain != "" {
+				nd, err := dns.ParseDomain(r.NewDomain)
+				if err != nil {
+					addDomainErrorf("retire: parsing new domain: %v", err)
+				} else if nd == domain.Domain {
+					addDomainErrorf("retire: new domain must be different from the domain")
+				}
+				r.NewDomainParsed = nd
+			}
+			if len(r.Message) >= 256 {
+				addDomainErrorf("retire: message must be smaller than 256 bytes")
+			}
+			for _, c := range r.Message {
+				if c < ' ' || c >= 0x7f {
+					addDomainErrorf("retire: message cannot contain control characters (including newlines) or non-ascii")
+					break
+				}
+			}
+			parseDate := func(s, field string) time.Time {
+				if s == "" {
+					return time.Time{}
+				}
+				if t, err := time.Parse("2006-01-02", s); err == nil {
+					return t
+				}
+				t, err := time.Parse(time.RFC3339, s)
+				if err != nil {
+					addDomainErrorf("retire: %s must be a date like 2006-01-02 or an RFC3339 timestamp: %v", field, err)
+				}
+				return t
+			}
+			r.RejectAfterTime = parseDate(r.RejectAfter, "reject after")
+			r.LoginUntilTime = parseDate(r.LoginUntil, "login until")
+		}
+
 		checkRoutes("routes for domain", domain.Routes)
 
 		c.Domains[d] = domain
 	}
 
+	// Alias domains use the localpart catchall separators of their target domain.
+	for d, domain := range c.Domains {
+		if target, ok := c.Domains[domain.AliasOf]; ok && domain.AliasOf != "" {
+			domain.LocalpartCatchallSeparatorsEffective = target.LocalpartCatchallSeparatorsEffective
+			c.Domains[d] = domain
+		}
+	}
+
+	// Subdomain routing, needs all domains to be known.
+	for d, domain := range c.Domains {
+		if domain.Subdomains == nil {
+			continue
+		}
+		if domain.AliasOf != "" {
+			addErrorf("domain %s: domain that is an alias cannot accept subdomains, configure them at domain %s", d, domain.AliasOf)
+		}
+		switch dmarc.Policy(domain.Subdomains.DMARCPolicy) {
+		case dmarc.PolicyEmpty, dmarc.PolicyNone, dmarc.PolicyQuarantine, dmarc.PolicyReject:
+		default:
+			addErrorf("domain %s: subdomains: invalid dmarc policy %q, must be none, quarantine or reject", d, domain.Subdomains.DMARCPolicy)
+		}
+		for i, r := range domain.Subdomains.Routes {
+			addRouteErrorf := func(format string, args ...any) {
+				addErrorf("domain %s: subdomain route %d: %s", d, i+1, fmt.Sprintf(format, args...))
+			}
+
+			sub := r.Subdomain
+			if sub == "*" {
+				sub = ""
+				r.Wildcard = true
+			} else if strings.HasPrefix(sub, "*.") {
+				sub = sub[2:]
+				r.Wildcard = true
+			}
+			if sub != "" {
+				sd, err := dns.ParseDomain(sub)
+				if err != nil {
+					addRouteErrorf("parsing subdomain %q: %v", r.Subdomain, err)
+				}
+				r.SubdomainASCII = sd.ASCII
+			} else if !r.Wildcard {
+				addRouteErrorf("subdomain must be set")
+			}
+
+			switch r.Deliver {
+			case "", "localpart", "subdomain":
+			default:
+				addr, err := smtp.ParseAddress(r.Deliver)
+				if err != nil {
+					addRouteErrorf("deliver must be localpart, subdomain or an email address: %v", err)
+				} else if _, ok := c.Domains[addr.Domain.Name()]; !ok {
+					addRouteErrorf("domain of deliver address %s is not configured", addr)
+				}
+				r.DeliverAddress = addr
+			}
+			domain.Subdomains.Routes[i] = r
+		}
+	}
+
 	// To determine ReportsOnly.
 	domainHasAddress := map[string]bool{}
 
@@ -1435,6 +2228,40 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 			addAccountErrorf("cannot set RejectsMailbox to inbox, messages will be removed automatically from the rejects mailbox")
 		}
 		checkMailboxNormf(acc.RejectsMailbox, "rejects mailbox", addErrorf)
+		switch acc.RejectsRescueAllow {
+		case "", "address", "domain":
+		default:
+			addAccountErrorf("RejectsRescueAllow must be empty, \"address\" or \"domain\", not %q", acc.RejectsRescueAllow)
+		}
+
+		retentionMailboxes := map[string]bool{}
+		for i, mr := range acc.MailboxRetention {
+			if mr.Mailbox == "" {
+				addAccountErrorf("mailbox retention %d: missing mailbox", i+1)
+			}
+			checkMailboxNormf(mr.Mailbox, "mailbox retention mailbox", addErrorf)
+			if retentionMailboxes[mr.Mailbox] {
+				addAccountErrorf("mailbox retention %d: duplicate mailbox %q", i+1, mr.Mailbox)
+			}
+			retentionMailboxes[mr.Mailbox] = true
+			if mr.Period < time.Hour {
+				addAccountErrorf("mailbox retention %d: period must be at least 1h", i+1)
+			}
+		}
+
+		if acc.AutoArchive != nil {
+			if acc.AutoArchive.Period < 24*time.Hour {
+				addAccountErrorf("auto archive period must be at least 24h")
+			}
+			checkMailboxNormf(acc.AutoArchive.Mailbox, "auto archive mailbox", addErrorf)
+			if strings.EqualFold(acc.AutoArchive.Mailbox, "Inbox") || strings.HasPrefix(strings.ToLower(acc.AutoArchive.Mailbox), "inbox/") {
+				addAccountErrorf("auto archive mailbox cannot be inbox or one of its children")
+			}
+		}
+
+		if acc.DeliveryPriority < -9 || acc.DeliveryPriority > 9 {
+			addAccountErrorf("delivery priority must be between -9 and 9")
+		}
 
 		if len(acc.LoginDisabled) > 256 {
 			addAccountErrorf("message for disabled login must be <256 characters")
@@ -1446,6 +2273,73 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 			}
 		}
 
+		if len(acc.SubmissionDisabled) > 256 {
+			addAccountErrorf("message for disabled submission must be <256 characters")
+		}
+		for _, c := range acc.SubmissionDisabled {
+			// For SMTP.
+			if c < ' ' || c >= 0x7f {
+				addAccountErrorf("message for disabled submission cannot contain control characters including newlines, and must be ascii-only")
+				break
+			}
+		}
+
+		if acc.AttachmentLinks != nil {
+			if acc.AttachmentLinks.MinSize <= 0 {
+				addAccountErrorf("attachment links: MinSize must be > 0")
+			}
+			if acc.AttachmentLinks.ValidityDays < 0 || acc.AttachmentLinks.ValidityDays > 90 {
+				addAccountErrorf("attachment links: ValidityDays must be between 0 (default) and 90")
+			}
+		}
+
+		for i := range acc.Notifications {
+			n := &acc.Notifications[i]
+			addNotificationErrorf := func(format string, args ...any) {
+				addAccountErrorf("notification %d: %s", i+1, fmt.Sprintf(format, args...))
+			}
+			switch n.Type {
+			case "ntfy", "webhook":
+			case "gotify":
+				if n.Token == "" {
+					addNotificationErrorf("gotify requires a token")
+				}
+			default:
+				addNotificationErrorf("unknown type %q, must be ntfy, gotify or webhook", n.Type)
+			}
+			if u, err := url.Parse(n.URL); err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+				addNotificationErrorf("URL %q must be an http or https url", n.URL)
+			}
+			for _, mb := range n.Mailboxes {
+				checkMailboxNormf(mb, "notification mailbox", addErrorf)
+			}
+			var err error
+			n.EffectiveToken, err = resolveSecret(ctx, dynamicPath, n.Token)
+			if err != nil {
+				addNotificationErrorf("token: %v", err)
+			}
+			if n.FromRegexp != "" {
+				n.FromRegexpCompiled, err = regexp.Compile(n.FromRegexp)
+				if err != nil {
+					addNotificationErrorf("invalid FromRegexp regular expression: %v", err)
+				}
+			}
+			if n.SubjectRegexp != "" {
+				n.SubjectRegexpCompiled, err = regexp.Compile(n.SubjectRegexp)
+				if err != nil {
+					addNotificationErrorf("invalid SubjectRegexp regular expression: %v", err)
+				}
+			}
+		}
+
+		if acc.IMAPReferralHost != "" {
+			d, err := dns.ParseDomain(acc.IMAPReferralHost)
+			if err != nil {
+				addAccountErrorf("parsing imap referral host: %v", err)
+			}
+			acc.IMAPReferralHostDNS = d
+		}
+
 		if acc.AutomaticJunkFlags.JunkMailboxRegexp != "" {
 			r, err := regexp.Compile(acc.AutomaticJunkFlags.JunkMailboxRegexp)
 			if err != nil {
@@ -1563,7 +2457,7 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 				t := strings.SplitN(dest.SMTPError, " ", 2)
 				switch t[0] {
 				default:
-					addDestErrorf("smtp error must be 421 or 550 (with optional message), not %q", dest.SMTPError)
+					addDestErrorf("smtp error must be 421, 550 (with optional message) or 551 (with new address), not %q", dest.SMTPError)
 
 				case "421":
 					dest.SMTPErrorCode = smtp.C451LocalErr
@@ -1573,8 +2467,22 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 					dest.SMTPErrorCode = smtp.C550MailboxUnavail
 					dest.SMTPErrorSecode = smtp.SeAddr1UnknownDestMailbox1
 					dest.SMTPErrorMsg = "no such user(s)"
+				case "551":
+					// ../rfc/5321:2490
+					dest.SMTPErrorCode = smtp.C551UserNotLocal
+					dest.SMTPErrorSecode = smtp.SeAddr1DestMailboxMoved6
+					if len(t) != 2 {
+						addDestErrorf("smtp error 551 requires the new address")
+						break
+					}
+					addr, err := smtp.ParseAddress(strings.TrimSpace(t[1]))
+					if err != nil {
+						addDestErrorf("parsing new address for smtp error 551: %v", err)
+						break
+					}
+					dest.SMTPErrorMsg = fmt.Sprintf("user not local; please try <%s>", addr.Pack(true))
 				}
-				if len(t) > 1 {
+				if len(t) > 1 && t[0] != "551" {
 					dest.SMTPErrorMsg = strings.TrimSpace(t[1])
 				}
 				acc.Destinations[addrName] = dest
@@ -1592,6 +2500,18 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 				}
 			}
 
+			if len(dest.ForwardTo) > 0 && dest.SMTPError != "" {
+				addDestErrorf("cannot have both SMTPError and ForwardTo")
+			}
+			if dest.ForwardKeepCopy && len(dest.ForwardTo) == 0 {
+				addDestErrorf("ForwardKeepCopy requires ForwardTo")
+			}
+			for _, s := range dest.ForwardTo {
+				if _, err := smtp.ParseAddress(s); err != nil {
+					addDestErrorf("invalid ForwardTo address %q: %v", s, err)
+				}
+			}
+
 			for i, rs := range dest.Rulesets {
 				addRulesetErrorf := func(format string, args ...any) {
 					addDestErrorf("ruleset %d: %s", i+1, fmt.Sprintf(format, args...))
@@ -1647,6 +2567,68 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 				}
 				c.Accounts[accName].Destinations[addrName].Rulesets[i].HeadersRegexpCompiled = hdr
 
+				var patterns []*regexp.Regexp
+				for _, pat := range rs.MsgFromPatterns {
+					n++
+					if pat == "" {
+						addRulesetErrorf("empty MsgFromPatterns pattern")
+						continue
+					}
+					// Each '*' matches any text, the rest is literal.
+					l := strings.Split(pat, "*")
+					for j, t := range l {
+						l[j] = regexp.QuoteMeta(t)
+					}
+					patterns = append(patterns, regexp.MustCompile("(?i)^"+strings.Join(l, ".*")+"$"))
+				}
+				c.Accounts[accName].Destinations[addrName].Rulesets[i].MsgFromPatternsCompiled = patterns
+
+				switch rs.SPFResult {
+				case "":
+				case "pass", "fail", "softfail", "neutral", "none", "temperror", "permerror":
+					n++
+				default:
+					addRulesetErrorf("invalid SPFResult %q, must be pass, fail, softfail, neutral, none, temperror or permerror", rs.SPFResult)
+				}
+				switch rs.DKIMResult {
+				case "":
+				case "pass", "none":
+					n++
+				default:
+					addRulesetErrorf("invalid DKIMResult %q, must be pass or none", rs.DKIMResult)
+				}
+				switch rs.DMARCResult {
+				case "":
+				case "pass", "fail":
+					n++
+				default:
+					addRulesetErrorf("invalid DMARCResult %q, must be pass or fail", rs.DMARCResult)
+				}
+				if rs.MinSize < 0 || rs.MaxSize < 0 {
+					addRulesetErrorf("MinSize and MaxSize cannot be negative")
+				} else if rs.MaxSize > 0 && rs.MinSize > rs.MaxSize {
+					addRulesetErrorf("MinSize %d larger than MaxSize %d", rs.MinSize, rs.MaxSize)
+				}
+				if rs.MinSize > 0 {
+					n++
+				}
+				if rs.MaxSize > 0 {
+					n++
+				}
+				switch rs.Attachments {
+				case "":
+				case "yes", "no":
+					n++
+				default:
+					addRulesetErrorf("invalid Attachments %q, must be yes or no", rs.Attachments)
+				}
+				if rs.ListID != "" {
+					n++
+					if strings.ContainsAny(rs.ListID, "<> \t") {
+						addRulesetErrorf("ListID %q must be a list identifier without angle brackets or whitespace", rs.ListID)
+					}
+				}
+
 				if n == 0 {
 					addRulesetErrorf("ruleset must have at least one rule")
 				}
@@ -1671,6 +2653,19 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 				if strings.EqualFold(rs.AcceptRejectsToMailbox, "inbox") {
 					addRulesetErrorf("AcceptRejectsToMailbox cannot be set to Inbox")
 				}
+
+				if rs.Discard {
+					if rs.Mailbox != "" || rs.MarkSeen || rs.AcceptRejectsToMailbox != "" {
+						addRulesetErrorf("ruleset with Discard cannot have Mailbox, MarkSeen or AcceptRejectsToMailbox")
+					}
+				} else if rs.Mailbox == "" {
+					addRulesetErrorf("ruleset must have a Mailbox, or Discard")
+				}
+				for _, s := range rs.ForwardTo {
+					if _, err := smtp.ParseAddress(s); err != nil {
+						addRulesetErrorf("invalid ForwardTo address %q: %v", s, err)
+					}
+				}
 			}
 
 			// Catchall destination for domain.
@@ -1679,9 +2674,15 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 				if err != nil {
 					addDestErrorf("parsing domain %q", addrName[1:])
 					continue
-				} else if _, ok := c.Domains[d.Name()]; !ok {
+				} else if dc, ok := c.Domains[d.Name()]; !ok {
 					addDestErrorf("unknown domain for address")
 					continue
+				} else if dc.AliasOf != "" {
+					addDestErrorf("domain is an alias of %s, configure the address at that domain", dc.AliasOf)
+					continue
+				}
+				if tenantOf(d.Name()) != tenantOf(acc.DNSDomain.Name()) {
+					addDestErrorf("domain belongs to another tenant than the account")
 				}
 				domainHasAddress[d.Name()] = true
 				addrFull := "@" + d.Name()
@@ -1718,6 +2719,13 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 
 			origLP := address.Localpart
 			dc := c.Domains[address.Domain.Name()]
+			if dc.AliasOf != "" {
+				addDestErrorf("domain is an alias of %s, configure the address at that domain", dc.AliasOf)
+				continue
+			}
+			if dc.Tenant != tenantOf(acc.DNSDomain.Name()) {
+				addDestErrorf("domain belongs to another tenant than the account")
+			}
 			domainHasAddress[address.Domain.Name()] = true
 			lp := CanonicalLocalpart(address.Localpart, dc)
 			var hasSep bool
@@ -1780,6 +2788,8 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 		}
 		if _, ok := c.Accounts[dmarc.Account]; !ok {
 			addDomainErrorf("DMARC account %q does not exist", dmarc.Account)
+		} else if accountTenant(dmarc.Account) != domain.Tenant {
+			addDomainErrorf("DMARC account %q belongs to another tenant", dmarc.Account)
 		}
 
 		// Note: For backwards compabilitiy, DMARC reporting localparts can contain catchall separators.
@@ -1832,6 +2842,8 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 		}
 		if _, ok := c.Accounts[tlsrpt.Account]; !ok {
 			addDomainErrorf("TLSRPT account %q does not exist", tlsrpt.Account)
+		} else if accountTenant(tlsrpt.Account) != domain.Tenant {
+			addDomainErrorf("TLSRPT account %q belongs to another tenant", tlsrpt.Account)
 		}
 
 		// Note: For backwards compabilitiy, TLS reporting localparts can contain catchall separators.
@@ -1873,8 +2885,53 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 		accDests[addrFull] = AccountDestination{false, lp, tlsrpt.Account, dest}
 	}
 
+	// Destination patterns, per domain.
+	for d, domain := range c.Domains {
+		for i, dp := range domain.DestinationPatterns {
+			addPatternErrorf := func(format string, args ...any) {
+				addErrorf("domain %s: destination pattern %d: %s", d, i+1, fmt.Sprintf(format, args...))
+			}
+
+			if _, ok := c.Accounts[dp.Account]; !ok {
+				addPatternErrorf("account %q does not exist", dp.Account)
+			} else if accountTenant(dp.Account) != domain.Tenant {
+				addPatternErrorf("account %q belongs to another tenant", dp.Account)
+			}
+			checkMailboxNormf(dp.Mailbox, "mailbox", addPatternErrorf)
+
+			var expr string
+			if dp.Localpart != "" && dp.LocalpartRegexp != "" || dp.Localpart == "" && dp.LocalpartRegexp == "" {
+				addPatternErrorf("exactly one of Localpart and LocalpartRegexp must be set")
+				continue
+			} else if dp.Localpart != "" {
+				lp := dp.Localpart
+				if !domain.LocalpartCaseSensitive {
+					lp = strings.ToLower(lp)
+				}
+				expr = regexp.QuoteMeta(lp)
+				expr = strings.ReplaceAll(expr, `\*`, ".*")
+				expr = strings.ReplaceAll(expr, `\?`, ".")
+			} else {
+				expr = dp.LocalpartRegexp
+			}
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				addPatternErrorf("compiling localpart pattern: %v", err)
+				continue
+			}
+			domain.DestinationPatterns[i].LocalpartRegexpCompiled = re
+			domainHasAddress[domain.Domain.Name()] = true
+		}
+	}
+
 	// Set ReportsOnly for domains, based on whether we have seen addresses (possibly
-	// from DMARC or TLS reporting).
+	// from DMARC or TLS reporting, or through the domain an alias domain is an alias
+	// of).
+	for d, domain := range c.Domains {
+		if domain.AliasOf != "" && domainHasAddress[domain.AliasOf] {
+			domainHasAddress[d] = true
+		}
+	}
 	for d, domain := range c.Domains {
 		domain.ReportsOnly = !domainHasAddress[domain.Domain.Name()]
 		c.Domains[d] = domain
@@ -1940,6 +2997,10 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 					addAliasErrorf("duplicate address %q", destAddr)
 					continue
 				}
+				if accountTenant(accDest.Account) != domain.Tenant {
+					addAliasErrorf("address %q belongs to account of another tenant", destAddr)
+					continue
+				}
 				seen[dastr] = true
 				aa := config.AliasAddress{Address: da, AccountName: accDest.Account, Destination: accDest.Destination}
 				a.ParsedAddresses = append(a.ParsedAddresses, aa)
@@ -1971,6 +3032,54 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 		}
 	}
 
+	for _, s := range forwardLoops(c, accDests, aliases) {
+		addErrorf("forwarding loop between local addresses: %s", s)
+	}
+
+	// Mailing lists, per domain. The list and request addresses must not be in use.
+	for d, domain := range c.Domains {
+		for lpstr, l := range domain.Lists {
+			addListErrorf := func(format string, args ...any) {
+				addErrorf("domain %s: list %s: %s", d, lpstr, fmt.Sprintf(format, args...))
+			}
+
+			lp, err := smtp.ParseLocalpart(lpstr)
+			if err != nil {
+				addListErrorf("parsing list localpart: %v", err)
+				continue
+			}
+			if domain.AliasOf != "" {
+				addListErrorf("domain that is an alias cannot have lists, configure them at domain %s", domain.AliasOf)
+				continue
+			}
+			if slices.ContainsFunc(domain.LocalpartCatchallSeparatorsEffective, func(sep string) bool { return strings.Contains(string(lp), sep) }) {
+				addListErrorf("list localpart contains localpart catchall separator")
+				continue
+			}
+			for _, xlp := range []smtp.Localpart{lp, lp + "-request"} {
+				addr := smtp.NewAddress(CanonicalLocalpart(xlp, domain), domain.Domain).Pack(true)
+				if _, ok := accDests[addr]; ok {
+					addListErrorf("list address %q already present as regular address", addr)
+				} else if _, ok := aliases[addr]; ok {
+					addListErrorf("list address %q already present as alias", addr)
+				}
+			}
+			if _, ok := c.Accounts[l.Account]; !ok {
+				addListErrorf("owner account %q does not exist", l.Account)
+			} else if accountTenant(l.Account) != domain.Tenant {
+				addListErrorf("owner account %q belongs to another tenant", l.Account)
+			}
+			l.LocalpartStr = lpstr
+			l.Domain = domain.Domain
+			c.Domains[d].Lists[lpstr] = l
+		}
+		// A domain with only lists is not just for reports.
+		if len(domain.Lists) > 0 && domain.ReportsOnly {
+			domain.ReportsOnly = false
+			c.Domains[d] = domain
+		}
+	}
+
 	// Check webserver configs.
 	if (len(c.WebDomainRedirects) > 0 || len(c.WebHandlers) > 0) && !haveWebserverListener {
 		addErrorf("WebDomainRedirects or WebHandlers configured but no listener with WebserverHTTP or WebserverHTTPS enabled")
@@ -2118,6 +3227,48 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 		}
 	}
 
+	// Check canaries.
+	for name, cn := range c.Canaries {
+		addCanaryErrorf := func(format string, args ...any) {
+			addErrorf("canary %s: %s", name, fmt.Sprintf(format, args...))
+		}
+
+		from, err := smtp.ParseAddress(cn.From)
+		if err != nil {
+			addCanaryErrorf("parsing from address %q: %v", cn.From, err)
+			continue
+		}
+		dc, ok := c.Domains[from.Domain.Name()]
+		if !ok {
+			addCanaryErrorf("from address %s not at a configured domain", from)
+			continue
+		}
+		accDest, ok := accDests[smtp.NewAddress(CanonicalLocalpart(from.Localpart, dc), from.Domain).Pack(true)]
+		if !ok {
+			addCanaryErrorf("from address %s is not an address of an account", from)
+			continue
+		}
+		to, err := smtp.ParseAddress(cn.To)
+		if err != nil {
+			addCanaryErrorf("parsing to address %q: %v", cn.To, err)
+			continue
+		}
+		if cn.Interval != 0 && cn.Interval < 5*time.Minute {
+			addCanaryErrorf("interval must be at least 5m")
+		}
+		interval := cn.Interval
+		if interval == 0 {
+			interval = time.Hour
+		}
+		if cn.Deadline < 0 || cn.Deadline >= interval {
+			addCanaryErrorf("deadline must be smaller than interval")
+		}
+		cn.FromAddress = from
+		cn.ToAddress = to
+		cn.Account = accDest.Account
+		c.Canaries[name] = cn
+	}
+
 	c.MonitorDNSBLZones = nil
 	for _, s := range c.MonitorDNSBLs {
 		d, err := dns.ParseDomain(s)
@@ -2175,13 +3326,33 @@ func loadTLSKeyCerts(configFile, kind string, ctls *config.TLS) error {
 		}
 		certs = append(certs, cert)
 	}
+	ctls.KeyCertsLoaded = &atomic.Pointer[[]tls.Certificate]{}
+	ctls.KeyCertsLoaded.Store(&certs)
 	ctls.Config = &tls.Config{
-		Certificates: certs,
+		// Certificates can be replaced at runtime, so we select them ourselves.
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return selectCertificate(*ctls.KeyCertsLoaded.Load(), hello), nil
+		},
 	}
 	ctls.ConfigFallback = ctls.Config
 	return nil
 }
 
+// selectCertificate returns the first certificate supported by the client, like
+// crypto/tls does for tls.Config.Certificates, or the first certificate if none
+// is supported.
+func selectCertificate(certs []tls.Certificate, hello *tls.ClientHelloInfo) *tls.Certificate {
+	if len(certs) == 0 {
+		return nil
+	}
+	for i := range certs {
+		if hello.SupportsCertificate(&certs[i]) == nil {
+			return &certs[i]
+		}
+	}
+	return &certs[0]
+}
+
 // load x509 key/cert files from file descriptor possibly passed in by privileged
 // process.
 func loadX509KeyPairPrivileged(certPath, keyPath string) (tls.Certificate, error) {
@@ -2205,3 +3376,25 @@ func readFilePrivileged(path string) ([]byte, error) {
 	defer f.Close()
 	return io.ReadAll(f)
 }
+
+// parseSocketAddress parses an address for an external service, like
+// "unix:/path/to/socket" or "inet:host:port", into a network and address for
+// dialing.
+func parseSocketAddress(s string) (network, addr string, rerr error) {
+	kind, addr, _ := strings.Cut(s, ":")
+	switch kind {
+	case "unix":
+		network = "unix"
+		if addr == "" {
+			return "", "", fmt.Errorf("address %q: missing path", s)
+		}
+	case "inet":
+		network = "tcp"
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return "", "", fmt.Errorf("address %q: %v", s, err)
+		}
+	default:
+		return "", "", fmt.Errorf("address %q must start with \"unix:\" or \"inet:\"", s)
+	}
+	return network, addr, nil
+}
@@ -0,0 +1,35 @@
+package mox
+
+import (
+	"testing"
+
+	"github.com/mjl-/mox/config"
+)
+
+func TestCRAMMD5Disabled(t *testing.T) {
+	test := func(expect bool, listeners ...config.Listener) {
+		t.Helper()
+		c := &Config{}
+		c.Static.Listeners = map[string]config.Listener{}
+		for i, l := range listeners {
+			c.Static.Listeners[string(rune('a'+i))] = l
+		}
+		if disabled := c.CRAMMD5Disabled(); disabled != expect {
+			t.Fatalf("got cram-md5 disabled %v, expected %v", disabled, expect)
+		}
+	}
+
+	var imap, imapNoPlain, smtpOnly config.Listener
+	imap.IMAPS.Enabled = true
+	imapNoPlain.IMAPS.Enabled = true
+	imapNoPlain.Submissions.Enabled = true
+	imapNoPlain.NoPlaintextAuth = true
+	smtpOnly.SMTP.Enabled = true
+
+	test(false)
+	test(false, smtpOnly)
+	test(false, imap)
+	test(true, imapNoPlain)
+	test(true, imapNoPlain, smtpOnly)
+	test(false, imapNoPlain, imap)
+}
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/mjl-/mox/bimi"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dns"
@@ -34,6 +37,44 @@ func DKIMSelectors(dkimConf config.DKIM) []dkim.Selector {
 	return l
 }
 
+// BIMISelectorHeader returns a BIMI-Selector header to add to a DKIM-signed
+// message from the domain, and selectors with the header added to the signed
+// headers. The header is only returned if the domain is configured to add it, and
+// if there are selectors: A BIMI-Selector header must be DKIM-signed.
+func BIMISelectorHeader(confDom config.Domain, selectors []dkim.Selector) (string, []dkim.Selector) {
+	if confDom.BIMI == nil || !confDom.BIMI.SelectorHeader || len(selectors) == 0 {
+		return "", selectors
+	}
+	sel := confDom.BIMI.Selector
+	if sel == "" {
+		sel = bimi.DefaultSelector
+	}
+	l := make([]dkim.Selector, len(selectors))
+	for i, s := range selectors {
+		if !slices.ContainsFunc(s.Headers, func(h string) bool { return strings.EqualFold(h, "BIMI-Selector") }) {
+			s.Headers = append(slices.Clone(s.Headers), "BIMI-Selector")
+		}
+		l[i] = s
+	}
+	return fmt.Sprintf("BIMI-Selector: v=BIMI1; s=%s;\r\n", sel), l
+}
+
+// closestDomain returns the configured domain d, or its closest configured
+// parent domain.
+func closestDomain(d dns.Domain) (dns.Domain, config.Domain, bool) {
+	var zerodom dns.Domain
+	for d != zerodom {
+		if confDom, ok := Conf.Domain(d); ok {
+			return d, confDom, true
+		}
+		var nd dns.Domain
+		_, nd.ASCII, _ = strings.Cut(d.ASCII, ".")
+		_, nd.Unicode, _ = strings.Cut(d.Unicode, ".")
+		d = nd
+	}
+	return zerodom, config.Domain{}, false
+}
+
 // DKIMSign looks up the domain for "from", and uses its DKIM configuration to
 // generate DKIM-Signature headers, for inclusion in a message. The
 // DKIM-Signatur headers, are returned. If no domain was found an empty string and
@@ -42,28 +83,65 @@ func DKIMSign(ctx context.Context, log mlog.Log, from smtp.Path, smtputf8 bool,
 	// Add DKIM signature for domain, even if higher up than the full mail hostname.
 	// This helps with an assumed (because default) relaxed DKIM policy. If the DMARC
 	// policy happens to be strict, the signature won't help, but won't hurt either.
-	fd := from.IPDomain.Domain
-	var zerodom dns.Domain
-	for fd != zerodom {
-		confDom, ok := Conf.Domain(fd)
-		if !ok {
-			var nfd dns.Domain
-			_, nfd.ASCII, _ = strings.Cut(fd.ASCII, ".")
-			_, nfd.Unicode, _ = strings.Cut(fd.Unicode, ".")
-			fd = nfd
-			continue
-		}
+	fd, confDom, ok := closestDomain(from.IPDomain.Domain)
+	if !ok {
+		return "", nil
+	}
+	if confDom.Disabled {
+		return "", ErrDomainDisabled
+	}
 
-		if confDom.Disabled {
-			return "", ErrDomainDisabled
+	selectors := DKIMSelectors(confDom.DKIM)
+	dkimHeaders, err := dkim.Sign(ctx, log.Logger, from.Localpart, fd, selectors, smtputf8, bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("dkim sign for domain %s: %v", fd, err)
+	}
+	return dkimHeaders, nil
+}
+
+// DKIMSignSubmission returns DKIM-Signature headers for a message submitted by an
+// account, with message From address "from" in the domain with configuration
+// confDom, signed with selectors (typically from DKIMSelectors for confDom,
+// possibly modified by the caller). If configured for the From domain, the
+// message is also signed for the domain of the SMTP MAIL FROM address, and for the
+// domain of the hostname. Additional domains that are not configured, are
+// disabled or have no selectors are skipped.
+func DKIMSignSubmission(ctx context.Context, log mlog.Log, confDom config.Domain, from smtp.Address, mailFrom smtp.Path, selectors []dkim.Selector, smtputf8 bool, msg io.ReaderAt) (string, error) {
+	type signDomain struct {
+		localpart smtp.Localpart
+		domain    dns.Domain
+		selectors []dkim.Selector
+	}
+	l := []signDomain{{CanonicalLocalpart(from.Localpart, confDom), from.Domain, selectors}}
+	add := func(lp smtp.Localpart, d dns.Domain, dconf config.Domain) {
+		if dconf.Disabled || slices.ContainsFunc(l, func(sd signDomain) bool { return sd.domain == d }) {
+			return
+		}
+		if sels := DKIMSelectors(dconf.DKIM); len(sels) > 0 {
+			l = append(l, signDomain{lp, d, sels})
 		}
+	}
+	if confDom.DKIM.SignMailFrom && !mailFrom.IsZero() {
+		if dconf, ok := Conf.Domain(mailFrom.IPDomain.Domain); ok {
+			add(CanonicalLocalpart(mailFrom.Localpart, dconf), mailFrom.IPDomain.Domain, dconf)
+		}
+	}
+	if confDom.DKIM.SignHostname {
+		if d, dconf, ok := closestDomain(Conf.Static.HostnameDomain); ok {
+			add("", d, dconf)
+		}
+	}
 
-		selectors := DKIMSelectors(confDom.DKIM)
-		dkimHeaders, err := dkim.Sign(ctx, log.Logger, from.Localpart, fd, selectors, smtputf8, bytes.NewReader(data))
+	var headers string
+	for _, sd := range l {
+		if len(sd.selectors) == 0 {
+			continue
+		}
+		h, err := dkim.Sign(ctx, log.Logger, sd.localpart, sd.domain, sd.selectors, smtputf8, msg)
 		if err != nil {
-			return "", fmt.Errorf("dkim sign for domain %s: %v", fd, err)
+			return "", fmt.Errorf("dkim sign for domain %s: %v", sd.domain, err)
 		}
-		return dkimHeaders, nil
+		headers += h
 	}
-	return "", nil
+	return headers, nil
 }
@@ -0,0 +1,109 @@
+package mox
+
+import (
+	"context"
+	"crypto/ed25519"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dkim"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtp"
+)
+
+func TestBIMISelectorHeader(t *testing.T) {
+	headers := []string{"From", "To"}
+	selectors := []dkim.Selector{{Headers: headers}}
+
+	test := func(b *config.BIMI, sels []dkim.Selector, expHeader string, expHeaders []string) {
+		t.Helper()
+		hdr, l := BIMISelectorHeader(config.Domain{BIMI: b}, sels)
+		if hdr != expHeader {
+			t.Fatalf("got header %q, expected %q", hdr, expHeader)
+		}
+		if len(l) != len(sels) {
+			t.Fatalf("got %d selectors, expected %d", len(l), len(sels))
+		}
+		if len(l) > 0 && !reflect.DeepEqual(l[0].Headers, expHeaders) {
+			t.Fatalf("got signed headers %v, expected %v", l[0].Headers, expHeaders)
+		}
+	}
+
+	test(nil, selectors, "", headers)
+	test(&config.BIMI{Location: "https://mox.example/logo.svg"}, selectors, "", headers)
+	test(&config.BIMI{SelectorHeader: true}, nil, "", nil)
+	test(&config.BIMI{SelectorHeader: true}, selectors, "BIMI-Selector: v=BIMI1; s=default;\r\n", []string{"From", "To", "BIMI-Selector"})
+	test(&config.BIMI{Selector: "brand", SelectorHeader: true}, []dkim.Selector{{Headers: []string{"From", "bimi-selector"}}}, "BIMI-Selector: v=BIMI1; s=brand;\r\n", []string{"From", "bimi-selector"})
+
+	// Headers of selectors from the config must not be modified.
+	if !reflect.DeepEqual(headers, []string{"From", "To"}) {
+		t.Fatalf("headers of configured selector were modified: %v", headers)
+	}
+}
+
+func TestDKIMSignSubmission(t *testing.T) {
+	privKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)) // Fake key, don't use this for real!
+	dkimConf := config.DKIM{
+		Selectors: map[string]config.Selector{
+			"testsel": {
+				HashEffective:    "sha256",
+				HeadersEffective: []string{"From", "To", "Subject"},
+				Key:              privKey,
+				Domain:           dns.Domain{ASCII: "testsel"},
+			},
+		},
+		Sign: []string{"testsel"},
+	}
+
+	origStatic, origDynamic := Conf.Static, Conf.Dynamic
+	defer func() {
+		Conf.Static, Conf.Dynamic = origStatic, origDynamic
+		Conf.DynamicLastCheck = time.Time{}
+	}()
+	Conf.Static.HostnameDomain = dns.Domain{ASCII: "mail.host.example"}
+	Conf.Dynamic.Domains = map[string]config.Domain{
+		"from.example":     {DKIM: dkimConf},
+		"mailfrom.example": {DKIM: dkimConf},
+		"disabled.example": {DKIM: dkimConf, Disabled: true},
+		"host.example":     {DKIM: dkimConf},
+	}
+	// Prevent reloading the domains config from file.
+	Conf.DynamicLastCheck = time.Now().Add(time.Hour)
+
+	const msg = "From: <mjl@from.example>\r\nTo: <other@remote.example>\r\nSubject: test\r\n\r\ntest\r\n"
+	log := mlog.New("mox", nil)
+	from := smtp.NewAddress("mjl", dns.Domain{ASCII: "from.example"})
+	selectors := DKIMSelectors(dkimConf)
+
+	test := func(signMailFrom, signHostname bool, mailFromDomain string, expDomains []string) {
+		t.Helper()
+		confDom := Conf.Dynamic.Domains["from.example"]
+		confDom.DKIM.SignMailFrom = signMailFrom
+		confDom.DKIM.SignHostname = signHostname
+		mailFrom := smtp.Path{Localpart: "mjl", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: mailFromDomain}}}
+		headers, err := DKIMSignSubmission(context.Background(), log, confDom, from, mailFrom, selectors, false, strings.NewReader(msg))
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		var domains []string
+		for _, m := range regexp.MustCompile(`; d=([^;]+);`).FindAllStringSubmatch(headers, -1) {
+			domains = append(domains, m[1])
+		}
+		if !reflect.DeepEqual(domains, expDomains) {
+			t.Fatalf("got signatures for domains %v, expected %v", domains, expDomains)
+		}
+	}
+
+	test(false, false, "mailfrom.example", []string{"from.example"})
+	test(true, false, "mailfrom.example", []string{"from.example", "mailfrom.example"})
+	test(true, false, "from.example", []string{"from.example"})
+	test(true, false, "disabled.example", []string{"from.example"})
+	test(true, false, "unknown.example", []string{"from.example"})
+	test(false, true, "mailfrom.example", []string{"from.example", "host.example"})
+	test(true, true, "mailfrom.example", []string{"from.example", "mailfrom.example", "host.example"})
+}
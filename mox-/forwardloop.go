@@ -0,0 +1,116 @@
+package mox
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/smtp"
+)
+
+// forwardLoops returns descriptions of forwarding loops between local addresses
+// in the configuration: Through ForwardTo of destinations and their rulesets, and
+// through alias members. Loops through external mail servers cannot be detected
+// in the configuration, the smtpserver detects those by the Delivered-To headers
+// added to forwarded messages.
+func forwardLoops(c *config.Dynamic, accDests map[string]AccountDestination, aliases map[string]config.Alias) []string {
+	// resolve returns the account destination or alias an address is delivered to,
+	// or an empty string if it is not local or delivered without forwarding.
+	resolve := func(s string) string {
+		addr, err := smtp.ParseAddress(s)
+		if err != nil {
+			return ""
+		}
+		dom := addr.Domain
+		d, ok := c.Domains[dom.Name()]
+		if !ok {
+			return ""
+		}
+		if d.AliasOf != "" {
+			k := smtp.NewAddress(CanonicalLocalpart(addr.Localpart, d), dom).Pack(true)
+			if _, ok := accDests[k]; ok {
+				return k
+			}
+			dom = d.AliasOfDomain
+			if d, ok = c.Domains[dom.Name()]; !ok {
+				return ""
+			}
+		}
+		lp := CanonicalLocalpart(addr.Localpart, d)
+		k := smtp.NewAddress(lp, dom).Pack(true)
+		if _, ok := accDests[k]; ok {
+			return k
+		} else if _, ok := aliases[k]; ok {
+			return k
+		}
+		// Destination patterns deliver to an account, without forwarding.
+		for _, dp := range d.DestinationPatterns {
+			if dp.LocalpartRegexpCompiled != nil && dp.LocalpartRegexpCompiled.MatchString(string(lp)) {
+				return ""
+			}
+		}
+		if _, ok := accDests["@"+dom.Name()]; ok {
+			return "@" + dom.Name()
+		}
+		return ""
+	}
+
+	// Edges from an address to the local addresses it forwards/expands to.
+	graph := map[string][]string{}
+	for k, ad := range accDests {
+		targets := slices.Clone(ad.Destination.ForwardTo)
+		for _, rs := range ad.Destination.Rulesets {
+			targets = append(targets, rs.ForwardTo...)
+		}
+		for _, s := range targets {
+			if t := resolve(s); t != "" && !slices.Contains(graph[k], t) {
+				graph[k] = append(graph[k], t)
+			}
+		}
+	}
+	for k, a := range aliases {
+		for _, aa := range a.ParsedAddresses {
+			graph[k] = append(graph[k], aa.Address.Pack(true))
+		}
+	}
+
+	// Depth-first search, reporting each cycle once.
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var stack []string
+	seen := map[string]bool{}
+	var loops []string
+	var visit func(k string)
+	visit = func(k string) {
+		state[k] = visiting
+		stack = append(stack, k)
+		for _, t := range graph[k] {
+			switch state[t] {
+			case visiting:
+				cycle := stack[slices.Index(stack, t):]
+				// Start at the lowest address, for a stable description.
+				i := slices.Index(cycle, slices.Min(cycle))
+				cycle = append(slices.Clone(cycle[i:]), cycle[:i]...)
+				s := strings.Join(append(cycle, cycle[0]), " -> ")
+				if !seen[s] {
+					seen[s] = true
+					loops = append(loops, s)
+				}
+			case 0:
+				visit(t)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[k] = done
+	}
+	for _, k := range slices.Sorted(maps.Keys(graph)) {
+		if state[k] == 0 {
+			visit(k)
+		}
+	}
+	return loops
+}
@@ -0,0 +1,61 @@
+package mox
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+)
+
+func TestForwardLoops(t *testing.T) {
+	dom := dns.Domain{ASCII: "mox.example"}
+	aliasDom := dns.Domain{ASCII: "alias.example"}
+	c := &config.Dynamic{
+		Domains: map[string]config.Domain{
+			"mox.example":   {Domain: dom, LocalpartCatchallSeparatorsEffective: []string{"+"}},
+			"alias.example": {Domain: aliasDom, AliasOf: "mox.example", AliasOfDomain: dom},
+		},
+	}
+	dest := func(forwardTo ...string) AccountDestination {
+		return AccountDestination{Account: "mjl", Destination: config.Destination{ForwardTo: forwardTo}}
+	}
+	member := func(s string) config.AliasAddress {
+		return config.AliasAddress{Address: smtp.NewAddress(smtp.Localpart(s), dom)}
+	}
+
+	test := func(accDests map[string]AccountDestination, aliases map[string]config.Alias, expLoops ...string) {
+		t.Helper()
+		loops := forwardLoops(c, accDests, aliases)
+		if !slices.Equal(loops, expLoops) {
+			t.Fatalf("got loops %q, expected %q", loops, expLoops)
+		}
+	}
+
+	// External forward, and to local address without forwarding.
+	test(map[string]AccountDestination{
+		"a@mox.example": dest("a@remote.example", "b@mox.example"),
+		"b@mox.example": dest(),
+	}, nil)
+
+	// Forward to self, with catchall separator and through alias domain.
+	test(map[string]AccountDestination{"a@mox.example": dest("a+x@alias.example")}, nil, "a@mox.example -> a@mox.example")
+
+	// Forward through aliases and rulesets.
+	rs := dest()
+	rs.Destination.Rulesets = []config.Ruleset{{ForwardTo: []string{"list@mox.example"}}}
+	test(map[string]AccountDestination{
+		"a@mox.example": dest("b@mox.example"),
+		"b@mox.example": rs,
+		"c@mox.example": dest(),
+	}, map[string]config.Alias{
+		"list@mox.example": {ParsedAddresses: []config.AliasAddress{member("c"), member("a")}},
+	}, "a@mox.example -> b@mox.example -> list@mox.example -> a@mox.example")
+
+	// Forward to unknown address at local domain ends at the catchall.
+	test(map[string]AccountDestination{
+		"a@mox.example": dest("unknown@mox.example"),
+		"@mox.example":  dest("a@mox.example"),
+	}, nil, "@mox.example -> a@mox.example -> @mox.example")
+}
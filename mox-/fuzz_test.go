@@ -0,0 +1,45 @@
+package mox
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mjl-/sconf"
+
+	"github.com/mjl-/mox/config"
+)
+
+// FuzzParseConfig parses static and dynamic config files, which must not panic.
+// Successfully parsed configs must parse again after writing them. The seed corpus
+// includes the config files from testdata.
+func FuzzParseConfig(f *testing.F) {
+	f.Add([]byte(""))
+	for _, pattern := range []string{"../testdata/*/mox.conf", "../testdata/*/domains.conf"} {
+		names, _ := filepath.Glob(pattern)
+		for _, name := range names {
+			if buf, err := os.ReadFile(name); err == nil {
+				f.Add(buf)
+			}
+		}
+	}
+
+	roundtrip := func(t *testing.T, buf []byte, v, nv any) {
+		if err := sconf.Parse(bytes.NewReader(buf), v); err != nil {
+			return
+		}
+		var b bytes.Buffer
+		if err := sconf.Write(&b, v); err != nil {
+			return
+		}
+		if err := sconf.Parse(bytes.NewReader(b.Bytes()), nv); err != nil {
+			t.Fatalf("parsing written config: %v\noriginal:\n%s\nwritten:\n%s", err, buf, b.Bytes())
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		roundtrip(t, buf, &config.Static{}, &config.Static{})
+		roundtrip(t, buf, &config.Dynamic{}, &config.Dynamic{})
+	})
+}
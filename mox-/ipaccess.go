@@ -0,0 +1,107 @@
+package mox
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+)
+
+var metricIPAccessRefused = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_ipaccess_refused_total",
+		Help: "Connections and HTTP requests refused due to IPAccess configured for listeners/services.",
+	},
+	[]string{
+		"protocol",
+	},
+)
+
+// IPAccessAllowed returns whether ip is allowed by each of the access
+// configurations. Nil configurations allow all IPs.
+func IPAccessAllowed(ip net.IP, l ...*config.IPAccess) bool {
+	for _, a := range l {
+		if !a.Allowed(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// IPAccessListener returns a listener that immediately closes accepted
+// connections from remote IPs not allowed by the access configurations. If all
+// access configurations are nil, ln is returned as is.
+func IPAccessListener(ln net.Listener, protocol string, l ...*config.IPAccess) net.Listener {
+	for _, a := range l {
+		if a != nil {
+			return ipAccessListener{ln, protocol, l}
+		}
+	}
+	return ln
+}
+
+type ipAccessListener struct {
+	net.Listener
+	protocol string
+	access   []*config.IPAccess
+}
+
+func (ln ipAccessListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ln.Listener.Accept()
+		if err != nil {
+			return conn, err
+		}
+		if IPAccessAllowedConn(conn, ln.protocol, ln.access...) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
+
+// IPAccessAllowedConn returns whether the remote IP of conn is allowed by the
+// access configurations. If not, the refusal is logged and counted, and the
+// caller must close the connection.
+func IPAccessAllowedConn(conn net.Conn, protocol string, l ...*config.IPAccess) bool {
+	var ip net.IP
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+	return IPAccessAllowedLog(ip, protocol, l...)
+}
+
+// IPAccessAllowedLog returns whether ip is allowed by the access configurations,
+// like IPAccessAllowed, but logs and counts refusals. Used for connections, and
+// for client IPs passed along by a trusted upstream server.
+func IPAccessAllowedLog(ip net.IP, protocol string, l ...*config.IPAccess) bool {
+	if IPAccessAllowed(ip, l...) {
+		return true
+	}
+	metricIPAccessRefused.WithLabelValues(protocol).Inc()
+	mlog.New("mox", nil).Debug("refusing ip not allowed by ipaccess config",
+		slog.String("protocol", protocol),
+		slog.Any("remoteip", ip))
+	return false
+}
+
+// IPAccessHandler returns a handler that responds with "403 - forbidden" to
+// requests from remote IPs not allowed by a. The client IP is looked up with
+// clientIP. If a is nil, handler is returned as is.
+func IPAccessHandler(a *config.IPAccess, protocol string, clientIP func(r *http.Request) net.IP, handler http.Handler) http.Handler {
+	if a == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := clientIP(r); ip == nil || !a.Allowed(ip) {
+			metricIPAccessRefused.WithLabelValues(protocol).Inc()
+			http.Error(w, "403 - forbidden", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,94 @@
+package mox
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mjl-/mox/config"
+)
+
+func TestIPAccess(t *testing.T) {
+	mustParse := func(s string) *net.IPNet {
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatalf("parse cidr %q: %v", s, err)
+		}
+		return ipnet
+	}
+
+	vpn := &config.IPAccess{AllowNets: []*net.IPNet{mustParse("10.8.0.0/16"), mustParse("2001:db8::/32")}, DenyNets: []*net.IPNet{mustParse("10.8.1.0/24")}}
+	abusive := &config.IPAccess{DenyNets: []*net.IPNet{mustParse("192.0.2.0/24")}}
+
+	test := func(ip string, expect bool, l ...*config.IPAccess) {
+		t.Helper()
+		if allowed := IPAccessAllowed(net.ParseIP(ip), l...); allowed != expect {
+			t.Fatalf("ip %s: got allowed %v, expected %v", ip, allowed, expect)
+		}
+	}
+	test("10.8.0.1", true, vpn)
+	test("10.8.1.1", false, vpn)
+	test("10.9.0.1", false, vpn)
+	test("2001:db8::1", true, vpn)
+	test("::ffff:10.8.0.1", true, vpn)
+	test("192.0.2.1", false, abusive)
+	test("198.51.100.1", true, abusive)
+	test("198.51.100.1", true, nil, nil)
+	test("10.8.0.1", true, nil, vpn, abusive)
+	test("198.51.100.1", false, abusive, vpn)
+
+	// Listener closes connections from IPs that are not allowed.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	if xln := IPAccessListener(ln, "test", nil); xln != ln {
+		t.Fatalf("got wrapped listener for nil ipaccess")
+	}
+	xln := IPAccessListener(ln, "test", &config.IPAccess{DenyNets: []*net.IPNet{mustParse("127.0.0.0/8")}})
+	defer xln.Close()
+	accepted := make(chan struct{})
+	go func() {
+		if conn, err := xln.Accept(); err == nil {
+			conn.Close()
+			close(accepted)
+		}
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_, err = conn.Read(make([]byte, 1))
+	if err == nil {
+		t.Fatalf("read on refused connection succeeded")
+	}
+	conn.Close()
+	select {
+	case <-accepted:
+		t.Fatalf("connection from denied ip was accepted")
+	default:
+	}
+
+	// HTTP handler responds with 403 for IPs that are not allowed.
+	handler := IPAccessHandler(vpn, "test", func(r *http.Request) net.IP {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		return net.ParseIP(host)
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tc := range []struct {
+		remoteAddr string
+		status     int
+	}{
+		{"10.8.0.1:1234", http.StatusOK},
+		{"10.8.1.1:1234", http.StatusForbidden},
+		{"192.0.2.1:1234", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Fatalf("remote addr %s: got status %d, expected %d", tc.remoteAddr, w.Code, tc.status)
+		}
+	}
+}
@@ -3,12 +3,14 @@ package mox
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net"
 	"os"
 	"runtime"
 	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -76,7 +78,9 @@ var FilesImmediate bool
 
 // Listen returns a newly created network listener when starting as root, and
 // otherwise (not root) returns a network listener from a file descriptor that was
-// passed by the parent root process.
+// passed by the parent root process. When started as root by systemd with socket
+// activation, a passed socket with a matching address is used instead of creating
+// a new listener. For network "unix", addr is the path of a unix domain socket.
 func Listen(network, addr string) (net.Listener, error) {
 	if os.Getuid() != 0 && !FilesImmediate {
 		f, ok := passedListeners[addr]
@@ -87,6 +91,9 @@ func Listen(network, addr string) (net.Listener, error) {
 		if err != nil {
 			return nil, fmt.Errorf("making network listener from file descriptor for address %s: %v", addr, err)
 		}
+		if network == "unix" {
+			ln = unixListener{ln}
+		}
 		return ln, nil
 	}
 
@@ -94,23 +101,43 @@ func Listen(network, addr string) (net.Listener, error) {
 		return nil, fmt.Errorf("duplicate listener: %s", addr)
 	}
 
-	ln, err := net.Listen(network, addr)
+	var ln net.Listener
+	var err error
+	if f := systemdSocket(network, addr); f != nil {
+		pkglog.Print("using socket from systemd socket activation", slog.String("address", addr))
+		ln, err = net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("making network listener from systemd socket for address %s: %v", addr, err)
+		}
+	} else if network == "unix" {
+		ln, err = listenUnix(addr)
+	} else {
+		ln, err = net.Listen(network, addr)
+	}
 	if err != nil {
 		return nil, err
 	}
 	// On windows, we cannot duplicate a socket. We don't need to for mox localserve
 	// with FilesImmediate.
 	if !FilesImmediate {
-		tcpln, ok := ln.(*net.TCPListener)
-		if !ok {
-			return nil, fmt.Errorf("listener not a tcp listener, but %T, for network %s, address %s", ln, network, addr)
+		var f *os.File
+		switch xln := ln.(type) {
+		case *net.TCPListener:
+			f, err = xln.File()
+		case *net.UnixListener:
+			f, err = xln.File()
+		default:
+			return nil, fmt.Errorf("listener not a tcp or unix listener, but %T, for network %s, address %s", ln, network, addr)
 		}
-		f, err := tcpln.File()
 		if err != nil {
 			return nil, fmt.Errorf("dup listener: %v", err)
 		}
 		passedListeners[addr] = f
 	}
+	if network == "unix" {
+		ln = unixListener{ln}
+	}
 	return ln, err
 }
 
@@ -147,6 +174,11 @@ func OpenPrivileged(path string) (*os.File, error) {
 var Shutdown context.Context
 var ShutdownCancel func()
 
+// Draining is set when preparing for a planned shutdown with "mox drain". New
+// incoming SMTP connections and transactions are refused with a temporary error,
+// while transactions in progress can complete and the queue is flushed.
+var Draining atomic.Bool
+
 // This context should be used as parent by most operations. It is canceled 1
 // second after graceful shutdown was initiated with the cancelation of the
 // Shutdown context. This should abort active operations.
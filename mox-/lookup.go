@@ -64,6 +64,23 @@ func LookupAddress(localpart smtp.Localpart, domain dns.Domain, allowPostmaster,
 		return "", nil, "", config.Destination{}, ErrDomainDisabled
 	}
 
+	// For alias domains, only the reporting addresses are configured for the domain
+	// itself. Other addresses are looked up at the domain it is an alias of.
+	if d.AliasOf != "" {
+		canonical := smtp.NewAddress(CanonicalLocalpart(localpart, d), domain).String()
+		if accAddr, alias, ok := Conf.AccountDestination(canonical); ok && alias == nil {
+			return accAddr.Account, nil, canonical, accAddr.Destination, nil
+		}
+		domain = d.AliasOfDomain
+		d, ok = Conf.Domain(domain)
+		if !ok || d.ReportsOnly {
+			return "", nil, "", config.Destination{}, ErrDomainNotFound
+		}
+		if d.Disabled && checkDomainDisabled {
+			return "", nil, "", config.Destination{}, ErrDomainDisabled
+		}
+	}
+
 	localpart = CanonicalLocalpart(localpart, d)
 	canonical := smtp.NewAddress(localpart, domain).String()
 
@@ -74,6 +91,12 @@ func LookupAddress(localpart smtp.Localpart, domain dns.Domain, allowPostmaster,
 		}
 		return "", alias, canonical, config.Destination{}, nil
 	} else if !ok {
+		// Patterns are evaluated in configured order, before the catchall.
+		for _, dp := range d.DestinationPatterns {
+			if dp.LocalpartRegexpCompiled != nil && dp.LocalpartRegexpCompiled.MatchString(string(localpart)) {
+				return dp.Account, nil, canonical, config.Destination{Mailbox: dp.Mailbox}, nil
+			}
+		}
 		if accAddr, alias, ok = Conf.AccountDestination("@" + domain.Name()); !ok || alias != nil {
 			if localpart == "postmaster" && allowPostmaster {
 				return Conf.Static.Postmaster.Account, nil, "postmaster", config.Destination{Mailbox: Conf.Static.Postmaster.Mailbox}, nil
@@ -85,6 +108,90 @@ func LookupAddress(localpart smtp.Localpart, domain dns.Domain, allowPostmaster,
 	return accAddr.Account, nil, canonical, accAddr.Destination, nil
 }
 
+// LookupSubdomainAddress looks up the account for an address at a subdomain of a
+// configured domain that accepts messages for its subdomains, see
+// config.Domain.Subdomains. The nearest configured parent domain is used, its
+// subdomain routes determine the address at a configured domain to look up with
+// LookupAddress. Only for incoming deliveries, addresses at subdomains cannot be
+// used for logging in.
+//
+// Returns ErrDomainNotFound if no parent domain accepts the subdomain, and errors
+// from LookupAddress otherwise.
+func LookupSubdomainAddress(localpart smtp.Localpart, domain dns.Domain, allowPostmaster, allowAlias, checkDomainDisabled bool) (accountName string, alias *config.Alias, canonicalAddress string, dest config.Destination, rerr error) {
+	labels := strings.Split(domain.ASCII, ".")
+	names := strings.Split(domain.Name(), ".")
+	for i := 1; i < len(labels)-1; i++ {
+		parent, err := dns.ParseDomain(strings.Join(labels[i:], "."))
+		if err != nil {
+			break
+		}
+		d, ok := Conf.Domain(parent)
+		if !ok {
+			continue
+		}
+		if d.Subdomains == nil {
+			break
+		}
+
+		sub := strings.Join(labels[:i], ".")
+		var route *config.SubdomainRoute
+		for j, r := range d.Subdomains.Routes {
+			if r.Wildcard && (r.SubdomainASCII == "" || strings.HasSuffix(sub, "."+r.SubdomainASCII)) || !r.Wildcard && sub == r.SubdomainASCII {
+				route = &d.Subdomains.Routes[j]
+				break
+			}
+		}
+		if route == nil && len(d.Subdomains.Routes) > 0 {
+			break
+		}
+
+		switch {
+		case route == nil || route.Deliver == "" || route.Deliver == "localpart":
+			return LookupAddress(localpart, parent, allowPostmaster, allowAlias, checkDomainDisabled)
+		case route.Deliver == "subdomain":
+			return LookupAddress(smtp.Localpart(names[0]), parent, allowPostmaster, allowAlias, checkDomainDisabled)
+		default:
+			return LookupAddress(route.DeliverAddress.Localpart, route.DeliverAddress.Domain, allowPostmaster, allowAlias, checkDomainDisabled)
+		}
+	}
+	return "", nil, "", config.Destination{}, ErrDomainNotFound
+}
+
+// LookupList looks up the mailing list for localpart and domain. If the address
+// is the request address of a list, request is set. Disabled domains don't have
+// lists.
+func LookupList(localpart smtp.Localpart, domain dns.Domain) (list config.List, request, ok bool) {
+	d, xok := Conf.Domain(domain)
+	if !xok || d.Disabled || len(d.Lists) == 0 {
+		return config.List{}, false, false
+	}
+
+	// The request address is matched before removing catchall separators, which may
+	// include the "-".
+	lp := string(localpart)
+	if !d.LocalpartCaseSensitive {
+		lp = strings.ToLower(lp)
+	}
+	for lpstr, l := range d.Lists {
+		if !d.LocalpartCaseSensitive {
+			lpstr = strings.ToLower(lpstr)
+		}
+		if lp == lpstr+"-request" {
+			return l, true, true
+		}
+	}
+	clp := string(CanonicalLocalpart(localpart, d))
+	for lpstr, l := range d.Lists {
+		if !d.LocalpartCaseSensitive {
+			lpstr = strings.ToLower(lpstr)
+		}
+		if clp == lpstr {
+			return l, false, true
+		}
+	}
+	return config.List{}, false, false
+}
+
 // lp and rlp are both lower-case when domain localparts aren't case sensitive.
 func matchReportingSeparators(lp, rlp smtp.Localpart, d config.Domain) bool {
 	lps := string(lp)
@@ -0,0 +1,347 @@
+package mox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moxvar"
+)
+
+var metricMetricsPush = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_metrics_push_total",
+		Help: "Pushes of metrics to external monitoring services, by kind and result.",
+	},
+	[]string{
+		"kind",   // remotewrite, statsd
+		"result", // ok, error
+	},
+)
+
+// pushSample is a single value of a time series, after flattening histograms
+// and summaries.
+type pushSample struct {
+	name    string
+	labels  [][2]string // Sorted by name, without __name__.
+	value   float64
+	counter bool // Monotonically increasing value, otherwise gauge.
+}
+
+// key returns a unique key for the time series of the sample.
+func (s pushSample) key() string {
+	var b strings.Builder
+	b.WriteString(s.name)
+	for _, l := range s.labels {
+		b.WriteString("\x00" + l[0] + "\x00" + l[1])
+	}
+	return b.String()
+}
+
+// StartMetricsPush starts a goroutine that periodically pushes all registered
+// metrics to the remote-write endpoint and/or StatsD server configured in c, until
+// ctx is canceled.
+func StartMetricsPush(ctx context.Context, c *config.MetricsPush) {
+	log := mlog.New("metricspush", nil)
+
+	labels := map[string]string{
+		"job":      "mox",
+		"instance": Conf.Static.HostnameDomain.ASCII,
+	}
+	for k, v := range c.Labels {
+		labels[k] = v
+	}
+
+	// Previous values of counters, for sending increases to StatsD.
+	prev := map[string]float64{}
+
+	go func() {
+		for {
+			if Sleep(ctx, c.Interval) {
+				return
+			}
+
+			samples, err := gatherSamples(prometheus.DefaultGatherer, labels)
+			if err != nil {
+				log.Errorx("gathering metrics for push", err)
+				continue
+			}
+			now := time.Now()
+
+			if rw := c.RemoteWrite; rw != nil {
+				err := pushRemoteWrite(ctx, rw, samples, now)
+				log.Check(err, "pushing metrics with remote-write", slog.String("url", rw.URL))
+				metricMetricsPush.WithLabelValues("remotewrite", pushResult(err)).Inc()
+			}
+			if sd := c.StatsD; sd != nil {
+				err := pushStatsD(sd, samples, prev)
+				log.Check(err, "pushing metrics to statsd", slog.String("address", sd.Address))
+				metricMetricsPush.WithLabelValues("statsd", pushResult(err)).Inc()
+			}
+		}
+	}()
+}
+
+func pushResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// gatherSamples gathers all metrics from g, returning them as flat samples, with
+// the extra labels added.
+func gatherSamples(g prometheus.Gatherer, extra map[string]string) ([]pushSample, error) {
+	families, err := g.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []pushSample
+	for _, mf := range families {
+		name := mf.GetName()
+		for _, m := range mf.Metric {
+			var labels [][2]string
+			for k, v := range extra {
+				labels = append(labels, [2]string{k, v})
+			}
+			for _, lp := range m.Label {
+				labels = slices.DeleteFunc(labels, func(l [2]string) bool { return l[0] == lp.GetName() })
+				labels = append(labels, [2]string{lp.GetName(), lp.GetValue()})
+			}
+			add := func(name string, value float64, counter bool, extra ...string) {
+				l := slices.Clone(labels)
+				if len(extra) == 2 {
+					l = append(l, [2]string{extra[0], extra[1]})
+				}
+				slices.SortFunc(l, func(a, b [2]string) int { return strings.Compare(a[0], b[0]) })
+				samples = append(samples, pushSample{name, l, value, counter})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue(), true)
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue(), false)
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue(), false)
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.Bucket {
+					add(name+"_bucket", float64(b.GetCumulativeCount()), true, "le", formatFloat(b.GetUpperBound()))
+				}
+				add(name+"_bucket", float64(h.GetSampleCount()), true, "le", "+Inf")
+				add(name+"_sum", h.GetSampleSum(), true)
+				add(name+"_count", float64(h.GetSampleCount()), true)
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					add(name, q.GetValue(), false, "quantile", formatFloat(q.GetQuantile()))
+				}
+				add(name+"_sum", s.GetSampleSum(), true)
+				add(name+"_count", float64(s.GetSampleCount()), true)
+			}
+		}
+	}
+	return samples, nil
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// pushRemoteWrite sends samples in a Prometheus remote-write request.
+func pushRemoteWrite(ctx context.Context, rw *config.MetricsRemoteWrite, samples []pushSample, now time.Time) error {
+	buf := remoteWriteRequest(samples, now)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", rw.URL, bytes.NewReader(snappyEncode(buf)))
+	if err != nil {
+		return fmt.Errorf("making http request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "mox/"+moxvar.Version)
+	if rw.EffectiveBearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+rw.EffectiveBearerToken)
+	} else if rw.Username != "" || rw.EffectivePassword != "" {
+		req.SetBasicAuth(rw.Username, rw.EffectivePassword)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("remote-write endpoint returned http status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// remoteWriteRequest returns a protobuf-encoded prometheus.WriteRequest with a
+// time series for each sample.
+func remoteWriteRequest(samples []pushSample, now time.Time) []byte {
+	// message WriteRequest { repeated TimeSeries timeseries = 1; }
+	// message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+	// message Label { string name = 1; string value = 2; }
+	// message Sample { double value = 1; int64 timestamp = 2; }
+	label := func(name, value string) []byte {
+		var l []byte
+		l = protowire.AppendTag(l, 1, protowire.BytesType)
+		l = protowire.AppendString(l, name)
+		l = protowire.AppendTag(l, 2, protowire.BytesType)
+		l = protowire.AppendString(l, value)
+		return l
+	}
+
+	var buf []byte
+	for _, s := range samples {
+		// Labels must be sorted by name. "__name__" does not always come first.
+		labels := append([][2]string{{"__name__", s.name}}, s.labels...)
+		slices.SortFunc(labels, func(a, b [2]string) int { return strings.Compare(a[0], b[0]) })
+
+		var ts []byte
+		for _, l := range labels {
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label(l[0], l[1]))
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(now.UnixMilli()))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+	return buf
+}
+
+// snappyEncode returns buf in the snappy block format, as required for
+// remote-write. We don't compress, but write the data as a single literal, which
+// is valid snappy.
+func snappyEncode(buf []byte) []byte {
+	var b []byte
+	b = protowire.AppendVarint(b, uint64(len(buf)))
+	if len(buf) == 0 {
+		return b
+	}
+	n := uint32(len(buf) - 1)
+	switch {
+	case n < 60:
+		b = append(b, byte(n<<2))
+	case n < 1<<8:
+		b = append(b, 60<<2, byte(n))
+	case n < 1<<16:
+		b = append(b, 61<<2, byte(n), byte(n>>8))
+	case n < 1<<24:
+		b = append(b, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		b = append(b, 63<<2, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(b, buf...)
+}
+
+// pushStatsD sends samples to a StatsD server. For counters, the increase since
+// the previous push is sent, using prev to keep track of previous values.
+func pushStatsD(sd *config.MetricsStatsD, samples []pushSample, prev map[string]float64) error {
+	conn, err := net.Dial("udp", sd.Address)
+	if err != nil {
+		return fmt.Errorf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// Keep packets below a typical MTU.
+	const maxPacket = 1400
+	var packet []byte
+	flush := func() error {
+		if len(packet) == 0 {
+			return nil
+		}
+		_, err := conn.Write(packet)
+		packet = packet[:0]
+		return err
+	}
+
+	for _, s := range samples {
+		var line string
+		if s.counter {
+			k := s.key()
+			delta := s.value - prev[k]
+			prev[k] = s.value
+			if delta <= 0 {
+				continue
+			}
+			line = statsdLine(sd, s, formatFloat(delta), "c")
+		} else {
+			line = statsdLine(sd, s, formatFloat(s.value), "g")
+		}
+		if len(packet) > 0 && len(packet)+1+len(line) > maxPacket {
+			if err := flush(); err != nil {
+				return fmt.Errorf("write: %v", err)
+			}
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if err := flush(); err != nil {
+		return fmt.Errorf("write: %v", err)
+	}
+	return nil
+}
+
+// statsdLine returns the StatsD line for sample s.
+func statsdLine(sd *config.MetricsStatsD, s pushSample, value, typ string) string {
+	name := sd.Prefix + s.name
+	var tags []string
+	for _, l := range s.labels {
+		if sd.Tags {
+			tags = append(tags, statsdSanitize(l[0])+":"+statsdSanitize(l[1]))
+		} else if l[0] != "job" && l[0] != "instance" {
+			name += "." + statsdSanitize(l[1])
+		}
+	}
+	line := name + ":" + value + "|" + typ
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// statsdSanitize replaces characters with special meaning in StatsD lines.
+func statsdSanitize(s string) string {
+	return strings.Map(func(c rune) rune {
+		switch c {
+		case ':', '|', '@', '#', ',', ' ', '\n':
+			return '_'
+		}
+		return c
+	}, s)
+}
@@ -0,0 +1,194 @@
+package mox
+
+import (
+	"context"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mjl-/mox/config"
+)
+
+func TestMetricsPush(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_total", Help: "Test."}, []string{"result"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_gauge", Help: "Test."})
+	hist := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Help: "Test.", Buckets: []float64{0.1, 1}})
+	reg.MustRegister(counter, gauge, hist)
+	counter.WithLabelValues("ok").Add(3)
+	gauge.Set(1.5)
+	hist.Observe(0.5)
+
+	extra := map[string]string{"job": "mox", "instance": "mox.example"}
+	samples, err := gatherSamples(reg, extra)
+	tcheckf(t, err, "gather")
+	var names []string
+	for _, s := range samples {
+		names = append(names, s.name)
+	}
+	expNames := []string{"test_duration_seconds_bucket", "test_duration_seconds_bucket", "test_duration_seconds_bucket", "test_duration_seconds_sum", "test_duration_seconds_count", "test_gauge", "test_total"}
+	if !slices.Equal(names, expNames) {
+		t.Fatalf("got sample names %v, expected %v", names, expNames)
+	}
+
+	// Remote-write, with the request decoded again.
+	var got map[string]float64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		buf, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		got = decodeRemoteWrite(t, snappyDecodeLiteral(t, buf))
+	}))
+	defer srv.Close()
+
+	err = pushRemoteWrite(context.Background(), &config.MetricsRemoteWrite{URL: srv.URL, EffectiveBearerToken: "secret"}, samples, time.Now())
+	tcheckf(t, err, "push remote-write")
+	exp := map[string]float64{
+		`__name__=test_total,instance=mox.example,job=mox,result=ok`:                 3,
+		`__name__=test_gauge,instance=mox.example,job=mox`:                           1.5,
+		`__name__=test_duration_seconds_bucket,instance=mox.example,job=mox,le=0.1`:  0,
+		`__name__=test_duration_seconds_bucket,instance=mox.example,job=mox,le=1`:    1,
+		`__name__=test_duration_seconds_bucket,instance=mox.example,job=mox,le=+Inf`: 1,
+		`__name__=test_duration_seconds_sum,instance=mox.example,job=mox`:            0.5,
+		`__name__=test_duration_seconds_count,instance=mox.example,job=mox`:          1,
+	}
+	for k, v := range exp {
+		if gv, ok := got[k]; !ok || gv != v {
+			t.Fatalf("remote-write series %s: got %v (present %v), expected %v; all: %v", k, gv, ok, v, got)
+		}
+	}
+	if len(got) != len(exp) {
+		t.Fatalf("got %d series, expected %d: %v", len(got), len(exp), got)
+	}
+
+	// StatsD, counters are sent as increase since previous push.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	tcheckf(t, err, "listen udp")
+	defer pc.Close()
+	readLines := func() []string {
+		t.Helper()
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 2048)
+		n, _, err := pc.ReadFrom(buf)
+		tcheckf(t, err, "read statsd packet")
+		return strings.Split(string(buf[:n]), "\n")
+	}
+
+	sd := &config.MetricsStatsD{Address: pc.LocalAddr().String(), Prefix: "mox."}
+	prev := map[string]float64{}
+	err = pushStatsD(sd, samples, prev)
+	tcheckf(t, err, "push statsd")
+	lines := readLines()
+	for _, exp := range []string{"mox.test_total.ok:3|c", "mox.test_gauge:1.5|g", "mox.test_duration_seconds_bucket.1:1|c"} {
+		if !slices.Contains(lines, exp) {
+			t.Fatalf("missing statsd line %q in %v", exp, lines)
+		}
+	}
+
+	counter.WithLabelValues("ok").Inc()
+	samples, err = gatherSamples(reg, extra)
+	tcheckf(t, err, "gather")
+	sd.Tags = true
+	err = pushStatsD(sd, samples, prev)
+	tcheckf(t, err, "push statsd")
+	lines = readLines()
+	if !slices.Contains(lines, "mox.test_total:1|c|#instance:mox.example,job:mox,result:ok") || slices.ContainsFunc(lines, func(s string) bool { return strings.HasPrefix(s, "mox.test_duration_seconds_count:") }) {
+		t.Fatalf("unexpected statsd lines %v", lines)
+	}
+}
+
+// snappyDecodeLiteral decodes a snappy block consisting of a single literal, as
+// written by snappyEncode.
+func snappyDecodeLiteral(t *testing.T, buf []byte) []byte {
+	t.Helper()
+	size, n := protowire.ConsumeVarint(buf)
+	if n < 0 {
+		t.Fatalf("bad snappy length")
+	}
+	buf = buf[n:]
+	if size == 0 {
+		return nil
+	}
+	tag := buf[0] >> 2
+	buf = buf[1:]
+	if tag >= 60 {
+		buf = buf[tag-59:]
+	}
+	if uint64(len(buf)) != size {
+		t.Fatalf("bad snappy literal, got %d bytes, expected %d", len(buf), size)
+	}
+	return buf
+}
+
+// decodeRemoteWrite returns the series with their values, keyed by labels.
+func decodeRemoteWrite(t *testing.T, buf []byte) map[string]float64 {
+	t.Helper()
+	fields := func(buf []byte, fn func(num protowire.Number, v []byte, fixed uint64)) {
+		for len(buf) > 0 {
+			num, typ, n := protowire.ConsumeTag(buf)
+			if n < 0 {
+				t.Fatalf("bad tag")
+			}
+			buf = buf[n:]
+			switch typ {
+			case protowire.BytesType:
+				v, n := protowire.ConsumeBytes(buf)
+				fn(num, v, 0)
+				buf = buf[n:]
+			case protowire.Fixed64Type:
+				v, n := protowire.ConsumeFixed64(buf)
+				fn(num, nil, v)
+				buf = buf[n:]
+			case protowire.VarintType:
+				_, n := protowire.ConsumeVarint(buf)
+				buf = buf[n:]
+			default:
+				t.Fatalf("unexpected wire type %v", typ)
+			}
+		}
+	}
+
+	r := map[string]float64{}
+	fields(buf, func(_ protowire.Number, ts []byte, _ uint64) {
+		var labels []string
+		var value float64
+		fields(ts, func(num protowire.Number, v []byte, _ uint64) {
+			switch num {
+			case 1:
+				var name, value string
+				fields(v, func(num protowire.Number, v []byte, _ uint64) {
+					if num == 1 {
+						name = string(v)
+					} else {
+						value = string(v)
+					}
+				})
+				labels = append(labels, name+"="+value)
+			case 2:
+				fields(v, func(num protowire.Number, _ []byte, fixed uint64) {
+					if num == 1 {
+						value = math.Float64frombits(fixed)
+					}
+				})
+			}
+		})
+		r[strings.Join(labels, ",")] = value
+	})
+	return r
+}
@@ -0,0 +1,192 @@
+package mox
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moxvar"
+)
+
+var metricOCSPFetch = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_tls_ocsp_fetch_total",
+		Help: "OCSP responses fetched for stapling in TLS handshakes, by result.",
+	},
+	[]string{
+		"result", // ok, revoked, unknown, error
+	},
+)
+
+// ocspStaples holds OCSP responses for certificates, by SHA-256 of the leaf
+// certificate.
+var ocspStaples = struct {
+	sync.Mutex
+	responses map[[sha256.Size]byte]*ocspEntry
+}{responses: map[[sha256.Size]byte]*ocspEntry{}}
+
+type ocspEntry struct {
+	leaf     *x509.Certificate
+	response []byte    // Raw OCSP response, nil if none available yet.
+	expires  time.Time // NextUpdate of response, zero if unknown.
+	refresh  time.Time // When to fetch a new response.
+	fetching bool
+}
+
+// OCSPStapleGetCertificate wraps a GetCertificate function of a tls.Config, adding
+// an OCSP response for stapling to returned certificates that have an OCSP
+// responder. OCSP responses are fetched in the background and refreshed halfway
+// through their validity period. TLS handshakes don't wait for responses. ../rfc/6066
+func OCSPStapleGetCertificate(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := getCertificate(hello)
+		if err != nil || cert == nil || cert.OCSPStaple != nil || len(cert.Certificate) < 2 {
+			return cert, err
+		}
+		return stapleOCSP(cert), nil
+	}
+}
+
+func stapleOCSP(cert *tls.Certificate) *tls.Certificate {
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return cert
+		}
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return cert
+	}
+
+	key := sha256.Sum256(cert.Certificate[0])
+	now := time.Now()
+
+	ocspStaples.Lock()
+	defer ocspStaples.Unlock()
+	st := ocspStaples.responses[key]
+	if st == nil {
+		// Remove responses for expired certificates, so we don't grow unbounded when
+		// certificates are renewed.
+		for k, xst := range ocspStaples.responses {
+			if now.After(xst.leaf.NotAfter) {
+				delete(ocspStaples.responses, k)
+			}
+		}
+		st = &ocspEntry{leaf: leaf}
+		ocspStaples.responses[key] = st
+	}
+	if !st.fetching && !now.Before(st.refresh) {
+		st.fetching = true
+		go ocspFetch(key, leaf, cert.Certificate[1])
+	}
+	if st.response == nil || !st.expires.IsZero() && now.After(st.expires) {
+		return cert
+	}
+	ncert := *cert
+	ncert.OCSPStaple = st.response
+	return &ncert
+}
+
+func ocspFetch(key [sha256.Size]byte, leaf *x509.Certificate, issuerDER []byte) {
+	log := mlog.New("mox", nil).With(slog.Any("subject", leaf.Subject), slog.String("serial", leaf.SerialNumber.String()))
+
+	var response []byte
+	var expires, refresh time.Time
+	defer func() {
+		ocspStaples.Lock()
+		defer ocspStaples.Unlock()
+		st := ocspStaples.responses[key]
+		if st == nil {
+			return
+		}
+		st.fetching = false
+		if refresh.IsZero() {
+			// Failure, try again later, keeping any existing response that is still valid.
+			refresh = time.Now().Add(time.Hour)
+		}
+		st.refresh = refresh
+		if response != nil {
+			st.response = response
+			st.expires = expires
+		}
+	}()
+
+	result, resp, err := ocspRequest(context.Background(), leaf, issuerDER)
+	metricOCSPFetch.WithLabelValues(result).Inc()
+	if err != nil {
+		log.Errorx("fetching ocsp response for stapling", err, slog.String("result", result))
+		return
+	}
+	log.Debug("fetched ocsp response for stapling", slog.Time("thisupdate", resp.ThisUpdate), slog.Time("nextupdate", resp.NextUpdate))
+	response = resp.Raw
+	expires = resp.NextUpdate
+	refresh = resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+	if resp.NextUpdate.IsZero() {
+		refresh = time.Now().Add(24 * time.Hour)
+	}
+}
+
+// ocspRequest fetches an OCSP response for leaf from its OCSP responder. The
+// result is for metrics: ok, revoked, unknown or error.
+func ocspRequest(ctx context.Context, leaf *x509.Certificate, issuerDER []byte) (string, *ocsp.Response, error) {
+	issuer, err := x509.ParseCertificate(issuerDER)
+	if err != nil {
+		return "error", nil, fmt.Errorf("parsing issuer certificate: %v", err)
+	}
+	reqBuf, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return "error", nil, fmt.Errorf("creating ocsp request: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", leaf.OCSPServer[0], bytes.NewReader(reqBuf))
+	if err != nil {
+		return "error", nil, fmt.Errorf("making http request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+	req.Header.Set("User-Agent", "mox/"+moxvar.Version)
+	hresp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "error", nil, fmt.Errorf("http request to ocsp responder: %v", err)
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode != http.StatusOK {
+		return "error", nil, fmt.Errorf("ocsp responder returned http status %s", hresp.Status)
+	}
+	buf, err := io.ReadAll(io.LimitReader(hresp.Body, 64*1024))
+	if err != nil {
+		return "error", nil, fmt.Errorf("reading ocsp response: %v", err)
+	}
+	resp, err := ocsp.ParseResponseForCert(buf, leaf, issuer)
+	if err != nil {
+		return "error", nil, fmt.Errorf("parsing ocsp response: %v", err)
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		return "ok", resp, nil
+	case ocsp.Revoked:
+		// We don't staple responses for revoked certificates. Clients that check will
+		// find out themselves.
+		return "revoked", nil, fmt.Errorf("certificate has been revoked at %s", resp.RevokedAt)
+	default:
+		return "unknown", nil, fmt.Errorf("ocsp responder does not know certificate")
+	}
+}
@@ -0,0 +1,147 @@
+package mox
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPStaple(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	tcheckf(t, err, "generate ca key")
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(cryptorand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	tcheckf(t, err, "create ca certificate")
+	ca, err := x509.ParseCertificate(caDER)
+	tcheckf(t, err, "parse ca certificate")
+
+	var status = ocsp.Good
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		req, err := ocsp.ParseRequest(buf)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		resp, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now(),
+		}, caKey)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+	defer responder.Close()
+
+	makeCert := func(serial int64, ocspServers []string) *tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+		tcheckf(t, err, "generate key")
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "mox.example"},
+			DNSNames:     []string{"mox.example"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			OCSPServer:   ocspServers,
+		}
+		der, err := x509.CreateCertificate(cryptorand.Reader, tmpl, ca, key.Public(), caKey)
+		tcheckf(t, err, "create certificate")
+		leaf, err := x509.ParseCertificate(der)
+		tcheckf(t, err, "parse certificate")
+		return &tls.Certificate{Certificate: [][]byte{der, caDER}, PrivateKey: crypto.Signer(key), Leaf: leaf}
+	}
+
+	// Wait for background fetch of the OCSP response, returning the certificate with
+	// staple if any.
+	staple := func(cert *tls.Certificate) *tls.Certificate {
+		t.Helper()
+		get := OCSPStapleGetCertificate(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return cert, nil
+		})
+		var xcert *tls.Certificate
+		for range 100 {
+			xcert, err = get(&tls.ClientHelloInfo{ServerName: "mox.example"})
+			tcheckf(t, err, "get certificate")
+			ocspStaples.Lock()
+			st := ocspStaples.responses[sha256.Sum256(cert.Certificate[0])]
+			fetching := st != nil && st.fetching
+			ocspStaples.Unlock()
+			if xcert.OCSPStaple != nil || st != nil && !fetching && !st.refresh.IsZero() {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return xcert
+	}
+
+	// Good status, response is stapled.
+	cert := makeCert(2, []string{responder.URL})
+	xcert := staple(cert)
+	if xcert.OCSPStaple == nil {
+		t.Fatalf("no ocsp response stapled")
+	}
+	resp, err := ocsp.ParseResponseForCert(xcert.OCSPStaple, cert.Leaf, ca)
+	tcheckf(t, err, "parse stapled response")
+	if resp.Status != ocsp.Good {
+		t.Fatalf("got ocsp status %d, expected good", resp.Status)
+	}
+	if cert.OCSPStaple != nil {
+		t.Fatalf("original certificate was modified")
+	}
+
+	// Revoked status, not stapled.
+	status = ocsp.Revoked
+	xcert = staple(makeCert(3, []string{responder.URL}))
+	if xcert.OCSPStaple != nil {
+		t.Fatalf("ocsp response for revoked certificate stapled")
+	}
+
+	// No OCSP responder, nothing to do.
+	cert = makeCert(4, nil)
+	xcert, err = OCSPStapleGetCertificate(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return cert, nil
+	})(&tls.ClientHelloInfo{ServerName: "mox.example"})
+	tcheckf(t, err, "get certificate")
+	if xcert != cert {
+		t.Fatalf("certificate without ocsp responder was changed")
+	}
+}
+
+func tcheckf(t *testing.T, err error, format string, args ...any) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", fmt.Sprintf(format, args...), err)
+	}
+}
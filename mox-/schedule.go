@@ -0,0 +1,136 @@
+package mox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron-like schedule, with fields minute, hour, day of
+// month, month and day of week.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64 // Bitmaps of allowed values.
+
+	// Whether day of month and day of week are "*". If both are restricted, a time
+	// matches if either matches, like cron.
+	domAll, dowAll bool
+}
+
+// ParseSchedule parses a cron-like schedule of 5 space-separated fields: minute
+// (0-59), hour (0-23), day of month (1-31), month (1-12) and day of week (0-6, 0
+// is Sunday, 7 is also accepted as Sunday). Each field is a "*" or a
+// comma-separated list of numbers and ranges like "1-5". Both "*" and ranges can
+// have a step, like "*/15".
+func ParseSchedule(s string) (Schedule, error) {
+	var sch Schedule
+	t := strings.Fields(s)
+	if len(t) != 5 {
+		return sch, fmt.Errorf("schedule must have 5 fields (minute, hour, day of month, month, day of week), got %d", len(t))
+	}
+	fields := []struct {
+		name     string
+		min, max int
+		bits     *uint64
+	}{
+		{"minute", 0, 59, &sch.minute},
+		{"hour", 0, 23, &sch.hour},
+		{"day of month", 1, 31, &sch.dom},
+		{"month", 1, 12, &sch.month},
+		{"day of week", 0, 7, &sch.dow},
+	}
+	for i, f := range fields {
+		bits, err := parseScheduleField(t[i], f.min, f.max)
+		if err != nil {
+			return sch, fmt.Errorf("%s: %v", f.name, err)
+		}
+		*f.bits = bits
+	}
+	// Sunday can be specified as 7.
+	if sch.dow&(1<<7) != 0 {
+		sch.dow |= 1
+	}
+	sch.domAll = t[2] == "*"
+	sch.dowAll = t[4] == "*"
+	return sch, nil
+}
+
+func parseScheduleField(s string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, e := range strings.Split(s, ",") {
+		rs, stepstr, hasStep := strings.Cut(e, "/")
+		step := 1
+		if hasStep {
+			v, err := strconv.Atoi(stepstr)
+			if err != nil || v <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepstr)
+			}
+			step = v
+		}
+
+		var first, last int
+		if rs == "*" {
+			first, last = min, max
+		} else {
+			a, b, isRange := strings.Cut(rs, "-")
+			var err error
+			first, err = strconv.Atoi(a)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", a)
+			}
+			last = first
+			if isRange {
+				last, err = strconv.Atoi(b)
+				if err != nil {
+					return 0, fmt.Errorf("invalid value %q", b)
+				}
+			} else if hasStep {
+				last = max
+			}
+			if first < min || last > max || first > last {
+				return 0, fmt.Errorf("invalid range %q, values must be between %d and %d", rs, min, max)
+			}
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time after t that matches the schedule, in the
+// location of t. The zero time is returned if there is no match within 5
+// years, e.g. for February 30.
+func (sch Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		if sch.month&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !sch.dayMatch(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if sch.hour&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if sch.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (sch Schedule) dayMatch(t time.Time) bool {
+	dom := sch.dom&(1<<t.Day()) != 0
+	dow := sch.dow&(1<<int(t.Weekday())) != 0
+	if sch.domAll || sch.dowAll {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,51 @@
+package mox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	base := time.Date(2026, 10, 17, 10, 20, 30, 0, time.UTC) // Saturday.
+
+	test := func(s string, exp time.Time) {
+		t.Helper()
+		sch, err := ParseSchedule(s)
+		if err != nil {
+			t.Fatalf("parse schedule %q: %v", s, err)
+		}
+		if next := sch.Next(base); !next.Equal(exp) {
+			t.Fatalf("schedule %q: got next %v, expected %v", s, next, exp)
+		}
+	}
+	date := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	test("* * * * *", date(10, 17, 10, 21))
+	test("30 3 * * *", date(10, 18, 3, 30))
+	test("*/15 * * * *", date(10, 17, 10, 30))
+	test("0 10-12 * * *", date(10, 17, 11, 0))
+	test("0 0 * * 1", date(10, 19, 0, 0))
+	test("0 0 * * 7", date(10, 18, 0, 0))
+	test("0 0 1 * *", date(11, 1, 0, 0))
+	test("0 0 1,20 * *", date(10, 20, 0, 0))
+	test("0 0 1 1 *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	// Both day of month and day of week restricted, either matches.
+	test("0 0 1 * 1", date(10, 19, 0, 0))
+	test("5/20 10 * * *", date(10, 17, 10, 25))
+
+	sch, err := ParseSchedule("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("parse schedule: %v", err)
+	}
+	if next := sch.Next(base); !next.IsZero() {
+		t.Fatalf("got next %v for impossible schedule, expected zero time", next)
+	}
+
+	for _, s := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := ParseSchedule(s); err == nil {
+			t.Fatalf("parse schedule %q: got no error, expected error", s)
+		}
+	}
+}
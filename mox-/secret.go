@@ -0,0 +1,130 @@
+package mox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Secret values in the configuration files can reference values stored
+// elsewhere, so the configuration files can be kept in version control without
+// containing secrets. References are resolved when loading the configuration.
+//
+// Supported references:
+//
+//	file:<path>             Contents of file, without trailing newline. Relative to the directory of the config file.
+//	env:<name>              Value of environment variable.
+//	vault:<path>#<field>    Field from HashiCorp Vault secret, fetched from $VAULT_ADDR/v1/<path> with token $VAULT_TOKEN.
+
+var errSecretRef = errors.New("resolving secret reference")
+
+// IsSecretRef returns whether s is a reference to a secret value stored
+// elsewhere.
+func IsSecretRef(s string) bool {
+	for _, p := range []string{"file:", "env:", "vault:"} {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSecret returns the secret value for s, which is returned as is if it
+// is not a reference.
+func resolveSecret(ctx context.Context, configFile, s string) (string, error) {
+	if t, ok := strings.CutPrefix(s, "file:"); ok {
+		buf, err := os.ReadFile(configDirPath(configFile, t))
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", errSecretRef, err)
+		}
+		return strings.TrimRight(string(buf), "\r\n"), nil
+	} else if t, ok := strings.CutPrefix(s, "env:"); ok {
+		v, ok := os.LookupEnv(t)
+		if !ok {
+			return "", fmt.Errorf("%w: environment variable %q not set", errSecretRef, t)
+		}
+		return v, nil
+	} else if t, ok := strings.CutPrefix(s, "vault:"); ok {
+		v, err := vaultSecret(ctx, t)
+		if err != nil {
+			return "", fmt.Errorf("%w: vault: %v", errSecretRef, err)
+		}
+		return v, nil
+	}
+	return s, nil
+}
+
+// resolveSecretFile returns the contents of the file at path f, relative to the
+// directory of configFile, or the resolved value if f is an "env:" or "vault:"
+// reference. Used for config fields that hold a file name.
+func resolveSecretFile(ctx context.Context, configFile, f string) ([]byte, error) {
+	if IsSecretRef(f) && !strings.HasPrefix(f, "file:") {
+		v, err := resolveSecret(ctx, configFile, f)
+		return []byte(v), err
+	}
+	return os.ReadFile(configDirPath(configFile, strings.TrimPrefix(f, "file:")))
+}
+
+// vaultSecret fetches field from the secret at path (in form "<path>#<field>")
+// in HashiCorp Vault. Both KV version 1 and 2 secrets engines are supported.
+func vaultSecret(ctx context.Context, s string) (string, error) {
+	path, field, ok := strings.Cut(s, "#")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("reference must be of form <path>#<field>")
+	}
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("environment variable VAULT_ADDR not set")
+	}
+	u, err := url.Parse(strings.TrimRight(addr, "/") + "/v1/" + strings.TrimLeft(path, "/"))
+	if err != nil {
+		return "", fmt.Errorf("parsing url: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("new request: %v", err)
+	}
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		buf, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("response status %s: %s", resp.Status, strings.TrimSpace(string(buf)))
+	}
+
+	var r struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&r); err != nil {
+		return "", fmt.Errorf("parsing response: %v", err)
+	}
+	data := r.Data
+	// KV version 2 has the secret data in a nested data field, along with metadata.
+	if d, ok := data["data"].(map[string]any); ok && data["metadata"] != nil {
+		data = d
+	}
+	v, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("field %q not present in secret", field)
+	}
+	str, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("field %q is %T, not a string", field, v)
+	}
+	return str, nil
+}
@@ -0,0 +1,83 @@
+package mox
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "mox.conf")
+	err := os.WriteFile(filepath.Join(dir, "secret"), []byte("filesecret\n"), 0600)
+	if err != nil {
+		t.Fatalf("write secret file: %v", err)
+	}
+	t.Setenv("MOX_TEST_SECRET", "envsecret")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/mox":
+			w.Write([]byte(`{"data": {"data": {"password": "vaultsecret", "number": 1}, "metadata": {"version": 1}}}`))
+		case "/v1/kv/mox":
+			w.Write([]byte(`{"data": {"password": "vaultsecretv1"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "token")
+
+	test := func(s, exp string, expErr bool) {
+		t.Helper()
+		v, err := resolveSecret(ctx, configFile, s)
+		if expErr {
+			if err == nil || !errors.Is(err, errSecretRef) {
+				t.Fatalf("resolve %q: got err %v, expected error", s, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("resolve %q: %v", s, err)
+		}
+		if v != exp {
+			t.Fatalf("resolve %q: got %q, expected %q", s, v, exp)
+		}
+	}
+
+	test("plain", "plain", false)
+	test("", "", false)
+	test("file:secret", "filesecret", false)
+	test("file:"+filepath.Join(dir, "secret"), "filesecret", false)
+	test("file:missing", "", true)
+	test("env:MOX_TEST_SECRET", "envsecret", false)
+	test("env:MOX_TEST_MISSING", "", true)
+	test("vault:secret/data/mox#password", "vaultsecret", false)
+	test("vault:kv/mox#password", "vaultsecretv1", false)
+	test("vault:secret/data/mox#missing", "", true)
+	test("vault:secret/data/mox#number", "", true)
+	test("vault:secret/data/other#password", "", true)
+	test("vault:secret/data/mox", "", true)
+
+	t.Setenv("VAULT_TOKEN", "bad")
+	test("vault:secret/data/mox#password", "", true)
+
+	buf, err := resolveSecretFile(ctx, configFile, "secret")
+	if err != nil || string(buf) != "filesecret\n" {
+		t.Fatalf("resolve secret file: got %q, %v", buf, err)
+	}
+	buf, err = resolveSecretFile(ctx, configFile, "env:MOX_TEST_SECRET")
+	if err != nil || string(buf) != "envsecret" {
+		t.Fatalf("resolve secret file from env: got %q, %v", buf, err)
+	}
+}
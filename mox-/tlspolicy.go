@@ -0,0 +1,79 @@
+package mox
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/config"
+)
+
+var metricTLSHandshake = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_tls_handshake_total",
+		Help: "Completed incoming TLS handshakes, by listener and negotiated TLS version.",
+	},
+	[]string{
+		"listener",
+		"version", // E.g. "TLS 1.3".
+	},
+)
+
+// ALPN protocols that can be configured in TLS ALPNProtocols.
+var tlsALPNProtocols = []string{"h2", "http/1.1", "smtp", "imap"}
+
+// parseTLSCipherSuites returns the IDs of the named cipher suites. Insecure
+// cipher suites are refused.
+func parseTLSCipherSuites(names []string) ([]uint16, error) {
+	var ids []uint16
+Names:
+	for _, name := range names {
+		for _, cs := range tls.CipherSuites() {
+			if cs.Name == name {
+				ids = append(ids, cs.ID)
+				continue Names
+			}
+		}
+		for _, cs := range tls.InsecureCipherSuites() {
+			if cs.Name == name {
+				return nil, fmt.Errorf("cipher suite %q is insecure", name)
+			}
+		}
+		return nil, fmt.Errorf("unknown cipher suite %q", name)
+	}
+	return ids, nil
+}
+
+// applyTLSPolicy sets the TLS settings of listener TLS config ctls on tlsConfig,
+// and registers metrics for negotiated versions.
+func applyTLSPolicy(listenerName string, ctls *config.TLS, tlsConfig *tls.Config, minVersion uint16, cipherSuites []uint16) {
+	tlsConfig.MinVersion = minVersion
+	tlsConfig.CipherSuites = cipherSuites
+	tlsConfig.SessionTicketsDisabled = ctls.SessionTicketsDisabled
+	if !ctls.OCSPStaplingDisabled && tlsConfig.GetCertificate != nil {
+		tlsConfig.GetCertificate = OCSPStapleGetCertificate(tlsConfig.GetCertificate)
+	}
+	tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		metricTLSHandshake.WithLabelValues(listenerName, tls.VersionName(cs.Version)).Inc()
+		return nil
+	}
+}
+
+// FilterALPNProtocols removes protocols from nextProtos that are not allowed by
+// the ALPNProtocols of the listener TLS config, if set. Protocol acme-tls/1 is
+// always kept.
+func FilterALPNProtocols(ctls *config.TLS, nextProtos []string) []string {
+	if ctls == nil || len(ctls.ALPNProtocols) == 0 {
+		return nextProtos
+	}
+	var l []string
+	for _, p := range nextProtos {
+		if p == "acme-tls/1" || slices.Contains(ctls.ALPNProtocols, p) {
+			l = append(l, p)
+		}
+	}
+	return l
+}
@@ -0,0 +1,38 @@
+package mox
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+
+	"github.com/mjl-/mox/config"
+)
+
+func TestTLSPolicy(t *testing.T) {
+	ids, err := parseTLSCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"})
+	tcheckf(t, err, "parse cipher suites")
+	if !reflect.DeepEqual(ids, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}) {
+		t.Fatalf("got cipher suites %v", ids)
+	}
+	if _, err := parseTLSCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"}); err == nil {
+		t.Fatalf("insecure cipher suite accepted")
+	}
+	if _, err := parseTLSCipherSuites([]string{"bogus"}); err == nil {
+		t.Fatalf("unknown cipher suite accepted")
+	}
+
+	protos := []string{"h2", "http/1.1", "acme-tls/1", "smtp"}
+	if l := FilterALPNProtocols(&config.TLS{}, protos); !reflect.DeepEqual(l, protos) {
+		t.Fatalf("got alpn protocols %v, expected all", l)
+	}
+	if l := FilterALPNProtocols(&config.TLS{ALPNProtocols: []string{"http/1.1"}}, protos); !reflect.DeepEqual(l, []string{"http/1.1", "acme-tls/1"}) {
+		t.Fatalf("got alpn protocols %v, expected http/1.1 and acme-tls/1", l)
+	}
+
+	ctls := &config.TLS{SessionTicketsDisabled: true}
+	tlsConfig := &tls.Config{}
+	applyTLSPolicy("test", ctls, tlsConfig, tls.VersionTLS13, ids)
+	if tlsConfig.MinVersion != tls.VersionTLS13 || !tlsConfig.SessionTicketsDisabled || !reflect.DeepEqual(tlsConfig.CipherSuites, ids) || tlsConfig.VerifyConnection == nil {
+		t.Fatalf("tls policy not applied: %#v", tlsConfig)
+	}
+}
@@ -1,14 +1,19 @@
 package mox
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 	"log/slog"
 
 	"github.com/mjl-/mox/mlog"
 )
 
-// TLSReceivedComment returns a comment about TLS of the connection for use in a Receive header.
+// TLSReceivedComment returns a comment about TLS of the connection for use in a
+// Received header. If the client presented a certificate, the fingerprint of its
+// public key is included.
 func TLSReceivedComment(log mlog.Log, cs tls.ConnectionState) []string {
 	// todo future: we could use the "tls" clause for the Received header as specified in ../rfc/8314:496. however, the text implies it is only for submission, not regular smtp. and it cannot specify the tls version. for now, not worth the trouble.
 
@@ -39,9 +44,21 @@ func TLSReceivedComment(log mlog.Log, cs tls.ConnectionState) []string {
 
 	add(tls.CipherSuiteName(cs.CipherSuite))
 
+	if len(cs.PeerCertificates) > 0 {
+		add("client-cert=" + TLSClientCertFingerprint(cs.PeerCertificates[0]))
+	}
+
 	// Make it a comment.
 	l[0] = "(" + l[0]
 	l[len(l)-1] = l[len(l)-1] + ")"
 
 	return l
 }
+
+// TLSClientCertFingerprint returns the raw-url-base64-encoded SHA-256 of the
+// Subject Public Key Info of a certificate, as used for TLS public keys for
+// client authentication.
+func TLSClientCertFingerprint(cert *x509.Certificate) string {
+	buf := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.RawURLEncoding.EncodeToString(buf[:])
+}
@@ -10,18 +10,22 @@ import (
 )
 
 // StartTLSSessionTicketKeyRefresher sets session keys on the TLS config, and
-// rotates them periodically.
+// rotates them every rotation interval, or daily if zero.
 //
 // Useful for TLS configs that are being cloned for each connection. The
 // automatically managed keys would happen in the cloned config, and not make
 // it back to the base config.
-func StartTLSSessionTicketKeyRefresher(ctx context.Context, log mlog.Log, c *tls.Config) {
+func StartTLSSessionTicketKeyRefresher(ctx context.Context, log mlog.Log, c *tls.Config, rotation time.Duration) {
+	if rotation <= 0 {
+		rotation = 24 * time.Hour
+	}
+
 	var keys [][32]byte
 	first := make(chan struct{})
 
-	// Similar to crypto/tls, we rotate keys once a day. Previous keys stay valid for 7
-	// days. We currently only store ticket keys in memory, so a restart invalidates
-	// previous session tickets. We could store them in the future.
+	// Similar to crypto/tls, we rotate keys once a day by default. Previous keys stay
+	// valid for 7 rotations. We currently only store ticket keys in memory, so a
+	// restart invalidates previous session tickets. We could store them in the future.
 	go func() {
 		for {
 			var nk [32]byte
@@ -37,7 +41,7 @@ func StartTLSSessionTicketKeyRefresher(ctx context.Context, log mlog.Log, c *tls
 				first = nil
 			}
 
-			ctxDone := Sleep(ctx, 24*time.Hour)
+			ctxDone := Sleep(ctx, rotation)
 			if ctxDone {
 				break
 			}
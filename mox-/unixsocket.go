@@ -0,0 +1,183 @@
+package mox
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/mjl-/mox/config"
+)
+
+// ListenAddress is a network and address to listen on for a service.
+type ListenAddress struct {
+	Network string // "tcp", "tcp4", "tcp6" or "unix".
+	Address string // IP and port, or path of unix domain socket.
+}
+
+// ListenAddresses returns the addresses to listen on for a service of listener l,
+// with protocol (e.g. "smtp", "imaps", "https") and port: One for each IP of the
+// listener, and a unix domain socket in UnixSocketDir if configured.
+func ListenAddresses(l config.Listener, protocol string, port int) []ListenAddress {
+	var r []ListenAddress
+	for _, ip := range l.IPs {
+		r = append(r, ListenAddress{Network(ip), net.JoinHostPort(ip, fmt.Sprintf("%d", port))})
+	}
+	if l.UnixSocketDir != "" {
+		r = append(r, ListenAddress{"unix", UnixSocketPath(l, protocol, port)})
+	}
+	return r
+}
+
+// UnixSocketPath returns the path of the unix domain socket for the service with
+// protocol and port of listener l.
+func UnixSocketPath(l config.Listener, protocol string, port int) string {
+	return filepath.Join(DataDirPath(l.UnixSocketDir), fmt.Sprintf("%s-%d.sock", protocol, port))
+}
+
+// listenUnix creates a unix domain socket listener at path, creating the
+// directory if needed and removing a socket file left behind from an earlier run.
+// When running as root, the socket is made accessible for the mox user and group.
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("creating directory for unix domain socket: %v", err)
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing old unix domain socket: %v", err)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// We keep the socket file around when closing, the unprivileged child process
+	// still has a duplicate file descriptor. It is removed at the next start.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(path, 0660); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("setting permissions on unix domain socket: %v", err)
+	}
+	if os.Getuid() == 0 {
+		if err := os.Chown(path, int(Conf.Static.UID), int(Conf.Static.GID)); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("setting owner of unix domain socket: %v", err)
+		}
+	}
+	return ln, nil
+}
+
+// unixListener returns connections that have 127.0.0.1 as local and remote
+// address. The servers use the remote IP for logging, access control, rate
+// limiting and reputation, and treat connections on unix domain sockets as coming
+// from localhost.
+type unixListener struct {
+	net.Listener
+}
+
+func (ln unixListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return unixConn{conn}, nil
+}
+
+type unixConn struct {
+	net.Conn
+}
+
+var unixConnAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+func (unixConn) LocalAddr() net.Addr {
+	return unixConnAddr
+}
+
+func (unixConn) RemoteAddr() net.Addr {
+	return unixConnAddr
+}
+
+// Sockets passed by systemd through socket activation, keyed by listenKey. Loaded
+// on first use by the privileged process, and taken by Listen instead of creating
+// a new socket.
+var systemdSockets struct {
+	sync.Once
+	files map[string]*os.File
+}
+
+// listenKey returns a key for matching a configured listen address with a socket
+// from systemd socket activation.
+func listenKey(network, addr string) string {
+	if network == "unix" {
+		return "unix:" + addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return network + ":" + addr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		host = ip.String()
+	}
+	return "tcp:" + net.JoinHostPort(host, port)
+}
+
+// loadSystemdSockets reads the file descriptors passed through systemd socket
+// activation, in $LISTEN_FDS, starting at file descriptor 3. The environment
+// variables are cleared, they are not for the unprivileged child process.
+func loadSystemdSockets() {
+	systemdSockets.files = map[string]*os.File{}
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+	for fd := 3; fd < 3+n; fd++ {
+		closeOnExec(fd)
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("systemd socket %d", fd))
+		ln, err := net.FileListener(f)
+		if err != nil {
+			pkglog.Errorx("using socket from systemd socket activation, ignoring", err, slog.Int("fd", fd))
+			f.Close()
+			continue
+		}
+		var key string
+		switch a := ln.Addr().(type) {
+		case *net.TCPAddr:
+			key = listenKey("tcp", net.JoinHostPort(a.IP.String(), fmt.Sprintf("%d", a.Port)))
+		case *net.UnixAddr:
+			key = listenKey("unix", a.Name)
+		default:
+			pkglog.Error("unsupported socket type from systemd socket activation, ignoring", slog.Int("fd", fd), slog.Any("addr", ln.Addr()))
+			ln.Close()
+			f.Close()
+			continue
+		}
+		ln.Close()
+		pkglog.Debug("socket from systemd socket activation", slog.Int("fd", fd), slog.String("address", key))
+		systemdSockets.files[key] = f
+	}
+}
+
+// systemdSocket returns the socket for network and addr passed through systemd
+// socket activation, if any. A socket is returned only once.
+func systemdSocket(network, addr string) *os.File {
+	systemdSockets.Do(loadSystemdSockets)
+	key := listenKey(network, addr)
+	f := systemdSockets.files[key]
+	delete(systemdSockets.files, key)
+	return f
+}
@@ -0,0 +1,56 @@
+package mox
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenKey(t *testing.T) {
+	test := func(network, addr, exp string) {
+		t.Helper()
+		if key := listenKey(network, addr); key != exp {
+			t.Fatalf("listenKey(%q, %q): got %q, expected %q", network, addr, key, exp)
+		}
+	}
+	test("tcp4", "0.0.0.0:25", "tcp:0.0.0.0:25")
+	test("tcp", "[::ffff:127.0.0.1]:25", "tcp:127.0.0.1:25")
+	test("tcp6", "[::]:25", "tcp:[::]:25")
+	test("tcp6", "[2001:DB8::1]:993", "tcp:[2001:db8::1]:993")
+	test("unix", "/run/mox/smtp-25.sock", "unix:/run/mox/smtp-25.sock")
+}
+
+func TestListenUnix(t *testing.T) {
+	FilesImmediate = true
+	defer func() {
+		FilesImmediate = false
+	}()
+
+	path := filepath.Join(t.TempDir(), "sockets", "smtp-25.sock")
+	ln, err := Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	// Socket file is left behind after close, and removed when listening again.
+	ln.Close()
+	ln, err = Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen again: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+	if a, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || !a.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("got remote address %v, expected 127.0.0.1", conn.RemoteAddr())
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package moxio
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// LinkCount returns the number of hard links to a file, and whether the number is
+// known.
+func LinkCount(fi fs.FileInfo) (uint64, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Nlink), true
+}
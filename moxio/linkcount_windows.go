@@ -0,0 +1,12 @@
+package moxio
+
+import (
+	"io/fs"
+)
+
+// LinkCount returns the number of hard links to a file, and whether the number is
+// known. The number of links is never known on Windows.
+func LinkCount(fi fs.FileInfo) (uint64, bool) {
+	// todo: get number of links with GetFileInformationByHandle?
+	return 0, false
+}
@@ -7,23 +7,33 @@ import (
 	"github.com/mjl-/mox/mlog"
 )
 
+// Capturer receives the protocol data read and written by TraceReader and
+// TraceWriter, e.g. for recording connections while debugging.
+type Capturer interface {
+	Capture(prefix string, level slog.Level, buf []byte)
+}
+
 type TraceWriter struct {
-	log    mlog.Log
-	prefix string
-	w      io.Writer
-	level  slog.Level
+	log     mlog.Log
+	prefix  string
+	w       io.Writer
+	level   slog.Level
+	capture Capturer
 }
 
 // NewTraceWriter wraps "w" into a writer that logs all writes to "log" with
 // log level trace, prefixed with "prefix".
 func NewTraceWriter(log mlog.Log, prefix string, w io.Writer) *TraceWriter {
-	return &TraceWriter{log, prefix, w, mlog.LevelTrace}
+	return &TraceWriter{log, prefix, w, mlog.LevelTrace, nil}
 }
 
 // Write logs a trace line for writing buf to the client, then writes to the
 // client.
 func (w *TraceWriter) Write(buf []byte) (int, error) {
 	w.log.Trace(w.level, w.prefix, buf)
+	if w.capture != nil {
+		w.capture.Capture(w.prefix, w.level, buf)
+	}
 	return w.w.Write(buf)
 }
 
@@ -31,17 +41,24 @@ func (w *TraceWriter) SetTrace(level slog.Level) {
 	w.level = level
 }
 
+// SetCapture sets a capturer that receives all written data, along with the
+// current trace level.
+func (w *TraceWriter) SetCapture(c Capturer) {
+	w.capture = c
+}
+
 type TraceReader struct {
-	log    mlog.Log
-	prefix string
-	r      io.Reader
-	level  slog.Level
+	log     mlog.Log
+	prefix  string
+	r       io.Reader
+	level   slog.Level
+	capture Capturer
 }
 
 // NewTraceReader wraps reader "r" into a reader that logs all reads to "log"
 // with log level trace, prefixed with "prefix".
 func NewTraceReader(log mlog.Log, prefix string, r io.Reader) *TraceReader {
-	return &TraceReader{log, prefix, r, mlog.LevelTrace}
+	return &TraceReader{log, prefix, r, mlog.LevelTrace, nil}
 }
 
 // Read does a single Read on its underlying reader, logs data of successful
@@ -50,6 +67,9 @@ func (r *TraceReader) Read(buf []byte) (int, error) {
 	n, err := r.r.Read(buf)
 	if n > 0 {
 		r.log.Trace(r.level, r.prefix, buf[:n])
+		if r.capture != nil {
+			r.capture.Capture(r.prefix, r.level, buf[:n])
+		}
 	}
 	return n, err
 }
@@ -57,3 +77,9 @@ func (r *TraceReader) Read(buf []byte) (int, error) {
 func (r *TraceReader) SetTrace(level slog.Level) {
 	r.level = level
 }
+
+// SetCapture sets a capturer that receives all read data, along with the current
+// trace level.
+func (r *TraceReader) SetCapture(c Capturer) {
+	r.capture = c
+}
@@ -0,0 +1,195 @@
+// Package notify sends short notifications about newly delivered messages to
+// push notification services.
+//
+// Notifications are configured per account, see Notifications in the account
+// config. For each incoming message delivered over SMTP that matches the
+// optional rules of a notification, a request is made to an ntfy topic, a Gotify
+// server, or a generic webhook, with the sender, subject and mailbox of the
+// message. Useful for getting notified of new email on devices where an IMAP
+// connection with IDLE isn't practical.
+//
+// Notifications are best-effort: They are not queued, and failed requests are
+// only logged, not retried.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moxvar"
+)
+
+var (
+	metricRequest = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_notify_request_duration_seconds",
+			Help:    "Duration of requests to notification services, by type and result.",
+			Buckets: []float64{0.01, 0.05, 0.100, 0.5, 1, 5, 10, 20, 30},
+		},
+		[]string{
+			"type",   // ntfy, gotify, webhook
+			"result", // ok, error
+		},
+	)
+)
+
+// Timeout for a request to a notification service.
+const timeout = 30 * time.Second
+
+// Message holds the details about a delivered message that are sent in a
+// notification. It is the JSON body for notifications of type webhook.
+type Message struct {
+	Version   int       // Format of the notification, currently 0.
+	Account   string    // Account the message was delivered to.
+	Mailbox   string    // Mailbox the message was delivered to.
+	From      string    // Address from message From header, may be empty.
+	FromName  string    // Display name from message From header, may be empty.
+	Subject   string    // From message header.
+	MessageID string    // Message-Id header, including <>.
+	Received  time.Time // Time of delivery.
+}
+
+// sender returns the display name and address of the sender for use in
+// notification texts.
+func (m Message) sender() string {
+	if m.FromName != "" && m.From != "" {
+		return fmt.Sprintf("%s <%s>", m.FromName, m.From)
+	} else if m.From != "" {
+		return m.From
+	}
+	return m.FromName
+}
+
+// Matches returns whether notification n applies to message m, based on its
+// mailbox, sender address and subject rules.
+func Matches(n config.Notification, m Message) bool {
+	if len(n.Mailboxes) > 0 && !slices.Contains(n.Mailboxes, m.Mailbox) {
+		return false
+	}
+	if n.FromRegexpCompiled != nil && !n.FromRegexpCompiled.MatchString(m.From) {
+		return false
+	}
+	if n.SubjectRegexpCompiled != nil && !n.SubjectRegexpCompiled.MatchString(m.Subject) {
+		return false
+	}
+	return true
+}
+
+var client = &http.Client{}
+
+// Send sends a notification about message m to the service configured in n.
+func Send(ctx context.Context, log mlog.Log, n config.Notification, m Message) (rerr error) {
+	start := time.Now()
+	defer func() {
+		result := "ok"
+		if rerr != nil {
+			result = "error"
+		}
+		metricRequest.WithLabelValues(n.Type, result).Observe(float64(time.Since(start)) / float64(time.Second))
+		log.Debugx("notification result", rerr,
+			slog.String("type", n.Type),
+			slog.String("url", n.URL),
+			slog.Duration("duration", time.Since(start)))
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	title := "New message"
+	if s := m.sender(); s != "" {
+		title = "New message from " + s
+	}
+	text := m.Subject
+	if text == "" {
+		text = "(no subject)"
+	}
+	if m.Mailbox != "Inbox" {
+		text += "\nMailbox: " + m.Mailbox
+	}
+
+	var req *http.Request
+	var err error
+	switch n.Type {
+	case "ntfy":
+		// Topic URL, with title in a header, and the message as body.
+		// See https://docs.ntfy.sh/publish/.
+		req, err = http.NewRequestWithContext(ctx, "POST", n.URL, strings.NewReader(text))
+		if err == nil {
+			req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+			// ntfy accepts RFC 2047-encoded header values for non-ascii text.
+			req.Header.Set("Title", mime.QEncoding.Encode("utf-8", title))
+			req.Header.Set("Tags", "email")
+			if n.EffectiveToken != "" {
+				req.Header.Set("Authorization", "Bearer "+n.EffectiveToken)
+			}
+		}
+
+	case "gotify":
+		// Base URL of server, messages are posted to /message.
+		// See https://gotify.net/docs/pushmsg.
+		var buf []byte
+		buf, err = json.Marshal(struct {
+			Title    string `json:"title"`
+			Message  string `json:"message"`
+			Priority int    `json:"priority"`
+		}{title, text, 5})
+		if err != nil {
+			return fmt.Errorf("marshal gotify message: %v", err)
+		}
+		req, err = http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(n.URL, "/")+"/message", bytes.NewReader(buf))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json; charset=utf-8")
+			req.Header.Set("X-Gotify-Key", n.EffectiveToken)
+		}
+
+	case "webhook":
+		var buf []byte
+		buf, err = json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("marshal webhook message: %v", err)
+		}
+		req, err = http.NewRequestWithContext(ctx, "POST", n.URL, bytes.NewReader(buf))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json; charset=utf-8")
+			if n.EffectiveToken != "" {
+				req.Header.Set("Authorization", n.EffectiveToken)
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown notification type %q", n.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("mox/%s (notify)", moxvar.Version))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("notification request: %v", err)
+	}
+	defer func() {
+		err := resp.Body.Close()
+		log.Check(err, "closing response body")
+	}()
+	// Read (part of) the body, so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("notification request: status %q, expected 2xx", resp.Status)
+	}
+	return nil
+}
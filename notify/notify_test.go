@@ -0,0 +1,114 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+)
+
+func TestMatches(t *testing.T) {
+	m := Message{Mailbox: "Inbox", From: "boss@example.org", Subject: "Urgent: report"}
+
+	test := func(n config.Notification, exp bool) {
+		t.Helper()
+		if got := Matches(n, m); got != exp {
+			t.Fatalf("matches %#v: got %v, expected %v", n, got, exp)
+		}
+	}
+
+	test(config.Notification{}, true)
+	test(config.Notification{Mailboxes: []string{"Inbox"}}, true)
+	test(config.Notification{Mailboxes: []string{"Lists"}}, false)
+	test(config.Notification{FromRegexpCompiled: regexp.MustCompile(`@example\.org$`)}, true)
+	test(config.Notification{FromRegexpCompiled: regexp.MustCompile(`@example\.com$`)}, false)
+	test(config.Notification{SubjectRegexpCompiled: regexp.MustCompile(`(?i)urgent`)}, true)
+	test(config.Notification{SubjectRegexpCompiled: regexp.MustCompile(`invoice`)}, false)
+}
+
+func TestSend(t *testing.T) {
+	log := mlog.New("notify", nil)
+
+	var lastReq *http.Request
+	var lastBody string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "reading body", http.StatusInternalServerError)
+			return
+		}
+		lastReq = r
+		lastBody = string(buf)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	m := Message{
+		Account:   "mjl",
+		Mailbox:   "Lists",
+		From:      "remote@example.org",
+		FromName:  "Rémote",
+		Subject:   "hi",
+		MessageID: "<test@example.org>",
+		Received:  time.Now().Round(0).UTC(),
+	}
+
+	send := func(n config.Notification) {
+		t.Helper()
+		err := Send(context.Background(), log, n, m)
+		if err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+
+	check := func(got, exp string) {
+		t.Helper()
+		if got != exp {
+			t.Fatalf("got %q, expected %q", got, exp)
+		}
+	}
+
+	send(config.Notification{Type: "ntfy", URL: srv.URL + "/mytopic", EffectiveToken: "tk_secret"})
+	check(lastReq.URL.Path, "/mytopic")
+	check(lastReq.Header.Get("Authorization"), "Bearer tk_secret")
+	check(lastReq.Header.Get("Title"), "=?utf-8?q?New_message_from_R=C3=A9mote_<remote@example.org>?=")
+	check(lastBody, "hi\nMailbox: Lists")
+
+	send(config.Notification{Type: "gotify", URL: srv.URL + "/", EffectiveToken: "apptoken"})
+	check(lastReq.URL.Path, "/message")
+	check(lastReq.Header.Get("X-Gotify-Key"), "apptoken")
+	var gm struct {
+		Title   string `json:"title"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(lastBody), &gm); err != nil {
+		t.Fatalf("parsing gotify message: %v", err)
+	}
+	check(gm.Title, "New message from Rémote <remote@example.org>")
+	check(gm.Message, "hi\nMailbox: Lists")
+
+	send(config.Notification{Type: "webhook", URL: srv.URL + "/hook", EffectiveToken: "Basic dGVzdDp0ZXN0"})
+	check(lastReq.Header.Get("Authorization"), "Basic dGVzdDp0ZXN0")
+	var wm Message
+	if err := json.Unmarshal([]byte(lastBody), &wm); err != nil {
+		t.Fatalf("parsing webhook message: %v", err)
+	}
+	if wm != m {
+		t.Fatalf("got webhook message %#v, expected %#v", wm, m)
+	}
+
+	// Errors from service.
+	status = http.StatusForbidden
+	err := Send(context.Background(), log, config.Notification{Type: "ntfy", URL: srv.URL}, m)
+	if err == nil {
+		t.Fatalf("got nil error for forbidden response")
+	}
+}
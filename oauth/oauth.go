@@ -0,0 +1,198 @@
+// Package oauth verifies OAuth 2.0 bearer tokens, as used with the OAUTHBEARER and
+// XOAUTH2 SASL authentication mechanisms in IMAP and SMTP submission.
+//
+// Tokens issued by mox are stored (hashed) in the auth database, see
+// store.OAuthToken. Other tokens are validated through token introspection (RFC
+// 7662) at an external identity provider, if configured.
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// ErrInvalidToken is returned for unknown, expired or otherwise invalid tokens.
+var ErrInvalidToken = errors.New("invalid token")
+
+var (
+	metricVerify = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_oauth_verify_total",
+			Help: "OAuth bearer token verifications.",
+		},
+		[]string{
+			"source", // "local" or "external"
+			"result", // "ok", "invalid", "error"
+		},
+	)
+)
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// Verify checks if token is valid, and returns the email address it authenticates
+// as. The address belongs to an account. ErrInvalidToken is returned for tokens
+// that are not valid, other errors indicate temporary failures.
+func Verify(ctx context.Context, log mlog.Log, token string) (loginAddress string, rerr error) {
+	source := "external"
+	if store.IsOAuthToken(token) || mox.Conf.Static.OAuth == nil {
+		source = "local"
+	}
+	defer func() {
+		result := "ok"
+		if errors.Is(rerr, ErrInvalidToken) {
+			result = "invalid"
+		} else if rerr != nil {
+			result = "error"
+		}
+		metricVerify.WithLabelValues(source, result).Inc()
+	}()
+
+	if source == "local" {
+		t, err := store.OAuthTokenVerify(ctx, token)
+		if err != nil && errors.Is(err, store.ErrOAuthTokenUnknown) {
+			return "", ErrInvalidToken
+		} else if err != nil {
+			return "", fmt.Errorf("verifying token: %v", err)
+		}
+		// Address may have been removed from account after token was created.
+		if accName, err := lookupAccount(t.LoginAddress); err != nil {
+			return "", err
+		} else if accName != t.Account {
+			log.Info("login address for oauth token no longer belongs to account", slog.String("address", t.LoginAddress), slog.String("account", t.Account))
+			return "", ErrInvalidToken
+		}
+		return t.LoginAddress, nil
+	}
+
+	loginAddress, err := introspect(ctx, log, token)
+	if err != nil {
+		return "", err
+	}
+	if _, err := lookupAccount(loginAddress); err != nil {
+		return "", err
+	}
+	return loginAddress, nil
+}
+
+func lookupAccount(address string) (accountName string, rerr error) {
+	addr, err := smtp.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("%w: parsing login address: %v", ErrInvalidToken, err)
+	}
+	accountName, _, _, _, err = mox.LookupAddress(addr.Localpart, addr.Domain, false, false, false)
+	if err != nil && (errors.Is(err, mox.ErrDomainNotFound) || errors.Is(err, mox.ErrAddressNotFound)) {
+		return "", fmt.Errorf("%w: no account for login address", ErrInvalidToken)
+	} else if err != nil {
+		return "", fmt.Errorf("looking up login address: %v", err)
+	}
+	return accountName, nil
+}
+
+// introspect asks the external identity provider about the token. ../rfc/7662
+func introspect(ctx context.Context, log mlog.Log, token string) (loginAddress string, rerr error) {
+	conf := mox.Conf.Static.OAuth
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	data := url.Values{}
+	data.Set("token", token)
+	data.Set("token_type_hint", "access_token")
+	req, err := http.NewRequestWithContext(ctx, "POST", conf.IntrospectionURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("new request: %v", err)
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("mox/%s (oauth)", moxvar.Version))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(conf.ClientID), url.QueryEscape(conf.EffectiveClientSecret))
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token introspection request: %v", err)
+	}
+	defer func() {
+		err := resp.Body.Close()
+		log.Check(err, "closing response body")
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token introspection response status %q, expected 200 ok", resp.Status)
+	}
+	var result map[string]any
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&result); err != nil {
+		return "", fmt.Errorf("parsing token introspection response: %v", err)
+	}
+
+	if active, _ := result["active"].(bool); !active {
+		return "", fmt.Errorf("%w: token not active", ErrInvalidToken)
+	}
+	if exp, ok := result["exp"].(float64); ok && time.Now().After(time.Unix(int64(exp), 0)) {
+		return "", fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+	if !issuedFor(result, append([]string{conf.ClientID}, conf.Audiences...)) {
+		return "", fmt.Errorf("%w: token not issued for mox, audience and client id do not match configuration", ErrInvalidToken)
+	}
+	loginAddress, _ = result[conf.UsernameClaim].(string)
+	if loginAddress == "" {
+		return "", fmt.Errorf("%w: missing field %q in token introspection response", ErrInvalidToken, conf.UsernameClaim)
+	}
+	return loginAddress, nil
+}
+
+// issuedFor returns whether the token in the introspection result was issued for
+// one of the accepted audiences, either through its "aud", which can be a single
+// string or a list, or its "client_id". ../rfc/7662 ../rfc/7519
+func issuedFor(result map[string]any, accepted []string) bool {
+	var l []string
+	switch aud := result["aud"].(type) {
+	case string:
+		l = append(l, aud)
+	case []any:
+		for _, e := range aud {
+			if s, ok := e.(string); ok {
+				l = append(l, s)
+			}
+		}
+	}
+	if clientID, ok := result["client_id"].(string); ok {
+		l = append(l, clientID)
+	}
+	for _, s := range l {
+		if s != "" && slices.Contains(accepted, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorResponse returns the JSON error response to send to a client after a
+// failed authentication attempt. ../rfc/7628
+func ErrorResponse() []byte {
+	r := struct {
+		Status              string `json:"status"`
+		Schemes             string `json:"schemes"`
+		Scope               string `json:"scope"`
+		OpenIDConfiguration string `json:"openid-configuration,omitempty"`
+	}{"invalid_token", "bearer", "email", ""}
+	if conf := mox.Conf.Static.OAuth; conf != nil {
+		r.OpenIDConfiguration = conf.OpenIDConfiguration
+	}
+	buf, _ := json.Marshal(r)
+	return buf
+}
@@ -0,0 +1,64 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestIntrospect(t *testing.T) {
+	log := mlog.New("oauth", nil)
+
+	var response string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "mox" || pass != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, response)
+	}))
+	defer srv.Close()
+
+	mox.Conf.Static.OAuth = &config.OAuth{
+		IntrospectionURL:      srv.URL,
+		ClientID:              "mox",
+		EffectiveClientSecret: "secret",
+		UsernameClaim:         "email",
+		Audiences:             []string{"https://mail.mox.example", "thunderbird"},
+	}
+	defer func() { mox.Conf.Static.OAuth = nil }()
+
+	test := func(resp, expAddress string, expErr error) {
+		t.Helper()
+		response = resp
+		address, err := introspect(context.Background(), log, "token")
+		if expErr == nil && err != nil || expErr != nil && !errors.Is(err, expErr) {
+			t.Fatalf("got err %v, expected %v", err, expErr)
+		}
+		if address != expAddress {
+			t.Fatalf("got address %q, expected %q", address, expAddress)
+		}
+	}
+
+	test(`{"active": true, "aud": "mox", "email": "mjl@mox.example"}`, "mjl@mox.example", nil)
+	test(`{"active": true, "aud": ["other", "https://mail.mox.example"], "email": "mjl@mox.example"}`, "mjl@mox.example", nil)
+	test(`{"active": true, "client_id": "thunderbird", "email": "mjl@mox.example"}`, "mjl@mox.example", nil)
+	test(`{"active": true, "aud": "other", "client_id": "mox", "email": "mjl@mox.example"}`, "mjl@mox.example", nil)
+
+	// Tokens for other relying parties of the identity provider.
+	test(`{"active": true, "aud": "other", "email": "mjl@mox.example"}`, "", ErrInvalidToken)
+	test(`{"active": true, "aud": ["other", "another"], "client_id": "otherclient", "email": "mjl@mox.example"}`, "", ErrInvalidToken)
+	test(`{"active": true, "email": "mjl@mox.example"}`, "", ErrInvalidToken)
+	test(`{"active": true, "aud": "", "client_id": "", "email": "mjl@mox.example"}`, "", ErrInvalidToken)
+
+	test(`{"active": false, "aud": "mox", "email": "mjl@mox.example"}`, "", ErrInvalidToken)
+	test(`{"active": true, "aud": "mox", "exp": 1000, "email": "mjl@mox.example"}`, "", ErrInvalidToken)
+	test(`{"active": true, "aud": "mox"}`, "", ErrInvalidToken)
+}
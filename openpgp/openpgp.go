@@ -0,0 +1,374 @@
+// Package openpgp implements minimal parsing of OpenPGP public keys, and
+// verification of detached OpenPGP signatures, as used in PGP/MIME signed
+// messages (RFC 3156).
+//
+// Only version 4 and 6 keys and signatures are supported, with RSA, ECDSA (NIST
+// curves) and Ed25519 keys. Self-signatures and subkey binding signatures are not
+// verified: keys are expected to come from a trusted source, such as the keyring
+// of an account.
+package openpgp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/mail"
+	"slices"
+	"strings"
+
+	"github.com/mjl-/mox/smtp"
+)
+
+var ErrKey = errors.New("bad openpgp key")
+
+// MaxKeySize is the maximum size of a (binary) key we accept.
+const MaxKeySize = 256 * 1024
+
+// Packet tags. ../rfc/9580
+const (
+	tagSignature    = 2
+	tagPublicKey    = 6
+	tagUserID       = 13
+	tagPublicSubkey = 14
+)
+
+// Public key algorithms. ../rfc/9580
+const (
+	algoRSA         = 1
+	algoRSASignOnly = 3
+	algoECDSA       = 19
+	algoEdDSALegacy = 22
+	algoEd25519     = 27
+)
+
+// Curve OIDs, without the DER tag and length. ../rfc/9580
+var (
+	oidP256    = []byte{0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}
+	oidP384    = []byte{0x2b, 0x81, 0x04, 0x00, 0x22}
+	oidP521    = []byte{0x2b, 0x81, 0x04, 0x00, 0x23}
+	oidEd25519 = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0xda, 0x47, 0x0f, 0x01}
+)
+
+// Key is a parsed OpenPGP transferable public key.
+type Key struct {
+	Data        []byte   // Binary form of the key.
+	Fingerprint string   // Upper-case hexadecimal.
+	UserIDs     []string // Typically of the form "Name <user@example.org>".
+
+	// Primary key and subkeys, for verifying signatures.
+	keys []publicKey
+}
+
+// publicKey is a primary key or subkey.
+type publicKey struct {
+	fingerprint []byte
+	algo        byte
+	pub         crypto.PublicKey // Nil for unsupported algorithms, e.g. encryption-only.
+}
+
+// keyID returns the 8-byte key ID, as used in issuer subpackets of signatures.
+func (pk publicKey) keyID() []byte {
+	if len(pk.fingerprint) == sha1.Size {
+		// Version 4.
+		return pk.fingerprint[len(pk.fingerprint)-8:]
+	}
+	return pk.fingerprint[:8]
+}
+
+// ParseKey parses an OpenPGP transferable public key in binary or ASCII-armored
+// form. The first packet must be a version 4 or 6 public key packet, and at least
+// one user ID must be present.
+func ParseKey(buf []byte) (Key, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(buf), []byte("-----BEGIN ")) {
+		var err error
+		buf, err = dearmor(buf, "PGP PUBLIC KEY BLOCK")
+		if err != nil {
+			return Key{}, fmt.Errorf("%w: %v", ErrKey, err)
+		}
+	}
+	if len(buf) > MaxKeySize {
+		return Key{}, fmt.Errorf("%w: key larger than %d bytes", ErrKey, MaxKeySize)
+	}
+
+	k := Key{Data: buf}
+	for len(buf) > 0 {
+		tag, body, rest, err := parsePacket(buf)
+		if err != nil {
+			return Key{}, fmt.Errorf("%w: %v", ErrKey, err)
+		}
+		buf = rest
+
+		if k.Fingerprint == "" {
+			if tag != tagPublicKey {
+				return Key{}, fmt.Errorf("%w: first packet is not a public key, but tag %d", ErrKey, tag)
+			}
+			pk, err := parsePublicKey(body)
+			if err != nil {
+				return Key{}, err
+			}
+			k.Fingerprint = strings.ToUpper(hex.EncodeToString(pk.fingerprint))
+			k.keys = append(k.keys, pk)
+		} else if tag == tagPublicKey {
+			return Key{}, fmt.Errorf("%w: multiple keys", ErrKey)
+		} else if tag == tagUserID {
+			k.UserIDs = append(k.UserIDs, string(body))
+		} else if tag == tagPublicSubkey {
+			pk, err := parsePublicKey(body)
+			if err != nil {
+				return Key{}, err
+			}
+			k.keys = append(k.keys, pk)
+		}
+	}
+	if k.Fingerprint == "" {
+		return Key{}, fmt.Errorf("%w: empty key", ErrKey)
+	}
+	if len(k.UserIDs) == 0 {
+		return Key{}, fmt.Errorf("%w: no user id", ErrKey)
+	}
+	return k, nil
+}
+
+// dearmor decodes an ASCII-armored block of typ, e.g. "PGP PUBLIC KEY BLOCK".
+// ../rfc/9580
+func dearmor(buf []byte, typ string) ([]byte, error) {
+	lines := strings.Split(strings.ReplaceAll(string(buf), "\r\n", "\n"), "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "-----BEGIN "+typ+"-----" {
+		return nil, fmt.Errorf("missing %s begin line", strings.ToLower(typ))
+	}
+	lines = lines[1:]
+	// Skip armor headers, they end with an empty line.
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines = lines[i+1:]
+			break
+		} else if !strings.Contains(line, ": ") {
+			break
+		}
+	}
+	var data strings.Builder
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "-----END "+typ+"-----" {
+			out, err := base64.StdEncoding.DecodeString(data.String())
+			if err != nil {
+				return nil, fmt.Errorf("decoding base64: %v", err)
+			}
+			return out, nil
+		} else if strings.HasPrefix(line, "=") {
+			// Optional checksum.
+			continue
+		}
+		data.WriteString(line)
+	}
+	return nil, fmt.Errorf("missing %s end line", strings.ToLower(typ))
+}
+
+// parsePacket parses the packet at the start of buf. ../rfc/9580
+func parsePacket(buf []byte) (tag int, body, rest []byte, rerr error) {
+	if len(buf) < 2 || buf[0]&0x80 == 0 {
+		return 0, nil, nil, fmt.Errorf("bad packet header")
+	}
+	var size int
+	var n int
+	if buf[0]&0x40 != 0 {
+		// New format.
+		tag = int(buf[0] & 0x3f)
+		switch o := int(buf[1]); {
+		case o < 192:
+			size, n = o, 2
+		case o < 224:
+			if len(buf) < 3 {
+				return 0, nil, nil, fmt.Errorf("short packet length")
+			}
+			size, n = (o-192)<<8+int(buf[2])+192, 3
+		case o == 255:
+			if len(buf) < 6 {
+				return 0, nil, nil, fmt.Errorf("short packet length")
+			}
+			size, n = int(binary.BigEndian.Uint32(buf[2:6])), 6
+		default:
+			return 0, nil, nil, fmt.Errorf("partial body length not supported")
+		}
+	} else {
+		// Legacy format.
+		tag = int(buf[0]>>2) & 0x0f
+		switch buf[0] & 3 {
+		case 0:
+			size, n = int(buf[1]), 2
+		case 1:
+			if len(buf) < 3 {
+				return 0, nil, nil, fmt.Errorf("short packet length")
+			}
+			size, n = int(binary.BigEndian.Uint16(buf[1:3])), 3
+		case 2:
+			if len(buf) < 5 {
+				return 0, nil, nil, fmt.Errorf("short packet length")
+			}
+			size, n = int(binary.BigEndian.Uint32(buf[1:5])), 5
+		default:
+			return 0, nil, nil, fmt.Errorf("indeterminate packet length not supported")
+		}
+	}
+	if size < 0 || size > len(buf)-n {
+		return 0, nil, nil, fmt.Errorf("packet length beyond end of data")
+	}
+	return tag, buf[n : n+size], buf[n+size:], nil
+}
+
+// parsePublicKey parses a public key or subkey packet body, returning its
+// fingerprint and the key for verifying signatures. ../rfc/9580
+func parsePublicKey(body []byte) (publicKey, error) {
+	var pk publicKey
+	if len(body) == 0 {
+		return pk, fmt.Errorf("%w: empty public key packet", ErrKey)
+	}
+	var material []byte
+	switch body[0] {
+	case 4:
+		if len(body) < 6 {
+			return pk, fmt.Errorf("%w: short public key packet", ErrKey)
+		}
+		h := sha1.New()
+		h.Write([]byte{0x99, byte(len(body) >> 8), byte(len(body))})
+		h.Write(body)
+		pk.fingerprint = h.Sum(nil)
+		pk.algo = body[5]
+		material = body[6:]
+	case 6:
+		if len(body) < 10 || int(binary.BigEndian.Uint32(body[6:10])) != len(body)-10 {
+			return pk, fmt.Errorf("%w: bad public key packet length", ErrKey)
+		}
+		h := sha256.New()
+		h.Write([]byte{0x9b})
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(body))))
+		h.Write(body)
+		pk.fingerprint = h.Sum(nil)
+		pk.algo = body[5]
+		material = body[10:]
+	default:
+		return pk, fmt.Errorf("%w: unsupported public key version %d", ErrKey, body[0])
+	}
+
+	var err error
+	pk.pub, err = parseKeyMaterial(pk.algo, material)
+	if err != nil {
+		return pk, fmt.Errorf("%w: %v", ErrKey, err)
+	}
+	return pk, nil
+}
+
+// parseKeyMaterial parses the algorithm-specific public key material. For
+// algorithms that cannot be used for verifying signatures, nil is returned.
+// ../rfc/9580
+func parseKeyMaterial(algo byte, buf []byte) (crypto.PublicKey, error) {
+	switch algo {
+	case algoRSA, algoRSASignOnly:
+		n, buf, err := readMPI(buf)
+		if err != nil {
+			return nil, err
+		}
+		e, _, err := readMPI(buf)
+		if err != nil {
+			return nil, err
+		}
+		ev := new(big.Int).SetBytes(e)
+		if !ev.IsInt64() || ev.Int64() < 3 || ev.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("unsupported rsa exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(ev.Int64())}, nil
+
+	case algoECDSA, algoEdDSALegacy:
+		if len(buf) == 0 || int(buf[0]) == 0 || int(buf[0]) == 0xff || len(buf) < 1+int(buf[0]) {
+			return nil, fmt.Errorf("bad curve oid")
+		}
+		oid := buf[1 : 1+int(buf[0])]
+		point, _, err := readMPI(buf[1+int(buf[0]):])
+		if err != nil {
+			return nil, err
+		}
+		if algo == algoEdDSALegacy {
+			// Point is prefixed with 0x40 to indicate a native point. ../rfc/9580
+			if !bytes.Equal(oid, oidEd25519) {
+				return nil, fmt.Errorf("unsupported eddsa curve")
+			} else if len(point) != 1+ed25519.PublicKeySize || point[0] != 0x40 {
+				return nil, fmt.Errorf("bad ed25519 point")
+			}
+			return ed25519.PublicKey(point[1:]), nil
+		}
+		var curve elliptic.Curve
+		switch {
+		case bytes.Equal(oid, oidP256):
+			curve = elliptic.P256()
+		case bytes.Equal(oid, oidP384):
+			curve = elliptic.P384()
+		case bytes.Equal(oid, oidP521):
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported ecdsa curve")
+		}
+		x, y := elliptic.Unmarshal(curve, point)
+		if x == nil {
+			return nil, fmt.Errorf("bad ecdsa point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case algoEd25519:
+		if len(buf) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("bad ed25519 key size")
+		}
+		return ed25519.PublicKey(buf), nil
+	}
+	return nil, nil
+}
+
+// readMPI reads a multiprecision integer, returning its big-endian bytes. ../rfc/9580
+func readMPI(buf []byte) (v, rest []byte, rerr error) {
+	if len(buf) < 2 {
+		return nil, nil, fmt.Errorf("short mpi")
+	}
+	n := (int(binary.BigEndian.Uint16(buf)) + 7) / 8
+	if len(buf) < 2+n {
+		return nil, nil, fmt.Errorf("mpi beyond end of data")
+	}
+	return buf[2 : 2+n], buf[2+n:], nil
+}
+
+// Addresses returns the email addresses in the user IDs of the key. User IDs
+// without valid address are skipped.
+func (k Key) Addresses() []smtp.Address {
+	var l []smtp.Address
+	for _, uid := range k.UserIDs {
+		s := uid
+		if a, err := mail.ParseAddress(uid); err == nil {
+			s = a.Address
+		} else if t := strings.TrimSpace(uid); strings.HasSuffix(t, ">") && strings.Contains(t, "<") {
+			s = t[strings.LastIndex(t, "<")+1 : len(t)-1]
+		}
+		if a, err := smtp.ParseAddress(strings.TrimSpace(s)); err == nil {
+			l = append(l, a)
+		}
+	}
+	return l
+}
+
+// HasAddress returns whether the key has a user ID for the address.
+func (k Key) HasAddress(addr smtp.Address) bool {
+	return slices.ContainsFunc(k.Addresses(), func(a smtp.Address) bool {
+		return strings.EqualFold(string(a.Localpart), string(addr.Localpart)) && a.Domain == addr.Domain
+	})
+}
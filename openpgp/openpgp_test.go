@@ -0,0 +1,65 @@
+package openpgp
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mjl-/mox/smtp"
+)
+
+// Generated with: gpg --quick-gen-key "Mox Test <mjl@mox.example>" ed25519 sign never
+const testKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEatIr1BYJKwYBBAHaRw8BAQdACT6ZOfByvHCGzG46c1KvGooxU/iLaqLNobd7
+/t55c4a0Gk1veCBUZXN0IDxtamxAbW94LmV4YW1wbGU+iJAEExYIADgWIQQxfqgp
+TePUQKB4BaUy6/B0f8dsBQUCatIr1AIbAwULCQgHAgYVCgkICwIEFgIDAQIeAQIX
+gAAKCRAy6/B0f8dsBdM3AQDJM5gvUwbiGZcQwzOL9F2UpKRIOxgilICHQPckfpwz
+0QD+K7Po425muVXm4F8pz1zwQ1Q8Smn968j8A6R0zWIlxAk=
+=JHsk
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+func TestParseKey(t *testing.T) {
+	k, err := ParseKey([]byte(testKey))
+	if err != nil {
+		t.Fatalf("parse key: %v", err)
+	}
+	if k.Fingerprint != "317EA8294DE3D440A07805A532EBF0747FC76C05" {
+		t.Fatalf("got fingerprint %s", k.Fingerprint)
+	}
+	if len(k.UserIDs) != 1 || k.UserIDs[0] != "Mox Test <mjl@mox.example>" {
+		t.Fatalf("got user ids %v", k.UserIDs)
+	}
+
+	// Binary form.
+	bk, err := ParseKey(k.Data)
+	if err != nil || bk.Fingerprint != k.Fingerprint {
+		t.Fatalf("parse binary key: %v, fingerprint %s", err, bk.Fingerprint)
+	}
+
+	has := func(s string, exp bool) {
+		t.Helper()
+		addr, err := smtp.ParseAddress(s)
+		if err != nil {
+			t.Fatalf("parse address: %v", err)
+		}
+		if k.HasAddress(addr) != exp {
+			t.Fatalf("hasaddress %s, expected %v", s, exp)
+		}
+	}
+	has("mjl@mox.example", true)
+	has("MJL@mox.example", true)
+	has("other@mox.example", false)
+
+	bad := func(buf []byte) {
+		t.Helper()
+		if _, err := ParseKey(buf); !errors.Is(err, ErrKey) {
+			t.Fatalf("got err %v, expected ErrKey", err)
+		}
+	}
+	bad(nil)
+	bad([]byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nAAAA\n"))
+	bad(k.Data[:len(k.Data)-1])
+	bad(append([]byte{0xb4, 0x01, 'x'}, k.Data...)) // User ID packet first.
+	bad(k.Data[:0x35])                              // Without user id.
+}
@@ -0,0 +1,417 @@
+package openpgp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha3"
+	_ "crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed   = errors.New("malformed signature")
+	ErrUnsupported = errors.New("unsupported signature")
+	ErrSignature   = errors.New("signature does not match data")
+	ErrSigner      = errors.New("key of signer not found")
+)
+
+// Signature types for documents. ../rfc/9580
+const (
+	sigBinary = 0x00
+	sigText   = 0x01
+)
+
+// Signature subpacket types. ../rfc/9580
+const (
+	subCreationTime      = 2
+	subExpirationTime    = 3
+	subIssuerKeyID       = 16
+	subIssuerFingerprint = 33
+)
+
+// Hash algorithms. SHA-1 and older are not accepted for signatures over data.
+// ../rfc/9580
+var hashAlgorithms = map[byte]crypto.Hash{
+	8:  crypto.SHA256,
+	9:  crypto.SHA384,
+	10: crypto.SHA512,
+	11: crypto.SHA224,
+	12: crypto.SHA3_256,
+	14: crypto.SHA3_512,
+}
+
+// signature is a parsed signature packet.
+type signature struct {
+	version  byte
+	sigType  byte
+	algo     byte
+	hash     crypto.Hash
+	hashed   []byte // Version through hashed subpackets, hashed after the data.
+	left16   []byte
+	salt     []byte // Version 6 only.
+	material []byte
+
+	created     time.Time
+	expiration  time.Duration // Zero if signature does not expire.
+	issuerKeyID []byte
+	issuerFP    []byte
+}
+
+// Verify verifies a detached signature over data, as found in the second part of
+// a PGP/MIME signed message, by one of keys. The signature can be in binary or
+// ASCII-armored form. If it consists of multiple signature packets, the first
+// signature made by one of keys is verified.
+//
+// The key that made the signature is returned. If none of the keys made the
+// signature, ErrSigner is returned. For signatures that do not match the data,
+// ErrSignature is returned.
+func Verify(sigBuf []byte, data io.Reader, keys []Key, now time.Time) (Key, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(sigBuf), []byte("-----BEGIN ")) {
+		var err error
+		sigBuf, err = dearmor(sigBuf, "PGP SIGNATURE")
+		if err != nil {
+			return Key{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+	}
+
+	if len(sigBuf) == 0 {
+		return Key{}, fmt.Errorf("%w: no signature", ErrMalformed)
+	}
+	var issuers []string
+	for len(sigBuf) > 0 {
+		tag, body, rest, err := parsePacket(sigBuf)
+		if err != nil {
+			return Key{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		sigBuf = rest
+		if tag != tagSignature {
+			return Key{}, fmt.Errorf("%w: packet with tag %d instead of signature", ErrMalformed, tag)
+		}
+
+		sig, err := parseSignature(body)
+		if err != nil {
+			return Key{}, err
+		}
+		k, pk, ok := sig.findKey(keys)
+		if !ok {
+			if sig.issuerFP != nil {
+				issuers = append(issuers, strings.ToUpper(hex.EncodeToString(sig.issuerFP)))
+			} else if sig.issuerKeyID != nil {
+				issuers = append(issuers, strings.ToUpper(hex.EncodeToString(sig.issuerKeyID)))
+			}
+			continue
+		}
+		if err := sig.verify(pk, data, now); err != nil {
+			return k, err
+		}
+		return k, nil
+	}
+	if len(issuers) == 0 {
+		return Key{}, fmt.Errorf("%w: no issuer in signature", ErrSigner)
+	}
+	return Key{}, fmt.Errorf("%w: issuer %s", ErrSigner, strings.Join(issuers, ", "))
+}
+
+// parseSignature parses a version 4 or 6 signature packet body. ../rfc/9580
+func parseSignature(body []byte) (sig signature, rerr error) {
+	malformed := func(format string, args ...any) (signature, error) {
+		return signature{}, fmt.Errorf("%w: %s", ErrMalformed, fmt.Sprintf(format, args...))
+	}
+
+	// Length of the fields of the hashed and unhashed subpacket areas.
+	var nlen int
+	switch {
+	case len(body) == 0:
+		return malformed("empty signature packet")
+	case body[0] == 4:
+		nlen = 2
+	case body[0] == 6:
+		nlen = 4
+	default:
+		return signature{}, fmt.Errorf("%w: signature version %d", ErrUnsupported, body[0])
+	}
+	if len(body) < 4+nlen {
+		return malformed("short signature packet")
+	}
+	sig.version = body[0]
+	sig.sigType = body[1]
+	sig.algo = body[2]
+	if sig.sigType != sigBinary && sig.sigType != sigText {
+		return signature{}, fmt.Errorf("%w: signature type %#x is not for a document", ErrUnsupported, sig.sigType)
+	}
+	var ok bool
+	sig.hash, ok = hashAlgorithms[body[3]]
+	if !ok {
+		return signature{}, fmt.Errorf("%w: hash algorithm %d", ErrUnsupported, body[3])
+	}
+
+	subpackets := func(o int) (area []byte, next int, err error) {
+		if len(body) < o+nlen {
+			return nil, 0, fmt.Errorf("%w: short subpacket area length", ErrMalformed)
+		}
+		var n int
+		if nlen == 2 {
+			n = int(binary.BigEndian.Uint16(body[o:]))
+		} else {
+			n = int(binary.BigEndian.Uint32(body[o:]))
+		}
+		o += nlen
+		if n < 0 || n > len(body)-o {
+			return nil, 0, fmt.Errorf("%w: subpacket area beyond end of packet", ErrMalformed)
+		}
+		return body[o : o+n], o + n, nil
+	}
+	hashedArea, o, err := subpackets(4)
+	if err != nil {
+		return signature{}, err
+	}
+	sig.hashed = body[:o]
+	unhashedArea, o, err := subpackets(o)
+	if err != nil {
+		return signature{}, err
+	}
+	if err := sig.parseSubpackets(hashedArea, true); err != nil {
+		return signature{}, err
+	}
+	// Issuer subpackets are typically in the unhashed area for version 4 signatures.
+	// They only select the key to verify with, so can be unprotected.
+	if err := sig.parseSubpackets(unhashedArea, false); err != nil {
+		return signature{}, err
+	}
+
+	if len(body) < o+2 {
+		return malformed("missing hash prefix")
+	}
+	sig.left16 = body[o : o+2]
+	o += 2
+	if sig.version == 6 {
+		if len(body) < o+1 || len(body) < o+1+int(body[o]) {
+			return malformed("bad salt")
+		}
+		sig.salt = body[o+1 : o+1+int(body[o])]
+		o += 1 + int(body[o])
+	}
+	sig.material = body[o:]
+	if sig.created.IsZero() {
+		return malformed("missing signature creation time")
+	}
+	return sig, nil
+}
+
+// parseSubpackets parses the subpackets in an area of a signature. Creation and
+// expiration time are only used from the hashed area.
+func (sig *signature) parseSubpackets(buf []byte, hashed bool) error {
+	for len(buf) > 0 {
+		var n int
+		switch o := int(buf[0]); {
+		case o < 192:
+			n, buf = o, buf[1:]
+		case o < 255:
+			if len(buf) < 2 {
+				return fmt.Errorf("%w: short subpacket length", ErrMalformed)
+			}
+			n, buf = (o-192)<<8+int(buf[1])+192, buf[2:]
+		default:
+			if len(buf) < 5 {
+				return fmt.Errorf("%w: short subpacket length", ErrMalformed)
+			}
+			n, buf = int(binary.BigEndian.Uint32(buf[1:5])), buf[5:]
+		}
+		if n < 1 || n > len(buf) {
+			return fmt.Errorf("%w: bad subpacket length", ErrMalformed)
+		}
+		typ, critical, data := buf[0]&0x7f, buf[0]&0x80 != 0, buf[1:n]
+		buf = buf[n:]
+
+		switch typ {
+		case subCreationTime, subExpirationTime:
+			if len(data) != 4 {
+				return fmt.Errorf("%w: bad time subpacket", ErrMalformed)
+			}
+			if !hashed {
+				continue
+			}
+			v := binary.BigEndian.Uint32(data)
+			if typ == subCreationTime {
+				sig.created = time.Unix(int64(v), 0)
+			} else {
+				sig.expiration = time.Duration(v) * time.Second
+			}
+		case subIssuerKeyID:
+			if len(data) != 8 {
+				return fmt.Errorf("%w: bad issuer key id subpacket", ErrMalformed)
+			}
+			if sig.issuerKeyID == nil || hashed {
+				sig.issuerKeyID = data
+			}
+		case subIssuerFingerprint:
+			if len(data) < 1 || data[0] == 4 && len(data) != 1+20 || data[0] == 6 && len(data) != 1+32 {
+				return fmt.Errorf("%w: bad issuer fingerprint subpacket", ErrMalformed)
+			}
+			if sig.issuerFP == nil || hashed {
+				sig.issuerFP = data[1:]
+			}
+		default:
+			// Unknown critical subpackets make the signature invalid. ../rfc/9580
+			if critical && hashed {
+				return fmt.Errorf("%w: unknown critical subpacket type %d", ErrUnsupported, typ)
+			}
+		}
+	}
+	return nil
+}
+
+// findKey returns the key and primary key or subkey the signature claims to be
+// made with.
+func (sig signature) findKey(keys []Key) (Key, publicKey, bool) {
+	for _, k := range keys {
+		for _, pk := range k.keys {
+			if sig.issuerFP != nil && bytes.Equal(sig.issuerFP, pk.fingerprint) || sig.issuerFP == nil && sig.issuerKeyID != nil && bytes.Equal(sig.issuerKeyID, pk.keyID()) {
+				return k, pk, true
+			}
+		}
+	}
+	return Key{}, publicKey{}, false
+}
+
+// verify verifies the signature over data with key pk. ../rfc/9580
+func (sig signature) verify(pk publicKey, data io.Reader, now time.Time) error {
+	if pk.pub == nil {
+		return fmt.Errorf("%w: public key algorithm %d", ErrUnsupported, pk.algo)
+	} else if sig.algo != pk.algo {
+		return fmt.Errorf("%w: signature algorithm %d does not match key algorithm %d", ErrSignature, sig.algo, pk.algo)
+	} else if (len(pk.fingerprint) == 32) != (sig.version == 6) {
+		return fmt.Errorf("%w: signature version %d does not match key version", ErrSignature, sig.version)
+	}
+	if expires := sig.created.Add(sig.expiration); sig.expiration > 0 && !now.Before(expires) {
+		return fmt.Errorf("%w: signature expired at %s", ErrSignature, expires.UTC().Format(time.RFC3339))
+	}
+
+	h := sig.hash.New()
+	h.Write(sig.salt)
+	var w io.Writer = h
+	if sig.sigType == sigText {
+		w = &textWriter{w: h}
+	}
+	if _, err := io.Copy(w, data); err != nil {
+		return fmt.Errorf("reading signed data: %w", err)
+	}
+	h.Write(sig.hashed)
+	// Trailer. ../rfc/9580
+	h.Write([]byte{sig.version, 0xff})
+	h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(sig.hashed))))
+	digest := h.Sum(nil)
+	if !bytes.Equal(digest[:2], sig.left16) {
+		return ErrSignature
+	}
+
+	mpis := func(n int) ([][]byte, error) {
+		var l [][]byte
+		buf := sig.material
+		for range n {
+			v, rest, err := readMPI(buf)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+			}
+			l = append(l, v)
+			buf = rest
+		}
+		return l, nil
+	}
+
+	switch pub := pk.pub.(type) {
+	case *rsa.PublicKey:
+		l, err := mpis(1)
+		if err != nil {
+			return err
+		}
+		// Leading zero bytes are stripped from the MPI.
+		s := l[0]
+		if len(s) > pub.Size() {
+			return ErrSignature
+		}
+		s = append(make([]byte, pub.Size()-len(s)), s...)
+		if err := rsa.VerifyPKCS1v15(pub, sig.hash, digest, s); err != nil {
+			return ErrSignature
+		}
+	case *ecdsa.PublicKey:
+		l, err := mpis(2)
+		if err != nil {
+			return err
+		}
+		if !ecdsa.Verify(pub, digest, new(big.Int).SetBytes(l[0]), new(big.Int).SetBytes(l[1])) {
+			return ErrSignature
+		}
+	case ed25519.PublicKey:
+		var s []byte
+		if sig.algo == algoEdDSALegacy {
+			// R and S as MPIs, with leading zero bytes stripped.
+			l, err := mpis(2)
+			if err != nil {
+				return err
+			}
+			if len(l[0]) > 32 || len(l[1]) > 32 {
+				return ErrSignature
+			}
+			s = append(s, make([]byte, 32-len(l[0]))...)
+			s = append(s, l[0]...)
+			s = append(s, make([]byte, 32-len(l[1]))...)
+			s = append(s, l[1]...)
+		} else {
+			s = sig.material
+		}
+		if !ed25519.Verify(pub, digest, s) {
+			return ErrSignature
+		}
+	default:
+		return fmt.Errorf("%w: public key algorithm %d", ErrUnsupported, pk.algo)
+	}
+	return nil
+}
+
+// textWriter converts bare LF line endings to CRLF, for signatures over
+// canonical text.
+type textWriter struct {
+	w  io.Writer
+	cr bool // Whether last byte written was a CR.
+}
+
+func (w *textWriter) Write(buf []byte) (int, error) {
+	n := len(buf)
+	for len(buf) > 0 {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			if _, err := w.w.Write(buf); err != nil {
+				return 0, err
+			}
+			w.cr = buf[len(buf)-1] == '\r'
+			break
+		}
+		cr := i > 0 && buf[i-1] == '\r' || i == 0 && w.cr
+		if _, err := w.w.Write(buf[:i]); err != nil {
+			return 0, err
+		}
+		if cr {
+			_, err := w.w.Write([]byte{'\n'})
+			if err != nil {
+				return 0, err
+			}
+		} else if _, err := w.w.Write([]byte("\r\n")); err != nil {
+			return 0, err
+		}
+		w.cr = false
+		buf = buf[i+1:]
+	}
+	return n, nil
+}
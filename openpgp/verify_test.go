@@ -0,0 +1,240 @@
+package openpgp
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Generated with gpg --quick-gen-key, for ed25519, rsa2048 and nistp256, and an
+// ed25519 certification-only key with an ed25519 signing subkey.
+var verifyKeys = map[string]string{
+	"signer": `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEatLwthYJKwYBBAHaRw8BAQdAHG/K8YY8p6wxjZpagyBPp+v8FSaWPm0W4piv
+mI07oG20Ik1veCBTaWduZXIgPHNpZ25lckByZW1vdGUuZXhhbXBsZT6IkAQTFggA
+OBYhBJC2AlGnSnzfcLcW7L/uelzTzL9WBQJq0vC2AhsDBQsJCAcCBhUKCQgLAgQW
+AgMBAh4BAheAAAoJEL/uelzTzL9WgtIBAIaRe3aAsaO1LkgKFLzLha8cJQHpFGgk
+1WHOM3WJEmGjAQDlCp9YMxjhaCGds8mFooVOi7wl0QOhMJE6bb/IWsWWBA==
+=XGzB
+-----END PGP PUBLIC KEY BLOCK-----
+`,
+	"rsa": `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mQENBGrS8LYBCADNK1ixqJkA/nOAckB49d8SuaCUQiZtAkp4UkHYuYLJCmaozdAO
+TuUKH1llHt4O75ipeU5P6E6Z+0E+oPKyYIZGOsWgmKAM1QZCYbj8yIsVByUzA00F
+cuASkQYISF6UNRyLWes/RSEN8qM8+8Ewo0kBe6PE8CRm6jH19sz8CcLV5iNkiJ+z
+as0mnV13FsCywMw3fxZ0pnrYKNY3UcYdk042jC1PIw9Tg/d7z0yrEPsumn4CKhmw
+/9xP2jBl/6MaHrTuPHETd+Cldp1s+Bjd13o4uk6V5WIO/vndizoXCMKNNH5m968A
+saZ5JTbdPaCSBqK19tgyvu0LxFnbZ5z5shWLABEBAAG0HE1veCBSU0EgPHJzYUBy
+ZW1vdGUuZXhhbXBsZT6JAU4EEwEKADgWIQRo7xvGcWHtenv3tTFp2Nedud9JewUC
+atLwtgIbAwULCQgHAgYVCgkICwIEFgIDAQIeAQIXgAAKCRBp2Nedud9Je6gpB/9S
+PexPLvzR1NQNXI9x8FdgHbANC1Hc4AgeeMQWgz1DpnjLHwafw1no6FQFct+Oni08
+AtVDkkg07vyWNDnb2UnyiUm6lelucDOAH8RDM2XtO6CZVHRiS8mA9r3ipxFMdYQa
+7T82fapRpW/EFjRcvMcjxpIk8bRZzkiLYb5SlOhOfHPR3ldhSzIjzWrAZSltqU6B
+trEf5TDDUiFNO1HaT+PZD5YR2/S11EfAwbUtwHA1mxCX6OU9NarM4/k327lmaO3E
+F6mHd3QyXcQaBOy/YPwVr5f1h0D6ATaZTzANGxiJVY/Lzn+S4F0XgYw94TY2U+PT
+DpofK4zPogbDa7OVbkg/
+=30U0
+-----END PGP PUBLIC KEY BLOCK-----
+`,
+	"ecdsa": `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mFIEatLwtxMIKoZIzj0DAQcCAwQatbQdgTZCGUhUC36vSLlCu4qZJ2RxZlSHsMKd
+JuUBpQKUSc4nwZSWT1cdSnZ2hn3vqP2eDA/cgF2c61i0+AYttCBNb3ggRUNEU0Eg
+PGVjZHNhQHJlbW90ZS5leGFtcGxlPoiQBBMTCAA4FiEEw/U8PZxFOsJ8fkh/GI8h
+L/ldOSsFAmrS8LcCGwMFCwkIBwIGFQoJCAsCBBYCAwECHgECF4AACgkQGI8hL/ld
+OSsEpQEA/hqaIHP5u0SYy2Io8vgT0xCRYWVZfUo4B88yiKJpuYQA/A9XUYFykC1A
+f+rOaQJYcJ8e7/FNMTck1PazP0l7B8uo
+=ftTo
+-----END PGP PUBLIC KEY BLOCK-----
+`,
+	"sub": `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEatLwuxYJKwYBBAHaRw8BAQdA5vlyxj7bMZMyvAMbkM61QubrqloBaVCVKQed
+QIC+dGC0H01veCBTdWJrZXkgPHN1YkByZW1vdGUuZXhhbXBsZT6IkAQTFggAOBYh
+BMCeiYhHpp0HDXhZX4W7YQF1HyfbBQJq0vC7AhsBBQsJCAcCBhUKCQgLAgQWAgMB
+Ah4BAheAAAoJEIW7YQF1HyfbGf0BALWlxEpt9YDjeF2N2kaA0V/kczWGILKbBLlH
+jHuwqx9BAP943EUXzZ+ZZ6dkDjbJPs8IihvY+T4iwdwrtIaNOu+3BbgzBGrS8LsW
+CSsGAQQB2kcPAQEHQKt573gTGxP66FGkGM35COZOjby7QYOsOkzRuQGUETp0iO8E
+GBYIACAWIQTAnomIR6adBw14WV+Fu2EBdR8n2wUCatLwuwIbAgCBCRCFu2EBdR8n
+23YgBBkWCAAdFiEExSX7KBbWSFeaXrT928N9sHQtYAMFAmrS8LsACgkQ28N9sHQt
+YAN73wD/X8G6z1SwrHGF5tB/lPS51hpNgj1EyQcd/gFzr9WRukYA/jkFuUcgvC1Y
+oKRF3RfAfFTDKUqESTcCSlcwynN1XIcACWgA/i0DpfabvS87nmOh8WPjjwyKJyrS
+iQVJgnCkCHhkC8CrAQCFS3AmEMFhlW/BBTQ1sjb242SbLDB1xxUzg1ctjWINCA==
+=EAK4
+-----END PGP PUBLIC KEY BLOCK-----
+`,
+}
+
+// Generated with: gpg --armor --detach-sign [--textmode] --digest-algo SHA256, over verifyData.
+var verifySignatures = map[string]string{
+	"signer": `-----BEGIN PGP SIGNATURE-----
+
+iIwEABYIADQWIQSQtgJRp0p833C3Fuy/7npc08y/VgUCatLwuxYcc2lnbmVyQHJl
+bW90ZS5leGFtcGxlAAoJEL/uelzTzL9WNZgA/iVaRnysUEsBLUXjotLe4qSfEmpz
+r9sRorFWshmxhDI+AQD4NftoDWlrsGKjszztnPxL+mD3NMG4OYXbg0m4GaYkAQ==
+=8FXL
+-----END PGP SIGNATURE-----
+`,
+	"rsa": `-----BEGIN PGP SIGNATURE-----
+
+iQFHBAABCAAxFiEEaO8bxnFh7Xp797UxadjXnbnfSXsFAmrS8LsTHHJzYUByZW1v
+dGUuZXhhbXBsZQAKCRBp2Nedud9Jew7WCADCjhfUGkqDTkl9vt2khvZd+VG/7LRZ
+X/vm4cTHQRcNtUwjTubbA5oXjK+A6xG3CxtYCHpobIeljgY+pbFelc9OVpYt7cWD
+mgtK+vDWpJVpwDUOxWBjQ6wiyXJ/TKkSpV9Jfz+T6ErVVqFTiCneHgxLwyRANU7p
+dm4NNVoxBtPOt/GYJn5Z9cvP+OTWkfyqdsOq6pNn423Nm6NYiJwgOpxhIf6Vbfjd
+PBwtdvayXzmEfRN0+dI+77Bf7kQtbRIxd5zP1+sN61i2Alk2CuirciN0C5JyDGI6
+rJS0+t6QokrGy7ATMPYbUAqXtF/e5KLtPKy6FWEkgztbjagwQKiGlQxk
+=jCQ5
+-----END PGP SIGNATURE-----
+`,
+	"ecdsa": `-----BEGIN PGP SIGNATURE-----
+
+iIsEABMIADMWIQTD9Tw9nEU6wnx+SH8YjyEv+V05KwUCatLwuxUcZWNkc2FAcmVt
+b3RlLmV4YW1wbGUACgkQGI8hL/ldOSs5aAEAtpbKzcojqfeypQjiVyhypbAKh+M2
+ap1X/MgRpq/ENoIBAIFT3JnHuTL5SFYQQz5tvVvvVQcBvVCqZdxW1i5W82/S
+=OZES
+-----END PGP SIGNATURE-----
+`,
+	"sub": `-----BEGIN PGP SIGNATURE-----
+
+iIkEABYIADEWIQTFJfsoFtZIV5petP3bw32wdC1gAwUCatLwuxMcc3ViQHJlbW90
+ZS5leGFtcGxlAAoJENvDfbB0LWAD9eABAK6NDfl+GdrTdw9PPYDrPJ9mM5HpcNIV
+Iegth8fy8LhBAQDc3ZD/LebE7CUqYibnWMLx5NJy69a/VQccwQyfWLtTCw==
+=Bdz8
+-----END PGP SIGNATURE-----
+`,
+	"text": `-----BEGIN PGP SIGNATURE-----
+
+iIwEARYIADQWIQSQtgJRp0p833C3Fuy/7npc08y/VgUCatLwuxYcc2lnbmVyQHJl
+bW90ZS5leGFtcGxlAAoJEL/uelzTzL9WYFMA/3i8g0Lmm25cu6maaGnav5g0E3IH
+sj2w0JRXMaUVebAlAQDjWKO9BoR68Ju4yVMs9l4vv138Ccb+B0sOX0eIYwqoAg==
+=MXge
+-----END PGP SIGNATURE-----
+`,
+}
+
+const verifyData = "Content-Type: text/plain\r\n\r\nhello\r\n"
+
+func TestVerify(t *testing.T) {
+	var keys []Key
+	for _, name := range []string{"signer", "rsa", "ecdsa", "sub"} {
+		k, err := ParseKey([]byte(verifyKeys[name]))
+		if err != nil {
+			t.Fatalf("parse key %s: %v", name, err)
+		}
+		keys = append(keys, k)
+	}
+	now := time.Now()
+
+	test := func(sig, data string, keys []Key, expErr error, expFingerprint string) {
+		t.Helper()
+		k, err := Verify([]byte(sig), strings.NewReader(data), keys, now)
+		if expErr == nil && err != nil || expErr != nil && !errors.Is(err, expErr) {
+			t.Fatalf("verify: got err %v, expected %v", err, expErr)
+		}
+		if err == nil && k.Fingerprint != expFingerprint {
+			t.Fatalf("verify: got key %s, expected %s", k.Fingerprint, expFingerprint)
+		}
+	}
+
+	test(verifySignatures["signer"], verifyData, keys, nil, keys[0].Fingerprint)
+	test(verifySignatures["rsa"], verifyData, keys, nil, keys[1].Fingerprint)
+	test(verifySignatures["ecdsa"], verifyData, keys, nil, keys[2].Fingerprint)
+	test(verifySignatures["sub"], verifyData, keys, nil, keys[3].Fingerprint) // Signing subkey.
+
+	// Text signatures are over data with CRLF line endings.
+	test(verifySignatures["text"], verifyData, keys, nil, keys[0].Fingerprint)
+	test(verifySignatures["text"], strings.ReplaceAll(verifyData, "\r\n", "\n"), keys, nil, keys[0].Fingerprint)
+	test(verifySignatures["signer"], strings.ReplaceAll(verifyData, "\r\n", "\n"), keys, ErrSignature, "")
+
+	// Modified data.
+	for _, name := range []string{"signer", "rsa", "ecdsa", "sub"} {
+		test(verifySignatures[name], verifyData+"x", keys, ErrSignature, "")
+	}
+
+	// Key not in keyring.
+	test(verifySignatures["signer"], verifyData, keys[1:], ErrSigner, "")
+
+	// Bad signatures.
+	test("", verifyData, keys, ErrMalformed, "")
+	test("-----BEGIN PGP SIGNATURE-----\n\nAAAA\n", verifyData, keys, ErrMalformed, "")
+	test("\x88\x01\x04", verifyData, keys, ErrMalformed, "")
+}
+
+func TestVerifyV6(t *testing.T) {
+	// Construct a version 6 Ed25519 key and signature, gpg does not generate them.
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	created := time.Now().Add(-time.Hour)
+
+	packet := func(tag byte, body []byte) []byte {
+		if len(body) >= 192 {
+			t.Fatalf("packet too large")
+		}
+		return append([]byte{0xc0 | tag, byte(len(body))}, body...)
+	}
+
+	keyBody := []byte{6}
+	keyBody = binary.BigEndian.AppendUint32(keyBody, uint32(created.Unix()))
+	keyBody = append(keyBody, algoEd25519)
+	keyBody = binary.BigEndian.AppendUint32(keyBody, uint32(len(pub)))
+	keyBody = append(keyBody, pub...)
+	keyData := append(packet(tagPublicKey, keyBody), packet(tagUserID, []byte("<v6@mox.example>"))...)
+	k, err := ParseKey(keyData)
+	if err != nil {
+		t.Fatalf("parse v6 key: %v", err)
+	}
+	if len(k.Fingerprint) != 64 {
+		t.Fatalf("got fingerprint %s, expected sha-256", k.Fingerprint)
+	}
+
+	sign := func(data string, expires time.Duration) string {
+		subpackets := []byte{5, subCreationTime}
+		subpackets = binary.BigEndian.AppendUint32(subpackets, uint32(created.Unix()))
+		if expires > 0 {
+			subpackets = append(subpackets, 5, subExpirationTime)
+			subpackets = binary.BigEndian.AppendUint32(subpackets, uint32(expires/time.Second))
+		}
+		subpackets = append(subpackets, 1+1+32, subIssuerFingerprint, 6)
+		subpackets = append(subpackets, k.keys[0].fingerprint...)
+
+		hashed := []byte{6, sigBinary, algoEd25519, 8}
+		hashed = binary.BigEndian.AppendUint32(hashed, uint32(len(subpackets)))
+		hashed = append(hashed, subpackets...)
+
+		salt := bytes.Repeat([]byte{1}, 16)
+		h := sha256.New()
+		h.Write(salt)
+		h.Write([]byte(data))
+		h.Write(hashed)
+		h.Write([]byte{6, 0xff})
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(hashed))))
+		digest := h.Sum(nil)
+
+		body := binary.BigEndian.AppendUint32(hashed, 0)
+		body = append(body, digest[:2]...)
+		body = append(body, byte(len(salt)))
+		body = append(body, salt...)
+		body = append(body, ed25519.Sign(priv, digest)...)
+		return string(packet(tagSignature, body))
+	}
+
+	if _, err := Verify([]byte(sign("test", 0)), strings.NewReader("test"), []Key{k}, time.Now()); err != nil {
+		t.Fatalf("verify v6 signature: %v", err)
+	}
+	if _, err := Verify([]byte(sign("test", 0)), strings.NewReader("other"), []Key{k}, time.Now()); !errors.Is(err, ErrSignature) {
+		t.Fatalf("verify v6 signature over other data: got err %v, expected ErrSignature", err)
+	}
+	if _, err := Verify([]byte(sign("test", time.Minute)), strings.NewReader("test"), []Key{k}, time.Now()); !errors.Is(err, ErrSignature) {
+		t.Fatalf("verify expired v6 signature: got err %v, expected ErrSignature", err)
+	}
+}
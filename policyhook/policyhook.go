@@ -0,0 +1,320 @@
+// Package policyhook evaluates local policies during incoming SMTP transactions,
+// with an embedded WebAssembly module or an external policy service, and has the
+// data types for its requests and responses.
+//
+// A policy hook is configured per listener, see SMTP PolicyHook in the config
+// file. At each configured stage (connect, MAIL FROM, RCPT TO and after the
+// message data has been received and authentication results are known), mox
+// passes a JSON [Request] to the hook, which returns a JSON [Response] holding the
+// decision. This lets administrators implement local policies, for cases the
+// built-in checks don't cover.
+//
+// A WebAssembly module is executed by mox itself, in a sandbox without access to
+// files, network or environment. Modules can be compiled from languages like Go
+// (GOOS=wasip1), Rust, C or AssemblyScript, or contain an interpreter for a
+// scripting language like Lua. Each call gets a fresh instance of the module. The
+// module must export its "memory", and these functions:
+//
+//	mox_alloc(size i32) i32
+//	mox_policy(ptr i32, size i32) i64
+//
+// Mox calls mox_alloc for a buffer of size bytes, writes the JSON request into it,
+// and calls mox_policy with the location of the request. The return value of
+// mox_policy holds the location of the JSON response in the memory of the module:
+// the offset in the upper 32 bits, and the size in the lower 32 bits. A module
+// with a WASI "_initialize" function (a "reactor") has it called before
+// mox_alloc. Modules have access to the WASI clocks and random source.
+//
+// An external policy service is called with an HTTP POST request with the JSON
+// request as body, and responds with the JSON response. Such services can be
+// implemented in any language.
+//
+// New fields may be added to Request in the future, unrecognized fields should be
+// ignored when parsing for forward compatibility.
+package policyhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moxvar"
+)
+
+var (
+	metricRequest = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_policyhook_request_duration_seconds",
+			Help:    "Duration of requests to policy hooks, with resulting action.",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.100, 0.5, 1, 5, 10, 20, 30},
+		},
+		[]string{
+			"stage",  // connect, mail, rcpt, data
+			"action", // accept, reject, tempfail, quarantine, error
+		},
+	)
+)
+
+// Stage of an SMTP transaction at which the hook is called.
+type Stage string
+
+const (
+	StageConnect Stage = "connect" // After the connection was accepted, before the SMTP greeting.
+	StageMail    Stage = "mail"    // After MAIL FROM was parsed and checked.
+	StageRcpt    Stage = "rcpt"    // After RCPT TO was parsed and the recipient looked up.
+	StageData    Stage = "data"    // After the message was received and SPF/DKIM/DMARC were evaluated.
+)
+
+// Action is the decision of the policy hook.
+type Action string
+
+const (
+	// Continue processing as usual. Messages can still be rejected by later stages of
+	// the hook, or by mox itself, e.g. for being junk. An empty Action means accept.
+	ActionAccept Action = "accept"
+
+	// Reject with a permanent error. At stage connect, the connection is refused. At
+	// stage rcpt, only the recipient is refused.
+	ActionReject Action = "reject"
+
+	// Reject with a temporary error, the remote may try again later.
+	ActionTempfail Action = "tempfail"
+
+	// Accept, but deliver the message to the Junk mailbox of recipients. When returned
+	// at stage connect, mail or rcpt, it applies to the message(s) delivered in the
+	// connection or transaction.
+	ActionQuarantine Action = "quarantine"
+)
+
+// Request is the JSON body sent to the policy hook.
+type Request struct {
+	Version      int    // Format of request, currently 0.
+	Stage        Stage  // Stage of SMTP transaction.
+	ConnectionID int64  // Identifier of the connection, as used in the mox logging.
+	RemoteIP     string // IP address of the connecting SMTP client.
+	LocalIP      string // IP address the connection was accepted on.
+	TLS          bool   // Whether connection is currently TLS-protected.
+
+	// Fields below are empty at stage connect.
+	EHLO     string // Domain or IP address literal from EHLO/HELO.
+	MailFrom string // SMTP MAIL FROM address. Empty for the null reverse path ("<>").
+
+	RcptTo     string   // For stage rcpt, the recipient being evaluated.
+	Recipients []string // Recipients accepted so far. For stage data, all recipients.
+
+	Data *Data `json:",omitempty"` // Only for stage data.
+}
+
+// Data holds details about the message and its evaluation, for stage data.
+type Data struct {
+	Size        int64               // Of message as received, excluding headers added by mox.
+	From        string              // Address in message From header. Can be empty.
+	Subject     string              // From message header.
+	MessageID   string              // Message-Id header, including <>.
+	Header      map[string][]string // Message header, with canonicalized keys.
+	IPRev       string              // Result of reverse IP lookup: pass, fail, temperror, permerror.
+	SPF         string              // SPF result: none, neutral, pass, fail, softfail, temperror, permerror.
+	SPFIdentity string              // Verified SPF identity: mailfrom, helo, or empty.
+	SPFDomain   string              // Domain that was evaluated for SPF.
+	DKIM        []DKIMResult        // One result per DKIM-Signature header.
+	DMARC       string              // DMARC result: none, pass, fail, temperror, permerror.
+	DMARCDomain string              // Domain of DMARC policy, typically the organizational domain of From.
+	DMARCReject bool                // Whether the DMARC policy requests rejection of the message.
+}
+
+// DKIMResult is the result of verifying a DKIM-Signature.
+type DKIMResult struct {
+	Status   string // none, pass, fail, policy, neutral, temperror, permerror.
+	Domain   string // Signing domain, from d= in signature. Empty if signature could not be parsed.
+	Selector string // From s= in signature.
+}
+
+// Response is the JSON body of the response from the policy hook.
+type Response struct {
+	Action Action // Decision, see constants. Empty means accept.
+
+	// For actions reject and tempfail, optional SMTP code, must be 5xx for reject and
+	// 4xx for tempfail. Default 550 for reject, 451 for tempfail.
+	Code int
+	// For actions reject and tempfail, optional enhanced status code, e.g. "5.7.1".
+	// The first digit must match Code. Default 5.7.1 for reject and 4.7.1 for
+	// tempfail.
+	EnhancedCode string
+	// For actions reject and tempfail, optional human-readable message sent in the SMTP
+	// response. Must be printable ASCII, at most 200 characters.
+	Message string
+
+	// Message header fields to add to message during delivery. Headers returned at
+	// earlier stages are added to all messages of the connection (stage connect) or
+	// transaction (stages mail and rcpt).
+	Headers []Header
+}
+
+// Header is a message header field to add.
+type Header struct {
+	Name  string // E.g. "X-Policy". Must be printable ASCII, without colon or space.
+	Value string // Value, without line endings.
+}
+
+// ErrResponse indicates the policy hook returned an invalid response.
+var ErrResponse = errors.New("invalid response from policy hook")
+
+var client = &http.Client{}
+
+// Call evaluates the request with the WebAssembly module or external service of
+// the policy hook, and returns its parsed and checked response. An error is
+// returned if the hook could not be executed or reached, or returned an invalid
+// response.
+func Call(ctx context.Context, log mlog.Log, hook *config.PolicyHook, req Request) (resp Response, rerr error) {
+	start := time.Now()
+	defer func() {
+		action := string(resp.Action)
+		if rerr != nil {
+			action = "error"
+		}
+		metricRequest.WithLabelValues(string(req.Stage), action).Observe(float64(time.Since(start)) / float64(time.Second))
+		log.Debugx("policy hook result", rerr,
+			slog.String("stage", string(req.Stage)),
+			slog.String("action", string(resp.Action)),
+			slog.Duration("duration", time.Since(start)))
+	}()
+
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return Response{}, fmt.Errorf("marshal request: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout)
+	defer cancel()
+	if hook.WASMPath != "" {
+		resp, err = callWASM(ctx, hook.WASMPath, buf)
+	} else {
+		resp, err = callHTTP(ctx, log, hook, buf)
+	}
+	if err != nil {
+		return Response{}, err
+	}
+	if err := resp.check(); err != nil {
+		return Response{}, fmt.Errorf("%w: %v", ErrResponse, err)
+	}
+	return resp, nil
+}
+
+// callHTTP makes a request to the external policy service of the hook.
+func callHTTP(ctx context.Context, log mlog.Log, hook *config.PolicyHook, buf []byte) (resp Response, rerr error) {
+	hreq, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(buf))
+	if err != nil {
+		return Response{}, fmt.Errorf("new request: %v", err)
+	}
+	hreq.Header.Set("User-Agent", fmt.Sprintf("mox/%s (policyhook)", moxvar.Version))
+	hreq.Header.Set("Content-Type", "application/json; charset=utf-8")
+	hreq.Header.Set("Accept", "application/json")
+	if hook.EffectiveAuthorization != "" {
+		hreq.Header.Set("Authorization", hook.EffectiveAuthorization)
+	}
+	hresp, err := client.Do(hreq)
+	if err != nil {
+		return Response{}, fmt.Errorf("policy hook request: %v", err)
+	}
+	defer func() {
+		err := hresp.Body.Close()
+		log.Check(err, "closing response body")
+	}()
+	if hresp.StatusCode != http.StatusOK {
+		return Response{}, fmt.Errorf("%w: status %q, expected 200 ok", ErrResponse, hresp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(hresp.Body, 64*1024)).Decode(&resp); err != nil {
+		return Response{}, fmt.Errorf("%w: parsing json: %v", ErrResponse, err)
+	}
+	return resp, nil
+}
+
+// check verifies the response, and sets the default action and codes.
+func (r *Response) check() error {
+	var class int
+	switch r.Action {
+	case "":
+		r.Action = ActionAccept
+	case ActionAccept, ActionQuarantine:
+	case ActionReject:
+		class = 5
+	case ActionTempfail:
+		class = 4
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	if class == 0 && (r.Code != 0 || r.EnhancedCode != "" || r.Message != "") {
+		return fmt.Errorf("code, enhanced code and message only allowed for reject and tempfail")
+	}
+	if class != 0 {
+		if r.Code == 0 && class == 5 {
+			r.Code = 550
+		} else if r.Code == 0 {
+			r.Code = 451
+		} else if r.Code/100 != class || r.Code%100 >= 60 {
+			return fmt.Errorf("code %d not valid for action %s", r.Code, r.Action)
+		}
+		if r.EnhancedCode == "" {
+			r.EnhancedCode = fmt.Sprintf("%d.7.1", class)
+		} else if !validEnhancedCode(r.EnhancedCode, class) {
+			return fmt.Errorf("enhanced code %q not valid for action %s", r.EnhancedCode, r.Action)
+		}
+		if len(r.Message) > 200 || !printableASCII(r.Message, true) {
+			return fmt.Errorf("message must be at most 200 printable ascii characters")
+		}
+	}
+
+	for _, h := range r.Headers {
+		if h.Name == "" || !printableASCII(h.Name, false) || strings.Contains(h.Name, ":") {
+			return fmt.Errorf("invalid header name %q", h.Name)
+		}
+		if strings.ContainsAny(h.Value, "\r\n") {
+			return fmt.Errorf("header value for %q contains line ending", h.Name)
+		}
+	}
+	return nil
+}
+
+// validEnhancedCode returns whether s is a valid enhanced status code with the
+// given class. ../rfc/3463:119
+func validEnhancedCode(s string, class int) bool {
+	t := strings.Split(s, ".")
+	if len(t) != 3 || t[0] != fmt.Sprintf("%d", class) {
+		return false
+	}
+	for _, e := range t[1:] {
+		if e == "" || len(e) > 3 || strings.Trim(e, "0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func printableASCII(s string, space bool) bool {
+	for _, c := range s {
+		if c < ' ' || c >= 0x7f || c == ' ' && !space {
+			return false
+		}
+	}
+	return true
+}
+
+// Secode returns the enhanced code without the leading class, as used by the
+// smtp package.
+func (r Response) Secode() string {
+	_, s, _ := strings.Cut(r.EnhancedCode, ".")
+	return s
+}
@@ -0,0 +1,204 @@
+package policyhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+)
+
+func TestCall(t *testing.T) {
+	log := mlog.New("policyhook", nil)
+
+	var response string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Stage != StageRcpt || req.RcptTo != "mjl@mox.example" {
+			http.Error(w, fmt.Sprintf("bad request %v %#v", err, req), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, response)
+	}))
+	defer srv.Close()
+
+	hook := &config.PolicyHook{URL: srv.URL, EffectiveAuthorization: "Bearer secret", Timeout: 5 * time.Second}
+	req := Request{Stage: StageRcpt, RemoteIP: "10.0.0.1", MailFrom: "remote@example.org", RcptTo: "mjl@mox.example"}
+
+	test := func(resp string, expResp Response, expErr error) {
+		t.Helper()
+		response = resp
+		r, err := Call(context.Background(), log, hook, req)
+		if expErr == nil && err != nil || expErr != nil && !errors.Is(err, expErr) {
+			t.Fatalf("got err %v, expected %v", err, expErr)
+		}
+		if expErr == nil && !reflect.DeepEqual(r, expResp) {
+			t.Fatalf("got response %#v, expected %#v", r, expResp)
+		}
+	}
+
+	test(`{}`, Response{Action: ActionAccept}, nil)
+	test(`{"Action": "reject"}`, Response{Action: ActionReject, Code: 550, EnhancedCode: "5.7.1"}, nil)
+	test(`{"Action": "tempfail", "Code": 450, "EnhancedCode": "4.2.0", "Message": "try later"}`, Response{Action: ActionTempfail, Code: 450, EnhancedCode: "4.2.0", Message: "try later"}, nil)
+	test(`{"Action": "quarantine", "Headers": [{"Name": "X-Policy", "Value": "suspicious"}]}`, Response{Action: ActionQuarantine, Headers: []Header{{"X-Policy", "suspicious"}}}, nil)
+
+	test(`{"Action": "bogus"}`, Response{}, ErrResponse)
+	test(`{"Action": "reject", "Code": 451}`, Response{}, ErrResponse)
+	test(`{"Action": "tempfail", "EnhancedCode": "5.7.1"}`, Response{}, ErrResponse)
+	test(`{"Action": "reject", "EnhancedCode": "5.x.1"}`, Response{}, ErrResponse)
+	test(`{"Action": "reject", "Message": "bad\r\n250 ok"}`, Response{}, ErrResponse)
+	test(`{"Action": "accept", "Code": 550}`, Response{}, ErrResponse)
+	test(`{"Headers": [{"Name": "X-Bad:", "Value": "x"}]}`, Response{}, ErrResponse)
+	test(`{"Headers": [{"Name": "X-Bad", "Value": "x\r\nInjected: y"}]}`, Response{}, ErrResponse)
+	test(`not json`, Response{}, ErrResponse)
+
+	hook.EffectiveAuthorization = ""
+	test(`{}`, Response{}, ErrResponse)
+
+	// Unreachable hook.
+	srv.Close()
+	hook.EffectiveAuthorization = "Bearer secret"
+	_, err := Call(context.Background(), log, hook, req)
+	if err == nil || errors.Is(err, ErrResponse) {
+		t.Fatalf("got err %v, expected connection error", err)
+	}
+}
+
+// wasmPolicyModule returns a WebAssembly module that rejects at stage data, loops
+// forever at stage mail, and accepts otherwise.
+func wasmPolicyModule() []byte {
+	uleb := func(v uint64) (b []byte) {
+		for {
+			c := byte(v & 0x7f)
+			v >>= 7
+			if v == 0 {
+				return append(b, c)
+			}
+			b = append(b, c|0x80)
+		}
+	}
+	sleb := func(v int64) (b []byte) {
+		for {
+			c := byte(v & 0x7f)
+			v >>= 7
+			if v == 0 && c&0x40 == 0 || v == -1 && c&0x40 != 0 {
+				return append(b, c)
+			}
+			b = append(b, c|0x80)
+		}
+	}
+	vec := func(l ...[]byte) []byte {
+		b := uleb(uint64(len(l)))
+		for _, e := range l {
+			b = append(b, e...)
+		}
+		return b
+	}
+	name := func(s string) []byte {
+		return append(uleb(uint64(len(s))), s...)
+	}
+	section := func(id byte, content []byte) []byte {
+		return append(append([]byte{id}, uleb(uint64(len(content)))...), content...)
+	}
+	cat := func(l ...[]byte) []byte {
+		var b []byte
+		for _, e := range l {
+			b = append(b, e...)
+		}
+		return b
+	}
+	body := func(code ...[]byte) []byte {
+		b := cat(append([][]byte{{0}}, code...)...) // No locals.
+		return append(uleb(uint64(len(b))), b...)
+	}
+
+	const reqBuf, rejectBuf, acceptBuf = 1024, 2048, 3072
+	const reject = `{"Action": "reject", "Message": "policy says no"}`
+	const accept = `{}`
+	// Requests start with `{"Version":0,"Stage":"`, stage starts at offset 22.
+	stageByte := []byte{0x20, 0x00, 0x2d, 0x00, 22} // local.get 0, i32.load8_u offset 22.
+
+	return cat(
+		[]byte("\x00asm\x01\x00\x00\x00"),
+		section(1, vec(
+			[]byte{0x60, 1, 0x7f, 1, 0x7f},       // (i32) -> i32
+			[]byte{0x60, 2, 0x7f, 0x7f, 1, 0x7e}, // (i32, i32) -> i64
+		)),
+		section(3, vec([]byte{0}, []byte{1})),
+		section(5, vec([]byte{0, 1})), // Memory with 1 page.
+		section(7, vec(
+			cat(name("memory"), []byte{2, 0}),
+			cat(name("mox_alloc"), []byte{0, 0}),
+			cat(name("mox_policy"), []byte{0, 1}),
+		)),
+		section(10, vec(
+			body([]byte{0x41}, sleb(reqBuf), []byte{0x0b}),
+			body(
+				stageByte, []byte{0x41}, sleb('m'), []byte{0x46}, // i32.eq
+				[]byte{0x04, 0x40, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x0b}, // if, loop, br 0, end, end
+				stageByte, []byte{0x41}, sleb('d'), []byte{0x46},
+				[]byte{0x04, 0x7e, 0x42}, sleb(rejectBuf<<32|int64(len(reject))), // if (result i64), i64.const
+				[]byte{0x05, 0x42}, sleb(acceptBuf<<32|int64(len(accept))), // else, i64.const
+				[]byte{0x0b, 0x0b},
+			),
+		)),
+		section(11, vec(
+			cat([]byte{0, 0x41}, sleb(rejectBuf), []byte{0x0b}, name(reject)),
+			cat([]byte{0, 0x41}, sleb(acceptBuf), []byte{0x0b}, name(accept)),
+		)),
+	)
+}
+
+func TestCallWASM(t *testing.T) {
+	log := mlog.New("policyhook", nil)
+
+	path := filepath.Join(t.TempDir(), "policy.wasm")
+	err := os.WriteFile(path, wasmPolicyModule(), 0660)
+	if err != nil {
+		t.Fatalf("writing wasm module: %v", err)
+	}
+	hook := &config.PolicyHook{WASMPath: path, Timeout: 5 * time.Second}
+
+	test := func(stage Stage, expResp Response, expErr error) {
+		t.Helper()
+		r, err := Call(context.Background(), log, hook, Request{Stage: stage, RemoteIP: "10.0.0.1"})
+		if expErr == nil && err != nil || expErr != nil && !errors.Is(err, expErr) {
+			t.Fatalf("got err %v, expected %v", err, expErr)
+		}
+		if expErr == nil && !reflect.DeepEqual(r, expResp) {
+			t.Fatalf("got response %#v, expected %#v", r, expResp)
+		}
+	}
+
+	test(StageConnect, Response{Action: ActionAccept}, nil)
+	test(StageData, Response{Action: ActionReject, Code: 550, EnhancedCode: "5.7.1", Message: "policy says no"}, nil)
+	test(StageRcpt, Response{Action: ActionAccept}, nil)
+
+	// Module that doesn't finish is stopped at the timeout.
+	hook.Timeout = 100 * time.Millisecond
+	test(StageMail, Response{}, context.DeadlineExceeded)
+
+	// Module without the required exports.
+	hook.WASMPath = filepath.Join(t.TempDir(), "empty.wasm")
+	err = os.WriteFile(hook.WASMPath, []byte("\x00asm\x01\x00\x00\x00"), 0660)
+	if err != nil {
+		t.Fatalf("writing wasm module: %v", err)
+	}
+	_, err = Call(context.Background(), log, hook, Request{Stage: StageConnect})
+	if err == nil {
+		t.Fatalf("got nil error for module without exports")
+	}
+}
@@ -0,0 +1,128 @@
+package policyhook
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// The WebAssembly runtime is the only third-party dependency for policy hooks. It
+// is pure Go without dependencies of its own, and executes modules in a sandbox:
+// modules only get the WASI functions for clocks and random data, without access
+// to files, network or environment.
+
+// Maximum memory of a module instance, in 64KiB pages.
+const wasmMaxMemoryPages = 1024
+
+// Maximum size of a JSON response from a module.
+const wasmMaxResponseSize = 64 * 1024
+
+var wasm struct {
+	sync.Mutex
+	runtime wazero.Runtime
+	modules map[string]wazero.CompiledModule // By path.
+}
+
+// wasmModule returns the compiled module for the file at path, compiling it on
+// first use.
+func wasmModule(ctx context.Context, path string) (wazero.Runtime, wazero.CompiledModule, error) {
+	wasm.Lock()
+	defer wasm.Unlock()
+
+	if wasm.runtime == nil {
+		// Executions are stopped when the context is done, e.g. at the timeout.
+		cfg := wazero.NewRuntimeConfig().WithCloseOnContextDone(true).WithMemoryLimitPages(wasmMaxMemoryPages)
+		r := wazero.NewRuntimeWithConfig(context.Background(), cfg)
+		if _, err := wasi_snapshot_preview1.Instantiate(context.Background(), r); err != nil {
+			return nil, nil, fmt.Errorf("instantiating wasi: %v", err)
+		}
+		wasm.runtime = r
+		wasm.modules = map[string]wazero.CompiledModule{}
+	}
+
+	if cm, ok := wasm.modules[path]; ok {
+		return wasm.runtime, cm, nil
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading wasm module: %v", err)
+	}
+	cm, err := wasm.runtime.CompileModule(ctx, buf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("compiling wasm module: %v", err)
+	}
+	for _, name := range []string{"mox_alloc", "mox_policy"} {
+		if _, ok := cm.ExportedFunctions()[name]; !ok {
+			return nil, nil, fmt.Errorf("wasm module does not export function %q", name)
+		}
+	}
+	wasm.modules[path] = cm
+	return wasm.runtime, cm, nil
+}
+
+// callWASM evaluates the request with the policy of the WebAssembly module at
+// path. Each call gets a fresh instance of the module, so no state is kept
+// between calls.
+func callWASM(ctx context.Context, path string, req []byte) (resp Response, rerr error) {
+	r, cm, err := wasmModule(ctx, path)
+	if err != nil {
+		return Response{}, err
+	}
+
+	cfg := wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(cryptorand.Reader)
+	mod, err := r.InstantiateModule(ctx, cm, cfg)
+	if err != nil {
+		return Response{}, fmt.Errorf("instantiating wasm module: %v", err)
+	}
+	defer mod.Close(context.Background())
+
+	call := func(fn string, params ...uint64) (uint64, error) {
+		results, err := mod.ExportedFunction(fn).Call(ctx, params...)
+		if err != nil && ctx.Err() != nil {
+			// Execution was stopped, e.g. at the timeout.
+			return 0, fmt.Errorf("calling %s: %w", fn, ctx.Err())
+		} else if err != nil {
+			return 0, fmt.Errorf("calling %s: %v", fn, err)
+		} else if len(results) != 1 {
+			return 0, fmt.Errorf("%w: %s returned %d values, expected 1", ErrResponse, fn, len(results))
+		}
+		return results[0], nil
+	}
+
+	v, err := call("mox_alloc", uint64(len(req)))
+	if err != nil {
+		return Response{}, err
+	}
+	ptr := uint32(v)
+	if !mod.Memory().Write(ptr, req) {
+		return Response{}, fmt.Errorf("%w: buffer from mox_alloc outside memory", ErrResponse)
+	}
+
+	v, err = call("mox_policy", uint64(ptr), uint64(len(req)))
+	if err != nil {
+		return Response{}, err
+	}
+	rptr, rlen := uint32(v>>32), uint32(v)
+	if rlen > wasmMaxResponseSize {
+		return Response{}, fmt.Errorf("%w: response larger than %d bytes", ErrResponse, wasmMaxResponseSize)
+	}
+	buf, ok := mod.Memory().Read(rptr, rlen)
+	if !ok {
+		return Response{}, fmt.Errorf("%w: response outside memory", ErrResponse)
+	}
+	if err := json.Unmarshal(buf, &resp); err != nil {
+		return Response{}, fmt.Errorf("%w: parsing json: %v", ErrResponse, err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,1404 @@
+// Package pop3server implements a POP3 server (RFC 1939) with the CAPA (RFC
+// 2449), STLS (RFC 2595) and SASL AUTH (RFC 5034) extensions.
+package pop3server
+
+/*
+Implementation notes
+
+- POP3 only gives access to the Inbox. At login, we take a snapshot of the
+  messages in the Inbox. Messages delivered during the session are not visible
+  until the next session. Messages removed by other sessions (e.g. IMAP) during the
+  session result in an error when retrieved.
+- DELE only marks messages for removal. They are removed when the session ends
+  with QUIT. Messages are not marked as seen when retrieved.
+- Unique IDs for UIDL are formed from the UIDVALIDITY of the Inbox and the IMAP
+  UID of the message, so they are stable across sessions.
+- Authentication shares the failed authentication rate limiter and account
+  authentication with the IMAP and SMTP submission servers. Login attempts are
+  recorded.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/ratelimit"
+	"github.com/mjl-/mox/scram"
+	"github.com/mjl-/mox/store"
+)
+
+var (
+	metricPOP3Connection = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_pop3_connection_total",
+			Help: "Incoming POP3 connections.",
+		},
+		[]string{
+			"service", // pop3, pop3s
+		},
+	)
+	metricPOP3Commands = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_pop3_command_duration_seconds",
+			Help:    "POP3 command duration and result codes in seconds.",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.100, 0.5, 1, 5, 10, 20},
+		},
+		[]string{
+			"cmd",
+			"result", // ok, ioerror, servererror, usererror, panic
+		},
+	)
+)
+
+var limiterConnectionrate, limiterConnections *ratelimit.Limiter
+
+func init() {
+	// Also called by tests, so they don't trigger the rate limiter.
+	limitersInit()
+}
+
+func limitersInit() {
+	mox.LimitersInit()
+	limiterConnectionrate = &ratelimit.Limiter{
+		WindowLimits: []ratelimit.WindowLimit{
+			{
+				Window: time.Minute,
+				Limits: [...]int64{300, 900, 2700},
+			},
+		},
+	}
+	limiterConnections = &ratelimit.Limiter{
+		WindowLimits: []ratelimit.WindowLimit{
+			{
+				Window: time.Duration(math.MaxInt64), // All of time.
+				Limits: [...]int64{30, 90, 270},
+			},
+		},
+	}
+}
+
+// Delay after bad/suspicious behaviour. Tests set these to zero.
+var badClientDelay = time.Second // Before reads and after 1-byte writes for probably spammers.
+var authFailDelay = time.Second  // After authentication failure.
+
+// Listen initializes all pop3 listeners for the configuration, and stores them for Serve to start them.
+func Listen() {
+	names := slices.Sorted(maps.Keys(mox.Conf.Static.Listeners))
+	for _, name := range names {
+		listener := mox.Conf.Static.Listeners[name]
+
+		var tlsConfig *tls.Config
+		if listener.TLS != nil {
+			tlsConfig = listener.TLS.Config
+		}
+
+		if listener.POP3.Enabled {
+			port := config.Port(listener.POP3.Port, 110)
+			for _, la := range mox.ListenAddresses(listener, "pop3", port) {
+				listen1("pop3", name, la.Network, la.Address, tlsConfig, false, listener.POP3.NoRequireSTARTTLS, listener.NoPlaintextAuth, listener.IPAccess, listener.POP3.IPAccess)
+			}
+		}
+
+		if listener.POP3S.Enabled {
+			port := config.Port(listener.POP3S.Port, 995)
+			for _, la := range mox.ListenAddresses(listener, "pop3s", port) {
+				listen1("pop3s", name, la.Network, la.Address, tlsConfig, true, false, listener.NoPlaintextAuth, listener.IPAccess, listener.POP3S.IPAccess)
+			}
+		}
+	}
+}
+
+var servers []func()
+
+func listen1(protocol, listenerName, network, addr string, tlsConfig *tls.Config, xtls, noRequireSTARTTLS, noPlaintextAuth bool, ipAccess ...*config.IPAccess) {
+	log := mlog.New("pop3server", nil)
+	if os.Getuid() == 0 {
+		log.Print("listening for pop3",
+			slog.String("listener", listenerName),
+			slog.String("addr", addr),
+			slog.String("protocol", protocol))
+	}
+	ln, err := mox.Listen(network, addr)
+	if err != nil {
+		log.Fatalx("pop3: listen for pop3", err, slog.String("protocol", protocol), slog.String("listener", listenerName))
+	}
+	ln = mox.IPAccessListener(ln, protocol, ipAccess...)
+
+	// Each listener gets its own copy of the config, so session keys between different
+	// ports on same listener aren't shared.
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+		mox.StartTLSSessionTicketKeyRefresher(mox.Shutdown, log, tlsConfig, mox.Conf.Static.Listeners[listenerName].TLS.SessionTicketKeyRotation)
+	}
+
+	serve := func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Infox("pop3: accept", err, slog.String("protocol", protocol), slog.String("listener", listenerName))
+				continue
+			}
+
+			metricPOP3Connection.WithLabelValues(protocol).Inc()
+			go serve(listenerName, mox.Cid(), tlsConfig, conn, xtls, noRequireSTARTTLS, noPlaintextAuth)
+		}
+	}
+
+	servers = append(servers, serve)
+}
+
+// Serve starts serving on all listeners, launching a goroutine per listener.
+func Serve() {
+	for _, serve := range servers {
+		go serve()
+	}
+	servers = nil
+}
+
+type state byte
+
+const (
+	stateAuthorization state = iota
+	stateTransaction
+)
+
+// msg is a message in the snapshot of the Inbox taken at login.
+type msg struct {
+	store.Message
+	deleted bool // Marked for removal with DELE.
+}
+
+type conn struct {
+	cid               int64
+	state             state
+	conn              net.Conn
+	tls               bool // Whether TLS has been initialized.
+	baseTLSConfig     *tls.Config
+	noRequireSTARTTLS bool
+	noPlaintextAuth   bool             // Refuse USER/PASS, and AUTH with PLAIN and CRAM-MD5.
+	greeting          *config.Greeting // Of listener, can be nil.
+	remoteIP          net.IP
+	tr                *moxio.TraceReader // Kept to change trace level when reading/writing cmd/auth/data.
+	br                *bufio.Reader
+	tw                *moxio.TraceWriter
+	bw                *bufio.Writer
+	lastlog           time.Time // For printing time since previous log line.
+	log               mlog.Log
+	slow              bool // If set, reads are done with a 1 second sleep, and writes are done 1 byte at a time, to keep spammers busy.
+	cmd               string
+	cmdStart          time.Time
+
+	// Authentication state.
+	authFailed   int    // Number of failed authentication attempts.
+	userArg      string // From USER command, for a following PASS command.
+	loginAttempt *store.LoginAttempt
+
+	// Set after authentication.
+	username    string
+	account     *store.Account
+	mailboxID   int64
+	uidValidity uint32
+	msgs        []msg
+}
+
+var bufpool = moxio.NewBufpool(8, 16*1024)
+
+// Errors are raised with panics, and handled per command. For user errors, the
+// session continues. For i/o errors, the connection is closed.
+var errIO = errors.New("io error")
+
+type userError struct {
+	code string // Optional response code, like AUTH, SYS/TEMP.
+	err  error
+}
+
+func (e userError) Error() string { return e.err.Error() }
+func (e userError) Unwrap() error { return e.err }
+
+type serverError struct{ err error }
+
+func (e serverError) Error() string { return e.err.Error() }
+func (e serverError) Unwrap() error { return e.err }
+
+func xuserErrorf(format string, args ...any) {
+	panic(userError{err: fmt.Errorf(format, args...)})
+}
+
+func xusercodeErrorf(code, format string, args ...any) {
+	panic(userError{code: code, err: fmt.Errorf(format, args...)})
+}
+
+func xserverErrorf(format string, args ...any) {
+	panic(serverError{fmt.Errorf(format, args...)})
+}
+
+func xcheckf(err error, format string, args ...any) {
+	if err != nil {
+		xserverErrorf("%s: %w", fmt.Sprintf(format, args...), err)
+	}
+}
+
+func (c *conn) xbrokenf(format string, args ...any) {
+	panic(fmt.Errorf(format, args...))
+}
+
+// Write makes a connection an io.Writer. It panics for i/o errors. These errors
+// are handled in the connection command loop.
+func (c *conn) Write(buf []byte) (int, error) {
+	chunk := len(buf)
+	if c.slow {
+		chunk = 1
+	}
+
+	var n int
+	for len(buf) > 0 {
+		err := c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		c.log.Check(err, "setting write deadline")
+
+		nn, err := c.conn.Write(buf[:chunk])
+		if err != nil {
+			c.xbrokenf("write: %s (%w)", err, errIO)
+		}
+		n += nn
+		buf = buf[chunk:]
+		if len(buf) > 0 && badClientDelay > 0 {
+			mox.Sleep(mox.Context, badClientDelay)
+		}
+	}
+	return n, nil
+}
+
+func (c *conn) xtraceread(level slog.Level) func() {
+	c.tr.SetTrace(level)
+	return func() {
+		c.tr.SetTrace(mlog.LevelTrace)
+	}
+}
+
+func (c *conn) xtracewrite(level slog.Level) func() {
+	c.xflush()
+	c.tw.SetTrace(level)
+	return func() {
+		c.xflush()
+		c.tw.SetTrace(mlog.LevelTrace)
+	}
+}
+
+func (c *conn) xflush() {
+	if err := c.bw.Flush(); err != nil {
+		c.xbrokenf("flush: %s (%w)", err, errIO)
+	}
+}
+
+func (c *conn) xwritelinef(format string, args ...any) {
+	fmt.Fprintf(c.bw, format+"\r\n", args...)
+	c.xflush()
+}
+
+// xreadline reads a line without the trailing CRLF.
+func (c *conn) xreadline() string {
+	if c.slow && badClientDelay > 0 {
+		mox.Sleep(mox.Context, badClientDelay)
+	}
+
+	// ../rfc/1939 Autologout timer of at least 10 minutes.
+	d := 10 * time.Minute
+	if c.state == stateAuthorization {
+		d = time.Minute
+	}
+	err := c.conn.SetReadDeadline(time.Now().Add(d))
+	c.log.Check(err, "setting read deadline")
+
+	line, err := bufpool.Readline(c.log, c.br)
+	if err != nil {
+		c.xbrokenf("read: %s (%w)", err, errIO)
+	}
+	return line
+}
+
+// serve handles a single POP3 connection on nc.
+//
+// If xtls is set, immediate TLS is started on the connection. If xtls is false
+// and tlsConfig is set, STLS may enable TLS later on.
+//
+// If noRequireSTARTTLS is set, TLS is not required for authentication with
+// plain text passwords. If noPlaintextAuth is set, plain text passwords and
+// CRAM-MD5 are refused altogether.
+//
+// The connection is closed before returning.
+func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, xtls, noRequireSTARTTLS, noPlaintextAuth bool) {
+	var remoteIP net.IP
+	if a, ok := nc.RemoteAddr().(*net.TCPAddr); ok {
+		remoteIP = a.IP
+	} else {
+		// For tests.
+		remoteIP = net.ParseIP("127.0.0.10")
+	}
+
+	c := &conn{
+		cid:               cid,
+		conn:              nc,
+		tls:               xtls,
+		baseTLSConfig:     tlsConfig,
+		noRequireSTARTTLS: noRequireSTARTTLS,
+		noPlaintextAuth:   noPlaintextAuth,
+		greeting:          mox.Conf.Static.Listeners[listenerName].Greeting,
+		remoteIP:          remoteIP,
+		lastlog:           time.Now(),
+		cmd:               "(greeting)",
+		cmdStart:          time.Now(),
+	}
+	var logmutex sync.Mutex
+	c.log = mlog.New("pop3server", nil).WithFunc(func() []slog.Attr {
+		logmutex.Lock()
+		defer logmutex.Unlock()
+		now := time.Now()
+		l := []slog.Attr{
+			slog.Int64("cid", c.cid),
+			slog.Duration("delta", now.Sub(c.lastlog)),
+		}
+		c.lastlog = now
+		if c.username != "" {
+			l = append(l, slog.String("username", c.username))
+		}
+		return l
+	})
+	c.tr = moxio.NewTraceReader(c.log, "C: ", c.conn)
+	c.br = bufio.NewReader(c.tr)
+	c.tw = moxio.NewTraceWriter(c.log, "S: ", c)
+	c.bw = bufio.NewWriter(c.tw)
+
+	c.log.Info("new connection",
+		slog.Any("remote", c.conn.RemoteAddr()),
+		slog.Any("local", c.conn.LocalAddr()),
+		slog.Bool("tls", xtls),
+		slog.String("listener", listenerName))
+
+	defer func() {
+		err := c.conn.Close()
+		if err != nil {
+			c.log.Debugx("closing connection", err)
+		}
+
+		if c.account != nil {
+			err := c.account.Close()
+			c.log.Check(err, "close account")
+			c.account = nil
+		}
+
+		x := recover()
+		if x == nil {
+			c.log.Info("connection closed")
+		} else if err, ok := x.(error); ok && (errors.Is(err, errIO) || mlog.IsClosed(err)) {
+			c.log.Infox("connection closed", err)
+		} else {
+			c.log.Error("unhandled panic", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Pop3server)
+		}
+	}()
+
+	if xtls {
+		c.xtlsHandshake()
+	}
+
+	select {
+	case <-mox.Shutdown.Done():
+		c.xwritelinef("-ERR [SYS/TEMP] mox shutting down")
+		return
+	default:
+	}
+
+	if !limiterConnectionrate.Add(c.remoteIP, time.Now(), 1) {
+		c.xwritelinef("-ERR [SYS/TEMP] connection rate from your ip or network too high, slow down please")
+		return
+	}
+
+	// If remote IP/network resulted in too many authentication failures, refuse to serve.
+	if !mox.LimiterFailedAuth.CanAdd(c.remoteIP, time.Now(), 1) || store.AuthLockedOut(c.remoteIP, "") {
+		metrics.AuthenticationRatelimitedInc("pop3")
+		c.log.Debug("refusing connection due to many auth failures", slog.Any("remoteip", c.remoteIP))
+		c.xwritelinef("-ERR [SYS/TEMP] too many auth failures")
+		return
+	}
+
+	if !limiterConnections.Add(c.remoteIP, time.Now(), 1) {
+		c.log.Debug("refusing connection due to many open connections", slog.Any("remoteip", c.remoteIP))
+		c.xwritelinef("-ERR [SYS/TEMP] too many open connections from your ip or network")
+		return
+	}
+	defer limiterConnections.Add(c.remoteIP, time.Now(), -1)
+
+	// We register and unregister the original connection, in case c.conn is replaced
+	// with a TLS connection later on.
+	mox.Connections.Register(nc, "pop3", listenerName)
+	defer mox.Connections.Unregister(nc)
+
+	// ../rfc/1939
+	greetText := "mox pop3"
+	if g := c.greeting; g != nil {
+		if g.POP3 != "" {
+			greetText = g.POP3
+		} else if g.HideSoftware {
+			greetText = "pop3 ready"
+		}
+	}
+	c.xwritelinef("+OK %s", greetText)
+
+	for {
+		if c.command() {
+			return
+		}
+	}
+}
+
+func (c *conn) xtlsHandshake() {
+	tlsConn := tls.Server(c.conn, c.baseTLSConfig)
+	c.conn = tlsConn
+	c.tr = moxio.NewTraceReader(c.log, "C: ", c.conn)
+	c.br = bufio.NewReader(c.tr)
+
+	cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
+	ctx, cancel := context.WithTimeout(cidctx, time.Minute)
+	defer cancel()
+	c.log.Debug("starting tls server handshake")
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		c.xbrokenf("tls handshake: %s (%w)", err, errIO)
+	}
+	cancel()
+
+	cs := tlsConn.ConnectionState()
+	version, ciphersuite := moxio.TLSInfo(cs)
+	c.log.Debug("tls handshake completed",
+		slog.String("version", version),
+		slog.String("ciphersuite", ciphersuite),
+		slog.String("sni", cs.ServerName),
+		slog.Bool("resumed", cs.DidResume))
+}
+
+var commands = map[string]func(c *conn, args []string){
+	// Authorization state.
+	"capa": (*conn).cmdCapa,
+	"stls": (*conn).cmdStls,
+	"user": (*conn).cmdUser,
+	"pass": (*conn).cmdPass,
+	"auth": (*conn).cmdAuth,
+	"quit": (*conn).cmdQuit,
+
+	// Transaction state.
+	"stat": (*conn).cmdStat,
+	"list": (*conn).cmdList,
+	"uidl": (*conn).cmdUidl,
+	"retr": (*conn).cmdRetr,
+	"top":  (*conn).cmdTop,
+	"dele": (*conn).cmdDele,
+	"noop": (*conn).cmdNoop,
+	"rset": (*conn).cmdRset,
+}
+
+// Commands allowed in the authorization state. CAPA and QUIT are allowed in both
+// states.
+var authorizationCommands = map[string]bool{"capa": true, "stls": true, "user": true, "pass": true, "auth": true, "quit": true}
+var transactionCommands = map[string]bool{"capa": true, "quit": true, "stat": true, "list": true, "uidl": true, "retr": true, "top": true, "dele": true, "noop": true, "rset": true}
+
+// errQuit is raised by QUIT to close the connection after the response.
+var errQuit = errors.New("quit")
+
+// command reads and executes a single command. It returns true if the connection
+// should be closed.
+func (c *conn) command() (quit bool) {
+	var cmdl string
+	defer func() {
+		x := recover()
+		var result string
+		defer func() {
+			metricPOP3Commands.WithLabelValues(cmdl, result).Observe(float64(time.Since(c.cmdStart)) / float64(time.Second))
+		}()
+
+		logFields := []slog.Attr{
+			slog.String("cmd", c.cmd),
+			slog.Duration("duration", time.Since(c.cmdStart)),
+		}
+		c.cmd = ""
+
+		if x == nil {
+			result = "ok"
+			c.log.Debug("pop3 command done", logFields...)
+			return
+		}
+		err, ok := x.(error)
+		if !ok {
+			result = "panic"
+			c.log.Error("pop3 command panic", append([]slog.Attr{slog.Any("panic", x)}, logFields...)...)
+			panic(x)
+		}
+
+		var uerr userError
+		var serr serverError
+		if errors.Is(err, errQuit) {
+			result = "ok"
+			quit = true
+			c.log.Debug("pop3 command done", logFields...)
+		} else if errors.Is(err, errIO) {
+			result = "ioerror"
+			c.log.Infox("pop3 command ioerror", err, logFields...)
+			panic(err)
+		} else if errors.As(err, &uerr) {
+			result = "usererror"
+			c.log.Debugx("pop3 command user error", err, logFields...)
+			if uerr.code != "" {
+				c.xwritelinef("-ERR [%s] %s", uerr.code, uerr.err)
+			} else {
+				c.xwritelinef("-ERR %s", uerr.err)
+			}
+		} else if errors.As(err, &serr) {
+			result = "servererror"
+			c.log.Errorx("pop3 command server error", err, logFields...)
+			c.xwritelinef("-ERR [SYS/TEMP] processing command: %v", serr.err)
+		} else {
+			// Other type of panic, we pass it on, aborting the connection.
+			result = "panic"
+			c.log.Errorx("pop3 command panic", err, logFields...)
+			panic(err)
+		}
+	}()
+
+	// todo: log the line of PASS commands with traceauth. we only know the command after having read and logged it.
+	line := c.xreadline()
+	c.cmdStart = time.Now()
+
+	// ../rfc/1939
+	t := strings.Split(line, " ")
+	cmdl = strings.ToLower(t[0])
+	args := t[1:]
+	c.cmd = cmdl
+	if cmdl == "pass" {
+		c.cmd = "pass ..."
+	}
+
+	fn, ok := commands[cmdl]
+	if !ok {
+		cmdl = "(unknown)"
+		xuserErrorf("unknown command")
+	}
+	if c.state == stateAuthorization && !authorizationCommands[cmdl] || c.state == stateTransaction && !transactionCommands[cmdl] {
+		xuserErrorf("command not allowed in this state")
+	}
+	fn(c, args)
+	return false
+}
+
+func xnoargs(args []string) {
+	if len(args) != 0 {
+		xuserErrorf("no parameters expected")
+	}
+}
+
+// xmsg returns the message for the message number in arg, starting at 1. Messages
+// marked as deleted result in an error.
+func (c *conn) xmsg(arg string) *msg {
+	n, err := strconv.ParseUint(arg, 10, 32)
+	if err != nil || n == 0 || n > uint64(len(c.msgs)) {
+		xuserErrorf("no such message")
+	}
+	m := &c.msgs[n-1]
+	if m.deleted {
+		// ../rfc/1939
+		xuserErrorf("message %d already deleted", n)
+	}
+	return m
+}
+
+func (c *conn) uniqueID(m msg) string {
+	// ../rfc/1939
+	return fmt.Sprintf("%d.%d", c.uidValidity, m.UID)
+}
+
+// plaintextAllowed returns whether plain text passwords may be sent.
+func (c *conn) plaintextAllowed() bool {
+	return (c.tls || c.noRequireSTARTTLS) && !c.noPlaintextAuth
+}
+
+func (c *conn) saslMechanisms() []string {
+	var l []string
+	if c.plaintextAllowed() {
+		l = append(l, "PLAIN")
+	}
+	if !c.noPlaintextAuth {
+		l = append(l, "CRAM-MD5")
+	}
+	l = append(l, "SCRAM-SHA-1", "SCRAM-SHA-256")
+	if c.tls {
+		l = append(l, "SCRAM-SHA-1-PLUS", "SCRAM-SHA-256-PLUS")
+	}
+	return l
+}
+
+// Capa lists the capabilities of the server.
+func (c *conn) cmdCapa(args []string) {
+	// ../rfc/2449
+	xnoargs(args)
+
+	caps := []string{"TOP", "UIDL", "RESP-CODES", "AUTH-RESP-CODE", "PIPELINING"}
+	if c.state == stateAuthorization {
+		if c.plaintextAllowed() {
+			caps = append(caps, "USER")
+		}
+		caps = append(caps, "SASL "+strings.Join(c.saslMechanisms(), " "))
+		if !c.tls && c.baseTLSConfig != nil {
+			caps = append(caps, "STLS")
+		}
+	}
+	if c.greeting == nil || !c.greeting.HideSoftware {
+		caps = append(caps, "IMPLEMENTATION mox")
+	}
+
+	fmt.Fprintf(c.bw, "+OK capability list follows\r\n")
+	for _, s := range caps {
+		fmt.Fprintf(c.bw, "%s\r\n", s)
+	}
+	fmt.Fprintf(c.bw, ".\r\n")
+	c.xflush()
+}
+
+// Stls starts TLS on the connection.
+func (c *conn) cmdStls(args []string) {
+	// ../rfc/2595
+	xnoargs(args)
+
+	if c.tls {
+		xuserErrorf("tls already active")
+	}
+	if c.baseTLSConfig == nil {
+		xuserErrorf("starttls not available")
+	}
+
+	c.xwritelinef("+OK begin tls negotiation")
+
+	// We don't want to do TLS on top of c.br. Some of the TLS messages may already be
+	// in the buffer and will be lost. So we read whatever is in the buffer and let
+	// the TLS handshake read from it first, and then continue on the connection.
+	conn := c.conn
+	if n := c.br.Buffered(); n > 0 {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(c.br, buf)
+		xcheckf(err, "reading buffered data for tls handshake")
+		conn = &prefixConn{buf, conn}
+	}
+	c.conn = conn
+	c.xtlsHandshake()
+	c.tls = true
+}
+
+// prefixConn is a net.Conn with a buffer from which the first reads are satisfied.
+type prefixConn struct {
+	prefix []byte
+	net.Conn
+}
+
+func (c *prefixConn) Read(buf []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := min(len(buf), len(c.prefix))
+		copy(buf[:n], c.prefix[:n])
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(buf)
+}
+
+// User sets the username for a following PASS command.
+func (c *conn) cmdUser(args []string) {
+	// ../rfc/1939
+	if len(args) != 1 {
+		xuserErrorf("expected a single username")
+	}
+	if c.noPlaintextAuth {
+		xusercodeErrorf("AUTH", "plaintext authentication is disabled")
+	} else if !c.plaintextAllowed() {
+		// ../rfc/2595
+		xusercodeErrorf("AUTH", "tls required for login")
+	}
+	c.userArg = norm.NFC.String(args[0])
+	c.xwritelinef("+OK send password")
+}
+
+// Pass authenticates with the username from the previous USER command and a
+// plain text password.
+func (c *conn) cmdPass(args []string) {
+	// ../rfc/1939
+	username := c.userArg
+	c.userArg = ""
+	if username == "" {
+		xuserErrorf("missing preceding USER command")
+	}
+	// The password may contain spaces. ../rfc/1939
+	password := strings.Join(args, " ")
+	if c.noPlaintextAuth {
+		xusercodeErrorf("AUTH", "plaintext authentication is disabled")
+	} else if !c.plaintextAllowed() {
+		xusercodeErrorf("AUTH", "tls required for login")
+	}
+
+	c.newLoginAttempt("userpass")
+	defer c.finishLoginAttempt(false)
+	c.loginAttempt.LoginAddress = username
+
+	c.authDelay()
+	c.authFailed++ // Compensated on success.
+
+	account, accName, err := store.OpenEmailAuth(c.log, username, password, true)
+	c.loginAttempt.AccountName = accName
+	if err != nil {
+		if errors.Is(err, store.ErrUnknownCredentials) {
+			c.loginAttempt.Result = store.AuthBadCredentials
+			c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+			xusercodeErrorf("AUTH", "bad credentials")
+		} else if errors.Is(err, store.ErrLoginDisabled) {
+			c.loginAttempt.Result = store.AuthLoginDisabled
+			c.log.Info("account login disabled", slog.String("username", username))
+			xusercodeErrorf("AUTH", "%s", err)
+		}
+		xserverErrorf("login: %w", err)
+	}
+	c.xlogin(account, username)
+}
+
+// Auth authenticates with a SASL mechanism.
+func (c *conn) cmdAuth(args []string) {
+	// ../rfc/5034
+	if len(args) == 0 || len(args) > 2 {
+		xuserErrorf("expected mechanism and optional initial response")
+	}
+
+	// If authentication fails due to missing derived secrets, we don't hold it against
+	// the connection.
+	var missingDerivedSecrets bool
+
+	c.newLoginAttempt("")
+	defer func() {
+		c.finishLoginAttempt(missingDerivedSecrets)
+		if missingDerivedSecrets {
+			c.authFailed--
+		}
+	}()
+
+	c.authDelay()
+	c.authFailed++ // Compensated on success.
+
+	xreadInitial := func() []byte {
+		var line string
+		if len(args) == 2 {
+			line = args[1]
+			if line == "=" {
+				// ../rfc/5034
+				line = ""
+			}
+		} else {
+			c.xwritelinef("+ ")
+			line = c.xreadline()
+		}
+		return c.xdecodeSASL(line)
+	}
+
+	xreadContinuation := func() []byte {
+		return c.xdecodeSASL(c.xreadline())
+	}
+
+	var account *store.Account
+	var username string
+	defer func() {
+		if account != nil {
+			err := account.Close()
+			c.log.Check(err, "close account")
+		}
+	}()
+
+	mech := strings.ToUpper(args[0])
+	if c.noPlaintextAuth && (mech == "PLAIN" || mech == "CRAM-MD5") {
+		c.loginAttempt.AuthMech = strings.ToLower(mech)
+		c.loginAttempt.Result = store.AuthBadProtocol
+		xusercodeErrorf("AUTH", "mechanism not allowed, plaintext authentication is disabled")
+	}
+	switch mech {
+	case "PLAIN":
+		c.loginAttempt.AuthMech = "plain"
+
+		if !c.plaintextAllowed() {
+			xusercodeErrorf("AUTH", "tls required for login")
+		}
+
+		// Plain text passwords, mark as traceauth.
+		defer c.xtraceread(mlog.LevelTraceauth)()
+		buf := xreadInitial()
+		c.xtraceread(mlog.LevelTrace) // Restore.
+		plain := bytes.Split(buf, []byte{0})
+		if len(plain) != 3 {
+			xuserErrorf("bad plain auth data, expected 3 nul-separated tokens, got %d tokens", len(plain))
+		}
+		authz := norm.NFC.String(string(plain[0]))
+		username = norm.NFC.String(string(plain[1]))
+		password := string(plain[2])
+		c.loginAttempt.LoginAddress = username
+
+		if authz != "" && authz != username {
+			xusercodeErrorf("AUTH", "cannot assume role")
+		}
+
+		var err error
+		account, c.loginAttempt.AccountName, err = store.OpenEmailAuth(c.log, username, password, false)
+		if err != nil {
+			if errors.Is(err, store.ErrUnknownCredentials) {
+				c.loginAttempt.Result = store.AuthBadCredentials
+				c.log.Info("authentication failed", slog.String("username", username))
+				xusercodeErrorf("AUTH", "bad credentials")
+			}
+			xserverErrorf("login: %w", err)
+		}
+
+	case "CRAM-MD5":
+		c.loginAttempt.AuthMech = "cram-md5"
+
+		if len(args) != 1 {
+			xuserErrorf("unexpected initial response")
+		}
+
+		// ../rfc/2195:82
+		chal := fmt.Sprintf("<%d.%d@%s>", uint64(mox.CryptoRandInt()), time.Now().UnixNano(), mox.Conf.Static.HostnameDomain.ASCII)
+		c.xwritelinef("+ %s", base64.StdEncoding.EncodeToString([]byte(chal)))
+
+		resp := xreadContinuation()
+		t := strings.Split(string(resp), " ")
+		if len(t) != 2 || len(t[1]) != 2*md5.Size {
+			xuserErrorf("malformed cram-md5 response")
+		}
+		username = norm.NFC.String(t[0])
+		c.loginAttempt.LoginAddress = username
+		c.log.Debug("cram-md5 auth", slog.String("address", username))
+		var err error
+		account, c.loginAttempt.AccountName, _, err = store.OpenEmail(c.log, username, false)
+		if err != nil {
+			if errors.Is(err, store.ErrUnknownCredentials) {
+				c.loginAttempt.Result = store.AuthBadCredentials
+				c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xusercodeErrorf("AUTH", "bad credentials")
+			}
+			xserverErrorf("looking up address: %v", err)
+		}
+		password := c.xpassword(account, username)
+		ipadhash := password.CRAMMD5.Ipad
+		opadhash := password.CRAMMD5.Opad
+		if ipadhash == nil || opadhash == nil {
+			c.log.Info("cram-md5 auth attempt without derived secrets set, save password again to store secrets", slog.String("username", username))
+			missingDerivedSecrets = true
+			xusercodeErrorf("AUTH", "bad credentials")
+		}
+
+		// ../rfc/2195:138 ../rfc/2104:142
+		ipadhash.Write([]byte(chal))
+		opadhash.Write(ipadhash.Sum(nil))
+		digest := fmt.Sprintf("%x", opadhash.Sum(nil))
+		if digest != t[1] {
+			c.loginAttempt.Result = store.AuthBadCredentials
+			c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+			xusercodeErrorf("AUTH", "bad credentials")
+		}
+
+	case "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1":
+		// No plaintext credentials, we can log these normally.
+
+		c.loginAttempt.AuthMech = strings.ToLower(mech)
+		var h func() hash.Hash
+		if strings.HasPrefix(mech, "SCRAM-SHA-1") {
+			h = sha1.New
+		} else {
+			h = sha256.New
+		}
+
+		var cs *tls.ConnectionState
+		requireChannelBinding := strings.HasSuffix(mech, "-PLUS")
+		if requireChannelBinding && !c.tls {
+			xuserErrorf("cannot use plus variant with tls channel binding without tls")
+		}
+		if c.tls {
+			xcs := c.conn.(*tls.Conn).ConnectionState()
+			cs = &xcs
+		}
+		c0 := xreadInitial()
+		ss, err := scram.NewServer(h, c0, cs, requireChannelBinding)
+		if err != nil {
+			c.log.Infox("scram protocol error", err, slog.Any("remote", c.remoteIP))
+			xuserErrorf("scram protocol error: %s", err)
+		}
+		username = ss.Authentication
+		c.loginAttempt.LoginAddress = username
+		c.log.Debug("scram auth", slog.String("authentication", username))
+		account, c.loginAttempt.AccountName, _, err = store.OpenEmail(c.log, username, false)
+		if err != nil {
+			xuserErrorf("scram not possible")
+		}
+		if ss.Authorization != "" && ss.Authorization != username {
+			xuserErrorf("authentication with authorization for different user not supported")
+		}
+		password := c.xpassword(account, username)
+		xscram := password.SCRAMSHA256
+		if strings.HasPrefix(mech, "SCRAM-SHA-1") {
+			xscram = password.SCRAMSHA1
+		}
+		if len(xscram.Salt) == 0 || xscram.Iterations == 0 || len(xscram.SaltedPassword) == 0 {
+			missingDerivedSecrets = true
+			c.log.Info("scram auth attempt without derived secrets set, save password again to store secrets", slog.String("username", username))
+			xuserErrorf("scram not possible")
+		}
+		s1, err := ss.ServerFirst(xscram.Iterations, xscram.Salt)
+		xcheckf(err, "scram first server step")
+		c.xwritelinef("+ %s", base64.StdEncoding.EncodeToString([]byte(s1)))
+		c2 := xreadContinuation()
+		s3, err := ss.Finish(c2, xscram.SaltedPassword)
+		if len(s3) > 0 {
+			c.xwritelinef("+ %s", base64.StdEncoding.EncodeToString([]byte(s3)))
+		}
+		if err != nil {
+			c.xreadline() // Should be "*" for cancellation.
+			if errors.Is(err, scram.ErrInvalidProof) {
+				c.loginAttempt.Result = store.AuthBadCredentials
+				c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xusercodeErrorf("AUTH", "bad credentials")
+			} else if errors.Is(err, scram.ErrChannelBindingsDontMatch) {
+				c.loginAttempt.Result = store.AuthBadChannelBinding
+				c.log.Warn("bad channel binding during authentication, potential mitm", slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xusercodeErrorf("AUTH", "channel bindings do not match, potential mitm")
+			} else if errors.Is(err, scram.ErrInvalidEncoding) {
+				c.loginAttempt.Result = store.AuthBadProtocol
+				c.log.Infox("bad scram protocol message", err, slog.String("username", username), slog.Any("remote", c.remoteIP))
+				xuserErrorf("bad scram protocol message: %s", err)
+			}
+			xuserErrorf("server final: %w", err)
+		}
+
+		// Client must still respond, but there is nothing to say. ../rfc/5034
+		xreadContinuation()
+
+	default:
+		c.loginAttempt.AuthMech = "(unrecognized)"
+		xuserErrorf("mechanism not supported")
+	}
+
+	if accConf, ok := account.Conf(); !ok {
+		xserverErrorf("cannot get account config")
+	} else if store.AuthLockedOut(nil, account.Name) {
+		c.loginAttempt.Result = store.AuthBadCredentials
+		c.log.Info("account locked out after failed authentication attempts", slog.String("username", username))
+		xusercodeErrorf("AUTH", "bad credentials")
+	} else if accConf.LoginDisabled != "" {
+		c.loginAttempt.Result = store.AuthLoginDisabled
+		c.log.Info("account login disabled", slog.String("username", username))
+		xusercodeErrorf("AUTH", "%s: %s", store.ErrLoginDisabled, accConf.LoginDisabled)
+	}
+
+	c.xlogin(account, username)
+	account = nil // Prevent cleanup.
+}
+
+// xdecodeSASL decodes a base64 SASL response line, handling aborts by the client.
+func (c *conn) xdecodeSASL(line string) []byte {
+	if line == "*" {
+		// ../rfc/5034
+		c.loginAttempt.Result = store.AuthAborted
+		xuserErrorf("authentication aborted by client")
+	}
+	buf, err := base64.StdEncoding.DecodeString(line)
+	if err != nil {
+		c.loginAttempt.Result = store.AuthBadProtocol
+		xuserErrorf("parsing base64: %v", err)
+	}
+	return buf
+}
+
+// xpassword returns the stored password with derived secrets for account.
+func (c *conn) xpassword(account *store.Account, username string) (password store.Password) {
+	account.WithRLock(func() {
+		err := account.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+			var err error
+			password, err = bstore.QueryTx[store.Password](tx).Get()
+			return err
+		})
+		if err == bstore.ErrAbsent {
+			c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+			xusercodeErrorf("AUTH", "bad credentials")
+		}
+		xcheckf(err, "fetching credentials")
+	})
+	return
+}
+
+// authDelay slows down authentication after many failed attempts.
+func (c *conn) authDelay() {
+	if c.authFailed > 3 && authFailDelay > 0 {
+		mox.Sleep(mox.Context, time.Duration(c.authFailed-3)*authFailDelay)
+	}
+	// On the 3rd failed authentication, start responding slowly. Successful auth will
+	// cause fast responses again.
+	if c.authFailed >= 3 {
+		c.setSlow(true)
+	}
+}
+
+func (c *conn) setSlow(on bool) {
+	if on && !c.slow {
+		c.log.Debug("connection changed to slow")
+	} else if !on && c.slow {
+		c.log.Debug("connection restored to regular pace")
+	}
+	c.slow = on
+}
+
+// newLoginAttempt initializes a c.loginAttempt, for adding to the store after
+// filling in the results and other details.
+func (c *conn) newLoginAttempt(authMech string) {
+	var state *tls.ConnectionState
+	if tc, ok := c.conn.(*tls.Conn); ok {
+		v := tc.ConnectionState()
+		state = &v
+	}
+
+	localAddr := c.conn.LocalAddr().String()
+	localIP, _, _ := net.SplitHostPort(localAddr)
+	if localIP == "" {
+		localIP = localAddr
+	}
+
+	c.loginAttempt = &store.LoginAttempt{
+		RemoteIP: c.remoteIP.String(),
+		LocalIP:  localIP,
+		TLS:      store.LoginAttemptTLS(state),
+		Protocol: "pop3",
+		AuthMech: authMech,
+		Result:   store.AuthError, // Replaced by caller.
+	}
+}
+
+// finishLoginAttempt updates the failed authentication rate limiter and stores the
+// login attempt.
+func (c *conn) finishLoginAttempt(missingDerivedSecrets bool) {
+	if c.loginAttempt.Result == store.AuthSuccess {
+		mox.LimiterFailedAuth.Reset(c.remoteIP, time.Now())
+	} else if !missingDerivedSecrets {
+		mox.LimiterFailedAuth.Add(c.remoteIP, time.Now(), 1)
+	}
+	store.LoginAttemptAdd(context.Background(), c.log, *c.loginAttempt)
+	c.loginAttempt = nil
+}
+
+// xlogin finishes a successful authentication, taking a snapshot of the Inbox. On
+// errors, the account is closed.
+func (c *conn) xlogin(account *store.Account, username string) {
+	var ok bool
+	defer func() {
+		if !ok {
+			err := account.Close()
+			c.log.Check(err, "close account")
+		}
+	}()
+
+	var mb store.Mailbox
+	var msgs []msg
+	account.WithRLock(func() {
+		err := account.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+			var err error
+			mb, err = bstore.QueryTx[store.Mailbox](tx).FilterNonzero(store.Mailbox{Name: "Inbox"}).FilterEqual("Expunged", false).Get()
+			if err != nil {
+				return fmt.Errorf("get inbox: %w", err)
+			}
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterNonzero(store.Message{MailboxID: mb.ID})
+			q.FilterEqual("Expunged", false)
+			q.SortAsc("UID")
+			return q.ForEach(func(m store.Message) error {
+				msgs = append(msgs, msg{Message: m})
+				return nil
+			})
+		})
+		xcheckf(err, "listing messages in inbox")
+	})
+
+	ok = true
+	c.account = account
+	c.username = username
+	c.mailboxID = mb.ID
+	c.uidValidity = mb.UIDValidity
+	c.msgs = msgs
+	c.state = stateTransaction
+	c.loginAttempt.AccountName = account.Name
+	c.loginAttempt.LoginAddress = username
+	c.loginAttempt.Result = store.AuthSuccess
+	c.authFailed = 0
+	c.setSlow(false)
+	c.xwritelinef("+OK mox pop3 welcomes %s, %d messages", username, len(msgs))
+}
+
+// Quit ends the session. In the transaction state, messages marked for removal
+// are removed.
+func (c *conn) cmdQuit(args []string) {
+	// ../rfc/1939
+	xnoargs(args)
+
+	if c.state == stateTransaction {
+		n := c.xremoveDeleted()
+		c.xwritelinef("+OK mox pop3 signing off, %d messages removed", n)
+	} else {
+		c.xwritelinef("+OK mox pop3 signing off")
+	}
+	panic(errQuit)
+}
+
+// xremoveDeleted removes the messages marked for removal, returning the number of
+// removed messages. Messages removed by another session in the mean time are
+// skipped.
+func (c *conn) xremoveDeleted() (removed int) {
+	var ids []int64
+	for _, m := range c.msgs {
+		if m.deleted {
+			ids = append(ids, m.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return 0
+	}
+
+	c.account.WithWLock(func() {
+		var changes []store.Change
+
+		err := c.account.DB.Write(context.TODO(), func(tx *bstore.Tx) error {
+			mb, err := store.MailboxID(tx, c.mailboxID)
+			if err == bstore.ErrAbsent || err == store.ErrMailboxExpunged {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("get mailbox: %w", err)
+			}
+
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterNonzero(store.Message{MailboxID: c.mailboxID})
+			q.FilterIDs(ids)
+			q.FilterEqual("Expunged", false)
+			l, err := q.List()
+			if err != nil {
+				return fmt.Errorf("listing messages to remove: %w", err)
+			}
+			if len(l) == 0 {
+				return nil
+			}
+
+			modseq, err := c.account.NextModSeq(tx)
+			if err != nil {
+				return fmt.Errorf("assigning next modseq: %w", err)
+			}
+			mb.ModSeq = modseq
+
+			chremuids, chmbcounts, err := c.account.MessageRemove(c.log, tx, modseq, &mb, store.RemoveOpts{}, l...)
+			if err != nil {
+				return fmt.Errorf("removing messages: %w", err)
+			}
+			changes = append(changes, chremuids, chmbcounts)
+
+			if err := tx.Update(&mb); err != nil {
+				return fmt.Errorf("update mailbox: %w", err)
+			}
+			removed = len(l)
+			return nil
+		})
+		// ../rfc/1939
+		xcheckf(err, "removing messages")
+
+		store.BroadcastChanges(c.account, changes)
+	})
+	return removed
+}
+
+// Stat returns the number of messages and their total size.
+func (c *conn) cmdStat(args []string) {
+	// ../rfc/1939
+	xnoargs(args)
+
+	var n int
+	var size int64
+	for _, m := range c.msgs {
+		if !m.deleted {
+			n++
+			size += m.Size
+		}
+	}
+	c.xwritelinef("+OK %d %d", n, size)
+}
+
+// List returns the size of a single or all messages.
+func (c *conn) cmdList(args []string) {
+	// ../rfc/1939
+	c.xlisting(args, "scan listing", func(m msg) string { return fmt.Sprintf("%d", m.Size) })
+}
+
+// Uidl returns the unique ID of a single or all messages.
+func (c *conn) cmdUidl(args []string) {
+	// ../rfc/1939
+	c.xlisting(args, "unique-id listing", func(m msg) string { return c.uniqueID(m) })
+}
+
+func (c *conn) xlisting(args []string, what string, value func(m msg) string) {
+	if len(args) > 1 {
+		xuserErrorf("expected optional message number")
+	}
+	if len(args) == 1 {
+		m := c.xmsg(args[0])
+		c.xwritelinef("+OK %s %s", args[0], value(*m))
+		return
+	}
+
+	fmt.Fprintf(c.bw, "+OK %s follows\r\n", what)
+	for i, m := range c.msgs {
+		if !m.deleted {
+			fmt.Fprintf(c.bw, "%d %s\r\n", i+1, value(m))
+		}
+	}
+	fmt.Fprintf(c.bw, ".\r\n")
+	c.xflush()
+}
+
+// Retr returns a message.
+func (c *conn) cmdRetr(args []string) {
+	// ../rfc/1939
+	if len(args) != 1 {
+		xuserErrorf("expected message number")
+	}
+	m := c.xmsg(args[0])
+	c.xwriteMessage(*m, -1, fmt.Sprintf("%d octets", m.Size))
+}
+
+// Top returns the header and the first lines of the body of a message.
+func (c *conn) cmdTop(args []string) {
+	// ../rfc/1939
+	if len(args) != 2 {
+		xuserErrorf("expected message number and number of lines")
+	}
+	m := c.xmsg(args[0])
+	lines, err := strconv.ParseUint(args[1], 10, 31)
+	if err != nil {
+		xuserErrorf("invalid number of lines")
+	}
+	c.xwriteMessage(*m, int(lines), "top of message follows")
+}
+
+// xwriteMessage writes the message as multi-line response, with dot-stuffing. If
+// bodyLines is >= 0, only the header and the first bodyLines lines of the body are
+// written.
+func (c *conn) xwriteMessage(m msg, bodyLines int, okText string) {
+	// Check message is still present before sending a positive response.
+	if _, err := os.Stat(c.account.MessagePath(m.ID)); err != nil {
+		c.log.Debugx("stat message file", err, slog.Int64("msgid", m.ID))
+		xusercodeErrorf("SYS/TEMP", "message no longer available")
+	}
+
+	mr := c.account.MessageReader(m.Message)
+	defer func() {
+		err := mr.Close()
+		c.log.Check(err, "closing message reader")
+	}()
+
+	c.xwritelinef("+OK %s", okText)
+	defer c.xtracewrite(mlog.LevelTracedata)()
+
+	// ../rfc/1939
+	br := bufio.NewReader(mr)
+	inHeader := true
+	for inHeader || bodyLines != 0 {
+		line, err := br.ReadSlice('\n')
+		if len(line) == 0 && err == io.EOF {
+			break
+		} else if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+			// We already sent a positive response, we can't signal the error anymore.
+			c.xbrokenf("reading message: %s (%w)", err, errIO)
+		}
+		if line[0] == '.' {
+			c.bw.WriteByte('.')
+		}
+		c.bw.Write(line)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// Continue with the remainder of this long line, it must not be dot-stuffed.
+			for errors.Is(err, bufio.ErrBufferFull) {
+				line, err = br.ReadSlice('\n')
+				c.bw.Write(line)
+			}
+		}
+		if !bytes.HasSuffix(line, []byte("\n")) {
+			c.bw.WriteString("\r\n")
+		}
+		if inHeader {
+			inHeader = string(line) != "\r\n" && string(line) != "\n"
+		} else if bodyLines > 0 {
+			bodyLines--
+		}
+	}
+	c.bw.WriteString(".\r\n")
+}
+
+// Dele marks a message for removal at the end of the session.
+func (c *conn) cmdDele(args []string) {
+	// ../rfc/1939
+	if len(args) != 1 {
+		xuserErrorf("expected message number")
+	}
+	m := c.xmsg(args[0])
+	if accConf, _ := c.account.Conf(); accConf.ReadOnly {
+		xuserErrorf("account is read-only")
+	}
+	m.deleted = true
+	c.xwritelinef("+OK message %s marked for removal", args[0])
+}
+
+// Noop does nothing.
+func (c *conn) cmdNoop(args []string) {
+	// ../rfc/1939
+	xnoargs(args)
+	c.xwritelinef("+OK")
+}
+
+// Rset unmarks messages marked for removal.
+func (c *conn) cmdRset(args []string) {
+	// ../rfc/1939
+	xnoargs(args)
+	for i := range c.msgs {
+		c.msgs[i].deleted = false
+	}
+	c.xwritelinef("+OK")
+}
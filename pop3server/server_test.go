@@ -0,0 +1,375 @@
+package pop3server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/sasl"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+var pkglog = mlog.New("pop3server", nil)
+
+const password0 = "te\u0301st \u00a0\u2002\u200a" // NFD and various unicode spaces.
+const password1 = "tést    "                      // PRECIS normalized, with NFC.
+
+func init() {
+	badClientDelay = 0
+	authFailDelay = 0
+}
+
+func TestMain(m *testing.M) {
+	m.Run()
+	if metrics.Panics.Load() > 0 {
+		fmt.Println("unhandled panics encountered")
+		os.Exit(2)
+	}
+}
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(exp) {
+		t.Fatalf("got %v, expected %v", got, exp)
+	}
+}
+
+const msg0 = "From: <mjl@mox.example>\r\nSubject: test\r\n\r\nline 1\r\n.line 2\r\nline 3\r\n"
+const msg1 = "From: <mjl@mox.example>\r\nSubject: other\r\n\r\nbody\r\n"
+
+type testconn struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+	done chan struct{}
+}
+
+// setup loads the config, initializes a fresh store and delivers two messages to
+// the Inbox of account mjl.
+func setup(t *testing.T) func() {
+	limitersInit() // Reset rate limiters.
+
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/pop3/mox.conf")
+	mox.ConfigDynamicPath = filepath.Join(filepath.Dir(mox.ConfigStaticPath), "domains.conf")
+	mox.MustLoadConfig(true, false)
+	store.Close() // May not be open, we ignore error.
+	os.RemoveAll("../testdata/pop3/data")
+	err := store.Init(ctxbg)
+	tcheck(t, err, "store init")
+	switchStop := store.Switchboard()
+
+	acc, err := store.OpenAccount(pkglog, "mjl", false)
+	tcheck(t, err, "open account")
+	err = acc.SetPassword(pkglog, password0)
+	tcheck(t, err, "set password")
+
+	for _, s := range []string{msg0, msg1} {
+		acc.WithWLock(func() {
+			msgFile, err := store.CreateMessageTemp(pkglog, "pop3-test")
+			tcheck(t, err, "create message temp")
+			defer os.Remove(msgFile.Name())
+			defer msgFile.Close()
+			_, err = msgFile.Write([]byte(s))
+			tcheck(t, err, "write message temp")
+			m := store.Message{Size: int64(len(s))}
+			err = acc.DeliverMailbox(pkglog, "Inbox", &m, msgFile)
+			tcheck(t, err, "deliver message")
+		})
+	}
+
+	return func() {
+		err := acc.Close()
+		pkglog.Check(err, "close account")
+		acc.WaitClosed()
+		switchStop()
+		err = store.Close()
+		tcheck(t, err, "store close")
+	}
+}
+
+func startConn(t *testing.T, tlsConfig *tls.Config, xtls, noRequireSTARTTLS, noPlaintextAuth bool) *testconn {
+	serverConn, clientConn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve("test", mox.Cid(), tlsConfig, serverConn, xtls, noRequireSTARTTLS, noPlaintextAuth)
+	}()
+	if xtls {
+		clientConn = tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	}
+	tc := &testconn{t: t, conn: clientConn, br: bufio.NewReader(clientConn), done: done}
+	tc.readok()
+	return tc
+}
+
+func (tc *testconn) close() {
+	tc.conn.Close()
+	<-tc.done
+}
+
+func (tc *testconn) readline() string {
+	tc.t.Helper()
+	err := tc.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	tcheck(tc.t, err, "set read deadline")
+	line, err := tc.br.ReadString('\n')
+	tcheck(tc.t, err, "read line")
+	if !strings.HasSuffix(line, "\r\n") {
+		tc.t.Fatalf("line %q does not end with crlf", line)
+	}
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+func (tc *testconn) writelinef(format string, args ...any) {
+	tc.t.Helper()
+	_, err := fmt.Fprintf(tc.conn, format+"\r\n", args...)
+	tcheck(tc.t, err, "write line")
+}
+
+func (tc *testconn) readok() string {
+	tc.t.Helper()
+	line := tc.readline()
+	if !strings.HasPrefix(line, "+OK") {
+		tc.t.Fatalf("got %q, expected +OK", line)
+	}
+	return line
+}
+
+func (tc *testconn) readerr(code string) {
+	tc.t.Helper()
+	line := tc.readline()
+	if !strings.HasPrefix(line, "-ERR") || code != "" && !strings.HasPrefix(line, "-ERR ["+code+"]") {
+		tc.t.Fatalf("got %q, expected -ERR with code %q", line, code)
+	}
+}
+
+// readmulti reads a multi-line response, undoing dot-stuffing.
+func (tc *testconn) readmulti() []string {
+	tc.t.Helper()
+	tc.readok()
+	var l []string
+	for {
+		line := tc.readline()
+		if line == "." {
+			return l
+		}
+		l = append(l, strings.TrimPrefix(line, "."))
+	}
+}
+
+func (tc *testconn) cmdok(format string, args ...any) string {
+	tc.t.Helper()
+	tc.writelinef(format, args...)
+	return tc.readok()
+}
+
+func (tc *testconn) cmderr(code, format string, args ...any) {
+	tc.t.Helper()
+	tc.writelinef(format, args...)
+	tc.readerr(code)
+}
+
+// auth runs a sasl authentication, returning the final line from the server.
+func (tc *testconn) auth(client sasl.Client) string {
+	tc.t.Helper()
+	name, _ := client.Info()
+	toServer, last, err := client.Next(nil)
+	tcheck(tc.t, err, "sasl next")
+	if toServer == nil {
+		tc.writelinef("AUTH %s", name)
+	} else {
+		tc.writelinef("AUTH %s %s", name, base64.StdEncoding.EncodeToString(toServer))
+	}
+	for {
+		line := tc.readline()
+		if !strings.HasPrefix(line, "+ ") {
+			return line
+		}
+		if last {
+			tc.t.Fatalf("server wants more after last sasl client message")
+		}
+		fromServer, err := base64.StdEncoding.DecodeString(line[2:])
+		tcheck(tc.t, err, "decode sasl server message")
+		toServer, last, err = client.Next(fromServer)
+		if err != nil {
+			// Likely a scram error from the server, abort.
+			tc.writelinef("*")
+			continue
+		}
+		tc.writelinef("%s", base64.StdEncoding.EncodeToString(toServer))
+	}
+}
+
+func TestSession(t *testing.T) {
+	cleanup := setup(t)
+	defer cleanup()
+
+	tc := startConn(t, nil, false, true, false)
+	defer tc.close()
+
+	caps := tc.readmultiCmd("CAPA")
+	tcompare(t, strings.Join(caps, ","), "TOP,UIDL,RESP-CODES,AUTH-RESP-CODE,PIPELINING,USER,SASL PLAIN CRAM-MD5 SCRAM-SHA-1 SCRAM-SHA-256,IMPLEMENTATION mox")
+
+	tc.cmderr("", "STAT")          // Not authenticated.
+	tc.cmderr("", "PASS test")     // Missing USER.
+	tc.cmderr("", "NOSUCHCOMMAND") // Unknown command.
+	tc.cmdok("USER mjl@mox.example")
+	tc.cmderr("AUTH", "PASS bad") // Bad password.
+	tc.cmdok("USER mjl@mox.example")
+	tc.cmdok("PASS %s", password1)        // Password with spaces.
+	tc.cmderr("", "USER mjl@mox.example") // Already authenticated.
+	tcompare(t, tc.cmdok("STAT"), fmt.Sprintf("+OK 2 %d", len(msg0)+len(msg1)))
+
+	tcompare(t, tc.readmultiCmd("LIST"), []string{fmt.Sprintf("1 %d", len(msg0)), fmt.Sprintf("2 %d", len(msg1))})
+	tcompare(t, tc.cmdok("LIST 2"), fmt.Sprintf("+OK 2 %d", len(msg1)))
+	tc.cmderr("", "LIST 3")
+	tc.cmderr("", "LIST 0")
+
+	uidl := tc.readmultiCmd("UIDL")
+	tcompare(t, len(uidl), 2)
+	tcompare(t, tc.cmdok("UIDL 1"), "+OK "+uidl[0])
+
+	// Dot-stuffing is undone by readmulti.
+	tcompare(t, strings.Join(tc.readmultiCmd("RETR 1"), "\r\n")+"\r\n", msg0)
+	tcompare(t, tc.readmultiCmd("TOP 1 1"), []string{"From: <mjl@mox.example>", "Subject: test", "", "line 1"})
+	tcompare(t, tc.readmultiCmd("TOP 1 0"), []string{"From: <mjl@mox.example>", "Subject: test", ""})
+
+	tc.cmdok("DELE 1")
+	tc.cmderr("", "DELE 1")
+	tc.cmderr("", "RETR 1")
+	tcompare(t, tc.cmdok("STAT"), fmt.Sprintf("+OK 1 %d", len(msg1)))
+	tc.cmdok("RSET")
+	tcompare(t, tc.cmdok("STAT"), fmt.Sprintf("+OK 2 %d", len(msg0)+len(msg1)))
+	tc.cmdok("DELE 2")
+	tc.cmdok("NOOP")
+	tcompare(t, tc.cmdok("QUIT"), "+OK mox pop3 signing off, 1 messages removed")
+	tc.close()
+
+	// Check message was removed, and unique ids stay the same.
+	tc = startConn(t, nil, false, true, false)
+	tc.cmdok("USER mjl@mox.example")
+	tc.cmdok("PASS %s", password0)
+	tcompare(t, tc.readmultiCmd("UIDL"), []string{uidl[0]})
+	tc.cmdok("QUIT")
+	tc.close()
+}
+
+func (tc *testconn) readmultiCmd(format string, args ...any) []string {
+	tc.t.Helper()
+	tc.writelinef(format, args...)
+	return tc.readmulti()
+}
+
+func TestAuthenticate(t *testing.T) {
+	cleanup := setup(t)
+	defer cleanup()
+
+	// Plain text authentication is refused without TLS.
+	tc := startConn(t, nil, false, false, false)
+	caps := tc.readmultiCmd("CAPA")
+	tcompare(t, strings.Join(caps, ","), "TOP,UIDL,RESP-CODES,AUTH-RESP-CODE,PIPELINING,SASL CRAM-MD5 SCRAM-SHA-1 SCRAM-SHA-256,IMPLEMENTATION mox")
+	tc.cmderr("AUTH", "USER mjl@mox.example")
+	tc.cmderr("AUTH", "AUTH PLAIN %s", base64.StdEncoding.EncodeToString([]byte("\u0000mjl@mox.example\u0000"+password0)))
+	tc.cmderr("", "AUTH BOGUS")
+	tc.cmderr("", "AUTH CRAM-MD5 bogus") // Initial response not allowed.
+
+	// Aborted authentication.
+	tc.writelinef("AUTH SCRAM-SHA-256")
+	tcompare(t, tc.readline(), "+ ")
+	tc.writelinef("*")
+	tc.readerr("")
+
+	line := tc.auth(sasl.NewClientSCRAMSHA256("mjl@mox.example", "bad", false))
+	tcompare(t, strings.HasPrefix(line, "-ERR [AUTH]"), true)
+	tc.close()
+
+	for _, client := range []sasl.Client{
+		sasl.NewClientCRAMMD5("mjl@mox.example", password0),
+		sasl.NewClientSCRAMSHA1("mjl@mox.example", password0, false),
+		sasl.NewClientSCRAMSHA256("mjl@mox.example", password0, false),
+	} {
+		tc := startConn(t, nil, false, false, false)
+		line := tc.auth(client)
+		if !strings.HasPrefix(line, "+OK") {
+			t.Fatalf("auth failed: %q", line)
+		}
+		tc.cmdok("STAT")
+		tc.close()
+	}
+
+	// Disabled login.
+	tc = startConn(t, nil, false, true, false)
+	tc.cmdok("USER disabled@mox.example")
+	tc.cmderr("AUTH", "PASS test")
+	tc.close()
+
+	// With STLS, PLAIN is allowed.
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{fakeCert(t)}}
+	tc = startConn(t, tlsConfig, false, false, false)
+	caps = tc.readmultiCmd("CAPA")
+	tcompare(t, caps[len(caps)-2], "STLS")
+	tc.cmdok("STLS")
+	tlsConn := tls.Client(tc.conn, &tls.Config{InsecureSkipVerify: true})
+	tc.conn = tlsConn
+	tc.br = bufio.NewReader(tlsConn)
+	tc.cmderr("", "STLS")
+	line = tc.auth(sasl.NewClientPlain("mjl@mox.example", password0))
+	tcompare(t, strings.HasPrefix(line, "+OK"), true)
+	tc.close()
+
+	// Immediate TLS, with channel binding.
+	tc = startConn(t, tlsConfig, true, false, false)
+	cs := tc.conn.(*tls.Conn).ConnectionState()
+	line = tc.auth(sasl.NewClientSCRAMSHA256PLUS("mjl@mox.example", password0, cs))
+	tcompare(t, strings.HasPrefix(line, "+OK"), true)
+	tc.close()
+
+	// With plaintext authentication disabled, only SCRAM is allowed, also with TLS.
+	tc = startConn(t, tlsConfig, true, false, true)
+	caps = tc.readmultiCmd("CAPA")
+	tcompare(t, strings.Join(caps, ","), "TOP,UIDL,RESP-CODES,AUTH-RESP-CODE,PIPELINING,SASL SCRAM-SHA-1 SCRAM-SHA-256 SCRAM-SHA-1-PLUS SCRAM-SHA-256-PLUS,IMPLEMENTATION mox")
+	tc.cmderr("AUTH", "USER mjl@mox.example")
+	tc.cmderr("AUTH", "AUTH PLAIN %s", base64.StdEncoding.EncodeToString([]byte("\u0000mjl@mox.example\u0000"+password0)))
+	tc.cmderr("AUTH", "AUTH CRAM-MD5")
+	line = tc.auth(sasl.NewClientSCRAMSHA256("mjl@mox.example", password0, false))
+	tcompare(t, strings.HasPrefix(line, "+OK"), true)
+	tc.close()
+}
+
+func fakeCert(t *testing.T) tls.Certificate {
+	privKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)) // Fake key, don't use this for real!
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1), // Required field...
+	}
+	localCertBuf, err := x509.CreateCertificate(cryptorand.Reader, template, template, privKey.Public(), privKey)
+	tcheck(t, err, "making certificate")
+	cert, err := x509.ParseCertificate(localCertBuf)
+	tcheck(t, err, "parsing generated certificate")
+	return tls.Certificate{
+		Certificate: [][]byte{localCertBuf},
+		PrivateKey:  privKey,
+		Leaf:        cert,
+	}
+}
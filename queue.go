@@ -394,6 +394,53 @@ func ctlcmdQueueRequireTLS(ctl *ctl, f queue.Filter, tlsreq *bool) {
 	}
 }
 
+func cmdQueueRequireVerifiedTLS(c *cmd) {
+	c.params = "[filterflags] {yes | no}"
+	c.help = `Set whether verified TLS is required for delivery of matching messages.
+
+With value "yes", direct delivery requires STARTTLS with a certificate verified
+through DANE or otherwise PKIX/WebPKI, also when the recipient domain has no
+MTA-STS or DANE policy. Delivery attempts fail if verified TLS is not possible,
+with a DSN to the sender after the last attempt. A "TLS-Required: No" header is
+ignored.
+
+Value "no" restores the default behaviour. Verified TLS can still be required
+through the direct transport used for delivery.
+`
+	var f queue.Filter
+	flagFilterSort(c.flag, &f, nil)
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+	var required bool
+	switch args[0] {
+	case "yes":
+		required = true
+	case "no":
+	default:
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdQueueRequireVerifiedTLS(xctl(), f, required)
+}
+
+func ctlcmdQueueRequireVerifiedTLS(ctl *ctl, f queue.Filter, required bool) {
+	ctl.xwrite("queuerequireverifiedtls")
+	xctlwriteJSON(ctl, f)
+	if required {
+		ctl.xwrite("true")
+	} else {
+		ctl.xwrite("false")
+	}
+	line := ctl.xread()
+	if line == "ok" {
+		fmt.Printf("%s message(s) changed\n", ctl.xread())
+	} else {
+		log.Fatalf("%s", line)
+	}
+}
+
 func cmdQueueFail(c *cmd) {
 	c.params = "[filterflags]"
 	c.help = `Fail delivery of matching messages, delivering DSNs.
@@ -0,0 +1,251 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtpclient"
+)
+
+// We keep track of the health of delivery destinations: a recipient domain with
+// its MX hosts for direct delivery, or the smarthost of a submission/smtp
+// transport. When connections to a destination consistently fail (connection
+// refused, timeouts, TLS errors), we open a "circuit" for the destination. While
+// the circuit is open, messages to the destination are postponed collectively,
+// instead of each message being retried on its own schedule, only to fail in the
+// same way. Once the open period has passed, a single delivery attempt is allowed
+// through as probe. If it can connect, the circuit is closed again. If not, the
+// circuit opens again for twice as long. Health is only kept in memory, a restart
+// starts with all circuits closed.
+
+var (
+	metricCircuitOpen = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mox_queue_circuit_open_total",
+			Help: "Number of times a circuit for a delivery destination was opened due to consistent connection failures, including after a failed probe.",
+		},
+	)
+	_ = promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "mox_queue_circuit_open",
+			Help: "Delivery destinations that currently have an open circuit.",
+		},
+		func() float64 {
+			circuits.Lock()
+			defer circuits.Unlock()
+			var n int
+			for _, c := range circuits.m {
+				if !c.openUntil.IsZero() {
+					n++
+				}
+			}
+			return float64(n)
+		},
+	)
+)
+
+const (
+	circuitThreshold  = 5                // Consecutive connection failures before opening a circuit.
+	circuitOpenPeriod = 5 * time.Minute  // Initial period a circuit is open.
+	circuitOpenMax    = 4 * time.Hour    // Maximum period a circuit is open, after repeated failed probes.
+	circuitProbeWait  = 30 * time.Second // Postponement of other messages while a probe is in progress.
+)
+
+// circuit is the health of a single delivery destination.
+type circuit struct {
+	failures   int           // Consecutive connection failures.
+	openUntil  time.Time     // Zero if circuit is closed.
+	openPeriod time.Duration // Period the circuit was last opened for.
+	probing    bool          // Whether a probe delivery attempt is in progress for an open circuit.
+}
+
+var circuits = struct {
+	sync.Mutex
+	m map[string]*circuit
+}{m: map[string]*circuit{}}
+
+// circuitsReset closes all circuits.
+func circuitsReset() {
+	circuits.Lock()
+	defer circuits.Unlock()
+	circuits.m = map[string]*circuit{}
+}
+
+// circuitKeyTransport returns the key for the destination of a
+// submission/smtp transport, i.e. the smarthost.
+func circuitKeyTransport(transportName string) string {
+	return "transport " + transportName
+}
+
+// circuitKeyTransportHost returns the key for a single host of a submission/smtp
+// transport with multiple hosts. Hosts with an open circuit are skipped, messages
+// are not postponed for them.
+func circuitKeyTransportHost(transportName, addr string) string {
+	return "host " + addr + " transport " + transportName
+}
+
+// circuitKeyDirect returns the key for direct delivery to a recipient domain,
+// possibly through a transport (e.g. socks or with specific IPs).
+func circuitKeyDirect(transportName, recipientDomain string) string {
+	if transportName == "" {
+		return "domain " + recipientDomain
+	}
+	return "transport " + transportName + " domain " + recipientDomain
+}
+
+// circuitKey returns the key for the destination of m when delivered with
+// transport. An empty string is returned for transports that don't connect to a
+// destination.
+func circuitKey(transportName string, transport config.Transport, m Msg) string {
+	switch {
+	case transport.Fail != nil:
+		return ""
+	case transport.Submissions != nil, transport.Submission != nil, transport.SMTP != nil:
+		return circuitKeyTransport(transportName)
+	}
+	return circuitKeyDirect(transportName, m.RecipientDomainStr)
+}
+
+// circuitCheck returns whether a delivery attempt to the destination for key can
+// be made. If not, the time until which the attempt should be postponed is
+// returned. When the open period of a circuit has passed, a single caller is
+// allowed through as probe. A probe must call circuitProbeEnd when done, typically
+// deferred.
+func circuitCheck(key string, now time.Time) (allow, probe bool, until time.Time) {
+	circuits.Lock()
+	defer circuits.Unlock()
+
+	c := circuits.m[key]
+	if c == nil || c.openUntil.IsZero() {
+		return true, false, time.Time{}
+	}
+	if now.Before(c.openUntil) {
+		return false, false, c.openUntil
+	}
+	if c.probing {
+		return false, false, now.Add(circuitProbeWait)
+	}
+	c.probing = true
+	return true, true, time.Time{}
+}
+
+// circuitProbeEnd ends the probe for the destination of key if it is still in
+// progress. A probe normally ends with the result of connecting, but a delivery
+// attempt can stop before connecting, e.g. due to a DNS error or the message
+// being removed. Without a result, the circuit stays open, and the next delivery
+// attempt becomes the probe.
+func circuitProbeEnd(key string) {
+	circuits.Lock()
+	defer circuits.Unlock()
+	if c := circuits.m[key]; c != nil {
+		c.probing = false
+	}
+}
+
+// circuitConnFailure returns whether err from a delivery attempt indicates we could
+// not get to an SMTP session with the remote, and should count against the health
+// of the destination.
+func circuitConnFailure(err error) bool {
+	return errors.Is(err, errDial) || errors.Is(err, smtpclient.ErrTLS) || errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// circuitResult registers the outcome of a delivery attempt to the destination for
+// key. If connFailure is false, we were able to speak SMTP with the remote and the
+// circuit is closed. Otherwise the failure is counted, and the circuit is opened
+// when the threshold is reached, or reopened if this was a probe. When the circuit
+// is (re)opened, all messages for the destination are postponed until the end of
+// the open period.
+func circuitResult(log mlog.Log, key string, connFailure bool) {
+	if key == "" {
+		return
+	}
+
+	now := time.Now()
+	var openUntil time.Time
+	var failures int
+	circuits.Lock()
+	c := circuits.m[key]
+	if !connFailure {
+		if c != nil && !c.openUntil.IsZero() {
+			log.Info("closing circuit for delivery destination after successful connection", slog.String("destination", key))
+		}
+		delete(circuits.m, key)
+		circuits.Unlock()
+		return
+	}
+	if c == nil {
+		c = &circuit{}
+		circuits.m[key] = c
+	}
+	c.failures++
+	if c.probing {
+		c.probing = false
+		c.openPeriod = min(2*c.openPeriod, circuitOpenMax)
+		c.openUntil = now.Add(c.openPeriod)
+		openUntil = c.openUntil
+	} else if c.openUntil.IsZero() && c.failures >= circuitThreshold {
+		c.openPeriod = circuitOpenPeriod
+		c.openUntil = now.Add(c.openPeriod)
+		openUntil = c.openUntil
+	}
+	failures = c.failures
+	circuits.Unlock()
+
+	if openUntil.IsZero() {
+		return
+	}
+	metricCircuitOpen.Inc()
+	if strings.HasPrefix(key, "host ") {
+		log.Info("opening circuit for transport host due to consistent connection failures, skipping host",
+			slog.String("destination", key),
+			slog.Int("failures", failures),
+			slog.Time("until", openUntil))
+		return
+	}
+	log.Info("opening circuit for delivery destination due to consistent connection failures, postponing messages",
+		slog.String("destination", key),
+		slog.Int("failures", failures),
+		slog.Time("until", openUntil))
+	n, err := circuitPostpone(key, openUntil)
+	log.Check(err, "postponing messages for delivery destination with open circuit", slog.String("destination", key))
+	if n > 0 {
+		log.Debug("postponed messages for delivery destination with open circuit", slog.String("destination", key), slog.Int("count", n))
+	}
+}
+
+// circuitPostpone sets the next attempt for messages in the queue for the
+// destination of key to until, for messages that would be attempted earlier.
+func circuitPostpone(key string, until time.Time) (int, error) {
+	var n int
+	err := DB.Write(context.Background(), func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[Msg](tx)
+		q.FilterEqual("Hold", false)
+		q.FilterLess("NextAttempt", until)
+		var ids []int64
+		err := q.ForEach(func(m Msg) error {
+			transportName, transport, ok := resolveTransport(m)
+			if ok && circuitKey(transportName, transport, m) == key {
+				ids = append(ids, m.ID)
+			}
+			return nil
+		})
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		n, err = bstore.QueryTx[Msg](tx).FilterIDs(ids).UpdateField("NextAttempt", until)
+		return err
+	})
+	return n, err
+}
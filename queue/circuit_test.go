@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+)
+
+func TestCircuit(t *testing.T) {
+	_, cleanup := setup(t)
+	defer cleanup()
+
+	get := func(id int64) Msg {
+		t.Helper()
+		m := Msg{ID: id}
+		err := DB.Get(ctxbg, &m)
+		tcheck(t, err, "get message")
+		return m
+	}
+	add := func(domain string) Msg {
+		t.Helper()
+		path := smtp.Path{Localpart: "mjl", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "mox.example"}}}
+		rcpt := smtp.Path{Localpart: "mjl", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: domain}}}
+		mf := prepareFile(t)
+		defer os.Remove(mf.Name())
+		defer mf.Close()
+		qm := MakeMsg(path, rcpt, false, false, int64(len(testmsg)), "<test@localhost>", nil, nil, time.Now(), "test")
+		qml := []Msg{qm}
+		err := Add(ctxbg, pkglog, "mjl", mf, qml...)
+		tcheck(t, err, "add message to queue")
+		return get(qml[0].ID)
+	}
+
+	mdirect := add("mox.example")
+	msubmit := add("submit.example")
+
+	keyFor := func(m Msg) string {
+		transportName, transport, ok := resolveTransport(m)
+		tcompare(t, ok, true)
+		return circuitKey(transportName, transport, m)
+	}
+	key := keyFor(mdirect)
+	tcompare(t, key, "domain mox.example")
+	tcompare(t, keyFor(msubmit), "transport submit")
+
+	check := func(expAllow bool) time.Time {
+		t.Helper()
+		allow, _, until := circuitCheck(key, time.Now())
+		tcompare(t, allow, expAllow)
+		return until
+	}
+	expire := func() {
+		circuits.Lock()
+		defer circuits.Unlock()
+		circuits.m[key].openUntil = time.Now().Add(-time.Second)
+	}
+
+	// Failures below the threshold don't open the circuit, and a success resets the count.
+	for range circuitThreshold - 1 {
+		circuitResult(pkglog, key, true)
+		check(true)
+	}
+	circuitResult(pkglog, key, false)
+	for range circuitThreshold - 1 {
+		circuitResult(pkglog, key, true)
+		check(true)
+	}
+
+	// Reaching the threshold opens the circuit and postpones messages for the destination.
+	circuitResult(pkglog, key, true)
+	until := check(false)
+	tcompare(t, get(mdirect.ID).NextAttempt.Equal(until), true)
+	tcompare(t, get(msubmit.ID).NextAttempt.Before(until), true)
+
+	// After the open period, a single probe is allowed.
+	expire()
+	check(true)
+	check(false)
+
+	// Failed probe opens the circuit again, for longer.
+	circuitResult(pkglog, key, true)
+	nuntil := check(false)
+	tcompare(t, nuntil.Sub(until) > circuitOpenPeriod/2, true)
+	tcompare(t, get(mdirect.ID).NextAttempt.Equal(nuntil), true)
+
+	// Probe that ends without result, e.g. due to a DNS error, lets the next attempt
+	// probe.
+	expire()
+	allow, probe, _ := circuitCheck(key, time.Now())
+	tcompare(t, allow, true)
+	tcompare(t, probe, true)
+	check(false)
+	circuitProbeEnd(key)
+	check(true)
+	circuitProbeEnd(key)
+
+	// Successful probe closes the circuit.
+	expire()
+	check(true)
+	circuitResult(pkglog, key, false)
+	check(true)
+	circuits.Lock()
+	tcompare(t, len(circuits.m), 0)
+	circuits.Unlock()
+}
@@ -7,8 +7,10 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	mathrand2 "math/rand/v2"
 	"net"
 	"os"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -37,6 +39,9 @@ import (
 // dnsbl monitoring to pace querying.
 var connectionCounter atomic.Int64
 
+// errDial is returned when we could not connect to a remote smtp server.
+var errDial = errors.New("dialing smtp server")
+
 var (
 	metricDestinations = promauto.NewCounter(
 		prometheus.CounterOpts{
@@ -112,7 +117,7 @@ type msgResp struct {
 // domain (MTA-STS), its policy type can be empty, in which case there is no
 // information (e.g. internal failure). hostResults are per-host details (DANE, one
 // per MX target).
-func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer, ourHostname dns.Domain, transportName string, transportDirect *config.TransportDirect, msgs []*Msg, backoff time.Duration) (recipientDomainResult tlsrpt.Result, hostResults []tlsrpt.Result) {
+func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer, ourHostname dns.Domain, transportName string, transportDirect *config.TransportDirect, remoteResolve bool, msgs []*Msg, backoff time.Duration) (recipientDomainResult tlsrpt.Result, hostResults []tlsrpt.Result) {
 	// High-level approach:
 	// - Resolve domain to deliver to (CNAME), and determine hosts to try to deliver to (MX)
 	// - Get MTA-STS policy for domain (optional). If present, only deliver to its
@@ -127,6 +132,8 @@ func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 	//   - If RequireTLS is false, we'll fall back to regular delivery attempts without
 	//     TLS verification and possibly without TLS at all, ignoring recipient domain/host
 	//     MTA-STS and DANE policies.
+	//   - If verified TLS is required, for the message or the direct transport, we
+	//     require STARTTLS with DANE or PKIX verification, even without MTA-STS policy.
 
 	// For convenience, we use m0 to access properties that are shared over all
 	// messages we are delivering.
@@ -139,7 +146,18 @@ func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 	// directly.
 	origNextHop := m0.RecipientDomain.Domain
 	ctx := mox.Shutdown
-	haveMX, origNextHopAuthentic, expandedNextHopAuthentic, expandedNextHop, hostPrefs, permanent, err := smtpclient.GatherDestinations(ctx, qlog.Logger, resolver, m0.RecipientDomain)
+	var haveMX, origNextHopAuthentic, expandedNextHopAuthentic, permanent bool
+	var expandedNextHop dns.Domain
+	var hostPrefs []smtpclient.HostPref
+	var err error
+	if remoteResolve && m0.RecipientDomain.IsDomain() {
+		// With a SOCKS proxy that resolves names, e.g. for .onion domains, we don't do
+		// any DNS lookups and connect to the recipient domain itself.
+		expandedNextHop = origNextHop
+		hostPrefs = []smtpclient.HostPref{{Host: m0.RecipientDomain, Pref: -1}}
+	} else {
+		haveMX, origNextHopAuthentic, expandedNextHopAuthentic, expandedNextHop, hostPrefs, permanent, err = smtpclient.GatherDestinations(ctx, qlog.Logger, resolver, m0.RecipientDomain)
+	}
 	if err != nil {
 		// If this is a DNSSEC authentication error, we'll collect it for TLS reporting.
 		// Hopefully it's a temporary misconfiguration that is solve before we try to send
@@ -160,14 +178,14 @@ func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 		return
 	}
 
-	tlsRequiredNo := m0.RequireTLS != nil && !*m0.RequireTLS
+	tlsRequiredNo, requireVerifiedTLS := tlsRequirements(m0, transportDirect)
 
 	// Check for MTA-STS policy and enforce it if needed.
 	// We must check at the original next-hop, i.e. recipient domain, not following any
 	// CNAMEs. If we were to follow CNAMEs and ask for MTA-STS at that domain, it
 	// would only take a single CNAME DNS response to direct us to an unrelated domain.
 	var policy *mtasts.Policy // Policy can have mode enforce, testing and none.
-	if !origNextHop.IsZero() {
+	if !origNextHop.IsZero() && !remoteResolve {
 		policy, recipientDomainResult, _, err = mtastsdb.Get(ctx, qlog.Logger, resolver, origNextHop)
 		if err != nil {
 			if tlsRequiredNo {
@@ -195,6 +213,16 @@ func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 	var remoteMTA dsn.NameIP
 	var lastErr = errors.New("no error") // Can be smtpclient.Error.
 	nmissingRequireTLS := 0
+	// For the health of the destination, we track whether we could connect to any of
+	// the hosts. Failures for other reasons than connecting, e.g. SMTP errors, count as
+	// connected.
+	circuit := circuitKeyDirect(transportName, m0.RecipientDomainStr)
+	var nconnected, nconnFailed int
+	defer func() {
+		if nconnected > 0 || nconnFailed > 0 {
+			circuitResult(qlog, circuit, nconnected == 0)
+		}
+	}()
 	// todo: should make distinction between host permanently not accepting the message, and the message not being deliverable permanently. e.g. a mx host may have a size limit, or not accept 8bitmime, while another host in the list does accept the message. same for smtputf8, ../rfc/6531:555
 	for _, hp := range hostPrefs {
 		h := hp.Host
@@ -220,6 +248,9 @@ func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 					lastErr = fmt.Errorf("mx host %s does not match enforced mta-sts policy with hosts %s", h.Domain, strings.Join(policyHosts, ","))
 					qlog.Error("mx host does not match mta-sts policy in mode enforce, skipping", slog.Any("host", h.Domain), slog.Any("policyhosts", policyHosts))
 					recipientDomainResult.Summary.TotalFailureSessionCount++
+					for _, m := range msgs {
+						m.addResultHost(MsgResultHost{Host: h.XString(false), Start: time.Now(), Error: lastErr.Error(), TLSFailures: []string{string(tlsrpt.ResultValidationFailure)}})
+					}
 					continue
 				}
 			} else {
@@ -234,11 +265,11 @@ func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 		enforceMTASTS := policy != nil && policy.Mode == mtasts.ModeEnforce
 		tlsMode := smtpclient.TLSOpportunistic
 		tlsPKIX := false
-		if enforceMTASTS {
+		if enforceMTASTS || requireVerifiedTLS {
 			tlsMode = smtpclient.TLSRequiredStartTLS
 			tlsPKIX = true
-			// note: smtpclient will still go through PKIX verification, and report about it, but not fail the connection if not passing.
 		}
+		// note: without tlsPKIX, smtpclient will still go through PKIX verification, and report about it, but not fail the connection if not passing.
 
 		// Try to deliver to host. We can get various errors back. Like permanent failure
 		// response codes, TCP, DNSSEC, TLS (opportunistic, i.e. optional with fallback to
@@ -255,7 +286,9 @@ func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 			msgResps[i] = &msgResp{msg: msgs[i]}
 		}
 
-		result := deliverHost(nqlog, resolver, dialer, ourHostname, transportName, transportDirect, h, enforceMTASTS, haveMX, origNextHopAuthentic, origNextHop, expandedNextHopAuthentic, expandedNextHop, msgResps, tlsMode, tlsPKIX, &recipientDomainResult)
+		start := time.Now()
+		result := deliverHost(nqlog, resolver, dialer, ourHostname, transportName, transportDirect, remoteResolve, h, enforceMTASTS, haveMX, origNextHopAuthentic, origNextHop, expandedNextHopAuthentic, expandedNextHop, msgResps, tlsMode, tlsPKIX, &recipientDomainResult)
+		addHostResults(msgResps, h, start, result)
 
 		var zerotype tlsrpt.PolicyType
 		if result.hostResult.Policy.Type != zerotype {
@@ -282,10 +315,27 @@ func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 				slog.Bool("enforcemtasts", enforceMTASTS),
 				slog.Bool("tlsdane", result.tlsDANE),
 				slog.Any("requiretls", m0.RequireTLS))
-			result = deliverHost(nqlog, resolver, dialer, ourHostname, transportName, transportDirect, h, enforceMTASTS, haveMX, origNextHopAuthentic, origNextHop, expandedNextHopAuthentic, expandedNextHop, msgResps, smtpclient.TLSSkip, false, &tlsrpt.Result{})
+			start := time.Now()
+			result = deliverHost(nqlog, resolver, dialer, ourHostname, transportName, transportDirect, remoteResolve, h, enforceMTASTS, haveMX, origNextHopAuthentic, origNextHop, expandedNextHopAuthentic, expandedNextHop, msgResps, smtpclient.TLSSkip, false, &tlsrpt.Result{})
+			addHostResults(msgResps, h, start, result)
+		}
+
+		// Make it clear in the DSN why delivery failed.
+		var cerr smtpclient.Error
+		if requireVerifiedTLS && result.err != nil && errors.Is(result.err, smtpclient.ErrTLS) && errors.As(result.err, &cerr) {
+			cerr.Err = fmt.Errorf("verified tls required for delivery: %w", cerr.Err)
+			if cerr.Secode == "" {
+				cerr.Secode = smtp.SePol7EncNeeded10
+			}
+			result.err = cerr
 		}
 
 		remoteMTA = dsn.NameIP{Name: h.XString(false), IP: remoteIP}
+		if result.err != nil && circuitConnFailure(result.err) {
+			nconnFailed++
+		} else {
+			nconnected++
+		}
 		if result.err != nil {
 			lastErr = result.err
 			var cerr smtpclient.Error
@@ -306,6 +356,9 @@ func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 			mqlog.Info("delivered from queue")
 			mr.msg.markResult(mr.resp.Code, mr.resp.Secode, "", true)
 			delMsgs[i] = *mr.msg
+			if !result.remoteDSN {
+				deliverDSNRelayed(mqlog, *mr.msg, remoteMTA)
+			}
 		}
 		if len(delMsgs) > 0 {
 			err := DB.Write(context.Background(), func(tx *bstore.Tx) error {
@@ -365,8 +418,59 @@ func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 	return
 }
 
+// addHostResults adds the result of a delivery attempt to host, started at
+// start, to the delivery results in progress of the messages.
+func addHostResults(msgResps []*msgResp, host dns.IPDomain, start time.Time, result deliverResult) {
+	rh := MsgResultHost{
+		Host:     host.XString(false),
+		Start:    start,
+		Duration: time.Since(start),
+		TLS:      result.tls,
+		TLSMode:  result.tlsMode,
+	}
+	if result.remoteIP != nil {
+		rh.IP = result.remoteIP.String()
+	}
+	for _, fd := range result.hostResult.FailureDetails {
+		if !slices.Contains(rh.TLSFailures, string(fd.ResultType)) {
+			rh.TLSFailures = append(rh.TLSFailures, string(fd.ResultType))
+		}
+	}
+	for _, mr := range msgResps {
+		mrh := rh
+		if result.err != nil {
+			var cerr smtpclient.Error
+			if errors.As(result.err, &cerr) {
+				mrh.Code = cerr.Code
+				mrh.Secode = cerr.Secode
+			}
+			mrh.Error = result.err.Error()
+		} else {
+			mrh.Code = mr.resp.Code
+			mrh.Secode = mr.resp.Secode
+			mrh.Success = slices.Contains(result.delivered, mr)
+			if !mrh.Success {
+				mrh.Error = smtpclient.Error(mr.resp).Error()
+			}
+		}
+		mr.msg.addResultHost(mrh)
+	}
+}
+
+// tlsRequirements returns whether TLS verification errors must be ignored due to a
+// "TLS-Required: No" header, and whether verified TLS is required for delivery,
+// for the message or through the direct transport. Requiring verified TLS takes
+// precedence.
+func tlsRequirements(m *Msg, transportDirect *config.TransportDirect) (tlsRequiredNo, requireVerifiedTLS bool) {
+	requireVerifiedTLS = m.RequireVerifiedTLS || transportDirect != nil && transportDirect.RequireVerifiedTLS
+	tlsRequiredNo = !requireVerifiedTLS && m.RequireTLS != nil && !*m.RequireTLS
+	return
+}
+
 type deliverResult struct {
 	tlsDANE    bool
+	tls        bool   // Whether the connection was protected with TLS.
+	tlsMode    string // TLS mode with verification mechanisms, as used in metrics.
 	remoteIP   net.IP
 	hostResult tlsrpt.Result
 
@@ -376,6 +480,27 @@ type deliverResult struct {
 	delivered []*msgResp
 	failed    []*msgResp
 	err       error
+
+	// Whether the remote server supports the DSN extension, and was passed the DSN
+	// parameters.
+	remoteDSN bool
+}
+
+// localIPs returns the IPs to use as source address when connecting for delivery of
+// a message from the account. If the account belongs to a tenant with outgoing IPs,
+// those IPs are returned in random order, so smtpclient.Dial picks a random IP
+// from the pool. Otherwise the explicitly configured SMTP listener IPs are
+// returned.
+func localIPs(accountName string) []net.IP {
+	_, tenant, ok := mox.Conf.AccountTenant(accountName)
+	if !ok || len(tenant.IPs) == 0 {
+		return mox.Conf.Static.SpecifiedSMTPListenIPs
+	}
+	ips := slices.Clone(tenant.IPs)
+	mathrand2.Shuffle(len(ips), func(i, j int) {
+		ips[i], ips[j] = ips[j], ips[i]
+	})
+	return ips
 }
 
 // deliverHost attempts to deliver msgs to host. All msgs must have the same
@@ -398,28 +523,32 @@ type deliverResult struct {
 //
 // deliverHost may send a message multiple times: if the server doesn't accept
 // multiple recipients for a message.
-func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer, ourHostname dns.Domain, transportName string, transportDirect *config.TransportDirect, host dns.IPDomain, enforceMTASTS, haveMX, origNextHopAuthentic bool, origNextHop dns.Domain, expandedNextHopAuthentic bool, expandedNextHop dns.Domain, msgResps []*msgResp, tlsMode smtpclient.TLSMode, tlsPKIX bool, recipientDomainResult *tlsrpt.Result) (result deliverResult) {
+func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer, ourHostname dns.Domain, transportName string, transportDirect *config.TransportDirect, remoteResolve bool, host dns.IPDomain, enforceMTASTS, haveMX, origNextHopAuthentic bool, origNextHop dns.Domain, expandedNextHopAuthentic bool, expandedNextHop dns.Domain, msgResps []*msgResp, tlsMode smtpclient.TLSMode, tlsPKIX bool, recipientDomainResult *tlsrpt.Result) (result deliverResult) {
 	// About attempting delivery to multiple addresses of a host: ../rfc/5321:3898
 
 	m0 := msgResps[0].msg
-	tlsRequiredNo := m0.RequireTLS != nil && !*m0.RequireTLS
+	tlsRequiredNo, requireVerifiedTLS := tlsRequirements(m0, transportDirect)
 
-	var tlsDANE bool
+	var tlsDANE, tlsUsed bool
 	var remoteIP net.IP
 	var hostResult tlsrpt.Result
 	start := time.Now()
 	defer func() {
 		result.tlsDANE = tlsDANE
+		result.tls = tlsUsed
 		result.remoteIP = remoteIP
 		result.hostResult = hostResult
 
 		mode := string(tlsMode)
-		if tlsPKIX {
+		if enforceMTASTS {
 			mode += "+mtasts"
+		} else if tlsPKIX {
+			mode += "+pkix"
 		}
 		if tlsDANE {
 			mode += "+dane"
 		}
+		result.tlsMode = mode
 
 		r := deliveryResult(result.err, len(result.delivered), len(result.failed))
 		d := float64(time.Since(start)) / float64(time.Second)
@@ -434,6 +563,7 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 			slog.Bool("tlspkix", tlsPKIX),
 			slog.Bool("tlsdane", tlsDANE),
 			slog.Bool("tlsrequiredno", tlsRequiredNo),
+			slog.Bool("requireverifiedtls", requireVerifiedTLS),
 			slog.Bool("badtls", result.err != nil && errors.Is(result.err, smtpclient.ErrTLS)),
 			slog.Duration("duration", time.Since(start)))
 	}()
@@ -480,7 +610,15 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 			network = transportDirect.IPFamily
 		}
 	}
-	authentic, expandedAuthentic, expandedHost, ips, dualstack, err := smtpclient.GatherIPs(ctx, log.Logger, resolver, network, host, m0.DialedIPs)
+	var authentic, expandedAuthentic, dualstack bool
+	var expandedHost dns.Domain
+	var ips []net.IP
+	if remoteResolve && host.IsDomain() {
+		// The SOCKS proxy resolves the name, so we can't do DANE either.
+		log.Debug("not resolving host, leaving it to socks proxy", slog.Any("host", host))
+	} else {
+		authentic, expandedAuthentic, expandedHost, ips, dualstack, err = smtpclient.GatherIPs(ctx, log.Logger, resolver, network, host, m0.DialedIPs)
+	}
 	destAuthentic := err == nil && authentic && origNextHopAuthentic && (!haveMX || expandedNextHopAuthentic) && host.IsDomain()
 	if !destAuthentic {
 		log.Debugx("not attempting verification with dane", err, slog.Bool("authentic", authentic), slog.Bool("expandedauthentic", expandedAuthentic))
@@ -541,6 +679,10 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 				}
 			} else {
 				log.Debug("delivery with required starttls with dane verification", slog.Any("allowedtlshostnames", tlsHostnames))
+				if requireVerifiedTLS && !enforceMTASTS {
+					// Verification with DANE is enough, don't also require PKIX.
+					tlsPKIX = false
+				}
 			}
 			// Based on CNAMEs followed and DNSSEC-secure status, we must allow up to 4 host
 			// names.
@@ -593,7 +735,11 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 	var conn net.Conn
 	if err == nil {
 		connectionCounter.Add(1)
-		conn, remoteIP, err = smtpclient.Dial(ctx, log.Logger, dialer, host, ips, 25, m0.DialedIPs, mox.Conf.Static.SpecifiedSMTPListenIPs)
+		if remoteResolve && host.IsDomain() {
+			conn, err = smtpclient.DialName(ctx, log.Logger, dialer, host.Domain, 25)
+		} else {
+			conn, remoteIP, err = smtpclient.Dial(ctx, log.Logger, dialer, host, ips, 25, m0.DialedIPs, localIPs(m0.SenderAccount))
+		}
 	}
 	cancel()
 
@@ -612,7 +758,7 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 	metricConnection.WithLabelValues(dialResult).Inc()
 	if err != nil {
 		log.Debugx("connecting to remote smtp", err, slog.Any("host", host))
-		return deliverResult{err: fmt.Errorf("dialing smtp server: %v", err)}
+		return deliverResult{err: fmt.Errorf("%w: %v", errDial, err)}
 	}
 
 	var mailFrom string
@@ -645,6 +791,7 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 		HostResult:            &hostResult,
 	}
 	sc, err := smtpclient.New(ctx, log.Logger, conn, tlsMode, tlsPKIX, ourHostname, firstHost, opts)
+	tlsUsed = sc != nil && sc.TLSConnectionState() != nil
 	defer func() {
 		if sc == nil {
 			err := conn.Close()
@@ -655,7 +802,7 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 		}
 		mox.Connections.Unregister(conn)
 	}()
-	if err == nil && m0.SenderAccount != "" {
+	if err == nil && m0.SenderAccount != "" && m0.RecipientDomain.IsDomain() {
 		// Remember the STARTTLS and REQUIRETLS support for this recipient domain.
 		// It is used in the webmail client, to show the recipient domain security mechanisms.
 		// We always save only the last connection we actually encountered. There may be
@@ -726,8 +873,10 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 		}
 
 		rcpts := make([]string, n)
+		rmsgs := make([]*Msg, n)
 		for i, mr := range todo[:n] {
 			rcpts[i] = mr.msg.Recipient().XString(m0.SMTPUTF8)
+			rmsgs[i] = mr.msg
 		}
 
 		// Only require that remote announces 8bitmime extension when in pedantic mode. All
@@ -736,7 +885,7 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 		// 7-bit-only, but the trouble likely isn't worth it.
 		req8bit := has8bit && mox.Pedantic
 
-		resps, err := sc.DeliverMultiple(ctx, mailFrom, rcpts, size, msg, req8bit, smtputf8, m0.RequireTLS != nil && *m0.RequireTLS)
+		resps, err := sc.DeliverMultipleDSN(ctx, mailFrom, rcpts, size, msg, req8bit, smtputf8, m0.RequireTLS != nil && *m0.RequireTLS, dsnParams(rmsgs))
 		if err != nil && (len(resps) == 0 && n == len(msgResps) || len(resps) == len(msgResps)) {
 			// If error and it applies to all recipients, return a single error.
 			return deliverResult{err: inspectError(err)}
@@ -768,7 +917,7 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 		// implement such a limit when we see it in practice.
 	}
 
-	return deliverResult{delivered: delivered, failed: failed}
+	return deliverResult{delivered: delivered, failed: failed, remoteDSN: sc.SupportsDSN()}
 }
 
 // Update (overwite) last known starttls/requiretls support for recipient domain.
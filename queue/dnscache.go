@@ -0,0 +1,165 @@
+package queue
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/adns"
+
+	"github.com/mjl-/mox/dns"
+)
+
+// When delivering many messages to the same domain, e.g. for bulk sends, each
+// delivery attempt would do the same MX, CNAME and IP lookups. The queue uses a
+// resolver that caches the results of those lookups, including "not found"
+// results (negative caching). Results are kept for the lowest TTL of the records
+// in the responses, or for "not found" results, the negative caching TTL from the
+// SOA record, ../rfc/2308:370. Both are capped at a short maximum, so changes in
+// DNS are picked up soon. Results without known TTL, e.g. from /etc/hosts or
+// negative responses without SOA record, and temporary errors, like timeouts and
+// server failures, are not cached. The DNSSEC-verified status of a result is never
+// kept beyond the TTL of the records.
+
+var metricDNSCache = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_queue_dnscache_lookup_total",
+		Help: "DNS lookups for delivery through the queue cache.",
+	},
+	[]string{
+		"type",   // "mx", "cname", "ip"
+		"result", // "hit", "neghit" (cached not found), "miss"
+	},
+)
+
+const (
+	dnsCacheTTL         = 5 * time.Minute // Maximum lifetime of cached records.
+	dnsCacheNegativeTTL = time.Minute     // Maximum lifetime of cached "not found" results.
+	dnsCacheMax         = 10000           // Maximum number of entries in the cache.
+)
+
+type dnsCacheEntry struct {
+	expires time.Time
+	mx      []*net.MX
+	cname   string
+	ips     []net.IP
+	result  adns.Result
+	err     error // Only "not found" errors.
+}
+
+// dnsCache is a resolver that caches MX, CNAME and IP lookups, passing all other
+// lookups to the underlying resolver.
+type dnsCache struct {
+	dns.Resolver
+
+	sync.Mutex
+	entries map[string]dnsCacheEntry
+}
+
+var _ dns.Resolver = (*dnsCache)(nil)
+
+func newDNSCache(resolver dns.Resolver) *dnsCache {
+	return &dnsCache{Resolver: resolver, entries: map[string]dnsCacheEntry{}}
+}
+
+// get returns a cached entry for key if present and not expired.
+func (c *dnsCache) get(typ, key string) (dnsCacheEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.entries[key]
+	if ok && time.Now().After(e.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	if !ok {
+		metricDNSCache.WithLabelValues(typ, "miss").Inc()
+	} else if e.err != nil {
+		metricDNSCache.WithLabelValues(typ, "neghit").Inc()
+	} else {
+		metricDNSCache.WithLabelValues(typ, "hit").Inc()
+	}
+	return e, ok
+}
+
+// put stores the result of a lookup in the cache, for the TTL recorded during the
+// lookup, unless the TTL is unknown or zero, or the lookup had a temporary error.
+func (c *dnsCache) put(key string, e dnsCacheEntry, ttl *dns.TTL, err error) {
+	d, ok := ttl.TTL()
+	if !ok || d <= 0 {
+		return
+	}
+	now := time.Now()
+	if err == nil {
+		e.expires = now.Add(min(d, dnsCacheTTL))
+	} else if dns.IsNotFound(err) {
+		e.expires = now.Add(min(d, dnsCacheNegativeTTL))
+		e.err = err
+	} else {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if len(c.entries) >= dnsCacheMax {
+		for k, xe := range c.entries {
+			if now.After(xe.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= dnsCacheMax {
+			c.entries = map[string]dnsCacheEntry{}
+		}
+	}
+	c.entries[key] = e
+}
+
+func (c *dnsCache) LookupMX(ctx context.Context, name string) ([]*net.MX, adns.Result, error) {
+	key := "mx " + name
+	if e, ok := c.get("mx", key); ok {
+		l := make([]*net.MX, len(e.mx))
+		for i, mx := range e.mx {
+			xmx := *mx
+			l[i] = &xmx
+		}
+		return l, e.result, e.err
+	}
+	ctx, ttl := dns.WithTTL(ctx)
+	l, result, err := c.Resolver.LookupMX(ctx, name)
+	if err == nil {
+		xl := make([]*net.MX, len(l))
+		for i, mx := range l {
+			xmx := *mx
+			xl[i] = &xmx
+		}
+		c.put(key, dnsCacheEntry{mx: xl, result: result}, ttl, nil)
+	} else {
+		c.put(key, dnsCacheEntry{result: result}, ttl, err)
+	}
+	return l, result, err
+}
+
+func (c *dnsCache) LookupCNAME(ctx context.Context, host string) (string, adns.Result, error) {
+	key := "cname " + host
+	if e, ok := c.get("cname", key); ok {
+		return e.cname, e.result, e.err
+	}
+	ctx, ttl := dns.WithTTL(ctx)
+	cname, result, err := c.Resolver.LookupCNAME(ctx, host)
+	c.put(key, dnsCacheEntry{cname: cname, result: result}, ttl, err)
+	return cname, result, err
+}
+
+func (c *dnsCache) LookupIP(ctx context.Context, network, host string) ([]net.IP, adns.Result, error) {
+	key := "ip " + network + " " + host
+	if e, ok := c.get("ip", key); ok {
+		return append([]net.IP{}, e.ips...), e.result, e.err
+	}
+	ctx, ttl := dns.WithTTL(ctx)
+	ips, result, err := c.Resolver.LookupIP(ctx, network, host)
+	c.put(key, dnsCacheEntry{ips: append([]net.IP{}, ips...), result: result}, ttl, err)
+	return ips, result, err
+}
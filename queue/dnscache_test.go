@@ -0,0 +1,121 @@
+package queue
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mjl-/adns"
+
+	"github.com/mjl-/mox/dns"
+)
+
+// countResolver counts lookups that make it to the underlying resolver.
+type countResolver struct {
+	dns.MockResolver
+	n *int
+}
+
+func (r countResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, adns.Result, error) {
+	*r.n++
+	return r.MockResolver.LookupMX(ctx, name)
+}
+
+func (r countResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, adns.Result, error) {
+	*r.n++
+	return r.MockResolver.LookupIP(ctx, network, host)
+}
+
+func TestDNSCache(t *testing.T) {
+	var n int
+	resolver := countResolver{
+		dns.MockResolver{
+			MX: map[string][]*net.MX{
+				"mox.example.":   {{Host: "mail.mox.example.", Pref: 10}},
+				"nottl.example.": {{Host: "mail.mox.example.", Pref: 10}},
+			},
+			A:            map[string][]string{"mail.mox.example.": {"10.0.0.1"}},
+			Fail:         []string{"mx temperror.example."},
+			AllAuthentic: true,
+			TTL: map[string]uint32{
+				"mx mox.example.":      3600,
+				"mx absent.example.":   30,
+				"ip mail.mox.example.": 120,
+			},
+		},
+		&n,
+	}
+	c := newDNSCache(resolver)
+
+	lookupMX := func(name string, expN int, expErr bool) []*net.MX {
+		t.Helper()
+		l, result, err := c.LookupMX(ctxbg, name)
+		tcompare(t, err != nil, expErr)
+		tcompare(t, n, expN)
+		if err == nil {
+			tcompare(t, result.Authentic, true)
+		}
+		return l
+	}
+
+	// Positive results are cached, and callers cannot modify the cached records.
+	l := lookupMX("mox.example.", 1, false)
+	tcompare(t, len(l), 1)
+	l[0].Host = "other.example."
+	l = lookupMX("mox.example.", 1, false)
+	tcompare(t, l[0].Host, "mail.mox.example.")
+
+	// Negative results are cached.
+	lookupMX("absent.example.", 2, true)
+	_, _, err := c.LookupMX(ctxbg, "absent.example.")
+	tcompare(t, dns.IsNotFound(err), true)
+	tcompare(t, n, 2)
+
+	// Temporary errors are not cached.
+	lookupMX("temperror.example.", 3, true)
+	lookupMX("temperror.example.", 4, true)
+
+	// Results without known TTL, e.g. negative responses without SOA record, are not
+	// cached.
+	lookupMX("nottl.example.", 5, false)
+	lookupMX("nottl.example.", 6, false)
+	lookupMX("absentnottl.example.", 7, true)
+	lookupMX("absentnottl.example.", 8, true)
+
+	// Entries are cached for the TTL, capped at a maximum.
+	expires := func(key string) time.Duration {
+		t.Helper()
+		c.Lock()
+		defer c.Unlock()
+		e, ok := c.entries[key]
+		tcompare(t, ok, true)
+		return time.Until(e.expires)
+	}
+	d := expires("mx mox.example.")
+	tcompare(t, d > dnsCacheTTL-time.Minute && d <= dnsCacheTTL, true)
+	d = expires("mx absent.example.")
+	tcompare(t, d > 20*time.Second && d <= 30*time.Second, true)
+
+	// IPs are cached per network.
+	_, _, err = c.LookupIP(ctxbg, "ip", "mail.mox.example.")
+	tcheck(t, err, "lookup ip")
+	_, _, err = c.LookupIP(ctxbg, "ip", "mail.mox.example.")
+	tcheck(t, err, "lookup ip")
+	tcompare(t, n, 9)
+	_, _, err = c.LookupIP(ctxbg, "ip4", "mail.mox.example.")
+	tcheck(t, err, "lookup ip")
+	tcompare(t, n, 10)
+	d = expires("ip ip mail.mox.example.")
+	tcompare(t, d > time.Minute && d <= 2*time.Minute, true)
+
+	// Expired entries are looked up again.
+	c.Lock()
+	for k, e := range c.entries {
+		e.expires = time.Now().Add(-time.Second)
+		c.entries[k] = e
+	}
+	c.Unlock()
+	lookupMX("mox.example.", 11, false)
+	lookupMX("absent.example.", 12, true)
+}
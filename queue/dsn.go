@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"os"
@@ -65,7 +66,6 @@ func failMsgsDB(qlog mlog.Log, msgs []*Msg, dialedIPs map[string][]net.IP, backo
 func failMsgsTx(qlog mlog.Log, tx *bstore.Tx, msgs []*Msg, dialedIPs map[string][]net.IP, backoff time.Duration, remoteMTA dsn.NameIP, err error) {
 	// todo future: when we implement relaying, we should be able to send DSNs to non-local users. and possibly specify a null mailfrom. ../rfc/5321:1503
 	// todo future: when we implement relaying, and a dsn cannot be delivered, and requiretls was active, we cannot drop the message. instead deliver to local postmaster? though ../rfc/8689:383 may intend to say the dsn should be delivered without requiretls?
-	// todo future: when we implement smtp dsn extension, parameter RET=FULL must be disregarded for messages with REQUIRETLS. ../rfc/8689:379
 
 	m0 := msgs[0]
 
@@ -91,8 +91,10 @@ func failMsgsTx(qlog mlog.Log, tx *bstore.Tx, msgs []*Msg, dialedIPs map[string]
 	)
 
 	ids := make([]int64, len(msgs))
+	msgsByID := map[int64]*Msg{}
 	for i, m := range msgs {
 		ids[i] = m.ID
+		msgsByID[m.ID] = m
 	}
 
 	if permanent || m0.MaxAttempts == 0 && m0.Attempts >= 8 || m0.MaxAttempts > 0 && m0.Attempts >= m0.MaxAttempts {
@@ -115,8 +117,9 @@ func failMsgsTx(qlog mlog.Log, tx *bstore.Tx, msgs []*Msg, dialedIPs map[string]
 			rmsgs[i] = rm
 
 			// If this was an smtp error from remote, we'll pass the failure to the
-			// suppression list.
-			if code == 0 {
+			// suppression list. Except for forwarded messages, the recipient is not one the
+			// account sends messages to itself.
+			if code == 0 || rm.IsForward {
 				continue
 			}
 			sc := suppressionCheck{
@@ -180,14 +183,21 @@ func failMsgsTx(qlog mlog.Log, tx *bstore.Tx, msgs []*Msg, dialedIPs map[string]
 			// All messages should have the same DialedIPs.
 			um.DialedIPs = dialedIPs
 			um.markResult(code, secodeOpt, errmsg, false)
-			if err := tx.Update(&um); err != nil {
+			// The hosts tried during this attempt are only recorded in our copy of the message.
+			if m := msgsByID[um.ID]; m != nil && len(m.Results) > 0 {
+				um.Results[len(um.Results)-1].Hosts = m.Results[len(m.Results)-1].Hosts
+			}
+			if err := journalAdd(tx, &um); err != nil {
+				return err
+			}
+			if err := attemptUpdate(tx, um); err != nil {
 				return fmt.Errorf("updating message after temporary failure to deliver: %v", err)
 			}
 		}
 
 		// If configured, we'll queue webhooks for delivery.
 		accConf, ok := mox.Conf.Account(m0.SenderAccount)
-		if !(ok && accConf.OutgoingWebhook != nil && (len(accConf.OutgoingWebhook.Events) == 0 || slices.Contains(accConf.OutgoingWebhook.Events, string(webhook.EventDelayed)))) {
+		if m0.IsForward || !(ok && accConf.OutgoingWebhook != nil && (len(accConf.OutgoingWebhook.Events) == 0 || slices.Contains(accConf.OutgoingWebhook.Events, string(webhook.EventDelayed)))) {
 			return nil
 		}
 
@@ -214,6 +224,11 @@ func failMsgsTx(qlog mlog.Log, tx *bstore.Tx, msgs []*Msg, dialedIPs map[string]
 }
 
 func deliverDSNFailure(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg string, smtpLines []string) {
+	if !m.dsnNotify("FAILURE") {
+		log.Debug("not delivering dsn for failure, not requested by sender", slog.String("notify", m.DSNNotify))
+		return
+	}
+
 	const subject = "mail delivery failed"
 	message := fmt.Sprintf(`
 Delivery has failed permanently for your email to:
@@ -230,7 +245,7 @@ Error during the last delivery attempt:
 		message += "\nFull SMTP response:\n\n\t" + strings.Join(smtpLines, "\n\t") + "\n"
 	}
 
-	deliverDSN(log, m, remoteMTA, secodeOpt, errmsg, smtpLines, true, nil, subject, message)
+	deliverDSN(log, m, remoteMTA, secodeOpt, errmsg, smtpLines, dsn.Failed, nil, subject, message)
 }
 
 func deliverDSNDelay(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg string, smtpLines []string, retryUntil time.Time) {
@@ -239,6 +254,10 @@ func deliverDSNDelay(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errms
 	if m.IsDMARCReport {
 		return
 	}
+	if !m.dsnNotify("DELAY") {
+		log.Debug("not delivering dsn for delayed delivery, not requested by sender", slog.String("notify", m.DSNNotify))
+		return
+	}
 
 	const subject = "mail delivery delayed"
 	message := fmt.Sprintf(`
@@ -257,18 +276,37 @@ Error during the last delivery attempt:
 		message += "\nFull SMTP response:\n\n\t" + strings.Join(smtpLines, "\n\t") + "\n"
 	}
 
-	deliverDSN(log, m, remoteMTA, secodeOpt, errmsg, smtpLines, false, &retryUntil, subject, message)
+	deliverDSN(log, m, remoteMTA, secodeOpt, errmsg, smtpLines, dsn.Delayed, &retryUntil, subject, message)
+}
+
+// deliverDSNRelayed delivers a DSN for a successful delivery if requested through
+// the NOTIFY parameter of the SMTP DSN extension, and the next hop did not accept
+// the DSN parameters, so it won't send a DSN for the final delivery.
+// ../rfc/3461
+func deliverDSNRelayed(log mlog.Log, m Msg, remoteMTA dsn.NameIP) {
+	if !m.dsnNotify("SUCCESS") {
+		return
+	}
+
+	const subject = "mail relayed"
+	message := fmt.Sprintf(`
+Your email to:
+
+	%s
+
+has been delivered to the next mail server. That server does not support delivery
+status notifications, you will not be notified of final delivery.
+`, m.Recipient().XString(m.SMTPUTF8))
+
+	deliverDSN(log, m, remoteMTA, "", "", nil, dsn.Relayed, nil, subject, message)
 }
 
-// We only queue DSNs for delivery failures for emails submitted by authenticated
+// We only queue DSNs for emails submitted by authenticated
 // users. So we are delivering to local users. ../rfc/5321:1466
 // ../rfc/5321:1494
 // ../rfc/7208:490
-func deliverDSN(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg string, smtpLines []string, permanent bool, retryUntil *time.Time, subject, textBody string) {
-	kind := "delayed delivery"
-	if permanent {
-		kind = "failure"
-	}
+func deliverDSN(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg string, smtpLines []string, action dsn.Action, retryUntil *time.Time, subject, textBody string) {
+	kind := string(action)
 
 	qlog := func(text string, err error) {
 		log.Errorx("queue dsn: "+text+": sender will not be informed about dsn", err, slog.String("sender", m.Sender().XString(m.SMTPUTF8)), slog.String("kind", kind))
@@ -284,20 +322,34 @@ func deliverDSN(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg str
 		err := msgr.Close()
 		log.Check(err, "closing message reader after queuing dsn")
 	}()
-	headers, err := message.ReadHeaders(bufio.NewReader(msgr))
-	if err != nil {
-		qlog("reading headers of queued message", err)
-		return
+
+	// Return the full message for failures if requested through the RET parameter of
+	// the SMTP DSN extension, but not for messages with REQUIRETLS, and not for large
+	// messages. ../rfc/3461 ../rfc/8689:379
+	var original []byte
+	full := action == dsn.Failed && m.DSNRet == "FULL" && (m.RequireTLS == nil || !*m.RequireTLS) && m.Size <= 100*1024
+	if full {
+		original, err = io.ReadAll(msgr)
+		if err != nil {
+			qlog("reading queued message", err)
+			return
+		}
+	} else {
+		original, err = message.ReadHeaders(bufio.NewReader(msgr))
+		if err != nil {
+			qlog("reading headers of queued message", err)
+			return
+		}
 	}
 
-	var action dsn.Action
 	var status string
-	if permanent {
+	switch action {
+	case dsn.Failed:
 		status = "5."
-		action = dsn.Failed
-	} else {
-		action = dsn.Delayed
+	case dsn.Delayed:
 		status = "4."
+	default:
+		status = "2."
 	}
 	if secodeOpt != "" {
 		status += secodeOpt
@@ -311,6 +363,15 @@ func deliverDSN(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg str
 		smtpDiag = strings.Join(smtpLines, " ")
 	}
 
+	var origRcpt smtp.Path
+	if m.DSNOrigRecipient != "" {
+		if addr, err := smtp.ParseAddress(m.DSNOrigRecipient); err != nil {
+			log.Debugx("parsing original recipient for dsn, not including", err, slog.String("orcpt", m.DSNOrigRecipient))
+		} else {
+			origRcpt = addr.Path()
+		}
+	}
+
 	dsnMsg := &dsn.Message{
 		SMTPUTF8:   m.SMTPUTF8,
 		From:       smtp.Path{Localpart: "postmaster", IPDomain: dns.IPDomain{Domain: mox.Conf.Static.HostnameDomain}},
@@ -320,6 +381,7 @@ func deliverDSN(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg str
 		References: m.MessageID,
 		TextBody:   textBody,
 
+		OriginalEnvelopeID:   m.DSNEnvID,
 		ReportingMTA:         mox.Conf.Static.HostnameDomain.ASCII,
 		ArrivalDate:          m.Queued,
 		FutureReleaseRequest: m.FutureReleaseRequest,
@@ -327,6 +389,7 @@ func deliverDSN(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg str
 		Recipients: []dsn.Recipient{
 			{
 				FinalRecipient:     m.Recipient(),
+				OriginalRecipient:  origRcpt,
 				Action:             action,
 				Status:             status,
 				StatusComment:      errmsg,
@@ -337,7 +400,8 @@ func deliverDSN(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg str
 			},
 		},
 
-		Original: headers,
+		Original:     original,
+		OriginalFull: full,
 	}
 	msgData, err := dsnMsg.Compose(log, m.SMTPUTF8)
 	if err != nil {
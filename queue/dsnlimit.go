@@ -0,0 +1,104 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/smtp"
+)
+
+// DSNs we queue for delivery to remote senders (messages with a null reverse
+// path) are limited, to prevent us from participating in backscatter when
+// spammers forge sender addresses: A DSN is not queued if a DSN for the same
+// recipient is still in the queue, or if too many DSNs were recently queued for
+// the recipient address or domain. The recent counts are kept in memory only.
+
+var metricDSNSuppressed = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_queue_dsn_suppressed_total",
+		Help: "DSNs to remote senders not queued, by reason.",
+	},
+	[]string{
+		"reason", // "duplicate", "ratelimit"
+	},
+)
+
+// ErrDSNSuppressed is returned by Add when a DSN is not queued because a DSN for
+// the recipient is already in the queue, or because of rate limits.
+var ErrDSNSuppressed = errors.New("dsn suppressed")
+
+const (
+	dsnLimitWindow  = time.Hour
+	dsnLimitAddress = 5  // Per recipient address, per window.
+	dsnLimitDomain  = 50 // Per recipient domain, per window.
+)
+
+var dsnLimits = struct {
+	sync.Mutex
+	addresses map[string][]time.Time
+	domains   map[string][]time.Time
+}{addresses: map[string][]time.Time{}, domains: map[string][]time.Time{}}
+
+// dsnLimitsReset clears the recent DSN counts.
+func dsnLimitsReset() {
+	dsnLimits.Lock()
+	defer dsnLimits.Unlock()
+	dsnLimits.addresses = map[string][]time.Time{}
+	dsnLimits.domains = map[string][]time.Time{}
+}
+
+// dsnCheck returns ErrDSNSuppressed if DSN m should not be added to the queue. If
+// it can be added, it is counted for the rate limits.
+func dsnCheck(tx *bstore.Tx, m Msg, now time.Time) error {
+	q := bstore.QueryTx[Msg](tx)
+	q.FilterNonzero(Msg{RecipientLocalpart: m.RecipientLocalpart, RecipientDomainStr: m.RecipientDomainStr})
+	q.FilterEqual("SenderLocalpart", smtp.Localpart(""))
+	q.FilterEqual("SenderDomainStr", "")
+	if exists, err := q.Exists(); err != nil {
+		return fmt.Errorf("looking up dsns in queue: %v", err)
+	} else if exists {
+		metricDSNSuppressed.WithLabelValues("duplicate").Inc()
+		return fmt.Errorf("%w: dsn for recipient %s already in queue", ErrDSNSuppressed, m.Recipient())
+	}
+
+	dsnLimits.Lock()
+	defer dsnLimits.Unlock()
+
+	recent := func(l []time.Time) []time.Time {
+		for len(l) > 0 && now.Sub(l[0]) >= dsnLimitWindow {
+			l = l[1:]
+		}
+		return l
+	}
+	// Prevent unbounded growth when DSNs go to many different addresses.
+	for _, tm := range []map[string][]time.Time{dsnLimits.addresses, dsnLimits.domains} {
+		if len(tm) < 1000 {
+			continue
+		}
+		for k, l := range tm {
+			if now.Sub(l[len(l)-1]) >= dsnLimitWindow {
+				delete(tm, k)
+			}
+		}
+	}
+
+	addr := m.Recipient().String()
+	addrTimes := recent(dsnLimits.addresses[addr])
+	domTimes := recent(dsnLimits.domains[m.RecipientDomainStr])
+	if len(addrTimes) >= dsnLimitAddress || len(domTimes) >= dsnLimitDomain {
+		dsnLimits.addresses[addr] = addrTimes
+		dsnLimits.domains[m.RecipientDomainStr] = domTimes
+		metricDSNSuppressed.WithLabelValues("ratelimit").Inc()
+		return fmt.Errorf("%w: too many recent dsns for recipient %s", ErrDSNSuppressed, m.Recipient())
+	}
+	dsnLimits.addresses[addr] = append(addrTimes, now)
+	dsnLimits.domains[m.RecipientDomainStr] = append(domTimes, now)
+	return nil
+}
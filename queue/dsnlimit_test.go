@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+)
+
+func TestDSNLimit(t *testing.T) {
+	_, cleanup := setup(t)
+	defer cleanup()
+
+	add := func(localpart, domain string) error {
+		t.Helper()
+		rcpt := smtp.Path{Localpart: smtp.Localpart(localpart), IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: domain}}}
+		mf := prepareFile(t)
+		defer os.Remove(mf.Name())
+		defer mf.Close()
+		qm := MakeMsg(smtp.Path{}, rcpt, false, false, int64(len(testmsg)), "<test@localhost>", nil, nil, time.Now(), "test")
+		return Add(ctxbg, pkglog, "mjl", mf, qm)
+	}
+	drop := func() {
+		t.Helper()
+		_, err := Drop(ctxbg, pkglog, Filter{})
+		tcheck(t, err, "drop messages")
+	}
+
+	// Second DSN to same recipient is collapsed while first is in queue.
+	err := add("a", "remote.example")
+	tcheck(t, err, "add dsn")
+	err = add("a", "remote.example")
+	if !errors.Is(err, ErrDSNSuppressed) {
+		t.Fatalf("got err %v, expected ErrDSNSuppressed", err)
+	}
+	err = add("b", "remote.example")
+	tcheck(t, err, "add dsn for other recipient")
+	drop()
+
+	// Rate limit per address.
+	for range dsnLimitAddress - 1 {
+		err := add("a", "remote.example")
+		tcheck(t, err, "add dsn")
+		drop()
+	}
+	err = add("a", "remote.example")
+	if !errors.Is(err, ErrDSNSuppressed) {
+		t.Fatalf("got err %v, expected ErrDSNSuppressed", err)
+	}
+
+	// Rate limit per domain.
+	for i := range dsnLimitDomain - dsnLimitAddress - 1 {
+		err := add(fmt.Sprintf("x%d", i), "remote.example")
+		tcheck(t, err, "add dsn")
+	}
+	err = add("y", "remote.example")
+	if !errors.Is(err, ErrDSNSuppressed) {
+		t.Fatalf("got err %v, expected ErrDSNSuppressed", err)
+	}
+	err = add("y", "other.example")
+	tcheck(t, err, "add dsn for other domain")
+
+	// Limits expire.
+	dsnLimits.Lock()
+	for _, l := range dsnLimits.domains {
+		for i := range l {
+			l[i] = l[i].Add(-dsnLimitWindow)
+		}
+	}
+	for _, l := range dsnLimits.addresses {
+		for i := range l {
+			l[i] = l[i].Add(-dsnLimitWindow)
+		}
+	}
+	dsnLimits.Unlock()
+	err = add("a", "remote.example")
+	tcheck(t, err, "add dsn after window")
+}
@@ -0,0 +1,171 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+// Delivery attempts are recorded in an append-only journal of MsgAttempt records,
+// separate from the Msg itself. Each finished attempt is inserted once and never
+// updated. Msg.Results only holds the most recent results (for display and for
+// the in-progress attempt), so the frequently rewritten Msg records don't grow
+// with each attempt. A delivery attempt only updates the attempt fields of a Msg,
+// see attemptUpdate. A retired message keeps only the last result, its history
+// stays in the journal under the same ID until the retired message is removed.
+// Journal entries are compacted by the periodic cleanup.
+
+// Maximum number of results kept in Msg.Results. Older results are only in the
+// journal.
+const msgResultsMax = 3
+
+// MsgAttempt is a journal entry for a single finished delivery attempt of a
+// message in the queue.
+type MsgAttempt struct {
+	ID      int64
+	MsgID   int64 `bstore:"nonzero,index MsgID+Attempt"` // Msg.ID, or MsgRetired.ID after retiring.
+	Attempt int   // Attempt number, starting at 1.
+	MsgResult
+
+	// Zero while the message is in the queue. Set when the message is retired, and
+	// the entry is removed after this time.
+	KeepUntil time.Time `bstore:"index"`
+}
+
+// journalAdd adds the last result of m to the journal, if it is a finished
+// attempt, and trims m.Results to the most recent results. Messages queued before
+// the journal existed have older results that are not in the journal yet, they
+// are added first, so they are not lost by trimming. Callers must store the
+// (modified) m in the database.
+func journalAdd(tx *bstore.Tx, m *Msg) error {
+	if len(m.Results) == 0 {
+		return nil
+	}
+	r := m.Results[len(m.Results)-1]
+	if r.Error == resultErrorDelivering {
+		return nil
+	}
+
+	// Until results are trimmed, the journal has an entry for each earlier result.
+	n, err := bstore.QueryTx[MsgAttempt](tx).FilterNonzero(MsgAttempt{MsgID: m.ID}).Count()
+	if err != nil {
+		return fmt.Errorf("counting delivery attempts in journal: %v", err)
+	}
+	older := m.Results[:len(m.Results)-1]
+	if n < len(older) {
+		for i, xr := range older[:len(older)-n] {
+			ma := MsgAttempt{MsgID: m.ID, Attempt: max(1, m.Attempts-len(older)+i), MsgResult: xr}
+			if err := tx.Insert(&ma); err != nil {
+				return fmt.Errorf("adding earlier delivery attempt to journal: %v", err)
+			}
+		}
+	}
+
+	ma := MsgAttempt{MsgID: m.ID, Attempt: m.Attempts, MsgResult: r}
+	if err := tx.Insert(&ma); err != nil {
+		return fmt.Errorf("adding delivery attempt to journal: %v", err)
+	}
+	if len(m.Results) > msgResultsMax {
+		m.Results = append([]MsgResult{}, m.Results[len(m.Results)-msgResultsMax:]...)
+	}
+	return nil
+}
+
+// attemptUpdate stores the fields of m that change with a delivery attempt.
+func attemptUpdate(tx *bstore.Tx, m Msg) error {
+	q := bstore.QueryTx[Msg](tx)
+	q.FilterID(m.ID)
+	_, err := q.UpdateFields(map[string]any{
+		"Attempts":    m.Attempts,
+		"LastAttempt": m.LastAttempt,
+		"NextAttempt": m.NextAttempt,
+		"DialedIPs":   m.DialedIPs,
+		"Results":     m.Results,
+	})
+	return err
+}
+
+// lastResults returns a slice with only the last of results, or nil if there are
+// none.
+func lastResults(results []MsgResult) []MsgResult {
+	if len(results) == 0 {
+		return nil
+	}
+	return []MsgResult{results[len(results)-1]}
+}
+
+// journalRetire adds the final result of m to the journal. If keepUntil is zero,
+// the journal entries for m are removed, otherwise they are marked for removal at
+// keepUntil, along with the retired message.
+func journalRetire(tx *bstore.Tx, m *Msg, keepUntil time.Time) error {
+	if err := journalAdd(tx, m); err != nil {
+		return err
+	}
+	q := bstore.QueryTx[MsgAttempt](tx)
+	q.FilterNonzero(MsgAttempt{MsgID: m.ID})
+	var err error
+	if keepUntil.IsZero() {
+		_, err = q.Delete()
+	} else {
+		_, err = q.UpdateField("KeepUntil", keepUntil)
+	}
+	if err != nil {
+		return fmt.Errorf("updating delivery attempts in journal for retired message: %v", err)
+	}
+	return nil
+}
+
+// AttemptList returns the journal of delivery attempts for a message in the queue
+// or retired message, ordered by attempt.
+func AttemptList(ctx context.Context, msgID int64) ([]MsgAttempt, error) {
+	q := bstore.QueryDB[MsgAttempt](ctx, DB)
+	q.FilterNonzero(MsgAttempt{MsgID: msgID})
+	q.SortAsc("Attempt", "ID")
+	return q.List()
+}
+
+// journalCompact removes expired journal entries of retired messages, and entries
+// for messages that are no longer in the queue and not kept as retired message,
+// e.g. after a crash between updates.
+func journalCompact(ctx context.Context, log mlog.Log) {
+	n, err := bstore.QueryDB[MsgAttempt](ctx, DB).FilterNotEqual("KeepUntil", time.Time{}).FilterLess("KeepUntil", time.Now()).Delete()
+	log.Check(err, "removing expired delivery attempts from journal")
+
+	var orphans int
+	err = DB.Write(ctx, func(tx *bstore.Tx) error {
+		seen := map[int64]bool{}
+		q := bstore.QueryTx[MsgAttempt](tx)
+		q.FilterEqual("KeepUntil", time.Time{})
+		var ids []int64
+		err := q.ForEach(func(ma MsgAttempt) error {
+			exists, ok := seen[ma.MsgID]
+			if !ok {
+				var err error
+				exists, err = bstore.QueryTx[Msg](tx).FilterID(ma.MsgID).Exists()
+				if err != nil {
+					return err
+				}
+				seen[ma.MsgID] = exists
+			}
+			if !exists {
+				ids = append(ids, ma.ID)
+			}
+			return nil
+		})
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		orphans, err = bstore.QueryTx[MsgAttempt](tx).FilterIDs(ids).Delete()
+		return err
+	})
+	log.Check(err, "removing orphaned delivery attempts from journal")
+
+	if n > 0 || orphans > 0 {
+		log.Debug("compacted delivery attempt journal", slog.Int("expired", n), slog.Int("orphaned", orphans))
+	}
+}
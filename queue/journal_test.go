@@ -0,0 +1,132 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/webhook"
+)
+
+func TestJournal(t *testing.T) {
+	_, cleanup := setup(t)
+	defer cleanup()
+
+	path := smtp.Path{Localpart: "mjl", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "mox.example"}}}
+	mf := prepareFile(t)
+	defer os.Remove(mf.Name())
+	defer mf.Close()
+
+	qm := MakeMsg(path, path, false, false, int64(len(testmsg)), "<test@localhost>", nil, nil, time.Now(), "test")
+	// Account "retired" keeps retired messages.
+	err := Add(ctxbg, pkglog, "retired", mf, qm)
+	tcheck(t, err, "add message to queue")
+	msgs, err := List(ctxbg, Filter{}, Sort{})
+	tcheck(t, err, "list queue")
+	tcompare(t, len(msgs), 1)
+	m := msgs[0]
+
+	// Simulate failed attempts, each adding to the journal.
+	for i := range 5 {
+		err := DB.Write(ctxbg, func(tx *bstore.Tx) error {
+			m.Attempts++
+			m.Results = append(m.Results, MsgResult{Start: time.Now(), Error: resultErrorDelivering})
+			m.markResult(451, "", fmt.Sprintf("failure %d", i), false)
+			if err := journalAdd(tx, &m); err != nil {
+				return err
+			}
+			return attemptUpdate(tx, m)
+		})
+		tcheck(t, err, "marking attempt")
+	}
+	tcompare(t, len(m.Results), msgResultsMax)
+	l, err := AttemptList(ctxbg, m.ID)
+	tcheck(t, err, "list attempts")
+	tcompare(t, len(l), 5)
+	tcompare(t, l[0].Attempt, 1)
+	tcompare(t, l[0].Error, "failure 0")
+
+	// Compaction keeps the journal of messages still in the queue.
+	journalCompact(ctxbg, pkglog)
+	l, err = AttemptList(ctxbg, m.ID)
+	tcheck(t, err, "list attempts")
+	tcompare(t, len(l), 5)
+
+	// When retiring, the retired message only has the last result, the full history
+	// stays in the journal.
+	m.markResult(250, "", "", true)
+	err = DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		return retireMsgs(pkglog, tx, webhook.EventDelivered, 0, "", nil, m)
+	})
+	tcheck(t, err, "retire message")
+	err = removeMsgsFS(pkglog, m)
+	tcheck(t, err, "remove message file")
+	mr := MsgRetired{ID: m.ID}
+	err = DB.Get(ctxbg, &mr)
+	tcheck(t, err, "get retired message")
+	tcompare(t, len(mr.Results), 1)
+	tcompare(t, mr.Results[0].Success, true)
+
+	l, err = AttemptList(ctxbg, m.ID)
+	tcheck(t, err, "list attempts")
+	tcompare(t, len(l), 6)
+	tcompare(t, l[0].KeepUntil.Equal(mr.KeepUntil), true)
+
+	// Expired journal entries are removed.
+	_, err = bstore.QueryDB[MsgAttempt](ctxbg, DB).UpdateField("KeepUntil", time.Now().Add(-time.Minute))
+	tcheck(t, err, "expire journal entries")
+	journalCompact(ctxbg, pkglog)
+	l, err = AttemptList(ctxbg, m.ID)
+	tcheck(t, err, "list attempts")
+	tcompare(t, len(l), 0)
+
+	// Message queued before the journal existed has all its results in the Msg. They
+	// end up in the journal, not only the most recent.
+	qm = MakeMsg(path, path, false, false, int64(len(testmsg)), "<test@localhost>", nil, nil, time.Now(), "test")
+	err = Add(ctxbg, pkglog, "retired", mf, qm)
+	tcheck(t, err, "add message to queue")
+	msgs, err = List(ctxbg, Filter{}, Sort{})
+	tcheck(t, err, "list queue")
+	tcompare(t, len(msgs), 1)
+	m = msgs[0]
+	for i := range 5 {
+		m.Attempts++
+		m.Results = append(m.Results, MsgResult{Start: time.Now(), Code: 451, Error: fmt.Sprintf("old failure %d", i)})
+	}
+	err = DB.Update(ctxbg, &m)
+	tcheck(t, err, "update message")
+	m.Attempts++
+	m.Results = append(m.Results, MsgResult{Start: time.Now(), Error: resultErrorDelivering})
+	m.markResult(250, "", "", true)
+	err = DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		return retireMsgs(pkglog, tx, webhook.EventDelivered, 0, "", nil, m)
+	})
+	tcheck(t, err, "retire message")
+	err = removeMsgsFS(pkglog, m)
+	tcheck(t, err, "remove message file")
+	mr = MsgRetired{ID: m.ID}
+	err = DB.Get(ctxbg, &mr)
+	tcheck(t, err, "get retired message")
+	tcompare(t, len(mr.Results), 1)
+	l, err = AttemptList(ctxbg, m.ID)
+	tcheck(t, err, "list attempts")
+	tcompare(t, len(l), 6)
+	tcompare(t, l[0].Attempt, 1)
+	tcompare(t, l[0].Error, "old failure 0")
+	tcompare(t, l[5].Attempt, 6)
+	_, err = bstore.QueryDB[MsgAttempt](ctxbg, DB).Delete()
+	tcheck(t, err, "remove journal entries")
+
+	// Orphaned entries, for messages no longer in the queue, are removed too.
+	err = DB.Insert(ctxbg, &MsgAttempt{MsgID: m.ID + 1000, Attempt: 1})
+	tcheck(t, err, "insert orphaned attempt")
+	journalCompact(ctxbg, pkglog)
+	n, err := bstore.QueryDB[MsgAttempt](ctxbg, DB).Count()
+	tcheck(t, err, "count journal")
+	tcompare(t, n, 0)
+}
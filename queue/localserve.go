@@ -96,7 +96,7 @@ func deliverLocalserve(ctx context.Context, log mlog.Log, msgs []*Msg, backoff t
 	for i, m := range msgs {
 		rcpts[i] = m.Recipient().String()
 	}
-	rcptErrs, err := client.DeliverMultiple(deliverctx, m0.Sender().String(), rcpts, size, msgr, m0.Has8bit, m0.SMTPUTF8, requireTLS)
+	rcptErrs, err := client.DeliverMultipleDSN(deliverctx, m0.Sender().String(), rcpts, size, msgr, m0.Has8bit, m0.SMTPUTF8, requireTLS, dsnParams(msgs))
 	delivercancel()
 	if err != nil {
 		log.Infox("smtp transaction for delivery failed", err)
@@ -108,5 +108,5 @@ func deliverLocalserve(ctx context.Context, log mlog.Log, msgs []*Msg, backoff t
 	log.Check(cerr, "closing message after delivery attempt")
 	msgr = nil
 
-	processDeliveries(log, m0, msgs, addr, "localhost", backoff, rcptErrs, err)
+	processDeliveries(log, m0, msgs, addr, "localhost", backoff, rcptErrs, err, client.SupportsDSN())
 }
@@ -16,6 +16,7 @@ import (
 	"runtime/debug"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/proxy"
@@ -69,6 +70,16 @@ var (
 			"result",    // ok, timeout, canceled, temperror, permerror, error
 		},
 	)
+	metricTenantMessages = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_queue_tenant_messages_total",
+			Help: "Outgoing messages of accounts of tenants, added to the queue and removed from the queue, per tenant.",
+		},
+		[]string{
+			"tenant",
+			"event", // "submitted", or a webhook outgoing event like "delivered", "failed", "canceled", "suppressed".
+		},
+	)
 	metricHold = promauto.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "mox_queue_hold",
@@ -79,8 +90,8 @@ var (
 
 var jitter = mox.NewPseudoRand()
 
-var DBTypes = []any{Msg{}, HoldRule{}, MsgRetired{}, webapi.Suppression{}, Hook{}, HookRetired{}} // Types stored in DB.
-var DB *bstore.DB                                                                                 // Exported for making backups.
+var DBTypes = []any{Msg{}, HoldRule{}, MsgRetired{}, webapi.Suppression{}, Hook{}, HookRetired{}, MsgAttempt{}} // Types stored in DB.
+var DB *bstore.DB                                                                                               // Exported for making backups.
 
 // Allow requesting delivery starting from up to this interval from time of submission.
 const FutureReleaseIntervalMax = 60 * 24 * time.Hour
@@ -125,6 +136,7 @@ type Msg struct {
 
 	Queued             time.Time      `bstore:"default now"`
 	Hold               bool           // If set, delivery won't be attempted.
+	HoldReason         string         // If set, the message was automatically put on hold for review by the admin, e.g. because the sender reached a sending limit. Cleared when the message is taken off hold.
 	SenderAccount      string         // Failures are delivered back to this local account. Also used for routing.
 	SenderLocalpart    smtp.Localpart // Should be a local user and domain.
 	SenderDomain       dns.IPDomain
@@ -138,12 +150,19 @@ type Msg struct {
 	DialedIPs          map[string][]net.IP // For each host, the IPs that were dialed. Used for IP selection for later attempts.
 	NextAttempt        time.Time           // For scheduling.
 	LastAttempt        *time.Time
-	Results            []MsgResult
+	Results            []MsgResult // Most recent results only, the full history is in the journal, see AttemptList.
+
+	// Priority for delivery, from -9 (lowest) to 9 (highest), 0 is normal. When the
+	// number of concurrent deliveries is limited, messages with higher priority are
+	// delivered first. Set from the SMTP MT-PRIORITY extension or the account default.
+	Priority int
+	// ../rfc/6710
 
 	Has8bit       bool   // Whether message contains bytes with high bit set, determines whether 8BITMIME SMTP extension is needed.
 	SMTPUTF8      bool   // Whether message requires use of SMTPUTF8.
 	IsDMARCReport bool   // Delivery failures for DMARC reports are handled differently.
 	IsTLSReport   bool   // Delivery failures for TLS reports are handled differently.
+	IsForward     bool   // Incoming message forwarded to another address. Delivery failures don't add the recipient to the suppression list of the account, and no webhooks are sent.
 	Size          int64  // Full size of message, combined MsgPrefix with contents of message file.
 	MessageID     string // Message-ID header, including <>. Used when composing a DSN, in its References header.
 	MsgPrefix     []byte // Data to send before the contents from the file, typically with headers like DKIM-Signature.
@@ -174,12 +193,27 @@ type Msg struct {
 	RequireTLS *bool
 	// ../rfc/8689:250
 
+	// If set, direct delivery requires STARTTLS with a verified certificate, through
+	// DANE or otherwise PKIX/WebPKI verification of the MX host name, also when the
+	// recipient domain has no MTA-STS or DANE policy. A "TLS-Required: No" header is
+	// ignored. Can be set during submission through the webapi, and with "mox queue
+	// requireverifiedtls". Can also be enabled per destination domain with a route to
+	// a direct transport with RequireVerifiedTLS.
+	RequireVerifiedTLS bool
+
 	// For DSNs, where the original FUTURERELEASE value must be included as per-message
 	// field. This field should be of the form "for;" plus interval, or "until;" plus
 	// utc date-time.
 	FutureReleaseRequest string
 	// ../rfc/4865:305
 
+	// Parameters from the SMTP DSN extension, for messages submitted over SMTP.
+	// ../rfc/3461
+	DSNNotify        string // "NEVER", or comma-separated "SUCCESS", "FAILURE" and/or "DELAY". If empty, DSNs are sent for failures and delays.
+	DSNRet           string // "FULL" or "HDRS", whether to include the full original message or only its headers in failure DSNs. If empty, only headers are included.
+	DSNEnvID         string // Envelope identifier, included in DSNs and passed on to the next hop.
+	DSNOrigRecipient string // Original recipient address (ORCPT), included in DSNs and passed on to the next hop.
+
 	Extra map[string]string // Extra information, for transactional email.
 }
 
@@ -191,9 +225,27 @@ type MsgResult struct {
 	Code     int
 	Secode   string
 	Error    string
+	Hosts    []MsgResultHost // Hosts tried during a direct delivery attempt, in order.
 	// todo: store smtp trace for failed deliveries for debugging, perhaps also for successful deliveries.
 }
 
+// MsgResultHost is the result of delivering to a single host, e.g. one of the MX
+// targets, during a delivery attempt. The same host can be tried again without TLS
+// after a TLS failure.
+type MsgResultHost struct {
+	Host        string // Host name or IP address.
+	IP          string // Remote IP connected to, empty if no connection was made.
+	Start       time.Time
+	Duration    time.Duration
+	Success     bool
+	Code        int
+	Secode      string
+	Error       string
+	TLS         bool     // Whether the SMTP connection was protected with TLS.
+	TLSMode     string   // E.g. "opportunistic", "requiredstarttls+mtasts", "requiredstarttls+dane", "skip".
+	TLSFailures []string // TLSRPT result types of TLS failures for the host, e.g. "certificate-expired".
+}
+
 // Stored in MsgResult.Error while delivery is in progress. Replaced after success/error.
 const resultErrorDelivering = "delivering..."
 
@@ -210,6 +262,16 @@ func (m *Msg) markResult(code int, secode string, errmsg string, success bool) {
 	result.Success = success
 }
 
+// addResultHost adds the result of delivering to a host to the delivery attempt
+// in progress.
+func (m *Msg) addResultHost(rh MsgResultHost) {
+	if len(m.Results) == 0 || m.Results[len(m.Results)-1].Error != resultErrorDelivering {
+		return
+	}
+	r := &m.Results[len(m.Results)-1]
+	r.Hosts = append(r.Hosts, rh)
+}
+
 // LastResult returns the last result entry, or an empty result.
 func (m *Msg) LastResult() MsgResult {
 	if len(m.Results) == 0 {
@@ -218,6 +280,49 @@ func (m *Msg) LastResult() MsgResult {
 	return m.Results[len(m.Results)-1]
 }
 
+// dsnNotify returns whether a DSN should be sent for notify, one of "SUCCESS",
+// "FAILURE" or "DELAY", based on the NOTIFY parameter of the SMTP DSN extension.
+// ../rfc/3461
+func (m Msg) dsnNotify(notify string) bool {
+	if m.DSNNotify == "" {
+		return notify != "SUCCESS"
+	}
+	return slices.Contains(strings.Split(m.DSNNotify, ","), notify)
+}
+
+// dsnParams returns the DSN extension parameters for msgs, for passing to the next
+// hop. Returns nil if the messages were not submitted with DSN parameters.
+func dsnParams(msgs []*Msg) *smtpclient.DSNParams {
+	m0 := msgs[0]
+	p := &smtpclient.DSNParams{
+		Ret:        m0.DSNRet,
+		EnvID:      m0.DSNEnvID,
+		Recipients: make([]smtpclient.DSNRecipientParams, len(msgs)),
+	}
+	have := p.Ret != "" || p.EnvID != ""
+	for i, m := range msgs {
+		p.Recipients[i].Notify = m.DSNNotify
+		// We can only pass ascii addresses, with address type "rfc822".
+		if isASCII(m.DSNOrigRecipient) {
+			p.Recipients[i].ORcpt = m.DSNOrigRecipient
+		}
+		have = have || p.Recipients[i] != smtpclient.DSNRecipientParams{}
+	}
+	if !have {
+		return nil
+	}
+	return p
+}
+
+func isASCII(s string) bool {
+	for _, c := range s {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
 // Sender of message as used in MAIL FROM.
 func (m Msg) Sender() smtp.Path {
 	return smtp.Path{Localpart: m.SenderLocalpart, IPDomain: m.SenderDomain}
@@ -252,16 +357,18 @@ func (m Msg) Retired(success bool, t, keepUntil time.Time) MsgRetired {
 		MaxAttempts:          m.MaxAttempts,
 		DialedIPs:            m.DialedIPs,
 		LastAttempt:          m.LastAttempt,
-		Results:              m.Results,
+		Results:              lastResults(m.Results),
 		Has8bit:              m.Has8bit,
 		SMTPUTF8:             m.SMTPUTF8,
 		IsDMARCReport:        m.IsDMARCReport,
 		IsTLSReport:          m.IsTLSReport,
+		IsForward:            m.IsForward,
 		Size:                 m.Size,
 		MessageID:            m.MessageID,
 		Subject:              m.Subject,
 		Transport:            m.Transport,
 		RequireTLS:           m.RequireTLS,
+		RequireVerifiedTLS:   m.RequireVerifiedTLS,
 		FutureReleaseRequest: m.FutureReleaseRequest,
 		Extra:                m.Extra,
 
@@ -291,18 +398,20 @@ type MsgRetired struct {
 	MaxAttempts        int                 // Max number of attempts before giving up. If 0, then the default of 8 attempts is used instead.
 	DialedIPs          map[string][]net.IP // For each host, the IPs that were dialed. Used for IP selection for later attempts.
 	LastAttempt        *time.Time
-	Results            []MsgResult
+	Results            []MsgResult // Last result only, the full history is in the journal, see AttemptList.
 
 	Has8bit       bool   // Whether message contains bytes with high bit set, determines whether 8BITMIME SMTP extension is needed.
 	SMTPUTF8      bool   // Whether message requires use of SMTPUTF8.
 	IsDMARCReport bool   // Delivery failures for DMARC reports are handled differently.
 	IsTLSReport   bool   // Delivery failures for TLS reports are handled differently.
+	IsForward     bool   // Incoming message forwarded to another address.
 	Size          int64  // Full size of message, combined MsgPrefix with contents of message file.
 	MessageID     string // Used when composing a DSN, in its References header.
 	Subject       string // For context about delivery.
 
 	Transport            string
 	RequireTLS           *bool
+	RequireVerifiedTLS   bool
 	FutureReleaseRequest string
 
 	Extra map[string]string // Extra information, for transactional email.
@@ -350,6 +459,9 @@ func Init() error {
 		isNew = true
 	}
 
+	circuitsReset()
+	dsnLimitsReset()
+
 	var err error
 	log := mlog.New("queue", nil)
 	opts := bstore.Options{Timeout: 5 * time.Second, Perm: 0660, RegisterLogger: moxvar.RegisterLogger(qpath, log.Logger)}
@@ -369,6 +481,30 @@ func Init() error {
 	return nil
 }
 
+// MaxHeldForReview is the maximum number of messages of an account held in the
+// queue for review. Beyond this, submissions are refused again, so a compromised
+// account cannot fill up the queue.
+const MaxHeldForReview = 1000
+
+// HoldForReview returns whether a message submitted by the account while a sending
+// limit is reached should be accepted and held in the queue for review by the
+// admin, instead of being refused. Only for accounts with HoldOverSendLimits, and
+// fewer than MaxHeldForReview messages held for review.
+func HoldForReview(ctx context.Context, accountName string) (bool, error) {
+	accConf, ok := mox.Conf.Account(accountName)
+	if !ok || !accConf.HoldOverSendLimits {
+		return false, nil
+	}
+	q := bstore.QueryDB[Msg](ctx, DB)
+	q.FilterNonzero(Msg{SenderAccount: accountName, Hold: true})
+	q.FilterNotEqual("HoldReason", "")
+	n, err := q.Count()
+	if err != nil {
+		return false, fmt.Errorf("counting messages held for review: %v", err)
+	}
+	return n < MaxHeldForReview, nil
+}
+
 // When we update the gauge, we just get the full current value, not try to account
 // for adds/removes.
 func metricHoldUpdate(tx *bstore.Tx) error {
@@ -686,6 +822,13 @@ func Add(ctx context.Context, log mlog.Log, senderAccount string, msgFile *os.Fi
 			}
 		}
 
+		// DSNs to remote senders are collapsed and rate limited.
+		if qml[i].Sender().IsZero() {
+			if err := dsnCheck(tx, qml[i], time.Now()); err != nil {
+				return err
+			}
+		}
+
 		qml[i].SenderAccount = senderAccount
 		qml[i].BaseID = baseID
 		for _, hr := range holdRules {
@@ -752,6 +895,10 @@ func Add(ctx context.Context, log mlog.Log, senderAccount string, msgFile *os.Fi
 	tx = nil
 	paths = nil
 
+	if tenant, _, ok := mox.Conf.AccountTenant(senderAccount); ok {
+		metricTenantMessages.WithLabelValues(tenant, "submitted").Add(float64(len(qml)))
+	}
+
 	msgqueueKick()
 
 	return nil
@@ -767,6 +914,9 @@ func formatIPDomain(d dns.IPDomain) string {
 var (
 	msgqueue        = make(chan struct{}, 1)
 	deliveryResults = make(chan string, 1)
+
+	// Number of deliveries in progress, for Pending.
+	deliveriesActive atomic.Int64
 )
 
 func kick() {
@@ -824,14 +974,39 @@ func NextAttemptSet(ctx context.Context, filter Filter, t time.Time) (affected i
 	return n, nil
 }
 
-// HoldSet sets Hold for all matching messages and kicks the queue.
+// Flush schedules all messages that are not on hold for immediate delivery, and
+// kicks the queue. Used when draining before a planned shutdown.
+func Flush(ctx context.Context) (affected int, err error) {
+	hold := false
+	return NextAttemptSet(ctx, Filter{Hold: &hold}, time.Now())
+}
+
+// Pending returns the number of deliveries in progress, and the number of
+// messages not on hold that are due for a delivery attempt. When both are zero
+// after a Flush, no more delivery attempts are made until the next retry
+// interval.
+func Pending(ctx context.Context) (active int64, due int, err error) {
+	active = deliveriesActive.Load()
+	q := bstore.QueryDB[Msg](ctx, DB)
+	q.FilterLessEqual("NextAttempt", time.Now())
+	q.FilterEqual("Hold", false)
+	due, err = q.Count()
+	return active, due, err
+}
+
+// HoldSet sets Hold for all matching messages and kicks the queue. Taking messages
+// off hold clears their HoldReason.
 func HoldSet(ctx context.Context, filter Filter, hold bool) (affected int, err error) {
 	err = DB.Write(ctx, func(tx *bstore.Tx) error {
 		q := bstore.QueryTx[Msg](tx)
 		if err := filter.apply(q); err != nil {
 			return err
 		}
-		n, err := q.UpdateFields(map[string]any{"Hold": hold})
+		fields := map[string]any{"Hold": hold}
+		if !hold {
+			fields["HoldReason"] = ""
+		}
+		n, err := q.UpdateFields(fields)
 		if err != nil {
 			return fmt.Errorf("selecting and updating messages in queue: %v", err)
 		}
@@ -941,6 +1116,17 @@ func RequireTLSSet(ctx context.Context, filter Filter, requireTLS *bool) (affect
 	return n, err
 }
 
+// RequireVerifiedTLSSet updates the RequireVerifiedTLS field of matching messages.
+func RequireVerifiedTLSSet(ctx context.Context, filter Filter, requireVerifiedTLS bool) (affected int, err error) {
+	q := bstore.QueryDB[Msg](ctx, DB)
+	if err := filter.apply(q); err != nil {
+		return 0, err
+	}
+	n, err := q.UpdateFields(map[string]any{"RequireVerifiedTLS": requireVerifiedTLS})
+	msgqueueKick()
+	return n, err
+}
+
 // RetiredFilter filters messages to list or operate on. Used by admin web interface
 // and cli.
 //
@@ -1114,7 +1300,7 @@ func Start(resolver dns.Resolver, done chan struct{}) error {
 		return err
 	}
 
-	go startQueue(resolver, done)
+	go startQueue(newDNSCache(resolver), done)
 	go startHookQueue(done)
 
 	go cleanupMsgRetired(done)
@@ -1145,6 +1331,7 @@ func cleanupMsgRetired(done chan struct{}) {
 		}
 
 		cleanupMsgRetiredSingle(log)
+		journalCompact(mox.Shutdown, log)
 		timer.Reset(time.Hour)
 	}
 }
@@ -1172,6 +1359,7 @@ func startQueue(resolver dns.Resolver, done chan struct{}) {
 			for len(busyDomains) > 0 {
 				domain := <-deliveryResults
 				delete(busyDomains, domain)
+				deliveriesActive.Add(-1)
 			}
 			done <- struct{}{}
 			return
@@ -1179,6 +1367,7 @@ func startQueue(resolver dns.Resolver, done chan struct{}) {
 		case <-timer.C:
 		case domain := <-deliveryResults:
 			delete(busyDomains, domain)
+			deliveriesActive.Add(-1)
 		}
 
 		if len(busyDomains) >= maxConcurrentDeliveries {
@@ -1212,12 +1401,16 @@ func nextWork(ctx context.Context, log mlog.Log, busyDomains map[string]struct{}
 	return time.Until(qm.NextAttempt)
 }
 
+// launchWork starts deliveries for messages that are due, for domains that don't
+// have a delivery in progress. Messages with higher priority are started first,
+// so they don't have to wait for lower priority messages when the number of
+// concurrent deliveries is at its maximum.
 func launchWork(log mlog.Log, resolver dns.Resolver, busyDomains map[string]struct{}) int {
 	q := bstore.QueryDB[Msg](mox.Shutdown, DB)
 	q.FilterLessEqual("NextAttempt", time.Now())
 	q.FilterEqual("Hold", false)
+	q.SortDesc("Priority")
 	q.SortAsc("NextAttempt")
-	q.Limit(maxConcurrentDeliveries)
 	if len(busyDomains) > 0 {
 		var doms []any
 		for d := range busyDomains {
@@ -1233,6 +1426,9 @@ func launchWork(log mlog.Log, resolver dns.Resolver, busyDomains map[string]stru
 			seen[dom] = true
 			msgs = append(msgs, m)
 		}
+		if len(busyDomains)+len(msgs) >= maxConcurrentDeliveries {
+			return bstore.StopForEach
+		}
 		return nil
 	})
 	if err != nil {
@@ -1243,6 +1439,7 @@ func launchWork(log mlog.Log, resolver dns.Resolver, busyDomains map[string]stru
 
 	for _, m := range msgs {
 		busyDomains[m.RecipientDomainStr] = struct{}{}
+		deliveriesActive.Add(1)
 		go deliver(log, resolver, m)
 	}
 	return len(msgs)
@@ -1282,8 +1479,14 @@ func retireMsgs(log mlog.Log, tx *bstore.Tx, event webhook.OutgoingEvent, code i
 		hookURL = accConf.OutgoingWebhook.URL
 	}
 	log.Debug("retiring messages from queue", slog.Any("event", event), slog.String("account", m0.SenderAccount), slog.Bool("ok", ok), slog.String("webhookurl", hookURL))
+	if tenant, _, ok := mox.Conf.AccountTenant(m0.SenderAccount); ok {
+		metricTenantMessages.WithLabelValues(tenant, string(event)).Add(float64(len(msgs)))
+	}
 	if hookURL != "" && (len(accConf.OutgoingWebhook.Events) == 0 || slices.Contains(accConf.OutgoingWebhook.Events, string(event))) {
 		for _, m := range msgs {
+			if m.IsForward {
+				continue
+			}
 			suppressing := slices.Contains(suppressedMsgIDs, m.ID)
 			h, err := hookCompose(m, hookURL, accConf.OutgoingWebhook.Authorization, event, suppressing, code, secode)
 			if err != nil {
@@ -1301,14 +1504,19 @@ func retireMsgs(log mlog.Log, tx *bstore.Tx, event webhook.OutgoingEvent, code i
 		hookKeep = accConf.KeepRetiredWebhookPeriod
 	}
 
+	var keepUntil time.Time
+	if msgKeep > 0 {
+		keepUntil = now.Add(msgKeep)
+	}
 	for _, m := range msgs {
+		if err := journalRetire(tx, &m, keepUntil); err != nil {
+			return err
+		}
 		if err := tx.Delete(&m); err != nil {
 			return err
 		}
-	}
-	if msgKeep > 0 {
-		for _, m := range msgs {
-			rm := m.Retired(event == webhook.EventDelivered, now, now.Add(msgKeep))
+		if msgKeep > 0 {
+			rm := m.Retired(event == webhook.EventDelivered, now, keepUntil)
 			if err := tx.Insert(&rm); err != nil {
 				return err
 			}
@@ -1392,7 +1600,7 @@ func deliver(log mlog.Log, resolver dns.Resolver, m0 Msg) {
 		m0.LastAttempt = &now
 		m0.NextAttempt = now.Add(backoff)
 		m0.Results = append(m0.Results, MsgResult{Start: now, Error: resultErrorDelivering})
-		if err := xtx.Update(&m0); err != nil {
+		if err := attemptUpdate(xtx, m0); err != nil {
 			return fmt.Errorf("update message to be delivered: %v", err)
 		}
 		return nil
@@ -1435,21 +1643,13 @@ func deliver(log mlog.Log, resolver dns.Resolver, m0 Msg) {
 		return
 	}
 
-	resolveTransport := func(mm Msg) (string, config.Transport, bool) {
-		if mm.Transport != "" {
-			transport, ok := mox.Conf.Static.Transports[mm.Transport]
-			if !ok {
-				return "", config.Transport{}, false
-			}
-			return mm.Transport, transport, ok
-		}
-		route := findRoute(mm.Attempts, mm)
-		return route.Transport, route.ResolvedTransport, true
-	}
-
 	// Find route for transport to use for delivery attempt.
 	m0.Attempts--
 	transportName, transport, transportOK := resolveTransport(m0)
+	var circuit string
+	if transportOK {
+		circuit = circuitKey(transportName, transport, m0)
+	}
 	m0.Attempts++
 	if !transportOK {
 		failMsgsTx(qlog, xtx, []*Msg{&m0}, m0.DialedIPs, backoff, remoteMTA, fmt.Errorf("cannot find transport %q", m0.Transport))
@@ -1460,6 +1660,29 @@ func deliver(log mlog.Log, resolver dns.Resolver, m0 Msg) {
 		return
 	}
 
+	// If the destination of the message has consistently been failing, we don't make
+	// an attempt, but postpone the message until the circuit for the destination is
+	// half-open again. The registered delivery attempt is rolled back. If we are the
+	// probe, the probe ends when we return, also if we return before connecting.
+	if circuit != "" && !Localserve {
+		allow, probe, until := circuitCheck(circuit, time.Now())
+		if !allow {
+			qlog.Debug("circuit for delivery destination is open, postponing delivery attempt",
+				slog.String("destination", circuit),
+				slog.Int64("msgid", m0.ID),
+				slog.Time("until", until))
+			err := xtx.Rollback()
+			qlog.Check(err, "rolling back delivery attempt for destination with open circuit")
+			xtx = nil
+			_, err = bstore.QueryDB[Msg](ctx, DB).FilterID(m0.ID).UpdateField("NextAttempt", until)
+			qlog.Check(err, "postponing message for delivery destination with open circuit")
+			return
+		}
+		if probe {
+			defer circuitProbeEnd(circuit)
+		}
+	}
+
 	if transportName != "" {
 		qlog = qlog.With(slog.String("transport", transportName))
 		qlog.Debug("delivering with transport")
@@ -1479,7 +1702,7 @@ func deliver(log mlog.Log, resolver dns.Resolver, m0 Msg) {
 			err := q.ForEach(func(xm Msg) error {
 				mrtls := m0.RequireTLS != nil
 				xmrtls := xm.RequireTLS != nil
-				if mrtls != xmrtls || mrtls && *m0.RequireTLS != *xm.RequireTLS {
+				if mrtls != xmrtls || mrtls && *m0.RequireTLS != *xm.RequireTLS || m0.RequireVerifiedTLS != xm.RequireVerifiedTLS {
 					return nil
 				}
 				tn, _, ok := resolveTransport(xm)
@@ -1498,7 +1721,7 @@ func deliver(log mlog.Log, resolver dns.Resolver, m0 Msg) {
 				mm.NextAttempt = m0.NextAttempt
 				mm.LastAttempt = m0.LastAttempt
 				mm.Results = append(mm.Results, MsgResult{Start: now, Error: resultErrorDelivering})
-				if err := xtx.Update(mm); err != nil {
+				if err := attemptUpdate(xtx, *mm); err != nil {
 					return fmt.Errorf("updating more message recipients for smtp transaction: %v", err)
 				}
 			}
@@ -1561,7 +1784,7 @@ func deliver(log mlog.Log, resolver dns.Resolver, m0 Msg) {
 	var recipientDomainResult tlsrpt.Result
 	var hostResults []tlsrpt.Result
 	defer func() {
-		if mox.Conf.Static.NoOutgoingTLSReports || m0.RecipientDomain.IsIP() {
+		if mox.Conf.Static.NoOutgoingTLSReports || m0.RecipientDomain.IsIP() || transport.Socks != nil && transport.Socks.RemoteResolve {
 			return
 		}
 
@@ -1638,6 +1861,7 @@ func deliver(log mlog.Log, resolver dns.Resolver, m0 Msg) {
 		deliverSubmit(qlog, resolver, dialer, msgs, backoff, transportName, transport.SMTP, false, 25)
 	} else {
 		ourHostname := mox.Conf.Static.HostnameDomain
+		var remoteResolve bool
 		if transport.Socks != nil {
 			socksdialer, err := proxy.SOCKS5("tcp", transport.Socks.Address, nil, &net.Dialer{})
 			if err != nil {
@@ -1650,11 +1874,27 @@ func deliver(log mlog.Log, resolver dns.Resolver, m0 Msg) {
 				dialer = d
 			}
 			ourHostname = transport.Socks.Hostname
+			remoteResolve = transport.Socks.RemoteResolve
 		}
-		recipientDomainResult, hostResults = deliverDirect(qlog, resolver, dialer, ourHostname, transportName, transport.Direct, msgs, backoff)
+		recipientDomainResult, hostResults = deliverDirect(qlog, resolver, dialer, ourHostname, transportName, transport.Direct, remoteResolve, msgs, backoff)
 	}
 }
 
+// resolveTransport returns the transport to use for the next delivery attempt of
+// mm, either explicitly set on the message, or through a matching route. If the
+// transport configured for the message does not exist, false is returned.
+func resolveTransport(mm Msg) (string, config.Transport, bool) {
+	if mm.Transport != "" {
+		transport, ok := mox.Conf.Static.Transports[mm.Transport]
+		if !ok {
+			return "", config.Transport{}, false
+		}
+		return mm.Transport, transport, ok
+	}
+	route := findRoute(mm.Attempts, mm)
+	return route.Transport, route.ResolvedTransport, true
+}
+
 func findRoute(attempt int, m Msg) config.Route {
 	routesAccount, routesDomain, routesGlobal := mox.Conf.Routes(m.SenderAccount, m.SenderDomain.Domain)
 	if r, ok := findRouteInList(attempt, m, routesAccount); ok {
@@ -1679,13 +1919,18 @@ func findRouteInList(attempt int, m Msg, routes []config.Route) (config.Route, b
 }
 
 func routeMatch(attempt int, m Msg, r config.Route) bool {
-	return attempt >= r.MinimumAttempts && routeMatchDomain(r.FromDomainASCII, m.SenderDomain.Domain) && routeMatchDomain(r.ToDomainASCII, m.RecipientDomain.Domain)
+	return attempt >= r.MinimumAttempts && routeMatchDomain(r.FromDomainASCII, m.SenderDomain) && routeMatchDomain(r.ToDomainASCII, m.RecipientDomain)
 }
 
-func routeMatchDomain(l []string, d dns.Domain) bool {
+func routeMatchDomain(l []string, ipd dns.IPDomain) bool {
 	if len(l) == 0 {
 		return true
 	}
+	if ipd.IsIP() {
+		// Address literal, normalized during config parsing.
+		return slices.Contains(l, "[]") || slices.Contains(l, formatIPDomain(ipd)) || slices.Contains(l, ".")
+	}
+	d := ipd.Domain
 	for _, e := range l {
 		if d.ASCII == e || strings.HasPrefix(e, ".") && (d.ASCII == e[1:] || strings.HasSuffix(d.ASCII, e)) {
 			return true
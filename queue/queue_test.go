@@ -25,6 +25,7 @@ import (
 	"github.com/mjl-/adns"
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
@@ -213,6 +214,19 @@ func TestQueue(t *testing.T) {
 	filter(Filter{Transport: &empty}, 1)
 	filter(Filter{Transport: &bogus}, 0)
 
+	// Flush makes messages due again.
+	_, err = NextAttemptAdd(ctxbg, Filter{}, time.Hour)
+	tcheck(t, err, "next attempt add")
+	_, due, err := Pending(ctxbg)
+	tcheck(t, err, "pending")
+	tcompare(t, due, 0)
+	n, err = Flush(ctxbg)
+	tcheck(t, err, "flush")
+	tcompare(t, n, 1)
+	_, due, err = Pending(ctxbg)
+	tcheck(t, err, "pending")
+	tcompare(t, due, 1)
+
 	next := nextWork(ctxbg, pkglog, nil)
 	if next > 0 {
 		t.Fatalf("nextWork in %s, should be now", next)
@@ -260,6 +274,15 @@ func TestQueue(t *testing.T) {
 		m, err := bstore.QueryDB[Msg](ctxbg, DB).Get()
 		tcheck(t, err, "get")
 		tcompare(t, m.Attempts, 1)
+		// The attempted host is recorded with the delivery result.
+		tcompare(t, len(m.Results), 1)
+		tcompare(t, len(m.Results[0].Hosts), 1)
+		rh := m.Results[0].Hosts[0]
+		tcompare(t, rh.Host, "mail.mox.example")
+		tcompare(t, rh.Success, false)
+		if !strings.Contains(rh.Error, "failure from test") {
+			t.Fatalf("got host error %q, expected dial error", rh.Error)
+		}
 	case <-timer.C:
 		t.Fatalf("no delivery within 1s")
 	}
@@ -565,6 +588,23 @@ func TestQueue(t *testing.T) {
 		t.Fatalf("expected net.Dialer as dialer")
 	}
 
+	// Sender requested a DSN for successful delivery. Remote does not support the DSN
+	// extension, so we deliver a DSN for relaying the message.
+	qm = MakeMsg(path, path, false, false, int64(len(testmsg)), "<dsnsuccess@localhost>", nil, nil, time.Now(), "test")
+	qm.DSNNotify = "SUCCESS"
+	err = Add(ctxbg, pkglog, "mjl", mf, qm)
+	tcheck(t, err, "add message to queue for delivery")
+	testDSN(fakeSMTPServer)
+
+	// Remote supports the DSN extension, it is responsible for sending the DSN.
+	qm = MakeMsg(path, path, false, false, int64(len(testmsg)), "<dsnsuccess@localhost>", nil, nil, time.Now(), "test")
+	qm.DSNNotify = "SUCCESS"
+	err = Add(ctxbg, pkglog, "mjl", mf, qm)
+	tcheck(t, err, "add message to queue for delivery")
+	testDeliver(func(conn net.Conn) {
+		nfakeSMTPServer(conn, 1, 1, false, []string{"DSN"})
+	})
+
 	// Single delivery to two recipients at same domain, expecting single connection
 	// and single transaction.
 	qm0 := MakeMsg(path, path, false, false, int64(len(testmsg)), "<test@localhost>", nil, nil, time.Now(), "test")
@@ -687,6 +727,27 @@ func TestQueue(t *testing.T) {
 		t.Fatalf("expected non-net.Dialer as dialer") // SOCKS5 dialer is a private type, we cannot check for it.
 	}
 
+	// Add a message for a .onion domain, routed to a socks transport that resolves
+	// names remotely. The mock resolver has no records for it.
+	onionpath := smtp.Path{Localpart: "mjl", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "hidden.onion"}}}
+	qml = []Msg{MakeMsg(path, onionpath, false, false, int64(len(testmsg)), "<onion@localhost>", nil, nil, time.Now(), "test")}
+	err = Add(ctxbg, pkglog, "mjl", mf, qml...)
+	tcheck(t, err, "add message to queue for delivery")
+	wasNetDialer = testDeliver(fakeSMTPServer)
+	if wasNetDialer {
+		t.Fatalf("expected non-net.Dialer as dialer")
+	}
+
+	// Add a message for an address literal, delivered directly to the IP.
+	ippath := smtp.Path{Localpart: "mjl", IPDomain: dns.IPDomain{IP: net.ParseIP("127.0.0.1")}}
+	qml = []Msg{MakeMsg(path, ippath, false, false, int64(len(testmsg)), "<ipliteral@localhost>", nil, nil, time.Now(), "test")}
+	err = Add(ctxbg, pkglog, "mjl", mf, qml...)
+	tcheck(t, err, "add message to queue for delivery")
+	wasNetDialer = testDeliver(fakeSMTPServer)
+	if !wasNetDialer {
+		t.Fatalf("expected net.Dialer as dialer")
+	}
+
 	// Add message to be delivered with opportunistic TLS verification.
 	clearTLSResults(t)
 	qml = []Msg{MakeMsg(path, path, false, false, int64(len(testmsg)), "<opportunistictls@localhost>", nil, nil, time.Now(), "test")}
@@ -737,6 +798,15 @@ func TestQueue(t *testing.T) {
 	kick(1, qml[0].ID)
 	testDeliver(fakeSMTPSTARTTLSServer)
 
+	// Add message requiring verified TLS, delivered with DANE verification, without
+	// PKIX verification.
+	qml = []Msg{MakeMsg(path, path, false, false, int64(len(testmsg)), "<requireverifiedtlsdane@localhost>", nil, nil, time.Now(), "test")}
+	qml[0].RequireVerifiedTLS = true
+	err = Add(ctxbg, pkglog, "mjl", mf, qml...)
+	tcheck(t, err, "add message to queue for delivery")
+	kick(1, qml[0].ID)
+	testDeliver(fakeSMTPSTARTTLSServer)
+
 	// Check that message is delivered with all unusable DANE records.
 	clearTLSResults(t)
 	resolver.TLSA = map[string][]adns.TLSA{
@@ -798,6 +868,14 @@ func TestQueue(t *testing.T) {
 	kick(1, qml[0].ID)
 	testDSN(makeBadFakeSMTPSTARTTLSServer(false))
 
+	// Same, but the sender does not want a DSN.
+	qml = []Msg{MakeMsg(path, path, false, false, int64(len(testmsg)), "<tlsrequiredunsupported@localhost>", nil, &yes, time.Now(), "test")}
+	qml[0].DSNNotify = "NEVER"
+	err = Add(ctxbg, pkglog, "mjl", mf, qml...)
+	tcheck(t, err, "add message to queue for delivery")
+	kick(1, qml[0].ID)
+	testDeliver(makeBadFakeSMTPSTARTTLSServer(false))
+
 	// Restore pre-DANE behaviour.
 	resolver.AllAuthentic = false
 	resolver.TLSA = nil
@@ -810,6 +888,37 @@ func TestQueue(t *testing.T) {
 	// Based on DNS lookups, there won't be any dialing or SMTP connection.
 	testDSN(func(conn net.Conn) {})
 
+	// Add message requiring verified TLS, failing because the certificate cannot be
+	// verified with PKIX and there is no DANE. A "TLS-Required: No" header is
+	// ignored.
+	qml = []Msg{MakeMsg(path, path, false, false, int64(len(testmsg)), "<requireverifiedtls@localhost>", nil, &no, time.Now(), "test")}
+	qml[0].RequireVerifiedTLS = true
+	qml[0].MaxAttempts = 1
+	err = Add(ctxbg, pkglog, "mjl", mf, qml...)
+	tcheck(t, err, "add message to queue for delivery")
+	kick(1, qml[0].ID)
+	testDSN(fakeSMTPSTARTTLSServer)
+	dsnm, err := bstore.QueryDB[store.Message](ctxbg, acc.DB).SortDesc("ID").Limit(1).Get()
+	tcheck(t, err, "get dsn message")
+	dsnr := acc.MessageReader(dsnm)
+	dsnbuf, err := io.ReadAll(dsnr)
+	tcheck(t, err, "read dsn")
+	dsnr.Close()
+	if !strings.Contains(string(dsnbuf), "verified tls required for delivery") {
+		t.Fatalf("dsn does not mention verified tls requirement:\n%s", dsnbuf)
+	}
+
+	// Same, but required through the direct transport, as configured for a route.
+	qml = []Msg{MakeMsg(path, path, false, false, int64(len(testmsg)), "<requireverifiedtlstransport@localhost>", nil, nil, time.Now(), "test")}
+	qml[0].MaxAttempts = 1
+	err = Add(ctxbg, pkglog, "mjl", mf, qml...)
+	tcheck(t, err, "add message to queue for delivery")
+	n, err = TransportSet(ctxbg, idfilter(qml[0].ID), "directverified")
+	tcheck(t, err, "TransportSet")
+	tcompare(t, n, 1)
+	kick(1, qml[0].ID)
+	testDSN(fakeSMTPSTARTTLSServer)
+
 	// Add another message that we'll fail to deliver entirely.
 	qm = MakeMsg(path, path, false, false, int64(len(testmsg)), "<test@localhost>", nil, nil, time.Now(), "test")
 	err = Add(ctxbg, pkglog, "mjl", mf, qm)
@@ -1012,6 +1121,13 @@ func TestRetiredHooks(t *testing.T) {
 			lr.Duration = 0
 			tcompare(t, lr.Error == "", expResult.Error == "")
 			lr.Error = expResult.Error
+			// Only deliveries that reached the remote SMTP server tried a host.
+			expHosts := 0
+			if expResult.Code != 0 {
+				expHosts = 1
+			}
+			tcompare(t, len(lr.Hosts), expHosts)
+			lr.Hosts = nil
 			tcompare(t, lr, *expResult)
 
 			// Compare added webhook.
@@ -1495,3 +1611,131 @@ func fakeCert(t *testing.T, name string, expired bool) tls.Certificate {
 	}
 	return c
 }
+
+func TestPriority(t *testing.T) {
+	_, cleanup := setup(t)
+	defer cleanup()
+
+	mf := prepareFile(t)
+	defer os.Remove(mf.Name())
+	defer mf.Close()
+
+	path := smtp.Path{Localpart: "mjl", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "mox.example"}}}
+	lowPath := smtp.Path{Localpart: "low", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "low.example"}}}
+	highPath := smtp.Path{Localpart: "high", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "high.example"}}}
+
+	// The low priority message is due earlier, but the high priority message must be
+	// delivered first.
+	now := time.Now()
+	qmlow := MakeMsg(path, lowPath, false, false, int64(len(testmsg)), "<test@localhost>", nil, nil, now.Add(-time.Minute), "test")
+	qmlow.Priority = -5
+	qmhigh := MakeMsg(path, highPath, false, false, int64(len(testmsg)), "<test@localhost>", nil, nil, now, "test")
+	qmhigh.Priority = 5
+	err := Add(ctxbg, pkglog, "mjl", mf, qmlow, qmhigh)
+	tcheck(t, err, "add messages to queue")
+
+	// All but one delivery slot is busy.
+	busy := map[string]struct{}{}
+	for i := range maxConcurrentDeliveries - 1 {
+		busy[fmt.Sprintf("busy%d.example", i)] = struct{}{}
+	}
+	n := launchWork(pkglog, dns.MockResolver{}, busy)
+	tcompare(t, n, 1)
+	_, ok := busy["high.example"]
+	tcompare(t, ok, true)
+	_, ok = busy["low.example"]
+	tcompare(t, ok, false)
+
+	// Wait for the delivery attempt to finish, it fails for lack of dns records.
+	select {
+	case dom := <-deliveryResults:
+		tcompare(t, dom, "high.example")
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no delivery result")
+	}
+}
+
+func TestRouteMatchDomain(t *testing.T) {
+	ip := dns.IPDomain{IP: net.ParseIP("192.0.2.1")}
+	onion := dns.IPDomain{Domain: dns.Domain{ASCII: "hidden.onion"}}
+
+	tcompare(t, routeMatchDomain(nil, ip), true)
+	tcompare(t, routeMatchDomain([]string{"[]"}, ip), true)
+	tcompare(t, routeMatchDomain([]string{"[192.0.2.1]"}, ip), true)
+	tcompare(t, routeMatchDomain([]string{"[192.0.2.2]"}, ip), false)
+	tcompare(t, routeMatchDomain([]string{".onion"}, ip), false)
+	tcompare(t, routeMatchDomain([]string{"[]"}, onion), false)
+	tcompare(t, routeMatchDomain([]string{".onion"}, onion), true)
+}
+
+func TestLocalIPs(t *testing.T) {
+	_, cleanup := setup(t)
+	defer cleanup()
+
+	tcompare(t, localIPs("mjl"), mox.Conf.Static.SpecifiedSMTPListenIPs)
+
+	// Put the domain of the account in a tenant with outgoing IPs. Not saved, only for
+	// this test.
+	pool := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("2001:db8::1")}
+	mox.Conf.Dynamic.Tenants = map[string]config.Tenant{"acme": {IPs: pool}}
+	dom := mox.Conf.Dynamic.Domains["mox.example"]
+	dom.Tenant = "acme"
+	mox.Conf.Dynamic.Domains["mox.example"] = dom
+	defer func() {
+		dom.Tenant = ""
+		mox.Conf.Dynamic.Domains["mox.example"] = dom
+		mox.Conf.Dynamic.Tenants = nil
+	}()
+
+	ips := localIPs("mjl")
+	tcompare(t, len(ips), len(pool))
+	for _, ip := range pool {
+		if !slices.ContainsFunc(ips, ip.Equal) {
+			t.Fatalf("missing ip %s from pool in %v", ip, ips)
+		}
+	}
+}
+
+func TestHoldForReview(t *testing.T) {
+	_, cleanup := setup(t)
+	defer cleanup()
+
+	hold, err := HoldForReview(ctxbg, "mjl")
+	tcheck(t, err, "hold for review")
+	tcompare(t, hold, false)
+
+	accConf := mox.Conf.Dynamic.Accounts["mjl"]
+	accConf.HoldOverSendLimits = true
+	mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	defer func() {
+		accConf.HoldOverSendLimits = false
+		mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	}()
+	hold, err = HoldForReview(ctxbg, "mjl")
+	tcheck(t, err, "hold for review")
+	tcompare(t, hold, true)
+
+	// Messages on hold without reason, e.g. through hold rules, don't count.
+	err = DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		for i := range MaxHeldForReview {
+			qm := Msg{SenderAccount: "mjl", Hold: true}
+			if i > 0 {
+				qm.HoldReason = "test"
+			}
+			if err := tx.Insert(&qm); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	tcheck(t, err, "insert held messages")
+	hold, err = HoldForReview(ctxbg, "mjl")
+	tcheck(t, err, "hold for review")
+	tcompare(t, hold, true)
+
+	err = DB.Insert(ctxbg, &Msg{SenderAccount: "mjl", Hold: true, HoldReason: "test"})
+	tcheck(t, err, "insert held message")
+	hold, err = HoldForReview(ctxbg, "mjl")
+	tcheck(t, err, "hold for review")
+	tcompare(t, hold, false)
+}
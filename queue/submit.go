@@ -2,6 +2,7 @@ package queue
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/tls"
 	"errors"
@@ -37,10 +38,10 @@ func deliverSubmit(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 	// For convenience, all messages share the same relevant values.
 	m0 := msgs[0]
 
-	port := transport.Port
-	if port == 0 {
-		port = defaultPort
-	}
+	hosts := submitHosts(transport, defaultPort)
+	// Host we are connecting to, or connected to.
+	host := hosts[0]
+	addr := net.JoinHostPort(host.Host, fmt.Sprintf("%d", host.Port))
 
 	tlsMode := smtpclient.TLSRequiredStartTLS
 	tlsPKIX := true
@@ -66,8 +67,8 @@ func deliverSubmit(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 		metricDelivery.WithLabelValues(fmt.Sprintf("%d", m0.Attempts), transportName, string(tlsMode), r).Observe(d)
 
 		qlog.Debugx("queue deliversubmit result", submiterr,
-			slog.Any("host", transport.DNSHost),
-			slog.Int("port", port),
+			slog.Any("host", host.DNSHost),
+			slog.Int("port", host.Port),
 			slog.String("result", r),
 			slog.Int("delivered", delivered),
 			slog.Int("failed", failed),
@@ -97,41 +98,10 @@ func deliverSubmit(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 		return
 	}
 
-	dialctx, dialcancel := context.WithTimeout(ctx, 30*time.Second)
-	defer dialcancel()
 	if msgs[0].DialedIPs == nil {
 		msgs[0].DialedIPs = map[string][]net.IP{}
 		m0 = msgs[0]
 	}
-	_, _, _, ips, _, err := smtpclient.GatherIPs(dialctx, qlog.Logger, resolver, "ip", dns.IPDomain{Domain: transport.DNSHost}, m0.DialedIPs)
-	var conn net.Conn
-	if err == nil {
-		conn, _, err = smtpclient.Dial(dialctx, qlog.Logger, dialer, dns.IPDomain{Domain: transport.DNSHost}, ips, port, m0.DialedIPs, mox.Conf.Static.SpecifiedSMTPListenIPs)
-	}
-	addr := net.JoinHostPort(transport.Host, fmt.Sprintf("%d", port))
-	var result string
-	switch {
-	case err == nil:
-		result = "ok"
-	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
-		result = "timeout"
-	case errors.Is(err, context.Canceled):
-		result = "canceled"
-	default:
-		result = "error"
-	}
-	metricConnection.WithLabelValues(result).Inc()
-	if err != nil {
-		if conn != nil {
-			err := conn.Close()
-			qlog.Check(err, "closing connection")
-		}
-		qlog.Errorx("dialing for submission", err, slog.String("remote", addr))
-		submiterr = fmt.Errorf("transport %s: dialing %s for submission: %w", transportName, addr, err)
-		failMsgsDB(qlog, msgs, m0.DialedIPs, backoff, dsn.NameIP{}, submiterr)
-		return
-	}
-	dialcancel()
 
 	var auth func(mechanisms []string, cs *tls.ConnectionState) (sasl.Client, error)
 	if transport.Auth != nil {
@@ -149,17 +119,17 @@ func deliverSubmit(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 					continue
 				}
 				if mech == "SCRAM-SHA-256-PLUS" && cs != nil {
-					return sasl.NewClientSCRAMSHA256PLUS(a.Username, a.Password, *cs), nil
+					return sasl.NewClientSCRAMSHA256PLUS(a.Username, a.EffectivePassword, *cs), nil
 				} else if mech == "SCRAM-SHA-256" {
-					return sasl.NewClientSCRAMSHA256(a.Username, a.Password, supportsscramsha256plus), nil
+					return sasl.NewClientSCRAMSHA256(a.Username, a.EffectivePassword, supportsscramsha256plus), nil
 				} else if mech == "SCRAM-SHA-1-PLUS" && cs != nil {
-					return sasl.NewClientSCRAMSHA1PLUS(a.Username, a.Password, *cs), nil
+					return sasl.NewClientSCRAMSHA1PLUS(a.Username, a.EffectivePassword, *cs), nil
 				} else if mech == "SCRAM-SHA-1" {
-					return sasl.NewClientSCRAMSHA1(a.Username, a.Password, supportsscramsha1plus), nil
+					return sasl.NewClientSCRAMSHA1(a.Username, a.EffectivePassword, supportsscramsha1plus), nil
 				} else if mech == "CRAM-MD5" {
-					return sasl.NewClientCRAMMD5(a.Username, a.Password), nil
+					return sasl.NewClientCRAMMD5(a.Username, a.EffectivePassword), nil
 				} else if mech == "PLAIN" {
-					return sasl.NewClientPlain(a.Username, a.Password), nil
+					return sasl.NewClientPlain(a.Username, a.EffectivePassword), nil
 				}
 				return nil, fmt.Errorf("internal error: unrecognized authentication mechanism %q for transport %s", mech, transportName)
 			}
@@ -168,31 +138,98 @@ func deliverSubmit(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 			return nil, nil
 		}
 	}
-	clientctx, clientcancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer clientcancel()
 	opts := smtpclient.Opts{
 		Auth:    auth,
 		RootCAs: mox.Conf.Static.TLS.CertPool,
 	}
-	client, err := smtpclient.New(clientctx, qlog.Logger, conn, tlsMode, tlsPKIX, mox.Conf.Static.HostnameDomain, transport.DNSHost, opts)
-	if err != nil {
-		smtperr, ok := err.(smtpclient.Error)
-		var remoteMTA dsn.NameIP
-		submiterr = fmt.Errorf("transport %s: establishing smtp session with %s for submission: %w", transportName, addr, err)
-		if ok {
-			remoteMTA.Name = transport.Host
-			smtperr.Err = submiterr
-			submiterr = smtperr
+
+	// Try the hosts in order until we have an SMTP session. If we cannot connect to a
+	// host, we fail over to the next. With multiple hosts, we keep track of the health
+	// of each host, skipping hosts that consistently fail until they are probed again.
+	var client *smtpclient.Client
+	for _, h := range hosts {
+		host = h
+		addr = net.JoinHostPort(host.Host, fmt.Sprintf("%d", host.Port))
+
+		var hostKey string
+		if len(hosts) > 1 {
+			hostKey = circuitKeyTransportHost(transportName, addr)
+			allow, probe, until := circuitCheck(hostKey, time.Now())
+			if !allow {
+				qlog.Debug("circuit for transport host is open, skipping host", slog.String("remote", addr), slog.Time("until", until))
+				submiterr = fmt.Errorf("transport %s: skipped host %s due to consistent connection failures", transportName, addr)
+				continue
+			}
+			if probe {
+				defer circuitProbeEnd(hostKey)
+			}
+		}
+
+		dialctx, dialcancel := context.WithTimeout(ctx, 30*time.Second)
+		_, _, _, ips, _, err := smtpclient.GatherIPs(dialctx, qlog.Logger, resolver, "ip", dns.IPDomain{Domain: host.DNSHost}, m0.DialedIPs)
+		var conn net.Conn
+		if err == nil {
+			conn, _, err = smtpclient.Dial(dialctx, qlog.Logger, dialer, dns.IPDomain{Domain: host.DNSHost}, ips, host.Port, m0.DialedIPs, mox.Conf.Static.SpecifiedSMTPListenIPs)
+		}
+		dialcancel()
+		var result string
+		switch {
+		case err == nil:
+			result = "ok"
+		case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+			result = "timeout"
+		case errors.Is(err, context.Canceled):
+			result = "canceled"
+		default:
+			result = "error"
+		}
+		metricConnection.WithLabelValues(result).Inc()
+		if err != nil {
+			if conn != nil {
+				err := conn.Close()
+				qlog.Check(err, "closing connection")
+			}
+			qlog.Errorx("dialing for submission", err, slog.String("remote", addr))
+			circuitResult(qlog, hostKey, true)
+			submiterr = fmt.Errorf("transport %s: dialing %s for submission: %w", transportName, addr, err)
+			continue
+		}
+
+		clientctx, clientcancel := context.WithTimeout(context.Background(), 60*time.Second)
+		client, err = smtpclient.New(clientctx, qlog.Logger, conn, tlsMode, tlsPKIX, mox.Conf.Static.HostnameDomain, host.DNSHost, opts)
+		clientcancel()
+		connFailure := err != nil && circuitConnFailure(err)
+		circuitResult(qlog, hostKey, connFailure)
+		if err != nil {
+			smtperr, ok := err.(smtpclient.Error)
+			var remoteMTA dsn.NameIP
+			submiterr = fmt.Errorf("transport %s: establishing smtp session with %s for submission: %w", transportName, addr, err)
+			if ok {
+				remoteMTA.Name = host.Host
+				smtperr.Err = submiterr
+				submiterr = smtperr
+			}
+			qlog.Errorx("establishing smtp session for submission", submiterr, slog.String("remote", addr))
+			if connFailure {
+				continue
+			}
+			// We did speak SMTP, so the remote is healthy.
+			circuitResult(qlog, circuitKeyTransport(transportName), false)
+			failMsgsDB(qlog, msgs, m0.DialedIPs, backoff, remoteMTA, submiterr)
+			return
 		}
-		qlog.Errorx("establishing smtp session for submission", submiterr, slog.String("remote", addr))
-		failMsgsDB(qlog, msgs, m0.DialedIPs, backoff, remoteMTA, submiterr)
+		submiterr = nil
+		break
+	}
+	circuitResult(qlog, circuitKeyTransport(transportName), client == nil)
+	if client == nil {
+		failMsgsDB(qlog, msgs, m0.DialedIPs, backoff, dsn.NameIP{}, submiterr)
 		return
 	}
 	defer func() {
 		err := client.Close()
 		qlog.Check(err, "closing smtp client after delivery")
 	}()
-	clientcancel()
 
 	var msgr io.ReadCloser
 	var size int64
@@ -228,7 +265,7 @@ func deliverSubmit(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 	for i, m := range msgs {
 		rcpts[i] = m.Recipient().String()
 	}
-	rcptErrs, submiterr := client.DeliverMultiple(deliverctx, m0.Sender().String(), rcpts, size, msgr, req8bit, reqsmtputf8, requireTLS)
+	rcptErrs, submiterr := client.DeliverMultipleDSN(deliverctx, m0.Sender().String(), rcpts, size, msgr, req8bit, reqsmtputf8, requireTLS, dsnParams(msgs))
 	if submiterr != nil {
 		qlog.Infox("smtp transaction for delivery failed", submiterr)
 	}
@@ -239,14 +276,74 @@ func deliverSubmit(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 	qlog.Check(cerr, "closing message after delivery attempt")
 	msgr = nil
 
-	failed, delivered = processDeliveries(qlog, m0, msgs, addr, transport.Host, backoff, rcptErrs, submiterr)
+	failed, delivered = processDeliveries(qlog, m0, msgs, addr, host.Host, backoff, rcptErrs, submiterr, client.SupportsDSN())
+}
+
+// submitHost is a host of a submission/smtp transport to connect to.
+type submitHost struct {
+	Host    string
+	DNSHost dns.Domain
+	Port    int
+}
+
+// submitHosts returns the hosts of transport in the order they should be tried.
+// Hosts are ordered by priority. Hosts with the same priority are ordered
+// randomly, with hosts with a higher weight more likely to come first, like with
+// SRV records. ../rfc/2782
+func submitHosts(transport *config.TransportSMTP, defaultPort int) []submitHost {
+	port := transport.Port
+	if port == 0 {
+		port = defaultPort
+	}
+
+	type weightedHost struct {
+		submitHost
+		priority int
+		weight   int
+	}
+	l := []weightedHost{{submitHost{transport.Host, transport.DNSHost, port}, 0, max(transport.Weight, 1)}}
+	for _, h := range transport.Hosts {
+		hport := h.Port
+		if hport == 0 {
+			hport = port
+		}
+		l = append(l, weightedHost{submitHost{h.Host, h.DNSHost, hport}, h.Priority, max(h.Weight, 1)})
+	}
+	slices.SortStableFunc(l, func(a, b weightedHost) int {
+		return cmp.Compare(a.priority, b.priority)
+	})
+
+	r := make([]submitHost, 0, len(l))
+	for len(l) > 0 {
+		n := 1
+		for n < len(l) && l[n].priority == l[0].priority {
+			n++
+		}
+		group := l[:n]
+		l = l[n:]
+		for len(group) > 0 {
+			var sum int
+			for _, h := range group {
+				sum += h.weight
+			}
+			v := jitter.IntN(sum)
+			var i int
+			for v >= group[i].weight {
+				v -= group[i].weight
+				i++
+			}
+			r = append(r, group[i].submitHost)
+			group = slices.Delete(group, i, i+1)
+		}
+	}
+	return r
 }
 
 // Process failures and successful deliveries, retiring/removing messages from
 // queue, queueing webhooks.
 //
 // Also used by deliverLocalserve.
-func processDeliveries(qlog mlog.Log, m0 *Msg, msgs []*Msg, remoteAddr string, remoteHost string, backoff time.Duration, rcptErrs []smtpclient.Response, submiterr error) (failed, delivered int) {
+func processDeliveries(qlog mlog.Log, m0 *Msg, msgs []*Msg, remoteAddr string, remoteHost string, backoff time.Duration, rcptErrs []smtpclient.Response, submiterr error, remoteDSN bool) (failed, delivered int) {
 	var delMsgs []Msg
 	for i, m := range msgs {
 		qmlog := qlog.With(
@@ -277,6 +374,9 @@ func processDeliveries(qlog mlog.Log, m0 *Msg, msgs []*Msg, remoteAddr string, r
 			delMsgs = append(delMsgs, *m)
 			qmlog.Info("delivered from queue with transport")
 			delivered++
+			if !remoteDSN {
+				deliverDSNRelayed(qmlog, *m, dsn.NameIP{Name: remoteHost})
+			}
 		}
 	}
 	if len(delMsgs) > 0 {
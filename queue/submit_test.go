@@ -0,0 +1,130 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
+)
+
+func TestSubmitHosts(t *testing.T) {
+	transport := &config.TransportSMTP{
+		Host: "a.example",
+		Hosts: []config.TransportSMTPHost{
+			{Host: "c.example", Priority: 2},
+			{Host: "b1.example", Priority: 1, Port: 2525},
+			{Host: "b2.example", Priority: 1, Weight: 3},
+		},
+	}
+	seen := map[string]int{}
+	for range 100 {
+		l := submitHosts(transport, 587)
+		tcompare(t, len(l), 4)
+		tcompare(t, l[0], submitHost{Host: "a.example", Port: 587})
+		tcompare(t, l[3], submitHost{Host: "c.example", Port: 587})
+		seen[l[1].Host]++
+	}
+	// With weights 1 and 3, b2 should be first most of the time, but not always.
+	if seen["b1.example"] == 0 || seen["b2.example"] <= seen["b1.example"] {
+		t.Fatalf("unexpected weighted ordering, first of priority 1: %v", seen)
+	}
+}
+
+func TestSubmitFailover(t *testing.T) {
+	_, cleanup := setup(t)
+	defer cleanup()
+
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"primary.example.": {"10.0.0.1"},
+			"backup.example.":  {"10.0.0.2"},
+		},
+	}
+	transport := &config.TransportSMTP{
+		Host:       "primary.example",
+		DNSHost:    dns.Domain{ASCII: "primary.example"},
+		NoSTARTTLS: true,
+		Hosts: []config.TransportSMTPHost{
+			{Host: "backup.example", DNSHost: dns.Domain{ASCII: "backup.example"}, Priority: 1},
+		},
+	}
+
+	fakeServer := func(server net.Conn) {
+		defer server.Close()
+		fmt.Fprintf(server, "220 backup.example\r\n")
+		br := bufio.NewReader(server)
+		br.ReadString('\n') // Should be EHLO.
+		fmt.Fprintf(server, "250 backup.example\r\n")
+		br.ReadString('\n') // Should be MAIL FROM.
+		fmt.Fprintf(server, "250 ok\r\n")
+		br.ReadString('\n') // Should be RCPT TO.
+		fmt.Fprintf(server, "250 ok\r\n")
+		br.ReadString('\n') // Should be DATA.
+		fmt.Fprintf(server, "354 continue\r\n")
+		io.Copy(io.Discard, smtp.NewDataReader(br))
+		fmt.Fprintf(server, "250 ok\r\n")
+		br.ReadString('\n') // Should be QUIT.
+		fmt.Fprintf(server, "221 ok\r\n")
+	}
+
+	var dialMu sync.Mutex
+	var dialed []string
+	smtpclient.DialHook = func(ctx context.Context, dialer smtpclient.Dialer, timeout time.Duration, addr string, laddr net.Addr) (net.Conn, error) {
+		dialMu.Lock()
+		defer dialMu.Unlock()
+		dialed = append(dialed, addr)
+		if addr == "10.0.0.1:25" {
+			return nil, errors.New("connection refused")
+		}
+		server, client := net.Pipe()
+		go fakeServer(server)
+		return client, nil
+	}
+	defer func() {
+		smtpclient.DialHook = nil
+	}()
+
+	deliver := func(expDialed ...string) {
+		t.Helper()
+
+		path := smtp.Path{Localpart: "mjl", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "mox.example"}}}
+		rcpt := smtp.Path{Localpart: "mjl", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "remote.example"}}}
+		mf := prepareFile(t)
+		defer os.Remove(mf.Name())
+		defer mf.Close()
+		qm := MakeMsg(path, rcpt, false, false, int64(len(testmsg)), "<test@localhost>", nil, nil, time.Now(), "test")
+		qml := []Msg{qm}
+		err := Add(ctxbg, pkglog, "mjl", mf, qml...)
+		tcheck(t, err, "add message to queue")
+		m := Msg{ID: qml[0].ID}
+		err = DB.Get(ctxbg, &m)
+		tcheck(t, err, "get message")
+
+		dialed = nil
+		deliverSubmit(pkglog, resolver, &net.Dialer{}, []*Msg{&m}, time.Minute, "failover", transport, false, 25)
+		tcompare(t, dialed, expDialed)
+
+		// Message must have been delivered, and removed from the queue.
+		n, err := Count(ctxbg)
+		tcheck(t, err, "count queue")
+		tcompare(t, n, 0)
+	}
+
+	// Primary fails, we fail over to backup, until the circuit for the primary opens.
+	for range circuitThreshold {
+		deliver("10.0.0.1:25", "10.0.0.2:25")
+	}
+	// Primary is skipped now.
+	deliver("10.0.0.2:25")
+}
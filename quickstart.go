@@ -1029,6 +1029,11 @@ To mox, at:
 
 	http://127.0.0.1:81
 
+Alternatively, the MTA-STS policies and autoconfig files can be served as static
+files by your webserver. Run "mox config webserver-snippets -watch dir" to write
+the files and nginx/caddy/apache configuration snippets to dir, and keep them
+up to date when domains change.
+
 If it makes it easier to get a TLS certificate for %s, you can add a
 reverse proxy for that hostname too.
 
@@ -1055,7 +1060,7 @@ have been configured correctly. The DNS records to add:
 	// priming dns caches with negative/absent records, causing our "quick setup" to
 	// appear to fail or take longer than "quick".
 
-	records, err := admin.DomainRecords(confDomain, domain, domainDNSSECResult.Authentic, "letsencrypt.org", "")
+	records, err := admin.DomainRecords(confDomain, domain, domainDNSSECResult.Authentic, "letsencrypt.org", "", []store.OpenPGPKey{})
 	if err != nil {
 		fatalf("making required DNS records")
 	}
@@ -0,0 +1,316 @@
+// Package rejectdb retains incoming messages that were rejected with a
+// permanent error during the SMTP transaction, for domains with
+// RejectedRetention configured.
+//
+// Unlike the Rejects mailbox of accounts, retained messages are only visible to
+// admins. They are useful for finding false positives, and for delivering
+// messages to their recipients after adjusting the policy that caused the
+// rejection.
+package rejectdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxvar"
+)
+
+var (
+	metricRetained = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mox_rejectdb_retained_total",
+			Help: "Number of rejected incoming messages retained for review.",
+		},
+	)
+)
+
+// Defaults for fields of config.RejectedRetention that are not set.
+const (
+	DefaultMaxAge  = 14 * 24 * time.Hour
+	DefaultMaxSize = 100 * 1024 * 1024
+)
+
+// ErrTooLarge is returned by Add for messages larger than a tenth of the
+// maximum size for the domain.
+var ErrTooLarge = errors.New("rejectdb: message too large to retain")
+
+var (
+	DBTypes = []any{Message{}, MessageData{}} // Types stored in DB.
+	DB      *bstore.DB                        // Exported for backups.
+)
+
+// Message is an incoming message that was rejected with a permanent error
+// after the DATA command. If a message was rejected for recipients at multiple
+// domains, a Message is stored for each domain with retention configured.
+type Message struct {
+	ID        int64
+	Received  time.Time `bstore:"default now,index"`
+	Domain    string    `bstore:"nonzero,index Domain+Received"` // Recipient domain, unicode.
+	RemoteIP  string
+	EHLO      string
+	MailFrom  string   // SMTP MAIL FROM, empty for the null reverse path.
+	RcptTo    []string // SMTP RCPT TO addresses at Domain that rejected the message.
+	MsgFrom   string   // Address in message From header, if it could be parsed.
+	Subject   string
+	MessageID string
+	Code      int    // SMTP response code, e.g. 550.
+	Secode    string // Enhanced status code without class, e.g. "7.1".
+	Errmsg    string // Error message in SMTP response.
+	Size      int64
+
+	// Last time the message was delivered to the recipients by an admin, zero if
+	// never.
+	Delivered time.Time
+}
+
+// MessageData holds the message as received. Stored separately from Message, so
+// listing messages does not read their contents.
+type MessageData struct {
+	ID   int64 // Same as Message.ID.
+	Data []byte
+}
+
+// Init opens the database.
+func Init() error {
+	if DB != nil {
+		return fmt.Errorf("already initialized")
+	}
+
+	log := mlog.New("rejectdb", nil)
+	p := mox.DataDirPath("rejected.db")
+	os.MkdirAll(filepath.Dir(p), 0770)
+	opts := bstore.Options{Timeout: 5 * time.Second, Perm: 0660, RegisterLogger: moxvar.RegisterLogger(p, log.Logger)}
+	var err error
+	DB, err = bstore.Open(mox.Shutdown, p, &opts, DBTypes...)
+	return err
+}
+
+// Close closes the database.
+func Close() error {
+	if err := DB.Close(); err != nil {
+		return fmt.Errorf("close db: %w", err)
+	}
+	DB = nil
+	return nil
+}
+
+func limits(conf config.RejectedRetention) (maxAge time.Duration, maxSize int64) {
+	maxAge = conf.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultMaxAge
+	}
+	maxSize = conf.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxSize
+	}
+	return
+}
+
+// Add stores message m with its contents from msgFile, of m.Size bytes. Older
+// messages of the domain are removed when over the configured limits.
+//
+// ErrTooLarge is returned if the message is larger than a tenth of the maximum
+// size.
+func Add(ctx context.Context, log mlog.Log, conf config.RejectedRetention, m *Message, msgFile io.ReaderAt) error {
+	_, maxSize := limits(conf)
+	if m.Size > maxSize/10 {
+		return ErrTooLarge
+	}
+
+	data := make([]byte, m.Size)
+	if _, err := io.ReadFull(io.NewSectionReader(msgFile, 0, m.Size), data); err != nil {
+		return fmt.Errorf("reading message: %v", err)
+	}
+
+	err := DB.Write(ctx, func(tx *bstore.Tx) error {
+		if err := tx.Insert(m); err != nil {
+			return fmt.Errorf("inserting message: %w", err)
+		}
+		if err := tx.Insert(&MessageData{m.ID, data}); err != nil {
+			return fmt.Errorf("inserting message data: %w", err)
+		}
+		n, err := applyLimits(tx, m.Domain, &conf, time.Now())
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Debug("removed retained rejected messages over limits", slog.String("domain", m.Domain), slog.Int("count", n))
+		}
+		return nil
+	})
+	if err == nil {
+		metricRetained.Inc()
+	}
+	return err
+}
+
+// applyLimits removes messages for domain that are too old, or beyond the
+// maximum total size. If conf is nil, all messages of domain are removed.
+func applyLimits(tx *bstore.Tx, domain string, conf *config.RejectedRetention, now time.Time) (int, error) {
+	var maxAge time.Duration
+	var maxSize int64
+	if conf != nil {
+		maxAge, maxSize = limits(*conf)
+	}
+
+	var remove []int64
+	var size int64
+	q := bstore.QueryTx[Message](tx)
+	q.FilterNonzero(Message{Domain: domain})
+	q.SortDesc("Received")
+	err := q.ForEach(func(m Message) error {
+		size += m.Size
+		if conf == nil || now.Sub(m.Received) > maxAge || size > maxSize {
+			remove = append(remove, m.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("listing messages: %w", err)
+	}
+	for _, id := range remove {
+		if err := tx.Delete(&Message{ID: id}); err != nil {
+			return 0, fmt.Errorf("removing message: %w", err)
+		}
+		if err := tx.Delete(&MessageData{ID: id}); err != nil {
+			return 0, fmt.Errorf("removing message data: %w", err)
+		}
+	}
+	return len(remove), nil
+}
+
+// Cleanup removes messages beyond the limits of their domain, and all messages
+// of domains that no longer have retention configured.
+func Cleanup(ctx context.Context, log mlog.Log) error {
+	return DB.Write(ctx, func(tx *bstore.Tx) error {
+		domains := map[string]bool{}
+		err := bstore.QueryTx[Message](tx).ForEach(func(m Message) error {
+			domains[m.Domain] = true
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("listing messages: %w", err)
+		}
+
+		now := time.Now()
+		for name := range domains {
+			var conf *config.RejectedRetention
+			if d, err := dns.ParseDomain(name); err == nil {
+				if dc, ok := mox.Conf.Domain(d); ok {
+					conf = dc.RejectedRetention
+				}
+			}
+			n, err := applyLimits(tx, name, conf, now)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				log.Info("removed retained rejected messages", slog.String("domain", name), slog.Int("count", n))
+			}
+		}
+		return nil
+	})
+}
+
+// Start starts a goroutine that periodically removes messages beyond the
+// retention limits.
+func Start() {
+	log := mlog.New("rejectdb", nil)
+
+	go func() {
+		defer func() {
+			x := recover()
+			if x == nil {
+				return
+			}
+
+			log.Error("unhandled panic in rejectdb cleanup", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Rejectdb)
+		}()
+
+		t := time.NewTicker(time.Hour)
+		defer t.Stop()
+		for {
+			err := Cleanup(mox.Shutdown, log)
+			log.Check(err, "cleaning up retained rejected messages")
+
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// List returns messages, most recent first. If domain is not empty, only
+// messages for that domain are returned.
+func List(ctx context.Context, domain string) ([]Message, error) {
+	q := bstore.QueryDB[Message](ctx, DB)
+	if domain != "" {
+		q.FilterNonzero(Message{Domain: domain})
+	}
+	q.SortDesc("Received")
+	return q.List()
+}
+
+// Get returns a message and its contents.
+func Get(ctx context.Context, id int64) (Message, []byte, error) {
+	var m Message
+	var md MessageData
+	err := DB.Read(ctx, func(tx *bstore.Tx) error {
+		m = Message{ID: id}
+		if err := tx.Get(&m); err != nil {
+			return err
+		}
+		md = MessageData{ID: id}
+		return tx.Get(&md)
+	})
+	return m, md.Data, err
+}
+
+// Remove removes messages.
+func Remove(ctx context.Context, ids ...int64) error {
+	return DB.Write(ctx, func(tx *bstore.Tx) error {
+		for _, id := range ids {
+			if err := tx.Delete(&Message{ID: id}); err != nil {
+				return err
+			}
+			if err := tx.Delete(&MessageData{ID: id}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MarkDelivered sets the time the message was last delivered to its
+// recipients.
+func MarkDelivered(ctx context.Context, id int64, t time.Time) error {
+	return DB.Write(ctx, func(tx *bstore.Tx) error {
+		m := Message{ID: id}
+		if err := tx.Get(&m); err != nil {
+			return err
+		}
+		m.Delivered = t
+		return tx.Update(&m)
+	})
+}
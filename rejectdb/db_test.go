@@ -0,0 +1,123 @@
+package rejectdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+var ctxbg = context.Background()
+var pkglog = mlog.New("rejectdb", nil)
+
+func tcheckf(t *testing.T, err error, format string, args ...any) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", fmt.Sprintf(format, args...), err)
+	}
+}
+
+func TestRejectDB(t *testing.T) {
+	mox.Shutdown, mox.ShutdownCancel = context.WithCancel(ctxbg)
+	mox.Conf.Static.DataDir = t.TempDir()
+	conf := config.RejectedRetention{MaxAge: time.Hour, MaxSize: 1000}
+	mox.Conf.Dynamic.Domains = map[string]config.Domain{
+		"mox.example":   {RejectedRetention: &conf},
+		"other.example": {},
+	}
+
+	err := Init()
+	tcheckf(t, err, "init")
+	defer Close()
+
+	add := func(domain string, received time.Time, data string) (Message, error) {
+		t.Helper()
+		m := Message{
+			Received: received,
+			Domain:   domain,
+			RcptTo:   []string{"mjl@" + domain},
+			Code:     550,
+			Secode:   "7.1",
+			Errmsg:   "rejected",
+			Size:     int64(len(data)),
+		}
+		err := Add(ctxbg, pkglog, conf, &m, strings.NewReader(data))
+		return m, err
+	}
+
+	now := time.Now()
+	m0, err := add("mox.example", now.Add(-2*time.Hour), "old")
+	tcheckf(t, err, "add")
+	m1, err := add("mox.example", now.Add(-time.Minute), strings.Repeat("a", 50))
+	tcheckf(t, err, "add")
+	_, err = add("mox.example", now, strings.Repeat("b", 101))
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("got err %v, expected ErrTooLarge", err)
+	}
+
+	// Message older than max age was removed on add.
+	l, err := List(ctxbg, "")
+	tcheckf(t, err, "list")
+	if len(l) != 1 || l[0].ID != m1.ID {
+		t.Fatalf("got %v, expected single message %d", l, m1.ID)
+	}
+	if _, _, err := Get(ctxbg, m0.ID); err == nil {
+		t.Fatalf("old message not removed")
+	}
+
+	m, data, err := Get(ctxbg, m1.ID)
+	tcheckf(t, err, "get")
+	if m.Errmsg != "rejected" || string(data) != strings.Repeat("a", 50) {
+		t.Fatalf("got message %v, data %q", m, data)
+	}
+
+	// Total size over max, oldest removed first.
+	var last Message
+	for i := range 20 {
+		last, err = add("mox.example", now.Add(time.Duration(i)*time.Second), strings.Repeat("c", 100))
+		tcheckf(t, err, "add")
+	}
+	l, err = List(ctxbg, "mox.example")
+	tcheckf(t, err, "list")
+	if len(l) != 10 || l[0].ID != last.ID {
+		t.Fatalf("got %d messages, first %d, expected 10, first %d", len(l), l[0].ID, last.ID)
+	}
+
+	err = MarkDelivered(ctxbg, last.ID, now)
+	tcheckf(t, err, "mark delivered")
+	m, _, err = Get(ctxbg, last.ID)
+	tcheckf(t, err, "get")
+	if !m.Delivered.Equal(now) {
+		t.Fatalf("got delivered %v, expected %v", m.Delivered, now)
+	}
+
+	err = Remove(ctxbg, last.ID)
+	tcheckf(t, err, "remove")
+	l, err = List(ctxbg, "")
+	tcheckf(t, err, "list")
+	if len(l) != 9 {
+		t.Fatalf("got %d messages after remove, expected 9", len(l))
+	}
+
+	// Domain without retention config, messages are removed by cleanup.
+	_, err = add("other.example", now, "x")
+	tcheckf(t, err, "add")
+	err = Cleanup(ctxbg, pkglog)
+	tcheckf(t, err, "cleanup")
+	l, err = List(ctxbg, "other.example")
+	tcheckf(t, err, "list")
+	if len(l) != 0 {
+		t.Fatalf("got %d messages for domain without retention, expected 0", len(l))
+	}
+	l, err = List(ctxbg, "mox.example")
+	tcheckf(t, err, "list")
+	if len(l) != 9 {
+		t.Fatalf("got %d messages after cleanup, expected 9", len(l))
+	}
+}
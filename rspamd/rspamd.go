@@ -0,0 +1,185 @@
+// Package rspamd classifies messages with rspamd, through its HTTP protocol.
+//
+// The message is sent in a POST request to the /checkv2 endpoint of the rspamd
+// normal worker, with details about the SMTP transaction in request headers.
+// Rspamd responds with a JSON object with a score and an action.
+package rspamd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moxvar"
+)
+
+var (
+	metricCheck = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_rspamd_check_duration_seconds",
+			Help:    "Duration of message checks by rspamd, with resulting action.",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.100, 0.5, 1, 5, 10, 20, 30},
+		},
+		[]string{
+			"action", // "no action", "greylist", "add header", "rewrite subject", "soft reject", "reject", error
+		},
+	)
+)
+
+// Action is the action rspamd recommends for a message.
+type Action string
+
+const (
+	ActionNoAction       Action = "no action"
+	ActionGreylist       Action = "greylist"
+	ActionAddHeader      Action = "add header"
+	ActionRewriteSubject Action = "rewrite subject"
+	ActionSoftReject     Action = "soft reject"
+	ActionReject         Action = "reject"
+)
+
+// Junk returns whether the action means the message is considered junk.
+func (a Action) Junk() bool {
+	switch a {
+	case ActionAddHeader, ActionRewriteSubject, ActionSoftReject, ActionReject:
+		return true
+	}
+	return false
+}
+
+// ErrResponse indicates rspamd returned an error or an invalid response.
+var ErrResponse = errors.New("invalid response from rspamd")
+
+// Request holds details about the SMTP transaction, sent to rspamd along with the
+// message. Empty fields are not sent.
+type Request struct {
+	IP        string // Remote IP address.
+	Helo      string // Domain from EHLO/HELO.
+	From      string // SMTP MAIL FROM address.
+	Rcpt      string // SMTP RCPT TO address.
+	DeliverTo string // Address the message is delivered to, e.g. member of an alias.
+}
+
+// Result is the classification of a message by rspamd.
+type Result struct {
+	Action        Action            `json:"action"`
+	Score         float64           `json:"score"`
+	RequiredScore float64           `json:"required_score"` // Score for action reject.
+	Symbols       map[string]Symbol `json:"symbols"`
+	IsSkipped     bool              `json:"is_skipped"`
+}
+
+// Symbol is a rule in rspamd that matched a message.
+type Symbol struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+// TopSymbols returns up to n symbols with the highest absolute scores, highest
+// first.
+func (r Result) TopSymbols(n int) []Symbol {
+	var l []Symbol
+	for _, s := range r.Symbols {
+		if s.Score != 0 {
+			l = append(l, s)
+		}
+	}
+	sort.Slice(l, func(i, j int) bool {
+		ai, aj := abs(l[i].Score), abs(l[j].Score)
+		if ai != aj {
+			return ai > aj
+		}
+		return l[i].Name < l[j].Name
+	})
+	return l[:min(n, len(l))]
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+var client = &http.Client{}
+
+// Check sends the message from msg of size bytes to rspamd for classification.
+// The timeout from the config applies to the entire request.
+func Check(ctx context.Context, log mlog.Log, conf *config.Rspamd, req Request, msg io.Reader, size int64) (result Result, rerr error) {
+	start := time.Now()
+	defer func() {
+		action := string(result.Action)
+		if rerr != nil {
+			action = "error"
+		}
+		metricCheck.WithLabelValues(action).Observe(float64(time.Since(start)) / float64(time.Second))
+		log.Debugx("rspamd check result", rerr,
+			slog.String("action", string(result.Action)),
+			slog.Float64("score", result.Score),
+			slog.Duration("duration", time.Since(start)))
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, conf.Timeout)
+	defer cancel()
+	// The HTTP client closes a request body that is an io.Closer, but msg is owned
+	// by the caller.
+	hreq, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(conf.URL, "/")+"/checkv2", io.NopCloser(msg))
+	if err != nil {
+		return Result{}, fmt.Errorf("new request: %v", err)
+	}
+	hreq.ContentLength = size
+	hreq.Header.Set("User-Agent", fmt.Sprintf("mox/%s (rspamd)", moxvar.Version))
+	hreq.Header.Set("Accept", "application/json")
+	if conf.EffectivePassword != "" {
+		hreq.Header.Set("Password", conf.EffectivePassword)
+	}
+	for _, kv := range [][2]string{
+		{"IP", req.IP},
+		{"Helo", req.Helo},
+		{"From", req.From},
+		{"Rcpt", req.Rcpt},
+		{"Deliver-To", req.DeliverTo},
+	} {
+		if kv[1] != "" {
+			hreq.Header.Set(kv[0], kv[1])
+		}
+	}
+	hresp, err := client.Do(hreq)
+	if err != nil {
+		return Result{}, fmt.Errorf("rspamd request: %v", err)
+	}
+	defer func() {
+		err := hresp.Body.Close()
+		log.Check(err, "closing response body")
+	}()
+	if hresp.StatusCode != http.StatusOK {
+		return Result{}, fmt.Errorf("%w: status %q, expected 200 ok", ErrResponse, hresp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(hresp.Body, 1024*1024)).Decode(&result); err != nil {
+		return Result{}, fmt.Errorf("%w: parsing json: %v", ErrResponse, err)
+	}
+	switch result.Action {
+	case ActionNoAction, ActionGreylist, ActionAddHeader, ActionRewriteSubject, ActionSoftReject, ActionReject:
+	case "":
+		// Rspamd sets no action for skipped messages, e.g. because of settings.
+		if !result.IsSkipped {
+			return Result{}, fmt.Errorf("%w: missing action", ErrResponse)
+		}
+		result.Action = ActionNoAction
+	default:
+		return Result{}, fmt.Errorf("%w: unknown action %q", ErrResponse, result.Action)
+	}
+	return result, nil
+}
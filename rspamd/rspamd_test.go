@@ -0,0 +1,101 @@
+package rspamd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+)
+
+func TestCheck(t *testing.T) {
+	log := mlog.New("rspamd", nil)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/checkv2" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Password") != "secret" {
+			http.Error(w, "unauthorized", http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("IP") != "127.0.0.10" || r.Header.Get("Rcpt") != "mjl@mox.example" || r.Header.Get("Helo") != "" {
+			t.Errorf("unexpected request headers %v", r.Header)
+		}
+		buf, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(buf), "SPAM"):
+			w.Write([]byte(`{"is_skipped":false,"score":16.5,"required_score":15,"action":"reject","symbols":{"BAYES_SPAM":{"name":"BAYES_SPAM","score":5.1},"R_SPF_FAIL":{"name":"R_SPF_FAIL","score":1},"ZERO":{"name":"ZERO","score":0},"DMARC_POLICY_ALLOW":{"name":"DMARC_POLICY_ALLOW","score":-0.5}}}`))
+		case strings.Contains(string(buf), "SKIP"):
+			w.Write([]byte(`{"is_skipped":true}`))
+		case strings.Contains(string(buf), "BOGUS"):
+			w.Write([]byte(`{"score":1,"action":"bogus"}`))
+		default:
+			w.Write([]byte(`{"is_skipped":false,"score":-1.2,"required_score":15,"action":"no action","symbols":{}}`))
+		}
+	}))
+	defer srv.Close()
+
+	conf := &config.Rspamd{URL: srv.URL + "/", EffectivePassword: "secret", Timeout: 5 * time.Second}
+	req := Request{IP: "127.0.0.10", From: "remote@example.org", Rcpt: "mjl@mox.example"}
+
+	test := func(msg string, expAction Action, expErr error) Result {
+		t.Helper()
+		result, err := Check(context.Background(), log, conf, req, strings.NewReader(msg), int64(len(msg)))
+		if expErr == nil && err != nil || expErr != nil && !errors.Is(err, expErr) {
+			t.Fatalf("got err %v, expected %v", err, expErr)
+		}
+		if result.Action != expAction {
+			t.Fatalf("got action %q, expected %q", result.Action, expAction)
+		}
+		return result
+	}
+
+	r := test("Subject: hi\r\n\r\nclean\r\n", ActionNoAction, nil)
+	if r.Action.Junk() || r.Score != -1.2 {
+		t.Fatalf("unexpected result %#v", r)
+	}
+	r = test("Subject: hi\r\n\r\nSPAM\r\n", ActionReject, nil)
+	if !r.Action.Junk() || r.Score != 16.5 || r.RequiredScore != 15 {
+		t.Fatalf("unexpected result %#v", r)
+	}
+	top := r.TopSymbols(2)
+	if len(top) != 2 || top[0].Name != "BAYES_SPAM" || top[1].Name != "R_SPF_FAIL" {
+		t.Fatalf("unexpected top symbols %v", top)
+	}
+	if l := r.TopSymbols(10); len(l) != 3 || l[2].Name != "DMARC_POLICY_ALLOW" {
+		t.Fatalf("unexpected top symbols %v", l)
+	}
+	test("Subject: hi\r\n\r\nSKIP\r\n", ActionNoAction, nil)
+	test("Subject: hi\r\n\r\nBOGUS\r\n", "", ErrResponse)
+
+	conf.EffectivePassword = "wrong"
+	test("Subject: hi\r\n\r\nclean\r\n", "", ErrResponse)
+
+	// Rspamd not running.
+	srv.Close()
+	_, err := Check(context.Background(), log, conf, req, strings.NewReader("test"), 4)
+	if err == nil || errors.Is(err, ErrResponse) {
+		t.Fatalf("got err %v, expected connection error", err)
+	}
+}
+
+func TestActionJunk(t *testing.T) {
+	for _, a := range []Action{ActionNoAction, ActionGreylist} {
+		if a.Junk() {
+			t.Fatalf("action %q is junk, expected not", a)
+		}
+	}
+	for _, a := range []Action{ActionAddHeader, ActionRewriteSubject, ActionSoftReject, ActionReject} {
+		if !a.Junk() {
+			t.Fatalf("action %q is not junk, expected junk", a)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package sasl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrOAuthSyntax is returned by the parse functions for malformed OAuth client
+// responses.
+var ErrOAuthSyntax = errors.New("malformed oauth client response")
+
+type clientOAuthBearer struct {
+	Username, Token string
+	step            int
+}
+
+var _ Client = (*clientOAuthBearer)(nil)
+
+// NewClientOAuthBearer returns a client for SASL OAUTHBEARER authentication with
+// an OAuth 2.0 bearer token.
+//
+// OAUTHBEARER is specified in RFC 7628, A Set of Simple Authentication and
+// Security Layer (SASL) Mechanisms for OAuth.
+func NewClientOAuthBearer(username, token string) Client {
+	return &clientOAuthBearer{username, token, 0}
+}
+
+func (a *clientOAuthBearer) Info() (name string, hasCleartextCredentials bool) {
+	return "OAUTHBEARER", true
+}
+
+func (a *clientOAuthBearer) Next(fromServer []byte) (toServer []byte, last bool, rerr error) {
+	defer func() { a.step++ }()
+	switch a.step {
+	case 0:
+		// ../rfc/7628
+		var authz string
+		if a.Username != "" {
+			authz = "a=" + strings.NewReplacer("=", "=3D", ",", "=2C").Replace(a.Username)
+		}
+		return fmt.Appendf(nil, "n,%s,\x01auth=Bearer %s\x01\x01", authz, a.Token), true, nil
+	case 1:
+		// Server sent an error, we must respond with a dummy response. ../rfc/7628
+		return []byte("\x01"), true, nil
+	default:
+		return nil, false, fmt.Errorf("invalid step %d", a.step)
+	}
+}
+
+type clientXOAuth2 struct {
+	Username, Token string
+	step            int
+}
+
+var _ Client = (*clientXOAuth2)(nil)
+
+// NewClientXOAuth2 returns a client for the non-standard SASL XOAUTH2
+// authentication with an OAuth 2.0 bearer token, as implemented by Google and
+// Microsoft, and supported by many email clients.
+//
+// See https://developers.google.com/gmail/imap/xoauth2-protocol
+func NewClientXOAuth2(username, token string) Client {
+	return &clientXOAuth2{username, token, 0}
+}
+
+func (a *clientXOAuth2) Info() (name string, hasCleartextCredentials bool) {
+	return "XOAUTH2", true
+}
+
+func (a *clientXOAuth2) Next(fromServer []byte) (toServer []byte, last bool, rerr error) {
+	defer func() { a.step++ }()
+	switch a.step {
+	case 0:
+		return fmt.Appendf(nil, "user=%s\x01auth=Bearer %s\x01\x01", a.Username, a.Token), true, nil
+	case 1:
+		// Server sent an error, we must respond with an empty response.
+		return []byte{}, true, nil
+	default:
+		return nil, false, fmt.Errorf("invalid step %d", a.step)
+	}
+}
+
+// ParseOAuthBearer parses the initial client response of the OAUTHBEARER
+// mechanism, for servers. The authorization identity is optional. If the client
+// response is just a single 0x01, the client is acknowledging an error response
+// and ErrOAuthSyntax is returned.
+func ParseOAuthBearer(buf []byte) (authz, token string, rerr error) {
+	// ../rfc/7628
+	s := string(buf)
+	gs2, rest, ok := strings.Cut(s, "\x01")
+	if !ok || gs2 == "" {
+		return "", "", fmt.Errorf("%w: missing gs2 header", ErrOAuthSyntax)
+	}
+	t := strings.Split(gs2, ",")
+	if len(t) != 3 || t[2] != "" || t[0] != "n" && t[0] != "y" {
+		return "", "", fmt.Errorf("%w: bad gs2 header, channel binding not supported", ErrOAuthSyntax)
+	}
+	if t[1] != "" {
+		if !strings.HasPrefix(t[1], "a=") {
+			return "", "", fmt.Errorf("%w: bad authorization identity in gs2 header", ErrOAuthSyntax)
+		}
+		authz = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(t[1][2:])
+	}
+	token, err := parseOAuthKeyValues(rest)
+	if err != nil {
+		return "", "", err
+	}
+	return authz, token, nil
+}
+
+// ParseXOAuth2 parses the initial client response of the XOAUTH2 mechanism, for
+// servers.
+func ParseXOAuth2(buf []byte) (username, token string, rerr error) {
+	s := string(buf)
+	user, rest, ok := strings.Cut(s, "\x01")
+	if !ok || !strings.HasPrefix(user, "user=") {
+		return "", "", fmt.Errorf("%w: missing user", ErrOAuthSyntax)
+	}
+	token, err := parseOAuthKeyValues(rest)
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimPrefix(user, "user="), token, nil
+}
+
+// parseOAuthKeyValues parses 0x01-separated key/value pairs, ending with an empty
+// pair, returning the bearer token from the "auth" key.
+func parseOAuthKeyValues(s string) (token string, rerr error) {
+	s, ok := strings.CutSuffix(s, "\x01")
+	if !ok {
+		return "", fmt.Errorf("%w: missing final separator", ErrOAuthSyntax)
+	}
+	s, ok = strings.CutSuffix(s, "\x01")
+	if !ok {
+		return "", fmt.Errorf("%w: missing bearer token", ErrOAuthSyntax)
+	}
+	for _, kv := range strings.Split(s, "\x01") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return "", fmt.Errorf("%w: bad key/value pair", ErrOAuthSyntax)
+		}
+		if k == "auth" {
+			// Scheme is case-insensitive. ../rfc/6750
+			scheme, tok, ok := strings.Cut(v, " ")
+			if !ok || !strings.EqualFold(scheme, "bearer") || tok == "" {
+				return "", fmt.Errorf("%w: auth value must be bearer token", ErrOAuthSyntax)
+			}
+			token = tok
+		}
+	}
+	if token == "" {
+		return "", fmt.Errorf("%w: missing bearer token", ErrOAuthSyntax)
+	}
+	return token, nil
+}
@@ -5,14 +5,18 @@ import (
 	"os"
 	"time"
 
+	"github.com/mjl-/mox/admin"
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/http"
 	"github.com/mjl-/mox/imapserver"
+	"github.com/mjl-/mox/managesieveserver"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/mtastsdb"
+	"github.com/mjl-/mox/pop3server"
 	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/rejectdb"
 	"github.com/mjl-/mox/smtpserver"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/tlsrptdb"
@@ -56,6 +60,8 @@ func shutdown(log mlog.Log) {
 func start(mtastsdbRefresher, sendDMARCReports, sendTLSReports, skipForkExec bool) error {
 	smtpserver.Listen()
 	imapserver.Listen()
+	pop3server.Listen()
+	managesieveserver.Listen()
 	http.Listen()
 
 	if !skipForkExec {
@@ -82,6 +88,10 @@ func start(mtastsdbRefresher, sendDMARCReports, sendTLSReports, skipForkExec boo
 		return fmt.Errorf("dmarcdb init: %s", err)
 	}
 
+	if err := rejectdb.Init(); err != nil {
+		return fmt.Errorf("rejectdb init: %s", err)
+	}
+
 	if err := store.Init(mox.Context); err != nil {
 		return fmt.Errorf("store init: %s", err)
 	}
@@ -100,10 +110,29 @@ func start(mtastsdbRefresher, sendDMARCReports, sendTLSReports, skipForkExec boo
 	}
 
 	store.StartAuthCache()
+	store.StartDigests()
+	store.StartRetention()
+	store.StartAutoArchive()
+	store.StartDedupCleanup()
+	store.StartAttachmentLinkCleanup()
+	rejectdb.Start()
+	admin.StartRetire()
+	if bs := mox.Conf.Static.BackupSchedule; bs != nil {
+		startBackupSchedule(*bs)
+	}
+	if err := store.StartAuthEvents(mlog.New("store", nil)); err != nil {
+		return fmt.Errorf("auth events start: %s", err)
+	}
 	smtpserver.Serve()
 	imapserver.Serve()
+	pop3server.Serve()
+	managesieveserver.Serve()
 	http.Serve()
 
+	if c := mox.Conf.Static.MetricsPush; c != nil {
+		mox.StartMetricsPush(mox.Shutdown, c)
+	}
+
 	go func() {
 		store.Switchboard()
 		<-make(chan struct{})
@@ -5,6 +5,7 @@ package main
 import (
 	"context"
 	cryptorand "crypto/rand"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log/slog"
@@ -22,6 +23,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 
+	"github.com/mjl-/mox/canary"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/dnsbl"
 	"github.com/mjl-/mox/message"
@@ -34,15 +36,41 @@ import (
 	"github.com/mjl-/mox/updates"
 )
 
-var metricDNSBL = promauto.NewGaugeVec(
-	prometheus.GaugeOpts{
-		Name: "mox_dnsbl_ips_success",
-		Help: "DNSBL lookups to configured DNSBLs of our IPs.",
-	},
-	[]string{
-		"zone",
-		"ip",
-	},
+var (
+	metricDNSBL = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mox_dnsbl_ips_success",
+			Help: "DNSBL lookups to configured DNSBLs of our IPs: 1 if not listed, 0 if listed, -1 if the lookup failed or the zone is unhealthy.",
+		},
+		[]string{
+			"zone",
+			"ip",
+		},
+	)
+	metricDNSBLZoneHealthy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mox_dnsbl_zone_healthy",
+			Help: "Health of configured DNSBL zones, as with \"mox dnsbl checkhealth\": 1 if healthy, 0 if unhealthy (e.g. listing all IPs), -1 if the check failed due to DNS errors.",
+		},
+		[]string{
+			"zone",
+		},
+	)
+	metricDNSBLZoneListed = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mox_dnsbl_zone_ips_listed",
+			Help: "Number of our IPs listed in configured DNSBL zones.",
+		},
+		[]string{
+			"zone",
+		},
+	)
+	metricDNSBLLastCheck = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mox_dnsbl_check_last_timestamp_seconds",
+			Help: "Time of the last completed check of our IPs against the configured DNSBL zones.",
+		},
+	)
 )
 
 func monitorDNSBL(log mlog.Log) {
@@ -56,8 +84,6 @@ func monitorDNSBL(log mlog.Log) {
 		}
 	}()
 
-	publicListener := mox.Conf.Static.Listeners["public"]
-
 	// We keep track of the previous metric values, so we can delete those we no longer
 	// monitor.
 	type key struct {
@@ -65,6 +91,7 @@ func monitorDNSBL(log mlog.Log) {
 		ip   string
 	}
 	prevResults := map[key]struct{}{}
+	prevZones := map[dns.Domain]struct{}{}
 
 	// Last time we checked, and how many outgoing delivery connections were made at that time.
 	var last time.Time
@@ -84,8 +111,15 @@ func monitorDNSBL(log mlog.Log) {
 		lastConns = conns
 		last = time.Now()
 
-		// Gather zones.
-		zones := slices.Clone(publicListener.SMTP.DNSBLZones)
+		// Gather zones, from all SMTP listeners and the zones only used for monitoring.
+		var zones []dns.Domain
+		for _, l := range mox.Conf.Static.Listeners {
+			for _, zone := range l.SMTP.DNSBLZones {
+				if !slices.Contains(zones, zone) {
+					zones = append(zones, zone)
+				}
+			}
+		}
 		conf := mox.Conf.DynamicConfig()
 		for _, zone := range conf.MonitorDNSBLZones {
 			if !slices.Contains(zones, zone) {
@@ -119,10 +153,41 @@ func monitorDNSBL(log mlog.Log) {
 				delete(prevResults, k)
 			}
 		}
+		for zone := range prevZones {
+			if !slices.Contains(zones, zone) {
+				metricDNSBLZoneHealthy.DeleteLabelValues(zone.Name())
+				metricDNSBLZoneListed.DeleteLabelValues(zone.Name())
+				delete(prevZones, zone)
+			}
+		}
+
+		// Do DNSBL checks and update metric. An unhealthy zone, e.g. one that lists all
+		// IPs because we are querying it through a public DNS resolver, would result in
+		// false listings, so we don't look up our IPs in those zones.
+		for _, zone := range zones {
+			prevZones[zone] = struct{}{}
+			err := dnsbl.CheckHealth(mox.Context, log.Logger, resolver, zone)
+			healthy := err == nil
+			var hv float64
+			if err == nil {
+				hv = 1
+			} else if errors.Is(err, dnsbl.ErrDNS) {
+				hv = -1
+				log.Infox("dnsbl monitor health check", err, slog.Any("zone", zone))
+			} else {
+				log.Errorx("dnsbl monitor health check, zone unhealthy, not checking our ips", err, slog.Any("zone", zone))
+			}
+			metricDNSBLZoneHealthy.WithLabelValues(zone.Name()).Set(hv)
+
+			var listed int
+			for _, ip := range publicIPs {
+				k := key{zone, ip.String()}
+				prevResults[k] = struct{}{}
+				if !healthy {
+					metricDNSBL.WithLabelValues(zone.Name(), ip.String()).Set(-1)
+					continue
+				}
 
-		// Do DNSBL checks and update metric.
-		for _, ip := range publicIPs {
-			for _, zone := range zones {
 				status, expl, err := dnsbl.Lookup(mox.Context, log.Logger, resolver, zone, ip)
 				if err != nil {
 					log.Errorx("dnsbl monitor lookup", err,
@@ -132,16 +197,21 @@ func monitorDNSBL(log mlog.Log) {
 						slog.Any("status", status))
 				}
 				var v float64
-				if status == dnsbl.StatusPass {
+				switch status {
+				case dnsbl.StatusPass:
 					v = 1
+				case dnsbl.StatusFail:
+					listed++
+				default:
+					v = -1
 				}
 				metricDNSBL.WithLabelValues(zone.Name(), ip.String()).Set(v)
-				k := key{zone, ip.String()}
-				prevResults[k] = struct{}{}
 
 				time.Sleep(time.Second)
 			}
+			metricDNSBLZoneListed.WithLabelValues(zone.Name()).Set(float64(listed))
 		}
+		metricDNSBLLastCheck.SetToCurrentTime()
 	}
 }
 
@@ -154,6 +224,15 @@ IMAP. HTTP listeners are started for the admin/account web interfaces, and for
 automated TLS configuration. Missing essential TLS certificates are immediately
 requested, other TLS certificates are requested on demand.
 
+Mox must be started as root. It binds the sockets for the listeners, and then
+starts itself as unprivileged user. With systemd socket activation, the sockets
+passed by systemd are used for listeners with a matching address (IP and port,
+or path for unix domain sockets), instead of binding them. Systemd keeps the
+sockets open while mox restarts, so incoming connections wait instead of being
+refused. Sockets must match the addresses configured for the listeners exactly,
+e.g. a systemd socket listening on port 25 of all IPv6 addresses matches IP "::"
+in a listener, not "0.0.0.0".
+
 Only implemented on unix systems, not Windows.
 `
 	args := c.Parse()
@@ -364,6 +443,7 @@ Only implemented on unix systems, not Windows.
 	}
 
 	go monitorDNSBL(log)
+	canary.Start()
 
 	ctlpath := mox.DataDirPath("ctl")
 	_ = os.Remove(ctlpath)
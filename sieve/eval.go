@@ -0,0 +1,885 @@
+package sieve
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrEval is wrapped by errors returned by Evaluate.
+var ErrEval = errors.New("evaluating sieve script")
+
+// EvalError is an error during evaluation of a script, e.g. conflicting actions or
+// too many redirects.
+type EvalError struct {
+	Line int
+	Msg  string
+}
+
+func (e EvalError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+func (e EvalError) Unwrap() error {
+	return ErrEval
+}
+
+// Limits during evaluation.
+const (
+	maxRedirects = 4         // Per script evaluation.
+	maxVarLength = 64 * 1024 // Longer values for variables are truncated.
+	maxMatchWork = 100000    // Steps for :matches, after which the key doesn't match.
+)
+
+// Message is an incoming message that a script is evaluated against.
+type Message struct {
+	Header textproto.MIMEHeader // Raw header values, encoded words are decoded during evaluation.
+	Size   int64
+
+	// Envelope, SMTP MAIL FROM and RCPT TO addresses, without brackets. From is empty
+	// for the null reverse path.
+	EnvelopeFrom string
+	EnvelopeTo   string
+
+	// Separators between the user and detail part of localparts, for the
+	// "subaddress" extension. If empty, localparts have no detail part.
+	Separators []string
+
+	// Body returns the text of the message body for the "body" test. If raw is
+	// set, the undecoded body is returned. Otherwise the decoded text of the parts
+	// with a content-type matching one of types, where "text" matches all text/*
+	// parts, "text/plain" only text/plain parts, and the empty string all parts. If
+	// nil, the body test never matches.
+	Body func(raw bool, types []string) []string
+
+	// MailboxExists returns whether a mailbox exists, for the "mailboxexists" test.
+	// If nil, no mailboxes exist.
+	MailboxExists func(name string) bool
+}
+
+// Result holds the actions to take after evaluating a script.
+type Result struct {
+	// Whether to deliver the message to the default mailbox, due to an explicit
+	// "keep" or the implicit keep, and flags for the message.
+	Keep      bool
+	KeepFlags []string
+
+	FileInto []FileInto
+	Redirect []string // Addresses to forward the message to.
+
+	// Whether the message is to be rejected, with the reason. For "ereject", the
+	// message is to be rejected during the SMTP transaction.
+	Reject       bool
+	RejectReason string
+
+	Vacation *Vacation // Automatic reply to send, if any.
+}
+
+// FileInto is a delivery to a mailbox.
+type FileInto struct {
+	Mailbox string
+	Flags   []string
+	Create  bool // Create the mailbox if it doesn't exist.
+}
+
+// Vacation is an automatic reply, for the "vacation" extension.
+type Vacation struct {
+	Reason    string
+	Subject   string // Can be empty, a subject is then derived from the incoming message.
+	From      string
+	Addresses []string // Additional addresses of the recipient.
+
+	// Minimum number of days between replies to the same sender for this Handle.
+	Days int
+
+	// Identifies the vacation action, for remembering to whom replies were sent. If
+	// not specified in the script, it is derived from the arguments.
+	Handle string
+}
+
+// Evaluate runs script s against message m and returns the actions to take. On
+// error, the returned result only has Keep set, the implicit keep, and the error
+// wraps ErrEval. ../rfc/5228:1118
+func Evaluate(s *Script, m Message) (rr Result, rerr error) {
+	e := &evaluator{
+		s:            s,
+		m:            m,
+		variables:    slices.Contains(s.Require, "variables"),
+		vars:         map[string]string{},
+		implicitKeep: true,
+	}
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(EvalError); ok {
+			rr = Result{Keep: true}
+			rerr = err
+			return
+		}
+		panic(x)
+	}()
+
+	e.xcommands(s.Commands)
+	e.xcheckConflicts()
+
+	r := e.r
+	if !r.Keep && e.implicitKeep {
+		r.Keep = true
+		r.KeepFlags = e.internalFlags()
+	}
+	return r, nil
+}
+
+// The imap4flags internal variable, for flag commands without variable name. It
+// cannot be referenced by scripts. ../rfc/5232:181
+const internalFlagsVar = "\x00flags"
+
+type evaluator struct {
+	s         *Script
+	m         Message
+	variables bool // Whether the variables extension is required, and strings are expanded.
+
+	vars      map[string]string // Lower case names.
+	matchVars []string          // From last successful :matches.
+
+	r            Result
+	implicitKeep bool
+	stopped      bool
+	line         int // Of command being evaluated, for errors.
+}
+
+func (e *evaluator) xerrorf(format string, args ...any) {
+	panic(EvalError{e.line, fmt.Sprintf(format, args...)})
+}
+
+// xcheckConflicts checks for combinations of actions that are not allowed.
+func (e *evaluator) xcheckConflicts() {
+	r := e.r
+	// ../rfc/5429:261
+	if r.Reject && (r.Keep || len(r.FileInto) > 0 || len(r.Redirect) > 0 || r.Vacation != nil) {
+		e.xerrorf("reject cannot be combined with keep, fileinto, redirect or vacation")
+	}
+}
+
+func (e *evaluator) xcommands(cmds []Command) {
+	// Whether the preceding if or elsif matched, causing following elsif/else to be
+	// skipped.
+	var matched bool
+	for _, c := range cmds {
+		if e.stopped {
+			return
+		}
+		e.line = c.Line
+		switch c.Name {
+		case "require":
+		case "if":
+			matched = e.xtest(c.Tests[0])
+			if matched {
+				e.xcommands(c.Block)
+			}
+		case "elsif":
+			if !matched {
+				matched = e.xtest(c.Tests[0])
+				if matched {
+					e.xcommands(c.Block)
+				}
+			}
+		case "else":
+			if !matched {
+				e.xcommands(c.Block)
+			}
+		case "stop":
+			e.stopped = true
+		default:
+			e.xaction(c)
+		}
+	}
+}
+
+// args splits arguments into tags with their parameter, and positional arguments.
+// Tags without parameter have a zero Arg.
+func args(l []Arg) (tags map[string]Arg, positional []Arg) {
+	tags = map[string]Arg{}
+	for i := 0; i < len(l); i++ {
+		a := l[i]
+		if a.Tag == "" {
+			positional = append(positional, a)
+			continue
+		}
+		if _, ok := tagParams[a.Tag]; ok && i+1 < len(l) {
+			i++
+			tags[a.Tag] = l[i]
+		} else {
+			tags[a.Tag] = Arg{}
+		}
+	}
+	return
+}
+
+func (e *evaluator) xaction(c Command) {
+	tags, pos := args(c.Args)
+	_, isCopy := tags["copy"]
+
+	switch c.Name {
+	case "keep":
+		// ../rfc/5228:1194
+		e.r.Keep = true
+		e.r.KeepFlags = e.flagsArg(tags)
+
+	case "discard":
+		// ../rfc/5228:1284
+		e.implicitKeep = false
+
+	case "fileinto":
+		// ../rfc/5228:1226
+		name := e.expand(pos[0].Strings[0])
+		if strings.EqualFold(name, "inbox") {
+			name = "Inbox"
+		}
+		_, create := tags["create"]
+		if !slices.ContainsFunc(e.r.FileInto, func(f FileInto) bool { return f.Mailbox == name }) {
+			e.r.FileInto = append(e.r.FileInto, FileInto{name, e.flagsArg(tags), create})
+		}
+		if !isCopy {
+			e.implicitKeep = false
+		}
+
+	case "redirect":
+		// ../rfc/5228:1242
+		addr := strings.TrimSpace(e.expand(pos[0].Strings[0]))
+		if _, err := mail.ParseAddress(addr); err != nil || strings.ContainsAny(addr, "<> ") {
+			e.xerrorf("invalid redirect address %q", addr)
+		}
+		if !slices.ContainsFunc(e.r.Redirect, func(s string) bool { return strings.EqualFold(s, addr) }) {
+			if len(e.r.Redirect) >= maxRedirects {
+				e.xerrorf("too many redirects, max %d", maxRedirects)
+			}
+			e.r.Redirect = append(e.r.Redirect, addr)
+		}
+		if !isCopy {
+			e.implicitKeep = false
+		}
+
+	case "reject", "ereject":
+		// ../rfc/5429:191
+		if e.r.Reject {
+			e.xerrorf("multiple reject actions")
+		}
+		e.r.Reject = true
+		e.r.RejectReason = e.expand(pos[0].Strings[0])
+		e.implicitKeep = false
+
+	case "vacation":
+		// ../rfc/5230:212
+		if e.r.Vacation != nil {
+			e.xerrorf("multiple vacation actions")
+		}
+		v := &Vacation{Reason: e.expand(pos[0].Strings[0]), Days: 7}
+		if a, ok := tags["days"]; ok {
+			v.Days = int(min(max(*a.Number, 1), 30))
+		}
+		if a, ok := tags["subject"]; ok {
+			v.Subject = e.expand(a.Strings[0])
+		}
+		if a, ok := tags["from"]; ok {
+			v.From = e.expand(a.Strings[0])
+		}
+		if a, ok := tags["addresses"]; ok {
+			for _, s := range a.Strings {
+				v.Addresses = append(v.Addresses, e.expand(s))
+			}
+		}
+		if a, ok := tags["handle"]; ok {
+			v.Handle = e.expand(a.Strings[0])
+		} else {
+			// ../rfc/5230:293
+			h := sha256.Sum256([]byte(v.Subject + "\x00" + v.From + "\x00" + v.Reason))
+			v.Handle = "auto:" + hex.EncodeToString(h[:16])
+		}
+		e.r.Vacation = v
+
+	case "setflag", "addflag", "removeflag":
+		// ../rfc/5232:224
+		name := internalFlagsVar
+		flags := pos[0].Strings
+		if len(pos) == 2 {
+			name = strings.ToLower(pos[0].Strings[0])
+			flags = pos[1].Strings
+		}
+		var l []string
+		for _, s := range flags {
+			l = append(l, splitFlags(e.expand(s))...)
+		}
+		cur := splitFlags(e.vars[name])
+		switch c.Name {
+		case "setflag":
+			cur = nil
+			fallthrough
+		case "addflag":
+			for _, f := range l {
+				if !slices.ContainsFunc(cur, func(s string) bool { return strings.EqualFold(s, f) }) {
+					cur = append(cur, f)
+				}
+			}
+		case "removeflag":
+			cur = slices.DeleteFunc(cur, func(s string) bool {
+				return slices.ContainsFunc(l, func(f string) bool { return strings.EqualFold(s, f) })
+			})
+		}
+		e.setVar(name, strings.Join(cur, " "))
+
+	case "set":
+		// ../rfc/5229:313
+		name := strings.ToLower(pos[0].Strings[0])
+		if !validVarName(name) {
+			e.xerrorf("invalid variable name %q", name)
+		}
+		v := e.expand(pos[1].Strings[0])
+		// Modifiers are applied from highest to lowest precedence. ../rfc/5229:335
+		if _, ok := tags["lower"]; ok {
+			v = strings.ToLower(v)
+		}
+		if _, ok := tags["upper"]; ok {
+			v = strings.ToUpper(v)
+		}
+		if _, ok := tags["lowerfirst"]; ok {
+			v = mapFirst(v, unicode.ToLower)
+		}
+		if _, ok := tags["upperfirst"]; ok {
+			v = mapFirst(v, unicode.ToUpper)
+		}
+		if _, ok := tags["quotewildcard"]; ok {
+			r := strings.NewReplacer(`*`, `\*`, `?`, `\?`, `\`, `\\`)
+			v = r.Replace(v)
+		}
+		if _, ok := tags["length"]; ok {
+			v = strconv.Itoa(utf8.RuneCountInString(v))
+		}
+		e.setVar(name, v)
+
+	default:
+		e.xerrorf("unknown command %q", c.Name)
+	}
+}
+
+func mapFirst(s string, fn func(rune) rune) string {
+	r, n := utf8.DecodeRuneInString(s)
+	if n == 0 {
+		return s
+	}
+	return string(fn(r)) + s[n:]
+}
+
+func (e *evaluator) setVar(name, v string) {
+	if len(v) > maxVarLength {
+		v = v[:maxVarLength]
+	}
+	e.vars[name] = v
+}
+
+// flagsArg returns the flags from a :flags tag, or otherwise the flags of the
+// internal variable. ../rfc/5232:310
+func (e *evaluator) flagsArg(tags map[string]Arg) []string {
+	a, ok := tags["flags"]
+	if !ok {
+		return e.internalFlags()
+	}
+	var l []string
+	for _, s := range a.Strings {
+		for _, f := range splitFlags(e.expand(s)) {
+			if !slices.ContainsFunc(l, func(s string) bool { return strings.EqualFold(s, f) }) {
+				l = append(l, f)
+			}
+		}
+	}
+	return l
+}
+
+func (e *evaluator) internalFlags() []string {
+	return splitFlags(e.vars[internalFlagsVar])
+}
+
+func splitFlags(s string) []string {
+	l := strings.Fields(s)
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+func validVarName(s string) bool {
+	if s == "" || !isAlpha(s[0]) {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if !isAlpha(c) && !isDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// expand replaces variable references if the variables extension is in use.
+// Unknown variables expand to the empty string, invalid references are left
+// as is. ../rfc/5229:178
+func (e *evaluator) expand(s string) string {
+	if !e.variables || !strings.Contains(s, "${") {
+		return s
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			b.WriteString(s)
+			break
+		}
+		name := s[2:end]
+		if n, err := strconv.ParseUint(name, 10, 32); err == nil && isDigit(name[0]) {
+			if int(n) < len(e.matchVars) {
+				b.WriteString(e.matchVars[n])
+			}
+		} else if validVarName(strings.ToLower(name)) {
+			b.WriteString(e.vars[strings.ToLower(name)])
+		} else if isNamespaced(name) {
+			// No namespaces are supported, they expand to the empty string.
+		} else {
+			// Not a variable reference, continue after the "$".
+			b.WriteString("$")
+			s = s[1:]
+			continue
+		}
+		s = s[end+1:]
+		if b.Len() > maxVarLength {
+			break
+		}
+	}
+	return b.String()
+}
+
+func isNamespaced(s string) bool {
+	l := strings.Split(s, ".")
+	if len(l) < 2 {
+		return false
+	}
+	for _, t := range l {
+		if !validVarName(strings.ToLower(t)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *evaluator) xtest(t Test) bool {
+	tags, pos := args(t.Args)
+
+	switch t.Name {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "not":
+		return !e.xtest(t.Tests[0])
+	case "allof":
+		for _, tt := range t.Tests {
+			if !e.xtest(tt) {
+				return false
+			}
+		}
+		return true
+	case "anyof":
+		for _, tt := range t.Tests {
+			if e.xtest(tt) {
+				return true
+			}
+		}
+		return false
+
+	case "exists":
+		// ../rfc/5228:1566
+		for _, h := range pos[0].Strings {
+			if len(e.m.Header.Values(e.expand(h))) == 0 {
+				return false
+			}
+		}
+		return true
+
+	case "size":
+		// ../rfc/5228:1590
+		n := *pos[0].Number
+		if _, ok := tags["over"]; ok {
+			return e.m.Size > n
+		}
+		return e.m.Size < n
+
+	case "header":
+		// ../rfc/5228:1486
+		var values []string
+		for _, h := range pos[0].Strings {
+			for _, v := range e.m.Header.Values(e.expand(h)) {
+				values = append(values, decodeHeader(v))
+			}
+		}
+		return e.xmatch(tags, values, pos[1].Strings, false)
+
+	case "address":
+		// ../rfc/5228:1416
+		var values []string
+		for _, h := range pos[0].Strings {
+			for _, v := range e.m.Header.Values(e.expand(h)) {
+				for _, addr := range parseAddresses(v) {
+					if s, ok := e.addressPart(tags, addr); ok {
+						values = append(values, s)
+					}
+				}
+			}
+		}
+		return e.xmatch(tags, values, pos[1].Strings, false)
+
+	case "envelope":
+		// ../rfc/5228:1513
+		var values []string
+		for _, p := range pos[0].Strings {
+			var addr string
+			switch strings.ToLower(e.expand(p)) {
+			case "from":
+				addr = e.m.EnvelopeFrom
+			case "to":
+				addr = e.m.EnvelopeTo
+			default:
+				continue
+			}
+			if s, ok := e.addressPart(tags, addr); ok {
+				values = append(values, s)
+			}
+		}
+		return e.xmatch(tags, values, pos[1].Strings, false)
+
+	case "body":
+		// ../rfc/5173:131
+		if e.m.Body == nil {
+			return false
+		}
+		raw := false
+		types := []string{"text"}
+		if _, ok := tags["raw"]; ok {
+			raw = true
+			types = nil
+		} else if a, ok := tags["content"]; ok {
+			types = nil
+			for _, s := range a.Strings {
+				types = append(types, strings.ToLower(e.expand(s)))
+			}
+		}
+		return e.xmatch(tags, e.m.Body(raw, types), pos[0].Strings, false)
+
+	case "hasflag":
+		// ../rfc/5232:365
+		names := []string{internalFlagsVar}
+		keys := pos[0].Strings
+		if len(pos) == 2 {
+			names = pos[0].Strings
+			keys = pos[1].Strings
+		}
+		var values []string
+		for _, name := range names {
+			values = append(values, splitFlags(e.vars[strings.ToLower(name)])...)
+		}
+		return e.xmatch(tags, values, keys, false)
+
+	case "string":
+		// ../rfc/5229:466
+		var values []string
+		for _, s := range pos[0].Strings {
+			values = append(values, e.expand(s))
+		}
+		return e.xmatch(tags, values, pos[1].Strings, true)
+
+	case "mailboxexists":
+		// ../rfc/5490:108
+		for _, s := range pos[0].Strings {
+			if e.m.MailboxExists == nil || !e.m.MailboxExists(e.expand(s)) {
+				return false
+			}
+		}
+		return true
+	}
+	e.xerrorf("unknown test %q", t.Name)
+	panic("not reached")
+}
+
+func decodeHeader(s string) string {
+	s = strings.TrimSpace(s)
+	var dec mime.WordDecoder
+	if ds, err := dec.DecodeHeader(s); err == nil {
+		return ds
+	}
+	return s
+}
+
+// parseAddresses returns the addresses in a header value as localpart@domain. If
+// the value can't be parsed, it is returned as is, as single address.
+func parseAddresses(s string) []string {
+	l, err := mail.ParseAddressList(s)
+	if err != nil {
+		return []string{strings.TrimSpace(s)}
+	}
+	var r []string
+	for _, a := range l {
+		r = append(r, a.Address)
+	}
+	return r
+}
+
+// addressPart returns the part of address addr requested with tags. If false is
+// returned, the part is not present, e.g. no detail in the localpart.
+// ../rfc/5228:1027 ../rfc/5233:97
+func (e *evaluator) addressPart(tags map[string]Arg, addr string) (string, bool) {
+	localpart, domain := addr, ""
+	if i := strings.LastIndexByte(addr, '@'); i >= 0 {
+		localpart, domain = addr[:i], addr[i+1:]
+	}
+	user, detail, haveDetail := localpart, "", false
+	for _, sep := range e.m.Separators {
+		if sep == "" {
+			continue
+		}
+		if i := strings.Index(localpart, sep); i >= 0 && (!haveDetail || i < len(user)) {
+			user, detail, haveDetail = localpart[:i], localpart[i+len(sep):], true
+		}
+	}
+
+	if _, ok := tags["localpart"]; ok {
+		return localpart, true
+	} else if _, ok := tags["domain"]; ok {
+		return domain, true
+	} else if _, ok := tags["user"]; ok {
+		return user, true
+	} else if _, ok := tags["detail"]; ok {
+		return detail, haveDetail
+	}
+	return addr, true
+}
+
+// comparator implements comparisons of strings. ../rfc/4790
+type comparator string
+
+func (c comparator) normalize(s string) string {
+	if c == "i;ascii-casemap" {
+		return asciiLower(s)
+	}
+	return s
+}
+
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return r
+	}, s)
+}
+
+// compare returns -1, 0 or 1. For i;ascii-numeric, strings not starting with a
+// digit are positive infinity. ../rfc/4790:1066
+func (c comparator) compare(a, b string) int {
+	if c != "i;ascii-numeric" {
+		return strings.Compare(c.normalize(a), c.normalize(b))
+	}
+	num := func(s string) (string, bool) {
+		n := 0
+		for n < len(s) && isDigit(s[n]) {
+			n++
+		}
+		if n == 0 {
+			return "", false
+		}
+		s = strings.TrimLeft(s[:n], "0")
+		return s, true
+	}
+	na, oka := num(a)
+	nb, okb := num(b)
+	switch {
+	case !oka && !okb:
+		return 0
+	case !oka:
+		return 1
+	case !okb:
+		return -1
+	case len(na) != len(nb):
+		if len(na) < len(nb) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(na, nb)
+}
+
+func relational(op string, cmp int) bool {
+	switch op {
+	case "gt":
+		return cmp > 0
+	case "ge":
+		return cmp >= 0
+	case "lt":
+		return cmp < 0
+	case "le":
+		return cmp <= 0
+	case "eq":
+		return cmp == 0
+	case "ne":
+		return cmp != 0
+	}
+	return false
+}
+
+// xmatch returns whether any value matches any key, according to the comparator
+// and match type in tags. For :count with countNonEmpty, as for the "string"
+// test, only non-empty values are counted. ../rfc/5228:807
+func (e *evaluator) xmatch(tags map[string]Arg, values, keys []string, countNonEmpty bool) bool {
+	cmp := comparator("i;ascii-casemap")
+	if a, ok := tags["comparator"]; ok {
+		cmp = comparator(a.Strings[0])
+	}
+
+	if a, ok := tags["count"]; ok {
+		// ../rfc/5231:149
+		n := len(values)
+		if countNonEmpty {
+			n = 0
+			for _, v := range values {
+				if v != "" {
+					n++
+				}
+			}
+		}
+		for _, k := range keys {
+			if relational(a.Strings[0], cmp.compare(strconv.Itoa(n), e.expand(k))) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, k := range keys {
+		k = e.expand(k)
+		for _, v := range values {
+			if a, ok := tags["value"]; ok {
+				// ../rfc/5231:125
+				if relational(a.Strings[0], cmp.compare(v, k)) {
+					return true
+				}
+			} else if _, ok := tags["contains"]; ok {
+				if cmp == "i;ascii-numeric" {
+					e.xerrorf(":contains not supported with comparator i;ascii-numeric")
+				}
+				if strings.Contains(cmp.normalize(v), cmp.normalize(k)) {
+					return true
+				}
+			} else if _, ok := tags["matches"]; ok {
+				if cmp == "i;ascii-numeric" {
+					e.xerrorf(":matches not supported with comparator i;ascii-numeric")
+				}
+				if captures, ok := globMatch(cmp.normalize(k), v, cmp.normalize(v)); ok {
+					// ../rfc/5229:224
+					if e.variables {
+						e.matchVars = captures
+					}
+					return true
+				}
+			} else if cmp.compare(v, k) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// globMatch matches pattern against normalized value nv, with "*" matching zero
+// or more characters and "?" a single character, and backslash escaping the next
+// character. Wildcards match as few characters as possible. The returned
+// captures are from the original value v: the full value followed by the text
+// matched by each wildcard. ../rfc/5228:864 ../rfc/5229:238
+func globMatch(pattern, v, nv string) ([]string, bool) {
+	type token struct {
+		wildcard byte // '*' or '?', or 0 for literal.
+		c        rune
+	}
+	var tokens []token
+	pr := []rune(pattern)
+	for i := 0; i < len(pr); i++ {
+		switch c := pr[i]; c {
+		case '*', '?':
+			tokens = append(tokens, token{wildcard: byte(c)})
+		case '\\':
+			if i+1 < len(pr) {
+				i++
+			}
+			tokens = append(tokens, token{c: pr[i]})
+		default:
+			tokens = append(tokens, token{c: c})
+		}
+	}
+
+	// Normalization only changes ASCII characters, so positions in v and nv are the
+	// same.
+	vr := []rune(v)
+	nvr := []rune(nv)
+
+	var captures []string
+	work := 0
+	var match func(ti, vi int) bool
+	match = func(ti, vi int) bool {
+		work++
+		if work > maxMatchWork {
+			return false
+		}
+		if ti == len(tokens) {
+			return vi == len(nvr)
+		}
+		t := tokens[ti]
+		switch t.wildcard {
+		case '*':
+			for n := 0; vi+n <= len(nvr); n++ {
+				captures = append(captures, string(vr[vi:vi+n]))
+				if match(ti+1, vi+n) {
+					return true
+				}
+				captures = captures[:len(captures)-1]
+			}
+			return false
+		case '?':
+			if vi >= len(nvr) {
+				return false
+			}
+			captures = append(captures, string(vr[vi:vi+1]))
+			if match(ti+1, vi+1) {
+				return true
+			}
+			captures = captures[:len(captures)-1]
+			return false
+		}
+		return vi < len(nvr) && nvr[vi] == t.c && match(ti+1, vi+1)
+	}
+	if !match(0, 0) {
+		return nil, false
+	}
+	return append([]string{v}, captures...), true
+}
@@ -0,0 +1,151 @@
+package sieve
+
+import (
+	"errors"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	m := Message{
+		Header: textproto.MIMEHeader{
+			"From":       {`"Mjl" <mjl+lists@mox.example>`},
+			"To":         {"other@mox.example, Remote <remote@Remote.example>"},
+			"Subject":    {"=?utf-8?q?caf=C3=A9?= [list] hello"},
+			"List-Id":    {"Mox Users <mox-users.lists.example>"},
+			"X-Spam-Lvl": {"12"},
+		},
+		Size:         2000,
+		EnvelopeFrom: "bounce@lists.example",
+		EnvelopeTo:   "mjl+mox@mox.example",
+		Separators:   []string{"+"},
+		Body: func(raw bool, types []string) []string {
+			if raw {
+				return []string{"raw body"}
+			}
+			return []string{"hello there"}
+		},
+		MailboxExists: func(name string) bool { return name == "Archive" },
+	}
+
+	eval := func(script string) Result {
+		t.Helper()
+		s, err := Parse(script)
+		if err != nil {
+			t.Fatalf("parse %q: %v", script, err)
+		}
+		r, err := Evaluate(s, m)
+		if err != nil {
+			t.Fatalf("evaluate %q: %v", script, err)
+		}
+		return r
+	}
+	test := func(script string, exp Result) {
+		t.Helper()
+		r := eval(script)
+		if !reflect.DeepEqual(r, exp) {
+			t.Fatalf("evaluate %q:\ngot      %#v\nexpected %#v", script, r, exp)
+		}
+	}
+	bad := func(script string) {
+		t.Helper()
+		s, err := Parse(script)
+		if err != nil {
+			t.Fatalf("parse %q: %v", script, err)
+		}
+		r, err := Evaluate(s, m)
+		if err == nil || !errors.Is(err, ErrEval) {
+			t.Fatalf("evaluate %q: got err %v, expected eval error", script, err)
+		}
+		if !reflect.DeepEqual(r, Result{Keep: true}) {
+			t.Fatalf("evaluate %q: got result %#v after error, expected implicit keep", script, r)
+		}
+	}
+	keep := Result{Keep: true}
+	discard := Result{}
+
+	test("", keep)
+	test("keep;", keep)
+	test("discard;", discard)
+	test("discard; stop; keep;", discard)
+	test(`require "fileinto"; fileinto "Lists";`, Result{FileInto: []FileInto{{Mailbox: "Lists"}}})
+	test(`require ["fileinto", "copy"]; fileinto :copy "INBOX";`, Result{Keep: true, FileInto: []FileInto{{Mailbox: "Inbox"}}})
+	test(`redirect "other@remote.example"; redirect "Other@remote.example";`, Result{Redirect: []string{"other@remote.example"}})
+	test(`require "reject"; reject "no thanks";`, Result{Reject: true, RejectReason: "no thanks"})
+
+	// Tests and control flow.
+	test(`if header :contains "subject" "café" { discard; }`, discard)
+	test(`if header :is "subject" "CAFÉ [LIST] HELLO" { discard; }`, keep) // Only ASCII is case-folded.
+	test(`if header :matches "list-id" "*<*.lists.example>" { discard; }`, discard)
+	test(`if address :domain :is "to" "remote.example" { discard; }`, discard)
+	test(`if address :localpart :is "from" "mjl+lists" { discard; }`, discard)
+	test(`require "subaddress"; if address :detail "from" "lists" { discard; }`, discard)
+	test(`require "subaddress"; if address :user "to" "other" { discard; }`, discard)
+	test(`require "subaddress"; if address :detail :matches "to" "*" { discard; }`, keep) // No detail.
+	test(`require "envelope"; if envelope :all :is "from" "bounce@lists.example" { discard; }`, discard)
+	test(`require ["envelope", "subaddress"]; if envelope :detail "to" "mox" { discard; }`, discard)
+	test(`if exists ["from", "list-id"] { discard; }`, discard)
+	test(`if exists ["from", "absent"] { discard; }`, keep)
+	test(`if size :over 1k { discard; }`, discard)
+	test(`if size :under 1k { discard; }`, keep)
+	test(`if not anyof (false, header :is "x" "y") { discard; }`, discard)
+	test(`if allof (true, false) { discard; } elsif true { stop; } else { discard; }`, keep)
+	test(`require "body"; if body :contains "there" { discard; }`, discard)
+	test(`require "body"; if body :raw :is "raw body" { discard; }`, discard)
+	test(`require "mailbox"; if mailboxexists "Archive" { discard; }`, discard)
+	test(`require "mailbox"; if mailboxexists ["Archive", "Other"] { discard; }`, keep)
+	test(`require ["relational", "comparator-i;ascii-numeric"]; if header :value "ge" :comparator "i;ascii-numeric" "x-spam-lvl" "5" { discard; }`, discard)
+	test(`require ["relational"]; if header :value "ge" "x-spam-lvl" "5" { discard; }`, keep) // String comparison.
+	test(`require ["relational", "comparator-i;ascii-numeric"]; if address :count "eq" :comparator "i;ascii-numeric" "to" "2" { discard; }`, discard)
+
+	// Variables.
+	test(`require ["variables", "fileinto"]; if header :matches "list-id" "*<*.lists.example>" { fileinto "Lists/${2}"; }`, Result{FileInto: []FileInto{{Mailbox: "Lists/mox-users"}}})
+	test(`require ["variables", "fileinto"]; set :upperfirst :lower "name" "ARCHIVE"; fileinto "${name}";`, Result{FileInto: []FileInto{{Mailbox: "Archive"}}})
+	test(`require ["variables", "fileinto"]; set :length "n" "abc"; fileinto "x${n}${unknown}${ not}";`, Result{FileInto: []FileInto{{Mailbox: "x3${ not}"}}})
+	test(`require ["variables"]; set "a" "b"; if string :is "${a}" "b" { discard; }`, discard)
+	test(`require ["fileinto"]; fileinto "${a}";`, Result{FileInto: []FileInto{{Mailbox: "${a}"}}}) // No variables extension.
+
+	// Flags.
+	test(`require "imap4flags"; addflag ["\\Seen", "$Label1 $label2"]; removeflag "$LABEL1";`, Result{Keep: true, KeepFlags: []string{`\Seen`, "$label2"}})
+	test(`require ["imap4flags", "fileinto"]; setflag "\\Flagged"; fileinto :flags "\\Seen" "A"; fileinto "B";`, Result{FileInto: []FileInto{{Mailbox: "A", Flags: []string{`\Seen`}}, {Mailbox: "B", Flags: []string{`\Flagged`}}}})
+	test(`require ["imap4flags", "variables"]; addflag "f" "\\Seen"; if hasflag "f" "\\seen" { discard; }`, discard)
+	test(`require ["imap4flags"]; addflag "\\Seen"; if hasflag :contains "seen" { discard; }`, discard)
+
+	// Vacation.
+	r := eval(`require "vacation"; vacation :days 100 :subject "away" :addresses ["a@mox.example"] "I'm away";`)
+	if v := r.Vacation; !r.Keep || v == nil || v.Days != 30 || v.Subject != "away" || v.Reason != "I'm away" || !reflect.DeepEqual(v.Addresses, []string{"a@mox.example"}) || !strings.HasPrefix(v.Handle, "auto:") {
+		t.Fatalf("unexpected vacation result %#v %#v", r, r.Vacation)
+	}
+	r = eval(`require "vacation"; vacation :handle "h" "away";`)
+	if v := r.Vacation; v == nil || v.Days != 7 || v.Handle != "h" {
+		t.Fatalf("unexpected vacation result %#v", r.Vacation)
+	}
+
+	bad(`require ["reject", "fileinto"]; reject "no"; fileinto "x";`)
+	bad(`require ["reject", "ereject"]; reject "no"; ereject "no";`)
+	bad(`require "reject"; keep; reject "no";`)
+	bad(`redirect "a@x.example"; redirect "b@x.example"; redirect "c@x.example"; redirect "d@x.example"; redirect "e@x.example";`)
+	bad(`redirect "not an address";`)
+	bad(`require "comparator-i;ascii-numeric"; if header :contains :comparator "i;ascii-numeric" "x-spam-lvl" "1" { discard; }`)
+	bad(`require "vacation"; vacation "a"; vacation "b";`)
+}
+
+func TestGlobMatch(t *testing.T) {
+	test := func(pattern, v string, expCaptures []string, expMatch bool) {
+		t.Helper()
+		captures, match := globMatch(pattern, v, v)
+		if match != expMatch || !reflect.DeepEqual(captures, expCaptures) {
+			t.Fatalf("glob %q %q: got %v %v, expected %v %v", pattern, v, captures, match, expCaptures, expMatch)
+		}
+	}
+	test("*", "", []string{"", ""}, true)
+	test("a*c", "abcbc", []string{"abcbc", "bcb"}, true)
+	test("*b*", "abcbc", []string{"abcbc", "a", "cbc"}, true) // Shortest match first.
+	test("a?c", "abc", []string{"abc", "b"}, true)
+	test(`a\*c`, "a*c", []string{"a*c"}, true)
+	test(`a\*c`, "abc", nil, false)
+	test("a?", "a", nil, false)
+	test(strings.Repeat("*a", 30)+"b", strings.Repeat("a", 100), nil, false) // Bounded work.
+}
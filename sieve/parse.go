@@ -0,0 +1,659 @@
+// Package sieve parses, validates and evaluates Sieve email filtering scripts
+// (RFC 5228).
+//
+// Scripts are checked for syntax errors, unknown commands and tests, wrong use of
+// arguments, and use of extensions that were not declared with "require" or that
+// are not supported. Evaluate runs a script against an incoming message, and
+// returns the resulting actions, for the caller to execute.
+package sieve
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Extensions lists the supported Sieve extensions, as announced by ManageSieve.
+var Extensions = []string{
+	"body",
+	"comparator-i;ascii-numeric",
+	"copy",
+	"envelope",
+	"ereject",
+	"fileinto",
+	"imap4flags",
+	"mailbox",
+	"reject",
+	"relational",
+	"subaddress",
+	"vacation",
+	"variables",
+}
+
+// ErrParse is wrapped by errors returned by Parse.
+var ErrParse = errors.New("parsing sieve script")
+
+// ParseError is a syntax or validation error in a script.
+type ParseError struct {
+	Line int // Starting at 1.
+	Msg  string
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+func (e ParseError) Unwrap() error {
+	return ErrParse
+}
+
+// Script is a parsed Sieve script.
+type Script struct {
+	Require  []string // Extensions required by the script.
+	Commands []Command
+}
+
+// Command is a control or action command, like "if", "fileinto" or "stop".
+type Command struct {
+	Line  int
+	Name  string // In lower case.
+	Args  []Arg
+	Tests []Test // For "if", "elsif".
+	Block []Command
+}
+
+// Test is a test of an "if" or "elsif" command, or of another test.
+type Test struct {
+	Line  int
+	Name  string // In lower case.
+	Args  []Arg
+	Tests []Test // For "allof", "anyof", "not".
+}
+
+// Arg is a single argument. Only one of the fields is set.
+type Arg struct {
+	Tag     string   // Without leading colon, in lower case.
+	Number  *int64   // With quantifier applied.
+	Strings []string // Single string, or string list.
+	List    bool     // Whether Strings was specified as a list.
+}
+
+// Parse parses and validates script. Errors are of type ParseError.
+func Parse(script string) (rs *Script, rerr error) {
+	p := &parser{s: script, line: 1}
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(ParseError); ok {
+			rerr = err
+			return
+		}
+		panic(x)
+	}()
+
+	s := &Script{}
+	s.Commands = p.xcommands(false)
+	p.xvalidate(s)
+	return s, nil
+}
+
+type parser struct {
+	s    string
+	o    int
+	line int
+}
+
+func (p *parser) xerrorf(format string, args ...any) {
+	panic(ParseError{p.line, fmt.Sprintf(format, args...)})
+}
+
+// skip skips whitespace and comments. ../rfc/5228
+func (p *parser) skip() {
+	for p.o < len(p.s) {
+		switch c := p.s[p.o]; {
+		case c == '\n':
+			p.line++
+			p.o++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.o++
+		case c == '#':
+			for p.o < len(p.s) && p.s[p.o] != '\n' {
+				p.o++
+			}
+		case strings.HasPrefix(p.s[p.o:], "/*"):
+			end := strings.Index(p.s[p.o+2:], "*/")
+			if end < 0 {
+				p.xerrorf("unterminated bracket comment")
+			}
+			p.line += strings.Count(p.s[p.o:p.o+2+end], "\n")
+			p.o += 2 + end + 2
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) empty() bool {
+	p.skip()
+	return p.o >= len(p.s)
+}
+
+func (p *parser) peek(s string) bool {
+	p.skip()
+	return strings.HasPrefix(p.s[p.o:], s)
+}
+
+func (p *parser) take(s string) bool {
+	if p.peek(s) {
+		p.o += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *parser) xtake(s string) {
+	if !p.take(s) {
+		p.xerrorf("expected %q", s)
+	}
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// identifier returns an identifier in lower case, or empty string if not present.
+func (p *parser) identifier() string {
+	p.skip()
+	if p.o >= len(p.s) || !isAlpha(p.s[p.o]) {
+		return ""
+	}
+	o := p.o
+	for p.o < len(p.s) && (isAlpha(p.s[p.o]) || isDigit(p.s[p.o])) {
+		p.o++
+	}
+	return strings.ToLower(p.s[o:p.o])
+}
+
+func (p *parser) xidentifier() string {
+	s := p.identifier()
+	if s == "" {
+		p.xerrorf("expected identifier")
+	}
+	return s
+}
+
+// xcommands parses commands until end of script, or until "}" if inBlock.
+func (p *parser) xcommands(inBlock bool) []Command {
+	var l []Command
+	for {
+		if p.empty() {
+			if inBlock {
+				p.xerrorf("missing closing brace of block")
+			}
+			return l
+		}
+		if inBlock && p.peek("}") {
+			return l
+		}
+		l = append(l, p.xcommand())
+	}
+}
+
+func (p *parser) xcommand() Command {
+	p.skip()
+	c := Command{Line: p.line}
+	c.Name = p.xidentifier()
+	c.Args = p.xarguments()
+	if p.peek("(") {
+		p.xtake("(")
+		c.Tests = p.xtestList()
+	} else if !p.peek(";") && !p.peek("{") {
+		c.Tests = []Test{p.xtest()}
+	}
+	if p.take("{") {
+		c.Block = p.xcommands(true)
+		p.xtake("}")
+		if c.Block == nil {
+			c.Block = []Command{}
+		}
+	} else if !p.take(";") {
+		p.xerrorf("expected semicolon or block after command %q", c.Name)
+	}
+	return c
+}
+
+// xtestList parses a test list, after the opening parenthesis.
+func (p *parser) xtestList() []Test {
+	l := []Test{p.xtest()}
+	for p.take(",") {
+		l = append(l, p.xtest())
+	}
+	p.xtake(")")
+	return l
+}
+
+// xtest parses a test, with its arguments and tests. ../rfc/5228
+func (p *parser) xtest() Test {
+	p.skip()
+	t := Test{Line: p.line}
+	t.Name = p.identifier()
+	if t.Name == "" {
+		p.xerrorf("expected test")
+	}
+	t.Args = p.xarguments()
+	if p.take("(") {
+		t.Tests = p.xtestList()
+	} else if p.peekTest() {
+		t.Tests = []Test{p.xtest()}
+	}
+	return t
+}
+
+// peekTest returns whether an identifier follows, which would be a test.
+func (p *parser) peekTest() bool {
+	p.skip()
+	return p.o < len(p.s) && isAlpha(p.s[p.o]) && !strings.HasPrefix(strings.ToLower(p.s[p.o:]), "text:")
+}
+
+// xarguments parses arguments (strings, string lists, numbers, tags), stopping
+// at tests, blocks and semicolons. ../rfc/5228
+func (p *parser) xarguments() []Arg {
+	var l []Arg
+	for {
+		p.skip()
+		if p.o >= len(p.s) {
+			return l
+		}
+		c := p.s[p.o]
+		switch {
+		case c == ':':
+			p.o++
+			if p.o >= len(p.s) || !isAlpha(p.s[p.o]) {
+				p.xerrorf("expected identifier for tag")
+			}
+			l = append(l, Arg{Tag: p.identifier()})
+		case isDigit(c):
+			n := p.xnumber()
+			l = append(l, Arg{Number: &n})
+		case c == '[':
+			p.o++
+			ss := []string{p.xstring()}
+			for p.take(",") {
+				ss = append(ss, p.xstring())
+			}
+			p.xtake("]")
+			l = append(l, Arg{Strings: ss, List: true})
+		case c == '"' || strings.HasPrefix(strings.ToLower(p.s[p.o:]), "text:"):
+			l = append(l, Arg{Strings: []string{p.xstring()}})
+		default:
+			return l
+		}
+	}
+}
+
+// xnumber parses a number with optional quantifier. ../rfc/5228
+func (p *parser) xnumber() int64 {
+	var n int64
+	for p.o < len(p.s) && isDigit(p.s[p.o]) {
+		n = n*10 + int64(p.s[p.o]-'0')
+		if n > 1<<40 {
+			p.xerrorf("number too large")
+		}
+		p.o++
+	}
+	if p.o < len(p.s) {
+		switch p.s[p.o] {
+		case 'k', 'K':
+			n <<= 10
+			p.o++
+		case 'm', 'M':
+			n <<= 20
+			p.o++
+		case 'g', 'G':
+			n <<= 30
+			p.o++
+		}
+	}
+	return n
+}
+
+// xstring parses a quoted string or multi-line string. ../rfc/5228
+func (p *parser) xstring() string {
+	p.skip()
+	if p.o < len(p.s) && p.s[p.o] == '"' {
+		p.o++
+		var b strings.Builder
+		for {
+			if p.o >= len(p.s) {
+				p.xerrorf("unterminated quoted string")
+			}
+			c := p.s[p.o]
+			p.o++
+			switch c {
+			case '"':
+				return b.String()
+			case '\\':
+				// Only \\ and \" are defined, other escaped characters are taken literally. ../rfc/5228
+				if p.o >= len(p.s) {
+					p.xerrorf("unterminated quoted string")
+				}
+				c = p.s[p.o]
+				p.o++
+			case '\n':
+				p.line++
+			}
+			b.WriteByte(c)
+		}
+	}
+
+	if !strings.HasPrefix(strings.ToLower(p.s[p.o:]), "text:") {
+		p.xerrorf("expected string")
+	}
+	p.o += len("text:")
+	// Rest of the line must be whitespace or a comment. ../rfc/5228
+	for p.o < len(p.s) && (p.s[p.o] == ' ' || p.s[p.o] == '\t') {
+		p.o++
+	}
+	if p.o < len(p.s) && p.s[p.o] == '#' {
+		for p.o < len(p.s) && p.s[p.o] != '\n' {
+			p.o++
+		}
+	}
+	if p.o < len(p.s) && p.s[p.o] == '\r' {
+		p.o++
+	}
+	if p.o >= len(p.s) || p.s[p.o] != '\n' {
+		p.xerrorf("expected newline after text:")
+	}
+	p.o++
+	p.line++
+
+	var lines []string
+	for {
+		if p.o >= len(p.s) {
+			p.xerrorf("unterminated multi-line string")
+		}
+		end := strings.IndexByte(p.s[p.o:], '\n')
+		var line string
+		if end < 0 {
+			line = p.s[p.o:]
+			p.o = len(p.s)
+		} else {
+			line = p.s[p.o : p.o+end]
+			p.o += end + 1
+			p.line++
+		}
+		line = strings.TrimSuffix(line, "\r")
+		if line == "." {
+			break
+		}
+		// Dot-stuffing. ../rfc/5228
+		line = strings.TrimPrefix(line, ".")
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// spec describes the arguments of a command or test.
+type spec struct {
+	ext      string   // Extension that must be required, empty for base language.
+	min, max int      // Number of positional, non-tag, arguments.
+	tests    int      // 0: no tests, 1: single test, 2: test list.
+	block    bool     // Whether a block is required. Commands only.
+	tags     []string // Allowed tags, in addition to comparator and match type tags if match is set.
+	match    bool     // Whether comparator and match type tags are allowed.
+}
+
+var commandSpecs = map[string]spec{
+	// ../rfc/5228
+	"require": {min: 1, max: 1},
+	"if":      {tests: 1, block: true},
+	"elsif":   {tests: 1, block: true},
+	"else":    {block: true},
+	"stop":    {},
+	// ../rfc/5228
+	"keep":     {tags: []string{"flags"}},
+	"discard":  {},
+	"redirect": {min: 1, max: 1, tags: []string{"copy"}},
+	"fileinto": {ext: "fileinto", min: 1, max: 1, tags: []string{"copy", "flags", "create"}},
+	// ../rfc/5429
+	"reject":  {ext: "reject", min: 1, max: 1},
+	"ereject": {ext: "ereject", min: 1, max: 1},
+	// ../rfc/5230
+	"vacation": {ext: "vacation", min: 1, max: 1, tags: []string{"days", "subject", "from", "addresses", "handle"}},
+	// ../rfc/5232
+	"setflag":    {ext: "imap4flags", min: 1, max: 2},
+	"addflag":    {ext: "imap4flags", min: 1, max: 2},
+	"removeflag": {ext: "imap4flags", min: 1, max: 2},
+	// ../rfc/5229
+	"set": {ext: "variables", min: 2, max: 2, tags: []string{"lower", "upper", "lowerfirst", "upperfirst", "quotewildcard", "length"}},
+}
+
+var testSpecs = map[string]spec{
+	// ../rfc/5228
+	"address":  {min: 2, max: 2, match: true, tags: []string{"all", "localpart", "domain", "user", "detail"}},
+	"allof":    {tests: 2},
+	"anyof":    {tests: 2},
+	"envelope": {ext: "envelope", min: 2, max: 2, match: true, tags: []string{"all", "localpart", "domain", "user", "detail"}},
+	"exists":   {min: 1, max: 1},
+	"false":    {},
+	"header":   {min: 2, max: 2, match: true},
+	"not":      {tests: 1},
+	"size":     {min: 1, max: 1, tags: []string{"over", "under"}},
+	"true":     {},
+	// ../rfc/5173
+	"body": {ext: "body", min: 1, max: 1, match: true, tags: []string{"raw", "content", "text"}},
+	// ../rfc/5232
+	"hasflag": {ext: "imap4flags", min: 1, max: 2, match: true},
+	// ../rfc/5229
+	"string": {ext: "variables", min: 2, max: 2, match: true},
+	// ../rfc/5490
+	"mailboxexists": {ext: "mailbox", min: 1, max: 1},
+}
+
+// Tags that take a parameter. Values indicate the kind: "string", "stringlist", "number".
+var tagParams = map[string]string{
+	"comparator": "string",
+	"count":      "string",
+	"value":      "string",
+	"days":       "number",
+	"subject":    "string",
+	"from":       "string",
+	"addresses":  "stringlist",
+	"handle":     "string",
+	"flags":      "stringlist",
+	"content":    "stringlist",
+}
+
+// Extensions needed for tags.
+var tagExtensions = map[string]string{
+	"copy":    "copy",
+	"flags":   "imap4flags",
+	"create":  "mailbox",
+	"count":   "relational",
+	"value":   "relational",
+	"user":    "subaddress",
+	"detail":  "subaddress",
+	"content": "body",
+}
+
+var matchTags = []string{"comparator", "is", "contains", "matches", "count", "value"}
+
+func (p *parser) xvalidate(s *Script) {
+	// Require commands must come first. ../rfc/5228
+	cmds := s.Commands
+	for len(cmds) > 0 && cmds[0].Name == "require" {
+		c := cmds[0]
+		p.xcheckArgs(c.Line, "command", c.Name, commandSpecs[c.Name], c.Args, c.Tests, nil)
+		for _, ext := range c.Args[0].Strings {
+			if !slices.Contains(Extensions, ext) {
+				panic(ParseError{c.Line, fmt.Sprintf("unsupported extension %q", ext)})
+			}
+			if !slices.Contains(s.Require, ext) {
+				s.Require = append(s.Require, ext)
+			}
+		}
+		if c.Block != nil {
+			panic(ParseError{c.Line, "unexpected block for require"})
+		}
+		cmds = cmds[1:]
+	}
+	p.xvalidateCommands(s, cmds)
+}
+
+func (p *parser) xvalidateCommands(s *Script, cmds []Command) {
+	var prev string
+	for _, c := range cmds {
+		sp, ok := commandSpecs[c.Name]
+		if !ok {
+			panic(ParseError{c.Line, fmt.Sprintf("unknown command %q", c.Name)})
+		}
+		switch c.Name {
+		case "require":
+			panic(ParseError{c.Line, "require only allowed at start of script"})
+		case "elsif", "else":
+			if prev != "if" && prev != "elsif" {
+				panic(ParseError{c.Line, fmt.Sprintf("%s without preceding if", c.Name)})
+			}
+		}
+		p.xcheckExt(s, c.Line, c.Name, sp.ext)
+		p.xcheckArgs(c.Line, "command", c.Name, sp, c.Args, c.Tests, s)
+		for _, t := range c.Tests {
+			p.xvalidateTest(s, t)
+		}
+		if sp.block && c.Block == nil {
+			panic(ParseError{c.Line, fmt.Sprintf("missing block for %s", c.Name)})
+		} else if !sp.block && c.Block != nil {
+			panic(ParseError{c.Line, fmt.Sprintf("unexpected block for %s", c.Name)})
+		}
+		p.xvalidateCommands(s, c.Block)
+		prev = c.Name
+	}
+}
+
+func (p *parser) xvalidateTest(s *Script, t Test) {
+	sp, ok := testSpecs[t.Name]
+	if !ok {
+		panic(ParseError{t.Line, fmt.Sprintf("unknown test %q", t.Name)})
+	}
+	p.xcheckExt(s, t.Line, t.Name, sp.ext)
+	p.xcheckArgs(t.Line, "test", t.Name, sp, t.Args, t.Tests, s)
+	for _, tt := range t.Tests {
+		p.xvalidateTest(s, tt)
+	}
+}
+
+func (p *parser) xcheckExt(s *Script, line int, name, ext string) {
+	if ext != "" && !slices.Contains(s.Require, ext) {
+		panic(ParseError{line, fmt.Sprintf("%s requires extension %q", name, ext)})
+	}
+}
+
+// xcheckArgs checks the arguments and tests against the spec. If s is nil, no
+// extension checks are done.
+func (p *parser) xcheckArgs(line int, kind, name string, sp spec, args []Arg, tests []Test, s *Script) {
+	xerrorf := func(format string, args ...any) {
+		panic(ParseError{line, fmt.Sprintf("%s %s: %s", kind, name, fmt.Sprintf(format, args...))})
+	}
+
+	var positional int
+	var haveMatchType bool
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a.Tag == "" {
+			positional++
+			if a.Number != nil && name != "size" {
+				xerrorf("unexpected number")
+			} else if a.Number == nil && name == "size" {
+				xerrorf("expected number")
+			}
+			continue
+		}
+		if positional > 0 {
+			// Positional arguments come after all tags.
+			xerrorf("tag :%s must come before positional arguments", a.Tag)
+		}
+		if !slices.Contains(sp.tags, a.Tag) && !(sp.match && slices.Contains(matchTags, a.Tag)) {
+			xerrorf("unknown or unexpected tag :%s", a.Tag)
+		}
+		if ext, ok := tagExtensions[a.Tag]; ok && s != nil {
+			p.xcheckExt(s, line, ":"+a.Tag, ext)
+		}
+		switch a.Tag {
+		case "is", "contains", "matches", "count", "value":
+			if haveMatchType {
+				xerrorf("multiple match types")
+			}
+			haveMatchType = true
+		}
+		kind, ok := tagParams[a.Tag]
+		if !ok {
+			continue
+		}
+		if i+1 >= len(args) || args[i+1].Tag != "" {
+			xerrorf("missing parameter for tag :%s", a.Tag)
+		}
+		i++
+		pa := args[i]
+		switch kind {
+		case "number":
+			if pa.Number == nil {
+				xerrorf("parameter for tag :%s must be a number", a.Tag)
+			}
+		case "string":
+			if pa.Number != nil || len(pa.Strings) != 1 || pa.List {
+				xerrorf("parameter for tag :%s must be a single string", a.Tag)
+			}
+		case "stringlist":
+			if pa.Number != nil {
+				xerrorf("parameter for tag :%s must be a string list", a.Tag)
+			}
+		}
+		switch a.Tag {
+		case "comparator":
+			switch pa.Strings[0] {
+			case "i;octet", "i;ascii-casemap":
+			case "i;ascii-numeric":
+				if s != nil {
+					p.xcheckExt(s, line, "comparator", "comparator-i;ascii-numeric")
+				}
+			default:
+				xerrorf("unsupported comparator %q", pa.Strings[0])
+			}
+		case "count", "value":
+			// ../rfc/5231
+			switch pa.Strings[0] {
+			case "gt", "ge", "lt", "le", "eq", "ne":
+			default:
+				xerrorf("unknown relational match operator %q", pa.Strings[0])
+			}
+		}
+	}
+	if positional < sp.min || positional > sp.max {
+		if sp.min == sp.max {
+			xerrorf("expected %d positional argument(s), got %d", sp.min, positional)
+		}
+		xerrorf("expected %d to %d positional arguments, got %d", sp.min, sp.max, positional)
+	}
+	if name == "size" && !slices.ContainsFunc(args, func(a Arg) bool { return a.Tag == "over" || a.Tag == "under" }) {
+		xerrorf("expected :over or :under")
+	}
+	switch {
+	case sp.tests == 0 && len(tests) > 0:
+		xerrorf("unexpected test")
+	case sp.tests == 1 && len(tests) != 1:
+		xerrorf("expected single test")
+	case sp.tests == 2 && len(tests) == 0:
+		xerrorf("expected test list")
+	}
+}
@@ -0,0 +1,89 @@
+package sieve
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	good := func(script string) *Script {
+		t.Helper()
+		s, err := Parse(script)
+		if err != nil {
+			t.Fatalf("parse %q: %v", script, err)
+		}
+		return s
+	}
+	bad := func(script string, expLine int) {
+		t.Helper()
+		_, err := Parse(script)
+		var perr ParseError
+		if err == nil || !errors.As(err, &perr) || !errors.Is(err, ErrParse) {
+			t.Fatalf("parse %q: got err %v, expected parse error", script, err)
+		}
+		if perr.Line != expLine {
+			t.Fatalf("parse %q: got error %v on line %d, expected line %d", script, err, perr.Line, expLine)
+		}
+	}
+
+	good("")
+	good("# comment only\r\n")
+	good("keep;")
+	good("/* multi\nline */ discard; stop;")
+
+	s := good(`require ["fileinto", "copy"];
+require "envelope";
+if header :contains "subject" "[list]" {
+	fileinto :copy "Lists";
+} elsif envelope :is "from" "spam@example.org" {
+	discard;
+} else {
+	keep;
+}
+`)
+	if !reflect.DeepEqual(s.Require, []string{"fileinto", "copy", "envelope"}) {
+		t.Fatalf("got require %v", s.Require)
+	}
+	if len(s.Commands) != 5 || s.Commands[2].Name != "if" || s.Commands[3].Name != "elsif" || s.Commands[4].Name != "else" {
+		t.Fatalf("unexpected commands %#v", s.Commands)
+	}
+	if a := s.Commands[2].Block[0].Args; len(a) != 2 || a[0].Tag != "copy" || a[1].Strings[0] != "Lists" {
+		t.Fatalf("unexpected fileinto args %#v", a)
+	}
+
+	s = good(`if anyof (size :over 1M, not exists ["from", "date"], allof (true, false)) { discard; }`)
+	if n := *s.Commands[0].Tests[0].Tests[0].Args[1].Number; n != 1<<20 {
+		t.Fatalf("got size %d, expected 1M", n)
+	}
+
+	s = good("require \"vacation\";\nvacation :days 7 :subject \"away\" text:\nI'm away.\n..dot\n.\n;")
+	if text := s.Commands[1].Args[4].Strings[0]; text != "I'm away.\r\n.dot\r\n" {
+		t.Fatalf("got multi-line text %q", text)
+	}
+	good(`require ["relational", "comparator-i;ascii-numeric"]; if header :value "ge" :comparator "i;ascii-numeric" "x-spam-score" "5" { discard; }`)
+	good(`require ["imap4flags", "variables"]; set "a" "b"; addflag "\\Seen"; if hasflag :contains "\\Seen" { keep; }`)
+	good(`if address :all :is "from" "a\"b\\c@example.org" { redirect "other@example.org"; }`)
+
+	bad("keep", 1)                         // Missing semicolon.
+	bad("keep;\nfoo;", 2)                  // Unknown command.
+	bad("if true { keep;", 1)              // Missing closing brace.
+	bad("fileinto \"x\";", 1)              // Missing require.
+	bad("require \"nonexistent\";", 1)     // Unsupported extension.
+	bad("keep;\nrequire \"fileinto\";", 2) // Require not at start.
+	bad("else { keep; }", 1)               // Else without if.
+	bad("if true;", 1)                     // Missing block.
+	bad("keep { stop; }", 1)               // Unexpected block.
+	bad("if { keep; }", 1)                 // Missing test.
+	bad("if foo { keep; }", 1)             // Unknown test.
+	bad("if header :is :contains \"a\" \"b\" { keep; }", 1)
+	bad("if header :comparator \"i;unknown\" \"a\" \"b\" { keep; }", 1)
+	bad("if header \"a\" { keep; }", 1) // Missing argument.
+	bad("if size 10 { keep; }", 1)      // Missing :over/:under.
+	bad("redirect :copy;", 1)           // Missing argument, and copy not required.
+	bad("redirect \"a\" :copy;", 1)     // Tag after positional argument.
+	bad("discard \"x\";", 1)            // Unexpected argument.
+	bad("keep; /* unterminated", 1)
+	bad("keep \"unterminated;", 1)
+	bad("require \"vacation\"; vacation text:\nno end\n", 3)
+}
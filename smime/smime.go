@@ -0,0 +1,495 @@
+// Package smime detects signed and encrypted messages (S/MIME and PGP/MIME), and
+// verifies S/MIME signatures.
+//
+// S/MIME signatures (RFC 8551) are in the Cryptographic Message Syntax (CMS, RFC
+// 5652), either "detached" in a multipart/signed message, or "opaque" in an
+// application/pkcs7-mime message with the signed content included. The signer
+// certificate is verified against a pool of CA certificates, and must be valid
+// for the message From address.
+//
+// PGP/MIME (RFC 3156) signatures and encryption are only detected here. PGP
+// signatures are verified with package openpgp, with keys of the receiving
+// account.
+package smime
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtp"
+)
+
+// Kind of signature or encryption of a message.
+type Kind string
+
+const (
+	KindNone  Kind = ""
+	KindSMIME Kind = "smime"
+	KindPGP   Kind = "pgp"
+)
+
+// Status is the result of verifying an S/MIME signature, as used in the "smime"
+// method in Authentication-Results headers.
+type Status string
+
+// ../rfc/7281
+const (
+	StatusNone      Status = "none"      // Message is not S/MIME signed.
+	StatusPass      Status = "pass"      // Signature is valid, signer certificate is trusted and valid for the From address.
+	StatusFail      Status = "fail"      // Signature does not match the message.
+	StatusPolicy    Status = "policy"    // Signature is valid, but certificate is not trusted, has expired or is not valid for the From address.
+	StatusPermerror Status = "permerror" // Signature could not be processed, e.g. malformed or unsupported algorithm.
+)
+
+var (
+	ErrMalformed   = errors.New("malformed signature")
+	ErrUnsupported = errors.New("unsupported signature")
+	ErrSignature   = errors.New("signature does not match message")
+	ErrSigner      = errors.New("signer certificate not found")
+)
+
+// Maximum size of a signature we parse, or of opaque signed data.
+const maxSignatureSize = 10 * 1024 * 1024
+
+// Result of checking a message.
+type Result struct {
+	Signed    Kind
+	Encrypted Kind
+
+	// For S/MIME signed messages. Status is empty for messages that are not S/MIME
+	// signed.
+	Status Status
+	Signer string // Email address of signer certificate, set for status pass and policy.
+	Err    error  // Details for status other than pass.
+}
+
+// Detect returns how a message is signed and/or encrypted, based on the MIME
+// structure of the message. Only the top-level part is inspected.
+func Detect(p *message.Part) (signed, encrypted Kind) {
+	protocol := strings.ToLower(p.ContentTypeParams["protocol"])
+	switch p.MediaType + "/" + p.MediaSubType {
+	case "MULTIPART/SIGNED":
+		// ../rfc/8551 ../rfc/3156
+		switch protocol {
+		case "application/pkcs7-signature", "application/x-pkcs7-signature":
+			signed = KindSMIME
+		case "application/pgp-signature":
+			signed = KindPGP
+		}
+	case "MULTIPART/ENCRYPTED":
+		// ../rfc/3156
+		if protocol == "application/pgp-encrypted" {
+			encrypted = KindPGP
+		}
+	case "APPLICATION/PKCS7-MIME", "APPLICATION/X-PKCS7-MIME":
+		// ../rfc/8551
+		switch strings.ToLower(p.ContentTypeParams["smime-type"]) {
+		case "signed-data":
+			signed = KindSMIME
+		case "enveloped-data", "authenveloped-data":
+			encrypted = KindSMIME
+		case "":
+			// Older clients leave out the smime-type, it is usually encrypted data.
+			encrypted = KindSMIME
+		}
+	}
+	return
+}
+
+// Check detects whether a message is signed or encrypted, and verifies S/MIME
+// signatures. The message is read from msgr, which must be the reader used for
+// parsing p. Subparts of p are parsed if needed. For verifying certificate chains, roots is used, or the system
+// certificate pool if nil.
+func Check(log mlog.Log, p *message.Part, msgr io.ReaderAt, from smtp.Address, roots *x509.CertPool, now time.Time) (r Result) {
+	r.Signed, r.Encrypted = Detect(p)
+	if r.Signed != KindSMIME {
+		return
+	}
+
+	// Delivery only parses the top-level part, we need the subparts.
+	if p.MediaType == "MULTIPART" && len(p.Parts) == 0 {
+		if err := p.Walk(log.Logger, nil); err != nil {
+			r.Status = StatusPermerror
+			r.Err = fmt.Errorf("%w: parsing message: %v", ErrMalformed, err)
+			return
+		}
+	}
+
+	var signer *x509.Certificate
+	var intermediates []*x509.Certificate
+	signer, intermediates, r.Err = verifySignature(p, msgr)
+	if r.Err != nil {
+		if errors.Is(r.Err, ErrSignature) {
+			r.Status = StatusFail
+		} else {
+			r.Status = StatusPermerror
+		}
+		log.Debugx("s/mime signature verification", r.Err)
+		return
+	}
+
+	r.Signer = signerAddress(signer)
+	r.Status, r.Err = checkSigner(signer, intermediates, from, roots, now)
+	log.Debugx("s/mime signature verified", r.Err, slog.Any("status", r.Status), slog.String("signer", r.Signer))
+	return
+}
+
+// verifySignature verifies the CMS signature of a signed message and returns the
+// signer certificate and other certificates included in the signature.
+func verifySignature(p *message.Part, msgr io.ReaderAt) (signer *x509.Certificate, other []*x509.Certificate, rerr error) {
+	var sigBuf []byte
+	var content *io.SectionReader
+	if p.MediaType == "MULTIPART" {
+		// Detached signature. First part is the signed content, second part the
+		// signature. ../rfc/1847
+		if len(p.Parts) != 2 {
+			return nil, nil, fmt.Errorf("%w: multipart/signed with %d parts, need 2", ErrMalformed, len(p.Parts))
+		}
+		cp := p.Parts[0]
+		if cp.EndOffset < cp.HeaderOffset {
+			return nil, nil, fmt.Errorf("%w: bad offsets for signed content", ErrMalformed)
+		}
+		// The signed content is the MIME entity including its headers, without the CRLF
+		// that is part of the boundary. ../rfc/8551
+		content = io.NewSectionReader(msgr, cp.HeaderOffset, cp.EndOffset-cp.HeaderOffset)
+		sp := p.Parts[1]
+		sigBuf, rerr = io.ReadAll(io.LimitReader(sp.Reader(), maxSignatureSize+1))
+	} else {
+		// Opaque signature, content is in the CMS structure.
+		sigBuf, rerr = io.ReadAll(io.LimitReader(p.Reader(), maxSignatureSize+1))
+	}
+	if rerr != nil {
+		return nil, nil, fmt.Errorf("%w: reading signature: %v", ErrMalformed, rerr)
+	} else if len(sigBuf) > maxSignatureSize {
+		return nil, nil, fmt.Errorf("%w: signature too large", ErrMalformed)
+	}
+	return verifyCMS(sigBuf, content)
+}
+
+// Object identifiers. ../rfc/5652
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECPublicKey     = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidEd25519         = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// CMS structures, ../rfc/5652
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []algorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    algorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm algorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber asn1.RawValue
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// verifyCMS verifies a CMS SignedData structure. For detached signatures, content
+// is the signed data. For opaque signatures, content is nil and the signed data is
+// taken from the CMS structure.
+func verifyCMS(buf []byte, content *io.SectionReader) (signer *x509.Certificate, other []*x509.Certificate, rerr error) {
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(buf, &ci); err != nil {
+		return nil, nil, fmt.Errorf("%w: parsing content info: %v", ErrMalformed, err)
+	} else if len(rest) != 0 {
+		return nil, nil, fmt.Errorf("%w: trailing data after content info", ErrMalformed)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, nil, fmt.Errorf("%w: content type %s, expected signed data", ErrUnsupported, ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, nil, fmt.Errorf("%w: parsing signed data: %v", ErrMalformed, err)
+	}
+	if content == nil {
+		if sd.EncapContentInfo.EContent == nil {
+			return nil, nil, fmt.Errorf("%w: no signed content in opaque signature", ErrMalformed)
+		}
+		content = io.NewSectionReader(bytes.NewReader(sd.EncapContentInfo.EContent), 0, int64(len(sd.EncapContentInfo.EContent)))
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, nil, fmt.Errorf("%w: no signer info", ErrMalformed)
+	}
+
+	var certs []*x509.Certificate
+	if len(sd.Certificates.Bytes) > 0 {
+		var err error
+		certs, err = x509.ParseCertificates(sd.Certificates.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: parsing certificates: %v", ErrMalformed, err)
+		}
+	}
+
+	// We need a single valid signature. Messages with multiple signers are rare, we
+	// return the first error if none verify.
+	var firstErr error
+	for _, si := range sd.SignerInfos {
+		cert, err := verifySignerInfo(si, certs, sd.EncapContentInfo.EContentType, content)
+		if err == nil {
+			for _, c := range certs {
+				if c != cert {
+					other = append(other, c)
+				}
+			}
+			return cert, other, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, nil, firstErr
+}
+
+func verifySignerInfo(si signerInfo, certs []*x509.Certificate, contentType asn1.ObjectIdentifier, content *io.SectionReader) (*x509.Certificate, error) {
+	cert, err := findSigner(si.SID, certs)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := digestHash(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	if _, err := io.Copy(h, io.NewSectionReader(content, 0, content.Size())); err != nil {
+		return nil, fmt.Errorf("reading signed content: %v", err)
+	}
+	digest := h.Sum(nil)
+
+	// Without signed attributes, the signature is over the content. With signed
+	// attributes, they must contain the digest of the content, and the signature is
+	// over the DER encoding of the attributes as SET. ../rfc/5652
+	// Signed data is only needed for ed25519, which signs the data instead of a
+	// digest, and which is always used with signed attributes.
+	var signed []byte
+	if len(si.SignedAttrs.FullBytes) > 0 {
+		var msgDigest []byte
+		var haveContentType bool
+		rest := si.SignedAttrs.Bytes
+		for len(rest) > 0 {
+			var a attribute
+			rest, err = asn1.Unmarshal(rest, &a)
+			if err != nil {
+				return nil, fmt.Errorf("%w: parsing signed attribute: %v", ErrMalformed, err)
+			}
+			switch {
+			case a.Type.Equal(oidMessageDigest):
+				if _, err := asn1.Unmarshal(a.Values.Bytes, &msgDigest); err != nil {
+					return nil, fmt.Errorf("%w: parsing message digest attribute: %v", ErrMalformed, err)
+				}
+			case a.Type.Equal(oidContentType):
+				var ct asn1.ObjectIdentifier
+				if _, err := asn1.Unmarshal(a.Values.Bytes, &ct); err != nil {
+					return nil, fmt.Errorf("%w: parsing content type attribute: %v", ErrMalformed, err)
+				} else if !ct.Equal(contentType) {
+					return nil, fmt.Errorf("%w: content type attribute %s does not match encapsulated content type %s", ErrSignature, ct, contentType)
+				}
+				haveContentType = true
+			}
+		}
+		if msgDigest == nil || !haveContentType {
+			return nil, fmt.Errorf("%w: missing message digest or content type attribute", ErrMalformed)
+		}
+		if !bytes.Equal(msgDigest, digest) {
+			return nil, fmt.Errorf("%w: message digest mismatch", ErrSignature)
+		}
+		signed = append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...) // SET tag instead of implicit [0].
+		h = hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	} else if !contentType.Equal(oidData) {
+		return nil, fmt.Errorf("%w: signed attributes required for content type %s", ErrMalformed, contentType)
+	}
+
+	if err := checkSignature(cert, si.SignatureAlgorithm.Algorithm, hash, digest, signed, si.Signature); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// findSigner finds the certificate referenced by the signer identifier, either an
+// issuer and serial number, or a subject key identifier. ../rfc/5652
+func findSigner(sid asn1.RawValue, certs []*x509.Certificate) (*x509.Certificate, error) {
+	if sid.Class == asn1.ClassContextSpecific && sid.Tag == 0 {
+		for _, c := range certs {
+			if bytes.Equal(c.SubjectKeyId, sid.Bytes) {
+				return c, nil
+			}
+		}
+		return nil, ErrSigner
+	}
+	var isn issuerAndSerialNumber
+	if _, err := asn1.Unmarshal(sid.FullBytes, &isn); err != nil {
+		return nil, fmt.Errorf("%w: parsing signer identifier: %v", ErrMalformed, err)
+	}
+	for _, c := range certs {
+		serial, err := asn1.Marshal(c.SerialNumber)
+		if err == nil && bytes.Equal(c.RawIssuer, isn.Issuer.FullBytes) && bytes.Equal(serial, isn.SerialNumber.FullBytes) {
+			return c, nil
+		}
+	}
+	return nil, ErrSigner
+}
+
+func digestHash(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("%w: digest algorithm %s", ErrUnsupported, oid)
+}
+
+func checkSignature(cert *x509.Certificate, alg asn1.ObjectIdentifier, hash crypto.Hash, digest, signed, sig []byte) error {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if !alg.Equal(oidRSAEncryption) && !alg.Equal(oidSHA256WithRSA) && !alg.Equal(oidSHA384WithRSA) && !alg.Equal(oidSHA512WithRSA) {
+			return fmt.Errorf("%w: signature algorithm %s with rsa key", ErrUnsupported, alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+			return fmt.Errorf("%w: %v", ErrSignature, err)
+		}
+	case *ecdsa.PublicKey:
+		if !alg.Equal(oidECPublicKey) && !alg.Equal(oidECDSAWithSHA256) && !alg.Equal(oidECDSAWithSHA384) && !alg.Equal(oidECDSAWithSHA512) {
+			return fmt.Errorf("%w: signature algorithm %s with ecdsa key", ErrUnsupported, alg)
+		}
+		if !ecdsa.VerifyASN1(pub, digest, sig) {
+			return ErrSignature
+		}
+	case ed25519.PublicKey:
+		// Ed25519 signs the data itself, not a digest. ../rfc/8419
+		if !alg.Equal(oidEd25519) {
+			return fmt.Errorf("%w: signature algorithm %s with ed25519 key", ErrUnsupported, alg)
+		} else if signed == nil {
+			return fmt.Errorf("%w: ed25519 signature without signed attributes", ErrUnsupported)
+		}
+		if !ed25519.Verify(pub, signed, sig) {
+			return ErrSignature
+		}
+	default:
+		return fmt.Errorf("%w: public key type %T", ErrUnsupported, cert.PublicKey)
+	}
+	return nil
+}
+
+// checkSigner verifies the certificate chain of the signer and checks the
+// certificate is for the From address. ../rfc/8550
+func checkSigner(signer *x509.Certificate, intermediates []*x509.Certificate, from smtp.Address, roots *x509.CertPool, now time.Time) (Status, error) {
+	pool := x509.NewCertPool()
+	for _, c := range intermediates {
+		pool.AddCert(c)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: pool,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	if _, err := signer.Verify(opts); err != nil {
+		return StatusPolicy, fmt.Errorf("verifying signer certificate: %v", err)
+	}
+	if from.IsZero() {
+		return StatusPolicy, errors.New("message has no from address")
+	}
+	fromStr := strings.ToLower(from.String())
+	for _, addr := range certAddresses(signer) {
+		if strings.ToLower(addr) == fromStr {
+			return StatusPass, nil
+		}
+	}
+	return StatusPolicy, fmt.Errorf("signer certificate is not for from address %s", from)
+}
+
+// oid for emailAddress attribute in the subject of a certificate, used by older certificates.
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// certAddresses returns the email addresses the certificate is for, from its
+// subject alternative names, or from the subject for older certificates.
+func certAddresses(c *x509.Certificate) []string {
+	if len(c.EmailAddresses) > 0 {
+		return c.EmailAddresses
+	}
+	var l []string
+	for _, n := range c.Subject.Names {
+		if s, ok := n.Value.(string); ok && n.Type.Equal(oidEmailAddress) {
+			l = append(l, s)
+		}
+	}
+	return l
+}
+
+func signerAddress(c *x509.Certificate) string {
+	l := certAddresses(c)
+	if len(l) > 0 {
+		return l[0]
+	}
+	return c.Subject.CommonName
+}
@@ -0,0 +1,185 @@
+package smime
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtp"
+)
+
+var pkglog = mlog.New("smime", nil)
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCA(t *testing.T) testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	tcheck(t, err, "generate key")
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	buf, err := x509.CreateCertificate(cryptorand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	tcheck(t, err, "create ca cert")
+	cert, err := x509.ParseCertificate(buf)
+	tcheck(t, err, "parse ca cert")
+	return testCA{cert, key}
+}
+
+func (ca testCA) newSigner(t *testing.T, addr string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	tcheck(t, err, "generate key")
+	tmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: addr},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		EmailAddresses: []string{addr},
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	buf, err := x509.CreateCertificate(cryptorand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	tcheck(t, err, "create cert")
+	cert, err := x509.ParseCertificate(buf)
+	tcheck(t, err, "parse cert")
+	return cert, key
+}
+
+func xmarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	buf, err := asn1.Marshal(v)
+	tcheck(t, err, "marshal")
+	return buf
+}
+
+// sign creates a CMS SignedData structure with signed attributes. If opaque, the
+// content is included.
+func sign(t *testing.T, cert *x509.Certificate, key *ecdsa.PrivateKey, content []byte, opaque bool) []byte {
+	t.Helper()
+
+	digest := crypto.SHA256.New()
+	digest.Write(content)
+
+	attr := func(oid asn1.ObjectIdentifier, value any) []byte {
+		return xmarshal(t, attribute{oid, asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: xmarshal(t, value)}})
+	}
+	var attrs []byte
+	attrs = append(attrs, attr(oidContentType, oidData)...)
+	attrs = append(attrs, attr(oidMessageDigest, digest.Sum(nil))...)
+
+	h := crypto.SHA256.New()
+	h.Write(xmarshal(t, asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attrs}))
+	sig, err := ecdsa.SignASN1(cryptorand.Reader, key, h.Sum(nil))
+	tcheck(t, err, "sign")
+
+	sid := xmarshal(t, issuerAndSerialNumber{asn1.RawValue{FullBytes: cert.RawIssuer}, asn1.RawValue{FullBytes: xmarshal(t, cert.SerialNumber)}})
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []algorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: encapContentInfo{EContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                asn1.RawValue{FullBytes: sid},
+			DigestAlgorithm:    algorithmIdentifier{Algorithm: oidSHA256},
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+			SignatureAlgorithm: algorithmIdentifier{Algorithm: oidECDSAWithSHA256},
+			Signature:          sig,
+		}},
+	}
+	if opaque {
+		sd.EncapContentInfo.EContent = content
+	}
+	// RawValue is marshaled as is, so we add the explicit tag ourselves.
+	return xmarshal(t, contentInfo{oidSignedData, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: xmarshal(t, sd)}})
+}
+
+func b64(buf []byte) string {
+	s := base64.StdEncoding.EncodeToString(buf)
+	var r string
+	for len(s) > 76 {
+		r += s[:76] + "\r\n"
+		s = s[76:]
+	}
+	return r + s + "\r\n"
+}
+
+func TestCheck(t *testing.T) {
+	ca := newCA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	cert, key := ca.newSigner(t, "mjl@mox.example")
+
+	content := "Content-Type: text/plain\r\n\r\nhello\r\n"
+	signedContent := strings.TrimSuffix(content, "\r\n") // CRLF before boundary is not signed.
+	detached := func(signed, sig string) string {
+		return "From: mjl@mox.example\r\nContent-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256; boundary=b\r\n\r\n--b\r\n" +
+			signed + "--b\r\nContent-Type: application/pkcs7-signature; name=smime.p7s\r\nContent-Transfer-Encoding: base64\r\n\r\n" + sig + "--b--\r\n"
+	}
+	sig := b64(sign(t, cert, key, []byte(signedContent), false))
+
+	fromMox, err := smtp.ParseAddress("mjl@mox.example")
+	tcheck(t, err, "parse address")
+	fromOther, err := smtp.ParseAddress("other@mox.example")
+	tcheck(t, err, "parse address")
+
+	test := func(msg string, from smtp.Address, roots *x509.CertPool, expSigned, expEncrypted Kind, expStatus Status, expSigner string) {
+		t.Helper()
+		r := strings.NewReader(msg)
+		p, err := message.Parse(pkglog.Logger, false, r)
+		tcheck(t, err, "parse message")
+		res := Check(pkglog, &p, r, from, roots, time.Now())
+		if res.Signed != expSigned || res.Encrypted != expEncrypted || res.Status != expStatus || res.Signer != expSigner {
+			t.Fatalf("got signed %q, encrypted %q, status %q, signer %q, err %v; expected %q, %q, %q, %q", res.Signed, res.Encrypted, res.Status, res.Signer, res.Err, expSigned, expEncrypted, expStatus, expSigner)
+		}
+	}
+
+	test(detached(content, sig), fromMox, roots, KindSMIME, KindNone, StatusPass, "mjl@mox.example")
+	// Signature valid, but not for from address.
+	test(detached(content, sig), fromOther, roots, KindSMIME, KindNone, StatusPolicy, "mjl@mox.example")
+	// Signer not trusted.
+	test(detached(content, sig), fromMox, x509.NewCertPool(), KindSMIME, KindNone, StatusPolicy, "mjl@mox.example")
+	// Modified content.
+	test(detached(strings.Replace(content, "hello", "hallo", 1), sig), fromMox, roots, KindSMIME, KindNone, StatusFail, "")
+	// Bad signature data.
+	test(detached(content, b64([]byte("bogus"))), fromMox, roots, KindSMIME, KindNone, StatusPermerror, "")
+
+	// Opaque signature.
+	opaque := "From: mjl@mox.example\r\nContent-Type: application/pkcs7-mime; smime-type=signed-data; name=smime.p7m\r\nContent-Transfer-Encoding: base64\r\n\r\n" + b64(sign(t, cert, key, []byte(content), true))
+	test(opaque, fromMox, roots, KindSMIME, KindNone, StatusPass, "mjl@mox.example")
+
+	// Only detected.
+	encrypted := "Content-Type: application/pkcs7-mime; smime-type=enveloped-data\r\nContent-Transfer-Encoding: base64\r\n\r\nAAAA\r\n"
+	test(encrypted, fromMox, roots, KindNone, KindSMIME, "", "")
+	pgpSigned := "Content-Type: multipart/signed; protocol=\"application/pgp-signature\"; micalg=pgp-sha256; boundary=b\r\n\r\n--b\r\n" + content + "--b\r\nContent-Type: application/pgp-signature\r\n\r\nsig\r\n--b--\r\n"
+	test(pgpSigned, fromMox, roots, KindPGP, KindNone, "", "")
+	pgpEncrypted := "Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=b\r\n\r\n--b\r\nContent-Type: application/pgp-encrypted\r\n\r\nVersion: 1\r\n--b\r\nContent-Type: application/octet-stream\r\n\r\ndata\r\n--b--\r\n"
+	test(pgpEncrypted, fromMox, roots, KindNone, KindPGP, "", "")
+	test("Content-Type: text/plain\r\n\r\nhi\r\n", fromMox, roots, KindNone, KindNone, "", "")
+}
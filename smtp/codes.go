@@ -113,6 +113,7 @@ var (
 	SeMsg6ConversionUnsupported3    = "6.3"
 	SeMsg6ConversionWithLoss4       = "6.4"
 	SeMsg6ConversionFailed5         = "6.5"
+	SeMsg6ContentUnavailable6       = "6.6" // ../rfc/4468
 	SeMsg6NonASCIIAddrNotPermitted7 = "6.7" // ../rfc/6531:735
 	SeMsg6UTF8ReplyRequired8        = "6.8" // ../rfc/6531:746
 	SeMsg6UTF8CannotTransfer9       = "6.9" // ../rfc/6531:758
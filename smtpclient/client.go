@@ -145,6 +145,7 @@ type Client struct {
 	extSMTPUTF8           bool              // Remote server supports SMTPUTF8 extension.
 	extAuthMechanisms     []string          // Supported authentication mechanisms.
 	extRequireTLS         bool              // Remote supports REQUIRETLS extension.
+	extDSN                bool              // Remote supports DSN extension.
 	ExtLimits             map[string]string // For LIMITS extension, only if present and valid, with uppercase keys.
 	ExtLimitMailMax       int               // Max "MAIL" commands in a connection, if > 0.
 	ExtLimitRcptMax       int               // Max "RCPT" commands in a transaction, if > 0.
@@ -762,6 +763,8 @@ func (c *Client) hello(ctx context.Context, tlsMode TLSMode, ehloHostname dns.Do
 				c.extPipelining = true
 			case "REQUIRETLS":
 				c.extRequireTLS = true
+			case "DSN":
+				c.extDSN = true
 			default:
 				// For SMTPUTF8 we must ignore any parameter. ../rfc/6531:207
 				if s == "SMTPUTF8" || strings.HasPrefix(s, "SMTPUTF8 ") {
@@ -1093,6 +1096,12 @@ func (c *Client) SupportsRequireTLS() bool {
 	return c.extRequireTLS
 }
 
+// SupportsDSN returns whether the SMTP server supports the DSN extension, for
+// passing delivery status notification parameters. ../rfc/3461
+func (c *Client) SupportsDSN() bool {
+	return c.extDSN
+}
+
 // TLSConnectionState returns TLS details if TLS is enabled, and nil otherwise.
 func (c *Client) TLSConnectionState() *tls.ConnectionState {
 	if tlsConn, ok := c.conn.(*tls.Conn); ok {
@@ -1144,11 +1153,37 @@ var errNoRecipients = errors.New("no recipients accepted in transaction")
 // delivery attempt as failed. Also code "552" must be treated like temporary error
 // code "452" for historic reasons.
 func (c *Client) DeliverMultiple(ctx context.Context, mailFrom string, rcptTo []string, msgSize int64, msg io.Reader, req8bitmime, reqSMTPUTF8, requireTLS bool) (rcptResps []Response, rerr error) {
+	return c.DeliverMultipleDSN(ctx, mailFrom, rcptTo, msgSize, msg, req8bitmime, reqSMTPUTF8, requireTLS, nil)
+}
+
+// DSNParams holds parameters for the SMTP DSN extension, as received from the
+// submitter of a message. They are only sent if the remote server supports the DSN
+// extension. ../rfc/3461
+type DSNParams struct {
+	Ret   string // "FULL" or "HDRS", or empty.
+	EnvID string // Envelope identifier, without xtext encoding. Optional.
+
+	// For each recipient, with the same length as rcptTo.
+	Recipients []DSNRecipientParams
+}
+
+// DSNRecipientParams holds the DSN extension parameters for a recipient.
+type DSNRecipientParams struct {
+	Notify string // "NEVER", or a comma-separated list of "SUCCESS", "FAILURE", "DELAY". Optional.
+	ORcpt  string // Original recipient, an ASCII email address. Optional.
+}
+
+// DeliverMultipleDSN is like DeliverMultiple, but also passes the DSN parameters
+// in dsn if not nil and the remote server supports the DSN extension.
+func (c *Client) DeliverMultipleDSN(ctx context.Context, mailFrom string, rcptTo []string, msgSize int64, msg io.Reader, req8bitmime, reqSMTPUTF8, requireTLS bool, dsn *DSNParams) (rcptResps []Response, rerr error) {
 	defer c.recover(&rerr)
 
 	if len(rcptTo) == 0 {
 		return nil, fmt.Errorf("need at least one recipient")
 	}
+	if dsn != nil && len(dsn.Recipients) != len(rcptTo) {
+		return nil, fmt.Errorf("dsn parameters for %d recipients, but %d recipients", len(dsn.Recipients), len(rcptTo))
+	}
 
 	if c.origConn == nil {
 		return nil, ErrClosed
@@ -1202,7 +1237,28 @@ func (c *Client) DeliverMultiple(ctx context.Context, mailFrom string, rcptTo []
 	// MAIL FROM: ../rfc/5321:1879
 	// RCPT TO: ../rfc/5321:1916
 	// DATA: ../rfc/5321:1992
-	lineMailFrom := fmt.Sprintf("MAIL FROM:<%s>%s%s%s%s", mailFrom, mailSize, bodyType, smtputf8Arg, requiretlsArg)
+	var dsnArgs string
+	rcptLines := make([]string, len(rcptTo))
+	for i, rcpt := range rcptTo {
+		rcptLines[i] = "RCPT TO:<" + rcpt + ">"
+	}
+	if dsn != nil && c.extDSN {
+		if dsn.Ret != "" {
+			dsnArgs += " RET=" + dsn.Ret
+		}
+		if dsn.EnvID != "" {
+			dsnArgs += " ENVID=" + xtext(dsn.EnvID)
+		}
+		for i, r := range dsn.Recipients {
+			if r.Notify != "" {
+				rcptLines[i] += " NOTIFY=" + r.Notify
+			}
+			if r.ORcpt != "" {
+				rcptLines[i] += " ORCPT=rfc822;" + xtext(r.ORcpt)
+			}
+		}
+	}
+	lineMailFrom := fmt.Sprintf("MAIL FROM:<%s>%s%s%s%s%s", mailFrom, mailSize, bodyType, smtputf8Arg, requiretlsArg, dsnArgs)
 
 	// We are going into a transaction. We'll clear this when done.
 	c.needRset = true
@@ -1230,10 +1286,9 @@ func (c *Client) DeliverMultiple(ctx context.Context, mailFrom string, rcptTo []
 			var b bytes.Buffer
 			b.WriteString(lineMailFrom)
 			b.WriteString("\r\n")
-			for _, rcpt := range rcptTo {
-				b.WriteString("RCPT TO:<")
-				b.WriteString(rcpt)
-				b.WriteString(">\r\n")
+			for _, line := range rcptLines {
+				b.WriteString(line)
+				b.WriteString("\r\n")
 			}
 			b.WriteString("DATA\r\n")
 			_, err := c.w.Write(b.Bytes())
@@ -1339,10 +1394,10 @@ func (c *Client) DeliverMultiple(ctx context.Context, mailFrom string, rcptTo []
 
 		rcptResps = make([]Response, len(rcptTo))
 		nok := 0
-		for i, rcpt := range rcptTo {
+		for i, line := range rcptLines {
 			c.cmds[0] = "rcptto"
 			c.cmdStart = time.Now()
-			c.xwriteline(fmt.Sprintf("RCPT TO:<%s>", rcpt))
+			c.xwriteline(line)
 			code, secode, firstLine, moreLines = c.xread()
 			if i > 0 && (code == smtp.C452StorageFull || code == smtp.C552MailboxFull) {
 				// Remote doesn't accept more recipients for this transaction. Don't send more, give
@@ -1475,3 +1530,17 @@ func (c *Client) Conn() (net.Conn, error) {
 	}
 	return c.conn, nil
 }
+
+// xtext encodes s as xtext, with characters outside printable ASCII, "+" and "="
+// hex-encoded. ../rfc/3461
+func xtext(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c > ' ' && c < 0x7f && c != '+' && c != '=' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "+%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -852,6 +852,79 @@ func TestErrors(t *testing.T) {
 	})
 }
 
+func TestDeliverDSN(t *testing.T) {
+	ctx := context.Background()
+	log := mlog.New("smtpclient", nil)
+
+	dsn := &DSNParams{
+		Ret:   "HDRS",
+		EnvID: "id+1=x",
+		Recipients: []DSNRecipientParams{
+			{Notify: "SUCCESS,FAILURE", ORcpt: "orig@mox.example"},
+			{},
+		},
+	}
+	rcptTo := []string{"mjl@mox.example", "other@mox.example"}
+
+	// Remote supports DSN, parameters are passed.
+	run(t, func(s xserver) {
+		s.writeline("220 mox.example")
+		s.readline("EHLO")
+		s.writeline("250-mox.example")
+		s.writeline("250 DSN")
+		s.readline("MAIL FROM:<postmaster@other.example> RET=HDRS ENVID=id+2B1+3Dx\r\n")
+		s.writeline("250 ok")
+		s.readline("RCPT TO:<mjl@mox.example> NOTIFY=SUCCESS,FAILURE ORCPT=rfc822;orig@mox.example\r\n")
+		s.writeline("250 ok")
+		s.readline("RCPT TO:<other@mox.example>\r\n")
+		s.writeline("250 ok")
+		s.readline("DATA")
+		s.writeline("354 continue")
+		s.readline(".")
+		s.writeline("250 ok")
+	}, func(conn net.Conn) {
+		c, err := New(ctx, log.Logger, conn, TLSOpportunistic, false, localhost, zerohost, Opts{})
+		if err != nil {
+			panic(err)
+		}
+		if !c.SupportsDSN() {
+			panic("dsn extension not recognized")
+		}
+		msg := ""
+		_, err = c.DeliverMultipleDSN(ctx, "postmaster@other.example", rcptTo, int64(len(msg)), strings.NewReader(msg), false, false, false, dsn)
+		if err != nil {
+			panic(err)
+		}
+	})
+
+	// Remote does not support DSN, parameters are not passed.
+	run(t, func(s xserver) {
+		s.writeline("220 mox.example")
+		s.readline("EHLO")
+		s.writeline("250 mox.example")
+		s.readline("MAIL FROM:<postmaster@other.example>\r\n")
+		s.writeline("250 ok")
+		s.readline("RCPT TO:<mjl@mox.example>\r\n")
+		s.writeline("250 ok")
+		s.readline("RCPT TO:<other@mox.example>\r\n")
+		s.writeline("250 ok")
+		s.readline("DATA")
+		s.writeline("354 continue")
+		s.readline(".")
+		s.writeline("250 ok")
+	}, func(conn net.Conn) {
+		c, err := New(ctx, log.Logger, conn, TLSOpportunistic, false, localhost, zerohost, Opts{})
+		if err != nil {
+			panic(err)
+		}
+		msg := ""
+		_, err = c.DeliverMultipleDSN(ctx, "postmaster@other.example", rcptTo, int64(len(msg)), strings.NewReader(msg), false, false, false, dsn)
+		if err != nil {
+			panic(err)
+		}
+	})
+}
+
 type xserver struct {
 	conn net.Conn
 	br   *bufio.Reader
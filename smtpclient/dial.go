@@ -92,3 +92,19 @@ func Dial(ctx context.Context, elog *slog.Logger, dialer Dialer, host dns.IPDoma
 	// todo: possibly return all errors joined?
 	return nil, lastIP, lastErr
 }
+
+// DialName connects to host by name through dialer, instead of to IPs looked up
+// by the caller. For use with a dialer that resolves names itself, e.g. a SOCKS
+// proxy connecting to .onion domains. DialHook is used, if set.
+func DialName(ctx context.Context, elog *slog.Logger, dialer Dialer, host dns.Domain, port int) (net.Conn, error) {
+	log := mlog.New("smtpclient", elog)
+	addr := net.JoinHostPort(host.ASCII, fmt.Sprintf("%d", port))
+	log.Debug("dialing host by name", slog.String("addr", addr))
+	conn, err := dial(ctx, dialer, 30*time.Second, addr, nil)
+	if err != nil {
+		log.Debugx("connection attempt", err, slog.String("addr", addr))
+		return nil, err
+	}
+	log.Debug("connected to host", slog.String("addr", addr))
+	return conn, nil
+}
@@ -2,6 +2,7 @@ package smtpserver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
@@ -22,6 +23,7 @@ import (
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/publicsuffix"
+	"github.com/mjl-/mox/rspamd"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/subjectpass"
@@ -41,6 +43,9 @@ type delivery struct {
 	msgCc            []message.Address
 	msgFrom          smtp.Address
 	dnsBLs           []dns.Domain
+	dnsBLWeights     map[dns.Domain]float64
+	dnsBLThreshold   float64
+	uriBLs           []dns.Domain
 	dmarcUse         bool
 	dmarcResult      dmarc.Result
 	dkimResults      []dkim.Result
@@ -65,6 +70,9 @@ type analysis struct {
 	// Additional headers to add during delivery. Used for reasons a message to a
 	// dmarc/tls reporting address isn't processed.
 	headers string
+	// Matching ruleset whose actions (mark seen, forward, discard) apply to an
+	// accepted message. Not set for messages accepted as reject.
+	ruleset *config.Ruleset
 }
 
 const (
@@ -80,11 +88,15 @@ const (
 	reasonJunkContent       = "junk-content"
 	reasonJunkContentStrict = "junk-content-strict"
 	reasonDNSBlocklisted    = "dns-blocklisted"
+	reasonURIBlocklisted    = "uri-blocklisted"
 	reasonSubjectpass       = "subjectpass"
 	reasonSubjectpassError  = "subjectpass-error"
 	reasonIPrev             = "iprev"     // No or mild junk reputation signals, and bad iprev.
 	reasonHighRate          = "high-rate" // Too many messages, not added to rejects.
 	reasonMsgAuthRequired   = "msg-auth-required"
+	reasonSenderAllow       = "sender-allow"
+	reasonRspamd            = "rspamd"
+	reasonRspamdError       = "rspamd-error"
 )
 
 func isListDomain(d delivery, ld dns.Domain) bool {
@@ -185,18 +197,21 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		log.Errorx("checking delivery rates", err)
 		metricDelivery.WithLabelValues("checkrates", "").Inc()
 		addReasonText("checking delivery rates: %v", err)
-		return analysis{d, false, "", smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing", err, nil, nil, reasonReputationError, reasonText, "", headers}
+		return analysis{d, false, "", smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing", err, nil, nil, reasonReputationError, reasonText, "", headers, nil}
 	} else if err != nil {
 		log.Debugx("refusing due to high delivery rate", err)
 		metricDelivery.WithLabelValues("highrate", "").Inc()
 		addReasonText("high delivery rate")
-		return analysis{d, false, "", smtp.C452StorageFull, smtp.SeMailbox2Full2, true, err.Error(), err, nil, nil, reasonHighRate, reasonText, "", headers}
+		return analysis{d, false, "", smtp.C452StorageFull, smtp.SeMailbox2Full2, true, err.Error(), err, nil, nil, reasonHighRate, reasonText, "", headers, nil}
 	}
 
 	mailbox := d.destination.Mailbox
 	if mailbox == "" {
 		mailbox = "Inbox"
 	}
+	if mb := subaddressMailbox(log, d); mb != "" {
+		mailbox = mb
+	}
 
 	// If destination mailbox has a mailing list domain (for SPF/DKIM) configured,
 	// check it for a pass.
@@ -217,6 +232,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 				reasonText:          reasonText,
 				dmarcOverrideReason: string(dmarcrpt.PolicyOverrideMailingList),
 				headers:             headers,
+				ruleset:             rs,
 			}
 		}
 	}
@@ -288,7 +304,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 			})
 			if mberr != nil {
 				addReasonText("error setting original destination mailbox for rejected message: %v", mberr)
-				return analysis{d, false, mailbox, smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing", err, nil, nil, reasonReputationError, reasonText, dmarcOverrideReason, headers}
+				return analysis{d, false, mailbox, smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing", err, nil, nil, reasonReputationError, reasonText, dmarcOverrideReason, headers, nil}
 			}
 			d.m.MailboxID = 0 // We plan to reject, no need to set intended MailboxID.
 		}
@@ -303,7 +319,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 			log.Info("accepting reject to configured mailbox due to ruleset")
 			addReasonText("accepting reject to mailbox due to ruleset")
 		}
-		return analysis{d, accept, mailbox, code, secode, err == nil, errmsg, err, nil, nil, reason, reasonText, dmarcOverrideReason, headers}
+		return analysis{d, accept, mailbox, code, secode, err == nil, errmsg, err, nil, nil, reason, reasonText, dmarcOverrideReason, headers, nil}
 	}
 
 	if d.dmarcUse && d.dmarcResult.Reject {
@@ -410,6 +426,39 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		return reject(code, smtp.SePol7MultiAuthFails26, msg, nil, reasonMsgAuthRequired)
 	}
 
+	// Senders can be explicitly allowed, e.g. after a message was moved out of the
+	// Rejects mailbox. Only for verified message From addresses, the From address is
+	// trivial to spoof otherwise.
+	if d.m.MsgFromValidated {
+		var allowed bool
+		d.acc.WithRLock(func() {
+			err = d.acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+				allowed, err = store.SenderAllowed(tx, d.m.MsgFromLocalpart, d.m.MsgFromDomain)
+				return err
+			})
+		})
+		if err != nil {
+			log.Errorx("checking sender allow entries", err)
+			addReasonText("checking sender allow entries: %v", err)
+			return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, reasonReputationError)
+		} else if allowed {
+			log.Info("accepting message from allowed sender")
+			addReasonText("verified message from address or domain is on allow list")
+			return analysis{
+				d:                   d,
+				accept:              true,
+				mailbox:             mailbox,
+				dmarcReport:         dmarcReport,
+				tlsReport:           tlsReport,
+				reason:              reasonSenderAllow,
+				reasonText:          reasonText,
+				dmarcOverrideReason: dmarcOverrideReason,
+				headers:             headers,
+				ruleset:             rs,
+			}
+		}
+	}
+
 	// Determine if message is acceptable based on DMARC domain, DKIM identities, or
 	// host-based reputation.
 	var isjunk *bool
@@ -462,6 +511,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 				reasonText:          reasonText,
 				dmarcOverrideReason: dmarcOverrideReason,
 				headers:             headers,
+				ruleset:             rs,
 			}
 		}
 		return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, string(method))
@@ -478,6 +528,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 			reasonText:          reasonText,
 			dmarcOverrideReason: dmarcOverrideReason,
 			headers:             headers,
+			ruleset:             rs,
 		}
 	}
 	// If there was no previous message from sender or its domain, and we have an SPF
@@ -529,6 +580,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 				reasonText:          reasonText,
 				dmarcOverrideReason: dmarcOverrideReason,
 				headers:             headers,
+				ruleset:             rs,
 			}
 		}
 	}
@@ -536,119 +588,186 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 	reason = reasonNoBadSignals
 	accept := true
 	var junkSubjectpass bool
-	f, jf, err := d.acc.OpenJunkFilter(ctx, log)
-	if err == nil {
-		defer func() {
-			err := f.Close()
-			log.Check(err, "closing junkfilter")
-		}()
-		result, err := f.ClassifyMessageReader(ctx, store.FileMsgReader(d.m.MsgPrefix, d.dataFile), d.m.Size)
-		if err != nil {
-			log.Errorx("testing for spam", err)
-			addReasonText("classify message error: %v", err)
-			return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, reasonJunkClassifyError)
-		}
-		// todo: if isjunk is not nil (i.e. there was inconclusive reputation), use it in the probability calculation. give reputation a score of 0.25 or .75 perhaps?
-		// todo: if there aren't enough historic messages, we should just let messages in.
-		// todo: we could require nham and nspam to be above a certain number when there were plenty of words in the message, and in the database. can indicate a spammer is misspelling words. however, it can also mean a message in a different language/script...
-
-		// If we don't accept, we may still respond with a "subjectpass" hint below.
-		// We add some jitter to the threshold we use. So we don't act as too easy an
-		// oracle for words that are a strong indicator of haminess.
-		// todo: we should rate-limit uses of the junkfilter.
-		jitter := (jitterRand.Float64() - 0.5) / 10
-		threshold := jf.Threshold + jitter
-
-		rcptToMatch := func(l []message.Address) bool {
-			// todo: we use Go's net/mail to parse message header addresses. it does not allow empty quoted strings (contrary to spec), leaving To empty. so we don't verify To address for that unusual case for now. ../rfc/5322:961 ../rfc/5322:743
-			if d.smtpRcptTo.Localpart == "" {
-				return true
-			}
-			for _, a := range l {
-				dom, err := dns.ParseDomain(a.Host)
-				if err != nil {
-					continue
+
+	// With rspamd configured, it classifies the message instead of, or in addition
+	// to, the builtin junk filter.
+	useJunkFilter := true
+	var rspamdJunk, rspamdSubjectpass bool
+	if rc := mox.Conf.Static.Rspamd; rc != nil {
+		ehlo := d.m.EHLODomain
+		if ehlo == "" {
+			ehlo = d.m.OrigEHLODomain
+		}
+		req := rspamd.Request{
+			IP:        d.m.RemoteIP,
+			Helo:      ehlo,
+			From:      d.m.MailFrom,
+			Rcpt:      d.smtpRcptTo.String(),
+			DeliverTo: d.deliverTo.String(),
+		}
+		result, err := rspamd.Check(ctx, log, rc, req, store.FileMsgReader(d.m.MsgPrefix, d.dataFile), d.m.Size)
+		if err != nil && !rc.FailOpen {
+			log.Errorx("checking message with rspamd", err)
+			addReasonText("rspamd error: %v", err)
+			return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, reasonRspamdError)
+		} else if err != nil {
+			log.Errorx("checking message with rspamd, continuing due to fail open", err)
+			addReasonText("rspamd error, ignored due to fail open: %v", err)
+		} else {
+			useJunkFilter = rc.Mode == "combine"
+			rspamdJunk = result.Action.Junk()
+			// Like with the builtin junk filter, we only give a subjectpass hint for
+			// moderately spammy messages, not for those rspamd wants rejected.
+			rspamdSubjectpass = rspamdJunk && result.Action != rspamd.ActionReject
+			log.Info("rspamd analyzed",
+				slog.String("action", string(result.Action)),
+				slog.Float64("score", result.Score),
+				slog.Float64("requiredscore", result.RequiredScore))
+
+			s := fmt.Sprintf("rspamd: action %s, score %.2f, required score %.2f (symbols: ", result.Action, result.Score, result.RequiredScore)
+			for i, sym := range result.TopSymbols(10) {
+				if i > 0 {
+					s += ", "
 				}
-				lp, err := smtp.ParseLocalpart(a.User)
-				if err == nil && dom == d.smtpRcptTo.IPDomain.Domain && lp == d.smtpRcptTo.Localpart {
+				s += fmt.Sprintf("%s %.2f", sym.Name, sym.Score)
+			}
+			s += ")"
+			addReasonText("%s", s)
+		}
+	}
+
+	if useJunkFilter {
+		f, jf, err := d.acc.OpenJunkFilter(ctx, log)
+		if err == nil {
+			defer func() {
+				err := f.Close()
+				log.Check(err, "closing junkfilter")
+			}()
+			result, err := f.ClassifyMessageReader(ctx, store.FileMsgReader(d.m.MsgPrefix, d.dataFile), d.m.Size)
+			if err != nil {
+				log.Errorx("testing for spam", err)
+				addReasonText("classify message error: %v", err)
+				return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, reasonJunkClassifyError)
+			}
+			// todo: if isjunk is not nil (i.e. there was inconclusive reputation), use it in the probability calculation. give reputation a score of 0.25 or .75 perhaps?
+			// todo: if there aren't enough historic messages, we should just let messages in.
+			// todo: we could require nham and nspam to be above a certain number when there were plenty of words in the message, and in the database. can indicate a spammer is misspelling words. however, it can also mean a message in a different language/script...
+
+			// If we don't accept, we may still respond with a "subjectpass" hint below.
+			// We add some jitter to the threshold we use. So we don't act as too easy an
+			// oracle for words that are a strong indicator of haminess.
+			// todo: we should rate-limit uses of the junkfilter.
+			jitter := (jitterRand.Float64() - 0.5) / 10
+			threshold := jf.Threshold + jitter
+
+			rcptToMatch := func(l []message.Address) bool {
+				// todo: we use Go's net/mail to parse message header addresses. it does not allow empty quoted strings (contrary to spec), leaving To empty. so we don't verify To address for that unusual case for now. ../rfc/5322:961 ../rfc/5322:743
+				if d.smtpRcptTo.Localpart == "" {
 					return true
 				}
+				for _, a := range l {
+					dom, err := dns.ParseDomain(a.Host)
+					if err != nil {
+						continue
+					}
+					lp, err := smtp.ParseLocalpart(a.User)
+					if err == nil && dom == d.smtpRcptTo.IPDomain.Domain && lp == d.smtpRcptTo.Localpart {
+						return true
+					}
+				}
+				return false
 			}
-			return false
-		}
 
-		// todo: some of these checks should also apply for reputation-based analysis with a weak signal, e.g. verified dkim/spf signal from new domain.
-		// With an iprev fail, non-TLS connection or our address not in To/Cc header, we set a higher bar for content.
-		reason = reasonJunkContent
-		var thresholdRemark string
-		if suspiciousIPrevFail && threshold > 0.25 {
-			threshold = 0.25
-			log.Info("setting junk threshold due to iprev fail", slog.Float64("threshold", threshold))
-			reason = reasonJunkContentStrict
-			thresholdRemark = " (stricter due to reverse ip mismatch)"
-		} else if !d.tls && threshold > 0.25 {
-			threshold = 0.25
-			log.Info("setting junk threshold due to plaintext smtp", slog.Float64("threshold", threshold))
-			reason = reasonJunkContentStrict
-			thresholdRemark = " (stricter due to missing tls)"
-		} else if (rs == nil || !rs.IsForward) && threshold > 0.25 && !rcptToMatch(d.msgTo) && !rcptToMatch(d.msgCc) {
-			// A common theme in junk messages is your recipient address not being in the To/Cc
-			// headers. We may be in Bcc, but that's unusual for first-time senders. Some
-			// providers (e.g. gmail) does not DKIM-sign Bcc headers, so junk messages can be
-			// sent with matching Bcc headers. We don't get here for known senders.
-			threshold = 0.25
-			log.Info("setting junk threshold due to smtp rcpt to and message to/cc address mismatch", slog.Float64("threshold", threshold))
-			reason = reasonJunkContentStrict
-			thresholdRemark = " (stricter due to recipient address not in to/cc header)"
-		}
-		accept = result.Probability <= threshold || (!result.Significant && !suspiciousIPrevFail)
-		junkSubjectpass = result.Probability < threshold-0.2
-		log.Info("content analyzed",
-			slog.Bool("accept", accept),
-			slog.Float64("contentprob", result.Probability),
-			slog.Bool("contentsignificant", result.Significant),
-			slog.Bool("subjectpass", junkSubjectpass))
-
-		s := "content: "
-		if accept {
-			s += "not junk"
-		} else {
-			s += "junk"
-		}
-		if !result.Significant {
-			s += " (not significant)"
-		}
-		s += fmt.Sprintf(", spamscore %.2f, threshold %.2f%s", result.Probability, threshold, thresholdRemark)
-		s += " (ham words: "
-		for i, w := range result.Hams {
-			if i > 0 {
-				s += ", "
+			// todo: some of these checks should also apply for reputation-based analysis with a weak signal, e.g. verified dkim/spf signal from new domain.
+			// With an iprev fail, non-TLS connection or our address not in To/Cc header, we set a higher bar for content.
+			reason = reasonJunkContent
+			var thresholdRemark string
+			if suspiciousIPrevFail && threshold > 0.25 {
+				threshold = 0.25
+				log.Info("setting junk threshold due to iprev fail", slog.Float64("threshold", threshold))
+				reason = reasonJunkContentStrict
+				thresholdRemark = "stricter due to reverse ip mismatch"
+			} else if !d.tls && threshold > 0.25 {
+				threshold = 0.25
+				log.Info("setting junk threshold due to plaintext smtp", slog.Float64("threshold", threshold))
+				reason = reasonJunkContentStrict
+				thresholdRemark = "stricter due to missing tls"
+			} else if (rs == nil || !rs.IsForward) && threshold > 0.25 && !rcptToMatch(d.msgTo) && !rcptToMatch(d.msgCc) {
+				// A common theme in junk messages is your recipient address not being in the To/Cc
+				// headers. We may be in Bcc, but that's unusual for first-time senders. Some
+				// providers (e.g. gmail) does not DKIM-sign Bcc headers, so junk messages can be
+				// sent with matching Bcc headers. We don't get here for known senders.
+				threshold = 0.25
+				log.Info("setting junk threshold due to smtp rcpt to and message to/cc address mismatch", slog.Float64("threshold", threshold))
+				reason = reasonJunkContentStrict
+				thresholdRemark = "stricter due to recipient address not in to/cc header"
 			}
-			word := w.Word
-			if !d.smtputf8 && !isASCII(word) {
-				word = "(non-ascii)"
+			accept = result.Probability <= threshold || (!result.Significant && !suspiciousIPrevFail)
+			junkSubjectpass = result.Probability < threshold-0.2
+			log.Info("content analyzed",
+				slog.Bool("accept", accept),
+				slog.Float64("contentprob", result.Probability),
+				slog.Bool("contentsignificant", result.Significant),
+				slog.Bool("subjectpass", junkSubjectpass))
+
+			d.m.JunkClassification = store.NewJunkClassification(!accept, result, threshold, thresholdRemark)
+
+			s := "content: "
+			if accept {
+				s += "not junk"
+			} else {
+				s += "junk"
 			}
-			s += fmt.Sprintf("%s %.3f", word, w.Score)
-		}
-		s += "), (spam words: "
-		for i, w := range result.Spams {
-			if i > 0 {
-				s += ", "
+			if !result.Significant {
+				s += " (not significant)"
+			}
+			s += fmt.Sprintf(", spamscore %.2f, threshold %.2f", result.Probability, threshold)
+			if thresholdRemark != "" {
+				s += " (" + thresholdRemark + ")"
 			}
-			word := w.Word
-			if !d.smtputf8 && !isASCII(word) {
-				word = "(non-ascii)"
+			s += " (ham words: "
+			for i, w := range result.Hams {
+				if i > 0 {
+					s += ", "
+				}
+				word := w.Word
+				if !d.smtputf8 && !isASCII(word) {
+					word = "(non-ascii)"
+				}
+				s += fmt.Sprintf("%s %.3f", word, w.Score)
 			}
-			s += fmt.Sprintf("%s %.3f", word, w.Score)
+			s += "), (spam words: "
+			for i, w := range result.Spams {
+				if i > 0 {
+					s += ", "
+				}
+				word := w.Word
+				if !d.smtputf8 && !isASCII(word) {
+					word = "(non-ascii)"
+				}
+				s += fmt.Sprintf("%s %.3f", word, w.Score)
+			}
+			s += ")"
+			addReasonText("%s", s)
+		} else if err != store.ErrNoJunkFilter {
+			log.Errorx("open junkfilter", err)
+			addReasonText("open junkfilter: %v", err)
+			return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, reasonJunkFilterError)
+		} else {
+			addReasonText("no junk filter configured")
 		}
-		s += ")"
-		addReasonText("%s", s)
-	} else if err != store.ErrNoJunkFilter {
-		log.Errorx("open junkfilter", err)
-		addReasonText("open junkfilter: %v", err)
-		return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, reasonJunkFilterError)
-	} else {
-		addReasonText("no junk filter configured")
+	}
+
+	// In combine mode, a message is junk if either rspamd or the builtin junk filter
+	// classifies it as junk.
+	if rspamdJunk {
+		if accept {
+			reason = reasonRspamd
+			junkSubjectpass = rspamdSubjectpass
+		} else {
+			junkSubjectpass = junkSubjectpass && rspamdSubjectpass
+		}
+		accept = false
 	}
 
 	// If content looks good, we'll still look at DNS block lists for a reason to
@@ -666,8 +785,9 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 
 			status, expl, err := dnsbl.Lookup(dnsblctx, log.Logger, resolver, zone, net.ParseIP(d.m.RemoteIP))
 			dnsblcancel()
+			metricDNSBLLookup.WithLabelValues(zone.Name(), string(status)).Inc()
 			if status == dnsbl.StatusFail {
-				log.Info("rejecting due to listing in dnsbl", slog.Any("zone", zone), slog.String("explanation", expl))
+				log.Info("ip listed in dnsbl", slog.Any("zone", zone), slog.String("explanation", expl))
 				return true
 			} else if err != nil {
 				log.Infox("dnsbl lookup", err, slog.Any("zone", zone), slog.Any("status", status))
@@ -676,13 +796,40 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		}
 
 		// Note: We don't check in parallel, we are in no hurry to accept possible spam.
-		for _, zone := range d.dnsBLs {
-			if blocked(zone) {
+		if d.dnsBLThreshold == 0 {
+			for _, zone := range d.dnsBLs {
+				if blocked(zone) {
+					log.Info("rejecting due to listing in dnsbl", slog.Any("zone", zone))
+					accept = false
+					dnsblocklisted = true
+					reason = reasonDNSBlocklisted
+					addReasonText("dnsbl: ip %s listed in dnsbl %s", d.m.RemoteIP, zone.XName(d.smtputf8))
+					break
+				}
+			}
+		} else {
+			// With a threshold, we consult all DNSBLs and add the weights of those listing
+			// the IP.
+			var score float64
+			var listed []string
+			for _, zone := range d.dnsBLs {
+				if blocked(zone) {
+					w, ok := d.dnsBLWeights[zone]
+					if !ok {
+						w = 1
+					}
+					score += w
+					listed = append(listed, fmt.Sprintf("%s (weight %g)", zone.XName(d.smtputf8), w))
+				}
+			}
+			if len(listed) > 0 {
+				addReasonText("dnsbl: ip %s listed in dnsbls %s, score %g, threshold %g", d.m.RemoteIP, strings.Join(listed, ", "), score, d.dnsBLThreshold)
+			}
+			if score >= d.dnsBLThreshold {
+				log.Info("rejecting due to dnsbl score", slog.Float64("score", score), slog.Float64("threshold", d.dnsBLThreshold))
 				accept = false
 				dnsblocklisted = true
 				reason = reasonDNSBlocklisted
-				addReasonText("dnsbl: ip %s listed in dnsbl %s", d.m.RemoteIP, zone.XName(d.smtputf8))
-				break
 			}
 		}
 		if !dnsblocklisted && len(d.dnsBLs) > 0 {
@@ -690,6 +837,19 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		}
 	}
 
+	// Domains of URLs in the message can also be blocklisted. A listing is a strong
+	// signal, we don't give a subjectpass hint.
+	if accept && len(d.uriBLs) > 0 {
+		listed, s := uriBlocklisted(ctx, log, resolver, d)
+		if s != "" {
+			addReasonText("%s", s)
+		}
+		if listed {
+			accept = false
+			reason = reasonURIBlocklisted
+		}
+	}
+
 	if accept {
 		addReasonText("no known reputation and no bad signals")
 		return analysis{
@@ -700,6 +860,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 			reasonText:          reasonText,
 			dmarcOverrideReason: dmarcOverrideReason,
 			headers:             headers,
+			ruleset:             rs,
 		}
 	}
 
@@ -721,3 +882,48 @@ func isASCII(s string) bool {
 	}
 	return true
 }
+
+// subaddressMailbox returns the mailbox named after the subaddress of the
+// recipient localpart, the part after a catchall separator, if the account has
+// SubaddressMailboxes enabled. An empty string is returned if not applicable, or
+// if the mailbox would be the Inbox, rejects mailbox or a special-use mailbox.
+func subaddressMailbox(log mlog.Log, d delivery) string {
+	conf, _ := d.acc.Conf()
+	if !conf.SubaddressMailboxes || !d.smtpRcptTo.Equal(d.deliverTo) {
+		// Not for aliases, the subaddress is of the alias address.
+		return ""
+	}
+	dc, ok := mox.Conf.Domain(d.smtpRcptTo.IPDomain.Domain)
+	if !ok {
+		return ""
+	}
+	lp := string(d.smtpRcptTo.Localpart)
+	var sub string
+	index := -1
+	for _, sep := range dc.LocalpartCatchallSeparatorsEffective {
+		if i := strings.Index(lp, sep); i > 0 && (index < 0 || i < index) {
+			index = i
+			sub = lp[i+len(sep):]
+		}
+	}
+	if sub == "" || strings.Contains(sub, "/") {
+		return ""
+	}
+	name, _, err := store.CheckMailboxName(sub, false)
+	if err != nil || name == conf.RejectsMailbox {
+		log.Debugx("not using subaddress as mailbox", err, slog.String("subaddress", sub))
+		return ""
+	}
+	err = d.acc.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+		mb, err := d.acc.MailboxFind(tx, name)
+		if err == nil && mb != nil && mb.SpecialUse != (store.SpecialUse{}) {
+			err = errors.New("special-use mailbox")
+		}
+		return err
+	})
+	if err != nil {
+		log.Debugx("not using subaddress as mailbox", err, slog.String("mailbox", name))
+		return ""
+	}
+	return name
+}
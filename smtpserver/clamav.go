@@ -0,0 +1,50 @@
+package smtpserver
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/mjl-/mox/clamav"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/smtp"
+)
+
+// virusScanEnabled returns whether messages for recipient domain d must be
+// scanned for viruses.
+func virusScanEnabled(d dns.Domain) bool {
+	cl := mox.Conf.Static.ClamAV
+	if cl == nil {
+		return false
+	}
+	if dc, ok := mox.Conf.Domain(d); ok && dc.VirusScan != "" {
+		return dc.VirusScan == "enabled"
+	}
+	return !cl.DefaultDisabled
+}
+
+// xvirusScan scans the message with clamd and returns the name of a virus found,
+// or an empty string. Messages larger than the configured maximum size are not
+// scanned. If the message could not be scanned, the command is aborted with a
+// temporary error, unless ClamAV is configured to fail open.
+func (c *conn) xvirusScan(ctx context.Context, size int64, dataFile *os.File) string {
+	cl := mox.Conf.Static.ClamAV
+	if size > cl.MaxSize {
+		c.log.Info("not scanning large message for viruses", slog.Int64("size", size), slog.Int64("maxsize", cl.MaxSize))
+		return ""
+	}
+	virus, err := clamav.Scan(ctx, c.log, cl.Network, cl.NetworkAddr, cl.Timeout, &moxio.AtReader{R: dataFile})
+	if err != nil && cl.FailOpen {
+		c.log.Errorx("scanning message for viruses, continuing due to fail open", err)
+		return ""
+	} else if err != nil {
+		c.log.Errorx("scanning message for viruses", err)
+		xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "error scanning message for viruses, try again later")
+	}
+	if virus != "" {
+		c.log.Info("virus found in message", slog.String("virus", virus), slog.String("action", cl.Action))
+	}
+	return virus
+}
@@ -0,0 +1,150 @@
+package smtpserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
+	"github.com/mjl-/mox/store"
+)
+
+func TestVirusScan(t *testing.T) {
+	// Fake clamd, finding a virus in messages containing "EICAR".
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	tcheck(t, err, "listen")
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				cmd := make([]byte, len("zINSTREAM\x00"))
+				if _, err := io.ReadFull(conn, cmd); err != nil {
+					return
+				}
+				var data []byte
+				for {
+					var sizebuf [4]byte
+					if _, err := io.ReadFull(conn, sizebuf[:]); err != nil {
+						return
+					}
+					n := binary.BigEndian.Uint32(sizebuf[:])
+					if n == 0 {
+						break
+					}
+					buf := make([]byte, n)
+					if _, err := io.ReadFull(conn, buf); err != nil {
+						return
+					}
+					data = append(data, buf...)
+				}
+				if bytes.Contains(data, []byte("EICAR")) {
+					conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+				} else {
+					conn.Write([]byte("stream: OK\x00"))
+				}
+			}()
+		}
+	}()
+
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+		TXT: map[string][]string{
+			"example.org.": {"v=spf1 ip4:127.0.0.10 -all"},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	mox.Conf.Static.ClamAV = &config.ClamAV{
+		Address:     "inet:" + ln.Addr().String(),
+		Network:     "tcp",
+		NetworkAddr: ln.Addr().String(),
+		Timeout:     5 * time.Second,
+		Action:      "reject",
+		MaxSize:     1024 * 1024,
+	}
+	defer func() {
+		mox.Conf.Static.ClamAV = nil
+	}()
+
+	virusMessage := strings.ReplaceAll(deliverMessage, "test email", "EICAR test")
+
+	testDeliver := func(msg string, expErr *smtpclient.Error) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+			err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+			ts.smtpErr(err, expErr)
+		})
+	}
+
+	// Clean message is delivered.
+	testDeliver(deliverMessage, nil)
+	ts.checkCount("Inbox", 1)
+
+	// Message with virus is rejected, and not stored in the rejects mailbox.
+	testDeliver(virusMessage, &smtpclient.Error{Permanent: true, Code: smtp.C554TransactionFailed, Secode: smtp.SePol7DeliveryUnauth1})
+	ts.checkCount("Inbox", 1)
+	n, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).FilterEqual("Expunged", false).Count()
+	tcheck(t, err, "count messages")
+	tcompare(t, n, 1)
+
+	// Message larger than the maximum size is not scanned.
+	mox.Conf.Static.ClamAV.MaxSize = 10
+	testDeliver(virusMessage, nil)
+	ts.checkCount("Inbox", 2)
+	mox.Conf.Static.ClamAV.MaxSize = 1024 * 1024
+
+	// With action quarantine, the message is delivered to the junk mailbox, with a header.
+	mox.Conf.Static.ClamAV.Action = "quarantine"
+	testDeliver(virusMessage, nil)
+	ts.checkCount("Junk", 1)
+	m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).FilterEqual("Expunged", false).SortDesc("ID").Limit(1).Get()
+	tcheck(t, err, "get delivered message")
+	mr := ts.acc.MessageReader(m)
+	buf, err := io.ReadAll(mr)
+	tcheck(t, err, "read message")
+	err = mr.Close()
+	tcheck(t, err, "close message")
+	if !strings.Contains(string(buf), "X-Mox-Virus: Eicar-Signature\r\n") {
+		t.Fatalf("missing virus header in message:\n%s", buf)
+	}
+
+	// Scanning disabled for domain.
+	mox.Conf.Static.ClamAV.Action = "reject"
+	dom, _ := mox.Conf.Domain(dns.Domain{ASCII: "mox.example"})
+	dom.VirusScan = "disabled"
+	mox.Conf.Dynamic.Domains["mox.example"] = dom
+	testDeliver(virusMessage, nil)
+	ts.checkCount("Inbox", 3)
+	dom.VirusScan = ""
+	mox.Conf.Dynamic.Domains["mox.example"] = dom
+
+	// Unreachable clamd results in temporary error, and is ignored with FailOpen.
+	ln.Close()
+	testDeliver(deliverMessage, &smtpclient.Error{Code: smtp.C451LocalErr, Secode: smtp.SeSys3Other0})
+	mox.Conf.Static.ClamAV.FailOpen = true
+	testDeliver(deliverMessage, nil)
+	ts.checkCount("Inbox", 4)
+}
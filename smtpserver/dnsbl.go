@@ -6,11 +6,25 @@ import (
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/dnsbl"
 	"github.com/mjl-/mox/mlog"
 )
 
+var metricDNSBLLookup = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_smtpserver_dnsbl_lookup_total",
+		Help: "DNSBL lookups for incoming deliveries, per zone and result, for tuning DNSBL weights.",
+	},
+	[]string{
+		"zone",
+		"result", // pass (not listed), fail (listed), temperror
+	},
+)
+
 var dnsblHealth = struct {
 	sync.Mutex
 	zones map[dns.Domain]dnsblStatus
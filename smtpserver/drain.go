@@ -0,0 +1,28 @@
+package smtpserver
+
+import (
+	"sync/atomic"
+)
+
+// Number of connections with a message transaction in progress, i.e. after a MAIL
+// FROM command, including connections waiting for the next command.
+var transactions atomic.Int64
+
+// Transactions returns the number of connections with a message transaction in
+// progress. Used while draining, to wait for transactions to complete before
+// shutting down.
+func Transactions() int64 {
+	return transactions.Load()
+}
+
+func (c *conn) transactionSet(active bool) {
+	if active == c.transaction {
+		return
+	}
+	c.transaction = active
+	if active {
+		transactions.Add(1)
+	} else {
+		transactions.Add(-1)
+	}
+}
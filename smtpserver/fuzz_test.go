@@ -113,7 +113,7 @@ func FuzzServer(f *testing.F) {
 			const viaHTTPS = false
 			err := serverConn.SetDeadline(time.Now().Add(time.Second))
 			flog(err, "set server deadline")
-			serve("test", cid, dns.Domain{ASCII: "mox.example"}, nil, serverConn, resolver, submission, false, viaHTTPS, false, 100<<10, false, false, false, nil, 0)
+			serve("test", cid, dns.Domain{ASCII: "mox.example"}, nil, serverConn, resolver, submission, false, viaHTTPS, false, false, 100<<10, false, false, false, nil, nil, 0, nil, 0, 0, 0, nil, nil)
 			cid++
 		}
 
@@ -0,0 +1,76 @@
+package smtpserver
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/iprev"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+)
+
+var metricHELOCheck = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_smtpserver_helo_check_failed_total",
+		Help: "Incoming connections failing an EHLO/HELO check, by check (fqdn, ownhostname, fcrdns) and mode (log for only logged, enforce for rejected).",
+	},
+	[]string{
+		"check",
+		"mode",
+	},
+)
+
+// xheloChecks applies the HELOChecks of the listener to the EHLO/HELO hostname of
+// an incoming connection, for delivery, not submission. Failing checks are logged,
+// and in enforce mode result in a rejection of the EHLO/HELO command.
+func (c *conn) xheloChecks(remote dns.IPDomain) {
+	hc := c.heloChecks
+	if hc == nil {
+		return
+	}
+
+	check := func(name, mode string, failed bool, code int, secode, errmsg string) {
+		if !failed {
+			return
+		}
+		metricHELOCheck.WithLabelValues(name, mode).Inc()
+		c.log.Info("ehlo/helo check failed", slog.String("check", name), slog.String("mode", mode), slog.String("hello", remote.String()), slog.String("reason", errmsg))
+		if mode == "enforce" {
+			xsmtpUserErrorf(code, secode, "%s", errmsg)
+		}
+	}
+
+	// Address literals are fine, they are not hostnames. ../rfc/5321:1790
+	if hc.RequireFQDN != "" {
+		failed := !remote.Domain.IsZero() && !strings.Contains(strings.TrimSuffix(remote.Domain.ASCII, "."), ".")
+		check("fqdn", hc.RequireFQDN, failed, smtp.C550MailboxUnavail, smtp.SeProto5Other0, "ehlo/helo hostname must be a fully qualified domain name")
+	}
+
+	if hc.RejectOwnHostname != "" {
+		var failed bool
+		if remote.Domain.IsZero() {
+			failed = remote.IP.Equal(c.localIP)
+		} else {
+			failed = remote.Domain == c.hostname || remote.Domain == mox.Conf.Static.HostnameDomain
+		}
+		check("ownhostname", hc.RejectOwnHostname, failed, smtp.C550MailboxUnavail, smtp.SePol7DeliveryUnauth1, "ehlo/helo hostname must not be our own hostname")
+	}
+
+	if hc.RequireFCrDNS != "" {
+		cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
+		ctx, cancel := context.WithTimeout(cidctx, time.Minute)
+		status, _, _, _, err := iprev.Lookup(ctx, c.resolver, c.remoteIP)
+		cancel()
+		c.log.Debugx("forward-confirmed reverse dns check", err, slog.Any("status", status))
+		// Temporary errors never cause a rejection.
+		failed := status == iprev.StatusFail || status == iprev.StatusPermerror
+		check("fcrdns", hc.RequireFCrDNS, failed, smtp.C550MailboxUnavail, smtp.SePol7RevDNSFail25, "remote ip must have forward-confirmed reverse dns")
+	}
+}
@@ -0,0 +1,680 @@
+package smtpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dkim"
+	"github.com/mjl-/mox/dmarc"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// Mailing lists are configured per domain, see config.List. Messages to the list
+// address are posts, sent to all subscribers, or held for moderation by the list
+// owner. Messages to the request address are commands, e.g. for subscribing.
+// Messages are analyzed like regular deliveries, against the account of the list
+// owner, before they are handled here.
+//
+// Messages sent to subscribers get List-* headers, an optional subject prefix,
+// and a DKIM signature of the list domain. The list address is not an ARC
+// sealer. Instead, if the domain of the author has a DMARC policy that would
+// make receivers reject or quarantine messages that fail DMARC, e.g. because of
+// the modified subject, the From header is rewritten to the list address, with
+// the author in Reply-To.
+
+// rcptList is a recipient that is a mailing list or its request address.
+type rcptList struct {
+	List    config.List
+	Request bool // Whether the recipient is the request address, for commands.
+}
+
+// listMessage is an incoming message for a list, accepted after analysis.
+type listMessage struct {
+	a        analysis // For the account of the list owner.
+	envelope *message.Envelope
+	headers  textproto.MIMEHeader
+	part     *message.Part // Nil if message could not be parsed.
+	recvHdr  string        // Our Received header, included in messages to subscribers.
+	dataFile *os.File
+	size     int64
+	has8bit  bool
+	hold     bool // Whether the message must be held for moderation, e.g. when quarantined.
+}
+
+// listRecipient returns the list for rcptTo, if it is a list address or request
+// address, for incoming deliveries.
+func (c *conn) listRecipient(rcptTo smtp.Path) (l config.List, request, ok bool) {
+	if c.submission || rcptTo.IPDomain.IsIP() {
+		return config.List{}, false, false
+	}
+	return mox.LookupList(rcptTo.Localpart, rcptTo.IPDomain.Domain)
+}
+
+// deliverList handles a message for a list. Errors that should be returned to
+// the sender are of type smtpError.
+func (c *conn) deliverList(ctx context.Context, log mlog.Log, rl rcptList, lm listMessage) error {
+	listAddr, _ := rl.List.Addresses()
+	log = log.With(slog.Any("list", listAddr))
+	if rl.Request {
+		return c.listRequest(ctx, log, rl.List, lm)
+	}
+	return c.listPost(ctx, log, rl.List, lm)
+}
+
+// listIDValue returns the identifier of the list in the List-Id header, with
+// angle brackets. ../rfc/2919:159
+func listIDValue(l config.List) string {
+	return "<" + l.LocalpartStr + "." + l.Domain.ASCII + ">"
+}
+
+// listID returns the value for the List-Id header, with the description as
+// phrase.
+func listID(l config.List) string {
+	s := listIDValue(l)
+	if l.Description == "" {
+		return s
+	}
+	desc := l.Description
+	if !hasNonASCII(desc) {
+		desc = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(desc) + `"`
+	} else {
+		desc = mime.QEncoding.Encode("utf-8", desc)
+	}
+	return desc + " " + s
+}
+
+// listRewriteFrom returns whether the From header of a post must be replaced with
+// the list address, because the DMARC policy of the author domain would cause
+// the message to be rejected or quarantined by receivers, with DKIM signatures of
+// the author broken by our modifications and SPF failing for our IPs.
+func listRewriteFrom(a analysis, l config.List) bool {
+	r := a.d.dmarcResult.Record
+	if r == nil || a.d.msgFrom.Domain == l.Domain {
+		return false
+	}
+	p := r.Policy
+	if a.d.msgFrom.Domain != a.d.dmarcResult.Domain && r.SubdomainPolicy != dmarc.PolicyEmpty {
+		p = r.SubdomainPolicy
+	}
+	return p == dmarc.PolicyQuarantine || p == dmarc.PolicyReject
+}
+
+// listHeaders returns the header for a message to subscribers, from the original
+// header. List-* headers are replaced, and the subject prefix is added. If
+// rewriteFrom is set, the From header is replaced with the list address and a
+// Reply-To header with the author is added if not present.
+func listHeaders(l config.List, hdr []byte, author message.NameAddress, rewriteFrom, smtputf8 bool) (string, error) {
+	listAddr, requestAddr := l.Addresses()
+
+	var b strings.Builder
+	add := func(k, v string) {
+		b.WriteString(k + ": " + v + "\r\n")
+	}
+	add("List-Id", listID(l))
+	add("List-Post", "<mailto:"+listAddr.Pack(smtputf8)+">")
+	add("List-Help", "<mailto:"+requestAddr.Pack(smtputf8)+"?subject=help>")
+	add("List-Subscribe", "<mailto:"+requestAddr.Pack(smtputf8)+"?subject=subscribe>")
+	add("List-Unsubscribe", "<mailto:"+requestAddr.Pack(smtputf8)+"?subject=unsubscribe>")
+	add("Precedence", "list")
+
+	// Split the header in fields, continuation lines start with whitespace.
+	var fields []string
+	for _, line := range strings.SplitAfter(string(hdr), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+		} else {
+			fields = append(fields, line)
+		}
+	}
+
+	prefix := l.SubjectPrefix
+	if prefix != "" && hasNonASCII(prefix) && !smtputf8 {
+		prefix = mime.QEncoding.Encode("utf-8", prefix)
+	}
+	var haveSubject, haveReplyTo bool
+	for _, f := range fields {
+		k, v, ok := strings.Cut(f, ":")
+		if !ok {
+			return "", fmt.Errorf("malformed header field %q", f)
+		}
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "list-id", "list-post", "list-help", "list-subscribe", "list-unsubscribe", "list-unsubscribe-post", "list-owner", "list-archive", "precedence":
+			continue
+		case "subject":
+			haveSubject = true
+			if prefix != "" && !strings.Contains(v, prefix) && !strings.Contains(v, l.SubjectPrefix) {
+				f = k + ": " + prefix + " " + strings.TrimLeft(v, " \t")
+			}
+		case "reply-to":
+			haveReplyTo = true
+		case "from":
+			if rewriteFrom {
+				name := author.DisplayName
+				if name == "" {
+					name = author.Address.Pack(smtputf8)
+				}
+				var hb bytes.Buffer
+				xc := message.NewComposer(&hb, 10*1024, smtputf8)
+				xc.HeaderAddrs("From", []message.NameAddress{{DisplayName: name + " via " + l.LocalpartStr, Address: listAddr}})
+				xc.Flush()
+				f = hb.String()
+			}
+		}
+		b.WriteString(f)
+	}
+	if !haveSubject && prefix != "" {
+		add("Subject", prefix)
+	}
+	if rewriteFrom && !haveReplyTo {
+		var hb bytes.Buffer
+		xc := message.NewComposer(&hb, 10*1024, smtputf8)
+		xc.HeaderAddrs("Reply-To", []message.NameAddress{author})
+		xc.Flush()
+		b.WriteString(hb.String())
+	}
+	return b.String(), nil
+}
+
+// listPost sends a message to the subscribers of the list, or holds it for
+// moderation.
+func (c *conn) listPost(ctx context.Context, log mlog.Log, l config.List, lm listMessage) error {
+	listAddr, requestAddr := l.Addresses()
+	list := listAddr.Pack(true)
+	a := lm.a
+	msgFrom := a.d.msgFrom
+
+	// Messages we sent to subscribers coming back to the list, e.g. through a
+	// forwarding subscriber, must not be sent again.
+	for _, v := range lm.headers.Values("List-Id") {
+		if strings.Contains(strings.ToLower(v), strings.ToLower(listIDValue(l))) {
+			return smtpError{smtp.C554TransactionFailed, smtp.SeNet4Loop6, "mail loop detected for list", nil, false, true}
+		}
+	}
+
+	var holdReason string
+	if l.Moderated {
+		holdReason = "list is moderated"
+	} else if lm.hold {
+		holdReason = "message quarantined"
+	} else if !l.PostPublic {
+		if msgFrom.IsZero() || !a.d.m.MsgFromValidated {
+			holdReason = "message from address not verified"
+		} else if subscribed, err := store.ListSubscribed(ctx, list, msgFrom.Pack(true)); err != nil {
+			return fmt.Errorf("checking if sender is subscribed: %v", err)
+		} else if !subscribed {
+			holdReason = "sender not subscribed"
+		}
+	}
+
+	subs, err := store.ListSubscriberList(ctx, list)
+	if err != nil {
+		return fmt.Errorf("listing subscribers: %v", err)
+	}
+	var rcpts []smtp.Path
+	smtputf8 := c.msgsmtputf8
+	for _, s := range subs {
+		addr, err := smtp.ParseAddress(s.Address)
+		if err != nil {
+			log.Errorx("parsing subscriber address, skipping", err, slog.String("address", s.Address))
+			continue
+		}
+		smtputf8 = smtputf8 || addr.Localpart.IsInternational()
+		rcpts = append(rcpts, addr.Path())
+	}
+	if len(rcpts) == 0 {
+		log.Info("message for list without subscribers, not sending")
+		metricDelivery.WithLabelValues("list", "nosubscribers").Inc()
+		return nil
+	}
+
+	// Compose the message for subscribers: our Received header, the list headers and
+	// the modified original header, followed by the original body.
+	hdr, err := message.ReadHeaders(bufio.NewReader(&moxio.AtReader{R: lm.dataFile}))
+	if err != nil {
+		return smtpError{smtp.C554TransactionFailed, smtp.SeMsg6Other0, "malformed message header", err, false, true}
+	}
+	var author message.NameAddress
+	if lm.envelope != nil && len(lm.envelope.From) > 0 {
+		author.DisplayName = lm.envelope.From[0].Name
+	}
+	author.Address = msgFrom
+	rewriteFrom := !msgFrom.IsZero() && listRewriteFrom(a, l)
+	lhdrs, err := listHeaders(l, hdr, author, rewriteFrom, smtputf8)
+	if err != nil {
+		return smtpError{smtp.C554TransactionFailed, smtp.SeMsg6Other0, "malformed message header", err, false, true}
+	}
+
+	f, err := store.CreateMessageTemp(log, "smtp-list")
+	if err != nil {
+		return fmt.Errorf("creating temp file for list message: %v", err)
+	}
+	defer store.CloseRemoveTempFile(log, f, "smtpserver list message")
+	bodyOffset := int64(len(hdr)) + 2 // Including the empty line.
+	if _, err := f.WriteString(lm.recvHdr + lhdrs + "\r\n"); err != nil {
+		return fmt.Errorf("writing list message: %v", err)
+	} else if _, err := io.Copy(f, io.NewSectionReader(lm.dataFile, bodyOffset, lm.size-bodyOffset)); err != nil {
+		return fmt.Errorf("writing list message body: %v", err)
+	}
+	size := int64(len(lm.recvHdr)+len(lhdrs)+2) + lm.size - bodyOffset
+
+	var dkimHeaders string
+	if confDom, ok := mox.Conf.Domain(l.Domain); ok {
+		if selectors := mox.DKIMSelectors(confDom.DKIM); len(selectors) > 0 {
+			dkimHeaders, err = dkim.Sign(ctx, log.Logger, listAddr.Localpart, l.Domain, selectors, smtputf8, f)
+			if err != nil {
+				return fmt.Errorf("dkim signing list message: %v", err)
+			}
+		}
+	}
+
+	var messageID, subject string
+	if lm.envelope != nil {
+		messageID = lm.envelope.MessageID
+		subject = lm.envelope.Subject
+		if l.SubjectPrefix != "" && !strings.Contains(subject, l.SubjectPrefix) {
+			subject = l.SubjectPrefix + " " + subject
+		}
+	}
+
+	// DSNs for the messages go to the request address, and are delivered to the
+	// owner. Like forwarded messages, they don't cause webhooks or suppression list
+	// entries for the account of the owner.
+	has8bit := lm.has8bit || hasNonASCII(lhdrs)
+	now := time.Now()
+	qml := make([]queue.Msg, len(rcpts))
+	for i, rcpt := range rcpts {
+		qm := queue.MakeMsg(requestAddr.Path(), rcpt, has8bit, smtputf8, int64(len(dkimHeaders))+size, messageID, []byte(dkimHeaders), nil, now, subject)
+		qm.IsForward = true
+		if holdReason != "" {
+			qm.Hold = true
+			qm.HoldReason = "list moderation: " + holdReason
+		}
+		qml[i] = qm
+	}
+	if err := queue.Add(ctx, log, a.d.acc.Name, f, qml...); err != nil {
+		return fmt.Errorf("queueing list messages: %v", err)
+	}
+
+	if holdReason == "" {
+		log.Info("list message queued for subscribers", slog.Int("subscribers", len(qml)), slog.Any("msgfrom", msgFrom), slog.Bool("rewrotefrom", rewriteFrom))
+		metricDelivery.WithLabelValues("list", "sent").Inc()
+		return nil
+	}
+
+	p := store.ListPending{List: list, Kind: store.ListPendingPost, Address: msgFrom.Pack(true)}
+	for _, qm := range qml {
+		p.QueueMsgIDs = append(p.QueueMsgIDs, qm.ID)
+	}
+	if err := store.ListPendingAdd(ctx, &p); err != nil {
+		return fmt.Errorf("storing held list message: %v", err)
+	}
+	log.Info("list message held for moderation", slog.String("reason", holdReason), slog.Any("msgfrom", msgFrom))
+	metricDelivery.WithLabelValues("list", "held").Inc()
+
+	text := fmt.Sprintf(`A message for mailing list %s is held for moderation: %s.
+
+From: %s
+Subject: %s
+
+To send the message to the %d subscriber(s), send a message to %s with subject:
+
+	approve %s
+
+To reject the message, send a message with subject:
+
+	reject %s
+
+Held messages that are not moderated within %d days remain in the queue
+until removed by the admin.
+`, listAddr, holdReason, msgFrom, subject, len(qml), requestAddr, p.Token, p.Token, store.ListPendingMaxAge/(24*time.Hour))
+	if err := listNotifyOwner(ctx, log, a.d.acc, l, "Held for moderation: "+subject, text); err != nil {
+		log.Errorx("notifying list owner about held message", err)
+	}
+	return nil
+}
+
+// listNotifyOwner delivers a notification to the Inbox of the account of the list
+// owner.
+func listNotifyOwner(ctx context.Context, log mlog.Log, acc *store.Account, l config.List, subject, text string) (rerr error) {
+	_, requestAddr := l.Addresses()
+	smtputf8 := requestAddr.Localpart.IsInternational()
+
+	var b bytes.Buffer
+	xc := message.NewComposer(&b, 1024*1024, smtputf8)
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(error); ok && errors.Is(err, message.ErrCompose) {
+			rerr = err
+			return
+		}
+		panic(x)
+	}()
+	xc.HeaderAddrs("From", []message.NameAddress{{Address: requestAddr}})
+	xc.Subject(subject)
+	xc.Header("Message-Id", fmt.Sprintf("<%s>", mox.MessageIDGen(xc.SMTPUTF8)))
+	xc.Header("Date", time.Now().Format(message.RFC5322Z))
+	xc.Header("Auto-Submitted", "auto-generated")
+	xc.Header("MIME-Version", "1.0")
+	textBody, ct, cte := xc.TextPart("plain", text)
+	xc.Header("Content-Type", ct)
+	xc.Header("Content-Transfer-Encoding", cte)
+	xc.Line()
+	xc.Write(textBody)
+	xc.Flush()
+
+	f, err := store.CreateMessageTemp(log, "smtp-listnotify")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer store.CloseRemoveTempFile(log, f, "smtpserver list notification")
+	if _, err := f.Write(b.Bytes()); err != nil {
+		return fmt.Errorf("writing notification: %w", err)
+	}
+	m := store.Message{Received: time.Now(), Size: int64(b.Len())}
+	acc.WithWLock(func() {
+		rerr = acc.DeliverMailbox(log, "Inbox", &m, f)
+	})
+	return rerr
+}
+
+// listCommand returns the lower-cased command and its optional argument from s,
+// skipping reply prefixes like "Re:", so replying to a confirmation works.
+func listCommand(s string) (cmd, arg string) {
+	t := strings.Fields(s)
+	for len(t) > 0 && strings.HasSuffix(t[0], ":") {
+		t = t[1:]
+	}
+	if len(t) == 0 {
+		return "", ""
+	}
+	cmd = strings.ToLower(t[0])
+	if len(t) > 1 {
+		arg = t[1]
+	}
+	return
+}
+
+var listCommands = map[string]bool{"help": true, "subscribe": true, "unsubscribe": true, "confirm": true, "approve": true, "reject": true}
+
+// listBodyCommand returns the first non-empty line of the first text/plain part
+// of the message, for commands not in the subject.
+func listBodyCommand(log mlog.Log, p *message.Part) string {
+	if p == nil {
+		return ""
+	}
+	if p.MediaType == "MULTIPART" {
+		if err := p.Walk(log.Logger, nil); err != nil {
+			log.Debugx("parsing message for list command", err)
+			return ""
+		}
+		for i := range p.Parts {
+			if p.Parts[i].MediaType == "TEXT" && p.Parts[i].MediaSubType == "PLAIN" {
+				p = &p.Parts[i]
+				break
+			}
+		}
+	}
+	if p.MediaType != "TEXT" && p.MediaType != "" || p.MediaSubType != "PLAIN" && p.MediaSubType != "" {
+		return ""
+	}
+	scanner := bufio.NewScanner(io.LimitReader(p.ReaderUTF8OrBinary(), 16*1024))
+	for scanner.Scan() {
+		if s := strings.TrimSpace(scanner.Text()); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// listRequest executes a command sent to the request address of a list. Replies
+// are sent to the address in the message From header.
+func (c *conn) listRequest(ctx context.Context, log mlog.Log, l config.List, lm listMessage) error {
+	listAddr, requestAddr := l.Addresses()
+	list := listAddr.Pack(true)
+	a := lm.a
+	msgFrom := a.d.msgFrom
+	if msgFrom.IsZero() {
+		log.Info("ignoring list request without message from address")
+		return nil
+	}
+	if s := strings.TrimSpace(lm.headers.Get("Auto-Submitted")); s != "" && !strings.EqualFold(s, "no") {
+		log.Info("ignoring automatically submitted list request", slog.String("autosubmitted", s))
+		return nil
+	}
+
+	var subject string
+	if lm.envelope != nil {
+		subject = lm.envelope.Subject
+	}
+	cmd, arg := listCommand(subject)
+	if !listCommands[cmd] {
+		if bcmd, barg := listCommand(listBodyCommand(log, lm.part)); listCommands[bcmd] {
+			cmd, arg = bcmd, barg
+		}
+	}
+	log = log.With(slog.String("command", cmd), slog.Any("msgfrom", msgFrom))
+	metricDelivery.WithLabelValues("listrequest", cmd).Inc()
+
+	from := msgFrom.Pack(true)
+	replyTo := msgFrom
+	var replySubject, text string
+	switch cmd {
+	case "subscribe", "unsubscribe":
+		subscribed, err := store.ListSubscribed(ctx, list, from)
+		if err != nil {
+			return fmt.Errorf("checking subscription: %v", err)
+		}
+		if cmd == "subscribe" && subscribed {
+			replySubject = "Already subscribed to " + listAddr.String()
+			text = fmt.Sprintf("Address %s is already subscribed to mailing list %s.\n", msgFrom, listAddr)
+			break
+		} else if cmd == "unsubscribe" && !subscribed {
+			replySubject = "Not subscribed to " + listAddr.String()
+			text = fmt.Sprintf("Address %s is not subscribed to mailing list %s.\n", msgFrom, listAddr)
+			break
+		}
+		// With a verified message From address, unsubscribing is done immediately.
+		// Subscribing always needs confirmation, proving the address can receive the
+		// list messages.
+		if cmd == "unsubscribe" && a.d.m.MsgFromValidated {
+			if _, err := store.ListUnsubscribe(ctx, list, from); err != nil {
+				return fmt.Errorf("unsubscribing: %v", err)
+			}
+			log.Info("unsubscribed from list")
+			replySubject = "Unsubscribed from " + listAddr.String()
+			text = fmt.Sprintf("Address %s has been unsubscribed from mailing list %s.\n", msgFrom, listAddr)
+			break
+		}
+		p := store.ListPending{List: list, Kind: store.ListPendingKind(cmd), Address: from}
+		if err := store.ListPendingAdd(ctx, &p); err != nil {
+			return fmt.Errorf("storing pending request: %v", err)
+		}
+		replySubject = "confirm " + p.Token
+		text = fmt.Sprintf(`A request was made to %s address %s for mailing list %s.
+
+To confirm, reply to this message, keeping the subject, or send a message to
+%s with subject:
+
+	confirm %s
+
+If you did not make this request, you can ignore this message.
+`, cmd, msgFrom, listAddr, requestAddr, p.Token)
+
+	case "confirm":
+		p, err := store.ListPendingTake(ctx, list, arg, store.ListPendingSubscribe, store.ListPendingUnsubscribe)
+		if errors.Is(err, store.ErrListPendingUnknown) {
+			replySubject = "Unknown confirmation for " + listAddr.String()
+			text = "The token is unknown or has expired.\n"
+			break
+		} else if err != nil {
+			return fmt.Errorf("looking up pending request: %v", err)
+		}
+		addr, err := smtp.ParseAddress(p.Address)
+		if err != nil {
+			return fmt.Errorf("parsing address of pending request: %v", err)
+		}
+		replyTo = addr
+		if p.Kind == store.ListPendingSubscribe {
+			if _, err := store.ListSubscribe(ctx, list, p.Address); err != nil {
+				return fmt.Errorf("subscribing: %v", err)
+			}
+			log.Info("subscribed to list", slog.String("address", p.Address))
+			replySubject = "Subscribed to " + listAddr.String()
+			text = fmt.Sprintf("Address %s is now subscribed to mailing list %s.\n\nTo unsubscribe, send a message with subject \"unsubscribe\" to %s.\n", p.Address, listAddr, requestAddr)
+		} else {
+			if _, err := store.ListUnsubscribe(ctx, list, p.Address); err != nil {
+				return fmt.Errorf("unsubscribing: %v", err)
+			}
+			log.Info("unsubscribed from list", slog.String("address", p.Address))
+			replySubject = "Unsubscribed from " + listAddr.String()
+			text = fmt.Sprintf("Address %s has been unsubscribed from mailing list %s.\n", p.Address, listAddr)
+		}
+
+	case "approve", "reject":
+		// The token is only sent to the list owner.
+		p, err := store.ListPendingTake(ctx, list, arg, store.ListPendingPost)
+		if errors.Is(err, store.ErrListPendingUnknown) {
+			replySubject = "Unknown moderation token for " + listAddr.String()
+			text = "The token is unknown or has expired. Held messages can still be managed in the queue by the admin.\n"
+			break
+		} else if err != nil {
+			return fmt.Errorf("looking up held message: %v", err)
+		}
+		var n int
+		if cmd == "approve" {
+			n, err = queue.HoldSet(ctx, queue.Filter{IDs: p.QueueMsgIDs}, false)
+		} else {
+			n, err = queue.Drop(ctx, log, queue.Filter{IDs: p.QueueMsgIDs})
+		}
+		if err != nil {
+			return fmt.Errorf("moderating held messages: %v", err)
+		}
+		log.Info("list message moderated", slog.Int("messages", n), slog.String("from", p.Address))
+		if cmd == "approve" {
+			replySubject = "Approved message for " + listAddr.String()
+			text = fmt.Sprintf("The message from %s is sent to %d subscriber(s).\n", p.Address, n)
+		} else {
+			replySubject = "Rejected message for " + listAddr.String()
+			text = fmt.Sprintf("The message from %s has been removed from the queue.\n", p.Address)
+		}
+
+	default:
+		replySubject = "Help for " + listAddr.String()
+		if cmd != "help" {
+			text = fmt.Sprintf("Unknown command %q.\n\n", cmd)
+		}
+		desc := l.Description
+		if desc != "" {
+			desc = " (" + desc + ")"
+		}
+		text += fmt.Sprintf(`Commands for mailing list %s%s are sent to %s, with
+the command as subject:
+
+subscribe
+	Subscribe the address in the From header of your message. A confirmation
+	message is sent to the address.
+unsubscribe
+	Unsubscribe the address in the From header of your message.
+confirm <token>
+	Confirm a subscription change, with the token from a confirmation message.
+help
+	Send this message.
+
+Messages for the list are sent to %s.
+`, listAddr, desc, requestAddr, listAddr)
+	}
+
+	if err := listReply(ctx, log, a.d.acc.Name, l, replyTo.Path(), lm.envelope, replySubject, text); err != nil {
+		if errors.Is(err, queue.ErrDSNSuppressed) {
+			log.Infox("not sending reply to list request", err)
+			return nil
+		}
+		return fmt.Errorf("sending reply to list request: %v", err)
+	}
+	return nil
+}
+
+// listReply queues a reply from the request address of the list, with a null
+// reverse path, so the rate limits for outgoing DSNs apply and failures won't
+// cause DSNs.
+func listReply(ctx context.Context, log mlog.Log, accountName string, l config.List, to smtp.Path, envelope *message.Envelope, subject, text string) (rerr error) {
+	_, requestAddr := l.Addresses()
+	smtputf8 := requestAddr.Localpart.IsInternational() || to.Localpart.IsInternational()
+
+	var b bytes.Buffer
+	xc := message.NewComposer(&b, 1024*1024, smtputf8)
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(error); ok && errors.Is(err, message.ErrCompose) {
+			rerr = err
+			return
+		}
+		panic(x)
+	}()
+
+	now := time.Now()
+	xc.HeaderAddrs("From", []message.NameAddress{{Address: requestAddr}})
+	xc.HeaderAddrs("To", []message.NameAddress{{Address: smtp.Address{Localpart: to.Localpart, Domain: to.IPDomain.Domain}}})
+	xc.Subject(subject)
+	messageID := fmt.Sprintf("<%s>", mox.MessageIDGen(xc.SMTPUTF8))
+	xc.Header("Message-Id", messageID)
+	xc.Header("Date", now.Format(message.RFC5322Z))
+	if envelope != nil && envelope.MessageID != "" {
+		xc.Header("In-Reply-To", envelope.MessageID)
+		xc.Header("References", envelope.MessageID)
+	}
+	xc.Header("Auto-Submitted", "auto-replied")
+	xc.Header("List-Id", listID(l))
+	xc.Header("User-Agent", "mox/"+moxvar.Version)
+	xc.Header("MIME-Version", "1.0")
+	textBody, ct, cte := xc.TextPart("plain", text)
+	xc.Header("Content-Type", ct)
+	xc.Header("Content-Transfer-Encoding", cte)
+	xc.Line()
+	xc.Write(textBody)
+	xc.Flush()
+	has8bit := xc.Has8bit || cte == "8bit"
+
+	dkimHeader, err := mox.DKIMSign(ctx, log, requestAddr.Path(), smtputf8, b.Bytes())
+	log.Check(err, "dkim signing list reply")
+
+	f, err := store.CreateMessageTemp(log, "smtp-listreply")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer store.CloseRemoveTempFile(log, f, "smtpserver list reply")
+	if _, err := f.Write(b.Bytes()); err != nil {
+		return fmt.Errorf("writing list reply: %w", err)
+	}
+
+	size := int64(len(dkimHeader) + b.Len())
+	qm := queue.MakeMsg(smtp.Path{}, to, has8bit, smtputf8, size, messageID, []byte(dkimHeader), nil, now, subject)
+	return queue.Add(ctx, log, accountName, f, qm)
+}
@@ -0,0 +1,104 @@
+package smtpserver
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+)
+
+var metricLoopDetected = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_smtpserver_loop_detected_total",
+		Help: "Incoming messages detected as looping, by reason: hops (too many Received headers, rejected), ownhost (passed through this host too often, rejected), forward (already forwarded for the recipient address, delivered locally instead of forwarded again).",
+	},
+	[]string{
+		"reason",
+	},
+)
+
+// loopDetect checks the Received headers of an incoming message for signs of a
+// mail loop. If the message is looping, a reason for metrics and an error
+// message are returned. ../rfc/5321:4065 ../rfc/5321:1526
+func loopDetect(headers textproto.MIMEHeader) (reason, errmsg string) {
+	maxHops, maxOwnHops := 100, 2
+	if l := mox.Conf.Static.LoopDetection; l != nil {
+		if l.MaxHops > 0 {
+			maxHops = l.MaxHops
+		}
+		if l.MaxOwnHops > 0 {
+			maxOwnHops = l.MaxOwnHops
+		}
+	}
+
+	received := headers.Values("Received")
+	if len(received) > maxHops {
+		return "hops", fmt.Sprintf("loop detected, more than %d Received headers", maxHops)
+	}
+
+	var own int
+	for _, s := range received {
+		if receivedOwnDelivery(s) {
+			own++
+		}
+	}
+	if own >= maxOwnHops {
+		return "ownhost", fmt.Sprintf("loop detected, message was delivered to this host %d times before", own)
+	}
+	return "", ""
+}
+
+// receivedOwnDelivery returns whether the value of a Received header was added by
+// this host during an earlier incoming delivery. Our Received headers have an ID
+// that only we can decode. Received headers added during submission are
+// recognized by the "A" at the end of the "with" clause (e.g. ESMTPSA), or by a
+// missing "with" clause, as added for webmail and webapi submissions.
+func receivedOwnDelivery(s string) bool {
+	// Remove comments, they can contain words like "with".
+	var b strings.Builder
+	var depth int
+	for _, c := range s {
+		if c == '(' {
+			depth++
+		} else if c == ')' && depth > 0 {
+			depth--
+		} else if depth == 0 {
+			b.WriteRune(c)
+		}
+	}
+
+	var id, with string
+	t := strings.Fields(b.String())
+	for i := 0; i+1 < len(t); i++ {
+		switch strings.ToLower(t[i]) {
+		case "id":
+			id = strings.TrimSuffix(t[i+1], ";")
+		case "with":
+			with = strings.TrimSuffix(t[i+1], ";")
+		}
+	}
+	if id == "" || with == "" || strings.HasSuffix(strings.ToUpper(with), "A") {
+		return false
+	}
+	_, err := mox.ReceivedToCid(id)
+	return err == nil
+}
+
+// forwardLoop returns whether a message was already forwarded for address
+// deliverTo, as indicated by the Delivered-To header we add to forwarded messages.
+// Forwarding it again would loop, e.g. through a mail server that forwards the
+// message back to us.
+func forwardLoop(headers textproto.MIMEHeader, deliverTo smtp.Path) bool {
+	for _, v := range headers.Values("Delivered-To") {
+		addr, err := smtp.ParseAddress(strings.TrimSpace(v))
+		if err == nil && deliverTo.Equal(addr.Path()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,297 @@
+package smtpserver
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/milter"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// connMilter is a milter used for a connection.
+type connMilter struct {
+	config *config.Milter
+	client *milter.Client // Nil if not connected, e.g. after a failure with FailOpen.
+
+	failed      bool // Milter without FailOpen failed, commands fail temporarily for the remainder of the connection.
+	acceptConn  bool // Milter accepted the connection, it isn't called anymore.
+	discardConn bool // Milter wants all messages of the connection discarded.
+	acceptMsg   bool // Milter accepted the transaction, it isn't called anymore until the next.
+	transaction bool // Transaction started at the milter and not finished, for sending an abort on reset.
+}
+
+// milterConnect connects to the configured milters and sends the connection
+// details. A reject or tempfail decision is returned, for refusing the
+// connection.
+func (c *conn) milterConnect(configs []config.Milter) milter.Response {
+	for i := range configs {
+		m := &connMilter{config: &configs[i]}
+		c.milters = append(c.milters, m)
+		client, err := milter.Dial(c.log, m.config.Network, m.config.NetworkAddr, m.config.Timeout)
+		if err != nil {
+			c.milterFailed(m, "connect", err)
+			continue
+		}
+		m.client = client
+	}
+
+	var port int
+	if a, ok := c.conn.RemoteAddr().(*net.TCPAddr); ok {
+		port = a.Port
+	}
+	macros := map[string]string{
+		"j":             mox.Conf.Static.HostnameDomain.ASCII,
+		"{daemon_name}": "mox",
+		"{client_addr}": c.remoteIP.String(),
+		"{if_addr}":     c.localIP.String(),
+	}
+	return c.milterCall("connect", func(m *connMilter) (milter.Response, error) {
+		return m.client.Connect(smtp.AddressLiteral(c.remoteIP), c.remoteIP, port, macros)
+	})
+}
+
+// milterCall calls fn for each milter that should see the current stage, and
+// returns the first reject or tempfail decision. Accept and discard decisions
+// are registered for the connection or transaction. Failing milters are dropped
+// if configured to fail open, otherwise a temporary failure is returned.
+func (c *conn) milterCall(stage string, fn func(m *connMilter) (milter.Response, error)) milter.Response {
+	for _, m := range c.milters {
+		if m.failed {
+			return milter.Response{Action: milter.ActionTempfail, Code: smtp.C451LocalErr, EnhancedCode: "4." + smtp.SeSys3Other0, Message: "error evaluating with filter, try again later"}
+		}
+		if m.client == nil || m.acceptConn || m.acceptMsg {
+			continue
+		}
+		resp, err := fn(m)
+		if err != nil {
+			c.milterFailed(m, stage, err)
+			if m.failed {
+				return milter.Response{Action: milter.ActionTempfail, Code: smtp.C451LocalErr, EnhancedCode: "4." + smtp.SeSys3Other0, Message: "error evaluating with filter, try again later"}
+			}
+			continue
+		}
+		c.log.Debug("milter decision", slog.String("milter", m.config.Address), slog.String("stage", stage), slog.String("action", string(resp.Action)))
+		switch resp.Action {
+		case milter.ActionAccept:
+			// At stage rcpt, accept is only for the recipient.
+			if stage == "connect" || stage == "helo" {
+				m.acceptConn = true
+			} else if stage != "rcpt" {
+				m.acceptMsg = true
+			}
+		case milter.ActionDiscard:
+			if stage == "connect" || stage == "helo" {
+				m.discardConn = true
+			} else {
+				c.milterDiscard = true
+			}
+		case milter.ActionReject, milter.ActionTempfail:
+			return resp
+		}
+	}
+	return milter.Response{Action: milter.ActionContinue}
+}
+
+// milterFailed closes the connection to a milter after an error. Without FailOpen,
+// the milter is marked as failed.
+func (c *conn) milterFailed(m *connMilter, stage string, err error) {
+	if m.client != nil {
+		xerr := m.client.Close()
+		c.log.Check(xerr, "closing milter connection after error")
+		m.client = nil
+	}
+	if m.config.FailOpen {
+		c.log.Errorx("milter failed, continuing without it due to fail open", err, slog.String("milter", m.config.Address), slog.String("stage", stage))
+	} else {
+		c.log.Errorx("milter failed", err, slog.String("milter", m.config.Address), slog.String("stage", stage))
+		m.failed = true
+	}
+}
+
+// milterClose closes the connections to the milters.
+func (c *conn) milterClose() {
+	for _, m := range c.milters {
+		if m.client != nil {
+			err := m.client.Close()
+			c.log.Check(err, "closing milter connection")
+			m.client = nil
+		}
+	}
+}
+
+// milterAbort tells milters the current transaction was aborted.
+func (c *conn) milterAbort() {
+	for _, m := range c.milters {
+		if m.client != nil && m.transaction {
+			if err := m.client.Abort(); err != nil {
+				c.milterFailed(m, "abort", err)
+			}
+		}
+		m.transaction = false
+		m.acceptMsg = false
+	}
+}
+
+// xmilterRefuse aborts the current command with the SMTP error from a reject or
+// tempfail decision of a milter. Other decisions are ignored.
+func (c *conn) xmilterRefuse(stage string, resp milter.Response) {
+	if resp.Action != milter.ActionReject && resp.Action != milter.ActionTempfail {
+		return
+	}
+	code, secode, msg := resp.Code, resp.Secode(), resp.Message
+	if resp.Action == milter.ActionReject {
+		if code == 0 {
+			code = smtp.C550MailboxUnavail
+		}
+		if secode == "" {
+			secode = smtp.SePol7DeliveryUnauth1
+		}
+		if msg == "" {
+			msg = "rejected by filter"
+		}
+	} else {
+		if code == 0 {
+			code = smtp.C451LocalErr
+		}
+		if secode == "" {
+			secode = smtp.SePol7DeliveryUnauth1
+		}
+		if msg == "" {
+			msg = "temporarily rejected by filter, try again later"
+		}
+	}
+	c.log.Info("milter refused", slog.String("stage", stage), slog.String("action", string(resp.Action)), slog.Int("code", code))
+	xsmtpErrorf(code, secode, true, "%s", msg)
+}
+
+// xmilterHelo sends the EHLO/HELO name to the milters.
+func (c *conn) xmilterHelo(name dns.IPDomain) {
+	if len(c.milters) == 0 {
+		return
+	}
+	macros := map[string]string{}
+	if c.tls {
+		cs := c.conn.(*tls.Conn).ConnectionState()
+		macros["{tls_version}"] = tls.VersionName(cs.Version)
+		macros["{cipher}"] = tls.CipherSuiteName(cs.CipherSuite)
+	}
+	resp := c.milterCall("helo", func(m *connMilter) (milter.Response, error) {
+		return m.client.Helo(name.String(), macros)
+	})
+	c.xmilterRefuse("helo", resp)
+}
+
+// xmilterMail sends the MAIL FROM address to the milters, starting a
+// transaction.
+func (c *conn) xmilterMail() {
+	if len(c.milters) == 0 {
+		return
+	}
+
+	// Abort a transaction that wasn't finished, e.g. after a failed DATA.
+	c.milterAbort()
+
+	var params []string
+	if c.has8bitmime {
+		params = append(params, "BODY=8BITMIME")
+	}
+	if c.smtputf8 {
+		params = append(params, "SMTPUTF8")
+	}
+	from := c.mailFrom.String()
+	macros := map[string]string{
+		"i":           mox.ReceivedID(c.cid),
+		"{mail_addr}": from,
+	}
+	resp := c.milterCall("mail", func(m *connMilter) (milter.Response, error) {
+		m.transaction = true
+		return m.client.Mail(from, params, macros)
+	})
+	if resp.Action == milter.ActionReject || resp.Action == milter.ActionTempfail {
+		c.mailFrom = nil
+		c.milterAbort()
+		c.xmilterRefuse("mail", resp)
+	}
+}
+
+// milterRcpt sends a recipient to the milters, returning a reject or tempfail
+// decision for the recipient.
+func (c *conn) milterRcpt(rcpt smtp.Path) milter.Response {
+	to := rcpt.String()
+	macros := map[string]string{"{rcpt_addr}": to}
+	return c.milterCall("rcpt", func(m *connMilter) (milter.Response, error) {
+		return m.client.Rcpt(to, nil, macros)
+	})
+}
+
+// xmilterMessage sends the message to the milters. If milters modified the
+// message, a new message writer and file are returned, and the caller must
+// remove the file. Otherwise the returned file is nil.
+func (c *conn) xmilterMessage(msgWriter *message.Writer, dataFile *os.File) (*message.Writer, *os.File) {
+	var newFile *os.File
+	removeNew := func() {
+		if newFile != nil {
+			store.CloseRemoveTempFile(c.log, newFile, "smtpserver message modified by milter")
+			newFile = nil
+		}
+	}
+
+	macros := map[string]string{"i": mox.ReceivedID(c.cid)}
+	resp := c.milterCall("message", func(m *connMilter) (milter.Response, error) {
+		r, err := m.client.Message(&moxio.AtReader{R: dataFile}, macros)
+		m.transaction = false
+		if err != nil {
+			return r.Response, err
+		}
+		if r.Quarantine {
+			c.log.Info("milter quarantined message", slog.String("milter", m.config.Address), slog.String("reason", r.QuarantineReason))
+			c.milterQuarantine = true
+		}
+		if len(r.Changes) == 0 || r.Action != milter.ActionContinue && r.Action != milter.ActionAccept {
+			return r.Response, nil
+		}
+
+		f, err := store.CreateMessageTemp(c.log, "smtp-milter")
+		if err != nil {
+			removeNew()
+			xsmtpServerErrorf(errCodes(smtp.C451LocalErr, smtp.SeSys3Other0, err), "creating temporary file for modified message: %s", err)
+		}
+		mw := message.NewWriter(f)
+		if err := milter.Apply(mw, &moxio.AtReader{R: dataFile}, r.Changes); err != nil {
+			store.CloseRemoveTempFile(c.log, f, "smtpserver message modified by milter")
+			removeNew()
+			xsmtpServerErrorf(errCodes(smtp.C451LocalErr, smtp.SeSys3Other0, err), "applying message changes from milter: %s", err)
+		}
+		c.log.Debug("message modified by milter", slog.String("milter", m.config.Address), slog.Int("changes", len(r.Changes)))
+		removeNew()
+		newFile, dataFile, msgWriter = f, f, mw
+		return r.Response, nil
+	})
+	if resp.Action == milter.ActionReject || resp.Action == milter.ActionTempfail {
+		removeNew()
+		c.xmilterRefuse("message", resp)
+	}
+	return msgWriter, newFile
+}
+
+// milterDiscarding returns whether the current message should be discarded
+// due to a milter decision.
+func (c *conn) milterDiscarding() bool {
+	if c.milterDiscard {
+		return true
+	}
+	for _, m := range c.milters {
+		if m.discardConn {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package smtpserver
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
+	"github.com/mjl-/mox/store"
+)
+
+func TestMilter(t *testing.T) {
+	var mutex sync.Mutex
+	var commands []byte
+	var respond func(cmd byte, data []byte) []string
+
+	setRespond := func(fn func(cmd byte, data []byte) []string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		respond = fn
+		commands = nil
+	}
+	// Responds with continue to all commands, and the given responses at a command.
+	respondAt := func(at byte, responses ...string) func(cmd byte, data []byte) []string {
+		return func(cmd byte, data []byte) []string {
+			if cmd == at {
+				return responses
+			}
+			return []string{"c"}
+		}
+	}
+
+	// Fake milter, handling each connection with respond.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	tcheck(t, err, "listen")
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					var lenbuf [4]byte
+					if _, err := io.ReadFull(conn, lenbuf[:]); err != nil {
+						return
+					}
+					buf := make([]byte, binary.BigEndian.Uint32(lenbuf[:]))
+					if _, err := io.ReadFull(conn, buf); err != nil {
+						return
+					}
+					mutex.Lock()
+					if buf[0] != 'O' && buf[0] != 'Q' {
+						commands = append(commands, buf[0])
+					}
+					fn := respond
+					mutex.Unlock()
+
+					var responses []string
+					switch buf[0] {
+					case 'O':
+						// Version 6, add/change headers, replace body and quarantine, all protocol steps.
+						responses = []string{"O\x00\x00\x00\x06\x00\x00\x00\x33\x00\x00\x00\x00"}
+					case 'D', 'A', 'Q':
+						// No responses for macros, abort and quit.
+					default:
+						responses = fn(buf[0], buf[1:])
+					}
+					for _, resp := range responses {
+						out := binary.BigEndian.AppendUint32(nil, uint32(len(resp)))
+						if _, err := conn.Write(append(out, resp...)); err != nil {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}()
+
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+		TXT: map[string][]string{
+			"example.org.": {"v=spf1 ip4:127.0.0.10 -all"},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	ts.milters = []config.Milter{{Address: "inet:" + ln.Addr().String(), Network: "tcp", NetworkAddr: ln.Addr().String(), Timeout: 5 * time.Second}}
+	defer ts.close()
+
+	testDeliver := func(expErr *smtpclient.Error) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+			err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+			ts.smtpErr(err, expErr)
+		})
+	}
+
+	// Connection refused before the greeting.
+	setRespond(respondAt('C', "r"))
+	ts.runx(func(helloErr error, client *smtpclient.Client) {
+		ts.smtpErr(helloErr, &smtpclient.Error{Permanent: true, Code: smtp.C554TransactionFailed})
+	})
+
+	// Recipient rejected with custom code.
+	setRespond(respondAt('R', "y550 5.1.1 go away\x00"))
+	testDeliver(&smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SeAddr1UnknownDestMailbox1})
+
+	// Temporary failure after the message.
+	setRespond(respondAt('E', "t"))
+	testDeliver(&smtpclient.Error{Code: smtp.C451LocalErr, Secode: smtp.SePol7DeliveryUnauth1})
+
+	// Discarded message is accepted, but not delivered.
+	setRespond(respondAt('E', "d"))
+	testDeliver(nil)
+	n, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).Count()
+	tcheck(t, err, "count messages")
+	tcompare(t, n, 0)
+
+	// Message with modified headers, quarantined.
+	setRespond(respondAt('E', "hX-Milter\x00scanned\x00", "m\x00\x00\x00\x01Subject\x00[milter] test\x00", "qsuspicious\x00", "c"))
+	testDeliver(nil)
+	ts.checkCount("Junk", 1)
+
+	// Helo is sent again with macros for TLS after STARTTLS.
+	mutex.Lock()
+	tcompare(t, string(commands), "DCHDHDMDRTLLLLNBDE")
+	mutex.Unlock()
+
+	m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).FilterEqual("Expunged", false).Get()
+	tcheck(t, err, "get delivered message")
+	mr := ts.acc.MessageReader(m)
+	buf, err := io.ReadAll(mr)
+	tcheck(t, err, "read message")
+	err = mr.Close()
+	tcheck(t, err, "close message")
+	if !strings.Contains(string(buf), "\r\nSubject: [milter] test\r\nMessage-Id: <test@example.org>\r\nX-Milter: scanned\r\n\r\ntest email\r\n") {
+		t.Fatalf("missing milter modifications in message:\n%s", buf)
+	}
+	tcompare(t, m.Size, int64(len(buf)))
+
+	// Unreachable milter results in temporary error, and is ignored with FailOpen.
+	ln.Close()
+	ts.runx(func(helloErr error, client *smtpclient.Client) {
+		ts.smtpErr(helloErr, &smtpclient.Error{Code: smtp.C421ServiceUnavail})
+	})
+	ts.milters[0].FailOpen = true
+	testDeliver(nil)
+	ts.checkCount("Inbox", 1)
+}
@@ -0,0 +1,66 @@
+package smtpserver
+
+import (
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/notify"
+)
+
+// sendNotifications sends notifications about a delivered message for the
+// notifications configured for the account that match the message, in the
+// background. Junk messages and rejects don't cause notifications.
+func sendNotifications(log mlog.Log, a analysis, part message.Part, now time.Time) {
+	if a.d.m.Junk || a.d.m.IsReject {
+		return
+	}
+	accConf, ok := a.d.acc.Conf()
+	if !ok || len(accConf.Notifications) == 0 {
+		return
+	}
+
+	m := notify.Message{
+		Account:  a.d.acc.Name,
+		Mailbox:  a.mailbox,
+		Received: now,
+	}
+	if part.Envelope != nil {
+		env := part.Envelope
+		m.Subject = env.Subject
+		m.MessageID = env.MessageID
+		if len(env.From) > 0 {
+			m.FromName = env.From[0].Name
+			if env.From[0].User != "" || env.From[0].Host != "" {
+				m.From = strings.ToLower(env.From[0].User + "@" + env.From[0].Host)
+			}
+		}
+	}
+
+	for _, n := range accConf.Notifications {
+		if !notify.Matches(n, m) {
+			continue
+		}
+		go func() {
+			defer func() {
+				x := recover()
+				if x != nil {
+					log.Error("unhandled panic sending notification", slog.Any("err", x))
+					debug.PrintStack()
+					metrics.PanicInc(metrics.Smtpserver)
+				}
+			}()
+
+			err := notify.Send(mox.Shutdown, log, n, m)
+			if err != nil {
+				log.Errorx("sending notification for delivered message", err, slog.String("type", n.Type), slog.String("url", n.URL))
+				metricServerErrors.WithLabelValues("notify").Inc()
+			}
+		}()
+	}
+}
@@ -537,3 +537,51 @@ func (p *parser) xtext() string {
 	}
 	return r
 }
+
+// xorcpt parses the value of the ORCPT parameter of the DSN extension, returning
+// the address. Only address types "rfc822" and "utf-8" are supported.
+// ../rfc/3461 ../rfc/6533:259
+func (p *parser) xorcpt() string {
+	addrType := p.xtakefn1("address type", func(c rune, i int) bool {
+		return c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c == '-'
+	})
+	p.xtake(";")
+	var s string
+	switch strings.ToLower(addrType) {
+	case "rfc822":
+		s = p.xtext()
+	case "utf-8":
+		// Both utf-8-addr-xtext and utf-8-addr-unitext, with \x{HEX} escapes.
+		// ../rfc/6533:259
+		raw := p.xtakefn1("utf-8 address", func(c rune, i int) bool {
+			return c > ' ' && c != '=' && c != 0x7f && (c < 0x80 || p.smtputf8)
+		})
+		var b strings.Builder
+		for raw != "" {
+			t, rem, ok := strings.Cut(raw, `\x{`)
+			b.WriteString(t)
+			if !ok {
+				break
+			}
+			hexstr, rest, ok := strings.Cut(rem, "}")
+			v, err := strconv.ParseUint(hexstr, 16, 32)
+			if !ok || err != nil || len(hexstr) < 2 || len(hexstr) > 6 {
+				p.xerrorf("invalid escape in utf-8 address")
+			}
+			b.WriteRune(rune(v))
+			raw = rest
+		}
+		s = b.String()
+	default:
+		// ../rfc/5321:2230
+		xsmtpUserErrorf(smtp.C555UnrecognizedAddrParams, smtp.SeSys3NotSupported3, "unsupported address type %q for ORCPT", addrType)
+	}
+	if len(s) > 500 {
+		p.xerrorf("ORCPT value too long")
+	}
+	addr, err := smtp.ParseAddress(s)
+	if err != nil {
+		p.xerrorf("parsing ORCPT address: %v", err)
+	}
+	return addr.Pack(false)
+}
@@ -0,0 +1,84 @@
+package smtpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/openpgp"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// Maximum size of a PGP signature we read.
+const pgpMaxSignatureSize = 1024 * 1024
+
+// pgpVerify verifies the signature of a PGP/MIME signed message against the keys
+// known to the account of the recipient, and sets the signature result and signer
+// on m. The message is read from msgr, which must be the reader used for parsing
+// p. ../rfc/3156
+func pgpVerify(ctx context.Context, log mlog.Log, acc *store.Account, p *message.Part, msgr io.ReaderAt, from smtp.Address, m *store.Message) {
+	keys, err := acc.OpenPGPVerifyKeys(ctx, log, from)
+	if err != nil {
+		log.Errorx("looking up openpgp keys for verifying signature", err)
+		return
+	}
+
+	k, err := pgpVerifySignature(log, p, msgr, keys)
+	switch {
+	case err == nil && k.HasAddress(from):
+		m.SignatureResult = "pass"
+		m.SignatureSigner = from.String()
+	case err == nil:
+		m.SignatureResult = "policy"
+		if l := k.Addresses(); len(l) > 0 {
+			m.SignatureSigner = l[0].String()
+		}
+	case errors.Is(err, openpgp.ErrSigner):
+		m.SignatureResult = "neutral"
+	case errors.Is(err, openpgp.ErrSignature):
+		m.SignatureResult = "fail"
+	case errors.Is(err, openpgp.ErrMalformed), errors.Is(err, openpgp.ErrUnsupported):
+		m.SignatureResult = "permerror"
+	default:
+		log.Errorx("verifying pgp signature", err)
+		return
+	}
+	log.Debugx("pgp signature verification", err,
+		slog.String("result", m.SignatureResult),
+		slog.String("signer", m.SignatureSigner),
+		slog.String("fingerprint", k.Fingerprint))
+}
+
+// pgpVerifySignature verifies the detached signature in the second part of a
+// multipart/signed message over the first part, including its headers.
+func pgpVerifySignature(log mlog.Log, p *message.Part, msgr io.ReaderAt, keys []openpgp.Key) (openpgp.Key, error) {
+	// Delivery only parses the top-level part, we need the subparts.
+	if len(p.Parts) == 0 {
+		if err := p.Walk(log.Logger, nil); err != nil {
+			return openpgp.Key{}, fmt.Errorf("%w: parsing message: %v", openpgp.ErrMalformed, err)
+		}
+	}
+	if len(p.Parts) != 2 {
+		return openpgp.Key{}, fmt.Errorf("%w: multipart/signed with %d parts, need 2", openpgp.ErrMalformed, len(p.Parts))
+	}
+	cp := p.Parts[0]
+	if cp.EndOffset < cp.HeaderOffset {
+		return openpgp.Key{}, fmt.Errorf("%w: bad offsets for signed content", openpgp.ErrMalformed)
+	}
+	sig, err := io.ReadAll(io.LimitReader(p.Parts[1].Reader(), pgpMaxSignatureSize+1))
+	if err != nil {
+		return openpgp.Key{}, fmt.Errorf("reading signature: %v", err)
+	} else if len(sig) > pgpMaxSignatureSize {
+		return openpgp.Key{}, fmt.Errorf("%w: signature too large", openpgp.ErrMalformed)
+	}
+	// The signed content is the MIME entity including its headers, without the CRLF
+	// that is part of the boundary.
+	content := io.NewSectionReader(msgr, cp.HeaderOffset, cp.EndOffset-cp.HeaderOffset)
+	return openpgp.Verify(sig, content, keys, time.Now())
+}
@@ -0,0 +1,125 @@
+package smtpserver
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/policyhook"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// policyHookEnabled returns whether a policy hook is configured for stage.
+func (c *conn) policyHookEnabled(stage policyhook.Stage) bool {
+	h := c.policyHook
+	return h != nil && (len(h.Stages) == 0 || slices.Contains(h.Stages, string(stage)))
+}
+
+// policyHookCall calls the policy hook and returns its decision. Headers and
+// quarantine decisions of accepting responses are registered for the connection
+// or transaction, to be applied during delivery. If the
+// hook could not be called, a temporary failure is returned, or an accept if the
+// hook is configured to fail open.
+func (c *conn) policyHookCall(stage policyhook.Stage, rcptTo string, data *policyhook.Data) policyhook.Response {
+	req := policyhook.Request{
+		Stage:        stage,
+		ConnectionID: c.cid,
+		RemoteIP:     c.remoteIP.String(),
+		LocalIP:      c.localIP.String(),
+		TLS:          c.tls,
+		RcptTo:       rcptTo,
+		Data:         data,
+	}
+	if stage != policyhook.StageConnect {
+		req.EHLO = c.hello.String()
+	}
+	if c.mailFrom != nil {
+		req.MailFrom = c.mailFrom.String()
+	}
+	rcpts := c.recipients
+	if stage == policyhook.StageRcpt && len(rcpts) > 0 {
+		// The recipient being evaluated was already added to the transaction.
+		rcpts = rcpts[:len(rcpts)-1]
+	}
+	for _, rcpt := range rcpts {
+		req.Recipients = append(req.Recipients, rcpt.Addr.String())
+	}
+
+	cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
+	resp, err := policyhook.Call(cidctx, c.log, c.policyHook, req)
+	if err != nil && c.policyHook.FailOpen {
+		c.log.Errorx("calling policy hook, continuing due to fail open", err, slog.String("stage", string(stage)))
+		return policyhook.Response{Action: policyhook.ActionAccept}
+	} else if err != nil {
+		c.log.Errorx("calling policy hook", err, slog.String("stage", string(stage)))
+		return policyhook.Response{Action: policyhook.ActionTempfail, Code: smtp.C451LocalErr, EnhancedCode: "4." + smtp.SeSys3Other0, Message: "error evaluating policy, try again later"}
+	}
+
+	c.log.Debug("policy hook decision",
+		slog.String("stage", string(stage)),
+		slog.String("action", string(resp.Action)),
+		slog.Int("headers", len(resp.Headers)))
+	if resp.Action == policyhook.ActionReject || resp.Action == policyhook.ActionTempfail {
+		return resp
+	} else if stage == policyhook.StageConnect {
+		c.policyConnQuarantine = c.policyConnQuarantine || resp.Action == policyhook.ActionQuarantine
+		c.policyConnHeaders = append(c.policyConnHeaders, resp.Headers...)
+	} else {
+		c.policyQuarantine = c.policyQuarantine || resp.Action == policyhook.ActionQuarantine
+		c.policyHeaders = append(c.policyHeaders, resp.Headers...)
+	}
+	return resp
+}
+
+// xpolicyHookRefuse aborts the current command with the SMTP error from a
+// reject or tempfail decision of the policy hook. Other decisions are ignored.
+func (c *conn) xpolicyHookRefuse(stage policyhook.Stage, resp policyhook.Response) {
+	if resp.Action != policyhook.ActionReject && resp.Action != policyhook.ActionTempfail {
+		return
+	}
+	msg := resp.Message
+	if msg == "" && resp.Action == policyhook.ActionReject {
+		msg = "rejected by policy"
+	} else if msg == "" {
+		msg = "temporarily rejected by policy, try again later"
+	}
+	c.log.Info("policy hook refused", slog.String("stage", string(stage)), slog.String("action", string(resp.Action)), slog.Int("code", resp.Code))
+	xsmtpErrorf(resp.Code, resp.Secode(), true, "%s", msg)
+}
+
+// policyHookHeaders returns the message header fields to add from the policy
+// hook decisions for the connection and transaction.
+func (c *conn) policyHookHeaders() string {
+	var s string
+	for _, h := range slices.Concat(c.policyConnHeaders, c.policyHeaders) {
+		s += h.Name + ": " + h.Value + "\r\n"
+	}
+	return s
+}
+
+// quarantineMailbox returns the name of the mailbox with special-use Junk of the
+// account, for delivering messages quarantined by the policy hook. If there is no
+// such mailbox, "Junk" is returned, and created during delivery.
+func quarantineMailbox(ctx context.Context, log mlog.Log, acc *store.Account) string {
+	name := "Junk"
+	err := acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[store.Mailbox](tx)
+		q.FilterEqual("Expunged", false)
+		q.FilterEqual("Junk", true)
+		mb, err := q.Get()
+		if err == bstore.ErrAbsent {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		name = mb.Name
+		return nil
+	})
+	log.Check(err, "looking up junk mailbox for quarantine")
+	return name
+}
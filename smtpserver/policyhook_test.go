@@ -0,0 +1,169 @@
+package smtpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/policyhook"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
+	"github.com/mjl-/mox/store"
+)
+
+func TestPolicyHook(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []policyhook.Request
+	var decide func(req policyhook.Request) policyhook.Response
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req policyhook.Request
+		err := json.NewDecoder(r.Body).Decode(&req)
+		tcheck(t, err, "parse request")
+		mutex.Lock()
+		requests = append(requests, req)
+		fn := decide
+		mutex.Unlock()
+		if fn == nil {
+			http.Error(w, "no decision", http.StatusInternalServerError)
+			return
+		}
+		err = json.NewEncoder(w).Encode(fn(req))
+		tcheck(t, err, "write response")
+	}))
+	defer srv.Close()
+
+	setDecide := func(fn func(req policyhook.Request) policyhook.Response) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		decide = fn
+		requests = nil
+	}
+	stageDecision := func(stage policyhook.Stage, resp policyhook.Response) func(req policyhook.Request) policyhook.Response {
+		return func(req policyhook.Request) policyhook.Response {
+			if req.Stage == stage {
+				return resp
+			}
+			return policyhook.Response{}
+		}
+	}
+
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+		TXT: map[string][]string{
+			"example.org.": {"v=spf1 ip4:127.0.0.10 -all"},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	ts.policyHook = &config.PolicyHook{URL: srv.URL, Timeout: 5 * time.Second}
+	defer ts.close()
+
+	testDeliver := func(rcptTo string, expErr *smtpclient.Error) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+			err := client.Deliver(ctxbg, "remote@example.org", rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+			ts.smtpErr(err, expErr)
+		})
+	}
+
+	// Connection refused before the greeting. The client doesn't parse enhanced codes
+	// in the greeting.
+	setDecide(stageDecision(policyhook.StageConnect, policyhook.Response{Action: policyhook.ActionReject}))
+	ts.runx(func(helloErr error, client *smtpclient.Client) {
+		ts.smtpErr(helloErr, &smtpclient.Error{Permanent: true, Code: smtp.C554TransactionFailed})
+	})
+
+	// Temporary failure for MAIL FROM, with custom code and message.
+	setDecide(stageDecision(policyhook.StageMail, policyhook.Response{Action: policyhook.ActionTempfail, Code: smtp.C450MailboxUnavail, EnhancedCode: "4.7.0", Message: "greylisted"}))
+	testDeliver("mjl@mox.example", &smtpclient.Error{Code: smtp.C450MailboxUnavail, Secode: smtp.SePol7Other0})
+
+	// Recipient rejected.
+	setDecide(func(req policyhook.Request) policyhook.Response {
+		if req.Stage == policyhook.StageRcpt && req.RcptTo == "mjl@mox.example" {
+			return policyhook.Response{Action: policyhook.ActionReject}
+		}
+		return policyhook.Response{}
+	})
+	testDeliver("mjl@mox.example", &smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SePol7DeliveryUnauth1})
+
+	// Message rejected after data.
+	setDecide(stageDecision(policyhook.StageData, policyhook.Response{Action: policyhook.ActionReject, Code: smtp.C554TransactionFailed, EnhancedCode: "5.7.1", Message: "not today"}))
+	testDeliver("mjl@mox.example", &smtpclient.Error{Permanent: true, Code: smtp.C554TransactionFailed, Secode: smtp.SePol7DeliveryUnauth1})
+
+	// Header from stage connect and quarantine at stage data, message ends up in Junk
+	// with the header.
+	setDecide(func(req policyhook.Request) policyhook.Response {
+		switch req.Stage {
+		case policyhook.StageConnect:
+			return policyhook.Response{Headers: []policyhook.Header{{Name: "X-Policy-Connect", Value: "seen"}}}
+		case policyhook.StageData:
+			return policyhook.Response{Action: policyhook.ActionQuarantine, Headers: []policyhook.Header{{Name: "X-Policy", Value: "quarantined"}}}
+		}
+		return policyhook.Response{}
+	})
+	testDeliver("mjl@mox.example", nil)
+	ts.checkCount("Junk", 1)
+
+	mutex.Lock()
+	stages := make([]policyhook.Stage, len(requests))
+	for i, req := range requests {
+		stages[i] = req.Stage
+	}
+	tcompare(t, stages, []policyhook.Stage{policyhook.StageConnect, policyhook.StageMail, policyhook.StageRcpt, policyhook.StageData})
+	data := requests[3]
+	mutex.Unlock()
+	tcompare(t, data.MailFrom, "remote@example.org")
+	tcompare(t, data.Recipients, []string{"mjl@mox.example"})
+	tcompare(t, data.Data.SPF, "pass")
+	tcompare(t, data.Data.IPRev, "pass")
+	tcompare(t, data.Data.From, "remote@example.org")
+	tcompare(t, data.Data.MessageID, "<test@example.org>")
+	tcompare(t, data.Data.Subject, "test")
+
+	m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).FilterEqual("Expunged", false).Get()
+	tcheck(t, err, "get delivered message")
+	tcompare(t, m.Junk, true)
+	mr := ts.acc.MessageReader(m)
+	buf, err := io.ReadAll(mr)
+	tcheck(t, err, "read message")
+	err = mr.Close()
+	tcheck(t, err, "close message")
+	if !strings.Contains(string(buf), "X-Policy-Connect: seen\r\n") || !strings.Contains(string(buf), "X-Policy: quarantined\r\n") {
+		t.Fatalf("missing policy hook headers in message:\n%s", buf)
+	}
+
+	// Failing hook results in temporary error, and is ignored with FailOpen.
+	setDecide(nil)
+	ts.runx(func(helloErr error, client *smtpclient.Client) {
+		ts.smtpErr(helloErr, &smtpclient.Error{Code: smtp.C421ServiceUnavail})
+	})
+	ts.policyHook.Stages = []string{"data"}
+	testDeliver("mjl@mox.example", &smtpclient.Error{Code: smtp.C451LocalErr, Secode: smtp.SeSys3Other0})
+	ts.policyHook.FailOpen = true
+	testDeliver("mjl@mox.example", nil)
+	ts.checkCount("Inbox", 1)
+
+	// Only configured stages are called.
+	ts.policyHook.Stages = []string{"rcpt"}
+	setDecide(stageDecision(policyhook.StageRcpt, policyhook.Response{Action: policyhook.ActionAccept}))
+	testDeliver("mjl@mox.example", nil)
+	mutex.Lock()
+	tcompare(t, len(requests), 1)
+	tcompare(t, requests[0].Stage, policyhook.StageRcpt)
+	mutex.Unlock()
+}
@@ -0,0 +1,86 @@
+package smtpserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/rejectdb"
+	"github.com/mjl-/mox/smtp"
+)
+
+// rejectedRcpt is a recipient for which delivery failed with a permanent error.
+type rejectedRcpt struct {
+	addr   smtp.Path
+	code   int
+	secode string
+	errmsg string
+}
+
+// retainRejected stores the rejected message for each recipient domain with
+// RejectedRetention configured, for review by admins.
+func (c *conn) retainRejected(ctx context.Context, rcpts []rejectedRcpt, size int64, dataFile *os.File, msgFrom smtp.Address, envelope *message.Envelope) {
+	if rejectdb.DB == nil {
+		return
+	}
+
+	// One message per domain, in order of first recipient.
+	type retain struct {
+		m    *rejectdb.Message
+		conf config.RejectedRetention
+	}
+	var retains []retain
+	byDomain := map[string]*rejectdb.Message{}
+	for _, r := range rcpts {
+		if r.addr.IPDomain.IsIP() {
+			continue
+		}
+		dom := r.addr.IPDomain.Domain
+		dc, ok := mox.Conf.Domain(dom)
+		if !ok || dc.RejectedRetention == nil {
+			continue
+		}
+		if m, ok := byDomain[dom.Name()]; ok {
+			m.RcptTo = append(m.RcptTo, r.addr.String())
+			continue
+		}
+		m := &rejectdb.Message{
+			Received: time.Now(),
+			Domain:   dom.Name(),
+			RemoteIP: c.remoteIP.String(),
+			EHLO:     c.hello.String(),
+			MailFrom: c.mailFrom.String(),
+			RcptTo:   []string{r.addr.String()},
+			Code:     r.code,
+			Secode:   r.secode,
+			Errmsg:   r.errmsg,
+			Size:     size,
+		}
+		if !msgFrom.IsZero() {
+			m.MsgFrom = msgFrom.String()
+		}
+		if envelope != nil {
+			m.Subject = envelope.Subject
+			m.MessageID = envelope.MessageID
+		}
+		byDomain[dom.Name()] = m
+		retains = append(retains, retain{m, *dc.RejectedRetention})
+	}
+
+	for _, r := range retains {
+		m := r.m
+		err := rejectdb.Add(ctx, c.log, r.conf, m, dataFile)
+		if errors.Is(err, rejectdb.ErrTooLarge) {
+			c.log.Debug("not retaining rejected message, too large", slog.String("domain", m.Domain), slog.Int64("size", size))
+		} else if err != nil {
+			c.log.Errorx("retaining rejected message", err, slog.String("domain", m.Domain))
+		} else {
+			c.log.Info("retained rejected message for review", slog.String("domain", m.Domain), slog.Int64("id", m.ID))
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package smtpserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+)
+
+// xcheckRetired rejects a recipient at a domain that is being retired, once its
+// notice period has passed. With a 551 if a new domain is configured, so senders
+// can update their address books.
+func (c *conn) xcheckRetired(rcpt smtp.Path) {
+	if rcpt.IPDomain.IsIP() {
+		return
+	}
+	dc, ok := mox.Conf.Domain(rcpt.IPDomain.Domain)
+	if !ok || dc.Retire == nil || !dc.Retire.Rejecting(time.Now()) {
+		return
+	}
+	c.log.Info("smtp recipient for retired domain", slog.Any("rcptto", rcpt))
+	code := smtp.C550MailboxUnavail
+	if !dc.Retire.NewDomainParsed.IsZero() {
+		// ../rfc/5321:2490
+		code = smtp.C551UserNotLocal
+	}
+	xsmtpUserErrorf(code, smtp.SeAddr1DestMailboxMoved6, "%s", dc.Retire.Text(rcpt.Localpart))
+}
+
+// queueRetireReply queues an automatic reply about the address change for a
+// message delivered to an address at a domain that is being retired, during the
+// notice period before messages are rejected. Replies are sent at most once a week
+// per sender, and not to messages that would not get a vacation reply.
+func queueRetireReply(ctx context.Context, log mlog.Log, a analysis, mailFrom smtp.Path, part message.Part, now time.Time) {
+	rcpt := a.d.smtpRcptTo
+	if rcpt.IPDomain.IsIP() {
+		return
+	}
+	dc, ok := mox.Conf.Domain(rcpt.IPDomain.Domain)
+	if !ok || dc.Retire == nil || dc.Retire.Rejecting(now) {
+		return
+	}
+	r := *dc.Retire
+
+	h, err := part.Header()
+	if err != nil {
+		log.Debugx("parsing message header for retire reply", err)
+		return
+	}
+	if reason := vacationSkipReason(a, mailFrom, h, nil); reason != "" {
+		log.Debug("not sending retire reply", slog.String("reason", reason))
+		return
+	}
+
+	reply, err := a.d.acc.RetireReplyCheck(ctx, mailFrom.String(), now)
+	if err != nil {
+		log.Errorx("checking for retire reply", err)
+		return
+	} else if !reply {
+		return
+	}
+
+	addr := smtp.NewAddress(rcpt.Localpart, rcpt.IPDomain.Domain)
+	subject := "Address change for " + addr.String()
+	var b strings.Builder
+	fmt.Fprintf(&b, "This is an automatic reply. Your message to %s was delivered, but messages to this address will be rejected from %s.\n", addr, r.RejectAfter)
+	if r.Message != "" {
+		fmt.Fprintf(&b, "\n%s\n", r.Text(rcpt.Localpart))
+	} else if newAddr := r.NewAddress(rcpt.Localpart); !newAddr.IsZero() {
+		fmt.Fprintf(&b, "\nPlease use %s for future messages.\n", newAddr)
+	}
+
+	if err := autoReplyQueue(ctx, log, a, mailFrom, part, subject, b.String(), now); err != nil {
+		log.Errorx("queueing retire reply", err)
+		metricServerErrors.WithLabelValues("retirereply").Inc()
+		return
+	}
+	log.Info("retire reply queued", slog.Any("to", mailFrom))
+}
@@ -0,0 +1,133 @@
+package smtpserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/subjectpass"
+)
+
+func TestRspamd(t *testing.T) {
+	// Fake rspamd, with the action taken from the message.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := io.ReadAll(r.Body)
+		if r.Header.Get("IP") != "127.0.0.10" || r.Header.Get("From") != "remote@example.org" || r.Header.Get("Rcpt") != "mjl@mox.example" {
+			t.Errorf("unexpected request headers %v", r.Header)
+		}
+		switch {
+		case strings.Contains(string(buf), "rspamd-reject"):
+			w.Write([]byte(`{"score":20,"required_score":15,"action":"reject","symbols":{"BAYES_SPAM":{"name":"BAYES_SPAM","score":5}}}`))
+		case strings.Contains(string(buf), "rspamd-add-header"):
+			w.Write([]byte(`{"score":7,"required_score":15,"action":"add header","symbols":{}}`))
+		default:
+			w.Write([]byte(`{"score":-1,"required_score":15,"action":"no action","symbols":{}}`))
+		}
+	}))
+	defer srv.Close()
+
+	// DMARC pass, needed for subjectpass hints.
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+		TXT: map[string][]string{
+			"example.org.":        {"v=spf1 ip4:127.0.0.10 -all"},
+			"_dmarc.example.org.": {"v=DMARC1;p=reject"},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	mox.Conf.Static.Rspamd = &config.Rspamd{
+		URL:     srv.URL,
+		Timeout: 5 * time.Second,
+		Mode:    "replace",
+	}
+	defer func() {
+		mox.Conf.Static.Rspamd = nil
+	}()
+
+	rejectMessage := strings.ReplaceAll(deliverMessage, "test email", "rspamd-reject")
+	addHeaderMessage := strings.ReplaceAll(deliverMessage, "test email", "rspamd-add-header")
+
+	testDeliver := func(msg string, expErr *smtpclient.Error) *smtpclient.Error {
+		t.Helper()
+		var cerr *smtpclient.Error
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+			err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+			cerr = ts.smtpErr(err, expErr)
+		})
+		return cerr
+	}
+
+	lastClassification := func() *store.JunkClassification {
+		t.Helper()
+		m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).FilterEqual("Expunged", false).SortDesc("ID").Limit(1).Get()
+		tcheck(t, err, "get delivered message")
+		return m.JunkClassification
+	}
+
+	// Clean message is delivered, without using the builtin junk filter.
+	testDeliver(deliverMessage, nil)
+	ts.checkCount("Inbox", 1)
+	if jc := lastClassification(); jc != nil {
+		t.Fatalf("got junk classification %#v, expected none with rspamd replacing junk filter", jc)
+	}
+
+	// Junk messages are refused.
+	testDeliver(rejectMessage, &smtpclient.Error{Code: smtp.C451LocalErr, Secode: smtp.SeSys3Other0})
+	testDeliver(addHeaderMessage, &smtpclient.Error{Code: smtp.C451LocalErr, Secode: smtp.SeSys3Other0})
+	ts.checkCount("Inbox", 1)
+
+	// With subjectpass, moderately spammy messages get a hint, but not messages
+	// rspamd wants rejected.
+	acc := mox.Conf.Dynamic.Accounts[ts.acc.Name]
+	acc.SubjectPass.Period = time.Hour
+	mox.Conf.Dynamic.Accounts[ts.acc.Name] = acc
+	testDeliver(rejectMessage, &smtpclient.Error{Code: smtp.C451LocalErr, Secode: smtp.SeSys3Other0})
+	cerr := testDeliver(addHeaderMessage, &smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SePol7DeliveryUnauth1})
+	if !strings.Contains(cerr.Line, subjectpass.Explanation) {
+		t.Fatalf("got error line %q, expected error line with subjectpass", cerr.Line)
+	}
+	acc.SubjectPass.Period = 0
+	mox.Conf.Dynamic.Accounts[ts.acc.Name] = acc
+
+	// In combine mode, the builtin junk filter is used too.
+	mox.Conf.Static.Rspamd.Mode = "combine"
+	testDeliver(deliverMessage, nil)
+	ts.checkCount("Inbox", 2)
+	if jc := lastClassification(); jc == nil {
+		t.Fatalf("missing junk classification with rspamd combined with junk filter")
+	}
+	testDeliver(rejectMessage, &smtpclient.Error{Code: smtp.C451LocalErr, Secode: smtp.SeSys3Other0})
+	ts.checkCount("Inbox", 2)
+
+	// Unreachable rspamd results in temporary error. With FailOpen, the builtin junk
+	// filter is used.
+	mox.Conf.Static.Rspamd.Mode = "replace"
+	srv.Close()
+	testDeliver(deliverMessage, &smtpclient.Error{Code: smtp.C451LocalErr, Secode: smtp.SeSys3Other0})
+	mox.Conf.Static.Rspamd.FailOpen = true
+	testDeliver(deliverMessage, nil)
+	ts.checkCount("Inbox", 3)
+	if jc := lastClassification(); jc == nil {
+		t.Fatalf("missing junk classification with rspamd failing open")
+	}
+}
@@ -39,6 +39,7 @@ import (
 
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/canary"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dmarc"
@@ -46,16 +47,23 @@ import (
 	"github.com/mjl-/mox/dmarcrpt"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/dsn"
+	"github.com/mjl-/mox/imapurl"
 	"github.com/mjl-/mox/iprev"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/milter"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/oauth"
+	"github.com/mjl-/mox/policyhook"
 	"github.com/mjl-/mox/publicsuffix"
 	"github.com/mjl-/mox/queue"
 	"github.com/mjl-/mox/ratelimit"
+	"github.com/mjl-/mox/sasl"
 	"github.com/mjl-/mox/scram"
+	"github.com/mjl-/mox/sieve"
+	"github.com/mjl-/mox/smime"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/spf"
 	"github.com/mjl-/mox/store"
@@ -77,10 +85,22 @@ var limiterConnectionRate, limiterConnections *ratelimit.Limiter
 var limitIPMasked1MessagesPerMinute int = 500
 var limitIPMasked1SizePerMinute int64 = 1000 * 1024 * 1024
 
-// Maximum number of RCPT TO commands (i.e. recipients) for a single message
-// delivery. Must be at least 100. Announced in LIMIT extension.
+// Default maximum number of RCPT TO commands (i.e. recipients) for a single
+// message delivery. Must be at least 100. Announced in LIMIT extension.
 const rcptToLimit = 1000
 
+// For detecting dictionary attacks: remote IPs/networks that use too many unknown
+// recipients are refused further connections until the window ends. Used for
+// listeners without MaxUnknownRecipientsPerHour.
+var limiterUnknownRecipients *ratelimit.Limiter
+
+// Limiters for unknown recipients of listeners with MaxUnknownRecipientsPerHour,
+// by listener name. Created on first use, reset by limitersInit.
+var limitersUnknownRecipients = struct {
+	sync.Mutex
+	m map[string]*ratelimit.Limiter
+}{}
+
 func init() {
 	// Also called by tests, so they don't trigger the rate limiter.
 	limitersInit()
@@ -105,6 +125,42 @@ func limitersInit() {
 			},
 		},
 	}
+	limiterUnknownRecipients = &ratelimit.Limiter{
+		WindowLimits: []ratelimit.WindowLimit{
+			{
+				Window: time.Hour,
+				Limits: [...]int64{50, 150, 450},
+			},
+		},
+	}
+	limitersUnknownRecipients.Lock()
+	limitersUnknownRecipients.m = map[string]*ratelimit.Limiter{}
+	limitersUnknownRecipients.Unlock()
+}
+
+// unknownRecipientsLimiter returns the limiter for unknown recipients for the
+// SMTP listener.
+func unknownRecipientsLimiter(listenerName string) *ratelimit.Limiter {
+	limits := mox.Conf.Static.Listeners[listenerName].SMTP.MaxUnknownRecipientsPerHour
+	if len(limits) != 3 {
+		return limiterUnknownRecipients
+	}
+
+	limitersUnknownRecipients.Lock()
+	defer limitersUnknownRecipients.Unlock()
+	if l, ok := limitersUnknownRecipients.m[listenerName]; ok {
+		return l
+	}
+	l := &ratelimit.Limiter{
+		WindowLimits: []ratelimit.WindowLimit{
+			{
+				Window: time.Hour,
+				Limits: [...]int64{limits[0], limits[1], limits[2]},
+			},
+		},
+	}
+	limitersUnknownRecipients.m[listenerName] = l
+	return l
 }
 
 var (
@@ -146,18 +202,28 @@ var (
 	metricDelivery = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "mox_smtpserver_delivery_total",
-			Help: "SMTP incoming message delivery from external source, not submission. Result values: delivered, reject, unknownuser, accounterror, delivererror. Reason indicates why a message was rejected/accepted.",
+			Help: "SMTP incoming message delivery from external source, not submission. Result values: delivered, forwarded, discarded, srsbounce, reject, unknownuser, accounterror, delivererror. Reason indicates why a message was rejected/accepted.",
 		},
 		[]string{
 			"result",
 			"reason",
 		},
 	)
+	metricTenantDelivery = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_smtpserver_tenant_delivery_total",
+			Help: "SMTP incoming message delivery to accounts of tenants, per tenant. Result values: delivered, delivererror.",
+		},
+		[]string{
+			"tenant",
+			"result",
+		},
+	)
 	// Similar between ../webmail/webmail.go:/metricSubmission and ../smtpserver/server.go:/metricSubmission and ../webapisrv/server.go:/metricSubmission
 	metricSubmission = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "mox_smtpserver_submission_total",
-			Help: "SMTP server incoming submission results, known values (those ending with error are server errors): ok, badmessage, badfrom, badheader, messagelimiterror, recipientlimiterror, localserveerror, queueerror.",
+			Help: "SMTP server incoming submission results, known values (those ending with error are server errors): ok, badmessage, badfrom, badheader, messagelimiterror, recipientlimiterror, holdforreview, disabled, localserveerror, queueerror.",
 		},
 		[]string{
 			"result",
@@ -166,7 +232,7 @@ var (
 	metricServerErrors = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "mox_smtpserver_errors_total",
-			Help: "SMTP server errors, known values: dkimsign, queuedsn.",
+			Help: "SMTP server errors, known values: dkimsign, queuedsn, queueforward, queuesrsbounce.",
 		},
 		[]string{
 			"error",
@@ -227,7 +293,7 @@ func Listen() {
 				hostname = listener.HostnameDomain
 			}
 			port := config.Port(listener.SMTP.Port, 25)
-			for _, ip := range listener.IPs {
+			for _, la := range mox.ListenAddresses(listener, "smtp", port) {
 				firstTimeSenderDelay := durationDefault(listener.SMTP.FirstTimeSenderDelay, firstTimeSenderDelayDefault)
 				if tlsConfigDelivery != nil {
 					tlsConfigDelivery = tlsConfigDelivery.Clone()
@@ -236,7 +302,7 @@ func Listen() {
 					// https://github.com/golang/go/issues/70232.
 					tlsConfigDelivery.SessionTicketsDisabled = listener.SMTP.TLSSessionTicketsDisabled == nil || *listener.SMTP.TLSSessionTicketsDisabled
 				}
-				listen1("smtp", name, ip, port, hostname, tlsConfigDelivery, false, false, noTLSClientAuth, maxMsgSize, false, listener.SMTP.RequireSTARTTLS, !listener.SMTP.NoRequireTLS, listener.SMTP.DNSBLZones, firstTimeSenderDelay)
+				listen1("smtp", name, la.Network, la.Address, hostname, tlsConfigDelivery, false, false, noTLSClientAuth, false, maxMsgSize, false, listener.SMTP.RequireSTARTTLS, !listener.SMTP.NoRequireTLS, listener.SMTP.DNSBLZones, listener.SMTP.DNSBLZoneWeights, listener.SMTP.DNSBLThreshold, listener.SMTP.URIBLZones, firstTimeSenderDelay, listener.SMTP.MaxRecipients, listener.SMTP.MaxRecipientsPerConnection, listener.SMTP.PolicyHook, listener.SMTP.Milters, listener.IPAccess, listener.SMTP.IPAccess)
 			}
 		}
 		if listener.Submission.Enabled {
@@ -245,8 +311,8 @@ func Listen() {
 				hostname = listener.HostnameDomain
 			}
 			port := config.Port(listener.Submission.Port, 587)
-			for _, ip := range listener.IPs {
-				listen1("submission", name, ip, port, hostname, tlsConfig, true, false, noTLSClientAuth, maxMsgSize, !listener.Submission.NoRequireSTARTTLS, !listener.Submission.NoRequireSTARTTLS, true, nil, 0)
+			for _, la := range mox.ListenAddresses(listener, "submission", port) {
+				listen1("submission", name, la.Network, la.Address, hostname, tlsConfig, true, false, noTLSClientAuth, listener.NoPlaintextAuth, maxMsgSize, !listener.Submission.NoRequireSTARTTLS, !listener.Submission.NoRequireSTARTTLS, true, nil, nil, 0, nil, 0, 0, 0, nil, nil, listener.IPAccess, listener.Submission.IPAccess)
 			}
 		}
 
@@ -256,8 +322,8 @@ func Listen() {
 				hostname = listener.HostnameDomain
 			}
 			port := config.Port(listener.Submissions.Port, 465)
-			for _, ip := range listener.IPs {
-				listen1("submissions", name, ip, port, hostname, tlsConfig, true, true, noTLSClientAuth, maxMsgSize, true, true, true, nil, 0)
+			for _, la := range mox.ListenAddresses(listener, "submissions", port) {
+				listen1("submissions", name, la.Network, la.Address, hostname, tlsConfig, true, true, noTLSClientAuth, listener.NoPlaintextAuth, maxMsgSize, true, true, true, nil, nil, 0, nil, 0, 0, 0, nil, nil, listener.IPAccess, listener.Submissions.IPAccess)
 			}
 		}
 	}
@@ -265,20 +331,19 @@ func Listen() {
 
 var servers []func()
 
-func listen1(protocol, name, ip string, port int, hostname dns.Domain, tlsConfig *tls.Config, submission, xtls, noTLSClientAuth bool, maxMessageSize int64, requireTLSForAuth, requireTLSForDelivery, requireTLS bool, dnsBLs []dns.Domain, firstTimeSenderDelay time.Duration) {
+func listen1(protocol, name, network, addr string, hostname dns.Domain, tlsConfig *tls.Config, submission, xtls, noTLSClientAuth, noPlaintextAuth bool, maxMessageSize int64, requireTLSForAuth, requireTLSForDelivery, requireTLS bool, dnsBLs []dns.Domain, dnsBLWeights map[dns.Domain]float64, dnsBLThreshold float64, uriBLs []dns.Domain, firstTimeSenderDelay time.Duration, maxRecipients, maxRecipientsConn int, policyHook *config.PolicyHook, milters []config.Milter, ipAccess ...*config.IPAccess) {
 	log := mlog.New("smtpserver", nil)
-	addr := net.JoinHostPort(ip, fmt.Sprintf("%d", port))
 	if os.Getuid() == 0 {
 		log.Print("listening for smtp",
 			slog.String("listener", name),
 			slog.String("address", addr),
 			slog.String("protocol", protocol))
 	}
-	network := mox.Network(ip)
 	ln, err := mox.Listen(network, addr)
 	if err != nil {
 		log.Fatalx("smtp: listen for smtp", err, slog.String("protocol", protocol), slog.String("listener", name))
 	}
+	ln = mox.IPAccessListener(ln, protocol, ipAccess...)
 
 	// Each listener gets its own copy of the config, so session keys between different
 	// ports on same listener aren't shared. We rotate session keys explicitly in this
@@ -286,7 +351,7 @@ func listen1(protocol, name, ip string, port int, hostname dns.Domain, tlsConfig
 	// base TLS config would never get automatically managed/rotated session keys.
 	if tlsConfig != nil {
 		tlsConfig = tlsConfig.Clone()
-		mox.StartTLSSessionTicketKeyRefresher(mox.Shutdown, log, tlsConfig)
+		mox.StartTLSSessionTicketKeyRefresher(mox.Shutdown, log, tlsConfig, mox.Conf.Static.Listeners[name].TLS.SessionTicketKeyRotation)
 	}
 
 	serve := func() {
@@ -299,7 +364,7 @@ func listen1(protocol, name, ip string, port int, hostname dns.Domain, tlsConfig
 
 			// Package is set on the resolver by the dkim/spf/dmarc/etc packages.
 			resolver := dns.StrictResolver{Log: log.Logger}
-			go serve(name, mox.Cid(), hostname, tlsConfig, conn, resolver, submission, xtls, false, noTLSClientAuth, maxMessageSize, requireTLSForAuth, requireTLSForDelivery, requireTLS, dnsBLs, firstTimeSenderDelay)
+			go serve(name, mox.Cid(), hostname, tlsConfig, conn, resolver, submission, xtls, false, noTLSClientAuth, noPlaintextAuth, maxMessageSize, requireTLSForAuth, requireTLSForDelivery, requireTLS, dnsBLs, dnsBLWeights, dnsBLThreshold, uriBLs, firstTimeSenderDelay, maxRecipients, maxRecipientsConn, policyHook, milters)
 		}
 	}
 
@@ -327,6 +392,8 @@ type conn struct {
 	extRequireTLS   bool // Whether to announce and allow the REQUIRETLS extension.
 	viaHTTPS        bool // Whether the connection came in via the HTTPS port (using TLS ALPN).
 	noTLSClientAuth bool
+	noPlaintextAuth bool             // Refuse PLAIN, LOGIN and CRAM-MD5 authentication.
+	greeting        *config.Greeting // Of listener, can be nil.
 	resolver        dns.Resolver
 	// The "x" in the readers and writes indicate Read and Write errors use panic to
 	// propagate the error.
@@ -334,9 +401,10 @@ type conn struct {
 	xbw                   *bufio.Writer
 	xtr                   *moxio.TraceReader // Kept for changing trace level during cmd/auth/data.
 	xtw                   *moxio.TraceWriter
-	slow                  bool      // If set, reads are done with a 1 second sleep, and writes are done 1 byte at a time, to keep spammers busy.
-	lastlog               time.Time // Used for printing the delta time since the previous logging for this connection.
-	submission            bool      // ../rfc/6409:19 applies
+	capture               *mox.ConnCapture // If set, protocol data is recorded for a capture started by the admin.
+	slow                  bool             // If set, reads are done with a 1 second sleep, and writes are done 1 byte at a time, to keep spammers busy.
+	lastlog               time.Time        // Used for printing the delta time since the previous logging for this connection.
+	submission            bool             // ../rfc/6409:19 applies
 	baseTLSConfig         *tls.Config
 	localIP               net.IP
 	remoteIP              net.IP
@@ -349,7 +417,24 @@ type conn struct {
 	cmdStart              time.Time // Start of current command.
 	ncmds                 int       // Number of commands processed. Used to abort connection when first incoming command is unknown/invalid.
 	dnsBLs                []dns.Domain
+	dnsBLWeights          map[dns.Domain]float64 // Zones without weight have weight 1.
+	dnsBLThreshold        float64                // If 0, reject on first DNSBL listing.
+	uriBLs                []dns.Domain
 	firstTimeSenderDelay  time.Duration
+	maxRecipients         int // Per transaction.
+	maxRecipientsConn     int // Per connection, 0 is no limit.
+	nrecipientsConn       int // Number of recipients added over all transactions.
+	policyHook            *config.PolicyHook
+	heloChecks            *config.HELOChecks // Of listener, can be nil.
+	xclient               *config.XCLIENT    // Set if remote is a trusted upstream server, allowed to use XCLIENT and XFORWARD.
+	unknownRcptLimiter    *ratelimit.Limiter // For refusing remote IPs/networks with many unknown recipients. Nil for submission.
+	ipAccess              []*config.IPAccess // Of listener and SMTP, checked again for IPs from XCLIENT and XFORWARD.
+
+	// Decisions by the policy hook at stage connect, for all messages of the connection.
+	policyConnQuarantine bool
+	policyConnHeaders    []policyhook.Header
+
+	milters []*connMilter // Milters for incoming messages, called in order.
 
 	// If non-zero, taken into account during Read and Write. Set while processing DATA
 	// command, we don't want the entire delivery to take too long.
@@ -358,6 +443,10 @@ type conn struct {
 	hello dns.IPDomain // Claimed remote name. Can be ip address for ehlo.
 	ehlo  bool         // If set, we had EHLO instead of HELO.
 
+	upstreamIP   net.IP        // If set, remoteIP was replaced through XCLIENT or XFORWARD, and this is the IP of the upstream server.
+	xclientHello dns.IPDomain  // From XCLIENT, takes precedence over the name in EHLO/HELO.
+	xforwardOrig *xforwardOrig // State replaced by XFORWARD for the current transaction, restored by rset.
+
 	authFailed int            // Number of failed auth attempts. For slowing down remote with many failures.
 	authSASL   bool           // Whether SASL authentication was done.
 	authTLS    bool           // Whether we did TLS client cert authentication.
@@ -367,16 +456,29 @@ type conn struct {
 	// We track good/bad message transactions to disconnect spammers trying to guess addresses.
 	transactionGood int
 	transactionBad  int
+	transaction     bool // Whether counted in transactions, for draining.
 
 	// Message transaction.
 	mailFrom             *smtp.Path
 	requireTLS           *bool     // MAIL FROM with REQUIRETLS set.
 	futureRelease        time.Time // MAIL FROM with HOLDFOR or HOLDUNTIL.
 	futureReleaseRequest string    // For use in DSNs, either "for;" or "until;" plus original value. ../rfc/4865:305
+	mtPriority           *int      // MAIL FROM with MT-PRIORITY, for submission.
+	dsnRet               string    // MAIL FROM with RET, "FULL" or "HDRS".
+	dsnEnvID             string    // MAIL FROM with ENVID, xtext-decoded.
 	has8bitmime          bool      // If MAIL FROM parameter BODY=8BITMIME was sent. Required for SMTPUTF8.
 	smtputf8             bool      // todo future: we should keep track of this per recipient. perhaps only a specific recipient requires smtputf8, e.g. due to a utf8 localpart.
 	msgsmtputf8          bool      // Is SMTPUTF8 required for the received message. Default to the same value as `smtputf8`, but is re-evaluated after the whole message (envelope and data) is received.
 	recipients           []recipient
+	policyQuarantine     bool                // Policy hook decided to quarantine at stage mail, rcpt or data.
+	policyHeaders        []policyhook.Header // Headers to add from policy hook at stage mail, rcpt or data.
+	milterQuarantine     bool                // Milter requested quarantine of the message.
+	milterDiscard        bool                // Milter decided to discard the message.
+
+	// Message data from BURL commands without LAST, until the BURL command with LAST.
+	burlFile   *os.File
+	burlWriter *message.Writer
+	burlLimit  *limitWriter // Wraps burlWriter.
 }
 
 type rcptAccount struct {
@@ -398,6 +500,26 @@ type recipient struct {
 	// deliveries, this will result in an error.
 	Account *rcptAccount // If set, recipient address is for this local account.
 	Alias   *rcptAlias   // If set, for a local alias.
+
+	// From RCPT TO parameters of the DSN extension. ../rfc/3461
+	DSNNotify        string // "NEVER" or comma-separated list of "SUCCESS", "FAILURE", "DELAY". Empty for default.
+	DSNOrigRecipient string // Original recipient address, from ORCPT parameter.
+
+	// If set, the recipient is an SRS address of a message we forwarded, and the
+	// message, a DSN, is to be sent on to this original sender.
+	SRSBounce *smtp.Path
+
+	// If set, the recipient is a mailing list or its request address.
+	List *rcptList
+}
+
+// dsnNotify returns whether the sender wants a DSN for notify, one of "SUCCESS",
+// "FAILURE" or "DELAY". ../rfc/3461
+func (r recipient) dsnNotify(notify string) bool {
+	if r.DSNNotify == "" {
+		return notify != "SUCCESS"
+	}
+	return slices.Contains(strings.Split(r.DSNNotify, ","), notify)
 }
 
 func isClosed(err error) bool {
@@ -436,9 +558,10 @@ func (c *conn) loginAttempt(useTLS bool, authMech string) store.LoginAttempt {
 }
 
 // makeTLSConfig makes a new tls config that is bound to the connection for
-// possible client certificate authentication in case of submission.
+// possible client certificate authentication in case of submission. For
+// delivery, client certificates are requested but only recorded.
 func (c *conn) makeTLSConfig() *tls.Config {
-	if !c.submission || c.noTLSClientAuth {
+	if c.noTLSClientAuth {
 		return c.baseTLSConfig
 	}
 
@@ -448,13 +571,16 @@ func (c *conn) makeTLSConfig() *tls.Config {
 	tlsConf := c.baseTLSConfig.Clone()
 
 	// Allow client certificate authentication, for use with the sasl "external"
-	// authentication mechanism.
+	// authentication mechanism. For delivery, we only note the certificate in the
+	// Received header and the stored message.
 	tlsConf.ClientAuth = tls.RequestClientCert
 
 	// We verify the client certificate during the handshake. The TLS handshake is
 	// initiated explicitly for incoming connections and during starttls, so we can
 	// immediately extract the account name and address used for authentication.
-	tlsConf.VerifyPeerCertificate = c.tlsClientAuthVerifyPeerCert
+	if c.submission {
+		tlsConf.VerifyPeerCertificate = c.tlsClientAuthVerifyPeerCert
+	}
 
 	return tlsConf
 }
@@ -470,7 +596,7 @@ func (c *conn) tlsClientAuthVerifyPeerCert(rawCerts [][]byte, verifiedChains [][
 	// If we had too many authentication failures from this IP, don't attempt
 	// authentication. If this is a new incoming connetion, it is closed after the TLS
 	// handshake.
-	if !mox.LimiterFailedAuth.CanAdd(c.remoteIP, time.Now(), 1) {
+	if !mox.LimiterFailedAuth.CanAdd(c.remoteIP, time.Now(), 1) || store.AuthLockedOut(c.remoteIP, "") {
 		return nil
 	}
 
@@ -575,6 +701,9 @@ func (c *conn) tlsClientAuthVerifyPeerCertParsed(cert *x509.Certificate) error {
 	c.account = acc
 	acc = nil // Prevent cleanup by defer.
 	c.username = pubKey.LoginAddress
+	if c.capture == nil {
+		c.setCapture(mox.CaptureConn(c.kind(), c.cid, nil, c.account.Name))
+	}
 	c.authTLS = true
 	la.Result = store.AuthSuccess
 	c.log.Debug("tls client authenticated with client certificate",
@@ -623,7 +752,7 @@ func (c *conn) xtlsHandshakeAndAuthenticate(conn net.Conn) {
 	cancel()
 
 	cs := tlsConn.ConnectionState()
-	if cs.DidResume && len(cs.PeerCertificates) > 0 && !c.noTLSClientAuth {
+	if cs.DidResume && len(cs.PeerCertificates) > 0 && c.submission && !c.noTLSClientAuth {
 		// Verify client after session resumption.
 		err := c.tlsClientAuthVerifyPeerCertParsed(cs.PeerCertificates[0])
 		if err != nil {
@@ -640,6 +769,9 @@ func (c *conn) xtlsHandshakeAndAuthenticate(conn net.Conn) {
 		slog.Bool("notlsclientauth", c.noTLSClientAuth),
 		slog.Int("clientcerts", len(cs.PeerCertificates)),
 	}
+	if len(cs.PeerCertificates) > 0 {
+		attrs = append(attrs, slog.String("clientcert", mox.TLSClientCertFingerprint(cs.PeerCertificates[0])))
+	}
 	if c.account != nil {
 		attrs = append(attrs,
 			slog.String("account", c.account.Name),
@@ -649,6 +781,21 @@ func (c *conn) xtlsHandshakeAndAuthenticate(conn net.Conn) {
 	c.log.Debug("tls handshake completed", attrs...)
 }
 
+// tlsInfo returns the negotiated TLS version and cipher suite and the fingerprint
+// of a client certificate for logging, or "plain" for connections without TLS.
+func (c *conn) tlsInfo() string {
+	if !c.tls {
+		return "plain"
+	}
+	cs := c.conn.(*tls.Conn).ConnectionState()
+	version, ciphersuite := moxio.TLSInfo(cs)
+	s := version + " " + ciphersuite
+	if len(cs.PeerCertificates) > 0 {
+		s += " clientcert=" + mox.TLSClientCertFingerprint(cs.PeerCertificates[0])
+	}
+	return s
+}
+
 // completely reset connection state as if greeting has just been sent.
 // ../rfc/3207:210
 func (c *conn) reset() {
@@ -673,10 +820,25 @@ func (c *conn) rset() {
 	c.requireTLS = nil
 	c.futureRelease = time.Time{}
 	c.futureReleaseRequest = ""
+	c.mtPriority = nil
+	c.dsnRet = ""
+	c.dsnEnvID = ""
 	c.has8bitmime = false
 	c.smtputf8 = false
 	c.msgsmtputf8 = false
 	c.recipients = nil
+	c.policyQuarantine = false
+	c.policyHeaders = nil
+	c.milterQuarantine = false
+	c.milterDiscard = false
+	c.milterAbort()
+	c.xforwardRestore()
+	if c.burlFile != nil {
+		store.CloseRemoveTempFile(c.log, c.burlFile, "smtpserver burl message data")
+		c.burlFile = nil
+		c.burlWriter = nil
+		c.burlLimit = nil
+	}
 }
 
 func (c *conn) earliestDeadline(d time.Duration) time.Time {
@@ -861,13 +1023,13 @@ func (c *conn) xwritelinef(format string, args ...any) {
 var cleanClose struct{} // Sentinel value for panic/recover indicating clean close of connection.
 
 // ServeTLSConn serves a TLS connection.
-func ServeTLSConn(listenerName string, hostname dns.Domain, conn *tls.Conn, tlsConfig *tls.Config, submission, viaHTTPS bool, maxMsgSize int64, requireTLS bool) {
+func ServeTLSConn(listenerName string, hostname dns.Domain, conn *tls.Conn, tlsConfig *tls.Config, submission, viaHTTPS, noPlaintextAuth bool, maxMsgSize int64, requireTLS bool) {
 	log := mlog.New("smtpserver", nil)
 	resolver := dns.StrictResolver{Log: log.Logger}
-	serve(listenerName, mox.Cid(), hostname, tlsConfig, conn, resolver, submission, true, viaHTTPS, true, maxMsgSize, true, true, requireTLS, nil, 0)
+	serve(listenerName, mox.Cid(), hostname, tlsConfig, conn, resolver, submission, true, viaHTTPS, true, noPlaintextAuth, maxMsgSize, true, true, requireTLS, nil, nil, 0, nil, 0, 0, 0, nil, nil)
 }
 
-func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.Config, nc net.Conn, resolver dns.Resolver, submission, xtls, viaHTTPS, noTLSClientAuth bool, maxMessageSize int64, requireTLSForAuth, requireTLSForDelivery, requireTLS bool, dnsBLs []dns.Domain, firstTimeSenderDelay time.Duration) {
+func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.Config, nc net.Conn, resolver dns.Resolver, submission, xtls, viaHTTPS, noTLSClientAuth, noPlaintextAuth bool, maxMessageSize int64, requireTLSForAuth, requireTLSForDelivery, requireTLS bool, dnsBLs []dns.Domain, dnsBLWeights map[dns.Domain]float64, dnsBLThreshold float64, uriBLs []dns.Domain, firstTimeSenderDelay time.Duration, maxRecipients, maxRecipientsConn int, policyHook *config.PolicyHook, milters []config.Milter) {
 	var localIP, remoteIP net.IP
 	if a, ok := nc.LocalAddr().(*net.TCPAddr); ok {
 		localIP = a.IP
@@ -895,6 +1057,8 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 		tls:                   xtls,
 		viaHTTPS:              viaHTTPS,
 		noTLSClientAuth:       noTLSClientAuth,
+		noPlaintextAuth:       noPlaintextAuth,
+		greeting:              mox.Conf.Static.Listeners[listenerName].Greeting,
 		extRequireTLS:         requireTLS,
 		resolver:              resolver,
 		lastlog:               time.Now(),
@@ -906,7 +1070,25 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 		requireTLSForAuth:     requireTLSForAuth,
 		requireTLSForDelivery: requireTLSForDelivery,
 		dnsBLs:                dnsBLs,
+		dnsBLWeights:          dnsBLWeights,
+		dnsBLThreshold:        dnsBLThreshold,
+		uriBLs:                uriBLs,
 		firstTimeSenderDelay:  firstTimeSenderDelay,
+		maxRecipients:         maxRecipients,
+		maxRecipientsConn:     maxRecipientsConn,
+		policyHook:            policyHook,
+	}
+	if !submission {
+		c.heloChecks = mox.Conf.Static.Listeners[listenerName].SMTP.HELOChecks
+		c.unknownRcptLimiter = unknownRecipientsLimiter(listenerName)
+		if x := mox.Conf.Static.Listeners[listenerName].SMTP.XCLIENT; x != nil && x.Trusted(remoteIP) {
+			c.xclient = x
+			l := mox.Conf.Static.Listeners[listenerName]
+			c.ipAccess = []*config.IPAccess{l.IPAccess, l.SMTP.IPAccess}
+		}
+	}
+	if c.maxRecipients <= 0 {
+		c.maxRecipients = rcptToLimit
 	}
 	var logmutex sync.Mutex
 	// Also see (and possibly update) c.logbg, for logging in a goroutine.
@@ -922,12 +1104,16 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 		if c.username != "" {
 			l = append(l, slog.String("username", c.username))
 		}
+		if c.upstreamIP != nil {
+			l = append(l, slog.Any("clientip", c.remoteIP))
+		}
 		return l
 	})
 	c.xtr = moxio.NewTraceReader(c.log, "RC: ", c)
 	c.xbr = bufio.NewReader(c.xtr)
 	c.xtw = moxio.NewTraceWriter(c.log, "LS: ", c)
 	c.xbw = bufio.NewWriter(c.xtw)
+	c.setCapture(mox.CaptureConn(c.kind(), c.cid, c.remoteIP, ""))
 
 	metricConnection.WithLabelValues(c.kind()).Inc()
 	c.log.Info("new connection",
@@ -948,6 +1134,10 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 			c.log.Check(err, "closing account")
 			c.account = nil
 		}
+		if c.burlFile != nil {
+			store.CloseRemoveTempFile(c.log, c.burlFile, "smtpserver burl message data")
+		}
+		c.milterClose()
 
 		x := recover()
 		if x == nil || x == cleanClose {
@@ -974,6 +1164,10 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 		return
 	default:
 	}
+	if mox.Draining.Load() {
+		c.xwritecodeline(smtp.C421ServiceUnavail, smtp.SeSys3NotAccepting2, "shutting down for maintenance", nil)
+		return
+	}
 
 	if !limiterConnectionRate.Add(c.remoteIP, time.Now(), 1) {
 		c.xwritecodeline(smtp.C421ServiceUnavail, smtp.SePol7Other0, "connection rate from your ip or network too high, slow down please", nil)
@@ -981,13 +1175,20 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 	}
 
 	// If remote IP/network resulted in too many authentication failures, refuse to serve.
-	if submission && !mox.LimiterFailedAuth.CanAdd(c.remoteIP, time.Now(), 1) {
+	if submission && (!mox.LimiterFailedAuth.CanAdd(c.remoteIP, time.Now(), 1) || store.AuthLockedOut(c.remoteIP, "")) {
 		metrics.AuthenticationRatelimitedInc("submission")
 		c.log.Debug("refusing connection due to many auth failures", slog.Any("remoteip", c.remoteIP))
 		c.xwritecodeline(smtp.C421ServiceUnavail, smtp.SePol7Other0, "too many auth failures", nil)
 		return
 	}
 
+	// If remote IP/network used too many unknown recipients, refuse to serve.
+	if !submission && !c.unknownRcptLimiter.CanAdd(c.remoteIP, time.Now(), 1) {
+		c.log.Debug("refusing connection due to many unknown recipients", slog.Any("remoteip", c.remoteIP))
+		c.xwritecodeline(smtp.C421ServiceUnavail, smtp.SePol7Other0, "too many unknown recipients from your ip or network, try again later", nil)
+		return
+	}
+
 	if !limiterConnections.Add(c.remoteIP, time.Now(), 1) {
 		c.log.Debug("refusing connection due to many open connections", slog.Any("remoteip", c.remoteIP))
 		c.xwritecodeline(smtp.C421ServiceUnavail, smtp.SePol7Other0, "too many open connections from your ip or network", nil)
@@ -999,15 +1200,50 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 	// with a TLS connection later on.
 	mox.Connections.Register(nc, "smtp", listenerName)
 	defer mox.Connections.Unregister(nc)
+	defer c.transactionSet(false)
+
+	// Let the policy hook decide whether we talk to this remote at all. A refusal is
+	// a greeting with a 554 (or 421 for temporary) code.
+	if c.policyHookEnabled(policyhook.StageConnect) {
+		resp := c.policyHookCall(policyhook.StageConnect, "", nil)
+		if resp.Action == policyhook.ActionReject || resp.Action == policyhook.ActionTempfail {
+			code, msg := smtp.C554TransactionFailed, "connection refused by policy"
+			if resp.Action == policyhook.ActionTempfail {
+				code, msg = smtp.C421ServiceUnavail, "connection refused by policy, try again later"
+			}
+			if resp.Message != "" {
+				msg = resp.Message
+			}
+			c.log.Info("policy hook refused connection", slog.String("action", string(resp.Action)))
+			c.xwritecodeline(code, resp.Secode(), msg, nil)
+			return
+		}
+	}
 
-	// ../rfc/5321:964 ../rfc/5321:4294 about announcing software and version
-	// Syntax: ../rfc/5321:2586
-	// We include the string ESMTP. https://cr.yp.to/smtp/greeting.html recommends it.
-	// Should not be too relevant nowadays, but does not hurt and default blackbox
-	// exporter SMTP health check expects it.
-	c.xwritelinef("%d %s ESMTP mox", smtp.C220ServiceReady, c.hostname.ASCII)
+	// Let the milters see the connection. Like the policy hook, they can refuse it.
+	if len(milters) > 0 {
+		resp := c.milterConnect(milters)
+		if resp.Action == milter.ActionReject || resp.Action == milter.ActionTempfail {
+			code, secode, msg := smtp.C554TransactionFailed, smtp.SePol7Other0, "connection refused by filter"
+			if resp.Action == milter.ActionTempfail {
+				code, msg = smtp.C421ServiceUnavail, "connection refused by filter, try again later"
+			}
+			if resp.Secode() != "" {
+				secode = resp.Secode()
+			}
+			if resp.Message != "" {
+				msg = resp.Message
+			}
+			c.log.Info("milter refused connection", slog.String("action", string(resp.Action)))
+			c.xwritecodeline(code, secode, msg, nil)
+			return
+		}
+	}
+
+	c.xwritelinef("%d %s", smtp.C220ServiceReady, c.greetingText())
 
 	for {
+		c.transactionSet(c.mailFrom != nil)
 		command(c)
 
 		// If another command is present, don't flush our buffered response yet. Holding
@@ -1023,6 +1259,30 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 	}
 }
 
+// greetingText returns the text for the 220 greeting, after the code.
+func (c *conn) greetingText() string {
+	// ../rfc/5321:964 ../rfc/5321:4294 about announcing software and version
+	// Syntax: ../rfc/5321:2586
+	// We include the string ESMTP. https://cr.yp.to/smtp/greeting.html recommends it.
+	// Should not be too relevant nowadays, but does not hurt and default blackbox
+	// exporter SMTP health check expects it.
+	greetHost, greetText := c.hostname.ASCII, "mox"
+	if g := c.greeting; g != nil {
+		if g.HostnameDomain.ASCII != "" {
+			greetHost = g.HostnameDomain.ASCII
+		}
+		if g.SMTP != "" {
+			greetText = g.SMTP
+		} else if g.HideSoftware {
+			greetText = ""
+		}
+	}
+	if greetText != "" {
+		greetText = " " + greetText
+	}
+	return greetHost + " ESMTP" + greetText
+}
+
 var commands = map[string]func(c *conn, p *parser){
 	"helo":     (*conn).cmdHelo,
 	"ehlo":     (*conn).cmdEhlo,
@@ -1031,12 +1291,15 @@ var commands = map[string]func(c *conn, p *parser){
 	"mail":     (*conn).cmdMail,
 	"rcpt":     (*conn).cmdRcpt,
 	"data":     (*conn).cmdData,
+	"burl":     (*conn).cmdBurl,
 	"rset":     (*conn).cmdRset,
 	"vrfy":     (*conn).cmdVrfy,
 	"expn":     (*conn).cmdExpn,
 	"help":     (*conn).cmdHelp,
 	"noop":     (*conn).cmdNoop,
 	"quit":     (*conn).cmdQuit,
+	"xclient":  (*conn).cmdXclient,
+	"xforward": (*conn).cmdXforward,
 }
 
 func command(c *conn) {
@@ -1088,6 +1351,11 @@ func command(c *conn) {
 		panic(errIO)
 	default:
 	}
+	// While draining, transactions in progress can complete, but no new ones are started.
+	if mox.Draining.Load() && c.mailFrom == nil && cmdl != "quit" {
+		c.xwritecodeline(smtp.C421ServiceUnavail, smtp.SeSys3NotAccepting2, "shutting down for maintenance", nil)
+		panic(errIO)
+	}
 
 	c.cmd = cmdl
 	c.cmdStart = time.Now()
@@ -1110,6 +1378,16 @@ func command(c *conn) {
 	fn(c, p)
 }
 
+// setCapture starts recording protocol data of the connection for a capture
+// started by the admin, if cc is not nil.
+func (c *conn) setCapture(cc *mox.ConnCapture) {
+	if cc != nil && c.capture == nil {
+		c.capture = cc
+		c.xtr.SetCapture(cc)
+		c.xtw.SetCapture(cc)
+	}
+}
+
 // For use in metric labels.
 func (c *conn) kind() string {
 	if c.submission {
@@ -1183,9 +1461,18 @@ func (c *conn) cmdHello(p *parser, ehlo bool) {
 		p.xend()
 	}
 
+	// The original client of a trusted upstream server.
+	if !c.xclientHello.IsZero() {
+		remote = c.xclientHello
+	}
+
+	c.xheloChecks(remote)
+
 	// Reset state as if RSET command has been issued. ../rfc/5321:2093 ../rfc/5321:2453
 	c.rset()
 
+	c.xmilterHelo(remote)
+
 	c.ehlo = ehlo
 	c.hello = remote
 
@@ -1212,7 +1499,10 @@ func (c *conn) cmdHello(p *parser, ehlo bool) {
 			// authentication. The client should select the bare variant when TLS isn't
 			// present, and also not indicate the server supports the PLUS variant in that
 			// case, or it would trigger the mechanism downgrade detection.
-			mechs = "SCRAM-SHA-256-PLUS SCRAM-SHA-256 SCRAM-SHA-1-PLUS SCRAM-SHA-1 CRAM-MD5 PLAIN LOGIN"
+			mechs = "SCRAM-SHA-256-PLUS SCRAM-SHA-256 SCRAM-SHA-1-PLUS SCRAM-SHA-1 CRAM-MD5 PLAIN LOGIN OAUTHBEARER XOAUTH2"
+			if c.noPlaintextAuth {
+				mechs = "SCRAM-SHA-256-PLUS SCRAM-SHA-256 SCRAM-SHA-1-PLUS SCRAM-SHA-1 OAUTHBEARER XOAUTH2"
+			}
 		}
 		if c.tls && len(c.conn.(*tls.Conn).ConnectionState().PeerCertificates) > 0 && !c.viaHTTPS && !c.noTLSClientAuth {
 			mechs = "EXTERNAL " + mechs
@@ -1221,12 +1511,22 @@ func (c *conn) cmdHello(p *parser, ehlo bool) {
 		// ../rfc/4865:127
 		t := time.Now().Add(queue.FutureReleaseIntervalMax).UTC() // ../rfc/4865:98
 		c.xbwritelinef("250-FUTURERELEASE %d %s", queue.FutureReleaseIntervalMax/time.Second, t.Format(time.RFC3339))
-	}
-	c.xbwritelinef("250-ENHANCEDSTATUSCODES") // ../rfc/2034:71
-	// todo future? c.writelinef("250-DSN")
-	c.xbwritelinef("250-8BITMIME")                       // ../rfc/6152:86
-	c.xbwritelinef("250-LIMITS RCPTMAX=%d", rcptToLimit) // ../rfc/9422:301
-	c.xbwritecodeline(250, "", "SMTPUTF8", nil)          // ../rfc/6531:201
+		// We don't implement one of the registered priority assignment policies, the
+		// priority is only used for ordering deliveries from our queue. ../rfc/6710
+		c.xbwritelinef("250-MT-PRIORITY")
+		// We can only resolve URLs for our own IMAP server. ../rfc/4468
+		c.xbwritelinef("250-BURL imap")
+	}
+	if c.xclient != nil {
+		// https://www.postfix.org/XCLIENT_README.html https://www.postfix.org/XFORWARD_README.html
+		c.xbwritelinef("250-XCLIENT %s", strings.Join(xclientAttrs, " "))
+		c.xbwritelinef("250-XFORWARD %s", strings.Join(xforwardAttrs, " "))
+	}
+	c.xbwritelinef("250-ENHANCEDSTATUSCODES")                // ../rfc/2034:71
+	c.xbwritelinef("250-DSN")                                // ../rfc/3461
+	c.xbwritelinef("250-8BITMIME")                           // ../rfc/6152:86
+	c.xbwritelinef("250-LIMITS RCPTMAX=%d", c.maxRecipients) // ../rfc/9422:301
+	c.xbwritecodeline(250, "", "SMTPUTF8", nil)              // ../rfc/6531:201
 	c.xflush()
 }
 
@@ -1385,6 +1685,13 @@ func (c *conn) cmdAuth(p *parser) {
 		}
 	}()
 
+	if c.noPlaintextAuth && (mech == "PLAIN" || mech == "LOGIN" || mech == "CRAM-MD5") {
+		la.AuthMech = strings.ToLower(mech)
+		la.Result = store.AuthBadProtocol
+		// ../rfc/4954:176
+		xsmtpUserErrorf(smtp.C504ParamNotImpl, smtp.SeProto5BadParams4, "mechanism %s not allowed, plaintext authentication is disabled", mech)
+	}
+
 	switch mech {
 	case "PLAIN":
 		la.AuthMech = "plain"
@@ -1414,7 +1721,12 @@ func (c *conn) cmdAuth(p *parser) {
 		}
 
 		var err error
-		account, la.AccountName, err = store.OpenEmailAuth(c.log, username, password, false)
+		var scope store.APITokenScope
+		account, la.AccountName, scope, err = store.OpenEmailAuthToken(c.log, username, password, false)
+		// API tokens with read scope cannot be used for submission.
+		if err == nil && scope == store.APITokenScopeRead {
+			err = store.ErrUnknownCredentials
+		}
 		if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
 			// ../rfc/4954:274
 			la.Result = store.AuthBadCredentials
@@ -1457,7 +1769,12 @@ func (c *conn) cmdAuth(p *parser) {
 		c.xtrace(mlog.LevelTrace) // Restore.
 
 		var err error
-		account, la.AccountName, err = store.OpenEmailAuth(c.log, username, password, false)
+		var scope store.APITokenScope
+		account, la.AccountName, scope, err = store.OpenEmailAuthToken(c.log, username, password, false)
+		// API tokens with read scope cannot be used for submission.
+		if err == nil && scope == store.APITokenScopeRead {
+			err = store.ErrUnknownCredentials
+		}
 		if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
 			// ../rfc/4954:274
 			la.Result = store.AuthBadCredentials
@@ -1630,6 +1947,48 @@ func (c *conn) cmdAuth(p *parser) {
 		// The message should be empty. todo: should we require it is empty?
 		xreadContinuation()
 
+	case "OAUTHBEARER", "XOAUTH2":
+		la.AuthMech = strings.ToLower(mech)
+
+		if !c.tls && c.requireTLSForAuth {
+			xsmtpUserErrorf(smtp.C538EncReqForAuth, smtp.SePol7EncReqForAuth11, "authentication requires tls")
+		}
+
+		// Bearer token is in plain text, so hide it.
+		defer c.xtrace(mlog.LevelTraceauth)()
+		buf := xreadInitial("")
+		c.xtrace(mlog.LevelTrace) // Restore.
+		var authz, token string
+		var err error
+		if mech == "OAUTHBEARER" {
+			authz, token, err = sasl.ParseOAuthBearer(buf)
+		} else {
+			authz, token, err = sasl.ParseXOAuth2(buf)
+		}
+		if err != nil {
+			la.Result = store.AuthBadProtocol
+			xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5BadParams4, "%s", err)
+		}
+		la.LoginAddress = authz
+
+		username, err = oauth.Verify(context.TODO(), c.log, token)
+		if err == nil && authz != "" && !strings.EqualFold(authz, username) {
+			err = fmt.Errorf("%w: token is for other user", oauth.ErrInvalidToken)
+		}
+		if err != nil && errors.Is(err, oauth.ErrInvalidToken) {
+			la.Result = store.AuthBadCredentials
+			c.log.Infox("failed authentication attempt", err, slog.String("username", authz), slog.Any("remote", c.remoteIP))
+			// Error details are sent as challenge, client must respond with a dummy response
+			// before we send the final failure. ../rfc/7628
+			c.xwritelinef("%d %s", smtp.C334ContinueAuth, base64.StdEncoding.EncodeToString(oauth.ErrorResponse()))
+			c.xreadline()
+			xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "bad token")
+		}
+		xcheckf(err, "verifying token")
+		la.LoginAddress = username
+		account, la.AccountName, _, err = store.OpenEmail(c.log, username, false)
+		xcheckf(err, "looking up address for token")
+
 	case "EXTERNAL":
 		la.AuthMech = "external"
 
@@ -1662,6 +2021,10 @@ func (c *conn) cmdAuth(p *parser) {
 
 	if accConf, ok := account.Conf(); !ok {
 		xcheckf(errors.New("cannot find account"), "get account config")
+	} else if store.AuthLockedOut(nil, account.Name) {
+		la.Result = store.AuthBadCredentials
+		c.log.Info("account locked out after failed authentication attempts", slog.String("username", username))
+		xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "bad credentials")
 	} else if accConf.LoginDisabled != "" {
 		la.Result = store.AuthLoginDisabled
 		c.log.Info("account login disabled", slog.String("username", username))
@@ -1697,6 +2060,9 @@ func (c *conn) cmdAuth(p *parser) {
 	la.LoginAddress = c.username
 	la.AccountName = c.account.Name
 	la.Result = store.AuthSuccess
+	if c.capture == nil {
+		c.setCapture(mox.CaptureConn(c.kind(), c.cid, nil, c.account.Name))
+	}
 	c.authSASL = true
 	c.authFailed = 0
 	c.setSlow(false)
@@ -1720,6 +2086,13 @@ func (c *conn) cmdMail(p *parser) {
 
 	c.xneedHello()
 	c.xcheckAuth()
+	if c.submission && c.account != nil {
+		if accConf, _ := c.account.Conf(); accConf.SubmissionDisabled != "" {
+			metricSubmission.WithLabelValues("disabled").Inc()
+			c.log.Info("submission disabled for account", slog.String("user", c.username))
+			xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SePol7DeliveryUnauth1, "submission disabled: %s", accConf.SubmissionDisabled)
+		}
+	}
 	if c.mailFrom != nil {
 		// ../rfc/5321:2507, though ../rfc/5321:1029 contradicts, implying a MAIL would also reset, but ../rfc/5321:1160 decides.
 		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "already have MAIL")
@@ -1805,6 +2178,22 @@ func (c *conn) cmdMail(p *parser) {
 			}
 			v := true
 			c.requireTLS = &v
+		case "MT-PRIORITY":
+			// Only for submission, we only use it for our own queue. ../rfc/6710
+			if !c.submission {
+				xsmtpUserErrorf(smtp.C555UnrecognizedAddrParams, smtp.SeSys3NotSupported3, "unrecognized parameter %q", key)
+			}
+			p.xtake("=")
+			// ../rfc/6710
+			neg := p.take("-")
+			if !neg {
+				p.take("+")
+			}
+			v := int(p.xnumber(1, true))
+			if neg {
+				v = -v
+			}
+			c.mtPriority = &v
 		case "HOLDFOR", "HOLDUNTIL":
 			// Only for submission ../rfc/4865:163
 			if !c.submission {
@@ -1838,6 +2227,20 @@ func (c *conn) cmdMail(p *parser) {
 				c.futureRelease = t
 				c.futureReleaseRequest = "until;" + s
 			}
+		case "RET":
+			p.xtake("=")
+			v := strings.ToUpper(p.xparamValue())
+			if v != "FULL" && v != "HDRS" {
+				xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5BadParams4, "invalid value %q for RET", v)
+			}
+			c.dsnRet = v
+		case "ENVID":
+			p.xtake("=")
+			v := p.xtext()
+			if v == "" || len(v) > 100 {
+				xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5BadParams4, "ENVID must be 1 to 100 characters")
+			}
+			c.dsnEnvID = v
 		default:
 			// ../rfc/5321:2230
 			xsmtpUserErrorf(smtp.C555UnrecognizedAddrParams, smtp.SeSys3NotSupported3, "unrecognized parameter %q", key)
@@ -1910,6 +2313,15 @@ func (c *conn) cmdMail(p *parser) {
 
 	c.mailFrom = &rpath
 
+	if c.policyHookEnabled(policyhook.StageMail) {
+		resp := c.policyHookCall(policyhook.StageMail, "", nil)
+		if resp.Action == policyhook.ActionReject || resp.Action == policyhook.ActionTempfail {
+			c.mailFrom = nil
+			c.xpolicyHookRefuse(policyhook.StageMail, resp)
+		}
+	}
+	c.xmilterMail()
+
 	c.xbwritecodeline(smtp.C250Completed, smtp.SeAddr1Other0, "looking good", nil)
 }
 
@@ -1936,13 +2348,35 @@ func (c *conn) cmdRcpt(p *parser) {
 	} else {
 		fpath = p.xforwardPath()
 	}
+	var dsnNotify, dsnOrigRcpt string
+	paramSeen := map[string]bool{}
 	for p.space() {
 		// ../rfc/5321:2275
 		key := p.xparamKeyword()
-		// K := strings.ToUpper(key)
-		// todo future: DSN, ../rfc/3461, with "NOTIFY"
-		// ../rfc/5321:2230
-		xsmtpUserErrorf(smtp.C555UnrecognizedAddrParams, smtp.SeSys3NotSupported3, "unrecognized parameter %q", key)
+		K := strings.ToUpper(key)
+		if paramSeen[K] {
+			xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5BadParams4, "duplicate param %q", key)
+		}
+		paramSeen[K] = true
+
+		switch K {
+		case "NOTIFY":
+			p.xtake("=")
+			dsnNotify = strings.ToUpper(p.xparamValue())
+			l := strings.Split(dsnNotify, ",")
+			for _, v := range l {
+				if v == "NEVER" && len(l) == 1 || v == "SUCCESS" || v == "FAILURE" || v == "DELAY" {
+					continue
+				}
+				xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5BadParams4, "invalid value %q for NOTIFY", dsnNotify)
+			}
+		case "ORCPT":
+			p.xtake("=")
+			dsnOrigRcpt = p.xorcpt()
+		default:
+			// ../rfc/5321:2230
+			xsmtpUserErrorf(smtp.C555UnrecognizedAddrParams, smtp.SeSys3NotSupported3, "unrecognized parameter %q", key)
+		}
 	}
 	p.xend()
 
@@ -1954,9 +2388,12 @@ func (c *conn) cmdRcpt(p *parser) {
 
 	// todo future: for submission, should we do explicit verification that domains are fully qualified? also for mail from. ../rfc/6409:420
 
-	if len(c.recipients) >= rcptToLimit {
+	if len(c.recipients) >= c.maxRecipients {
 		// ../rfc/5321:3535 ../rfc/5321:3571
-		xsmtpUserErrorf(smtp.C452StorageFull, smtp.SeProto5TooManyRcpts3, "max of %d recipients reached", rcptToLimit)
+		xsmtpUserErrorf(smtp.C452StorageFull, smtp.SeProto5TooManyRcpts3, "max of %d recipients reached", c.maxRecipients)
+	}
+	if c.maxRecipientsConn > 0 && c.nrecipientsConn >= c.maxRecipientsConn {
+		xsmtpUserErrorf(smtp.C452StorageFull, smtp.SeProto5TooManyRcpts3, "max of %d recipients per connection reached, reconnect to continue", c.maxRecipientsConn)
 	}
 
 	// We don't want to allow delivery to multiple recipients with a null reverse path.
@@ -2008,19 +2445,33 @@ func (c *conn) cmdRcpt(p *parser) {
 		c.xlocalserveError(fpath.Localpart)
 	}
 
+	c.xcheckRetired(fpath)
+
 	if len(fpath.IPDomain.IP) > 0 {
 		if !c.submission {
 			xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, "not accepting email for ip")
 		}
-		c.recipients = append(c.recipients, recipient{fpath, nil, nil})
-	} else if accountName, alias, canonical, dest, err := mox.LookupAddress(fpath.Localpart, fpath.IPDomain.Domain, true, true, true); err == nil {
+		c.recipients = append(c.recipients, recipient{fpath, nil, nil, dsnNotify, dsnOrigRcpt, nil, nil})
+	} else if bounceTo := c.srsBounceRecipient(fpath); bounceTo != nil {
+		// DSN for a message we forwarded, to be sent on to the original sender.
+		c.recipients = append(c.recipients, recipient{fpath, nil, nil, dsnNotify, dsnOrigRcpt, bounceTo, nil})
+	} else if l, request, ok := c.listRecipient(fpath); ok {
+		if c.mailFrom.IsZero() {
+			// DSNs and other messages with a null reverse path, e.g. for messages sent to
+			// subscribers, are delivered to the list owner.
+			listAddr, _ := l.Addresses()
+			c.recipients = append(c.recipients, recipient{fpath, &rcptAccount{l.Account, config.Destination{}, listAddr.Pack(true)}, nil, dsnNotify, dsnOrigRcpt, nil, nil})
+		} else {
+			c.recipients = append(c.recipients, recipient{fpath, nil, nil, dsnNotify, dsnOrigRcpt, nil, &rcptList{l, request}})
+		}
+	} else if accountName, alias, canonical, dest, err := lookupRecipient(fpath.Localpart, fpath.IPDomain.Domain); err == nil {
 		// note: a bare postmaster, without domain, is handled by LookupAddress. ../rfc/5321:735
 		if alias != nil {
-			c.recipients = append(c.recipients, recipient{fpath, nil, &rcptAlias{*alias, canonical}})
+			c.recipients = append(c.recipients, recipient{fpath, nil, &rcptAlias{*alias, canonical}, dsnNotify, dsnOrigRcpt, nil, nil})
 		} else if dest.SMTPError != "" {
 			xsmtpServerErrorf(codes{dest.SMTPErrorCode, dest.SMTPErrorSecode}, "%s", dest.SMTPErrorMsg)
 		} else {
-			c.recipients = append(c.recipients, recipient{fpath, &rcptAccount{accountName, dest, canonical}, nil})
+			c.recipients = append(c.recipients, recipient{fpath, &rcptAccount{accountName, dest, canonical}, nil, dsnNotify, dsnOrigRcpt, nil, nil})
 		}
 
 	} else if Localserve {
@@ -2030,7 +2481,7 @@ func (c *conn) cmdRcpt(p *parser) {
 		// which is typically the mox user.
 		acc, _ := mox.Conf.Account("mox")
 		dest := acc.Destinations["mox@localhost"]
-		c.recipients = append(c.recipients, recipient{fpath, &rcptAccount{"mox", dest, "mox@localhost"}, nil})
+		c.recipients = append(c.recipients, recipient{fpath, &rcptAccount{"mox", dest, "mox@localhost"}, nil, dsnNotify, dsnOrigRcpt, nil, nil})
 	} else if errors.Is(err, mox.ErrDomainDisabled) {
 		c.log.Info("smtp recipient for temporarily disabled domain", slog.Any("domain", fpath.IPDomain.Domain))
 		xsmtpUserErrorf(smtp.C450MailboxUnavail, smtp.SeMailbox2Disabled1, "recipient domain temporarily disabled")
@@ -2039,24 +2490,59 @@ func (c *conn) cmdRcpt(p *parser) {
 			xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, "not accepting email for domain")
 		}
 		// We'll be delivering this email.
-		c.recipients = append(c.recipients, recipient{fpath, nil, nil})
+		c.recipients = append(c.recipients, recipient{fpath, nil, nil, dsnNotify, dsnOrigRcpt, nil, nil})
 	} else if errors.Is(err, mox.ErrAddressNotFound) {
 		if c.submission {
 			// For submission, we're transparent about which user exists. Should be fine for the typical small-scale deploy.
 			// ../rfc/5321:1071
 			xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, "no such user")
 		}
+		// Many unknown recipients from an IP or network is likely a dictionary attack,
+		// trying to find valid addresses. We refuse further connections for a while.
+		if !Localserve && !c.unknownRcptLimiter.Add(c.remoteIP, time.Now(), 1) {
+			c.log.Info("too many unknown recipients from ip or network, possible dictionary attack, refusing connections", slog.Any("remoteip", c.remoteIP))
+			// ../rfc/5321:2811
+			c.xwritecodeline(smtp.C421ServiceUnavail, smtp.SePol7Other0, "too many unknown recipients from your ip or network, try again later", nil)
+			panic(errIO)
+		}
+
 		// We pretend to accept. We don't want to let remote know the user does not exist
 		// until after DATA. Because then remote has committed to sending a message.
 		// note: not local for !c.submission is the signal this address is in error.
-		c.recipients = append(c.recipients, recipient{fpath, nil, nil})
+		c.recipients = append(c.recipients, recipient{fpath, nil, nil, dsnNotify, dsnOrigRcpt, nil, nil})
 	} else {
 		c.log.Errorx("looking up account for delivery", err, slog.Any("rcptto", fpath))
 		xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "error processing")
 	}
+
+	if c.policyHookEnabled(policyhook.StageRcpt) {
+		resp := c.policyHookCall(policyhook.StageRcpt, fpath.String(), nil)
+		if resp.Action == policyhook.ActionReject || resp.Action == policyhook.ActionTempfail {
+			c.recipients = c.recipients[:len(c.recipients)-1]
+			c.xpolicyHookRefuse(policyhook.StageRcpt, resp)
+		}
+	}
+	if len(c.milters) > 0 {
+		resp := c.milterRcpt(fpath)
+		if resp.Action == milter.ActionReject || resp.Action == milter.ActionTempfail {
+			c.recipients = c.recipients[:len(c.recipients)-1]
+			c.xmilterRefuse("rcpt", resp)
+		}
+	}
+	c.nrecipientsConn++
 	c.xbwritecodeline(smtp.C250Completed, smtp.SeAddr1Other0, "now on the list", nil)
 }
 
+// lookupRecipient looks up the destination for a recipient, at a configured
+// domain or at a subdomain of a configured domain that accepts subdomains.
+func lookupRecipient(localpart smtp.Localpart, domain dns.Domain) (accountName string, alias *config.Alias, canonical string, dest config.Destination, rerr error) {
+	accountName, alias, canonical, dest, rerr = mox.LookupAddress(localpart, domain, true, true, true)
+	if errors.Is(rerr, mox.ErrDomainNotFound) {
+		return mox.LookupSubdomainAddress(localpart, domain, true, true, true)
+	}
+	return
+}
+
 func hasNonASCII(s string) bool {
 	for _, c := range []byte(s) {
 		if c > unicode.MaxASCII {
@@ -2097,6 +2583,10 @@ func (c *conn) cmdData(p *parser) {
 		// ../rfc/5321:1130
 		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "missing RCPT TO")
 	}
+	if c.burlFile != nil {
+		// ../rfc/4468
+		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "cannot mix BURL and DATA")
+	}
 
 	// ../rfc/5321:2066
 	p.xend()
@@ -2156,6 +2646,87 @@ func (c *conn) cmdData(p *parser) {
 		return
 	}
 
+	c.processMessage(cmdctx, msgWriter, dataFile)
+}
+
+// cmdBurl adds the message data referenced by an URLAUTH-authorized IMAP URL to
+// the message of the transaction. With LAST, the message is complete and
+// submitted like with DATA.
+// ../rfc/4468
+func (c *conn) cmdBurl(p *parser) {
+	if !c.submission {
+		// Only announced for submission.
+		xsmtpUserErrorf(smtp.C500BadSyntax, smtp.SeProto5BadCmdOrSeq1, "unknown command")
+	}
+	c.xneedHello()
+	c.xcheckAuth()
+	if c.mailFrom == nil {
+		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "missing MAIL FROM")
+	}
+	if len(c.recipients) == 0 {
+		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "missing RCPT TO")
+	}
+
+	// Request syntax: ../rfc/4468
+	p.xspace()
+	url := p.xtakefn1case("url", func(c rune, i int) bool { return c != ' ' })
+	var last bool
+	if p.space() {
+		p.xtake("LAST")
+		last = true
+	}
+	p.xend()
+
+	cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
+	cmdctx, cmdcancel := context.WithTimeout(cidctx, 30*time.Minute)
+	defer cmdcancel()
+	c.deadline, _ = cmdctx.Deadline()
+	defer func() {
+		c.deadline = time.Time{}
+	}()
+
+	if c.burlFile == nil {
+		f, err := store.CreateMessageTemp(c.log, "smtp-burl")
+		if err != nil {
+			xsmtpServerErrorf(errCodes(smtp.C451LocalErr, smtp.SeSys3Other0, err), "creating temporary file for message: %s", err)
+		}
+		c.burlFile = f
+		c.burlWriter = message.NewWriter(f)
+		c.burlLimit = &limitWriter{maxSize: c.maxMessageSize, w: c.burlWriter}
+	}
+
+	// The URL is resolved in-process, for our own IMAP server. The access identifier
+	// must allow submission on behalf of the authenticated account.
+	u, err := imapurl.Parse(url)
+	if err == nil {
+		_, err = imapurl.FetchAuthorized(cmdctx, c.log, u, c.account.Name, true, c.burlLimit)
+	}
+	if err != nil {
+		// The message would be incomplete, the client has to start over.
+		c.rset()
+		if errors.Is(err, errMessageTooLarge) {
+			// ../rfc/1870:136
+			xsmtpUserErrorf(smtp.C552MailboxFull, smtp.SeSys3MsgLimitExceeded4, "message too large, transaction reset")
+		}
+		xsmtpUserErrorf(smtp.C554TransactionFailed, smtp.SeMsg6ContentUnavailable6, "resolving url: %v, transaction reset", err)
+	}
+
+	if !last {
+		c.xbwritecodeline(smtp.C250Completed, smtp.SeOther00, "url data added", nil)
+		return
+	}
+
+	dataFile, msgWriter := c.burlFile, c.burlWriter
+	c.burlFile = nil
+	c.burlWriter = nil
+	c.burlLimit = nil
+	defer store.CloseRemoveTempFile(c.log, dataFile, "smtpserver burl message")
+	c.processMessage(cmdctx, msgWriter, dataFile)
+}
+
+// processMessage checks a message received with DATA or BURL, and submits or
+// delivers it.
+func (c *conn) processMessage(cmdctx context.Context, msgWriter *message.Writer, dataFile *os.File) {
 	// Basic sanity checks on messages before we send them out to the world. Just
 	// trying to be strict in what we do to others and liberal in what we accept.
 	if c.submission {
@@ -2185,6 +2756,7 @@ func (c *conn) cmdData(p *parser) {
 
 	// Now that we have all the whole message (envelope + data), we can check if the SMTPUTF8 extension is required.
 	var part *message.Part
+	var walked bool
 	if c.smtputf8 || c.submission || mox.Pedantic {
 		// Try to parse the message.
 		// Do nothing if something bad happen during Parse and Walk, just keep the current value for c.msgsmtputf8.
@@ -2194,6 +2766,7 @@ func (c *conn) cmdData(p *parser) {
 			part = &p
 			err = part.Walk(c.log.Logger, nil)
 			if err == nil {
+				walked = true
 				c.msgsmtputf8 = c.isSMTPUTF8Required(part)
 			}
 		}
@@ -2209,12 +2782,29 @@ func (c *conn) cmdData(p *parser) {
 		xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeMsg6Other0, "smtputf8 extension is required but was not added to the MAIL command")
 	}
 
+	// Reject messages exceeding the limits for parsing, e.g. with too many or too
+	// deeply nested parts, instead of spending resources on them during analysis.
+	// Otherwise invalid messages are accepted, like before.
+	if !walked {
+		p, err := message.Parse(c.log.Logger, false, dataFile)
+		if err == nil {
+			err = p.Walk(c.log.Logger, nil)
+		}
+		if err != nil && errors.Is(err, message.ErrLimitExceeded) {
+			if c.submission {
+				metricSubmission.WithLabelValues("badmessage").Inc()
+			}
+			xsmtpUserErrorf(smtp.C552MailboxFull, smtp.SeSys3MsgLimitExceeded4, "message too complex: %v", err)
+		}
+	}
+
 	// Prepare "Received" header.
 	// ../rfc/5321:2051 ../rfc/5321:3302
 	// ../rfc/5321:3311 ../rfc/6531:578
 	var recvFrom string
 	var iprevStatus iprev.Status // Only for delivery, not submission.
 	var iprevAuthentic bool
+	var err error
 	if c.submission {
 		// Hide internal hosts.
 		// todo future: make this a config option, where admins specify ip ranges that they don't want exposed. also see ../rfc/5321:4321
@@ -2281,17 +2871,20 @@ func (c *conn) cmdData(p *parser) {
 		recvHdr := &message.HeaderWriter{}
 		// For additional Received-header clauses, see:
 		// https://www.iana.org/assignments/mail-parameters/mail-parameters.xhtml#table-mail-parameters-8
-		withComment := ""
-		if c.requireTLS != nil && *c.requireTLS {
-			// Comment is actually part of ID ABNF rule. ../rfc/5321:3336
-			withComment = " (requiretls)"
-		}
-		recvHdr.Add(" ", "Received:", "from", recvFrom, "by", recvBy, "via", "tcp", "with", with+withComment, "id", mox.ReceivedID(c.cid)) // ../rfc/5321:3158
+		recvHdr.Add(" ", "Received:", "from", recvFrom, "by", recvBy, "via", "tcp", "with", with) // ../rfc/5321:3158
+		// Comments are actually part of ID ABNF rule. ../rfc/5321:3336
 		if c.tls {
-			tlsConn := c.conn.(*tls.Conn)
-			tlsComment := mox.TLSReceivedComment(c.log, tlsConn.ConnectionState())
-			recvHdr.Add(" ", tlsComment...)
+			cs := c.conn.(*tls.Conn).ConnectionState()
+			if c.submission {
+				// Don't expose client certificates of our own users.
+				cs.PeerCertificates = nil
+			}
+			recvHdr.Add(" ", mox.TLSReceivedComment(c.log, cs)...)
+		}
+		if c.requireTLS != nil && *c.requireTLS {
+			recvHdr.Add(" ", "(requiretls)")
 		}
+		recvHdr.Add(" ", "id", mox.ReceivedID(c.cid))
 		// We leave out an empty "for" clause. This is empty for messages submitted to
 		// multiple recipients, so the message stays identical and a single smtp
 		// transaction can deliver, only transferring the data once.
@@ -2307,9 +2900,35 @@ func (c *conn) cmdData(p *parser) {
 	// internet traffic.
 	if c.submission {
 		c.submit(cmdctx, recvHdrFor, msgWriter, dataFile, part)
-	} else {
-		c.deliver(cmdctx, recvHdrFor, msgWriter, iprevStatus, iprevAuthentic, dataFile)
+		return
+	}
+
+	// Milters see the message before our own analysis, and may modify it.
+	if len(c.milters) > 0 {
+		var milterFile *os.File
+		msgWriter, milterFile = c.xmilterMessage(msgWriter, dataFile)
+		if milterFile != nil {
+			defer store.CloseRemoveTempFile(c.log, milterFile, "smtpserver message modified by milter")
+			dataFile = milterFile
+		}
+		if c.milterDiscarding() {
+			c.log.Info("discarding message due to milter decision")
+			c.transactionGood++
+			c.transactionBad--
+			c.rset()
+			c.xwritecodeline(smtp.C250Completed, smtp.SeMailbox2Other0, "it is done", nil)
+			return
+		}
+	}
+
+	// DSNs for messages we forwarded are sent on to the original sender. A null reverse
+	// path allows only a single recipient.
+	if len(c.recipients) == 1 && c.recipients[0].SRSBounce != nil {
+		c.relaySRSBounce(cmdctx, recvHdrFor, msgWriter, dataFile)
+		return
 	}
+
+	c.deliver(cmdctx, recvHdrFor, msgWriter, iprevStatus, iprevAuthentic, dataFile)
 }
 
 // Check if a message has unambiguous "TLS-Required: No" header. Messages must not
@@ -2384,7 +3003,9 @@ func (c *conn) submit(ctx context.Context, recvHdrFor func(string) string, msgWr
 		msgPrefix = append(msgPrefix, "Date: "+time.Now().Format(message.RFC5322Z)+"\r\n"...)
 	}
 
-	// Check outgoing message rate limit.
+	// Check outgoing message rate limit. If a limit is reached, the message is
+	// refused, or held in the queue for review if the account is configured to.
+	var limitErr, limitSetting, limitMetric string
 	err = c.account.DB.Read(ctx, func(tx *bstore.Tx) error {
 		rcpts := make([]smtp.Path, len(c.recipients))
 		for i, r := range c.recipients {
@@ -2393,15 +3014,29 @@ func (c *conn) submit(ctx context.Context, recvHdrFor func(string) string, msgWr
 		msglimit, rcptlimit, err := c.account.SendLimitReached(tx, rcpts)
 		xcheckf(err, "checking sender limit")
 		if msglimit >= 0 {
-			metricSubmission.WithLabelValues("messagelimiterror").Inc()
-			xsmtpUserErrorf(smtp.C451LocalErr, smtp.SePol7DeliveryUnauth1, "max number of messages (%d) over past 24h reached, try increasing per-account setting MaxOutgoingMessagesPerDay", msglimit)
+			limitErr = fmt.Sprintf("max number of messages (%d) over past 24h reached", msglimit)
+			limitSetting = "MaxOutgoingMessagesPerDay"
+			limitMetric = "messagelimiterror"
 		} else if rcptlimit >= 0 {
-			metricSubmission.WithLabelValues("recipientlimiterror").Inc()
-			xsmtpUserErrorf(smtp.C451LocalErr, smtp.SePol7DeliveryUnauth1, "max number of new/first-time recipients (%d) over past 24h reached, try increasing per-account setting MaxFirstTimeRecipientsPerDay", rcptlimit)
+			limitErr = fmt.Sprintf("max number of new/first-time recipients (%d) over past 24h reached", rcptlimit)
+			limitSetting = "MaxFirstTimeRecipientsPerDay"
+			limitMetric = "recipientlimiterror"
 		}
 		return nil
 	})
 	xcheckf(err, "read-only transaction")
+	var holdReason string
+	if limitErr != "" {
+		hold, err := queue.HoldForReview(ctx, c.account.Name)
+		xcheckf(err, "checking messages held for review")
+		if !hold {
+			metricSubmission.WithLabelValues(limitMetric).Inc()
+			xsmtpUserErrorf(smtp.C451LocalErr, smtp.SePol7DeliveryUnauth1, "%s, try increasing per-account setting %s", limitErr, limitSetting)
+		}
+		metricSubmission.WithLabelValues("holdforreview").Inc()
+		c.log.Info("holding submitted message in queue for review", slog.String("reason", limitErr))
+		holdReason = limitErr
+	}
 
 	// We gather any X-Mox-Extra-* headers into the "extra" data during queueing, which
 	// will make it into any webhook we deliver.
@@ -2436,14 +3071,18 @@ func (c *conn) submit(ctx context.Context, recvHdrFor func(string) string, msgWr
 	}
 
 	selectors := mox.DKIMSelectors(confDom.DKIM)
-	if len(selectors) > 0 {
-		canonical := mox.CanonicalLocalpart(msgFrom.Localpart, confDom)
-		if dkimHeaders, err := dkim.Sign(ctx, c.log.Logger, canonical, msgFrom.Domain, selectors, c.msgsmtputf8, store.FileMsgReader(msgPrefix, dataFile)); err != nil {
-			c.log.Errorx("dkim sign for domain", err, slog.Any("domain", msgFrom.Domain))
-			metricServerErrors.WithLabelValues("dkimsign").Inc()
-		} else {
-			msgPrefix = append(msgPrefix, []byte(dkimHeaders)...)
-		}
+	// Add BIMI-Selector header when configured and the message doesn't have one yet,
+	// before signing, the header must be included in the DKIM signatures.
+	if len(header.Values("BIMI-Selector")) == 0 {
+		var bimiHeader string
+		bimiHeader, selectors = mox.BIMISelectorHeader(confDom, selectors)
+		msgPrefix = append(msgPrefix, bimiHeader...)
+	}
+	if dkimHeaders, err := mox.DKIMSignSubmission(ctx, c.log, confDom, msgFrom, *c.mailFrom, selectors, c.msgsmtputf8, store.FileMsgReader(msgPrefix, dataFile)); err != nil {
+		c.log.Errorx("dkim sign for domain", err, slog.Any("domain", msgFrom.Domain))
+		metricServerErrors.WithLabelValues("dkimsign").Inc()
+	} else {
+		msgPrefix = append(msgPrefix, []byte(dkimHeaders)...)
 	}
 
 	authResults := message.AuthResults{
@@ -2525,6 +3164,16 @@ func (c *conn) submit(ctx context.Context, recvHdrFor func(string) string, msgWr
 		}
 		qm.FromID = fromID
 		qm.Extra = extra
+		qm.DSNNotify = rcpt.DSNNotify
+		qm.DSNRet = c.dsnRet
+		qm.DSNEnvID = c.dsnEnvID
+		qm.DSNOrigRecipient = rcpt.DSNOrigRecipient
+		qm.Priority = accConf.DeliveryPriority
+		if c.mtPriority != nil {
+			qm.Priority = *c.mtPriority
+		}
+		qm.Hold = holdReason != ""
+		qm.HoldReason = holdReason
 		qml[i] = qm
 	}
 
@@ -2606,6 +3255,14 @@ func (c *conn) xlocalserveError(lp smtp.Localpart) {
 
 // deliver is called for incoming messages from external, typically untrusted
 // sources. i.e. not submitted by authenticated users.
+// tenantDeliveryMetric counts an incoming delivery for the tenant of the account,
+// if any.
+func tenantDeliveryMetric(accountName, result string) {
+	if tenant, _, ok := mox.Conf.AccountTenant(accountName); ok {
+		metricTenantDelivery.WithLabelValues(tenant, result).Inc()
+	}
+}
+
 func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgWriter *message.Writer, iprevStatus iprev.Status, iprevAuthentic bool, dataFile *os.File) {
 	// todo: in decision making process, if we run into (some) temporary errors, attempt to continue. if we decide to accept, all good. if we decide to reject, we'll make it a temporary reject.
 
@@ -2614,6 +3271,7 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 	var headers textproto.MIMEHeader
 	var isDSN bool
 	part, err := message.Parse(c.log.Logger, false, dataFile)
+	partParsed := err == nil
 	if err == nil {
 		// todo: is it enough to check only the the content-type header? in other places we look at the content-types of the parts before considering a message a dsn. should we change other places to this simpler check?
 		isDSN = part.MediaType == "MULTIPART" && part.MediaSubType == "REPORT" && strings.EqualFold(part.ContentTypeParams["report-type"], "delivery-status")
@@ -2623,9 +3281,9 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		c.log.Infox("parsing message for From address", err)
 	}
 
-	// Basic loop detection. ../rfc/5321:4065 ../rfc/5321:1526
-	if len(headers.Values("Received")) > 100 {
-		xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeNet4Loop6, "loop detected, more than 100 Received headers")
+	if reason, errmsg := loopDetect(headers); reason != "" {
+		metricLoopDetected.WithLabelValues(reason).Inc()
+		xsmtpUserErrorf(smtp.C554TransactionFailed, smtp.SeNet4Loop6, "%s", errmsg)
 	}
 
 	// TLS-Required: No header makes us not enforce recipient domain's TLS policy.
@@ -2768,7 +3426,7 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 	// Give immediate response if all recipients are unknown.
 	nunknown := 0
 	for _, r := range c.recipients {
-		if r.Account == nil && r.Alias == nil {
+		if r.Account == nil && r.Alias == nil && r.List == nil {
 			nunknown++
 		}
 	}
@@ -2913,6 +3571,35 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		receivedSPF.Result = spf.StatusNone
 	}
 
+	// Detect signed/encrypted messages, and verify S/MIME signatures.
+	// ../rfc/7281
+	var smimeResult smime.Result
+	if part.MediaType != "" {
+		smimeResult = smime.Check(c.log, &part, dataFile, msgFrom, mox.Conf.Static.SMIME.CertPool, time.Now())
+	}
+	if smimeResult.Status != "" {
+		var props []message.AuthProp
+		if signer, err := smtp.ParseAddress(smimeResult.Signer); err == nil {
+			props = []message.AuthProp{message.MakeAuthProp("smime", "smime-identifier", signer.Pack(c.msgsmtputf8), true, "")}
+		}
+		var reason string
+		if smimeResult.Err != nil {
+			// Error can contain text from the certificate, don't let it break the header.
+			reason = strings.Map(func(r rune) rune {
+				if r < ' ' || r == 0x7f {
+					return ' '
+				}
+				return r
+			}, smimeResult.Err.Error())
+		}
+		authResults.Methods = append(authResults.Methods, message.AuthMethod{
+			Method: "smime",
+			Result: string(smimeResult.Status),
+			Reason: reason,
+			Props:  props,
+		})
+	}
+
 	// DMARC
 	var dmarcUse bool
 	var dmarcResult dmarc.Result
@@ -2967,6 +3654,49 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 	}
 	c.log.Debug("dmarc verification", slog.Any("result", dmarcResult.Status), slog.Any("domain", msgFrom.Domain))
 
+	// With the authentication results known, let the policy hook decide about the
+	// message for all recipients. Account-specific analysis, e.g. junk filtering, is
+	// done after this.
+	if c.policyHookEnabled(policyhook.StageData) {
+		data := policyhook.Data{
+			Size:        msgWriter.Size,
+			Header:      headers,
+			IPRev:       string(iprevStatus),
+			SPF:         string(receivedSPF.Result),
+			SPFIdentity: string(receivedSPF.Identity),
+			DMARC:       string(dmarcResult.Status),
+			DMARCDomain: dmarcResult.Domain.Name(),
+			DMARCReject: dmarcUse && dmarcResult.Reject,
+		}
+		if !msgFrom.IsZero() {
+			data.From = msgFrom.String()
+		}
+		if envelope != nil {
+			data.Subject = envelope.Subject
+			data.MessageID = envelope.MessageID
+		}
+		if spfIdentity != nil {
+			data.SPFDomain = spfIdentity.Name()
+		}
+		for _, r := range dkimResults {
+			dr := policyhook.DKIMResult{Status: string(r.Status)}
+			if r.Sig != nil {
+				dr.Domain = r.Sig.Domain.Name()
+				dr.Selector = r.Sig.Selector.Name()
+			}
+			data.DKIM = append(data.DKIM, dr)
+		}
+		resp := c.policyHookCall(policyhook.StageData, "", &data)
+		c.xpolicyHookRefuse(policyhook.StageData, resp)
+	}
+
+	// Scan for viruses once, if needed for any of the recipients. The configured
+	// action is applied per recipient.
+	var virus string
+	if slices.ContainsFunc(c.recipients, func(rcpt recipient) bool { return virusScanEnabled(rcpt.Addr.IPDomain.Domain) }) {
+		virus = c.xvirusScan(ctx, msgWriter.Size, dataFile)
+	}
+
 	// Prepare for analyzing content, calculating reputation.
 	ipmasked1, ipmasked2, ipmasked3 := ipmasked(c.remoteIP)
 	var verifiedDKIMDomains []string
@@ -2984,6 +3714,23 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		}
 	}
 
+	// Probes sent by our canaries are registered, not delivered.
+	var subject string
+	if envelope != nil {
+		subject = envelope.Subject
+	}
+	if token := canary.Token(headers, subject); token != "" {
+		dkimPass := slices.Contains(verifiedDKIMDomains, msgFrom.Domain.Name())
+		if canary.Received(c.log, token, receivedSPF.Result == spf.StatusPass, dkimPass, dmarcResult.Status == dmarc.StatusPass) {
+			metricDelivery.WithLabelValues("canary", "").Inc()
+			c.transactionGood++
+			c.transactionBad-- // Compensate for early earlier pessimistic increase.
+			c.rset()
+			c.xwritecodeline(smtp.C250Completed, smtp.SeMailbox2Other0, "it is done", nil)
+			return
+		}
+	}
+
 	// When we deliver, we try to remove from rejects mailbox based on message-id.
 	// We'll parse it when we need it, but it is the same for each recipient.
 	var messageID string
@@ -3000,10 +3747,11 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		secode    string
 		userError bool
 		errmsg    string
+		rcpt      recipient
 	}
 	var deliverErrors []deliverError
 	addError := func(rcpt recipient, code int, secode string, userError bool, errmsg string) {
-		e := deliverError{rcpt.Addr, code, secode, userError, errmsg}
+		e := deliverError{rcpt.Addr, code, secode, userError, errmsg, rcpt}
 		c.log.Info("deliver error",
 			slog.Any("rcptto", e.rcptTo),
 			slog.Int("code", code),
@@ -3013,15 +3761,17 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		deliverErrors = append(deliverErrors, e)
 	}
 
-	// Sort recipients: local accounts, aliases, unknown. For ensuring we don't deliver
-	// to an alias destination that was also explicitly sent to.
+	// Sort recipients: local accounts, aliases, lists, unknown. For ensuring we don't
+	// deliver to an alias destination that was also explicitly sent to.
 	rcptScore := func(r recipient) int {
 		if r.Account != nil {
 			return 0
 		} else if r.Alias != nil {
 			return 1
+		} else if r.List != nil {
+			return 2
 		}
-		return 2
+		return 3
 	}
 	sort.SliceStable(c.recipients, func(i, j int) bool {
 		return rcptScore(c.recipients[i]) < rcptScore(c.recipients[j])
@@ -3082,12 +3832,19 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			MsgFromValidation:  msgFromValidation,
 			DKIMDomains:        verifiedDKIMDomains,
 			DSN:                isDSN,
+			Signed:             string(smimeResult.Signed),
+			Encrypted:          string(smimeResult.Encrypted),
+			SignatureResult:    string(smimeResult.Status),
+			SignatureSigner:    smimeResult.Signer,
 			Size:               msgWriter.Size,
 		}
 		if c.tls {
 			tlsState := c.conn.(*tls.Conn).ConnectionState()
 			m.ReceivedTLSVersion = tlsState.Version
 			m.ReceivedTLSCipherSuite = tlsState.CipherSuite
+			if len(tlsState.PeerCertificates) > 0 {
+				m.ReceivedTLSClientCert = mox.TLSClientCertFingerprint(tlsState.PeerCertificates[0])
+			}
 			if c.requireTLS != nil {
 				m.ReceivedRequireTLS = *c.requireTLS
 			}
@@ -3095,14 +3852,25 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			m.ReceivedTLSVersion = 1 // Signals plain text delivery.
 		}
 
+		// PGP signatures are verified with the keys known to the account.
+		if smimeResult.Signed == smime.KindPGP {
+			pgpVerify(ctx, log, acc, &part, dataFile, msgFrom, &m)
+		}
+		m.Keywords = m.SignatureKeywords()
+
 		var msgTo, msgCc []message.Address
 		if envelope != nil {
 			msgTo = envelope.To
 			msgCc = envelope.CC
 		}
-		d := delivery{c.tls, &m, dataFile, smtpRcptTo, deliverTo, destination, canonicalAddr, acc, msgTo, msgCc, msgFrom, c.dnsBLs, dmarcUse, dmarcResult, dkimResults, iprevStatus, c.smtputf8}
+		d := delivery{c.tls, &m, dataFile, smtpRcptTo, deliverTo, destination, canonicalAddr, acc, msgTo, msgCc, msgFrom, c.dnsBLs, c.dnsBLWeights, c.dnsBLThreshold, c.uriBLs, dmarcUse, dmarcResult, dkimResults, iprevStatus, c.smtputf8}
 
 		r := analyze(ctx, log, c.resolver, d)
+		if !r.accept && !smtpRcptTo.IPDomain.IsIP() {
+			if dc, ok := mox.Conf.Domain(smtpRcptTo.IPDomain.Domain); ok && dc.RejectText != nil {
+				r.errmsg = dc.RejectText.Text(r.reason, r.errmsg)
+			}
+		}
 		return &r, nil
 	}
 
@@ -3121,12 +3889,75 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		// deliveries, and return an error at the end? Though the failure conditions will
 		// probably prevent any other successful deliveries too...
 		// We'll continue delivering to other recipients. ../rfc/5321:3275
-		if rcpt.Account == nil && rcpt.Alias == nil {
+		if rcpt.Account == nil && rcpt.Alias == nil && rcpt.List == nil {
 			metricDelivery.WithLabelValues("unknownuser", "").Inc()
 			addError(rcpt, smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, true, "no such user")
 			return
 		}
 
+		// Messages with a virus are rejected without analysis, and not stored in the
+		// Rejects mailbox. Or they are quarantined.
+		var virusQuarantine bool
+		if virus != "" && virusScanEnabled(rcpt.Addr.IPDomain.Domain) {
+			if mox.Conf.Static.ClamAV.Action == "quarantine" {
+				virusQuarantine = true
+			} else {
+				log.Info("incoming message rejected due to virus", slog.String("virus", virus))
+				metricDelivery.WithLabelValues("reject", "virus").Inc()
+				addError(rcpt, smtp.C554TransactionFailed, smtp.SePol7DeliveryUnauth1, true, "message contains a virus: "+virus)
+				return
+			}
+		}
+
+		// Messages for mailing lists are analyzed for the account of the list owner. If
+		// accepted, they are sent to subscribers, held for moderation, or handled as
+		// command for the list.
+		if rcpt.List != nil {
+			listAddr, _ := rcpt.List.List.Addresses()
+			a, err := messageAnalyze(log, rcpt.Addr, rcpt.Addr, rcpt.List.List.Account, config.Destination{}, listAddr.Pack(true))
+			if err != nil {
+				addError(rcpt, smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing")
+				return
+			}
+			defer func() {
+				err := a.d.acc.Close()
+				log.Check(err, "close account")
+			}()
+			if !a.accept {
+				log.Info("incoming message for list rejected", slog.String("reason", a.reason), slog.Any("msgfrom", msgFrom))
+				metricDelivery.WithLabelValues("reject", a.reason).Inc()
+				c.setSlow(true)
+				addError(rcpt, a.code, a.secode, a.userError, a.errmsg)
+				return
+			}
+
+			lm := listMessage{
+				a:        *a,
+				envelope: envelope,
+				headers:  headers,
+				recvHdr:  recvHdrFor(rcpt.Addr.String()),
+				dataFile: dataFile,
+				size:     msgWriter.Size,
+				has8bit:  msgWriter.Has8bit,
+				hold:     virusQuarantine || c.policyConnQuarantine || c.policyQuarantine || c.milterQuarantine,
+			}
+			if partParsed {
+				lm.part = &part
+			}
+			if err := c.deliverList(ctx, log, *rcpt.List, lm); err != nil {
+				var serr smtpError
+				if errors.As(err, &serr) {
+					log.Infox("list message refused", err)
+					addError(rcpt, serr.code, serr.secode, serr.userError, serr.errmsg)
+				} else {
+					log.Errorx("handling list message", err)
+					metricServerErrors.WithLabelValues("list").Inc()
+					addError(rcpt, smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing")
+				}
+			}
+			return
+		}
+
 		// la holds all analysis, and message preparation, for all accounts (multiple for
 		// aliases). Each has an open account that we we close on return.
 		var la []analysis
@@ -3233,6 +4064,22 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			xmox = hw.String()
 		}
 		xmox += a0.headers
+		xmox += c.policyHookHeaders()
+		if virusQuarantine {
+			xmox += "X-Mox-Virus: " + virus + "\r\n"
+		}
+
+		// Deliver messages quarantined by the policy hook, a milter or due to a virus to
+		// the Junk mailbox. Messages rejected by our own analysis stay rejected.
+		quarantined := a0.accept && (c.policyConnQuarantine || c.policyQuarantine || c.milterQuarantine || virusQuarantine)
+		if quarantined {
+			log.Info("delivering quarantined message to junk mailbox")
+			for i := range la {
+				la[i].mailbox = quarantineMailbox(ctx, log, la[i].d.acc)
+				// Ruleset actions like forwarding don't apply to quarantined messages.
+				la[i].ruleset = nil
+			}
+		}
 
 		for i := range la {
 			// ../rfc/5321:3204
@@ -3535,22 +4382,42 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			parsedMessageID = true
 		}
 
+		// For forwarding due to a ruleset or a sieve script redirect. The forwarded
+		// message gets a Delivered-To header, for loop detection, and our Received header,
+		// but not our other headers about the incoming delivery.
+		forward := func(a analysis, sr *sieve.Result) {
+			var forwardTo []string
+			why := "ruleset"
+			if sr != nil {
+				forwardTo = sr.Redirect
+				why = "sieve script"
+			} else if a.ruleset != nil {
+				forwardTo = a.ruleset.ForwardTo
+			}
+			if len(forwardTo) == 0 {
+				return
+			}
+			if forwardLoop(headers, a.d.deliverTo) {
+				log.Info("not forwarding message due to "+why+", already forwarded for address", slog.Any("deliverto", a.d.deliverTo))
+				metricLoopDetected.WithLabelValues("forward").Inc()
+				return
+			}
+			prefix := []byte("Delivered-To: " + a.d.deliverTo.XString(c.msgsmtputf8) + "\r\n" + recvHdrFor(rcpt.Addr.String()))
+			queueForward(log, a, forwardTo, why, dataFile, prefix, int64(len(prefix))+msgWriter.Size, msgWriter.Has8bit, c.msgsmtputf8, messageID)
+		}
+
 		// Finally deliver the message to the account(s).
 		var nerr int       // Number of non-quota errors.
 		var nfull int      // Number of failed deliveries due to over quota.
 		var ndelivered int // Number delivered to account.
-		for _, a := range la {
-			// Don't deliver to recipient that was explicitly present in SMTP transaction, or
-			// is sending the message to an alias they are member of.
-			if rcpt.Alias != nil && (regularRecipient(a.d.deliverTo) || a.d.deliverTo.Equal(msgFrom.Path())) {
-				continue
-			}
 
-			var delivered bool
+		// Deliver message m to mailbox of the account, returning whether it was delivered.
+		deliverMailbox := func(a analysis, mailbox string, m *store.Message) (delivered bool) {
 			a.d.acc.WithWLock(func() {
-				if err := a.d.acc.DeliverMailbox(log, a.mailbox, a.d.m, dataFile); err != nil {
+				if err := a.d.acc.DeliverMailbox(log, mailbox, m, dataFile); err != nil {
 					log.Errorx("delivering", err)
 					metricDelivery.WithLabelValues("delivererror", a0.reason).Inc()
+					tenantDeliveryMetric(a.d.acc.Name, "delivererror")
 					if errors.Is(err, store.ErrOverQuota) {
 						nfull++
 					} else {
@@ -3562,15 +4429,109 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 				delivered = true
 				ndelivered++
 				metricDelivery.WithLabelValues("delivered", a0.reason).Inc()
-				log.Info("incoming message delivered", slog.String("reason", a0.reason), slog.Any("msgfrom", msgFrom))
+				tenantDeliveryMetric(a.d.acc.Name, "delivered")
+				log.Info("incoming message delivered", slog.String("reason", a0.reason), slog.Any("msgfrom", msgFrom), slog.String("mailbox", mailbox), slog.String("tls", c.tlsInfo()))
 
 				conf, _ := a.d.acc.Conf()
-				if conf.RejectsMailbox != "" && a.d.m.MessageID != "" {
-					if err := a.d.acc.RejectsRemove(log, conf.RejectsMailbox, a.d.m.MessageID); err != nil {
+				if conf.RejectsMailbox != "" && m.MessageID != "" {
+					if err := a.d.acc.RejectsRemove(log, conf.RejectsMailbox, m.MessageID); err != nil {
 						log.Errorx("removing message from rejects mailbox", err, slog.String("messageid", messageID))
 					}
 				}
 			})
+			return
+		}
+		for _, a := range la {
+			// Don't deliver to recipient that was explicitly present in SMTP transaction, or
+			// is sending the message to an alias they are member of.
+			if rcpt.Alias != nil && (regularRecipient(a.d.deliverTo) || a.d.deliverTo.Equal(msgFrom.Path())) {
+				continue
+			}
+
+			// Destinations can forward to other addresses, with an SRS sender. Only if
+			// configured, the message is delivered locally too. Messages put in the junk or
+			// rejects mailbox are not forwarded.
+			if len(a.d.destination.ForwardTo) > 0 && !quarantined && !a.d.m.IsReject && forwardLoop(headers, a.d.deliverTo) {
+				log.Info("not forwarding message already forwarded for address, delivering locally", slog.Any("deliverto", a.d.deliverTo))
+				metricLoopDetected.WithLabelValues("forward").Inc()
+			} else if len(a.d.destination.ForwardTo) > 0 && !quarantined && !a.d.m.IsReject {
+				prefix := []byte("Delivered-To: " + a.d.deliverTo.XString(c.msgsmtputf8) + "\r\n" + recvHdrFor(rcpt.Addr.String()))
+				forwarded := queueSRSForward(ctx, log, a, *c.mailFrom, dataFile, prefix, int64(len(prefix))+msgWriter.Size, msgWriter.Has8bit, c.msgsmtputf8, messageID)
+				if forwarded && !a.d.destination.ForwardKeepCopy {
+					log.Info("incoming message forwarded", slog.String("reason", a0.reason), slog.Any("msgfrom", msgFrom), slog.Any("forwardto", a.d.destination.ForwardTo))
+					metricDelivery.WithLabelValues("forwarded", a0.reason).Inc()
+					ndelivered++
+					continue
+				}
+			}
+
+			if a.ruleset != nil && a.ruleset.Discard {
+				log.Info("incoming message discarded due to ruleset", slog.String("reason", a0.reason), slog.Any("msgfrom", msgFrom))
+				metricDelivery.WithLabelValues("discarded", a0.reason).Inc()
+				ndelivered++
+				forward(a, nil)
+				continue
+			}
+			if a.ruleset != nil && a.ruleset.MarkSeen {
+				a.d.m.Seen = true
+			}
+
+			// Without matching ruleset, the active sieve script of the account, if any,
+			// determines where the message is delivered. Not for reports, and not for
+			// quarantined messages.
+			deliveries := []sieveDelivery{{a.mailbox, nil}}
+			var sr *sieve.Result
+			if a.ruleset == nil && !quarantined && !a.d.m.IsReject && a.dmarcReport == nil && a.tlsReport == nil {
+				sr = sieveEvaluate(ctx, log, a, *c.mailFrom, dataFile)
+			}
+			if sr != nil && sr.Reject {
+				// For aliases, the message is still delivered to other members.
+				log.Info("incoming message rejected due to sieve script", slog.Any("msgfrom", msgFrom))
+				metricDelivery.WithLabelValues("reject", "sieve").Inc()
+				if rcpt.Alias == nil {
+					addError(rcpt, smtp.C550MailboxUnavail, smtp.SePol7DeliveryUnauth1, true, sieveRejectText(sr.RejectReason))
+				} else {
+					ndelivered++
+				}
+				continue
+			} else if sr != nil {
+				deliveries = sieveDeliveries(a.mailbox, *sr)
+			}
+			if len(deliveries) == 0 {
+				log.Info("incoming message discarded due to sieve script", slog.Any("msgfrom", msgFrom))
+				metricDelivery.WithLabelValues("discarded", a0.reason).Inc()
+				ndelivered++
+				forward(a, sr)
+				if sr.Vacation != nil {
+					mr := store.FileMsgReader(a.d.m.MsgPrefix, dataFile)
+					if part, err := message.EnsurePart(log.Logger, false, mr, a.d.m.Size); err != nil {
+						log.Errorx("parsing message for sieve vacation reply", err)
+					} else {
+						queueSieveVacationReply(context.Background(), log, a, *c.mailFrom, part, *sr.Vacation, time.Now())
+					}
+				}
+				continue
+			}
+
+			var delivered bool
+			var deliveredMailbox string
+			tmpl := *a.d.m
+			for i, sd := range deliveries {
+				// Additional deliveries by a sieve script get a copy of the message.
+				m := a.d.m
+				if i > 0 {
+					mc := tmpl
+					m = &mc
+				}
+				sieveApplyFlags(log, m, sd.flags)
+				if !deliverMailbox(a, sd.mailbox, m) {
+					break
+				}
+				if !delivered {
+					deliveredMailbox = sd.mailbox
+				}
+				delivered = true
+			}
 
 			// Pass delivered messages to queue for DSN processing and/or hooks.
 			if delivered {
@@ -3579,9 +4540,21 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 				if err != nil {
 					log.Errorx("loading parsed part for evaluating webhook", err)
 				} else {
-					err = queue.Incoming(context.Background(), log, a.d.acc, messageID, *a.d.m, part, a.mailbox)
+					err = queue.Incoming(context.Background(), log, a.d.acc, messageID, *a.d.m, part, deliveredMailbox)
 					log.Check(err, "queueing webhook for incoming delivery")
+					// Quarantined messages don't get automatic replies. A vacation action of a sieve
+					// script replaces the vacation settings of the account.
+					if !quarantined {
+						if sr != nil && sr.Vacation != nil {
+							queueSieveVacationReply(context.Background(), log, a, *c.mailFrom, part, *sr.Vacation, time.Now())
+						} else {
+							queueVacationReply(context.Background(), log, a, *c.mailFrom, part, time.Now())
+						}
+						queueRetireReply(context.Background(), log, a, *c.mailFrom, part, time.Now())
+					}
+					sendNotifications(log, a, part, time.Now())
 				}
+				forward(a, sr)
 			} else if nerr > 0 && ndelivered == 0 {
 				// Don't continue if we had an error and haven't delivered yet. If we only had
 				// quota-related errors, we keep trying for an account to deliver to.
@@ -3597,9 +4570,16 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		}
 	}
 
-	// For each recipient, do final spam analysis and delivery.
+	// For each recipient, do final spam analysis and delivery. We keep track of
+	// successful deliveries for which the sender requested a DSN through the SMTP DSN
+	// extension.
+	var dsnDelivered []recipient
 	for _, rcpt := range c.recipients {
+		n := len(deliverErrors)
 		processRecipient(rcpt)
+		if len(deliverErrors) == n && rcpt.dsnNotify("SUCCESS") {
+			dsnDelivered = append(dsnDelivered, rcpt)
+		}
 	}
 
 	// If all recipients failed to deliver, return an error.
@@ -3619,6 +4599,16 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 				major = 5
 			}
 		}
+		if major == 5 {
+			var rejected []rejectedRcpt
+			for _, e := range deliverErrors {
+				if e.code >= 500 {
+					rejected = append(rejected, rejectedRcpt{e.rcptTo, e.code, e.secode, e.errmsg})
+				}
+			}
+			c.retainRejected(ctx, rejected, msgWriter.Size, dataFile, msgFrom, envelope)
+		}
+
 		if same {
 			xsmtpErrorf(e0.code, e0.secode, !serverError, "%s", strings.Join(msgs, "\n"))
 		}
@@ -3637,51 +4627,111 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		lines = append(lines, "multiple errors")
 		xsmtpErrorf(code, secode, !serverError, "%s", strings.Join(lines, "\n"))
 	}
-	// Generate one DSN for all failed recipients.
-	if len(deliverErrors) > 0 {
+	// Generate one DSN for all failed recipients, and for successful deliveries for
+	// which the sender requested a DSN. Recipients can opt out of DSNs for failures
+	// with the NOTIFY parameter of the SMTP DSN extension. ../rfc/3461
+	var dsnFailed []deliverError
+	for _, e := range deliverErrors {
+		if e.rcpt.dsnNotify("FAILURE") {
+			dsnFailed = append(dsnFailed, e)
+		}
+	}
+	if len(dsnFailed) > 0 || len(dsnDelivered) > 0 {
+		// ORCPT is only stored as address if it parses.
+		origRecipient := func(rcpt recipient) smtp.Path {
+			if rcpt.DSNOrigRecipient == "" {
+				return smtp.Path{}
+			}
+			addr, err := smtp.ParseAddress(rcpt.DSNOrigRecipient)
+			if err != nil {
+				return smtp.Path{}
+			}
+			return addr.Path()
+		}
+
 		now := time.Now()
+		var rcptDomain dns.IPDomain
+		subject := "mail delivery failure"
+		if len(dsnFailed) > 0 {
+			rcptDomain = dsnFailed[0].rcptTo.IPDomain
+		} else {
+			rcptDomain = dsnDelivered[0].Addr.IPDomain
+			subject = "mail delivered"
+		}
 		dsnMsg := dsn.Message{
 			SMTPUTF8:   c.msgsmtputf8,
-			From:       smtp.Path{Localpart: "postmaster", IPDomain: deliverErrors[0].rcptTo.IPDomain},
+			From:       smtp.Path{Localpart: "postmaster", IPDomain: rcptDomain},
 			To:         *c.mailFrom,
-			Subject:    "mail delivery failure",
+			Subject:    subject,
 			MessageID:  mox.MessageIDGen(false),
 			References: messageID,
 
 			// Per-message details.
-			ReportingMTA:    mox.Conf.Static.HostnameDomain.ASCII,
-			ReceivedFromMTA: smtp.Ehlo{Name: c.hello, ConnIP: c.remoteIP},
-			ArrivalDate:     now,
+			OriginalEnvelopeID: c.dsnEnvID,
+			ReportingMTA:       mox.Conf.Static.HostnameDomain.ASCII,
+			ReceivedFromMTA:    smtp.Ehlo{Name: c.hello, ConnIP: c.remoteIP},
+			ArrivalDate:        now,
 		}
 
-		if len(deliverErrors) > 1 {
+		if len(dsnFailed) > 1 {
 			dsnMsg.TextBody = "Multiple delivery failures occurred.\n\n"
 		}
 
-		for _, e := range deliverErrors {
+		for _, e := range dsnFailed {
 			kind := "Permanent"
 			if e.code/100 == 4 {
 				kind = "Transient"
 			}
-			dsnMsg.TextBody += fmt.Sprintf("%s delivery failure to:\n\n\t%s\n\nError:\n\n\t%s\n\n", kind, e.errmsg, e.rcptTo.XString(false))
+			dsnMsg.TextBody += fmt.Sprintf("%s delivery failure to:\n\n\t%s\n\nError:\n\n\t%s\n\n", kind, e.rcptTo.XString(false), e.errmsg)
+			rcpt := dsn.Recipient{
+				FinalRecipient:    e.rcptTo,
+				OriginalRecipient: origRecipient(e.rcpt),
+				Action:            dsn.Failed,
+				Status:            fmt.Sprintf("%d.%s", e.code/100, e.secode),
+				LastAttemptDate:   now,
+			}
+			dsnMsg.Recipients = append(dsnMsg.Recipients, rcpt)
+		}
+		for _, r := range dsnDelivered {
+			dsnMsg.TextBody += fmt.Sprintf("Delivered to:\n\n\t%s\n\n", r.Addr.XString(false))
 			rcpt := dsn.Recipient{
-				FinalRecipient:  e.rcptTo,
-				Action:          dsn.Failed,
-				Status:          fmt.Sprintf("%d.%s", e.code/100, e.secode),
-				LastAttemptDate: now,
+				FinalRecipient:    r.Addr,
+				OriginalRecipient: origRecipient(r),
+				Action:            dsn.Delivered,
+				Status:            "2.0.0",
+				LastAttemptDate:   now,
 			}
 			dsnMsg.Recipients = append(dsnMsg.Recipients, rcpt)
 		}
 
-		header, err := message.ReadHeaders(bufio.NewReader(&moxio.AtReader{R: dataFile}))
-		if err != nil {
-			c.log.Errorx("reading headers of incoming message for dsn, continuing dsn without headers", err)
+		// Return the full message for failures if requested with the RET parameter, but
+		// not for messages with REQUIRETLS, and not for large messages. ../rfc/8689:379
+		if len(dsnFailed) > 0 && c.dsnRet == "FULL" && (c.requireTLS == nil || !*c.requireTLS) && msgWriter.Size <= 100*1024 {
+			buf, err := io.ReadAll(&moxio.AtReader{R: dataFile})
+			if err != nil {
+				c.log.Errorx("reading incoming message for dsn, continuing dsn without message", err)
+			} else {
+				dsnMsg.Original = buf
+				dsnMsg.OriginalFull = true
+			}
+		} else {
+			header, err := message.ReadHeaders(bufio.NewReader(&moxio.AtReader{R: dataFile}))
+			if err != nil {
+				c.log.Errorx("reading headers of incoming message for dsn, continuing dsn without headers", err)
+			}
+			dsnMsg.Original = header
 		}
-		dsnMsg.Original = header
 
+		// If the sender address was not verified with SPF, it may be forged, e.g. by
+		// spammers, and we don't send a DSN to prevent backscatter. Messages with multiple
+		// recipients are only accepted with an SPF pass, but we don't rely on that.
 		if Localserve {
 			c.log.Error("not queueing dsn for incoming delivery due to localserve")
-		} else if err := queueDSN(context.TODO(), c.log, c, *c.mailFrom, dsnMsg, c.requireTLS != nil && *c.requireTLS); err != nil {
+		} else if mailFromValidation != store.ValidationPass {
+			c.log.Info("not queueing dsn for incoming delivery for unverified sender, to prevent backscatter", slog.Any("mailfrom", *c.mailFrom))
+		} else if err := queueDSN(context.TODO(), c.log, c, *c.mailFrom, dsnMsg, c.requireTLS != nil && *c.requireTLS); err != nil && errors.Is(err, queue.ErrDSNSuppressed) {
+			c.log.Infox("not queueing dsn for incoming delivery", err)
+		} else if err != nil {
 			metricServerErrors.WithLabelValues("queuedsn").Inc()
 			c.log.Errorx("queuing DSN for incoming delivery, no DSN sent", err)
 		}
@@ -3693,6 +4743,38 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 	c.xwritecodeline(smtp.C250Completed, smtp.SeMailbox2Other0, "it is done", nil)
 }
 
+// queueForward queues copies of a delivered (or discarded) message to the
+// forwardTo addresses, of the matching ruleset or sieve script redirects of a,
+// with the address the message was delivered to as sender. DSNs are not
+// forwarded, to prevent bounce loops.
+func queueForward(log mlog.Log, a analysis, forwardTo []string, why string, dataFile *os.File, prefix []byte, size int64, has8bit, smtputf8 bool, messageID string) {
+	if a.d.m.DSN {
+		log.Info("not forwarding dsn due to " + why)
+		return
+	}
+	var qml []queue.Msg
+	for _, s := range forwardTo {
+		addr, err := smtp.ParseAddress(s)
+		if err != nil {
+			// Addresses of rulesets are checked during config validation.
+			log.Errorx("parsing forward address of "+why, err, slog.String("address", s))
+			continue
+		}
+		qm := queue.MakeMsg(a.d.deliverTo, addr.Path(), has8bit, smtputf8, size, messageID, prefix, nil, time.Now(), "")
+		qm.IsForward = true
+		qml = append(qml, qm)
+	}
+	if len(qml) == 0 {
+		return
+	}
+	if err := queue.Add(context.Background(), log, a.d.acc.Name, dataFile, qml...); err != nil {
+		log.Errorx("queueing message for forwarding due to "+why, err)
+		metricServerErrors.WithLabelValues("queueforward").Inc()
+		return
+	}
+	log.Info("message queued for forwarding due to "+why, slog.Any("forwardto", forwardTo))
+}
+
 // Return whether msgFrom address is allowed to send a message to alias.
 func aliasAllowedMsgFrom(alias config.Alias, msgFrom smtp.Address) bool {
 	for _, aa := range alias.ParsedAddresses {
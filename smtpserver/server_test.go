@@ -4,6 +4,7 @@ package smtpserver
 // todo: test delivering a message to multiple recipients, and with some of them failing.
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/ed25519"
@@ -13,26 +14,33 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"math/big"
 	"mime/quotedprintable"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/canary"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/imapurl"
+	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/rejectdb"
 	"github.com/mjl-/mox/sasl"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/smtpclient"
@@ -83,24 +91,32 @@ test email, unique.
 `, "\n", "\r\n")
 
 type testserver struct {
-	t            *testing.T
-	acc          *store.Account
-	switchStop   func()
-	comm         *store.Comm
-	cid          int64
-	resolver     dns.Resolver
-	auth         func(mechanisms []string, cs *tls.ConnectionState) (sasl.Client, error)
-	user, pass   string
-	immediateTLS bool
-	serverConfig *tls.Config
-	clientConfig *tls.Config
-	clientCert   *tls.Certificate // Passed to smtpclient for starttls authentication.
-	submission   bool
-	requiretls   bool
-	dnsbls       []dns.Domain
-	tlsmode      smtpclient.TLSMode
-	tlspkix      bool
-	xops         webops.XOps
+	t               *testing.T
+	acc             *store.Account
+	switchStop      func()
+	comm            *store.Comm
+	cid             int64
+	resolver        dns.Resolver
+	auth            func(mechanisms []string, cs *tls.ConnectionState) (sasl.Client, error)
+	user, pass      string
+	immediateTLS    bool
+	serverConfig    *tls.Config
+	clientConfig    *tls.Config
+	clientCert      *tls.Certificate // Passed to smtpclient for starttls authentication.
+	submission      bool
+	requiretls      bool
+	noPlaintextAuth bool
+	dnsbls          []dns.Domain
+	dnsblWeights    map[dns.Domain]float64
+	dnsblThreshold  float64
+	uribls          []dns.Domain
+	maxRcpts        int
+	maxRcptsConn    int
+	policyHook      *config.PolicyHook
+	milters         []config.Milter
+	tlsmode         smtpclient.TLSMode
+	tlspkix         bool
+	xops            webops.XOps
 }
 
 const password0 = "te\u0301st \u00a0\u2002\u200a" // NFD and various unicode spaces.
@@ -140,7 +156,7 @@ func newTestServer(t *testing.T, configPath string, resolver dns.Resolver) *test
 	// Ensure session keys, for tests that check resume and authentication.
 	ctx, cancel := context.WithCancel(ctxbg)
 	defer cancel()
-	mox.StartTLSSessionTicketKeyRefresher(ctx, log, ts.serverConfig)
+	mox.StartTLSSessionTicketKeyRefresher(ctx, log, ts.serverConfig, 0)
 
 	mox.Context = ctxbg
 	mox.ConfigStaticPath = configPath
@@ -152,6 +168,8 @@ func newTestServer(t *testing.T, configPath string, resolver dns.Resolver) *test
 	tcheck(t, err, "dmarcdb init")
 	err = tlsrptdb.Init()
 	tcheck(t, err, "tlsrptdb init")
+	err = rejectdb.Init()
+	tcheck(t, err, "rejectdb init")
 	err = store.Init(ctxbg)
 	tcheck(t, err, "store init")
 
@@ -177,6 +195,8 @@ func (ts *testserver) close() {
 	tcheck(ts.t, err, "dmarcdb close")
 	err = tlsrptdb.Close()
 	tcheck(ts.t, err, "tlsrptdb close")
+	err = rejectdb.Close()
+	tcheck(ts.t, err, "rejectdb close")
 	ts.comm.Unregister()
 	queue.Shutdown()
 	err = ts.acc.Close()
@@ -257,7 +277,7 @@ func (ts *testserver) runRaw(fn func(clientConn net.Conn)) {
 	defer func() { <-serverdone }()
 
 	go func() {
-		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, ts.serverConfig, serverConn, ts.resolver, ts.submission, ts.immediateTLS, false, false, 100<<20, false, false, ts.requiretls, ts.dnsbls, 0)
+		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, ts.serverConfig, serverConn, ts.resolver, ts.submission, ts.immediateTLS, false, false, ts.noPlaintextAuth, 100<<20, false, false, ts.requiretls, ts.dnsbls, ts.dnsblWeights, ts.dnsblThreshold, ts.uribls, 0, ts.maxRcpts, ts.maxRcptsConn, ts.policyHook, ts.milters)
 		close(serverdone)
 	}()
 
@@ -399,6 +419,59 @@ func TestSubmission(t *testing.T) {
 		testAuth(fn, "disabled@mox.example", "bogus", &smtpclient.Error{Code: smtp.C535AuthBadCreds, Secode: smtp.SePol7AuthBadCreds8})
 	}
 
+	// API tokens can be used instead of the password with PLAIN and LOGIN, but only
+	// with the send scope.
+	sendToken, err := store.APITokenAdd(ctxbg, &store.APIToken{Account: "mjl", Name: "send", Scope: store.APITokenScopeSend})
+	tcheck(t, err, "add api token")
+	readToken, err := store.APITokenAdd(ctxbg, &store.APIToken{Account: "mjl", Name: "read", Scope: store.APITokenScopeRead})
+	tcheck(t, err, "add api token")
+	for _, fn := range authfns[:2] {
+		testAuth(fn, "mjl@mox.example", sendToken, nil)
+		testAuth(fn, "mjl@mox.example", sendToken+"x", &smtpclient.Error{Code: smtp.C535AuthBadCreds, Secode: smtp.SePol7AuthBadCreds8})
+		testAuth(fn, "mjl@mox.example", readToken, &smtpclient.Error{Code: smtp.C535AuthBadCreds, Secode: smtp.SePol7AuthBadCreds8})
+	}
+
+	// With plaintext authentication disabled, PLAIN, LOGIN and CRAM-MD5 are refused.
+	ts.noPlaintextAuth = true
+	for i, fn := range authfns {
+		var expErr *smtpclient.Error
+		if i == 0 {
+			expErr = &smtpclient.Error{Code: smtp.C504ParamNotImpl, Secode: smtp.SeProto5BadParams4}
+		} else if i < 3 {
+			// Client doesn't parse enhanced status codes for mechanisms without initial response.
+			expErr = &smtpclient.Error{Code: smtp.C504ParamNotImpl}
+		}
+		testAuth(fn, "mjl@mox.example", password0, expErr)
+	}
+	ts.noPlaintextAuth = false
+
+	// OAuth bearer tokens.
+	token, err := store.OAuthTokenAdd(ctxbg, &store.OAuthToken{Account: "mjl", Name: "test", LoginAddress: "mjl@mox.example"})
+	tcheck(t, err, "add oauth token")
+	oauthfns := []func(user, token string, cs *tls.ConnectionState) sasl.Client{
+		func(user, token string, cs *tls.ConnectionState) sasl.Client {
+			return sasl.NewClientOAuthBearer(user, token)
+		},
+		func(user, token string, cs *tls.ConnectionState) sasl.Client {
+			return sasl.NewClientXOAuth2(user, token)
+		},
+	}
+	for _, fn := range oauthfns {
+		testAuth(fn, "mjl@mox.example", token, nil)
+		// Server responds with error details in continuation, which the client doesn't expect.
+		testAuth(fn, "mjl@mox.example", token+"x", &smtpclient.Error{Code: smtp.C334ContinueAuth})
+		testAuth(fn, "móx@mox.example", token, &smtpclient.Error{Code: smtp.C334ContinueAuth}) // Token for other address.
+	}
+	testAuth(oauthfns[0], "", token, nil) // Authorization identity is optional.
+
+	// Submission disabled for account.
+	accConf := mox.Conf.Dynamic.Accounts["mjl"]
+	accConf.SubmissionDisabled = "account frozen"
+	mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	testAuth(authfns[0], "mjl@mox.example", password0, &smtpclient.Error{Code: smtp.C550MailboxUnavail, Secode: smtp.SePol7DeliveryUnauth1})
+	accConf.SubmissionDisabled = ""
+	mox.Conf.Dynamic.Accounts["mjl"] = accConf
+
 	// Create a certificate, register its public key with account, and make a tls
 	// client config that sends the certificate.
 	clientCert0 := fakeCert(ts.t, true)
@@ -476,7 +549,7 @@ func TestSubmission(t *testing.T) {
 		tlsConfig := &tls.Config{
 			Certificates: []tls.Certificate{fakeCert(ts.t, false)},
 		}
-		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, tlsConfig, serverConn, ts.resolver, ts.submission, ts.immediateTLS, false, false, 100<<20, false, false, false, ts.dnsbls, 0)
+		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, tlsConfig, serverConn, ts.resolver, ts.submission, ts.immediateTLS, false, false, false, 100<<20, false, false, false, ts.dnsbls, ts.dnsblWeights, ts.dnsblThreshold, ts.uribls, 0, 0, 0, nil, nil)
 		close(serverdone)
 	}()
 
@@ -641,6 +714,553 @@ func TestDelivery(t *testing.T) {
 	checkEvaluationCount(t, 0)
 }
 
+// Test rejection of looping messages, by number of Received headers and by
+// earlier deliveries through this host.
+func TestLoopDetect(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	deliver := func(received []string, expErr *smtpclient.Error) {
+		t.Helper()
+		var hdrs string
+		for _, s := range received {
+			hdrs += "Received: " + s + "\r\n"
+		}
+		msg := hdrs + deliverMessage
+		ts.run(func(client *smtpclient.Client) {
+			err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+			ts.smtpErr(err, expErr)
+		})
+	}
+	loopErr := &smtpclient.Error{Permanent: true, Code: smtp.C554TransactionFailed, Secode: smtp.SeNet4Loop6}
+
+	other := "from remote.example (remote.example [10.0.0.1]) by mx.example.org with ESMTPS id abc; Mon, 1 Jan 2024 00:00:00 +0000"
+	own := func(cid int64, with string) string {
+		return fmt.Sprintf("from remote.example ([10.0.0.1]) by mox.example ([127.0.0.1]) via tcp with %s (using TLS1.3 with cipher TLS_AES_128_GCM_SHA256) id %s for <mjl@mox.example>; Mon, 1 Jan 2024 00:00:00 +0000", with, mox.ReceivedID(cid))
+	}
+
+	deliver([]string{other, own(1, "ESMTPS")}, nil)
+	deliver([]string{own(1, "ESMTPSA"), own(2, "ESMTPSA"), own(3, "ESMTP")}, nil)
+	deliver([]string{own(1, "ESMTPS"), other, own(2, "ESMTP")}, loopErr)
+
+	var many []string
+	for range 101 {
+		many = append(many, other)
+	}
+	deliver(many, loopErr)
+	deliver(many[:100], nil)
+}
+
+// Test the actions of rulesets: discard, forward and mark seen.
+func TestRulesetActions(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	deliver := func(listID string) {
+		t.Helper()
+		msg := strings.ReplaceAll(deliverMessage, "To: <mjl@mox.example>", "To: <rulesets@mox.example>\r\nList-Id: <"+listID+">")
+		ts.run(func(client *smtpclient.Client) {
+			err := client.Deliver(ctxbg, "remote@example.org", "rulesets@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+			tcheck(t, err, "deliver")
+		})
+	}
+
+	// Discarded, and forwarded.
+	deliver("discard.lists.example.org")
+	ts.checkCount("Inbox", 0)
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "queue list")
+	tcompare(t, len(msgs), 1)
+	tcompare(t, msgs[0].Sender().String(), "rulesets@mox.example")
+	tcompare(t, msgs[0].Recipient().String(), "forward@example.org")
+	tcompare(t, strings.HasPrefix(string(msgs[0].MsgPrefix), "Delivered-To: rulesets@mox.example\r\n"), true)
+
+	// Delivered to mailbox, and marked seen.
+	deliver("seen.lists.example.org")
+	ts.checkCount("Lists", 1)
+	m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).FilterEqual("Expunged", false).Get()
+	tcheck(t, err, "get message")
+	tcompare(t, m.Seen, true)
+}
+
+// Test forwarding by destinations with SRS, and relaying DSNs for SRS addresses
+// to the original sender.
+func TestSRSForward(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	deliver := func(mailFrom, rcptTo, msg string, expErr *smtpclient.Error) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			err := client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(msg)), strings.NewReader(msg), false, false, false)
+			ts.smtpErr(err, expErr)
+		})
+	}
+
+	// Forwarded with srs sender, not delivered locally.
+	msg := strings.ReplaceAll(deliverMessage, "To: <mjl@mox.example>", "To: <forwarded@mox.example>")
+	deliver("remote@example.org", "forwarded@mox.example", msg, nil)
+	ts.checkCount("Inbox", 0)
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "queue list")
+	tcompare(t, len(msgs), 1)
+	sender := msgs[0].Sender()
+	tcompare(t, strings.HasPrefix(string(sender.Localpart), "SRS0="), true)
+	tcompare(t, sender.IPDomain.Domain.ASCII, "mox.example")
+	tcompare(t, msgs[0].Recipient().String(), "forward@example.org")
+	tcompare(t, msgs[0].IsForward, true)
+	tcompare(t, strings.HasPrefix(string(msgs[0].MsgPrefix), "Delivered-To: forwarded@mox.example\r\n"), true)
+
+	// Forwarded and kept.
+	msg = strings.ReplaceAll(deliverMessage, "To: <mjl@mox.example>", "To: <forwardedcopy@mox.example>")
+	deliver("remote@example.org", "forwardedcopy@mox.example", msg, nil)
+	ts.checkCount("Inbox", 1)
+	n, err := queue.Count(ctxbg)
+	tcheck(t, err, "queue count")
+	tcompare(t, n, 2)
+
+	// DSN to the srs address is queued for the original sender, with null reverse path.
+	deliver("", sender.String(), deliverMessage, nil)
+	msgs, err = queue.List(ctxbg, queue.Filter{}, queue.Sort{Field: "Queued", Asc: true})
+	tcheck(t, err, "queue list")
+	tcompare(t, len(msgs), 3)
+	tcompare(t, msgs[2].Sender().IsZero(), true)
+	tcompare(t, msgs[2].Recipient().String(), "remote@example.org")
+
+	// Invalid srs address is treated like an unknown user.
+	bad := strings.Replace(sender.String(), "SRS0=", "SRS0=x", 1)
+	deliver("", bad, deliverMessage, &smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SeAddr1UnknownDestMailbox1})
+
+	// Message that was already forwarded for the address, e.g. coming back from the
+	// forward address, is delivered locally instead of forwarded again.
+	msg = "Delivered-To: forwarded@mox.example\r\n" + strings.ReplaceAll(deliverMessage, "To: <mjl@mox.example>", "To: <forwarded@mox.example>")
+	deliver("forward@example.org", "forwarded@mox.example", msg, nil)
+	ts.checkCount("Inbox", 2)
+	n, err = queue.Count(ctxbg)
+	tcheck(t, err, "queue count")
+	tcompare(t, n, 3)
+}
+
+// Test mailing lists: subscribing through the request address, posting, holding
+// messages for moderation, and DSNs for list messages.
+func TestList(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		TXT: map[string][]string{
+			"example.org.": {"v=spf1 ip4:127.0.0.10 -all"},
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	const list = "discuss@mox.example"
+
+	deliver := func(mailFrom, rcptTo, subject string, expErr *smtpclient.Error) {
+		t.Helper()
+		msg := strings.ReplaceAll(fmt.Sprintf(`From: <%s>
+To: <%s>
+Subject: %s
+Message-Id: <%d@example.org>
+
+test email
+`, mailFrom, rcptTo, subject, time.Now().UnixNano()), "\n", "\r\n")
+		ts.run(func(client *smtpclient.Client) {
+			err := client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(msg)), strings.NewReader(msg), false, false, false)
+			ts.smtpErr(err, expErr)
+		})
+	}
+
+	queueList := func(expect int) []queue.Msg {
+		t.Helper()
+		msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{Field: "Queued", Asc: true})
+		tcheck(t, err, "queue list")
+		tcompare(t, len(msgs), expect)
+		return msgs
+	}
+
+	queueDrop := func() {
+		t.Helper()
+		_, err := queue.Drop(ctxbg, pkglog, queue.Filter{})
+		tcheck(t, err, "drop queue")
+	}
+
+	pending := func(kind store.ListPendingKind) store.ListPending {
+		t.Helper()
+		p, err := bstore.QueryDB[store.ListPending](ctxbg, store.AuthDB).FilterNonzero(store.ListPending{Kind: kind}).Get()
+		tcheck(t, err, "get pending list request")
+		return p
+	}
+
+	// Subscribing requires confirmation.
+	deliver("sub@example.org", "discuss-request@mox.example", "subscribe", nil)
+	msgs := queueList(1)
+	tcompare(t, msgs[0].Sender().IsZero(), true)
+	tcompare(t, msgs[0].Recipient().String(), "sub@example.org")
+	p := pending(store.ListPendingSubscribe)
+	tcompare(t, msgs[0].Subject, "confirm "+p.Token)
+	subscribed, err := store.ListSubscribed(ctxbg, list, "sub@example.org")
+	tcheck(t, err, "list subscribed")
+	tcompare(t, subscribed, false)
+	queueDrop()
+
+	deliver("sub@example.org", "discuss-request@mox.example", "Re: confirm "+p.Token, nil)
+	subscribed, err = store.ListSubscribed(ctxbg, list, "sub@example.org")
+	tcheck(t, err, "list subscribed")
+	tcompare(t, subscribed, true)
+	queueDrop()
+
+	// Token can only be used once.
+	_, err = store.ListPendingTake(ctxbg, list, p.Token, store.ListPendingSubscribe)
+	tcompare(t, errors.Is(err, store.ErrListPendingUnknown), true)
+
+	// Post from verified subscriber is sent to subscribers, with list headers.
+	deliver("sub@example.org", list, "hello", nil)
+	msgs = queueList(1)
+	tcompare(t, msgs[0].Sender().String(), "discuss-request@mox.example")
+	tcompare(t, msgs[0].Recipient().String(), "sub@example.org")
+	tcompare(t, msgs[0].Hold, false)
+	tcompare(t, msgs[0].Subject, "[discuss] hello")
+	f, err := queue.OpenMessage(ctxbg, msgs[0].ID)
+	tcheck(t, err, "open message in queue")
+	buf, err := io.ReadAll(f)
+	tcheck(t, err, "read queued message")
+	err = f.Close()
+	tcheck(t, err, "close message")
+	for _, s := range []string{"List-Id: \"Discussion\" <discuss.mox.example>\r\n", "Subject: [discuss] hello\r\n", "Precedence: list\r\n", "\r\n\r\ntest email\r\n"} {
+		if !strings.Contains(string(buf), s) {
+			t.Fatalf("queued list message %q does not contain %q", buf, s)
+		}
+	}
+	queueDrop()
+
+	// Message coming back to the list is a loop.
+	loopMsg := strings.ReplaceAll(string(buf), "\r\nSubject:", "\r\nX-Test: loop\r\nSubject:")
+	ts.run(func(client *smtpclient.Client) {
+		err := client.Deliver(ctxbg, "sub@example.org", list, int64(len(loopMsg)), strings.NewReader(loopMsg), false, false, false)
+		ts.smtpErr(err, &smtpclient.Error{Permanent: true, Code: smtp.C554TransactionFailed, Secode: smtp.SeNet4Loop6})
+	})
+	queueList(0)
+
+	// Post from non-subscriber is held, and the owner is notified.
+	ts.checkCount("Inbox", 0)
+	deliver("other@example.org", list, "spam", nil)
+	msgs = queueList(1)
+	tcompare(t, msgs[0].Hold, true)
+	ts.checkCount("Inbox", 1)
+
+	// Owner approves the message, it is released from the queue.
+	p = pending(store.ListPendingPost)
+	tcompare(t, p.QueueMsgIDs, []int64{msgs[0].ID})
+	deliver("owner@example.org", "discuss-request@mox.example", "approve "+p.Token, nil)
+	msgs = queueList(2)
+	tcompare(t, msgs[0].Hold, false)
+	tcompare(t, msgs[1].Recipient().String(), "owner@example.org")
+	queueDrop()
+
+	// Posts to a moderated list are always held.
+	_, err = store.ListSubscribe(ctxbg, "moderated@mox.example", "sub@example.org")
+	tcheck(t, err, "subscribe")
+	deliver("sub@example.org", "moderated@mox.example", "hi", nil)
+	msgs = queueList(1)
+	tcompare(t, msgs[0].Hold, true)
+	ts.checkCount("Inbox", 2)
+	p = pending(store.ListPendingPost)
+	deliver("owner@example.org", "moderated-request@mox.example", "reject "+p.Token, nil)
+	msgs = queueList(1)
+	tcompare(t, msgs[0].Recipient().String(), "owner@example.org")
+	queueDrop()
+
+	// Unsubscribe with verified address is done immediately.
+	deliver("sub@example.org", "discuss-request@mox.example", "unsubscribe", nil)
+	subscribed, err = store.ListSubscribed(ctxbg, list, "sub@example.org")
+	tcheck(t, err, "list subscribed")
+	tcompare(t, subscribed, false)
+	queueDrop()
+
+	// DSN for a list message is delivered to the owner.
+	deliver("", "discuss-request@mox.example", "delivery failure", nil)
+	queueList(0)
+	ts.checkCount("Inbox", 3)
+}
+
+// Test probes of canaries are registered instead of delivered.
+func TestCanary(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+			"mox.example.": {"127.0.0.10"}, // For mx check of probe sender.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	deliver := func(mailFrom, msg string) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			err := client.Deliver(ctxbg, mailFrom, "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+			ts.smtpErr(err, nil)
+		})
+	}
+
+	c := mox.Conf.DynamicConfig().Canaries["selftest"]
+	token, err := canary.Probe(ctxbg, pkglog, "selftest", c)
+	tcheck(t, err, "sending probe")
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "queue list")
+	tcompare(t, len(msgs), 1)
+	tcompare(t, msgs[0].Sender().String(), "mjl@mox.example")
+	f, err := queue.OpenMessage(ctxbg, msgs[0].ID)
+	tcheck(t, err, "open message in queue")
+	buf, err := io.ReadAll(f)
+	tcheck(t, err, "read queued message")
+	err = f.Close()
+	tcheck(t, err, "close message")
+	tcompare(t, strings.Contains(string(buf), canary.Header+": "+token+"\r\n"), true)
+
+	// Probe is not delivered to the mailbox.
+	deliver("mjl@mox.example", string(buf))
+	ts.checkCount("Inbox", 0)
+
+	// Token can only be used once, another message with it is delivered normally.
+	msg := strings.ReplaceAll(deliverMessage, "Subject: test", canary.Header+": "+token+"\r\nSubject: test")
+	deliver("remote@example.org", msg)
+	ts.checkCount("Inbox", 1)
+
+	// Reply from an echo service, with the token in the subject.
+	token, err = canary.Probe(ctxbg, pkglog, "selftest", c)
+	tcheck(t, err, "sending probe")
+	msg = strings.ReplaceAll(deliverMessage, "Subject: test", "Subject: Re: mox canary "+token)
+	deliver("remote@example.org", msg)
+	ts.checkCount("Inbox", 1)
+}
+
+// Test automatic vacation replies are queued once per sender, and not for
+// mailing list messages.
+func TestVacation(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	err := ts.acc.VacationSave(ctxbg, store.Vacation{Active: true, Subject: "Out of office", Body: "Back next week.\n"})
+	tcheck(t, err, "save vacation")
+
+	deliver := func(msg string) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+			tcheck(t, err, "deliver")
+		})
+	}
+
+	checkQueued := func(exp int) {
+		t.Helper()
+		n, err := queue.Count(ctxbg)
+		tcheck(t, err, "queue count")
+		tcompare(t, n, exp)
+	}
+
+	// Mailing list message does not get a reply.
+	deliver(strings.ReplaceAll(deliverMessage, "To: <mjl@mox.example>", "To: <mjl@mox.example>\r\nList-Id: <test.lists.example.org>"))
+	checkQueued(0)
+
+	deliver(deliverMessage)
+	checkQueued(1)
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "queue list")
+	tcompare(t, msgs[0].Sender().IsZero(), true)
+	tcompare(t, msgs[0].Recipient().String(), "remote@example.org")
+	tcompare(t, msgs[0].Subject, "Out of office")
+
+	// Second message from same sender does not get another reply.
+	deliver(deliverMessage)
+	checkQueued(1)
+}
+
+// TestSieve checks the active sieve script of an account is evaluated during
+// delivery.
+func TestSieve(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	script := `require ["fileinto", "copy", "imap4flags", "reject", "vacation", "variables"];
+if header :matches "subject" "list *" {
+	fileinto :copy :flags "\\Seen $label" "Lists/${1}";
+} elsif header :is "subject" "reject" {
+	reject "not interested";
+	stop;
+} elsif header :is "subject" "discard" {
+	redirect "other@example.org";
+	stop;
+}
+vacation :subject "Away" "I'm away.";
+`
+	err := ts.acc.DB.Insert(ctxbg, &store.SieveScript{Name: "test", Script: script, Active: true})
+	tcheck(t, err, "insert sieve script")
+
+	deliver := func(subject string) error {
+		t.Helper()
+		msg := strings.ReplaceAll(deliverMessage, "Subject: test", "Subject: "+subject)
+		var err error
+		ts.run(func(client *smtpclient.Client) {
+			err = client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+		})
+		return err
+	}
+	queued := func() []queue.Msg {
+		t.Helper()
+		msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{Field: "Queued", Asc: true})
+		tcheck(t, err, "queue list")
+		return msgs
+	}
+
+	// Delivered to Inbox, and a copy to a mailbox with flags. And a vacation reply.
+	err = deliver("list mox")
+	tcheck(t, err, "deliver")
+	ts.checkCount("Inbox", 1)
+	ts.checkCount("Lists/mox", 1)
+	mb, err := bstore.QueryDB[store.Mailbox](ctxbg, ts.acc.DB).FilterNonzero(store.Mailbox{Name: "Lists/mox"}).Get()
+	tcheck(t, err, "get mailbox")
+	m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).FilterNonzero(store.Message{MailboxID: mb.ID}).Get()
+	tcheck(t, err, "get message")
+	tcompare(t, m.Seen, true)
+	tcompare(t, m.Keywords, []string{"$label"})
+	msgs := queued()
+	tcompare(t, len(msgs), 1)
+	tcompare(t, msgs[0].Sender().IsZero(), true)
+	tcompare(t, msgs[0].Subject, "Away")
+
+	// Rejected during SMTP transaction.
+	err = deliver("reject")
+	var cerr smtpclient.Error
+	if !errors.As(err, &cerr) || cerr.Code != smtp.C550MailboxUnavail || !strings.Contains(cerr.Line, "not interested") {
+		t.Fatalf("got err %v, expected rejection by sieve script", err)
+	}
+	ts.checkCount("Inbox", 1)
+
+	// Redirected, not kept, and no vacation reply due to stop.
+	err = deliver("discard")
+	tcheck(t, err, "deliver")
+	ts.checkCount("Inbox", 1)
+	msgs = queued()
+	tcompare(t, len(msgs), 2)
+	tcompare(t, msgs[1].Sender().String(), "mjl@mox.example")
+	tcompare(t, msgs[1].Recipient().String(), "other@example.org")
+}
+
+// TestDomainRetire checks messages for a retired domain get an automatic reply
+// during the notice period, and are rejected after.
+func TestDomainRetire(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	retire := config.DomainRetire{
+		NewDomainParsed: dns.Domain{ASCII: "example.net"},
+		RejectAfter:     "2100-01-01",
+		RejectAfterTime: time.Now().Add(time.Hour),
+	}
+	dom, _ := mox.Conf.Domain(dns.Domain{ASCII: "mox.example"})
+	dom.Retire = &retire
+	mox.Conf.Dynamic.Domains["mox.example"] = dom
+	defer func() {
+		dom.Retire = nil
+		mox.Conf.Dynamic.Domains["mox.example"] = dom
+	}()
+
+	deliver := func(expErr *smtpclient.Error) error {
+		t.Helper()
+		var rerr error
+		ts.run(func(client *smtpclient.Client) {
+			rerr = client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+			ts.smtpErr(rerr, expErr)
+		})
+		return rerr
+	}
+
+	// During the notice period, messages are delivered and the sender gets a single
+	// reply.
+	deliver(nil)
+	deliver(nil)
+	ts.checkCount("Inbox", 2)
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "queue list")
+	tcompare(t, len(msgs), 1)
+	tcompare(t, msgs[0].Sender().IsZero(), true)
+	tcompare(t, msgs[0].Recipient().String(), "remote@example.org")
+	tcompare(t, msgs[0].Subject, "Address change for mjl@mox.example")
+
+	// After the notice period, messages are rejected with a reference to the new address.
+	retire.RejectAfterTime = time.Now().Add(-time.Hour)
+	err = deliver(&smtpclient.Error{Permanent: true, Code: smtp.C551UserNotLocal, Secode: smtp.SeAddr1DestMailboxMoved6})
+	var cerr smtpclient.Error
+	if !errors.As(err, &cerr) || !strings.Contains(cerr.Line, "please try <mjl@example.net>") {
+		t.Fatalf("got err %v, expected reference to new address", err)
+	}
+
+	// Without new domain, a custom message and a 550.
+	retire.NewDomainParsed = dns.Domain{}
+	retire.Message = "domain closed"
+	err = deliver(&smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SeAddr1DestMailboxMoved6})
+	if !errors.As(err, &cerr) || !strings.Contains(cerr.Line, "domain closed") {
+		t.Fatalf("got err %v, expected custom message", err)
+	}
+	ts.checkCount("Inbox", 2)
+}
+
 func tinsertmsg(t *testing.T, acc *store.Account, mailbox string, m *store.Message, msg string) {
 	mf, err := store.CreateMessageTemp(pkglog, "insertmsg")
 	tcheck(t, err, "temp message")
@@ -746,6 +1366,23 @@ func TestSpam(t *testing.T) {
 		checkEvaluationCount(t, 0) // No positive interactions yet.
 	})
 
+	// Custom rejection text for the domain.
+	dom, _ := mox.Conf.Domain(dns.Domain{ASCII: "mox.example"})
+	dom.RejectText = &config.RejectText{
+		ContactURL: "https://mox.example/help",
+		Default:    "refused ({reason}), see {contact}",
+	}
+	mox.Conf.Dynamic.Domains["mox.example"] = dom
+	ts.run(func(client *smtpclient.Client) {
+		err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		cerr := ts.smtpErr(err, &smtpclient.Error{Permanent: false, Code: smtp.C451LocalErr, Secode: smtp.SeSys3Other0})
+		if !strings.Contains(cerr.Line, "refused (msgfromfull), see https://mox.example/help") {
+			t.Fatalf("got line %q, expected custom rejection text", cerr.Line)
+		}
+	})
+	dom.RejectText = nil
+	mox.Conf.Dynamic.Domains["mox.example"] = dom
+
 	// Delivery from sender with bad reputation matching AcceptRejectsToMailbox should
 	// result in accepted delivery to the mailbox.
 	ts.run(func(client *smtpclient.Client) {
@@ -800,6 +1437,36 @@ func TestSpam(t *testing.T) {
 		ts.smtpErr(err, &smtpclient.Error{Permanent: false, Code: smtp.C451LocalErr, Secode: smtp.SeSys3Other0})
 		checkEvaluationCount(t, 1) // No new evaluation, this isn't a DMARC reject.
 	})
+
+	// Moving the message out of the Rejects mailbox adds a sender allow entry when
+	// configured. Later messages with verified From address are then accepted.
+	accConf := mox.Conf.Dynamic.Accounts[ts.acc.Name]
+	accConf.RejectsRescueAllow = "address"
+	mox.Conf.Dynamic.Accounts[ts.acc.Name] = accConf
+	mbrej, err := bstore.QueryDB[store.Mailbox](ctxbg, ts.acc.DB).FilterNonzero(store.Mailbox{Name: "Rejects"}).Get()
+	tcheck(t, err, "get rejects mailbox")
+	rm, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).FilterNonzero(store.Message{MailboxID: mbrej.ID}).FilterEqual("Expunged", false).Get()
+	tcheck(t, err, "get rejected message")
+	ts.xops.MessageMove(ctxbg, pkglog, ts.acc, []int64{rm.ID}, "Inbox", 0)
+	sa, err := bstore.QueryDB[store.SenderAllow](ctxbg, ts.acc.DB).Get()
+	tcheck(t, err, "get sender allow entry")
+	tcompare(t, sa.Address(), "remote@example.org")
+	tcompare(t, sa.MessageID, rm.ID)
+
+	// Still rejected without verified From address.
+	ts.run(func(client *smtpclient.Client) {
+		err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		ts.smtpErr(err, &smtpclient.Error{Permanent: false, Code: smtp.C451LocalErr, Secode: smtp.SeSys3Other0})
+	})
+
+	resolver.TXT = map[string][]string{
+		"example.org.":        {"v=spf1 ip4:127.0.0.10 -all"},
+		"_dmarc.example.org.": {"v=DMARC1;p=reject"},
+	}
+	ts.run(func(client *smtpclient.Client) {
+		err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		tcheck(t, err, "deliver from allowed sender")
+	})
 }
 
 // Test accept/reject with forwarded messages, DMARC ignored, no IP/EHLO/MAIL
@@ -1077,29 +1744,409 @@ func TestBlocklistedSubjectpass(t *testing.T) {
 	})
 }
 
-// Test accepting a DMARC report.
-func TestDMARCReport(t *testing.T) {
+// Test DNSBLs with weights and a threshold.
+func TestDNSBLWeights(t *testing.T) {
 	resolver := &dns.MockResolver{
 		A: map[string][]string{
-			"example.org.": {"127.0.0.10"}, // For mx check.
+			"example.org.":               {"127.0.0.10"}, // For mx check.
+			"2.0.0.127.dnsbl.example.":   {"127.0.0.2"},  // For healthcheck.
+			"2.0.0.127.dnsbl2.example.":  {"127.0.0.2"},  // For healthcheck.
+			"10.0.0.127.dnsbl.example.":  {"127.0.0.10"}, // Where our connection pretends to come from.
+			"10.0.0.127.dnsbl2.example.": {"127.0.0.10"},
 		},
 		TXT: map[string][]string{
 			"example.org.":        {"v=spf1 ip4:127.0.0.10 -all"},
-			"_dmarc.example.org.": {"v=DMARC1;p=reject; rua=mailto:dmarcrpt@example.org"},
+			"_dmarc.example.org.": {"v=DMARC1;p=reject"},
 		},
 		PTR: map[string][]string{
 			"127.0.0.10": {"example.org."}, // For iprev check.
 		},
 	}
-	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/dmarcreport/mox.conf"), resolver)
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
 	defer ts.close()
+	zone1 := dns.Domain{ASCII: "dnsbl.example"}
+	zone2 := dns.Domain{ASCII: "dnsbl2.example"}
+	ts.dnsbls = []dns.Domain{zone1, zone2}
 
-	run := func(rcptTo, report string, n int) {
+	testDeliver := func(expErr *smtpclient.Error) {
 		t.Helper()
 		ts.run(func(client *smtpclient.Client) {
-			t.Helper()
-
-			mailFrom := "remote@example.org"
+			err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+			ts.smtpErr(err, expErr)
+		})
+	}
+
+	// Listed in both, with combined weight 1.5 below the threshold.
+	ts.dnsblThreshold = 2
+	ts.dnsblWeights = map[dns.Domain]float64{zone2: 0.5}
+	testDeliver(nil)
+
+	// Weight of first zone raised, now reaching the threshold.
+	ts.dnsblWeights[zone1] = 1.5
+	testDeliver(&smtpclient.Error{Code: smtp.C451LocalErr, Secode: smtp.SeSys3Other0})
+
+	// Zone with weight 0 is consulted, but does not contribute.
+	ts.dnsblWeights[zone2] = 0
+	testDeliver(nil)
+}
+
+// Test the greeting can be customized per listener.
+func TestGreeting(t *testing.T) {
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), dns.MockResolver{})
+	defer ts.close()
+	defer delete(mox.Conf.Static.Listeners, "test")
+
+	testGreeting := func(g *config.Greeting, exp string) {
+		t.Helper()
+		mox.Conf.Static.Listeners["test"] = config.Listener{Greeting: g}
+		ts.runRaw(func(conn net.Conn) {
+			defer conn.Close()
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			tcheck(t, err, "read greeting")
+			tcompare(t, line, exp+"\r\n")
+		})
+	}
+
+	testGreeting(nil, "220 mox.example ESMTP mox")
+	testGreeting(&config.Greeting{HideSoftware: true}, "220 mox.example ESMTP")
+	testGreeting(&config.Greeting{Hostname: "mx.example", HostnameDomain: dns.Domain{ASCII: "mx.example"}, SMTP: "ready"}, "220 mx.example ESMTP ready")
+}
+
+// Test the EHLO/HELO checks of a listener, in log and enforce mode.
+func TestHELOChecks(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"mail.example.org.": {"127.0.0.10"},
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"mail.example.org."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+	defer delete(mox.Conf.Static.Listeners, "test")
+
+	test := func(hc config.HELOChecks, hello string, expCode int) {
+		t.Helper()
+		var l config.Listener
+		l.SMTP.HELOChecks = &hc
+		mox.Conf.Static.Listeners["test"] = l
+		ts.runRaw(func(conn net.Conn) {
+			t.Helper()
+			defer conn.Close()
+			br := bufio.NewReader(conn)
+			readCode := func() int {
+				t.Helper()
+				for {
+					line, err := br.ReadString('\n')
+					tcheck(t, err, "read response")
+					if len(line) >= 4 && line[3] == ' ' {
+						code, err := strconv.Atoi(line[:3])
+						tcheck(t, err, "parse response code")
+						return code
+					}
+				}
+			}
+			readCode() // Greeting.
+			_, err := fmt.Fprintf(conn, "EHLO %s\r\n", hello)
+			tcheck(t, err, "write ehlo")
+			tcompare(t, readCode(), expCode)
+			_, err = fmt.Fprintf(conn, "QUIT\r\n")
+			tcheck(t, err, "write quit")
+			readCode()
+		})
+	}
+
+	test(config.HELOChecks{}, "localhost", 250)
+
+	test(config.HELOChecks{RequireFQDN: "log"}, "localhost", 250)
+	test(config.HELOChecks{RequireFQDN: "enforce"}, "localhost", 550)
+	test(config.HELOChecks{RequireFQDN: "enforce"}, "[127.0.0.1]", 250)
+	test(config.HELOChecks{RequireFQDN: "enforce"}, "mail.example.org", 250)
+
+	test(config.HELOChecks{RejectOwnHostname: "log"}, "mox.example", 250)
+	test(config.HELOChecks{RejectOwnHostname: "enforce"}, "mox.example", 550)
+	test(config.HELOChecks{RejectOwnHostname: "enforce"}, "[127.0.0.10]", 550) // Local IP for net.Pipe.
+	test(config.HELOChecks{RejectOwnHostname: "enforce"}, "mail.example.org", 250)
+
+	test(config.HELOChecks{RequireFCrDNS: "enforce"}, "mail.example.org", 250)
+	ts.resolver = dns.MockResolver{}
+	test(config.HELOChecks{RequireFCrDNS: "log"}, "mail.example.org", 250)
+	test(config.HELOChecks{RequireFCrDNS: "enforce"}, "mail.example.org", 550)
+}
+
+// Test XCLIENT and XFORWARD from trusted upstream servers.
+func TestXCLIENT(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10", "10.1.2.3"}, // For mx and iprev check.
+		},
+		AAAA: map[string][]string{
+			"example.org.": {"2001:db8::1"},
+		},
+		PTR: map[string][]string{
+			"127.0.0.10":  {"example.org."},
+			"10.1.2.3":    {"example.org."},
+			"2001:db8::1": {"example.org."},
+		},
+		TXT: map[string][]string{
+			"example.org.":        {"v=spf1 ip4:10.1.2.3 ip4:127.0.0.10 ip6:2001:db8::1 -all"},
+			"_dmarc.example.org.": {"v=DMARC1;p=reject"},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+	defer delete(mox.Conf.Static.Listeners, "test")
+
+	setTrusted := func(ipnet string) {
+		_, n, err := net.ParseCIDR(ipnet)
+		tcheck(t, err, "parse cidr")
+		var l config.Listener
+		l.SMTP.XCLIENT = &config.XCLIENT{TrustedNets: []*net.IPNet{n}}
+		mox.Conf.Static.Listeners["test"] = l
+	}
+
+	// Run commands, returning the last line of the response for each.
+	run := func(cmds ...string) (lines []string) {
+		t.Helper()
+		ts.runRaw(func(conn net.Conn) {
+			t.Helper()
+			defer conn.Close()
+			br := bufio.NewReader(conn)
+			readLine := func() string {
+				t.Helper()
+				var text string
+				for {
+					line, err := br.ReadString('\n')
+					tcheck(t, err, "read response")
+					text += line
+					if len(line) >= 4 && line[3] == ' ' {
+						return text
+					}
+				}
+			}
+			readLine() // Greeting.
+			for _, cmd := range cmds {
+				_, err := fmt.Fprintf(conn, "%s\r\n", cmd)
+				tcheck(t, err, "write command")
+				if cmd == "DATA" {
+					readLine()
+					_, err := fmt.Fprintf(conn, "%s.\r\n", deliverMessage)
+					tcheck(t, err, "write message")
+				}
+				lines = append(lines, readLine())
+			}
+		})
+		return
+	}
+
+	lastMessage := func() store.Message {
+		t.Helper()
+		m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).SortDesc("ID").Limit(1).Get()
+		tcheck(t, err, "get last message")
+		return m
+	}
+
+	checkCode := func(line string, code string) {
+		t.Helper()
+		if !strings.HasPrefix(line, code+" ") && !strings.HasPrefix(line, code+"-") {
+			t.Fatalf("got response %q, expected code %s", line, code)
+		}
+	}
+
+	// Local IP for net.Pipe is not trusted: not announced, commands are unknown.
+	setTrusted("10.0.0.0/8")
+	l := run("EHLO mail.example.org", "XCLIENT ADDR=10.1.2.3")
+	if strings.Contains(l[0], "XCLIENT") {
+		t.Fatalf("xclient announced to untrusted remote")
+	}
+	checkCode(l[1], "500")
+
+	setTrusted("127.0.0.0/8")
+	l = run(
+		"EHLO upstream.example.org",
+		"XCLIENT ADDR=10.1.2.3 NAME=[UNAVAILABLE] HELO=client.example.org",
+		"EHLO upstream.example.org",
+		"MAIL FROM:<remote@example.org>",
+		"RCPT TO:<mjl@mox.example>",
+		"DATA",
+	)
+	if !strings.Contains(l[0], "250-XCLIENT ADDR NAME PORT PROTO HELO\r\n") || !strings.Contains(l[0], "250-XFORWARD ") {
+		t.Fatalf("xclient/xforward not announced: %q", l[0])
+	}
+	checkCode(l[1], "220")
+	checkCode(l[2], "250")
+	checkCode(l[5], "250")
+	m := lastMessage()
+	tcompare(t, m.RemoteIP, "10.1.2.3")
+	tcompare(t, m.EHLODomain, "client.example.org")
+
+	// XFORWARD is for the next transaction only.
+	l = run(
+		"EHLO upstream.example.org",
+		"XFORWARD ADDR=IPV6:2001:db8::1 HELO=client.example.org",
+		"MAIL FROM:<remote@example.org>",
+		"RCPT TO:<mjl@mox.example>",
+		"DATA",
+		"MAIL FROM:<remote@example.org>",
+		"RCPT TO:<mjl@mox.example>",
+		"DATA",
+	)
+	checkCode(l[1], "250")
+	checkCode(l[4], "250")
+	checkCode(l[7], "250")
+	m = lastMessage()
+	tcompare(t, m.RemoteIP, "127.0.0.10")
+	tcompare(t, m.EHLODomain, "upstream.example.org")
+	m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).FilterEqual("RemoteIP", "2001:db8::1").Get()
+	tcheck(t, err, "get message with xforward ip")
+	tcompare(t, m.EHLODomain, "client.example.org")
+
+	// Not during transaction, and bad syntax.
+	l = run(
+		"EHLO upstream.example.org",
+		"XFORWARD BOGUS=1",
+		"XFORWARD ADDR=bogus",
+		"MAIL FROM:<remote@example.org>",
+		"XFORWARD ADDR=10.1.2.3",
+		"XCLIENT ADDR=10.1.2.3",
+	)
+	checkCode(l[1], "501")
+	checkCode(l[2], "501")
+	checkCode(l[4], "503")
+	checkCode(l[5], "503")
+
+	// Unavailable and invalid HELO names are treated as absent.
+	for _, helo := range []string{"[UNAVAILABLE]", "[TEMPUNAVAIL]", "bogus..example", "[bogus]"} {
+		l = run(
+			"EHLO upstream.example.org",
+			"XFORWARD ADDR=10.1.2.3 HELO="+helo,
+			"MAIL FROM:<remote@example.org>",
+			"RCPT TO:<mjl@mox.example>",
+			"DATA",
+		)
+		checkCode(l[1], "250")
+		checkCode(l[4], "250")
+		m = lastMessage()
+		tcompare(t, m.RemoteIP, "10.1.2.3")
+		tcompare(t, m.EHLODomain, "upstream.example.org")
+	}
+
+	// IPAccess of listener applies to the client IP from XCLIENT and XFORWARD.
+	setTrusted("127.0.0.0/8")
+	ln := mox.Conf.Static.Listeners["test"]
+	_, denyNet, err := net.ParseCIDR("10.1.2.0/24")
+	tcheck(t, err, "parse cidr")
+	ln.SMTP.IPAccess = &config.IPAccess{DenyNets: []*net.IPNet{denyNet}}
+	mox.Conf.Static.Listeners["test"] = ln
+	l = run("EHLO upstream.example.org", "XCLIENT ADDR=10.1.2.3")
+	checkCode(l[1], "421")
+	l = run("EHLO upstream.example.org", "XFORWARD ADDR=10.1.2.3")
+	checkCode(l[1], "421")
+	l = run("EHLO upstream.example.org", "XFORWARD ADDR=10.1.3.3")
+	checkCode(l[1], "250")
+}
+
+// Test domains of URLs in messages are looked up in URI blocklists.
+func TestURIBL(t *testing.T) {
+	resolver := &dns.MockResolver{
+		A: map[string][]string{
+			"example.org.":                {"127.0.0.10"}, // For mx check.
+			"spam.example.uribl.example.": {"127.0.0.2"},
+		},
+		TXT: map[string][]string{
+			"example.org.":        {"v=spf1 ip4:127.0.0.10 -all"},
+			"_dmarc.example.org.": {"v=DMARC1;p=reject"},
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+	ts.uribls = []dns.Domain{{ASCII: "uribl.example"}}
+
+	testDeliver := func(msg string, expErr *smtpclient.Error) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+			ts.smtpErr(err, expErr)
+		})
+	}
+
+	// No URLs.
+	testDeliver(deliverMessage, nil)
+
+	// URL with domain that isn't listed.
+	hamMessage := strings.Replace(deliverMessage, "test email", "see https://www.ham.example/page", 1)
+	testDeliver(hamMessage, nil)
+
+	// Subdomain of listed domain, in an html part. Looked up as organizational domain.
+	spamMessage := strings.ReplaceAll(`From: <remote@example.org>
+To: <mjl@mox.example>
+Subject: test
+Message-Id: <test3@example.org>
+MIME-Version: 1.0
+Content-Type: text/html; charset=utf-8
+
+<a href="http://user@www.spam.example:8080/x">click</a>
+`, "\n", "\r\n")
+	testDeliver(spamMessage, &smtpclient.Error{Code: smtp.C451LocalErr, Secode: smtp.SeSys3Other0})
+}
+
+// Test the junk classification is stored with a message delivered from a sender
+// without reputation.
+func TestJunkClassification(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+		TXT: map[string][]string{
+			"example.org.": {"v=spf1 ip4:127.0.0.10 -all"},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	ts.run(func(client *smtpclient.Client) {
+		err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		tcheck(t, err, "deliver")
+	})
+
+	m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).FilterEqual("Expunged", false).Get()
+	tcheck(t, err, "get delivered message")
+	jc := m.JunkClassification
+	if jc == nil || jc.Junk || jc.Significant || jc.Probability != 0.5 || jc.Threshold == 0 {
+		t.Fatalf("unexpected junk classification %#v", jc)
+	}
+}
+
+// Test accepting a DMARC report.
+func TestDMARCReport(t *testing.T) {
+	resolver := &dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		TXT: map[string][]string{
+			"example.org.":        {"v=spf1 ip4:127.0.0.10 -all"},
+			"_dmarc.example.org.": {"v=DMARC1;p=reject; rua=mailto:dmarcrpt@example.org"},
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/dmarcreport/mox.conf"), resolver)
+	defer ts.close()
+
+	run := func(rcptTo, report string, n int) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+
+			mailFrom := "remote@example.org"
 
 			msgb := &bytes.Buffer{}
 			_, xerr := fmt.Fprintf(msgb, "From: %s\r\nTo: %s\r\nSubject: dmarc report\r\nMIME-Version: 1.0\r\nContent-Type: text/xml\r\n\r\n", mailFrom, rcptTo)
@@ -1426,7 +2473,7 @@ func TestNonSMTP(t *testing.T) {
 		tlsConfig := &tls.Config{
 			Certificates: []tls.Certificate{fakeCert(ts.t, false)},
 		}
-		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, tlsConfig, serverConn, ts.resolver, ts.submission, false, false, false, 100<<20, false, false, false, ts.dnsbls, 0)
+		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, tlsConfig, serverConn, ts.resolver, ts.submission, false, false, false, false, 100<<20, false, false, false, ts.dnsbls, ts.dnsblWeights, ts.dnsblThreshold, ts.uribls, 0, 0, 0, nil, nil)
 		close(serverdone)
 	}()
 
@@ -1484,11 +2531,143 @@ func TestLimitOutgoing(t *testing.T) {
 	testSubmit("b@other.example", nil)
 	testSubmit("b@other.example", nil)
 	testSubmit("b@other.example", &smtpclient.Error{Code: smtp.C451LocalErr, Secode: smtp.SePol7DeliveryUnauth1}) // Would be 5th message.
+
+	// With HoldOverSendLimits, the message is accepted but held for review.
+	accConf := mox.Conf.Dynamic.Accounts["mjl"]
+	accConf.HoldOverSendLimits = true
+	mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	defer func() {
+		accConf.HoldOverSendLimits = false
+		mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	}()
+	testSubmit("b@other.example", nil)
+	yes := true
+	held, err := queue.List(ctxbg, queue.Filter{Hold: &yes}, queue.Sort{})
+	tcheck(t, err, "listing held messages")
+	tcompare(t, len(held), 1)
+	tcompare(t, held[0].HoldReason, "max number of messages (4) over past 24h reached")
+
+	// Releasing the message clears the hold reason.
+	n, err := queue.HoldSet(ctxbg, queue.Filter{IDs: []int64{held[0].ID}}, false)
+	tcheck(t, err, "release held message")
+	tcompare(t, n, 1)
+	qm, err := queue.List(ctxbg, queue.Filter{IDs: []int64{held[0].ID}}, queue.Sort{})
+	tcheck(t, err, "get released message")
+	tcompare(t, qm[0].Hold, false)
+	tcompare(t, qm[0].HoldReason, "")
+}
+
+// Test limits on number of recipients per transaction and connection, and refusing
+// connections after many unknown recipients.
+func TestRecipientLimits(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+		TXT: map[string][]string{
+			"example.org.": {"v=spf1 ip4:127.0.0.10 -all"}, // Multiple recipients require spf pass.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	ts.tlsmode = smtpclient.TLSSkip
+	ts.maxRcpts = 2
+	ts.maxRcptsConn = 3
+	defer ts.close()
+
+	// Configure limits for unknown recipients that trigger quickly.
+	var l config.Listener
+	l.SMTP.MaxUnknownRecipientsPerHour = []int64{2, 6, 18}
+	mox.Conf.Static.Listeners["test"] = l
+	defer delete(mox.Conf.Static.Listeners, "test")
+	defer limitersInit()
+
+	// Run an smtp session with raw commands and responses.
+	session := func(fn func(write func(s string), read func(expPrefix string))) {
+		t.Helper()
+		ts.runRaw(func(conn net.Conn) {
+			t.Helper()
+			defer conn.Close()
+
+			br := bufio.NewReader(conn)
+			write := func(s string) {
+				t.Helper()
+				_, err := fmt.Fprintf(conn, "%s\r\n", s)
+				tcheck(t, err, "write")
+			}
+			// Read response, skipping continuation lines.
+			read := func(expPrefix string) {
+				t.Helper()
+				for {
+					line, err := br.ReadString('\n')
+					tcheck(t, err, "read response")
+					if len(line) >= 4 && line[3] == '-' {
+						continue
+					}
+					if !strings.HasPrefix(line, expPrefix) {
+						t.Fatalf("got response %q, expected prefix %q", line, expPrefix)
+					}
+					return
+				}
+			}
+			fn(write, read)
+		})
+	}
+
+	session(func(write func(s string), read func(expPrefix string)) {
+		read("220 ")
+		write("EHLO example.org")
+		read("250 ")
+		write("MAIL FROM:<remote@example.org>")
+		read("250 ")
+		write("RCPT TO:<mjl@mox.example>")
+		read("250 ")
+		write("RCPT TO:<mjl@mox.example>")
+		read("250 ")
+		write("RCPT TO:<mjl@mox.example>")
+		read("452 4.5.3 ") // Per transaction.
+		write("RSET")
+		read("250 ")
+		write("MAIL FROM:<remote@example.org>")
+		read("250 ")
+		write("RCPT TO:<mjl@mox.example>")
+		read("250 ")
+		write("RCPT TO:<mjl@mox.example>")
+		read("452 4.5.3 ") // Per connection.
+		write("QUIT")
+		read("221 ")
+	})
+
+	ts.maxRcptsConn = 0
+	session(func(write func(s string), read func(expPrefix string)) {
+		read("220 ")
+		write("EHLO example.org")
+		read("250 ")
+		write("MAIL FROM:<remote@example.org>")
+		read("250 ")
+		write("RCPT TO:<unknown1@mox.example>")
+		read("250 ")
+		write("RCPT TO:<unknown2@mox.example>")
+		read("250 ")
+		write("RSET")
+		read("250 ")
+		write("MAIL FROM:<remote@example.org>")
+		read("250 ")
+		write("RCPT TO:<unknown3@mox.example>")
+		read("421 4.7.0 ") // Too many unknown recipients.
+	})
+
+	// New connections are refused.
+	session(func(write func(s string), read func(expPrefix string)) {
+		read("421 4.7.0 ")
+	})
 }
 
 // Test account size limit enforcement.
 func TestQuota(t *testing.T) {
-	resolver := dns.MockResolver{
+	resolver := &dns.MockResolver{
 		A: map[string][]string{
 			"other.example.": {"127.0.0.10"}, // For mx check.
 		},
@@ -1508,6 +2687,62 @@ func TestQuota(t *testing.T) {
 	}
 
 	testDeliver("mjl@mox.example", &smtpclient.Error{Code: smtp.C452StorageFull, Secode: smtp.SeMailbox2Full2})
+
+	// Delivery to one of two recipients fails, so a DSN is sent. Multiple recipients
+	// require an SPF pass.
+	resolver.TXT = map[string][]string{
+		"other.example.": {"v=spf1 ip4:127.0.0.10 -all"},
+	}
+	testPartial := func(expDSNs int) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			_, err := client.DeliverMultiple(ctxbg, "mjl@other.example", []string{"mjl@mox.example", "nolimit@mox.example"}, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+			tcheck(t, err, "deliver")
+		})
+		n, err := queue.Count(ctxbg)
+		tcheck(t, err, "queue count")
+		tcompare(t, n, expDSNs)
+	}
+	testPartial(1)
+	testPartial(1) // DSN to same recipient still in queue.
+
+	// With the DSN extension, the sender can opt out of DSNs for failures, and request
+	// DSNs for successful deliveries.
+	testPartialDSN := func(notifyOK, notifyFail string, expDSN bool, expContains ...string) {
+		t.Helper()
+		_, err := queue.Drop(ctxbg, pkglog, queue.Filter{})
+		tcheck(t, err, "drop queue")
+		ts.run(func(client *smtpclient.Client) {
+			dsnParams := &smtpclient.DSNParams{
+				EnvID: "envid1",
+				Recipients: []smtpclient.DSNRecipientParams{
+					{Notify: notifyFail, ORcpt: "orig@mox.example"},
+					{Notify: notifyOK},
+				},
+			}
+			_, err := client.DeliverMultipleDSN(ctxbg, "mjl@other.example", []string{"mjl@mox.example", "nolimit@mox.example"}, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false, dsnParams)
+			tcheck(t, err, "deliver")
+		})
+		msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+		tcheck(t, err, "queue list")
+		tcompare(t, len(msgs) == 1, expDSN)
+		if !expDSN {
+			return
+		}
+		mr, err := queue.OpenMessage(ctxbg, msgs[0].ID)
+		tcheck(t, err, "open dsn message")
+		defer mr.Close()
+		buf, err := io.ReadAll(mr)
+		tcheck(t, err, "read dsn message")
+		for _, exp := range expContains {
+			if !strings.Contains(string(buf), exp) {
+				t.Fatalf("dsn does not contain %q:\n%s", exp, buf)
+			}
+		}
+	}
+	testPartialDSN("", "NEVER", false)
+	testPartialDSN("SUCCESS", "NEVER", true, "Action: delivered", "Original-Envelope-ID: envid1", "Final-Recipient: rfc822;nolimit@mox.example")
+	testPartialDSN("NEVER", "FAILURE", true, "Action: failed", "Original-Recipient: rfc822;orig@mox.example")
 }
 
 // Test with catchall destination address.
@@ -1559,41 +2794,170 @@ func TestCatchall(t *testing.T) {
 	n, err = bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).Count()
 	tcheck(t, err, "checking delivered messages")
 	tcompare(t, n, 6)
+
+	// Destination patterns, first match wins, before catchall.
+	testDeliver("Invoice-2024@mox.example", nil)   // First pattern, wildcard.
+	testDeliver("invoice-1+test@mox.example", nil) // Catchall separator is stripped.
+	testDeliver("order123@mox.example", nil)       // Second pattern, regexp.
+	testDeliver("order@mox.example", nil)          // No pattern match, to catchall Inbox.
+	testDeliver("xorder1@mox.example", nil)        // Regexp must match entire localpart.
+
+	mailboxCount := func(acc *store.Account, mailbox string) int {
+		t.Helper()
+		mb, err := bstore.QueryDB[store.Mailbox](ctxbg, acc.DB).FilterNonzero(store.Mailbox{Name: mailbox}).Get()
+		tcheck(t, err, "get mailbox")
+		n, err := bstore.QueryDB[store.Message](ctxbg, acc.DB).FilterNonzero(store.Message{MailboxID: mb.ID}).Count()
+		tcheck(t, err, "count messages")
+		return n
+	}
+	tcompare(t, mailboxCount(ts.acc, "Invoices"), 2)
+	tcompare(t, mailboxCount(acc, "Orders"), 1)
+	tcompare(t, mailboxCount(acc, "Inbox"), 3)
 }
 
-// Test DKIM signing for outgoing messages.
-func TestDKIMSign(t *testing.T) {
+// Test delivery to mailboxes named after the subaddress.
+func TestSubaddressMailbox(t *testing.T) {
 	resolver := dns.MockResolver{
 		A: map[string][]string{
-			"mox.example.": {"127.0.0.10"}, // For mx check.
+			"other.example.": {"127.0.0.10"}, // For mx check.
 		},
 		PTR: map[string][]string{
-			"127.0.0.10": {"mox.example."},
+			"127.0.0.10": {"other.example."},
 		},
 	}
-
-	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtpservercatchall/mox.conf"), resolver)
 	defer ts.close()
 
-	// Set DKIM signing config.
-	var gen byte
-	genDKIM := func(domain string) string {
-		dom, _ := mox.Conf.Domain(dns.Domain{ASCII: domain})
+	acc, err := store.OpenAccount(pkglog, "subaddress", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		acc.Close()
+		acc.WaitClosed()
+	}()
 
-		privkey := make([]byte, ed25519.SeedSize) // Fake key, don't use for real.
-		gen++
-		privkey[0] = byte(gen)
+	testDeliver := func(rcptTo, expMailbox string) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+			err := client.Deliver(ctxbg, "mjl@other.example", rcptTo, int64(len(submitMessage)), strings.NewReader(submitMessage), false, false, false)
+			ts.smtpErr(err, nil)
+		})
+		m, err := bstore.QueryDB[store.Message](ctxbg, acc.DB).SortDesc("ID").Limit(1).Get()
+		tcheck(t, err, "get last delivered message")
+		mb, err := bstore.QueryDB[store.Mailbox](ctxbg, acc.DB).FilterID(m.MailboxID).Get()
+		tcheck(t, err, "get mailbox")
+		tcompare(t, mb.Name, expMailbox)
+	}
 
-		sel := config.Selector{
-			HashEffective:    "sha256",
-			HeadersEffective: []string{"From", "To", "Subject"},
-			Key:              ed25519.NewKeyFromSeed(privkey),
-			Domain:           dns.Domain{ASCII: "testsel"},
-		}
-		dom.DKIM = config.DKIM{
-			Selectors: map[string]config.Selector{"testsel": sel},
-			Sign:      []string{"testsel"},
-		}
+	testDeliver("sub@mox.example", "Inbox")
+	testDeliver("sub+news@mox.example", "news") // Mailbox is created.
+	testDeliver("sub+news@mox.example", "news")
+	testDeliver("sub+Trash@mox.example", "Inbox")   // Special-use mailbox.
+	testDeliver("sub+inbox@mox.example", "Inbox")   // Inbox, explicitly.
+	testDeliver("sub+Rejects@mox.example", "Inbox") // Rejects mailbox.
+	testDeliver("sub+a/b@mox.example", "Inbox")     // Only top-level mailboxes.
+
+	// Not for accounts without the option.
+	ts.run(func(client *smtpclient.Client) {
+		err := client.Deliver(ctxbg, "mjl@other.example", "mjl+news@mox.example", int64(len(submitMessage)), strings.NewReader(submitMessage), false, false, false)
+		ts.smtpErr(err, nil)
+	})
+	exists, err := bstore.QueryDB[store.Mailbox](ctxbg, ts.acc.DB).FilterNonzero(store.Mailbox{Name: "news"}).Exists()
+	tcheck(t, err, "checking mailbox")
+	tcompare(t, exists, false)
+}
+
+// Test delivery to mailboxes that don't exist yet.
+func TestAutoCreateMailbox(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"other.example.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"other.example."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtpservercatchall/mox.conf"), resolver)
+	defer ts.close()
+
+	testDeliver := func(accName, rcptTo, expMailbox string) store.Mailbox {
+		t.Helper()
+		acc, err := store.OpenAccount(pkglog, accName, false)
+		tcheck(t, err, "open account")
+		defer func() {
+			acc.Close()
+			acc.WaitClosed()
+		}()
+
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+			err := client.Deliver(ctxbg, "mjl@other.example", rcptTo, int64(len(submitMessage)), strings.NewReader(submitMessage), false, false, false)
+			ts.smtpErr(err, nil)
+		})
+		m, err := bstore.QueryDB[store.Message](ctxbg, acc.DB).SortDesc("ID").Limit(1).Get()
+		tcheck(t, err, "get last delivered message")
+		mb, err := bstore.QueryDB[store.Mailbox](ctxbg, acc.DB).FilterID(m.MailboxID).Get()
+		tcheck(t, err, "get mailbox")
+		tcompare(t, mb.Name, expMailbox)
+		return mb
+	}
+
+	// Mailbox is created.
+	mb := testDeliver("autocreate", "lists@mox.example", "Lists")
+	tcompare(t, mb.SpecialUse, store.SpecialUse{})
+	if mb.UIDValidity == 0 {
+		t.Fatalf("missing uidvalidity for created mailbox")
+	}
+
+	// Remove the Archive mailbox, created with the account, so it is created again on
+	// delivery, with its special-use flag.
+	acc, err := store.OpenAccount(pkglog, "autocreate", false)
+	tcheck(t, err, "open account")
+	_, err = bstore.QueryDB[store.Mailbox](ctxbg, acc.DB).FilterNonzero(store.Mailbox{Name: "Archive"}).Delete()
+	tcheck(t, err, "removing archive mailbox")
+	err = acc.Close()
+	tcheck(t, err, "close account")
+	acc.WaitClosed()
+	mb = testDeliver("autocreate", "archive@mox.example", "Archive")
+	tcompare(t, mb.SpecialUse, store.SpecialUse{Archive: true})
+
+	// Account with option delivers to Inbox instead.
+	testDeliver("noautocreate", "noauto@mox.example", "Inbox")
+}
+
+// Test DKIM signing for outgoing messages.
+func TestDKIMSign(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"mox.example.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"mox.example."},
+		},
+	}
+
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	// Set DKIM signing config.
+	var gen byte
+	genDKIM := func(domain string) string {
+		dom, _ := mox.Conf.Domain(dns.Domain{ASCII: domain})
+
+		privkey := make([]byte, ed25519.SeedSize) // Fake key, don't use for real.
+		gen++
+		privkey[0] = byte(gen)
+
+		sel := config.Selector{
+			HashEffective:    "sha256",
+			HeadersEffective: []string{"From", "To", "Subject"},
+			Key:              ed25519.NewKeyFromSeed(privkey),
+			Domain:           dns.Domain{ASCII: "testsel"},
+		}
+		dom.DKIM = config.DKIM{
+			Selectors: map[string]config.Selector{"testsel": sel},
+			Sign:      []string{"testsel"},
+		}
 		mox.Conf.Dynamic.Domains[domain] = dom
 		pubkey := sel.Key.Public().(ed25519.PublicKey)
 		return "v=DKIM1;k=ed25519;p=" + base64.StdEncoding.EncodeToString(pubkey)
@@ -1652,6 +3016,88 @@ test email
 	testSubmit("mjl@mox.example", "mjl@mox2.example") // DKIM signature will be for mox2.example.
 }
 
+// Test delivery to, and submission with, addresses at an alias domain.
+func TestDomainAlias(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"other.example.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"other.example."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	testDeliver := func(rcptTo string, expErr *smtpclient.Error) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+			mailFrom := "mjl@other.example"
+			err := client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+			ts.smtpErr(err, expErr)
+		})
+	}
+
+	testDeliver("mjl@alias.example", nil)
+	testDeliver("MJL@alias.example", nil) // Case-insensitive, like the target domain.
+	testDeliver("unknown@alias.example", &smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SeAddr1UnknownDestMailbox1})
+
+	n, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).Count()
+	tcheck(t, err, "checking delivered messages")
+	tcompare(t, n, 2)
+
+	// Login and submit with address at alias domain.
+	ts.submission = true
+	ts.user = "mjl@alias.example"
+	ts.pass = password0
+	msg := strings.ReplaceAll(submitMessage, "mjl@mox.example", "mjl@alias.example")
+	ts.run(func(client *smtpclient.Client) {
+		mailFrom := "mjl@alias.example"
+		rcptTo := "remote@example.org"
+		err := client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(msg)), strings.NewReader(msg), false, false, false)
+		tcheck(t, err, "deliver")
+	})
+}
+
+// Test delivery to addresses at subdomains of a domain accepting subdomains.
+func TestSubdomains(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"other.example.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"other.example."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	testDeliver := func(rcptTo string, expErr *smtpclient.Error) {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+			mailFrom := "mjl@other.example"
+			err := client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+			ts.smtpErr(err, expErr)
+		})
+	}
+
+	unknown := &smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SeAddr1UnknownDestMailbox1}
+	testDeliver("mjl@sales.mox2.example", nil)        // Same localpart at mox2.example.
+	testDeliver("other@sales.mox2.example", unknown)  // No such address at mox2.example.
+	testDeliver("anything@mjl.eu.mox2.example", nil)  // Subdomain as localpart.
+	testDeliver("anything@eu.mox2.example", unknown)  // Wildcard does not match eu itself.
+	testDeliver("anything@team.mox2.example", nil)    // Fixed address.
+	testDeliver("mjl@other.mox2.example", unknown)    // No matching route.
+	testDeliver("mjl@sub.mox.example", unknown)       // Domain does not accept subdomains.
+	testDeliver("mjl@sales.unknown.example", unknown) // Unknown domain.
+
+	n, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).Count()
+	tcheck(t, err, "checking delivered messages")
+	tcompare(t, n, 3)
+}
+
 // Test to postmaster addresses.
 func TestPostmaster(t *testing.T) {
 	resolver := dns.MockResolver{
@@ -1795,6 +3241,42 @@ test email
 	})
 }
 
+// Test that TLS details of incoming deliveries are recorded.
+func TestDeliveryTLS(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	clientCert := fakeCert(ts.t, true)
+	ts.clientCert = &clientCert
+	ts.run(func(client *smtpclient.Client) {
+		err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		tcheck(t, err, "deliver")
+	})
+
+	m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).Get()
+	tcheck(t, err, "get delivered message")
+	fp := mox.TLSClientCertFingerprint(clientCert.Leaf)
+	tcompare(t, m.ReceivedTLSVersion, uint16(tls.VersionTLS13))
+	tcompare(t, m.ReceivedTLSClientCert, fp)
+
+	buf, err := io.ReadAll(ts.acc.MessageReader(m))
+	tcheck(t, err, "read message")
+	recv := strings.Split(string(buf), "\r\n\r\n")[0]
+	recv = strings.ReplaceAll(recv, "\r\n\t", " ")
+	exp := fmt.Sprintf("with ESMTPS (TLS1.3 %s client-cert=%s) id ", tls.CipherSuiteName(m.ReceivedTLSCipherSuite), fp)
+	if !strings.Contains(recv, exp) {
+		t.Fatalf("received header does not contain %q:\n%s", exp, recv)
+	}
+}
+
 func TestSmuggle(t *testing.T) {
 	resolver := dns.MockResolver{
 		A: map[string][]string{
@@ -1936,6 +3418,145 @@ func TestFutureRelease(t *testing.T) {
 	test(" HOLDFOR=1 HOLDUNTIL="+time.Now().Add(time.Hour).UTC().Format(time.RFC3339), "501")                        // Duplicate.
 }
 
+// Test MT-PRIORITY for submissions, with fallback to the account default.
+func TestMTPriority(t *testing.T) {
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), dns.MockResolver{})
+	ts.tlsmode = smtpclient.TLSSkip
+	ts.user = "mjl@mox.example"
+	ts.pass = password0
+	ts.submission = true
+	defer ts.close()
+
+	ts.auth = func(mechanisms []string, cs *tls.ConnectionState) (sasl.Client, error) {
+		return sasl.NewClientPlain(ts.user, ts.pass), nil
+	}
+
+	test := func(mailtoMore, expResponsePrefix string, expPriority int) {
+		t.Helper()
+
+		ts.runRaw(func(conn net.Conn) {
+			t.Helper()
+
+			ourHostname := mox.Conf.Static.HostnameDomain
+			remoteHostname := dns.Domain{ASCII: "mox.example"}
+			opts := smtpclient.Opts{Auth: ts.auth}
+			log := pkglog.WithCid(ts.cid - 1)
+			_, err := smtpclient.New(ctxbg, log.Logger, conn, ts.tlsmode, false, ourHostname, remoteHostname, opts)
+			tcheck(t, err, "smtpclient")
+			defer conn.Close()
+
+			write := func(s string) {
+				_, err := conn.Write([]byte(s))
+				tcheck(t, err, "write")
+			}
+
+			readPrefixLine := func(prefix string) string {
+				t.Helper()
+				buf := make([]byte, 512)
+				n, err := conn.Read(buf)
+				tcheck(t, err, "read")
+				s := strings.TrimRight(string(buf[:n]), "\r\n")
+				if !strings.HasPrefix(s, prefix) {
+					t.Fatalf("got smtp response %q, expected line with prefix %q", s, prefix)
+				}
+				return s
+			}
+
+			write(fmt.Sprintf("MAIL FROM:<mjl@mox.example>%s\r\n", mailtoMore))
+			readPrefixLine(expResponsePrefix)
+			if expResponsePrefix != "2" {
+				return
+			}
+			write("RCPT TO:<mjl@mox.example>\r\n")
+			readPrefixLine("2")
+
+			write("DATA\r\n")
+			readPrefixLine("3")
+			write("From: <mjl@mox.example>\r\n\r\nbody\r\n\r\n.\r\n")
+			readPrefixLine("2")
+
+			msgs, _ := queue.List(ctxbg, queue.Filter{}, queue.Sort{Field: "Queued", Asc: false})
+			tcompare(t, msgs[0].Priority, expPriority)
+		})
+	}
+
+	test("", "2", 0) // Account default.
+	test(" MT-PRIORITY=3", "2", 3)
+	test(" MT-PRIORITY=+3", "2", 3)
+	test(" MT-PRIORITY=-9", "2", -9)
+
+	test(" MT-PRIORITY=10", "501", 0)              // Out of range.
+	test(" MT-PRIORITY=x", "501", 0)               // Invalid syntax.
+	test(" MT-PRIORITY=1 MT-PRIORITY=1", "501", 0) // Duplicate.
+}
+
+// Test DSN extension parameters for submissions.
+func TestDSNParams(t *testing.T) {
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), dns.MockResolver{})
+	ts.user = "mjl@mox.example"
+	ts.pass = password0
+	ts.submission = true
+	defer ts.close()
+
+	test := func(mailMore, rcptMore, expMailPrefix, expRcptPrefix string, expMsg queue.Msg) {
+		t.Helper()
+
+		ts.run(func(client *smtpclient.Client) {
+			t.Helper()
+
+			conn, err := client.Conn()
+			tcheck(t, err, "get conn")
+			br := bufio.NewReader(conn)
+			write := func(s string) {
+				t.Helper()
+				_, err := fmt.Fprintf(conn, "%s\r\n", s)
+				tcheck(t, err, "write")
+			}
+			read := func(expPrefix string) {
+				t.Helper()
+				line, err := br.ReadString('\n')
+				tcheck(t, err, "read response")
+				if !strings.HasPrefix(line, expPrefix) {
+					t.Fatalf("got response %q, expected prefix %q", line, expPrefix)
+				}
+			}
+
+			write("MAIL FROM:<mjl@mox.example>" + mailMore)
+			read(expMailPrefix)
+			if expMailPrefix != "2" {
+				return
+			}
+			write("RCPT TO:<remote@example.org>" + rcptMore)
+			read(expRcptPrefix)
+			if expRcptPrefix != "2" {
+				return
+			}
+			write("DATA")
+			read("3")
+			write("From: <mjl@mox.example>\r\n\r\nbody\r\n.")
+			read("2")
+
+			msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{Field: "Queued", Asc: false})
+			tcheck(t, err, "list queue")
+			m := msgs[0]
+			tcompare(t, []string{m.DSNNotify, m.DSNRet, m.DSNEnvID, m.DSNOrigRecipient}, []string{expMsg.DSNNotify, expMsg.DSNRet, expMsg.DSNEnvID, expMsg.DSNOrigRecipient})
+		})
+	}
+
+	test("", "", "2", "2", queue.Msg{})
+	test(" RET=hdrs ENVID=a+2Bb", " NOTIFY=success,failure ORCPT=rfc822;Orig+2Btest@Example.org", "2", "2", queue.Msg{DSNNotify: "SUCCESS,FAILURE", DSNRet: "HDRS", DSNEnvID: "a+b", DSNOrigRecipient: "Orig+test@example.org"})
+	test(" RET=FULL", " NOTIFY=NEVER ORCPT=utf-8;m\\x{E9}t@example.org", "2", "2", queue.Msg{DSNNotify: "NEVER", DSNRet: "FULL", DSNOrigRecipient: "mét@example.org"})
+
+	test(" RET=BOGUS", "", "501", "", queue.Msg{})                       // Invalid value.
+	test(" RET=FULL RET=HDRS", "", "501", "", queue.Msg{})               // Duplicate.
+	test(" ENVID="+strings.Repeat("x", 101), "", "501", "", queue.Msg{}) // Too long.
+	test("", " NOTIFY=NEVER,SUCCESS", "2", "501", queue.Msg{})           // NEVER must be alone.
+	test("", " NOTIFY=BOGUS", "2", "501", queue.Msg{})                   // Invalid value.
+	test("", " ORCPT=x400;bogus", "2", "555", queue.Msg{})               // Unsupported address type.
+	test("", " ORCPT=rfc822;bogus", "2", "501", queue.Msg{})             // Invalid address.
+	test("", " NOTIFY=NEVER NOTIFY=NEVER", "2", "501", queue.Msg{})      // Duplicate.
+}
+
 // Test SMTPUTF8
 func TestSMTPUTF8(t *testing.T) {
 	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), dns.MockResolver{})
@@ -2120,6 +3741,18 @@ func TestDestinationSMTPError(t *testing.T) {
 		err := client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
 		ts.smtpErr(err, &smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SeAddr1UnknownDestMailbox1})
 	})
+
+	// User moved, response refers to new address.
+	ts.run(func(client *smtpclient.Client) {
+		mailFrom := "mjl@example.org"
+		rcptTo := "moved@mox.example"
+		err := client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		ts.smtpErr(err, &smtpclient.Error{Permanent: true, Code: smtp.C551UserNotLocal, Secode: smtp.SeAddr1DestMailboxMoved6})
+		var cerr smtpclient.Error
+		if !errors.As(err, &cerr) || !strings.Contains(cerr.Line, "please try <moved@example.org>") {
+			t.Fatalf("got err %v, expected reference to new address", err)
+		}
+	})
 }
 
 // TestDestinationMessageAuthRequiredSMTPError checks delivery to a destination
@@ -2154,3 +3787,359 @@ func TestDestinationMessageAuthRequiredSMTPError(t *testing.T) {
 		ts.smtpErr(err, nil)
 	})
 }
+
+// Test messages rejected with a permanent error during DATA are retained for
+// domains with RejectedRetention configured.
+func TestRejectedRetention(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+	}
+
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	deliver := func() {
+		t.Helper()
+		ts.run(func(client *smtpclient.Client) {
+			mailFrom := "mjl@example.org"
+			rcptTo := "msgauthrequired@mox.example"
+			err := client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+			ts.smtpErr(err, &smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SePol7MultiAuthFails26})
+		})
+	}
+
+	// Not retained without configuration.
+	deliver()
+	l, err := rejectdb.List(ctxbg, "")
+	tcheck(t, err, "list rejected messages")
+	if len(l) != 0 {
+		t.Fatalf("got %d retained messages, expected 0", len(l))
+	}
+
+	dom, _ := mox.Conf.Domain(dns.Domain{ASCII: "mox.example"})
+	dom.RejectedRetention = &config.RejectedRetention{}
+	mox.Conf.Dynamic.Domains["mox.example"] = dom
+
+	deliver()
+	l, err = rejectdb.List(ctxbg, "")
+	tcheck(t, err, "list rejected messages")
+	if len(l) != 1 {
+		t.Fatalf("got %d retained messages, expected 1", len(l))
+	}
+	m := l[0]
+	if m.Domain != "mox.example" || !slices.Equal(m.RcptTo, []string{"msgauthrequired@mox.example"}) || m.MailFrom != "mjl@example.org" || m.Code != smtp.C550MailboxUnavail || m.Subject != "test" || m.RemoteIP != "127.0.0.10" {
+		t.Fatalf("unexpected retained message %#v", m)
+	}
+	_, data, err := rejectdb.Get(ctxbg, m.ID)
+	tcheck(t, err, "get rejected message")
+	if string(data) != deliverMessage {
+		t.Fatalf("got retained message data %q, expected %q", data, deliverMessage)
+	}
+}
+
+// Generated with: gpg --quick-gen-key "Remote <remote@example.org>" ed25519 sign never
+const pgpRemoteKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEatLyRRYJKwYBBAHaRw8BAQdA+vP/skppm0QE4ztPWkc9GN9eAiGhpNE25Ztt
+D3F7Sgm0G1JlbW90ZSA8cmVtb3RlQGV4YW1wbGUub3JnPoiQBBMWCAA4FiEEuWT3
+IiAMsHyZB4Lt6ltNz0avA5gFAmrS8kUCGwMFCwkIBwIGFQoJCAsCBBYCAwECHgEC
+F4AACgkQ6ltNz0avA5jOkwEAubY/waqXkjrw5O2HdlvH2XnKURUAEVu6XIL9nmTH
+03sBAKubl81o9UM5h6qWIzgMuuvvJssZWz843cvNB8WrZfIP
+=U1hr
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+// Generated with: gpg --armor --detach-sign --digest-algo SHA256, over "Content-Type:
+// text/plain\r\n\r\nhi\r\n".
+const pgpRemoteSignature = `-----BEGIN PGP SIGNATURE-----
+
+iIkEABYIADEWIQS5ZPciIAywfJkHgu3qW03PRq8DmAUCatLyRRMccmVtb3RlQGV4
+YW1wbGUub3JnAAoJEOpbTc9GrwOYX8IA/RhYT5Bw9Pc3Y9RtyfvKdmR0Qj7yfDuJ
+AV2dapIuNzlUAQCEvlB4aGA+7xSR9iietiOsSJwBmtqpREVZ3rMBNPcVBA==
+=1FuR
+-----END PGP SIGNATURE-----
+`
+
+// Test signed/encrypted messages are detected and S/MIME and PGP signatures
+// verified during delivery, and keywords set.
+func TestSMIME(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	test := func(contentType, body, expSigned, expEncrypted, expResult string) {
+		t.Helper()
+
+		msg := strings.ReplaceAll(deliverMessage, "\r\n\r\ntest email\r\n", "\r\nContent-Type: "+contentType+"\r\n\r\n"+body)
+		ts.run(func(client *smtpclient.Client) {
+			err := client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+			tcheck(t, err, "deliver")
+		})
+
+		m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).SortDesc("ID").Limit(1).Get()
+		tcheck(t, err, "get delivered message")
+		if m.Signed != expSigned || m.Encrypted != expEncrypted || m.SignatureResult != expResult {
+			t.Fatalf("got signed %q, encrypted %q, signature result %q, expected %q, %q, %q", m.Signed, m.Encrypted, m.SignatureResult, expSigned, expEncrypted, expResult)
+		}
+		if hasSMIME := strings.Contains(string(m.MsgPrefix), "smime="+expResult); expSigned == "smime" && !hasSMIME {
+			t.Fatalf("missing smime result in authentication-results header: %s", m.MsgPrefix)
+		}
+		if kw := m.SignatureKeywords(); !slices.Equal(m.Keywords, kw) {
+			t.Fatalf("got keywords %v, expected %v", m.Keywords, kw)
+		}
+	}
+
+	signedBody := "--b\r\nContent-Type: text/plain\r\n\r\nhi\r\n--b\r\nContent-Type: application/pkcs7-signature\r\nContent-Transfer-Encoding: base64\r\n\r\nYm9ndXM=\r\n--b--\r\n"
+	test(`multipart/signed; protocol="application/pkcs7-signature"; micalg=sha-256; boundary=b`, signedBody, "smime", "", "permerror")
+	test(`multipart/signed; protocol="application/pgp-signature"; micalg=pgp-sha256; boundary=b`, signedBody, "pgp", "", "permerror")
+	test(`application/pkcs7-mime; smime-type=enveloped-data`, "AAAA\r\n", "", "smime", "")
+	test(`text/plain`, "test email\r\n", "", "", "")
+
+	// PGP signatures are verified with the keys in the keyring of the account.
+	// The CRLF before a boundary is part of the boundary, not of the signed content.
+	pgpSignedBody := "--b\r\nContent-Type: text/plain\r\n\r\nhi\r\n\r\n--b\r\nContent-Type: application/pgp-signature\r\n\r\n" + strings.ReplaceAll(pgpRemoteSignature, "\n", "\r\n") + "--b--\r\n"
+	pgpContentType := `multipart/signed; protocol="application/pgp-signature"; micalg=pgp-sha256; boundary=b`
+	test(pgpContentType, pgpSignedBody, "pgp", "", "neutral")
+	_, err := ts.acc.OpenPGPKeyringAdd(ctxbg, []byte(pgpRemoteKey))
+	tcheck(t, err, "add key to keyring")
+	test(pgpContentType, pgpSignedBody, "pgp", "", "pass")
+	test(pgpContentType, strings.Replace(pgpSignedBody, "hi", "bye", 1), "pgp", "", "fail")
+
+	m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).SortDesc("ID").Limit(1).Get()
+	tcheck(t, err, "get delivered message")
+	if !slices.Equal(m.Keywords, []string{store.KeywordSignatureInvalid, store.KeywordSigned}) {
+		t.Fatalf("got keywords %v for message with bad signature", m.Keywords)
+	}
+}
+
+// TestMessageLimits checks messages exceeding the limits for parsing are rejected.
+func TestMessageLimits(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	omaxParts := message.MaxParts
+	defer func() {
+		message.MaxParts = omaxParts
+	}()
+	message.MaxParts = 2
+
+	msg := strings.ReplaceAll(`From: <remote@example.org>
+To: <mjl@mox.example>
+Subject: test
+Content-Type: multipart/mixed; boundary=b
+
+--b
+
+one
+--b
+
+two
+--b
+
+three
+--b--
+`, "\n", "\r\n")
+
+	ts.run(func(client *smtpclient.Client) {
+		mailFrom := "remote@example.org"
+		rcptTo := "mjl@mox.example"
+		err := client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(msg)), strings.NewReader(msg), false, false, false)
+		ts.smtpErr(err, &smtpclient.Error{Permanent: true, Code: smtp.C552MailboxFull, Secode: smtp.SeSys3MsgLimitExceeded4})
+	})
+
+	ts.user = "mjl@mox.example"
+	ts.pass = password0
+	ts.submission = true
+	ts.run(func(client *smtpclient.Client) {
+		mailFrom := "mjl@mox.example"
+		rcptTo := "remote@example.org"
+		err := client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(msg)), strings.NewReader(msg), false, false, false)
+		ts.smtpErr(err, &smtpclient.Error{Permanent: true, Code: smtp.C552MailboxFull, Secode: smtp.SeSys3MsgLimitExceeded4})
+	})
+}
+
+// Test BURL, submitting a message referenced by URLAUTH-authorized IMAP URLs.
+func TestBurl(t *testing.T) {
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), dns.MockResolver{})
+	defer ts.close()
+	ts.submission = true
+
+	msg := strings.ReplaceAll(`From: <mjl@mox.example>
+To: <remote@example.org>
+Subject: test
+Message-Id: <test@mox.example>
+
+test email
+`, "\n", "\r\n")
+	tinsertmsg(t, ts.acc, "Inbox", &store.Message{Size: int64(len(msg))}, msg)
+
+	urlauth := func(section, access string) string {
+		rump := "imap://mjl%40mox.example@mox.example/INBOX/;UID=1" + section + ";URLAUTH=" + access
+		var key []byte
+		err := ts.acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+			mb, err := ts.acc.MailboxFind(tx, "Inbox")
+			if err == nil {
+				key, err = store.URLAuthKeyGet(tx, mb.ID, true)
+			}
+			return err
+		})
+		tcheck(t, err, "get mailbox access key")
+		return rump + ":INTERNAL:" + imapurl.Token(key, rump)
+	}
+	headerURL := urlauth("/;SECTION=HEADER", "submit+mjl@mox.example")
+	textURL := urlauth("/;SECTION=TEXT", "submit+mjl@mox.example")
+	userURL := urlauth("", "user+mjl@mox.example")
+	badURL := textURL[:len(textURL)-8] + "00000000"
+
+	ts.runRaw(func(conn net.Conn) {
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		write := func(s string) {
+			t.Helper()
+			_, err := fmt.Fprintf(conn, "%s\r\n", s)
+			tcheck(t, err, "write")
+		}
+		read := func(expPrefix string) {
+			t.Helper()
+			for {
+				line, err := br.ReadString('\n')
+				tcheck(t, err, "read response")
+				if len(line) >= 4 && line[3] == '-' {
+					continue
+				}
+				if !strings.HasPrefix(line, expPrefix) {
+					t.Fatalf("got response %q, expected prefix %q", line, expPrefix)
+				}
+				return
+			}
+		}
+
+		read("220 ")
+		write("EHLO example.org")
+		read("250 ")
+		write("AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\u0000mjl@mox.example\u0000"+password0)))
+		read("235 ")
+		write("BURL " + headerURL)
+		read("503 ") // Missing MAIL FROM.
+
+		transaction := func() {
+			t.Helper()
+			write("MAIL FROM:<mjl@mox.example>")
+			read("250 ")
+			write("RCPT TO:<remote@example.org>")
+			read("250 ")
+		}
+
+		transaction()
+		write("BURL " + headerURL)
+		read("250 ")
+		write("DATA")
+		read("503 ") // Cannot mix BURL and DATA.
+		write("BURL " + textURL + " LAST")
+		read("250 ")
+
+		transaction()
+		write("BURL " + badURL + " LAST")
+		read("554 5.6.6 ")
+		write("BURL " + textURL + " LAST")
+		read("503 ") // Transaction was reset.
+
+		transaction()
+		write("BURL " + userURL + " LAST")
+		read("554 5.6.6 ") // Access identifier not for submission.
+
+		write("QUIT")
+		read("221 ")
+	})
+
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "listing queue")
+	tcompare(t, len(msgs), 1)
+	f, err := queue.OpenMessage(ctxbg, msgs[0].ID)
+	tcheck(t, err, "open message in queue")
+	defer f.Close()
+	buf, err := io.ReadAll(f)
+	tcheck(t, err, "read queued message")
+	if !strings.HasSuffix(string(buf), msg) {
+		t.Fatalf("queued message %q does not end with original message %q", buf, msg)
+	}
+}
+
+// Test that while draining, new connections and transactions are refused, but a
+// transaction in progress completes.
+func TestDrain(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+	defer mox.Draining.Store(false)
+
+	ts.runRaw(func(conn net.Conn) {
+		br := bufio.NewReader(conn)
+		xcmd := func(cmd string, expCode int) {
+			t.Helper()
+			if cmd != "" {
+				_, err := fmt.Fprintf(conn, "%s\r\n", cmd)
+				tcheck(t, err, "write command")
+			}
+			for {
+				line, err := br.ReadString('\n')
+				tcheck(t, err, "read response")
+				if !strings.HasPrefix(line, fmt.Sprintf("%d", expCode)) {
+					t.Fatalf("got response %q for command %q, expected code %d", line, cmd, expCode)
+				}
+				if len(line) < 4 || line[3] != '-' {
+					return
+				}
+			}
+		}
+
+		xcmd("", smtp.C220ServiceReady)
+		xcmd("EHLO example.org", smtp.C250Completed)
+		xcmd("MAIL FROM:<remote@example.org>", smtp.C250Completed)
+
+		// Transaction in progress can complete.
+		mox.Draining.Store(true)
+		xcmd("RCPT TO:<mjl@mox.example>", smtp.C250Completed)
+		tcompare(t, Transactions(), int64(1))
+		xcmd("DATA", smtp.C354Continue)
+		msg := strings.ReplaceAll(deliverMessage, "\n.", "\n..")
+		_, err := io.WriteString(conn, msg)
+		tcheck(t, err, "write message")
+		xcmd(".", smtp.C250Completed)
+
+		// But no new transaction is started.
+		xcmd("MAIL FROM:<remote@example.org>", smtp.C421ServiceUnavail)
+	})
+	tcompare(t, Transactions(), int64(0))
+	ts.checkCount("Inbox", 1)
+
+	// New connections are refused.
+	ts.runx(func(helloErr error, client *smtpclient.Client) {
+		ts.smtpErr(helloErr, &smtpclient.Error{Code: smtp.C421ServiceUnavail})
+	})
+}
@@ -0,0 +1,251 @@
+package smtpserver
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/sieve"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// Maximum number of bytes of a message body part made available to the Sieve
+// "body" test.
+const sieveMaxBodyPartSize = 1024 * 1024
+
+// sieveEvaluate evaluates the active Sieve script of the account of a delivery,
+// as managed through ManageSieve. A nil result is returned if the account has no
+// active script. Errors during evaluation result in the implicit keep.
+func sieveEvaluate(ctx context.Context, log mlog.Log, a analysis, mailFrom smtp.Path, dataFile *os.File) *sieve.Result {
+	ss, err := a.d.acc.SieveScriptActive(ctx)
+	if err != nil {
+		log.Errorx("looking up active sieve script, delivering without", err)
+		return nil
+	} else if ss == nil {
+		return nil
+	}
+	log = log.With(slog.String("sievescript", ss.Name))
+
+	script, err := sieve.Parse(ss.Script)
+	if err != nil {
+		// Scripts are validated when stored, but could fail after an upgrade.
+		log.Errorx("parsing active sieve script, delivering without", err)
+		return nil
+	}
+
+	p, err := message.Parse(log.Logger, false, store.FileMsgReader(a.d.m.MsgPrefix, dataFile))
+	if err != nil {
+		log.Infox("parsing message for sieve script, delivering with implicit keep", err)
+		return &sieve.Result{Keep: true}
+	}
+	if err := p.Walk(log.Logger, nil); err != nil {
+		log.Debugx("parsing message parts for sieve script, continuing with parts parsed so far", err)
+	}
+	h, err := p.Header()
+	if err != nil {
+		log.Infox("parsing message header for sieve script, delivering with implicit keep", err)
+		return &sieve.Result{Keep: true}
+	}
+
+	var separators []string
+	if dc, ok := mox.Conf.Domain(a.d.deliverTo.IPDomain.Domain); ok {
+		separators = dc.LocalpartCatchallSeparatorsEffective
+	}
+
+	m := sieve.Message{
+		Header:       h,
+		Size:         a.d.m.Size,
+		EnvelopeFrom: mailFrom.String(),
+		EnvelopeTo:   a.d.smtpRcptTo.String(),
+		Separators:   separators,
+		Body: func(raw bool, types []string) []string {
+			return sieveBody(log, &p, raw, types)
+		},
+		MailboxExists: func(name string) bool {
+			var exists bool
+			err := a.d.acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+				mb, err := a.d.acc.MailboxFind(tx, name)
+				exists = mb != nil
+				return err
+			})
+			log.Check(err, "looking up mailbox for sieve script")
+			return exists
+		},
+	}
+	if mailFrom.IsZero() {
+		m.EnvelopeFrom = ""
+	}
+
+	r, err := sieve.Evaluate(script, m)
+	if err != nil {
+		log.Infox("evaluating sieve script, delivering with implicit keep", err)
+	} else {
+		log.Debug("sieve script evaluated",
+			slog.Bool("keep", r.Keep),
+			slog.Int("fileinto", len(r.FileInto)),
+			slog.Any("redirect", r.Redirect),
+			slog.Bool("reject", r.Reject),
+			slog.Bool("vacation", r.Vacation != nil))
+	}
+	return &r
+}
+
+// sieveBody returns the body text for the Sieve "body" test.
+func sieveBody(log mlog.Log, p *message.Part, raw bool, types []string) []string {
+	if raw {
+		buf, err := io.ReadAll(io.LimitReader(p.RawReader(), sieveMaxBodyPartSize))
+		if err != nil {
+			log.Debugx("reading raw message body for sieve script", err)
+		}
+		return []string{string(buf)}
+	}
+
+	// Leaf parts, including those of nested messages. ../rfc/5173:182
+	var l []string
+	err := p.WalkContent(func(p *message.Part, r io.Reader) error {
+		mt := strings.ToLower(p.MediaType)
+		ct := mt + "/" + strings.ToLower(p.MediaSubType)
+		if ct == "/" {
+			mt, ct = "text", "text/plain"
+		}
+		match := slices.ContainsFunc(types, func(t string) bool {
+			return t == "" || t == ct || !strings.Contains(t, "/") && t == mt
+		})
+		if !match {
+			return nil
+		}
+		if mt == "text" {
+			r = message.DecodeReader(p.ContentTypeParams["charset"], r)
+		}
+		buf, err := io.ReadAll(io.LimitReader(r, sieveMaxBodyPartSize))
+		if err != nil {
+			log.Debugx("reading message part for sieve script", err)
+			return nil
+		}
+		l = append(l, string(buf))
+		return nil
+	})
+	log.Check(err, "walking message parts for sieve script")
+	return l
+}
+
+// sieveDelivery is a delivery of a message to a mailbox of an account, with
+// additional flags/keywords for the message.
+type sieveDelivery struct {
+	mailbox string
+	flags   []string
+}
+
+// sieveDeliveries returns the mailboxes to deliver to, for the keep and fileinto
+// actions of a sieve result. The keep delivers to mailbox, the mailbox the message
+// would have been delivered to without script.
+func sieveDeliveries(mailbox string, r sieve.Result) []sieveDelivery {
+	var l []sieveDelivery
+	if r.Keep {
+		l = append(l, sieveDelivery{mailbox, r.KeepFlags})
+	}
+	for _, f := range r.FileInto {
+		if !slices.ContainsFunc(l, func(d sieveDelivery) bool { return d.mailbox == f.Mailbox }) {
+			l = append(l, sieveDelivery{f.Mailbox, f.Flags})
+		}
+	}
+	return l
+}
+
+// sieveApplyFlags sets the flags and keywords from a sieve script on m. Invalid
+// keywords are ignored.
+func sieveApplyFlags(log mlog.Log, m *store.Message, l []string) {
+	if len(l) == 0 {
+		return
+	}
+	var flags []string
+	for _, f := range l {
+		if _, _, err := store.ParseFlagsKeywords([]string{f}); err != nil {
+			log.Debugx("ignoring invalid flag from sieve script", err, slog.String("flag", f))
+			continue
+		}
+		flags = append(flags, f)
+	}
+	fl, keywords, err := store.ParseFlagsKeywords(flags)
+	if err != nil {
+		log.Debugx("ignoring flags from sieve script", err)
+		return
+	}
+	m.Flags = m.Flags.Set(fl, fl)
+	nkeywords := slices.Clone(m.Keywords)
+	for _, kw := range keywords {
+		if !slices.Contains(nkeywords, kw) {
+			nkeywords = append(nkeywords, kw)
+		}
+	}
+	slices.Sort(nkeywords)
+	m.Keywords = nkeywords
+}
+
+// sieveRejectText returns the reason of a sieve reject action for use in an SMTP
+// response: a single line of printable ASCII, of limited length.
+func sieveRejectText(reason string) string {
+	s := strings.Join(strings.Fields(reason), " ")
+	s = strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return '?'
+		}
+		return r
+	}, s)
+	if len(s) > 200 {
+		s = s[:200] + "..."
+	}
+	if s == "" {
+		s = "message rejected by recipient"
+	}
+	return s
+}
+
+// queueSieveVacationReply queues an automatic reply for a Sieve vacation action,
+// unless the message should not get automatic replies, or the sender already got
+// a reply for the action recently. The reply is sent from the recipient address,
+// the :from of the action is not used.
+func queueSieveVacationReply(ctx context.Context, log mlog.Log, a analysis, mailFrom smtp.Path, part message.Part, v sieve.Vacation, now time.Time) {
+	h, err := part.Header()
+	if err != nil {
+		log.Debugx("parsing message header for sieve vacation reply", err)
+		return
+	}
+	if reason := vacationSkipReason(a, mailFrom, h, v.Addresses); reason != "" {
+		log.Debug("not sending sieve vacation reply", slog.String("reason", reason))
+		return
+	}
+
+	reply, err := a.d.acc.SieveVacationReplyCheck(ctx, v.Handle, mailFrom.String(), time.Duration(v.Days)*24*time.Hour, now)
+	if err != nil {
+		log.Errorx("checking for sieve vacation reply", err)
+		return
+	} else if !reply {
+		return
+	}
+
+	// ../rfc/5230:397
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(v.Subject)
+	if subject == "" {
+		subject = "Auto: "
+		if part.Envelope != nil {
+			subject += part.Envelope.Subject
+		}
+	}
+	if err := autoReplyQueue(ctx, log, a, mailFrom, part, subject, v.Reason, now); err != nil {
+		log.Errorx("queueing sieve vacation reply", err)
+		metricServerErrors.WithLabelValues("vacationreply").Inc()
+		return
+	}
+	log.Info("sieve vacation reply queued", slog.Any("to", mailFrom))
+}
@@ -0,0 +1,115 @@
+package smtpserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/srs"
+	"github.com/mjl-/mox/store"
+)
+
+// queueSRSForward queues copies of an accepted message to the ForwardTo addresses
+// of the destination of a. The SMTP MAIL FROM is rewritten with SRS to an address
+// at the domain of the destination, so SPF checks by the receiving mail server can
+// pass, and DSNs come back to us and can be sent on to the original sender.
+// Returns whether the message was queued. If not, the caller should deliver the
+// message locally.
+func queueSRSForward(ctx context.Context, log mlog.Log, a analysis, mailFrom smtp.Path, dataFile *os.File, prefix []byte, size int64, has8bit, smtputf8 bool, messageID string) bool {
+	dest := a.d.destination
+	if mailFrom.IsZero() || a.d.m.DSN {
+		// Forwarding DSNs would only risk bounce loops.
+		log.Info("not forwarding message with null reverse path or dsn, delivering locally")
+		return false
+	}
+
+	key, err := store.SRSKeyGet(ctx)
+	if err != nil {
+		log.Errorx("getting srs key for forwarding, delivering locally", err)
+		return false
+	}
+	sender, err := srs.Forward(key, mailFrom, a.d.deliverTo.IPDomain.Domain, time.Now())
+	if err != nil {
+		log.Infox("making srs address for forwarding, delivering locally", err, slog.Any("mailfrom", mailFrom))
+		return false
+	}
+
+	var qml []queue.Msg
+	for _, s := range dest.ForwardTo {
+		addr, err := smtp.ParseAddress(s)
+		if err != nil {
+			// Already checked during config validation.
+			log.Errorx("parsing forward address of destination", err, slog.String("address", s))
+			continue
+		}
+		qm := queue.MakeMsg(sender, addr.Path(), has8bit, smtputf8, size, messageID, prefix, nil, time.Now(), "")
+		qm.IsForward = true
+		qml = append(qml, qm)
+	}
+	if len(qml) == 0 {
+		return false
+	}
+	if err := queue.Add(ctx, log, a.d.acc.Name, dataFile, qml...); err != nil {
+		log.Errorx("queueing message for forwarding, delivering locally", err)
+		metricServerErrors.WithLabelValues("queueforward").Inc()
+		return false
+	}
+	log.Info("message queued for forwarding with srs", slog.Any("forwardto", dest.ForwardTo), slog.Any("sender", sender))
+	return true
+}
+
+// srsBounceRecipient returns the address to send a DSN on to if rcptTo is a valid
+// SRS address at one of our domains, used as SMTP MAIL FROM for a message we
+// forwarded earlier. Only for incoming messages with a null reverse path.
+func (c *conn) srsBounceRecipient(rcptTo smtp.Path) *smtp.Path {
+	if c.submission || !c.mailFrom.IsZero() || !srs.IsSRS(rcptTo.Localpart) {
+		return nil
+	}
+	if _, ok := mox.Conf.Domain(rcptTo.IPDomain.Domain); !ok {
+		return nil
+	}
+	key, err := store.SRSKeyGet(context.TODO())
+	if err != nil {
+		c.log.Errorx("getting srs key", err)
+		return nil
+	}
+	orig, err := srs.Reverse(key, rcptTo.Localpart, time.Now())
+	if err != nil {
+		c.log.Infox("srs address for incoming dsn not valid", err, slog.Any("rcptto", rcptTo))
+		return nil
+	}
+	return &orig
+}
+
+// relaySRSBounce queues an incoming DSN for an SRS address on to the original
+// sender of the message we forwarded. It is queued with a null reverse path, so
+// the rate limits and deduplication for outgoing DSNs apply.
+func (c *conn) relaySRSBounce(ctx context.Context, recvHdrFor func(string) string, msgWriter *message.Writer, dataFile *os.File) {
+	rcpt := c.recipients[0]
+	prefix := []byte(recvHdrFor(rcpt.Addr.String()))
+	qm := queue.MakeMsg(smtp.Path{}, *rcpt.SRSBounce, msgWriter.Has8bit, c.msgsmtputf8, int64(len(prefix))+msgWriter.Size, "", prefix, nil, time.Now(), "")
+	qm.IsForward = true
+	if err := queue.Add(ctx, c.log, mox.Conf.Static.Postmaster.Account, dataFile, qm); err != nil && errors.Is(err, queue.ErrDSNSuppressed) {
+		c.log.Infox("not relaying dsn for srs address", err, slog.Any("rcptto", rcpt.Addr), slog.Any("origsender", *rcpt.SRSBounce))
+		metricDelivery.WithLabelValues("srsbounce", "suppressed").Inc()
+	} else if err != nil {
+		c.log.Errorx("queueing dsn for srs address", err, slog.Any("rcptto", rcpt.Addr))
+		metricServerErrors.WithLabelValues("queuesrsbounce").Inc()
+		xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "error processing")
+	} else {
+		c.log.Info("dsn for srs address queued for original sender", slog.Any("rcptto", rcpt.Addr), slog.Any("origsender", *rcpt.SRSBounce))
+		metricDelivery.WithLabelValues("srsbounce", "queued").Inc()
+	}
+
+	c.transactionGood++
+	c.transactionBad-- // Compensate for early earlier pessimistic increase.
+	c.rset()
+	c.xwritecodeline(smtp.C250Completed, smtp.SeMailbox2Other0, "it is done", nil)
+}
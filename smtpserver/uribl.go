@@ -0,0 +1,130 @@
+package smtpserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/dnsbl"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/publicsuffix"
+	"github.com/mjl-/mox/store"
+)
+
+var metricURIBLLookup = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_smtpserver_uribl_lookup_total",
+		Help: "URI blocklist lookups of domains in URLs in incoming messages, per zone and result.",
+	},
+	[]string{
+		"zone",
+		"result", // pass (not listed), fail (listed), temperror
+	},
+)
+
+const (
+	uriblMaxDomains  = 10      // Domains from URLs looked up per message.
+	uriblMaxPartSize = 1 << 20 // Bytes of text read per message part for finding URLs.
+)
+
+// Scheme and host of http/https URLs, in plain text and in html, e.g. in href
+// attributes.
+var uriblURLRegexp = regexp.MustCompile(`(?i)\bhttps?://([^/\\\s"'<>()\[\]?#]+)`)
+
+// urlDomains returns the organizational domains of the hosts in http/https URLs
+// in the text and html parts of a message, in order of occurrence, without
+// duplicates, at most uriblMaxDomains. Hosts that are IP addresses are skipped.
+func urlDomains(ctx context.Context, log mlog.Log, p *message.Part) []dns.Domain {
+	var domains []dns.Domain
+	seen := map[dns.Domain]bool{}
+
+	// Text parts, including those of nested messages, e.g. forwarded.
+	err := p.WalkContent(func(p *message.Part, r io.Reader) error {
+		ct := p.MediaType + "/" + p.MediaSubType
+		if ct != "/" && !strings.HasPrefix(ct, "TEXT/") {
+			return nil
+		}
+		buf, err := io.ReadAll(io.LimitReader(message.DecodeReader(p.ContentTypeParams["charset"], r), uriblMaxPartSize))
+		if err != nil {
+			log.Debugx("reading message part for urls", err)
+			return nil
+		}
+		for _, l := range uriblURLRegexp.FindAllSubmatch(buf, -1) {
+			host := string(l[1])
+			// Strip userinfo and port.
+			if i := strings.LastIndexByte(host, '@'); i >= 0 {
+				host = host[i+1:]
+			}
+			if i := strings.IndexByte(host, ':'); i >= 0 {
+				host = host[:i]
+			}
+			host = strings.TrimSuffix(host, ".")
+			if host == "" || net.ParseIP(host) != nil {
+				continue
+			}
+			d, err := dns.ParseDomain(host)
+			if err != nil {
+				continue
+			}
+			d = publicsuffix.Lookup(ctx, log.Logger, d)
+			if seen[d] {
+				continue
+			}
+			seen[d] = true
+			domains = append(domains, d)
+			if len(domains) >= uriblMaxDomains {
+				return message.ErrStopWalk
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Debugx("walking message parts for urls", err)
+	}
+	return domains
+}
+
+// uriBlocklisted returns whether a domain in a URL in the message is listed in
+// one of the URI blocklists, with a description for the reason text. Lookups stop
+// at the first listing.
+func uriBlocklisted(ctx context.Context, log mlog.Log, resolver dns.Resolver, d delivery) (listed bool, reason string) {
+	p, err := message.Parse(log.Logger, false, store.FileMsgReader(d.m.MsgPrefix, d.dataFile))
+	if err != nil {
+		log.Debugx("parsing message for urls, not checking uribls", err)
+		return false, ""
+	}
+	if err := p.Walk(log.Logger, nil); err != nil {
+		log.Debugx("parsing message parts for urls, continuing with parts parsed so far", err)
+	}
+	domains := urlDomains(ctx, log, &p)
+	if len(domains) == 0 {
+		return false, ""
+	}
+
+	// Note: We don't check in parallel, like with DNSBLs.
+	for _, zone := range d.uriBLs {
+		for _, dom := range domains {
+			lookupctx, lookupcancel := context.WithTimeout(ctx, 30*time.Second)
+			status, expl, err := dnsbl.LookupDomain(lookupctx, log.Logger, resolver, zone, dom)
+			lookupcancel()
+			metricURIBLLookup.WithLabelValues(zone.Name(), string(status)).Inc()
+			if status == dnsbl.StatusFail {
+				log.Info("url domain listed in uribl", slog.Any("zone", zone), slog.Any("domain", dom), slog.String("explanation", expl))
+				return true, fmt.Sprintf("uribl: domain %s in url listed in %s", dom.XName(d.smtputf8), zone.XName(d.smtputf8))
+			} else if err != nil {
+				log.Infox("uribl lookup", err, slog.Any("zone", zone), slog.Any("domain", dom), slog.Any("status", status))
+			}
+		}
+	}
+	return false, fmt.Sprintf("domains in urls not blocklisted (%d checked)", len(domains))
+}
@@ -0,0 +1,189 @@
+package smtpserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// vacationSkipReason returns a non-empty reason if no automatic reply must be
+// sent for a delivered message, following the recommendations of RFC 3834 to
+// prevent mail loops and replies to mailing lists and automated messages.
+// Addresses are additional addresses of the recipient, besides the address the
+// message was delivered to, for checking the message was addressed to the
+// recipient.
+func vacationSkipReason(a analysis, mailFrom smtp.Path, h textproto.MIMEHeader, addresses []string) string {
+	m := a.d.m
+	if mailFrom.IsZero() {
+		return "null reverse path"
+	}
+	if m.DSN || m.Junk || m.IsMailingList || m.IsReject {
+		return "dsn, junk or mailing list message"
+	}
+	if !a.d.smtpRcptTo.Equal(a.d.deliverTo) {
+		return "delivered through alias"
+	}
+	if mailFrom.Equal(a.d.deliverTo) {
+		return "message from self"
+	}
+
+	// Addresses used by mailing list software and automated senders.
+	lp := strings.ToLower(string(mailFrom.Localpart))
+	if lp == "mailer-daemon" || lp == "postmaster" || lp == "listserv" || lp == "majordomo" || strings.HasPrefix(lp, "owner-") || strings.HasSuffix(lp, "-request") || strings.HasPrefix(lp, "noreply") || strings.HasPrefix(lp, "no-reply") {
+		return "automated sender address"
+	}
+
+	if s := strings.TrimSpace(h.Get("Auto-Submitted")); s != "" && !strings.EqualFold(s, "no") {
+		return "auto-submitted message"
+	}
+	switch strings.ToLower(strings.TrimSpace(h.Get("Precedence"))) {
+	case "bulk", "list", "junk":
+		return "bulk precedence"
+	}
+	for _, k := range []string{"List-Id", "List-Unsubscribe", "List-Post", "Feedback-ID"} {
+		if h.Get(k) != "" {
+			return "mailing list or bulk message"
+		}
+	}
+	// Used by Microsoft Exchange/Outlook.
+	for _, s := range strings.Split(h.Get("X-Auto-Response-Suppress"), ",") {
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "all", "oof", "autoreply":
+			return "auto response suppressed"
+		}
+	}
+
+	// Only reply to messages that were explicitly addressed to the recipient, not for
+	// blind copies.
+	var addressed bool
+	for _, addr := range append(append([]message.Address{}, a.d.msgTo...), a.d.msgCc...) {
+		if strings.EqualFold(addr.User, string(a.d.deliverTo.Localpart)) && strings.EqualFold(addr.Host, a.d.deliverTo.IPDomain.Domain.ASCII) {
+			addressed = true
+			break
+		}
+		if slices.ContainsFunc(addresses, func(s string) bool { return strings.EqualFold(s, addr.User+"@"+addr.Host) }) {
+			addressed = true
+			break
+		}
+	}
+	if !addressed {
+		return "recipient not in to or cc"
+	}
+	return ""
+}
+
+// queueVacationReply queues an automatic reply for a delivered message if the
+// account has an active vacation configured and the sender did not get a reply
+// recently. The reply is sent with a null reverse path, so failures won't cause
+// DSNs, and with an Auto-Submitted header, so other responders won't reply.
+func queueVacationReply(ctx context.Context, log mlog.Log, a analysis, mailFrom smtp.Path, part message.Part, now time.Time) {
+	h, err := part.Header()
+	if err != nil {
+		log.Debugx("parsing message header for vacation reply", err)
+		return
+	}
+	if reason := vacationSkipReason(a, mailFrom, h, nil); reason != "" {
+		log.Debug("not sending vacation reply", slog.String("reason", reason))
+		return
+	}
+
+	v, reply, err := a.d.acc.VacationReplyCheck(ctx, mailFrom.String(), now)
+	if err != nil {
+		log.Errorx("checking for vacation reply", err)
+		return
+	} else if !reply {
+		return
+	}
+
+	if err := autoReplyQueue(ctx, log, a, mailFrom, part, v.Subject, v.Body, now); err != nil {
+		log.Errorx("queueing vacation reply", err)
+		metricServerErrors.WithLabelValues("vacationreply").Inc()
+		return
+	}
+	log.Info("vacation reply queued", slog.Any("to", mailFrom))
+}
+
+// autoReplyQueue composes an automatic reply, e.g. for a vacation, DKIM-signs it
+// and adds it to the queue.
+func autoReplyQueue(ctx context.Context, log mlog.Log, a analysis, mailFrom smtp.Path, part message.Part, subject, body string, now time.Time) (rerr error) {
+	from := a.d.deliverTo
+	smtputf8 := from.Localpart.IsInternational() || mailFrom.Localpart.IsInternational()
+
+	var fromName string
+	if accConf, ok := a.d.acc.Conf(); ok {
+		fromName = accConf.FullName
+	}
+	if a.d.destination.FullName != "" {
+		fromName = a.d.destination.FullName
+	}
+
+	var b bytes.Buffer
+	xc := message.NewComposer(&b, 1024*1024, smtputf8)
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(error); ok && errors.Is(err, message.ErrCompose) {
+			rerr = err
+			return
+		}
+		panic(x)
+	}()
+
+	fromAddr := smtp.Address{Localpart: from.Localpart, Domain: from.IPDomain.Domain}
+	toAddr := smtp.Address{Localpart: mailFrom.Localpart, Domain: mailFrom.IPDomain.Domain}
+	xc.HeaderAddrs("From", []message.NameAddress{{DisplayName: fromName, Address: fromAddr}})
+	xc.HeaderAddrs("To", []message.NameAddress{{Address: toAddr}})
+	xc.Subject(subject)
+	messageID := fmt.Sprintf("<%s>", mox.MessageIDGen(xc.SMTPUTF8))
+	xc.Header("Message-Id", messageID)
+	xc.Header("Date", now.Format(message.RFC5322Z))
+	if part.Envelope != nil && part.Envelope.MessageID != "" {
+		xc.Header("In-Reply-To", part.Envelope.MessageID)
+		xc.Header("References", part.Envelope.MessageID)
+	}
+	// ../rfc/3834
+	xc.Header("Auto-Submitted", "auto-replied")
+	xc.Header("X-Auto-Response-Suppress", "All")
+	xc.Header("User-Agent", "mox/"+moxvar.Version)
+	xc.Header("MIME-Version", "1.0")
+	textBody, ct, cte := xc.TextPart("plain", body)
+	xc.Header("Content-Type", ct)
+	xc.Header("Content-Transfer-Encoding", cte)
+	xc.Line()
+	xc.Write(textBody)
+	xc.Flush()
+	has8bit := xc.Has8bit || cte == "8bit"
+
+	dkimHeader, err := mox.DKIMSign(ctx, log, from, smtputf8, b.Bytes())
+	log.Check(err, "dkim signing automatic reply")
+
+	f, err := store.CreateMessageTemp(log, "smtp-autoreply")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer store.CloseRemoveTempFile(log, f, "smtpserver automatic reply")
+	if _, err := f.Write(b.Bytes()); err != nil {
+		return fmt.Errorf("writing automatic reply: %w", err)
+	}
+
+	// Sent with null reverse path. ../rfc/3834
+	size := int64(len(dkimHeader) + b.Len())
+	qm := queue.MakeMsg(smtp.Path{}, mailFrom, has8bit, smtputf8, size, messageID, []byte(dkimHeader), nil, now, subject)
+	return queue.Add(ctx, log, a.d.acc.Name, f, qm)
+}
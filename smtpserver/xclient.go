@@ -0,0 +1,197 @@
+package smtpserver
+
+import (
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+)
+
+// Attributes announced for XCLIENT and XFORWARD. We only use ADDR and HELO, the
+// others are accepted and ignored.
+// https://www.postfix.org/XCLIENT_README.html
+// https://www.postfix.org/XFORWARD_README.html
+var xclientAttrs = []string{"ADDR", "NAME", "PORT", "PROTO", "HELO"}
+var xforwardAttrs = []string{"ADDR", "NAME", "PORT", "PROTO", "HELO", "IDENT", "SOURCE"}
+
+// xforwardOrig is the connection state replaced by XFORWARD for a single
+// transaction.
+type xforwardOrig struct {
+	remoteIP   net.IP
+	upstreamIP net.IP
+	hello      dns.IPDomain
+}
+
+// xclientAttributes holds the used attributes of XCLIENT/XFORWARD commands.
+type xclientAttributes struct {
+	addr  net.IP       // Nil if absent or unavailable.
+	hello dns.IPDomain // Zero if absent or unavailable.
+}
+
+// xparseAttributes parses space-separated "name=xtext" attributes of an XCLIENT
+// or XFORWARD command. At least one attribute is required.
+func (p *parser) xparseAttributes(allowed []string) xclientAttributes {
+	var r xclientAttributes
+	for {
+		p.xspace()
+		name := strings.ToUpper(p.xparamKeyword())
+		if !slices.Contains(allowed, name) {
+			p.xerrorf("unknown attribute %q", name)
+		}
+		p.xtake("=")
+		value := p.xtext()
+		if value == "[UNAVAILABLE]" || value == "[TEMPUNAVAIL]" {
+			value = ""
+		}
+		switch name {
+		case "ADDR":
+			if value == "" {
+				break
+			}
+			s := value
+			if len(s) > len("IPV6:") && strings.EqualFold(s[:len("IPV6:")], "IPV6:") {
+				s = s[len("IPV6:"):]
+			}
+			r.addr = net.ParseIP(s)
+			if r.addr == nil {
+				p.xerrorf("invalid ip address %q", value)
+			}
+		case "HELO":
+			if value == "" {
+				break
+			}
+			// The upstream server passes along whatever the client sent. An invalid name is
+			// treated like an absent one, as HELO checks would for the client.
+			r.hello = parseHello(p.conn, value)
+			if r.hello.IsZero() {
+				p.conn.log.Debug("ignoring invalid helo from upstream server", slog.String("helo", value))
+			}
+		}
+		if p.empty() {
+			break
+		}
+	}
+	return r
+}
+
+// parseHello parses a HELO/EHLO name, as domain or address literal, returning a
+// zero value if it is invalid.
+func parseHello(c *conn, s string) (r dns.IPDomain) {
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if _, ok := x.(smtpError); ok {
+			r = dns.IPDomain{}
+			return
+		}
+		panic(x)
+	}()
+
+	p := newParser(s, false, c)
+	r = p.xipdomain(true)
+	p.xempty()
+	return r
+}
+
+// xsetRemoteIP changes the remote IP for the connection to that of the original
+// client of a trusted upstream server, for use in SPF, iprev, rate limiting, etc.
+func (c *conn) xsetRemoteIP(ip net.IP) {
+	if c.upstreamIP == nil {
+		c.upstreamIP = c.remoteIP
+	}
+	c.remoteIP = ip
+
+	// The upstream server was allowed to connect, the original client must be allowed
+	// too.
+	if !mox.IPAccessAllowedLog(c.remoteIP, "smtp", c.ipAccess...) {
+		c.xwritecodeline(smtp.C421ServiceUnavail, smtp.SePol7Other0, "client ip not allowed", nil)
+		panic(errIO)
+	}
+	if !limiterConnectionRate.Add(c.remoteIP, time.Now(), 1) {
+		c.xwritecodeline(smtp.C421ServiceUnavail, smtp.SePol7Other0, "connection rate from client ip or network too high, slow down please", nil)
+		panic(errIO)
+	}
+	if !c.unknownRcptLimiter.CanAdd(c.remoteIP, time.Now(), 1) {
+		c.log.Debug("refusing client due to many unknown recipients", slog.Any("remoteip", c.remoteIP))
+		c.xwritecodeline(smtp.C421ServiceUnavail, smtp.SePol7Other0, "too many unknown recipients from client ip or network, try again later", nil)
+		panic(errIO)
+	}
+}
+
+// cmdXclient handles XCLIENT from a trusted upstream server, changing the remote
+// IP and HELO name for the remainder of the connection. The session is reset, and
+// a new greeting is sent, after which the upstream server must send EHLO again.
+// https://www.postfix.org/XCLIENT_README.html
+func (c *conn) cmdXclient(p *parser) {
+	if c.xclient == nil {
+		// Not announced.
+		xsmtpUserErrorf(smtp.C500BadSyntax, smtp.SeProto5BadCmdOrSeq1, "unknown command")
+	}
+	if c.mailFrom != nil {
+		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "xclient not allowed during transaction")
+	}
+
+	attrs := p.xparseAttributes(xclientAttrs)
+
+	c.rset()
+	if attrs.addr != nil {
+		c.xsetRemoteIP(attrs.addr)
+	}
+	if !attrs.hello.IsZero() {
+		c.xclientHello = attrs.hello
+	}
+	c.log.Debug("xclient", slog.Any("upstreamip", c.upstreamIP), slog.Any("clienthello", c.xclientHello))
+
+	// Like a new connection.
+	c.hello = dns.IPDomain{}
+	c.ehlo = false
+	c.xbwritelinef("%d %s", smtp.C220ServiceReady, c.greetingText())
+}
+
+// cmdXforward handles XFORWARD from a trusted upstream server, changing the remote
+// IP and HELO name for the next transaction only.
+// https://www.postfix.org/XFORWARD_README.html
+func (c *conn) cmdXforward(p *parser) {
+	if c.xclient == nil {
+		// Not announced.
+		xsmtpUserErrorf(smtp.C500BadSyntax, smtp.SeProto5BadCmdOrSeq1, "unknown command")
+	}
+	c.xneedHello()
+	if c.mailFrom != nil {
+		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "xforward not allowed during transaction")
+	}
+
+	attrs := p.xparseAttributes(xforwardAttrs)
+
+	if c.xforwardOrig == nil {
+		c.xforwardOrig = &xforwardOrig{c.remoteIP, c.upstreamIP, c.hello}
+	}
+	if attrs.addr != nil {
+		c.xsetRemoteIP(attrs.addr)
+	}
+	if !attrs.hello.IsZero() {
+		c.hello = attrs.hello
+	}
+	c.log.Debug("xforward", slog.Any("upstreamip", c.upstreamIP), slog.Any("clienthello", c.hello))
+
+	c.xbwritecodeline(smtp.C250Completed, smtp.SeOther00, "ok", nil)
+}
+
+// xforwardRestore restores the state replaced by XFORWARD, at the end of a
+// transaction.
+func (c *conn) xforwardRestore() {
+	if c.xforwardOrig == nil {
+		return
+	}
+	c.remoteIP = c.xforwardOrig.remoteIP
+	c.upstreamIP = c.xforwardOrig.upstreamIP
+	c.hello = c.xforwardOrig.hello
+	c.xforwardOrig = nil
+}
@@ -291,6 +291,55 @@ func Evaluate(ctx context.Context, elog *slog.Logger, record *Record, resolver d
 	return evaluate(ctx, log, record, resolver, args)
 }
 
+// DNSLookups returns the number of DNS lookups that evaluating record can take in the worst case, i.e. when no directive matches. Records of
+// include mechanisms and redirect modifiers are looked up and counted
+// recursively. Domain specifications with macros are counted but not followed.
+// Evaluation fails with a permerror when more than 10 lookups are needed.
+// ../rfc/7208:1396
+func DNSLookups(ctx context.Context, elog *slog.Logger, resolver dns.Resolver, record *Record) (int, error) {
+	n := 0
+	var count func(r *Record, depth int) error
+	count = func(r *Record, depth int) error {
+		if depth > dnsRequestsMax {
+			return ErrTooManyDNSRequests
+		}
+		follow := func(spec string) error {
+			if strings.Contains(spec, "%") {
+				return nil
+			}
+			d, err := dns.ParseDomain(spec)
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrName, err)
+			}
+			_, _, nr, _, err := Lookup(ctx, elog, resolver, d)
+			if err != nil {
+				return fmt.Errorf("looking up spf record for %s: %w", d, err)
+			}
+			return count(nr, depth+1)
+		}
+		for _, d := range r.Directives {
+			switch d.Mechanism {
+			case "include":
+				n++
+				if err := follow(d.DomainSpec); err != nil {
+					return err
+				}
+			case "a", "mx", "ptr", "exists":
+				n++
+			}
+		}
+		if r.Redirect != "" {
+			n++
+			if err := follow(r.Redirect); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := count(record, 0)
+	return n, err
+}
+
 // evaluate RemoteIP against domain from args, given record.
 func evaluate(ctx context.Context, log mlog.Log, record *Record, resolver dns.Resolver, args Args) (rstatus Status, mechanism, rexplanation string, rauthentic bool, rerr error) {
 	start := time.Now()
@@ -521,3 +521,37 @@ func TestEvaluate(t *testing.T) {
 		t.Fatalf("got status %q, mechanism %q, err %v, expected neutral, default, no error", status, mechanism, err)
 	}
 }
+
+func TestDNSLookups(t *testing.T) {
+	resolver := dns.MockResolver{
+		TXT: map[string][]string{
+			"inc.example.":      {"v=spf1 a mx include:inc2.example -all"},
+			"inc2.example.":     {"v=spf1 ip4:10.0.0.1 exists:%{i}.example -all"},
+			"loop.example.":     {"v=spf1 include:loop.example -all"},
+			"redirect.example.": {"v=spf1 redirect=inc.example"},
+		},
+	}
+
+	test := func(txt string, exp int, expErr error) {
+		t.Helper()
+		r, _, err := ParseRecord(txt)
+		if err != nil {
+			t.Fatalf("parsing record %q: %v", txt, err)
+		}
+		n, err := DNSLookups(context.Background(), pkglog.Logger, resolver, r)
+		if (err == nil) != (expErr == nil) || err != nil && !errors.Is(err, expErr) {
+			t.Fatalf("dns lookups for %q: got err %v, expected %v", txt, err, expErr)
+		}
+		if err == nil && n != exp {
+			t.Fatalf("dns lookups for %q: got %d, expected %d", txt, n, exp)
+		}
+	}
+
+	test("v=spf1 ip4:10.0.0.1 -all", 0, nil)
+	test("v=spf1 a mx ptr -all", 3, nil)
+	test("v=spf1 include:inc.example -all", 5, nil)
+	test("v=spf1 redirect=redirect.example", 6, nil)
+	test("v=spf1 include:%{d}.example -all", 1, nil)
+	test("v=spf1 include:missing.example -all", 0, ErrNoRecord)
+	test("v=spf1 include:loop.example -all", 0, ErrTooManyDNSRequests)
+}
@@ -0,0 +1,169 @@
+// Package srs implements the Sender Rewriting Scheme, for forwarding messages
+// without breaking SPF.
+//
+// When forwarding a message to another domain, the original SMTP MAIL FROM
+// cannot be kept: the receiving server would evaluate SPF for the original
+// sender domain against the IP of the forwarding server, and fail. With SRS, the
+// forwarder uses an address in its own domain as MAIL FROM, with the original
+// address encoded in the localpart, protected by a hash and with a timestamp. A
+// DSN for the forwarded message is sent to the SRS address, the forwarder
+// verifies the hash and timestamp, and sends the DSN on to the original sender.
+//
+// An SRS0 address looks like:
+//
+//	SRS0=HHHH=TT=example.org=user@forwarder.example
+//
+// With HHHH a hash, TT a timestamp with day precision, and "example.org" and
+// "user" the domain and localpart of the original address. When forwarding a
+// message that already has an SRS address as MAIL FROM, an SRS1 address is made,
+// referencing the first forwarder, so addresses don't keep growing:
+//
+//	SRS1=HHHH=forwarder.example==HHHH=TT=example.org=user@second.example
+package srs
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+)
+
+var (
+	ErrInvalid = errors.New("srs: malformed address")
+	ErrVerify  = errors.New("srs: hash verification failed")
+	ErrExpired = errors.New("srs: address expired")
+)
+
+// MaxAge is the maximum age of an SRS address that is accepted by Reverse.
+// Bounces typically arrive well within this period.
+const MaxAge = 21 * 24 * time.Hour
+
+// Timestamps are days since the epoch, modulo 1024, encoded as 2 base32 characters.
+const timeBase32 = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+const timeSlots = 1024
+
+// IsSRS returns whether the localpart looks like an SRS0 or SRS1 address.
+func IsSRS(lp smtp.Localpart) bool {
+	s := strings.ToUpper(string(lp))
+	return strings.HasPrefix(s, "SRS0=") || strings.HasPrefix(s, "SRS1=")
+}
+
+// hash returns the first 4 characters of the base64-encoded HMAC-SHA1 of the
+// lower-cased parts, signed with key.
+func hash(key []byte, parts ...string) string {
+	mac := hmac.New(sha1.New, key)
+	for _, p := range parts {
+		mac.Write([]byte(strings.ToLower(p)))
+	}
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))[:4]
+}
+
+func timestamp(now time.Time) string {
+	days := (now.Unix() / (24 * 3600)) % timeSlots
+	return string([]byte{timeBase32[days>>5], timeBase32[days&31]})
+}
+
+// Forward returns the SRS address to use as SMTP MAIL FROM when forwarding a
+// message with SMTP MAIL FROM "orig" from domain "forwarder". The original must
+// not be a null reverse path, those can be forwarded as is. An error is returned
+// for original addresses with an IP address instead of a domain.
+func Forward(key []byte, orig smtp.Path, forwarder dns.Domain, now time.Time) (smtp.Path, error) {
+	if orig.IPDomain.IsIP() || orig.IPDomain.IsZero() {
+		return smtp.Path{}, fmt.Errorf("%w: original address must have a domain", ErrInvalid)
+	}
+	lp := string(orig.Localpart)
+	dom := orig.IPDomain.Domain.ASCII
+
+	var nlp string
+	switch {
+	case strings.HasPrefix(strings.ToUpper(lp), "SRS0="):
+		// Original sender is an SRS forwarder. We reference it, and keep its
+		// address (without the "SRS0") intact so it can verify its own hash.
+		rem := lp[len("SRS0"):]
+		nlp = "SRS1=" + hash(key, dom, rem) + "=" + dom + "=" + rem
+	case strings.HasPrefix(strings.ToUpper(lp), "SRS1="):
+		// Keep the reference to the first forwarder, only replace the hash.
+		t := strings.SplitN(lp, "=", 4)
+		if len(t) != 4 || !strings.HasPrefix(t[3], "=") {
+			return smtp.Path{}, fmt.Errorf("%w: malformed srs1 address", ErrInvalid)
+		}
+		rem := t[3]
+		nlp = "SRS1=" + hash(key, t[2], rem) + "=" + t[2] + "=" + rem
+	default:
+		ts := timestamp(now)
+		nlp = "SRS0=" + hash(key, ts, dom, lp) + "=" + ts + "=" + dom + "=" + lp
+	}
+	return smtp.Path{Localpart: smtp.Localpart(nlp), IPDomain: dns.IPDomain{Domain: forwarder}}, nil
+}
+
+// Reverse verifies the SRS localpart of an address in our domain and returns the
+// address to send a DSN to. For SRS0 addresses, this is the original sender. For
+// SRS1 addresses, this is the SRS0 address at the first forwarder.
+func Reverse(key []byte, lp smtp.Localpart, now time.Time) (smtp.Path, error) {
+	s := string(lp)
+	if !IsSRS(lp) {
+		return smtp.Path{}, fmt.Errorf("%w: not an srs address", ErrInvalid)
+	}
+
+	var nlp, dom string
+	if strings.HasPrefix(strings.ToUpper(s), "SRS0=") {
+		t := strings.SplitN(s, "=", 5)
+		if len(t) != 5 || t[4] == "" {
+			return smtp.Path{}, fmt.Errorf("%w: malformed srs0 address", ErrInvalid)
+		}
+		h, ts := t[1], t[2]
+		dom, nlp = t[3], t[4]
+		if !hmac.Equal([]byte(strings.ToLower(h)), []byte(strings.ToLower(hash(key, ts, dom, nlp)))) {
+			return smtp.Path{}, ErrVerify
+		}
+		if err := checkTimestamp(ts, now); err != nil {
+			return smtp.Path{}, err
+		}
+	} else {
+		t := strings.SplitN(s, "=", 4)
+		if len(t) != 4 || !strings.HasPrefix(t[3], "=") {
+			return smtp.Path{}, fmt.Errorf("%w: malformed srs1 address", ErrInvalid)
+		}
+		h, rem := t[1], t[3]
+		dom = t[2]
+		if !hmac.Equal([]byte(strings.ToLower(h)), []byte(strings.ToLower(hash(key, dom, rem)))) {
+			return smtp.Path{}, ErrVerify
+		}
+		nlp = "SRS0" + rem
+	}
+
+	d, err := dns.ParseDomain(dom)
+	if err != nil {
+		return smtp.Path{}, fmt.Errorf("%w: parsing domain: %v", ErrInvalid, err)
+	}
+	olp, err := smtp.ParseLocalpart(nlp)
+	if err != nil {
+		return smtp.Path{}, fmt.Errorf("%w: parsing localpart: %v", ErrInvalid, err)
+	}
+	return smtp.Path{Localpart: olp, IPDomain: dns.IPDomain{Domain: d}}, nil
+}
+
+func checkTimestamp(ts string, now time.Time) error {
+	ts = strings.ToUpper(ts)
+	if len(ts) != 2 {
+		return fmt.Errorf("%w: bad timestamp length", ErrInvalid)
+	}
+	a := strings.IndexByte(timeBase32, ts[0])
+	b := strings.IndexByte(timeBase32, ts[1])
+	if a < 0 || b < 0 {
+		return fmt.Errorf("%w: bad timestamp characters", ErrInvalid)
+	}
+	then := int64(a<<5 | b)
+	days := (now.Unix() / (24 * 3600)) % timeSlots
+	age := (days - then + timeSlots) % timeSlots
+	if age > int64(MaxAge/(24*time.Hour)) {
+		return fmt.Errorf("%w: %d days old", ErrExpired, age)
+	}
+	return nil
+}
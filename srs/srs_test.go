@@ -0,0 +1,98 @@
+package srs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+)
+
+func TestSRS(t *testing.T) {
+	key := []byte("secret")
+	key2 := []byte("other secret")
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	parse := func(s string) smtp.Path {
+		t.Helper()
+		a, err := smtp.ParseAddress(s)
+		if err != nil {
+			t.Fatalf("parse address %q: %v", s, err)
+		}
+		return a.Path()
+	}
+
+	forwarder := dns.Domain{ASCII: "forwarder.example"}
+	second := dns.Domain{ASCII: "second.example"}
+
+	orig := parse("user@example.org")
+	fwd, err := Forward(key, orig, forwarder, now)
+	if err != nil {
+		t.Fatalf("forward: %v", err)
+	}
+	if !IsSRS(fwd.Localpart) || fwd.IPDomain.Domain != forwarder {
+		t.Fatalf("unexpected srs address %s", fwd)
+	}
+
+	rev, err := Reverse(key, fwd.Localpart, now.Add(5*24*time.Hour))
+	if err != nil {
+		t.Fatalf("reverse: %v", err)
+	}
+	if !rev.Equal(orig) {
+		t.Fatalf("reverse gave %s, expected %s", rev, orig)
+	}
+
+	// Hash is compared case-insensitively, some mail servers lower-case addresses.
+	_, err = Reverse(key, smtp.Localpart(strings.ToLower(string(fwd.Localpart))), now)
+	if err != nil {
+		t.Fatalf("reverse lower-cased: %v", err)
+	}
+
+	if _, err := Reverse(key2, fwd.Localpart, now); !errors.Is(err, ErrVerify) {
+		t.Fatalf("reverse with other key: got %v, expected ErrVerify", err)
+	}
+	if _, err := Reverse(key, fwd.Localpart, now.Add(MaxAge+24*time.Hour)); !errors.Is(err, ErrExpired) {
+		t.Fatalf("reverse expired: got %v, expected ErrExpired", err)
+	}
+	if _, err := Reverse(key, "user", now); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("reverse non-srs: got %v, expected ErrInvalid", err)
+	}
+	if _, err := Reverse(key, "SRS0=bad", now); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("reverse malformed: got %v, expected ErrInvalid", err)
+	}
+
+	// Forwarding again results in SRS1, referencing the first forwarder.
+	fwd2, err := Forward(key2, fwd, second, now)
+	if err != nil {
+		t.Fatalf("forward srs0: %v", err)
+	}
+	if string(fwd2.Localpart[:5]) != "SRS1=" {
+		t.Fatalf("expected srs1 address, got %s", fwd2)
+	}
+	rev2, err := Reverse(key2, fwd2.Localpart, now)
+	if err != nil {
+		t.Fatalf("reverse srs1: %v", err)
+	}
+	if !rev2.Equal(fwd) {
+		t.Fatalf("reverse srs1 gave %s, expected %s", rev2, fwd)
+	}
+
+	// Forwarding an SRS1 address keeps referencing the first forwarder.
+	fwd3, err := Forward(key, fwd2, dns.Domain{ASCII: "third.example"}, now)
+	if err != nil {
+		t.Fatalf("forward srs1: %v", err)
+	}
+	rev3, err := Reverse(key, fwd3.Localpart, now)
+	if err != nil {
+		t.Fatalf("reverse forwarded srs1: %v", err)
+	}
+	if !rev3.Equal(fwd) {
+		t.Fatalf("reverse forwarded srs1 gave %s, expected %s", rev3, fwd)
+	}
+
+	if _, err := Forward(key, smtp.Path{}, forwarder, now); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("forward null path: got %v, expected ErrInvalid", err)
+	}
+}
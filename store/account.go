@@ -38,6 +38,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"runtime/debug"
 	"slices"
 	"sort"
@@ -152,6 +153,25 @@ func (c *CRAMMD5) UnmarshalBinary(buf []byte) error {
 	return nil
 }
 
+// MarshalJSON stores the binary form of the ipad/opad hash states, for account
+// journals.
+func (c CRAMMD5) MarshalJSON() ([]byte, error) {
+	buf, err := c.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(buf)
+}
+
+// UnmarshalJSON restores the hash states stored with MarshalJSON.
+func (c *CRAMMD5) UnmarshalJSON(buf []byte) error {
+	var xbuf []byte
+	if err := json.Unmarshal(buf, &xbuf); err != nil {
+		return err
+	}
+	return c.UnmarshalBinary(xbuf)
+}
+
 // Password holds credentials in various forms, for logging in with SMTP/IMAP.
 type Password struct {
 	Hash        string  // bcrypt hash for IMAP LOGIN, SASL PLAIN and HTTP basic authentication.
@@ -564,10 +584,33 @@ type Message struct {
 	// at the subject when matching threads.
 	DSN bool
 
-	ReceivedTLSVersion     uint16 // 0 if unknown, 1 if plaintext/no TLS, otherwise TLS cipher suite.
+	ReceivedTLSVersion     uint16 // 0 if unknown, 1 if plaintext/no TLS, otherwise TLS version.
 	ReceivedTLSCipherSuite uint16
 	ReceivedRequireTLS     bool // Whether RequireTLS was known to be used for incoming delivery.
 
+	// Fingerprint of the public key of the TLS client certificate presented during
+	// incoming delivery, if any. Raw-url-base64-encoded SHA-256 of the Subject Public
+	// Key Info, like TLSPublicKey.Fingerprint.
+	ReceivedTLSClientCert string
+
+	// Whether the message is signed and/or encrypted, "smime" or "pgp", determined at
+	// delivery based on the MIME structure. At delivery, keywords are set for these
+	// fields, see SignatureKeywords.
+	Signed    string
+	Encrypted string
+	// Result of verifying a signature at delivery, as in the "smime" method of
+	// Authentication-Results (RFC 7281): "pass", "fail", "policy" or "permerror". S/MIME
+	// signatures are verified against trusted CA certificates. PGP signatures are
+	// verified against the keys in the OpenPGP keyring of the account and published
+	// keys of local accounts, with result "neutral" if no key of the signer is known.
+	SignatureResult string
+	SignatureSigner string // Email address of signer certificate or key, for results pass and policy.
+
+	// Result of the junk filter classification during incoming delivery, with the
+	// most significant words. Nil if the message was not classified, e.g. because the
+	// sender had a good reputation or the message was not delivered over SMTP.
+	JunkClassification *JunkClassification
+
 	Flags
 	// For keywords other than system flags or the basic well-known $-flags. Only in
 	// "atom" syntax (IMAP), they are case-insensitive, always stored in lower-case
@@ -618,6 +661,35 @@ func (m Message) MailboxCounts() (mc MailboxCounts) {
 	return
 }
 
+// Keywords set on messages at delivery for signed and encrypted messages, for
+// IMAP clients to show and search.
+const (
+	KeywordSigned           = "$signed"
+	KeywordEncrypted        = "$encrypted"
+	KeywordSignatureValid   = "$signaturevalid"   // SignatureResult "pass".
+	KeywordSignatureInvalid = "$signatureinvalid" // SignatureResult "fail".
+)
+
+// SignatureKeywords returns the keywords for the signature and encryption fields
+// of the message, sorted.
+func (m Message) SignatureKeywords() []string {
+	var l []string
+	if m.Signed != "" {
+		l = append(l, KeywordSigned)
+	}
+	if m.Encrypted != "" {
+		l = append(l, KeywordEncrypted)
+	}
+	switch m.SignatureResult {
+	case "pass":
+		l = append(l, KeywordSignatureValid)
+	case "fail":
+		l = append(l, KeywordSignatureInvalid)
+	}
+	slices.Sort(l)
+	return l
+}
+
 func (m Message) ChangeAddUID(mb Mailbox) ChangeAddUID {
 	return ChangeAddUID{m.MailboxID, m.UID, m.ModSeq, m.Flags, m.Keywords, mb.MessageCountIMAP(), uint32(mb.MailboxCounts.Unseen)}
 }
@@ -807,11 +879,17 @@ type LoginSession struct {
 	ID                 int64
 	Created            time.Time `bstore:"nonzero,default now"` // Of original login.
 	Expires            time.Time `bstore:"nonzero"`             // Extended each time it is used.
-	SessionTokenBinary [16]byte  `bstore:"nonzero"`             // Stored in cookie, like "webmailsession" or "webaccountsession".
-	CSRFTokenBinary    [16]byte  // For API requests, in "x-mox-csrf" header.
+	SessionTokenBinary [16]byte  `bstore:"nonzero" json:"-"`    // Stored in cookie, like "webmailsession" or "webaccountsession".
+	CSRFTokenBinary    [16]byte  `json:"-"`                     // For API requests, in "x-mox-csrf" header.
 	AccountName        string    `bstore:"nonzero"`
 	LoginAddress       string    `bstore:"nonzero"`
 
+	// Of most recent use, for showing active sessions to the user. Written to the
+	// database with a delay, like Expires.
+	LastUsed  time.Time
+	RemoteIP  string
+	UserAgent string
+
 	// Set when loading from database.
 	sessionToken SessionToken
 	csrfToken    CSRFToken
@@ -847,14 +925,16 @@ type Settings struct {
 	ShowHeaders []string
 }
 
-// ViewMode how a message should be viewed: its text parts, html parts, or html
-// with loading external resources.
+// ViewMode how a message should be viewed: its text parts, html parts, html with
+// remote images loaded through the image proxy of the server, or html with loading
+// external resources.
 type ViewMode string
 
 const (
-	ModeText    ViewMode = "text"
-	ModeHTML    ViewMode = "html"
-	ModeHTMLExt ViewMode = "htmlext" // HTML with external resources.
+	ModeText      ViewMode = "text"
+	ModeHTML      ViewMode = "html"
+	ModeHTMLProxy ViewMode = "htmlproxy" // HTML with remote images loaded through proxy.
+	ModeHTMLExt   ViewMode = "htmlext"   // HTML with external resources.
 )
 
 // FromAddressSettings are webmail client settings per "From" address.
@@ -930,6 +1010,17 @@ var DBTypes = []any{
 	RulesetNoMailbox{},
 	Annotation{},
 	MessageErase{},
+	SieveScript{},
+	SieveVacationReply{},
+	TOTP{},
+	TOTPRecoveryCode{},
+	URLAuthKey{},
+	DigestState{},
+	SenderAllow{},
+	Vacation{},
+	VacationReply{},
+	RetireReply{},
+	OpenPGPKeyringKey{},
 }
 
 // Account holds the information about a user, includings mailboxes, messages, imap subscriptions.
@@ -967,6 +1058,13 @@ type Account struct {
 	// releasing the lock to ensure proper UID ordering.
 	sync.RWMutex
 
+	// Cached mailbox tree, cleared when mailboxes or subscriptions change. The
+	// generation is incremented on each invalidation, so a tree read from the
+	// database concurrently with a change isn't cached.
+	mailboxTreeMutex sync.Mutex
+	mailboxTree      *MailboxTree
+	mailboxTreeGen   int64
+
 	// Reference count, while >0, this account is alive and shared. Protected by
 	// openAccounts, not by account wlock.
 	nused   int
@@ -1081,6 +1179,26 @@ func removeAccount(log mlog.Log, accountName string) error {
 		if err := loginAttemptRemoveAccount(tx, accountName); err != nil {
 			return fmt.Errorf("removing historic login attempts for account: %v", err)
 		}
+
+		if err := messageShareRemoveForAccount(tx, accountName); err != nil {
+			return fmt.Errorf("removing message share links for account: %v", err)
+		}
+
+		if err := attachmentLinkRemoveForAccount(tx, accountName); err != nil {
+			return fmt.Errorf("removing attachment links for account: %v", err)
+		}
+
+		if err := openPGPKeyRemoveForAccount(tx, accountName); err != nil {
+			return fmt.Errorf("removing openpgp keys for account: %v", err)
+		}
+
+		if err := oauthTokenRemoveForAccount(tx, accountName); err != nil {
+			return fmt.Errorf("removing oauth tokens for account: %v", err)
+		}
+
+		if err := apiTokenRemoveForAccount(tx, accountName); err != nil {
+			return fmt.Errorf("removing api tokens for account: %v", err)
+		}
 		return nil
 	})
 	if err != nil {
@@ -1507,6 +1625,24 @@ func OpenAccountDB(log mlog.Log, accountDir, accountName string) (a *Account, re
 		}
 	}
 
+	// Remove CRAM-MD5 secrets if no listener accepts CRAM-MD5 authentication anymore.
+	// They are derived from the password with little protection.
+	if mox.Conf.CRAMMD5Disabled() {
+		err := acc.DB.Write(context.TODO(), func(tx *bstore.Tx) error {
+			return bstore.QueryTx[Password](tx).ForEach(func(pw Password) error {
+				if pw.CRAMMD5.Ipad == nil {
+					return nil
+				}
+				log.Info("removing cram-md5 secrets from account, plaintext authentication is disabled for all listeners")
+				pw.CRAMMD5 = CRAMMD5{}
+				return tx.Update(&pw)
+			})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("removing cram-md5 secrets: %v", err)
+		}
+	}
+
 	if up.MessageParseVersion != MessageParseVersionLatest {
 		log.Debug("upgrade: reparsing message for mime structures for new message parse version", slog.Int("current", up.MessageParseVersion), slog.Int("latest", MessageParseVersionLatest))
 
@@ -1976,12 +2112,12 @@ func (a *Account) CheckConsistency() error {
 
 			messageIDs[m.ID] = struct{}{}
 			p := a.MessagePath(m.ID)
-			st, err := os.Stat(p)
+			size, err := MessageFileSize(p)
 			if err != nil {
 				existserr := fmt.Sprintf("message %d in mailbox %q (id %d) on-disk file %s: %v", m.ID, mb.Name, mb.ID, p, err)
 				fileErrors = append(fileErrors, existserr)
-			} else if len(fileErrors) < 20 && m.Size != int64(len(m.MsgPrefix))+st.Size() {
-				sizeerr := fmt.Sprintf("message %d in mailbox %q (id %d) has size %d != len msgprefix %d + on-disk file size %d = %d", m.ID, mb.Name, mb.ID, m.Size, len(m.MsgPrefix), st.Size(), int64(len(m.MsgPrefix))+st.Size())
+			} else if len(fileErrors) < 20 && m.Size != int64(len(m.MsgPrefix))+size {
+				sizeerr := fmt.Sprintf("message %d in mailbox %q (id %d) has size %d != len msgprefix %d + on-disk file size %d = %d", m.ID, mb.Name, mb.ID, m.Size, len(m.MsgPrefix), size, int64(len(m.MsgPrefix))+size)
 				fileErrors = append(fileErrors, sizeerr)
 			}
 
@@ -2471,8 +2607,33 @@ func (a *Account) MessageAdd(log mlog.Log, tx *bstore.Tx, mb *Mailbox, m *Messag
 		}
 	}
 
-	if err := moxio.LinkOrCopy(log, msgPath, msgFile.Name(), &moxio.AtReader{R: msgFile}, true); err != nil {
-		return fmt.Errorf("linking/copying message to new file: %w", err)
+	// With deduplication, we link to an existing file with the same contents, or make
+	// our new file available to future messages.
+	fileSize := m.Size - int64(len(m.MsgPrefix))
+	var dedupHash string
+	if mox.Conf.Static.MessageDedup && fileSize > 0 {
+		var err error
+		dedupHash, err = MessageFileHash(msgFile)
+		if err != nil {
+			return fmt.Errorf("hashing message file for deduplication: %w", err)
+		}
+	}
+
+	if dedupHash == "" || !dedupLink(log, dedupHash, msgPath) {
+		// Store compressed if configured, unless the file is already compressed, e.g. when
+		// copying from another account.
+		if compressed, err := msgFileCompressed(msgFile); err != nil {
+			return fmt.Errorf("reading message file: %w", err)
+		} else if !compressed && compressMessage(fileSize) {
+			if _, err := writeCompressed(msgPath, msgFile, fileSize); err != nil {
+				return fmt.Errorf("writing compressed message file: %w", err)
+			}
+		} else if err := moxio.LinkOrCopy(log, msgPath, msgFile.Name(), &moxio.AtReader{R: msgFile}, true); err != nil {
+			return fmt.Errorf("linking/copying message to new file: %w", err)
+		}
+		if dedupHash != "" {
+			dedupRegister(log, dedupHash, msgPath)
+		}
 	}
 
 	defer func() {
@@ -2553,24 +2714,27 @@ func (a *Account) SetPassword(log mlog.Log, password string) error {
 		// store the hash state in the database. When we actually authenticate, we'll
 		// complete the HMAC by hashing only the text. We cannot store crypto/hmac's hash,
 		// because it does not expose its internal state and isn't a BinaryMarshaler.
+		// We don't store these secrets if no listener accepts CRAM-MD5.
 		// ../rfc/2104:121
-		pw.CRAMMD5.Ipad = md5.New()
-		pw.CRAMMD5.Opad = md5.New()
-		key := []byte(password)
-		if len(key) > 64 {
-			t := md5.Sum(key)
-			key = t[:]
-		}
-		ipad := make([]byte, md5.BlockSize)
-		opad := make([]byte, md5.BlockSize)
-		copy(ipad, key)
-		copy(opad, key)
-		for i := range ipad {
-			ipad[i] ^= 0x36
-			opad[i] ^= 0x5c
-		}
-		pw.CRAMMD5.Ipad.Write(ipad)
-		pw.CRAMMD5.Opad.Write(opad)
+		if !mox.Conf.CRAMMD5Disabled() {
+			pw.CRAMMD5.Ipad = md5.New()
+			pw.CRAMMD5.Opad = md5.New()
+			key := []byte(password)
+			if len(key) > 64 {
+				t := md5.Sum(key)
+				key = t[:]
+			}
+			ipad := make([]byte, md5.BlockSize)
+			opad := make([]byte, md5.BlockSize)
+			copy(ipad, key)
+			copy(opad, key)
+			for i := range ipad {
+				ipad[i] ^= 0x36
+				opad[i] ^= 0x5c
+			}
+			pw.CRAMMD5.Ipad.Write(ipad)
+			pw.CRAMMD5.Opad.Write(opad)
+		}
 
 		pw.SCRAMSHA1.Salt = scram.MakeRandom()
 		pw.SCRAMSHA1.Iterations = 2 * 4096
@@ -2844,8 +3008,33 @@ func MessageRuleset(log mlog.Log, dest config.Destination, m *Message, msgPrefix
 		return nil
 	}
 
+	// Only walked when needed for a ruleset.
+	var attachments, walked bool
+	hasAttachments := func() bool {
+		if !walked {
+			walked = true
+			if err := p.Walk(log.Logger, nil); err != nil {
+				log.Debugx("parsing message parts for evaluating rulesets, continuing with parts parsed so far", err)
+			}
+			attachments = partHasAttachment(&p)
+		}
+		return attachments
+	}
+
 ruleset:
 	for _, rs := range dest.Rulesets {
+		if rs.MinSize > 0 && m.Size < rs.MinSize || rs.MaxSize > 0 && m.Size > rs.MaxSize {
+			continue ruleset
+		}
+		if rs.SPFResult != "" && string(validationSPFStatus(m.MailFromValidation)) != rs.SPFResult {
+			continue ruleset
+		}
+		if rs.DKIMResult == "pass" && len(m.DKIMDomains) == 0 || rs.DKIMResult == "none" && len(m.DKIMDomains) > 0 {
+			continue ruleset
+		}
+		if rs.DMARCResult == "pass" && !m.MsgFromValidated || rs.DMARCResult == "fail" && m.MsgFromValidated {
+			continue ruleset
+		}
 		if rs.SMTPMailFromRegexpCompiled != nil {
 			if !rs.SMTPMailFromRegexpCompiled.MatchString(m.MailFrom) {
 				continue ruleset
@@ -2856,6 +3045,15 @@ ruleset:
 				continue ruleset
 			}
 		}
+		if len(rs.MsgFromPatternsCompiled) > 0 {
+			if m.MsgFromLocalpart == "" && m.MsgFromDomain == "" {
+				continue ruleset
+			}
+			msgFrom := m.MsgFromLocalpart.String() + "@" + m.MsgFromDomain
+			if !slices.ContainsFunc(rs.MsgFromPatternsCompiled, func(r *regexp.Regexp) bool { return r.MatchString(msgFrom) }) {
+				continue ruleset
+			}
+		}
 
 		if !rs.VerifiedDNSDomain.IsZero() {
 			d := rs.VerifiedDNSDomain.Name()
@@ -2897,11 +3095,48 @@ ruleset:
 			}
 			continue ruleset
 		}
+
+		if rs.ListID != "" && !strings.EqualFold(headerListID(header.Get("List-Id")), rs.ListID) {
+			continue ruleset
+		}
+		if rs.Attachments == "yes" && !hasAttachments() || rs.Attachments == "no" && hasAttachments() {
+			continue ruleset
+		}
 		return &rs
 	}
 	return nil
 }
 
+// headerListID returns the list identifier from a List-Id header value, the part
+// between angle brackets, or an empty string.
+func headerListID(s string) string {
+	// ../rfc/2919:198
+	s = strings.TrimRight(s, " \t")
+	if !strings.HasSuffix(s, ">") {
+		return ""
+	}
+	i := strings.LastIndexByte(s, '<')
+	if i < 0 {
+		return ""
+	}
+	return s[i+1 : len(s)-1]
+}
+
+// partHasAttachment returns whether p or one of its (multipart) subparts has a
+// Content-Disposition of attachment, or a filename.
+func partHasAttachment(p *message.Part) bool {
+	disp, filename, _ := p.DispositionFilename()
+	if strings.EqualFold(disp, "attachment") || filename != "" {
+		return true
+	}
+	for i := range p.Parts {
+		if partHasAttachment(&p.Parts[i]) {
+			return true
+		}
+	}
+	return false
+}
+
 // MessagePath returns the file system path of a message.
 func (a *Account) MessagePath(messageID int64) string {
 	return strings.Join(append([]string{a.Dir, "msg"}, messagePathElems(messageID)...), string(filepath.Separator))
@@ -2914,6 +3149,8 @@ func (a *Account) MessageReader(m Message) *MsgReader {
 }
 
 // DeliverDestination delivers an email to dest, based on the configured rulesets.
+// If the matching ruleset has Discard set, the message is not delivered and nil is
+// returned. ForwardTo of rulesets is not handled, only by the SMTP server.
 //
 // Returns ErrOverQuota when account would be over quota after adding message.
 //
@@ -2923,8 +3160,14 @@ func (a *Account) MessageReader(m Message) *MsgReader {
 func (a *Account) DeliverDestination(log mlog.Log, dest config.Destination, m *Message, msgFile *os.File) error {
 	var mailbox string
 	rs := MessageRuleset(log, dest, m, m.MsgPrefix, msgFile)
-	if rs != nil {
+	if rs != nil && rs.Discard {
+		log.Info("not delivering message due to ruleset with discard")
+		return nil
+	} else if rs != nil {
 		mailbox = rs.Mailbox
+		if rs.MarkSeen {
+			m.Seen = true
+		}
 	} else if dest.Mailbox == "" {
 		mailbox = "Inbox"
 	} else {
@@ -2954,7 +3197,11 @@ func (a *Account) DeliverMailbox(log mlog.Log, mailbox string, m *Message, msgFi
 	}()
 
 	err := a.DB.Write(context.TODO(), func(tx *bstore.Tx) error {
-		mb, chl, err := a.MailboxEnsure(tx, mailbox, true, SpecialUse{}, &m.ModSeq)
+		name, specialUse, err := a.deliveryMailbox(log, tx, mailbox)
+		if err != nil {
+			return err
+		}
+		mb, chl, err := a.MailboxEnsure(tx, name, true, specialUse, &m.ModSeq)
 		if err != nil {
 			return fmt.Errorf("ensuring mailbox: %w", err)
 		}
@@ -2987,6 +3234,60 @@ func (a *Account) DeliverMailbox(log mlog.Log, mailbox string, m *Message, msgFi
 	return nil
 }
 
+// deliveryMailbox returns the mailbox to deliver to, for a delivery to mailbox
+// name, e.g. from a ruleset. If the mailbox does not exist, and the account has
+// NoAutoCreateMailboxes set, the Inbox is returned. Otherwise the mailbox will be
+// created, and if its name is configured as special-use mailbox in
+// InitialMailboxes and no other mailbox has that special-use flag, the special-use
+// flag is returned for the new mailbox.
+func (a *Account) deliveryMailbox(log mlog.Log, tx *bstore.Tx, name string) (string, SpecialUse, error) {
+	if name == "Inbox" {
+		return name, SpecialUse{}, nil
+	}
+	if mb, err := a.MailboxFind(tx, name); err != nil {
+		return "", SpecialUse{}, err
+	} else if mb != nil {
+		return name, SpecialUse{}, nil
+	}
+
+	if conf, _ := a.Conf(); conf.NoAutoCreateMailboxes {
+		log.Info("mailbox for delivery does not exist, delivering to inbox", slog.String("mailbox", name))
+		return "Inbox", SpecialUse{}, nil
+	}
+
+	mailboxes := mox.Conf.Static.InitialMailboxes
+	if mailboxes.SpecialUse == (config.SpecialUseMailboxes{}) && len(mailboxes.Regular) == 0 {
+		mailboxes = DefaultInitialMailboxes
+	}
+	var use SpecialUse
+	switch name {
+	case "":
+	case mailboxes.SpecialUse.Archive:
+		use.Archive = true
+	case mailboxes.SpecialUse.Draft:
+		use.Draft = true
+	case mailboxes.SpecialUse.Junk:
+		use.Junk = true
+	case mailboxes.SpecialUse.Sent:
+		use.Sent = true
+	case mailboxes.SpecialUse.Trash:
+		use.Trash = true
+	}
+	if use == (SpecialUse{}) {
+		return name, use, nil
+	}
+	// Don't take the special-use flag away from another mailbox.
+	exists, err := bstore.QueryTx[Mailbox](tx).FilterEqual("Expunged", false).FilterFn(func(mb Mailbox) bool {
+		return use.Archive && mb.Archive || use.Draft && mb.Draft || use.Junk && mb.Junk || use.Sent && mb.Sent || use.Trash && mb.Trash
+	}).Exists()
+	if err != nil {
+		return "", SpecialUse{}, fmt.Errorf("checking special-use mailboxes: %v", err)
+	} else if exists {
+		use = SpecialUse{}
+	}
+	return name, use, nil
+}
+
 type RemoveOpts struct {
 	JunkFilter *junk.Filter // If set, this filter is used for training, instead of opening and saving the junk filter.
 }
@@ -3084,6 +3385,213 @@ func (a *Account) MessageRemove(log mlog.Log, tx *bstore.Tx, modseq ModSeq, mb *
 	return ChangeRemoveUIDs{mb.ID, uids, modseq, ids, mb.UIDNext, mb.MessageCountIMAP(), uint32(mb.MailboxCounts.Unseen)}, mb.ChangeCounts(), nil
 }
 
+// MessageMove moves messages to mailbox mbDst, which must be different than their
+// current mailbox. Moving a message is done by changing the MailboxID and
+// assigning an appropriate new UID, and then inserting a replacement Message record
+// with new ID that is marked expunged in the original mailbox, along with a
+// MessageErase record so the message gets erased when all sessions stopped
+// referencing the message.
+//
+// If modseq is zero, a new modseq is assigned. The source and destination
+// mailboxes are saved to the database. The IDs of the new message files are
+// returned, the caller must remove them if the transaction does not commit.
+//
+// Caller must hold the account wlock.
+// Caller must broadcast changes.
+func (a *Account) MessageMove(ctx context.Context, log mlog.Log, tx *bstore.Tx, mbDst *Mailbox, modseq *ModSeq, l ...Message) (newIDs []int64, changes []Change, rerr error) {
+	defer func() {
+		if rerr == nil {
+			return
+		}
+		for _, id := range newIDs {
+			p := a.MessagePath(id)
+			err := os.Remove(p)
+			log.Check(err, "removing delivered message after failure", slog.String("path", p))
+		}
+		newIDs = nil
+	}()
+
+	// n adds, 1 remove, 2 mailboxcounts, 1 mailboxkeywords, optimistic that messages are in a single source mailbox.
+	changes = make([]Change, 0, len(l)+4)
+
+	if *modseq == 0 {
+		var err error
+		*modseq, err = a.NextModSeq(tx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("assigning next modseq: %v", err)
+		}
+	}
+
+	mbDst.ModSeq = *modseq
+
+	// Sort (group) by mailbox, sort by UID.
+	l = slices.Clone(l)
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].MailboxID != l[j].MailboxID {
+			return l[i].MailboxID < l[j].MailboxID
+		}
+		return l[i].UID < l[j].UID
+	})
+
+	var jf *junk.Filter
+	defer func() {
+		if jf != nil {
+			err := jf.CloseDiscard()
+			log.Check(err, "close junk filter")
+		}
+	}()
+
+	accConf, _ := a.Conf()
+
+	var mbSrc Mailbox
+	var changeRemoveUIDs ChangeRemoveUIDs
+	flushMailbox := func() error {
+		changeRemoveUIDs.UIDNext = mbSrc.UIDNext
+		changeRemoveUIDs.MessageCountIMAP = mbSrc.MessageCountIMAP()
+		changeRemoveUIDs.Unseen = uint32(mbSrc.MailboxCounts.Unseen)
+		changes = append(changes, changeRemoveUIDs, mbSrc.ChangeCounts())
+
+		if err := tx.Update(&mbSrc); err != nil {
+			return fmt.Errorf("updating source mailbox counts: %v", err)
+		}
+		return nil
+	}
+
+	nkeywords := len(mbDst.Keywords)
+	now := time.Now()
+
+	syncDirs := map[string]struct{}{}
+
+	for _, om := range l {
+		if om.MailboxID == mbDst.ID {
+			return newIDs, nil, fmt.Errorf("message %d already in destination mailbox", om.ID)
+		}
+		if om.MailboxID != mbSrc.ID {
+			if mbSrc.ID != 0 {
+				if err := flushMailbox(); err != nil {
+					return newIDs, nil, err
+				}
+			}
+			var err error
+			mbSrc, err = MailboxID(tx, om.MailboxID)
+			if err != nil {
+				return newIDs, nil, fmt.Errorf("get source mailbox: %w", err)
+			}
+			mbSrc.ModSeq = *modseq
+			changeRemoveUIDs = ChangeRemoveUIDs{MailboxID: mbSrc.ID, ModSeq: *modseq}
+		}
+
+		nm := om
+		nm.MailboxID = mbDst.ID
+		nm.UID = mbDst.UIDNext
+		if err := mbDst.UIDNextAdd(1); err != nil {
+			return newIDs, nil, fmt.Errorf("adding uid: %v", err)
+		}
+		nm.ModSeq = *modseq
+		nm.CreateSeq = *modseq
+		nm.SaveDate = &now
+		if nm.IsReject && nm.MailboxDestinedID != 0 {
+			// Incorrectly delivered to Rejects mailbox. Adjust MailboxOrigID so this message
+			// is used for reputation calculation during future deliveries.
+			nm.MailboxOrigID = nm.MailboxDestinedID
+			nm.IsReject = false
+			nm.Seen = false
+			if err := SenderAllowRescued(tx, accConf.RejectsRescueAllow, nm); err != nil {
+				return newIDs, nil, fmt.Errorf("adding sender allow entry: %v", err)
+			}
+		}
+		if mbDst.Trash {
+			nm.Seen = true
+		}
+
+		nm.JunkFlagsForMailbox(*mbDst, accConf)
+
+		if err := tx.Update(&nm); err != nil {
+			return newIDs, nil, fmt.Errorf("updating message with new mailbox: %v", err)
+		}
+
+		mbDst.Add(nm.MailboxCounts())
+
+		mbSrc.Sub(om.MailboxCounts())
+		om.ID = 0
+		om.Expunged = true
+		om.ModSeq = *modseq
+		om.TrainedJunk = nil
+		if err := tx.Insert(&om); err != nil {
+			return newIDs, nil, fmt.Errorf("inserting expunged message in old mailbox: %v", err)
+		}
+
+		dstPath := a.MessagePath(om.ID)
+		dstDir := filepath.Dir(dstPath)
+		if _, ok := syncDirs[dstDir]; !ok {
+			os.MkdirAll(dstDir, 0770)
+			syncDirs[dstDir] = struct{}{}
+		}
+
+		if err := moxio.LinkOrCopy(log, dstPath, a.MessagePath(nm.ID), nil, false); err != nil {
+			return newIDs, nil, fmt.Errorf("duplicating message in old mailbox for current sessions: %v", err)
+		}
+		newIDs = append(newIDs, om.ID)
+		// We don't sync the directory. In case of a crash and files disappearing, the
+		// eraser will simply not find the file at next startup.
+
+		if err := tx.Insert(&MessageErase{ID: om.ID, SkipUpdateDiskUsage: true}); err != nil {
+			return newIDs, nil, fmt.Errorf("insert message erase: %v", err)
+		}
+
+		mbDst.Keywords, _ = MergeKeywords(mbDst.Keywords, nm.Keywords)
+
+		if accConf.JunkFilter != nil && nm.NeedsTraining() {
+			// Lazily open junk filter.
+			if jf == nil {
+				var err error
+				jf, _, err = a.OpenJunkFilter(ctx, log)
+				if err != nil {
+					return newIDs, nil, fmt.Errorf("open junk filter: %v", err)
+				}
+			}
+			if err := a.RetrainMessage(ctx, log, tx, jf, &nm); err != nil {
+				return newIDs, nil, fmt.Errorf("retrain message after moving: %w", err)
+			}
+		}
+
+		changeRemoveUIDs.UIDs = append(changeRemoveUIDs.UIDs, om.UID)
+		changeRemoveUIDs.MsgIDs = append(changeRemoveUIDs.MsgIDs, om.ID)
+		changes = append(changes, nm.ChangeAddUID(*mbDst))
+	}
+
+	for dir := range syncDirs {
+		if err := moxio.SyncDir(log, dir); err != nil {
+			return newIDs, nil, fmt.Errorf("sync directory: %v", err)
+		}
+	}
+
+	if mbSrc.ID != 0 {
+		if err := flushMailbox(); err != nil {
+			return newIDs, nil, err
+		}
+	}
+
+	changes = append(changes, mbDst.ChangeCounts())
+	if nkeywords > len(mbDst.Keywords) {
+		changes = append(changes, mbDst.ChangeKeywords())
+	}
+
+	if err := tx.Update(mbDst); err != nil {
+		return newIDs, nil, fmt.Errorf("updating destination mailbox with uidnext and modseq: %v", err)
+	}
+
+	if jf != nil {
+		err := jf.Close()
+		jf = nil
+		if err != nil {
+			return newIDs, nil, fmt.Errorf("saving junk filter: %v", err)
+		}
+	}
+
+	return newIDs, changes, nil
+}
+
 // TidyRejectsMailbox removes old reject emails, and returns whether there is space for a new delivery.
 //
 // The changed mailbox is saved to the database.
@@ -3279,6 +3787,10 @@ func OpenEmailAuth(log mlog.Log, email string, password string, checkLoginDisabl
 		}
 	}()
 
+	if AuthLockedOut(nil, acc.Name) {
+		return nil, "", ErrUnknownCredentials
+	}
+
 	password, err = precis.OpaqueString.String(password)
 	if err != nil {
 		return nil, "", ErrUnknownCredentials
@@ -3313,6 +3825,50 @@ func OpenEmailAuth(log mlog.Log, email string, password string, checkLoginDisabl
 	return acc, accName, nil
 }
 
+// OpenEmailAuthToken is like OpenEmailAuth, but also accepts an API token of the
+// account instead of the password. For a password, the returned scope is empty,
+// for full access. For a token, its scope is returned, and the caller must limit
+// access accordingly.
+func OpenEmailAuthToken(log mlog.Log, email string, password string, checkLoginDisabled bool) (racc *Account, raccName string, scope APITokenScope, rerr error) {
+	if !IsAPIToken(password) {
+		acc, accName, err := OpenEmailAuth(log, email, password, checkLoginDisabled)
+		return acc, accName, "", err
+	}
+
+	acc, accName, _, err := OpenEmail(log, email, false)
+	if err != nil {
+		return nil, accName, "", err
+	}
+
+	defer func() {
+		if rerr != nil {
+			err := acc.Close()
+			log.Check(err, "closing account after open auth failure")
+			acc = nil
+		}
+	}()
+
+	if AuthLockedOut(nil, acc.Name) {
+		return nil, "", "", ErrUnknownCredentials
+	}
+
+	t, err := APITokenVerify(context.TODO(), acc.Name, password)
+	if err != nil && errors.Is(err, ErrAPITokenUnknown) {
+		return nil, accName, "", ErrUnknownCredentials
+	} else if err != nil {
+		return nil, accName, "", fmt.Errorf("verifying api token: %v", err)
+	}
+	if checkLoginDisabled {
+		conf, aok := acc.Conf()
+		if !aok {
+			return nil, "", "", fmt.Errorf("cannot find config for account")
+		} else if conf.LoginDisabled != "" {
+			return nil, "", "", fmt.Errorf("%w: %s", ErrLoginDisabled, conf.LoginDisabled)
+		}
+	}
+	return acc, accName, t.Scope, nil
+}
+
 // OpenEmail opens an account given an email address.
 //
 // The email address may contain a catchall separator.
@@ -3556,13 +4112,19 @@ func CheckKeyword(kw string) error {
 // total number of messages and number of first-time recipients.
 func (a *Account) SendLimitReached(tx *bstore.Tx, recipients []smtp.Path) (msglimit, rcptlimit int, rerr error) {
 	conf, _ := a.Conf()
+	_, tenant, _ := mox.Conf.AccountTenant(a.Name)
 	msgmax := conf.MaxOutgoingMessagesPerDay
-	if msgmax == 0 {
+	if tenant.MaxOutgoingMessagesPerDay > 0 && (msgmax == 0 || msgmax > tenant.MaxOutgoingMessagesPerDay) {
+		// The tenant limit is both the default and the maximum for its accounts.
+		msgmax = tenant.MaxOutgoingMessagesPerDay
+	} else if msgmax == 0 {
 		// For human senders, 1000 recipients in a day is quite a lot.
 		msgmax = 1000
 	}
 	rcptmax := conf.MaxFirstTimeRecipientsPerDay
-	if rcptmax == 0 {
+	if tenant.MaxFirstTimeRecipientsPerDay > 0 && (rcptmax == 0 || rcptmax > tenant.MaxFirstTimeRecipientsPerDay) {
+		rcptmax = tenant.MaxFirstTimeRecipientsPerDay
+	} else if rcptmax == 0 {
 		// Human senders may address a new human-sized list of people once in a while. In
 		// case of a compromise, a spammer will probably try to send to many new addresses.
 		rcptmax = 200
@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -16,9 +17,12 @@ import (
 	"github.com/mjl-/sconf"
 
 	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/junk"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
 )
 
 var ctxbg = context.Background()
@@ -317,8 +321,108 @@ test
 	// todo: test the SMTPMailFrom and VerifiedDomains rule.
 }
 
+func TestMessageRulesetConditions(t *testing.T) {
+	log := mlog.New("store", nil)
+	f, err := CreateMessageTemp(log, "msgruleset")
+	tcheck(t, err, "creating temp msg file")
+	defer CloseRemoveTempFile(log, f, "temp message file")
+
+	plainBuf := []byte(strings.ReplaceAll(`From: <news@lists.example.org>
+List-Id: Example list <news.lists.example.org>
+
+test
+`, "\n", "\r\n"))
+	attachBuf := []byte(strings.ReplaceAll(`From: <mjl@mox.example>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary=x
+
+--x
+Content-Type: text/plain
+
+test
+--x
+Content-Type: application/pdf
+Content-Disposition: attachment; filename="test.pdf"
+
+data
+--x--
+`, "\n", "\r\n"))
+
+	plainMsg := &Message{
+		MsgFromLocalpart:   "news",
+		MsgFromDomain:      "lists.example.org",
+		MailFromValidation: ValidationSoftfail,
+		DKIMDomains:        []string{"lists.example.org"},
+		MsgFromValidated:   true,
+		Size:               int64(len(plainBuf)),
+	}
+	attachMsg := &Message{
+		MsgFromLocalpart:   "mjl",
+		MsgFromDomain:      "mox.example",
+		MailFromValidation: ValidationPass,
+		Size:               int64(len(attachBuf)),
+	}
+
+	test := func(rs config.Ruleset, m *Message, msgBuf []byte, expMatch bool) {
+		t.Helper()
+		rs.Mailbox = "test"
+		dest := config.Destination{Rulesets: []config.Ruleset{rs}}
+		c := MessageRuleset(log, dest, m, msgBuf, f)
+		if (c != nil) != expMatch {
+			t.Fatalf("ruleset %#v: got match %v, expected %v", rs, c != nil, expMatch)
+		}
+	}
+
+	patterns := []*regexp.Regexp{regexp.MustCompile(`(?i)^.*@lists\.example\.org$`)}
+	test(config.Ruleset{MsgFromPatternsCompiled: patterns}, plainMsg, plainBuf, true)
+	test(config.Ruleset{MsgFromPatternsCompiled: patterns}, attachMsg, attachBuf, false)
+
+	test(config.Ruleset{SPFResult: "softfail"}, plainMsg, plainBuf, true)
+	test(config.Ruleset{SPFResult: "pass"}, plainMsg, plainBuf, false)
+	test(config.Ruleset{SPFResult: "pass"}, attachMsg, attachBuf, true)
+
+	test(config.Ruleset{DKIMResult: "pass"}, plainMsg, plainBuf, true)
+	test(config.Ruleset{DKIMResult: "none"}, plainMsg, plainBuf, false)
+	test(config.Ruleset{DKIMResult: "none"}, attachMsg, attachBuf, true)
+
+	test(config.Ruleset{DMARCResult: "pass"}, plainMsg, plainBuf, true)
+	test(config.Ruleset{DMARCResult: "fail"}, plainMsg, plainBuf, false)
+	test(config.Ruleset{DMARCResult: "fail"}, attachMsg, attachBuf, true)
+
+	test(config.Ruleset{MinSize: 100}, plainMsg, plainBuf, false)
+	test(config.Ruleset{MinSize: 100}, attachMsg, attachBuf, true)
+	test(config.Ruleset{MaxSize: 100}, plainMsg, plainBuf, true)
+	test(config.Ruleset{MaxSize: 100}, attachMsg, attachBuf, false)
+
+	test(config.Ruleset{Attachments: "yes"}, attachMsg, attachBuf, true)
+	test(config.Ruleset{Attachments: "yes"}, plainMsg, plainBuf, false)
+	test(config.Ruleset{Attachments: "no"}, plainMsg, plainBuf, true)
+
+	test(config.Ruleset{ListID: "NEWS.lists.example.org"}, plainMsg, plainBuf, true)
+	test(config.Ruleset{ListID: "other.lists.example.org"}, plainMsg, plainBuf, false)
+	test(config.Ruleset{ListID: "news.lists.example.org"}, attachMsg, attachBuf, false)
+
+	// All conditions must match.
+	test(config.Ruleset{ListID: "news.lists.example.org", SPFResult: "pass"}, plainMsg, plainBuf, false)
+}
+
 // Check that opening an account forwards the Message.ID used for new additions if
 // message files already exist in the file system.
+func TestNewJunkClassification(t *testing.T) {
+	var spams []junk.WordScore
+	for i := range 15 {
+		spams = append(spams, junk.WordScore{Word: fmt.Sprintf("word%d", i), Score: 0.9})
+	}
+	long := strings.Repeat("x", 39) + "é"
+	r := junk.Result{Probability: 0.8, Significant: true, Hams: []junk.WordScore{{Word: long, Score: 0.1}}, Spams: spams}
+	jc := NewJunkClassification(true, r, 0.5, "")
+	tcompare(t, len(jc.Spams), junkClassificationMaxWords)
+	tcompare(t, jc.Hams, []JunkWord{{strings.Repeat("x", 39), 0.1}})
+	if s := jc.String(); !strings.Contains(s, "content: junk\n") || !strings.Contains(s, "0.900 word0\n") {
+		t.Fatalf("unexpected explanation %q", s)
+	}
+}
+
 func TestNextMessageID(t *testing.T) {
 	log := mlog.New("store", nil)
 	os.RemoveAll("../testdata/store/data")
@@ -482,3 +586,66 @@ func TestRemove(t *testing.T) {
 	tcheck(t, err, "checking for account removals")
 	tcompare(t, exists, false)
 }
+
+func TestSendLimitTenant(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	err := Init(ctxbg)
+	tcheck(t, err, "init")
+	defer func() {
+		err := Close()
+		tcheck(t, err, "close")
+	}()
+	defer Switchboard()()
+
+	acc, err := OpenAccount(log, "mjl", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err := acc.Close()
+		tcheck(t, err, "close account")
+		acc.WaitClosed()
+	}()
+
+	// Put the domain of the account in a tenant. Not saved, only for this test.
+	mox.Conf.Dynamic.Tenants = map[string]config.Tenant{"acme": {MaxOutgoingMessagesPerDay: 2, MaxFirstTimeRecipientsPerDay: 1}}
+	dom := mox.Conf.Dynamic.Domains["mox.example"]
+	dom.Tenant = "acme"
+	mox.Conf.Dynamic.Domains["mox.example"] = dom
+	defer func() {
+		dom.Tenant = ""
+		mox.Conf.Dynamic.Domains["mox.example"] = dom
+		mox.Conf.Dynamic.Tenants = nil
+	}()
+
+	err = acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		err := tx.Insert(&Outgoing{Recipient: "a@remote.example"})
+		tcheck(t, err, "insert outgoing")
+
+		rcpt := []smtp.Path{{Localpart: "b", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "remote.example"}}}}
+
+		// Tenant limit applies instead of the default account limit.
+		msglimit, rcptlimit, err := acc.SendLimitReached(tx, append(rcpt, rcpt...))
+		tcheck(t, err, "send limit")
+		tcompare(t, msglimit, 2)
+		tcompare(t, rcptlimit, -1)
+
+		msglimit, rcptlimit, err = acc.SendLimitReached(tx, rcpt)
+		tcheck(t, err, "send limit")
+		tcompare(t, msglimit, -1)
+		tcompare(t, rcptlimit, 1)
+
+		// Higher account limits are capped by the tenant.
+		accConf := mox.Conf.Dynamic.Accounts["mjl"]
+		accConf.MaxOutgoingMessagesPerDay = 100
+		mox.Conf.Dynamic.Accounts["mjl"] = accConf
+		msglimit, _, err = acc.SendLimitReached(tx, append(rcpt, rcpt...))
+		tcheck(t, err, "send limit")
+		tcompare(t, msglimit, 2)
+		accConf.MaxOutgoingMessagesPerDay = 0
+		mox.Conf.Dynamic.Accounts["mjl"] = accConf
+		return nil
+	})
+	tcheck(t, err, "write")
+}
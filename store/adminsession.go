@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// AdminSession is a login session for the admin web interface.
+type AdminSession struct {
+	ID      int64
+	Created time.Time `bstore:"nonzero,default now"` // Of login.
+	Expires time.Time `bstore:"nonzero"`             // Extended when used.
+
+	SessionToken string `bstore:"nonzero,unique" json:"-"` // Stored in "webadminsession" cookie.
+	CSRFToken    string `bstore:"nonzero" json:"-"`        // For API requests, in "x-mox-csrf" header.
+
+	// Hash of the admin password file at login. Sessions are no longer valid after
+	// the admin password has changed.
+	PasswordHash string `json:"-"`
+
+	// For sessions of tenant admins, the name of the tenant. The session only gives
+	// access to the domains and accounts of the tenant. PasswordHash is of the
+	// password file of the tenant.
+	Tenant string
+
+	// Of most recent use, for showing active sessions.
+	LastUsed  time.Time
+	RemoteIP  string
+	UserAgent string
+}
+
+// AdminSessionList returns all admin sessions, most recently used first.
+func AdminSessionList(ctx context.Context) ([]AdminSession, error) {
+	return bstore.QueryDB[AdminSession](ctx, AuthDB).SortDesc("LastUsed").List()
+}
+
+// AdminSessionGet returns the admin session for a session token.
+func AdminSessionGet(ctx context.Context, sessionToken SessionToken) (AdminSession, error) {
+	return bstore.QueryDB[AdminSession](ctx, AuthDB).FilterNonzero(AdminSession{SessionToken: string(sessionToken)}).Get()
+}
+
+// AdminSessionAdd adds a new admin session. Expired sessions are removed, and
+// the least recently used sessions are removed so at most max sessions remain.
+func AdminSessionAdd(ctx context.Context, s *AdminSession, max int) error {
+	return AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[AdminSession](tx)
+		q.FilterLess("Expires", time.Now())
+		if _, err := q.Delete(); err != nil {
+			return err
+		}
+
+		q = bstore.QueryTx[AdminSession](tx)
+		q.SortDesc("LastUsed")
+		l, err := q.List()
+		if err != nil {
+			return err
+		}
+		for i := max - 1; i >= 0 && i < len(l); i++ {
+			if err := tx.Delete(&l[i]); err != nil {
+				return err
+			}
+		}
+
+		return tx.Insert(s)
+	})
+}
+
+// AdminSessionUpdate stores the changed expiration time and most recent use of an
+// admin session.
+func AdminSessionUpdate(ctx context.Context, s *AdminSession) error {
+	return AuthDB.Update(ctx, s)
+}
+
+// AdminSessionRemove removes an admin session by ID.
+func AdminSessionRemove(ctx context.Context, id int64) error {
+	return AuthDB.Delete(ctx, &AdminSession{ID: id})
+}
+
+// AdminSessionRemoveOthers removes all admin sessions except keepID. The number of
+// removed sessions is returned.
+func AdminSessionRemoveOthers(ctx context.Context, keepID int64) (int, error) {
+	q := bstore.QueryDB[AdminSession](ctx, AuthDB)
+	q.FilterFn(func(s AdminSession) bool { return s.ID != keepID })
+	return q.Delete()
+}
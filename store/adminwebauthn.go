@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// AdminWebAuthnCredential is a security key or passkey registered for logging in
+// to the admin web interface, as alternative to the admin password.
+type AdminWebAuthnCredential struct {
+	ID      int64
+	Created time.Time `bstore:"nonzero,default now"`
+
+	// Descriptive name to identify the credential, e.g. the type of security key.
+	Name string `bstore:"nonzero"`
+
+	// Relying party ID the credential is registered for, the host name the admin
+	// web interface was accessed at. Credentials can only be used at this host name.
+	RPID string `bstore:"nonzero"`
+
+	CredentialID string    `bstore:"nonzero,unique" json:"-"` // Chosen by authenticator, raw-url-base64-encoded.
+	PublicKey    []byte    `bstore:"nonzero" json:"-"`        // COSE key, CBOR-encoded.
+	SignCount    uint32    `json:"-"`                         // Signature counter, to detect cloned authenticators.
+	LastUsed     time.Time // Zero if never used.
+}
+
+// AdminWebAuthnCredentialList returns the registered admin WebAuthn credentials.
+func AdminWebAuthnCredentialList(ctx context.Context) ([]AdminWebAuthnCredential, error) {
+	return bstore.QueryDB[AdminWebAuthnCredential](ctx, AuthDB).SortAsc("Created").List()
+}
+
+// AdminWebAuthnCredentialAdd adds a newly registered admin WebAuthn credential.
+func AdminWebAuthnCredentialAdd(ctx context.Context, c *AdminWebAuthnCredential) error {
+	return AuthDB.Insert(ctx, c)
+}
+
+// AdminWebAuthnCredentialRemove removes an admin WebAuthn credential.
+func AdminWebAuthnCredentialRemove(ctx context.Context, id int64) error {
+	return AuthDB.Delete(ctx, &AdminWebAuthnCredential{ID: id})
+}
+
+// AdminWebAuthnCredentialRemoveAll removes all admin WebAuthn credentials, e.g.
+// when locked out. The number of removed credentials is returned.
+func AdminWebAuthnCredentialRemoveAll(ctx context.Context) (int, error) {
+	return bstore.QueryDB[AdminWebAuthnCredential](ctx, AuthDB).Delete()
+}
+
+// AdminWebAuthnCredentialUse looks up a credential by its raw-url-base64-encoded
+// credential ID, and calls fn to verify its use. If fn returns a nil error, the
+// signature counter returned by fn and the time of last use are stored.
+func AdminWebAuthnCredentialUse(ctx context.Context, credentialID string, fn func(c AdminWebAuthnCredential) (signCount uint32, err error)) error {
+	return AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		c, err := bstore.QueryTx[AdminWebAuthnCredential](tx).FilterNonzero(AdminWebAuthnCredential{CredentialID: credentialID}).Get()
+		if err != nil {
+			return err
+		}
+		signCount, err := fn(c)
+		if err != nil {
+			return err
+		}
+		c.SignCount = signCount
+		c.LastUsed = time.Now()
+		return tx.Update(&c)
+	})
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// ErrAPITokenUnknown is returned for an unknown or expired API token.
+var ErrAPITokenUnknown = errors.New("unknown or expired api token")
+
+// apiTokenPrefix is the prefix of API tokens, to distinguish them from passwords.
+const apiTokenPrefix = "moxapi_"
+
+// APITokenScope limits what an API token can be used for.
+type APITokenScope string
+
+const (
+	// Read-only access to mailboxes and messages, with IMAP and the webapi.
+	APITokenScopeRead APITokenScope = "read"
+
+	// Only sending messages, with SMTP submission and the webapi.
+	APITokenScopeSend APITokenScope = "send"
+)
+
+// APIToken is a long-lived token for an account that can be used instead of the
+// account password with IMAP, SMTP submission and the webapi, with limited access.
+// Meant for scripts and integrations, so they don't need the account password.
+// Only a hash of the token is stored.
+type APIToken struct {
+	ID      int64
+	Created time.Time `bstore:"nonzero,default now"`
+	Account string    `bstore:"nonzero,index"`
+
+	// Descriptive name to identify the token, e.g. the script or integration where
+	// the token is used.
+	Name string `bstore:"nonzero"`
+
+	Scope APITokenScope `bstore:"nonzero"`
+
+	// Raw-url-base64-encoded SHA-256 of the token.
+	TokenHash string `bstore:"nonzero,unique" json:"-"`
+
+	Expires  time.Time // Zero for no expiration.
+	LastUsed time.Time // Zero if never used.
+}
+
+// IsAPIToken returns whether password looks like an API token.
+func IsAPIToken(password string) bool {
+	return strings.HasPrefix(password, apiTokenPrefix)
+}
+
+// APITokenAdd generates a new token and adds it. The token is returned, it is not
+// stored and cannot be retrieved later. Caller must set Account, Name, Scope and
+// optionally Expires, and is responsible for checking the account is valid.
+func APITokenAdd(ctx context.Context, t *APIToken) (token string, rerr error) {
+	if t.Scope != APITokenScopeRead && t.Scope != APITokenScopeSend {
+		return "", fmt.Errorf("unknown scope %q", t.Scope)
+	}
+	buf := make([]byte, 24)
+	cryptorand.Read(buf)
+	token = apiTokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	t.TokenHash = oauthTokenHash(token)
+	if err := AuthDB.Insert(ctx, t); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// APITokenList returns the API tokens of an account.
+func APITokenList(ctx context.Context, account string) ([]APIToken, error) {
+	return bstore.QueryDB[APIToken](ctx, AuthDB).FilterNonzero(APIToken{Account: account}).SortAsc("Created").List()
+}
+
+// APITokenRemove removes an API token of an account.
+func APITokenRemove(ctx context.Context, account string, id int64) error {
+	n, err := bstore.QueryDB[APIToken](ctx, AuthDB).FilterNonzero(APIToken{ID: id, Account: account}).Delete()
+	if err == nil && n == 0 {
+		return bstore.ErrAbsent
+	}
+	return err
+}
+
+// APITokenVerify looks up a token for an account, checks it hasn't expired and
+// registers its use. ErrAPITokenUnknown is returned for unknown and expired
+// tokens, and tokens of other accounts.
+func APITokenVerify(ctx context.Context, account, token string) (t APIToken, rerr error) {
+	rerr = AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		var err error
+		t, err = bstore.QueryTx[APIToken](tx).FilterNonzero(APIToken{TokenHash: oauthTokenHash(token)}).Get()
+		if err == bstore.ErrAbsent {
+			return ErrAPITokenUnknown
+		} else if err != nil {
+			return err
+		}
+		now := time.Now()
+		if t.Account != account || !t.Expires.IsZero() && !now.Before(t.Expires) {
+			return ErrAPITokenUnknown
+		}
+		// Only update once per hour, to prevent a write for each login.
+		if now.Sub(t.LastUsed) > time.Hour {
+			t.LastUsed = now
+			if err := tx.Update(&t); err != nil {
+				return fmt.Errorf("updating api token: %v", err)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// apiTokenRemoveForAccount removes all API tokens for an account.
+func apiTokenRemoveForAccount(tx *bstore.Tx, account string) error {
+	_, err := bstore.QueryTx[APIToken](tx).FilterNonzero(APIToken{Account: account}).Delete()
+	return err
+}
@@ -0,0 +1,210 @@
+package store
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// ErrAttachmentLinkUnknown is returned for an unknown or expired attachment link.
+var ErrAttachmentLinkUnknown = errors.New("unknown or expired attachment link")
+
+// AttachmentLink is a large attachment that was removed from an outgoing message
+// and stored on this server instead, with a link to it in the message. The file is
+// stored in the account directory. Anyone with the (unguessable) token can
+// download the attachment until it expires, after which it is removed.
+type AttachmentLink struct {
+	ID      int64
+	Created time.Time `bstore:"default now"`
+	Account string    `bstore:"nonzero,index"`
+
+	// Random token in the URL.
+	Token string `bstore:"nonzero,unique" json:"-"`
+
+	Filename    string
+	ContentType string
+	Size        int64
+	Expires     time.Time `bstore:"index"`
+	Accesses    int       // Number of downloads.
+	LastAccess  time.Time // Zero if never downloaded.
+}
+
+// attachmentLinkPath returns the path to the file for an attachment link.
+func attachmentLinkPath(account string, id int64) string {
+	return filepath.Join(mox.DataDirPath("accounts"), account, "attachmentlinks", fmt.Sprintf("%d", id))
+}
+
+// AttachmentLinkAdd stores the data in src as attachment for a new link, and adds
+// the link, setting its ID, Token and Size. Expired attachment links are removed.
+func AttachmentLinkAdd(ctx context.Context, log mlog.Log, al *AttachmentLink, src io.Reader) (rerr error) {
+	buf := make([]byte, 18)
+	cryptorand.Read(buf)
+	al.Token = base64.RawURLEncoding.EncodeToString(buf)
+
+	f, err := CreateMessageTemp(log, "attachmentlink")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %v", err)
+	}
+	defer func() {
+		if f != nil {
+			CloseRemoveTempFile(log, f, "attachment link")
+		}
+	}()
+	al.Size, err = io.Copy(f, src)
+	if err != nil {
+		return fmt.Errorf("writing attachment: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync attachment: %v", err)
+	}
+
+	var expired []AttachmentLink
+	err = AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		var err error
+		expired, err = attachmentLinkCleanup(tx)
+		if err != nil {
+			return err
+		}
+		if err := tx.Insert(al); err != nil {
+			return fmt.Errorf("inserting attachment link: %v", err)
+		}
+		p := attachmentLinkPath(al.Account, al.ID)
+		os.MkdirAll(filepath.Dir(p), 0770)
+		if err := os.Rename(f.Name(), p); err != nil {
+			return fmt.Errorf("moving attachment into place: %v", err)
+		}
+		err = f.Close()
+		log.Check(err, "closing attachment file")
+		f = nil
+		return nil
+	})
+	attachmentLinkRemoveFiles(log, expired)
+	return err
+}
+
+// AttachmentLinkUse looks up a valid attachment link by its token, registers the
+// access and returns the opened attachment file. ErrAttachmentLinkUnknown is
+// returned for unknown and expired links.
+func AttachmentLinkUse(ctx context.Context, token string) (al AttachmentLink, f *os.File, rerr error) {
+	rerr = AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		var err error
+		al, err = bstore.QueryTx[AttachmentLink](tx).FilterNonzero(AttachmentLink{Token: token}).Get()
+		if err == bstore.ErrAbsent {
+			return ErrAttachmentLinkUnknown
+		} else if err != nil {
+			return err
+		}
+		now := time.Now()
+		if !now.Before(al.Expires) {
+			return ErrAttachmentLinkUnknown
+		}
+		f, err = os.Open(attachmentLinkPath(al.Account, al.ID))
+		if err != nil {
+			return fmt.Errorf("open attachment: %v", err)
+		}
+		al.Accesses++
+		al.LastAccess = now
+		if err := tx.Update(&al); err != nil {
+			f.Close()
+			f = nil
+			return fmt.Errorf("updating attachment link: %v", err)
+		}
+		return nil
+	})
+	return
+}
+
+// attachmentLinkCleanup removes expired attachment links, returning them so the
+// caller can remove the files after committing.
+func attachmentLinkCleanup(tx *bstore.Tx) ([]AttachmentLink, error) {
+	q := bstore.QueryTx[AttachmentLink](tx)
+	q.FilterLess("Expires", time.Now())
+	l, err := q.List()
+	if err != nil {
+		return nil, fmt.Errorf("listing expired attachment links: %v", err)
+	}
+	for _, al := range l {
+		if err := tx.Delete(&al); err != nil {
+			return nil, fmt.Errorf("removing expired attachment link: %v", err)
+		}
+	}
+	return l, nil
+}
+
+// AttachmentLinkCleanup removes expired attachment links and their files,
+// returning the number of links removed.
+func AttachmentLinkCleanup(ctx context.Context, log mlog.Log) (int, error) {
+	var expired []AttachmentLink
+	err := AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		var err error
+		expired, err = attachmentLinkCleanup(tx)
+		return err
+	})
+	attachmentLinkRemoveFiles(log, expired)
+	return len(expired), err
+}
+
+// StartAttachmentLinkCleanup starts a goroutine that periodically removes expired
+// attachment links and their files, so they don't linger until the next link is
+// added.
+func StartAttachmentLinkCleanup() {
+	log := mlog.New("store", nil)
+
+	go func() {
+		defer func() {
+			x := recover()
+			if x == nil {
+				return
+			}
+
+			log.Error("unhandled panic in attachment link cleanup", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Store)
+		}()
+
+		t := time.NewTicker(time.Hour)
+		defer t.Stop()
+		for {
+			removed, err := AttachmentLinkCleanup(mox.Shutdown, log)
+			if err != nil {
+				log.Errorx("cleaning up expired attachment links", err)
+			} else if removed > 0 {
+				log.Info("removed expired attachment links", slog.Int("count", removed))
+			}
+
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+func attachmentLinkRemoveFiles(log mlog.Log, l []AttachmentLink) {
+	for _, al := range l {
+		err := os.Remove(attachmentLinkPath(al.Account, al.ID))
+		log.Check(err, "removing file for expired attachment link", slog.String("account", al.Account), slog.Int64("id", al.ID))
+	}
+}
+
+// attachmentLinkRemoveForAccount removes all attachment links for an account. The
+// files are in the account directory, and removed along with it.
+func attachmentLinkRemoveForAccount(tx *bstore.Tx, account string) error {
+	_, err := bstore.QueryTx[AttachmentLink](tx).FilterNonzero(AttachmentLink{Account: account}).Delete()
+	return err
+}
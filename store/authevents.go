@@ -0,0 +1,214 @@
+package store
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// Authentication events for external tools like fail2ban and CrowdSec, see
+// config.AuthEvents. Only written when started with StartAuthEvents, i.e. by
+// "mox serve", not by other commands that open the store.
+var authEvents = struct {
+	sync.Mutex
+	conf    *config.AuthEvents
+	file    string                   // Path to append to, empty if none.
+	clients map[chan []byte]struct{} // Connections on unix socket.
+}{clients: map[chan []byte]struct{}{}}
+
+// StartAuthEvents starts writing authentication events to the file and/or unix
+// domain socket from the AuthEvents configuration, if any.
+func StartAuthEvents(log mlog.Log) error {
+	conf := mox.Conf.Static.AuthEvents
+	if conf == nil {
+		return nil
+	}
+
+	authEvents.Lock()
+	defer authEvents.Unlock()
+	authEvents.conf = conf
+	if conf.File != "" {
+		authEvents.file = mox.DataDirPath(conf.File)
+	}
+	if conf.UnixSocket == "" {
+		return nil
+	}
+
+	path := mox.DataDirPath(conf.UnixSocket)
+	// Remove socket from previous run, if any.
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing old auth events socket: %v", err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("listen on auth events socket: %v", err)
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		ln.Close()
+		return fmt.Errorf("set permissions on auth events socket: %v", err)
+	}
+	log.Info("listening for auth events clients", slog.String("path", path))
+
+	go func() {
+		defer func() {
+			x := recover()
+			if x == nil {
+				return
+			}
+
+			mlog.New("store", nil).Error("unhandled panic in auth events listener", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Store)
+		}()
+
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Errorx("accepting auth events connection, stopping", err)
+				return
+			}
+			go authEventsServe(log, conn)
+		}
+	}()
+	return nil
+}
+
+// authEventsServe writes events to a client connected to the unix socket, until
+// a write fails.
+func authEventsServe(log mlog.Log, conn net.Conn) {
+	defer conn.Close()
+
+	c := make(chan []byte, 100)
+	authEvents.Lock()
+	authEvents.clients[c] = struct{}{}
+	authEvents.Unlock()
+	defer func() {
+		authEvents.Lock()
+		delete(authEvents.clients, c)
+		authEvents.Unlock()
+	}()
+
+	for buf := range c {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := conn.Write(buf); err != nil {
+			log.Debugx("writing auth event to client, disconnecting", err)
+			return
+		}
+	}
+}
+
+// authEventsWrite writes events for login attempts to the configured file and
+// unix socket clients.
+func authEventsWrite(l []LoginAttempt) {
+	authEvents.Lock()
+	defer authEvents.Unlock()
+	if authEvents.conf == nil {
+		return
+	}
+
+	var b strings.Builder
+	for _, a := range l {
+		if line := authEventLine(a, authEvents.conf.Successes); line != "" {
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+	}
+	if b.Len() == 0 {
+		return
+	}
+	buf := []byte(b.String())
+
+	if authEvents.file != "" {
+		err := appendFile(authEvents.file, buf)
+		l[0].log.Check(err, "writing auth events to file", slog.String("path", authEvents.file))
+	}
+	for c := range authEvents.clients {
+		select {
+		case c <- buf:
+		default:
+			// Client is not keeping up, drop events.
+		}
+	}
+}
+
+func appendFile(path string, buf []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf)
+	if xerr := f.Close(); err == nil {
+		err = xerr
+	}
+	return err
+}
+
+// authEventLine returns the line for a login attempt, or empty if no event should
+// be written for the login attempt.
+func authEventLine(a LoginAttempt, successes bool) string {
+	var event string
+	switch a.Result {
+	case AuthSuccess:
+		if !successes || a.AuthMech == "websession" {
+			return ""
+		}
+		event = "authok"
+	case AuthBadUser, AuthBadPassword, AuthBadCredentials, AuthBadChannelBinding, AuthBadTOTP, AuthBadProtocol:
+		if a.AuthMech == "websession" {
+			// Failed checks of web sessions are typically from expired sessions, not
+			// guessing of credentials.
+			return ""
+		}
+		event = "authfail"
+	default:
+		return ""
+	}
+
+	account := a.AccountName
+	if account == "" {
+		account = "-"
+	}
+	// The ip comes before fields that are (partially) under control of the client,
+	// so patterns cannot be confused by crafted values.
+	fields := [][2]string{
+		{"time", time.Now().UTC().Format(time.RFC3339)},
+		{"event", event},
+		{"ip", a.RemoteIP},
+		{"protocol", a.Protocol},
+		{"mech", a.AuthMech},
+		{"result", string(a.Result)},
+		{"account", account},
+		{"address", a.LoginAddress},
+		{"useragent", a.UserAgent},
+	}
+	var l []string
+	for _, f := range fields {
+		l = append(l, f[0]+"="+authEventValue(f[1]))
+	}
+	return strings.Join(l, " ")
+}
+
+// authEventValue returns v, quoted if it is empty or contains characters other
+// than a safe set.
+func authEventValue(v string) string {
+	for _, c := range v {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune(".:-_@+/()[]", c)) {
+			return strconv.Quote(v)
+		}
+	}
+	if v == "" {
+		return `""`
+	}
+	return v
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestAuthEvents(t *testing.T) {
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	dir := t.TempDir()
+	mox.Conf.Static.AuthEvents = &config.AuthEvents{
+		File:       filepath.Join(dir, "authevents.log"),
+		UnixSocket: filepath.Join(dir, "authevents.sock"),
+	}
+	defer func() {
+		mox.Conf.Static.AuthEvents = nil
+		authEvents.Lock()
+		authEvents.conf = nil
+		authEvents.file = ""
+		authEvents.Unlock()
+	}()
+
+	err := StartAuthEvents(pkglog)
+	tcheck(t, err, "start auth events")
+
+	conn, err := net.Dial("unix", mox.Conf.Static.AuthEvents.UnixSocket)
+	tcheck(t, err, "dial auth events socket")
+	defer conn.Close()
+	// Wait for the connection to be registered.
+	for range 100 {
+		authEvents.Lock()
+		n := len(authEvents.clients)
+		authEvents.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	authEventsWrite([]LoginAttempt{
+		{RemoteIP: "10.0.0.1", LoginAddress: "mjl@mox.example", AccountName: "mjl", Protocol: "imap", AuthMech: "plain", Result: AuthSuccess, log: pkglog},
+		{RemoteIP: "10.0.0.2", LoginAddress: `x" ip=10.0.0.3`, Protocol: "submission", AuthMech: "login", Result: AuthBadUser, log: pkglog},
+		{RemoteIP: "10.0.0.4", AccountName: "mjl", Protocol: "webaccount", AuthMech: "websession", Result: AuthBadCredentials, log: pkglog},
+		{RemoteIP: "10.0.0.5", AccountName: "mjl", Protocol: "imap", AuthMech: "plain", Result: AuthError, log: pkglog},
+	})
+
+	const exp = `event=authfail ip=10.0.0.2 protocol=submission mech=login result=baduser account=- address="x\" ip=10.0.0.3" useragent=""`
+
+	buf, err := os.ReadFile(mox.Conf.Static.AuthEvents.File)
+	tcheck(t, err, "read auth events file")
+	lines := strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "time=") || !strings.HasSuffix(lines[0], " "+exp) {
+		t.Fatalf("got auth events file %q, expected single line ending with %q", buf, exp)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	tcheck(t, err, "read auth event from socket")
+	if !strings.HasSuffix(line, " "+exp+"\n") {
+		t.Fatalf("got auth event %q from socket, expected suffix %q", line, exp)
+	}
+
+	// With successes.
+	line = authEventLine(LoginAttempt{RemoteIP: "10.0.0.1", LoginAddress: "mjl@mox.example", AccountName: "mjl", Protocol: "imap", AuthMech: "plain", Result: AuthSuccess, UserAgent: "Thunderbird 128"}, true)
+	if !strings.HasSuffix(line, ` event=authok ip=10.0.0.1 protocol=imap mech=plain result=ok account=mjl address=mjl@mox.example useragent="Thunderbird 128"`) {
+		t.Fatalf("unexpected line for successful login: %q", line)
+	}
+}
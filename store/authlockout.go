@@ -0,0 +1,259 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+)
+
+// AuthLockout tracks failed authentication attempts for an IP or account, and
+// whether it is locked out, see config.AuthLockout.
+type AuthLockout struct {
+	Key         string    // "ip <ip>" or "account <name>".
+	IP          string    // IPv4 address or IPv6 /64 network, for IP lockouts.
+	Account     string    // Account name, for account lockouts. "(admin)" for the admin web interface.
+	Failures    int       // Failed attempts since WindowStart.
+	WindowStart time.Time `bstore:"nonzero"`
+	Last        time.Time `bstore:"nonzero"` // Last failed attempt.
+	Until       time.Time // If in the future, authentication attempts are refused until this time.
+}
+
+// Active lockouts, for quick checks during authentication. Kept in sync with the
+// database.
+var authLockouts = struct {
+	sync.Mutex
+	until map[string]time.Time // By key.
+}{until: map[string]time.Time{}}
+
+func authLockoutIPKey(ip net.IP) (key, ipstr string) {
+	if ip4 := ip.To4(); ip4 != nil {
+		ipstr = ip4.String()
+	} else {
+		ipstr = (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+	}
+	return "ip " + ipstr, ipstr
+}
+
+func authLockoutAccountKey(accountName string) string {
+	return "account " + accountName
+}
+
+// AuthLockedOut returns whether authentication attempts from ip, or for account
+// accountName, are currently locked out. If ip is nil or accountName is empty,
+// it is not checked.
+func AuthLockedOut(ip net.IP, accountName string) bool {
+	var keys []string
+	if ip != nil {
+		key, _ := authLockoutIPKey(ip)
+		keys = append(keys, key)
+	}
+	if accountName != "" {
+		keys = append(keys, authLockoutAccountKey(accountName))
+	}
+
+	authLockouts.Lock()
+	defer authLockouts.Unlock()
+	now := time.Now()
+	for _, key := range keys {
+		if until, ok := authLockouts.until[key]; ok && now.Before(until) {
+			return true
+		} else if ok {
+			delete(authLockouts.until, key)
+		}
+	}
+	return false
+}
+
+// authLockoutLoad loads the active lockouts from the database.
+func authLockoutLoad(ctx context.Context) error {
+	l, err := bstore.QueryDB[AuthLockout](ctx, AuthDB).FilterGreater("Until", time.Now()).List()
+	if err != nil {
+		return fmt.Errorf("listing auth lockouts: %v", err)
+	}
+
+	authLockouts.Lock()
+	defer authLockouts.Unlock()
+	authLockouts.until = map[string]time.Time{}
+	for _, lo := range l {
+		authLockouts.until[lo.Key] = lo.Until
+	}
+	return nil
+}
+
+// authLockoutWriteTx updates the failed attempts and lockouts for login attempt a,
+// if lockouts are configured.
+func authLockoutWriteTx(tx *bstore.Tx, a LoginAttempt) error {
+	conf := mox.Conf.Static.AuthLockout
+	if conf == nil || a.AuthMech == "websession" {
+		// Failed checks of web sessions are typically from expired sessions, not
+		// guessing of credentials.
+		return nil
+	}
+
+	var failed bool
+	switch a.Result {
+	case AuthSuccess:
+	case AuthBadUser, AuthBadPassword, AuthBadCredentials, AuthBadChannelBinding, AuthBadTOTP:
+		failed = true
+	default:
+		return nil
+	}
+
+	ip := net.ParseIP(a.RemoteIP)
+
+	// Failed attempts for known addresses are typically stored without account name.
+	// We only track accounts that exist, to prevent unbounded growth.
+	accountName := a.AccountName
+	if accountName == "-" && a.Protocol == "webadmin" && a.LoginAddress == "" {
+		accountName = "(admin)"
+	} else if accountName == "-" && a.LoginAddress != "" {
+		accountName = ""
+		if addr, err := smtp.ParseAddress(a.LoginAddress); err == nil {
+			accountName, _, _, _, _ = mox.LookupAddress(addr.Localpart, addr.Domain, false, false, false)
+		}
+	} else if accountName == "-" {
+		accountName = ""
+	}
+
+	if ip != nil && conf.IPFailures > 0 {
+		key, ipstr := authLockoutIPKey(ip)
+		if err := authLockoutUpdateTx(tx, a.log, AuthLockout{Key: key, IP: ipstr}, failed, conf.IPFailures, conf.IPWindow, conf.IPDuration); err != nil {
+			return err
+		}
+	}
+	if accountName != "" && conf.AccountFailures > 0 {
+		lo := AuthLockout{Key: authLockoutAccountKey(accountName), Account: accountName}
+		if err := authLockoutUpdateTx(tx, a.log, lo, failed, conf.AccountFailures, conf.AccountWindow, conf.AccountDuration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func authLockoutUpdateTx(tx *bstore.Tx, log mlog.Log, lo AuthLockout, failed bool, maxFailures int, window, duration time.Duration) error {
+	now := time.Now()
+
+	err := tx.Get(&lo)
+	if err == bstore.ErrAbsent {
+		if !failed {
+			return nil
+		}
+		lo.WindowStart = now
+		lo.Last = now
+		lo.Failures = 1
+		err = tx.Insert(&lo)
+	} else if err != nil {
+		return fmt.Errorf("get auth lockout: %v", err)
+	} else if now.Before(lo.Until) {
+		// Already locked out, attempts from before the lockout may still be written.
+		return nil
+	} else if !failed {
+		// A successful login resets the failures.
+		err = tx.Delete(&lo)
+	} else {
+		if !lo.Until.IsZero() || now.Sub(lo.WindowStart) >= window {
+			lo.Failures = 0
+			lo.WindowStart = now
+			lo.Until = time.Time{}
+		}
+		lo.Failures++
+		lo.Last = now
+		if lo.Failures >= maxFailures {
+			lo.Until = now.Add(duration)
+			log.Warn("locking out authentication attempts after too many failures",
+				slog.String("ip", lo.IP),
+				slog.String("account", lo.Account),
+				slog.Int("failures", lo.Failures),
+				slog.Time("until", lo.Until))
+		}
+		err = tx.Update(&lo)
+	}
+	if err != nil {
+		return fmt.Errorf("storing auth lockout: %v", err)
+	}
+
+	if !lo.Until.IsZero() && now.Before(lo.Until) {
+		authLockouts.Lock()
+		authLockouts.until[lo.Key] = lo.Until
+		authLockouts.Unlock()
+	}
+	return nil
+}
+
+// AuthLockoutList returns the tracked failed authentication attempts for IPs and
+// accounts, including active lockouts (with Until in the future).
+func AuthLockoutList(ctx context.Context) ([]AuthLockout, error) {
+	return bstore.QueryDB[AuthLockout](ctx, AuthDB).SortAsc("Key").List()
+}
+
+// AuthLockoutClear removes failed attempts and a lockout for ipOrAccount, an IP
+// address or account name. If ipOrAccount is empty, all are removed. Returns the
+// number of removed records.
+func AuthLockoutClear(ctx context.Context, ipOrAccount string) (int, error) {
+	var key string
+	if ipOrAccount != "" {
+		if ip := net.ParseIP(ipOrAccount); ip != nil {
+			key, _ = authLockoutIPKey(ip)
+		} else if _, ipnet, err := net.ParseCIDR(ipOrAccount); err == nil {
+			key, _ = authLockoutIPKey(ipnet.IP)
+		} else {
+			key = authLockoutAccountKey(ipOrAccount)
+		}
+	}
+
+	var n int
+	err := AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[AuthLockout](tx)
+		if key != "" {
+			q.FilterNonzero(AuthLockout{Key: key})
+		}
+		var err error
+		n, err = q.Delete()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("removing auth lockouts: %v", err)
+	}
+
+	authLockouts.Lock()
+	defer authLockouts.Unlock()
+	if key == "" {
+		authLockouts.until = map[string]time.Time{}
+	} else {
+		delete(authLockouts.until, key)
+	}
+	return n, nil
+}
+
+// authLockoutCleanup removes records of failed attempts that are no longer
+// relevant: not locked out, and with the window for counting failures passed.
+func authLockoutCleanup(ctx context.Context) error {
+	conf := mox.Conf.Static.AuthLockout
+	if conf == nil {
+		conf = &config.AuthLockout{}
+	}
+	now := time.Now()
+	return AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[AuthLockout](tx)
+		q.FilterFn(func(lo AuthLockout) bool {
+			window := conf.AccountWindow
+			if strings.HasPrefix(lo.Key, "ip ") {
+				window = conf.IPWindow
+			}
+			return now.After(lo.Until) && now.Sub(lo.WindowStart) >= window
+		})
+		_, err := q.Delete()
+		return err
+	})
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestAuthLockout(t *testing.T) {
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	mox.Conf.Static.AuthLockout = &config.AuthLockout{
+		IPFailures:      3,
+		IPWindow:        time.Hour,
+		IPDuration:      time.Hour,
+		AccountFailures: 2,
+		AccountWindow:   time.Hour,
+		AccountDuration: time.Hour,
+	}
+	defer func() {
+		mox.Conf.Static.AuthLockout = nil
+	}()
+
+	xctx, xcancel := context.WithCancel(ctxbg)
+	defer xcancel()
+	err := Init(xctx)
+	tcheck(t, err, "store init")
+	defer func() {
+		err := Close()
+		tcheck(t, err, "store close")
+	}()
+
+	add := func(ip, address string, result AuthResult) {
+		t.Helper()
+		a := LoginAttempt{
+			RemoteIP:     ip,
+			LoginAddress: address,
+			AccountName:  "-",
+			Protocol:     "imap",
+			AuthMech:     "plain",
+			Result:       result,
+			log:          pkglog,
+		}
+		a.Key = a.calculateKey()
+		err := AuthDB.Write(ctxbg, func(tx *bstore.Tx) error {
+			return loginAttemptWriteTx(tx, &a)
+		})
+		tcheck(t, err, "write login attempt")
+	}
+
+	ip1 := net.ParseIP("10.0.0.1")
+	ip6a := net.ParseIP("2001:db8::1")
+	ip6b := net.ParseIP("2001:db8::2")
+
+	// Failures below the limit don't lock out.
+	add(ip1.String(), "unknown@mox.example", AuthBadUser)
+	add(ip1.String(), "unknown@mox.example", AuthBadUser)
+	tcompare(t, AuthLockedOut(ip1, ""), false)
+
+	// A successful login resets the count.
+	add(ip1.String(), "mjl@mox.example", AuthSuccess)
+	add(ip1.String(), "unknown@mox.example", AuthBadUser)
+	add(ip1.String(), "unknown@mox.example", AuthBadUser)
+	tcompare(t, AuthLockedOut(ip1, ""), false)
+	add(ip1.String(), "unknown@mox.example", AuthBadUser)
+	tcompare(t, AuthLockedOut(ip1, ""), true)
+
+	// Account lockout, for existing account by address. IPv6 is tracked per /64.
+	add(ip6a.String(), "mjl@mox.example", AuthBadPassword)
+	tcompare(t, AuthLockedOut(nil, "mjl"), false)
+	add(ip6b.String(), "other@mox.example", AuthBadPassword)
+	tcompare(t, AuthLockedOut(nil, "mjl"), true)
+	tcompare(t, AuthLockedOut(ip6b, ""), false)
+	add(ip6a.String(), "mjl@mox.example", AuthBadPassword)
+	tcompare(t, AuthLockedOut(net.ParseIP("2001:db8::3"), ""), true)
+
+	l, err := AuthLockoutList(ctxbg)
+	tcheck(t, err, "list auth lockouts")
+	tcompare(t, len(l), 3) // Account mjl, ipv4 and ipv6 network.
+
+	// Lockouts are loaded from the database.
+	err = authLockoutLoad(ctxbg)
+	tcheck(t, err, "load auth lockouts")
+	tcompare(t, AuthLockedOut(ip1, ""), true)
+
+	n, err := AuthLockoutClear(ctxbg, "mjl")
+	tcheck(t, err, "clear auth lockout")
+	tcompare(t, n, 1)
+	tcompare(t, AuthLockedOut(nil, "mjl"), false)
+
+	n, err = AuthLockoutClear(ctxbg, "")
+	tcheck(t, err, "clear auth lockouts")
+	tcompare(t, n, 2)
+	tcompare(t, AuthLockedOut(ip1, ""), false)
+	tcompare(t, AuthLockedOut(ip6a, ""), false)
+}
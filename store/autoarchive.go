@@ -0,0 +1,184 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// Maximum number of messages moved in a single transaction while archiving. The
+// account wlock is released between batches, so deliveries and IMAP sessions are
+// not blocked for long. Variable for tests.
+var autoArchiveBatchSize = 1000
+
+// AutoArchiveApply moves messages in the Inbox that are older than the period of
+// the AutoArchive policy of the account to the archive mailbox for the year they
+// were received in, e.g. Archive/2024. Archive mailboxes are created as needed.
+// Messages are moved in batches, with progress logged. The number of moved
+// messages is returned.
+//
+// Changes are broadcasted.
+func (a *Account) AutoArchiveApply(ctx context.Context, log mlog.Log, now time.Time) (moved int, rerr error) {
+	conf, _ := a.Conf()
+	if conf.AutoArchive == nil {
+		return 0, nil
+	}
+	parent := conf.AutoArchive.Mailbox
+	if parent == "" {
+		parent = "Archive"
+	}
+	before := now.Add(-conf.AutoArchive.Period)
+
+	for {
+		if ctx.Err() != nil {
+			return moved, ctx.Err()
+		}
+
+		var n int
+		var err error
+		a.WithWLock(func() {
+			n, err = a.autoArchiveBatch(ctx, log, parent, before)
+		})
+		if err != nil {
+			return moved, err
+		} else if n == 0 {
+			return moved, nil
+		}
+		moved += n
+		log.Info("archived old inbox messages", slog.Int("batch", n), slog.Int("total", moved))
+		if n < autoArchiveBatchSize {
+			return moved, nil
+		}
+	}
+}
+
+// autoArchiveBatch moves a batch of messages received before "before" from the
+// Inbox to per-year mailboxes under parent. Must be called with wlock held.
+func (a *Account) autoArchiveBatch(ctx context.Context, log mlog.Log, parent string, before time.Time) (moved int, rerr error) {
+	var changes []Change
+
+	var newIDs []int64
+	defer func() {
+		for _, id := range newIDs {
+			p := a.MessagePath(id)
+			err := os.Remove(p)
+			log.Check(err, "removing delivered message after failure", slog.String("path", p))
+		}
+	}()
+
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		mbInbox, err := a.MailboxFind(tx, "Inbox")
+		if err != nil {
+			return fmt.Errorf("looking up inbox: %v", err)
+		} else if mbInbox == nil {
+			return nil
+		}
+
+		q := bstore.QueryTx[Message](tx)
+		q.FilterNonzero(Message{MailboxID: mbInbox.ID})
+		q.FilterEqual("Expunged", false)
+		q.FilterLess("Received", before)
+		q.SortAsc("Received")
+		q.Limit(autoArchiveBatchSize)
+		msgs, err := q.List()
+		if err != nil {
+			return fmt.Errorf("listing old messages in inbox: %v", err)
+		}
+
+		years := map[int][]Message{}
+		for _, m := range msgs {
+			years[m.Received.Year()] = append(years[m.Received.Year()], m)
+		}
+
+		var modseq ModSeq
+		for _, year := range slices.Sorted(maps.Keys(years)) {
+			name := fmt.Sprintf("%s/%d", parent, year)
+			mb, nchanges, err := a.MailboxEnsure(tx, name, true, SpecialUse{}, &modseq)
+			if err != nil {
+				return fmt.Errorf("ensuring archive mailbox %q: %w", name, err)
+			}
+			changes = append(changes, nchanges...)
+
+			ids, nchanges, err := a.MessageMove(ctx, log, tx, &mb, &modseq, years[year]...)
+			newIDs = append(newIDs, ids...)
+			if err != nil {
+				return fmt.Errorf("moving messages to archive mailbox %q: %w", name, err)
+			}
+			changes = append(changes, nchanges...)
+			moved += len(years[year])
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	newIDs = nil
+
+	BroadcastChanges(a, changes)
+	return moved, nil
+}
+
+// StartAutoArchive starts a goroutine that periodically moves old Inbox messages
+// to archive mailboxes according to the AutoArchive policies of accounts.
+func StartAutoArchive() {
+	log := mlog.New("store", nil)
+
+	go func() {
+		defer func() {
+			x := recover()
+			if x == nil {
+				return
+			}
+
+			log.Error("unhandled panic in auto archive", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Store)
+		}()
+
+		t := time.NewTicker(time.Hour)
+		defer t.Stop()
+		for {
+			autoArchiveApply(log)
+
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+func autoArchiveApply(log mlog.Log) {
+	for _, name := range mox.Conf.Accounts() {
+		if conf, ok := mox.Conf.Account(name); !ok || conf.AutoArchive == nil {
+			continue
+		}
+
+		alog := log.With(slog.String("account", name))
+		acc, err := OpenAccount(alog, name, false)
+		if err != nil {
+			alog.Errorx("open account for auto archive", err)
+			continue
+		}
+		moved, err := acc.AutoArchiveApply(mox.Shutdown, alog, time.Now())
+		if err != nil {
+			alog.Errorx("archiving old inbox messages", err)
+		} else if moved > 0 {
+			alog.Info("archived old inbox messages finished", slog.Int("count", moved))
+		}
+		err = acc.Close()
+		alog.Check(err, "closing account after auto archive")
+	}
+}
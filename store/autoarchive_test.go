@@ -0,0 +1,89 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestAutoArchive(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	defer Switchboard()()
+	acc, err := OpenAccount(log, "mjl", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.WaitClosed()
+	}()
+
+	deliver := func(mailbox string, received time.Time) {
+		t.Helper()
+		msg := "Subject: test\r\n\r\ntest\r\n"
+		f, err := CreateMessageTemp(log, "autoarchive-test")
+		tcheck(t, err, "create temp message file")
+		defer CloseRemoveTempFile(log, f, "temp message file")
+		_, err = f.Write([]byte(msg))
+		tcheck(t, err, "write message")
+
+		m := Message{Received: received, Size: int64(len(msg))}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, mailbox, &m, f)
+		})
+		tcheck(t, err, "deliver")
+	}
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	deliver("Inbox", time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC))
+	deliver("Inbox", time.Date(2024, 12, 1, 12, 0, 0, 0, time.UTC))
+	deliver("Inbox", time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC))
+	deliver("Inbox", time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC))
+	deliver("Trash", time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC))
+
+	count := func(mailbox string) int {
+		t.Helper()
+		mb, err := bstore.QueryDB[Mailbox](ctxbg, acc.DB).FilterNonzero(Mailbox{Name: mailbox}).FilterEqual("Expunged", false).Get()
+		if err == bstore.ErrAbsent {
+			return -1
+		}
+		tcheck(t, err, "get mailbox")
+		n, err := bstore.QueryDB[Message](ctxbg, acc.DB).FilterNonzero(Message{MailboxID: mb.ID}).FilterEqual("Expunged", false).Count()
+		tcheck(t, err, "count messages")
+		tcompare(t, int(mb.Total+mb.Deleted), n)
+		return n
+	}
+
+	// Move in multiple batches.
+	defer func(n int) {
+		autoArchiveBatchSize = n
+	}(autoArchiveBatchSize)
+	autoArchiveBatchSize = 2
+
+	moved, err := acc.AutoArchiveApply(ctxbg, log, now)
+	tcheck(t, err, "auto archive")
+	tcompare(t, moved, 3)
+	tcompare(t, count("Inbox"), 1)
+	tcompare(t, count("Trash"), 1)
+	tcompare(t, count("Archive/2023"), 1)
+	tcompare(t, count("Archive/2024"), 2)
+	tcompare(t, count("Archive/2025"), -1)
+
+	// Nothing more to move.
+	moved, err = acc.AutoArchiveApply(ctxbg, log, now)
+	tcheck(t, err, "auto archive")
+	tcompare(t, moved, 0)
+
+	moved, err = acc.AutoArchiveApply(ctxbg, log, now.Add(60*24*time.Hour))
+	tcheck(t, err, "auto archive")
+	tcompare(t, moved, 1)
+	tcompare(t, count("Inbox"), 0)
+	tcompare(t, count("Archive/2025"), 1)
+}
@@ -0,0 +1,382 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+)
+
+// Message files can be stored compressed with zstd, see
+// config.MessageCompression. Compressed files are recognized by the zstd magic
+// number, which is not valid at the start of a message, and are decompressed
+// transparently by MsgReader. Message sizes in the database, and quota, are
+// always based on the uncompressed size.
+//
+// Compressed files are written in the zstd "seekable format": The message is
+// compressed in independent frames of at most zstdFrameSize bytes, followed by a
+// skippable frame with a seek table that has the compressed and decompressed
+// size of each frame. Readers only decompress the frames they need, and keep
+// just the most recently used frame in memory. Regular zstd tools can still
+// decompress the files, they ignore the seek table. Files without seek table
+// are read as a single frame.
+//
+// The Go standard library has no zstd implementation. The
+// github.com/klauspost/compress/zstd package is pure Go (no cgo), BSD-licensed,
+// widely used and has no further dependencies. Only its zstd package is used.
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+const (
+	zstdFrameSize         = 128 * 1024 // Maximum decompressed size of a frame in a compressed message file.
+	zstdSkippableMagic    = 0x184d2a5e // With lowest 4 bits 0xe, as used for the seek table.
+	zstdSeekableMagic     = 0x8f92eab1 // At the end of the seek table.
+	zstdSeekTableFooter   = 9          // Number of frames (4), descriptor (1), seekable magic (4).
+	zstdSeekTableMaxCount = 1 << 20    // Sanity check, 128GB of message with 128KB frames.
+)
+
+// Encoder and decoder for compressed message files, safe for concurrent use with
+// EncodeAll and DecodeAll.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+// msgFileCompressed returns whether a message file is compressed.
+func msgFileCompressed(r io.ReaderAt) (bool, error) {
+	buf := make([]byte, len(zstdMagic))
+	n, err := r.ReadAt(buf, 0)
+	if n == len(buf) {
+		return bytes.Equal(buf, zstdMagic), nil
+	} else if err == io.EOF {
+		return false, nil
+	}
+	return false, err
+}
+
+// zstdFrame is a frame in a compressed message file.
+type zstdFrame struct {
+	offset, size   int64 // Compressed, in file.
+	doffset, dsize int64 // Decompressed, in message.
+}
+
+// zstdIndex holds the frames of a compressed message file.
+type zstdIndex struct {
+	frames []zstdFrame
+	size   int64 // Total decompressed size.
+}
+
+// find returns the index of the frame that contains decompressed offset off, or
+// len(frames) if off is beyond the end.
+func (x *zstdIndex) find(off int64) int {
+	return sort.Search(len(x.frames), func(i int) bool {
+		f := x.frames[i]
+		return off < f.doffset+f.dsize
+	})
+}
+
+// readZstdIndex reads the seek table of a compressed message file of fileSize
+// bytes. For files without seek table, the file is treated as a single frame, and
+// is decompressed to find its size if the frame header does not have it.
+func readZstdIndex(r io.ReaderAt, fileSize int64) (*zstdIndex, error) {
+	if x, err := readZstdSeekTable(r, fileSize); err != nil || x != nil {
+		return x, err
+	}
+
+	f := zstdFrame{size: fileSize, dsize: -1}
+	buf := make([]byte, zstd.HeaderMaxSize)
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading compressed message file header: %v", err)
+	}
+	var h zstd.Header
+	if err := h.Decode(buf[:n]); err == nil && h.HasFCS {
+		f.dsize = int64(h.FrameContentSize)
+	} else {
+		data, err := zstdDecodeFrame(r, f, nil)
+		if err != nil {
+			return nil, err
+		}
+		f.dsize = int64(len(data))
+	}
+	return &zstdIndex{[]zstdFrame{f}, f.dsize}, nil
+}
+
+// readZstdSeekTable returns the frames from the seek table at the end of the
+// file, or nil if the file has no seek table.
+func readZstdSeekTable(r io.ReaderAt, fileSize int64) (*zstdIndex, error) {
+	if fileSize < 8+zstdSeekTableFooter {
+		return nil, nil
+	}
+	footer := make([]byte, zstdSeekTableFooter)
+	if _, err := r.ReadAt(footer, fileSize-zstdSeekTableFooter); err != nil {
+		return nil, fmt.Errorf("reading seek table footer: %v", err)
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != zstdSeekableMagic {
+		return nil, nil
+	}
+	count := int64(binary.LittleEndian.Uint32(footer[0:]))
+	descriptor := footer[4]
+	entrySize := int64(8)
+	if descriptor&0x80 != 0 {
+		entrySize += 4 // Checksum, we don't verify it.
+	}
+	tableSize := 8 + count*entrySize + zstdSeekTableFooter
+	if count > zstdSeekTableMaxCount || descriptor&0x7c != 0 || tableSize > fileSize {
+		return nil, fmt.Errorf("invalid seek table")
+	}
+	buf := make([]byte, tableSize)
+	if _, err := r.ReadAt(buf, fileSize-tableSize); err != nil {
+		return nil, fmt.Errorf("reading seek table: %v", err)
+	}
+	if binary.LittleEndian.Uint32(buf[0:]) != zstdSkippableMagic || int64(binary.LittleEndian.Uint32(buf[4:])) != tableSize-8 {
+		return nil, fmt.Errorf("invalid seek table frame header")
+	}
+
+	x := &zstdIndex{frames: make([]zstdFrame, count)}
+	var offset int64
+	for i := range x.frames {
+		o := 8 + int64(i)*entrySize
+		size := int64(binary.LittleEndian.Uint32(buf[o:]))
+		dsize := int64(binary.LittleEndian.Uint32(buf[o+4:]))
+		x.frames[i] = zstdFrame{offset, size, x.size, dsize}
+		offset += size
+		x.size += dsize
+	}
+	if offset != fileSize-tableSize {
+		return nil, fmt.Errorf("seek table does not match file size")
+	}
+	return x, nil
+}
+
+// zstdDecodeFrame reads and decompresses frame f, appending to dst, which is
+// typically a reused buffer with length 0.
+func zstdDecodeFrame(r io.ReaderAt, f zstdFrame, dst []byte) ([]byte, error) {
+	buf := make([]byte, f.size)
+	if _, err := r.ReadAt(buf, f.offset); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading compressed message file: %v", err)
+	}
+	data, err := zstdDecoder.DecodeAll(buf, dst)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing message file: %v", err)
+	}
+	if f.dsize >= 0 && int64(len(data)-len(dst)) != f.dsize {
+		return nil, fmt.Errorf("decompressed frame has size %d, expected %d", len(data)-len(dst), f.dsize)
+	}
+	return data, nil
+}
+
+// MessageFileSize returns the size of the contents of a message file, i.e. the
+// uncompressed size for compressed files.
+func MessageFileSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	compressed, err := msgFileCompressed(f)
+	if err != nil {
+		return 0, fmt.Errorf("reading message file: %v", err)
+	} else if !compressed {
+		return st.Size(), nil
+	}
+	x, err := readZstdIndex(f, st.Size())
+	if err != nil {
+		return 0, err
+	}
+	return x.size, nil
+}
+
+// compressMessage returns whether a new message file of size should be stored
+// compressed according to the configuration.
+func compressMessage(size int64) bool {
+	mc := mox.Conf.Static.MessageCompression
+	return mc != nil && size > 0 && size >= mc.MinSize
+}
+
+// writeCompressed writes the first size bytes of src compressed to a new file at
+// path, in frames followed by a seek table, and syncs it. The size of the
+// compressed file is returned.
+func writeCompressed(path string, src io.ReaderAt, size int64) (csize int64, rerr error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+		if rerr != nil {
+			os.Remove(path)
+		}
+	}()
+
+	// Seek table, its skippable frame header is filled in at the end.
+	table := make([]byte, 8)
+	var buf, cbuf []byte
+	for offset := int64(0); offset < size; {
+		n := min(size-offset, zstdFrameSize)
+		if cap(buf) < int(n) {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+		if _, err := src.ReadAt(buf, offset); err != nil && !(err == io.EOF && offset+n == size) {
+			return 0, fmt.Errorf("reading message: %w", err)
+		}
+		cbuf = zstdEncoder.EncodeAll(buf, cbuf[:0])
+		if _, err := f.Write(cbuf); err != nil {
+			return 0, fmt.Errorf("writing compressed frame: %w", err)
+		}
+		table = binary.LittleEndian.AppendUint32(table, uint32(len(cbuf)))
+		table = binary.LittleEndian.AppendUint32(table, uint32(n))
+		csize += int64(len(cbuf))
+		offset += n
+	}
+	nframes := (len(table) - 8) / 8
+	table = binary.LittleEndian.AppendUint32(table, uint32(nframes))
+	table = append(table, 0) // Descriptor, no checksums.
+	table = binary.LittleEndian.AppendUint32(table, zstdSeekableMagic)
+	binary.LittleEndian.PutUint32(table[0:], zstdSkippableMagic)
+	binary.LittleEndian.PutUint32(table[4:], uint32(len(table)-8))
+	if _, err := f.Write(table); err != nil {
+		return 0, fmt.Errorf("writing seek table: %w", err)
+	}
+	csize += int64(len(table))
+
+	if err := f.Sync(); err != nil {
+		return 0, fmt.Errorf("sync: %w", err)
+	}
+	err = f.Close()
+	f = nil
+	return csize, err
+}
+
+// CompressMessages compresses the on-disk files of messages in the account that
+// are not compressed yet and are at least minSize bytes. Files that would not
+// become smaller are left alone, as are files with multiple hard links: They are
+// shared with other messages, e.g. through deduplication, and compressing each
+// would use more disk space. Messages are processed in batches, with
+// progress logged, so the account is not blocked while compressing. The number
+// of compressed files and the number of bytes saved on disk are returned.
+//
+// Compressed files are replaced atomically, sessions that have a file open keep
+// reading the uncompressed file.
+func (a *Account) CompressMessages(ctx context.Context, log mlog.Log, minSize int64) (n int, saved int64, rerr error) {
+	const batchSize = 1000
+
+	var lastID int64
+	for {
+		var ids []int64
+		err := a.DB.Read(ctx, func(tx *bstore.Tx) error {
+			q := bstore.QueryTx[Message](tx)
+			q.FilterEqual("Expunged", false)
+			q.FilterGreater("ID", lastID)
+			q.SortAsc("ID")
+			q.Limit(batchSize)
+			return q.IDs(&ids)
+		})
+		if err != nil {
+			return n, saved, fmt.Errorf("listing messages: %v", err)
+		}
+
+		for _, id := range ids {
+			if ctx.Err() != nil {
+				return n, saved, ctx.Err()
+			}
+			lastID = id
+
+			s, err := a.compressMessageFile(ctx, log, id, minSize)
+			if err != nil {
+				return n, saved, fmt.Errorf("compressing message %d: %w", id, err)
+			} else if s > 0 {
+				n++
+				saved += s
+			}
+		}
+		if len(ids) < batchSize {
+			return n, saved, nil
+		}
+		log.Info("compressing message files", slog.Int("compressed", n), slog.Int64("saved", saved), slog.Int64("lastid", lastID))
+	}
+}
+
+// compressMessageFile compresses the file of a message if needed, returning the
+// number of bytes saved, 0 if the file was not compressed.
+func (a *Account) compressMessageFile(ctx context.Context, log mlog.Log, id, minSize int64) (int64, error) {
+	p := a.MessagePath(id)
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, fmt.Errorf("open message file: %v", err)
+	}
+	defer func() {
+		err := f.Close()
+		log.Check(err, "closing message file")
+	}()
+	st, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat message file: %v", err)
+	}
+	size := st.Size()
+	if size == 0 || size < minSize {
+		return 0, nil
+	} else if n, ok := moxio.LinkCount(st); ok && n > 1 {
+		return 0, nil
+	}
+	if compressed, err := msgFileCompressed(f); err != nil {
+		return 0, fmt.Errorf("reading message file: %v", err)
+	} else if compressed {
+		return 0, nil
+	}
+
+	tmp := p + ".compress"
+	csize, err := writeCompressed(tmp, f, size)
+	if err != nil {
+		return 0, fmt.Errorf("writing compressed file: %w", err)
+	}
+	defer func() {
+		if tmp != "" {
+			err := os.Remove(tmp)
+			log.Check(err, "removing temporary compressed message file", slog.String("path", tmp))
+		}
+	}()
+	if csize >= size {
+		return 0, nil
+	}
+
+	// Expunging requires the account wlock, so with the rlock the message cannot
+	// disappear between the check and replacing the file.
+	var expunged bool
+	a.WithRLock(func() {
+		m := Message{ID: id}
+		err = a.DB.Get(ctx, &m)
+		if err != nil || m.Expunged {
+			expunged = m.Expunged
+			return
+		}
+		err = os.Rename(tmp, p)
+	})
+	if errors.Is(err, bstore.ErrAbsent) || err == nil && expunged {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	tmp = ""
+	return size - csize, nil
+}
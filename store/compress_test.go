@@ -0,0 +1,180 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestCompress(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	defer Switchboard()()
+	acc, err := OpenAccount(log, "mjl", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.WaitClosed()
+	}()
+
+	msg := "Subject: test\r\n\r\n" + strings.Repeat("compressible message text\r\n", 100)
+	deliver := func() Message {
+		t.Helper()
+		f, err := CreateMessageTemp(log, "compress-test")
+		tcheck(t, err, "create temp message file")
+		defer CloseRemoveTempFile(log, f, "temp message file")
+		_, err = f.Write([]byte(msg))
+		tcheck(t, err, "write message")
+
+		m := Message{Size: int64(len(msg))}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, "Inbox", &m, f)
+		})
+		tcheck(t, err, "deliver")
+		return m
+	}
+
+	compressed := func(m Message) bool {
+		t.Helper()
+		f, err := os.Open(acc.MessagePath(m.ID))
+		tcheck(t, err, "open message file")
+		defer f.Close()
+		c, err := msgFileCompressed(f)
+		tcheck(t, err, "check compressed")
+		return c
+	}
+
+	checkRead := func(m Message) {
+		t.Helper()
+		mr := acc.MessageReader(m)
+		defer mr.Close()
+		buf, err := io.ReadAll(mr)
+		tcheck(t, err, "read message")
+		tcompare(t, string(buf), msg)
+
+		size, err := MessageFileSize(acc.MessagePath(m.ID))
+		tcheck(t, err, "message file size")
+		tcompare(t, size, int64(len(msg)))
+
+		f, err := os.Open(acc.MessagePath(m.ID))
+		tcheck(t, err, "open message file")
+		defer f.Close()
+		fmr := FileMsgReader(nil, f)
+		tcompare(t, fmr.Size(), int64(len(msg)))
+		buf = make([]byte, 9)
+		_, err = fmr.ReadAt(buf, 4)
+		tcheck(t, err, "readat")
+		tcompare(t, string(buf), msg[4:13])
+	}
+
+	// Not compressed without configuration.
+	m0 := deliver()
+	tcompare(t, compressed(m0), false)
+
+	// Messages below the minimum size are not compressed.
+	mox.Conf.Static.MessageCompression = &config.MessageCompression{MinSize: int64(len(msg)) + 1}
+	defer func() {
+		mox.Conf.Static.MessageCompression = nil
+	}()
+	m1 := deliver()
+	tcompare(t, compressed(m1), false)
+
+	mox.Conf.Static.MessageCompression.MinSize = 0
+	m2 := deliver()
+	tcompare(t, compressed(m2), true)
+	checkRead(m2)
+
+	// Compress existing messages.
+	n, saved, err := acc.CompressMessages(ctxbg, log, 0)
+	tcheck(t, err, "compress messages")
+	tcompare(t, n, 2)
+	if saved <= 0 {
+		t.Fatalf("saved %d bytes, expected more than 0", saved)
+	}
+	tcompare(t, compressed(m0), true)
+	tcompare(t, compressed(m1), true)
+	checkRead(m0)
+	checkRead(m1)
+
+	// Nothing left to do.
+	n, _, err = acc.CompressMessages(ctxbg, log, 0)
+	tcheck(t, err, "compress messages")
+	tcompare(t, n, 0)
+
+	err = acc.CheckConsistency()
+	tcheck(t, err, "check consistency")
+}
+
+// Test reading compressed files with multiple frames, only decompressing the
+// frames needed, and files without seek table.
+func TestCompressFrames(t *testing.T) {
+	dir := t.TempDir()
+
+	var b strings.Builder
+	for i := 0; b.Len() < 3*zstdFrameSize+1000; i++ {
+		fmt.Fprintf(&b, "line %d of a large message\r\n", i)
+	}
+	msg := b.String()
+
+	check := func(path string, expFrames int) {
+		t.Helper()
+		f, err := os.Open(path)
+		tcheck(t, err, "open compressed file")
+		defer f.Close()
+		mr := FileMsgReader([]byte("prefix\r\n"), f)
+		tcompare(t, mr.Size(), int64(len("prefix\r\n")+len(msg)))
+		tcompare(t, len(mr.zindex.frames), expFrames)
+
+		// Read across frame boundaries.
+		for _, off := range []int{zstdFrameSize - 10, 10, 2*zstdFrameSize + 3, len(msg) - 5} {
+			buf := make([]byte, 20)
+			n, err := mr.ReadAt(buf, int64(len("prefix\r\n")+off))
+			if n != min(20, len(msg)-off) || err != nil && err != io.EOF {
+				t.Fatalf("readat %d: n %d, err %v", off, n, err)
+			}
+			tcompare(t, string(buf[:n]), msg[off:off+n])
+			if expFrames > 1 && len(mr.zdata) > zstdFrameSize {
+				t.Fatalf("decompressed %d bytes, expected at most one frame", len(mr.zdata))
+			}
+		}
+
+		buf, err := io.ReadAll(mr)
+		tcheck(t, err, "read all")
+		tcompare(t, string(buf), "prefix\r\n"+msg)
+
+		size, err := MessageFileSize(path)
+		tcheck(t, err, "message file size")
+		tcompare(t, size, int64(len(msg)))
+	}
+
+	p := filepath.Join(dir, "framed")
+	_, err := writeCompressed(p, strings.NewReader(msg), int64(len(msg)))
+	tcheck(t, err, "write compressed")
+	check(p, 4)
+
+	// Single frame, without seek table.
+	p = filepath.Join(dir, "single")
+	f, err := os.Create(p)
+	tcheck(t, err, "create file")
+	enc, err := zstd.NewWriter(f)
+	tcheck(t, err, "new zstd writer")
+	_, err = enc.Write([]byte(msg))
+	tcheck(t, err, "write")
+	err = enc.Close()
+	tcheck(t, err, "close encoder")
+	err = f.Close()
+	tcheck(t, err, "close file")
+	check(p, 1)
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+)
+
+// Message files can be deduplicated, see config.MessageDedup. The data directory
+// has a "msgdedup" directory with a file for each distinct message file contents,
+// named after the SHA-256 hash of the (uncompressed) contents. Account message
+// files are hard links to these files. The link count of a file in msgdedup is its
+// reference count: A file that is only linked from msgdedup is no longer used by
+// any message, and is removed by DedupCleanup.
+
+// DedupDir is the directory in the data directory with deduplicated message files.
+const DedupDir = "msgdedup"
+
+// DedupPath returns the path of a deduplicated message file relative to DedupDir,
+// for the hex-encoded SHA-256 hash of its contents.
+func DedupPath(hexHash string) string {
+	return filepath.Join(hexHash[:2], hexHash)
+}
+
+// MessageFileHash returns the hex-encoded SHA-256 hash of the contents of a
+// message file, uncompressed if needed.
+func MessageFileHash(f *os.File) (string, error) {
+	mr := FileMsgReader(nil, f)
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(mr, 0, mr.Size())); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dedupLink attempts to create msgPath as hard link to an existing deduplicated
+// message file with hash, returning whether it was linked.
+func dedupLink(log mlog.Log, hash, msgPath string) bool {
+	p := mox.DataDirPath(filepath.Join(DedupDir, DedupPath(hash)))
+	err := os.Link(p, msgPath)
+	if err != nil && !os.IsNotExist(err) {
+		// E.g. too many links, or a file system without hard links. We'll write a new file.
+		log.Debugx("linking to deduplicated message file", err, slog.String("path", p))
+	}
+	return err == nil
+}
+
+// dedupRegister links msgPath from the dedup directory, so future messages with
+// the same contents can link to it.
+func dedupRegister(log mlog.Log, hash, msgPath string) {
+	p := mox.DataDirPath(filepath.Join(DedupDir, DedupPath(hash)))
+	os.MkdirAll(filepath.Dir(p), 0770)
+	err := os.Link(msgPath, p)
+	if err != nil && !os.IsExist(err) {
+		log.Errorx("linking message file from dedup directory", err, slog.String("path", p))
+	}
+}
+
+// DedupCleanup removes files from the dedup directory that are no longer linked
+// from any account, returning the number of removed files. Nothing is removed if
+// link counts cannot be determined, e.g. on Windows.
+func DedupCleanup(ctx context.Context, log mlog.Log) (removed int, rerr error) {
+	dir := mox.DataDirPath(DedupDir)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == dir {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("stat: %v", err)
+		}
+		// A delivery could be linking to the file at the same time. It can only have
+		// linked before we remove it, and would write a new file after.
+		if n, ok := moxio.LinkCount(fi); ok && n <= 1 {
+			if err := os.Remove(p); err != nil {
+				return fmt.Errorf("removing unused deduplicated message file: %v", err)
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// StartDedupCleanup starts a goroutine that periodically removes deduplicated
+// message files that are no longer used.
+func StartDedupCleanup() {
+	log := mlog.New("store", nil)
+
+	go func() {
+		defer func() {
+			x := recover()
+			if x == nil {
+				return
+			}
+
+			log.Error("unhandled panic in dedup cleanup", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Store)
+		}()
+
+		t := time.NewTicker(24 * time.Hour)
+		defer t.Stop()
+		for {
+			removed, err := DedupCleanup(mox.Shutdown, log)
+			if err != nil {
+				log.Errorx("cleaning up deduplicated message files", err)
+			} else if removed > 0 {
+				log.Info("removed unused deduplicated message files", slog.Int("count", removed))
+			}
+
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestDedup(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	defer Switchboard()()
+	acc, err := OpenAccount(log, "mjl", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.WaitClosed()
+	}()
+
+	mox.Conf.Static.MessageDedup = true
+	defer func() {
+		mox.Conf.Static.MessageDedup = false
+	}()
+
+	deliver := func(msg string) Message {
+		t.Helper()
+		f, err := CreateMessageTemp(log, "dedup-test")
+		tcheck(t, err, "create temp message file")
+		defer CloseRemoveTempFile(log, f, "temp message file")
+		_, err = f.Write([]byte(msg))
+		tcheck(t, err, "write message")
+
+		m := Message{Size: int64(len(msg)), MsgPrefix: []byte("Received: test\r\n")}
+		m.Size += int64(len(m.MsgPrefix))
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, "Inbox", &m, f)
+		})
+		tcheck(t, err, "deliver")
+		return m
+	}
+
+	sameFile := func(a, b Message) bool {
+		t.Helper()
+		fa, err := os.Stat(acc.MessagePath(a.ID))
+		tcheck(t, err, "stat message file")
+		fb, err := os.Stat(acc.MessagePath(b.ID))
+		tcheck(t, err, "stat message file")
+		return os.SameFile(fa, fb)
+	}
+
+	msg := "Subject: test\r\n\r\ntest\r\n"
+	m0 := deliver(msg)
+	m1 := deliver(msg)
+	m2 := deliver("Subject: other\r\n\r\ntest\r\n")
+	tcompare(t, sameFile(m0, m1), true)
+	tcompare(t, sameFile(m0, m2), false)
+
+	f, err := os.Open(acc.MessagePath(m0.ID))
+	tcheck(t, err, "open message file")
+	hash, err := MessageFileHash(f)
+	f.Close()
+	tcheck(t, err, "hash message file")
+	dedupPath := mox.DataDirPath(filepath.Join(DedupDir, DedupPath(hash)))
+	_, err = os.Stat(dedupPath)
+	tcheck(t, err, "stat dedup file")
+
+	err = acc.CheckConsistency()
+	tcheck(t, err, "check consistency")
+
+	// Files still in use are kept.
+	removed, err := DedupCleanup(ctxbg, log)
+	tcheck(t, err, "dedup cleanup")
+	tcompare(t, removed, 0)
+
+	// Once no message uses the file anymore, it is removed.
+	for _, m := range []Message{m0, m1} {
+		err := os.Remove(acc.MessagePath(m.ID))
+		tcheck(t, err, "remove message file")
+	}
+	removed, err = DedupCleanup(ctxbg, log)
+	tcheck(t, err, "dedup cleanup")
+	tcompare(t, removed, 1)
+	_, err = os.Stat(dedupPath)
+	tcompare(t, os.IsNotExist(err), true)
+
+	// Restore the message files for the consistency check on close.
+	for _, m := range []Message{m0, m1} {
+		err := os.WriteFile(acc.MessagePath(m.ID), []byte(msg), 0660)
+		tcheck(t, err, "write message file")
+	}
+}
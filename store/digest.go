@@ -0,0 +1,275 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// DigestState is a single record with ID 1, tracking the most recent weekly
+// digest message delivered to the account.
+type DigestState struct {
+	ID        int64
+	PeriodEnd time.Time // End of period covered by the last delivered digest.
+}
+
+// DigestSender is a sender (message From address) with the number of messages in
+// the period of a digest.
+type DigestSender struct {
+	Address string
+	Count   int
+}
+
+// Digest has statistics about incoming messages for an account over a period,
+// for a weekly digest message.
+type Digest struct {
+	Start time.Time
+	End   time.Time
+
+	Received   int            // Messages received over SMTP, including junk.
+	Junk       int            // Received messages marked as junk or delivered to the rejects mailbox.
+	NewSenders []DigestSender // Senders of non-junk messages without earlier messages.
+	TopSenders []DigestSender // Senders with most non-junk messages, at most 10.
+
+	MessageSize      int64 // Total size of all messages in the account.
+	QuotaMessageSize int64 // Maximum total size of messages, 0 if unlimited.
+}
+
+// DigestPeriod returns the most recent full week before now, starting on Monday
+// 00:00 UTC, that a digest covers.
+func DigestPeriod(now time.Time) (start, end time.Time) {
+	now = now.UTC()
+	end = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	end = end.AddDate(0, 0, -((int(end.Weekday()) + 6) % 7))
+	return end.AddDate(0, 0, -7), end
+}
+
+// DigestGather gathers statistics for messages received from start until end.
+func (a *Account) DigestGather(ctx context.Context, start, end time.Time) (Digest, error) {
+	d := Digest{
+		Start:            start,
+		End:              end,
+		QuotaMessageSize: a.QuotaMessageSize(),
+	}
+	err := a.DB.Read(ctx, func(tx *bstore.Tx) error {
+		du := DiskUsage{ID: 1}
+		if err := tx.Get(&du); err != nil {
+			return fmt.Errorf("get disk usage: %v", err)
+		}
+		d.MessageSize = du.MessageSize
+
+		counts := map[string]int{}
+		senders := map[string]Message{} // First message per sender, for checking earlier messages.
+		q := bstore.QueryTx[Message](tx)
+		q.FilterEqual("Expunged", false)
+		q.FilterGreaterEqual("Received", start)
+		q.FilterLess("Received", end)
+		q.FilterFn(func(m Message) bool { return m.RemoteIP != "" })
+		err := q.ForEach(func(m Message) error {
+			d.Received++
+			if m.Junk || m.IsReject {
+				d.Junk++
+				return nil
+			}
+			if m.MsgFromDomain == "" {
+				return nil
+			}
+			addr := m.MsgFromLocalpart.String() + "@" + m.MsgFromDomain
+			counts[addr]++
+			if _, ok := senders[addr]; !ok {
+				senders[addr] = m
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("listing received messages: %v", err)
+		}
+
+		for addr, m := range senders {
+			// Expunged messages count too, the sender was seen before.
+			q := bstore.QueryTx[Message](tx)
+			q.FilterNonzero(Message{MsgFromDomain: m.MsgFromDomain})
+			q.FilterEqual("MsgFromLocalpart", m.MsgFromLocalpart)
+			q.FilterLess("Received", start)
+			seen, err := q.Exists()
+			if err != nil {
+				return fmt.Errorf("looking up earlier messages from sender: %v", err)
+			}
+			ds := DigestSender{addr, counts[addr]}
+			if !seen {
+				d.NewSenders = append(d.NewSenders, ds)
+			}
+			d.TopSenders = append(d.TopSenders, ds)
+		}
+		return nil
+	})
+	if err != nil {
+		return Digest{}, err
+	}
+
+	// Most messages first, then by address for stable results.
+	sortSenders := func(l []DigestSender) {
+		sort.Slice(l, func(i, j int) bool {
+			if l[i].Count != l[j].Count {
+				return l[i].Count > l[j].Count
+			}
+			return l[i].Address < l[j].Address
+		})
+	}
+	sortSenders(d.NewSenders)
+	sortSenders(d.TopSenders)
+	if len(d.TopSenders) > 10 {
+		d.TopSenders = d.TopSenders[:10]
+	}
+	return d, nil
+}
+
+// Text returns the plain text for a digest message.
+func (d Digest) Text() string {
+	var b strings.Builder
+	const day = "Monday 2 January 2006"
+	fmt.Fprintf(&b, "Hi!\n\nThis is your weekly digest for %s until %s (UTC).\n\n", d.Start.Format(day), d.End.Format(day))
+	fmt.Fprintf(&b, "Messages received: %d\n", d.Received)
+	fmt.Fprintf(&b, "Messages marked as junk: %d\n", d.Junk)
+
+	senders := func(title string, l []DigestSender) {
+		fmt.Fprintf(&b, "\n%s:\n", title)
+		if len(l) == 0 {
+			b.WriteString("  (none)\n")
+		}
+		for _, s := range l {
+			fmt.Fprintf(&b, "  %s (%d)\n", s.Address, s.Count)
+		}
+	}
+	senders(fmt.Sprintf("New senders (%d)", len(d.NewSenders)), d.NewSenders)
+	senders("Top senders", d.TopSenders)
+
+	const mb = 1024 * 1024
+	fmt.Fprintf(&b, "\nStorage used: %.1f MB", float64(d.MessageSize)/mb)
+	if d.QuotaMessageSize > 0 {
+		fmt.Fprintf(&b, " of %.1f MB (%d%%)", float64(d.QuotaMessageSize)/mb, d.MessageSize*100/d.QuotaMessageSize)
+	}
+	b.WriteString("\n\nCheers,\nmox\n")
+	return b.String()
+}
+
+// DigestDeliver delivers a digest message for the most recent full week before
+// now into the Inbox, if not already delivered.
+func (a *Account) DigestDeliver(ctx context.Context, log mlog.Log, now time.Time) (delivered bool, rerr error) {
+	start, end := DigestPeriod(now)
+
+	ds := DigestState{ID: 1}
+	err := a.DB.Get(ctx, &ds)
+	if err != nil && err != bstore.ErrAbsent {
+		return false, fmt.Errorf("get digest state: %v", err)
+	}
+	exists := err == nil
+	if exists && !ds.PeriodEnd.Before(end) {
+		return false, nil
+	}
+
+	d, err := a.DigestGather(ctx, start, end)
+	if err != nil {
+		return false, fmt.Errorf("gathering digest statistics: %v", err)
+	}
+
+	f, err := CreateMessageTemp(log, "digest")
+	if err != nil {
+		return false, fmt.Errorf("creating temporary message file: %v", err)
+	}
+	defer CloseRemoveTempFile(log, f, "message for digest delivery")
+
+	text := strings.ReplaceAll(d.Text(), "\n", "\r\n")
+	n, err := fmt.Fprintf(f, "Date: %s\r\nFrom: <postmaster@%s>\r\nSubject: Weekly digest, week of %s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n%s", now.Format(message.RFC5322Z), mox.Conf.Static.HostnameDomain.ASCII, start.Format("2 January 2006"), text)
+	if err != nil {
+		return false, fmt.Errorf("writing temporary message file: %v", err)
+	}
+
+	m := Message{
+		Received: now,
+		Size:     int64(n),
+	}
+	a.WithWLock(func() {
+		rerr = a.DeliverMailbox(log, "Inbox", &m, f)
+	})
+	if rerr != nil {
+		return false, fmt.Errorf("delivering digest message: %v", rerr)
+	}
+
+	ds.PeriodEnd = end
+	if exists {
+		err = a.DB.Update(ctx, &ds)
+	} else {
+		err = a.DB.Insert(ctx, &ds)
+	}
+	if err != nil {
+		return true, fmt.Errorf("storing digest state: %v", err)
+	}
+	return true, nil
+}
+
+// StartDigests starts a goroutine that delivers weekly digest messages to
+// accounts with WeeklyDigest enabled, shortly after the start of each week
+// (Monday 00:00 UTC).
+func StartDigests() {
+	log := mlog.New("store", nil)
+
+	go func() {
+		defer func() {
+			x := recover()
+			if x == nil {
+				return
+			}
+
+			log.Error("unhandled panic in digest delivery", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Store)
+		}()
+
+		t := time.NewTicker(time.Hour)
+		defer t.Stop()
+		for {
+			digestsDeliver(log)
+
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+func digestsDeliver(log mlog.Log) {
+	for _, name := range mox.Conf.Accounts() {
+		if conf, ok := mox.Conf.Account(name); !ok || !conf.WeeklyDigest {
+			continue
+		}
+
+		alog := log.With(slog.String("account", name))
+		acc, err := OpenAccount(alog, name, false)
+		if err != nil {
+			alog.Errorx("open account for digest delivery", err)
+			continue
+		}
+		delivered, err := acc.DigestDeliver(mox.Shutdown, alog, time.Now())
+		if err != nil {
+			alog.Errorx("delivering weekly digest", err)
+		} else if delivered {
+			alog.Info("delivered weekly digest")
+		}
+		err = acc.Close()
+		alog.Check(err, "closing account after digest delivery")
+	}
+}
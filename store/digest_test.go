@@ -0,0 +1,118 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+)
+
+func TestDigest(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	err := Init(ctxbg)
+	tcheck(t, err, "init")
+	defer func() {
+		err := Close()
+		tcheck(t, err, "close")
+	}()
+	defer Switchboard()()
+	acc, err := OpenAccount(log, "mjl", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.WaitClosed()
+	}()
+
+	day := func(d int) time.Time {
+		return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC)
+	}
+
+	start, end := DigestPeriod(day(10))
+	tcompare(t, start, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	tcompare(t, end, time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC))
+	start, end = DigestPeriod(time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC))
+	tcompare(t, start, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	tcompare(t, end, time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC))
+
+	deliver := func(received time.Time, remoteIP, from string, junk bool) {
+		t.Helper()
+		msg := "Subject: test\r\n\r\ntest\r\n"
+		f, err := CreateMessageTemp(log, "digest-test")
+		tcheck(t, err, "create temp message file")
+		defer CloseRemoveTempFile(log, f, "temp message file")
+		_, err = f.Write([]byte(msg))
+		tcheck(t, err, "write message")
+
+		m := Message{
+			Received: received,
+			Size:     int64(len(msg)),
+			RemoteIP: remoteIP,
+		}
+		m.Junk = junk
+		if from != "" {
+			lp, dom, _ := strings.Cut(from, "@")
+			m.MsgFromLocalpart, m.MsgFromDomain = smtp.Localpart(lp), dom
+		}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, "Inbox", &m, f)
+		})
+		tcheck(t, err, "deliver")
+	}
+	deliver(time.Date(2023, 12, 20, 0, 0, 0, 0, time.UTC), "10.0.0.1", "old@a.example", false)
+	deliver(day(2), "10.0.0.1", "old@a.example", false)
+	deliver(day(3), "10.0.0.2", "new@b.example", false)
+	deliver(day(3), "10.0.0.2", "new@b.example", false)
+	deliver(day(4), "10.0.0.3", "spam@c.example", true)
+	deliver(day(5), "", "appended@b.example", false) // Not received over SMTP.
+	deliver(day(9), "10.0.0.4", "later@d.example", false)
+
+	d, err := acc.DigestGather(ctxbg, start, end)
+	tcheck(t, err, "gather digest")
+	tcompare(t, d.Received, 4)
+	tcompare(t, d.Junk, 1)
+	tcompare(t, d.NewSenders, []DigestSender{{"new@b.example", 2}})
+	tcompare(t, d.TopSenders, []DigestSender{{"new@b.example", 2}, {"old@a.example", 1}})
+	if d.MessageSize == 0 {
+		t.Fatalf("missing message size")
+	}
+	if !strings.Contains(d.Text(), "  new@b.example (2)\n") {
+		t.Fatalf("digest text does not mention new sender:\n%s", d.Text())
+	}
+
+	countInbox := func() int {
+		t.Helper()
+		q := bstore.QueryDB[Message](ctxbg, acc.DB)
+		q.FilterEqual("Expunged", false)
+		n, err := q.Count()
+		tcheck(t, err, "count messages")
+		return n
+	}
+	n := countInbox()
+
+	delivered, err := acc.DigestDeliver(ctxbg, log, day(10))
+	tcheck(t, err, "deliver digest")
+	tcompare(t, delivered, true)
+	tcompare(t, countInbox(), n+1)
+
+	// Already delivered for this week.
+	delivered, err = acc.DigestDeliver(ctxbg, log, day(14))
+	tcheck(t, err, "deliver digest")
+	tcompare(t, delivered, false)
+
+	// Next week.
+	delivered, err = acc.DigestDeliver(ctxbg, log, day(15))
+	tcheck(t, err, "deliver digest")
+	tcompare(t, delivered, true)
+	tcompare(t, countInbox(), n+2)
+}
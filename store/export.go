@@ -184,7 +184,7 @@ func ExportMessages(ctx context.Context, log mlog.Log, db *bstore.DB, accountDir
 			if trimPrefix != "" {
 				mailboxName = strings.TrimPrefix(mailboxName, trimPrefix)
 			}
-			errmsgs, err := exportMailbox(log, tx, accountDir, mb.ID, mailboxName, archiver, maildir, start)
+			errmsgs, err := exportMailbox(log, tx, accountDir, mb.ID, mailboxName, archiver, maildir, start, time.Time{}, time.Time{})
 			if err != nil {
 				return err
 			}
@@ -196,23 +196,27 @@ func ExportMessages(ctx context.Context, log mlog.Log, db *bstore.DB, accountDir
 		}
 	}
 
-	if errors != "" {
-		w, err := archiver.Create("errors.txt", int64(len(errors)), time.Now())
-		if err != nil {
-			log.Errorx("adding errors.txt to archive", err)
-			return err
-		}
-		if _, err := w.Write([]byte(errors)); err != nil {
-			log.Errorx("writing errors.txt to archive", err)
-			xerr := w.Close()
-			log.Check(xerr, "closing errors.txt after error")
-			return err
-		}
-		if err := w.Close(); err != nil {
-			return err
-		}
+	return exportErrors(log, archiver, errors)
+}
+
+// exportErrors adds file "errors.txt" with the errors to the archive, if there are
+// any errors.
+func exportErrors(log mlog.Log, archiver Archiver, errors string) error {
+	if errors == "" {
+		return nil
 	}
-	return nil
+	w, err := archiver.Create("errors.txt", int64(len(errors)), time.Now())
+	if err != nil {
+		log.Errorx("adding errors.txt to archive", err)
+		return err
+	}
+	if _, err := w.Write([]byte(errors)); err != nil {
+		log.Errorx("writing errors.txt to archive", err)
+		xerr := w.Close()
+		log.Check(xerr, "closing errors.txt after error")
+		return err
+	}
+	return w.Close()
 }
 
 func exportMessages(log mlog.Log, tx *bstore.Tx, accountDir string, messageIDs []int64, archiver Archiver, maildir bool, start time.Time) (string, error) {
@@ -239,7 +243,9 @@ func exportMessages(log mlog.Log, tx *bstore.Tx, accountDir string, messageIDs [
 	return mbe.errors, err
 }
 
-func exportMailbox(log mlog.Log, tx *bstore.Tx, accountDir string, mailboxID int64, mailboxName string, archiver Archiver, maildir bool, start time.Time) (string, error) {
+// exportMailbox exports messages in a mailbox. If since and/or before are
+// non-zero, only messages received in that period are exported.
+func exportMailbox(log mlog.Log, tx *bstore.Tx, accountDir string, mailboxID int64, mailboxName string, archiver Archiver, maildir bool, start, since, before time.Time) (string, error) {
 	mbe, err := newMailboxExport(log, mailboxName, accountDir, archiver, start, maildir)
 	if err != nil {
 		return "", err
@@ -250,6 +256,12 @@ func exportMailbox(log mlog.Log, tx *bstore.Tx, accountDir string, mailboxID int
 	q := bstore.QueryTx[Message](tx)
 	q.FilterNonzero(Message{MailboxID: mailboxID})
 	q.FilterEqual("Expunged", false)
+	if !since.IsZero() {
+		q.FilterGreaterEqual("Received", since)
+	}
+	if !before.IsZero() {
+		q.FilterLess("Received", before)
+	}
 	q.SortAsc("Received", "ID")
 	err = q.ForEach(func(m Message) error {
 		return mbe.ExportMessage(m)
@@ -356,16 +368,16 @@ func (e *mailboxExport) ExportMessage(m Message) error {
 			err := mf.Close()
 			e.log.Check(err, "closing message file after export")
 		}()
-		st, err := mf.Stat()
-		if err != nil {
-			e.errors += fmt.Sprintf("stat message file for id %d, path %s: %v (message skipped)\n", m.ID, mp, err)
+		fmr := FileMsgReader(m.MsgPrefix, mf)
+		if _, err := fmr.ReadAt(nil, 0); err != nil {
+			e.errors += fmt.Sprintf("reading message file for id %d, path %s: %v (message skipped)\n", m.ID, mp, err)
 			return nil
 		}
-		size := st.Size() + int64(len(m.MsgPrefix))
+		size := fmr.Size()
 		if size != m.Size {
-			e.errors += fmt.Sprintf("message size mismatch for message id %d, database has %d, size is %d+%d=%d, using calculated size\n", m.ID, m.Size, len(m.MsgPrefix), st.Size(), size)
+			e.errors += fmt.Sprintf("message size mismatch for message id %d, database has %d, size is %d+%d=%d, using calculated size\n", m.ID, m.Size, len(m.MsgPrefix), size-int64(len(m.MsgPrefix)), size)
 		}
-		mr = FileMsgReader(m.MsgPrefix, mf)
+		mr = fmr
 	}
 
 	if e.maildir {
@@ -4,13 +4,18 @@ import (
 	"archive/tar"
 	"archive/zip"
 	"bytes"
+	"errors"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 )
@@ -128,4 +133,62 @@ func TestExport(t *testing.T) {
 
 	checkDirFiles(filepath.FromSlash("../testdata/exportmaildir"), 2)
 	checkDirFiles(filepath.FromSlash("../testdata/exportmbox"), defaultMailboxes)
+
+	// Export to mailbox, only messages received in a period.
+	acc.WithWLock(func() {
+		m = Message{Received: time.Now().Add(-48 * time.Hour), Size: int64(len(msg))}
+		err = acc.DeliverMailbox(pkglog, "Inbox", &m, msgFile)
+		tcheck(t, err, "deliver")
+	})
+
+	exportFiles := func(opts ExportOpts) map[string]string {
+		t.Helper()
+		em, err := acc.ExportToMailbox(ctxbg, log, opts)
+		tcheck(t, err, "export to mailbox")
+		mb, err := bstore.QueryDB[Mailbox](ctxbg, acc.DB).FilterID(em.MailboxID).Get()
+		tcheck(t, err, "get mailbox")
+		tcompare(t, mb.Name, ExportsMailbox)
+
+		mr := acc.MessageReader(em)
+		defer mr.Close()
+		p, err := message.EnsurePart(log.Logger, false, mr, em.Size)
+		tcheck(t, err, "parse message")
+		if len(p.Parts) != 2 || p.Parts[1].MediaType != "APPLICATION" || p.Parts[1].MediaSubType != "ZIP" {
+			t.Fatalf("unexpected message structure %#v", p)
+		}
+		zipbuf, err := io.ReadAll(p.Parts[1].Reader())
+		tcheck(t, err, "read zip attachment")
+		r, err := zip.NewReader(bytes.NewReader(zipbuf), int64(len(zipbuf)))
+		tcheck(t, err, "reading zip")
+		files := map[string]string{}
+		for _, f := range r.File {
+			fr, err := f.Open()
+			tcheck(t, err, "open file in zip")
+			buf, err := io.ReadAll(fr)
+			tcheck(t, err, "read file in zip")
+			files[f.Name] = string(buf)
+		}
+		return files
+	}
+
+	files := exportFiles(ExportOpts{Mailboxes: []string{"Inbox"}, Since: time.Now().Add(-24 * time.Hour)})
+	tcompare(t, len(files), 1)
+	tcompare(t, strings.Count(files["Inbox.mbox"], "\nFrom "), 0) // Only the first message line.
+	if !strings.HasPrefix(files["Inbox.mbox"], "From ") {
+		t.Fatalf("missing message in export")
+	}
+	files = exportFiles(ExportOpts{Mailboxes: []string{"Inbox"}})
+	tcompare(t, strings.Count(files["Inbox.mbox"], "\nFrom "), 1)
+
+	// All mailboxes, except the exports mailbox.
+	files = exportFiles(ExportOpts{})
+	tcompare(t, len(files), defaultMailboxes)
+	if _, ok := files[ExportsMailbox+".mbox"]; ok {
+		t.Fatalf("exports mailbox included in export")
+	}
+
+	_, err = acc.ExportToMailbox(ctxbg, log, ExportOpts{Mailboxes: []string{"absent"}})
+	if !errors.Is(err, ErrUnknownMailbox) {
+		t.Fatalf("got err %v, expected ErrUnknownMailbox", err)
+	}
 }
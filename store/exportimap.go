@@ -0,0 +1,239 @@
+package store
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/moxvar"
+)
+
+// ExportsMailbox is the mailbox that ExportToMailbox delivers exports to. It is
+// created when needed.
+const ExportsMailbox = "Exports"
+
+// ExportOpts selects the messages for ExportToMailbox.
+type ExportOpts struct {
+	Mailboxes []string  // If empty, all mailboxes except ExportsMailbox are exported.
+	Recursive bool      // Whether to include child mailboxes of Mailboxes.
+	Since     time.Time // If non-zero, only messages received at or after Since.
+	Before    time.Time // If non-zero, only messages received before Before.
+	Maildir   bool      // Export in maildir format instead of mbox.
+}
+
+// ExportToMailbox exports messages as zip file, and delivers it as attachment of
+// a message to ExportsMailbox, where it can be retrieved over IMAP, e.g. by users
+// who cannot use the web interface for exports.
+//
+// As with ExportMessages, errors reading message files result in an "errors.txt"
+// file in the zip file instead of failing the export. The export is subject to the
+// account quota and returns ErrOverQuota if the account would be over quota.
+//
+// The message that was delivered is returned.
+func (a *Account) ExportToMailbox(ctx context.Context, log mlog.Log, opts ExportOpts) (m Message, rerr error) {
+	if !opts.Since.IsZero() && !opts.Before.IsZero() && !opts.Since.Before(opts.Before) {
+		return Message{}, fmt.Errorf("since must be before before")
+	}
+
+	zf, err := CreateMessageTemp(log, "export")
+	if err != nil {
+		return Message{}, fmt.Errorf("creating temporary zip file: %v", err)
+	}
+	defer CloseRemoveTempFile(log, zf, "zip file for export")
+
+	archiver := ZipArchiver{Writer: zip.NewWriter(zf)}
+	nmailboxes, err := a.exportMailboxes(ctx, log, archiver, opts)
+	if xerr := archiver.Close(); err == nil && xerr != nil {
+		err = fmt.Errorf("closing zip file: %v", xerr)
+	}
+	if err != nil {
+		return Message{}, err
+	}
+
+	now := time.Now()
+	var name string
+	if len(opts.Mailboxes) == 1 {
+		name = "-" + strings.ReplaceAll(opts.Mailboxes[0], "/", "-")
+	} else if len(opts.Mailboxes) == 0 {
+		name = "-all"
+	} else {
+		name = "-selection"
+	}
+	format := "mbox"
+	if opts.Maildir {
+		format = "maildir"
+	}
+	filename := fmt.Sprintf("mailexport%s-%s.%s.zip", name, now.Format("20060102-150405"), format)
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Hi!\n\nAttached is the export of your messages that was requested, with %d mailbox(es) in %s format.\n", nmailboxes, format)
+	if len(opts.Mailboxes) > 0 {
+		fmt.Fprintf(&text, "\nMailboxes: %s", strings.Join(opts.Mailboxes, ", "))
+		if opts.Recursive {
+			text.WriteString(" (including child mailboxes)")
+		}
+		text.WriteString("\n")
+	}
+	const day = "2 January 2006 15:04 MST"
+	if !opts.Since.IsZero() {
+		fmt.Fprintf(&text, "Received since: %s\n", opts.Since.Format(day))
+	}
+	if !opts.Before.IsZero() {
+		fmt.Fprintf(&text, "Received before: %s\n", opts.Before.Format(day))
+	}
+	text.WriteString("\nCheers,\nmox\n")
+
+	mf, err := CreateMessageTemp(log, "exportmsg")
+	if err != nil {
+		return Message{}, fmt.Errorf("creating temporary message file: %v", err)
+	}
+	defer CloseRemoveTempFile(log, mf, "message for export delivery")
+
+	if err := composeExport(mf, now, text.String(), filename, zf); err != nil {
+		return Message{}, fmt.Errorf("composing message: %w", err)
+	}
+	size, err := mf.Seek(0, io.SeekEnd)
+	if err != nil {
+		return Message{}, fmt.Errorf("size of message file: %v", err)
+	}
+
+	m = Message{
+		Received: now,
+		Size:     size,
+	}
+	a.WithWLock(func() {
+		// Ensure the mailbox exists, also for accounts with NoAutoCreateMailboxes.
+		var changes []Change
+		rerr = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+			var modseq ModSeq
+			var err error
+			_, changes, err = a.MailboxEnsure(tx, ExportsMailbox, true, SpecialUse{}, &modseq)
+			return err
+		})
+		if rerr != nil {
+			rerr = fmt.Errorf("ensuring mailbox: %w", rerr)
+			return
+		}
+		BroadcastChanges(a, changes)
+
+		rerr = a.DeliverMailbox(log, ExportsMailbox, &m, mf)
+	})
+	if rerr != nil {
+		return Message{}, fmt.Errorf("delivering export message: %w", rerr)
+	}
+	return m, nil
+}
+
+// exportMailboxes writes the messages selected by opts to archiver, returning the
+// number of exported mailboxes.
+func (a *Account) exportMailboxes(ctx context.Context, log mlog.Log, archiver Archiver, opts ExportOpts) (int, error) {
+	tx, err := a.DB.Begin(ctx, false)
+	if err != nil {
+		return 0, fmt.Errorf("transaction: %v", err)
+	}
+	defer func() {
+		err := tx.Rollback()
+		log.Check(err, "transaction rollback")
+	}()
+
+	for _, name := range opts.Mailboxes {
+		if mb, err := a.MailboxFind(tx, name); err != nil {
+			return 0, fmt.Errorf("looking up mailbox %q: %v", name, err)
+		} else if mb == nil {
+			return 0, fmt.Errorf("%w: %q", ErrUnknownMailbox, name)
+		}
+	}
+
+	start := time.Now()
+	var errmsgs string
+	var n int
+	q := bstore.QueryTx[Mailbox](tx)
+	q.FilterEqual("Expunged", false)
+	q.FilterFn(func(mb Mailbox) bool {
+		if len(opts.Mailboxes) == 0 {
+			return mb.Name != ExportsMailbox
+		}
+		return slices.ContainsFunc(opts.Mailboxes, func(name string) bool {
+			return mb.Name == name || opts.Recursive && strings.HasPrefix(mb.Name, name+"/")
+		})
+	})
+	q.SortAsc("Name")
+	err = q.ForEach(func(mb Mailbox) error {
+		s, err := exportMailbox(log, tx, a.Dir, mb.ID, mb.Name, archiver, opts.Maildir, start, opts.Since, opts.Before)
+		errmsgs += s
+		n++
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("exporting mailboxes: %w", err)
+	}
+	return n, exportErrors(log, archiver, errmsgs)
+}
+
+// composeExport writes a message to w with text and zip file zf as attachment.
+func composeExport(w io.Writer, now time.Time, text, filename string, zf io.ReaderAt) (rerr error) {
+	xc := message.NewComposer(w, 0, false)
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(error); ok && errors.Is(err, message.ErrCompose) {
+			rerr = err
+			return
+		}
+		panic(x)
+	}()
+
+	xc.Header("From", fmt.Sprintf("<postmaster@%s>", mox.Conf.Static.HostnameDomain.ASCII))
+	xc.Subject("Export of messages")
+	xc.Header("Message-Id", fmt.Sprintf("<%s>", mox.MessageIDGen(false)))
+	xc.Header("Date", now.Format(message.RFC5322Z))
+	xc.Header("User-Agent", "mox/"+moxvar.Version)
+	xc.Header("MIME-Version", "1.0")
+
+	mp := multipart.NewWriter(xc)
+	xc.Header("Content-Type", fmt.Sprintf(`multipart/mixed; boundary="%s"`, mp.Boundary()))
+	xc.Line()
+
+	textBody, ct, cte := xc.TextPart("plain", text)
+	textHdr := textproto.MIMEHeader{}
+	textHdr.Set("Content-Type", ct)
+	textHdr.Set("Content-Transfer-Encoding", cte)
+	textp, err := mp.CreatePart(textHdr)
+	xc.Checkf(err, "adding text part to message")
+	_, err = textp.Write(textBody)
+	xc.Checkf(err, "writing text part")
+
+	ahdr := textproto.MIMEHeader{}
+	ahdr.Set("Content-Type", "application/zip")
+	ahdr.Set("Content-Transfer-Encoding", "base64")
+	ahdr.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	ap, err := mp.CreatePart(ahdr)
+	xc.Checkf(err, "adding zip file to message")
+	wc := moxio.Base64Writer(ap)
+	_, err = io.Copy(wc, &moxio.AtReader{R: zf})
+	xc.Checkf(err, "adding attachment")
+	err = wc.Close()
+	xc.Checkf(err, "flushing attachment")
+
+	err = mp.Close()
+	xc.Checkf(err, "closing multipart")
+
+	xc.Flush()
+	return nil
+}
@@ -0,0 +1,322 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mjl-/bstore"
+)
+
+// Journals hold the changes to an account database since a modseq, for
+// incremental backups. Changes to messages, mailboxes and annotations are found
+// through their ModSeq, so a flag change on a single message results in a small
+// journal, instead of a full copy of a large database. Message and mailbox records
+// are never removed, only marked expunged, so their changes are complete. Other
+// types are small and stored in full.
+//
+// A journal consists of JSON lines, the first a JournalHeader, then JournalRecords.
+
+// JournalVersion is the version of the journal format.
+const JournalVersion = 1
+
+// JournalHeader is the first line in a journal.
+type JournalHeader struct {
+	Version int
+	Account string
+	Since   ModSeq // Journal has changes after this modseq.
+	ModSeq  ModSeq // Last modseq in journal, for use as Since in the next journal.
+}
+
+// JournalRecord is a line in a journal after the header, a database record.
+type JournalRecord struct {
+	Type   string
+	Record json.RawMessage
+}
+
+type journalType struct {
+	name string
+
+	// Whether all records are stored in journals. If so, existing records are
+	// removed before applying a journal.
+	full bool
+
+	export func(tx *bstore.Tx, since ModSeq, fn func(v any) error) error
+	apply  func(tx *bstore.Tx, buf []byte) error
+	clear  func(tx *bstore.Tx) error // For types stored in full.
+}
+
+// journalFull returns a type whose records are always stored in full.
+func journalFull[T any](name string) journalType {
+	return journalType{
+		name: name,
+		full: true,
+		export: func(tx *bstore.Tx, since ModSeq, fn func(v any) error) error {
+			return bstore.QueryTx[T](tx).ForEach(func(v T) error {
+				return fn(v)
+			})
+		},
+		apply: func(tx *bstore.Tx, buf []byte) error {
+			var v T
+			if err := json.Unmarshal(buf, &v); err != nil {
+				return err
+			}
+			return tx.Insert(&v)
+		},
+		clear: func(tx *bstore.Tx) error {
+			_, err := bstore.QueryTx[T](tx).Delete()
+			return err
+		},
+	}
+}
+
+// journalModSeq returns a type whose records have a ModSeq field, and are never
+// removed from the database. Only records changed since the modseq are stored.
+func journalModSeq[T any](name string) journalType {
+	return journalType{
+		name: name,
+		export: func(tx *bstore.Tx, since ModSeq, fn func(v any) error) error {
+			// In order of ID. A moved message keeps its ID, and an expunged message is
+			// inserted with a higher ID for the old mailbox and UID. Applying in this order
+			// prevents unique index conflicts.
+			q := bstore.QueryTx[T](tx)
+			q.FilterGreater("ModSeq", since)
+			q.SortAsc("ID")
+			return q.ForEach(func(v T) error {
+				return fn(v)
+			})
+		},
+		apply: func(tx *bstore.Tx, buf []byte) error {
+			var v T
+			if err := json.Unmarshal(buf, &v); err != nil {
+				return err
+			}
+			err := tx.Update(&v)
+			if err == bstore.ErrAbsent {
+				err = tx.Insert(&v)
+			}
+			return err
+		},
+	}
+}
+
+// Types in the journal, in the order they are written and must be applied, for
+// references between records. Mailbox parents can be created after their
+// children, so mailboxes are applied without parent, and parents are set at the
+// end.
+var journalTypes = []journalType{
+	journalFull[NextUIDValidity]("NextUIDValidity"),
+	journalModSeq[Mailbox]("Mailbox"),
+	journalModSeq[Message]("Message"),
+	{
+		name: "Recipient",
+		export: func(tx *bstore.Tx, since ModSeq, fn func(v any) error) error {
+			// Recipients are added along with a message, and removed when the message is
+			// expunged.
+			q := bstore.QueryTx[Message](tx)
+			q.FilterGreater("CreateSeq", since)
+			q.FilterEqual("Expunged", false)
+			return q.ForEach(func(m Message) error {
+				qr := bstore.QueryTx[Recipient](tx)
+				qr.FilterNonzero(Recipient{MessageID: m.ID})
+				return qr.ForEach(func(mr Recipient) error {
+					return fn(mr)
+				})
+			})
+		},
+		apply: func(tx *bstore.Tx, buf []byte) error {
+			var mr Recipient
+			if err := json.Unmarshal(buf, &mr); err != nil {
+				return err
+			}
+			if err := tx.Get(&Recipient{ID: mr.ID}); err == nil {
+				return nil
+			}
+			return tx.Insert(&mr)
+		},
+	},
+	journalModSeq[Annotation]("Annotation"),
+	journalFull[Subscription]("Subscription"),
+	journalFull[Outgoing]("Outgoing"),
+	journalFull[Password]("Password"),
+	journalFull[Subjectpass]("Subjectpass"),
+	journalFull[SyncState]("SyncState"),
+	journalFull[Upgrade]("Upgrade"),
+	journalFull[RecipientDomainTLS]("RecipientDomainTLS"),
+	journalFull[DiskUsage]("DiskUsage"),
+	journalFull[Settings]("Settings"),
+	journalFull[FromAddressSettings]("FromAddressSettings"),
+	journalFull[RulesetNoListID]("RulesetNoListID"),
+	journalFull[RulesetNoMsgFrom]("RulesetNoMsgFrom"),
+	journalFull[RulesetNoMailbox]("RulesetNoMailbox"),
+	journalFull[MessageErase]("MessageErase"),
+	journalFull[SieveScript]("SieveScript"),
+	journalFull[TOTP]("TOTP"),
+	journalFull[TOTPRecoveryCode]("TOTPRecoveryCode"),
+	journalFull[URLAuthKey]("URLAuthKey"),
+	journalFull[DigestState]("DigestState"),
+	journalFull[SenderAllow]("SenderAllow"),
+	// LoginSession is not stored, its tokens are not in the JSON representation.
+	// Existing sessions are removed when applying a journal.
+}
+
+// ErrJournalMismatch is returned when applying a journal to a database with
+// another last modseq than the journal starts at.
+var ErrJournalMismatch = errors.New("journal does not continue from database modseq")
+
+// LastModSeq returns the last assigned modseq, zero if none was assigned yet.
+func LastModSeq(tx *bstore.Tx) (ModSeq, error) {
+	v := SyncState{ID: 1}
+	err := tx.Get(&v)
+	if err == bstore.ErrAbsent {
+		return 0, nil
+	}
+	return v.LastModSeq, err
+}
+
+// ExportJournal writes a journal with changes after modseq since from the
+// database of accountName to w. The returned header has the modseq to use for the
+// next journal.
+func ExportJournal(tx *bstore.Tx, w io.Writer, accountName string, since ModSeq) (JournalHeader, error) {
+	modseq, err := LastModSeq(tx)
+	if err != nil {
+		return JournalHeader{}, fmt.Errorf("get last modseq: %v", err)
+	}
+	if since > modseq {
+		return JournalHeader{}, fmt.Errorf("journal since modseq %d after last modseq %d, account database replaced?", since, modseq)
+	}
+	h := JournalHeader{JournalVersion, accountName, since, modseq}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if err := enc.Encode(h); err != nil {
+		return JournalHeader{}, fmt.Errorf("writing journal header: %v", err)
+	}
+	for _, jt := range journalTypes {
+		err := jt.export(tx, since, func(v any) error {
+			buf, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			return enc.Encode(JournalRecord{jt.name, buf})
+		})
+		if err != nil {
+			return JournalHeader{}, fmt.Errorf("writing %s records to journal: %v", jt.name, err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return JournalHeader{}, fmt.Errorf("flush journal: %v", err)
+	}
+	return h, nil
+}
+
+// ApplyJournal applies the journal from r to the account database db, which must
+// not be in use, e.g. a database in a backup. The database must be at the modseq
+// the journal starts at, otherwise ErrJournalMismatch is returned.
+func ApplyJournal(ctx context.Context, db *bstore.DB, r io.Reader) (JournalHeader, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var h JournalHeader
+	if err := dec.Decode(&h); err != nil {
+		return JournalHeader{}, fmt.Errorf("reading journal header: %v", err)
+	}
+	if h.Version != JournalVersion {
+		return JournalHeader{}, fmt.Errorf("unknown journal version %d, expected %d", h.Version, JournalVersion)
+	}
+
+	types := map[string]journalType{}
+	for _, jt := range journalTypes {
+		types[jt.name] = jt
+	}
+
+	err := db.Write(ctx, func(tx *bstore.Tx) error {
+		if modseq, err := LastModSeq(tx); err != nil {
+			return fmt.Errorf("get last modseq: %v", err)
+		} else if modseq != h.Since {
+			return fmt.Errorf("%w: database at modseq %d, journal since %d", ErrJournalMismatch, modseq, h.Since)
+		}
+
+		// Remove records of types stored in full, and login sessions.
+		for _, jt := range journalTypes {
+			if jt.full {
+				if err := jt.clear(tx); err != nil {
+					return fmt.Errorf("removing %s records: %v", jt.name, err)
+				}
+			}
+		}
+		if _, err := bstore.QueryTx[LoginSession](tx).Delete(); err != nil {
+			return fmt.Errorf("removing login sessions: %v", err)
+		}
+
+		// Mailbox parents are set after all mailboxes have been added.
+		parents := map[int64]int64{}
+		for {
+			var jr JournalRecord
+			if err := dec.Decode(&jr); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("reading journal record: %v", err)
+			}
+
+			switch jr.Type {
+			case "Mailbox":
+				var mb Mailbox
+				if err := json.Unmarshal(jr.Record, &mb); err != nil {
+					return fmt.Errorf("parsing mailbox: %v", err)
+				}
+				parents[mb.ID] = mb.ParentID
+				mb.ParentID = 0
+				buf, err := json.Marshal(mb)
+				if err != nil {
+					return fmt.Errorf("marshal mailbox: %v", err)
+				}
+				jr.Record = buf
+			case "Message":
+				// Recipients of expunged messages are removed.
+				var m Message
+				if err := json.Unmarshal(jr.Record, &m); err != nil {
+					return fmt.Errorf("parsing message: %v", err)
+				}
+				if m.Expunged {
+					qr := bstore.QueryTx[Recipient](tx)
+					qr.FilterNonzero(Recipient{MessageID: m.ID})
+					if _, err := qr.Delete(); err != nil {
+						return fmt.Errorf("removing recipients for expunged message: %v", err)
+					}
+				}
+			}
+
+			jt, ok := types[jr.Type]
+			if !ok {
+				return fmt.Errorf("unknown record type %q in journal", jr.Type)
+			}
+			if err := jt.apply(tx, jr.Record); err != nil {
+				return fmt.Errorf("applying %s record: %v", jr.Type, err)
+			}
+		}
+
+		for id, parentID := range parents {
+			if parentID == 0 {
+				continue
+			}
+			mb := Mailbox{ID: id}
+			if err := tx.Get(&mb); err != nil {
+				return fmt.Errorf("get mailbox: %v", err)
+			}
+			mb.ParentID = parentID
+			if err := tx.Update(&mb); err != nil {
+				return fmt.Errorf("setting mailbox parent: %v", err)
+			}
+		}
+
+		if modseq, err := LastModSeq(tx); err != nil {
+			return fmt.Errorf("get last modseq: %v", err)
+		} else if modseq != h.ModSeq {
+			return fmt.Errorf("database at modseq %d after applying journal, expected %d", modseq, h.ModSeq)
+		}
+		return nil
+	})
+	return h, err
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestJournal(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	err := Init(ctxbg)
+	tcheck(t, err, "init")
+	defer func() {
+		err := Close()
+		tcheck(t, err, "close")
+	}()
+	defer Switchboard()()
+	acc, err := OpenAccount(log, "mjl", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.WaitClosed()
+	}()
+
+	deliver := func() {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "journal-test")
+		tcheck(t, err, "create temp message file")
+		defer CloseRemoveTempFile(log, msgFile, "temp message file")
+		msgPrefix := []byte("From: <remote@example.org>\r\nTo: <mjl@mox.example>\r\nSubject: test\r\n\r\ntest\r\n")
+		_, err = msgFile.Write(msgPrefix)
+		tcheck(t, err, "write message")
+		m := Message{Received: time.Now(), Size: int64(len(msgPrefix))}
+		acc.WithWLock(func() {
+			conf, _ := acc.Conf()
+			err := acc.DeliverDestination(log, conf.Destinations["mjl"], &m, msgFile)
+			tcheck(t, err, "deliver")
+		})
+	}
+	deliver()
+	deliver()
+
+	// Make a copy of the database, as base for the journal.
+	basePath := filepath.FromSlash("../testdata/store/data/tmp/journalbase.db")
+	os.MkdirAll(filepath.Dir(basePath), 0770)
+	var baseModSeq ModSeq
+	err = acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+		f, err := os.Create(basePath)
+		tcheck(t, err, "create base database")
+		defer f.Close()
+		_, err = tx.WriteTo(f)
+		tcheck(t, err, "write base database")
+		baseModSeq, err = LastModSeq(tx)
+		return err
+	})
+	tcheck(t, err, "copy database")
+
+	// Change a flag, create a mailbox, and deliver another message.
+	acc.WithWLock(func() {
+		err := acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+			m, err := bstore.QueryTx[Message](tx).Limit(1).Get()
+			tcheck(t, err, "get message")
+			mb := Mailbox{ID: m.MailboxID}
+			err = tx.Get(&mb)
+			tcheck(t, err, "get mailbox")
+			modseq, err := acc.NextModSeq(tx)
+			tcheck(t, err, "next modseq")
+			mb.Sub(m.MailboxCounts())
+			m.Seen = true
+			m.ModSeq = modseq
+			mb.Add(m.MailboxCounts())
+			mb.ModSeq = modseq
+			err = tx.Update(&m)
+			tcheck(t, err, "update message")
+			err = tx.Update(&mb)
+			tcheck(t, err, "update mailbox")
+			_, _, _, _, err = acc.MailboxCreate(tx, "Archive/2024", SpecialUse{})
+			return err
+		})
+		tcheck(t, err, "write")
+	})
+	deliver()
+
+	var journal bytes.Buffer
+	var h JournalHeader
+	err = acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+		var err error
+		h, err = ExportJournal(tx, &journal, acc.Name, baseModSeq)
+		return err
+	})
+	tcheck(t, err, "export journal")
+	tcompare(t, h.Since, baseModSeq)
+	if h.ModSeq <= baseModSeq {
+		t.Fatalf("journal modseq %d not after base modseq %d", h.ModSeq, baseModSeq)
+	}
+
+	db, err := bstore.Open(ctxbg, basePath, &bstore.Options{MustExist: true}, DBTypes...)
+	tcheck(t, err, "open base database")
+	defer db.Close()
+	journalBuf := journal.Bytes()
+	_, err = ApplyJournal(ctxbg, db, bytes.NewReader(journalBuf))
+	tcheck(t, err, "apply journal")
+
+	// Database with journal applied must be the same as the account database. Times
+	// can have a different location, so we compare the JSON representation.
+	compare := func(name string, fn func(db *bstore.DB) (any, error)) {
+		t.Helper()
+		exp, err := fn(acc.DB)
+		tcheck(t, err, "list "+name)
+		got, err := fn(db)
+		tcheck(t, err, "list "+name+" after applying journal")
+		expBuf, err := json.Marshal(exp)
+		tcheck(t, err, "marshal")
+		gotBuf, err := json.Marshal(got)
+		tcheck(t, err, "marshal")
+		tcompare(t, string(gotBuf), string(expBuf))
+	}
+	compare("messages", func(db *bstore.DB) (any, error) { return bstore.QueryDB[Message](ctxbg, db).List() })
+	compare("mailboxes", func(db *bstore.DB) (any, error) { return bstore.QueryDB[Mailbox](ctxbg, db).List() })
+	compare("subscriptions", func(db *bstore.DB) (any, error) { return bstore.QueryDB[Subscription](ctxbg, db).List() })
+	compare("recipients", func(db *bstore.DB) (any, error) { return bstore.QueryDB[Recipient](ctxbg, db).List() })
+	compare("syncstate", func(db *bstore.DB) (any, error) { return bstore.QueryDB[SyncState](ctxbg, db).List() })
+
+	// Applying again fails, the database has moved past the start of the journal.
+	_, err = ApplyJournal(ctxbg, db, bytes.NewReader(journalBuf))
+	if !errors.Is(err, ErrJournalMismatch) {
+		t.Fatalf("got err %v, expected ErrJournalMismatch", err)
+	}
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// ErrListPendingUnknown is returned for an unknown or expired token of a pending
+// mailing list request.
+var ErrListPendingUnknown = errors.New("unknown or expired token")
+
+// ListPendingMaxAge is how long a pending subscription change or held message can
+// be confirmed or moderated.
+const ListPendingMaxAge = 7 * 24 * time.Hour
+
+// ListSubscriber is a subscriber of a mailing list configured in a domain, see
+// config.List.
+type ListSubscriber struct {
+	ID      int64
+	Created time.Time `bstore:"nonzero,default now"`
+
+	// List address, as packed smtp address.
+	List string `bstore:"nonzero,unique List+Address"`
+
+	// Subscriber address, lower-cased.
+	Address string `bstore:"nonzero"`
+}
+
+// ListPendingKind is the kind of request pending for a mailing list.
+type ListPendingKind string
+
+const (
+	ListPendingSubscribe   ListPendingKind = "subscribe"   // Confirmation from subscriber needed.
+	ListPendingUnsubscribe ListPendingKind = "unsubscribe" // Confirmation from subscriber needed.
+	ListPendingPost        ListPendingKind = "post"        // Message held for moderation by the list owner.
+)
+
+// ListPending is a request for a mailing list that must be confirmed by a
+// subscriber or the list owner, with a token sent only to them.
+type ListPending struct {
+	ID      int64
+	Created time.Time       `bstore:"nonzero,default now"`
+	List    string          `bstore:"nonzero,index"`
+	Kind    ListPendingKind `bstore:"nonzero"`
+	Token   string          `bstore:"nonzero,unique"`
+
+	// For subscribe/unsubscribe, the address of the subscriber. For posts, the message
+	// From address.
+	Address string
+
+	// For posts, the messages held in the queue, one for each subscriber.
+	QueueMsgIDs []int64
+}
+
+// ListSubscriberList returns the subscribers of a list.
+func ListSubscriberList(ctx context.Context, list string) ([]ListSubscriber, error) {
+	return bstore.QueryDB[ListSubscriber](ctx, AuthDB).FilterNonzero(ListSubscriber{List: list}).SortAsc("Address").List()
+}
+
+// ListSubscribed returns whether address is subscribed to the list.
+func ListSubscribed(ctx context.Context, list, address string) (bool, error) {
+	return bstore.QueryDB[ListSubscriber](ctx, AuthDB).FilterNonzero(ListSubscriber{List: list, Address: strings.ToLower(address)}).Exists()
+}
+
+// ListSubscribe adds address as subscriber of list. Returns false if the address
+// was already subscribed.
+func ListSubscribe(ctx context.Context, list, address string) (added bool, rerr error) {
+	rerr = AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		s := ListSubscriber{List: list, Address: strings.ToLower(address)}
+		if exists, err := bstore.QueryTx[ListSubscriber](tx).FilterNonzero(s).Exists(); err != nil {
+			return err
+		} else if exists {
+			return nil
+		}
+		added = true
+		return tx.Insert(&s)
+	})
+	return
+}
+
+// ListUnsubscribe removes address as subscriber of list. Returns false if the
+// address was not subscribed.
+func ListUnsubscribe(ctx context.Context, list, address string) (bool, error) {
+	n, err := bstore.QueryDB[ListSubscriber](ctx, AuthDB).FilterNonzero(ListSubscriber{List: list, Address: strings.ToLower(address)}).Delete()
+	return n > 0, err
+}
+
+// ListPendingAdd generates a token for the pending request and stores it. Caller
+// must set List, Kind, and Address or QueueMsgIDs.
+func ListPendingAdd(ctx context.Context, p *ListPending) error {
+	buf := make([]byte, 12)
+	cryptorand.Read(buf)
+	p.Token = base64.RawURLEncoding.EncodeToString(buf)
+	return AuthDB.Insert(ctx, p)
+}
+
+// ListPendingTake looks up and removes the pending request with token for list,
+// of one of the kinds. Requests older than ListPendingMaxAge are removed and cause
+// ErrListPendingUnknown to be returned.
+func ListPendingTake(ctx context.Context, list, token string, kinds ...ListPendingKind) (p ListPending, rerr error) {
+	if token == "" {
+		return ListPending{}, ErrListPendingUnknown
+	}
+	rerr = AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		// Remove expired requests for all lists.
+		_, err := bstore.QueryTx[ListPending](tx).FilterLess("Created", time.Now().Add(-ListPendingMaxAge)).Delete()
+		if err != nil {
+			return err
+		}
+
+		p, err = bstore.QueryTx[ListPending](tx).FilterNonzero(ListPending{List: list, Token: token}).Get()
+		if err == bstore.ErrAbsent || err == nil && !slices.Contains(kinds, p.Kind) {
+			return ErrListPendingUnknown
+		} else if err != nil {
+			return err
+		}
+		return tx.Delete(&p)
+	})
+	return
+}
@@ -44,7 +44,7 @@ type LoginAttempt struct {
 	LocalIP              string
 	TLS                  string // Empty if no TLS, otherwise contains version, algorithm, properties, etc.
 	TLSPubKeyFingerprint string
-	Protocol             string // "submission", "imap", "webmail", "webaccount", "webadmin"
+	Protocol             string // "submission", "imap", "pop3", "managesieve", "webmail", "webaccount", "webadmin"
 	UserAgent            string // From HTTP header, or IMAP ID command.
 	AuthMech             string // "plain", "login", "cram-md5", "scram-sha-256-plus", "(unrecognized)", etc
 	Result               AuthResult
@@ -95,6 +95,9 @@ const (
 	AuthBadChannelBinding AuthResult = "badchanbind"
 	AuthBadProtocol       AuthResult = "badprotocol"
 	AuthLoginDisabled     AuthResult = "logindisabled"
+	AuthTOTPRequired      AuthResult = "totprequired" // Valid password, but two-factor authentication code missing.
+	AuthBadTOTP           AuthResult = "badtotp"
+	AuthReferral          AuthResult = "referral" // Account moved, client referred to other host.
 	AuthError             AuthResult = "error"
 	AuthAborted           AuthResult = "aborted"
 )
@@ -169,6 +172,8 @@ func LoginAttemptAdd(ctx context.Context, log mlog.Log, a LoginAttempt) {
 }
 
 func loginAttemptWrite(l ...LoginAttempt) {
+	authEventsWrite(l)
+
 	// Log on the way out, for "count" fetched from database.
 	defer func() {
 		for _, a := range l {
@@ -211,6 +216,10 @@ func loginAttemptWrite(l ...LoginAttempt) {
 }
 
 func loginAttemptWriteTx(tx *bstore.Tx, a *LoginAttempt) error {
+	if err := authLockoutWriteTx(tx, *a); err != nil {
+		return err
+	}
+
 	xa := LoginAttempt{Key: a.Key}
 	var insert bool
 	if err := tx.Get(&xa); err == bstore.ErrAbsent {
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mox-"
+)
+
+// MailboxTree is a snapshot of the names of the mailboxes and subscriptions of an
+// account, with hierarchy information. It is kept in memory for quickly listing
+// mailboxes, e.g. for IMAP LIST and LSUB, without reading all mailboxes and
+// subscriptions from the database for each command. It is invalidated through the
+// changes broadcast for mailboxes and subscriptions.
+//
+// A MailboxTree must not be modified, it is shared between connections.
+type MailboxTree struct {
+	// Mailboxes and subscriptions (which may not have a mailbox), sorted, unique.
+	Names   []string
+	Entries map[string]MailboxTreeEntry
+}
+
+// MailboxTreeEntry is a mailbox or subscription in a MailboxTree.
+type MailboxTreeEntry struct {
+	MailboxID             int64 // Zero if there is only a subscription, no mailbox.
+	SpecialUse                  // Of mailbox.
+	Subscribed            bool
+	HasChildren           bool // Whether a mailbox exists with this entry as parent.
+	HasSubscribedChildren bool // Whether a subscription exists for a child.
+}
+
+// WithPrefix returns the sorted names starting with prefix, as a subslice of
+// Names. Found through binary search, so without looking at each name.
+func (t *MailboxTree) WithPrefix(prefix string) []string {
+	s := sort.SearchStrings(t.Names, prefix)
+	e := s + sort.Search(len(t.Names)-s, func(i int) bool {
+		return !strings.HasPrefix(t.Names[s+i], prefix)
+	})
+	return t.Names[s:e]
+}
+
+// MailboxTree returns the cached mailbox tree for the account, reading it with tx
+// if it isn't cached yet. The account read lock must be held, to prevent the
+// tree of a database snapshot from being cached after a concurrent change.
+func (a *Account) MailboxTree(tx *bstore.Tx) (*MailboxTree, error) {
+	a.mailboxTreeMutex.Lock()
+	t := a.mailboxTree
+	gen := a.mailboxTreeGen
+	a.mailboxTreeMutex.Unlock()
+	if t != nil {
+		return t, nil
+	}
+
+	t = &MailboxTree{Entries: map[string]MailboxTreeEntry{}}
+	q := bstore.QueryTx[Mailbox](tx)
+	q.FilterEqual("Expunged", false)
+	err := q.ForEach(func(mb Mailbox) error {
+		e := t.Entries[mb.Name]
+		e.MailboxID = mb.ID
+		e.SpecialUse = mb.SpecialUse
+		t.Entries[mb.Name] = e
+		for p := mox.ParentMailboxName(mb.Name); p != ""; p = mox.ParentMailboxName(p) {
+			pe := t.Entries[p]
+			pe.HasChildren = true
+			t.Entries[p] = pe
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing mailboxes: %v", err)
+	}
+	err = bstore.QueryTx[Subscription](tx).ForEach(func(sub Subscription) error {
+		e := t.Entries[sub.Name]
+		e.Subscribed = true
+		t.Entries[sub.Name] = e
+		for p := mox.ParentMailboxName(sub.Name); p != ""; p = mox.ParentMailboxName(p) {
+			pe := t.Entries[p]
+			pe.HasSubscribedChildren = true
+			t.Entries[p] = pe
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing subscriptions: %v", err)
+	}
+	// Parents of mailboxes and subscriptions that don't exist themselves are only
+	// tracked for their children.
+	for name, e := range t.Entries {
+		if e.MailboxID != 0 || e.Subscribed {
+			t.Names = append(t.Names, name)
+		}
+	}
+	sort.Strings(t.Names)
+
+	a.mailboxTreeMutex.Lock()
+	defer a.mailboxTreeMutex.Unlock()
+	if a.mailboxTreeGen == gen {
+		a.mailboxTree = t
+	}
+	return t, nil
+}
+
+// mailboxTreeInvalidate clears the cached mailbox tree if changes affect it, i.e.
+// when mailboxes or subscriptions are added, removed or renamed, or when
+// special-use flags change.
+func (a *Account) mailboxTreeInvalidate(changes []Change) {
+	for _, ch := range changes {
+		switch ch.(type) {
+		case ChangeAddMailbox, ChangeRemoveMailbox, ChangeRenameMailbox, ChangeAddSubscription, ChangeRemoveSubscription, ChangeMailboxSpecialUse:
+			a.mailboxTreeMutex.Lock()
+			a.mailboxTree = nil
+			a.mailboxTreeGen++
+			a.mailboxTreeMutex.Unlock()
+			return
+		}
+	}
+}
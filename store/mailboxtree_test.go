@@ -0,0 +1,152 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestMailboxTree(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	err := Init(ctxbg)
+	tcheck(t, err, "init")
+	defer func() {
+		err := Close()
+		tcheck(t, err, "close")
+	}()
+	defer Switchboard()()
+	acc, err := OpenAccount(log, "mjl", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.WaitClosed()
+	}()
+
+	tree := func() *MailboxTree {
+		t.Helper()
+		var mt *MailboxTree
+		acc.WithRLock(func() {
+			err := acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+				var err error
+				mt, err = acc.MailboxTree(tx)
+				return err
+			})
+			tcheck(t, err, "mailbox tree")
+		})
+		return mt
+	}
+
+	t0 := tree()
+	if _, ok := t0.Entries["Inbox"]; !ok {
+		t.Fatalf("missing inbox in mailbox tree")
+	}
+	if t1 := tree(); t1 != t0 {
+		t.Fatalf("mailbox tree not cached")
+	}
+
+	// Creating mailboxes and subscriptions invalidates the tree.
+	acc.WithWLock(func() {
+		var changes []Change
+		err := acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+			_, chl, _, _, err := acc.MailboxCreate(tx, "Archive/2024/a", SpecialUse{})
+			changes = append(changes, chl...)
+			tcheck(t, err, "create mailbox")
+			chl, err = acc.SubscriptionEnsure(tx, "Other/x")
+			changes = append(changes, chl...)
+			return err
+		})
+		tcheck(t, err, "write")
+		BroadcastChanges(acc, changes)
+	})
+	t1 := tree()
+	if t1 == t0 {
+		t.Fatalf("mailbox tree not invalidated")
+	}
+	tcompare(t, t1.WithPrefix("Archive/"), []string{"Archive/2024", "Archive/2024/a"})
+	tcompare(t, t1.WithPrefix("Archive/2024/b"), []string{})
+	tcompare(t, t1.WithPrefix("Other"), []string{"Other/x"})
+	tcompare(t, t1.Entries["Archive"].HasChildren, true)
+	tcompare(t, t1.Entries["Archive/2024/a"].HasChildren, false)
+	tcompare(t, t1.Entries["Archive/2024/a"].Subscribed, true)
+	tcompare(t, t1.Entries["Archive"].HasSubscribedChildren, true)
+	tcompare(t, t1.Entries["Other/x"].MailboxID, int64(0))
+	tcompare(t, t1.Entries["Other"].HasSubscribedChildren, true)
+	tcompare(t, t1.Entries["Other"].HasChildren, false)
+
+	// Changes not about mailboxes or subscriptions keep the tree.
+	BroadcastChanges(acc, []Change{ChangeFlags{}})
+	if tree() != t1 {
+		t.Fatalf("mailbox tree invalidated by unrelated change")
+	}
+}
+
+// BenchmarkMailboxTree compares listing a part of many mailboxes through the cached
+// tree with reading all mailboxes and subscriptions for each list, as is done when
+// the tree isn't cached.
+func BenchmarkMailboxTree(b *testing.B) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	err := Init(ctxbg)
+	if err != nil {
+		b.Fatalf("init: %v", err)
+	}
+	defer Close()
+	defer Switchboard()()
+	acc, err := OpenAccount(log, "mjl", false)
+	if err != nil {
+		b.Fatalf("open account: %v", err)
+	}
+	defer func() {
+		acc.Close()
+		acc.WaitClosed()
+	}()
+
+	// Mailboxes as created by automated filing.
+	err = acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		for y := 2005; y < 2025; y++ {
+			for i := 0; i < 100; i++ {
+				if _, _, _, _, err := acc.MailboxCreate(tx, fmt.Sprintf("Archive/%d/%d", y, i), SpecialUse{}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatalf("creating mailboxes: %v", err)
+	}
+
+	list := func(b *testing.B, invalidate bool) {
+		for i := 0; i < b.N; i++ {
+			if invalidate {
+				acc.mailboxTreeInvalidate([]Change{ChangeAddMailbox{}})
+			}
+			acc.WithRLock(func() {
+				err := acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+					t, err := acc.MailboxTree(tx)
+					if err == nil && len(t.WithPrefix("Archive/2024/")) != 100 {
+						err = fmt.Errorf("unexpected number of mailboxes")
+					}
+					return err
+				})
+				if err != nil {
+					b.Fatalf("list: %v", err)
+				}
+			})
+		}
+	}
+	b.Run("uncached", func(b *testing.B) { list(b, true) })
+	b.Run("cached", func(b *testing.B) { list(b, false) })
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// Limits for message share links.
+const (
+	MessageShareMax         = 100                 // Maximum number of share links per account.
+	MessageShareValidityMax = 30 * 24 * time.Hour // Maximum validity of a share link.
+
+	// Share links are kept for this period after they expire, so their access log
+	// can still be inspected. Then they are removed.
+	messageShareKeep = 30 * 24 * time.Hour
+)
+
+// ErrMessageShareUnknown is returned for an unknown, expired or revoked share
+// link.
+var ErrMessageShareUnknown = errors.New("unknown or expired share link")
+
+// MessageShare is an expiring link to a message of an account, for sharing a
+// message with people without forwarding it. Anyone with the (unguessable)
+// token can view the message until it expires or the link is revoked (removed).
+type MessageShare struct {
+	ID        int64
+	Created   time.Time `bstore:"default now"`
+	Account   string    `bstore:"nonzero,index"`
+	MessageID int64     `bstore:"nonzero"`
+	Subject   string    // Of message at time of creating the link, for display.
+
+	// Random token in the URL. Only returned when creating the link.
+	Token string `bstore:"nonzero,unique" json:"-"`
+
+	// If set, the link gives a download of the raw message (.eml), instead of a text
+	// rendering.
+	Raw bool
+
+	Expires    time.Time
+	Accesses   int       // Number of times the link was used.
+	LastAccess time.Time // Zero if never accessed.
+}
+
+// MessageShareAccess is a logged use of a share link.
+type MessageShareAccess struct {
+	ID        int64
+	ShareID   int64     `bstore:"nonzero,ref MessageShare"`
+	Time      time.Time `bstore:"default now"`
+	RemoteIP  string
+	UserAgent string
+}
+
+// MessageShareAdd adds a share link, generating its token. Expired links of the
+// account past their keep period are removed. Caller must check the message
+// exists and belongs to the account.
+func MessageShareAdd(ctx context.Context, ms *MessageShare) error {
+	if ms.Expires.Sub(time.Now()) > MessageShareValidityMax {
+		return fmt.Errorf("validity of share link too long, max %v", MessageShareValidityMax)
+	}
+
+	buf := make([]byte, 18)
+	cryptorand.Read(buf)
+	ms.Token = base64.RawURLEncoding.EncodeToString(buf)
+
+	return AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		if err := messageShareCleanup(tx, ms.Account); err != nil {
+			return err
+		}
+		n, err := bstore.QueryTx[MessageShare](tx).FilterNonzero(MessageShare{Account: ms.Account}).Count()
+		if err != nil {
+			return fmt.Errorf("counting share links: %v", err)
+		}
+		if n >= MessageShareMax {
+			return fmt.Errorf("too many share links, max %d, revoke some first", MessageShareMax)
+		}
+		return tx.Insert(ms)
+	})
+}
+
+// MessageShareList returns the share links of an account, along with their access
+// logs, most recent first.
+func MessageShareList(ctx context.Context, account string) (shares []MessageShare, accesses [][]MessageShareAccess, rerr error) {
+	rerr = AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		if err := messageShareCleanup(tx, account); err != nil {
+			return err
+		}
+		var err error
+		shares, err = bstore.QueryTx[MessageShare](tx).FilterNonzero(MessageShare{Account: account}).SortDesc("ID").List()
+		if err != nil {
+			return fmt.Errorf("listing share links: %v", err)
+		}
+		for _, ms := range shares {
+			l, err := bstore.QueryTx[MessageShareAccess](tx).FilterNonzero(MessageShareAccess{ShareID: ms.ID}).SortDesc("ID").List()
+			if err != nil {
+				return fmt.Errorf("listing accesses for share link: %v", err)
+			}
+			accesses = append(accesses, l)
+		}
+		return nil
+	})
+	return
+}
+
+// MessageShareRemove revokes a share link of an account.
+func MessageShareRemove(ctx context.Context, account string, id int64) error {
+	return AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		ms := MessageShare{ID: id}
+		if err := tx.Get(&ms); err == bstore.ErrAbsent || err == nil && ms.Account != account {
+			return ErrMessageShareUnknown
+		} else if err != nil {
+			return err
+		}
+		return messageShareRemove(tx, []int64{ms.ID})
+	})
+}
+
+// MessageShareUse looks up a valid share link by its token, logging the access.
+// ErrMessageShareUnknown is returned for unknown and expired links.
+func MessageShareUse(ctx context.Context, token, remoteIP, userAgent string) (ms MessageShare, rerr error) {
+	if len(userAgent) > 256 {
+		userAgent = userAgent[:256]
+	}
+	rerr = AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		var err error
+		ms, err = bstore.QueryTx[MessageShare](tx).FilterNonzero(MessageShare{Token: token}).Get()
+		if err == bstore.ErrAbsent {
+			return ErrMessageShareUnknown
+		} else if err != nil {
+			return err
+		}
+		now := time.Now()
+		if !now.Before(ms.Expires) {
+			return ErrMessageShareUnknown
+		}
+		ms.Accesses++
+		ms.LastAccess = now
+		if err := tx.Update(&ms); err != nil {
+			return fmt.Errorf("updating share link: %v", err)
+		}
+		msa := MessageShareAccess{ShareID: ms.ID, Time: now, RemoteIP: remoteIP, UserAgent: userAgent}
+		return tx.Insert(&msa)
+	})
+	return
+}
+
+// messageShareCleanup removes share links of the account that expired longer than
+// messageShareKeep ago.
+func messageShareCleanup(tx *bstore.Tx, account string) error {
+	q := bstore.QueryTx[MessageShare](tx)
+	q.FilterNonzero(MessageShare{Account: account})
+	q.FilterLess("Expires", time.Now().Add(-messageShareKeep))
+	var ids []int64
+	if err := q.IDs(&ids); err != nil {
+		return fmt.Errorf("listing expired share links: %v", err)
+	}
+	return messageShareRemove(tx, ids)
+}
+
+func messageShareRemove(tx *bstore.Tx, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	shareIDs := make([]any, len(ids))
+	for i, id := range ids {
+		shareIDs[i] = id
+	}
+	if _, err := bstore.QueryTx[MessageShareAccess](tx).FilterEqual("ShareID", shareIDs...).Delete(); err != nil {
+		return fmt.Errorf("removing share link accesses: %v", err)
+	}
+	if _, err := bstore.QueryTx[MessageShare](tx).FilterIDs(ids).Delete(); err != nil {
+		return fmt.Errorf("removing share links: %v", err)
+	}
+	return nil
+}
+
+// messageShareRemoveForAccount removes all share links for an account.
+func messageShareRemoveForAccount(tx *bstore.Tx, account string) error {
+	var ids []int64
+	if err := bstore.QueryTx[MessageShare](tx).FilterNonzero(MessageShare{Account: account}).IDs(&ids); err != nil {
+		return err
+	}
+	return messageShareRemove(tx, ids)
+}
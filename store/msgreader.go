@@ -17,6 +17,12 @@ type MsgReader struct {
 	offset int64    // Current reading offset.
 	f      *os.File // Opened path, automatically opened after prefix has been read.
 	err    error    // If set, error to return for reads. Sets io.EOF for readers, but ReadAt ignores them.
+
+	// If the on-disk file is compressed: its frames, and the most recently
+	// decompressed frame.
+	zindex *zstdIndex
+	zframe int // Index in zindex.frames of zdata, -1 if none.
+	zdata  []byte
 }
 
 var errMsgClosed = errors.New("msg is closed")
@@ -32,9 +38,52 @@ func FileMsgReader(prefix []byte, msgFile *os.File) *MsgReader {
 		return mr
 	}
 	mr.size = int64(len(prefix)) + fi.Size()
+	if err := mr.loadCompressed(fi.Size()); err != nil {
+		mr.err = err
+	} else if mr.zindex != nil {
+		mr.size = int64(len(prefix)) + mr.zindex.size
+	}
 	return mr
 }
 
+// loadCompressed reads the seek table of m.f, of fileSize bytes, into m.zindex if
+// the file is compressed.
+func (m *MsgReader) loadCompressed(fileSize int64) error {
+	compressed, err := msgFileCompressed(m.f)
+	if err != nil || !compressed {
+		return err
+	}
+	m.zindex, err = readZstdIndex(m.f, fileSize)
+	m.zframe = -1
+	return err
+}
+
+// readCompressed reads from the decompressed contents of the file at offset off,
+// decompressing frames as needed.
+func (m *MsgReader) readCompressed(buf []byte, off int64) (int, error) {
+	var n int
+	for n < len(buf) {
+		i := m.zindex.find(off)
+		if i >= len(m.zindex.frames) {
+			return n, io.EOF
+		}
+		f := m.zindex.frames[i]
+		if m.zframe != i {
+			m.zframe = -1
+			data, err := zstdDecodeFrame(m.f, f, m.zdata[:0])
+			if err != nil {
+				return n, err
+			}
+			m.zdata = data
+			m.zframe = i
+		}
+		nn := copy(buf[n:], m.zdata[off-f.doffset:])
+		n += nn
+		off += int64(nn)
+	}
+	return n, nil
+}
+
 // Read reads data from the msg, taking prefix and on-disk msg file into account.
 // The read offset is adjusted after the read.
 func (m *MsgReader) Read(buf []byte) (int, error) {
@@ -79,8 +128,23 @@ func (m *MsgReader) read(buf []byte, off int64, pread bool) (int, error) {
 				break
 			}
 			m.f = f
+			fi, err := f.Stat()
+			if err != nil {
+				m.err = err
+				break
+			}
+			if err := m.loadCompressed(fi.Size()); err != nil {
+				m.err = err
+				break
+			}
+		}
+		var n int
+		var err error
+		if m.zindex != nil {
+			n, err = m.readCompressed(buf[o:], off-int64(len(m.prefix)))
+		} else {
+			n, err = m.f.ReadAt(buf[o:], off-int64(len(m.prefix)))
 		}
-		n, err := m.f.ReadAt(buf[o:], off-int64(len(m.prefix)))
 		if !pread && n > 0 {
 			m.offset += int64(n)
 		}
@@ -116,6 +180,8 @@ func (m *MsgReader) Close() error {
 		}
 		m.f = nil
 	}
+	m.zindex = nil
+	m.zdata = nil
 	if m.err == errMsgClosed {
 		return m.err
 	}
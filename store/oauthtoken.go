@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// ErrOAuthTokenUnknown is returned for an unknown or expired OAuth token.
+var ErrOAuthTokenUnknown = errors.New("unknown or expired oauth token")
+
+// oauthTokenPrefix is the prefix of tokens issued by mox, to distinguish them from
+// tokens from an external provider.
+const oauthTokenPrefix = "moxoauth_"
+
+// OAuthToken is an OAuth 2.0 bearer token issued to an account, for authenticating
+// with the OAUTHBEARER and XOAUTH2 SASL mechanisms in IMAP and SMTP submission,
+// instead of a password. Only a hash of the token is stored.
+type OAuthToken struct {
+	ID      int64
+	Created time.Time `bstore:"nonzero,default now"`
+	Account string    `bstore:"nonzero,index"`
+
+	// Descriptive name to identify the token, e.g. the device or application where
+	// the token is used.
+	Name string `bstore:"nonzero"`
+
+	// Email address the token authenticates as, must belong to account.
+	LoginAddress string `bstore:"nonzero"`
+
+	// Raw-url-base64-encoded SHA-256 of the token.
+	TokenHash string `bstore:"nonzero,unique" json:"-"`
+
+	Expires  time.Time // Zero for no expiration.
+	LastUsed time.Time // Zero if never used.
+}
+
+func oauthTokenHash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// IsOAuthToken returns whether token looks like it was issued by mox.
+func IsOAuthToken(token string) bool {
+	return strings.HasPrefix(token, oauthTokenPrefix)
+}
+
+// OAuthTokenAdd generates a new token and adds it. The token is returned, it is
+// not stored and cannot be retrieved later. Caller must set Account, Name,
+// LoginAddress and optionally Expires, and is responsible for checking the account
+// and login address are valid.
+func OAuthTokenAdd(ctx context.Context, t *OAuthToken) (token string, rerr error) {
+	if err := checkTLSPublicKeyAddress(t.LoginAddress); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 24)
+	cryptorand.Read(buf)
+	token = oauthTokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	t.TokenHash = oauthTokenHash(token)
+	if err := AuthDB.Insert(ctx, t); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// OAuthTokenList returns the OAuth tokens of an account.
+func OAuthTokenList(ctx context.Context, account string) ([]OAuthToken, error) {
+	return bstore.QueryDB[OAuthToken](ctx, AuthDB).FilterNonzero(OAuthToken{Account: account}).SortAsc("Created").List()
+}
+
+// OAuthTokenRemove removes an OAuth token of an account.
+func OAuthTokenRemove(ctx context.Context, account string, id int64) error {
+	n, err := bstore.QueryDB[OAuthToken](ctx, AuthDB).FilterNonzero(OAuthToken{ID: id, Account: account}).Delete()
+	if err == nil && n == 0 {
+		return bstore.ErrAbsent
+	}
+	return err
+}
+
+// OAuthTokenVerify looks up a token, checks it hasn't expired and registers its
+// use. ErrOAuthTokenUnknown is returned for unknown and expired tokens.
+func OAuthTokenVerify(ctx context.Context, token string) (t OAuthToken, rerr error) {
+	rerr = AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		var err error
+		t, err = bstore.QueryTx[OAuthToken](tx).FilterNonzero(OAuthToken{TokenHash: oauthTokenHash(token)}).Get()
+		if err == bstore.ErrAbsent {
+			return ErrOAuthTokenUnknown
+		} else if err != nil {
+			return err
+		}
+		now := time.Now()
+		if !t.Expires.IsZero() && !now.Before(t.Expires) {
+			return ErrOAuthTokenUnknown
+		}
+		// Only update once per hour, to prevent a write for each login.
+		if now.Sub(t.LastUsed) > time.Hour {
+			t.LastUsed = now
+			if err := tx.Update(&t); err != nil {
+				return fmt.Errorf("updating oauth token: %v", err)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// oauthTokenRemoveForAccount removes all OAuth tokens for an account.
+func oauthTokenRemoveForAccount(tx *bstore.Tx, account string) error {
+	_, err := bstore.QueryTx[OAuthToken](tx).FilterNonzero(OAuthToken{Account: account}).Delete()
+	return err
+}
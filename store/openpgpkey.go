@@ -0,0 +1,190 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/openpgp"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/wkd"
+)
+
+// OpenPGPKey is an OpenPGP public key for an address of an account, published
+// through the Web Key Directory (WKD) and as OPENPGPKEY DNS record, so
+// correspondents can find the key.
+type OpenPGPKey struct {
+	ID      int64
+	Created time.Time `bstore:"nonzero,default now"`
+	Account string    `bstore:"nonzero,index"`
+
+	// Canonical email address, with unicode domain. A single key per address.
+	Address string `bstore:"nonzero,unique"`
+
+	// ASCII domain of address, and hash of localpart as used by WKD, for looking up
+	// keys for WKD requests.
+	Domain  string `bstore:"nonzero,index Domain+WKDHash"`
+	WKDHash string `bstore:"nonzero"`
+
+	Fingerprint string   // Upper-case hexadecimal.
+	UserIDs     []string // From the key.
+	KeyData     []byte   `bstore:"nonzero" json:"-"` // Binary OpenPGP key.
+}
+
+// ParseOpenPGPKey parses an ASCII-armored or binary OpenPGP public key for an
+// address, preparing an OpenPGPKey for insertion. The key must have a user ID for
+// the address. Caller must set Account, and check the address belongs to the
+// account.
+func ParseOpenPGPKey(address smtp.Address, keyData []byte) (OpenPGPKey, error) {
+	k, err := openpgp.ParseKey(keyData)
+	if err != nil {
+		return OpenPGPKey{}, err
+	}
+	if !k.HasAddress(address) {
+		return OpenPGPKey{}, fmt.Errorf("key does not have a user id for address %s", address)
+	}
+	pk := OpenPGPKey{
+		Address:     address.String(),
+		Domain:      address.Domain.ASCII,
+		WKDHash:     wkd.Hash(address.Localpart),
+		Fingerprint: k.Fingerprint,
+		UserIDs:     k.UserIDs,
+		KeyData:     k.Data,
+	}
+	return pk, nil
+}
+
+// OpenPGPKeyList returns the OpenPGP keys of an account.
+func OpenPGPKeyList(ctx context.Context, account string) ([]OpenPGPKey, error) {
+	return bstore.QueryDB[OpenPGPKey](ctx, AuthDB).FilterNonzero(OpenPGPKey{Account: account}).SortAsc("Address").List()
+}
+
+// OpenPGPKeyListDomain returns the OpenPGP keys for addresses of an ASCII domain.
+func OpenPGPKeyListDomain(ctx context.Context, domain string) ([]OpenPGPKey, error) {
+	return bstore.QueryDB[OpenPGPKey](ctx, AuthDB).FilterNonzero(OpenPGPKey{Domain: domain}).SortAsc("Address").List()
+}
+
+// OpenPGPKeysWKD returns the OpenPGP keys for an ASCII domain and WKD hash. The
+// hash is of the lower-cased localpart, so addresses with localparts that only
+// differ in case, each with their own key, have the same hash.
+func OpenPGPKeysWKD(ctx context.Context, domain, hash string) ([]OpenPGPKey, error) {
+	return bstore.QueryDB[OpenPGPKey](ctx, AuthDB).FilterNonzero(OpenPGPKey{Domain: domain, WKDHash: hash}).SortAsc("Address").List()
+}
+
+// OpenPGPKeySet adds the key, replacing an existing key for the address of the
+// same account.
+func OpenPGPKeySet(ctx context.Context, pk *OpenPGPKey) error {
+	return AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		old, err := bstore.QueryTx[OpenPGPKey](tx).FilterNonzero(OpenPGPKey{Address: pk.Address}).Get()
+		if err == nil && old.Account != pk.Account {
+			return fmt.Errorf("address has key of other account")
+		} else if err == nil {
+			if err := tx.Delete(&old); err != nil {
+				return fmt.Errorf("removing previous key: %v", err)
+			}
+		} else if err != bstore.ErrAbsent {
+			return fmt.Errorf("looking up existing key: %v", err)
+		}
+		return tx.Insert(pk)
+	})
+}
+
+// OpenPGPKeyRemove removes the key for an address of an account.
+func OpenPGPKeyRemove(ctx context.Context, account, address string) error {
+	n, err := bstore.QueryDB[OpenPGPKey](ctx, AuthDB).FilterNonzero(OpenPGPKey{Account: account, Address: address}).Delete()
+	if err == nil && n == 0 {
+		err = bstore.ErrAbsent
+	}
+	return err
+}
+
+// openPGPKeyRemoveForAccount removes all OpenPGP keys for an account.
+func openPGPKeyRemoveForAccount(tx *bstore.Tx, account string) error {
+	_, err := bstore.QueryTx[OpenPGPKey](tx).FilterNonzero(OpenPGPKey{Account: account}).Delete()
+	return err
+}
+
+// OpenPGPKeyringKey is an OpenPGP public key of a correspondent, in the keyring of
+// an account. Keys in the keyring are used to verify signatures of incoming PGP/MIME
+// signed messages.
+type OpenPGPKeyringKey struct {
+	ID          int64
+	Created     time.Time `bstore:"nonzero,default now"`
+	Fingerprint string    `bstore:"nonzero,unique"` // Upper-case hexadecimal.
+	UserIDs     []string  // From the key.
+	KeyData     []byte    `bstore:"nonzero" json:"-"` // Binary OpenPGP key.
+}
+
+// OpenPGPKeyringList returns the keys in the OpenPGP keyring of the account.
+func (a *Account) OpenPGPKeyringList(ctx context.Context) ([]OpenPGPKeyringKey, error) {
+	return bstore.QueryDB[OpenPGPKeyringKey](ctx, a.DB).SortAsc("Fingerprint").List()
+}
+
+// OpenPGPKeyringAdd parses an ASCII-armored or binary OpenPGP public key and adds
+// it to the keyring of the account, replacing a key with the same fingerprint.
+func (a *Account) OpenPGPKeyringAdd(ctx context.Context, keyData []byte) (OpenPGPKeyringKey, error) {
+	k, err := openpgp.ParseKey(keyData)
+	if err != nil {
+		return OpenPGPKeyringKey{}, err
+	}
+	kk := OpenPGPKeyringKey{
+		Fingerprint: k.Fingerprint,
+		UserIDs:     k.UserIDs,
+		KeyData:     k.Data,
+	}
+	err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		_, err := bstore.QueryTx[OpenPGPKeyringKey](tx).FilterNonzero(OpenPGPKeyringKey{Fingerprint: kk.Fingerprint}).Delete()
+		if err != nil {
+			return fmt.Errorf("removing previous key: %v", err)
+		}
+		return tx.Insert(&kk)
+	})
+	return kk, err
+}
+
+// OpenPGPKeyringRemove removes the key with the fingerprint from the keyring of
+// the account.
+func (a *Account) OpenPGPKeyringRemove(ctx context.Context, fingerprint string) error {
+	n, err := bstore.QueryDB[OpenPGPKeyringKey](ctx, a.DB).FilterNonzero(OpenPGPKeyringKey{Fingerprint: fingerprint}).Delete()
+	if err == nil && n == 0 {
+		err = bstore.ErrAbsent
+	}
+	return err
+}
+
+// OpenPGPVerifyKeys returns the keys for verifying signatures of messages from
+// address delivered to the account: the keys in the keyring of the account, and
+// the key published by a local account for the address. Keys that fail to parse
+// are skipped.
+func (a *Account) OpenPGPVerifyKeys(ctx context.Context, log mlog.Log, address smtp.Address) ([]openpgp.Key, error) {
+	kl, err := a.OpenPGPKeyringList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing keyring: %v", err)
+	}
+	var l []openpgp.Key
+	for _, kk := range kl {
+		k, err := openpgp.ParseKey(kk.KeyData)
+		if err != nil {
+			log.Errorx("parsing key from keyring, skipping", err, slog.String("fingerprint", kk.Fingerprint))
+			continue
+		}
+		l = append(l, k)
+	}
+
+	pk, err := bstore.QueryDB[OpenPGPKey](ctx, AuthDB).FilterNonzero(OpenPGPKey{Address: address.String()}).Get()
+	if err == nil {
+		k, err := openpgp.ParseKey(pk.KeyData)
+		if err != nil {
+			log.Errorx("parsing published key, skipping", err, slog.String("address", pk.Address))
+		} else {
+			l = append(l, k)
+		}
+	} else if err != bstore.ErrAbsent {
+		return nil, fmt.Errorf("looking up published key: %v", err)
+	}
+	return l, nil
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// RetentionApply removes messages that are older than the period of the
+// MailboxRetention policies of the account. Mailboxes of policies that don't exist
+// are skipped. The number of removed messages is returned.
+//
+// Changes are broadcasted.
+func (a *Account) RetentionApply(ctx context.Context, log mlog.Log, now time.Time) (removed int, rerr error) {
+	conf, _ := a.Conf()
+	if len(conf.MailboxRetention) == 0 {
+		return 0, nil
+	}
+
+	a.WithWLock(func() {
+		var changes []Change
+		rerr = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+			var modseq ModSeq
+			for _, mr := range conf.MailboxRetention {
+				mb, err := a.MailboxFind(tx, mr.Mailbox)
+				if err != nil {
+					return fmt.Errorf("looking up mailbox %q: %v", mr.Mailbox, err)
+				} else if mb == nil {
+					continue
+				}
+
+				q := bstore.QueryTx[Message](tx)
+				q.FilterNonzero(Message{MailboxID: mb.ID})
+				q.FilterEqual("Expunged", false)
+				q.FilterLess("Received", now.Add(-mr.Period))
+				q.SortAsc("UID")
+				expunge, err := q.List()
+				if err != nil {
+					return fmt.Errorf("listing old messages in mailbox %q: %v", mb.Name, err)
+				}
+				if len(expunge) == 0 {
+					continue
+				}
+
+				if modseq == 0 {
+					modseq, err = a.NextModSeq(tx)
+					if err != nil {
+						return fmt.Errorf("next mod seq: %v", err)
+					}
+				}
+				chremuids, chmbcounts, err := a.MessageRemove(log, tx, modseq, mb, RemoveOpts{}, expunge...)
+				if err != nil {
+					return fmt.Errorf("removing messages from mailbox %q: %w", mb.Name, err)
+				}
+				if err := tx.Update(mb); err != nil {
+					return fmt.Errorf("updating mailbox: %v", err)
+				}
+				changes = append(changes, chremuids, chmbcounts)
+				removed += len(expunge)
+				log.Debug("removed messages for mailbox retention", slog.String("mailbox", mb.Name), slog.Int("count", len(expunge)))
+			}
+			return nil
+		})
+		if rerr == nil {
+			BroadcastChanges(a, changes)
+		}
+	})
+	return removed, rerr
+}
+
+// StartRetention starts a goroutine that periodically removes old messages
+// according to the MailboxRetention policies of accounts.
+func StartRetention() {
+	log := mlog.New("store", nil)
+
+	go func() {
+		defer func() {
+			x := recover()
+			if x == nil {
+				return
+			}
+
+			log.Error("unhandled panic in mailbox retention", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Store)
+		}()
+
+		t := time.NewTicker(time.Hour)
+		defer t.Stop()
+		for {
+			retentionApply(log)
+
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+func retentionApply(log mlog.Log) {
+	for _, name := range mox.Conf.Accounts() {
+		if conf, ok := mox.Conf.Account(name); !ok || len(conf.MailboxRetention) == 0 {
+			continue
+		}
+
+		alog := log.With(slog.String("account", name))
+		acc, err := OpenAccount(alog, name, false)
+		if err != nil {
+			alog.Errorx("open account for mailbox retention", err)
+			continue
+		}
+		removed, err := acc.RetentionApply(mox.Shutdown, alog, time.Now())
+		if err != nil {
+			alog.Errorx("applying mailbox retention", err)
+		} else if removed > 0 {
+			alog.Info("removed old messages for mailbox retention", slog.Int("count", removed))
+		}
+		err = acc.Close()
+		alog.Check(err, "closing account after mailbox retention")
+	}
+}
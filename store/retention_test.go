@@ -0,0 +1,74 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestRetention(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	defer Switchboard()()
+	acc, err := OpenAccount(log, "mjl", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.WaitClosed()
+	}()
+
+	now := time.Now()
+	deliver := func(mailbox string, received time.Time) {
+		t.Helper()
+		msg := "Subject: test\r\n\r\ntest\r\n"
+		f, err := CreateMessageTemp(log, "retention-test")
+		tcheck(t, err, "create temp message file")
+		defer CloseRemoveTempFile(log, f, "temp message file")
+		_, err = f.Write([]byte(msg))
+		tcheck(t, err, "write message")
+
+		m := Message{Received: received, Size: int64(len(msg))}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, mailbox, &m, f)
+		})
+		tcheck(t, err, "deliver")
+	}
+	deliver("Trash", now.Add(-31*24*time.Hour))
+	deliver("Trash", now.Add(-29*24*time.Hour))
+	deliver("Inbox", now.Add(-31*24*time.Hour))
+
+	count := func(mailbox string) int {
+		t.Helper()
+		mb, err := bstore.QueryDB[Mailbox](ctxbg, acc.DB).FilterNonzero(Mailbox{Name: mailbox}).Get()
+		tcheck(t, err, "get mailbox")
+		n, err := bstore.QueryDB[Message](ctxbg, acc.DB).FilterNonzero(Message{MailboxID: mb.ID}).FilterEqual("Expunged", false).Count()
+		tcheck(t, err, "count messages")
+		tcompare(t, int(mb.Total+mb.Deleted), n)
+		return n
+	}
+
+	removed, err := acc.RetentionApply(ctxbg, log, now)
+	tcheck(t, err, "apply retention")
+	tcompare(t, removed, 1)
+	tcompare(t, count("Trash"), 1)
+	tcompare(t, count("Inbox"), 1)
+
+	// Nothing more to remove.
+	removed, err = acc.RetentionApply(ctxbg, log, now)
+	tcheck(t, err, "apply retention")
+	tcompare(t, removed, 0)
+
+	removed, err = acc.RetentionApply(ctxbg, log, now.Add(2*24*time.Hour))
+	tcheck(t, err, "apply retention")
+	tcompare(t, removed, 1)
+	tcompare(t, count("Trash"), 0)
+}
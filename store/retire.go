@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// RetireReply records when the most recent automatic reply about a retired
+// domain was sent to an address.
+type RetireReply struct {
+	ID      int64
+	Address string `bstore:"unique"` // Lower-case SMTP MAIL FROM address.
+	Sent    time.Time
+}
+
+// RetireReplyCheck returns whether an automatic reply about a retired domain
+// should be sent to the sender address, for a message arriving at now. Replies
+// are sent at most once per VacationIntervalDefault per sender. If a reply should
+// be sent, it is recorded as sent.
+func (a *Account) RetireReplyCheck(ctx context.Context, address string, now time.Time) (reply bool, rerr error) {
+	address = strings.ToLower(address)
+	rerr = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		rr, err := bstore.QueryTx[RetireReply](tx).FilterNonzero(RetireReply{Address: address}).Get()
+		if err == bstore.ErrAbsent {
+			reply = true
+			return tx.Insert(&RetireReply{Address: address, Sent: now})
+		} else if err != nil {
+			return fmt.Errorf("looking up earlier retire reply: %v", err)
+		}
+		if now.Sub(rr.Sent) < VacationIntervalDefault {
+			return nil
+		}
+		reply = true
+		rr.Sent = now
+		return tx.Update(&rr)
+	})
+	if rerr != nil {
+		reply = false
+	}
+	return
+}
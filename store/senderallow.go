@@ -0,0 +1,94 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/smtp"
+)
+
+// SenderAllow is a sender address or domain from which messages are accepted
+// without reputation and content analysis, if the message From address is
+// verified. Typically added when a message is moved out of the Rejects mailbox,
+// so the same sender doesn't end up there again.
+type SenderAllow struct {
+	ID      int64
+	Created time.Time `bstore:"nonzero,default now"`
+
+	// Domain of the message From address, as unicode string, like
+	// Message.MsgFromDomain.
+	Domain string `bstore:"nonzero,index Domain+Localpart"`
+
+	// Localpart of the message From address. If empty, all addresses of the domain
+	// are allowed.
+	Localpart smtp.Localpart
+
+	// ID of the message the entry was added for, e.g. when moved out of the Rejects
+	// mailbox. Zero if not added for a message. The message may no longer exist.
+	MessageID int64
+}
+
+// Address returns the allowed address, or the domain if the entry is for the
+// whole domain.
+func (sa SenderAllow) Address() string {
+	if sa.Localpart == "" {
+		return sa.Domain
+	}
+	return sa.Localpart.String() + "@" + sa.Domain
+}
+
+// SenderAllowAdd adds an allow entry for the From address of the message, or
+// its domain. If a matching entry already exists, it is returned instead of adding
+// a new one.
+func SenderAllowAdd(tx *bstore.Tx, m Message, domain bool) (SenderAllow, error) {
+	if m.MsgFromDomain == "" {
+		return SenderAllow{}, errors.New("message has no from address")
+	}
+	sa := SenderAllow{Domain: m.MsgFromDomain, MessageID: m.ID}
+	if !domain {
+		sa.Localpart = m.MsgFromLocalpart
+	}
+
+	q := bstore.QueryTx[SenderAllow](tx)
+	q.FilterEqual("Domain", sa.Domain)
+	q.FilterEqual("Localpart", sa.Localpart)
+	if xsa, err := q.Get(); err == nil {
+		return xsa, nil
+	} else if err != bstore.ErrAbsent {
+		return SenderAllow{}, fmt.Errorf("looking up existing allow entry: %v", err)
+	}
+
+	if err := tx.Insert(&sa); err != nil {
+		return SenderAllow{}, fmt.Errorf("adding allow entry: %v", err)
+	}
+	return sa, nil
+}
+
+// SenderAllowRescued adds an allow entry for a message that is moved out of the
+// Rejects mailbox, depending on the account setting RejectsRescueAllow: empty for
+// no entry, or "address" or "domain".
+func SenderAllowRescued(tx *bstore.Tx, mode string, m Message) error {
+	if mode == "" || m.MsgFromDomain == "" {
+		return nil
+	}
+	_, err := SenderAllowAdd(tx, m, mode == "domain")
+	return err
+}
+
+// SenderAllowed returns whether messages with a From address with localpart and
+// domain (unicode) are allowed by an allow entry. The caller must check the From
+// address is verified.
+func SenderAllowed(tx *bstore.Tx, localpart smtp.Localpart, domain string) (bool, error) {
+	if domain == "" {
+		return false, nil
+	}
+	q := bstore.QueryTx[SenderAllow](tx)
+	q.FilterEqual("Domain", domain)
+	q.FilterFn(func(sa SenderAllow) bool {
+		return sa.Localpart == "" || sa.Localpart == localpart
+	})
+	return q.Exists()
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log/slog"
 	"runtime/debug"
+	"sort"
 	"sync"
 	"time"
 
@@ -18,6 +19,9 @@ import (
 	"github.com/mjl-/mox/mox-"
 )
 
+// ErrSessionUnknown is returned for operations on a session that does not exist.
+var ErrSessionUnknown = errors.New("unknown session token")
+
 const sessionsPerAccount = 100            // We remove the oldest when 100th is added.
 const sessionLifetime = 24 * time.Hour    // Extended automatically by use.
 const sessionWriteDelay = 5 * time.Minute // Per account, for coalescing writes.
@@ -84,8 +88,9 @@ func ensureAccountSessions(ctx context.Context, log mlog.Log, accountName string
 
 // SessionUse checks if a session is valid. If csrfToken is the empty string, no
 // CSRF check is done. Otherwise it must be the csrf token associated with the
-// session token.
-func SessionUse(ctx context.Context, log mlog.Log, accountName string, sessionToken SessionToken, csrfToken CSRFToken) (LoginSession, error) {
+// session token. If remoteIP is not empty, it and userAgent are stored as most
+// recent use of the session.
+func SessionUse(ctx context.Context, log mlog.Log, accountName string, sessionToken SessionToken, csrfToken CSRFToken, remoteIP, userAgent string) (LoginSession, error) {
 	sessions.Lock()
 	defer sessions.Unlock()
 
@@ -93,11 +98,11 @@ func SessionUse(ctx context.Context, log mlog.Log, accountName string, sessionTo
 		return LoginSession{}, err
 	}
 
-	return sessionUse(ctx, log, accountName, sessionToken, csrfToken)
+	return sessionUse(ctx, log, accountName, sessionToken, csrfToken, remoteIP, userAgent)
 }
 
 // must be called with sessions lock held.
-func sessionUse(ctx context.Context, log mlog.Log, accountName string, sessionToken SessionToken, csrfToken CSRFToken) (LoginSession, error) {
+func sessionUse(ctx context.Context, log mlog.Log, accountName string, sessionToken SessionToken, csrfToken CSRFToken, remoteIP, userAgent string) (LoginSession, error) {
 	// Check if valid.
 	ls, ok := sessions.accounts[accountName][sessionToken]
 	if !ok {
@@ -110,6 +115,11 @@ func sessionUse(ctx context.Context, log mlog.Log, accountName string, sessionTo
 
 	// Extend lifetime.
 	ls.Expires = time.Now().Add(sessionLifetime)
+	if remoteIP != "" {
+		ls.LastUsed = time.Now()
+		ls.RemoteIP = remoteIP
+		ls.UserAgent = userAgent
+	}
 	sessions.accounts[accountName][sessionToken] = ls
 
 	// If we haven't scheduled a flush to database yet, schedule one now.
@@ -241,10 +251,17 @@ func sessionAddToken(ctx context.Context, log mlog.Log, acc *Account, ls *LoginS
 
 // SessionAdd creates a new session token, with csrf token, and adds it to the
 // database and in-memory session cache. If there are too many sessions, the oldest
-// is removed.
-func SessionAdd(ctx context.Context, log mlog.Log, accountName, loginAddress string) (session SessionToken, csrf CSRFToken, rerr error) {
+// is removed. The remoteIP and userAgent of the login are stored with the session.
+func SessionAdd(ctx context.Context, log mlog.Log, accountName, loginAddress, remoteIP, userAgent string) (session SessionToken, csrf CSRFToken, rerr error) {
 	// Prepare new LoginSession.
-	ls := LoginSession{0, time.Time{}, time.Now().Add(sessionLifetime), [16]byte{}, [16]byte{}, accountName, loginAddress, "", ""}
+	ls := LoginSession{
+		Expires:      time.Now().Add(sessionLifetime),
+		AccountName:  accountName,
+		LoginAddress: loginAddress,
+		LastUsed:     time.Now(),
+		RemoteIP:     remoteIP,
+		UserAgent:    userAgent,
+	}
 	cryptorand.Read(ls.SessionTokenBinary[:])
 	cryptorand.Read(ls.CSRFTokenBinary[:])
 	ls.sessionToken = SessionToken(base64.RawURLEncoding.EncodeToString(ls.SessionTokenBinary[:]))
@@ -284,9 +301,14 @@ func SessionRemove(ctx context.Context, log mlog.Log, accountName string, sessio
 		log.Check(err, "closing account")
 	}()
 
+	return sessionRemoveAcc(ctx, acc, accountName, sessionToken)
+}
+
+// must be called with sessions lock held.
+func sessionRemoveAcc(ctx context.Context, acc *Account, accountName string, sessionToken SessionToken) error {
 	ls, ok := sessions.accounts[accountName][sessionToken]
 	if !ok {
-		return fmt.Errorf("unknown session token")
+		return ErrSessionUnknown
 	}
 
 	if err := acc.DB.Delete(ctx, &ls); err != nil {
@@ -302,6 +324,83 @@ func SessionRemove(ctx context.Context, log mlog.Log, accountName string, sessio
 	return nil
 }
 
+// SessionList returns the unexpired sessions of an account, most recently used
+// first. The ID of the session for currentToken is returned as currentID, or 0 if
+// it is not found.
+func SessionList(ctx context.Context, log mlog.Log, accountName string, currentToken SessionToken) (l []LoginSession, currentID int64, rerr error) {
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	if _, err := ensureAccountSessions(ctx, log, accountName, false); err != nil {
+		return nil, 0, err
+	}
+
+	for _, ls := range sessions.accounts[accountName] {
+		if time.Until(ls.Expires) < 0 {
+			continue
+		}
+		if ls.sessionToken == currentToken {
+			currentID = ls.ID
+		}
+		l = append(l, ls)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].LastUsed.After(l[j].LastUsed)
+	})
+	return l, currentID, nil
+}
+
+// SessionRemoveID removes a session by ID, e.g. when revoked by the user.
+func SessionRemoveID(ctx context.Context, log mlog.Log, accountName string, id int64) error {
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	acc, err := ensureAccountSessions(ctx, log, accountName, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	for _, ls := range sessions.accounts[accountName] {
+		if ls.ID == id {
+			return sessionRemoveAcc(ctx, acc, accountName, ls.sessionToken)
+		}
+	}
+	return ErrSessionUnknown
+}
+
+// SessionRemoveOthers removes all sessions of an account except the session for
+// keepToken, e.g. after the user suspects a session was compromised. The number of
+// removed sessions is returned.
+func SessionRemoveOthers(ctx context.Context, log mlog.Log, accountName string, keepToken SessionToken) (int, error) {
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	acc, err := ensureAccountSessions(ctx, log, accountName, true)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	var n int
+	for token := range sessions.accounts[accountName] {
+		if token == keepToken {
+			continue
+		}
+		if err := sessionRemoveAcc(ctx, acc, accountName, token); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
 // sessionRemoveAll removes all session tokens for an account. Useful after a password reset.
 func sessionRemoveAll(ctx context.Context, log mlog.Log, tx *bstore.Tx, accountName string) error {
 	sessions.Lock()
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// Limits for Sieve scripts, managed through ManageSieve.
+const (
+	SieveScriptMaxSize = 128 * 1024 // Maximum size of a Sieve script in bytes.
+	SieveScriptsMax    = 64         // Maximum number of Sieve scripts per account.
+	SieveScriptNameMax = 128        // Maximum length of a Sieve script name in characters.
+)
+
+// SieveScript is a Sieve filtering script for an account, as uploaded through
+// ManageSieve. At most one script is active. Scripts are validated before they
+// are stored.
+type SieveScript struct {
+	ID       int64
+	Name     string    `bstore:"nonzero,unique"`
+	Script   string    // Sieve script as uploaded.
+	Active   bool      `bstore:"index"`
+	Created  time.Time `bstore:"default now"`
+	Modified time.Time `bstore:"default now"`
+}
+
+// SieveVacationReply records when the most recent automatic reply for a Sieve
+// "vacation" action was sent to an address.
+type SieveVacationReply struct {
+	ID      int64
+	Handle  string `bstore:"nonzero,unique Handle+Address"` // Of the vacation action.
+	Address string // Lower-case SMTP MAIL FROM address.
+	Sent    time.Time
+}
+
+// SieveScriptActive returns the active Sieve script, or nil if no script is
+// active.
+func (a *Account) SieveScriptActive(ctx context.Context) (*SieveScript, error) {
+	ss, err := bstore.QueryDB[SieveScript](ctx, a.DB).FilterEqual("Active", true).Get()
+	if err == bstore.ErrAbsent {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &ss, nil
+}
+
+// SieveVacationReplyCheck returns whether an automatic reply for the Sieve
+// vacation action with handle should be sent to the sender address, with at least
+// interval between replies. If so, the reply is recorded as sent.
+func (a *Account) SieveVacationReplyCheck(ctx context.Context, handle, address string, interval time.Duration, now time.Time) (reply bool, rerr error) {
+	address = strings.ToLower(address)
+	rerr = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		vr, err := bstore.QueryTx[SieveVacationReply](tx).FilterNonzero(SieveVacationReply{Handle: handle, Address: address}).Get()
+		if err == bstore.ErrAbsent {
+			reply = true
+			return tx.Insert(&SieveVacationReply{Handle: handle, Address: address, Sent: now})
+		} else if err != nil {
+			return fmt.Errorf("looking up earlier vacation reply: %v", err)
+		}
+		if now.Sub(vr.Sent) < interval {
+			return nil
+		}
+		reply = true
+		vr.Sent = now
+		return tx.Update(&vr)
+	})
+	if rerr != nil {
+		reply = false
+	}
+	return
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+
+	"github.com/mjl-/bstore"
+)
+
+// SRSKey is the key for signing SRS addresses, used as SMTP MAIL FROM when
+// forwarding messages. There is only a single record, with ID 1. It is generated
+// on first use and kept in the database so SRS addresses of earlier forwarded
+// messages stay valid across restarts.
+type SRSKey struct {
+	ID  int64
+	Key []byte `bstore:"nonzero"`
+}
+
+// SRSKeyGet returns the SRS key, generating and storing a new one if none exists yet.
+func SRSKeyGet(ctx context.Context) (key []byte, rerr error) {
+	k := SRSKey{ID: 1}
+	if err := AuthDB.Get(ctx, &k); err == nil {
+		return k.Key, nil
+	} else if err != bstore.ErrAbsent {
+		return nil, err
+	}
+
+	// Check again in write transaction, another goroutine may have created the key.
+	rerr = AuthDB.Write(ctx, func(tx *bstore.Tx) error {
+		k := SRSKey{ID: 1}
+		err := tx.Get(&k)
+		if err == nil {
+			key = k.Key
+			return nil
+		} else if err != bstore.ErrAbsent {
+			return err
+		}
+		k.Key = make([]byte, 32)
+		cryptorand.Read(k.Key)
+		key = k.Key
+		return tx.Insert(&k)
+	})
+	return
+}
@@ -529,6 +529,7 @@ func (c *Comm) Broadcast(ch []Change) {
 	if len(ch) == 0 {
 		return
 	}
+	c.acc.mailboxTreeInvalidate(ch)
 	done := make(chan struct{}, 1)
 	broadcast <- changeReq{c.acc, c, ch, done}
 	<-done
@@ -559,6 +560,7 @@ func BroadcastChanges(acc *Account, ch []Change) {
 	if len(ch) == 0 {
 		return
 	}
+	acc.mailboxTreeInvalidate(ch)
 	done := make(chan struct{}, 1)
 	broadcast <- changeReq{acc, nil, ch, done}
 	<-done
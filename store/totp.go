@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/totp"
+)
+
+var (
+	// ErrTOTPRequired is returned when logging in to a web interface without TOTP
+	// code, for an account that has two-factor authentication enabled.
+	ErrTOTPRequired = errors.New("two-factor authentication code required")
+
+	// ErrTOTPInvalid is returned for invalid, reused or expired TOTP codes and
+	// unknown recovery codes.
+	ErrTOTPInvalid = errors.New("invalid two-factor authentication code")
+)
+
+// TOTP holds the secret for two-factor authentication with time-based one-time
+// passwords for the account and mail web interfaces. At most one record exists.
+// Two-factor authentication is enabled once the record is confirmed.
+type TOTP struct {
+	ID        int64
+	Created   time.Time `bstore:"default now"`
+	Secret    string    `bstore:"nonzero"` // Base32.
+	Confirmed bool      // After first code was entered, during enrollment.
+	LastStep  int64     // Time step of last used code, codes cannot be reused.
+}
+
+// TOTPRecoveryCode is a hash of a recovery code, for logging in when the
+// authenticator is not available. Each code can be used once.
+type TOTPRecoveryCode struct {
+	ID   int64
+	Hash string `bstore:"nonzero,unique"` // See totp.RecoveryCodeHash.
+}
+
+// Number of recovery codes generated when enabling two-factor authentication.
+const totpRecoveryCodes = 10
+
+// TOTPStatus returns whether two-factor authentication is enabled, and the
+// number of remaining recovery codes.
+func (a *Account) TOTPStatus(ctx context.Context) (enabled bool, recoveryCodes int, rerr error) {
+	rerr = a.DB.Read(ctx, func(tx *bstore.Tx) error {
+		t, err := bstore.QueryTx[TOTP](tx).Get()
+		if err == bstore.ErrAbsent {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		enabled = t.Confirmed
+		recoveryCodes, err = bstore.QueryTx[TOTPRecoveryCode](tx).Count()
+		return err
+	})
+	return
+}
+
+// TOTPEnrollStart generates a new TOTP secret for the account, to be confirmed
+// with TOTPEnrollConfirm. Any previous unconfirmed secret is replaced. An error is
+// returned if two-factor authentication is already enabled.
+func (a *Account) TOTPEnrollStart(ctx context.Context) (secret string, rerr error) {
+	rerr = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		t, err := bstore.QueryTx[TOTP](tx).Get()
+		if err == nil && t.Confirmed {
+			return fmt.Errorf("two-factor authentication already enabled")
+		} else if err != nil && err != bstore.ErrAbsent {
+			return err
+		}
+		if _, err := bstore.QueryTx[TOTP](tx).Delete(); err != nil {
+			return fmt.Errorf("removing previous totp secret: %v", err)
+		}
+		t = TOTP{Secret: totp.NewSecret()}
+		if err := tx.Insert(&t); err != nil {
+			return fmt.Errorf("inserting totp secret: %v", err)
+		}
+		secret = t.Secret
+		return nil
+	})
+	return
+}
+
+// TOTPEnrollConfirm enables two-factor authentication if code is valid for the
+// secret from TOTPEnrollStart. New recovery codes are generated and returned, only
+// their hashes are stored.
+func (a *Account) TOTPEnrollConfirm(ctx context.Context, code string) (recoveryCodes []string, rerr error) {
+	rerr = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		t, err := bstore.QueryTx[TOTP](tx).Get()
+		if err == bstore.ErrAbsent || err == nil && t.Confirmed {
+			return fmt.Errorf("no two-factor authentication enrollment in progress")
+		} else if err != nil {
+			return err
+		}
+		step, ok, err := totp.Verify(t.Secret, code, time.Now(), t.LastStep)
+		if err != nil {
+			return fmt.Errorf("verifying code: %v", err)
+		} else if !ok {
+			return ErrTOTPInvalid
+		}
+		t.Confirmed = true
+		t.LastStep = step
+		if err := tx.Update(&t); err != nil {
+			return fmt.Errorf("updating totp: %v", err)
+		}
+
+		if _, err := bstore.QueryTx[TOTPRecoveryCode](tx).Delete(); err != nil {
+			return fmt.Errorf("removing old recovery codes: %v", err)
+		}
+		recoveryCodes = totp.NewRecoveryCodes(totpRecoveryCodes)
+		for _, c := range recoveryCodes {
+			if err := tx.Insert(&TOTPRecoveryCode{Hash: totp.RecoveryCodeHash(c)}); err != nil {
+				return fmt.Errorf("inserting recovery code: %v", err)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// TOTPDisable disables two-factor authentication, removing the secret and any
+// recovery codes.
+func (a *Account) TOTPDisable(ctx context.Context) error {
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		if _, err := bstore.QueryTx[TOTP](tx).Delete(); err != nil {
+			return fmt.Errorf("removing totp secret: %v", err)
+		}
+		if _, err := bstore.QueryTx[TOTPRecoveryCode](tx).Delete(); err != nil {
+			return fmt.Errorf("removing recovery codes: %v", err)
+		}
+		return nil
+	})
+}
+
+// TOTPCheck checks code against the TOTP secret or the recovery codes, if
+// two-factor authentication is enabled. A used recovery code is removed.
+// ErrTOTPRequired is returned if code is empty and two-factor authentication is
+// enabled. ErrTOTPInvalid is returned for bad codes.
+func (a *Account) TOTPCheck(ctx context.Context, code string) error {
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		t, err := bstore.QueryTx[TOTP](tx).Get()
+		if err == bstore.ErrAbsent || err == nil && !t.Confirmed {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if code == "" {
+			return ErrTOTPRequired
+		}
+
+		step, ok, err := totp.Verify(t.Secret, code, time.Now(), t.LastStep)
+		if err != nil {
+			return fmt.Errorf("verifying code: %v", err)
+		} else if ok {
+			t.LastStep = step
+			if err := tx.Update(&t); err != nil {
+				return fmt.Errorf("updating totp: %v", err)
+			}
+			return nil
+		}
+
+		n, err := bstore.QueryTx[TOTPRecoveryCode](tx).FilterNonzero(TOTPRecoveryCode{Hash: totp.RecoveryCodeHash(code)}).Delete()
+		if err != nil {
+			return fmt.Errorf("removing recovery code: %v", err)
+		} else if n == 0 {
+			return ErrTOTPInvalid
+		}
+		return nil
+	})
+}
@@ -7,6 +7,8 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/mjl-/bstore"
 
@@ -176,3 +178,90 @@ func (a *Account) TrainMessage(ctx context.Context, log mlog.Log, jf *junk.Filte
 
 	return true, jf.Train(ctx, ham, words)
 }
+
+// Limits for the junk classification stored with a message.
+const (
+	junkClassificationMaxWords   = 10 // Per list of ham and spam words.
+	junkClassificationMaxWordLen = 40 // In bytes, longer words are truncated.
+)
+
+// JunkClassification holds the result of classifying a message with the junk
+// filter during incoming delivery. It is stored with the message so users can see
+// why a message was (not) considered junk.
+type JunkClassification struct {
+	Junk        bool    // Whether content was considered junk.
+	Probability float64 // Spam probability of content, between 0 (ham) and 1 (spam).
+	Threshold   float64 // Threshold used, possibly stricter than configured, see Remark.
+	Significant bool    // Whether enough words were known to make a decision.
+	Remark      string  // Why a stricter threshold was used, if any.
+
+	// Most significant words, with their score between 0 (ham) and 1 (spam).
+	Hams  []JunkWord
+	Spams []JunkWord
+}
+
+// JunkWord is a word and its spam probability from the junk filter.
+type JunkWord struct {
+	Word  string
+	Score float64
+}
+
+// NewJunkClassification returns a classification to store with a message, with
+// the number of words and their length limited.
+func NewJunkClassification(isJunk bool, r junk.Result, threshold float64, remark string) *JunkClassification {
+	words := func(l []junk.WordScore) []JunkWord {
+		var r []JunkWord
+		for _, w := range l[:min(len(l), junkClassificationMaxWords)] {
+			word := w.Word
+			if len(word) > junkClassificationMaxWordLen {
+				word = word[:junkClassificationMaxWordLen]
+				// Don't cut in the middle of a utf-8 sequence.
+				for !utf8.ValidString(word) {
+					word = word[:len(word)-1]
+				}
+			}
+			r = append(r, JunkWord{word, w.Score})
+		}
+		return r
+	}
+	return &JunkClassification{
+		Junk:        isJunk,
+		Probability: r.Probability,
+		Threshold:   threshold,
+		Significant: r.Significant,
+		Remark:      remark,
+		Hams:        words(r.Hams),
+		Spams:       words(r.Spams),
+	}
+}
+
+// String returns a human-readable multi-line explanation of the classification.
+func (c JunkClassification) String() string {
+	var b strings.Builder
+	verdict := "not junk"
+	if c.Junk {
+		verdict = "junk"
+	}
+	fmt.Fprintf(&b, "content: %s\n", verdict)
+	fmt.Fprintf(&b, "spam probability: %.3f\n", c.Probability)
+	fmt.Fprintf(&b, "threshold: %.3f", c.Threshold)
+	if c.Remark != "" {
+		fmt.Fprintf(&b, " (%s)", c.Remark)
+	}
+	b.WriteString("\n")
+	if !c.Significant {
+		b.WriteString("not significant: too few known words to make a decision\n")
+	}
+	xwords := func(kind string, l []JunkWord) {
+		fmt.Fprintf(&b, "%s words:\n", kind)
+		if len(l) == 0 {
+			b.WriteString("\t(none)\n")
+		}
+		for _, w := range l {
+			fmt.Fprintf(&b, "\t%.3f %s\n", w.Score, w.Word)
+		}
+	}
+	xwords("spam", c.Spams)
+	xwords("ham", c.Hams)
+	return b.String()
+}
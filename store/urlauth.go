@@ -0,0 +1,46 @@
+package store
+
+import (
+	"crypto/rand"
+
+	"github.com/mjl-/bstore"
+)
+
+// URLAuthKey is the "mailbox access key" for a mailbox, for generating and
+// verifying URLAUTH-authorized IMAP URLs, which give access to a message (part)
+// without the password of the account, e.g. for BURL during submission.
+type URLAuthKey struct {
+	ID        int64
+	MailboxID int64  `bstore:"nonzero,unique"`
+	Key       []byte `bstore:"nonzero"`
+}
+
+// URLAuthKeyGet returns the mailbox access key for URLAUTH for a mailbox. If
+// there is no key yet and create is set, a new key is generated and stored. If
+// there is no key and create is not set, bstore.ErrAbsent is returned.
+func URLAuthKeyGet(tx *bstore.Tx, mailboxID int64, create bool) ([]byte, error) {
+	k, err := bstore.QueryTx[URLAuthKey](tx).FilterNonzero(URLAuthKey{MailboxID: mailboxID}).Get()
+	if err == bstore.ErrAbsent && create {
+		k = URLAuthKey{MailboxID: mailboxID, Key: make([]byte, 32)}
+		if _, err := rand.Read(k.Key); err != nil {
+			return nil, err
+		}
+		err = tx.Insert(&k)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k.Key, nil
+}
+
+// URLAuthKeysReset removes the mailbox access keys for mailboxID, or all mailboxes
+// if mailboxID is 0, invalidating all previously generated URLAUTH-authorized URLs
+// for the mailbox(es). A new key is generated when needed.
+func URLAuthKeysReset(tx *bstore.Tx, mailboxID int64) error {
+	q := bstore.QueryTx[URLAuthKey](tx)
+	if mailboxID != 0 {
+		q.FilterNonzero(URLAuthKey{MailboxID: mailboxID})
+	}
+	_, err := q.Delete()
+	return err
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// Vacation holds the settings for automatic replies to incoming messages, e.g.
+// when out of office. A single record with ID 1.
+type Vacation struct {
+	ID int64
+
+	Active bool
+
+	// If non-zero, replies are only sent for messages arriving from Start, and before
+	// End.
+	Start time.Time
+	End   time.Time
+
+	Subject string
+	Body    string // Plain text.
+
+	// Minimum number of days between replies to the same sender. If 0, the default
+	// of 7 days is used.
+	IntervalDays int
+}
+
+// VacationReply records when the most recent automatic reply was sent to an
+// address.
+type VacationReply struct {
+	ID      int64
+	Address string `bstore:"unique"` // Lower-case SMTP MAIL FROM address.
+	Sent    time.Time
+}
+
+// VacationIntervalDefault is the default interval between replies to the same
+// sender, as recommended in RFC 3834.
+const VacationIntervalDefault = 7 * 24 * time.Hour
+
+// Interval returns the minimum time between replies to the same sender.
+func (v Vacation) Interval() time.Duration {
+	if v.IntervalDays <= 0 {
+		return VacationIntervalDefault
+	}
+	return time.Duration(v.IntervalDays) * 24 * time.Hour
+}
+
+// ActiveAt returns whether automatic replies are to be sent for messages arriving
+// at t.
+func (v Vacation) ActiveAt(t time.Time) bool {
+	return v.Active && (v.Start.IsZero() || !t.Before(v.Start)) && (v.End.IsZero() || t.Before(v.End))
+}
+
+// Check returns an error if the vacation settings are not valid.
+func (v Vacation) Check() error {
+	if v.Active && strings.TrimSpace(v.Subject) == "" {
+		return errors.New("subject required")
+	}
+	if strings.ContainsAny(v.Subject, "\r\n") {
+		return errors.New("subject cannot contain newlines")
+	}
+	if !v.Start.IsZero() && !v.End.IsZero() && !v.End.After(v.Start) {
+		return errors.New("end must be after start")
+	}
+	if v.IntervalDays < 0 || v.IntervalDays > 365 {
+		return errors.New("interval must be between 0 and 365 days")
+	}
+	return nil
+}
+
+// VacationGet returns the vacation settings, with Active false if none were
+// saved yet.
+func (a *Account) VacationGet(ctx context.Context) (Vacation, error) {
+	v := Vacation{ID: 1}
+	err := a.DB.Get(ctx, &v)
+	if err == bstore.ErrAbsent {
+		return Vacation{ID: 1}, nil
+	}
+	return v, err
+}
+
+// VacationSave stores the vacation settings. Earlier replies are forgotten, so
+// senders get the new reply on their next message.
+func (a *Account) VacationSave(ctx context.Context, v Vacation) error {
+	if err := v.Check(); err != nil {
+		return err
+	}
+	v.ID = 1
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		if _, err := bstore.QueryTx[VacationReply](tx).Delete(); err != nil {
+			return fmt.Errorf("removing earlier vacation replies: %v", err)
+		}
+		if err := tx.Get(&Vacation{ID: 1}); err == bstore.ErrAbsent {
+			return tx.Insert(&v)
+		} else if err != nil {
+			return err
+		}
+		return tx.Update(&v)
+	})
+}
+
+// VacationReplyCheck returns whether an automatic reply should be sent to the
+// sender address, for a message arriving at now. If so, the reply is recorded as
+// sent and the vacation settings for composing the reply are returned.
+func (a *Account) VacationReplyCheck(ctx context.Context, address string, now time.Time) (v Vacation, reply bool, rerr error) {
+	address = strings.ToLower(address)
+	rerr = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		v = Vacation{ID: 1}
+		if err := tx.Get(&v); err == bstore.ErrAbsent {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("get vacation settings: %v", err)
+		}
+		if !v.ActiveAt(now) {
+			return nil
+		}
+
+		vr, err := bstore.QueryTx[VacationReply](tx).FilterNonzero(VacationReply{Address: address}).Get()
+		if err == bstore.ErrAbsent {
+			reply = true
+			return tx.Insert(&VacationReply{Address: address, Sent: now})
+		} else if err != nil {
+			return fmt.Errorf("looking up earlier vacation reply: %v", err)
+		}
+		if now.Sub(vr.Sent) < v.Interval() {
+			return nil
+		}
+		reply = true
+		vr.Sent = now
+		return tx.Update(&vr)
+	})
+	if rerr != nil {
+		reply = false
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestVacation(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	err := Init(ctxbg)
+	tcheck(t, err, "init")
+	defer func() {
+		err := Close()
+		tcheck(t, err, "close")
+	}()
+	defer Switchboard()()
+	acc, err := OpenAccount(log, "mjl", false)
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.WaitClosed()
+	}()
+
+	day := func(d int) time.Time {
+		return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC)
+	}
+
+	check := func(addr string, now time.Time, expReply bool) {
+		t.Helper()
+		_, reply, err := acc.VacationReplyCheck(ctxbg, addr, now)
+		tcheck(t, err, "vacation reply check")
+		if reply != expReply {
+			t.Fatalf("reply for %s at %v: got %v, expected %v", addr, now, reply, expReply)
+		}
+	}
+
+	// Nothing configured yet.
+	v, err := acc.VacationGet(ctxbg)
+	tcheck(t, err, "get vacation")
+	if v.Active {
+		t.Fatalf("vacation active without settings")
+	}
+	check("remote@example.org", day(1), false)
+
+	// Invalid settings.
+	err = acc.VacationSave(ctxbg, Vacation{Active: true})
+	if err == nil {
+		t.Fatalf("missing subject accepted")
+	}
+	err = acc.VacationSave(ctxbg, Vacation{Active: true, Subject: "away", Start: day(5), End: day(4)})
+	if err == nil {
+		t.Fatalf("end before start accepted")
+	}
+
+	err = acc.VacationSave(ctxbg, Vacation{Active: true, Subject: "away", Body: "back later\n", Start: day(2), End: day(20), IntervalDays: 3})
+	tcheck(t, err, "save vacation")
+	v, err = acc.VacationGet(ctxbg)
+	tcheck(t, err, "get vacation")
+	if !v.Active || v.Subject != "away" || v.IntervalDays != 3 {
+		t.Fatalf("unexpected vacation settings %#v", v)
+	}
+
+	check("remote@example.org", day(1), false) // Before start.
+	check("remote@example.org", day(2), true)
+	check("Remote@Example.org", day(3), false) // Within interval, case-insensitive.
+	check("other@example.org", day(3), true)
+	check("remote@example.org", day(5), true)   // Interval passed.
+	check("remote@example.org", day(20), false) // At end.
+
+	// Saving again resets earlier replies.
+	err = acc.VacationSave(ctxbg, Vacation{Active: true, Subject: "still away", Start: day(2), End: day(20)})
+	tcheck(t, err, "save vacation")
+	check("remote@example.org", day(6), true)
+	check("remote@example.org", day(12), false) // Default interval of 7 days.
+	check("remote@example.org", day(13), true)
+
+	// Inactive.
+	err = acc.VacationSave(ctxbg, Vacation{Subject: "away"})
+	tcheck(t, err, "save vacation")
+	check("new@example.org", day(13), false)
+}
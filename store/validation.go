@@ -22,3 +22,14 @@ func SPFValidation(status spf.Status) Validation {
 	}
 	return v
 }
+
+// validationSPFStatus returns the spf.Status for a Validation from SPFValidation,
+// or an empty status if there is none, e.g. for ValidationUnknown.
+func validationSPFStatus(v Validation) spf.Status {
+	for status, sv := range spfValidations {
+		if sv == v {
+			return status
+		}
+	}
+	return ""
+}
@@ -0,0 +1,132 @@
+// Package totp implements time-based one-time passwords (TOTP), as generated by
+// authenticator apps and used for two-factor authentication, and recovery codes
+// for when the authenticator is lost.
+//
+// Only the parameters supported by practically all authenticator apps are
+// implemented: HMAC-SHA1, 6 digits and a period of 30 seconds.
+//
+// TOTP is specified in RFC 6238, based on HOTP, RFC 4226.
+package totp
+
+import (
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Period is the duration of a time step, a code is valid during one period.
+const Period = 30 * time.Second
+
+// Digits is the number of digits in a code.
+const Digits = 6
+
+// ErrSecret is returned for secrets that are not valid base32.
+var ErrSecret = errors.New("bad totp secret")
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a new random secret, base32-encoded without padding, for
+// entering in an authenticator app.
+func NewSecret() string {
+	// 160 bits, the size of the SHA-1 output, recommended by ../rfc/4226.
+	buf := make([]byte, 20)
+	cryptorand.Read(buf)
+	return encoding.EncodeToString(buf)
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	s := strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	buf, err := encoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSecret, err)
+	}
+	return buf, nil
+}
+
+// Step returns the time step for t.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// hotp returns the code for a time step. ../rfc/4226
+func hotp(key []byte, step int64) string {
+	mac := hmac.New(sha1.New, key)
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(step)))
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, v%1_000_000)
+}
+
+// Code returns the code for secret at time t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, Step(t)), nil
+}
+
+// Verify checks if code is valid for secret at time t. To account for clock skew
+// and delays in entering a code, codes for the previous and next time step are
+// also accepted. Codes for steps up to and including lastStep are rejected, to
+// prevent reuse of a code. On success, the time step of the code is returned, to
+// be passed as lastStep in future calls.
+func Verify(secret, code string, t time.Time, lastStep int64) (step int64, ok bool, rerr error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false, err
+	}
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != Digits {
+		return 0, false, nil
+	}
+	cur := Step(t)
+	for _, st := range []int64{cur, cur - 1, cur + 1} {
+		if st > lastStep && hmac.Equal([]byte(code), []byte(hotp(key, st))) {
+			return st, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// URI returns an "otpauth" URI for the secret, typically shown as QR code and
+// scanned by authenticator apps. The issuer and account are shown in the app.
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// NewRecoveryCodes returns n new random recovery codes, each of the form
+// "xxxxx-xxxxx" with lower case base32 characters.
+func NewRecoveryCodes(n int) []string {
+	codes := make([]string, n)
+	for i := range codes {
+		buf := make([]byte, 10)
+		cryptorand.Read(buf)
+		s := strings.ToLower(encoding.EncodeToString(buf))[:10]
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+	return codes
+}
+
+// RecoveryCodeHash returns the hash of a recovery code for storage, as hex
+// SHA-256 of the normalized code: lower case, without dashes and spaces.
+// Recovery codes are random with 50 bits of entropy, a simple hash is sufficient.
+func RecoveryCodeHash(code string) string {
+	s := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,94 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestTOTP(t *testing.T) {
+	// Test vectors for SHA-1 from ../rfc/6238, last 6 of the 8 digits.
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range vectors {
+		code, err := Code(secret, time.Unix(v.unix, 0))
+		if err != nil {
+			t.Fatalf("code: %v", err)
+		}
+		if code != v.code {
+			t.Fatalf("code at %d: got %q, expected %q", v.unix, code, v.code)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	step, ok, err := Verify(secret, "005924", now, 0)
+	if err != nil || !ok || step != Step(now) {
+		t.Fatalf("verify: got %d %v %v", step, ok, err)
+	}
+	// Code cannot be reused.
+	if _, ok, _ := Verify(secret, "005924", now, step); ok {
+		t.Fatalf("verify accepted reused code")
+	}
+	// Previous and next step are accepted, but not two steps away.
+	if _, ok, _ := Verify(secret, "005924", now.Add(Period), 0); !ok {
+		t.Fatalf("verify did not accept code from previous step")
+	}
+	if _, ok, _ := Verify(secret, "005924", now.Add(-Period), 0); !ok {
+		t.Fatalf("verify did not accept code from next step")
+	}
+	if _, ok, _ := Verify(secret, "005924", now.Add(2*Period), 0); ok {
+		t.Fatalf("verify accepted code from two steps ago")
+	}
+	if _, ok, _ := Verify(secret, "12345", now, 0); ok {
+		t.Fatalf("verify accepted short code")
+	}
+	if _, _, err := Verify("!", "005924", now, 0); err == nil {
+		t.Fatalf("verify accepted bad secret")
+	}
+
+	// New secrets can be used.
+	secret = NewSecret()
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatalf("code with new secret: %v", err)
+	}
+	if _, ok, _ := Verify(secret, code, now, 0); !ok {
+		t.Fatalf("verify with new secret failed")
+	}
+
+	const expURI = "otpauth://totp/mox:mjl@mox.example?issuer=mox&secret=ABC"
+	if uri := URI("mox", "mjl@mox.example", "ABC"); uri != expURI {
+		t.Fatalf("uri: got %q, expected %q", uri, expURI)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes := NewRecoveryCodes(10)
+	if len(codes) != 10 {
+		t.Fatalf("got %d codes, expected 10", len(codes))
+	}
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if len(c) != 11 || c[5] != '-' {
+			t.Fatalf("bad recovery code %q", c)
+		}
+		h := RecoveryCodeHash(c)
+		if seen[h] {
+			t.Fatalf("duplicate recovery code")
+		}
+		seen[h] = true
+	}
+	if RecoveryCodeHash("ABCDE-FGHIJ") != RecoveryCodeHash("abcdefghij") {
+		t.Fatalf("recovery code hash not normalized")
+	}
+}
@@ -0,0 +1,78 @@
+package updates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+// StageBinary downloads and verifies the binary for version, goos and goarch with
+// FetchBinary, and writes it next to the binary at exe, with suffix ".new". A
+// previously staged binary is replaced. The path of the staged binary is
+// returned. If fetching or verifying fails, the staged binary is removed.
+func StageBinary(ctx context.Context, elog *slog.Logger, baseURL string, version Version, goos, goarch string, pubKey []byte, exe string) (staged string, rerr error) {
+	log := mlog.New("updates", elog)
+
+	// Stage the new binary in the same directory, so it can be renamed in place.
+	staged = exe + ".new"
+	if err := os.Remove(staged); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("removing previously staged binary: %v", err)
+	}
+	f, err := os.OpenFile(staged, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return "", fmt.Errorf("creating file for new binary: %v", err)
+	}
+	err = FetchBinary(ctx, elog, baseURL, version, goos, goarch, pubKey, f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if xerr := f.Close(); err == nil {
+		err = xerr
+	}
+	if err != nil {
+		xerr := os.Remove(staged)
+		log.Check(xerr, "removing staged binary", slog.String("path", staged))
+		return "", err
+	}
+	return staged, nil
+}
+
+// ReplaceBinary moves the staged binary in place of the binary at exe. The
+// current binary is kept with suffix ".old", its path is returned. After
+// replacing, check is called with exe, typically to verify the new binary starts.
+// If check fails, the old binary is restored and the error from check returned.
+// On errors, the staged binary is removed.
+func ReplaceBinary(elog *slog.Logger, exe, staged string, check func(path string) error) (old string, rerr error) {
+	log := mlog.New("updates", elog)
+
+	removeStaged := func() {
+		err := os.Remove(staged)
+		log.Check(err, "removing staged binary", slog.String("path", staged))
+	}
+
+	old = exe + ".old"
+	if err := os.Remove(old); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		removeStaged()
+		return "", fmt.Errorf("removing previous old binary: %v", err)
+	}
+	if err := os.Link(exe, old); err != nil {
+		removeStaged()
+		return "", fmt.Errorf("keeping current binary: %v", err)
+	}
+	if err := os.Rename(staged, exe); err != nil {
+		removeStaged()
+		return "", fmt.Errorf("moving new binary in place: %v", err)
+	}
+	if err := check(exe); err != nil {
+		if xerr := os.Rename(old, exe); xerr != nil {
+			return "", fmt.Errorf("new binary does not work (%v), and restoring old binary failed, move %s to %s manually: %v", err, old, exe, xerr)
+		}
+		return "", fmt.Errorf("new binary does not work, old binary restored: %w", err)
+	}
+	return old, nil
+}
@@ -6,16 +6,21 @@
 // changelog compared to a last known version can be retrieved. A changelog base
 // URL and public key for signatures has to be specified explicitly.
 //
-// Downloading or upgrading to the latest version is not part of this package.
+// A binary for a version and platform can be downloaded, verified against a
+// signed description with the same public key as used for the changelog, staged
+// next to the current binary, and moved in its place.
 package updates
 
 import (
 	"bytes"
 	"context"
 	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
@@ -44,6 +49,10 @@ var (
 
 	// Fetch changelog errors.
 	ErrChangelogFetch = errors.New("updates: fetching changelog")
+
+	// Fetch binary errors.
+	ErrBinaryFetch  = errors.New("updates: fetching binary")
+	ErrBinaryVerify = errors.New("updates: verifying binary")
 )
 
 // Change is a an entry in the changelog, a released version.
@@ -288,3 +297,165 @@ func ParseRecord(txt string) (record *Record, isupdates bool, err error) {
 	}
 	return r, true, nil
 }
+
+// Binary describes a released binary for a version and platform, and is returned
+// as JSON.
+//
+// The signed text has lines similar to email headers, with fields "version",
+// "goos", "goarch", "size" and "sha256" (hex). Including the version and platform
+// in the signed text prevents a binary for an older version or other platform
+// from being accepted.
+type Binary struct {
+	PubKey []byte // Key used for signing.
+	Sig    []byte // Signature over text, with ed25519.
+	Text   string
+}
+
+// BinaryInfo is the parsed signed text of a Binary.
+type BinaryInfo struct {
+	Version Version
+	GOOS    string
+	GOARCH  string
+	Size    int64
+	SHA256  []byte
+}
+
+// ParseBinaryText parses the signed text of a Binary.
+func ParseBinaryText(text string) (bi BinaryInfo, rerr error) {
+	seen := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			return BinaryInfo{}, fmt.Errorf("malformed line %q", line)
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		if seen[k] {
+			return BinaryInfo{}, fmt.Errorf("duplicate field %q", k)
+		}
+		seen[k] = true
+		var err error
+		switch k {
+		case "version":
+			bi.Version, err = ParseVersion(v)
+		case "goos":
+			bi.GOOS = v
+		case "goarch":
+			bi.GOARCH = v
+		case "size":
+			bi.Size, err = strconv.ParseInt(v, 10, 64)
+		case "sha256":
+			bi.SHA256, err = hex.DecodeString(v)
+			if err == nil && len(bi.SHA256) != sha256.Size {
+				err = fmt.Errorf("got %d bytes, expected %d", len(bi.SHA256), sha256.Size)
+			}
+		}
+		if err != nil {
+			return BinaryInfo{}, fmt.Errorf("parsing field %q: %v", k, err)
+		}
+	}
+	for _, k := range []string{"version", "goos", "goarch", "size", "sha256"} {
+		if !seen[k] {
+			return BinaryInfo{}, fmt.Errorf("missing field %q", k)
+		}
+	}
+	return bi, nil
+}
+
+// FetchBinary downloads the binary for version, goos and goarch, verifies it, and
+// writes it to dst.
+//
+// The signed description is requested using HTTP GET from
+// "<baseURL>/<version>/<goos>-<goarch>.json", the binary itself from the same URL
+// without ".json". The description must be signed with pubKey, and must match
+// the requested version and platform. The binary must match the size and SHA-256
+// hash from the description. If verification fails, an error is returned, and
+// dst may have been written to.
+//
+// A binary can be maximum 256 MB.
+func FetchBinary(ctx context.Context, elog *slog.Logger, baseURL string, version Version, goos, goarch string, pubKey []byte, dst io.Writer) (rerr error) {
+	log := mlog.New("updates", elog)
+	start := time.Now()
+	defer func() {
+		log.Debugx("updates fetch binary result", rerr,
+			slog.String("baseurl", baseURL),
+			slog.Any("version", version),
+			slog.String("goos", goos),
+			slog.String("goarch", goarch),
+			slog.Duration("duration", time.Since(start)))
+	}()
+
+	url := fmt.Sprintf("%s/%s/%s-%s", baseURL, version, goos, goarch)
+
+	nctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	var b Binary
+	if err := httpGet(nctx, log, url+".json", "application/json", func(r io.Reader) error {
+		return json.NewDecoder(&moxio.LimitReader{R: r, Limit: 64 * 1024}).Decode(&b)
+	}); err != nil {
+		return fmt.Errorf("%w: fetching signed binary description: %s", ErrBinaryFetch, err)
+	}
+	if !bytes.Equal(b.PubKey, pubKey) {
+		return fmt.Errorf("%w: signed with unknown public key %x instead of %x", ErrBinaryVerify, b.PubKey, pubKey)
+	}
+	if !ed25519.Verify(b.PubKey, []byte(b.Text), b.Sig) {
+		return fmt.Errorf("%w: invalid signature for binary description", ErrBinaryVerify)
+	}
+	bi, err := ParseBinaryText(b.Text)
+	if err != nil {
+		return fmt.Errorf("%w: parsing binary description: %s", ErrBinaryVerify, err)
+	}
+	if bi.Version != version || bi.GOOS != goos || bi.GOARCH != goarch {
+		return fmt.Errorf("%w: binary description is for %s %s/%s, expected %s %s/%s", ErrBinaryVerify, bi.Version, bi.GOOS, bi.GOARCH, version, goos, goarch)
+	}
+	const maxSize = 256 * 1024 * 1024
+	if bi.Size <= 0 || bi.Size > maxSize {
+		return fmt.Errorf("%w: binary size %d not within limits", ErrBinaryVerify, bi.Size)
+	}
+
+	bctx, bcancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer bcancel()
+	h := sha256.New()
+	var n int64
+	if err := httpGet(bctx, log, url, "application/octet-stream", func(r io.Reader) error {
+		var err error
+		n, err = io.Copy(io.MultiWriter(dst, h), io.LimitReader(r, bi.Size+1))
+		return err
+	}); err != nil {
+		return fmt.Errorf("%w: fetching binary: %s", ErrBinaryFetch, err)
+	}
+	if n != bi.Size {
+		return fmt.Errorf("%w: got %d bytes, expected %d", ErrBinaryVerify, n, bi.Size)
+	}
+	if sum := h.Sum(nil); !bytes.Equal(sum, bi.SHA256) {
+		return fmt.Errorf("%w: sha256 %x of binary does not match signed %x", ErrBinaryVerify, sum, bi.SHA256)
+	}
+	return nil
+}
+
+// httpGet does an HTTP GET request for url, and calls fn with the response body
+// for an OK status.
+func httpGet(ctx context.Context, log mlog.Log, url, accept string, fn func(r io.Reader) error) error {
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("making request: %v", err)
+	}
+	req.Header.Add("Accept", accept)
+	resp, err := http.DefaultClient.Do(req)
+	if resp == nil {
+		resp = &http.Response{StatusCode: 0}
+	}
+	HTTPClientObserve(ctx, log.Logger, "updates", req.Method, resp.StatusCode, err, start)
+	if err != nil {
+		return fmt.Errorf("making http request: %s", err)
+	}
+	defer func() {
+		err := resp.Body.Close()
+		log.Check(err, "closing http response body")
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("http status: %s", resp.Status)
+	}
+	return fn(resp.Body)
+}
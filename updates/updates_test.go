@@ -1,15 +1,22 @@
 package updates
 
 import (
+	"bytes"
 	"context"
 	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	golog "log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/mjl-/mox/dns"
@@ -153,3 +160,153 @@ func TestUpdates(t *testing.T) {
 	check("mox.example", Version{0, 0, 0}, "", 0, pub, Version{0, 0, 1}, &Record{Version: "UPDATES0", Latest: Version{0, 0, 1}}, nil, ErrChangelogFetch)
 	check("absent.example", Version{0, 0, 1}, "", 200, pub, Version{}, nil, nil, ErrNoRecord)
 }
+
+func TestFetchBinary(t *testing.T) {
+	log := mlog.New("updates", nil)
+
+	seed := make([]byte, ed25519.SeedSize)
+	priv := ed25519.NewKeyFromSeed(seed)
+	pub := []byte(priv.Public().(ed25519.PublicKey))
+
+	binary := []byte("binary contents")
+	sum := sha256.Sum256(binary)
+	text := fmt.Sprintf("version: v0.0.2\ngoos: linux\ngoarch: amd64\nsize: %d\nsha256: %x\n", len(binary), sum[:])
+	desc := Binary{PubKey: pub, Sig: ed25519.Sign(priv, []byte(text)), Text: text}
+
+	mux := &http.ServeMux{}
+	mux.HandleFunc("/v0.0.2/linux-amd64.json", func(w http.ResponseWriter, r *http.Request) {
+		err := json.NewEncoder(w).Encode(desc)
+		if err != nil {
+			t.Fatalf("encode binary description: %v", err)
+		}
+	})
+	mux.HandleFunc("/v0.0.2/linux-amd64", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(binary)
+	})
+	s := httptest.NewUnstartedServer(mux)
+	s.Config.ErrorLog = golog.New(io.Discard, "", 0)
+	s.Start()
+	defer s.Close()
+
+	fetch := func(version Version, goarch string, pubKey []byte, expErr error) {
+		t.Helper()
+
+		var buf bytes.Buffer
+		err := FetchBinary(context.Background(), log.Logger, s.URL, version, "linux", goarch, pubKey, &buf)
+		if (err == nil) != (expErr == nil) || err != nil && !errors.Is(err, expErr) {
+			t.Fatalf("fetch binary: got err %v, expected %v", err, expErr)
+		}
+		if err == nil && !bytes.Equal(buf.Bytes(), binary) {
+			t.Fatalf("fetch binary: got %q, expected %q", buf.Bytes(), binary)
+		}
+	}
+
+	fetch(Version{0, 0, 2}, "amd64", pub, nil)
+	fetch(Version{0, 0, 2}, "arm64", pub, ErrBinaryFetch)                                  // Not found.
+	fetch(Version{0, 0, 2}, "amd64", make([]byte, ed25519.PublicKeySize), ErrBinaryVerify) // Other public key.
+
+	// Description for another version than requested, e.g. a replayed older release.
+	mux.HandleFunc("/v0.0.3/linux-amd64.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(desc)
+	})
+	fetch(Version{0, 0, 3}, "amd64", pub, ErrBinaryVerify)
+
+	// Bad signature.
+	desc.Text = strings.Replace(text, "linux", "plan9", 1)
+	fetch(Version{0, 0, 2}, "amd64", pub, ErrBinaryVerify)
+	desc.Text = text
+
+	// Binary does not match hash.
+	binary = []byte("binary contentz")
+	fetch(Version{0, 0, 2}, "amd64", pub, ErrBinaryVerify)
+
+	// Binary larger than signed size.
+	binary = []byte("binary contents, with more")
+	fetch(Version{0, 0, 2}, "amd64", pub, ErrBinaryVerify)
+
+	if _, err := ParseBinaryText("version: v0.0.1\ngoos: linux\n"); err == nil {
+		t.Fatalf("parsing binary text with missing fields succeeded")
+	}
+}
+
+func TestInstall(t *testing.T) {
+	log := mlog.New("updates", nil)
+
+	seed := make([]byte, ed25519.SeedSize)
+	priv := ed25519.NewKeyFromSeed(seed)
+	pub := []byte(priv.Public().(ed25519.PublicKey))
+
+	binary := []byte("new binary")
+	sum := sha256.Sum256(binary)
+	text := fmt.Sprintf("version: v0.0.2\ngoos: linux\ngoarch: amd64\nsize: %d\nsha256: %x\n", len(binary), sum[:])
+	desc := Binary{PubKey: pub, Sig: ed25519.Sign(priv, []byte(text)), Text: text}
+
+	mux := &http.ServeMux{}
+	mux.HandleFunc("/v0.0.2/linux-amd64.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(desc)
+	})
+	mux.HandleFunc("/v0.0.2/linux-amd64", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(binary)
+	})
+	s := httptest.NewUnstartedServer(mux)
+	s.Config.ErrorLog = golog.New(io.Discard, "", 0)
+	s.Start()
+	defer s.Close()
+
+	tcheck := func(err error, msg string) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", msg, err)
+		}
+	}
+
+	exe := filepath.Join(t.TempDir(), "mox")
+	err := os.WriteFile(exe, []byte("old binary"), 0755)
+	tcheck(err, "write current binary")
+
+	checkFile := func(path, exp string) {
+		t.Helper()
+		buf, err := os.ReadFile(path)
+		tcheck(err, "read file")
+		if string(buf) != exp {
+			t.Fatalf("file %s has %q, expected %q", path, buf, exp)
+		}
+	}
+	checkAbsent := func(path string) {
+		t.Helper()
+		if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("file %s exists, expected absent (err %v)", path, err)
+		}
+	}
+
+	// Failed verification removes the staged binary.
+	_, err = StageBinary(context.Background(), log.Logger, s.URL, Version{0, 0, 2}, "linux", "amd64", make([]byte, ed25519.PublicKeySize), exe)
+	if !errors.Is(err, ErrBinaryVerify) {
+		t.Fatalf("stage binary with other public key: got err %v, expected ErrBinaryVerify", err)
+	}
+	checkAbsent(exe + ".new")
+
+	// Check of new binary fails, old binary is restored.
+	staged, err := StageBinary(context.Background(), log.Logger, s.URL, Version{0, 0, 2}, "linux", "amd64", pub, exe)
+	tcheck(err, "stage binary")
+	checkFile(staged, "new binary")
+	errCheck := errors.New("binary does not start")
+	_, err = ReplaceBinary(log.Logger, exe, staged, func(path string) error {
+		checkFile(path, "new binary")
+		return errCheck
+	})
+	if !errors.Is(err, errCheck) {
+		t.Fatalf("replace binary with failing check: got err %v, expected %v", err, errCheck)
+	}
+	checkFile(exe, "old binary")
+	checkAbsent(staged)
+
+	// Successful update, keeping the old binary.
+	staged, err = StageBinary(context.Background(), log.Logger, s.URL, Version{0, 0, 2}, "linux", "amd64", pub, exe)
+	tcheck(err, "stage binary")
+	old, err := ReplaceBinary(log.Logger, exe, staged, func(path string) error { return nil })
+	tcheck(err, "replace binary")
+	checkFile(exe, "new binary")
+	checkFile(old, "old binary")
+	checkAbsent(staged)
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -11,6 +14,9 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 
@@ -18,13 +24,26 @@ import (
 
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/junk"
+	"github.com/mjl-/mox/moxio"
 	"github.com/mjl-/mox/moxvar"
 	"github.com/mjl-/mox/mtastsdb"
 	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/rejectdb"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/tlsrptdb"
 )
 
+// verifySummary is printed by verifydata with the -json flag.
+type verifySummary struct {
+	DataDir  string
+	OK       bool     // Whether no errors were found.
+	Errors   int      // Number of errors found.
+	Warnings int      // Number of warnings.
+	Accounts []string // Verified accounts.
+	Messages int64    // Number of messages in verified accounts and queue.
+	Seconds  float64  // Duration of verification.
+}
+
 func cmdVerifydata(c *cmd) {
 	c.params = "data-dir"
 	c.help = `Verify the contents of a data directory, typically of a backup.
@@ -36,7 +55,8 @@ specified, unrecognized message files are moved away. This may be needed after
 a restore, because messages enqueued or delivered in the future may get those
 message sequence numbers assigned and writing the message file would fail.
 Consistency of message/mailbox UID, UIDNEXT and UIDVALIDITY is verified as
-well.
+well. Deduplicated message files are verified to have contents matching their
+hash.
 
 Because verifydata opens the database files, schema upgrades may automatically
 be applied. This can happen if you use a new mox release. It is useful to run
@@ -44,6 +64,13 @@ be applied. This can happen if you use a new mox release. It is useful to run
 copy of the database files, as made with "mox backup". Before upgrading, make a
 new backup again since "mox verifydata" may have upgraded the database files,
 possibly making them potentially no longer readable by the previous version.
+
+For large data directories, accounts can be verified in parallel with
+-parallel, a single account can be verified with -account (skipping the other
+databases and files), and checks of message files (existence, sizes and
+unrecognized files) can be skipped with -skip-message-files, only verifying the
+databases. With -json, a summary is printed in JSON format instead of a single
+line.
 `
 	var fix bool
 	c.flag.BoolVar(&fix, "fix", false, "fix fixable problems, such as moving away message files not referenced by their database")
@@ -53,14 +80,28 @@ possibly making them potentially no longer readable by the previous version.
 	var skipSizeCheck bool
 	c.flag.BoolVar(&skipSizeCheck, "skip-size-check", false, "skip the check for message size")
 
+	var skipMessageFiles bool
+	c.flag.BoolVar(&skipMessageFiles, "skip-message-files", false, "skip checks of message files, including existence, size and unrecognized files, and contents of deduplicated message files")
+	var parallel int
+	c.flag.IntVar(&parallel, "parallel", 1, "number of accounts to verify in parallel")
+	var accountName string
+	c.flag.StringVar(&accountName, "account", "", "only verify this account, not the other databases and files")
+	var jsonSummary bool
+	c.flag.BoolVar(&jsonSummary, "json", false, "print summary in JSON format")
+
 	args := c.Parse()
 	if len(args) != 1 {
 		c.Usage()
 	}
 
+	if parallel < 1 {
+		c.Usage()
+	}
+
 	dataDir := filepath.Clean(args[0])
 
 	ctxbg := context.Background()
+	start := time.Now()
 
 	// Check whether file exists, or rather, that it doesn't not exist. Other errors
 	// will return true as well, so the triggered check can give the details.
@@ -69,22 +110,36 @@ possibly making them potentially no longer readable by the previous version.
 		return err == nil || !os.IsNotExist(err)
 	}
 
-	// Check for error. If so, write a log line, including the path, and set fail so we
-	// can warn at the end.
-	var fail bool
+	// Check for error. If so, write a log line, including the path, and count it so
+	// we can warn at the end. Accounts can be checked in parallel, so counts are
+	// protected by a mutex.
+	var countsMutex sync.Mutex
+	var nerrors, nwarnings int
 	checkf := func(err error, path, format string, args ...any) {
 		if err == nil {
 			return
 		}
-		fail = true
+		countsMutex.Lock()
+		nerrors++
+		countsMutex.Unlock()
 		log.Printf("error: %s: %s: %v", path, fmt.Sprintf(format, args...), err)
 	}
+	warnf := func(format string, args ...any) {
+		countsMutex.Lock()
+		nwarnings++
+		countsMutex.Unlock()
+		log.Printf("warning: "+format, args...)
+	}
+	var nmessages atomic.Int64
 
 	// When we fix problems, we may have to move files/dirs. We need to ensure the
 	// directory of the destination path exists before we move. We keep track of
 	// created dirs so we don't try to create the same directory all the time.
+	var createdDirsMutex sync.Mutex
 	createdDirs := map[string]struct{}{}
 	ensureDir := func(path string) {
+		createdDirsMutex.Lock()
+		defer createdDirsMutex.Unlock()
 		dir := filepath.Dir(path)
 		if _, ok := createdDirs[dir]; ok {
 			return
@@ -155,11 +210,13 @@ possibly making them potentially no longer readable by the previous version.
 	}
 
 	checkFile := func(dbpath, path string, prefixSize int, size int64) {
-		st, err := os.Stat(path)
+		if skipMessageFiles {
+			return
+		}
+		filesize, err := store.MessageFileSize(path)
 		checkf(err, path, "checking if file exists")
-		if !skipSizeCheck && err == nil && int64(prefixSize)+st.Size() != size {
-			filesize := st.Size()
-			checkf(fmt.Errorf("%s: message size is %d, should be %d (length of MsgPrefix %d + file size %d), see \"mox fixmsgsize\"", path, size, int64(prefixSize)+st.Size(), prefixSize, filesize), dbpath, "checking message size")
+		if !skipSizeCheck && err == nil && int64(prefixSize)+filesize != size {
+			checkf(fmt.Errorf("%s: message size is %d, should be %d (length of MsgPrefix %d + file size %d), see \"mox fixmsgsize\"", path, size, int64(prefixSize)+filesize, prefixSize, filesize), dbpath, "checking message size")
 		}
 	}
 
@@ -173,7 +230,13 @@ possibly making them potentially no longer readable by the previous version.
 		db, err := bstore.Open(ctxbg, dbpath, &opts, queue.DBTypes...)
 		checkf(err, dbpath, "opening queue database to check messages")
 		if err == nil {
+			defer func() {
+				if err := db.Close(); err != nil {
+					log.Printf("closing database file: %v", err)
+				}
+			}()
 			err := bstore.QueryDB[queue.Msg](ctxbg, db).ForEach(func(m queue.Msg) error {
+				nmessages.Add(1)
 				mp := store.MessagePath(m.ID)
 				seen[mp] = struct{}{}
 				p := filepath.Join(dataDir, "queue", mp)
@@ -183,6 +246,10 @@ possibly making them potentially no longer readable by the previous version.
 			checkf(err, dbpath, "reading messages in queue database to check files")
 		}
 
+		if skipMessageFiles {
+			return
+		}
+
 		// Check that there are no files that could be treated as a message.
 		qdir := filepath.Join(dataDir, "queue")
 		err = filepath.WalkDir(qdir, func(qpath string, d fs.DirEntry, err error) error {
@@ -202,14 +269,14 @@ possibly making them potentially no longer readable by the previous version.
 			}
 			l := strings.Split(p, string(filepath.Separator))
 			if len(l) == 1 {
-				log.Printf("warning: %s: unrecognized file in queue directory, ignoring", qpath)
+				warnf("%s: unrecognized file in queue directory, ignoring", qpath)
 				return nil
 			}
 			// If it doesn't look like a message number, there is no risk of it being the name
 			// of a message enqueued in the future.
 			if len(l) >= 3 {
 				if _, err := strconv.ParseInt(l[1], 10, 64); err != nil {
-					log.Printf("warning: %s: unrecognized file in queue directory, ignoring", qpath)
+					warnf("%s: unrecognized file in queue directory, ignoring", qpath)
 					return nil
 				}
 			}
@@ -222,7 +289,7 @@ possibly making them potentially no longer readable by the previous version.
 			err = os.Rename(qpath, npath)
 			checkf(err, qpath, "moving queue message file away")
 			if err == nil {
-				log.Printf("warning: moved %s to %s", qpath, npath)
+				warnf("moved %s to %s", qpath, npath)
 			}
 			return nil
 		})
@@ -249,6 +316,11 @@ possibly making them potentially no longer readable by the previous version.
 		db, err := bstore.Open(ctxbg, dbpath, &opts, store.DBTypes...)
 		checkf(err, dbpath, "opening account database to check messages")
 		if err == nil {
+			defer func() {
+				if err := db.Close(); err != nil {
+					log.Printf("closing database file: %v", err)
+				}
+			}()
 			uidvalidity := store.NextUIDValidity{ID: 1}
 			if err := db.Get(ctxbg, &uidvalidity); err != nil {
 				checkf(err, dbpath, "missing nextuidvalidity")
@@ -256,9 +328,9 @@ possibly making them potentially no longer readable by the previous version.
 
 			up := store.Upgrade{ID: 1}
 			if err := db.Get(ctxbg, &up); err != nil {
-				log.Printf("warning: %s: getting upgrade record (continuing, but not checking message threading): %v", dbpath, err)
+				warnf("%s: getting upgrade record (continuing, but not checking message threading): %v", dbpath, err)
 			} else if up.Threads != 2 {
-				log.Printf("warning: %s: no message threading in database, skipping checks for threading consistency", dbpath)
+				warnf("%s: no message threading in database, skipping checks for threading consistency", dbpath)
 			}
 
 			mailboxes := map[int64]store.Mailbox{}
@@ -275,6 +347,7 @@ possibly making them potentially no longer readable by the previous version.
 			mbCounts := map[int64]store.MailboxCounts{}
 			var totalSize int64
 			err = bstore.QueryDB[store.Message](ctxbg, db).ForEach(func(m store.Message) error {
+				nmessages.Add(1)
 				mb := mailboxes[m.MailboxID]
 				if m.UID >= mb.UIDNext {
 					checkf(errors.New(`inconsistent uidnext for message/mailbox, see "mox fixuidmeta"`), dbpath, "message id %d in mailbox %q (id %d) has uid %d >= mailbox uidnext %d", m.ID, mb.Name, mb.ID, m.UID, mb.UIDNext)
@@ -357,7 +430,7 @@ possibly making them potentially no longer readable by the previous version.
 		// Walk through all files in the msg directory. Warn about files that weren't in
 		// the database as message file. Possibly move away files that could cause trouble.
 		msgdir := filepath.Join(accdir, "msg")
-		if !exists(msgdir) {
+		if skipMessageFiles || !exists(msgdir) {
 			// New accounts with messages don't have a msg directory.
 			return
 		}
@@ -375,7 +448,7 @@ possibly making them potentially no longer readable by the previous version.
 			}
 			l := strings.Split(p, string(filepath.Separator))
 			if len(l) == 1 {
-				log.Printf("warning: %s: unrecognized file in message directory, ignoring", msgpath)
+				warnf("%s: unrecognized file in message directory, ignoring", msgpath)
 				return nil
 			}
 			if !fix {
@@ -387,18 +460,41 @@ possibly making them potentially no longer readable by the previous version.
 			err = os.Rename(msgpath, npath)
 			checkf(err, msgpath, "moving account message file away")
 			if err == nil {
-				log.Printf("warning: moved %s to %s", msgpath, npath)
+				warnf("moved %s to %s", msgpath, npath)
 			}
 			return nil
 		})
 		checkf(err, msgdir, "walking account message directory")
 	}
 
+	// Check accounts, with -parallel accounts at a time.
+	var accounts []string
+	checkAccountsParallel := func(names []string) {
+		accounts = names
+		work := make(chan string)
+		var wg sync.WaitGroup
+		for range min(parallel, len(names)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for name := range work {
+					checkAccount(name)
+				}
+			}()
+		}
+		for _, name := range names {
+			work <- name
+		}
+		close(work)
+		wg.Wait()
+	}
+
 	// Check everything in the "accounts" directory.
 	checkAccounts := func() {
 		accountsDir := filepath.Join(dataDir, "accounts")
 		entries, err := os.ReadDir(accountsDir)
 		checkf(err, accountsDir, "reading accounts directory")
+		var names []string
 		for _, e := range entries {
 			// We treat all directories as accounts. When we were backing up, we only verified
 			// accounts from the config and made regular file copies of all other files
@@ -406,11 +502,59 @@ possibly making them potentially no longer readable by the previous version.
 			// turn out that that account was/is not valid, generating warnings. Better safe
 			// than sorry. It should hopefully get the admin to move away such an old account.
 			if e.IsDir() {
-				checkAccount(e.Name())
+				names = append(names, e.Name())
 			} else {
-				log.Printf("warning: %s: unrecognized file in accounts directory, ignoring", filepath.Join("accounts", e.Name()))
+				warnf("%s: unrecognized file in accounts directory, ignoring", filepath.Join("accounts", e.Name()))
 			}
 		}
+		checkAccountsParallel(names)
+	}
+
+	// Check the deduplicated message files in the "msgdedup" directory. Files must be
+	// named after the hash of their contents. Files not linked from an account are
+	// removed by mox eventually, we only warn about them.
+	checkDedup := func() {
+		dedupDir := filepath.Join(dataDir, store.DedupDir)
+		err := filepath.WalkDir(dedupDir, func(dpath string, d fs.DirEntry, err error) error {
+			checkf(err, dpath, "walk")
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			p := dpath[len(dedupDir)+1:]
+			hash := filepath.Base(p)
+			if buf, err := hex.DecodeString(hash); err != nil || len(buf) != sha256.Size || p != store.DedupPath(hash) {
+				checkf(errors.New("not a deduplicated message file"), dpath, "unrecognized file in message dedup directory")
+				return nil
+			}
+
+			f, err := os.Open(dpath)
+			checkf(err, dpath, "open deduplicated message file")
+			if err != nil {
+				return nil
+			}
+			defer f.Close()
+			if fi, err := f.Stat(); err != nil {
+				checkf(err, dpath, "stat deduplicated message file")
+			} else if n, ok := moxio.LinkCount(fi); ok && n <= 1 {
+				warnf("%s: deduplicated message file not used by any message, will be removed by mox", dpath)
+			}
+			h, err := store.MessageFileHash(f)
+			if err != nil {
+				checkf(err, dpath, "hashing deduplicated message file")
+			} else if h != hash {
+				if !fix {
+					checkf(fmt.Errorf("file has hash %s", h), dpath, "deduplicated message file has wrong contents, future messages may link to it (use the -fix flag to remove it, messages linking to it keep their contents)")
+					return nil
+				}
+				err := os.Remove(dpath)
+				checkf(err, dpath, "removing deduplicated message file with wrong contents")
+				if err == nil {
+					warnf("removed deduplicated message file %s with wrong contents", dpath)
+				}
+			}
+			return nil
+		})
+		checkf(err, dedupDir, "walking message dedup directory")
 	}
 
 	// Check all files, skipping the known files, queue and accounts directories. Warn
@@ -431,9 +575,9 @@ possibly making them potentially no longer readable by the previous version.
 				p = p[len(dataDir)+1:]
 			}
 			switch p {
-			case "auth.db", "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "receivedid.key", "lastknownversion":
+			case "auth.db", "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "rejected.db", "receivedid.key", "lastknownversion":
 				return nil
-			case "acme", "queue", "accounts", "tmp", "moved":
+			case "acme", "queue", "accounts", "tmp", "moved", store.DedupDir:
 				return fs.SkipDir
 			case "moxversion":
 				buf, err := os.ReadFile(dpath)
@@ -443,29 +587,57 @@ possibly making them potentially no longer readable by the previous version.
 				}
 				return nil
 			}
-			log.Printf("warning: %s: unrecognized other file, ignoring", dpath)
+			warnf("%s: unrecognized other file, ignoring", dpath)
 			return nil
 		})
 		checkf(err, dataDir, "walking data directory")
 	}
 
-	checkDB(false, filepath.Join(dataDir, "auth.db"), store.AuthDBTypes) // Since v0.0.14.
-	checkDB(true, filepath.Join(dataDir, "dmarcrpt.db"), dmarcdb.ReportsDBTypes)
-	checkDB(false, filepath.Join(dataDir, "dmarceval.db"), dmarcdb.EvalDBTypes) // After v0.0.7.
-	checkDB(true, filepath.Join(dataDir, "mtasts.db"), mtastsdb.DBTypes)
-	checkDB(true, filepath.Join(dataDir, "tlsrpt.db"), tlsrptdb.ReportDBTypes)
-	checkDB(false, filepath.Join(dataDir, "tlsrptresult.db"), tlsrptdb.ResultDBTypes) // After v0.0.7.
-	checkQueue()
-	checkAccounts()
-	checkOther()
-
-	if backupmoxversion != moxvar.Version {
-		log.Printf("NOTE: The backup was made with mox version %q, while verifydata was run with mox version %q. Database files have probably been modified by running mox verifydata. Make a fresh backup before upgrading.", backupmoxversion, moxvar.Version)
+	if accountName != "" {
+		accdir := filepath.Join(dataDir, "accounts", accountName)
+		if fi, err := os.Stat(accdir); err != nil || !fi.IsDir() {
+			log.Fatalf("%s: account directory not found", accdir)
+		}
+		checkAccountsParallel([]string{accountName})
+	} else {
+		checkDB(false, filepath.Join(dataDir, "auth.db"), store.AuthDBTypes) // Since v0.0.14.
+		checkDB(true, filepath.Join(dataDir, "dmarcrpt.db"), dmarcdb.ReportsDBTypes)
+		checkDB(false, filepath.Join(dataDir, "dmarceval.db"), dmarcdb.EvalDBTypes) // After v0.0.7.
+		checkDB(true, filepath.Join(dataDir, "mtasts.db"), mtastsdb.DBTypes)
+		checkDB(true, filepath.Join(dataDir, "tlsrpt.db"), tlsrptdb.ReportDBTypes)
+		checkDB(false, filepath.Join(dataDir, "tlsrptresult.db"), tlsrptdb.ResultDBTypes) // After v0.0.7.
+		checkDB(false, filepath.Join(dataDir, "rejected.db"), rejectdb.DBTypes)
+		checkQueue()
+		checkAccounts()
+		if !skipMessageFiles && exists(filepath.Join(dataDir, store.DedupDir)) {
+			checkDedup()
+		}
+		checkOther()
+
+		if backupmoxversion != moxvar.Version {
+			log.Printf("NOTE: The backup was made with mox version %q, while verifydata was run with mox version %q. Database files have probably been modified by running mox verifydata. Make a fresh backup before upgrading.", backupmoxversion, moxvar.Version)
+		}
+	}
+
+	if jsonSummary {
+		summary := verifySummary{
+			DataDir:  dataDir,
+			OK:       nerrors == 0,
+			Errors:   nerrors,
+			Warnings: nwarnings,
+			Accounts: accounts,
+			Messages: nmessages.Load(),
+			Seconds:  time.Since(start).Seconds(),
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		err := enc.Encode(summary)
+		xcheckf(err, "write summary")
 	}
 
-	if fail {
+	if nerrors > 0 {
 		log.Fatalf("errors were found")
-	} else {
+	} else if !jsonSummary {
 		fmt.Printf("%s: OK\n", dataDir)
 	}
 }
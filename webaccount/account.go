@@ -34,9 +34,11 @@ import (
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/openpgp"
 	"github.com/mjl-/mox/queue"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/totp"
 	"github.com/mjl-/mox/webapi"
 	"github.com/mjl-/mox/webauth"
 	"github.com/mjl-/mox/webhook"
@@ -64,6 +66,12 @@ var webaccountFile = &mox.WebappFile{
 
 var accountDoc = mustParseAPI("account", accountapiJSON)
 
+// APIDocJSON returns the sherpadoc documentation of the account API in JSON, as served
+// at api/sherpadoc.json. The returned slice must not be modified.
+func APIDocJSON() []byte {
+	return accountapiJSON
+}
+
 func mustParseAPI(api string, buf []byte) (doc sherpadoc.Section) {
 	err := json.Unmarshal(buf, &doc)
 	if err != nil {
@@ -224,6 +232,16 @@ func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r
 			http.Error(w, "405 - method not allowed - use get", http.StatusMethodNotAllowed)
 		}
 		return
+	} else if r.URL.Path == "/api/sherpadoc.json" {
+		// API documentation, for generating clients, e.g. with "mox genapi".
+		switch r.Method {
+		case "GET", "HEAD":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Write(accountapiJSON)
+		default:
+			http.Error(w, "405 - method not allowed - use get", http.StatusMethodNotAllowed)
+		}
+		return
 	}
 
 	isAPI := strings.HasPrefix(r.URL.Path, "/api/")
@@ -347,12 +365,14 @@ func (w Account) LoginPrep(ctx context.Context) string {
 }
 
 // Login returns a session token for the credentials, or fails with error code
-// "user:badLogin". Call LoginPrep to get a loginToken.
-func (w Account) Login(ctx context.Context, loginToken, username, password string) store.CSRFToken {
+// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+// authentication is enabled and totp is empty, the call fails with error code
+// "user:totpRequired", and must be repeated with a TOTP code or recovery code.
+func (w Account) Login(ctx context.Context, loginToken, username, password, totp string) store.CSRFToken {
 	log := pkglog.WithContext(ctx)
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 
-	csrfToken, err := webauth.Login(ctx, log, webauth.Accounts, "webaccount", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, username, password)
+	csrfToken, err := webauth.Login(ctx, log, webauth.Accounts, "webaccount", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, username, password, totp)
 	if _, ok := err.(*sherpa.Error); ok {
 		panic(err)
 	}
@@ -404,7 +424,7 @@ func (Account) SetPassword(ctx context.Context, password string) {
 	}
 
 	// Retrieve session, resetting password invalidates it.
-	ls, err := store.SessionUse(ctx, log, reqInfo.AccountName, reqInfo.SessionToken, "")
+	ls, err := store.SessionUse(ctx, log, reqInfo.AccountName, reqInfo.SessionToken, "", "", "")
 	xcheckf(ctx, err, "get session")
 
 	err = acc.SetPassword(log, password)
@@ -431,7 +451,7 @@ func (Account) GeneratePassword(ctx context.Context) (password string) {
 	password = mox.GeneratePassword()
 
 	// Retrieve session, resetting password invalidates it.
-	ls, err := store.SessionUse(ctx, log, reqInfo.AccountName, reqInfo.SessionToken, "")
+	ls, err := store.SessionUse(ctx, log, reqInfo.AccountName, reqInfo.SessionToken, "", "", "")
 	xcheckf(ctx, err, "get session")
 
 	err = acc.SetPassword(log, password)
@@ -708,16 +728,154 @@ func (Account) JunkFilterSave(ctx context.Context, junkFilter *config.JunkFilter
 	xcheckf(ctx, err, "saving account junk filter settings")
 }
 
-// RejectsSave saves the RejectsMailbox and KeepRejects settings.
-func (Account) RejectsSave(ctx context.Context, mailbox string, keep bool) {
+// RejectsSave saves the RejectsMailbox, KeepRejects and RejectsRescueAllow
+// settings.
+func (Account) RejectsSave(ctx context.Context, mailbox string, keep bool, rescueAllow string) {
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	switch rescueAllow {
+	case "", "address", "domain":
+	default:
+		xcheckuserf(ctx, fmt.Errorf("invalid value %q", rescueAllow), "checking sender allow setting")
+	}
 	err := admin.AccountSave(ctx, reqInfo.AccountName, func(acc *config.Account) {
 		acc.RejectsMailbox = mailbox
 		acc.KeepRejects = keep
+		acc.RejectsRescueAllow = rescueAllow
 	})
 	xcheckf(ctx, err, "saving account rejects settings")
 }
 
+// MailboxRetentionSave saves the policies for removing old messages from
+// mailboxes.
+func (Account) MailboxRetentionSave(ctx context.Context, retention []config.MailboxRetention) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	seen := map[string]bool{}
+	for _, mr := range retention {
+		if mr.Mailbox == "" {
+			xcheckuserf(ctx, errors.New("missing mailbox"), "checking mailbox retention")
+		} else if seen[mr.Mailbox] {
+			xcheckuserf(ctx, fmt.Errorf("duplicate mailbox %q", mr.Mailbox), "checking mailbox retention")
+		} else if mr.Period < time.Hour {
+			xcheckuserf(ctx, fmt.Errorf("period for mailbox %q must be at least 1h", mr.Mailbox), "checking mailbox retention")
+		}
+		seen[mr.Mailbox] = true
+	}
+	err := admin.AccountSave(ctx, reqInfo.AccountName, func(acc *config.Account) {
+		acc.MailboxRetention = retention
+	})
+	xcheckf(ctx, err, "saving mailbox retention")
+}
+
+// SenderAllows returns the sender allow entries, for senders whose messages with
+// verified From address are accepted without junk filtering.
+func (Account) SenderAllows(ctx context.Context) []store.SenderAllow {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName, false)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	l, err := bstore.QueryDB[store.SenderAllow](ctx, acc.DB).SortAsc("Domain", "Localpart").List()
+	xcheckf(ctx, err, "listing sender allow entries")
+	return l
+}
+
+// SenderAllowRemove removes a sender allow entry.
+func (Account) SenderAllowRemove(ctx context.Context, id int64) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName, false)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.DB.Delete(ctx, &store.SenderAllow{ID: id})
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, errors.New("no such entry"), "removing sender allow entry")
+	}
+	xcheckf(ctx, err, "removing sender allow entry")
+}
+
+// JunkRescanDryRun evaluates messages received in the Inbox and Junk mailbox in
+// the past days against the current junk filter and sender allow list, returning
+// the messages that a junk rescan would move to the other mailbox. No messages are
+// moved.
+func (Account) JunkRescanDryRun(ctx context.Context, days int) []webops.JunkRescanMove {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	since := xjunkRescanSince(ctx, days)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName, false)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	moves, err := webops.JunkRescan(ctx, log, acc, since, true, 0)
+	xcheckf(ctx, err, "evaluating messages")
+	return moves
+}
+
+// JunkRescanStart starts a background junk rescan, moving the messages that
+// JunkRescanDryRun would return. Messages are evaluated slowly to limit the load
+// on the server. Only one rescan can be in progress per account.
+func (Account) JunkRescanStart(ctx context.Context, days int) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	since := xjunkRescanSince(ctx, days)
+	err := webops.JunkRescanStart(log, reqInfo.AccountName, since)
+	if errors.Is(err, webops.ErrJunkRescanRunning) {
+		xcheckuserf(ctx, err, "starting junk rescan")
+	}
+	xcheckf(ctx, err, "starting junk rescan")
+}
+
+func xjunkRescanSince(ctx context.Context, days int) time.Time {
+	if days < 1 || days > 90 {
+		xcheckuserf(ctx, errors.New("days must be between 1 and 90"), "checking period")
+	}
+	return time.Now().AddDate(0, 0, -days)
+}
+
+// Vacation returns the settings for automatic replies to incoming messages.
+func (Account) Vacation(ctx context.Context) store.Vacation {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName, false)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	v, err := acc.VacationGet(ctx)
+	xcheckf(ctx, err, "get vacation settings")
+	return v
+}
+
+// VacationSave saves the settings for automatic replies. Senders that already
+// received an automatic reply will get a new reply on their next message.
+func (Account) VacationSave(ctx context.Context, v store.Vacation) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName, false)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = v.Check()
+	xcheckuserf(ctx, err, "checking vacation settings")
+	err = acc.VacationSave(ctx, v)
+	xcheckf(ctx, err, "saving vacation settings")
+}
+
 func (Account) TLSPublicKeys(ctx context.Context) ([]store.TLSPublicKey, error) {
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 	return store.TLSPublicKeyList(ctx, reqInfo.AccountName)
@@ -802,6 +960,352 @@ func (Account) LoginAttempts(ctx context.Context, limit int) []store.LoginAttemp
 	return l
 }
 
+// Sessions returns the active login sessions for the account and mail web
+// interfaces, most recently used first, and the ID of the session of the
+// request.
+func (Account) Sessions(ctx context.Context) (sessions []store.LoginSession, currentID int64) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	l, currentID, err := store.SessionList(ctx, log, reqInfo.AccountName, reqInfo.SessionToken)
+	xcheckf(ctx, err, "listing sessions")
+	return l, currentID
+}
+
+// SessionRevoke removes a login session, it can no longer be used. The session
+// of the request cannot be revoked, use Logout instead.
+func (Account) SessionRevoke(ctx context.Context, id int64) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	_, currentID, err := store.SessionList(ctx, log, reqInfo.AccountName, reqInfo.SessionToken)
+	xcheckf(ctx, err, "listing sessions")
+	if id == currentID {
+		xcheckuserf(ctx, errors.New("cannot revoke current session, log out instead"), "revoking session")
+	}
+	err = store.SessionRemoveID(ctx, log, reqInfo.AccountName, id)
+	if err == store.ErrSessionUnknown {
+		xcheckuserf(ctx, err, "revoking session")
+	}
+	xcheckf(ctx, err, "revoking session")
+}
+
+// SessionsRevokeOthers removes all login sessions except the session of the
+// request, and returns the number of revoked sessions.
+func (Account) SessionsRevokeOthers(ctx context.Context) (revoked int) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	n, err := store.SessionRemoveOthers(ctx, log, reqInfo.AccountName, reqInfo.SessionToken)
+	xcheckf(ctx, err, "revoking sessions")
+	return n
+}
+
+// MessageShares returns the share links for messages, created through webmail,
+// with the accesses of each link.
+func (Account) MessageShares(ctx context.Context) (shares []store.MessageShare, accesses [][]store.MessageShareAccess) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	shares, accesses, err := store.MessageShareList(ctx, reqInfo.AccountName)
+	xcheckf(ctx, err, "listing share links")
+	return shares, accesses
+}
+
+// MessageShareRevoke removes a share link, it can no longer be used.
+func (Account) MessageShareRevoke(ctx context.Context, id int64) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	err := store.MessageShareRemove(ctx, reqInfo.AccountName, id)
+	if err == store.ErrMessageShareUnknown {
+		xcheckuserf(ctx, err, "revoking share link")
+	}
+	xcheckf(ctx, err, "revoking share link")
+}
+
+// OpenPGPKeys returns the OpenPGP public keys of the account, as published
+// through WKD and OPENPGPKEY DNS records.
+func (Account) OpenPGPKeys(ctx context.Context) []store.OpenPGPKey {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	l, err := store.OpenPGPKeyList(ctx, reqInfo.AccountName)
+	xcheckf(ctx, err, "listing openpgp keys")
+	return l
+}
+
+// OpenPGPKeySet sets the OpenPGP public key for an address of the account,
+// replacing any existing key for the address. The key can be ASCII-armored, and
+// must have a user ID with the address.
+func (Account) OpenPGPKeySet(ctx context.Context, address, key string) store.OpenPGPKey {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	addr, err := smtp.ParseAddress(address)
+	xcheckuserf(ctx, err, "parsing address")
+	accName, _, canonical, _, err := mox.LookupAddress(addr.Localpart, addr.Domain, false, false, false)
+	if err == nil && accName != reqInfo.AccountName {
+		err = mox.ErrAddressNotFound
+	}
+	if errors.Is(err, mox.ErrAddressNotFound) || errors.Is(err, mox.ErrDomainNotFound) {
+		xcheckuserf(ctx, errors.New("address not found for account"), "looking up address")
+	}
+	xcheckf(ctx, err, "looking up address")
+	addr, err = smtp.ParseAddress(canonical)
+	xcheckf(ctx, err, "parsing canonical address")
+
+	pk, err := store.ParseOpenPGPKey(addr, []byte(key))
+	xcheckuserf(ctx, err, "parsing openpgp key")
+	pk.Account = reqInfo.AccountName
+	err = store.OpenPGPKeySet(ctx, &pk)
+	xcheckf(ctx, err, "saving openpgp key")
+	return pk
+}
+
+// OpenPGPKeyRemove removes the OpenPGP public key for an address of the account.
+func (Account) OpenPGPKeyRemove(ctx context.Context, address string) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	err := store.OpenPGPKeyRemove(ctx, reqInfo.AccountName, address)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing openpgp key")
+	}
+	xcheckf(ctx, err, "removing openpgp key")
+}
+
+// OpenPGPKeyring returns the OpenPGP public keys of correspondents in the keyring
+// of the account, used for verifying signatures of incoming messages.
+func (Account) OpenPGPKeyring(ctx context.Context) []store.OpenPGPKeyringKey {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName, false)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	l, err := acc.OpenPGPKeyringList(ctx)
+	xcheckf(ctx, err, "listing openpgp keyring")
+	return l
+}
+
+// OpenPGPKeyringAdd adds an OpenPGP public key to the keyring of the account,
+// replacing a key with the same fingerprint. The key can be ASCII-armored.
+func (Account) OpenPGPKeyringAdd(ctx context.Context, key string) store.OpenPGPKeyringKey {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName, false)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	kk, err := acc.OpenPGPKeyringAdd(ctx, []byte(key))
+	if errors.Is(err, openpgp.ErrKey) {
+		xcheckuserf(ctx, err, "parsing openpgp key")
+	}
+	xcheckf(ctx, err, "adding openpgp key to keyring")
+	return kk
+}
+
+// OpenPGPKeyringRemove removes the key with the fingerprint from the keyring of
+// the account.
+func (Account) OpenPGPKeyringRemove(ctx context.Context, fingerprint string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName, false)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.OpenPGPKeyringRemove(ctx, fingerprint)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing openpgp key from keyring")
+	}
+	xcheckf(ctx, err, "removing openpgp key from keyring")
+}
+
+// OAuthTokens returns the OAuth bearer tokens of the account.
+func (Account) OAuthTokens(ctx context.Context) []store.OAuthToken {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	l, err := store.OAuthTokenList(ctx, reqInfo.AccountName)
+	xcheckf(ctx, err, "listing oauth tokens")
+	return l
+}
+
+// OAuthTokenAdd creates a new OAuth bearer token for authenticating as an address
+// of the account with the OAUTHBEARER or XOAUTH2 mechanisms, for IMAP and SMTP
+// submission, so email applications don't have to store the password. If
+// validDays is > 0, the token expires after that many days. The returned token
+// cannot be retrieved later.
+func (Account) OAuthTokenAdd(ctx context.Context, name, loginAddress string, validDays int) string {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	if name == "" {
+		xcheckuserf(ctx, errors.New("name required"), "checking name")
+	}
+	if validDays < 0 {
+		xcheckuserf(ctx, errors.New("must be >= 0"), "checking valid days")
+	}
+	addr, err := smtp.ParseAddress(loginAddress)
+	xcheckuserf(ctx, err, "parsing address")
+	accName, _, canonical, _, err := mox.LookupAddress(addr.Localpart, addr.Domain, false, false, false)
+	if err == nil && accName != reqInfo.AccountName {
+		err = mox.ErrAddressNotFound
+	}
+	if errors.Is(err, mox.ErrAddressNotFound) || errors.Is(err, mox.ErrDomainNotFound) {
+		xcheckuserf(ctx, errors.New("address not found for account"), "looking up address")
+	}
+	xcheckf(ctx, err, "looking up address")
+
+	t := store.OAuthToken{
+		Account:      reqInfo.AccountName,
+		Name:         name,
+		LoginAddress: canonical,
+	}
+	if validDays > 0 {
+		t.Expires = time.Now().Add(time.Duration(validDays) * 24 * time.Hour)
+	}
+	token, err := store.OAuthTokenAdd(ctx, &t)
+	xcheckf(ctx, err, "adding oauth token")
+	return token
+}
+
+// OAuthTokenRemove removes an OAuth bearer token of the account.
+func (Account) OAuthTokenRemove(ctx context.Context, id int64) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	err := store.OAuthTokenRemove(ctx, reqInfo.AccountName, id)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing oauth token")
+	}
+	xcheckf(ctx, err, "removing oauth token")
+}
+
+// APITokens returns the API tokens of the account.
+func (Account) APITokens(ctx context.Context) []store.APIToken {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	l, err := store.APITokenList(ctx, reqInfo.AccountName)
+	xcheckf(ctx, err, "listing api tokens")
+	return l
+}
+
+// APITokenAdd creates a new API token, for use instead of the password for any
+// login address of the account. Tokens with scope "read" give read-only access
+// with IMAP and the webapi, tokens with scope "send" can only send messages with
+// SMTP submission and the webapi. If validDays is > 0, the token expires after
+// that many days. The returned token cannot be retrieved later.
+func (Account) APITokenAdd(ctx context.Context, name string, scope store.APITokenScope, validDays int) string {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	if name == "" {
+		xcheckuserf(ctx, errors.New("name required"), "checking name")
+	}
+	if scope != store.APITokenScopeRead && scope != store.APITokenScopeSend {
+		xcheckuserf(ctx, fmt.Errorf("unknown scope %q", scope), "checking scope")
+	}
+	if validDays < 0 {
+		xcheckuserf(ctx, errors.New("must be >= 0"), "checking valid days")
+	}
+
+	t := store.APIToken{
+		Account: reqInfo.AccountName,
+		Name:    name,
+		Scope:   scope,
+	}
+	if validDays > 0 {
+		t.Expires = time.Now().Add(time.Duration(validDays) * 24 * time.Hour)
+	}
+	token, err := store.APITokenAdd(ctx, &t)
+	xcheckf(ctx, err, "adding api token")
+	return token
+}
+
+// APITokenRemove removes an API token of the account.
+func (Account) APITokenRemove(ctx context.Context, id int64) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	err := store.APITokenRemove(ctx, reqInfo.AccountName, id)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing api token")
+	}
+	xcheckf(ctx, err, "removing api token")
+}
+
+// TOTPStatus returns whether two-factor authentication with TOTP codes is enabled
+// for logging in to the account and mail web interfaces, and the number of unused
+// recovery codes.
+func (Account) TOTPStatus(ctx context.Context) (enabled bool, recoveryCodes int) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName, false)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	enabled, recoveryCodes, err = acc.TOTPStatus(ctx)
+	xcheckf(ctx, err, "get two-factor authentication status")
+	return
+}
+
+// TOTPEnrollStart starts enabling two-factor authentication. The secret, and the
+// otpauth URI with the secret, must be added to an authenticator app, after which
+// TOTPEnrollConfirm must be called with a code from the app.
+func (Account) TOTPEnrollStart(ctx context.Context) (secret, uri string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName, false)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	if enabled, _, err := acc.TOTPStatus(ctx); err == nil && enabled {
+		xcheckuserf(ctx, errors.New("already enabled"), "enabling two-factor authentication")
+	}
+	secret, err = acc.TOTPEnrollStart(ctx)
+	xcheckf(ctx, err, "generating totp secret")
+	return secret, totp.URI(mox.Conf.Static.HostnameDomain.Name(), reqInfo.LoginAddress, secret)
+}
+
+// TOTPEnrollConfirm enables two-factor authentication after the code from the
+// authenticator app has been verified. Recovery codes are returned, for logging in
+// when the authenticator app is not available, each can be used once. They are
+// not stored and cannot be retrieved later.
+func (Account) TOTPEnrollConfirm(ctx context.Context, code string) (recoveryCodes []string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName, false)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	recoveryCodes, err = acc.TOTPEnrollConfirm(ctx, code)
+	if errors.Is(err, store.ErrTOTPInvalid) {
+		xcheckuserf(ctx, err, "verifying code")
+	}
+	xcheckf(ctx, err, "enabling two-factor authentication")
+	return recoveryCodes
+}
+
+// TOTPDisable disables two-factor authentication. A current TOTP code or a
+// recovery code is required.
+func (Account) TOTPDisable(ctx context.Context, code string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName, false)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.TOTPCheck(ctx, code)
+	if errors.Is(err, store.ErrTOTPInvalid) || errors.Is(err, store.ErrTOTPRequired) {
+		xcheckuserf(ctx, err, "verifying code")
+	}
+	xcheckf(ctx, err, "verifying code")
+	err = acc.TOTPDisable(ctx)
+	xcheckf(ctx, err, "disabling two-factor authentication")
+}
+
 func (Account) IMAPSave(ctx context.Context, capabilitiesDisabled []string) {
 	// Basic check for capabilities.
 	for _, s := range capabilitiesDisabled {
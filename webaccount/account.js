@@ -265,20 +265,33 @@ var api;
 		AuthResult["AuthBadChannelBinding"] = "badchanbind";
 		AuthResult["AuthBadProtocol"] = "badprotocol";
 		AuthResult["AuthLoginDisabled"] = "logindisabled";
+		AuthResult["AuthTOTPRequired"] = "totprequired";
+		AuthResult["AuthBadTOTP"] = "badtotp";
+		AuthResult["AuthReferral"] = "referral";
 		AuthResult["AuthError"] = "error";
 		AuthResult["AuthAborted"] = "aborted";
 	})(AuthResult = api.AuthResult || (api.AuthResult = {}));
-	api.structTypes = { "Account": true, "Address": true, "AddressAlias": true, "Alias": true, "AliasAddress": true, "AutomaticJunkFlags": true, "Destination": true, "Domain": true, "ImportProgress": true, "Incoming": true, "IncomingMeta": true, "IncomingWebhook": true, "JunkFilter": true, "LoginAttempt": true, "NameAddress": true, "Outgoing": true, "OutgoingWebhook": true, "Route": true, "Ruleset": true, "Structure": true, "SubjectPass": true, "Suppression": true, "TLSPublicKey": true };
-	api.stringsTypes = { "AuthResult": true, "CSRFToken": true, "Localpart": true, "OutgoingEvent": true };
+	// APITokenScope limits what an API token can be used for.
+	let APITokenScope;
+	(function (APITokenScope) {
+		APITokenScope["APITokenScopeRead"] = "read";
+		APITokenScope["APITokenScopeSend"] = "send";
+	})(APITokenScope = api.APITokenScope || (api.APITokenScope = {}));
+	api.structTypes = { "APIToken": true, "Account": true, "Address": true, "AddressAlias": true, "Alias": true, "AliasAddress": true, "AttachmentLinks": true, "AutoArchive": true, "AutomaticJunkFlags": true, "Destination": true, "Domain": true, "ImportProgress": true, "Incoming": true, "IncomingMeta": true, "IncomingWebhook": true, "JunkFilter": true, "JunkRescanMove": true, "LoginAttempt": true, "LoginSession": true, "MailboxRetention": true, "MessageShare": true, "MessageShareAccess": true, "NameAddress": true, "Notification": true, "OAuthToken": true, "OpenPGPKey": true, "OpenPGPKeyringKey": true, "Outgoing": true, "OutgoingWebhook": true, "Route": true, "Ruleset": true, "SenderAllow": true, "Structure": true, "SubjectPass": true, "Suppression": true, "TLSPublicKey": true, "Vacation": true };
+	api.stringsTypes = { "APITokenScope": true, "AuthResult": true, "CSRFToken": true, "Localpart": true, "OutgoingEvent": true };
 	api.intsTypes = {};
 	api.types = {
-		"Account": { "Name": "Account", "Docs": "", "Fields": [{ "Name": "OutgoingWebhook", "Docs": "", "Typewords": ["nullable", "OutgoingWebhook"] }, { "Name": "IncomingWebhook", "Docs": "", "Typewords": ["nullable", "IncomingWebhook"] }, { "Name": "FromIDLoginAddresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "KeepRetiredMessagePeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "KeepRetiredWebhookPeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "LoginDisabled", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }, { "Name": "Destinations", "Docs": "", "Typewords": ["{}", "Destination"] }, { "Name": "SubjectPass", "Docs": "", "Typewords": ["SubjectPass"] }, { "Name": "QuotaMessageSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "RejectsMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "KeepRejects", "Docs": "", "Typewords": ["bool"] }, { "Name": "AutomaticJunkFlags", "Docs": "", "Typewords": ["AutomaticJunkFlags"] }, { "Name": "JunkFilter", "Docs": "", "Typewords": ["nullable", "JunkFilter"] }, { "Name": "MaxOutgoingMessagesPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxFirstTimeRecipientsPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "NoFirstTimeSenderDelay", "Docs": "", "Typewords": ["bool"] }, { "Name": "NoCustomPassword", "Docs": "", "Typewords": ["bool"] }, { "Name": "IMAPCapabilitiesDisabled", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "Aliases", "Docs": "", "Typewords": ["[]", "AddressAlias"] }] },
+		"Account": { "Name": "Account", "Docs": "", "Fields": [{ "Name": "OutgoingWebhook", "Docs": "", "Typewords": ["nullable", "OutgoingWebhook"] }, { "Name": "IncomingWebhook", "Docs": "", "Typewords": ["nullable", "IncomingWebhook"] }, { "Name": "FromIDLoginAddresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "KeepRetiredMessagePeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "KeepRetiredWebhookPeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "DeliveryPriority", "Docs": "", "Typewords": ["int32"] }, { "Name": "AttachmentLinks", "Docs": "", "Typewords": ["nullable", "AttachmentLinks"] }, { "Name": "WeeklyDigest", "Docs": "", "Typewords": ["bool"] }, { "Name": "SubaddressMailboxes", "Docs": "", "Typewords": ["bool"] }, { "Name": "Notifications", "Docs": "", "Typewords": ["[]", "Notification"] }, { "Name": "LoginDisabled", "Docs": "", "Typewords": ["string"] }, { "Name": "ReadOnly", "Docs": "", "Typewords": ["bool"] }, { "Name": "SubmissionDisabled", "Docs": "", "Typewords": ["string"] }, { "Name": "IMAPReferralHost", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }, { "Name": "Destinations", "Docs": "", "Typewords": ["{}", "Destination"] }, { "Name": "SubjectPass", "Docs": "", "Typewords": ["SubjectPass"] }, { "Name": "QuotaMessageSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "RejectsMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "KeepRejects", "Docs": "", "Typewords": ["bool"] }, { "Name": "RejectsRescueAllow", "Docs": "", "Typewords": ["string"] }, { "Name": "MailboxRetention", "Docs": "", "Typewords": ["[]", "MailboxRetention"] }, { "Name": "AutoArchive", "Docs": "", "Typewords": ["nullable", "AutoArchive"] }, { "Name": "AutomaticJunkFlags", "Docs": "", "Typewords": ["AutomaticJunkFlags"] }, { "Name": "JunkFilter", "Docs": "", "Typewords": ["nullable", "JunkFilter"] }, { "Name": "MaxOutgoingMessagesPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxFirstTimeRecipientsPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "HoldOverSendLimits", "Docs": "", "Typewords": ["bool"] }, { "Name": "NoFirstTimeSenderDelay", "Docs": "", "Typewords": ["bool"] }, { "Name": "NoAutoCreateMailboxes", "Docs": "", "Typewords": ["bool"] }, { "Name": "NoCustomPassword", "Docs": "", "Typewords": ["bool"] }, { "Name": "IMAPCapabilitiesDisabled", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "Aliases", "Docs": "", "Typewords": ["[]", "AddressAlias"] }] },
 		"OutgoingWebhook": { "Name": "OutgoingWebhook", "Docs": "", "Fields": [{ "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "Authorization", "Docs": "", "Typewords": ["string"] }, { "Name": "Events", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"IncomingWebhook": { "Name": "IncomingWebhook", "Docs": "", "Fields": [{ "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "Authorization", "Docs": "", "Typewords": ["string"] }] },
-		"Destination": { "Name": "Destination", "Docs": "", "Fields": [{ "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Rulesets", "Docs": "", "Typewords": ["[]", "Ruleset"] }, { "Name": "SMTPError", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageAuthRequiredSMTPError", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }] },
-		"Ruleset": { "Name": "Ruleset", "Docs": "", "Fields": [{ "Name": "SMTPMailFromRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgFromRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "VerifiedDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "HeadersRegexp", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "IsForward", "Docs": "", "Typewords": ["bool"] }, { "Name": "ListAllowDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "AcceptRejectsToMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Comment", "Docs": "", "Typewords": ["string"] }, { "Name": "VerifiedDNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "ListAllowDNSDomain", "Docs": "", "Typewords": ["Domain"] }] },
+		"AttachmentLinks": { "Name": "AttachmentLinks", "Docs": "", "Fields": [{ "Name": "MinSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "ValidityDays", "Docs": "", "Typewords": ["int32"] }] },
+		"Notification": { "Name": "Notification", "Docs": "", "Fields": [{ "Name": "Type", "Docs": "", "Typewords": ["string"] }, { "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "Token", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailboxes", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "FromRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "SubjectRegexp", "Docs": "", "Typewords": ["string"] }] },
+		"Destination": { "Name": "Destination", "Docs": "", "Fields": [{ "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Rulesets", "Docs": "", "Typewords": ["[]", "Ruleset"] }, { "Name": "SMTPError", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageAuthRequiredSMTPError", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }, { "Name": "ForwardTo", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "ForwardKeepCopy", "Docs": "", "Typewords": ["bool"] }] },
+		"Ruleset": { "Name": "Ruleset", "Docs": "", "Fields": [{ "Name": "SMTPMailFromRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgFromRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "VerifiedDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "HeadersRegexp", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "MsgFromPatterns", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "SPFResult", "Docs": "", "Typewords": ["string"] }, { "Name": "DKIMResult", "Docs": "", "Typewords": ["string"] }, { "Name": "DMARCResult", "Docs": "", "Typewords": ["string"] }, { "Name": "MinSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "MaxSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "Attachments", "Docs": "", "Typewords": ["string"] }, { "Name": "ListID", "Docs": "", "Typewords": ["string"] }, { "Name": "IsForward", "Docs": "", "Typewords": ["bool"] }, { "Name": "ListAllowDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "AcceptRejectsToMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "MarkSeen", "Docs": "", "Typewords": ["bool"] }, { "Name": "ForwardTo", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Discard", "Docs": "", "Typewords": ["bool"] }, { "Name": "Comment", "Docs": "", "Typewords": ["string"] }, { "Name": "VerifiedDNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "ListAllowDNSDomain", "Docs": "", "Typewords": ["Domain"] }] },
 		"Domain": { "Name": "Domain", "Docs": "", "Fields": [{ "Name": "ASCII", "Docs": "", "Typewords": ["string"] }, { "Name": "Unicode", "Docs": "", "Typewords": ["string"] }] },
 		"SubjectPass": { "Name": "SubjectPass", "Docs": "", "Fields": [{ "Name": "Period", "Docs": "", "Typewords": ["int64"] }] },
+		"MailboxRetention": { "Name": "MailboxRetention", "Docs": "", "Fields": [{ "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Period", "Docs": "", "Typewords": ["int64"] }] },
+		"AutoArchive": { "Name": "AutoArchive", "Docs": "", "Fields": [{ "Name": "Period", "Docs": "", "Typewords": ["int64"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }] },
 		"AutomaticJunkFlags": { "Name": "AutomaticJunkFlags", "Docs": "", "Fields": [{ "Name": "Enabled", "Docs": "", "Typewords": ["bool"] }, { "Name": "JunkMailboxRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "NeutralMailboxRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "NotJunkMailboxRegexp", "Docs": "", "Typewords": ["string"] }] },
 		"JunkFilter": { "Name": "JunkFilter", "Docs": "", "Fields": [{ "Name": "Threshold", "Docs": "", "Typewords": ["float64"] }, { "Name": "Onegrams", "Docs": "", "Typewords": ["bool"] }, { "Name": "Twograms", "Docs": "", "Typewords": ["bool"] }, { "Name": "Threegrams", "Docs": "", "Typewords": ["bool"] }, { "Name": "MaxPower", "Docs": "", "Typewords": ["float64"] }, { "Name": "TopWords", "Docs": "", "Typewords": ["int32"] }, { "Name": "IgnoreWords", "Docs": "", "Typewords": ["float64"] }, { "Name": "RareWords", "Docs": "", "Typewords": ["int32"] }] },
 		"Route": { "Name": "Route", "Docs": "", "Fields": [{ "Name": "FromDomain", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "ToDomain", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "MinimumAttempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "Transport", "Docs": "", "Typewords": ["string"] }, { "Name": "FromDomainASCII", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "ToDomainASCII", "Docs": "", "Typewords": ["[]", "string"] }] },
@@ -293,21 +306,36 @@ var api;
 		"NameAddress": { "Name": "NameAddress", "Docs": "", "Fields": [{ "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "Address", "Docs": "", "Typewords": ["string"] }] },
 		"Structure": { "Name": "Structure", "Docs": "", "Fields": [{ "Name": "ContentType", "Docs": "", "Typewords": ["string"] }, { "Name": "ContentTypeParams", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "ContentID", "Docs": "", "Typewords": ["string"] }, { "Name": "ContentDisposition", "Docs": "", "Typewords": ["string"] }, { "Name": "Filename", "Docs": "", "Typewords": ["string"] }, { "Name": "DecodedSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "Parts", "Docs": "", "Typewords": ["[]", "Structure"] }] },
 		"IncomingMeta": { "Name": "IncomingMeta", "Docs": "", "Fields": [{ "Name": "MsgID", "Docs": "", "Typewords": ["int64"] }, { "Name": "MailFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "MailFromValidated", "Docs": "", "Typewords": ["bool"] }, { "Name": "MsgFromValidated", "Docs": "", "Typewords": ["bool"] }, { "Name": "RcptTo", "Docs": "", "Typewords": ["string"] }, { "Name": "DKIMVerifiedDomains", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "Received", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "MailboxName", "Docs": "", "Typewords": ["string"] }, { "Name": "Automated", "Docs": "", "Typewords": ["bool"] }] },
+		"SenderAllow": { "Name": "SenderAllow", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Localpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["int64"] }] },
+		"JunkRescanMove": { "Name": "JunkRescanMove", "Docs": "", "Fields": [{ "Name": "MessageID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Received", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "From", "Docs": "", "Typewords": ["string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "DestMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Reason", "Docs": "", "Typewords": ["string"] }, { "Name": "Probability", "Docs": "", "Typewords": ["float64"] }] },
+		"Vacation": { "Name": "Vacation", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Active", "Docs": "", "Typewords": ["bool"] }, { "Name": "Start", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "End", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "Body", "Docs": "", "Typewords": ["string"] }, { "Name": "IntervalDays", "Docs": "", "Typewords": ["int32"] }] },
 		"TLSPublicKey": { "Name": "TLSPublicKey", "Docs": "", "Fields": [{ "Name": "Fingerprint", "Docs": "", "Typewords": ["string"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Type", "Docs": "", "Typewords": ["string"] }, { "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "NoIMAPPreauth", "Docs": "", "Typewords": ["bool"] }, { "Name": "CertDER", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "LoginAddress", "Docs": "", "Typewords": ["string"] }] },
 		"LoginAttempt": { "Name": "LoginAttempt", "Docs": "", "Fields": [{ "Name": "Key", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Last", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "First", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Count", "Docs": "", "Typewords": ["int64"] }, { "Name": "AccountName", "Docs": "", "Typewords": ["string"] }, { "Name": "LoginAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "LocalIP", "Docs": "", "Typewords": ["string"] }, { "Name": "TLS", "Docs": "", "Typewords": ["string"] }, { "Name": "TLSPubKeyFingerprint", "Docs": "", "Typewords": ["string"] }, { "Name": "Protocol", "Docs": "", "Typewords": ["string"] }, { "Name": "UserAgent", "Docs": "", "Typewords": ["string"] }, { "Name": "AuthMech", "Docs": "", "Typewords": ["string"] }, { "Name": "Result", "Docs": "", "Typewords": ["AuthResult"] }] },
+		"LoginSession": { "Name": "LoginSession", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Expires", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "AccountName", "Docs": "", "Typewords": ["string"] }, { "Name": "LoginAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "LastUsed", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "UserAgent", "Docs": "", "Typewords": ["string"] }] },
+		"MessageShare": { "Name": "MessageShare", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "Raw", "Docs": "", "Typewords": ["bool"] }, { "Name": "Expires", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Accesses", "Docs": "", "Typewords": ["int32"] }, { "Name": "LastAccess", "Docs": "", "Typewords": ["timestamp"] }] },
+		"MessageShareAccess": { "Name": "MessageShareAccess", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "ShareID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Time", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "UserAgent", "Docs": "", "Typewords": ["string"] }] },
+		"OpenPGPKey": { "Name": "OpenPGPKey", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Address", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "WKDHash", "Docs": "", "Typewords": ["string"] }, { "Name": "Fingerprint", "Docs": "", "Typewords": ["string"] }, { "Name": "UserIDs", "Docs": "", "Typewords": ["[]", "string"] }] },
+		"OpenPGPKeyringKey": { "Name": "OpenPGPKeyringKey", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Fingerprint", "Docs": "", "Typewords": ["string"] }, { "Name": "UserIDs", "Docs": "", "Typewords": ["[]", "string"] }] },
+		"OAuthToken": { "Name": "OAuthToken", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "LoginAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Expires", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastUsed", "Docs": "", "Typewords": ["timestamp"] }] },
+		"APIToken": { "Name": "APIToken", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "Scope", "Docs": "", "Typewords": ["APITokenScope"] }, { "Name": "Expires", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastUsed", "Docs": "", "Typewords": ["timestamp"] }] },
 		"CSRFToken": { "Name": "CSRFToken", "Docs": "", "Values": null },
 		"Localpart": { "Name": "Localpart", "Docs": "", "Values": null },
 		"OutgoingEvent": { "Name": "OutgoingEvent", "Docs": "", "Values": [{ "Name": "EventDelivered", "Value": "delivered", "Docs": "" }, { "Name": "EventSuppressed", "Value": "suppressed", "Docs": "" }, { "Name": "EventDelayed", "Value": "delayed", "Docs": "" }, { "Name": "EventFailed", "Value": "failed", "Docs": "" }, { "Name": "EventRelayed", "Value": "relayed", "Docs": "" }, { "Name": "EventExpanded", "Value": "expanded", "Docs": "" }, { "Name": "EventCanceled", "Value": "canceled", "Docs": "" }, { "Name": "EventUnrecognized", "Value": "unrecognized", "Docs": "" }] },
-		"AuthResult": { "Name": "AuthResult", "Docs": "", "Values": [{ "Name": "AuthSuccess", "Value": "ok", "Docs": "" }, { "Name": "AuthBadUser", "Value": "baduser", "Docs": "" }, { "Name": "AuthBadPassword", "Value": "badpassword", "Docs": "" }, { "Name": "AuthBadCredentials", "Value": "badcreds", "Docs": "" }, { "Name": "AuthBadChannelBinding", "Value": "badchanbind", "Docs": "" }, { "Name": "AuthBadProtocol", "Value": "badprotocol", "Docs": "" }, { "Name": "AuthLoginDisabled", "Value": "logindisabled", "Docs": "" }, { "Name": "AuthError", "Value": "error", "Docs": "" }, { "Name": "AuthAborted", "Value": "aborted", "Docs": "" }] },
+		"AuthResult": { "Name": "AuthResult", "Docs": "", "Values": [{ "Name": "AuthSuccess", "Value": "ok", "Docs": "" }, { "Name": "AuthBadUser", "Value": "baduser", "Docs": "" }, { "Name": "AuthBadPassword", "Value": "badpassword", "Docs": "" }, { "Name": "AuthBadCredentials", "Value": "badcreds", "Docs": "" }, { "Name": "AuthBadChannelBinding", "Value": "badchanbind", "Docs": "" }, { "Name": "AuthBadProtocol", "Value": "badprotocol", "Docs": "" }, { "Name": "AuthLoginDisabled", "Value": "logindisabled", "Docs": "" }, { "Name": "AuthTOTPRequired", "Value": "totprequired", "Docs": "" }, { "Name": "AuthBadTOTP", "Value": "badtotp", "Docs": "" }, { "Name": "AuthReferral", "Value": "referral", "Docs": "" }, { "Name": "AuthError", "Value": "error", "Docs": "" }, { "Name": "AuthAborted", "Value": "aborted", "Docs": "" }] },
+		"APITokenScope": { "Name": "APITokenScope", "Docs": "", "Values": [{ "Name": "APITokenScopeRead", "Value": "read", "Docs": "" }, { "Name": "APITokenScopeSend", "Value": "send", "Docs": "" }] },
 	};
 	api.parser = {
 		Account: (v) => api.parse("Account", v),
 		OutgoingWebhook: (v) => api.parse("OutgoingWebhook", v),
 		IncomingWebhook: (v) => api.parse("IncomingWebhook", v),
+		AttachmentLinks: (v) => api.parse("AttachmentLinks", v),
+		Notification: (v) => api.parse("Notification", v),
 		Destination: (v) => api.parse("Destination", v),
 		Ruleset: (v) => api.parse("Ruleset", v),
 		Domain: (v) => api.parse("Domain", v),
 		SubjectPass: (v) => api.parse("SubjectPass", v),
+		MailboxRetention: (v) => api.parse("MailboxRetention", v),
+		AutoArchive: (v) => api.parse("AutoArchive", v),
 		AutomaticJunkFlags: (v) => api.parse("AutomaticJunkFlags", v),
 		JunkFilter: (v) => api.parse("JunkFilter", v),
 		Route: (v) => api.parse("Route", v),
@@ -322,12 +350,23 @@ var api;
 		NameAddress: (v) => api.parse("NameAddress", v),
 		Structure: (v) => api.parse("Structure", v),
 		IncomingMeta: (v) => api.parse("IncomingMeta", v),
+		SenderAllow: (v) => api.parse("SenderAllow", v),
+		JunkRescanMove: (v) => api.parse("JunkRescanMove", v),
+		Vacation: (v) => api.parse("Vacation", v),
 		TLSPublicKey: (v) => api.parse("TLSPublicKey", v),
 		LoginAttempt: (v) => api.parse("LoginAttempt", v),
+		LoginSession: (v) => api.parse("LoginSession", v),
+		MessageShare: (v) => api.parse("MessageShare", v),
+		MessageShareAccess: (v) => api.parse("MessageShareAccess", v),
+		OpenPGPKey: (v) => api.parse("OpenPGPKey", v),
+		OpenPGPKeyringKey: (v) => api.parse("OpenPGPKeyringKey", v),
+		OAuthToken: (v) => api.parse("OAuthToken", v),
+		APIToken: (v) => api.parse("APIToken", v),
 		CSRFToken: (v) => api.parse("CSRFToken", v),
 		Localpart: (v) => api.parse("Localpart", v),
 		OutgoingEvent: (v) => api.parse("OutgoingEvent", v),
 		AuthResult: (v) => api.parse("AuthResult", v),
+		APITokenScope: (v) => api.parse("APITokenScope", v),
 	};
 	// Account exports web API functions for the account web interface. All its
 	// methods are exported under api/. Function calls require valid HTTP
@@ -364,12 +403,14 @@ var api;
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// Login returns a session token for the credentials, or fails with error code
-		// "user:badLogin". Call LoginPrep to get a loginToken.
-		async Login(loginToken, username, password) {
+		// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+		// authentication is enabled and totp is empty, the call fails with error code
+		// "user:totpRequired", and must be repeated with a TOTP code or recovery code.
+		async Login(loginToken, username, password, totp) {
 			const fn = "Login";
-			const paramTypes = [["string"], ["string"], ["string"]];
+			const paramTypes = [["string"], ["string"], ["string"], ["string"]];
 			const returnTypes = [["CSRFToken"]];
-			const params = [loginToken, username, password];
+			const params = [loginToken, username, password, totp];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// Logout invalidates the session token.
@@ -560,12 +601,77 @@ var api;
 			const params = [junkFilter];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
-		// RejectsSave saves the RejectsMailbox and KeepRejects settings.
-		async RejectsSave(mailbox, keep) {
+		// RejectsSave saves the RejectsMailbox, KeepRejects and RejectsRescueAllow
+		// settings.
+		async RejectsSave(mailbox, keep, rescueAllow) {
 			const fn = "RejectsSave";
-			const paramTypes = [["string"], ["bool"]];
+			const paramTypes = [["string"], ["bool"], ["string"]];
+			const returnTypes = [];
+			const params = [mailbox, keep, rescueAllow];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// MailboxRetentionSave saves the policies for removing old messages from
+		// mailboxes.
+		async MailboxRetentionSave(retention) {
+			const fn = "MailboxRetentionSave";
+			const paramTypes = [["[]", "MailboxRetention"]];
 			const returnTypes = [];
-			const params = [mailbox, keep];
+			const params = [retention];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SenderAllows returns the sender allow entries, for senders whose messages with
+		// verified From address are accepted without junk filtering.
+		async SenderAllows() {
+			const fn = "SenderAllows";
+			const paramTypes = [];
+			const returnTypes = [["[]", "SenderAllow"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SenderAllowRemove removes a sender allow entry.
+		async SenderAllowRemove(id) {
+			const fn = "SenderAllowRemove";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// JunkRescanDryRun evaluates messages received in the Inbox and Junk mailbox in
+		// the past days against the current junk filter and sender allow list, returning
+		// the messages that a junk rescan would move to the other mailbox. No messages are
+		// moved.
+		async JunkRescanDryRun(days) {
+			const fn = "JunkRescanDryRun";
+			const paramTypes = [["int32"]];
+			const returnTypes = [["[]", "JunkRescanMove"]];
+			const params = [days];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// JunkRescanStart starts a background junk rescan, moving the messages that
+		// JunkRescanDryRun would return. Messages are evaluated slowly to limit the load
+		// on the server. Only one rescan can be in progress per account.
+		async JunkRescanStart(days) {
+			const fn = "JunkRescanStart";
+			const paramTypes = [["int32"]];
+			const returnTypes = [];
+			const params = [days];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// Vacation returns the settings for automatic replies to incoming messages.
+		async Vacation() {
+			const fn = "Vacation";
+			const paramTypes = [];
+			const returnTypes = [["Vacation"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// VacationSave saves the settings for automatic replies. Senders that already
+		// received an automatic reply will get a new reply on their next message.
+		async VacationSave(v) {
+			const fn = "VacationSave";
+			const paramTypes = [["Vacation"]];
+			const returnTypes = [];
+			const params = [v];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		async TLSPublicKeys() {
@@ -603,6 +709,201 @@ var api;
 			const params = [limit];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// Sessions returns the active login sessions for the account and mail web
+		// interfaces, most recently used first, and the ID of the session of the
+		// request.
+		async Sessions() {
+			const fn = "Sessions";
+			const paramTypes = [];
+			const returnTypes = [["[]", "LoginSession"], ["int64"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SessionRevoke removes a login session, it can no longer be used. The session
+		// of the request cannot be revoked, use Logout instead.
+		async SessionRevoke(id) {
+			const fn = "SessionRevoke";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SessionsRevokeOthers removes all login sessions except the session of the
+		// request, and returns the number of revoked sessions.
+		async SessionsRevokeOthers() {
+			const fn = "SessionsRevokeOthers";
+			const paramTypes = [];
+			const returnTypes = [["int32"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// MessageShares returns the share links for messages, created through webmail,
+		// with the accesses of each link.
+		async MessageShares() {
+			const fn = "MessageShares";
+			const paramTypes = [];
+			const returnTypes = [["[]", "MessageShare"], ["[]", "[]", "MessageShareAccess"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// MessageShareRevoke removes a share link, it can no longer be used.
+		async MessageShareRevoke(id) {
+			const fn = "MessageShareRevoke";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// OpenPGPKeys returns the OpenPGP public keys of the account, as published
+		// through WKD and OPENPGPKEY DNS records.
+		async OpenPGPKeys() {
+			const fn = "OpenPGPKeys";
+			const paramTypes = [];
+			const returnTypes = [["[]", "OpenPGPKey"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// OpenPGPKeySet sets the OpenPGP public key for an address of the account,
+		// replacing any existing key for the address. The key can be ASCII-armored, and
+		// must have a user ID with the address.
+		async OpenPGPKeySet(address, key) {
+			const fn = "OpenPGPKeySet";
+			const paramTypes = [["string"], ["string"]];
+			const returnTypes = [["OpenPGPKey"]];
+			const params = [address, key];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// OpenPGPKeyRemove removes the OpenPGP public key for an address of the account.
+		async OpenPGPKeyRemove(address) {
+			const fn = "OpenPGPKeyRemove";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [address];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// OpenPGPKeyring returns the OpenPGP public keys of correspondents in the keyring
+		// of the account, used for verifying signatures of incoming messages.
+		async OpenPGPKeyring() {
+			const fn = "OpenPGPKeyring";
+			const paramTypes = [];
+			const returnTypes = [["[]", "OpenPGPKeyringKey"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// OpenPGPKeyringAdd adds an OpenPGP public key to the keyring of the account,
+		// replacing a key with the same fingerprint. The key can be ASCII-armored.
+		async OpenPGPKeyringAdd(key) {
+			const fn = "OpenPGPKeyringAdd";
+			const paramTypes = [["string"]];
+			const returnTypes = [["OpenPGPKeyringKey"]];
+			const params = [key];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// OpenPGPKeyringRemove removes the key with the fingerprint from the keyring of
+		// the account.
+		async OpenPGPKeyringRemove(fingerprint) {
+			const fn = "OpenPGPKeyringRemove";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [fingerprint];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// OAuthTokens returns the OAuth bearer tokens of the account.
+		async OAuthTokens() {
+			const fn = "OAuthTokens";
+			const paramTypes = [];
+			const returnTypes = [["[]", "OAuthToken"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// OAuthTokenAdd creates a new OAuth bearer token for authenticating as an address
+		// of the account with the OAUTHBEARER or XOAUTH2 mechanisms, for IMAP and SMTP
+		// submission, so email applications don't have to store the password. If
+		// validDays is > 0, the token expires after that many days. The returned token
+		// cannot be retrieved later.
+		async OAuthTokenAdd(name, loginAddress, validDays) {
+			const fn = "OAuthTokenAdd";
+			const paramTypes = [["string"], ["string"], ["int32"]];
+			const returnTypes = [["string"]];
+			const params = [name, loginAddress, validDays];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// OAuthTokenRemove removes an OAuth bearer token of the account.
+		async OAuthTokenRemove(id) {
+			const fn = "OAuthTokenRemove";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// APITokens returns the API tokens of the account.
+		async APITokens() {
+			const fn = "APITokens";
+			const paramTypes = [];
+			const returnTypes = [["[]", "APIToken"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// APITokenAdd creates a new API token, for use instead of the password for any
+		// login address of the account. Tokens with scope "read" give read-only access
+		// with IMAP and the webapi, tokens with scope "send" can only send messages with
+		// SMTP submission and the webapi. If validDays is > 0, the token expires after
+		// that many days. The returned token cannot be retrieved later.
+		async APITokenAdd(name, scope, validDays) {
+			const fn = "APITokenAdd";
+			const paramTypes = [["string"], ["APITokenScope"], ["int32"]];
+			const returnTypes = [["string"]];
+			const params = [name, scope, validDays];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// APITokenRemove removes an API token of the account.
+		async APITokenRemove(id) {
+			const fn = "APITokenRemove";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPStatus returns whether two-factor authentication with TOTP codes is enabled
+		// for logging in to the account and mail web interfaces, and the number of unused
+		// recovery codes.
+		async TOTPStatus() {
+			const fn = "TOTPStatus";
+			const paramTypes = [];
+			const returnTypes = [["bool"], ["int32"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPEnrollStart starts enabling two-factor authentication. The secret, and the
+		// otpauth URI with the secret, must be added to an authenticator app, after which
+		// TOTPEnrollConfirm must be called with a code from the app.
+		async TOTPEnrollStart() {
+			const fn = "TOTPEnrollStart";
+			const paramTypes = [];
+			const returnTypes = [["string"], ["string"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPEnrollConfirm enables two-factor authentication after the code from the
+		// authenticator app has been verified. Recovery codes are returned, for logging in
+		// when the authenticator app is not available, each can be used once. They are
+		// not stored and cannot be retrieved later.
+		async TOTPEnrollConfirm(code) {
+			const fn = "TOTPEnrollConfirm";
+			const paramTypes = [["string"]];
+			const returnTypes = [["[]", "string"]];
+			const params = [code];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPDisable disables two-factor authentication. A current TOTP code or a
+		// recovery code is required.
+		async TOTPDisable(code) {
+			const fn = "TOTPDisable";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [code];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		async IMAPSave(capabilitiesDisabled) {
 			const fn = "IMAPSave";
 			const paramTypes = [["[]", "string"]];
@@ -973,6 +1274,8 @@ const login = async (reason) => {
 		let autosize;
 		let username;
 		let password;
+		let totpLabel;
+		let totp;
 		const root = dom.div(style({ position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: '1', animation: 'fadein .15s ease-in' }), dom.div(style({ display: 'flex', flexDirection: 'column', alignItems: 'center' }), reasonElem = reason ? dom.div(style({ marginBottom: '2ex', textAlign: 'center' }), reason) : dom.div(), dom.div(style({ backgroundColor: 'white', borderRadius: '.25em', padding: '1em', boxShadow: '0 0 20px rgba(0, 0, 0, 0.1)', border: '1px solid #ddd', maxWidth: '95vw', overflowX: 'auto', maxHeight: '95vh', overflowY: 'auto', marginBottom: '20vh' }), dom.form(async function submit(e) {
 			e.preventDefault();
 			e.stopPropagation();
@@ -980,7 +1283,7 @@ const login = async (reason) => {
 			try {
 				fieldset.disabled = true;
 				const loginToken = await client.LoginPrep();
-				const token = await client.Login(loginToken, username.value, password.value);
+				const token = await client.Login(loginToken, username.value, password.value, totp.value);
 				try {
 					window.localStorage.setItem('webaccountaddress', username.value);
 					window.localStorage.setItem('webaccountcsrftoken', token);
@@ -996,12 +1299,20 @@ const login = async (reason) => {
 			}
 			catch (err) {
 				console.log('login error', err);
+				if (err.code === 'user:totpRequired') {
+					// Password was correct, ask for two-factor authentication code and retry.
+					totpLabel.style.display = 'block';
+					totp.required = true;
+					fieldset.disabled = false;
+					totp.focus();
+					return;
+				}
 				window.alert('Error: ' + errmsg(err));
 			}
 			finally {
 				fieldset.disabled = false;
 			}
-		}, fieldset = dom.fieldset(dom.h1('Account'), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Email address', style({ marginBottom: '.5ex' })), autosize = dom.span(dom._class('autosize'), username = dom.input(attr.required(''), attr.autocomplete('email'), attr.placeholder('jane@example.org'), function change() { autosize.dataset.value = username.value; }, function input() { autosize.dataset.value = username.value; }))), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Password', style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.autocomplete('current-password'), attr.required(''))), dom.div(style({ textAlign: 'center' }), dom.submitbutton('Login')))))));
+		}, fieldset = dom.fieldset(dom.h1('Account'), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Email address', style({ marginBottom: '.5ex' })), autosize = dom.span(dom._class('autosize'), username = dom.input(attr.required(''), attr.autocomplete('email'), attr.placeholder('jane@example.org'), function change() { autosize.dataset.value = username.value; }, function input() { autosize.dataset.value = username.value; }))), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Password', style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.autocomplete('current-password'), attr.required(''))), totpLabel = dom.label(style({ display: 'none', marginBottom: '2ex' }), dom.div('Two-factor authentication code', style({ marginBottom: '.5ex' })), totp = dom.input(attr.autocomplete('one-time-code'), attr.title('Code from authenticator app, or a recovery code.'))), dom.div(style({ textAlign: 'center' }), dom.submitbutton('Login')))))));
 		document.body.appendChild(root);
 		username.focus();
 	});
@@ -1180,12 +1491,24 @@ const formatQuotaSize = (v) => {
 	return '' + v;
 };
 const index = async () => {
-	const [[acc, storageUsed, storageLimit, suppressions], tlspubkeys0, recentLoginAttempts] = await Promise.all([
+	const [[acc, storageUsed, storageLimit, suppressions], tlspubkeys0, recentLoginAttempts, openpgpkeys0, keyring0, oauthtokens0, [totpEnabled0, totpRecoveryCodes0], vacation, apitokens0] = await Promise.all([
 		client.Account(),
 		client.TLSPublicKeys(),
 		client.LoginAttempts(10),
+		client.OpenPGPKeys(),
+		client.OpenPGPKeyring(),
+		client.OAuthTokens(),
+		client.TOTPStatus(),
+		client.Vacation(),
+		client.APITokens(),
 	]);
 	const tlspubkeys = tlspubkeys0 || [];
+	const openpgpkeys = openpgpkeys0 || [];
+	const keyring = keyring0 || [];
+	let oauthtokens = oauthtokens0 || [];
+	let apitokens = apitokens0 || [];
+	let totpEnabled = totpEnabled0;
+	let totpRecoveryCodes = totpRecoveryCodes0;
 	let fullNameForm;
 	let fullNameFieldset;
 	let fullName;
@@ -1200,6 +1523,9 @@ const index = async () => {
 	let neutralMailboxRegexp;
 	let notJunkMailboxRegexp;
 	let junkFilterFields;
+	let junkRescanFieldset;
+	let junkRescanDays;
+	let junkRescanResult;
 	let junkFilterEnabled;
 	let junkThreshold;
 	let junkOnegrams;
@@ -1209,8 +1535,20 @@ const index = async () => {
 	let junkIgnoreWords;
 	let junkRareWords;
 	let rejectsFieldset;
+	let mailboxRetentionFieldset;
 	let rejectsMailbox;
 	let keepRejects;
+	let rejectsRescueAllow;
+	let vacationFieldset;
+	let vacationActive;
+	let vacationStart;
+	let vacationEnd;
+	let vacationSubject;
+	let vacationBody;
+	let vacationIntervalDays;
+	// Date inputs work with yyyy-mm-dd, we store times in UTC, with zero time for "not set".
+	const vacationDate = (t) => t.getUTCFullYear() <= 1 ? '' : t.toISOString().substring(0, 10);
+	const vacationParseDate = (s) => new Date(s ? s + 'T00:00:00Z' : '0001-01-01T00:00:00Z');
 	let outgoingWebhookFieldset;
 	let outgoingWebhookURL;
 	let outgoingWebhookAuthorization;
@@ -1501,7 +1839,7 @@ const index = async () => {
 	}), dom.br(), dom.h2('Addresses'), dom.ul(Object.entries(acc.Destinations || {}).length === 0 ? dom.li('(None, login disabled)') : [], Object.entries(acc.Destinations || {}).sort().map(t => dom.li(dom.a(prewrap(t[0]), attr.href('#destinations/' + encodeURIComponent(t[0]))), t[0].startsWith('@') ? ' (catchall)' : []))), dom.br(), dom.h2('Aliases/lists'), dom.table(dom.thead(dom.tr(dom.th('Alias address', attr.title('Messages sent to this address will be delivered to all members of the alias/list. A member does not receive a message if their address is in the message From header.')), dom.th('Subscription address', attr.title('Address subscribed to the alias/list.')), dom.th('Allowed senders', attr.title('Whether only members can send through the alias/list, or anyone.')), dom.th('Send as alias address', attr.title('If enabled, messages can be sent with the alias address in the message "From" header.')), dom.th())), (acc.Aliases || []).length === 0 ? dom.tr(dom.td(attr.colspan('5'), 'None')) : [], (acc.Aliases || []).sort((a, b) => a.Alias.LocalpartStr < b.Alias.LocalpartStr ? -1 : (domainName(a.Alias.Domain) < domainName(b.Alias.Domain) ? -1 : 1)).map(a => dom.tr(dom.td(prewrap(a.Alias.LocalpartStr, '@', domainName(a.Alias.Domain))), dom.td(prewrap(a.SubscriptionAddress)), dom.td(a.Alias.PostPublic ? 'Anyone' : 'Members only'), dom.td(a.Alias.AllowMsgFrom ? 'Yes' : 'No'), dom.td((a.MemberAddresses || []).length === 0 ? [] :
 		dom.clickbutton('Show members', function click() {
 			popup(dom.h1('Members of alias ', prewrap(a.Alias.LocalpartStr, '@', domainName(a.Alias.Domain))), dom.ul((a.MemberAddresses || []).map(addr => dom.li(prewrap(addr)))));
-		}))))), dom.br(), dom.h2('Recent login attempts', attr.title('Login attempts are stored for 30 days. At most 10000 failed login attempts are stored to prevent unlimited growth of the database.')), renderLoginAttempts(recentLoginAttempts || []), dom.br(), recentLoginAttempts && recentLoginAttempts.length >= 10 ? dom.p('See ', dom.a(attr.href('#loginattempts'), 'all login attempts'), '.') : dom.br(), dom.h2('Change password'), acc.NoCustomPassword ?
+		}))))), dom.br(), dom.h2('Recent login attempts', attr.title('Login attempts are stored for 30 days. At most 10000 failed login attempts are stored to prevent unlimited growth of the database.')), renderLoginAttempts(recentLoginAttempts || []), dom.br(), recentLoginAttempts && recentLoginAttempts.length >= 10 ? dom.p('See ', dom.a(attr.href('#loginattempts'), 'all login attempts'), '.') : dom.br(), dom.h2('Share links'), dom.p('Links to messages, created in webmail, can be viewed by anyone with the link until they expire. See ', dom.a(attr.href('#shares'), 'share links'), ' to inspect their use or revoke them.'), dom.br(), dom.h2('Sessions'), dom.p('Logins to the account and mail web interfaces create sessions. See ', dom.a(attr.href('#sessions'), 'sessions'), ' for the devices you are logged in with, and to revoke sessions.'), dom.br(), dom.h2('Change password'), acc.NoCustomPassword ?
 		dom.div(dom.clickbutton('Generate and set new password', attr.title('Automatically generate a new password and set it for this account. Custom passwords risk reuse across services and are currently disabled for this account.'), async function click(e) {
 			const password = await check(e.target, client.GeneratePassword());
 			window.alert('New password: ' + password + '\n\nStore it securely, for example in a password manager.');
@@ -1533,7 +1871,43 @@ const index = async () => {
 			}
 			await check(passwordFieldset, client.SetPassword(password1.value));
 			passwordForm.reset();
-		}), dom.br(), dom.h2('TLS public keys'), dom.p('For TLS client authentication with certificates, for IMAP and/or submission (SMTP). Only the public key of the certificate is used during TLS authentication, to identify this account. Names, expiration or constraints are not verified.'), (() => {
+		}), dom.br(), dom.h2('Two-factor authentication'), dom.p('With two-factor authentication enabled, logging in to the account and webmail web interfaces requires a code from an authenticator app on your phone, in addition to your password. Recovery codes can be used instead of a code, once each, in case you lose access to the app. Email applications logging in with IMAP and SMTP submission are not affected.'), (() => {
+		let elem = dom.div();
+		const render = () => {
+			const e = dom.div(totpEnabled ?
+				dom.div(dom.p('Two-factor authentication is ', dom.b('enabled'), '. Remaining recovery codes: ', '' + totpRecoveryCodes, '.'), dom.clickbutton('Disable two-factor authentication', async function click(e) {
+					const code = window.prompt('Enter a code from your authenticator app or a recovery code to disable two-factor authentication.');
+					if (!code) {
+						return;
+					}
+					await check(e.target, client.TOTPDisable(code));
+					totpEnabled = false;
+					totpRecoveryCodes = 0;
+					render();
+				})) :
+				dom.div(dom.p('Two-factor authentication is not enabled.'), dom.clickbutton('Enable two-factor authentication', async function click(e) {
+					const [secret, uri] = await check(e.target, client.TOTPEnrollStart());
+					let code;
+					let box;
+					popup(box = dom.div(style({ maxWidth: '45em' }), dom.h1('Enable two-factor authentication'), dom.p('Add the secret below to your authenticator app, by opening the link on your phone or by entering the secret manually. Then enter the code shown by the app to confirm.'), dom.div(dom.b('Secret')), dom.div(dom._class('literal'), secret), dom.br(), dom.div(dom.a(attr.href(uri), 'Open in authenticator app')), dom.br(), dom.form(async function submit(e) {
+						e.preventDefault();
+						e.stopPropagation();
+						const codes = await check(e.target, client.TOTPEnrollConfirm(code.value)) || [];
+						totpEnabled = true;
+						totpRecoveryCodes = codes.length;
+						render();
+						box.replaceChildren(dom.h1('Two-factor authentication enabled'), dom.p('Store the recovery codes below securely, for example in a password manager. Each can be used once to log in instead of a code from your authenticator app. They cannot be shown again.'), dom.div(dom._class('literal'), codes.join('\n')));
+					}, dom.label(style({ display: 'block', marginBottom: '1ex' }), dom.div(dom.b('Code')), code = dom.input(attr.required(''), attr.autocomplete('one-time-code'))), dom.submitbutton('Confirm'))));
+					code.focus();
+				})));
+			if (elem) {
+				elem.replaceWith(e);
+			}
+			elem = e;
+		};
+		render();
+		return elem;
+	})(), dom.br(), dom.h2('TLS public keys'), dom.p('For TLS client authentication with certificates, for IMAP and/or submission (SMTP). Only the public key of the certificate is used during TLS authentication, to identify this account. Names, expiration or constraints are not verified.'), (() => {
 		let elem = dom.div();
 		const preauthHelp = 'New IMAP immediate TLS connections authenticated with a client certificate are automatically switched to "authenticated" state with an untagged IMAP "preauth" message by default. IMAP connections have a state machine specifying when commands are allowed. Authenticating is not allowed while in the "authenticated" state. Enable this option to work around clients that would try to authenticated anyway.';
 		const render = () => {
@@ -1630,6 +2004,133 @@ openssl pkcs12 \\
 		};
 		render();
 		return elem;
+	})(), dom.br(), dom.h2('OpenPGP keys'), dom.p('OpenPGP public keys for your addresses are published through the Web Key Directory (WKD) and can be added as OPENPGPKEY DNS records by the administrator, so correspondents can find your key automatically. The key must have a user ID with the email address.'), (() => {
+		let elem = dom.div();
+		const render = () => {
+			const e = dom.div(dom.table(dom.thead(dom.tr(dom.th('Address'), dom.th('Fingerprint'), dom.th('User IDs'), dom.th('Added'), dom.th('Remove'))), dom.tbody(openpgpkeys.length === 0 ? dom.tr(dom.td(attr.colspan('5'), 'None')) : [], openpgpkeys.map(pk => dom.tr(dom.td(pk.Address), dom.td(pk.Fingerprint), dom.td((pk.UserIDs || []).join(', ')), dom.td(age(pk.Created)), dom.td(dom.form(async function submit(e) {
+				e.stopPropagation();
+				e.preventDefault();
+				await check(e.target, client.OpenPGPKeyRemove(pk.Address));
+				openpgpkeys.splice(openpgpkeys.indexOf(pk), 1);
+				render();
+			}, dom.submitbutton('Remove'))))))), dom.clickbutton('Set key', style({ marginTop: '1ex' }), function click() {
+				let address;
+				let key;
+				const close = popup(dom.div(style({ maxWidth: '45em' }), dom.h1('Set OpenPGP key'), dom.form(async function submit(e) {
+					e.preventDefault();
+					e.stopPropagation();
+					const npk = await check(e.target, client.OpenPGPKeySet(address.value, key.value));
+					const i = openpgpkeys.findIndex(pk => pk.Address === npk.Address);
+					if (i >= 0) {
+						openpgpkeys.splice(i, 1, npk);
+					}
+					else {
+						openpgpkeys.push(npk);
+					}
+					render();
+					close();
+				}, dom.label(style({ display: 'block', marginBottom: '1ex' }), dom.div(dom.b('Address')), address = dom.select(attr.required(''), Object.keys(acc.Destinations || {}).filter(a => !a.startsWith('@')).sort().map(a => dom.option(a))), dom.div(style({ fontStyle: 'italic', marginTop: '.5ex' }), 'An existing key for the address is replaced.')), dom.label(style({ display: 'block', marginBottom: '1ex' }), dom.div(dom.b('Public key')), key = dom.textarea(attr.required(''), attr.rows('10'), style({ width: '100%' }), attr.placeholder('-----BEGIN PGP PUBLIC KEY BLOCK-----')), dom.div(style({ fontStyle: 'italic', marginTop: '.5ex' }), 'ASCII-armored public key, e.g. from "gpg --armor --export you@example.org". Never paste a private key.')), dom.br(), dom.submitbutton('Save'))));
+			}));
+			if (elem) {
+				elem.replaceWith(e);
+			}
+			elem = e;
+		};
+		render();
+		return elem;
+	})(), dom.br(), dom.h2('OpenPGP keyring'), dom.p('Public keys of correspondents in your keyring are used to verify PGP signatures of incoming messages. Published keys of addresses on this server are also used. Messages with a valid signature from a key with a user ID for the From address get keyword $SignatureValid, messages with a signature that does not match get keyword $SignatureInvalid.'), (() => {
+		let elem = dom.div();
+		const render = () => {
+			const e = dom.div(dom.table(dom.thead(dom.tr(dom.th('Fingerprint'), dom.th('User IDs'), dom.th('Added'), dom.th('Remove'))), dom.tbody(keyring.length === 0 ? dom.tr(dom.td(attr.colspan('4'), 'None')) : [], keyring.map(k => dom.tr(dom.td(k.Fingerprint), dom.td((k.UserIDs || []).join(', ')), dom.td(age(k.Created)), dom.td(dom.form(async function submit(e) {
+				e.stopPropagation();
+				e.preventDefault();
+				await check(e.target, client.OpenPGPKeyringRemove(k.Fingerprint));
+				keyring.splice(keyring.indexOf(k), 1);
+				render();
+			}, dom.submitbutton('Remove'))))))), dom.clickbutton('Add key', style({ marginTop: '1ex' }), function click() {
+				let key;
+				const close = popup(dom.div(style({ maxWidth: '45em' }), dom.h1('Add OpenPGP key to keyring'), dom.form(async function submit(e) {
+					e.preventDefault();
+					e.stopPropagation();
+					const nk = await check(e.target, client.OpenPGPKeyringAdd(key.value));
+					const i = keyring.findIndex(k => k.Fingerprint === nk.Fingerprint);
+					if (i >= 0) {
+						keyring.splice(i, 1, nk);
+					}
+					else {
+						keyring.push(nk);
+					}
+					render();
+					close();
+				}, dom.label(style({ display: 'block', marginBottom: '1ex' }), dom.div(dom.b('Public key')), key = dom.textarea(attr.required(''), attr.rows('10'), style({ width: '100%' }), attr.placeholder('-----BEGIN PGP PUBLIC KEY BLOCK-----')), dom.div(style({ fontStyle: 'italic', marginTop: '.5ex' }), 'ASCII-armored public key of a correspondent, e.g. from "gpg --armor --export them@example.org".')), dom.br(), dom.submitbutton('Add'))));
+			}));
+			if (elem) {
+				elem.replaceWith(e);
+			}
+			elem = e;
+		};
+		render();
+		return elem;
+	})(), dom.br(), dom.h2('OAuth tokens'), dom.p('OAuth bearer tokens can be used by email applications to log in with IMAP and SMTP submission using the OAUTHBEARER or XOAUTH2 authentication mechanisms, instead of with a password. Each application can get its own token, and a token can be removed without changing your password.'), (() => {
+		let elem = dom.div();
+		const render = () => {
+			const e = dom.div(dom.table(dom.thead(dom.tr(dom.th('Name'), dom.th('Login address'), dom.th('Created'), dom.th('Expires'), dom.th('Last used'), dom.th('Remove'))), dom.tbody(oauthtokens.length === 0 ? dom.tr(dom.td(attr.colspan('6'), 'None')) : [], oauthtokens.map(t => dom.tr(dom.td(t.Name), dom.td(t.LoginAddress), dom.td(age(t.Created)), dom.td(t.Expires.getUTCFullYear() <= 1 ? 'Never' : age(t.Expires)), dom.td(t.LastUsed.getUTCFullYear() <= 1 ? 'Never' : age(t.LastUsed)), dom.td(dom.form(async function submit(e) {
+				e.stopPropagation();
+				e.preventDefault();
+				await check(e.target, client.OAuthTokenRemove(t.ID));
+				oauthtokens.splice(oauthtokens.indexOf(t), 1);
+				render();
+			}, dom.submitbutton('Remove'))))))), dom.clickbutton('Create token', style({ marginTop: '1ex' }), function click() {
+				let name;
+				let loginAddress;
+				let validDays;
+				let box;
+				popup(box = dom.div(style({ maxWidth: '45em' }), dom.h1('Create OAuth token'), dom.form(async function submit(e) {
+					e.preventDefault();
+					e.stopPropagation();
+					const token = await check(e.target, client.OAuthTokenAdd(name.value, loginAddress.value, parseInt(validDays.value)));
+					oauthtokens = await check(e.target, client.OAuthTokens()) || [];
+					render();
+					box.replaceChildren(dom.h1('OAuth token created'), dom.p('Configure your email application with the token below. Copy it now, it cannot be shown again.'), dom.div(dom._class('literal'), token));
+				}, dom.label(style({ display: 'block', marginBottom: '1ex' }), dom.div(dom.b('Name')), name = dom.input(attr.required(''), attr.placeholder('e.g. laptop email application'))), dom.label(style({ display: 'block', marginBottom: '1ex' }), dom.div(dom.b('Login address')), loginAddress = dom.select(attr.required(''), Object.keys(acc.Destinations || {}).filter(a => !a.startsWith('@')).sort().map(a => dom.option(a)))), dom.label(style({ display: 'block', marginBottom: '1ex' }), dom.div(dom.b('Valid for days')), validDays = dom.input(attr.type('number'), attr.min('0'), attr.value('365'), attr.required('')), dom.div(style({ fontStyle: 'italic', marginTop: '.5ex' }), 'Use 0 for a token that does not expire.')), dom.br(), dom.submitbutton('Create'))));
+			}));
+			if (elem) {
+				elem.replaceWith(e);
+			}
+			elem = e;
+		};
+		render();
+		return elem;
+	})(), dom.br(), dom.h2('API tokens'), dom.p('API tokens can be used by scripts and integrations instead of your password, with limited access. A token with scope "read" gives read-only access to your mailboxes with IMAP and to messages with the webapi. A token with scope "send" can only send messages, with SMTP submission and the webapi. Use any of your addresses as username, and the token as password.'), (() => {
+		let elem = dom.div();
+		const render = () => {
+			const e = dom.div(dom.table(dom.thead(dom.tr(dom.th('Name'), dom.th('Scope'), dom.th('Created'), dom.th('Expires'), dom.th('Last used'), dom.th('Remove'))), dom.tbody(apitokens.length === 0 ? dom.tr(dom.td(attr.colspan('6'), 'None')) : [], apitokens.map(t => dom.tr(dom.td(t.Name), dom.td(t.Scope), dom.td(age(t.Created)), dom.td(t.Expires.getUTCFullYear() <= 1 ? 'Never' : age(t.Expires)), dom.td(t.LastUsed.getUTCFullYear() <= 1 ? 'Never' : age(t.LastUsed)), dom.td(dom.form(async function submit(e) {
+				e.stopPropagation();
+				e.preventDefault();
+				await check(e.target, client.APITokenRemove(t.ID));
+				apitokens.splice(apitokens.indexOf(t), 1);
+				render();
+			}, dom.submitbutton('Remove'))))))), dom.clickbutton('Create token', style({ marginTop: '1ex' }), function click() {
+				let name;
+				let scope;
+				let validDays;
+				let box;
+				popup(box = dom.div(style({ maxWidth: '45em' }), dom.h1('Create API token'), dom.form(async function submit(e) {
+					e.preventDefault();
+					e.stopPropagation();
+					const token = await check(e.target, client.APITokenAdd(name.value, scope.value, parseInt(validDays.value)));
+					apitokens = await check(e.target, client.APITokens()) || [];
+					render();
+					box.replaceChildren(dom.h1('API token created'), dom.p('Configure your script or integration with the token below as password. Copy it now, it cannot be shown again.'), dom.div(dom._class('literal'), token));
+				}, dom.label(style({ display: 'block', marginBottom: '1ex' }), dom.div(dom.b('Name')), name = dom.input(attr.required(''), attr.placeholder('e.g. backup script'))), dom.label(style({ display: 'block', marginBottom: '1ex' }), dom.div(dom.b('Scope')), scope = dom.select(attr.required(''), dom.option('Read-only mailbox access (IMAP, webapi)', attr.value(api.APITokenScope.APITokenScopeRead)), dom.option('Send only (SMTP submission, webapi)', attr.value(api.APITokenScope.APITokenScopeSend)))), dom.label(style({ display: 'block', marginBottom: '1ex' }), dom.div(dom.b('Valid for days')), validDays = dom.input(attr.type('number'), attr.min('0'), attr.value('365'), attr.required('')), dom.div(style({ fontStyle: 'italic', marginTop: '.5ex' }), 'Use 0 for a token that does not expire.')), dom.br(), dom.submitbutton('Create'))));
+			}));
+			if (elem) {
+				elem.replaceWith(e);
+			}
+			elem = e;
+		};
+		render();
+		return elem;
 	})(), dom.br(), dom.h2('Disk usage'), dom.p('Storage used is ', dom.b(formatQuotaSize(Math.floor(storageUsed / (1024 * 1024)) * 1024 * 1024)), storageLimit > 0 ? [
 		dom.b('/', formatQuotaSize(storageLimit)),
 		' (',
@@ -1659,11 +2160,64 @@ openssl pkcs12 \\
 			return r;
 		};
 		await check(junkFilterFields, (async () => await client.JunkFilterSave(xjunkFilter()))());
-	}, junkFilterFields = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em' }), dom.label('Enabled', attr.title("If enabled, the junk filter is used to classify incoming email from first-time senders. The result, along with other checks, determines if the message will be accepted or rejected"), dom.div(junkFilterEnabled = dom.input(attr.type('checkbox'), acc.JunkFilter ? attr.checked('') : []))), dom.label('Threshold', attr.title('Approximate spaminess score between 0 and 1 above which emails are rejected as spam. Each delivery attempt adds a little noise to make it slightly harder for spammers to identify words that strongly indicate non-spaminess and use it to bypass the filter. E.g. 0.95.'), dom.div(junkThreshold = dom.input(attr.value('' + (acc.JunkFilter?.Threshold || '0.95'))))), dom.label('Onegrams', attr.title('Track ham/spam ranking for single words.'), dom.div(junkOnegrams = dom.input(attr.type('checkbox'), acc.JunkFilter?.Onegrams ? attr.checked('') : []))), dom.label('Twograms', attr.title('Track ham/spam ranking for each two consecutive words.'), dom.div(junkTwograms = dom.input(attr.type('checkbox'), acc.JunkFilter?.Twograms ? attr.checked('') : []))), dom.label('Threegrams', attr.title('Track ham/spam ranking for each three consecutive words. Can only be changed by admin.'), dom.div(dom.input(attr.type('checkbox'), attr.disabled(''), acc.JunkFilter?.Threegrams ? attr.checked('') : []))), dom.label('Max power', attr.title('Maximum power a word (combination) can have. If spaminess is 0.99, and max power is 0.1, spaminess of the word will be set to 0.9. Similar for ham words.'), dom.div(junkMaxPower = dom.input(attr.value('' + (acc.JunkFilter?.MaxPower || 0.01))))), dom.label('Top words', attr.title('Number of most spammy/hammy words to use for calculating probability. E.g. 10.'), dom.div(junkTopWords = dom.input(attr.value('' + (acc.JunkFilter?.TopWords || 10))))), dom.label('Ignore words', attr.title('Ignore words that are this much away from 0.5 haminess/spaminess. E.g. 0.1, causing word (combinations) of 0.4 to 0.6 to be ignored.'), dom.div(junkIgnoreWords = dom.input(attr.value('' + (acc.JunkFilter?.IgnoreWords || 0.1))))), dom.label('Rare words', attr.title('Occurrences in word database until a word is considered rare and its influence in calculating probability reduced. E.g. 1 or 2.'), dom.div(junkRareWords = dom.input(attr.value('' + (acc.JunkFilter?.RareWords || 2))))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save')))))), dom.br(), dom.h2('Rejects'), dom.form(async function submit(e) {
+	}, junkFilterFields = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em' }), dom.label('Enabled', attr.title("If enabled, the junk filter is used to classify incoming email from first-time senders. The result, along with other checks, determines if the message will be accepted or rejected"), dom.div(junkFilterEnabled = dom.input(attr.type('checkbox'), acc.JunkFilter ? attr.checked('') : []))), dom.label('Threshold', attr.title('Approximate spaminess score between 0 and 1 above which emails are rejected as spam. Each delivery attempt adds a little noise to make it slightly harder for spammers to identify words that strongly indicate non-spaminess and use it to bypass the filter. E.g. 0.95.'), dom.div(junkThreshold = dom.input(attr.value('' + (acc.JunkFilter?.Threshold || '0.95'))))), dom.label('Onegrams', attr.title('Track ham/spam ranking for single words.'), dom.div(junkOnegrams = dom.input(attr.type('checkbox'), acc.JunkFilter?.Onegrams ? attr.checked('') : []))), dom.label('Twograms', attr.title('Track ham/spam ranking for each two consecutive words.'), dom.div(junkTwograms = dom.input(attr.type('checkbox'), acc.JunkFilter?.Twograms ? attr.checked('') : []))), dom.label('Threegrams', attr.title('Track ham/spam ranking for each three consecutive words. Can only be changed by admin.'), dom.div(dom.input(attr.type('checkbox'), attr.disabled(''), acc.JunkFilter?.Threegrams ? attr.checked('') : []))), dom.label('Max power', attr.title('Maximum power a word (combination) can have. If spaminess is 0.99, and max power is 0.1, spaminess of the word will be set to 0.9. Similar for ham words.'), dom.div(junkMaxPower = dom.input(attr.value('' + (acc.JunkFilter?.MaxPower || 0.01))))), dom.label('Top words', attr.title('Number of most spammy/hammy words to use for calculating probability. E.g. 10.'), dom.div(junkTopWords = dom.input(attr.value('' + (acc.JunkFilter?.TopWords || 10))))), dom.label('Ignore words', attr.title('Ignore words that are this much away from 0.5 haminess/spaminess. E.g. 0.1, causing word (combinations) of 0.4 to 0.6 to be ignored.'), dom.div(junkIgnoreWords = dom.input(attr.value('' + (acc.JunkFilter?.IgnoreWords || 0.1))))), dom.label('Rare words', attr.title('Occurrences in word database until a word is considered rare and its influence in calculating probability reduced. E.g. 1 or 2.'), dom.div(junkRareWords = dom.input(attr.value('' + (acc.JunkFilter?.RareWords || 2))))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save')))))), dom.br(), dom.h2('Rescan recent messages', attr.title('After changing junk filter settings or the sender allow list, recent messages in the Inbox and Junk mailbox can be evaluated again. Messages now classified as junk are moved from the Inbox to the Junk mailbox. Messages now classified as non-junk, including messages from allowed senders with a verified From address, are moved from the Junk mailbox to the Inbox. Do a dry run first to see which messages would be moved. The rescan runs in the background and evaluates messages slowly to limit the load on the server.')), dom.form(async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
-		await check(rejectsFieldset, client.RejectsSave(rejectsMailbox.value, keepRejects.checked));
-	}, rejectsFieldset = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em' }), dom.label('Mailbox', attr.title("Mail that looks like spam will be rejected, but a copy can be stored temporarily in a mailbox, e.g. Rejects. If mail isn't coming in when you expect, you can look there. The mail still isn't accepted, so the remote mail server may retry (hopefully, if legitimate), or give up (hopefully, if indeed a spammer). Messages are automatically removed from this mailbox, so do not set it to a mailbox that has messages you want to keep."), dom.div(rejectsMailbox = dom.input(attr.value(acc.RejectsMailbox)))), dom.label("No cleanup", attr.title("Don't automatically delete mail in the RejectsMailbox listed above. This can be useful, e.g. for future spam training. It can also cause storage to fill up."), dom.div(keepRejects = dom.input(attr.type('checkbox'), acc.KeepRejects ? attr.checked('') : []))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save')))))), dom.br(), dom.h2('Webhooks'), dom.h3('Outgoing', attr.title('Webhooks for outgoing messages are called for each attempt to deliver a message in the outgoing queue, e.g. when the queue has delivered a message to the next hop, when a single attempt failed with a temporary error, when delivery permanently failed, or when DSN (delivery status notification) messages were received about a previously sent message.')), dom.form(async function submit(e) {
+		const moves = await check(junkRescanFieldset, client.JunkRescanDryRun(parseInt(junkRescanDays.value))) || [];
+		dom._kids(junkRescanResult, dom.table(dom.thead(dom.tr(dom.th('Received'), dom.th('From'), dom.th('Subject'), dom.th('Move from'), dom.th('Move to'), dom.th('Reason'))), dom.tbody(moves.length ? [] : dom.tr(dom.td(attr.colspan('6'), 'No messages would be moved.')), moves.map(mv => dom.tr(dom.td(age(mv.Received)), dom.td(prewrap(mv.From)), dom.td(mv.Subject), dom.td(mv.Mailbox), dom.td(mv.DestMailbox), dom.td(mv.Reason === 'junk filter' ? 'Junk filter, probability ' + mv.Probability.toFixed(2) : mv.Reason))))));
+	}, junkRescanFieldset = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em', alignItems: 'flex-end' }), dom.label('Days', attr.title('Evaluate messages received in this many past days, at most 90.'), dom.div(junkRescanDays = dom.input(attr.type('number'), attr.min('1'), attr.max('90'), attr.value('7'), attr.required('')))), dom.div(dom.submitbutton('Dry run')), dom.div(dom.clickbutton('Rescan', async function click() {
+		await check(junkRescanFieldset, client.JunkRescanStart(parseInt(junkRescanDays.value)));
+		dom._kids(junkRescanResult, dom.p('Rescan started in the background.'));
+	}))))), junkRescanResult = dom.div(), dom.br(), dom.h2('Rejects'), dom.form(async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		await check(rejectsFieldset, client.RejectsSave(rejectsMailbox.value, keepRejects.checked, rejectsRescueAllow.value));
+	}, rejectsFieldset = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em' }), dom.label('Mailbox', attr.title("Mail that looks like spam will be rejected, but a copy can be stored temporarily in a mailbox, e.g. Rejects. If mail isn't coming in when you expect, you can look there. The mail still isn't accepted, so the remote mail server may retry (hopefully, if legitimate), or give up (hopefully, if indeed a spammer). Messages are automatically removed from this mailbox, so do not set it to a mailbox that has messages you want to keep."), dom.div(rejectsMailbox = dom.input(attr.value(acc.RejectsMailbox)))), dom.label("No cleanup", attr.title("Don't automatically delete mail in the RejectsMailbox listed above. This can be useful, e.g. for future spam training. It can also cause storage to fill up."), dom.div(keepRejects = dom.input(attr.type('checkbox'), acc.KeepRejects ? attr.checked('') : []))), dom.label('Allow sender when moved out', attr.title('When a message is moved out of the rejects mailbox, automatically add a sender allow entry for its From address or domain. Later messages from allowed senders with a verified From address are accepted without junk filtering. If not set, webmail offers to add an entry.'), dom.div(rejectsRescueAllow = dom.select(dom.option('No, ask in webmail', attr.value('')), dom.option('Address', attr.value('address'), acc.RejectsRescueAllow === 'address' ? attr.selected('') : []), dom.option('Domain', attr.value('domain'), acc.RejectsRescueAllow === 'domain' ? attr.selected('') : [])))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save')))))), dom.p('See ', dom.a(attr.href('#senderallow'), 'sender allow list'), ' for senders whose messages are accepted without junk filtering.'), dom.br(), dom.h2('Mailbox retention', attr.title('Messages in these mailboxes are removed automatically when they were received longer ago than the period, checked every hour. Use values like "30d" for 30 days, or units "h" for hour, "w" for week. The period must be at least 1h. Child mailboxes are not included. Messages in the rejects mailbox are always removed after 14 days, unless "No cleanup" is checked.')), (() => {
+		let rows = [];
+		let elem;
+		const render = () => {
+			rows = [];
+			const e = dom.form(async function submit(e) {
+				e.preventDefault();
+				e.stopPropagation();
+				await check(mailboxRetentionFieldset, (async () => await client.MailboxRetentionSave(rows.map(r => ({ Mailbox: r.mailbox.value, Period: parseDuration(r.period.value) }))))());
+			}, mailboxRetentionFieldset = dom.fieldset(dom.table(dom.thead(dom.tr(dom.th('Mailbox'), dom.th('Period'), dom.th())), dom.tbody((acc.MailboxRetention || []).length === 0 ? dom.tr(dom.td('(None)'), dom.td(), dom.td()) : [], (acc.MailboxRetention || []).map((mr, index) => {
+				const row = {
+					mailbox: dom.input(attr.required(''), attr.value(mr.Mailbox), attr.placeholder('Trash')),
+					period: dom.input(attr.required(''), attr.value(formatDuration(mr.Period)), attr.placeholder('30d')),
+				};
+				rows.push(row);
+				const x = dom.tr(dom.td(row.mailbox), dom.td(row.period), dom.td(dom.clickbutton('Remove', function click() {
+					acc.MailboxRetention.splice(index, 1);
+					render();
+				})));
+				return x;
+			})), dom.tfoot(dom.tr(dom.td(attr.colspan('2')), dom.td(dom.clickbutton('Add', function click() {
+				acc.MailboxRetention = (acc.MailboxRetention || []).concat([{ Mailbox: '', Period: 0 }]);
+				render();
+			}))), dom.tr(dom.td(attr.colspan('3'), dom.submitbutton('Save')))))));
+			if (elem) {
+				elem.replaceWith(e);
+				elem = e;
+			}
+			return e;
+		};
+		elem = render();
+		return elem;
+	})(), dom.br(), dom.h2('Vacation replies', attr.title('Automatic replies to incoming messages, e.g. when out of office. Replies are not sent for messages from mailing lists, automated senders, junk messages, and messages that did not have your address in To or Cc. Each sender gets at most one reply per interval.')), dom.form(async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		const v = {
+			ID: 1,
+			Active: vacationActive.checked,
+			Start: vacationParseDate(vacationStart.value),
+			End: vacationParseDate(vacationEnd.value),
+			Subject: vacationSubject.value,
+			Body: vacationBody.value,
+			IntervalDays: parseInt(vacationIntervalDays.value || '0'),
+		};
+		await check(vacationFieldset, client.VacationSave(v));
+	}, vacationFieldset = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em' }), dom.label('Active', dom.div(vacationActive = dom.input(attr.type('checkbox'), vacation.Active ? attr.checked('') : []))), dom.label('Start', attr.title('If set, replies are only sent for messages arriving at or after the start of this day (UTC).'), dom.div(vacationStart = dom.input(attr.type('date'), attr.value(vacationDate(vacation.Start))))), dom.label('End', attr.title('If set, replies are only sent for messages arriving before the start of this day (UTC).'), dom.div(vacationEnd = dom.input(attr.type('date'), attr.value(vacationDate(vacation.End))))), dom.label('Interval in days', attr.title('Minimum number of days between replies to the same sender. If 0, the default of 7 days is used.'), dom.div(vacationIntervalDays = dom.input(attr.type('number'), attr.min('0'), attr.max('365'), attr.value('' + vacation.IntervalDays))))), dom.label(style({ display: 'block', marginTop: '.5ex' }), 'Subject', dom.div(vacationSubject = dom.input(attr.value(vacation.Subject), style({ width: '100%', maxWidth: '50em' })))), dom.label(style({ display: 'block', marginTop: '.5ex' }), 'Message', dom.div(vacationBody = dom.textarea(vacation.Body, attr.rows('8'), style({ width: '100%', maxWidth: '50em' })))), dom.div(style({ marginTop: '.5ex' }), dom.submitbutton('Save')))), dom.br(), dom.h2('Webhooks'), dom.h3('Outgoing', attr.title('Webhooks for outgoing messages are called for each attempt to deliver a message in the outgoing queue, e.g. when the queue has delivered a message to the next hop, when a single attempt failed with a temporary error, when delivery permanently failed, or when DSN (delivery status notification) messages were received about a previously sent message.')), dom.form(async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
 		await check(outgoingWebhookFieldset, client.OutgoingWebhookSave(outgoingWebhookURL.value, outgoingWebhookAuthorization.value, [...outgoingWebhookEvents.selectedOptions].map(o => o.value)));
@@ -1832,6 +2386,48 @@ const loginattempts = async () => {
 	const loginAttempts = await client.LoginAttempts(0);
 	return dom.div(crumbs(crumblink('Mox Account', '#'), 'Login attempts'), dom.h2('Login attempts'), dom.p('Login attempts are stored for 30 days. At most 10000 failed login attempts are stored to prevent unlimited growth of the database.'), renderLoginAttempts(loginAttempts || []));
 };
+const sessions = async () => {
+	const [sessions0, currentID] = await client.Sessions();
+	const sessions = sessions0 || [];
+	return dom.div(crumbs(crumblink('Mox Account', '#'), 'Sessions'), dom.h2('Sessions'), dom.p('Sessions of the account and mail web interfaces. Sessions expire after 24 hours of inactivity. Changing your password revokes all other sessions.'), dom.table(dom.thead(dom.tr(dom.th('Login'), dom.th('Last used'), dom.th('Login address'), dom.th('Remote IP'), dom.th('User Agent'), dom.th('Action'))), dom.tbody(sessions.map(s => dom.tr(dom.td(age(s.Created)), dom.td(s.LastUsed.getTime() > 0 ? age(s.LastUsed) : []), dom.td(s.LoginAddress), dom.td(s.RemoteIP), dom.td(s.UserAgent), dom.td(s.ID === currentID ? 'Current session' :
+		dom.clickbutton('Revoke', async function click(e) {
+			if (!window.confirm('Are you sure you want to revoke this session?')) {
+				return;
+			}
+			await check(e.target, client.SessionRevoke(s.ID));
+			window.location.reload(); // todo: reload less
+		})))))), dom.br(), dom.clickbutton('Revoke all other sessions', sessions.length <= 1 ? attr.disabled('') : [], async function click(e) {
+		if (!window.confirm('Are you sure you want to revoke all other sessions?')) {
+			return;
+		}
+		await check(e.target, client.SessionsRevokeOthers());
+		window.location.reload(); // todo: reload less
+	}));
+};
+const shares = async () => {
+	const [shares0, accesses0] = await client.MessageShares();
+	const shares = shares0 || [];
+	const accesses = accesses0 || [];
+	return dom.div(crumbs(crumblink('Mox Account', '#'), 'Share links'), dom.h2('Share links'), dom.p('Share links are created in webmail, and give access to a single message without authentication until they expire. Expired links are removed after 30 days.'), dom.table(dom.thead(dom.tr(dom.th('Created'), dom.th('Subject'), dom.th('Type'), dom.th('Expires', attr.title('Negative values are in the future.')), dom.th('Accesses'), dom.th('Last access'), dom.th('Action'))), dom.tbody(shares.length ? [] : dom.tr(dom.td(attr.colspan('7'), 'No share links.')), shares.map((ms, i) => dom.tr(dom.td(age(ms.Created)), dom.td(ms.Subject), dom.td(ms.Raw ? 'Raw message' : 'Text'), dom.td(ms.Expires.getTime() <= new Date().getTime() ? box(red, 'expired') : age(ms.Expires)), dom.td('' + ms.Accesses), dom.td(ms.Accesses ? age(ms.LastAccess) : []), dom.td((accesses[i] || []).length === 0 ? [] : [
+		dom.clickbutton('Show accesses', function click() {
+			popup(dom.h1('Accesses of share link'), dom.table(dom.thead(dom.tr(dom.th('Time'), dom.th('Remote IP'), dom.th('User Agent'))), dom.tbody((accesses[i] || []).map(a => dom.tr(dom.td(age(a.Time)), dom.td(a.RemoteIP), dom.td(a.UserAgent))))));
+		}),
+		' ',
+	], dom.clickbutton('Revoke', async function click(e) {
+		if (!window.confirm('Are you sure you want to revoke this share link?')) {
+			return;
+		}
+		await check(e.target, client.MessageShareRevoke(ms.ID));
+		window.location.reload(); // todo: reload less
+	})))))));
+};
+const senderallow = async () => {
+	const allows = await client.SenderAllows() || [];
+	return dom.div(crumbs(crumblink('Mox Account', '#'), 'Sender allow list'), dom.h2('Sender allow list'), dom.p('Messages from these addresses and domains are accepted without reputation and junk filter analysis, if the From address is verified through SPF and/or DKIM, aligned per DMARC. Entries are added when moving messages out of the rejects mailbox, either automatically or after confirming in webmail.'), dom.table(dom.thead(dom.tr(dom.th('Created'), dom.th('Address or domain'), dom.th('Message ID', attr.title('Message the entry was added for.')), dom.th('Action'))), dom.tbody(allows.length ? [] : dom.tr(dom.td(attr.colspan('4'), 'No entries.')), allows.map(sa => dom.tr(dom.td(age(sa.Created)), dom.td(sa.Localpart ? sa.Localpart + '@' + sa.Domain : sa.Domain), dom.td(sa.MessageID ? '' + sa.MessageID : ''), dom.td(dom.clickbutton('Remove', async function click(e) {
+		await check(e.target, client.SenderAllowRemove(sa.ID));
+		window.location.reload(); // todo: reload less
+	})))))));
+};
 const destination = async (name) => {
 	const [acc] = await client.Account();
 	let dest = (acc.Destinations || {})[name];
@@ -1840,6 +2436,9 @@ const destination = async (name) => {
 	}
 	let rulesetsTbody = dom.tbody();
 	let rulesetsRows = [];
+	// Select with an empty option for "any", and the possible values.
+	const resultSelect = (v, values) => dom.select(dom.option('', attr.value('')), values.map(s => dom.option(s, attr.value(s), v === s ? attr.selected('') : [])));
+	const splitList = (s) => s.split(',').map(s => s.trim()).filter(s => !!s);
 	const addRulesetsRow = (rs) => {
 		let row;
 		let headersCell = dom.td();
@@ -1867,12 +2466,23 @@ const destination = async (name) => {
 		let smtpMailFromRegexp;
 		let msgFromRegexp;
 		let verifiedDomain;
+		let msgFromPatterns;
+		let spfResult;
+		let dkimResult;
+		let dmarcResult;
+		let minSize;
+		let maxSize;
+		let attachments;
+		let listID;
 		let isForward; // Checkbox
 		let listAllowDomain;
 		let acceptRejectsToMailbox;
 		let mailbox;
+		let markSeen; // Checkbox
+		let forwardTo;
+		let discard; // Checkbox
 		let comment;
-		const root = dom.tr(dom.td(smtpMailFromRegexp = dom.input(attr.value(rs.SMTPMailFromRegexp || ''))), dom.td(msgFromRegexp = dom.input(attr.value(rs.MsgFromRegexp || ''))), dom.td(verifiedDomain = dom.input(attr.value(rs.VerifiedDomain || ''))), headersCell, dom.td(dom.label(isForward = dom.input(attr.type('checkbox'), rs.IsForward ? attr.checked('') : []))), dom.td(listAllowDomain = dom.input(attr.value(rs.ListAllowDomain || ''))), dom.td(acceptRejectsToMailbox = dom.input(attr.value(rs.AcceptRejectsToMailbox || ''))), dom.td(mailbox = dom.input(attr.value(rs.Mailbox || ''))), dom.td(comment = dom.input(attr.value(rs.Comment || ''))), dom.td(dom.clickbutton('Remove ruleset', function click() {
+		const root = dom.tr(dom.td(smtpMailFromRegexp = dom.input(attr.value(rs.SMTPMailFromRegexp || ''))), dom.td(msgFromRegexp = dom.input(attr.value(rs.MsgFromRegexp || ''))), dom.td(verifiedDomain = dom.input(attr.value(rs.VerifiedDomain || ''))), headersCell, dom.td(msgFromPatterns = dom.input(attr.value((rs.MsgFromPatterns || []).join(', ')))), dom.td(spfResult = resultSelect(rs.SPFResult, ['pass', 'fail', 'softfail', 'neutral', 'none', 'temperror', 'permerror'])), dom.td(dkimResult = resultSelect(rs.DKIMResult, ['pass', 'none'])), dom.td(dmarcResult = resultSelect(rs.DMARCResult, ['pass', 'fail'])), dom.td(minSize = dom.input(attr.type('number'), attr.min('0'), style({ width: '7em' }), attr.value(rs.MinSize ? '' + rs.MinSize : ''))), dom.td(maxSize = dom.input(attr.type('number'), attr.min('0'), style({ width: '7em' }), attr.value(rs.MaxSize ? '' + rs.MaxSize : ''))), dom.td(attachments = resultSelect(rs.Attachments, ['yes', 'no'])), dom.td(listID = dom.input(attr.value(rs.ListID || ''))), dom.td(dom.label(isForward = dom.input(attr.type('checkbox'), rs.IsForward ? attr.checked('') : []))), dom.td(listAllowDomain = dom.input(attr.value(rs.ListAllowDomain || ''))), dom.td(acceptRejectsToMailbox = dom.input(attr.value(rs.AcceptRejectsToMailbox || ''))), dom.td(mailbox = dom.input(attr.value(rs.Mailbox || ''))), dom.td(dom.label(markSeen = dom.input(attr.type('checkbox'), rs.MarkSeen ? attr.checked('') : []))), dom.td(forwardTo = dom.input(attr.value((rs.ForwardTo || []).join(', ')))), dom.td(dom.label(discard = dom.input(attr.type('checkbox'), rs.Discard ? attr.checked('') : []))), dom.td(comment = dom.input(attr.value(rs.Comment || ''))), dom.td(dom.clickbutton('Remove ruleset', function click() {
 			row.root.remove();
 			rulesetsRows = rulesetsRows.filter(e => e !== row);
 		})));
@@ -1882,10 +2492,21 @@ const destination = async (name) => {
 			msgFromRegexp: msgFromRegexp,
 			verifiedDomain: verifiedDomain,
 			headers: [],
+			msgFromPatterns: msgFromPatterns,
+			spfResult: spfResult,
+			dkimResult: dkimResult,
+			dmarcResult: dmarcResult,
+			minSize: minSize,
+			maxSize: maxSize,
+			attachments: attachments,
+			listID: listID,
 			isForward: isForward,
 			listAllowDomain: listAllowDomain,
 			acceptRejectsToMailbox: acceptRejectsToMailbox,
 			mailbox: mailbox,
+			markSeen: markSeen,
+			forwardTo: forwardTo,
+			discard: discard,
 			comment: comment,
 		};
 		rulesetsRows.push(row);
@@ -1906,18 +2527,31 @@ const destination = async (name) => {
 	let fullName;
 	let smtpError;
 	let msgAuthRequiredSMTPError;
+	let forwardToAddresses;
+	let forwardKeepCopy;
 	let saveButton;
 	const addresses = [name, ...Object.keys(acc.Destinations || {}).filter(a => !a.startsWith('@') && a !== name)];
-	return dom.div(crumbs(crumblink('Mox Account', '#'), 'Destination ' + name), dom.div(dom.span('Default mailbox', attr.title('Default mailbox where email for this recipient is delivered to if it does not match any ruleset. Default is Inbox.')), dom.br(), defaultMailbox = dom.input(attr.value(dest.Mailbox), attr.placeholder('Inbox'))), dom.br(), dom.div(dom.span('Full name', attr.title('Name to use in From header when composing messages. If not set, the account default full name is used.')), dom.br(), fullName = dom.input(attr.value(dest.FullName))), dom.br(), dom.div(dom.span('Reject deliveries with SMTP Error', attr.title('If non-empty, incoming delivery attempts to this destination will be rejected during SMTP RCPT TO with this error response line. The response line must start with an error code. Currently the following error resonse codes are allowed: 421 (temporary local error), 550 (mailbox not found). If the line consists of only an error code, an appropriate error message is added. Rejecting messages with a 4xx code invites later retries by the remote, while 5xx codes should prevent further delivery attempts.')), dom.br(), smtpError = dom.input(attr.value(dest.SMTPError), attr.placeholder('421 or 550...'))), dom.br(), dom.div(dom.span('Reject messages without authenticated domain (aligned SPF/DKIM)', attr.title("If non-empty, an additional DMARC-like message authentication check is done for incoming messages, validating the domain in the From-header of the message. Messages without either an aligned SPF or aligned DKIM pass are rejected during the SMTP DATA command with a permanent error code followed by the message in this field. The domain in the message 'From' header is matched in relaxed or strict mode according to the domain's DMARC policy if present, or relaxed mode (organizational instead of exact domain match) otherwise. Useful for autoresponders that don't want to accept messages they don't want to send an automated reply to.")), dom.br(), msgAuthRequiredSMTPError = dom.input(attr.value(dest.MessageAuthRequiredSMTPError), attr.placeholder('messages must have aligned spf/dkim for domain authentication...'))), dom.br(), dom.h2('Rulesets'), dom.p('Incoming messages are checked against the rulesets. If a ruleset matches, the message is delivered to the mailbox configured for the ruleset instead of to the default mailbox.'), dom.p('"Is Forward" does not affect matching, but changes prevents the sending mail server from being included in future junk classifications by clearing fields related to the forwarding email server (IP address, EHLO domain, MAIL FROM domain and a matching DKIM domain), and prevents DMARC rejects for forwarded messages.'), dom.p('"List allow domain" does not affect matching, but skips the regular spam checks if one of the verified domains is a (sub)domain of the domain mentioned here.'), dom.p('"Accept rejects to mailbox" does not affect matching, but causes messages classified as junk to be accepted and delivered to this mailbox, instead of being rejected during the SMTP transaction. Useful for incoming forwarded messages where rejecting incoming messages may cause the forwarding server to stop forwarding.'), dom.table(dom.thead(dom.tr(dom.th('SMTP "MAIL FROM" regexp', attr.title('Matches if this regular expression matches (a substring of) the SMTP MAIL FROM address (not the message From-header). E.g. user@example.org.')), dom.th('Message "From" address regexp', attr.title('Matches if this regular expression matches (a substring of) the single address in the message From header.')), dom.th('Verified domain', attr.title('Matches if this domain matches an SPF- and/or DKIM-verified (sub)domain.')), dom.th('Headers regexp', attr.title('Matches if these header field/value regular expressions all match (substrings of) the message headers. Header fields and valuees are converted to lower case before matching. Whitespace is trimmed from the value before matching. A header field can occur multiple times in a message, only one instance has to match. For mailing lists, you could match on ^list-id$ with the value typically the mailing list address in angled brackets with @ replaced with a dot, e.g. <name\\.lists\\.example\\.org>.')), dom.th('Is Forward', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. Can only be used together with SMTPMailFromRegexp and VerifiedDomain. SMTPMailFromRegexp must be set to the address used to deliver the forwarded message, e.g. '^user(|\\+.*)@forward\\.example$'. Changes to junk analysis: 1. Messages are not rejected for failing a DMARC policy, because a legitimate forwarded message without valid/intact/aligned DKIM signature would be rejected because any verified SPF domain will be 'unaligned', of the forwarding mail server. 2. The sending mail server IP address, and sending EHLO and MAIL FROM domains and matching DKIM domain aren't used in future reputation-based spam classifications (but other verified DKIM domains are) because the forwarding server is not a useful spam signal for future messages.")), dom.th('List allow domain', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. If this domain matches an SPF- and/or DKIM-verified (sub)domain, the message is accepted without further spam checks, such as a junk filter or DMARC reject evaluation. DMARC rejects should not apply for mailing lists that are not configured to rewrite the From-header of messages that don't have a passing DKIM signature of the From-domain. Otherwise, by rejecting messages, you may be automatically unsubscribed from the mailing list. The assumption is that mailing lists do their own spam filtering/moderation.")), dom.th('Allow rejects to mailbox', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. If a message is classified as spam, it isn't rejected during the SMTP transaction (the normal behaviour), but accepted during the SMTP transaction and delivered to the specified mailbox. The specified mailbox is not automatically cleaned up like the account global Rejects mailbox, unless set to that Rejects mailbox.")), dom.th('Mailbox', attr.title('Mailbox to deliver to if this ruleset matches.')), dom.th('Comment', attr.title('Free-form comments.')), dom.th('Action'))), rulesetsTbody, dom.tfoot(dom.tr(dom.td(attr.colspan('9')), dom.td(dom.clickbutton('Add ruleset', function click() {
+	return dom.div(crumbs(crumblink('Mox Account', '#'), 'Destination ' + name), dom.div(dom.span('Default mailbox', attr.title('Default mailbox where email for this recipient is delivered to if it does not match any ruleset. Default is Inbox.')), dom.br(), defaultMailbox = dom.input(attr.value(dest.Mailbox), attr.placeholder('Inbox'))), dom.br(), dom.div(dom.span('Full name', attr.title('Name to use in From header when composing messages. If not set, the account default full name is used.')), dom.br(), fullName = dom.input(attr.value(dest.FullName))), dom.br(), dom.div(dom.span('Reject deliveries with SMTP Error', attr.title('If non-empty, incoming delivery attempts to this destination will be rejected during SMTP RCPT TO with this error response line. The response line must start with an error code. Currently the following error resonse codes are allowed: 421 (temporary local error), 550 (mailbox not found). If the line consists of only an error code, an appropriate error message is added. Rejecting messages with a 4xx code invites later retries by the remote, while 5xx codes should prevent further delivery attempts.')), dom.br(), smtpError = dom.input(attr.value(dest.SMTPError), attr.placeholder('421 or 550...'))), dom.br(), dom.div(dom.span('Reject messages without authenticated domain (aligned SPF/DKIM)', attr.title("If non-empty, an additional DMARC-like message authentication check is done for incoming messages, validating the domain in the From-header of the message. Messages without either an aligned SPF or aligned DKIM pass are rejected during the SMTP DATA command with a permanent error code followed by the message in this field. The domain in the message 'From' header is matched in relaxed or strict mode according to the domain's DMARC policy if present, or relaxed mode (organizational instead of exact domain match) otherwise. Useful for autoresponders that don't want to accept messages they don't want to send an automated reply to.")), dom.br(), msgAuthRequiredSMTPError = dom.input(attr.value(dest.MessageAuthRequiredSMTPError), attr.placeholder('messages must have aligned spf/dkim for domain authentication...'))), dom.br(), dom.div(dom.span('Forward to', attr.title('Comma-separated addresses to forward incoming messages for this address to, typically at other domains. The SMTP MAIL FROM of forwarded messages is rewritten to an address at this domain with SRS (Sender Rewriting Scheme), so SPF checks at the receiving mail server can pass, and delivery failure notifications are sent on to the original sender. Messages classified as junk and DSNs are not forwarded.')), dom.br(), forwardToAddresses = dom.input(attr.value((dest.ForwardTo || []).join(', ')), attr.placeholder('user@example.org, ...')), ' ', dom.label(forwardKeepCopy = dom.input(attr.type('checkbox'), dest.ForwardKeepCopy ? attr.checked('') : []), ' Keep a copy', attr.title('Also deliver forwarded messages to the mailbox of this address.'))), dom.br(), dom.h2('Rulesets'), dom.p('Incoming messages are checked against the rulesets. If a ruleset matches, the message is delivered to the mailbox configured for the ruleset instead of to the default mailbox.'), dom.p('"Is Forward" does not affect matching, but changes prevents the sending mail server from being included in future junk classifications by clearing fields related to the forwarding email server (IP address, EHLO domain, MAIL FROM domain and a matching DKIM domain), and prevents DMARC rejects for forwarded messages.'), dom.p('"List allow domain" does not affect matching, but skips the regular spam checks if one of the verified domains is a (sub)domain of the domain mentioned here.'), dom.p('"Accept rejects to mailbox" does not affect matching, but causes messages classified as junk to be accepted and delivered to this mailbox, instead of being rejected during the SMTP transaction. Useful for incoming forwarded messages where rejecting incoming messages may cause the forwarding server to stop forwarding.'), dom.p('Besides delivering to the mailbox, a matching ruleset can mark the message as read, forward a copy to other addresses, or discard the message instead of delivering it.'), dom.table(dom.thead(dom.tr(dom.th('SMTP "MAIL FROM" regexp', attr.title('Matches if this regular expression matches (a substring of) the SMTP MAIL FROM address (not the message From-header). E.g. user@example.org.')), dom.th('Message "From" address regexp', attr.title('Matches if this regular expression matches (a substring of) the single address in the message From header.')), dom.th('Verified domain', attr.title('Matches if this domain matches an SPF- and/or DKIM-verified (sub)domain.')), dom.th('Headers regexp', attr.title('Matches if these header field/value regular expressions all match (substrings of) the message headers. Header fields and valuees are converted to lower case before matching. Whitespace is trimmed from the value before matching. A header field can occur multiple times in a message, only one instance has to match. For mailing lists, you could match on ^list-id$ with the value typically the mailing list address in angled brackets with @ replaced with a dot, e.g. <name\\.lists\\.example\\.org>.')), dom.th('Message "From" patterns', attr.title("Matches if the single address in the message From header matches one of these comma-separated patterns. A '*' matches any sequence of characters, all other characters match literally. Matching is case-insensitive. E.g. '*@example.org' or 'notifications-*@*.example.org'.")), dom.th('SPF', attr.title('Matches if the SPF result for the SMTP MAIL FROM domain is this value.')), dom.th('DKIM', attr.title('Matches if the message has at least one verified DKIM signature (pass), or none (none).')), dom.th('DMARC', attr.title('Matches if the domain of the message From header is authenticated with an aligned SPF and/or DKIM pass, as used by DMARC (pass), or not (fail).')), dom.th('Min size', attr.title('Matches if the size of the message in bytes is at least this value.')), dom.th('Max size', attr.title('Matches if the size of the message in bytes is at most this value.')), dom.th('Attachments', attr.title('Matches if the message has attachments (yes), or not (no). Message parts with a Content-Disposition of attachment, or with a filename, are considered attachments.')), dom.th('List-Id', attr.title('Matches if the List-Id header of the message has this list identifier, without angle brackets, e.g. name.lists.example.org. Matching is case-insensitive.')), dom.th('Is Forward', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. Can only be used together with SMTPMailFromRegexp and VerifiedDomain. SMTPMailFromRegexp must be set to the address used to deliver the forwarded message, e.g. '^user(|\\+.*)@forward\\.example$'. Changes to junk analysis: 1. Messages are not rejected for failing a DMARC policy, because a legitimate forwarded message without valid/intact/aligned DKIM signature would be rejected because any verified SPF domain will be 'unaligned', of the forwarding mail server. 2. The sending mail server IP address, and sending EHLO and MAIL FROM domains and matching DKIM domain aren't used in future reputation-based spam classifications (but other verified DKIM domains are) because the forwarding server is not a useful spam signal for future messages.")), dom.th('List allow domain', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. If this domain matches an SPF- and/or DKIM-verified (sub)domain, the message is accepted without further spam checks, such as a junk filter or DMARC reject evaluation. DMARC rejects should not apply for mailing lists that are not configured to rewrite the From-header of messages that don't have a passing DKIM signature of the From-domain. Otherwise, by rejecting messages, you may be automatically unsubscribed from the mailing list. The assumption is that mailing lists do their own spam filtering/moderation.")), dom.th('Allow rejects to mailbox', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. If a message is classified as spam, it isn't rejected during the SMTP transaction (the normal behaviour), but accepted during the SMTP transaction and delivered to the specified mailbox. The specified mailbox is not automatically cleaned up like the account global Rejects mailbox, unless set to that Rejects mailbox.")), dom.th('Mailbox', attr.title('Mailbox to deliver to if this ruleset matches. Required unless Discard is set.')), dom.th('Mark read', attr.title('Mark the delivered message as read.')), dom.th('Forward to', attr.title('Comma-separated addresses to forward a copy of the message to, with the address of this destination as SMTP MAIL FROM. The message is forwarded unmodified, so it may fail DMARC checks at the recipient. Messages that are rejected or accepted as reject, and DSNs, are not forwarded.')), dom.th('Discard', attr.title("Accept the message during the SMTP transaction, but don't deliver it to a mailbox. Can be combined with forwarding.")), dom.th('Comment', attr.title('Free-form comments.')), dom.th('Action'))), rulesetsTbody, dom.tfoot(dom.tr(dom.td(attr.colspan('20')), dom.td(dom.clickbutton('Add ruleset', function click() {
 		addRulesetsRow({
 			SMTPMailFromRegexp: '',
 			MsgFromRegexp: '',
 			VerifiedDomain: '',
 			HeadersRegexp: {},
+			MsgFromPatterns: [],
+			SPFResult: '',
+			DKIMResult: '',
+			DMARCResult: '',
+			MinSize: 0,
+			MaxSize: 0,
+			Attachments: '',
+			ListID: '',
 			IsForward: false,
 			ListAllowDomain: '',
 			AcceptRejectsToMailbox: '',
 			Mailbox: '',
+			MarkSeen: false,
+			ForwardTo: [],
+			Discard: false,
 			Comment: '',
 			VerifiedDNSDomain: { ASCII: '', Unicode: '' },
 			ListAllowDNSDomain: { ASCII: '', Unicode: '' },
@@ -1932,10 +2566,21 @@ const destination = async (name) => {
 					MsgFromRegexp: row.msgFromRegexp.value,
 					VerifiedDomain: row.verifiedDomain.value,
 					HeadersRegexp: Object.fromEntries(row.headers.map(h => [h.key.value, h.value.value])),
+					MsgFromPatterns: splitList(row.msgFromPatterns.value),
+					SPFResult: row.spfResult.value,
+					DKIMResult: row.dkimResult.value,
+					DMARCResult: row.dmarcResult.value,
+					MinSize: parseInt(row.minSize.value || '0'),
+					MaxSize: parseInt(row.maxSize.value || '0'),
+					Attachments: row.attachments.value,
+					ListID: row.listID.value,
 					IsForward: row.isForward.checked,
 					ListAllowDomain: row.listAllowDomain.value,
 					AcceptRejectsToMailbox: row.acceptRejectsToMailbox.value,
 					Mailbox: row.mailbox.value,
+					MarkSeen: row.markSeen.checked,
+					ForwardTo: splitList(row.forwardTo.value),
+					Discard: row.discard.checked,
 					Comment: row.comment.value,
 					VerifiedDNSDomain: { ASCII: '', Unicode: '' },
 					ListAllowDNSDomain: { ASCII: '', Unicode: '' },
@@ -1943,6 +2588,8 @@ const destination = async (name) => {
 			}),
 			SMTPError: smtpError.value,
 			MessageAuthRequiredSMTPError: msgAuthRequiredSMTPError.value,
+			ForwardTo: splitList(forwardToAddresses.value),
+			ForwardKeepCopy: forwardKeepCopy.checked,
 		};
 		await check(saveButton, client.DestinationSave(name, dest, newDest));
 		window.location.reload(); // todo: only refresh part of ui
@@ -1969,6 +2616,15 @@ const init = async () => {
 			else if (t[0] === 'loginattempts' && t.length === 1) {
 				root = await loginattempts();
 			}
+			else if (t[0] === 'sessions' && t.length === 1) {
+				root = await sessions();
+			}
+			else if (t[0] === 'shares' && t.length === 1) {
+				root = await shares();
+			}
+			else if (t[0] === 'senderallow' && t.length === 1) {
+				root = await senderallow();
+			}
 			else if (t[0] === 'destinations' && t.length === 2) {
 				root = await destination(t[1]);
 			}
@@ -17,6 +17,8 @@ const login = async (reason: string) => {
 		let autosize: HTMLElement
 		let username: HTMLInputElement
 		let password: HTMLInputElement
+		let totpLabel: HTMLElement
+		let totp: HTMLInputElement
 
 		const root = dom.div(
 			style({position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: '1', animation: 'fadein .15s ease-in'}),
@@ -35,7 +37,7 @@ const login = async (reason: string) => {
 							try {
 								fieldset.disabled = true
 								const loginToken = await client.LoginPrep()
-								const token = await client.Login(loginToken, username.value, password.value)
+								const token = await client.Login(loginToken, username.value, password.value, totp.value)
 								try {
 									window.localStorage.setItem('webaccountaddress', username.value)
 									window.localStorage.setItem('webaccountcsrftoken', token)
@@ -49,6 +51,14 @@ const login = async (reason: string) => {
 								resolve(token)
 							} catch (err) {
 								console.log('login error', err)
+								if ((err as any).code === 'user:totpRequired') {
+									// Password was correct, ask for two-factor authentication code and retry.
+									totpLabel.style.display = 'block'
+									totp.required = true
+									fieldset.disabled = false
+									totp.focus()
+									return
+								}
 								window.alert('Error: ' + errmsg(err))
 							} finally {
 								fieldset.disabled = false
@@ -74,6 +84,11 @@ const login = async (reason: string) => {
 								dom.div('Password', style({marginBottom: '.5ex'})),
 								password=dom.input(attr.type('password'), attr.autocomplete('current-password'), attr.required('')),
 							),
+							totpLabel=dom.label(
+								style({display: 'none', marginBottom: '2ex'}),
+								dom.div('Two-factor authentication code', style({marginBottom: '.5ex'})),
+								totp=dom.input(attr.autocomplete('one-time-code'), attr.title('Code from authenticator app, or a recovery code.')),
+							),
 							dom.div(
 								style({textAlign: 'center'}),
 								dom.submitbutton('Login'),
@@ -300,12 +315,24 @@ const formatQuotaSize = (v: number) => {
 }
 
 const index = async () => {
-	const [[acc, storageUsed, storageLimit, suppressions], tlspubkeys0, recentLoginAttempts] = await Promise.all([
+	const [[acc, storageUsed, storageLimit, suppressions], tlspubkeys0, recentLoginAttempts, openpgpkeys0, keyring0, oauthtokens0, [totpEnabled0, totpRecoveryCodes0], vacation, apitokens0] = await Promise.all([
 		client.Account(),
 		client.TLSPublicKeys(),
 		client.LoginAttempts(10),
+		client.OpenPGPKeys(),
+		client.OpenPGPKeyring(),
+		client.OAuthTokens(),
+		client.TOTPStatus(),
+		client.Vacation(),
+		client.APITokens(),
 	])
 	const tlspubkeys = tlspubkeys0 || []
+	const openpgpkeys = openpgpkeys0 || []
+	const keyring = keyring0 || []
+	let oauthtokens = oauthtokens0 || []
+	let apitokens = apitokens0 || []
+	let totpEnabled = totpEnabled0
+	let totpRecoveryCodes = totpRecoveryCodes0
 
 	let fullNameForm: HTMLFormElement
 	let fullNameFieldset: HTMLFieldSetElement
@@ -323,6 +350,9 @@ const index = async () => {
 	let notJunkMailboxRegexp: HTMLInputElement
 
 	let junkFilterFields: HTMLFieldSetElement
+	let junkRescanFieldset: HTMLFieldSetElement
+	let junkRescanDays: HTMLInputElement
+	let junkRescanResult: HTMLElement
 	let junkFilterEnabled: HTMLInputElement
 	let junkThreshold: HTMLInputElement
 	let junkOnegrams: HTMLInputElement
@@ -333,8 +363,22 @@ const index = async () => {
 	let junkRareWords: HTMLInputElement
 
 	let rejectsFieldset: HTMLFieldSetElement
+	let mailboxRetentionFieldset: HTMLFieldSetElement
 	let rejectsMailbox: HTMLInputElement
 	let keepRejects: HTMLInputElement
+	let rejectsRescueAllow: HTMLSelectElement
+
+	let vacationFieldset: HTMLFieldSetElement
+	let vacationActive: HTMLInputElement
+	let vacationStart: HTMLInputElement
+	let vacationEnd: HTMLInputElement
+	let vacationSubject: HTMLInputElement
+	let vacationBody: HTMLTextAreaElement
+	let vacationIntervalDays: HTMLInputElement
+
+	// Date inputs work with yyyy-mm-dd, we store times in UTC, with zero time for "not set".
+	const vacationDate = (t: Date) => t.getUTCFullYear() <= 1 ? '' : t.toISOString().substring(0, 10)
+	const vacationParseDate = (s: string) => new Date(s ? s+'T00:00:00Z' : '0001-01-01T00:00:00Z')
 
 	let outgoingWebhookFieldset: HTMLFieldSetElement
 	let outgoingWebhookURL: HTMLInputElement
@@ -832,6 +876,14 @@ const index = async () => {
 		dom.br(),
 		recentLoginAttempts && recentLoginAttempts.length >= 10 ? dom.p('See ', dom.a(attr.href('#loginattempts'), 'all login attempts'), '.') : dom.br(),
 
+		dom.h2('Share links'),
+		dom.p('Links to messages, created in webmail, can be viewed by anyone with the link until they expire. See ', dom.a(attr.href('#shares'), 'share links'), ' to inspect their use or revoke them.'),
+		dom.br(),
+
+		dom.h2('Sessions'),
+		dom.p('Logins to the account and mail web interfaces create sessions. See ', dom.a(attr.href('#sessions'), 'sessions'), ' for the devices you are logged in with, and to revoke sessions.'),
+		dom.br(),
+
 		dom.h2('Change password'),
 		acc.NoCustomPassword ?
 			dom.div(
@@ -896,6 +948,82 @@ const index = async () => {
 			),
 		dom.br(),
 
+		dom.h2('Two-factor authentication'),
+		dom.p('With two-factor authentication enabled, logging in to the account and webmail web interfaces requires a code from an authenticator app on your phone, in addition to your password. Recovery codes can be used instead of a code, once each, in case you lose access to the app. Email applications logging in with IMAP and SMTP submission are not affected.'),
+		(() => {
+			let elem = dom.div()
+
+			const render = () => {
+				const e = dom.div(
+					totpEnabled ?
+						dom.div(
+							dom.p('Two-factor authentication is ', dom.b('enabled'), '. Remaining recovery codes: ', ''+totpRecoveryCodes, '.'),
+							dom.clickbutton('Disable two-factor authentication', async function click(e: {target: HTMLButtonElement}) {
+								const code = window.prompt('Enter a code from your authenticator app or a recovery code to disable two-factor authentication.')
+								if (!code) {
+									return
+								}
+								await check(e.target, client.TOTPDisable(code))
+								totpEnabled = false
+								totpRecoveryCodes = 0
+								render()
+							}),
+						) :
+						dom.div(
+							dom.p('Two-factor authentication is not enabled.'),
+							dom.clickbutton('Enable two-factor authentication', async function click(e: {target: HTMLButtonElement}) {
+								const [secret, uri] = await check(e.target, client.TOTPEnrollStart())
+
+								let code: HTMLInputElement
+								let box: HTMLElement
+								popup(
+									box=dom.div(
+										style({maxWidth: '45em'}),
+										dom.h1('Enable two-factor authentication'),
+										dom.p('Add the secret below to your authenticator app, by opening the link on your phone or by entering the secret manually. Then enter the code shown by the app to confirm.'),
+										dom.div(dom.b('Secret')),
+										dom.div(dom._class('literal'), secret),
+										dom.br(),
+										dom.div(dom.a(attr.href(uri), 'Open in authenticator app')),
+										dom.br(),
+										dom.form(
+											async function submit(e: SubmitEvent & {target: {disabled: boolean}}) {
+												e.preventDefault()
+												e.stopPropagation()
+												const codes = await check(e.target, client.TOTPEnrollConfirm(code.value)) || []
+												totpEnabled = true
+												totpRecoveryCodes = codes.length
+												render()
+												box.replaceChildren(
+													dom.h1('Two-factor authentication enabled'),
+													dom.p('Store the recovery codes below securely, for example in a password manager. Each can be used once to log in instead of a code from your authenticator app. They cannot be shown again.'),
+													dom.div(dom._class('literal'), codes.join('\n')),
+												)
+											},
+											dom.label(
+												style({display: 'block', marginBottom: '1ex'}),
+												dom.div(dom.b('Code')),
+												code=dom.input(attr.required(''), attr.autocomplete('one-time-code')),
+											),
+											dom.submitbutton('Confirm'),
+										),
+									),
+								)
+								code.focus()
+							}),
+						),
+				)
+
+				if (elem) {
+					elem.replaceWith(e)
+				}
+				elem = e
+			}
+			render()
+			return elem
+		})(),
+		dom.br(),
+
 		dom.h2('TLS public keys'),
 		dom.p('For TLS client authentication with certificates, for IMAP and/or submission (SMTP). Only the public key of the certificate is used during TLS authentication, to identify this account. Names, expiration or constraints are not verified.'),
 		(() => {
@@ -1089,6 +1217,393 @@ openssl pkcs12 \\
 		})(),
 		dom.br(),
 
+		dom.h2('OpenPGP keys'),
+		dom.p('OpenPGP public keys for your addresses are published through the Web Key Directory (WKD) and can be added as OPENPGPKEY DNS records by the administrator, so correspondents can find your key automatically. The key must have a user ID with the email address.'),
+		(() => {
+			let elem = dom.div()
+
+			const render = () => {
+				const e = dom.div(
+					dom.table(
+						dom.thead(
+							dom.tr(
+								dom.th('Address'),
+								dom.th('Fingerprint'),
+								dom.th('User IDs'),
+								dom.th('Added'),
+								dom.th('Remove'),
+							),
+						),
+						dom.tbody(
+							openpgpkeys.length === 0 ? dom.tr(dom.td(attr.colspan('5'), 'None')) : [],
+							openpgpkeys.map(pk =>
+								dom.tr(
+									dom.td(pk.Address),
+									dom.td(pk.Fingerprint),
+									dom.td((pk.UserIDs || []).join(', ')),
+									dom.td(age(pk.Created)),
+									dom.td(
+										dom.form(
+											async function submit(e: SubmitEvent & {target: {disabled: boolean}}) {
+												e.stopPropagation()
+												e.preventDefault()
+												await check(e.target, client.OpenPGPKeyRemove(pk.Address))
+												openpgpkeys.splice(openpgpkeys.indexOf(pk), 1)
+												render()
+											},
+											dom.submitbutton('Remove'),
+										),
+									),
+								)
+							),
+						),
+					),
+					dom.clickbutton('Set key', style({marginTop: '1ex'}), function click() {
+						let address: HTMLSelectElement
+						let key: HTMLTextAreaElement
+
+						const close = popup(
+							dom.div(
+								style({maxWidth: '45em'}),
+								dom.h1('Set OpenPGP key'),
+								dom.form(
+									async function submit(e: SubmitEvent & {target: {disabled: boolean}}) {
+										e.preventDefault()
+										e.stopPropagation()
+										const npk = await check(e.target, client.OpenPGPKeySet(address.value, key.value))
+										const i = openpgpkeys.findIndex(pk => pk.Address === npk.Address)
+										if (i >= 0) {
+											openpgpkeys.splice(i, 1, npk)
+										} else {
+											openpgpkeys.push(npk)
+										}
+										render()
+										close()
+									},
+									dom.label(
+										style({display: 'block', marginBottom: '1ex'}),
+										dom.div(dom.b('Address')),
+										address=dom.select(
+											attr.required(''),
+											Object.keys(acc.Destinations || {}).filter(a => !a.startsWith('@')).sort().map(a => dom.option(a)),
+										),
+										dom.div(style({fontStyle: 'italic', marginTop: '.5ex'}), 'An existing key for the address is replaced.'),
+									),
+									dom.label(
+										style({display: 'block', marginBottom: '1ex'}),
+										dom.div(dom.b('Public key')),
+										key=dom.textarea(attr.required(''), attr.rows('10'), style({width: '100%'}), attr.placeholder('-----BEGIN PGP PUBLIC KEY BLOCK-----')),
+										dom.div(style({fontStyle: 'italic', marginTop: '.5ex'}), 'ASCII-armored public key, e.g. from "gpg --armor --export you@example.org". Never paste a private key.'),
+									),
+									dom.br(),
+									dom.submitbutton('Save'),
+								),
+							),
+						)
+					})
+				)
+
+				if (elem) {
+					elem.replaceWith(e)
+				}
+				elem = e
+			}
+			render()
+			return elem
+		})(),
+		dom.br(),
+
+		dom.h2('OpenPGP keyring'),
+		dom.p('Public keys of correspondents in your keyring are used to verify PGP signatures of incoming messages. Published keys of addresses on this server are also used. Messages with a valid signature from a key with a user ID for the From address get keyword $SignatureValid, messages with a signature that does not match get keyword $SignatureInvalid.'),
+		(() => {
+			let elem = dom.div()
+
+			const render = () => {
+				const e = dom.div(
+					dom.table(
+						dom.thead(
+							dom.tr(
+								dom.th('Fingerprint'),
+								dom.th('User IDs'),
+								dom.th('Added'),
+								dom.th('Remove'),
+							),
+						),
+						dom.tbody(
+							keyring.length === 0 ? dom.tr(dom.td(attr.colspan('4'), 'None')) : [],
+							keyring.map(k =>
+								dom.tr(
+									dom.td(k.Fingerprint),
+									dom.td((k.UserIDs || []).join(', ')),
+									dom.td(age(k.Created)),
+									dom.td(
+										dom.form(
+											async function submit(e: SubmitEvent & {target: {disabled: boolean}}) {
+												e.stopPropagation()
+												e.preventDefault()
+												await check(e.target, client.OpenPGPKeyringRemove(k.Fingerprint))
+												keyring.splice(keyring.indexOf(k), 1)
+												render()
+											},
+											dom.submitbutton('Remove'),
+										),
+									),
+								)
+							),
+						),
+					),
+					dom.clickbutton('Add key', style({marginTop: '1ex'}), function click() {
+						let key: HTMLTextAreaElement
+
+						const close = popup(
+							dom.div(
+								style({maxWidth: '45em'}),
+								dom.h1('Add OpenPGP key to keyring'),
+								dom.form(
+									async function submit(e: SubmitEvent & {target: {disabled: boolean}}) {
+										e.preventDefault()
+										e.stopPropagation()
+										const nk = await check(e.target, client.OpenPGPKeyringAdd(key.value))
+										const i = keyring.findIndex(k => k.Fingerprint === nk.Fingerprint)
+										if (i >= 0) {
+											keyring.splice(i, 1, nk)
+										} else {
+											keyring.push(nk)
+										}
+										render()
+										close()
+									},
+									dom.label(
+										style({display: 'block', marginBottom: '1ex'}),
+										dom.div(dom.b('Public key')),
+										key=dom.textarea(attr.required(''), attr.rows('10'), style({width: '100%'}), attr.placeholder('-----BEGIN PGP PUBLIC KEY BLOCK-----')),
+										dom.div(style({fontStyle: 'italic', marginTop: '.5ex'}), 'ASCII-armored public key of a correspondent, e.g. from "gpg --armor --export them@example.org".'),
+									),
+									dom.br(),
+									dom.submitbutton('Add'),
+								),
+							),
+						)
+					})
+				)
+
+				if (elem) {
+					elem.replaceWith(e)
+				}
+				elem = e
+			}
+			render()
+			return elem
+		})(),
+		dom.br(),
+
+		dom.h2('OAuth tokens'),
+		dom.p('OAuth bearer tokens can be used by email applications to log in with IMAP and SMTP submission using the OAUTHBEARER or XOAUTH2 authentication mechanisms, instead of with a password. Each application can get its own token, and a token can be removed without changing your password.'),
+		(() => {
+			let elem = dom.div()
+
+			const render = () => {
+				const e = dom.div(
+					dom.table(
+						dom.thead(
+							dom.tr(
+								dom.th('Name'),
+								dom.th('Login address'),
+								dom.th('Created'),
+								dom.th('Expires'),
+								dom.th('Last used'),
+								dom.th('Remove'),
+							),
+						),
+						dom.tbody(
+							oauthtokens.length === 0 ? dom.tr(dom.td(attr.colspan('6'), 'None')) : [],
+							oauthtokens.map(t =>
+								dom.tr(
+									dom.td(t.Name),
+									dom.td(t.LoginAddress),
+									dom.td(age(t.Created)),
+									dom.td(t.Expires.getUTCFullYear() <= 1 ? 'Never' : age(t.Expires)),
+									dom.td(t.LastUsed.getUTCFullYear() <= 1 ? 'Never' : age(t.LastUsed)),
+									dom.td(
+										dom.form(
+											async function submit(e: SubmitEvent & {target: {disabled: boolean}}) {
+												e.stopPropagation()
+												e.preventDefault()
+												await check(e.target, client.OAuthTokenRemove(t.ID))
+												oauthtokens.splice(oauthtokens.indexOf(t), 1)
+												render()
+											},
+											dom.submitbutton('Remove'),
+										),
+									),
+								)
+							),
+						),
+					),
+					dom.clickbutton('Create token', style({marginTop: '1ex'}), function click() {
+						let name: HTMLInputElement
+						let loginAddress: HTMLSelectElement
+						let validDays: HTMLInputElement
+						let box: HTMLElement
+
+						popup(
+							box=dom.div(
+								style({maxWidth: '45em'}),
+								dom.h1('Create OAuth token'),
+								dom.form(
+									async function submit(e: SubmitEvent & {target: {disabled: boolean}}) {
+										e.preventDefault()
+										e.stopPropagation()
+										const token = await check(e.target, client.OAuthTokenAdd(name.value, loginAddress.value, parseInt(validDays.value)))
+										oauthtokens = await check(e.target, client.OAuthTokens()) || []
+										render()
+										box.replaceChildren(
+											dom.h1('OAuth token created'),
+											dom.p('Configure your email application with the token below. Copy it now, it cannot be shown again.'),
+											dom.div(dom._class('literal'), token),
+										)
+									},
+									dom.label(
+										style({display: 'block', marginBottom: '1ex'}),
+										dom.div(dom.b('Name')),
+										name=dom.input(attr.required(''), attr.placeholder('e.g. laptop email application')),
+									),
+									dom.label(
+										style({display: 'block', marginBottom: '1ex'}),
+										dom.div(dom.b('Login address')),
+										loginAddress=dom.select(
+											attr.required(''),
+											Object.keys(acc.Destinations || {}).filter(a => !a.startsWith('@')).sort().map(a => dom.option(a)),
+										),
+									),
+									dom.label(
+										style({display: 'block', marginBottom: '1ex'}),
+										dom.div(dom.b('Valid for days')),
+										validDays=dom.input(attr.type('number'), attr.min('0'), attr.value('365'), attr.required('')),
+										dom.div(style({fontStyle: 'italic', marginTop: '.5ex'}), 'Use 0 for a token that does not expire.'),
+									),
+									dom.br(),
+									dom.submitbutton('Create'),
+								),
+							),
+						)
+					})
+				)
+
+				if (elem) {
+					elem.replaceWith(e)
+				}
+				elem = e
+			}
+			render()
+			return elem
+		})(),
+		dom.br(),
+
+		dom.h2('API tokens'),
+		dom.p('API tokens can be used by scripts and integrations instead of your password, with limited access. A token with scope "read" gives read-only access to your mailboxes with IMAP and to messages with the webapi. A token with scope "send" can only send messages, with SMTP submission and the webapi. Use any of your addresses as username, and the token as password.'),
+		(() => {
+			let elem = dom.div()
+
+			const render = () => {
+				const e = dom.div(
+					dom.table(
+						dom.thead(
+							dom.tr(
+								dom.th('Name'),
+								dom.th('Scope'),
+								dom.th('Created'),
+								dom.th('Expires'),
+								dom.th('Last used'),
+								dom.th('Remove'),
+							),
+						),
+						dom.tbody(
+							apitokens.length === 0 ? dom.tr(dom.td(attr.colspan('6'), 'None')) : [],
+							apitokens.map(t =>
+								dom.tr(
+									dom.td(t.Name),
+									dom.td(t.Scope),
+									dom.td(age(t.Created)),
+									dom.td(t.Expires.getUTCFullYear() <= 1 ? 'Never' : age(t.Expires)),
+									dom.td(t.LastUsed.getUTCFullYear() <= 1 ? 'Never' : age(t.LastUsed)),
+									dom.td(
+										dom.form(
+											async function submit(e: SubmitEvent & {target: {disabled: boolean}}) {
+												e.stopPropagation()
+												e.preventDefault()
+												await check(e.target, client.APITokenRemove(t.ID))
+												apitokens.splice(apitokens.indexOf(t), 1)
+												render()
+											},
+											dom.submitbutton('Remove'),
+										),
+									),
+								)
+							),
+						),
+					),
+					dom.clickbutton('Create token', style({marginTop: '1ex'}), function click() {
+						let name: HTMLInputElement
+						let scope: HTMLSelectElement
+						let validDays: HTMLInputElement
+						let box: HTMLElement
+
+						popup(
+							box=dom.div(
+								style({maxWidth: '45em'}),
+								dom.h1('Create API token'),
+								dom.form(
+									async function submit(e: SubmitEvent & {target: {disabled: boolean}}) {
+										e.preventDefault()
+										e.stopPropagation()
+										const token = await check(e.target, client.APITokenAdd(name.value, scope.value as api.APITokenScope, parseInt(validDays.value)))
+										apitokens = await check(e.target, client.APITokens()) || []
+										render()
+										box.replaceChildren(
+											dom.h1('API token created'),
+											dom.p('Configure your script or integration with the token below as password. Copy it now, it cannot be shown again.'),
+											dom.div(dom._class('literal'), token),
+										)
+									},
+									dom.label(
+										style({display: 'block', marginBottom: '1ex'}),
+										dom.div(dom.b('Name')),
+										name=dom.input(attr.required(''), attr.placeholder('e.g. backup script')),
+									),
+									dom.label(
+										style({display: 'block', marginBottom: '1ex'}),
+										dom.div(dom.b('Scope')),
+										scope=dom.select(
+											attr.required(''),
+											dom.option('Read-only mailbox access (IMAP, webapi)', attr.value(api.APITokenScope.APITokenScopeRead)),
+											dom.option('Send only (SMTP submission, webapi)', attr.value(api.APITokenScope.APITokenScopeSend)),
+										),
+									),
+									dom.label(
+										style({display: 'block', marginBottom: '1ex'}),
+										dom.div(dom.b('Valid for days')),
+										validDays=dom.input(attr.type('number'), attr.min('0'), attr.value('365'), attr.required('')),
+										dom.div(style({fontStyle: 'italic', marginTop: '.5ex'}), 'Use 0 for a token that does not expire.'),
+									),
+									dom.br(),
+									dom.submitbutton('Create'),
+								),
+							),
+						)
+					})
+				)
+
+				if (elem) {
+					elem.replaceWith(e)
+				}
+				elem = e
+			}
+			render()
+			return elem
+		})(),
+		dom.br(),
+
 		dom.h2('Disk usage'),
 		dom.p('Storage used is ', dom.b(formatQuotaSize(Math.floor(storageUsed/(1024*1024))*1024*1024)),
 			storageLimit > 0 ? [
@@ -1208,13 +1723,68 @@ openssl pkcs12 \\
 		),
 		dom.br(),
 
+		dom.h2('Rescan recent messages', attr.title('After changing junk filter settings or the sender allow list, recent messages in the Inbox and Junk mailbox can be evaluated again. Messages now classified as junk are moved from the Inbox to the Junk mailbox. Messages now classified as non-junk, including messages from allowed senders with a verified From address, are moved from the Junk mailbox to the Inbox. Do a dry run first to see which messages would be moved. The rescan runs in the background and evaluates messages slowly to limit the load on the server.')),
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+
+				const moves = await check(junkRescanFieldset, client.JunkRescanDryRun(parseInt(junkRescanDays.value))) || []
+				dom._kids(junkRescanResult,
+					dom.table(
+						dom.thead(
+							dom.tr(
+								dom.th('Received'),
+								dom.th('From'),
+								dom.th('Subject'),
+								dom.th('Move from'),
+								dom.th('Move to'),
+								dom.th('Reason'),
+							),
+						),
+						dom.tbody(
+							moves.length ? [] : dom.tr(dom.td(attr.colspan('6'), 'No messages would be moved.')),
+							moves.map(mv =>
+								dom.tr(
+									dom.td(age(mv.Received)),
+									dom.td(prewrap(mv.From)),
+									dom.td(mv.Subject),
+									dom.td(mv.Mailbox),
+									dom.td(mv.DestMailbox),
+									dom.td(mv.Reason === 'junk filter' ? 'Junk filter, probability ' + mv.Probability.toFixed(2) : mv.Reason),
+								),
+							),
+						),
+					),
+				)
+			},
+			junkRescanFieldset=dom.fieldset(
+				dom.div(style({display: 'flex', gap: '1em', alignItems: 'flex-end'}),
+					dom.label(
+						'Days',
+						attr.title('Evaluate messages received in this many past days, at most 90.'),
+						dom.div(junkRescanDays=dom.input(attr.type('number'), attr.min('1'), attr.max('90'), attr.value('7'), attr.required(''))),
+					),
+					dom.div(dom.submitbutton('Dry run')),
+					dom.div(
+						dom.clickbutton('Rescan', async function click() {
+							await check(junkRescanFieldset, client.JunkRescanStart(parseInt(junkRescanDays.value)))
+							dom._kids(junkRescanResult, dom.p('Rescan started in the background.'))
+						}),
+					),
+				),
+			),
+		),
+		junkRescanResult=dom.div(),
+		dom.br(),
+
 		dom.h2('Rejects'),
 		dom.form(
 			async function submit(e: SubmitEvent) {
 				e.preventDefault()
 				e.stopPropagation()
 
-				await check(rejectsFieldset, client.RejectsSave(rejectsMailbox.value, keepRejects.checked))
+				await check(rejectsFieldset, client.RejectsSave(rejectsMailbox.value, keepRejects.checked, rejectsRescueAllow.value))
 			},
 			rejectsFieldset=dom.fieldset(
 				dom.div(style({display: 'flex', gap: '1em'}),
@@ -1228,10 +1798,145 @@ openssl pkcs12 \\
 						attr.title("Don't automatically delete mail in the RejectsMailbox listed above. This can be useful, e.g. for future spam training. It can also cause storage to fill up."),
 						dom.div(keepRejects=dom.input(attr.type('checkbox'), acc.KeepRejects ? attr.checked('') : [])),
 					),
+					dom.label(
+						'Allow sender when moved out',
+						attr.title('When a message is moved out of the rejects mailbox, automatically add a sender allow entry for its From address or domain. Later messages from allowed senders with a verified From address are accepted without junk filtering. If not set, webmail offers to add an entry.'),
+						dom.div(
+							rejectsRescueAllow=dom.select(
+								dom.option('No, ask in webmail', attr.value('')),
+								dom.option('Address', attr.value('address'), acc.RejectsRescueAllow === 'address' ? attr.selected('') : []),
+								dom.option('Domain', attr.value('domain'), acc.RejectsRescueAllow === 'domain' ? attr.selected('') : []),
+							),
+						),
+					),
 					dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save'))),
 				),
 			),
 		),
+		dom.p('See ', dom.a(attr.href('#senderallow'), 'sender allow list'), ' for senders whose messages are accepted without junk filtering.'),
+		dom.br(),
+
+		dom.h2('Mailbox retention', attr.title('Messages in these mailboxes are removed automatically when they were received longer ago than the period, checked every hour. Use values like "30d" for 30 days, or units "h" for hour, "w" for week. The period must be at least 1h. Child mailboxes are not included. Messages in the rejects mailbox are always removed after 14 days, unless "No cleanup" is checked.')),
+		(() => {
+			let rows: {mailbox: HTMLInputElement, period: HTMLInputElement}[] = []
+			let elem: HTMLElement
+
+			const render = () => {
+				rows = []
+
+				const e = dom.form(
+					async function submit(e: SubmitEvent) {
+						e.preventDefault()
+						e.stopPropagation()
+
+						await check(mailboxRetentionFieldset, (async () => await client.MailboxRetentionSave(rows.map(r => ({Mailbox: r.mailbox.value, Period: parseDuration(r.period.value)}))))())
+					},
+					mailboxRetentionFieldset=dom.fieldset(
+						dom.table(
+							dom.thead(
+								dom.tr(dom.th('Mailbox'), dom.th('Period'), dom.th()),
+							),
+							dom.tbody(
+								(acc.MailboxRetention || []).length === 0 ? dom.tr(dom.td('(None)'), dom.td(), dom.td()) : [],
+								(acc.MailboxRetention || []).map((mr, index) => {
+									const row = {
+										mailbox: dom.input(attr.required(''), attr.value(mr.Mailbox), attr.placeholder('Trash')),
+										period: dom.input(attr.required(''), attr.value(formatDuration(mr.Period)), attr.placeholder('30d')),
+									}
+									rows.push(row)
+									const x = dom.tr(
+										dom.td(row.mailbox),
+										dom.td(row.period),
+										dom.td(
+											dom.clickbutton('Remove', function click() {
+												acc.MailboxRetention!.splice(index, 1)
+												render()
+											}),
+										),
+									)
+									return x
+								}),
+							),
+							dom.tfoot(
+								dom.tr(
+									dom.td(attr.colspan('2')),
+									dom.td(
+										dom.clickbutton('Add', function click() {
+											acc.MailboxRetention = (acc.MailboxRetention || []).concat([{Mailbox: '', Period: 0}])
+											render()
+										}),
+									),
+								),
+								dom.tr(
+									dom.td(attr.colspan('3'), dom.submitbutton('Save')),
+								),
+							),
+						),
+					),
+				)
+				if (elem) {
+					elem.replaceWith(e)
+					elem = e
+				}
+				return e
+			}
+			elem = render()
+			return elem
+		})(),
+		dom.br(),
+
+		dom.h2('Vacation replies', attr.title('Automatic replies to incoming messages, e.g. when out of office. Replies are not sent for messages from mailing lists, automated senders, junk messages, and messages that did not have your address in To or Cc. Each sender gets at most one reply per interval.')),
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+
+				const v: api.Vacation = {
+					ID: 1,
+					Active: vacationActive.checked,
+					Start: vacationParseDate(vacationStart.value),
+					End: vacationParseDate(vacationEnd.value),
+					Subject: vacationSubject.value,
+					Body: vacationBody.value,
+					IntervalDays: parseInt(vacationIntervalDays.value || '0'),
+				}
+				await check(vacationFieldset, client.VacationSave(v))
+			},
+			vacationFieldset=dom.fieldset(
+				dom.div(style({display: 'flex', gap: '1em'}),
+					dom.label(
+						'Active',
+						dom.div(vacationActive=dom.input(attr.type('checkbox'), vacation.Active ? attr.checked('') : [])),
+					),
+					dom.label(
+						'Start',
+						attr.title('If set, replies are only sent for messages arriving at or after the start of this day (UTC).'),
+						dom.div(vacationStart=dom.input(attr.type('date'), attr.value(vacationDate(vacation.Start)))),
+					),
+					dom.label(
+						'End',
+						attr.title('If set, replies are only sent for messages arriving before the start of this day (UTC).'),
+						dom.div(vacationEnd=dom.input(attr.type('date'), attr.value(vacationDate(vacation.End)))),
+					),
+					dom.label(
+						'Interval in days',
+						attr.title('Minimum number of days between replies to the same sender. If 0, the default of 7 days is used.'),
+						dom.div(vacationIntervalDays=dom.input(attr.type('number'), attr.min('0'), attr.max('365'), attr.value(''+vacation.IntervalDays))),
+					),
+				),
+				dom.label(
+					style({display: 'block', marginTop: '.5ex'}),
+					'Subject',
+					dom.div(vacationSubject=dom.input(attr.value(vacation.Subject), style({width: '100%', maxWidth: '50em'}))),
+				),
+				dom.label(
+					style({display: 'block', marginTop: '.5ex'}),
+					'Message',
+					dom.div(vacationBody=dom.textarea(vacation.Body, attr.rows('8'), style({width: '100%', maxWidth: '50em'}))),
+				),
+				dom.div(style({marginTop: '.5ex'}), dom.submitbutton('Save')),
+			),
+		),
 		dom.br(),
 
 		dom.h2('Webhooks'),
@@ -1729,6 +2434,176 @@ const loginattempts = async () => {
 	)
 }
 
+const sessions = async () => {
+	const [sessions0, currentID] = await client.Sessions()
+	const sessions = sessions0 || []
+
+	return dom.div(
+		crumbs(
+			crumblink('Mox Account', '#'),
+			'Sessions',
+		),
+		dom.h2('Sessions'),
+		dom.p('Sessions of the account and mail web interfaces. Sessions expire after 24 hours of inactivity. Changing your password revokes all other sessions.'),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Login'),
+					dom.th('Last used'),
+					dom.th('Login address'),
+					dom.th('Remote IP'),
+					dom.th('User Agent'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				sessions.map(s =>
+					dom.tr(
+						dom.td(age(s.Created)),
+						dom.td(s.LastUsed.getTime() > 0 ? age(s.LastUsed) : []),
+						dom.td(s.LoginAddress),
+						dom.td(s.RemoteIP),
+						dom.td(s.UserAgent),
+						dom.td(
+							s.ID === currentID ? 'Current session' :
+							dom.clickbutton('Revoke', async function click(e: MouseEvent) {
+								if (!window.confirm('Are you sure you want to revoke this session?')) {
+									return
+								}
+								await check(e.target! as HTMLButtonElement, client.SessionRevoke(s.ID))
+								window.location.reload() // todo: reload less
+							}),
+						),
+					),
+				),
+			),
+		),
+		dom.br(),
+		dom.clickbutton('Revoke all other sessions', sessions.length <= 1 ? attr.disabled('') : [], async function click(e: MouseEvent) {
+			if (!window.confirm('Are you sure you want to revoke all other sessions?')) {
+				return
+			}
+			await check(e.target! as HTMLButtonElement, client.SessionsRevokeOthers())
+			window.location.reload() // todo: reload less
+		}),
+	)
+}
+
+const shares = async () => {
+	const [shares0, accesses0] = await client.MessageShares()
+	const shares = shares0 || []
+	const accesses = accesses0 || []
+
+	return dom.div(
+		crumbs(
+			crumblink('Mox Account', '#'),
+			'Share links',
+		),
+		dom.h2('Share links'),
+		dom.p('Share links are created in webmail, and give access to a single message without authentication until they expire. Expired links are removed after 30 days.'),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Created'),
+					dom.th('Subject'),
+					dom.th('Type'),
+					dom.th('Expires', attr.title('Negative values are in the future.')),
+					dom.th('Accesses'),
+					dom.th('Last access'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				shares.length ? [] : dom.tr(dom.td(attr.colspan('7'), 'No share links.')),
+				shares.map((ms, i) =>
+					dom.tr(
+						dom.td(age(ms.Created)),
+						dom.td(ms.Subject),
+						dom.td(ms.Raw ? 'Raw message' : 'Text'),
+						dom.td(ms.Expires.getTime() <= new Date().getTime() ? box(red, 'expired') : age(ms.Expires)),
+						dom.td(''+ms.Accesses),
+						dom.td(ms.Accesses ? age(ms.LastAccess) : []),
+						dom.td(
+							(accesses[i] || []).length === 0 ? [] : [
+								dom.clickbutton('Show accesses', function click() {
+									popup(
+										dom.h1('Accesses of share link'),
+										dom.table(
+											dom.thead(
+												dom.tr(
+													dom.th('Time'),
+													dom.th('Remote IP'),
+													dom.th('User Agent'),
+												),
+											),
+											dom.tbody(
+												(accesses[i] || []).map(a =>
+													dom.tr(
+														dom.td(age(a.Time)),
+														dom.td(a.RemoteIP),
+														dom.td(a.UserAgent),
+													),
+												),
+											),
+										),
+									)
+								}),
+								' ',
+							],
+							dom.clickbutton('Revoke', async function click(e: MouseEvent) {
+								if (!window.confirm('Are you sure you want to revoke this share link?')) {
+									return
+								}
+								await check(e.target! as HTMLButtonElement, client.MessageShareRevoke(ms.ID))
+								window.location.reload() // todo: reload less
+							}),
+						),
+					),
+				),
+			),
+		),
+	)
+}
+
+const senderallow = async () => {
+	const allows = await client.SenderAllows() || []
+
+	return dom.div(
+		crumbs(
+			crumblink('Mox Account', '#'),
+			'Sender allow list',
+		),
+		dom.h2('Sender allow list'),
+		dom.p('Messages from these addresses and domains are accepted without reputation and junk filter analysis, if the From address is verified through SPF and/or DKIM, aligned per DMARC. Entries are added when moving messages out of the rejects mailbox, either automatically or after confirming in webmail.'),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Created'),
+					dom.th('Address or domain'),
+					dom.th('Message ID', attr.title('Message the entry was added for.')),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				allows.length ? [] : dom.tr(dom.td(attr.colspan('4'), 'No entries.')),
+				allows.map(sa =>
+					dom.tr(
+						dom.td(age(sa.Created)),
+						dom.td(sa.Localpart ? sa.Localpart + '@' + sa.Domain : sa.Domain),
+						dom.td(sa.MessageID ? ''+sa.MessageID : ''),
+						dom.td(
+							dom.clickbutton('Remove', async function click(e: MouseEvent) {
+								await check(e.target! as HTMLButtonElement, client.SenderAllowRemove(sa.ID))
+								window.location.reload() // todo: reload less
+							}),
+						),
+					),
+				),
+			),
+		),
+	)
+}
+
 const destination = async (name: string) => {
 	const [acc] = await client.Account()
 	let dest = (acc.Destinations || {})[name]
@@ -1750,16 +2625,34 @@ const destination = async (name: string) => {
 		msgFromRegexp: HTMLInputElement
 		verifiedDomain: HTMLInputElement
 		headers: Header[]
+		msgFromPatterns: HTMLInputElement // Comma-separated.
+		spfResult: HTMLSelectElement
+		dkimResult: HTMLSelectElement
+		dmarcResult: HTMLSelectElement
+		minSize: HTMLInputElement
+		maxSize: HTMLInputElement
+		attachments: HTMLSelectElement
+		listID: HTMLInputElement
 		isForward: HTMLInputElement // Checkbox
 		listAllowDomain: HTMLInputElement
 		acceptRejectsToMailbox: HTMLInputElement
 		mailbox: HTMLInputElement
+		markSeen: HTMLInputElement // Checkbox
+		forwardTo: HTMLInputElement // Comma-separated.
+		discard: HTMLInputElement // Checkbox
 		comment: HTMLInputElement
 	}
 
 	let rulesetsTbody = dom.tbody()
 	let rulesetsRows: Row[] = []
 
+	// Select with an empty option for "any", and the possible values.
+	const resultSelect = (v: string, values: string[]) => dom.select(
+		dom.option('', attr.value('')),
+		values.map(s => dom.option(s, attr.value(s), v === s ? attr.selected('') : [])),
+	)
+	const splitList = (s: string) => s.split(',').map(s => s.trim()).filter(s => !!s)
+
 	const addRulesetsRow = (rs: api.Ruleset) => {
 		let row: Row
 		let headersCell = dom.td()
@@ -1798,10 +2691,21 @@ const destination = async (name: string) => {
 		let smtpMailFromRegexp: HTMLInputElement
 		let msgFromRegexp: HTMLInputElement
 		let verifiedDomain: HTMLInputElement
+		let msgFromPatterns: HTMLInputElement
+		let spfResult: HTMLSelectElement
+		let dkimResult: HTMLSelectElement
+		let dmarcResult: HTMLSelectElement
+		let minSize: HTMLInputElement
+		let maxSize: HTMLInputElement
+		let attachments: HTMLSelectElement
+		let listID: HTMLInputElement
 		let isForward: HTMLInputElement // Checkbox
 		let listAllowDomain: HTMLInputElement
 		let acceptRejectsToMailbox: HTMLInputElement
 		let mailbox: HTMLInputElement
+		let markSeen: HTMLInputElement // Checkbox
+		let forwardTo: HTMLInputElement
+		let discard: HTMLInputElement // Checkbox
 		let comment: HTMLInputElement
 
 		const root = dom.tr(
@@ -1809,10 +2713,21 @@ const destination = async (name: string) => {
 			dom.td(msgFromRegexp=dom.input(attr.value(rs.MsgFromRegexp || ''))),
 			dom.td(verifiedDomain=dom.input(attr.value(rs.VerifiedDomain || ''))),
 			headersCell,
+			dom.td(msgFromPatterns=dom.input(attr.value((rs.MsgFromPatterns || []).join(', ')))),
+			dom.td(spfResult=resultSelect(rs.SPFResult, ['pass', 'fail', 'softfail', 'neutral', 'none', 'temperror', 'permerror'])),
+			dom.td(dkimResult=resultSelect(rs.DKIMResult, ['pass', 'none'])),
+			dom.td(dmarcResult=resultSelect(rs.DMARCResult, ['pass', 'fail'])),
+			dom.td(minSize=dom.input(attr.type('number'), attr.min('0'), style({width: '7em'}), attr.value(rs.MinSize ? ''+rs.MinSize : ''))),
+			dom.td(maxSize=dom.input(attr.type('number'), attr.min('0'), style({width: '7em'}), attr.value(rs.MaxSize ? ''+rs.MaxSize : ''))),
+			dom.td(attachments=resultSelect(rs.Attachments, ['yes', 'no'])),
+			dom.td(listID=dom.input(attr.value(rs.ListID || ''))),
 			dom.td(dom.label(isForward=dom.input(attr.type('checkbox'), rs.IsForward ? attr.checked('') : [] ))),
 			dom.td(listAllowDomain=dom.input(attr.value(rs.ListAllowDomain || ''))),
 			dom.td(acceptRejectsToMailbox=dom.input(attr.value(rs.AcceptRejectsToMailbox || ''))),
 			dom.td(mailbox=dom.input(attr.value(rs.Mailbox || ''))),
+			dom.td(dom.label(markSeen=dom.input(attr.type('checkbox'), rs.MarkSeen ? attr.checked('') : []))),
+			dom.td(forwardTo=dom.input(attr.value((rs.ForwardTo || []).join(', ')))),
+			dom.td(dom.label(discard=dom.input(attr.type('checkbox'), rs.Discard ? attr.checked('') : []))),
 			dom.td(comment=dom.input(attr.value(rs.Comment || ''))),
 			dom.td(
 				dom.clickbutton('Remove ruleset', function click() {
@@ -1827,10 +2742,21 @@ const destination = async (name: string) => {
 			msgFromRegexp: msgFromRegexp,
 			verifiedDomain: verifiedDomain,
 			headers: [],
+			msgFromPatterns: msgFromPatterns,
+			spfResult: spfResult,
+			dkimResult: dkimResult,
+			dmarcResult: dmarcResult,
+			minSize: minSize,
+			maxSize: maxSize,
+			attachments: attachments,
+			listID: listID,
 			isForward: isForward,
 			listAllowDomain: listAllowDomain,
 			acceptRejectsToMailbox: acceptRejectsToMailbox,
 			mailbox: mailbox,
+			markSeen: markSeen,
+			forwardTo: forwardTo,
+			discard: discard,
 			comment: comment,
 		}
 		rulesetsRows.push(row)
@@ -1857,6 +2783,8 @@ const destination = async (name: string) => {
 	let fullName: HTMLInputElement
 	let smtpError: HTMLInputElement
 	let msgAuthRequiredSMTPError: HTMLInputElement
+	let forwardToAddresses: HTMLInputElement
+	let forwardKeepCopy: HTMLInputElement
 	let saveButton: HTMLButtonElement
 
 	const addresses = [name, ...Object.keys(acc.Destinations || {}).filter(a => !a.startsWith('@') && a !== name)]
@@ -1890,12 +2818,21 @@ const destination = async (name: string) => {
 			msgAuthRequiredSMTPError=dom.input(attr.value(dest.MessageAuthRequiredSMTPError), attr.placeholder('messages must have aligned spf/dkim for domain authentication...')),
 		),
 		dom.br(),
+		dom.div(
+			dom.span('Forward to', attr.title('Comma-separated addresses to forward incoming messages for this address to, typically at other domains. The SMTP MAIL FROM of forwarded messages is rewritten to an address at this domain with SRS (Sender Rewriting Scheme), so SPF checks at the receiving mail server can pass, and delivery failure notifications are sent on to the original sender. Messages classified as junk and DSNs are not forwarded.')),
+			dom.br(),
+			forwardToAddresses=dom.input(attr.value((dest.ForwardTo || []).join(', ')), attr.placeholder('user@example.org, ...')),
+			' ',
+			dom.label(forwardKeepCopy=dom.input(attr.type('checkbox'), dest.ForwardKeepCopy ? attr.checked('') : []), ' Keep a copy', attr.title('Also deliver forwarded messages to the mailbox of this address.')),
+		),
+		dom.br(),
 
 		dom.h2('Rulesets'),
 		dom.p('Incoming messages are checked against the rulesets. If a ruleset matches, the message is delivered to the mailbox configured for the ruleset instead of to the default mailbox.'),
 		dom.p('"Is Forward" does not affect matching, but changes prevents the sending mail server from being included in future junk classifications by clearing fields related to the forwarding email server (IP address, EHLO domain, MAIL FROM domain and a matching DKIM domain), and prevents DMARC rejects for forwarded messages.'),
 		dom.p('"List allow domain" does not affect matching, but skips the regular spam checks if one of the verified domains is a (sub)domain of the domain mentioned here.'),
 		dom.p('"Accept rejects to mailbox" does not affect matching, but causes messages classified as junk to be accepted and delivered to this mailbox, instead of being rejected during the SMTP transaction. Useful for incoming forwarded messages where rejecting incoming messages may cause the forwarding server to stop forwarding.'),
+		dom.p('Besides delivering to the mailbox, a matching ruleset can mark the message as read, forward a copy to other addresses, or discard the message instead of delivering it.'),
 		dom.table(
 			dom.thead(
 				dom.tr(
@@ -1903,10 +2840,21 @@ const destination = async (name: string) => {
 					dom.th('Message "From" address regexp', attr.title('Matches if this regular expression matches (a substring of) the single address in the message From header.')),
 					dom.th('Verified domain', attr.title('Matches if this domain matches an SPF- and/or DKIM-verified (sub)domain.')),
 					dom.th('Headers regexp', attr.title('Matches if these header field/value regular expressions all match (substrings of) the message headers. Header fields and valuees are converted to lower case before matching. Whitespace is trimmed from the value before matching. A header field can occur multiple times in a message, only one instance has to match. For mailing lists, you could match on ^list-id$ with the value typically the mailing list address in angled brackets with @ replaced with a dot, e.g. <name\\.lists\\.example\\.org>.')),
+					dom.th('Message "From" patterns', attr.title("Matches if the single address in the message From header matches one of these comma-separated patterns. A '*' matches any sequence of characters, all other characters match literally. Matching is case-insensitive. E.g. '*@example.org' or 'notifications-*@*.example.org'.")),
+					dom.th('SPF', attr.title('Matches if the SPF result for the SMTP MAIL FROM domain is this value.')),
+					dom.th('DKIM', attr.title('Matches if the message has at least one verified DKIM signature (pass), or none (none).')),
+					dom.th('DMARC', attr.title('Matches if the domain of the message From header is authenticated with an aligned SPF and/or DKIM pass, as used by DMARC (pass), or not (fail).')),
+					dom.th('Min size', attr.title('Matches if the size of the message in bytes is at least this value.')),
+					dom.th('Max size', attr.title('Matches if the size of the message in bytes is at most this value.')),
+					dom.th('Attachments', attr.title('Matches if the message has attachments (yes), or not (no). Message parts with a Content-Disposition of attachment, or with a filename, are considered attachments.')),
+					dom.th('List-Id', attr.title('Matches if the List-Id header of the message has this list identifier, without angle brackets, e.g. name.lists.example.org. Matching is case-insensitive.')),
 					dom.th('Is Forward', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. Can only be used together with SMTPMailFromRegexp and VerifiedDomain. SMTPMailFromRegexp must be set to the address used to deliver the forwarded message, e.g. '^user(|\\+.*)@forward\\.example$'. Changes to junk analysis: 1. Messages are not rejected for failing a DMARC policy, because a legitimate forwarded message without valid/intact/aligned DKIM signature would be rejected because any verified SPF domain will be 'unaligned', of the forwarding mail server. 2. The sending mail server IP address, and sending EHLO and MAIL FROM domains and matching DKIM domain aren't used in future reputation-based spam classifications (but other verified DKIM domains are) because the forwarding server is not a useful spam signal for future messages.")),
 					dom.th('List allow domain', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. If this domain matches an SPF- and/or DKIM-verified (sub)domain, the message is accepted without further spam checks, such as a junk filter or DMARC reject evaluation. DMARC rejects should not apply for mailing lists that are not configured to rewrite the From-header of messages that don't have a passing DKIM signature of the From-domain. Otherwise, by rejecting messages, you may be automatically unsubscribed from the mailing list. The assumption is that mailing lists do their own spam filtering/moderation.")),
 					dom.th('Allow rejects to mailbox', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. If a message is classified as spam, it isn't rejected during the SMTP transaction (the normal behaviour), but accepted during the SMTP transaction and delivered to the specified mailbox. The specified mailbox is not automatically cleaned up like the account global Rejects mailbox, unless set to that Rejects mailbox.")),
-					dom.th('Mailbox', attr.title('Mailbox to deliver to if this ruleset matches.')),
+					dom.th('Mailbox', attr.title('Mailbox to deliver to if this ruleset matches. Required unless Discard is set.')),
+					dom.th('Mark read', attr.title('Mark the delivered message as read.')),
+					dom.th('Forward to', attr.title('Comma-separated addresses to forward a copy of the message to, with the address of this destination as SMTP MAIL FROM. The message is forwarded unmodified, so it may fail DMARC checks at the recipient. Messages that are rejected or accepted as reject, and DSNs, are not forwarded.')),
+					dom.th('Discard', attr.title("Accept the message during the SMTP transaction, but don't deliver it to a mailbox. Can be combined with forwarding.")),
 					dom.th('Comment', attr.title('Free-form comments.')),
 					dom.th('Action'),
 				)
@@ -1914,7 +2862,7 @@ const destination = async (name: string) => {
 			rulesetsTbody,
 			dom.tfoot(
 				dom.tr(
-					dom.td(attr.colspan('9')),
+					dom.td(attr.colspan('20')),
 					dom.td(
 						dom.clickbutton('Add ruleset', function click() {
 							addRulesetsRow({
@@ -1922,10 +2870,21 @@ const destination = async (name: string) => {
 								MsgFromRegexp: '',
 								VerifiedDomain: '',
 								HeadersRegexp: {},
+								MsgFromPatterns: [],
+								SPFResult: '',
+								DKIMResult: '',
+								DMARCResult: '',
+								MinSize: 0,
+								MaxSize: 0,
+								Attachments: '',
+								ListID: '',
 								IsForward: false,
 								ListAllowDomain: '',
 								AcceptRejectsToMailbox: '',
 								Mailbox: '',
+								MarkSeen: false,
+								ForwardTo: [],
+								Discard: false,
 								Comment: '',
 								VerifiedDNSDomain: {ASCII: '', Unicode: ''},
 								ListAllowDNSDomain: {ASCII: '', Unicode: ''},
@@ -1946,10 +2905,21 @@ const destination = async (name: string) => {
 						MsgFromRegexp: row.msgFromRegexp.value,
 						VerifiedDomain: row.verifiedDomain.value,
 						HeadersRegexp: Object.fromEntries(row.headers.map(h => [h.key.value, h.value.value])),
+						MsgFromPatterns: splitList(row.msgFromPatterns.value),
+						SPFResult: row.spfResult.value,
+						DKIMResult: row.dkimResult.value,
+						DMARCResult: row.dmarcResult.value,
+						MinSize: parseInt(row.minSize.value || '0'),
+						MaxSize: parseInt(row.maxSize.value || '0'),
+						Attachments: row.attachments.value,
+						ListID: row.listID.value,
 						IsForward: row.isForward.checked,
 						ListAllowDomain: row.listAllowDomain.value,
 						AcceptRejectsToMailbox: row.acceptRejectsToMailbox.value,
 						Mailbox: row.mailbox.value,
+						MarkSeen: row.markSeen.checked,
+						ForwardTo: splitList(row.forwardTo.value),
+						Discard: row.discard.checked,
 						Comment: row.comment.value,
 						VerifiedDNSDomain: {ASCII: '', Unicode: ''},
 						ListAllowDNSDomain: {ASCII: '', Unicode: ''},
@@ -1957,6 +2927,8 @@ const destination = async (name: string) => {
 				}),
 				SMTPError: smtpError.value,
 				MessageAuthRequiredSMTPError: msgAuthRequiredSMTPError.value,
+				ForwardTo: splitList(forwardToAddresses.value),
+				ForwardKeepCopy: forwardKeepCopy.checked,
 			}
 			await check(saveButton, client.DestinationSave(name, dest, newDest))
 			window.location.reload() // todo: only refresh part of ui
@@ -1994,6 +2966,12 @@ const init = async () => {
 				root = await index()
 			} else if (t[0] === 'loginattempts' && t.length === 1) {
 				root = await loginattempts()
+			} else if (t[0] === 'sessions' && t.length === 1) {
+				root = await sessions()
+			} else if (t[0] === 'shares' && t.length === 1) {
+				root = await shares()
+			} else if (t[0] === 'senderallow' && t.length === 1) {
+				root = await senderallow()
 			} else if (t[0] === 'destinations' && t.length === 2) {
 				root = await destination(t[1])
 			} else {
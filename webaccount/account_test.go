@@ -38,8 +38,10 @@ import (
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/queue"
 	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/totp"
 	"github.com/mjl-/mox/webauth"
 	"github.com/mjl-/mox/webhook"
+	"github.com/mjl-/mox/webops"
 )
 
 var ctxbg = context.Background()
@@ -126,14 +128,14 @@ func TestAccount(t *testing.T) {
 	ctx := context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
 
 	// Missing login token.
-	tneedErrorCode(t, "user:error", func() { api.Login(ctx, "", "mjl☺@mox.example", "test1234") })
+	tneedErrorCode(t, "user:error", func() { api.Login(ctx, "", "mjl☺@mox.example", "test1234", "") })
 
 	// Login with loginToken.
 	loginCookie := &http.Cookie{Name: "webaccountlogin"}
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
 
-	csrfToken := api.Login(ctx, loginCookie.Value, "mjl☺@mox.example", "test1234")
+	csrfToken := api.Login(ctx, loginCookie.Value, "mjl☺@mox.example", "test1234", "")
 	var sessionCookie *http.Cookie
 	for _, c := range respRec.Result().Cookies() {
 		if c.Name == "webaccountsession" {
@@ -148,9 +150,9 @@ func TestAccount(t *testing.T) {
 	// Valid loginToken, but bad credentials.
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "mjl☺@mox.example", "badauth") })
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "baduser@mox.example", "badauth") })
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "baduser@baddomain.example", "badauth") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "mjl☺@mox.example", "badauth", "") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "baduser@mox.example", "badauth", "") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "baduser@baddomain.example", "badauth", "") })
 
 	acc2, err := store.OpenAccount(log, "disabled", false)
 	tcheck(t, err, "open account")
@@ -164,8 +166,8 @@ func TestAccount(t *testing.T) {
 	loginCookie2 := &http.Cookie{Name: "webaccountlogin"}
 	loginCookie2.Value = api.LoginPrep(loginctx2)
 	loginReqInfo2.Request.Header = http.Header{"Cookie": []string{loginCookie2.String()}}
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(loginctx2, loginCookie2.Value, "disabled@mox.example", "test1234") })
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(loginctx2, loginCookie2.Value, "disabled@mox.example", "bogus") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(loginctx2, loginCookie2.Value, "disabled@mox.example", "test1234", "") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(loginctx2, loginCookie2.Value, "disabled@mox.example", "bogus", "") })
 
 	type httpHeaders [][2]string
 	ctJSON := [2]string{"Content-Type", "application/json; charset=utf-8"}
@@ -255,6 +257,25 @@ func TestAccount(t *testing.T) {
 
 	api.SetPassword(ctx, "test1234")
 
+	// Other sessions are removed by a password change.
+	sessions, currentID := api.Sessions(ctx)
+	tcompare(t, len(sessions), 1)
+	tcompare(t, sessions[0].ID, currentID)
+	tneedErrorCode(t, "user:error", func() { api.SessionRevoke(ctx, currentID) })
+
+	// Revoking other sessions.
+	_, _, err = store.SessionAdd(ctxbg, pkglog, "mjl☺", "mjl☺@mox.example", "10.0.0.1", "test")
+	tcheck(t, err, "add session")
+	_, _, err = store.SessionAdd(ctxbg, pkglog, "mjl☺", "mjl☺@mox.example", "10.0.0.2", "test")
+	tcheck(t, err, "add session")
+	sessions, _ = api.Sessions(ctx)
+	tcompare(t, len(sessions), 3)
+	api.SessionRevoke(ctx, sessions[0].ID)
+	tneedErrorCode(t, "user:error", func() { api.SessionRevoke(ctx, sessions[0].ID) })
+	tcompare(t, api.SessionsRevokeOthers(ctx), 1)
+	sessions, _ = api.Sessions(ctx)
+	tcompare(t, len(sessions), 1)
+
 	err = queue.Init() // For DB.
 	tcheck(t, err, "queue init")
 	defer queue.Shutdown()
@@ -453,6 +474,92 @@ func TestAccount(t *testing.T) {
 	tneedErrorCode(t, "user:error", func() { api.SuppressionRemove(ctx, "mjl@mox.example") }) // Absent.
 	tneedErrorCode(t, "user:error", func() { api.SuppressionRemove(ctx, "bogus") })           // Not an address.
 
+	// OpenPGP keys.
+	const otherKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEatIsqRYJKwYBBAHaRw8BAQdAvyNITtq9D0Lw+QBSrtbj3ONaJMA4KzUL8f2u
+s0lULJK0GU90aGVyIDxvdGhlckBtb3guZXhhbXBsZT6IkAQTFggAOBYhBNOVXNSL
+Hof5eEB8M3175jhbSPQJBQJq0iypAhsDBQsJCAcCBhUKCQgLAgQWAgMBAh4BAheA
+AAoJEH175jhbSPQJf00A/3ijyGOzKgqYSH4NqROHjwFrFwEm9pIGdW1tz/MH4Tf1
+AP9OICouUU9rdKy3xiDHt+yjt6CRMxGiLTZfqMvOR7o4DQ==
+=ip6l
+-----END PGP PUBLIC KEY BLOCK-----
+`
+	pk := api.OpenPGPKeySet(ctx, "Other@mox.example", otherKey)
+	tcompare(t, pk.Address, "other@mox.example")
+	tcompare(t, pk.Fingerprint, "D3955CD48B1E87F978407C337D7BE6385B48F409")
+	api.OpenPGPKeySet(ctx, "other@mox.example", otherKey) // Replace.
+	tcompare(t, len(api.OpenPGPKeys(ctx)), 1)
+	tneedErrorCode(t, "user:error", func() { api.OpenPGPKeySet(ctx, "mjl☺@mox.example", otherKey) })     // Not in key.
+	tneedErrorCode(t, "user:error", func() { api.OpenPGPKeySet(ctx, "disabled@mox.example", otherKey) }) // Other account.
+	tneedErrorCode(t, "user:error", func() { api.OpenPGPKeySet(ctx, "other@mox.example", "bogus") })
+	api.OpenPGPKeyRemove(ctx, "other@mox.example")
+	tneedErrorCode(t, "user:error", func() { api.OpenPGPKeyRemove(ctx, "other@mox.example") })
+	tcompare(t, len(api.OpenPGPKeys(ctx)), 0)
+
+	token := api.OAuthTokenAdd(ctx, "test", "other@mox.example", 30)
+	tcompare(t, store.IsOAuthToken(token), true)
+	tl := api.OAuthTokens(ctx)
+	tcompare(t, len(tl), 1)
+	tcompare(t, tl[0].LoginAddress, "other@mox.example")
+	tneedErrorCode(t, "user:error", func() { api.OAuthTokenAdd(ctx, "", "other@mox.example", 0) })        // Missing name.
+	tneedErrorCode(t, "user:error", func() { api.OAuthTokenAdd(ctx, "test", "disabled@mox.example", 0) }) // Other account.
+	tneedErrorCode(t, "user:error", func() { api.OAuthTokenAdd(ctx, "test", "other@mox.example", -1) })
+	api.OAuthTokenRemove(ctx, tl[0].ID)
+	tneedErrorCode(t, "user:error", func() { api.OAuthTokenRemove(ctx, tl[0].ID) })
+	tcompare(t, len(api.OAuthTokens(ctx)), 0)
+
+	token = api.APITokenAdd(ctx, "script", store.APITokenScopeRead, 0)
+	tcompare(t, store.IsAPIToken(token), true)
+	atl := api.APITokens(ctx)
+	tcompare(t, len(atl), 1)
+	tcompare(t, atl[0].Scope, store.APITokenScopeRead)
+	tneedErrorCode(t, "user:error", func() { api.APITokenAdd(ctx, "", store.APITokenScopeRead, 0) }) // Missing name.
+	tneedErrorCode(t, "user:error", func() { api.APITokenAdd(ctx, "script", "bogus", 0) })           // Bad scope.
+	tneedErrorCode(t, "user:error", func() { api.APITokenAdd(ctx, "script", store.APITokenScopeSend, -1) })
+	api.APITokenRemove(ctx, atl[0].ID)
+	tneedErrorCode(t, "user:error", func() { api.APITokenRemove(ctx, atl[0].ID) })
+	tcompare(t, len(api.APITokens(ctx)), 0)
+
+	// Two-factor authentication.
+	totpEnabled, _ := api.TOTPStatus(ctx)
+	tcompare(t, totpEnabled, false)
+	secret, uri := api.TOTPEnrollStart(ctx)
+	tcompare(t, strings.HasPrefix(uri, "otpauth://totp/"), true)
+	tneedErrorCode(t, "user:error", func() { api.TOTPEnrollConfirm(ctx, "bad") })
+	totpCode, err := totp.Code(secret, time.Now())
+	tcheck(t, err, "totp code")
+	recoveryCodes := api.TOTPEnrollConfirm(ctx, totpCode)
+	tcompare(t, len(recoveryCodes), 10)
+	tneedErrorCode(t, "user:error", func() { api.TOTPEnrollStart(ctx) }) // Already enabled.
+
+	totpLogin := func(expErrCode, totpCode string) {
+		t.Helper()
+		reqInfo := requestInfo{"", "", "", httptest.NewRecorder(), &http.Request{RemoteAddr: "1.1.1.2:1234"}}
+		ctx := context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
+		cookie := &http.Cookie{Name: "webaccountlogin", Value: api.LoginPrep(ctx)}
+		reqInfo.Request.Header = http.Header{"Cookie": []string{cookie.String()}}
+		if expErrCode != "" {
+			tneedErrorCode(t, expErrCode, func() { api.Login(ctx, cookie.Value, "mjl☺@mox.example", "test1234", totpCode) })
+		} else {
+			api.Login(ctx, cookie.Value, "mjl☺@mox.example", "test1234", totpCode)
+		}
+	}
+	totpLogin("user:totpRequired", "")
+	totpLogin("user:loginFailed", "bogus")
+	totpLogin("", strings.ToUpper(recoveryCodes[0]))
+	totpLogin("user:loginFailed", recoveryCodes[0]) // Recovery codes can be used once.
+	totpEnabled, nrecovery := api.TOTPStatus(ctx)
+	tcompare(t, totpEnabled, true)
+	tcompare(t, nrecovery, 9)
+
+	tneedErrorCode(t, "user:error", func() { api.TOTPDisable(ctx, "") })
+	api.TOTPDisable(ctx, recoveryCodes[1])
+	totpEnabled, nrecovery = api.TOTPStatus(ctx)
+	tcompare(t, totpEnabled, false)
+	tcompare(t, nrecovery, 0)
+	totpLogin("", "")
+
 	var hooks int
 	hookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "ok")
@@ -507,9 +614,70 @@ func TestAccount(t *testing.T) {
 	}
 	api.JunkFilterSave(ctx, &jf)
 
-	api.RejectsSave(ctx, "Rejects", true)
-	api.RejectsSave(ctx, "Rejects", false)
-	api.RejectsSave(ctx, "", false) // Restore.
+	api.RejectsSave(ctx, "Rejects", true, "")
+	api.RejectsSave(ctx, "Rejects", false, "domain")
+	tneedErrorCode(t, "user:error", func() { api.RejectsSave(ctx, "Rejects", false, "bogus") })
+	api.RejectsSave(ctx, "", false, "") // Restore.
+
+	// MailboxRetentionSave
+	api.MailboxRetentionSave(ctx, []config.MailboxRetention{{Mailbox: "Trash", Period: 30 * 24 * time.Hour}})
+	tneedErrorCode(t, "user:error", func() {
+		api.MailboxRetentionSave(ctx, []config.MailboxRetention{{Mailbox: "Trash", Period: time.Minute}})
+	})
+	tneedErrorCode(t, "user:error", func() {
+		api.MailboxRetentionSave(ctx, []config.MailboxRetention{{Mailbox: "Trash", Period: time.Hour}, {Mailbox: "Trash", Period: time.Hour}})
+	})
+	api.MailboxRetentionSave(ctx, nil) // Restore.
+
+	sa := store.SenderAllow{Domain: "remote.example", Localpart: "other"}
+	err = acc.DB.Insert(ctxbg, &sa)
+	tcheck(t, err, "insert sender allow entry")
+	allows := api.SenderAllows(ctx)
+	tcompare(t, len(allows), 1)
+	tcompare(t, allows[0].Address(), "other@remote.example")
+	api.SenderAllowRemove(ctx, sa.ID)
+	tneedErrorCode(t, "user:error", func() { api.SenderAllowRemove(ctx, sa.ID) })
+	tcompare(t, len(api.SenderAllows(ctx)), 0)
+
+	// Junk rescan, moving a message from an allowed sender out of the Junk mailbox.
+	junkMsg := store.Message{
+		Received:         time.Now().Add(-time.Hour),
+		MsgFromLocalpart: "other",
+		MsgFromDomain:    "remote.example",
+		MsgFromValidated: true,
+	}
+	junkContent := "From: <other@remote.example>\r\nSubject: hi\r\n\r\ntest\r\n"
+	junkMsg.Size = int64(len(junkContent))
+	mf, err := store.CreateMessageTemp(log, "webaccount-test")
+	tcheck(t, err, "create temp message file")
+	_, err = mf.Write([]byte(junkContent))
+	tcheck(t, err, "write message")
+	acc.WithWLock(func() {
+		err = acc.DeliverMailbox(log, "Junk", &junkMsg, mf)
+	})
+	tcheck(t, err, "deliver message")
+	store.CloseRemoveTempFile(log, mf, "test message")
+
+	tcompare(t, len(api.JunkRescanDryRun(ctx, 1)), 0) // Not allowed yet.
+	sa = store.SenderAllow{Domain: "remote.example", Localpart: "other"}
+	err = acc.DB.Insert(ctxbg, &sa)
+	tcheck(t, err, "insert sender allow entry")
+	moves := api.JunkRescanDryRun(ctx, 1)
+	tcompare(t, len(moves), 1)
+	tcompare(t, moves[0].MessageID, junkMsg.ID)
+	tcompare(t, moves[0].Subject, "hi")
+	tcompare(t, moves[0].DestMailbox, "Inbox")
+	tneedErrorCode(t, "user:error", func() { api.JunkRescanDryRun(ctx, 0) })
+
+	api.JunkRescanStart(ctx, 1)
+	for i := 0; webops.JunkRescanRunning(acc.Name); i++ {
+		if i > 100 {
+			t.Fatalf("junk rescan did not finish")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	tcompare(t, len(api.JunkRescanDryRun(ctx, 1)), 0)
+	api.SenderAllowRemove(ctx, sa.ID)
 
 	// Make cert for TLSPublicKey.
 	certBuf := fakeCert(t)
@@ -559,6 +727,25 @@ func TestAccount(t *testing.T) {
 	account, _, _, _ = api.Account(ctx)
 	tcompare(t, account.IMAPCapabilitiesDisabled, []string{})
 
+	// Share links, created through webmail.
+	ms := store.MessageShare{Account: "mjl☺", MessageID: 1, Subject: "test", Expires: time.Now().Add(time.Hour)}
+	err = store.MessageShareAdd(ctxbg, &ms)
+	tcheck(t, err, "add share link")
+	ms2 := store.MessageShare{Account: "other", MessageID: 1, Expires: time.Now().Add(time.Hour)}
+	err = store.MessageShareAdd(ctxbg, &ms2)
+	tcheck(t, err, "add share link for other account")
+	_, err = store.MessageShareUse(ctxbg, ms.Token, "127.0.0.1", "test")
+	tcheck(t, err, "use share link")
+	shares, accesses := api.MessageShares(ctx)
+	tcompare(t, len(shares), 1)
+	tcompare(t, shares[0].Accesses, 1)
+	tcompare(t, len(accesses[0]), 1)
+	tneedErrorCode(t, "user:error", func() { api.MessageShareRevoke(ctx, ms2.ID) }) // Other account.
+	api.MessageShareRevoke(ctx, ms.ID)
+	tneedErrorCode(t, "user:error", func() { api.MessageShareRevoke(ctx, ms.ID) }) // Already revoked.
+	shares, _ = api.MessageShares(ctx)
+	tcompare(t, len(shares), 0)
+
 	api.Logout(ctx)
 	tneedErrorCode(t, "server:error", func() { api.Logout(ctx) })
 }
@@ -8,7 +8,15 @@ export interface Account {
 	FromIDLoginAddresses?: string[] | null
 	KeepRetiredMessagePeriod: number
 	KeepRetiredWebhookPeriod: number
+	DeliveryPriority: number
+	AttachmentLinks?: AttachmentLinks | null
+	WeeklyDigest: boolean
+	SubaddressMailboxes: boolean
+	Notifications?: Notification[] | null
 	LoginDisabled: string
+	ReadOnly: boolean
+	SubmissionDisabled: string
+	IMAPReferralHost: string
 	Domain: string
 	Description: string
 	FullName: string
@@ -17,11 +25,16 @@ export interface Account {
 	QuotaMessageSize: number
 	RejectsMailbox: string
 	KeepRejects: boolean
+	RejectsRescueAllow: string
+	MailboxRetention?: MailboxRetention[] | null
+	AutoArchive?: AutoArchive | null
 	AutomaticJunkFlags: AutomaticJunkFlags
 	JunkFilter?: JunkFilter | null  // todo: sane defaults for junkfilter
 	MaxOutgoingMessagesPerDay: number
 	MaxFirstTimeRecipientsPerDay: number
+	HoldOverSendLimits: boolean
 	NoFirstTimeSenderDelay: boolean
+	NoAutoCreateMailboxes: boolean
 	NoCustomPassword: boolean
 	IMAPCapabilitiesDisabled?: string[] | null
 	Routes?: Route[] | null
@@ -40,12 +53,30 @@ export interface IncomingWebhook {
 	Authorization: string
 }
 
+export interface AttachmentLinks {
+	MinSize: number
+	ValidityDays: number
+}
+
+// Notification is a push notification service to send notifications about
+// incoming deliveries to. See package notify.
+export interface Notification {
+	Type: string
+	URL: string
+	Token: string
+	Mailboxes?: string[] | null
+	FromRegexp: string
+	SubjectRegexp: string
+}
+
 export interface Destination {
 	Mailbox: string
 	Rulesets?: Ruleset[] | null
 	SMTPError: string
 	MessageAuthRequiredSMTPError: string
 	FullName: string
+	ForwardTo?: string[] | null
+	ForwardKeepCopy: boolean
 }
 
 export interface Ruleset {
@@ -53,10 +84,21 @@ export interface Ruleset {
 	MsgFromRegexp: string
 	VerifiedDomain: string
 	HeadersRegexp?: { [key: string]: string }
+	MsgFromPatterns?: string[] | null
+	SPFResult: string
+	DKIMResult: string
+	DMARCResult: string
+	MinSize: number
+	MaxSize: number
+	Attachments: string
+	ListID: string
 	IsForward: boolean  // todo: once we implement ARC, we can use dkim domains that we cannot verify but that the arc-verified forwarding mail server was able to verify.
 	ListAllowDomain: string
 	AcceptRejectsToMailbox: string
 	Mailbox: string
+	MarkSeen: boolean
+	ForwardTo?: string[] | null
+	Discard: boolean
 	Comment: string
 	VerifiedDNSDomain: Domain
 	ListAllowDNSDomain: Domain
@@ -75,6 +117,19 @@ export interface SubjectPass {
 	Period: number  // todo: have a reasonable default for this?
 }
 
+// MailboxRetention is a policy for removing old messages from a mailbox.
+export interface MailboxRetention {
+	Mailbox: string
+	Period: number
+}
+
+// AutoArchive is a policy for moving old messages from the Inbox to archive
+// mailboxes.
+export interface AutoArchive {
+	Period: number
+	Mailbox: string
+}
+
 export interface AutomaticJunkFlags {
 	Enabled: boolean
 	JunkMailboxRegexp: string
@@ -211,6 +266,43 @@ export interface IncomingMeta {
 	Automated: boolean  // Whether this message was automated and should not receive automated replies. E.g. out of office or mailing list messages.
 }
 
+// SenderAllow is a sender address or domain from which messages are accepted
+// without reputation and content analysis, if the message From address is
+// verified. Typically added when a message is moved out of the Rejects mailbox,
+// so the same sender doesn't end up there again.
+export interface SenderAllow {
+	ID: number
+	Created: Date
+	Domain: string  // Domain of the message From address, as unicode string, like Message.MsgFromDomain.
+	Localpart: Localpart  // Localpart of the message From address. If empty, all addresses of the domain are allowed.
+	MessageID: number  // ID of the message the entry was added for, e.g. when moved out of the Rejects mailbox. Zero if not added for a message. The message may no longer exist.
+}
+
+// JunkRescanMove is a message that a junk rescan moves to another mailbox, or
+// would move in a dry run.
+export interface JunkRescanMove {
+	MessageID: number
+	Received: Date
+	From: string  // Address in message From header.
+	Subject: string
+	Mailbox: string  // Current mailbox.
+	DestMailbox: string  // Inbox or the Junk mailbox.
+	Reason: string  // "allowed sender" or "junk filter".
+	Probability: number  // Junk probability by the junk filter, only for reason "junk filter".
+}
+
+// Vacation holds the settings for automatic replies to incoming messages, e.g.
+// when out of office. A single record with ID 1.
+export interface Vacation {
+	ID: number
+	Active: boolean
+	Start: Date  // If non-zero, replies are only sent for messages arriving from Start, and before End.
+	End: Date
+	Subject: string
+	Body: string  // Plain text.
+	IntervalDays: number  // Minimum number of days between replies to the same sender. If 0, the default of 7 days is used.
+}
+
 // TLSPublicKey is a public key for use with TLS client authentication based on the
 // public key of the certificate.
 export interface TLSPublicKey {
@@ -240,12 +332,100 @@ export interface LoginAttempt {
 	LocalIP: string
 	TLS: string  // Empty if no TLS, otherwise contains version, algorithm, properties, etc.
 	TLSPubKeyFingerprint: string
-	Protocol: string  // "submission", "imap", "webmail", "webaccount", "webadmin"
+	Protocol: string  // "submission", "imap", "pop3", "managesieve", "webmail", "webaccount", "webadmin"
 	UserAgent: string  // From HTTP header, or IMAP ID command.
 	AuthMech: string  // "plain", "login", "cram-md5", "scram-sha-256-plus", "(unrecognized)", etc
 	Result: AuthResult
 }
 
+// LoginSession represents a login session. We keep a limited number of sessions
+// for a user, removing the oldest session when a new one is created.
+export interface LoginSession {
+	ID: number
+	Created: Date  // Of original login.
+	Expires: Date  // Extended each time it is used.
+	AccountName: string
+	LoginAddress: string
+	LastUsed: Date  // Of most recent use, for showing active sessions to the user. Written to the database with a delay, like Expires.
+	RemoteIP: string
+	UserAgent: string
+}
+
+// MessageShare is an expiring link to a message of an account, for sharing a
+// message with people without forwarding it. Anyone with the (unguessable)
+// token can view the message until it expires or the link is revoked (removed).
+export interface MessageShare {
+	ID: number
+	Created: Date
+	Account: string
+	MessageID: number
+	Subject: string  // Of message at time of creating the link, for display.
+	Raw: boolean  // If set, the link gives a download of the raw message (.eml), instead of a text rendering.
+	Expires: Date
+	Accesses: number  // Number of times the link was used.
+	LastAccess: Date  // Zero if never accessed.
+}
+
+// MessageShareAccess is a logged use of a share link.
+export interface MessageShareAccess {
+	ID: number
+	ShareID: number
+	Time: Date
+	RemoteIP: string
+	UserAgent: string
+}
+
+// OpenPGPKey is an OpenPGP public key for an address of an account, published
+// through the Web Key Directory (WKD) and as OPENPGPKEY DNS record, so
+// correspondents can find the key.
+export interface OpenPGPKey {
+	ID: number
+	Created: Date
+	Account: string
+	Address: string  // Canonical email address, with unicode domain. A single key per address.
+	Domain: string  // ASCII domain of address, and hash of localpart as used by WKD, for looking up keys for WKD requests.
+	WKDHash: string
+	Fingerprint: string  // Upper-case hexadecimal.
+	UserIDs?: string[] | null  // From the key.
+}
+
+// OpenPGPKeyringKey is an OpenPGP public key of a correspondent, in the keyring of
+// an account. Keys in the keyring are used to verify signatures of incoming PGP/MIME
+// signed messages.
+export interface OpenPGPKeyringKey {
+	ID: number
+	Created: Date
+	Fingerprint: string  // Upper-case hexadecimal.
+	UserIDs?: string[] | null  // From the key.
+}
+
+// OAuthToken is an OAuth 2.0 bearer token issued to an account, for authenticating
+// with the OAUTHBEARER and XOAUTH2 SASL mechanisms in IMAP and SMTP submission,
+// instead of a password. Only a hash of the token is stored.
+export interface OAuthToken {
+	ID: number
+	Created: Date
+	Account: string
+	Name: string  // Descriptive name to identify the token, e.g. the device or application where the token is used.
+	LoginAddress: string  // Email address the token authenticates as, must belong to account.
+	Expires: Date  // Zero for no expiration.
+	LastUsed: Date  // Zero if never used.
+}
+
+// APIToken is a long-lived token for an account that can be used instead of the
+// account password with IMAP, SMTP submission and the webapi, with limited access.
+// Meant for scripts and integrations, so they don't need the account password.
+// Only a hash of the token is stored.
+export interface APIToken {
+	ID: number
+	Created: Date
+	Account: string
+	Name: string  // Descriptive name to identify the token, e.g. the script or integration where the token is used.
+	Scope: APITokenScope
+	Expires: Date  // Zero for no expiration.
+	LastUsed: Date  // Zero if never used.
+}
+
 export type CSRFToken = string
 
 // Localpart is a decoded local part of an email address, before the "@".
@@ -289,21 +469,34 @@ export enum AuthResult {
 	AuthBadChannelBinding = "badchanbind",
 	AuthBadProtocol = "badprotocol",
 	AuthLoginDisabled = "logindisabled",
+	AuthTOTPRequired = "totprequired",  // Valid password, but two-factor authentication code missing.
+	AuthBadTOTP = "badtotp",
+	AuthReferral = "referral",  // Account moved, client referred to other host.
 	AuthError = "error",
 	AuthAborted = "aborted",
 }
 
-export const structTypes: {[typename: string]: boolean} = {"Account":true,"Address":true,"AddressAlias":true,"Alias":true,"AliasAddress":true,"AutomaticJunkFlags":true,"Destination":true,"Domain":true,"ImportProgress":true,"Incoming":true,"IncomingMeta":true,"IncomingWebhook":true,"JunkFilter":true,"LoginAttempt":true,"NameAddress":true,"Outgoing":true,"OutgoingWebhook":true,"Route":true,"Ruleset":true,"Structure":true,"SubjectPass":true,"Suppression":true,"TLSPublicKey":true}
-export const stringsTypes: {[typename: string]: boolean} = {"AuthResult":true,"CSRFToken":true,"Localpart":true,"OutgoingEvent":true}
+// APITokenScope limits what an API token can be used for.
+export enum APITokenScope {
+	APITokenScopeRead = "read",  // Read-only access to mailboxes and messages, with IMAP and the webapi.
+	APITokenScopeSend = "send",  // Only sending messages, with SMTP submission and the webapi.
+}
+
+export const structTypes: {[typename: string]: boolean} = {"APIToken":true,"Account":true,"Address":true,"AddressAlias":true,"Alias":true,"AliasAddress":true,"AttachmentLinks":true,"AutoArchive":true,"AutomaticJunkFlags":true,"Destination":true,"Domain":true,"ImportProgress":true,"Incoming":true,"IncomingMeta":true,"IncomingWebhook":true,"JunkFilter":true,"JunkRescanMove":true,"LoginAttempt":true,"LoginSession":true,"MailboxRetention":true,"MessageShare":true,"MessageShareAccess":true,"NameAddress":true,"Notification":true,"OAuthToken":true,"OpenPGPKey":true,"OpenPGPKeyringKey":true,"Outgoing":true,"OutgoingWebhook":true,"Route":true,"Ruleset":true,"SenderAllow":true,"Structure":true,"SubjectPass":true,"Suppression":true,"TLSPublicKey":true,"Vacation":true}
+export const stringsTypes: {[typename: string]: boolean} = {"APITokenScope":true,"AuthResult":true,"CSRFToken":true,"Localpart":true,"OutgoingEvent":true}
 export const intsTypes: {[typename: string]: boolean} = {}
 export const types: TypenameMap = {
-	"Account": {"Name":"Account","Docs":"","Fields":[{"Name":"OutgoingWebhook","Docs":"","Typewords":["nullable","OutgoingWebhook"]},{"Name":"IncomingWebhook","Docs":"","Typewords":["nullable","IncomingWebhook"]},{"Name":"FromIDLoginAddresses","Docs":"","Typewords":["[]","string"]},{"Name":"KeepRetiredMessagePeriod","Docs":"","Typewords":["int64"]},{"Name":"KeepRetiredWebhookPeriod","Docs":"","Typewords":["int64"]},{"Name":"LoginDisabled","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]},{"Name":"Destinations","Docs":"","Typewords":["{}","Destination"]},{"Name":"SubjectPass","Docs":"","Typewords":["SubjectPass"]},{"Name":"QuotaMessageSize","Docs":"","Typewords":["int64"]},{"Name":"RejectsMailbox","Docs":"","Typewords":["string"]},{"Name":"KeepRejects","Docs":"","Typewords":["bool"]},{"Name":"AutomaticJunkFlags","Docs":"","Typewords":["AutomaticJunkFlags"]},{"Name":"JunkFilter","Docs":"","Typewords":["nullable","JunkFilter"]},{"Name":"MaxOutgoingMessagesPerDay","Docs":"","Typewords":["int32"]},{"Name":"MaxFirstTimeRecipientsPerDay","Docs":"","Typewords":["int32"]},{"Name":"NoFirstTimeSenderDelay","Docs":"","Typewords":["bool"]},{"Name":"NoCustomPassword","Docs":"","Typewords":["bool"]},{"Name":"IMAPCapabilitiesDisabled","Docs":"","Typewords":["[]","string"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"Aliases","Docs":"","Typewords":["[]","AddressAlias"]}]},
+	"Account": {"Name":"Account","Docs":"","Fields":[{"Name":"OutgoingWebhook","Docs":"","Typewords":["nullable","OutgoingWebhook"]},{"Name":"IncomingWebhook","Docs":"","Typewords":["nullable","IncomingWebhook"]},{"Name":"FromIDLoginAddresses","Docs":"","Typewords":["[]","string"]},{"Name":"KeepRetiredMessagePeriod","Docs":"","Typewords":["int64"]},{"Name":"KeepRetiredWebhookPeriod","Docs":"","Typewords":["int64"]},{"Name":"DeliveryPriority","Docs":"","Typewords":["int32"]},{"Name":"AttachmentLinks","Docs":"","Typewords":["nullable","AttachmentLinks"]},{"Name":"WeeklyDigest","Docs":"","Typewords":["bool"]},{"Name":"SubaddressMailboxes","Docs":"","Typewords":["bool"]},{"Name":"Notifications","Docs":"","Typewords":["[]","Notification"]},{"Name":"LoginDisabled","Docs":"","Typewords":["string"]},{"Name":"ReadOnly","Docs":"","Typewords":["bool"]},{"Name":"SubmissionDisabled","Docs":"","Typewords":["string"]},{"Name":"IMAPReferralHost","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]},{"Name":"Destinations","Docs":"","Typewords":["{}","Destination"]},{"Name":"SubjectPass","Docs":"","Typewords":["SubjectPass"]},{"Name":"QuotaMessageSize","Docs":"","Typewords":["int64"]},{"Name":"RejectsMailbox","Docs":"","Typewords":["string"]},{"Name":"KeepRejects","Docs":"","Typewords":["bool"]},{"Name":"RejectsRescueAllow","Docs":"","Typewords":["string"]},{"Name":"MailboxRetention","Docs":"","Typewords":["[]","MailboxRetention"]},{"Name":"AutoArchive","Docs":"","Typewords":["nullable","AutoArchive"]},{"Name":"AutomaticJunkFlags","Docs":"","Typewords":["AutomaticJunkFlags"]},{"Name":"JunkFilter","Docs":"","Typewords":["nullable","JunkFilter"]},{"Name":"MaxOutgoingMessagesPerDay","Docs":"","Typewords":["int32"]},{"Name":"MaxFirstTimeRecipientsPerDay","Docs":"","Typewords":["int32"]},{"Name":"HoldOverSendLimits","Docs":"","Typewords":["bool"]},{"Name":"NoFirstTimeSenderDelay","Docs":"","Typewords":["bool"]},{"Name":"NoAutoCreateMailboxes","Docs":"","Typewords":["bool"]},{"Name":"NoCustomPassword","Docs":"","Typewords":["bool"]},{"Name":"IMAPCapabilitiesDisabled","Docs":"","Typewords":["[]","string"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"Aliases","Docs":"","Typewords":["[]","AddressAlias"]}]},
 	"OutgoingWebhook": {"Name":"OutgoingWebhook","Docs":"","Fields":[{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"Authorization","Docs":"","Typewords":["string"]},{"Name":"Events","Docs":"","Typewords":["[]","string"]}]},
 	"IncomingWebhook": {"Name":"IncomingWebhook","Docs":"","Fields":[{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"Authorization","Docs":"","Typewords":["string"]}]},
-	"Destination": {"Name":"Destination","Docs":"","Fields":[{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Rulesets","Docs":"","Typewords":["[]","Ruleset"]},{"Name":"SMTPError","Docs":"","Typewords":["string"]},{"Name":"MessageAuthRequiredSMTPError","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]}]},
-	"Ruleset": {"Name":"Ruleset","Docs":"","Fields":[{"Name":"SMTPMailFromRegexp","Docs":"","Typewords":["string"]},{"Name":"MsgFromRegexp","Docs":"","Typewords":["string"]},{"Name":"VerifiedDomain","Docs":"","Typewords":["string"]},{"Name":"HeadersRegexp","Docs":"","Typewords":["{}","string"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"ListAllowDomain","Docs":"","Typewords":["string"]},{"Name":"AcceptRejectsToMailbox","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Comment","Docs":"","Typewords":["string"]},{"Name":"VerifiedDNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"ListAllowDNSDomain","Docs":"","Typewords":["Domain"]}]},
+	"AttachmentLinks": {"Name":"AttachmentLinks","Docs":"","Fields":[{"Name":"MinSize","Docs":"","Typewords":["int64"]},{"Name":"ValidityDays","Docs":"","Typewords":["int32"]}]},
+	"Notification": {"Name":"Notification","Docs":"","Fields":[{"Name":"Type","Docs":"","Typewords":["string"]},{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"Token","Docs":"","Typewords":["string"]},{"Name":"Mailboxes","Docs":"","Typewords":["[]","string"]},{"Name":"FromRegexp","Docs":"","Typewords":["string"]},{"Name":"SubjectRegexp","Docs":"","Typewords":["string"]}]},
+	"Destination": {"Name":"Destination","Docs":"","Fields":[{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Rulesets","Docs":"","Typewords":["[]","Ruleset"]},{"Name":"SMTPError","Docs":"","Typewords":["string"]},{"Name":"MessageAuthRequiredSMTPError","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]},{"Name":"ForwardTo","Docs":"","Typewords":["[]","string"]},{"Name":"ForwardKeepCopy","Docs":"","Typewords":["bool"]}]},
+	"Ruleset": {"Name":"Ruleset","Docs":"","Fields":[{"Name":"SMTPMailFromRegexp","Docs":"","Typewords":["string"]},{"Name":"MsgFromRegexp","Docs":"","Typewords":["string"]},{"Name":"VerifiedDomain","Docs":"","Typewords":["string"]},{"Name":"HeadersRegexp","Docs":"","Typewords":["{}","string"]},{"Name":"MsgFromPatterns","Docs":"","Typewords":["[]","string"]},{"Name":"SPFResult","Docs":"","Typewords":["string"]},{"Name":"DKIMResult","Docs":"","Typewords":["string"]},{"Name":"DMARCResult","Docs":"","Typewords":["string"]},{"Name":"MinSize","Docs":"","Typewords":["int64"]},{"Name":"MaxSize","Docs":"","Typewords":["int64"]},{"Name":"Attachments","Docs":"","Typewords":["string"]},{"Name":"ListID","Docs":"","Typewords":["string"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"ListAllowDomain","Docs":"","Typewords":["string"]},{"Name":"AcceptRejectsToMailbox","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"MarkSeen","Docs":"","Typewords":["bool"]},{"Name":"ForwardTo","Docs":"","Typewords":["[]","string"]},{"Name":"Discard","Docs":"","Typewords":["bool"]},{"Name":"Comment","Docs":"","Typewords":["string"]},{"Name":"VerifiedDNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"ListAllowDNSDomain","Docs":"","Typewords":["Domain"]}]},
 	"Domain": {"Name":"Domain","Docs":"","Fields":[{"Name":"ASCII","Docs":"","Typewords":["string"]},{"Name":"Unicode","Docs":"","Typewords":["string"]}]},
 	"SubjectPass": {"Name":"SubjectPass","Docs":"","Fields":[{"Name":"Period","Docs":"","Typewords":["int64"]}]},
+	"MailboxRetention": {"Name":"MailboxRetention","Docs":"","Fields":[{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Period","Docs":"","Typewords":["int64"]}]},
+	"AutoArchive": {"Name":"AutoArchive","Docs":"","Fields":[{"Name":"Period","Docs":"","Typewords":["int64"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]}]},
 	"AutomaticJunkFlags": {"Name":"AutomaticJunkFlags","Docs":"","Fields":[{"Name":"Enabled","Docs":"","Typewords":["bool"]},{"Name":"JunkMailboxRegexp","Docs":"","Typewords":["string"]},{"Name":"NeutralMailboxRegexp","Docs":"","Typewords":["string"]},{"Name":"NotJunkMailboxRegexp","Docs":"","Typewords":["string"]}]},
 	"JunkFilter": {"Name":"JunkFilter","Docs":"","Fields":[{"Name":"Threshold","Docs":"","Typewords":["float64"]},{"Name":"Onegrams","Docs":"","Typewords":["bool"]},{"Name":"Twograms","Docs":"","Typewords":["bool"]},{"Name":"Threegrams","Docs":"","Typewords":["bool"]},{"Name":"MaxPower","Docs":"","Typewords":["float64"]},{"Name":"TopWords","Docs":"","Typewords":["int32"]},{"Name":"IgnoreWords","Docs":"","Typewords":["float64"]},{"Name":"RareWords","Docs":"","Typewords":["int32"]}]},
 	"Route": {"Name":"Route","Docs":"","Fields":[{"Name":"FromDomain","Docs":"","Typewords":["[]","string"]},{"Name":"ToDomain","Docs":"","Typewords":["[]","string"]},{"Name":"MinimumAttempts","Docs":"","Typewords":["int32"]},{"Name":"Transport","Docs":"","Typewords":["string"]},{"Name":"FromDomainASCII","Docs":"","Typewords":["[]","string"]},{"Name":"ToDomainASCII","Docs":"","Typewords":["[]","string"]}]},
@@ -318,22 +511,37 @@ export const types: TypenameMap = {
 	"NameAddress": {"Name":"NameAddress","Docs":"","Fields":[{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"Address","Docs":"","Typewords":["string"]}]},
 	"Structure": {"Name":"Structure","Docs":"","Fields":[{"Name":"ContentType","Docs":"","Typewords":["string"]},{"Name":"ContentTypeParams","Docs":"","Typewords":["{}","string"]},{"Name":"ContentID","Docs":"","Typewords":["string"]},{"Name":"ContentDisposition","Docs":"","Typewords":["string"]},{"Name":"Filename","Docs":"","Typewords":["string"]},{"Name":"DecodedSize","Docs":"","Typewords":["int64"]},{"Name":"Parts","Docs":"","Typewords":["[]","Structure"]}]},
 	"IncomingMeta": {"Name":"IncomingMeta","Docs":"","Fields":[{"Name":"MsgID","Docs":"","Typewords":["int64"]},{"Name":"MailFrom","Docs":"","Typewords":["string"]},{"Name":"MailFromValidated","Docs":"","Typewords":["bool"]},{"Name":"MsgFromValidated","Docs":"","Typewords":["bool"]},{"Name":"RcptTo","Docs":"","Typewords":["string"]},{"Name":"DKIMVerifiedDomains","Docs":"","Typewords":["[]","string"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"Received","Docs":"","Typewords":["timestamp"]},{"Name":"MailboxName","Docs":"","Typewords":["string"]},{"Name":"Automated","Docs":"","Typewords":["bool"]}]},
+	"SenderAllow": {"Name":"SenderAllow","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Localpart","Docs":"","Typewords":["Localpart"]},{"Name":"MessageID","Docs":"","Typewords":["int64"]}]},
+	"JunkRescanMove": {"Name":"JunkRescanMove","Docs":"","Fields":[{"Name":"MessageID","Docs":"","Typewords":["int64"]},{"Name":"Received","Docs":"","Typewords":["timestamp"]},{"Name":"From","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"DestMailbox","Docs":"","Typewords":["string"]},{"Name":"Reason","Docs":"","Typewords":["string"]},{"Name":"Probability","Docs":"","Typewords":["float64"]}]},
+	"Vacation": {"Name":"Vacation","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Active","Docs":"","Typewords":["bool"]},{"Name":"Start","Docs":"","Typewords":["timestamp"]},{"Name":"End","Docs":"","Typewords":["timestamp"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"Body","Docs":"","Typewords":["string"]},{"Name":"IntervalDays","Docs":"","Typewords":["int32"]}]},
 	"TLSPublicKey": {"Name":"TLSPublicKey","Docs":"","Fields":[{"Name":"Fingerprint","Docs":"","Typewords":["string"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Type","Docs":"","Typewords":["string"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"NoIMAPPreauth","Docs":"","Typewords":["bool"]},{"Name":"CertDER","Docs":"","Typewords":["nullable","string"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"LoginAddress","Docs":"","Typewords":["string"]}]},
 	"LoginAttempt": {"Name":"LoginAttempt","Docs":"","Fields":[{"Name":"Key","Docs":"","Typewords":["nullable","string"]},{"Name":"Last","Docs":"","Typewords":["timestamp"]},{"Name":"First","Docs":"","Typewords":["timestamp"]},{"Name":"Count","Docs":"","Typewords":["int64"]},{"Name":"AccountName","Docs":"","Typewords":["string"]},{"Name":"LoginAddress","Docs":"","Typewords":["string"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"LocalIP","Docs":"","Typewords":["string"]},{"Name":"TLS","Docs":"","Typewords":["string"]},{"Name":"TLSPubKeyFingerprint","Docs":"","Typewords":["string"]},{"Name":"Protocol","Docs":"","Typewords":["string"]},{"Name":"UserAgent","Docs":"","Typewords":["string"]},{"Name":"AuthMech","Docs":"","Typewords":["string"]},{"Name":"Result","Docs":"","Typewords":["AuthResult"]}]},
+	"LoginSession": {"Name":"LoginSession","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Expires","Docs":"","Typewords":["timestamp"]},{"Name":"AccountName","Docs":"","Typewords":["string"]},{"Name":"LoginAddress","Docs":"","Typewords":["string"]},{"Name":"LastUsed","Docs":"","Typewords":["timestamp"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"UserAgent","Docs":"","Typewords":["string"]}]},
+	"MessageShare": {"Name":"MessageShare","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"MessageID","Docs":"","Typewords":["int64"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"Raw","Docs":"","Typewords":["bool"]},{"Name":"Expires","Docs":"","Typewords":["timestamp"]},{"Name":"Accesses","Docs":"","Typewords":["int32"]},{"Name":"LastAccess","Docs":"","Typewords":["timestamp"]}]},
+	"MessageShareAccess": {"Name":"MessageShareAccess","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"ShareID","Docs":"","Typewords":["int64"]},{"Name":"Time","Docs":"","Typewords":["timestamp"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"UserAgent","Docs":"","Typewords":["string"]}]},
+	"OpenPGPKey": {"Name":"OpenPGPKey","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Address","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"WKDHash","Docs":"","Typewords":["string"]},{"Name":"Fingerprint","Docs":"","Typewords":["string"]},{"Name":"UserIDs","Docs":"","Typewords":["[]","string"]}]},
+	"OpenPGPKeyringKey": {"Name":"OpenPGPKeyringKey","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Fingerprint","Docs":"","Typewords":["string"]},{"Name":"UserIDs","Docs":"","Typewords":["[]","string"]}]},
+	"OAuthToken": {"Name":"OAuthToken","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"LoginAddress","Docs":"","Typewords":["string"]},{"Name":"Expires","Docs":"","Typewords":["timestamp"]},{"Name":"LastUsed","Docs":"","Typewords":["timestamp"]}]},
+	"APIToken": {"Name":"APIToken","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"Scope","Docs":"","Typewords":["APITokenScope"]},{"Name":"Expires","Docs":"","Typewords":["timestamp"]},{"Name":"LastUsed","Docs":"","Typewords":["timestamp"]}]},
 	"CSRFToken": {"Name":"CSRFToken","Docs":"","Values":null},
 	"Localpart": {"Name":"Localpart","Docs":"","Values":null},
 	"OutgoingEvent": {"Name":"OutgoingEvent","Docs":"","Values":[{"Name":"EventDelivered","Value":"delivered","Docs":""},{"Name":"EventSuppressed","Value":"suppressed","Docs":""},{"Name":"EventDelayed","Value":"delayed","Docs":""},{"Name":"EventFailed","Value":"failed","Docs":""},{"Name":"EventRelayed","Value":"relayed","Docs":""},{"Name":"EventExpanded","Value":"expanded","Docs":""},{"Name":"EventCanceled","Value":"canceled","Docs":""},{"Name":"EventUnrecognized","Value":"unrecognized","Docs":""}]},
-	"AuthResult": {"Name":"AuthResult","Docs":"","Values":[{"Name":"AuthSuccess","Value":"ok","Docs":""},{"Name":"AuthBadUser","Value":"baduser","Docs":""},{"Name":"AuthBadPassword","Value":"badpassword","Docs":""},{"Name":"AuthBadCredentials","Value":"badcreds","Docs":""},{"Name":"AuthBadChannelBinding","Value":"badchanbind","Docs":""},{"Name":"AuthBadProtocol","Value":"badprotocol","Docs":""},{"Name":"AuthLoginDisabled","Value":"logindisabled","Docs":""},{"Name":"AuthError","Value":"error","Docs":""},{"Name":"AuthAborted","Value":"aborted","Docs":""}]},
+	"AuthResult": {"Name":"AuthResult","Docs":"","Values":[{"Name":"AuthSuccess","Value":"ok","Docs":""},{"Name":"AuthBadUser","Value":"baduser","Docs":""},{"Name":"AuthBadPassword","Value":"badpassword","Docs":""},{"Name":"AuthBadCredentials","Value":"badcreds","Docs":""},{"Name":"AuthBadChannelBinding","Value":"badchanbind","Docs":""},{"Name":"AuthBadProtocol","Value":"badprotocol","Docs":""},{"Name":"AuthLoginDisabled","Value":"logindisabled","Docs":""},{"Name":"AuthTOTPRequired","Value":"totprequired","Docs":""},{"Name":"AuthBadTOTP","Value":"badtotp","Docs":""},{"Name":"AuthReferral","Value":"referral","Docs":""},{"Name":"AuthError","Value":"error","Docs":""},{"Name":"AuthAborted","Value":"aborted","Docs":""}]},
+	"APITokenScope": {"Name":"APITokenScope","Docs":"","Values":[{"Name":"APITokenScopeRead","Value":"read","Docs":""},{"Name":"APITokenScopeSend","Value":"send","Docs":""}]},
 }
 
 export const parser = {
 	Account: (v: any) => parse("Account", v) as Account,
 	OutgoingWebhook: (v: any) => parse("OutgoingWebhook", v) as OutgoingWebhook,
 	IncomingWebhook: (v: any) => parse("IncomingWebhook", v) as IncomingWebhook,
+	AttachmentLinks: (v: any) => parse("AttachmentLinks", v) as AttachmentLinks,
+	Notification: (v: any) => parse("Notification", v) as Notification,
 	Destination: (v: any) => parse("Destination", v) as Destination,
 	Ruleset: (v: any) => parse("Ruleset", v) as Ruleset,
 	Domain: (v: any) => parse("Domain", v) as Domain,
 	SubjectPass: (v: any) => parse("SubjectPass", v) as SubjectPass,
+	MailboxRetention: (v: any) => parse("MailboxRetention", v) as MailboxRetention,
+	AutoArchive: (v: any) => parse("AutoArchive", v) as AutoArchive,
 	AutomaticJunkFlags: (v: any) => parse("AutomaticJunkFlags", v) as AutomaticJunkFlags,
 	JunkFilter: (v: any) => parse("JunkFilter", v) as JunkFilter,
 	Route: (v: any) => parse("Route", v) as Route,
@@ -348,12 +556,23 @@ export const parser = {
 	NameAddress: (v: any) => parse("NameAddress", v) as NameAddress,
 	Structure: (v: any) => parse("Structure", v) as Structure,
 	IncomingMeta: (v: any) => parse("IncomingMeta", v) as IncomingMeta,
+	SenderAllow: (v: any) => parse("SenderAllow", v) as SenderAllow,
+	JunkRescanMove: (v: any) => parse("JunkRescanMove", v) as JunkRescanMove,
+	Vacation: (v: any) => parse("Vacation", v) as Vacation,
 	TLSPublicKey: (v: any) => parse("TLSPublicKey", v) as TLSPublicKey,
 	LoginAttempt: (v: any) => parse("LoginAttempt", v) as LoginAttempt,
+	LoginSession: (v: any) => parse("LoginSession", v) as LoginSession,
+	MessageShare: (v: any) => parse("MessageShare", v) as MessageShare,
+	MessageShareAccess: (v: any) => parse("MessageShareAccess", v) as MessageShareAccess,
+	OpenPGPKey: (v: any) => parse("OpenPGPKey", v) as OpenPGPKey,
+	OpenPGPKeyringKey: (v: any) => parse("OpenPGPKeyringKey", v) as OpenPGPKeyringKey,
+	OAuthToken: (v: any) => parse("OAuthToken", v) as OAuthToken,
+	APIToken: (v: any) => parse("APIToken", v) as APIToken,
 	CSRFToken: (v: any) => parse("CSRFToken", v) as CSRFToken,
 	Localpart: (v: any) => parse("Localpart", v) as Localpart,
 	OutgoingEvent: (v: any) => parse("OutgoingEvent", v) as OutgoingEvent,
 	AuthResult: (v: any) => parse("AuthResult", v) as AuthResult,
+	APITokenScope: (v: any) => parse("APITokenScope", v) as APITokenScope,
 }
 
 // Account exports web API functions for the account web interface. All its
@@ -397,12 +616,14 @@ export class Client {
 	}
 
 	// Login returns a session token for the credentials, or fails with error code
-	// "user:badLogin". Call LoginPrep to get a loginToken.
-	async Login(loginToken: string, username: string, password: string): Promise<CSRFToken> {
+	// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+	// authentication is enabled and totp is empty, the call fails with error code
+	// "user:totpRequired", and must be repeated with a TOTP code or recovery code.
+	async Login(loginToken: string, username: string, password: string, totp: string): Promise<CSRFToken> {
 		const fn: string = "Login"
-		const paramTypes: string[][] = [["string"],["string"],["string"]]
+		const paramTypes: string[][] = [["string"],["string"],["string"],["string"]]
 		const returnTypes: string[][] = [["CSRFToken"]]
-		const params: any[] = [loginToken, username, password]
+		const params: any[] = [loginToken, username, password, totp]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
 	}
 
@@ -614,12 +835,84 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
-	// RejectsSave saves the RejectsMailbox and KeepRejects settings.
-	async RejectsSave(mailbox: string, keep: boolean): Promise<void> {
+	// RejectsSave saves the RejectsMailbox, KeepRejects and RejectsRescueAllow
+	// settings.
+	async RejectsSave(mailbox: string, keep: boolean, rescueAllow: string): Promise<void> {
 		const fn: string = "RejectsSave"
-		const paramTypes: string[][] = [["string"],["bool"]]
+		const paramTypes: string[][] = [["string"],["bool"],["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [mailbox, keep, rescueAllow]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// MailboxRetentionSave saves the policies for removing old messages from
+	// mailboxes.
+	async MailboxRetentionSave(retention: MailboxRetention[] | null): Promise<void> {
+		const fn: string = "MailboxRetentionSave"
+		const paramTypes: string[][] = [["[]","MailboxRetention"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [retention]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// SenderAllows returns the sender allow entries, for senders whose messages with
+	// verified From address are accepted without junk filtering.
+	async SenderAllows(): Promise<SenderAllow[] | null> {
+		const fn: string = "SenderAllows"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","SenderAllow"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as SenderAllow[] | null
+	}
+
+	// SenderAllowRemove removes a sender allow entry.
+	async SenderAllowRemove(id: number): Promise<void> {
+		const fn: string = "SenderAllowRemove"
+		const paramTypes: string[][] = [["int64"]]
 		const returnTypes: string[][] = []
-		const params: any[] = [mailbox, keep]
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// JunkRescanDryRun evaluates messages received in the Inbox and Junk mailbox in
+	// the past days against the current junk filter and sender allow list, returning
+	// the messages that a junk rescan would move to the other mailbox. No messages are
+	// moved.
+	async JunkRescanDryRun(days: number): Promise<JunkRescanMove[] | null> {
+		const fn: string = "JunkRescanDryRun"
+		const paramTypes: string[][] = [["int32"]]
+		const returnTypes: string[][] = [["[]","JunkRescanMove"]]
+		const params: any[] = [days]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as JunkRescanMove[] | null
+	}
+
+	// JunkRescanStart starts a background junk rescan, moving the messages that
+	// JunkRescanDryRun would return. Messages are evaluated slowly to limit the load
+	// on the server. Only one rescan can be in progress per account.
+	async JunkRescanStart(days: number): Promise<void> {
+		const fn: string = "JunkRescanStart"
+		const paramTypes: string[][] = [["int32"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [days]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// Vacation returns the settings for automatic replies to incoming messages.
+	async Vacation(): Promise<Vacation> {
+		const fn: string = "Vacation"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["Vacation"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Vacation
+	}
+
+	// VacationSave saves the settings for automatic replies. Senders that already
+	// received an automatic reply will get a new reply on their next message.
+	async VacationSave(v: Vacation): Promise<void> {
+		const fn: string = "VacationSave"
+		const paramTypes: string[][] = [["Vacation"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [v]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
@@ -663,6 +956,222 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as LoginAttempt[] | null
 	}
 
+	// Sessions returns the active login sessions for the account and mail web
+	// interfaces, most recently used first, and the ID of the session of the
+	// request.
+	async Sessions(): Promise<[LoginSession[] | null, number]> {
+		const fn: string = "Sessions"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","LoginSession"],["int64"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [LoginSession[] | null, number]
+	}
+
+	// SessionRevoke removes a login session, it can no longer be used. The session
+	// of the request cannot be revoked, use Logout instead.
+	async SessionRevoke(id: number): Promise<void> {
+		const fn: string = "SessionRevoke"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// SessionsRevokeOthers removes all login sessions except the session of the
+	// request, and returns the number of revoked sessions.
+	async SessionsRevokeOthers(): Promise<number> {
+		const fn: string = "SessionsRevokeOthers"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["int32"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as number
+	}
+
+	// MessageShares returns the share links for messages, created through webmail,
+	// with the accesses of each link.
+	async MessageShares(): Promise<[MessageShare[] | null, (MessageShareAccess[] | null)[] | null]> {
+		const fn: string = "MessageShares"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","MessageShare"],["[]","[]","MessageShareAccess"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [MessageShare[] | null, (MessageShareAccess[] | null)[] | null]
+	}
+
+	// MessageShareRevoke removes a share link, it can no longer be used.
+	async MessageShareRevoke(id: number): Promise<void> {
+		const fn: string = "MessageShareRevoke"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// OpenPGPKeys returns the OpenPGP public keys of the account, as published
+	// through WKD and OPENPGPKEY DNS records.
+	async OpenPGPKeys(): Promise<OpenPGPKey[] | null> {
+		const fn: string = "OpenPGPKeys"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","OpenPGPKey"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as OpenPGPKey[] | null
+	}
+
+	// OpenPGPKeySet sets the OpenPGP public key for an address of the account,
+	// replacing any existing key for the address. The key can be ASCII-armored, and
+	// must have a user ID with the address.
+	async OpenPGPKeySet(address: string, key: string): Promise<OpenPGPKey> {
+		const fn: string = "OpenPGPKeySet"
+		const paramTypes: string[][] = [["string"],["string"]]
+		const returnTypes: string[][] = [["OpenPGPKey"]]
+		const params: any[] = [address, key]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as OpenPGPKey
+	}
+
+	// OpenPGPKeyRemove removes the OpenPGP public key for an address of the account.
+	async OpenPGPKeyRemove(address: string): Promise<void> {
+		const fn: string = "OpenPGPKeyRemove"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [address]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// OpenPGPKeyring returns the OpenPGP public keys of correspondents in the keyring
+	// of the account, used for verifying signatures of incoming messages.
+	async OpenPGPKeyring(): Promise<OpenPGPKeyringKey[] | null> {
+		const fn: string = "OpenPGPKeyring"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","OpenPGPKeyringKey"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as OpenPGPKeyringKey[] | null
+	}
+
+	// OpenPGPKeyringAdd adds an OpenPGP public key to the keyring of the account,
+	// replacing a key with the same fingerprint. The key can be ASCII-armored.
+	async OpenPGPKeyringAdd(key: string): Promise<OpenPGPKeyringKey> {
+		const fn: string = "OpenPGPKeyringAdd"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["OpenPGPKeyringKey"]]
+		const params: any[] = [key]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as OpenPGPKeyringKey
+	}
+
+	// OpenPGPKeyringRemove removes the key with the fingerprint from the keyring of
+	// the account.
+	async OpenPGPKeyringRemove(fingerprint: string): Promise<void> {
+		const fn: string = "OpenPGPKeyringRemove"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [fingerprint]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// OAuthTokens returns the OAuth bearer tokens of the account.
+	async OAuthTokens(): Promise<OAuthToken[] | null> {
+		const fn: string = "OAuthTokens"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","OAuthToken"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as OAuthToken[] | null
+	}
+
+	// OAuthTokenAdd creates a new OAuth bearer token for authenticating as an address
+	// of the account with the OAUTHBEARER or XOAUTH2 mechanisms, for IMAP and SMTP
+	// submission, so email applications don't have to store the password. If
+	// validDays is > 0, the token expires after that many days. The returned token
+	// cannot be retrieved later.
+	async OAuthTokenAdd(name: string, loginAddress: string, validDays: number): Promise<string> {
+		const fn: string = "OAuthTokenAdd"
+		const paramTypes: string[][] = [["string"],["string"],["int32"]]
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = [name, loginAddress, validDays]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
+
+	// OAuthTokenRemove removes an OAuth bearer token of the account.
+	async OAuthTokenRemove(id: number): Promise<void> {
+		const fn: string = "OAuthTokenRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// APITokens returns the API tokens of the account.
+	async APITokens(): Promise<APIToken[] | null> {
+		const fn: string = "APITokens"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","APIToken"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as APIToken[] | null
+	}
+
+	// APITokenAdd creates a new API token, for use instead of the password for any
+	// login address of the account. Tokens with scope "read" give read-only access
+	// with IMAP and the webapi, tokens with scope "send" can only send messages with
+	// SMTP submission and the webapi. If validDays is > 0, the token expires after
+	// that many days. The returned token cannot be retrieved later.
+	async APITokenAdd(name: string, scope: APITokenScope, validDays: number): Promise<string> {
+		const fn: string = "APITokenAdd"
+		const paramTypes: string[][] = [["string"],["APITokenScope"],["int32"]]
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = [name, scope, validDays]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
+
+	// APITokenRemove removes an API token of the account.
+	async APITokenRemove(id: number): Promise<void> {
+		const fn: string = "APITokenRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// TOTPStatus returns whether two-factor authentication with TOTP codes is enabled
+	// for logging in to the account and mail web interfaces, and the number of unused
+	// recovery codes.
+	async TOTPStatus(): Promise<[boolean, number]> {
+		const fn: string = "TOTPStatus"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["bool"],["int32"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [boolean, number]
+	}
+
+	// TOTPEnrollStart starts enabling two-factor authentication. The secret, and the
+	// otpauth URI with the secret, must be added to an authenticator app, after which
+	// TOTPEnrollConfirm must be called with a code from the app.
+	async TOTPEnrollStart(): Promise<[string, string]> {
+		const fn: string = "TOTPEnrollStart"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["string"],["string"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [string, string]
+	}
+
+	// TOTPEnrollConfirm enables two-factor authentication after the code from the
+	// authenticator app has been verified. Recovery codes are returned, for logging in
+	// when the authenticator app is not available, each can be used once. They are
+	// not stored and cannot be retrieved later.
+	async TOTPEnrollConfirm(code: string): Promise<string[] | null> {
+		const fn: string = "TOTPEnrollConfirm"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["[]","string"]]
+		const params: any[] = [code]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string[] | null
+	}
+
+	// TOTPDisable disables two-factor authentication. A current TOTP code or a
+	// recovery code is required.
+	async TOTPDisable(code: string): Promise<void> {
+		const fn: string = "TOTPDisable"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [code]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
 	async IMAPSave(capabilitiesDisabled: string[] | null): Promise<void> {
 		const fn: string = "IMAPSave"
 		const paramTypes: string[][] = [["[]","string"]]
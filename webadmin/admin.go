@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"maps"
 	"net"
@@ -47,6 +48,7 @@ import (
 	"github.com/mjl-/sherpaprom"
 
 	"github.com/mjl-/mox/admin"
+	"github.com/mjl-/mox/bimi"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dmarc"
@@ -62,12 +64,14 @@ import (
 	"github.com/mjl-/mox/mtastsdb"
 	"github.com/mjl-/mox/publicsuffix"
 	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/rejectdb"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/spf"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/tlsrpt"
 	"github.com/mjl-/mox/tlsrptdb"
 	"github.com/mjl-/mox/webauth"
+	"github.com/mjl-/mox/webauthn"
 )
 
 var pkglog = mlog.New("webadmin", nil)
@@ -91,6 +95,12 @@ var webadminFile = &mox.WebappFile{
 
 var adminDoc = mustParseAPI("admin", adminapiJSON)
 
+// APIDocJSON returns the sherpadoc documentation of the admin API in JSON, as served
+// at api/sherpadoc.json. The returned slice must not be modified.
+func APIDocJSON() []byte {
+	return adminapiJSON
+}
+
 func mustParseAPI(api string, buf []byte) (doc sherpadoc.Section) {
 	err := json.Unmarshal(buf, &doc)
 	if err != nil {
@@ -152,6 +162,7 @@ type requestInfo struct {
 	SessionToken store.SessionToken
 	Response     http.ResponseWriter
 	Request      *http.Request // For Proto and TLS connection state during message submit.
+	Tenant       string        // For sessions of tenant admins, only functions in tenantFunctions are allowed.
 }
 
 func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r *http.Request) {
@@ -176,6 +187,16 @@ func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r
 			http.Error(w, "405 - method not allowed - use get", http.StatusMethodNotAllowed)
 		}
 		return
+	} else if r.URL.Path == "/api/sherpadoc.json" {
+		// API documentation, for generating clients, e.g. with "mox genapi".
+		switch r.Method {
+		case "GET", "HEAD":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Write(adminapiJSON)
+		default:
+			http.Error(w, "405 - method not allowed - use get", http.StatusMethodNotAllowed)
+		}
+		return
 	}
 
 	isAPI := strings.HasPrefix(r.URL.Path, "/api/")
@@ -187,17 +208,24 @@ func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r
 
 	// All other URLs, except the login endpoint require some authentication.
 	var sessionToken store.SessionToken
-	if r.URL.Path != "/api/LoginPrep" && r.URL.Path != "/api/Login" {
+	var tenant string
+	if r.URL.Path != "/api/LoginPrep" && r.URL.Path != "/api/Login" && r.URL.Path != "/api/LoginTenant" && r.URL.Path != "/api/WebAuthnLoginStart" && r.URL.Path != "/api/LoginWebAuthn" {
 		var ok bool
-		_, sessionToken, _, ok = webauth.Check(ctx, log, webauth.Admin, "webadmin", isForwarded, w, r, isAPI, isAPI, false)
+		// For tenant sessions, the login address is the tenant name.
+		_, sessionToken, tenant, ok = webauth.Check(ctx, log, webauth.Admin, "webadmin", isForwarded, w, r, isAPI, isAPI, false)
 		if !ok {
 			// Response has been written already.
 			return
 		}
+		if fn, _ := strings.CutPrefix(r.URL.Path, "/api/"); tenant != "" && fn != "" && !tenantFunctions[fn] {
+			log.Info("function not allowed for tenant admin", slog.String("function", fn), slog.String("tenant", tenant))
+			http.Error(w, "403 - forbidden - not allowed for tenant admin", http.StatusForbidden)
+			return
+		}
 	}
 
 	if isAPI {
-		reqInfo := requestInfo{sessionToken, w, r}
+		reqInfo := requestInfo{sessionToken, w, r, tenant}
 		ctx = context.WithValue(ctx, requestInfoCtxKey, reqInfo)
 		apiHandler.ServeHTTP(w, r.WithContext(ctx))
 		return
@@ -257,12 +285,14 @@ func (w Admin) LoginPrep(ctx context.Context) string {
 }
 
 // Login returns a session token for the credentials, or fails with error code
-// "user:badLogin". Call LoginPrep to get a loginToken.
-func (w Admin) Login(ctx context.Context, loginToken, password string) store.CSRFToken {
+// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+// authentication is enabled and totp is empty, the call fails with error code
+// "user:totpRequired", and must be repeated with a TOTP code or recovery code.
+func (w Admin) Login(ctx context.Context, loginToken, password, totp string) store.CSRFToken {
 	log := pkglog.WithContext(ctx)
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 
-	csrfToken, err := webauth.Login(ctx, log, webauth.Admin, "webadmin", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, "", password)
+	csrfToken, err := webauth.Login(ctx, log, webauth.Admin, "webadmin", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, "", password, totp)
 	if _, ok := err.(*sherpa.Error); ok {
 		panic(err)
 	}
@@ -279,6 +309,197 @@ func (w Admin) Logout(ctx context.Context) {
 	xcheckf(ctx, err, "logout")
 }
 
+// WebAuthnLoginOptions holds parameters for logging in with a security key or
+// passkey through the WebAuthn API of the browser. Binary values are
+// raw-url-base64-encoded.
+type WebAuthnLoginOptions struct {
+	Challenge     string
+	RPID          string   // Relying party ID, the host name.
+	CredentialIDs []string // Credentials registered for RPID.
+}
+
+// WebAuthnLoginStart returns options for logging in with a registered security key
+// or passkey instead of the admin password. The assertion from the browser must be
+// passed to LoginWebAuthn.
+func (w Admin) WebAuthnLoginStart(ctx context.Context) WebAuthnLoginOptions {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	rpID, _ := webauth.WebAuthnRP(w.isForwarded, reqInfo.Request)
+	creds, err := store.AdminWebAuthnCredentialList(ctx)
+	xcheckf(ctx, err, "listing webauthn credentials")
+	var ids []string
+	for _, c := range creds {
+		if c.RPID == rpID {
+			ids = append(ids, c.CredentialID)
+		}
+	}
+	if len(ids) == 0 {
+		xusererrorf(ctx, "no security keys or passkeys registered for host name %s", rpID)
+	}
+	challenge := webauth.WebAuthnChallenge()
+	return WebAuthnLoginOptions{base64.RawURLEncoding.EncodeToString(challenge), rpID, ids}
+}
+
+// LoginWebAuthn returns a session token for a WebAuthn assertion by a registered
+// security key or passkey, or fails with error code "user:loginFailed". Call
+// LoginPrep to get a loginToken, and WebAuthnLoginStart for the challenge. Binary
+// values are raw-url-base64-encoded.
+func (w Admin) LoginWebAuthn(ctx context.Context, loginToken, challenge, credentialID, clientDataJSON, authenticatorData, signature string) store.CSRFToken {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	xdecode := func(s, what string) []byte {
+		buf, err := base64.RawURLEncoding.DecodeString(s)
+		xcheckuserf(ctx, err, "decoding %s", what)
+		return buf
+	}
+	csrfToken, err := webauth.AdminLoginWebAuthn(ctx, log, w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken,
+		xdecode(challenge, "challenge"),
+		xdecode(credentialID, "credential id"),
+		xdecode(clientDataJSON, "client data"),
+		xdecode(authenticatorData, "authenticator data"),
+		xdecode(signature, "signature"),
+	)
+	if _, ok := err.(*sherpa.Error); ok {
+		panic(err)
+	}
+	xcheckf(ctx, err, "login")
+	return csrfToken
+}
+
+// WebAuthnRegisterOptions holds parameters for registering a security key or
+// passkey through the WebAuthn API of the browser. Binary values are
+// raw-url-base64-encoded.
+type WebAuthnRegisterOptions struct {
+	Challenge            string
+	RPID                 string // Relying party ID, the host name.
+	UserID               string
+	ExcludeCredentialIDs []string // Credentials already registered for RPID.
+	Algorithms           []int    // COSE algorithm identifiers, in order of preference.
+}
+
+// webauthnUserID identifies the admin user to authenticators.
+var webauthnUserID = []byte("mox-admin")
+
+// WebAuthnRegisterStart returns options for registering a security key or passkey
+// for logging in, at the host name the admin web interface is accessed at. The
+// response from the browser must be passed to WebAuthnRegisterFinish.
+func (w Admin) WebAuthnRegisterStart(ctx context.Context) WebAuthnRegisterOptions {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	rpID, _ := webauth.WebAuthnRP(w.isForwarded, reqInfo.Request)
+	creds, err := store.AdminWebAuthnCredentialList(ctx)
+	xcheckf(ctx, err, "listing webauthn credentials")
+	var exclude []string
+	for _, c := range creds {
+		if c.RPID == rpID {
+			exclude = append(exclude, c.CredentialID)
+		}
+	}
+	challenge := webauth.WebAuthnChallenge()
+	return WebAuthnRegisterOptions{
+		base64.RawURLEncoding.EncodeToString(challenge),
+		rpID,
+		base64.RawURLEncoding.EncodeToString(webauthnUserID),
+		exclude,
+		webauthn.Algorithms,
+	}
+}
+
+// WebAuthnRegisterFinish verifies and stores a new security key or passkey, with
+// the response from the browser for the options from WebAuthnRegisterStart.
+// Binary values are raw-url-base64-encoded.
+func (w Admin) WebAuthnRegisterFinish(ctx context.Context, name, challenge, clientDataJSON, attestationObject string) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	if name == "" {
+		xusererrorf(ctx, "name required")
+	}
+	xdecode := func(s, what string) []byte {
+		buf, err := base64.RawURLEncoding.DecodeString(s)
+		xcheckuserf(ctx, err, "decoding %s", what)
+		return buf
+	}
+	challengeBuf := xdecode(challenge, "challenge")
+	if !webauth.WebAuthnChallengeUse(challengeBuf) {
+		xusererrorf(ctx, "unknown or expired challenge, try again")
+	}
+	rpID, origin := webauth.WebAuthnRP(w.isForwarded, reqInfo.Request)
+	cred, err := webauthn.VerifyRegistration(rpID, origin, challengeBuf, xdecode(clientDataJSON, "client data"), xdecode(attestationObject, "attestation object"), false)
+	xcheckuserf(ctx, err, "verifying registration")
+
+	c := store.AdminWebAuthnCredential{
+		Name:         name,
+		RPID:         rpID,
+		CredentialID: base64.RawURLEncoding.EncodeToString(cred.ID),
+		PublicKey:    cred.PublicKey,
+		SignCount:    cred.SignCount,
+	}
+	err = store.AdminWebAuthnCredentialAdd(ctx, &c)
+	if err != nil && errors.Is(err, bstore.ErrUnique) {
+		xusererrorf(ctx, "credential already registered")
+	}
+	xcheckf(ctx, err, "adding webauthn credential")
+}
+
+// WebAuthnCredentials returns the security keys and passkeys registered for
+// logging in.
+func (Admin) WebAuthnCredentials(ctx context.Context) []store.AdminWebAuthnCredential {
+	l, err := store.AdminWebAuthnCredentialList(ctx)
+	xcheckf(ctx, err, "listing webauthn credentials")
+	return l
+}
+
+// WebAuthnCredentialRemove removes a registered security key or passkey.
+func (Admin) WebAuthnCredentialRemove(ctx context.Context, id int64) {
+	err := store.AdminWebAuthnCredentialRemove(ctx, id)
+	if err == bstore.ErrAbsent {
+		xusererrorf(ctx, "credential not found")
+	}
+	xcheckf(ctx, err, "removing webauthn credential")
+}
+
+// Sessions returns the active admin sessions, most recently used first, and the
+// ID of the session of the request.
+func (Admin) Sessions(ctx context.Context) (sessions []store.AdminSession, currentID int64) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	l, err := store.AdminSessionList(ctx)
+	xcheckf(ctx, err, "listing sessions")
+	for _, s := range l {
+		if s.SessionToken == string(reqInfo.SessionToken) {
+			currentID = s.ID
+		}
+	}
+	return l, currentID
+}
+
+// SessionRevoke removes an admin session, it can no longer be used. The session
+// of the request cannot be revoked, use Logout instead.
+func (Admin) SessionRevoke(ctx context.Context, id int64) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	s, err := store.AdminSessionGet(ctx, reqInfo.SessionToken)
+	xcheckf(ctx, err, "get current session")
+	if s.ID == id {
+		xusererrorf(ctx, "cannot revoke current session, log out instead")
+	}
+	err = store.AdminSessionRemove(ctx, id)
+	if err == bstore.ErrAbsent {
+		xusererrorf(ctx, "session not found")
+	}
+	xcheckf(ctx, err, "revoking session")
+}
+
+// SessionsRevokeOthers removes all admin sessions except the session of the
+// request, and returns the number of revoked sessions.
+func (Admin) SessionsRevokeOthers(ctx context.Context) (revoked int) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	s, err := store.AdminSessionGet(ctx, reqInfo.SessionToken)
+	xcheckf(ctx, err, "get current session")
+	n, err := store.AdminSessionRemoveOthers(ctx, s.ID)
+	xcheckf(ctx, err, "revoking sessions")
+	return n
+}
+
 // Version returns the version, goos and goarch.
 func (w Admin) Version(ctx context.Context) (version, goos, goarch string) {
 	return moxvar.Version, runtime.GOOS, runtime.GOARCH
@@ -374,6 +595,17 @@ type MTASTSCheckResult struct {
 	Result
 }
 
+type BIMIRecord struct {
+	bimi.Record
+}
+
+type BIMICheckResult struct {
+	Domain string
+	TXT    string
+	Record *BIMIRecord
+	Result
+}
+
 type SRVConfCheckResult struct {
 	SRVs map[string][]net.SRV // Service (e.g. "_imaps") to records.
 	Result
@@ -411,6 +643,7 @@ type CheckResult struct {
 	HostTLSRPT   TLSRPTCheckResult
 	DomainTLSRPT TLSRPTCheckResult
 	MTASTS       MTASTSCheckResult
+	BIMI         BIMICheckResult
 	SRVConf      SRVConfCheckResult
 	Autoconf     AutoconfCheckResult
 	Autodiscover AutodiscoverCheckResult
@@ -444,9 +677,17 @@ func xsendingIPs(ctx context.Context) []net.IP {
 // CheckDomain checks the configuration for the domain, such as MX, SMTP STARTTLS,
 // SPF, DKIM, DMARC, TLSRPT, MTASTS, autoconfig, autodiscover.
 func (Admin) CheckDomain(ctx context.Context, domainName string) (r CheckResult) {
+	xcheckTenantDomain(ctx, domainName)
 	// todo future: should run these checks without a DNS cache so recent changes are picked up.
 
 	resolver := dns.StrictResolver{Pkg: "check", Log: pkglog.WithContext(ctx).Logger}
+	return CheckDomainResolver(ctx, resolver, domainName)
+}
+
+// CheckDomainResolver is like CheckDomain, but does DNS lookups through resolver,
+// e.g. a dns.OverlayResolver for evaluating proposed DNS records before publishing
+// them.
+func CheckDomainResolver(ctx context.Context, resolver dns.Resolver, domainName string) (r CheckResult) {
 	dialer := &net.Dialer{Timeout: 10 * time.Second}
 	nctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
@@ -736,6 +977,21 @@ EOF
 		r.MX.Instructions = []string{
 			fmt.Sprintf("Ensure a DNS MX record like the following exists:\n\n\t%s MX 10 %s\n\nWithout the trailing dot, the name would be interpreted as relative to the domain.", domain.ASCII+".", mox.Conf.Static.HostnameDomain.ASCII+"."),
 		}
+
+		// Subdomains accepted for delivery need MX records too. For wildcard names, we
+		// check a made-up name matching the wildcard.
+		for _, name := range admin.SubdomainMXNames(domConf, domain) {
+			checkName := strings.Replace(name, "*", "mox-subdomain-check", 1)
+			mxs, _, err := resolver.LookupMX(ctx, checkName+".")
+			if err != nil {
+				addf(&r.MX.Errors, "Looking up MX records for subdomain %s: %s", name, err)
+			} else if !slices.ContainsFunc(mxs, func(mx *net.MX) bool {
+				return strings.EqualFold(strings.TrimSuffix(mx.Host, "."), mox.Conf.Static.HostnameDomain.ASCII)
+			}) {
+				addf(&r.MX.Errors, "No MX record pointing to this host for subdomain %s.", name)
+			}
+			r.MX.Instructions = append(r.MX.Instructions, fmt.Sprintf("Ensure a DNS MX record like the following exists for accepting messages for subdomains:\n\n\t%s MX 10 %s", name+".", mox.Conf.Static.HostnameDomain.ASCII+"."))
+		}
 	}()
 
 	// TLS, mostly checking certificate expiration and CA trust.
@@ -973,6 +1229,13 @@ EOF
 			var xrecord *SPFRecord
 			if record != nil {
 				xrecord = &SPFRecord{*record}
+
+				// ../rfc/7208:1396
+				if n, err := spf.DNSLookups(ctx, log.Logger, resolver, record); err != nil {
+					addf(&r.SPF.Warnings, "Counting DNS lookups for evaluating %s SPF record: %s", kind, err)
+				} else if n > 10 {
+					addf(&r.SPF.Errors, "Evaluating %s SPF record can take %d DNS lookups, more than the limit of 10, causing a permerror result at receiving mail servers.", kind, n)
+				}
 			}
 
 			spfr := spf.Record{
@@ -1148,6 +1411,20 @@ EOF
 		dmarcr := dmarc.DefaultRecord
 		dmarcr.Policy = "reject"
 
+		if domConf.Subdomains != nil && domConf.Subdomains.DMARCPolicy != "" {
+			dmarcr.SubdomainPolicy = dmarc.Policy(domConf.Subdomains.DMARCPolicy)
+			// Without sp=, the domain policy applies to subdomains. ../rfc/7489
+			if record != nil {
+				sp := record.SubdomainPolicy
+				if sp == dmarc.PolicyEmpty {
+					sp = record.Policy
+				}
+				if sp != dmarcr.SubdomainPolicy {
+					addf(&r.DMARC.Errors, "DMARC policy for subdomains is %q, but configured subdomain policy is %q.", sp, dmarcr.SubdomainPolicy)
+				}
+			}
+		}
+
 		var extInstr string
 		if domConf.DMARC != nil {
 			// If the domain is in a different Organizational Domain, the receiving domain
@@ -1266,6 +1543,57 @@ HostTLSRPT:
 		addf(&result.Instructions, "%s", instr)
 	}
 
+	// BIMI
+	wg.Add(1)
+	go func() {
+		defer logPanic(ctx)
+		defer wg.Done()
+
+		var selector string
+		if domConf.BIMI != nil {
+			selector = domConf.BIMI.Selector
+		}
+		if selector == "" {
+			selector = bimi.DefaultSelector
+		}
+		bimiDomain, record, txt, _, err := bimi.Lookup(ctx, log.Logger, resolver, selector, domain)
+		r.BIMI.Domain = bimiDomain.Name()
+		r.BIMI.TXT = txt
+		if record != nil {
+			r.BIMI.Record = &BIMIRecord{*record}
+		}
+		if domConf.BIMI == nil {
+			// BIMI is optional, only report problems for existing records.
+			if err != nil && !errors.Is(err, bimi.ErrNoRecord) {
+				addf(&r.BIMI.Errors, "Looking up BIMI record: %s", err)
+			}
+			addf(&r.BIMI.Instructions, "Optionally configure BIMI for the domain in the config file, to publish a brand logo that receiving mail clients may show for messages passing DMARC.")
+			return
+		}
+
+		if err != nil {
+			addf(&r.BIMI.Errors, "Looking up BIMI record: %s", err)
+		} else if record.Location != domConf.BIMI.Location || record.Authority != domConf.BIMI.Authority {
+			addf(&r.BIMI.Errors, "BIMI record does not match configured location and authority.")
+		}
+		if domConf.BIMI.Authority == "" {
+			addf(&r.BIMI.Warnings, "No Verified Mark Certificate (VMC) configured, some mail providers only show logos with a VMC.")
+		}
+
+		// BIMI logos are only shown for messages passing DMARC with an enforcing policy.
+		_, _, dmarcRecord, _, _, err := dmarc.Lookup(ctx, log.Logger, resolver, domain)
+		if err == nil && dmarcRecord != nil && (dmarcRecord.Policy == dmarc.PolicyNone || dmarcRecord.Percentage < 100) {
+			addf(&r.BIMI.Errors, "DMARC policy must be quarantine or reject, for all messages (pct=100), for BIMI logos to be shown.")
+		} else if dmarcRecord == nil && (err == nil || errors.Is(err, dmarc.ErrNoRecord)) {
+			addf(&r.BIMI.Errors, "BIMI requires a DMARC record with a quarantine or reject policy.")
+		}
+
+		bimir := bimi.DefaultRecord
+		bimir.Location = domConf.BIMI.Location
+		bimir.Authority = domConf.BIMI.Authority
+		addf(&r.BIMI.Instructions, "Ensure a DNS TXT record like the following exists:\n\n\t%s._bimi.%s TXT %s\n\n", selector, domain.ASCII+".", mox.TXTStrings(bimir.String()))
+	}()
+
 	// Host TLSRPT
 	wg.Add(1)
 	var hostTLSRPTAddr smtp.Address
@@ -1557,13 +1885,19 @@ When enabling MTA-STS, or updating a policy, always update the policy first (thr
 	return
 }
 
-// Domains returns all configured domain names.
+// Domains returns all configured domain names, or only those of the tenant for
+// tenant sessions.
 func (Admin) Domains(ctx context.Context) []config.Domain {
-	return mox.Conf.DomainConfigs()
+	l := mox.Conf.DomainConfigs()
+	if tenant := reqTenant(ctx); tenant != "" {
+		l = slices.DeleteFunc(l, func(d config.Domain) bool { return d.Tenant != tenant })
+	}
+	return l
 }
 
 // Domain returns the dns domain for a (potentially unicode as IDNA) domain name.
 func (Admin) Domain(ctx context.Context, domain string) dns.Domain {
+	xcheckTenantDomain(ctx, domain)
 	d, err := dns.ParseDomain(domain)
 	xcheckuserf(ctx, err, "parse domain")
 	_, ok := mox.Conf.Domain(d)
@@ -1582,6 +1916,7 @@ func (Admin) ParseDomain(ctx context.Context, domain string) dns.Domain {
 
 // DomainConfig returns the configuration for a domain.
 func (Admin) DomainConfig(ctx context.Context, domain string) config.Domain {
+	xcheckTenantDomain(ctx, domain)
 	d, err := dns.ParseDomain(domain)
 	xcheckuserf(ctx, err, "parse domain")
 	conf, ok := mox.Conf.Domain(d)
@@ -1593,6 +1928,7 @@ func (Admin) DomainConfig(ctx context.Context, domain string) config.Domain {
 
 // DomainLocalparts returns the encoded localparts and accounts configured in domain.
 func (Admin) DomainLocalparts(ctx context.Context, domain string) (localpartAccounts map[string]string, localpartAliases map[string]config.Alias) {
+	xcheckTenantDomain(ctx, domain)
 	d, err := dns.ParseDomain(domain)
 	xcheckuserf(ctx, err, "parsing domain")
 	_, ok := mox.Conf.Domain(d)
@@ -1602,15 +1938,25 @@ func (Admin) DomainLocalparts(ctx context.Context, domain string) (localpartAcco
 	return mox.Conf.DomainLocalparts(d)
 }
 
-// Accounts returns the names of all configured and all disabled accounts.
+// Accounts returns the names of all configured and all disabled accounts, or
+// only those of the tenant for tenant sessions.
 func (Admin) Accounts(ctx context.Context) (all, disabled []string) {
 	all, disabled = mox.Conf.AccountsDisabled()
+	if tenant := reqTenant(ctx); tenant != "" {
+		other := func(name string) bool {
+			t, _, _ := mox.Conf.AccountTenant(name)
+			return t != tenant
+		}
+		all = slices.DeleteFunc(all, other)
+		disabled = slices.DeleteFunc(disabled, other)
+	}
 	slices.Sort(all)
 	return
 }
 
 // Account returns the parsed configuration of an account.
 func (Admin) Account(ctx context.Context, account string) (accountConfig config.Account, diskUsage int64) {
+	xcheckTenantAccount(ctx, account)
 	log := pkglog.WithContext(ctx)
 
 	acc, err := store.OpenAccount(log, account, false)
@@ -1705,6 +2051,7 @@ type TLSRPTSummary struct {
 // period start/end for one or all domains (when domain is empty).
 // The returned summaries are ordered by domain name.
 func (Admin) TLSRPTSummaries(ctx context.Context, start, end time.Time, policyDomain string) (domainSummaries []TLSRPTSummary) {
+	xcheckTenantDomain(ctx, policyDomain)
 	var polDom dns.Domain
 	if policyDomain != "" {
 		var err error
@@ -1790,6 +2137,7 @@ type DMARCSummary struct {
 // period start/end for one or all domains (when domain is empty).
 // The returned summaries are ordered by domain name.
 func (Admin) DMARCSummaries(ctx context.Context, start, end time.Time, domain string) (domainSummaries []DMARCSummary) {
+	xcheckTenantDomain(ctx, domain)
 	reports, err := dmarcdb.RecordsPeriodDomain(ctx, start, end, domain)
 	xcheckf(ctx, err, "fetching dmarc aggregate reports from database")
 	summaries := map[string]DMARCSummary{}
@@ -1930,6 +2278,7 @@ func (Admin) MonitorDNSBLsSave(ctx context.Context, text string) {
 // DomainRecords returns lines describing DNS records that should exist for the
 // configured domain.
 func (Admin) DomainRecords(ctx context.Context, domain string) []string {
+	xcheckTenantDomain(ctx, domain)
 	log := pkglog.WithContext(ctx)
 	return DomainRecords(ctx, log, domain)
 }
@@ -1952,7 +2301,11 @@ func DomainRecords(ctx context.Context, log mlog.Log, domain string) []string {
 	var certIssuerDomainName, acmeAccountURI string
 	public := mox.Conf.Static.Listeners["public"]
 	if public.TLS != nil && public.TLS.ACME != "" {
-		acme, ok := mox.Conf.Static.ACME[public.TLS.ACME]
+		acmeName := public.TLS.ACME
+		if dc.ACME != "" {
+			acmeName = dc.ACME
+		}
+		acme, ok := mox.Conf.Static.ACME[acmeName]
 		if ok && acme.Manager.Manager.Client != nil {
 			certIssuerDomainName = acme.IssuerDomainName
 			acc, err := acme.Manager.Manager.Client.GetReg(ctx, "")
@@ -1963,7 +2316,13 @@ func DomainRecords(ctx context.Context, log mlog.Log, domain string) []string {
 		}
 	}
 
-	records, err := admin.DomainRecords(dc, d, result.Authentic, certIssuerDomainName, acmeAccountURI)
+	openpgpKeys, err := store.OpenPGPKeyListDomain(ctx, d.ASCII)
+	xcheckf(ctx, err, "listing openpgp keys")
+	if openpgpKeys == nil {
+		openpgpKeys = []store.OpenPGPKey{}
+	}
+
+	records, err := admin.DomainRecords(dc, d, result.Authentic, certIssuerDomainName, acmeAccountURI, openpgpKeys)
 	xcheckf(ctx, err, "dns records")
 	return records
 }
@@ -1973,7 +2332,7 @@ func (Admin) DomainAdd(ctx context.Context, disabled bool, domain, accountName,
 	d, err := dns.ParseDomain(domain)
 	xcheckuserf(ctx, err, "parsing domain")
 
-	err = admin.DomainAdd(ctx, disabled, d, accountName, smtp.Localpart(norm.NFC.String(localpart)))
+	err = admin.DomainAdd(ctx, disabled, d, accountName, smtp.Localpart(norm.NFC.String(localpart)), dns.Domain{})
 	xcheckf(ctx, err, "adding domain")
 }
 
@@ -1989,24 +2348,29 @@ func (Admin) DomainRemove(ctx context.Context, domain string) {
 // AccountAdd adds existing a new account, with an initial email address, and
 // reloads the configuration.
 func (Admin) AccountAdd(ctx context.Context, accountName, address string) {
+	xcheckTenantAddress(ctx, address)
 	err := admin.AccountAdd(ctx, accountName, address)
 	xcheckf(ctx, err, "adding account")
 }
 
 // AccountRemove removes an existing account and reloads the configuration.
 func (Admin) AccountRemove(ctx context.Context, accountName string) {
+	xcheckTenantAccount(ctx, accountName)
 	err := admin.AccountRemove(ctx, accountName)
 	xcheckf(ctx, err, "removing account")
 }
 
 // AddressAdd adds a new address to the account, which must already exist.
 func (Admin) AddressAdd(ctx context.Context, address, accountName string) {
+	xcheckTenantAddress(ctx, address)
+	xcheckTenantAccount(ctx, accountName)
 	err := admin.AddressAdd(ctx, address, accountName)
 	xcheckf(ctx, err, "adding address")
 }
 
 // AddressRemove removes an existing address.
 func (Admin) AddressRemove(ctx context.Context, address string) {
+	xcheckTenantAddress(ctx, address)
 	err := admin.AddressRemove(ctx, address)
 	xcheckf(ctx, err, "removing address")
 }
@@ -2015,6 +2379,7 @@ func (Admin) AddressRemove(ctx context.Context, address string) {
 // Sessions are not interrupted, and will keep working. New login attempts must use the new password.
 // Password must be at least 8 characters.
 func (Admin) SetPassword(ctx context.Context, accountName, password string) {
+	xcheckTenantAccount(ctx, accountName)
 	log := pkglog.WithContext(ctx)
 	if len(password) < 8 {
 		xusererrorf(ctx, "message must be at least 8 characters")
@@ -2031,6 +2396,7 @@ func (Admin) SetPassword(ctx context.Context, accountName, password string) {
 
 // AccountSettingsSave set new settings for an account that only an admin can set.
 func (Admin) AccountSettingsSave(ctx context.Context, accountName string, maxOutgoingMessagesPerDay, maxFirstTimeRecipientsPerDay int, maxMsgSize int64, firstTimeSenderDelay, noCustomPassword bool) {
+	xcheckTenantAccount(ctx, accountName)
 	err := admin.AccountSave(ctx, accountName, func(acc *config.Account) {
 		acc.MaxOutgoingMessagesPerDay = maxOutgoingMessagesPerDay
 		acc.MaxFirstTimeRecipientsPerDay = maxFirstTimeRecipientsPerDay
@@ -2043,6 +2409,7 @@ func (Admin) AccountSettingsSave(ctx context.Context, accountName string, maxOut
 
 // AccountLoginDisabledSave saves the LoginDisabled field of an account.
 func (Admin) AccountLoginDisabledSave(ctx context.Context, accountName string, loginDisabled string) {
+	xcheckTenantAccount(ctx, accountName)
 	log := pkglog.WithContext(ctx)
 
 	acc, err := store.OpenAccount(log, accountName, false)
@@ -2064,6 +2431,7 @@ func (Admin) AccountLoginDisabledSave(ctx context.Context, accountName string, l
 // ClientConfigsDomain returns configurations for email clients, IMAP and
 // Submission (SMTP) for the domain.
 func (Admin) ClientConfigsDomain(ctx context.Context, domain string) admin.ClientConfigs {
+	xcheckTenantDomain(ctx, domain)
 	d, err := dns.ParseDomain(domain)
 	xcheckuserf(ctx, err, "parsing domain")
 
@@ -2116,6 +2484,23 @@ func (Admin) QueueList(ctx context.Context, filter queue.Filter, sort queue.Sort
 	return l
 }
 
+// QueueMessageSource returns the source of a message in the queue, e.g. for
+// reviewing a message held for review. At most 1MB is returned.
+func (Admin) QueueMessageSource(ctx context.Context, id int64) string {
+	mr, err := queue.OpenMessage(ctx, id)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "looking up message in queue")
+	}
+	xcheckf(ctx, err, "opening message from queue")
+	defer func() {
+		err := mr.Close()
+		pkglog.WithContext(ctx).Check(err, "closing message from queue")
+	}()
+	buf, err := io.ReadAll(io.LimitReader(mr, 1024*1024))
+	xcheckf(ctx, err, "reading message from queue")
+	return string(buf)
+}
+
 // QueueNextAttemptSet sets a new time for next delivery attempt of matching
 // messages from the queue.
 func (Admin) QueueNextAttemptSet(ctx context.Context, filter queue.Filter, minutes int) (affected int) {
@@ -2523,6 +2908,7 @@ func (Admin) RoutesSave(ctx context.Context, routes []config.Route) {
 
 // DomainDescriptionSave saves the description for a domain.
 func (Admin) DomainDescriptionSave(ctx context.Context, domainName, descr string) {
+	xcheckTenantDomain(ctx, domainName)
 	err := admin.DomainSave(ctx, domainName, func(domain *config.Domain) error {
 		domain.Description = descr
 		return nil
@@ -2532,6 +2918,7 @@ func (Admin) DomainDescriptionSave(ctx context.Context, domainName, descr string
 
 // DomainClientSettingsDomainSave saves the client settings domain for a domain.
 func (Admin) DomainClientSettingsDomainSave(ctx context.Context, domainName, clientSettingsDomain string) {
+	xcheckTenantDomain(ctx, domainName)
 	err := admin.DomainSave(ctx, domainName, func(domain *config.Domain) error {
 		domain.ClientSettingsDomain = clientSettingsDomain
 		return nil
@@ -2542,6 +2929,7 @@ func (Admin) DomainClientSettingsDomainSave(ctx context.Context, domainName, cli
 // DomainLocalpartConfigSave saves the localpart catchall and case-sensitive
 // settings for a domain.
 func (Admin) DomainLocalpartConfigSave(ctx context.Context, domainName string, localpartCatchallSeparators []string, localpartCaseSensitive bool) {
+	xcheckTenantDomain(ctx, domainName)
 	err := admin.DomainSave(ctx, domainName, func(domain *config.Domain) error {
 		// We don't allow introducing new catchall separators that are used in DMARC/TLS
 		// reporting. Can occur in existing configs for backwards compatibility.
@@ -2581,6 +2969,14 @@ func (Admin) DomainLocalpartConfigSave(ctx context.Context, domainName string, l
 // configuration for a domain. If localpart is empty, processing reports is
 // disabled.
 func (Admin) DomainDMARCAddressSave(ctx context.Context, domainName, localpart, domain, account, mailbox string) {
+	xcheckTenantDomain(ctx, domainName)
+	if localpart != "" {
+		// Reports are delivered to the account, which must belong to the tenant too.
+		if domain != "" {
+			xcheckTenantDomain(ctx, domain)
+		}
+		xcheckTenantAccount(ctx, account)
+	}
 	err := admin.DomainSave(ctx, domainName, func(d *config.Domain) error {
 		// DMARC reporting addresses can contain the localpart catchall separator(s) for
 		// backwards compability (hence not enforced when parsing the config files), but we
@@ -2612,6 +3008,14 @@ func (Admin) DomainDMARCAddressSave(ctx context.Context, domainName, localpart,
 // configuration for a domain. If localpart is empty, processing reports is
 // disabled.
 func (Admin) DomainTLSRPTAddressSave(ctx context.Context, domainName, localpart, domain, account, mailbox string) {
+	xcheckTenantDomain(ctx, domainName)
+	if localpart != "" {
+		// Reports are delivered to the account, which must belong to the tenant too.
+		if domain != "" {
+			xcheckTenantDomain(ctx, domain)
+		}
+		xcheckTenantAccount(ctx, account)
+	}
 	err := admin.DomainSave(ctx, domainName, func(d *config.Domain) error {
 		// TLS reporting addresses can contain the localpart catchall separator(s) for
 		// backwards compability (hence not enforced when parsing the config files), but we
@@ -2642,6 +3046,7 @@ func (Admin) DomainTLSRPTAddressSave(ctx context.Context, domainName, localpart,
 // DomainMTASTSSave saves the MTASTS policy for a domain. If policyID is empty,
 // no MTASTS policy is served.
 func (Admin) DomainMTASTSSave(ctx context.Context, domainName, policyID string, mode mtasts.Mode, maxAge time.Duration, mx []string) {
+	xcheckTenantDomain(ctx, domainName)
 	err := admin.DomainSave(ctx, domainName, func(d *config.Domain) error {
 		if policyID == "" {
 			d.MTASTS = nil
@@ -2661,6 +3066,7 @@ func (Admin) DomainMTASTSSave(ctx context.Context, domainName, policyID string,
 // DomainDKIMAdd adds a DKIM selector for a domain, generating a new private
 // key. The selector is not enabled for signing.
 func (Admin) DomainDKIMAdd(ctx context.Context, domainName, selector, algorithm, hash string, headerRelaxed, bodyRelaxed, seal bool, headers []string, lifetime time.Duration) {
+	xcheckTenantDomain(ctx, domainName)
 	d, err := dns.ParseDomain(domainName)
 	xcheckuserf(ctx, err, "parsing domain")
 	s, err := dns.ParseDomain(selector)
@@ -2671,6 +3077,7 @@ func (Admin) DomainDKIMAdd(ctx context.Context, domainName, selector, algorithm,
 
 // DomainDKIMRemove removes a DKIM selector for a domain.
 func (Admin) DomainDKIMRemove(ctx context.Context, domainName, selector string) {
+	xcheckTenantDomain(ctx, domainName)
 	d, err := dns.ParseDomain(domainName)
 	xcheckuserf(ctx, err, "parsing domain")
 	s, err := dns.ParseDomain(selector)
@@ -2681,8 +3088,12 @@ func (Admin) DomainDKIMRemove(ctx context.Context, domainName, selector string)
 
 // DomainDKIMSave saves the settings of selectors, and which to enable for
 // signing, for a domain. All currently configured selectors must be present,
-// selectors cannot be added/removed with this function.
-func (Admin) DomainDKIMSave(ctx context.Context, domainName string, selectors map[string]config.Selector, sign []string) {
+// selectors cannot be added/removed with this function. If signMailFrom is set,
+// submitted messages are also signed for the SMTP MAIL FROM domain if it is
+// different. If signHostname is set, submitted messages are also signed for the
+// domain of the hostname.
+func (Admin) DomainDKIMSave(ctx context.Context, domainName string, selectors map[string]config.Selector, sign []string, signMailFrom, signHostname bool) {
+	xcheckTenantDomain(ctx, domainName)
 	for _, s := range sign {
 		if _, ok := selectors[s]; !ok {
 			xcheckuserf(ctx, fmt.Errorf("cannot sign unknown selector %q", s), "checking selectors")
@@ -2720,8 +3131,10 @@ func (Admin) DomainDKIMSave(ctx context.Context, domainName string, selectors ma
 
 		// Enable the new selector settings.
 		d.DKIM = config.DKIM{
-			Selectors: sels,
-			Sign:      sign,
+			Selectors:    sels,
+			Sign:         sign,
+			SignMailFrom: signMailFrom,
+			SignHostname: signHostname,
 		}
 		return nil
 	})
@@ -2732,6 +3145,7 @@ func (Admin) DomainDKIMSave(ctx context.Context, domainName string, selectors ma
 // rejects incoming/outgoing messages involving the domain and does not request new
 // TLS certificats with ACME.
 func (Admin) DomainDisabledSave(ctx context.Context, domainName string, disabled bool) {
+	xcheckTenantDomain(ctx, domainName)
 	err := admin.DomainSave(ctx, domainName, func(d *config.Domain) error {
 		d.Disabled = disabled
 		return nil
@@ -2748,12 +3162,17 @@ func xparseAddress(ctx context.Context, lp, domain string) smtp.Address {
 }
 
 func (Admin) AliasAdd(ctx context.Context, aliaslp string, domainName string, alias config.Alias) {
+	xcheckTenantDomain(ctx, domainName)
+	for _, a := range alias.Addresses {
+		xcheckTenantAddress(ctx, a)
+	}
 	addr := xparseAddress(ctx, aliaslp, domainName)
 	err := admin.AliasAdd(ctx, addr, alias)
 	xcheckf(ctx, err, "adding alias")
 }
 
 func (Admin) AliasUpdate(ctx context.Context, aliaslp string, domainName string, postPublic, listMembers, allowMsgFrom bool) {
+	xcheckTenantDomain(ctx, domainName)
 	addr := xparseAddress(ctx, aliaslp, domainName)
 	alias := config.Alias{
 		PostPublic:   postPublic,
@@ -2765,29 +3184,235 @@ func (Admin) AliasUpdate(ctx context.Context, aliaslp string, domainName string,
 }
 
 func (Admin) AliasRemove(ctx context.Context, aliaslp string, domainName string) {
+	xcheckTenantDomain(ctx, domainName)
 	addr := xparseAddress(ctx, aliaslp, domainName)
 	err := admin.AliasRemove(ctx, addr)
 	xcheckf(ctx, err, "removing alias")
 }
 
 func (Admin) AliasAddressesAdd(ctx context.Context, aliaslp string, domainName string, addresses []string) {
+	xcheckTenantDomain(ctx, domainName)
+	for _, a := range addresses {
+		xcheckTenantAddress(ctx, a)
+	}
 	addr := xparseAddress(ctx, aliaslp, domainName)
 	err := admin.AliasAddressesAdd(ctx, addr, addresses)
 	xcheckf(ctx, err, "adding address to alias")
 }
 
 func (Admin) AliasAddressesRemove(ctx context.Context, aliaslp string, domainName string, addresses []string) {
+	xcheckTenantDomain(ctx, domainName)
 	addr := xparseAddress(ctx, aliaslp, domainName)
 	err := admin.AliasAddressesRemove(ctx, addr, addresses)
 	xcheckf(ctx, err, "removing address from alias")
 }
 
 func (Admin) TLSPublicKeys(ctx context.Context, accountOpt string) ([]store.TLSPublicKey, error) {
+	xcheckTenantAccount(ctx, accountOpt)
 	return store.TLSPublicKeyList(ctx, accountOpt)
 }
 
 func (Admin) LoginAttempts(ctx context.Context, accountName string, limit int) []store.LoginAttempt {
+	xcheckTenantAccount(ctx, accountName)
 	l, err := store.LoginAttemptList(ctx, accountName, limit)
 	xcheckf(ctx, err, "listing login attempts")
 	return l
 }
+
+// AuthLockouts returns IPs and accounts with recent failed authentication
+// attempts, and whether they are locked out.
+func (Admin) AuthLockouts(ctx context.Context) []store.AuthLockout {
+	l, err := store.AuthLockoutList(ctx)
+	xcheckf(ctx, err, "listing auth lockouts")
+	return l
+}
+
+// AuthLockoutClear clears failed authentication attempts and a lockout for an IP
+// or account name. If empty, all are cleared.
+func (Admin) AuthLockoutClear(ctx context.Context, ipOrAccount string) {
+	_, err := store.AuthLockoutClear(ctx, ipOrAccount)
+	xcheckf(ctx, err, "clearing auth lockouts")
+}
+
+// TLSCertificate is a TLS certificate used by listeners, either requested
+// through ACME or configured with key and certificate files.
+type TLSCertificate struct {
+	Listeners []string // Names of listeners using the certificate.
+
+	ACME     string // Name of ACME provider, empty for certificates from files.
+	Hostname string // For ACME, the host name the certificate is for.
+	KeyType  string // For ACME, "ecdsa" or "rsa".
+
+	Listener string // For certificates from files, listener with the KeyCerts.
+	Index    int    // For certificates from files, index in KeyCerts.
+	CertFile string
+	KeyFile  string
+
+	Present    bool // ACME certificates are requested when first needed.
+	DNSNames   []string
+	Issuer     string
+	NotBefore  time.Time
+	NotAfter   time.Time
+	RenewAfter time.Time // For ACME, when automatic renewal starts.
+
+	Renewing      bool   // For ACME, whether renewal started through the admin interface is in progress.
+	LastError     string // For ACME, last error requesting a certificate, e.g. failed validation.
+	LastErrorTime time.Time
+	NextAttempt   time.Time // For ACME, after failed requests, no new certificate is requested before this time.
+	Error         string    // Error getting the certificate.
+}
+
+// TLSCertificates returns the TLS certificates of all listeners, both from ACME
+// and from files.
+func (Admin) TLSCertificates(ctx context.Context) []TLSCertificate {
+	log := pkglog.WithContext(ctx)
+
+	fill := func(c *TLSCertificate, cert *x509.Certificate) {
+		c.Present = true
+		c.DNSNames = cert.DNSNames
+		c.Issuer = cert.Issuer.String()
+		c.NotBefore = cert.NotBefore
+		c.NotAfter = cert.NotAfter
+	}
+
+	var l []TLSCertificate
+
+	acmeNames := slices.Sorted(maps.Keys(mox.Conf.Static.ACME))
+	for _, acmeName := range acmeNames {
+		acme := mox.Conf.Static.ACME[acmeName]
+		if acme.Manager == nil {
+			continue
+		}
+		var listeners []string
+		for _, name := range slices.Sorted(maps.Keys(mox.Conf.Static.Listeners)) {
+			if lis := mox.Conf.Static.Listeners[name]; lis.TLS != nil && lis.TLS.ACME == acmeName {
+				listeners = append(listeners, name)
+			}
+		}
+		renewBefore := acme.RenewBefore
+		if renewBefore == 0 {
+			renewBefore = 30 * 24 * time.Hour
+		}
+
+		hostnames := acme.Manager.Hostnames()
+		slices.SortFunc(hostnames, func(a, b dns.Domain) int { return strings.Compare(a.Name(), b.Name()) })
+		for _, h := range hostnames {
+			st := acme.Manager.HostState(h)
+			for _, rsaKey := range []bool{false, true} {
+				c := TLSCertificate{
+					Listeners:     listeners,
+					ACME:          acmeName,
+					Hostname:      h.Name(),
+					KeyType:       "ecdsa",
+					Renewing:      st.Renewing,
+					LastError:     st.LastError,
+					LastErrorTime: st.LastErrorTime,
+					NextAttempt:   st.NextAttempt,
+				}
+				if rsaKey {
+					c.KeyType = "rsa"
+				}
+				cert, err := acme.Manager.CachedCertificate(ctx, h, rsaKey)
+				if err != nil {
+					log.Debugx("getting certificate from acme cache", err, slog.Any("host", h))
+					c.Error = err.Error()
+				} else if cert != nil {
+					fill(&c, cert)
+					c.RenewAfter = cert.NotAfter.Add(-renewBefore)
+				} else if rsaKey {
+					// RSA certificates are only requested for clients that don't support ECDSA.
+					continue
+				}
+				l = append(l, c)
+			}
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(mox.Conf.Static.Listeners)) {
+		lis := mox.Conf.Static.Listeners[name]
+		if lis.TLS == nil {
+			continue
+		}
+		for i, kc := range lis.TLS.KeyCerts {
+			c := TLSCertificate{
+				Listeners: []string{name},
+				Listener:  name,
+				Index:     i,
+				CertFile:  kc.CertFile,
+				KeyFile:   kc.KeyFile,
+			}
+			if lis.TLS.KeyCertsLoaded == nil {
+				c.Error = "certificates not loaded"
+			} else if certs := *lis.TLS.KeyCertsLoaded.Load(); i < len(certs) && certs[i].Leaf != nil {
+				fill(&c, certs[i].Leaf)
+			}
+			l = append(l, c)
+		}
+	}
+	return l
+}
+
+// TLSCertificateRenew starts requesting new certificates through ACME for the
+// host name, regardless of the expiration time of the current certificates. The
+// request is done in the background, progress is visible in TLSCertificates.
+func (Admin) TLSCertificateRenew(ctx context.Context, acmeName, hostname string) {
+	log := pkglog.WithContext(ctx)
+
+	acme, ok := mox.Conf.Static.ACME[acmeName]
+	if !ok || acme.Manager == nil {
+		xusererrorf(ctx, "unknown acme provider")
+	}
+	h, err := dns.ParseDomain(hostname)
+	xcheckuserf(ctx, err, "parsing hostname")
+	err = acme.Manager.Renew(log, h)
+	xcheckuserf(ctx, err, "starting renewal")
+}
+
+// TLSKeyCertReplace replaces the certificate and private key configured for a
+// listener, writing the key and certificate files. The new certificate is used for
+// new connections immediately.
+func (Admin) TLSKeyCertReplace(ctx context.Context, listenerName string, index int, certPEM, keyPEM string) {
+	err := admin.TLSKeyCertReplace(ctx, listenerName, index, []byte(certPEM), []byte(keyPEM))
+	xcheckf(ctx, err, "replacing tls key and certificate")
+}
+
+// RejectedList returns the retained messages that were rejected during the SMTP
+// transaction, most recent first, optionally only for a domain.
+func (Admin) RejectedList(ctx context.Context, domain string) []rejectdb.Message {
+	if domain != "" {
+		d, err := dns.ParseDomain(domain)
+		xcheckuserf(ctx, err, "parsing domain")
+		domain = d.Name()
+	}
+	l, err := rejectdb.List(ctx, domain)
+	xcheckf(ctx, err, "listing rejected messages")
+	return l
+}
+
+// RejectedMessage returns a retained rejected message and its contents.
+func (Admin) RejectedMessage(ctx context.Context, id int64) (rejectdb.Message, string) {
+	m, data, err := rejectdb.Get(ctx, id)
+	if err == bstore.ErrAbsent {
+		xusererrorf(ctx, "no such message")
+	}
+	xcheckf(ctx, err, "get rejected message")
+	return m, string(data)
+}
+
+// RejectedRemove removes retained rejected messages.
+func (Admin) RejectedRemove(ctx context.Context, ids []int64) {
+	err := rejectdb.Remove(ctx, ids...)
+	if err == bstore.ErrAbsent {
+		xusererrorf(ctx, "no such message")
+	}
+	xcheckf(ctx, err, "removing rejected messages")
+}
+
+// RejectedDeliver delivers a retained rejected message to the accounts of its
+// recipients, without the checks that caused the rejection. Typically used after
+// adjusting the policy. The addresses the message was delivered to are returned.
+func (Admin) RejectedDeliver(ctx context.Context, id int64) (delivered []string) {
+	delivered, err := admin.RejectedDeliver(ctx, id)
+	xcheckf(ctx, err, "delivering rejected message")
+	return delivered
+}
@@ -260,14 +260,21 @@ var api;
 		AuthResult["AuthBadChannelBinding"] = "badchanbind";
 		AuthResult["AuthBadProtocol"] = "badprotocol";
 		AuthResult["AuthLoginDisabled"] = "logindisabled";
+		AuthResult["AuthTOTPRequired"] = "totprequired";
+		AuthResult["AuthBadTOTP"] = "badtotp";
+		AuthResult["AuthReferral"] = "referral";
 		AuthResult["AuthError"] = "error";
 		AuthResult["AuthAborted"] = "aborted";
 	})(AuthResult = api.AuthResult || (api.AuthResult = {}));
-	api.structTypes = { "Account": true, "Address": true, "AddressAlias": true, "Alias": true, "AliasAddress": true, "AuthResults": true, "AutoconfCheckResult": true, "AutodiscoverCheckResult": true, "AutodiscoverSRV": true, "AutomaticJunkFlags": true, "Canonicalization": true, "CheckResult": true, "ClientConfigs": true, "ClientConfigsEntry": true, "ConfigDomain": true, "DANECheckResult": true, "DKIM": true, "DKIMAuthResult": true, "DKIMCheckResult": true, "DKIMRecord": true, "DMARC": true, "DMARCCheckResult": true, "DMARCRecord": true, "DMARCSummary": true, "DNSSECResult": true, "DateRange": true, "Destination": true, "Directive": true, "Domain": true, "DomainFeedback": true, "Dynamic": true, "Evaluation": true, "EvaluationStat": true, "Extension": true, "FailureDetails": true, "Filter": true, "HoldRule": true, "Hook": true, "HookFilter": true, "HookResult": true, "HookRetired": true, "HookRetiredFilter": true, "HookRetiredSort": true, "HookSort": true, "IPDomain": true, "IPRevCheckResult": true, "Identifiers": true, "IncomingWebhook": true, "JunkFilter": true, "LoginAttempt": true, "MTASTS": true, "MTASTSCheckResult": true, "MTASTSRecord": true, "MX": true, "MXCheckResult": true, "Modifier": true, "Msg": true, "MsgResult": true, "MsgRetired": true, "OutgoingWebhook": true, "Pair": true, "Policy": true, "PolicyEvaluated": true, "PolicyOverrideReason": true, "PolicyPublished": true, "PolicyRecord": true, "Record": true, "Report": true, "ReportMetadata": true, "ReportRecord": true, "Result": true, "ResultPolicy": true, "RetiredFilter": true, "RetiredSort": true, "Reverse": true, "Route": true, "Row": true, "Ruleset": true, "SMTPAuth": true, "SPFAuthResult": true, "SPFCheckResult": true, "SPFRecord": true, "SRV": true, "SRVConfCheckResult": true, "STSMX": true, "Selector": true, "Sort": true, "SubjectPass": true, "Summary": true, "SuppressAddress": true, "TLSCheckResult": true, "TLSPublicKey": true, "TLSRPT": true, "TLSRPTCheckResult": true, "TLSRPTDateRange": true, "TLSRPTRecord": true, "TLSRPTSummary": true, "TLSRPTSuppressAddress": true, "TLSReportRecord": true, "TLSResult": true, "Transport": true, "TransportDirect": true, "TransportFail": true, "TransportSMTP": true, "TransportSocks": true, "URI": true, "WebForward": true, "WebHandler": true, "WebInternal": true, "WebRedirect": true, "WebStatic": true, "WebserverConfig": true };
+	api.structTypes = { "Account": true, "Address": true, "AddressAlias": true, "AdminSession": true, "AdminWebAuthnCredential": true, "Alias": true, "AliasAddress": true, "AttachmentLinks": true, "AuthLockout": true, "AuthResults": true, "AutoArchive": true, "AutoconfCheckResult": true, "AutodiscoverCheckResult": true, "AutodiscoverSRV": true, "AutomaticJunkFlags": true, "BIMI": true, "BIMICheckResult": true, "BIMIRecord": true, "Canary": true, "Canonicalization": true, "CheckResult": true, "ClientConfigs": true, "ClientConfigsEntry": true, "ConfigDomain": true, "DANECheckResult": true, "DKIM": true, "DKIMAuthResult": true, "DKIMCheckResult": true, "DKIMRecord": true, "DMARC": true, "DMARCCheckResult": true, "DMARCRecord": true, "DMARCSummary": true, "DNSSECResult": true, "DateRange": true, "Destination": true, "DestinationPattern": true, "Directive": true, "Domain": true, "DomainFeedback": true, "DomainRetire": true, "Dynamic": true, "Evaluation": true, "EvaluationStat": true, "Extension": true, "FailureDetails": true, "Filter": true, "HoldRule": true, "Hook": true, "HookFilter": true, "HookResult": true, "HookRetired": true, "HookRetiredFilter": true, "HookRetiredSort": true, "HookSort": true, "IPDomain": true, "IPRevCheckResult": true, "Identifiers": true, "IncomingWebhook": true, "JunkFilter": true, "List": true, "LoginAttempt": true, "MTASTS": true, "MTASTSCheckResult": true, "MTASTSRecord": true, "MX": true, "MXCheckResult": true, "MailboxRetention": true, "Message": true, "Modifier": true, "Msg": true, "MsgResult": true, "MsgResultHost": true, "MsgRetired": true, "Notification": true, "OutgoingWebhook": true, "Pair": true, "Policy": true, "PolicyEvaluated": true, "PolicyOverrideReason": true, "PolicyPublished": true, "PolicyRecord": true, "Record": true, "RejectText": true, "RejectedRetention": true, "Report": true, "ReportMetadata": true, "ReportRecord": true, "Result": true, "ResultPolicy": true, "RetiredFilter": true, "RetiredSort": true, "Reverse": true, "Route": true, "Row": true, "Ruleset": true, "SMTPAuth": true, "SPFAuthResult": true, "SPFCheckResult": true, "SPFRecord": true, "SRV": true, "SRVConfCheckResult": true, "STSMX": true, "Selector": true, "Sort": true, "SubdomainRoute": true, "Subdomains": true, "SubjectPass": true, "Summary": true, "SuppressAddress": true, "TLSCertificate": true, "TLSCheckResult": true, "TLSPublicKey": true, "TLSRPT": true, "TLSRPTCheckResult": true, "TLSRPTDateRange": true, "TLSRPTRecord": true, "TLSRPTSummary": true, "TLSRPTSuppressAddress": true, "TLSReportRecord": true, "TLSResult": true, "Tenant": true, "Transport": true, "TransportDirect": true, "TransportFail": true, "TransportSMTP": true, "TransportSMTPHost": true, "TransportSocks": true, "URI": true, "WebAuthnLoginOptions": true, "WebAuthnRegisterOptions": true, "WebForward": true, "WebHandler": true, "WebInternal": true, "WebRedirect": true, "WebStatic": true, "WebserverConfig": true };
 	api.stringsTypes = { "Align": true, "AuthResult": true, "CSRFToken": true, "DMARCPolicy": true, "IP": true, "Localpart": true, "Mode": true, "RUA": true };
 	api.intsTypes = {};
 	api.types = {
-		"CheckResult": { "Name": "CheckResult", "Docs": "", "Fields": [{ "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "DNSSEC", "Docs": "", "Typewords": ["DNSSECResult"] }, { "Name": "IPRev", "Docs": "", "Typewords": ["IPRevCheckResult"] }, { "Name": "MX", "Docs": "", "Typewords": ["MXCheckResult"] }, { "Name": "TLS", "Docs": "", "Typewords": ["TLSCheckResult"] }, { "Name": "DANE", "Docs": "", "Typewords": ["DANECheckResult"] }, { "Name": "SPF", "Docs": "", "Typewords": ["SPFCheckResult"] }, { "Name": "DKIM", "Docs": "", "Typewords": ["DKIMCheckResult"] }, { "Name": "DMARC", "Docs": "", "Typewords": ["DMARCCheckResult"] }, { "Name": "HostTLSRPT", "Docs": "", "Typewords": ["TLSRPTCheckResult"] }, { "Name": "DomainTLSRPT", "Docs": "", "Typewords": ["TLSRPTCheckResult"] }, { "Name": "MTASTS", "Docs": "", "Typewords": ["MTASTSCheckResult"] }, { "Name": "SRVConf", "Docs": "", "Typewords": ["SRVConfCheckResult"] }, { "Name": "Autoconf", "Docs": "", "Typewords": ["AutoconfCheckResult"] }, { "Name": "Autodiscover", "Docs": "", "Typewords": ["AutodiscoverCheckResult"] }] },
+		"WebAuthnLoginOptions": { "Name": "WebAuthnLoginOptions", "Docs": "", "Fields": [{ "Name": "Challenge", "Docs": "", "Typewords": ["string"] }, { "Name": "RPID", "Docs": "", "Typewords": ["string"] }, { "Name": "CredentialIDs", "Docs": "", "Typewords": ["[]", "string"] }] },
+		"WebAuthnRegisterOptions": { "Name": "WebAuthnRegisterOptions", "Docs": "", "Fields": [{ "Name": "Challenge", "Docs": "", "Typewords": ["string"] }, { "Name": "RPID", "Docs": "", "Typewords": ["string"] }, { "Name": "UserID", "Docs": "", "Typewords": ["string"] }, { "Name": "ExcludeCredentialIDs", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Algorithms", "Docs": "", "Typewords": ["[]", "int32"] }] },
+		"AdminWebAuthnCredential": { "Name": "AdminWebAuthnCredential", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "RPID", "Docs": "", "Typewords": ["string"] }, { "Name": "LastUsed", "Docs": "", "Typewords": ["timestamp"] }] },
+		"AdminSession": { "Name": "AdminSession", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Expires", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Tenant", "Docs": "", "Typewords": ["string"] }, { "Name": "LastUsed", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "UserAgent", "Docs": "", "Typewords": ["string"] }] },
+		"CheckResult": { "Name": "CheckResult", "Docs": "", "Fields": [{ "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "DNSSEC", "Docs": "", "Typewords": ["DNSSECResult"] }, { "Name": "IPRev", "Docs": "", "Typewords": ["IPRevCheckResult"] }, { "Name": "MX", "Docs": "", "Typewords": ["MXCheckResult"] }, { "Name": "TLS", "Docs": "", "Typewords": ["TLSCheckResult"] }, { "Name": "DANE", "Docs": "", "Typewords": ["DANECheckResult"] }, { "Name": "SPF", "Docs": "", "Typewords": ["SPFCheckResult"] }, { "Name": "DKIM", "Docs": "", "Typewords": ["DKIMCheckResult"] }, { "Name": "DMARC", "Docs": "", "Typewords": ["DMARCCheckResult"] }, { "Name": "HostTLSRPT", "Docs": "", "Typewords": ["TLSRPTCheckResult"] }, { "Name": "DomainTLSRPT", "Docs": "", "Typewords": ["TLSRPTCheckResult"] }, { "Name": "MTASTS", "Docs": "", "Typewords": ["MTASTSCheckResult"] }, { "Name": "BIMI", "Docs": "", "Typewords": ["BIMICheckResult"] }, { "Name": "SRVConf", "Docs": "", "Typewords": ["SRVConfCheckResult"] }, { "Name": "Autoconf", "Docs": "", "Typewords": ["AutoconfCheckResult"] }, { "Name": "Autodiscover", "Docs": "", "Typewords": ["AutodiscoverCheckResult"] }] },
 		"DNSSECResult": { "Name": "DNSSECResult", "Docs": "", "Fields": [{ "Name": "Errors", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Warnings", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Instructions", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"IPRevCheckResult": { "Name": "IPRevCheckResult", "Docs": "", "Fields": [{ "Name": "Hostname", "Docs": "", "Typewords": ["Domain"] }, { "Name": "IPNames", "Docs": "", "Typewords": ["{}", "[]", "string"] }, { "Name": "Errors", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Warnings", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Instructions", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"Domain": { "Name": "Domain", "Docs": "", "Fields": [{ "Name": "ASCII", "Docs": "", "Typewords": ["string"] }, { "Name": "Unicode", "Docs": "", "Typewords": ["string"] }] },
@@ -293,28 +300,42 @@ var api;
 		"Pair": { "Name": "Pair", "Docs": "", "Fields": [{ "Name": "Key", "Docs": "", "Typewords": ["string"] }, { "Name": "Value", "Docs": "", "Typewords": ["string"] }] },
 		"Policy": { "Name": "Policy", "Docs": "", "Fields": [{ "Name": "Version", "Docs": "", "Typewords": ["string"] }, { "Name": "Mode", "Docs": "", "Typewords": ["Mode"] }, { "Name": "MX", "Docs": "", "Typewords": ["[]", "STSMX"] }, { "Name": "MaxAgeSeconds", "Docs": "", "Typewords": ["int32"] }, { "Name": "Extensions", "Docs": "", "Typewords": ["[]", "Pair"] }] },
 		"STSMX": { "Name": "STSMX", "Docs": "", "Fields": [{ "Name": "Wildcard", "Docs": "", "Typewords": ["bool"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }] },
+		"BIMICheckResult": { "Name": "BIMICheckResult", "Docs": "", "Fields": [{ "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "TXT", "Docs": "", "Typewords": ["string"] }, { "Name": "Record", "Docs": "", "Typewords": ["nullable", "BIMIRecord"] }, { "Name": "Errors", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Warnings", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Instructions", "Docs": "", "Typewords": ["[]", "string"] }] },
+		"BIMIRecord": { "Name": "BIMIRecord", "Docs": "", "Fields": [{ "Name": "Version", "Docs": "", "Typewords": ["string"] }, { "Name": "Location", "Docs": "", "Typewords": ["string"] }, { "Name": "Authority", "Docs": "", "Typewords": ["string"] }] },
 		"SRVConfCheckResult": { "Name": "SRVConfCheckResult", "Docs": "", "Fields": [{ "Name": "SRVs", "Docs": "", "Typewords": ["{}", "[]", "SRV"] }, { "Name": "Errors", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Warnings", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Instructions", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"SRV": { "Name": "SRV", "Docs": "", "Fields": [{ "Name": "Target", "Docs": "", "Typewords": ["string"] }, { "Name": "Port", "Docs": "", "Typewords": ["uint16"] }, { "Name": "Priority", "Docs": "", "Typewords": ["uint16"] }, { "Name": "Weight", "Docs": "", "Typewords": ["uint16"] }] },
 		"AutoconfCheckResult": { "Name": "AutoconfCheckResult", "Docs": "", "Fields": [{ "Name": "ClientSettingsDomainIPs", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "IPs", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Errors", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Warnings", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Instructions", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"AutodiscoverCheckResult": { "Name": "AutodiscoverCheckResult", "Docs": "", "Fields": [{ "Name": "Records", "Docs": "", "Typewords": ["[]", "AutodiscoverSRV"] }, { "Name": "Errors", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Warnings", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Instructions", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"AutodiscoverSRV": { "Name": "AutodiscoverSRV", "Docs": "", "Fields": [{ "Name": "Target", "Docs": "", "Typewords": ["string"] }, { "Name": "Port", "Docs": "", "Typewords": ["uint16"] }, { "Name": "Priority", "Docs": "", "Typewords": ["uint16"] }, { "Name": "Weight", "Docs": "", "Typewords": ["uint16"] }, { "Name": "IPs", "Docs": "", "Typewords": ["[]", "string"] }] },
-		"ConfigDomain": { "Name": "ConfigDomain", "Docs": "", "Fields": [{ "Name": "Disabled", "Docs": "", "Typewords": ["bool"] }, { "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "ClientSettingsDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "LocalpartCatchallSeparator", "Docs": "", "Typewords": ["string"] }, { "Name": "LocalpartCatchallSeparators", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "LocalpartCaseSensitive", "Docs": "", "Typewords": ["bool"] }, { "Name": "DKIM", "Docs": "", "Typewords": ["DKIM"] }, { "Name": "DMARC", "Docs": "", "Typewords": ["nullable", "DMARC"] }, { "Name": "MTASTS", "Docs": "", "Typewords": ["nullable", "MTASTS"] }, { "Name": "TLSRPT", "Docs": "", "Typewords": ["nullable", "TLSRPT"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "Aliases", "Docs": "", "Typewords": ["{}", "Alias"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "LocalpartCatchallSeparatorsEffective", "Docs": "", "Typewords": ["[]", "string"] }] },
-		"DKIM": { "Name": "DKIM", "Docs": "", "Fields": [{ "Name": "Selectors", "Docs": "", "Typewords": ["{}", "Selector"] }, { "Name": "Sign", "Docs": "", "Typewords": ["[]", "string"] }] },
+		"ConfigDomain": { "Name": "ConfigDomain", "Docs": "", "Fields": [{ "Name": "Disabled", "Docs": "", "Typewords": ["bool"] }, { "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "ClientSettingsDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "ACME", "Docs": "", "Typewords": ["string"] }, { "Name": "AliasOf", "Docs": "", "Typewords": ["string"] }, { "Name": "LocalpartCatchallSeparator", "Docs": "", "Typewords": ["string"] }, { "Name": "LocalpartCatchallSeparators", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "LocalpartCaseSensitive", "Docs": "", "Typewords": ["bool"] }, { "Name": "DKIM", "Docs": "", "Typewords": ["DKIM"] }, { "Name": "DMARC", "Docs": "", "Typewords": ["nullable", "DMARC"] }, { "Name": "MTASTS", "Docs": "", "Typewords": ["nullable", "MTASTS"] }, { "Name": "TLSRPT", "Docs": "", "Typewords": ["nullable", "TLSRPT"] }, { "Name": "BIMI", "Docs": "", "Typewords": ["nullable", "BIMI"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "Aliases", "Docs": "", "Typewords": ["{}", "Alias"] }, { "Name": "Lists", "Docs": "", "Typewords": ["{}", "List"] }, { "Name": "DestinationPatterns", "Docs": "", "Typewords": ["[]", "DestinationPattern"] }, { "Name": "Tenant", "Docs": "", "Typewords": ["string"] }, { "Name": "VirusScan", "Docs": "", "Typewords": ["string"] }, { "Name": "Subdomains", "Docs": "", "Typewords": ["nullable", "Subdomains"] }, { "Name": "RejectedRetention", "Docs": "", "Typewords": ["nullable", "RejectedRetention"] }, { "Name": "RejectText", "Docs": "", "Typewords": ["nullable", "RejectText"] }, { "Name": "Retire", "Docs": "", "Typewords": ["nullable", "DomainRetire"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "LocalpartCatchallSeparatorsEffective", "Docs": "", "Typewords": ["[]", "string"] }] },
+		"DKIM": { "Name": "DKIM", "Docs": "", "Fields": [{ "Name": "Selectors", "Docs": "", "Typewords": ["{}", "Selector"] }, { "Name": "Sign", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "SignMailFrom", "Docs": "", "Typewords": ["bool"] }, { "Name": "SignHostname", "Docs": "", "Typewords": ["bool"] }] },
 		"Selector": { "Name": "Selector", "Docs": "", "Fields": [{ "Name": "Hash", "Docs": "", "Typewords": ["string"] }, { "Name": "HashEffective", "Docs": "", "Typewords": ["string"] }, { "Name": "Canonicalization", "Docs": "", "Typewords": ["Canonicalization"] }, { "Name": "Headers", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "HeadersEffective", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "DontSealHeaders", "Docs": "", "Typewords": ["bool"] }, { "Name": "Expiration", "Docs": "", "Typewords": ["string"] }, { "Name": "PrivateKeyFile", "Docs": "", "Typewords": ["string"] }, { "Name": "Algorithm", "Docs": "", "Typewords": ["string"] }] },
 		"Canonicalization": { "Name": "Canonicalization", "Docs": "", "Fields": [{ "Name": "HeaderRelaxed", "Docs": "", "Typewords": ["bool"] }, { "Name": "BodyRelaxed", "Docs": "", "Typewords": ["bool"] }] },
 		"DMARC": { "Name": "DMARC", "Docs": "", "Fields": [{ "Name": "Localpart", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "ParsedLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }] },
 		"MTASTS": { "Name": "MTASTS", "Docs": "", "Fields": [{ "Name": "PolicyID", "Docs": "", "Typewords": ["string"] }, { "Name": "Mode", "Docs": "", "Typewords": ["Mode"] }, { "Name": "MaxAge", "Docs": "", "Typewords": ["int64"] }, { "Name": "MX", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"TLSRPT": { "Name": "TLSRPT", "Docs": "", "Fields": [{ "Name": "Localpart", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "ParsedLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }] },
+		"BIMI": { "Name": "BIMI", "Docs": "", "Fields": [{ "Name": "Selector", "Docs": "", "Typewords": ["string"] }, { "Name": "Location", "Docs": "", "Typewords": ["string"] }, { "Name": "Authority", "Docs": "", "Typewords": ["string"] }, { "Name": "SelectorHeader", "Docs": "", "Typewords": ["bool"] }] },
 		"Route": { "Name": "Route", "Docs": "", "Fields": [{ "Name": "FromDomain", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "ToDomain", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "MinimumAttempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "Transport", "Docs": "", "Typewords": ["string"] }, { "Name": "FromDomainASCII", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "ToDomainASCII", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"Alias": { "Name": "Alias", "Docs": "", "Fields": [{ "Name": "Addresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "PostPublic", "Docs": "", "Typewords": ["bool"] }, { "Name": "ListMembers", "Docs": "", "Typewords": ["bool"] }, { "Name": "AllowMsgFrom", "Docs": "", "Typewords": ["bool"] }, { "Name": "LocalpartStr", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "ParsedAddresses", "Docs": "", "Typewords": ["[]", "AliasAddress"] }] },
 		"AliasAddress": { "Name": "AliasAddress", "Docs": "", "Fields": [{ "Name": "Address", "Docs": "", "Typewords": ["Address"] }, { "Name": "AccountName", "Docs": "", "Typewords": ["string"] }, { "Name": "Destination", "Docs": "", "Typewords": ["Destination"] }] },
 		"Address": { "Name": "Address", "Docs": "", "Fields": [{ "Name": "Localpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }] },
-		"Destination": { "Name": "Destination", "Docs": "", "Fields": [{ "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Rulesets", "Docs": "", "Typewords": ["[]", "Ruleset"] }, { "Name": "SMTPError", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageAuthRequiredSMTPError", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }] },
-		"Ruleset": { "Name": "Ruleset", "Docs": "", "Fields": [{ "Name": "SMTPMailFromRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgFromRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "VerifiedDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "HeadersRegexp", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "IsForward", "Docs": "", "Typewords": ["bool"] }, { "Name": "ListAllowDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "AcceptRejectsToMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Comment", "Docs": "", "Typewords": ["string"] }, { "Name": "VerifiedDNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "ListAllowDNSDomain", "Docs": "", "Typewords": ["Domain"] }] },
-		"Account": { "Name": "Account", "Docs": "", "Fields": [{ "Name": "OutgoingWebhook", "Docs": "", "Typewords": ["nullable", "OutgoingWebhook"] }, { "Name": "IncomingWebhook", "Docs": "", "Typewords": ["nullable", "IncomingWebhook"] }, { "Name": "FromIDLoginAddresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "KeepRetiredMessagePeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "KeepRetiredWebhookPeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "LoginDisabled", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }, { "Name": "Destinations", "Docs": "", "Typewords": ["{}", "Destination"] }, { "Name": "SubjectPass", "Docs": "", "Typewords": ["SubjectPass"] }, { "Name": "QuotaMessageSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "RejectsMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "KeepRejects", "Docs": "", "Typewords": ["bool"] }, { "Name": "AutomaticJunkFlags", "Docs": "", "Typewords": ["AutomaticJunkFlags"] }, { "Name": "JunkFilter", "Docs": "", "Typewords": ["nullable", "JunkFilter"] }, { "Name": "MaxOutgoingMessagesPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxFirstTimeRecipientsPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "NoFirstTimeSenderDelay", "Docs": "", "Typewords": ["bool"] }, { "Name": "NoCustomPassword", "Docs": "", "Typewords": ["bool"] }, { "Name": "IMAPCapabilitiesDisabled", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "Aliases", "Docs": "", "Typewords": ["[]", "AddressAlias"] }] },
+		"Destination": { "Name": "Destination", "Docs": "", "Fields": [{ "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Rulesets", "Docs": "", "Typewords": ["[]", "Ruleset"] }, { "Name": "SMTPError", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageAuthRequiredSMTPError", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }, { "Name": "ForwardTo", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "ForwardKeepCopy", "Docs": "", "Typewords": ["bool"] }] },
+		"Ruleset": { "Name": "Ruleset", "Docs": "", "Fields": [{ "Name": "SMTPMailFromRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgFromRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "VerifiedDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "HeadersRegexp", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "MsgFromPatterns", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "SPFResult", "Docs": "", "Typewords": ["string"] }, { "Name": "DKIMResult", "Docs": "", "Typewords": ["string"] }, { "Name": "DMARCResult", "Docs": "", "Typewords": ["string"] }, { "Name": "MinSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "MaxSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "Attachments", "Docs": "", "Typewords": ["string"] }, { "Name": "ListID", "Docs": "", "Typewords": ["string"] }, { "Name": "IsForward", "Docs": "", "Typewords": ["bool"] }, { "Name": "ListAllowDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "AcceptRejectsToMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "MarkSeen", "Docs": "", "Typewords": ["bool"] }, { "Name": "ForwardTo", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Discard", "Docs": "", "Typewords": ["bool"] }, { "Name": "Comment", "Docs": "", "Typewords": ["string"] }, { "Name": "VerifiedDNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "ListAllowDNSDomain", "Docs": "", "Typewords": ["Domain"] }] },
+		"List": { "Name": "List", "Docs": "", "Fields": [{ "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "SubjectPrefix", "Docs": "", "Typewords": ["string"] }, { "Name": "PostPublic", "Docs": "", "Typewords": ["bool"] }, { "Name": "Moderated", "Docs": "", "Typewords": ["bool"] }, { "Name": "LocalpartStr", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }] },
+		"DestinationPattern": { "Name": "DestinationPattern", "Docs": "", "Fields": [{ "Name": "Localpart", "Docs": "", "Typewords": ["string"] }, { "Name": "LocalpartRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }] },
+		"Subdomains": { "Name": "Subdomains", "Docs": "", "Fields": [{ "Name": "Routes", "Docs": "", "Typewords": ["[]", "SubdomainRoute"] }, { "Name": "DMARCPolicy", "Docs": "", "Typewords": ["string"] }] },
+		"SubdomainRoute": { "Name": "SubdomainRoute", "Docs": "", "Fields": [{ "Name": "Subdomain", "Docs": "", "Typewords": ["string"] }, { "Name": "Deliver", "Docs": "", "Typewords": ["string"] }] },
+		"RejectedRetention": { "Name": "RejectedRetention", "Docs": "", "Fields": [{ "Name": "MaxAge", "Docs": "", "Typewords": ["int64"] }, { "Name": "MaxSize", "Docs": "", "Typewords": ["int64"] }] },
+		"RejectText": { "Name": "RejectText", "Docs": "", "Fields": [{ "Name": "ContactURL", "Docs": "", "Typewords": ["string"] }, { "Name": "Default", "Docs": "", "Typewords": ["string"] }, { "Name": "Reasons", "Docs": "", "Typewords": ["{}", "string"] }] },
+		"DomainRetire": { "Name": "DomainRetire", "Docs": "", "Fields": [{ "Name": "NewDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "Message", "Docs": "", "Typewords": ["string"] }, { "Name": "RejectAfter", "Docs": "", "Typewords": ["string"] }, { "Name": "LoginUntil", "Docs": "", "Typewords": ["string"] }] },
+		"Account": { "Name": "Account", "Docs": "", "Fields": [{ "Name": "OutgoingWebhook", "Docs": "", "Typewords": ["nullable", "OutgoingWebhook"] }, { "Name": "IncomingWebhook", "Docs": "", "Typewords": ["nullable", "IncomingWebhook"] }, { "Name": "FromIDLoginAddresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "KeepRetiredMessagePeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "KeepRetiredWebhookPeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "DeliveryPriority", "Docs": "", "Typewords": ["int32"] }, { "Name": "AttachmentLinks", "Docs": "", "Typewords": ["nullable", "AttachmentLinks"] }, { "Name": "WeeklyDigest", "Docs": "", "Typewords": ["bool"] }, { "Name": "SubaddressMailboxes", "Docs": "", "Typewords": ["bool"] }, { "Name": "Notifications", "Docs": "", "Typewords": ["[]", "Notification"] }, { "Name": "LoginDisabled", "Docs": "", "Typewords": ["string"] }, { "Name": "ReadOnly", "Docs": "", "Typewords": ["bool"] }, { "Name": "SubmissionDisabled", "Docs": "", "Typewords": ["string"] }, { "Name": "IMAPReferralHost", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }, { "Name": "Destinations", "Docs": "", "Typewords": ["{}", "Destination"] }, { "Name": "SubjectPass", "Docs": "", "Typewords": ["SubjectPass"] }, { "Name": "QuotaMessageSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "RejectsMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "KeepRejects", "Docs": "", "Typewords": ["bool"] }, { "Name": "RejectsRescueAllow", "Docs": "", "Typewords": ["string"] }, { "Name": "MailboxRetention", "Docs": "", "Typewords": ["[]", "MailboxRetention"] }, { "Name": "AutoArchive", "Docs": "", "Typewords": ["nullable", "AutoArchive"] }, { "Name": "AutomaticJunkFlags", "Docs": "", "Typewords": ["AutomaticJunkFlags"] }, { "Name": "JunkFilter", "Docs": "", "Typewords": ["nullable", "JunkFilter"] }, { "Name": "MaxOutgoingMessagesPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxFirstTimeRecipientsPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "HoldOverSendLimits", "Docs": "", "Typewords": ["bool"] }, { "Name": "NoFirstTimeSenderDelay", "Docs": "", "Typewords": ["bool"] }, { "Name": "NoAutoCreateMailboxes", "Docs": "", "Typewords": ["bool"] }, { "Name": "NoCustomPassword", "Docs": "", "Typewords": ["bool"] }, { "Name": "IMAPCapabilitiesDisabled", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "Aliases", "Docs": "", "Typewords": ["[]", "AddressAlias"] }] },
 		"OutgoingWebhook": { "Name": "OutgoingWebhook", "Docs": "", "Fields": [{ "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "Authorization", "Docs": "", "Typewords": ["string"] }, { "Name": "Events", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"IncomingWebhook": { "Name": "IncomingWebhook", "Docs": "", "Fields": [{ "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "Authorization", "Docs": "", "Typewords": ["string"] }] },
+		"AttachmentLinks": { "Name": "AttachmentLinks", "Docs": "", "Fields": [{ "Name": "MinSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "ValidityDays", "Docs": "", "Typewords": ["int32"] }] },
+		"Notification": { "Name": "Notification", "Docs": "", "Fields": [{ "Name": "Type", "Docs": "", "Typewords": ["string"] }, { "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "Token", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailboxes", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "FromRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "SubjectRegexp", "Docs": "", "Typewords": ["string"] }] },
 		"SubjectPass": { "Name": "SubjectPass", "Docs": "", "Fields": [{ "Name": "Period", "Docs": "", "Typewords": ["int64"] }] },
+		"MailboxRetention": { "Name": "MailboxRetention", "Docs": "", "Fields": [{ "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Period", "Docs": "", "Typewords": ["int64"] }] },
+		"AutoArchive": { "Name": "AutoArchive", "Docs": "", "Fields": [{ "Name": "Period", "Docs": "", "Typewords": ["int64"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }] },
 		"AutomaticJunkFlags": { "Name": "AutomaticJunkFlags", "Docs": "", "Fields": [{ "Name": "Enabled", "Docs": "", "Typewords": ["bool"] }, { "Name": "JunkMailboxRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "NeutralMailboxRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "NotJunkMailboxRegexp", "Docs": "", "Typewords": ["string"] }] },
 		"JunkFilter": { "Name": "JunkFilter", "Docs": "", "Fields": [{ "Name": "Threshold", "Docs": "", "Typewords": ["float64"] }, { "Name": "Onegrams", "Docs": "", "Typewords": ["bool"] }, { "Name": "Twograms", "Docs": "", "Typewords": ["bool"] }, { "Name": "Threegrams", "Docs": "", "Typewords": ["bool"] }, { "Name": "MaxPower", "Docs": "", "Typewords": ["float64"] }, { "Name": "TopWords", "Docs": "", "Typewords": ["int32"] }, { "Name": "IgnoreWords", "Docs": "", "Typewords": ["float64"] }, { "Name": "RareWords", "Docs": "", "Typewords": ["int32"] }] },
 		"AddressAlias": { "Name": "AddressAlias", "Docs": "", "Fields": [{ "Name": "SubscriptionAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Alias", "Docs": "", "Typewords": ["Alias"] }, { "Name": "MemberAddresses", "Docs": "", "Typewords": ["[]", "string"] }] },
@@ -346,12 +367,13 @@ var api;
 		"HoldRule": { "Name": "HoldRule", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "SenderDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "RecipientDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "SenderDomainStr", "Docs": "", "Typewords": ["string"] }, { "Name": "RecipientDomainStr", "Docs": "", "Typewords": ["string"] }] },
 		"Filter": { "Name": "Filter", "Docs": "", "Fields": [{ "Name": "Max", "Docs": "", "Typewords": ["int32"] }, { "Name": "IDs", "Docs": "", "Typewords": ["[]", "int64"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "From", "Docs": "", "Typewords": ["string"] }, { "Name": "To", "Docs": "", "Typewords": ["string"] }, { "Name": "Hold", "Docs": "", "Typewords": ["nullable", "bool"] }, { "Name": "Submitted", "Docs": "", "Typewords": ["string"] }, { "Name": "NextAttempt", "Docs": "", "Typewords": ["string"] }, { "Name": "Transport", "Docs": "", "Typewords": ["nullable", "string"] }] },
 		"Sort": { "Name": "Sort", "Docs": "", "Fields": [{ "Name": "Field", "Docs": "", "Typewords": ["string"] }, { "Name": "LastID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Last", "Docs": "", "Typewords": ["any"] }, { "Name": "Asc", "Docs": "", "Typewords": ["bool"] }] },
-		"Msg": { "Name": "Msg", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "BaseID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Queued", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Hold", "Docs": "", "Typewords": ["bool"] }, { "Name": "SenderAccount", "Docs": "", "Typewords": ["string"] }, { "Name": "SenderLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "SenderDomain", "Docs": "", "Typewords": ["IPDomain"] }, { "Name": "SenderDomainStr", "Docs": "", "Typewords": ["string"] }, { "Name": "FromID", "Docs": "", "Typewords": ["string"] }, { "Name": "RecipientLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "RecipientDomain", "Docs": "", "Typewords": ["IPDomain"] }, { "Name": "RecipientDomainStr", "Docs": "", "Typewords": ["string"] }, { "Name": "Attempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxAttempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "DialedIPs", "Docs": "", "Typewords": ["{}", "[]", "IP"] }, { "Name": "NextAttempt", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastAttempt", "Docs": "", "Typewords": ["nullable", "timestamp"] }, { "Name": "Results", "Docs": "", "Typewords": ["[]", "MsgResult"] }, { "Name": "Has8bit", "Docs": "", "Typewords": ["bool"] }, { "Name": "SMTPUTF8", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsDMARCReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsTLSReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgPrefix", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "DSNUTF8", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Transport", "Docs": "", "Typewords": ["string"] }, { "Name": "RequireTLS", "Docs": "", "Typewords": ["nullable", "bool"] }, { "Name": "FutureReleaseRequest", "Docs": "", "Typewords": ["string"] }, { "Name": "Extra", "Docs": "", "Typewords": ["{}", "string"] }] },
+		"Msg": { "Name": "Msg", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "BaseID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Queued", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Hold", "Docs": "", "Typewords": ["bool"] }, { "Name": "HoldReason", "Docs": "", "Typewords": ["string"] }, { "Name": "SenderAccount", "Docs": "", "Typewords": ["string"] }, { "Name": "SenderLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "SenderDomain", "Docs": "", "Typewords": ["IPDomain"] }, { "Name": "SenderDomainStr", "Docs": "", "Typewords": ["string"] }, { "Name": "FromID", "Docs": "", "Typewords": ["string"] }, { "Name": "RecipientLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "RecipientDomain", "Docs": "", "Typewords": ["IPDomain"] }, { "Name": "RecipientDomainStr", "Docs": "", "Typewords": ["string"] }, { "Name": "Attempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxAttempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "DialedIPs", "Docs": "", "Typewords": ["{}", "[]", "IP"] }, { "Name": "NextAttempt", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastAttempt", "Docs": "", "Typewords": ["nullable", "timestamp"] }, { "Name": "Results", "Docs": "", "Typewords": ["[]", "MsgResult"] }, { "Name": "Priority", "Docs": "", "Typewords": ["int32"] }, { "Name": "Has8bit", "Docs": "", "Typewords": ["bool"] }, { "Name": "SMTPUTF8", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsDMARCReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsTLSReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsForward", "Docs": "", "Typewords": ["bool"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgPrefix", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "DSNUTF8", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Transport", "Docs": "", "Typewords": ["string"] }, { "Name": "RequireTLS", "Docs": "", "Typewords": ["nullable", "bool"] }, { "Name": "RequireVerifiedTLS", "Docs": "", "Typewords": ["bool"] }, { "Name": "FutureReleaseRequest", "Docs": "", "Typewords": ["string"] }, { "Name": "DSNNotify", "Docs": "", "Typewords": ["string"] }, { "Name": "DSNRet", "Docs": "", "Typewords": ["string"] }, { "Name": "DSNEnvID", "Docs": "", "Typewords": ["string"] }, { "Name": "DSNOrigRecipient", "Docs": "", "Typewords": ["string"] }, { "Name": "Extra", "Docs": "", "Typewords": ["{}", "string"] }] },
 		"IPDomain": { "Name": "IPDomain", "Docs": "", "Fields": [{ "Name": "IP", "Docs": "", "Typewords": ["IP"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }] },
-		"MsgResult": { "Name": "MsgResult", "Docs": "", "Fields": [{ "Name": "Start", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Duration", "Docs": "", "Typewords": ["int64"] }, { "Name": "Success", "Docs": "", "Typewords": ["bool"] }, { "Name": "Code", "Docs": "", "Typewords": ["int32"] }, { "Name": "Secode", "Docs": "", "Typewords": ["string"] }, { "Name": "Error", "Docs": "", "Typewords": ["string"] }] },
+		"MsgResult": { "Name": "MsgResult", "Docs": "", "Fields": [{ "Name": "Start", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Duration", "Docs": "", "Typewords": ["int64"] }, { "Name": "Success", "Docs": "", "Typewords": ["bool"] }, { "Name": "Code", "Docs": "", "Typewords": ["int32"] }, { "Name": "Secode", "Docs": "", "Typewords": ["string"] }, { "Name": "Error", "Docs": "", "Typewords": ["string"] }, { "Name": "Hosts", "Docs": "", "Typewords": ["[]", "MsgResultHost"] }] },
+		"MsgResultHost": { "Name": "MsgResultHost", "Docs": "", "Fields": [{ "Name": "Host", "Docs": "", "Typewords": ["string"] }, { "Name": "IP", "Docs": "", "Typewords": ["string"] }, { "Name": "Start", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Duration", "Docs": "", "Typewords": ["int64"] }, { "Name": "Success", "Docs": "", "Typewords": ["bool"] }, { "Name": "Code", "Docs": "", "Typewords": ["int32"] }, { "Name": "Secode", "Docs": "", "Typewords": ["string"] }, { "Name": "Error", "Docs": "", "Typewords": ["string"] }, { "Name": "TLS", "Docs": "", "Typewords": ["bool"] }, { "Name": "TLSMode", "Docs": "", "Typewords": ["string"] }, { "Name": "TLSFailures", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"RetiredFilter": { "Name": "RetiredFilter", "Docs": "", "Fields": [{ "Name": "Max", "Docs": "", "Typewords": ["int32"] }, { "Name": "IDs", "Docs": "", "Typewords": ["[]", "int64"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "From", "Docs": "", "Typewords": ["string"] }, { "Name": "To", "Docs": "", "Typewords": ["string"] }, { "Name": "Submitted", "Docs": "", "Typewords": ["string"] }, { "Name": "LastActivity", "Docs": "", "Typewords": ["string"] }, { "Name": "Transport", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Success", "Docs": "", "Typewords": ["nullable", "bool"] }] },
 		"RetiredSort": { "Name": "RetiredSort", "Docs": "", "Fields": [{ "Name": "Field", "Docs": "", "Typewords": ["string"] }, { "Name": "LastID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Last", "Docs": "", "Typewords": ["any"] }, { "Name": "Asc", "Docs": "", "Typewords": ["bool"] }] },
-		"MsgRetired": { "Name": "MsgRetired", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "BaseID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Queued", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "SenderAccount", "Docs": "", "Typewords": ["string"] }, { "Name": "SenderLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "SenderDomainStr", "Docs": "", "Typewords": ["string"] }, { "Name": "FromID", "Docs": "", "Typewords": ["string"] }, { "Name": "RecipientLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "RecipientDomain", "Docs": "", "Typewords": ["IPDomain"] }, { "Name": "RecipientDomainStr", "Docs": "", "Typewords": ["string"] }, { "Name": "Attempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxAttempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "DialedIPs", "Docs": "", "Typewords": ["{}", "[]", "IP"] }, { "Name": "LastAttempt", "Docs": "", "Typewords": ["nullable", "timestamp"] }, { "Name": "Results", "Docs": "", "Typewords": ["[]", "MsgResult"] }, { "Name": "Has8bit", "Docs": "", "Typewords": ["bool"] }, { "Name": "SMTPUTF8", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsDMARCReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsTLSReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "Transport", "Docs": "", "Typewords": ["string"] }, { "Name": "RequireTLS", "Docs": "", "Typewords": ["nullable", "bool"] }, { "Name": "FutureReleaseRequest", "Docs": "", "Typewords": ["string"] }, { "Name": "Extra", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "LastActivity", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "RecipientAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Success", "Docs": "", "Typewords": ["bool"] }, { "Name": "KeepUntil", "Docs": "", "Typewords": ["timestamp"] }] },
+		"MsgRetired": { "Name": "MsgRetired", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "BaseID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Queued", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "SenderAccount", "Docs": "", "Typewords": ["string"] }, { "Name": "SenderLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "SenderDomainStr", "Docs": "", "Typewords": ["string"] }, { "Name": "FromID", "Docs": "", "Typewords": ["string"] }, { "Name": "RecipientLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "RecipientDomain", "Docs": "", "Typewords": ["IPDomain"] }, { "Name": "RecipientDomainStr", "Docs": "", "Typewords": ["string"] }, { "Name": "Attempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxAttempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "DialedIPs", "Docs": "", "Typewords": ["{}", "[]", "IP"] }, { "Name": "LastAttempt", "Docs": "", "Typewords": ["nullable", "timestamp"] }, { "Name": "Results", "Docs": "", "Typewords": ["[]", "MsgResult"] }, { "Name": "Has8bit", "Docs": "", "Typewords": ["bool"] }, { "Name": "SMTPUTF8", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsDMARCReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsTLSReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsForward", "Docs": "", "Typewords": ["bool"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "Transport", "Docs": "", "Typewords": ["string"] }, { "Name": "RequireTLS", "Docs": "", "Typewords": ["nullable", "bool"] }, { "Name": "RequireVerifiedTLS", "Docs": "", "Typewords": ["bool"] }, { "Name": "FutureReleaseRequest", "Docs": "", "Typewords": ["string"] }, { "Name": "Extra", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "LastActivity", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "RecipientAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Success", "Docs": "", "Typewords": ["bool"] }, { "Name": "KeepUntil", "Docs": "", "Typewords": ["timestamp"] }] },
 		"HookFilter": { "Name": "HookFilter", "Docs": "", "Fields": [{ "Name": "Max", "Docs": "", "Typewords": ["int32"] }, { "Name": "IDs", "Docs": "", "Typewords": ["[]", "int64"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Submitted", "Docs": "", "Typewords": ["string"] }, { "Name": "NextAttempt", "Docs": "", "Typewords": ["string"] }, { "Name": "Event", "Docs": "", "Typewords": ["string"] }] },
 		"HookSort": { "Name": "HookSort", "Docs": "", "Fields": [{ "Name": "Field", "Docs": "", "Typewords": ["string"] }, { "Name": "LastID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Last", "Docs": "", "Typewords": ["any"] }, { "Name": "Asc", "Docs": "", "Typewords": ["bool"] }] },
 		"Hook": { "Name": "Hook", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "QueueMsgID", "Docs": "", "Typewords": ["int64"] }, { "Name": "FromID", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "Extra", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "Authorization", "Docs": "", "Typewords": ["string"] }, { "Name": "IsIncoming", "Docs": "", "Typewords": ["bool"] }, { "Name": "OutgoingEvent", "Docs": "", "Typewords": ["string"] }, { "Name": "Payload", "Docs": "", "Typewords": ["string"] }, { "Name": "Submitted", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Attempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "NextAttempt", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Results", "Docs": "", "Typewords": ["[]", "HookResult"] }] },
@@ -366,19 +388,25 @@ var api;
 		"WebForward": { "Name": "WebForward", "Docs": "", "Fields": [{ "Name": "StripPath", "Docs": "", "Typewords": ["bool"] }, { "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "ResponseHeaders", "Docs": "", "Typewords": ["{}", "string"] }] },
 		"WebInternal": { "Name": "WebInternal", "Docs": "", "Fields": [{ "Name": "BasePath", "Docs": "", "Typewords": ["string"] }, { "Name": "Service", "Docs": "", "Typewords": ["string"] }] },
 		"Transport": { "Name": "Transport", "Docs": "", "Fields": [{ "Name": "Submissions", "Docs": "", "Typewords": ["nullable", "TransportSMTP"] }, { "Name": "Submission", "Docs": "", "Typewords": ["nullable", "TransportSMTP"] }, { "Name": "SMTP", "Docs": "", "Typewords": ["nullable", "TransportSMTP"] }, { "Name": "Socks", "Docs": "", "Typewords": ["nullable", "TransportSocks"] }, { "Name": "Direct", "Docs": "", "Typewords": ["nullable", "TransportDirect"] }, { "Name": "Fail", "Docs": "", "Typewords": ["nullable", "TransportFail"] }] },
-		"TransportSMTP": { "Name": "TransportSMTP", "Docs": "", "Fields": [{ "Name": "Host", "Docs": "", "Typewords": ["string"] }, { "Name": "Port", "Docs": "", "Typewords": ["int32"] }, { "Name": "STARTTLSInsecureSkipVerify", "Docs": "", "Typewords": ["bool"] }, { "Name": "NoSTARTTLS", "Docs": "", "Typewords": ["bool"] }, { "Name": "Auth", "Docs": "", "Typewords": ["nullable", "SMTPAuth"] }] },
+		"TransportSMTP": { "Name": "TransportSMTP", "Docs": "", "Fields": [{ "Name": "Host", "Docs": "", "Typewords": ["string"] }, { "Name": "Port", "Docs": "", "Typewords": ["int32"] }, { "Name": "STARTTLSInsecureSkipVerify", "Docs": "", "Typewords": ["bool"] }, { "Name": "NoSTARTTLS", "Docs": "", "Typewords": ["bool"] }, { "Name": "Auth", "Docs": "", "Typewords": ["nullable", "SMTPAuth"] }, { "Name": "Weight", "Docs": "", "Typewords": ["int32"] }, { "Name": "Hosts", "Docs": "", "Typewords": ["[]", "TransportSMTPHost"] }] },
 		"SMTPAuth": { "Name": "SMTPAuth", "Docs": "", "Fields": [{ "Name": "Username", "Docs": "", "Typewords": ["string"] }, { "Name": "Password", "Docs": "", "Typewords": ["string"] }, { "Name": "Mechanisms", "Docs": "", "Typewords": ["[]", "string"] }] },
-		"TransportSocks": { "Name": "TransportSocks", "Docs": "", "Fields": [{ "Name": "Address", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIPs", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "RemoteHostname", "Docs": "", "Typewords": ["string"] }] },
-		"TransportDirect": { "Name": "TransportDirect", "Docs": "", "Fields": [{ "Name": "DisableIPv4", "Docs": "", "Typewords": ["bool"] }, { "Name": "DisableIPv6", "Docs": "", "Typewords": ["bool"] }] },
+		"TransportSMTPHost": { "Name": "TransportSMTPHost", "Docs": "", "Fields": [{ "Name": "Host", "Docs": "", "Typewords": ["string"] }, { "Name": "Port", "Docs": "", "Typewords": ["int32"] }, { "Name": "Priority", "Docs": "", "Typewords": ["int32"] }, { "Name": "Weight", "Docs": "", "Typewords": ["int32"] }] },
+		"TransportSocks": { "Name": "TransportSocks", "Docs": "", "Fields": [{ "Name": "Address", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIPs", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "RemoteHostname", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteResolve", "Docs": "", "Typewords": ["bool"] }] },
+		"TransportDirect": { "Name": "TransportDirect", "Docs": "", "Fields": [{ "Name": "DisableIPv4", "Docs": "", "Typewords": ["bool"] }, { "Name": "DisableIPv6", "Docs": "", "Typewords": ["bool"] }, { "Name": "RequireVerifiedTLS", "Docs": "", "Typewords": ["bool"] }] },
 		"TransportFail": { "Name": "TransportFail", "Docs": "", "Fields": [{ "Name": "SMTPCode", "Docs": "", "Typewords": ["int32"] }, { "Name": "SMTPMessage", "Docs": "", "Typewords": ["string"] }, { "Name": "Code", "Docs": "", "Typewords": ["int32"] }, { "Name": "Message", "Docs": "", "Typewords": ["string"] }] },
 		"EvaluationStat": { "Name": "EvaluationStat", "Docs": "", "Fields": [{ "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "Dispositions", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Count", "Docs": "", "Typewords": ["int32"] }, { "Name": "SendReport", "Docs": "", "Typewords": ["bool"] }] },
 		"Evaluation": { "Name": "Evaluation", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "PolicyDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "Evaluated", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Optional", "Docs": "", "Typewords": ["bool"] }, { "Name": "IntervalHours", "Docs": "", "Typewords": ["int32"] }, { "Name": "Addresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "PolicyPublished", "Docs": "", "Typewords": ["PolicyPublished"] }, { "Name": "SourceIP", "Docs": "", "Typewords": ["string"] }, { "Name": "Disposition", "Docs": "", "Typewords": ["string"] }, { "Name": "AlignedDKIMPass", "Docs": "", "Typewords": ["bool"] }, { "Name": "AlignedSPFPass", "Docs": "", "Typewords": ["bool"] }, { "Name": "OverrideReasons", "Docs": "", "Typewords": ["[]", "PolicyOverrideReason"] }, { "Name": "EnvelopeTo", "Docs": "", "Typewords": ["string"] }, { "Name": "EnvelopeFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "HeaderFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "DKIMResults", "Docs": "", "Typewords": ["[]", "DKIMAuthResult"] }, { "Name": "SPFResults", "Docs": "", "Typewords": ["[]", "SPFAuthResult"] }] },
 		"SuppressAddress": { "Name": "SuppressAddress", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Inserted", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "ReportingAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Until", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Comment", "Docs": "", "Typewords": ["string"] }] },
 		"TLSResult": { "Name": "TLSResult", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "PolicyDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "DayUTC", "Docs": "", "Typewords": ["string"] }, { "Name": "RecipientDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Updated", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "IsHost", "Docs": "", "Typewords": ["bool"] }, { "Name": "SendReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "SentToRecipientDomain", "Docs": "", "Typewords": ["bool"] }, { "Name": "RecipientDomainReportingAddresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "SentToPolicyDomain", "Docs": "", "Typewords": ["bool"] }, { "Name": "Results", "Docs": "", "Typewords": ["[]", "Result"] }] },
 		"TLSRPTSuppressAddress": { "Name": "TLSRPTSuppressAddress", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Inserted", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "ReportingAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Until", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Comment", "Docs": "", "Typewords": ["string"] }] },
-		"Dynamic": { "Name": "Dynamic", "Docs": "", "Fields": [{ "Name": "Domains", "Docs": "", "Typewords": ["{}", "ConfigDomain"] }, { "Name": "Accounts", "Docs": "", "Typewords": ["{}", "Account"] }, { "Name": "WebDomainRedirects", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "WebHandlers", "Docs": "", "Typewords": ["[]", "WebHandler"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "MonitorDNSBLs", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "MonitorDNSBLZones", "Docs": "", "Typewords": ["[]", "Domain"] }] },
+		"Dynamic": { "Name": "Dynamic", "Docs": "", "Fields": [{ "Name": "Domains", "Docs": "", "Typewords": ["{}", "ConfigDomain"] }, { "Name": "Accounts", "Docs": "", "Typewords": ["{}", "Account"] }, { "Name": "Tenants", "Docs": "", "Typewords": ["{}", "Tenant"] }, { "Name": "WebDomainRedirects", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "WebHandlers", "Docs": "", "Typewords": ["[]", "WebHandler"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "MonitorDNSBLs", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Canaries", "Docs": "", "Typewords": ["{}", "Canary"] }, { "Name": "MonitorDNSBLZones", "Docs": "", "Typewords": ["[]", "Domain"] }] },
+		"Tenant": { "Name": "Tenant", "Docs": "", "Fields": [{ "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "MaxOutgoingMessagesPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxFirstTimeRecipientsPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "OutgoingIPs", "Docs": "", "Typewords": ["[]", "string"] }] },
+		"Canary": { "Name": "Canary", "Docs": "", "Fields": [{ "Name": "From", "Docs": "", "Typewords": ["string"] }, { "Name": "To", "Docs": "", "Typewords": ["string"] }, { "Name": "Interval", "Docs": "", "Typewords": ["int64"] }, { "Name": "Deadline", "Docs": "", "Typewords": ["int64"] }] },
 		"TLSPublicKey": { "Name": "TLSPublicKey", "Docs": "", "Fields": [{ "Name": "Fingerprint", "Docs": "", "Typewords": ["string"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Type", "Docs": "", "Typewords": ["string"] }, { "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "NoIMAPPreauth", "Docs": "", "Typewords": ["bool"] }, { "Name": "CertDER", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "LoginAddress", "Docs": "", "Typewords": ["string"] }] },
 		"LoginAttempt": { "Name": "LoginAttempt", "Docs": "", "Fields": [{ "Name": "Key", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Last", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "First", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Count", "Docs": "", "Typewords": ["int64"] }, { "Name": "AccountName", "Docs": "", "Typewords": ["string"] }, { "Name": "LoginAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "LocalIP", "Docs": "", "Typewords": ["string"] }, { "Name": "TLS", "Docs": "", "Typewords": ["string"] }, { "Name": "TLSPubKeyFingerprint", "Docs": "", "Typewords": ["string"] }, { "Name": "Protocol", "Docs": "", "Typewords": ["string"] }, { "Name": "UserAgent", "Docs": "", "Typewords": ["string"] }, { "Name": "AuthMech", "Docs": "", "Typewords": ["string"] }, { "Name": "Result", "Docs": "", "Typewords": ["AuthResult"] }] },
+		"AuthLockout": { "Name": "AuthLockout", "Docs": "", "Fields": [{ "Name": "Key", "Docs": "", "Typewords": ["string"] }, { "Name": "IP", "Docs": "", "Typewords": ["string"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Failures", "Docs": "", "Typewords": ["int32"] }, { "Name": "WindowStart", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Last", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Until", "Docs": "", "Typewords": ["timestamp"] }] },
+		"TLSCertificate": { "Name": "TLSCertificate", "Docs": "", "Fields": [{ "Name": "Listeners", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "ACME", "Docs": "", "Typewords": ["string"] }, { "Name": "Hostname", "Docs": "", "Typewords": ["string"] }, { "Name": "KeyType", "Docs": "", "Typewords": ["string"] }, { "Name": "Listener", "Docs": "", "Typewords": ["string"] }, { "Name": "Index", "Docs": "", "Typewords": ["int32"] }, { "Name": "CertFile", "Docs": "", "Typewords": ["string"] }, { "Name": "KeyFile", "Docs": "", "Typewords": ["string"] }, { "Name": "Present", "Docs": "", "Typewords": ["bool"] }, { "Name": "DNSNames", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Issuer", "Docs": "", "Typewords": ["string"] }, { "Name": "NotBefore", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "NotAfter", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "RenewAfter", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Renewing", "Docs": "", "Typewords": ["bool"] }, { "Name": "LastError", "Docs": "", "Typewords": ["string"] }, { "Name": "LastErrorTime", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "NextAttempt", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Error", "Docs": "", "Typewords": ["string"] }] },
+		"Message": { "Name": "Message", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Received", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "EHLO", "Docs": "", "Typewords": ["string"] }, { "Name": "MailFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "RcptTo", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "MsgFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "Code", "Docs": "", "Typewords": ["int32"] }, { "Name": "Secode", "Docs": "", "Typewords": ["string"] }, { "Name": "Errmsg", "Docs": "", "Typewords": ["string"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }, { "Name": "Delivered", "Docs": "", "Typewords": ["timestamp"] }] },
 		"CSRFToken": { "Name": "CSRFToken", "Docs": "", "Values": null },
 		"DMARCPolicy": { "Name": "DMARCPolicy", "Docs": "", "Values": [{ "Name": "PolicyEmpty", "Value": "", "Docs": "" }, { "Name": "PolicyNone", "Value": "none", "Docs": "" }, { "Name": "PolicyQuarantine", "Value": "quarantine", "Docs": "" }, { "Name": "PolicyReject", "Value": "reject", "Docs": "" }] },
 		"Align": { "Name": "Align", "Docs": "", "Values": [{ "Name": "AlignStrict", "Value": "s", "Docs": "" }, { "Name": "AlignRelaxed", "Value": "r", "Docs": "" }] },
@@ -386,9 +414,13 @@ var api;
 		"Mode": { "Name": "Mode", "Docs": "", "Values": [{ "Name": "ModeEnforce", "Value": "enforce", "Docs": "" }, { "Name": "ModeTesting", "Value": "testing", "Docs": "" }, { "Name": "ModeNone", "Value": "none", "Docs": "" }] },
 		"Localpart": { "Name": "Localpart", "Docs": "", "Values": null },
 		"IP": { "Name": "IP", "Docs": "", "Values": [] },
-		"AuthResult": { "Name": "AuthResult", "Docs": "", "Values": [{ "Name": "AuthSuccess", "Value": "ok", "Docs": "" }, { "Name": "AuthBadUser", "Value": "baduser", "Docs": "" }, { "Name": "AuthBadPassword", "Value": "badpassword", "Docs": "" }, { "Name": "AuthBadCredentials", "Value": "badcreds", "Docs": "" }, { "Name": "AuthBadChannelBinding", "Value": "badchanbind", "Docs": "" }, { "Name": "AuthBadProtocol", "Value": "badprotocol", "Docs": "" }, { "Name": "AuthLoginDisabled", "Value": "logindisabled", "Docs": "" }, { "Name": "AuthError", "Value": "error", "Docs": "" }, { "Name": "AuthAborted", "Value": "aborted", "Docs": "" }] },
+		"AuthResult": { "Name": "AuthResult", "Docs": "", "Values": [{ "Name": "AuthSuccess", "Value": "ok", "Docs": "" }, { "Name": "AuthBadUser", "Value": "baduser", "Docs": "" }, { "Name": "AuthBadPassword", "Value": "badpassword", "Docs": "" }, { "Name": "AuthBadCredentials", "Value": "badcreds", "Docs": "" }, { "Name": "AuthBadChannelBinding", "Value": "badchanbind", "Docs": "" }, { "Name": "AuthBadProtocol", "Value": "badprotocol", "Docs": "" }, { "Name": "AuthLoginDisabled", "Value": "logindisabled", "Docs": "" }, { "Name": "AuthTOTPRequired", "Value": "totprequired", "Docs": "" }, { "Name": "AuthBadTOTP", "Value": "badtotp", "Docs": "" }, { "Name": "AuthReferral", "Value": "referral", "Docs": "" }, { "Name": "AuthError", "Value": "error", "Docs": "" }, { "Name": "AuthAborted", "Value": "aborted", "Docs": "" }] },
 	};
 	api.parser = {
+		WebAuthnLoginOptions: (v) => api.parse("WebAuthnLoginOptions", v),
+		WebAuthnRegisterOptions: (v) => api.parse("WebAuthnRegisterOptions", v),
+		AdminWebAuthnCredential: (v) => api.parse("AdminWebAuthnCredential", v),
+		AdminSession: (v) => api.parse("AdminSession", v),
 		CheckResult: (v) => api.parse("CheckResult", v),
 		DNSSECResult: (v) => api.parse("DNSSECResult", v),
 		IPRevCheckResult: (v) => api.parse("IPRevCheckResult", v),
@@ -415,6 +447,8 @@ var api;
 		Pair: (v) => api.parse("Pair", v),
 		Policy: (v) => api.parse("Policy", v),
 		STSMX: (v) => api.parse("STSMX", v),
+		BIMICheckResult: (v) => api.parse("BIMICheckResult", v),
+		BIMIRecord: (v) => api.parse("BIMIRecord", v),
 		SRVConfCheckResult: (v) => api.parse("SRVConfCheckResult", v),
 		SRV: (v) => api.parse("SRV", v),
 		AutoconfCheckResult: (v) => api.parse("AutoconfCheckResult", v),
@@ -427,16 +461,28 @@ var api;
 		DMARC: (v) => api.parse("DMARC", v),
 		MTASTS: (v) => api.parse("MTASTS", v),
 		TLSRPT: (v) => api.parse("TLSRPT", v),
+		BIMI: (v) => api.parse("BIMI", v),
 		Route: (v) => api.parse("Route", v),
 		Alias: (v) => api.parse("Alias", v),
 		AliasAddress: (v) => api.parse("AliasAddress", v),
 		Address: (v) => api.parse("Address", v),
 		Destination: (v) => api.parse("Destination", v),
 		Ruleset: (v) => api.parse("Ruleset", v),
+		List: (v) => api.parse("List", v),
+		DestinationPattern: (v) => api.parse("DestinationPattern", v),
+		Subdomains: (v) => api.parse("Subdomains", v),
+		SubdomainRoute: (v) => api.parse("SubdomainRoute", v),
+		RejectedRetention: (v) => api.parse("RejectedRetention", v),
+		RejectText: (v) => api.parse("RejectText", v),
+		DomainRetire: (v) => api.parse("DomainRetire", v),
 		Account: (v) => api.parse("Account", v),
 		OutgoingWebhook: (v) => api.parse("OutgoingWebhook", v),
 		IncomingWebhook: (v) => api.parse("IncomingWebhook", v),
+		AttachmentLinks: (v) => api.parse("AttachmentLinks", v),
+		Notification: (v) => api.parse("Notification", v),
 		SubjectPass: (v) => api.parse("SubjectPass", v),
+		MailboxRetention: (v) => api.parse("MailboxRetention", v),
+		AutoArchive: (v) => api.parse("AutoArchive", v),
 		AutomaticJunkFlags: (v) => api.parse("AutomaticJunkFlags", v),
 		JunkFilter: (v) => api.parse("JunkFilter", v),
 		AddressAlias: (v) => api.parse("AddressAlias", v),
@@ -471,6 +517,7 @@ var api;
 		Msg: (v) => api.parse("Msg", v),
 		IPDomain: (v) => api.parse("IPDomain", v),
 		MsgResult: (v) => api.parse("MsgResult", v),
+		MsgResultHost: (v) => api.parse("MsgResultHost", v),
 		RetiredFilter: (v) => api.parse("RetiredFilter", v),
 		RetiredSort: (v) => api.parse("RetiredSort", v),
 		MsgRetired: (v) => api.parse("MsgRetired", v),
@@ -490,6 +537,7 @@ var api;
 		Transport: (v) => api.parse("Transport", v),
 		TransportSMTP: (v) => api.parse("TransportSMTP", v),
 		SMTPAuth: (v) => api.parse("SMTPAuth", v),
+		TransportSMTPHost: (v) => api.parse("TransportSMTPHost", v),
 		TransportSocks: (v) => api.parse("TransportSocks", v),
 		TransportDirect: (v) => api.parse("TransportDirect", v),
 		TransportFail: (v) => api.parse("TransportFail", v),
@@ -499,8 +547,13 @@ var api;
 		TLSResult: (v) => api.parse("TLSResult", v),
 		TLSRPTSuppressAddress: (v) => api.parse("TLSRPTSuppressAddress", v),
 		Dynamic: (v) => api.parse("Dynamic", v),
+		Tenant: (v) => api.parse("Tenant", v),
+		Canary: (v) => api.parse("Canary", v),
 		TLSPublicKey: (v) => api.parse("TLSPublicKey", v),
 		LoginAttempt: (v) => api.parse("LoginAttempt", v),
+		AuthLockout: (v) => api.parse("AuthLockout", v),
+		TLSCertificate: (v) => api.parse("TLSCertificate", v),
+		Message: (v) => api.parse("Message", v),
 		CSRFToken: (v) => api.parse("CSRFToken", v),
 		DMARCPolicy: (v) => api.parse("DMARCPolicy", v),
 		Align: (v) => api.parse("Align", v),
@@ -545,12 +598,14 @@ var api;
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// Login returns a session token for the credentials, or fails with error code
-		// "user:badLogin". Call LoginPrep to get a loginToken.
-		async Login(loginToken, password) {
+		// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+		// authentication is enabled and totp is empty, the call fails with error code
+		// "user:totpRequired", and must be repeated with a TOTP code or recovery code.
+		async Login(loginToken, password, totp) {
 			const fn = "Login";
-			const paramTypes = [["string"], ["string"]];
+			const paramTypes = [["string"], ["string"], ["string"]];
 			const returnTypes = [["CSRFToken"]];
-			const params = [loginToken, password];
+			const params = [loginToken, password, totp];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// Logout invalidates the session token.
@@ -561,6 +616,91 @@ var api;
 			const params = [];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// WebAuthnLoginStart returns options for logging in with a registered security key
+		// or passkey instead of the admin password. The assertion from the browser must be
+		// passed to LoginWebAuthn.
+		async WebAuthnLoginStart() {
+			const fn = "WebAuthnLoginStart";
+			const paramTypes = [];
+			const returnTypes = [["WebAuthnLoginOptions"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// LoginWebAuthn returns a session token for a WebAuthn assertion by a registered
+		// security key or passkey, or fails with error code "user:loginFailed". Call
+		// LoginPrep to get a loginToken, and WebAuthnLoginStart for the challenge. Binary
+		// values are raw-url-base64-encoded.
+		async LoginWebAuthn(loginToken, challenge, credentialID, clientDataJSON, authenticatorData, signature) {
+			const fn = "LoginWebAuthn";
+			const paramTypes = [["string"], ["string"], ["string"], ["string"], ["string"], ["string"]];
+			const returnTypes = [["CSRFToken"]];
+			const params = [loginToken, challenge, credentialID, clientDataJSON, authenticatorData, signature];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// WebAuthnRegisterStart returns options for registering a security key or passkey
+		// for logging in, at the host name the admin web interface is accessed at. The
+		// response from the browser must be passed to WebAuthnRegisterFinish.
+		async WebAuthnRegisterStart() {
+			const fn = "WebAuthnRegisterStart";
+			const paramTypes = [];
+			const returnTypes = [["WebAuthnRegisterOptions"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// WebAuthnRegisterFinish verifies and stores a new security key or passkey, with
+		// the response from the browser for the options from WebAuthnRegisterStart.
+		// Binary values are raw-url-base64-encoded.
+		async WebAuthnRegisterFinish(name, challenge, clientDataJSON, attestationObject) {
+			const fn = "WebAuthnRegisterFinish";
+			const paramTypes = [["string"], ["string"], ["string"], ["string"]];
+			const returnTypes = [];
+			const params = [name, challenge, clientDataJSON, attestationObject];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// WebAuthnCredentials returns the security keys and passkeys registered for
+		// logging in.
+		async WebAuthnCredentials() {
+			const fn = "WebAuthnCredentials";
+			const paramTypes = [];
+			const returnTypes = [["[]", "AdminWebAuthnCredential"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// WebAuthnCredentialRemove removes a registered security key or passkey.
+		async WebAuthnCredentialRemove(id) {
+			const fn = "WebAuthnCredentialRemove";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// Sessions returns the active admin sessions, most recently used first, and the
+		// ID of the session of the request.
+		async Sessions() {
+			const fn = "Sessions";
+			const paramTypes = [];
+			const returnTypes = [["[]", "AdminSession"], ["int64"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SessionRevoke removes an admin session, it can no longer be used. The session
+		// of the request cannot be revoked, use Logout instead.
+		async SessionRevoke(id) {
+			const fn = "SessionRevoke";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SessionsRevokeOthers removes all admin sessions except the session of the
+		// request, and returns the number of revoked sessions.
+		async SessionsRevokeOthers() {
+			const fn = "SessionsRevokeOthers";
+			const paramTypes = [];
+			const returnTypes = [["int32"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// Version returns the version, goos and goarch.
 		async Version() {
 			const fn = "Version";
@@ -578,7 +718,8 @@ var api;
 			const params = [domainName];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
-		// Domains returns all configured domain names.
+		// Domains returns all configured domain names, or only those of the tenant for
+		// tenant sessions.
 		async Domains() {
 			const fn = "Domains";
 			const paramTypes = [];
@@ -618,7 +759,8 @@ var api;
 			const params = [domain];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
-		// Accounts returns the names of all configured and all disabled accounts.
+		// Accounts returns the names of all configured and all disabled accounts, or
+		// only those of the tenant for tenant sessions.
 		async Accounts() {
 			const fn = "Accounts";
 			const paramTypes = [];
@@ -870,6 +1012,15 @@ var api;
 			const params = [filter, sort];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// QueueMessageSource returns the source of a message in the queue, e.g. for
+		// reviewing a message held for review. At most 1MB is returned.
+		async QueueMessageSource(id) {
+			const fn = "QueueMessageSource";
+			const paramTypes = [["int64"]];
+			const returnTypes = [["string"]];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// QueueNextAttemptSet sets a new time for next delivery attempt of matching
 		// messages from the queue.
 		async QueueNextAttemptSet(filter, minutes) {
@@ -1285,12 +1436,15 @@ var api;
 		}
 		// DomainDKIMSave saves the settings of selectors, and which to enable for
 		// signing, for a domain. All currently configured selectors must be present,
-		// selectors cannot be added/removed with this function.
-		async DomainDKIMSave(domainName, selectors, sign) {
+		// selectors cannot be added/removed with this function. If signMailFrom is set,
+		// submitted messages are also signed for the SMTP MAIL FROM domain if it is
+		// different. If signHostname is set, submitted messages are also signed for the
+		// domain of the hostname.
+		async DomainDKIMSave(domainName, selectors, sign, signMailFrom, signHostname) {
 			const fn = "DomainDKIMSave";
-			const paramTypes = [["string"], ["{}", "Selector"], ["[]", "string"]];
+			const paramTypes = [["string"], ["{}", "Selector"], ["[]", "string"], ["bool"], ["bool"]];
 			const returnTypes = [];
-			const params = [domainName, selectors, sign];
+			const params = [domainName, selectors, sign, signMailFrom, signHostname];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// DomainDisabledSave saves the Disabled field of a domain. A disabled domain
@@ -1352,6 +1506,108 @@ var api;
 			const params = [accountName, limit];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// AuthLockouts returns IPs and accounts with recent failed authentication
+		// attempts, and whether they are locked out.
+		async AuthLockouts() {
+			const fn = "AuthLockouts";
+			const paramTypes = [];
+			const returnTypes = [["[]", "AuthLockout"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AuthLockoutClear clears failed authentication attempts and a lockout for an IP
+		// or account name. If empty, all are cleared.
+		async AuthLockoutClear(ipOrAccount) {
+			const fn = "AuthLockoutClear";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [ipOrAccount];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TLSCertificates returns the TLS certificates of all listeners, both from ACME
+		// and from files.
+		async TLSCertificates() {
+			const fn = "TLSCertificates";
+			const paramTypes = [];
+			const returnTypes = [["[]", "TLSCertificate"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TLSCertificateRenew starts requesting new certificates through ACME for the
+		// host name, regardless of the expiration time of the current certificates. The
+		// request is done in the background, progress is visible in TLSCertificates.
+		async TLSCertificateRenew(acmeName, hostname) {
+			const fn = "TLSCertificateRenew";
+			const paramTypes = [["string"], ["string"]];
+			const returnTypes = [];
+			const params = [acmeName, hostname];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TLSKeyCertReplace replaces the certificate and private key configured for a
+		// listener, writing the key and certificate files. The new certificate is used for
+		// new connections immediately.
+		async TLSKeyCertReplace(listenerName, index, certPEM, keyPEM) {
+			const fn = "TLSKeyCertReplace";
+			const paramTypes = [["string"], ["int32"], ["string"], ["string"]];
+			const returnTypes = [];
+			const params = [listenerName, index, certPEM, keyPEM];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// RejectedList returns the retained messages that were rejected during the SMTP
+		// transaction, most recent first, optionally only for a domain.
+		async RejectedList(domain) {
+			const fn = "RejectedList";
+			const paramTypes = [["string"]];
+			const returnTypes = [["[]", "Message"]];
+			const params = [domain];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// RejectedMessage returns a retained rejected message and its contents.
+		async RejectedMessage(id) {
+			const fn = "RejectedMessage";
+			const paramTypes = [["int64"]];
+			const returnTypes = [["Message"], ["string"]];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// RejectedRemove removes retained rejected messages.
+		async RejectedRemove(ids) {
+			const fn = "RejectedRemove";
+			const paramTypes = [["[]", "int64"]];
+			const returnTypes = [];
+			const params = [ids];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// RejectedDeliver delivers a retained rejected message to the accounts of its
+		// recipients, without the checks that caused the rejection. Typically used after
+		// adjusting the policy. The addresses the message was delivered to are returned.
+		async RejectedDeliver(id) {
+			const fn = "RejectedDeliver";
+			const paramTypes = [["int64"]];
+			const returnTypes = [["[]", "string"]];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// LoginTenant returns a session token for the admin of a tenant, or fails with
+		// error code "user:loginFailed". Call LoginPrep to get a loginToken. The session
+		// only gives access to the domains and accounts of the tenant.
+		async LoginTenant(loginToken, tenant, password) {
+			const fn = "LoginTenant";
+			const paramTypes = [["string"], ["string"], ["string"]];
+			const returnTypes = [["CSRFToken"]];
+			const params = [loginToken, tenant, password];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// Tenant returns the name of the tenant of the session, or an empty string for
+		// the admin. The web interface only shows the domains and accounts of the
+		// tenant for tenant sessions.
+		async Tenant() {
+			const fn = "Tenant";
+			const paramTypes = [];
+			const returnTypes = [["string"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 	}
 	api.Client = Client;
 	api.defaultBaseURL = (function () {
@@ -1707,12 +1963,29 @@ var api;
 let moxversion;
 let moxgoos;
 let moxgoarch;
+let tenant; // Set for sessions of tenant admins, which can only manage domains and accounts of the tenant.
 const login = async (reason) => {
 	return new Promise((resolve, _) => {
 		const origFocus = document.activeElement;
 		let reasonElem;
 		let fieldset;
+		let tenantName;
 		let password;
+		let totpLabel;
+		let totp;
+		const loggedIn = (token) => {
+			try {
+				window.localStorage.setItem('webadmincsrftoken', token);
+			}
+			catch (err) {
+				console.log('saving csrf token in localStorage', err);
+			}
+			root.remove();
+			if (origFocus && origFocus instanceof HTMLElement && origFocus.parentNode) {
+				origFocus.focus();
+			}
+			resolve(token);
+		};
 		const root = dom.div(style({ position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: '1', animation: 'fadein .15s ease-in' }), dom.div(style({ display: 'flex', flexDirection: 'column', alignItems: 'center' }), reasonElem = reason ? dom.div(style({ marginBottom: '2ex', textAlign: 'center' }), reason) : dom.div(), dom.div(style({ backgroundColor: 'white', borderRadius: '.25em', padding: '1em', boxShadow: '0 0 20px rgba(0, 0, 0, 0.1)', border: '1px solid #ddd', maxWidth: '95vw', overflowX: 'auto', maxHeight: '95vh', overflowY: 'auto', marginBottom: '20vh' }), dom.form(async function submit(e) {
 			e.preventDefault();
 			e.stopPropagation();
@@ -1720,31 +1993,60 @@ const login = async (reason) => {
 			try {
 				fieldset.disabled = true;
 				const loginToken = await client.LoginPrep();
-				const token = await client.Login(loginToken, password.value);
-				try {
-					window.localStorage.setItem('webadmincsrftoken', token);
-				}
-				catch (err) {
-					console.log('saving csrf token in localStorage', err);
+				const token = tenantName.value ? await client.LoginTenant(loginToken, tenantName.value, password.value) : await client.Login(loginToken, password.value, totp.value);
+				loggedIn(token);
+			}
+			catch (err) {
+				console.log('login error', err);
+				if (err.code === 'user:totpRequired') {
+					// Password was correct, ask for two-factor authentication code and retry.
+					totpLabel.style.display = 'block';
+					totp.required = true;
+					fieldset.disabled = false;
+					totp.focus();
+					return;
 				}
-				root.remove();
-				if (origFocus && origFocus instanceof HTMLElement && origFocus.parentNode) {
-					origFocus.focus();
+				window.alert('Error: ' + errmsg(err));
+			}
+			finally {
+				fieldset.disabled = false;
+			}
+		}, fieldset = dom.fieldset(dom.h1('Admin'), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Tenant', style({ marginBottom: '.5ex' }), attr.title('Only for admins of a tenant. Leave empty to login as admin.')), tenantName = dom.input(attr.autocomplete('username'), attr.placeholder('(optional)'))), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Password', style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.autocomplete('current-password'), attr.required(''))), totpLabel = dom.label(style({ display: 'none', marginBottom: '2ex' }), dom.div('Two-factor authentication code', style({ marginBottom: '.5ex' })), totp = dom.input(attr.autocomplete('one-time-code'), attr.title('Code from authenticator app, or a recovery code.'))), dom.div(style({ textAlign: 'center' }), dom.submitbutton('Login')), window.PublicKeyCredential ? dom.div(style({ textAlign: 'center', marginTop: '1ex' }), dom.clickbutton('Login with security key', attr.title('Login with a security key or passkey registered for this host name, instead of the password.'), async function click() {
+			reasonElem.remove();
+			try {
+				fieldset.disabled = true;
+				const loginToken = await client.LoginPrep();
+				const opts = await client.WebAuthnLoginStart();
+				const cred = await navigator.credentials.get({
+					publicKey: {
+						challenge: base64urlDecode(opts.Challenge),
+						rpId: opts.RPID,
+						allowCredentials: (opts.CredentialIDs || []).map(id => ({ type: 'public-key', id: base64urlDecode(id) })),
+						userVerification: 'preferred',
+					},
+				});
+				if (!cred) {
+					throw new Error('no credential');
 				}
-				resolve(token);
+				const resp = cred.response;
+				const token = await client.LoginWebAuthn(loginToken, opts.Challenge, base64urlEncode(cred.rawId), base64urlEncode(resp.clientDataJSON), base64urlEncode(resp.authenticatorData), base64urlEncode(resp.signature));
+				loggedIn(token);
 			}
 			catch (err) {
-				console.log('login error', err);
+				console.log('webauthn login error', err);
 				window.alert('Error: ' + errmsg(err));
 			}
 			finally {
 				fieldset.disabled = false;
 			}
-		}, fieldset = dom.fieldset(dom.h1('Admin'), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Password', style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.autocomplete('current-password'), attr.required(''))), dom.div(style({ textAlign: 'center' }), dom.submitbutton('Login')))))));
+		})) : [])))));
 		document.body.appendChild(root);
 		password.focus();
 	});
 };
+// Encode/decode binary data for WebAuthn, which the API passes as raw base64url.
+const base64urlEncode = (buf) => btoa(String.fromCharCode(...new Uint8Array(buf))).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+const base64urlDecode = (s) => Uint8Array.from(atob(s.replace(/-/g, '+').replace(/_/g, '/')), c => c.charCodeAt(0)).buffer;
 // Popup shows kids in a centered div with white background on top of a
 // transparent overlay on top of the window. Clicking the overlay or hitting
 // Escape closes the popup. Scrollbars are automatically added to the div with
@@ -1970,7 +2272,17 @@ const formatSize = (n) => {
 	}
 	return n + ' bytes';
 };
+// tenantIndex is the index page for tenant admins, who can only manage the
+// domains and accounts of their tenant.
+const tenantIndex = async () => {
+	const domains = await client.Domains();
+	return dom.div(crumbs('Mox Admin for tenant ' + tenant), dom.p(dom.a('Accounts', attr.href('#accounts')), dom.br()), dom.h2('Domains'), (domains || []).length === 0 ? box(red, 'No domains') :
+		dom.ul((domains || []).map(d => dom.li(dom.a(attr.href('#domains/' + domainName(d.Domain)), domainString(d.Domain)), d.Disabled ? ' (disabled)' : []))), footer());
+};
 const index = async () => {
+	if (tenant) {
+		return await tenantIndex();
+	}
 	const [domains, queueSize, hooksQueueSize, checkUpdatesEnabled, [accounts, accountsDisabled]] = await Promise.all([
 		client.Domains(),
 		client.QueueSize(),
@@ -1992,7 +2304,7 @@ const index = async () => {
 		e.stopPropagation();
 		await check(fieldset, client.DomainAdd(disabled.checked, domain.value, account.value, localpart.value));
 		window.location.hash = '#domains/' + domain.value;
-	}, fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), dom.span('Domain', attr.title('Domain for incoming/outgoing email to add to mox. Can also be a subdomain of a domain already configured.')), dom.br(), domain = dom.input(attr.required(''))), ' ', dom.label(style({ display: 'inline-block' }), dom.span('Postmaster/reporting account', attr.title('Account that is considered the owner of this domain. If the account does not yet exist, it will be created and a a localpart is required for the initial email address.')), dom.br(), account = dom.input(attr.required(''), attr.list('accountList')), dom.datalist(attr.id('accountList'), (accounts || []).map(a => dom.option(attr.value(a), a + (accountsDisabled?.includes(a) ? ' (disabled)' : ''))))), ' ', dom.label(style({ display: 'inline-block' }), dom.span('Localpart (if new account)', attr.title('Must be set if and only if account does not yet exist. A localpart is the part before the "@"-sign of an email address. An account requires an email address, so creating a new account for a domain requires a localpart to form an initial email address.')), dom.br(), localpart = dom.input()), ' ', dom.label(disabled = dom.input(attr.type('checkbox')), ' Disabled', attr.title('Disabled domains do fetch new certificates with ACME and do not accept incoming or outgoing messages involving the domain. Accounts and addresses referencing a disabled domain can be created. USeful during/before migrations.')), ' ', dom.submitbutton('Add domain', attr.title('Domain will be added and the config reloaded. Add the required DNS records after adding the domain.')))), dom.br(), dom.h2('Reports'), dom.div(dom.a('DMARC', attr.href('#dmarc/reports'))), dom.div(dom.a('TLS', attr.href('#tlsrpt/reports'))), dom.br(), dom.h2('Operations'), dom.div(dom.a('MTA-STS policies', attr.href('#mtasts'))), dom.div(dom.a('DMARC evaluations', attr.href('#dmarc/evaluations'))), dom.div(dom.a('TLS connection results', attr.href('#tlsrpt/results'))), dom.div(dom.a('DNSBL', attr.href('#dnsbl'))), dom.div(style({ marginTop: '.5ex' }), dom.form(async function submit(e) {
+	}, fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), dom.span('Domain', attr.title('Domain for incoming/outgoing email to add to mox. Can also be a subdomain of a domain already configured.')), dom.br(), domain = dom.input(attr.required(''))), ' ', dom.label(style({ display: 'inline-block' }), dom.span('Postmaster/reporting account', attr.title('Account that is considered the owner of this domain. If the account does not yet exist, it will be created and a a localpart is required for the initial email address.')), dom.br(), account = dom.input(attr.required(''), attr.list('accountList')), dom.datalist(attr.id('accountList'), (accounts || []).map(a => dom.option(attr.value(a), a + (accountsDisabled?.includes(a) ? ' (disabled)' : ''))))), ' ', dom.label(style({ display: 'inline-block' }), dom.span('Localpart (if new account)', attr.title('Must be set if and only if account does not yet exist. A localpart is the part before the "@"-sign of an email address. An account requires an email address, so creating a new account for a domain requires a localpart to form an initial email address.')), dom.br(), localpart = dom.input()), ' ', dom.label(disabled = dom.input(attr.type('checkbox')), ' Disabled', attr.title('Disabled domains do fetch new certificates with ACME and do not accept incoming or outgoing messages involving the domain. Accounts and addresses referencing a disabled domain can be created. USeful during/before migrations.')), ' ', dom.submitbutton('Add domain', attr.title('Domain will be added and the config reloaded. Add the required DNS records after adding the domain.')))), dom.br(), dom.h2('Reports'), dom.div(dom.a('DMARC', attr.href('#dmarc/reports'))), dom.div(dom.a('TLS', attr.href('#tlsrpt/reports'))), dom.br(), dom.h2('Operations'), dom.div(dom.a('MTA-STS policies', attr.href('#mtasts'))), dom.div(dom.a('DMARC evaluations', attr.href('#dmarc/evaluations'))), dom.div(dom.a('TLS connection results', attr.href('#tlsrpt/results'))), dom.div(dom.a('DNSBL', attr.href('#dnsbl'))), dom.div(dom.a('Rejected messages', attr.href('#rejected'))), dom.div(style({ marginTop: '.5ex' }), dom.form(async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
 		dom._kids(cidElem);
@@ -2000,7 +2312,7 @@ const index = async () => {
 		dom._kids(cidElem, cid);
 	}, recvIDFieldset = dom.fieldset(dom.label('Received ID', attr.title('The ID in the Received header that was added during incoming delivery.')), ' ', recvID = dom.input(attr.required('')), ' ', dom.submitbutton('Lookup cid', attr.title('Logging about an incoming message includes an attribute "cid", a counter identifying the transaction related to delivery of the message. The ID in the received header is an encrypted cid, which this form decrypts, after which you can look it up in the logging.')), ' ', cidElem = dom.span()))), 
 	// todo: routing, globally, per domain and per account
-	dom.br(), dom.h2('Configuration'), dom.div(dom.a('Routes', attr.href('#routes'))), dom.div(dom.a('Webserver', attr.href('#webserver'))), dom.div(dom.a('Files', attr.href('#config'))), dom.div(dom.a('Log levels', attr.href('#loglevels'))), footer());
+	dom.br(), dom.h2('Configuration'), dom.div(dom.a('Routes', attr.href('#routes'))), dom.div(dom.a('Webserver', attr.href('#webserver'))), dom.div(dom.a('Files', attr.href('#config'))), dom.div(dom.a('Log levels', attr.href('#loglevels'))), dom.div(dom.a('Security keys', attr.href('#webauthn'))), dom.div(dom.a('Sessions', attr.href('#sessions'))), dom.div(dom.a('TLS certificates', attr.href('#tls'))), footer());
 };
 const globalRoutes = async () => {
 	const [transports, config] = await Promise.all([
@@ -2013,6 +2325,145 @@ const config = async () => {
 	const [staticPath, dynamicPath, staticText, dynamicText] = await client.ConfigFiles();
 	return dom.div(crumbs(crumblink('Mox Admin', '#'), 'Config'), dom.h2(staticPath), dom.pre(dom._class('literal'), staticText), dom.h2(dynamicPath), dom.pre(dom._class('literal'), dynamicText));
 };
+const webauthnCredentials = async () => {
+	const creds = await client.WebAuthnCredentials();
+	const nowSecs = new Date().getTime() / 1000;
+	let form;
+	let fieldset;
+	let name;
+	return dom.div(crumbs(crumblink('Mox Admin', '#'), 'Security keys'), dom.p('Security keys and passkeys can be used to login to the admin web interface instead of the admin password. Credentials are only valid for the host name they were registered at, currently ', dom.b(location.hostname), '. If you lose access to your security keys, login with the admin password, or remove all credentials with "mox admin webauthn reset".'), dom.table(dom.thead(dom.tr(dom.th('Name'), dom.th('Host name'), dom.th('Created'), dom.th('Last used'), dom.th('Action'))), dom.tbody(creds.length === 0 ? dom.tr(dom.td(attr.colspan('5'), 'No security keys registered.')) : [], creds.map(c => dom.tr(dom.td(c.Name), dom.td(c.RPID), dom.td(age(c.Created, false, nowSecs)), dom.td(c.LastUsed.getTime() > 0 ? age(c.LastUsed, false, nowSecs) : '-'), dom.td(dom.clickbutton('Remove', async function click(e) {
+		if (!window.confirm('Are you sure you want to remove security key "' + c.Name + '"?')) {
+			return;
+		}
+		await check(e.target, client.WebAuthnCredentialRemove(c.ID));
+		window.location.reload(); // todo: reload just the list
+	})))))), dom.br(), dom.h2('Register security key'), window.PublicKeyCredential ? form = dom.form(async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		await check(fieldset, (async () => {
+			const opts = await client.WebAuthnRegisterStart();
+			const cred = await navigator.credentials.create({
+				publicKey: {
+					challenge: base64urlDecode(opts.Challenge),
+					rp: { id: opts.RPID, name: 'mox admin' },
+					user: { id: base64urlDecode(opts.UserID), name: 'admin', displayName: 'admin' },
+					pubKeyCredParams: (opts.Algorithms || []).map(alg => ({ type: 'public-key', alg: alg })),
+					excludeCredentials: (opts.ExcludeCredentialIDs || []).map(id => ({ type: 'public-key', id: base64urlDecode(id) })),
+					authenticatorSelection: { residentKey: 'preferred', userVerification: 'preferred' },
+					attestation: 'none',
+				},
+			});
+			if (!cred) {
+				throw new Error('no credential');
+			}
+			const resp = cred.response;
+			await client.WebAuthnRegisterFinish(name.value, opts.Challenge, base64urlEncode(resp.clientDataJSON), base64urlEncode(resp.attestationObject));
+		})());
+		form.reset();
+		window.location.reload(); // todo: reload just the list
+	}, fieldset = dom.fieldset(dom.label('Name ', name = dom.input(attr.required(''), attr.placeholder('e.g. "yubikey"'))), ' ', dom.submitbutton('Register'))) : dom.p('This browser does not support security keys.'));
+};
+const sessions = async () => {
+	const [sessions0, currentID] = await client.Sessions();
+	const sessions = sessions0 || [];
+	const nowSecs = new Date().getTime() / 1000;
+	return dom.div(crumbs(crumblink('Mox Admin', '#'), 'Sessions'), dom.p('Sessions of the admin web interface. Sessions expire after 12 hours of inactivity. At most 10 sessions are kept, the least recently used session is removed for a new login. Changing the admin password, with "mox setadminpassword", revokes all sessions.'), dom.table(dom.thead(dom.tr(dom.th('Login'), dom.th('Last used'), dom.th('Remote IP'), dom.th('User Agent'), dom.th('Action'))), dom.tbody(sessions.map(s => dom.tr(dom.td(age(s.Created, false, nowSecs)), dom.td(age(s.LastUsed, false, nowSecs)), dom.td(s.RemoteIP), dom.td(s.UserAgent), dom.td(s.ID === currentID ? 'Current session' :
+		dom.clickbutton('Revoke', async function click(e) {
+			if (!window.confirm('Are you sure you want to revoke this session?')) {
+				return;
+			}
+			await check(e.target, client.SessionRevoke(s.ID));
+			window.location.reload(); // todo: reload just the list
+		})))))), dom.br(), dom.clickbutton('Revoke all other sessions', sessions.length <= 1 ? attr.disabled('') : [], async function click(e) {
+		if (!window.confirm('Are you sure you want to revoke all other sessions?')) {
+			return;
+		}
+		await check(e.target, client.SessionsRevokeOthers());
+		window.location.reload(); // todo: reload just the list
+	}));
+};
+const tlsCertificates = async () => {
+	const certs = await client.TLSCertificates();
+	const nowSecs = new Date().getTime() / 1000;
+	const replacePopup = (c) => {
+		let fieldset;
+		let certPEM;
+		let keyPEM;
+		const close = popup(dom.h1('Replace certificate'), dom.p('The certificate and private key files are replaced, and new TLS connections use the new certificate immediately. The files must be writable by mox.'), dom.form(async function submit(e) {
+			e.preventDefault();
+			e.stopPropagation();
+			await check(fieldset, client.TLSKeyCertReplace(c.Listener, c.Index, certPEM.value, keyPEM.value));
+			close();
+			window.location.reload(); // todo: reload just the list
+		}, fieldset = dom.fieldset(dom.label(style({ display: 'block', marginBottom: '1ex' }), dom.div('Certificate chain, PEM-encoded, for ', dom.span(c.CertFile, style({ fontFamily: 'monospace' }))), certPEM = dom.textarea(attr.required(''), attr.rows('10'), style({ width: '50em', fontFamily: 'monospace' }))), dom.label(style({ display: 'block', marginBottom: '1ex' }), dom.div('Private key, PEM-encoded, for ', dom.span(c.KeyFile, style({ fontFamily: 'monospace' }))), keyPEM = dom.textarea(attr.required(''), attr.rows('5'), style({ width: '50em', fontFamily: 'monospace' }))), dom.submitbutton('Replace'))));
+		certPEM.focus();
+	};
+	const expiry = (c) => {
+		if (!c.Present) {
+			return dom.td('-');
+		}
+		const days = (c.NotAfter.getTime() / 1000 - nowSecs) / (24 * 3600);
+		return dom.td(days < 0 ? style({ color: 'red' }) : (days < 14 ? style({ color: 'orange' }) : []), days < 0 ? ['expired ', age(c.NotAfter, false, nowSecs)] : ['in ', age(c.NotAfter, true, nowSecs)]);
+	};
+	const status = (c) => {
+		const l = [];
+		if (c.Error) {
+			l.push(dom.div(style({ color: 'red' }), 'Error: ' + c.Error));
+		}
+		if (c.Renewing) {
+			l.push(dom.div('Renewal in progress...'));
+		}
+		if (c.LastError) {
+			l.push(dom.div(style({ color: 'red' }), 'Last error ', age(c.LastErrorTime, false, nowSecs), ': ' + c.LastError));
+		}
+		if (c.NextAttempt.getTime() / 1000 > nowSecs) {
+			l.push(dom.div('After failed requests, next attempt in ', age(c.NextAttempt, true, nowSecs)));
+		}
+		if (c.ACME && !c.Present) {
+			l.push(dom.div('Not yet requested, certificates are requested when first needed.'));
+		}
+		else if (c.ACME && c.RenewAfter.getTime() / 1000 < nowSecs) {
+			l.push(dom.div(style({ color: 'orange' }), 'Renewal is due, see last error or logs if it does not succeed.'));
+		}
+		else if (c.ACME) {
+			l.push(dom.div('Automatic renewal in ', age(c.RenewAfter, true, nowSecs)));
+		}
+		return l;
+	};
+	const acmeCerts = certs.filter(c => c.ACME);
+	const fileCerts = certs.filter(c => !c.ACME);
+	return dom.div(crumbs(crumblink('Mox Admin', '#'), 'TLS certificates'), dom.h2('ACME'), dom.p('Certificates requested automatically through ACME, e.g. from Let\'s Encrypt, and renewed before they expire. Certificates for RSA keys are only requested for TLS clients that do not support ECDSA.'), dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Host name'), dom.th('Key type'), dom.th('Provider', attr.title('ACME provider, from the configuration file.')), dom.th('Listeners'), dom.th('Issuer'), dom.th('Expires'), dom.th('Status'), dom.th('Action'))), dom.tbody(acmeCerts.length === 0 ? dom.tr(dom.td(attr.colspan('8'), 'No certificates.')) : [], acmeCerts.map(c => dom.tr(dom.td(c.Hostname), dom.td(c.KeyType), dom.td(c.ACME), dom.td((c.Listeners || []).join(', ')), dom.td(c.Issuer, attr.title((c.DNSNames || []).join(', '))), expiry(c), dom.td(status(c)), dom.td(dom.clickbutton('Renew', c.Renewing ? attr.disabled('') : [], attr.title('Request new certificates now, for all key types of this host name, instead of waiting for automatic renewal. Keep rate limits of the ACME provider in mind.'), async function click(e) {
+		await check(e.target, client.TLSCertificateRenew(c.ACME, c.Hostname));
+		window.location.reload(); // todo: reload just the list
+	})))))), dom.br(), dom.h2('Files'), dom.p('Certificates from key and certificate files configured in mox.conf. These are not renewed automatically.'), dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Listener'), dom.th('Certificate file'), dom.th('DNS names'), dom.th('Issuer'), dom.th('Expires'), dom.th('Status'), dom.th('Action'))), dom.tbody(fileCerts.length === 0 ? dom.tr(dom.td(attr.colspan('7'), 'No certificates.')) : [], fileCerts.map(c => dom.tr(dom.td(c.Listener), dom.td(c.CertFile), dom.td((c.DNSNames || []).join(', ')), dom.td(c.Issuer), expiry(c), dom.td(status(c)), dom.td(dom.clickbutton('Replace...', attr.title('Upload a new certificate and private key.'), function click() {
+		replacePopup(c);
+	})))))));
+};
+const rejectedList = async () => {
+	const msgs = await client.RejectedList('') || [];
+	const nowSecs = new Date().getTime() / 1000;
+	const response = (m) => '' + m.Code + ' ' + Math.floor(m.Code / 100) + '.' + m.Secode + ' ' + m.Errmsg;
+	const viewPopup = async (m) => {
+		const [, data] = await client.RejectedMessage(m.ID);
+		popup(dom.h1('Rejected message'), dom.table(dom.tr(dom.td('Received'), dom.td(m.Received.toString())), dom.tr(dom.td('Remote IP'), dom.td(m.RemoteIP)), dom.tr(dom.td('EHLO'), dom.td(m.EHLO)), dom.tr(dom.td('MAIL FROM'), dom.td(m.MailFrom || '<>')), dom.tr(dom.td('RCPT TO'), dom.td((m.RcptTo || []).join(', '))), dom.tr(dom.td('Response'), dom.td(response(m)))), dom.br(), dom.div(dom.a('Download', attr.download('rejected-' + m.ID + '.eml'), attr.href(URL.createObjectURL(new Blob([data], { type: 'message/rfc822' }))))), dom.pre(style({ maxWidth: '80em', maxHeight: '40em', overflow: 'auto', whiteSpace: 'pre-wrap' }), data));
+	};
+	return dom.div(crumbs(crumblink('Mox Admin', '#'), 'Rejected messages'), dom.p('Incoming messages that were rejected with a permanent error after the DATA command, retained for domains with RejectedRetention configured. Users do not see these messages. After adjusting the policy that caused a false positive, a message can be delivered to its recipients. Messages are removed automatically when they are older than the maximum age, or the maximum total size for the domain is reached.'), dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Received'), dom.th('Domain'), dom.th('Remote IP'), dom.th('MAIL FROM'), dom.th('RCPT TO'), dom.th('From'), dom.th('Subject'), dom.th('Size'), dom.th('Response'), dom.th('Delivered'), dom.th('Action'))), dom.tbody(msgs.length === 0 ? dom.tr(dom.td(attr.colspan('11'), 'No rejected messages.')) : [], msgs.map(m => dom.tr(dom.td(age(m.Received, false, nowSecs)), dom.td(m.Domain), dom.td(m.RemoteIP, attr.title('EHLO: ' + m.EHLO)), dom.td(m.MailFrom || '<>'), dom.td((m.RcptTo || []).join(', ')), dom.td(m.MsgFrom), dom.td(m.Subject), dom.td(formatSize(m.Size)), dom.td(response(m)), dom.td(m.Delivered.getTime() > 0 ? age(m.Delivered, false, nowSecs) : '-'), dom.td(dom.clickbutton('View', async function click() {
+		await viewPopup(m);
+	}), ' ', dom.clickbutton('Deliver', attr.title('Deliver the message to the accounts of the recipients, without the checks that caused the rejection.'), async function click(e) {
+		if (!window.confirm('Are you sure you want to deliver this message to its recipients?')) {
+			return;
+		}
+		const delivered = await check(e.target, client.RejectedDeliver(m.ID));
+		window.alert('Delivered to: ' + (delivered || []).join(', '));
+		window.location.reload(); // todo: reload just the list
+	}), ' ', dom.clickbutton('Remove', async function click(e) {
+		if (!window.confirm('Are you sure you want to remove this message?')) {
+			return;
+		}
+		await check(e.target, client.RejectedRemove([m.ID]));
+		window.location.reload(); // todo: reload just the list
+	})))))));
+};
 const loglevels = async () => {
 	const loglevels = await client.LogLevels();
 	const levels = ['error', 'info', 'warn', 'debug', 'trace', 'traceauth', 'tracedata'];
@@ -2059,7 +2510,7 @@ const accounts = async () => {
 	const [[accounts, accountsDisabled], domains, loginAttempts] = await Promise.all([
 		client.Accounts(),
 		client.Domains(),
-		client.LoginAttempts("", 10),
+		tenant ? Promise.resolve([]) : client.LoginAttempts("", 10),
 	]);
 	let fieldset;
 	let localpart;
@@ -2078,11 +2529,32 @@ const accounts = async () => {
 		}
 	})), '@', dom.label(style({ display: 'inline-block' }), dom.span('Domain', attr.title('The domain of the email address, after the "@".')), dom.br(), domain = dom.select(attr.required(''), (domains || []).map(d => dom.option(domainName(d.Domain))))), ' ', dom.label(style({ display: 'inline-block' }), dom.span('Account name', attr.title('An account has a password, and email address(es) (possibly at different domains). Its messages and the message index database are are stored in the file system in a directory with the name of the account. An account name is not an email address. Use a name like a unix user name, or the localpart (the part before the "@") of the initial address.')), dom.br(), account = dom.input(attr.required(''), function change() {
 		accountModified = true;
-	})), ' ', dom.submitbutton('Add account', attr.title('The account will be added and the config reloaded.')))), dom.br(), dom.h2('Recent login attempts', attr.title('Login attempts are stored for 30 days. At most 10000 failed login attempts are stored per account to prevent unlimited growth of the database.')), renderLoginAttempts(true, loginAttempts || []), dom.br(), loginAttempts && loginAttempts.length >= 10 ? dom.p('See ', dom.a(attr.href('#accounts/loginattempts'), 'all login attempts'), '.') : []);
+	})), ' ', dom.submitbutton('Add account', attr.title('The account will be added and the config reloaded.')))), dom.br(), tenant ? [] : [
+			dom.h2('Recent login attempts', attr.title('Login attempts are stored for 30 days. At most 10000 failed login attempts are stored per account to prevent unlimited growth of the database.')),
+			renderLoginAttempts(true, loginAttempts || []),
+		], dom.br(), loginAttempts && loginAttempts.length >= 10 ? dom.p('See ', dom.a(attr.href('#accounts/loginattempts'), 'all login attempts'), '.') : []);
 };
 const loginattempts = async () => {
-	const loginAttempts = await client.LoginAttempts("", 0);
-	return dom.div(crumbs(crumblink('Mox Admin', '#'), crumblink('Accounts', '#accounts'), 'Login attempts'), dom.h2('Login attempts'), dom.p('Login attempts are stored for 30 days. At most 10000 failed login attempts are stored per account to prevent unlimited growth of the database.'), renderLoginAttempts(true, loginAttempts || []));
+	const [loginAttempts, lockouts] = await Promise.all([
+		client.LoginAttempts("", 0),
+		client.AuthLockouts(),
+	]);
+	return dom.div(crumbs(crumblink('Mox Admin', '#'), crumblink('Accounts', '#accounts'), 'Login attempts'), dom.h2('Authentication lockouts'), dom.p('IPs and accounts with recent failed authentication attempts. Only tracked when AuthLockout is configured in mox.conf. Locked out IPs and accounts cannot authenticate until the lockout expires or is cleared. IPv6 addresses are tracked per /64 network.'), renderAuthLockouts(lockouts || []), dom.br(), dom.h2('Login attempts'), dom.p('Login attempts are stored for 30 days. At most 10000 failed login attempts are stored per account to prevent unlimited growth of the database.'), renderLoginAttempts(true, loginAttempts || []));
+};
+const renderAuthLockouts = (lockouts) => {
+	const nowSecs = new Date().getTime() / 1000;
+	const clear = async (btn, ipOrAccount) => {
+		await check(btn, client.AuthLockoutClear(ipOrAccount));
+		window.location.reload(); // todo: reload just the list
+	};
+	return dom.div(dom.table(dom.thead(dom.tr(dom.th('IP or account'), dom.th('Failures'), dom.th('Last failure'), dom.th('Locked until'), dom.th('Action'))), dom.tbody(lockouts.length ? [] : dom.tr(dom.td(attr.colspan('5'), 'No recent failed authentication attempts.')), lockouts.map(lo => dom.tr(dom.td(lo.IP || lo.Account), dom.td('' + lo.Failures), dom.td(age(lo.Last, false, nowSecs)), dom.td(lo.Until.getTime() / 1000 > nowSecs ? box(red, age(lo.Until, true, nowSecs)) : '-'), dom.td(dom.clickbutton('Clear', async function click(e) {
+		await clear(e.target, lo.IP || lo.Account);
+	})))))), lockouts.length ? dom.div(dom.br(), dom.clickbutton('Clear all', async function click(e) {
+		if (!window.confirm('Are you sure you want to clear all failed authentication attempts and lockouts?')) {
+			return;
+		}
+		await clear(e.target, '');
+	})) : []);
 };
 const accountloginattempts = async (accountName) => {
 	const loginAttempts = await client.LoginAttempts(accountName, 0);
@@ -2173,7 +2645,7 @@ const account = async (name) => {
 	const [[config, diskUsage], domains, transports, tlspubkeys, loginAttempts] = await Promise.all([
 		client.Account(name),
 		client.Domains(),
-		client.Transports(),
+		tenant ? Promise.resolve({}) : client.Transports(),
 		client.TLSPublicKeys(name),
 		client.LoginAttempts(name, 10),
 	]);
@@ -2279,7 +2751,10 @@ const account = async (name) => {
 	}), dom.br(), dom.h2('TLS public keys', attr.title('For TLS client authentication with certificates, for IMAP and/or submission (SMTP). Only the public key of the certificate is used during TLS authentication, to identify this account. Names, expiration or constraints are not verified.')), dom.table(dom.thead(dom.tr(dom.th('Login address'), dom.th('Name'), dom.th('Type'), dom.th('No IMAP "preauth"', attr.title('New IMAP immediate TLS connections authenticated with a client certificate are automatically switched to "authenticated" state with an untagged IMAP "preauth" message by default. IMAP connections have a state machine specifying when commands are allowed. Authenticating is not allowed while in the "authenticated" state. Enable this option to work around clients that would try to authenticated anyway.')), dom.th('Fingerprint'))), dom.tbody(tlspubkeys?.length ? [] : dom.tr(dom.td(attr.colspan('5'), 'None')), (tlspubkeys || []).map(tpk => {
 		const row = dom.tr(dom.td(tpk.LoginAddress), dom.td(tpk.Name), dom.td(tpk.Type), dom.td(tpk.NoIMAPPreauth ? 'Enabled' : ''), dom.td(tpk.Fingerprint));
 		return row;
-	}))), dom.br(), RoutesEditor('account-specific', transports, config.Routes || [], async (routes) => await client.AccountRoutesSave(name, routes)), dom.br(), dom.h2('Danger'), dom.div(config.LoginDisabled ? [
+	}))), dom.br(), tenant ? [] : [
+			RoutesEditor('account-specific', transports, config.Routes || [], async (routes) => await client.AccountRoutesSave(name, routes)),
+			dom.br(),
+		], dom.h2('Danger'), dom.div(config.LoginDisabled ? [
 		box(yellow, 'Account login is currently disabled.'),
 		dom.clickbutton('Enable account login', async function click(e) {
 			if (window.confirm('Are you sure you want to enable login to this account?')) {
@@ -2369,7 +2844,7 @@ const domain = async (d) => {
 		client.ClientConfigsDomain(d),
 		client.Accounts(),
 		client.DomainConfig(d),
-		client.Transports(),
+		tenant ? Promise.resolve({}) : client.Transports(),
 	]);
 	const dnsdomain = domainConfig.Domain;
 	let addrForm;
@@ -2473,7 +2948,10 @@ const domain = async (d) => {
 	}, aliasFieldset = dom.fieldset(style({ display: 'flex', alignItems: 'flex-start', gap: '1em' }), dom.label(dom.div('Localpart', attr.title('The localpart is the part before the "@"-sign of an address.')), aliasLocalpart = dom.input(attr.required('')), '@', domainName(dnsdomain), ' '), dom.label(dom.div('Addresses', attr.title('One members address per line, full address of form localpart@domain. At least one address required.')), aliasAddresses = dom.textarea(attr.required(''), attr.rows('1'), function focus() {
 		aliasAddresses.setAttribute('rows', '5');
 		aliasAddText.style.visibility = 'visible';
-	})), dom.div(dom.div('\u00a0'), dom.submitbutton('Add alias', attr.title('Alias will be added and the config reloaded.')), aliasAddText = dom.p(style({ visibility: 'hidden', fontStyle: 'italic' }), 'Messages sent to aliases are delivered to each member address of the alias, like a mailing list. For an additional address for an account, add it as regular address (see above).')))), dom.br(), RoutesEditor('domain-specific', transports, domainConfig.Routes || [], async (routes) => await client.DomainRoutesSave(d, routes)), dom.br(), dom.h2('Settings'), dom.form(async function submit(e) {
+	})), dom.div(dom.div('\u00a0'), dom.submitbutton('Add alias', attr.title('Alias will be added and the config reloaded.')), aliasAddText = dom.p(style({ visibility: 'hidden', fontStyle: 'italic' }), 'Messages sent to aliases are delivered to each member address of the alias, like a mailing list. For an additional address for an account, add it as regular address (see above).')))), dom.br(), tenant ? [] : [
+			RoutesEditor('domain-specific', transports, domainConfig.Routes || [], async (routes) => await client.DomainRoutesSave(d, routes)),
+			dom.br(),
+		], dom.h2('Settings'), dom.form(async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
 		await check(descrFieldset, client.DomainDescriptionSave(d, descrText.value));
@@ -2601,6 +3079,8 @@ const domain = async (d) => {
 		mtastsPolicyID.value = new Date().toISOString().replace(/-/g, '').replace(/:/g, '').split('.')[0];
 	})), mtastsPolicyID = dom.input(attr.value(domainConfig.MTASTS?.PolicyID || ''))), dom.label(attr.title("If set to \"enforce\", a remote SMTP server will not deliver email to us if it cannot make a WebPKI-verified SMTP STARTTLS connection. In mode \"testing\", deliveries can be done without verified TLS, but errors will be reported through TLS reporting. In mode \"none\", verified TLS is not required, used for phasing out an MTA-STS policy."), dom.div('Mode'), mtastsMode = dom.select(dom.option(''), Object.values(api.Mode).map(s => dom.option(s, domainConfig.MTASTS?.Mode === s ? attr.selected('') : [])))), dom.label(attr.title('How long a remote mail server is allowed to cache a policy. Typically 1 or several weeks. Units: s for seconds, m for minutes, h for hours, d for day, w for weeks.'), dom.div('Max age'), mtastsMaxAge = dom.input(attr.value(domainConfig.MTASTS?.MaxAge ? formatDuration(domainConfig.MTASTS?.MaxAge || 0) : ''))), dom.label(attr.title('List of server names allowed for SMTP. If empty, the configured hostname is set. Host names can contain a wildcard (*) as a leading label (matching a single label, e.g. *.example matches host.example, not sub.host.example).'), dom.div('MX hosts/patterns (optional)'), mtastsMX = dom.textarea(new String((domainConfig.MTASTS?.MX || []).join('\n')), attr.rows('' + Math.max(2, 1 + (domainConfig.MTASTS?.MX || []).length)))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save'))))), dom.br(), dom.h2('DKIM', attr.title('With DKIM signing, a domain is taking responsibility for (content of) emails it sends, letting receiving mail servers build up a (hopefully positive) reputation of the domain, which can help with mail delivery.')), (() => {
 		let fieldset;
+		let signMailFrom;
+		let signHostname;
 		let rows = [];
 		return dom.form(async function submit(e) {
 			e.preventDefault();
@@ -2618,7 +3098,7 @@ const domain = async (d) => {
 					sign.push(selName);
 				}
 			}
-			await check(fieldset, client.DomainDKIMSave(d, selectors, sign));
+			await check(fieldset, client.DomainDKIMSave(d, selectors, sign, signMailFrom.checked, signHostname.checked));
 			window.alert("Don't forget to update DNS records if needed. See suggested DNS records.");
 		}, fieldset = dom.fieldset(dom.table(dom.thead(dom.tr(dom.th('Selector', attr.title('Used in the DKIM-Signature header, and used to form a DNS record under ._domainkey.<domain>.')), dom.th('Enabled', attr.title('Whether a DKIM-Signature is added to messages for this message. Multiple selectors can be enabled. Having backup keys published in DNS can be useful for quickly rotating a key.')), dom.th('Algorithm', attr.title('For signing messages. RSA is common at the time of writing, not all mail servers recognize ed25519 signature.')), dom.th('Hash', attr.title("Used in signing messages. Don't use sha1 unless you understand the consequences.")), dom.th('Canonicalization header/body', attr.colspan('2'), attr.title('Canonicalization processes the message headers and bodies before signing. Relaxed allows more whitespace changes, making it more likely for DKIM signatures to validate after transit through servers that make whitespace modifications. Simple is more strict.')), dom.th('Seal headers', attr.title("DKIM-signatures cover headers. If headers are not sealed, additional message headers can be added with the same key without invalidating the signature. This may confuse software about which headers are trustworthy. Sealing is the safer option.")), dom.th('Headers', attr.title('Headers to sign.')), dom.th('Signature lifetime', attr.title('How long a signature remains valid. Should be as long as a message may take to be delivered. The signature must be valid at the time a message is being delivered to the final destination.')), dom.th('Action'))), dom.tbody(Object.keys(domainConfig.DKIM.Selectors || []).length === 0 ? dom.tr(dom.td(attr.colspan('9'), 'No DKIM keys/selectors.')) : [], rows = Object.entries(domainConfig.DKIM.Selectors || []).sort().map(([selName, sel]) => {
 			let enabled;
@@ -2661,7 +3141,7 @@ const domain = async (d) => {
 			};
 		})), dom.tfoot(dom.tr(dom.td(attr.colspan('9'), dom.submitbutton('Save'), ' ', dom.clickbutton('Add key/selector', function click() {
 			popupDKIMAdd();
-		})))))));
+		}))))), dom.div(style({ marginTop: '1ex' }), dom.label(attr.title('If a message with a From address in this domain is submitted with an SMTP MAIL FROM address in another domain configured on this server, also sign the message with the DKIM keys of the MAIL FROM domain.'), signMailFrom = dom.input(attr.type('checkbox'), domainConfig.DKIM.SignMailFrom ? attr.checked('') : []), ' Also sign submitted messages for SMTP MAIL FROM domain')), dom.div(dom.label(attr.title('Also sign messages submitted with a From address in this domain with the DKIM keys of the domain of the hostname of this mail server, as additional signature by the sending host. The hostname domain, or a parent domain, must be configured with DKIM keys.'), signHostname = dom.input(attr.type('checkbox'), domainConfig.DKIM.SignHostname ? attr.checked('') : []), ' Also sign submitted messages for hostname domain'))));
 	})(), dom.br(), dom.h2('External checks'), dom.ul(dom.li(link('https://internet.nl/mail/' + dnsdomain.ASCII + '/', 'Check configuration at internet.nl'))), dom.br(), dom.h2('Danger'), dom.div(domainConfig.Disabled ? [
 		box(yellow, 'Domain is currently disabled.'),
 		dom.clickbutton('Enable domain', async function click(e) {
@@ -2797,6 +3277,10 @@ const domainDNSCheck = async (d) => {
 		!checks.MTASTS.TXT ? [] : dom.div('MTA-STS record: ' + checks.MTASTS.TXT),
 		!checks.MTASTS.PolicyText ? [] : dom.div('MTA-STS policy: ', dom.pre(dom._class('literal'), style({ maxWidth: '60em' }), checks.MTASTS.PolicyText)),
 	];
+	const detailsBIMI = !checks.BIMI.TXT ? [] : [
+		dom.div('Domain: ' + checks.BIMI.Domain),
+		dom.div('TXT record: ' + checks.BIMI.TXT),
+	];
 	const detailsSRVConf = !checks.SRVConf.SRVs || Object.keys(checks.SRVConf.SRVs).length === 0 ? [] : [
 		dom.table(dom.thead(dom.tr(dom.th('Service'), dom.th('Priority'), dom.th('Weight'), dom.th('Port'), dom.th('Host'))), dom.tbody(Object.entries(checks.SRVConf.SRVs || []).map(t => {
 			const l = t[1];
@@ -2813,7 +3297,7 @@ const domainDNSCheck = async (d) => {
 	const detailsAutodiscover = !checks.Autodiscover.Records ? [] : [
 		dom.table(dom.thead(dom.tr(dom.th('Host'), dom.th('Port'), dom.th('Priority'), dom.th('Weight'), dom.th('IPs'))), dom.tbody((checks.Autodiscover.Records || []).map(r => dom.tr([r.Target, r.Port, r.Priority, r.Weight, (r.IPs || []).join(', ')].map(s => dom.td('' + s)))))),
 	];
-	return dom.div(crumbs(crumblink('Mox Admin', '#'), crumblink('Domain ' + domainString(dnsdomain), '#domains/' + d), 'Check DNS'), dom.h1('DNS records and domain configuration check'), resultSection('DNSSEC', checks.DNSSEC, detailsDNSSEC), resultSection('IPRev', checks.IPRev, detailsIPRev), resultSection('MX', checks.MX, detailsMX), resultSection('TLS', checks.TLS, detailsTLS), resultSection('DANE', checks.DANE, detailsDANE), resultSection('SPF', checks.SPF, detailsSPF), resultSection('DKIM', checks.DKIM, detailsDKIM), resultSection('DMARC', checks.DMARC, detailsDMARC), resultSection('Host TLSRPT', checks.HostTLSRPT, detailsTLSRPT(checks.HostTLSRPT)), resultSection('Domain TLSRPT', checks.DomainTLSRPT, detailsTLSRPT(checks.DomainTLSRPT)), resultSection('MTA-STS', checks.MTASTS, detailsMTASTS), resultSection('SRV conf', checks.SRVConf, detailsSRVConf), resultSection('Autoconf', checks.Autoconf, detailsAutoconf), resultSection('Autodiscover', checks.Autodiscover, detailsAutodiscover), dom.br());
+	return dom.div(crumbs(crumblink('Mox Admin', '#'), crumblink('Domain ' + domainString(dnsdomain), '#domains/' + d), 'Check DNS'), dom.h1('DNS records and domain configuration check'), resultSection('DNSSEC', checks.DNSSEC, detailsDNSSEC), resultSection('IPRev', checks.IPRev, detailsIPRev), resultSection('MX', checks.MX, detailsMX), resultSection('TLS', checks.TLS, detailsTLS), resultSection('DANE', checks.DANE, detailsDANE), resultSection('SPF', checks.SPF, detailsSPF), resultSection('DKIM', checks.DKIM, detailsDKIM), resultSection('DMARC', checks.DMARC, detailsDMARC), resultSection('Host TLSRPT', checks.HostTLSRPT, detailsTLSRPT(checks.HostTLSRPT)), resultSection('Domain TLSRPT', checks.DomainTLSRPT, detailsTLSRPT(checks.DomainTLSRPT)), resultSection('MTA-STS', checks.MTASTS, detailsMTASTS), resultSection('BIMI', checks.BIMI, detailsBIMI), resultSection('SRV conf', checks.SRVConf, detailsSRVConf), resultSection('Autoconf', checks.Autoconf, detailsAutoconf), resultSection('Autodiscover', checks.Autodiscover, detailsAutodiscover), dom.br());
 };
 const dmarcIndex = async () => {
 	return dom.div(crumbs(crumblink('Mox Admin', '#'), 'DMARC'), dom.ul(dom.li(dom.a(attr.href('#dmarc/reports'), 'Reports'), ', incoming DMARC aggregate reports.'), dom.li(dom.a(attr.href('#dmarc/evaluations'), 'Evaluations'), ', for outgoing DMARC aggregate reports.')));
@@ -3289,6 +3773,12 @@ const dnsbl = async () => {
 		dnsbl(); // Render page again.
 	}, fieldset = dom.fieldset(dom.div('One per line'), dom.div(style({ marginBottom: '.5ex' }), monitorTextarea = dom.textarea(style({ width: '20rem' }), attr.rows('' + Math.max(5, 1 + (monitorZones || []).length)), new String((monitorZones || []).map(zone => domainName(zone)).join('\n'))), dom.div('Examples: sbl.spamhaus.org or bl.spamcop.net')), dom.div(dom.submitbutton('Save')))));
 };
+// msgResultRows returns table rows for a delivery result of a queued or retired
+// message, with the hosts tried during the delivery attempt.
+const msgResultRows = (r, nowSecs) => [
+	dom.tr(dom.td(age(r.Start, false, nowSecs)), dom.td(Math.round(r.Duration / 1000000) + 'ms'), dom.td(r.Success ? '✓' : ''), dom.td('' + (r.Code || '')), dom.td(r.Secode), dom.td(r.Error)),
+	(r.Hosts || []).length === 0 ? [] : dom.tr(dom.td(attr.colspan('6'), style({ paddingLeft: '2em' }), dom.table(dom.thead(dom.tr(dom.th('Host'), dom.th('IP'), dom.th('Duration'), dom.th('Success'), dom.th('Code'), dom.th('Secode'), dom.th('TLS', attr.title('Whether TLS was used, the TLS mode with verification mechanisms, and TLS failures as reported in TLS reports.')), dom.th('Error'))), dom.tbody((r.Hosts || []).map(h => dom.tr(dom.td(h.Host), dom.td(h.IP), dom.td(Math.round(h.Duration / 1000000) + 'ms'), dom.td(h.Success ? '✓' : ''), dom.td('' + (h.Code || '')), dom.td(h.Secode), dom.td((h.TLS ? 'yes' : 'no') + (h.TLSMode ? ', ' + h.TLSMode : '') + ((h.TLSFailures || []).length > 0 ? ', failures: ' + (h.TLSFailures || []).join(', ') : '')), dom.td(h.Error))))))),
+];
 const queueList = async () => {
 	let filter = { Max: parseInt(localStorageGet('adminpaginationsize') || '') || 100, IDs: [], Account: '', From: '', To: '', Hold: null, Submitted: '', NextAttempt: '', Transport: null };
 	let sort = { Field: "NextAttempt", LastID: 0, Last: null, Asc: true };
@@ -3346,7 +3836,10 @@ const queueList = async () => {
 	};
 	const popupDetails = (m) => {
 		const nowSecs = new Date().getTime() / 1000;
-		popup(dom.h1('Details'), dom.table(dom.tr(dom.td('Message subject'), dom.td(m.Subject))), dom.br(), dom.h2('Results'), dom.table(dom.thead(dom.tr(dom.th('Start'), dom.th('Duration'), dom.th('Success'), dom.th('Code'), dom.th('Secode'), dom.th('Error'))), dom.tbody((m.Results || []).length === 0 ? dom.tr(dom.td(attr.colspan('6'), 'No results.')) : [], (m.Results || []).map(r => dom.tr(dom.td(age(r.Start, false, nowSecs)), dom.td(Math.round(r.Duration / 1000000) + 'ms'), dom.td(r.Success ? '✓' : ''), dom.td('' + (r.Code || '')), dom.td(r.Secode), dom.td(r.Error))))));
+		popup(dom.h1('Details'), dom.table(dom.tr(dom.td('Message subject'), dom.td(m.Subject)), m.HoldReason ? dom.tr(dom.td('Held for review'), dom.td(m.HoldReason)) : []), dom.br(), dom.clickbutton('View message', attr.title('Show the message source, e.g. to review a message held for review before releasing or failing it. At most 1MB is shown.'), async function click(e) {
+			const src = await check(e.target, client.QueueMessageSource(m.ID));
+			popup(dom.h1('Message'), dom.pre(dom._class('literal'), src));
+		}), dom.br(), dom.h2('Results'), dom.table(dom.thead(dom.tr(dom.th('Start'), dom.th('Duration'), dom.th('Success'), dom.th('Code'), dom.th('Secode'), dom.th('Error'))), dom.tbody((m.Results || []).length === 0 ? dom.tr(dom.td(attr.colspan('6'), 'No results.')) : [], (m.Results || []).map(r => msgResultRows(r, nowSecs)))));
 	};
 	let tbody = dom.tbody();
 	const render = () => {
@@ -3357,7 +3850,7 @@ const queueList = async () => {
 		const ntbody = dom.tbody(dom._class('loadend'), msgs.length === 0 ? dom.tr(dom.td(attr.colspan('15'), 'No messages.')) : [], msgs.map(m => {
 			return dom.tr(dom.td(toggles.get(m.ID)), dom.td('' + m.ID + (m.BaseID > 0 ? '/' + m.BaseID : '')), dom.td(age(new Date(m.Queued), false, nowSecs)), dom.td(m.SenderAccount || '-'), dom.td(prewrap(m.SenderLocalpart, "@", ipdomainString(m.SenderDomain))), // todo: escaping of localpart
 			dom.td(prewrap(m.RecipientLocalpart, "@", ipdomainString(m.RecipientDomain))), // todo: escaping of localpart
-			dom.td(formatSize(m.Size)), dom.td('' + m.Attempts), dom.td(m.Hold ? 'Hold' : ''), dom.td(age(new Date(m.NextAttempt), true, nowSecs)), dom.td(m.LastAttempt ? age(new Date(m.LastAttempt), false, nowSecs) : '-'), dom.td(m.Results && m.Results.length > 0 ? m.Results[m.Results.length - 1].Error : []), dom.td(m.Transport || '(default)'), dom.td(m.RequireTLS === true ? 'Yes' : (m.RequireTLS === false ? 'No' : '')), dom.td(dom.clickbutton('Details', function click() {
+			dom.td(formatSize(m.Size)), dom.td('' + m.Attempts), dom.td(m.Hold ? (m.HoldReason ? dom.span('Review', attr.title('Held for review: ' + m.HoldReason)) : 'Hold') : ''), dom.td(age(new Date(m.NextAttempt), true, nowSecs)), dom.td(m.LastAttempt ? age(new Date(m.LastAttempt), false, nowSecs) : '-'), dom.td(m.Results && m.Results.length > 0 ? m.Results[m.Results.length - 1].Error : []), dom.td(m.Transport || '(default)'), dom.td(m.RequireVerifiedTLS ? 'Verified' : (m.RequireTLS === true ? 'Yes' : (m.RequireTLS === false ? 'No' : ''))), dom.td(dom.clickbutton('Details', function click() {
 				popupDetails(m);
 			})));
 		}));
@@ -3539,13 +4032,13 @@ const retiredList = async () => {
 	let filterSuccess;
 	const popupDetails = (m) => {
 		const nowSecs = new Date().getTime() / 1000;
-		popup(dom.h1('Details'), dom.table(dom.tr(dom.td('Message subject'), dom.td(m.Subject))), dom.br(), dom.h2('Results'), dom.table(dom.thead(dom.tr(dom.th('Start'), dom.th('Duration'), dom.th('Success'), dom.th('Code'), dom.th('Secode'), dom.th('Error'))), dom.tbody((m.Results || []).length === 0 ? dom.tr(dom.td(attr.colspan('6'), 'No results.')) : [], (m.Results || []).map(r => dom.tr(dom.td(age(r.Start, false, nowSecs)), dom.td(Math.round(r.Duration / 1000000) + 'ms'), dom.td(r.Success ? '✓' : ''), dom.td('' + (r.Code || '')), dom.td(r.Secode), dom.td(r.Error))))));
+		popup(dom.h1('Details'), dom.table(dom.tr(dom.td('Message subject'), dom.td(m.Subject))), dom.br(), dom.h2('Results'), dom.table(dom.thead(dom.tr(dom.th('Start'), dom.th('Duration'), dom.th('Success'), dom.th('Code'), dom.th('Secode'), dom.th('Error'))), dom.tbody((m.Results || []).length === 0 ? dom.tr(dom.td(attr.colspan('6'), 'No results.')) : [], (m.Results || []).map(r => msgResultRows(r, nowSecs)))));
 	};
 	let tbody = dom.tbody();
 	const render = () => {
 		const ntbody = dom.tbody(dom._class('loadend'), retired.length === 0 ? dom.tr(dom.td(attr.colspan('14'), 'No retired messages.')) : [], retired.map(m => dom.tr(dom.td('' + m.ID + (m.BaseID > 0 ? '/' + m.BaseID : '')), dom.td(m.Success ? '✓' : ''), dom.td(age(new Date(m.LastActivity), false, nowSecs)), dom.td(age(new Date(m.Queued), false, nowSecs)), dom.td(m.SenderAccount || '-'), dom.td(prewrap(m.SenderLocalpart, "@", m.SenderDomainStr)), // todo: escaping of localpart
 		dom.td(prewrap(m.RecipientLocalpart, "@", m.RecipientDomainStr)), // todo: escaping of localpart
-		dom.td(formatSize(m.Size)), dom.td('' + m.Attempts), dom.td(m.LastAttempt ? age(new Date(m.LastAttempt), false, nowSecs) : '-'), dom.td(m.Results && m.Results.length > 0 ? m.Results[m.Results.length - 1].Error : []), dom.td(m.Transport || ''), dom.td(m.RequireTLS === true ? 'Yes' : (m.RequireTLS === false ? 'No' : '')), dom.td(dom.clickbutton('Details', function click() {
+		dom.td(formatSize(m.Size)), dom.td('' + m.Attempts), dom.td(m.LastAttempt ? age(new Date(m.LastAttempt), false, nowSecs) : '-'), dom.td(m.Results && m.Results.length > 0 ? m.Results[m.Results.length - 1].Error : []), dom.td(m.Transport || ''), dom.td(m.RequireVerifiedTLS ? 'Verified' : (m.RequireTLS === true ? 'Yes' : (m.RequireTLS === false ? 'No' : ''))), dom.td(dom.clickbutton('Details', function click() {
 			popupDetails(m);
 		})))));
 		tbody.replaceWith(ntbody);
@@ -4165,6 +4658,7 @@ const webserver = async () => {
 const init = async () => {
 	let curhash;
 	[moxversion, moxgoos, moxgoarch] = await client.Version();
+	tenant = await client.Tenant();
 	const hashChange = async () => {
 		if (curhash === window.location.hash) {
 			return;
@@ -4186,6 +4680,18 @@ const init = async () => {
 			else if (h === 'loglevels') {
 				root = await loglevels();
 			}
+			else if (h === 'webauthn') {
+				root = await webauthnCredentials();
+			}
+			else if (h === 'sessions') {
+				root = await sessions();
+			}
+			else if (h === 'tls') {
+				root = await tlsCertificates();
+			}
+			else if (h === 'rejected') {
+				root = await rejectedList();
+			}
 			else if (h === 'accounts') {
 				root = await accounts();
 			}
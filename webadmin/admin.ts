@@ -8,13 +8,31 @@ declare let moxBeforeDisplay: (webmailroot: HTMLElement) => void
 let moxversion: string
 let moxgoos: string
 let moxgoarch: string
+let tenant: string // Set for sessions of tenant admins, which can only manage domains and accounts of the tenant.
 
 const login = async (reason: string) => {
 	return new Promise<string>((resolve: (v: string) => void, _) => {
 		const origFocus = document.activeElement
 		let reasonElem: HTMLElement
 		let fieldset: HTMLFieldSetElement
+		let tenantName: HTMLInputElement
 		let password: HTMLInputElement
+		let totpLabel: HTMLElement
+		let totp: HTMLInputElement
+
+		const loggedIn = (token: string) => {
+			try {
+				window.localStorage.setItem('webadmincsrftoken', token)
+			} catch (err) {
+				console.log('saving csrf token in localStorage', err)
+			}
+			root.remove()
+			if (origFocus && origFocus instanceof HTMLElement && origFocus.parentNode) {
+				origFocus.focus()
+			}
+			resolve(token)
+		}
+
 		const root = dom.div(
 			style({position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: '1', animation: 'fadein .15s ease-in'}),
 			dom.div(
@@ -32,19 +50,18 @@ const login = async (reason: string) => {
 							try {
 								fieldset.disabled = true
 								const loginToken = await client.LoginPrep()
-								const token = await client.Login(loginToken, password.value)
-								try {
-									window.localStorage.setItem('webadmincsrftoken', token)
-								} catch (err) {
-									console.log('saving csrf token in localStorage', err)
-								}
-								root.remove()
-								if (origFocus && origFocus instanceof HTMLElement && origFocus.parentNode) {
-									origFocus.focus()
-								}
-								resolve(token)
+								const token = tenantName.value ? await client.LoginTenant(loginToken, tenantName.value, password.value) : await client.Login(loginToken, password.value, totp.value)
+								loggedIn(token)
 							} catch (err) {
 								console.log('login error', err)
+								if ((err as any).code === 'user:totpRequired') {
+									// Password was correct, ask for two-factor authentication code and retry.
+									totpLabel.style.display = 'block'
+									totp.required = true
+									fieldset.disabled = false
+									totp.focus()
+									return
+								}
 								window.alert('Error: ' + errmsg(err))
 							} finally {
 								fieldset.disabled = false
@@ -52,15 +69,55 @@ const login = async (reason: string) => {
 						},
 						fieldset=dom.fieldset(
 							dom.h1('Admin'),
+							dom.label(
+								style({display: 'block', marginBottom: '2ex'}),
+								dom.div('Tenant', style({marginBottom: '.5ex'}), attr.title('Only for admins of a tenant. Leave empty to login as admin.')),
+								tenantName=dom.input(attr.autocomplete('username'), attr.placeholder('(optional)')),
+							),
 							dom.label(
 								style({display: 'block', marginBottom: '2ex'}),
 								dom.div('Password', style({marginBottom: '.5ex'})),
 								password=dom.input(attr.type('password'), attr.autocomplete('current-password'), attr.required('')),
 							),
+							totpLabel=dom.label(
+								style({display: 'none', marginBottom: '2ex'}),
+								dom.div('Two-factor authentication code', style({marginBottom: '.5ex'})),
+								totp=dom.input(attr.autocomplete('one-time-code'), attr.title('Code from authenticator app, or a recovery code.')),
+							),
 							dom.div(
 								style({textAlign: 'center'}),
 								dom.submitbutton('Login'),
 							),
+							window.PublicKeyCredential ? dom.div(
+								style({textAlign: 'center', marginTop: '1ex'}),
+								dom.clickbutton('Login with security key', attr.title('Login with a security key or passkey registered for this host name, instead of the password.'), async function click() {
+									reasonElem.remove()
+									try {
+										fieldset.disabled = true
+										const loginToken = await client.LoginPrep()
+										const opts = await client.WebAuthnLoginStart()
+										const cred = await navigator.credentials.get({
+											publicKey: {
+												challenge: base64urlDecode(opts.Challenge),
+												rpId: opts.RPID,
+												allowCredentials: (opts.CredentialIDs || []).map(id => ({type: 'public-key', id: base64urlDecode(id)})),
+												userVerification: 'preferred',
+											},
+										}) as PublicKeyCredential | null
+										if (!cred) {
+											throw new Error('no credential')
+										}
+										const resp = cred.response as AuthenticatorAssertionResponse
+										const token = await client.LoginWebAuthn(loginToken, opts.Challenge, base64urlEncode(cred.rawId), base64urlEncode(resp.clientDataJSON), base64urlEncode(resp.authenticatorData), base64urlEncode(resp.signature))
+										loggedIn(token)
+									} catch (err) {
+										console.log('webauthn login error', err)
+										window.alert('Error: ' + errmsg(err))
+									} finally {
+										fieldset.disabled = false
+									}
+								}),
+							) : [],
 						),
 					)
 				)
@@ -71,6 +128,10 @@ const login = async (reason: string) => {
 	})
 }
 
+// Encode/decode binary data for WebAuthn, which the API passes as raw base64url.
+const base64urlEncode = (buf: ArrayBuffer) => btoa(String.fromCharCode(...new Uint8Array(buf))).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '')
+const base64urlDecode = (s: string) => Uint8Array.from(atob(s.replace(/-/g, '+').replace(/_/g, '/')), c => c.charCodeAt(0)).buffer
+
 // Popup shows kids in a centered div with white background on top of a
 // transparent overlay on top of the window. Clicking the overlay or hitting
 // Escape closes the popup. Scrollbars are automatically added to the div with
@@ -332,7 +393,30 @@ const formatSize = (n: number) => {
 	return n + ' bytes'
 }
 
+// tenantIndex is the index page for tenant admins, who can only manage the
+// domains and accounts of their tenant.
+const tenantIndex = async () => {
+	const domains = await client.Domains()
+
+	return dom.div(
+		crumbs('Mox Admin for tenant ' + tenant),
+		dom.p(
+			dom.a('Accounts', attr.href('#accounts')), dom.br(),
+		),
+		dom.h2('Domains'),
+		(domains || []).length === 0 ? box(red, 'No domains') :
+		dom.ul(
+			(domains || []).map(d => dom.li(dom.a(attr.href('#domains/'+domainName(d.Domain)), domainString(d.Domain)), d.Disabled ? ' (disabled)' : [])),
+		),
+		footer(),
+	)
+}
+
 const index = async () => {
+	if (tenant) {
+		return await tenantIndex()
+	}
+
 	const [domains, queueSize, hooksQueueSize, checkUpdatesEnabled, [accounts, accountsDisabled]] = await Promise.all([
 		client.Domains(),
 		client.QueueSize(),
@@ -415,6 +499,7 @@ const index = async () => {
 		dom.div(dom.a('DMARC evaluations', attr.href('#dmarc/evaluations'))),
 		dom.div(dom.a('TLS connection results', attr.href('#tlsrpt/results'))),
 		dom.div(dom.a('DNSBL', attr.href('#dnsbl'))),
+		dom.div(dom.a('Rejected messages', attr.href('#rejected'))),
 		dom.div(
 			style({marginTop: '.5ex'}),
 			dom.form(
@@ -440,6 +525,9 @@ const index = async () => {
 		dom.div(dom.a('Webserver', attr.href('#webserver'))),
 		dom.div(dom.a('Files', attr.href('#config'))),
 		dom.div(dom.a('Log levels', attr.href('#loglevels'))),
+		dom.div(dom.a('Security keys', attr.href('#webauthn'))),
+		dom.div(dom.a('Sessions', attr.href('#sessions'))),
+		dom.div(dom.a('TLS certificates', attr.href('#tls'))),
 		footer(),
 	)
 }
@@ -474,6 +562,384 @@ const config = async () => {
 	)
 }
 
+const webauthnCredentials = async () => {
+	const creds = await client.WebAuthnCredentials()
+	const nowSecs = new Date().getTime()/1000
+
+	let form: HTMLFormElement
+	let fieldset: HTMLFieldSetElement
+	let name: HTMLInputElement
+
+	return dom.div(
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'Security keys',
+		),
+		dom.p('Security keys and passkeys can be used to login to the admin web interface instead of the admin password. Credentials are only valid for the host name they were registered at, currently ', dom.b(location.hostname), '. If you lose access to your security keys, login with the admin password, or remove all credentials with "mox admin webauthn reset".'),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Name'),
+					dom.th('Host name'),
+					dom.th('Created'),
+					dom.th('Last used'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				creds.length === 0 ? dom.tr(dom.td(attr.colspan('5'), 'No security keys registered.')) : [],
+				creds.map(c =>
+					dom.tr(
+						dom.td(c.Name),
+						dom.td(c.RPID),
+						dom.td(age(c.Created, false, nowSecs)),
+						dom.td(c.LastUsed.getTime() > 0 ? age(c.LastUsed, false, nowSecs) : '-'),
+						dom.td(
+							dom.clickbutton('Remove', async function click(e: MouseEvent) {
+								if (!window.confirm('Are you sure you want to remove security key "' + c.Name + '"?')) {
+									return
+								}
+								await check(e.target! as HTMLButtonElement, client.WebAuthnCredentialRemove(c.ID))
+								window.location.reload() // todo: reload just the list
+							}),
+						),
+					)
+				),
+			),
+		),
+		dom.br(),
+		dom.h2('Register security key'),
+		window.PublicKeyCredential ? form=dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+				await check(fieldset, (async () => {
+					const opts = await client.WebAuthnRegisterStart()
+					const cred = await navigator.credentials.create({
+						publicKey: {
+							challenge: base64urlDecode(opts.Challenge),
+							rp: {id: opts.RPID, name: 'mox admin'},
+							user: {id: base64urlDecode(opts.UserID), name: 'admin', displayName: 'admin'},
+							pubKeyCredParams: (opts.Algorithms || []).map(alg => ({type: 'public-key', alg: alg})),
+							excludeCredentials: (opts.ExcludeCredentialIDs || []).map(id => ({type: 'public-key', id: base64urlDecode(id)})),
+							authenticatorSelection: {residentKey: 'preferred', userVerification: 'preferred'},
+							attestation: 'none',
+						},
+					}) as PublicKeyCredential | null
+					if (!cred) {
+						throw new Error('no credential')
+					}
+					const resp = cred.response as AuthenticatorAttestationResponse
+					await client.WebAuthnRegisterFinish(name.value, opts.Challenge, base64urlEncode(resp.clientDataJSON), base64urlEncode(resp.attestationObject))
+				})())
+				form.reset()
+				window.location.reload() // todo: reload just the list
+			},
+			fieldset=dom.fieldset(
+				dom.label(
+					'Name ',
+					name=dom.input(attr.required(''), attr.placeholder('e.g. "yubikey"')),
+				),
+				' ',
+				dom.submitbutton('Register'),
+			),
+		) : dom.p('This browser does not support security keys.'),
+	)
+}
+
+const sessions = async () => {
+	const [sessions0, currentID] = await client.Sessions()
+	const sessions = sessions0 || []
+	const nowSecs = new Date().getTime()/1000
+
+	return dom.div(
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'Sessions',
+		),
+		dom.p('Sessions of the admin web interface. Sessions expire after 12 hours of inactivity. At most 10 sessions are kept, the least recently used session is removed for a new login. Changing the admin password, with "mox setadminpassword", revokes all sessions.'),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Login'),
+					dom.th('Last used'),
+					dom.th('Remote IP'),
+					dom.th('User Agent'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				sessions.map(s =>
+					dom.tr(
+						dom.td(age(s.Created, false, nowSecs)),
+						dom.td(age(s.LastUsed, false, nowSecs)),
+						dom.td(s.RemoteIP),
+						dom.td(s.UserAgent),
+						dom.td(
+							s.ID === currentID ? 'Current session' :
+							dom.clickbutton('Revoke', async function click(e: MouseEvent) {
+								if (!window.confirm('Are you sure you want to revoke this session?')) {
+									return
+								}
+								await check(e.target! as HTMLButtonElement, client.SessionRevoke(s.ID))
+								window.location.reload() // todo: reload just the list
+							}),
+						),
+					)
+				),
+			),
+		),
+		dom.br(),
+		dom.clickbutton('Revoke all other sessions', sessions.length <= 1 ? attr.disabled('') : [], async function click(e: MouseEvent) {
+			if (!window.confirm('Are you sure you want to revoke all other sessions?')) {
+				return
+			}
+			await check(e.target! as HTMLButtonElement, client.SessionsRevokeOthers())
+			window.location.reload() // todo: reload just the list
+		}),
+	)
+}
+
+const tlsCertificates = async () => {
+	const certs = await client.TLSCertificates()
+	const nowSecs = new Date().getTime()/1000
+
+	const replacePopup = (c: api.TLSCertificate) => {
+		let fieldset: HTMLFieldSetElement
+		let certPEM: HTMLTextAreaElement
+		let keyPEM: HTMLTextAreaElement
+		const close = popup(
+			dom.h1('Replace certificate'),
+			dom.p('The certificate and private key files are replaced, and new TLS connections use the new certificate immediately. The files must be writable by mox.'),
+			dom.form(
+				async function submit(e: SubmitEvent) {
+					e.preventDefault()
+					e.stopPropagation()
+					await check(fieldset, client.TLSKeyCertReplace(c.Listener, c.Index, certPEM.value, keyPEM.value))
+					close()
+					window.location.reload() // todo: reload just the list
+				},
+				fieldset=dom.fieldset(
+					dom.label(
+						style({display: 'block', marginBottom: '1ex'}),
+						dom.div('Certificate chain, PEM-encoded, for ', dom.span(c.CertFile, style({fontFamily: 'monospace'}))),
+						certPEM=dom.textarea(attr.required(''), attr.rows('10'), style({width: '50em', fontFamily: 'monospace'})),
+					),
+					dom.label(
+						style({display: 'block', marginBottom: '1ex'}),
+						dom.div('Private key, PEM-encoded, for ', dom.span(c.KeyFile, style({fontFamily: 'monospace'}))),
+						keyPEM=dom.textarea(attr.required(''), attr.rows('5'), style({width: '50em', fontFamily: 'monospace'})),
+					),
+					dom.submitbutton('Replace'),
+				),
+			),
+		)
+		certPEM.focus()
+	}
+
+	const expiry = (c: api.TLSCertificate) => {
+		if (!c.Present) {
+			return dom.td('-')
+		}
+		const days = (c.NotAfter.getTime()/1000 - nowSecs)/(24*3600)
+		return dom.td(
+			days < 0 ? style({color: 'red'}) : (days < 14 ? style({color: 'orange'}) : []),
+			days < 0 ? ['expired ', age(c.NotAfter, false, nowSecs)] : ['in ', age(c.NotAfter, true, nowSecs)],
+		)
+	}
+
+	const status = (c: api.TLSCertificate) => {
+		const l: ElemArg[] = []
+		if (c.Error) {
+			l.push(dom.div(style({color: 'red'}), 'Error: ' + c.Error))
+		}
+		if (c.Renewing) {
+			l.push(dom.div('Renewal in progress...'))
+		}
+		if (c.LastError) {
+			l.push(dom.div(style({color: 'red'}), 'Last error ', age(c.LastErrorTime, false, nowSecs), ': ' + c.LastError))
+		}
+		if (c.NextAttempt.getTime()/1000 > nowSecs) {
+			l.push(dom.div('After failed requests, next attempt in ', age(c.NextAttempt, true, nowSecs)))
+		}
+		if (c.ACME && !c.Present) {
+			l.push(dom.div('Not yet requested, certificates are requested when first needed.'))
+		} else if (c.ACME && c.RenewAfter.getTime()/1000 < nowSecs) {
+			l.push(dom.div(style({color: 'orange'}), 'Renewal is due, see last error or logs if it does not succeed.'))
+		} else if (c.ACME) {
+			l.push(dom.div('Automatic renewal in ', age(c.RenewAfter, true, nowSecs)))
+		}
+		return l
+	}
+
+	const acmeCerts = certs.filter(c => c.ACME)
+	const fileCerts = certs.filter(c => !c.ACME)
+
+	return dom.div(
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'TLS certificates',
+		),
+		dom.h2('ACME'),
+		dom.p('Certificates requested automatically through ACME, e.g. from Let\'s Encrypt, and renewed before they expire. Certificates for RSA keys are only requested for TLS clients that do not support ECDSA.'),
+		dom.table(dom._class('hover'),
+			dom.thead(
+				dom.tr(
+					dom.th('Host name'),
+					dom.th('Key type'),
+					dom.th('Provider', attr.title('ACME provider, from the configuration file.')),
+					dom.th('Listeners'),
+					dom.th('Issuer'),
+					dom.th('Expires'),
+					dom.th('Status'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				acmeCerts.length === 0 ? dom.tr(dom.td(attr.colspan('8'), 'No certificates.')) : [],
+				acmeCerts.map(c =>
+					dom.tr(
+						dom.td(c.Hostname),
+						dom.td(c.KeyType),
+						dom.td(c.ACME),
+						dom.td((c.Listeners || []).join(', ')),
+						dom.td(c.Issuer, attr.title((c.DNSNames || []).join(', '))),
+						expiry(c),
+						dom.td(status(c)),
+						dom.td(
+							dom.clickbutton('Renew', c.Renewing ? attr.disabled('') : [], attr.title('Request new certificates now, for all key types of this host name, instead of waiting for automatic renewal. Keep rate limits of the ACME provider in mind.'), async function click(e: MouseEvent) {
+								await check(e.target! as HTMLButtonElement, client.TLSCertificateRenew(c.ACME, c.Hostname))
+								window.location.reload() // todo: reload just the list
+							}),
+						),
+					)
+				),
+			),
+		),
+		dom.br(),
+		dom.h2('Files'),
+		dom.p('Certificates from key and certificate files configured in mox.conf. These are not renewed automatically.'),
+		dom.table(dom._class('hover'),
+			dom.thead(
+				dom.tr(
+					dom.th('Listener'),
+					dom.th('Certificate file'),
+					dom.th('DNS names'),
+					dom.th('Issuer'),
+					dom.th('Expires'),
+					dom.th('Status'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				fileCerts.length === 0 ? dom.tr(dom.td(attr.colspan('7'), 'No certificates.')) : [],
+				fileCerts.map(c =>
+					dom.tr(
+						dom.td(c.Listener),
+						dom.td(c.CertFile),
+						dom.td((c.DNSNames || []).join(', ')),
+						dom.td(c.Issuer),
+						expiry(c),
+						dom.td(status(c)),
+						dom.td(
+							dom.clickbutton('Replace...', attr.title('Upload a new certificate and private key.'), function click() {
+								replacePopup(c)
+							}),
+						),
+					)
+				),
+			),
+		),
+	)
+}
+
+const rejectedList = async () => {
+	const msgs = await client.RejectedList('') || []
+	const nowSecs = new Date().getTime()/1000
+
+	const response = (m: api.Message) => ''+m.Code+' '+Math.floor(m.Code/100)+'.'+m.Secode+' '+m.Errmsg
+
+	const viewPopup = async (m: api.Message) => {
+		const [, data] = await client.RejectedMessage(m.ID)
+		popup(
+			dom.h1('Rejected message'),
+			dom.table(
+				dom.tr(dom.td('Received'), dom.td(m.Received.toString())),
+				dom.tr(dom.td('Remote IP'), dom.td(m.RemoteIP)),
+				dom.tr(dom.td('EHLO'), dom.td(m.EHLO)),
+				dom.tr(dom.td('MAIL FROM'), dom.td(m.MailFrom || '<>')),
+				dom.tr(dom.td('RCPT TO'), dom.td((m.RcptTo || []).join(', '))),
+				dom.tr(dom.td('Response'), dom.td(response(m))),
+			),
+			dom.br(),
+			dom.div(dom.a('Download', attr.download('rejected-'+m.ID+'.eml'), attr.href(URL.createObjectURL(new Blob([data], {type: 'message/rfc822'}))))),
+			dom.pre(style({maxWidth: '80em', maxHeight: '40em', overflow: 'auto', whiteSpace: 'pre-wrap'}), data),
+		)
+	}
+
+	return dom.div(
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'Rejected messages',
+		),
+		dom.p('Incoming messages that were rejected with a permanent error after the DATA command, retained for domains with RejectedRetention configured. Users do not see these messages. After adjusting the policy that caused a false positive, a message can be delivered to its recipients. Messages are removed automatically when they are older than the maximum age, or the maximum total size for the domain is reached.'),
+		dom.table(dom._class('hover'),
+			dom.thead(
+				dom.tr(
+					dom.th('Received'),
+					dom.th('Domain'),
+					dom.th('Remote IP'),
+					dom.th('MAIL FROM'),
+					dom.th('RCPT TO'),
+					dom.th('From'),
+					dom.th('Subject'),
+					dom.th('Size'),
+					dom.th('Response'),
+					dom.th('Delivered'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				msgs.length === 0 ? dom.tr(dom.td(attr.colspan('11'), 'No rejected messages.')) : [],
+				msgs.map(m =>
+					dom.tr(
+						dom.td(age(m.Received, false, nowSecs)),
+						dom.td(m.Domain),
+						dom.td(m.RemoteIP, attr.title('EHLO: ' + m.EHLO)),
+						dom.td(m.MailFrom || '<>'),
+						dom.td((m.RcptTo || []).join(', ')),
+						dom.td(m.MsgFrom),
+						dom.td(m.Subject),
+						dom.td(formatSize(m.Size)),
+						dom.td(response(m)),
+						dom.td(m.Delivered.getTime() > 0 ? age(m.Delivered, false, nowSecs) : '-'),
+						dom.td(
+							dom.clickbutton('View', async function click() {
+								await viewPopup(m)
+							}), ' ',
+							dom.clickbutton('Deliver', attr.title('Deliver the message to the accounts of the recipients, without the checks that caused the rejection.'), async function click(e: MouseEvent) {
+								if (!window.confirm('Are you sure you want to deliver this message to its recipients?')) {
+									return
+								}
+								const delivered = await check(e.target! as HTMLButtonElement, client.RejectedDeliver(m.ID))
+								window.alert('Delivered to: ' + (delivered || []).join(', '))
+								window.location.reload() // todo: reload just the list
+							}), ' ',
+							dom.clickbutton('Remove', async function click(e: MouseEvent) {
+								if (!window.confirm('Are you sure you want to remove this message?')) {
+									return
+								}
+								await check(e.target! as HTMLButtonElement, client.RejectedRemove([m.ID]))
+								window.location.reload() // todo: reload just the list
+							}),
+						),
+					)
+				),
+			),
+		),
+	)
+}
+
 const loglevels = async () => {
 	const loglevels = await client.LogLevels()
 
@@ -587,7 +1053,7 @@ const accounts = async () => {
 	const [[accounts, accountsDisabled], domains, loginAttempts] = await Promise.all([
 		client.Accounts(),
 		client.Domains(),
-		client.LoginAttempts("", 10),
+		tenant ? Promise.resolve([]) : client.LoginAttempts("", 10),
 	])
 
 	let fieldset: HTMLFieldSetElement
@@ -648,15 +1114,20 @@ const accounts = async () => {
 			)
 		),
 		dom.br(),
-		dom.h2('Recent login attempts', attr.title('Login attempts are stored for 30 days. At most 10000 failed login attempts are stored per account to prevent unlimited growth of the database.')),
-		renderLoginAttempts(true, loginAttempts || []),
+		tenant ? [] : [
+			dom.h2('Recent login attempts', attr.title('Login attempts are stored for 30 days. At most 10000 failed login attempts are stored per account to prevent unlimited growth of the database.')),
+			renderLoginAttempts(true, loginAttempts || []),
+		],
 		dom.br(),
 		loginAttempts && loginAttempts.length >= 10 ? dom.p('See ', dom.a(attr.href('#accounts/loginattempts'), 'all login attempts'), '.') : [],
 	)
 }
 
 const loginattempts = async () => {
-	const loginAttempts = await client.LoginAttempts("", 0)
+	const [loginAttempts, lockouts] = await Promise.all([
+		client.LoginAttempts("", 0),
+		client.AuthLockouts(),
+	])
 
 	return dom.div(
 		crumbs(
@@ -664,12 +1135,62 @@ const loginattempts = async () => {
 			crumblink('Accounts', '#accounts'),
 			'Login attempts',
 		),
+		dom.h2('Authentication lockouts'),
+		dom.p('IPs and accounts with recent failed authentication attempts. Only tracked when AuthLockout is configured in mox.conf. Locked out IPs and accounts cannot authenticate until the lockout expires or is cleared. IPv6 addresses are tracked per /64 network.'),
+		renderAuthLockouts(lockouts || []),
+		dom.br(),
 		dom.h2('Login attempts'),
 		dom.p('Login attempts are stored for 30 days. At most 10000 failed login attempts are stored per account to prevent unlimited growth of the database.'),
 		renderLoginAttempts(true, loginAttempts || [])
 	)
 }
 
+const renderAuthLockouts = (lockouts: api.AuthLockout[]) => {
+	const nowSecs = new Date().getTime()/1000
+	const clear = async (btn: HTMLButtonElement, ipOrAccount: string) => {
+		await check(btn, client.AuthLockoutClear(ipOrAccount))
+		window.location.reload() // todo: reload just the list
+	}
+	return dom.div(
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('IP or account'),
+					dom.th('Failures'),
+					dom.th('Last failure'),
+					dom.th('Locked until'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				lockouts.length ? [] : dom.tr(dom.td(attr.colspan('5'), 'No recent failed authentication attempts.')),
+				lockouts.map(lo =>
+					dom.tr(
+						dom.td(lo.IP || lo.Account),
+						dom.td(''+lo.Failures),
+						dom.td(age(lo.Last, false, nowSecs)),
+						dom.td(lo.Until.getTime()/1000 > nowSecs ? box(red, age(lo.Until, true, nowSecs)) : '-'),
+						dom.td(
+							dom.clickbutton('Clear', async function click(e: MouseEvent) {
+								await clear(e.target! as HTMLButtonElement, lo.IP || lo.Account)
+							}),
+						),
+					)
+				),
+			),
+		),
+		lockouts.length ? dom.div(
+			dom.br(),
+			dom.clickbutton('Clear all', async function click(e: MouseEvent) {
+				if (!window.confirm('Are you sure you want to clear all failed authentication attempts and lockouts?')) {
+					return
+				}
+				await clear(e.target! as HTMLButtonElement, '')
+			}),
+		) : [],
+	)
+}
+
 const accountloginattempts = async (accountName: string) => {
 	const loginAttempts = await client.LoginAttempts(accountName, 0)
 
@@ -853,7 +1374,7 @@ const account = async (name: string) => {
 	const [[config, diskUsage], domains, transports, tlspubkeys, loginAttempts] = await Promise.all([
 		client.Account(name),
 		client.Domains(),
-		client.Transports(),
+		tenant ? Promise.resolve({}) : client.Transports(),
 		client.TLSPublicKeys(name),
 		client.LoginAttempts(name, 10),
 	])
@@ -1124,8 +1645,10 @@ const account = async (name: string) => {
 		),
 
 		dom.br(),
-		RoutesEditor('account-specific', transports, config.Routes || [], async (routes: api.Route[]) => await client.AccountRoutesSave(name, routes)),
-		dom.br(),
+		tenant ? [] : [
+			RoutesEditor('account-specific', transports, config.Routes || [], async (routes: api.Route[]) => await client.AccountRoutesSave(name, routes)),
+			dom.br(),
+		],
 
 		dom.h2('Danger'),
 		dom.div(
@@ -1224,7 +1747,7 @@ const domain = async (d: string) => {
 		client.ClientConfigsDomain(d),
 		client.Accounts(),
 		client.DomainConfig(d),
-		client.Transports(),
+		tenant ? Promise.resolve({}) : client.Transports(),
 	])
 	const dnsdomain = domainConfig.Domain
 
@@ -1552,8 +2075,10 @@ const domain = async (d: string) => {
 		),
 		dom.br(),
 
-		RoutesEditor('domain-specific', transports, domainConfig.Routes || [], async (routes: api.Route[]) => await client.DomainRoutesSave(d, routes)),
-		dom.br(),
+		tenant ? [] : [
+			RoutesEditor('domain-specific', transports, domainConfig.Routes || [], async (routes: api.Route[]) => await client.DomainRoutesSave(d, routes)),
+			dom.br(),
+		],
 
 		dom.h2('Settings'),
 		dom.form(
@@ -1848,6 +2373,8 @@ const domain = async (d: string) => {
 		dom.h2('DKIM', attr.title('With DKIM signing, a domain is taking responsibility for (content of) emails it sends, letting receiving mail servers build up a (hopefully positive) reputation of the domain, which can help with mail delivery.')),
 		(() => {
 			let fieldset: HTMLFieldSetElement
+			let signMailFrom: HTMLInputElement
+			let signHostname: HTMLInputElement
 
 			interface Row {
 				root: HTMLElement
@@ -1872,7 +2399,7 @@ const domain = async (d: string) => {
 							sign.push(selName)
 						}
 					}
-					await check(fieldset, client.DomainDKIMSave(d, selectors, sign))
+					await check(fieldset, client.DomainDKIMSave(d, selectors, sign, signMailFrom.checked, signHostname.checked))
 					window.alert("Don't forget to update DNS records if needed. See suggested DNS records.")
 				},
 				fieldset=dom.fieldset(
@@ -1971,6 +2498,21 @@ const domain = async (d: string) => {
 							),
 						),
 					),
+					dom.div(
+						style({marginTop: '1ex'}),
+						dom.label(
+							attr.title('If a message with a From address in this domain is submitted with an SMTP MAIL FROM address in another domain configured on this server, also sign the message with the DKIM keys of the MAIL FROM domain.'),
+							signMailFrom=dom.input(attr.type('checkbox'), domainConfig.DKIM.SignMailFrom ? attr.checked('') : []),
+							' Also sign submitted messages for SMTP MAIL FROM domain',
+						),
+					),
+					dom.div(
+						dom.label(
+							attr.title('Also sign messages submitted with a From address in this domain with the DKIM keys of the domain of the hostname of this mail server, as additional signature by the sending host. The hostname domain, or a parent domain, must be configured with DKIM keys.'),
+							signHostname=dom.input(attr.type('checkbox'), domainConfig.DKIM.SignHostname ? attr.checked('') : []),
+							' Also sign submitted messages for hostname domain',
+						),
+					),
 				),
 			)
 		})(),
@@ -2255,6 +2797,10 @@ const domainDNSCheck = async (d: string) => {
 		!checks.MTASTS.TXT ? [] : dom.div('MTA-STS record: ' + checks.MTASTS.TXT),
 		!checks.MTASTS.PolicyText ? [] : dom.div('MTA-STS policy: ', dom.pre(dom._class('literal'), style({maxWidth: '60em'}), checks.MTASTS.PolicyText)),
 	]
+	const detailsBIMI = !checks.BIMI.TXT ? [] : [
+		dom.div('Domain: ' + checks.BIMI.Domain),
+		dom.div('TXT record: ' + checks.BIMI.TXT),
+	]
 	const detailsSRVConf = !checks.SRVConf.SRVs || Object.keys(checks.SRVConf.SRVs).length === 0 ? [] : [
 		dom.table(
 			dom.thead(
@@ -2306,6 +2852,7 @@ const domainDNSCheck = async (d: string) => {
 		resultSection('Host TLSRPT', checks.HostTLSRPT, detailsTLSRPT(checks.HostTLSRPT)),
 		resultSection('Domain TLSRPT', checks.DomainTLSRPT, detailsTLSRPT(checks.DomainTLSRPT)),
 		resultSection('MTA-STS', checks.MTASTS, detailsMTASTS),
+		resultSection('BIMI', checks.BIMI, detailsBIMI),
 		resultSection('SRV conf', checks.SRVConf, detailsSRVConf),
 		resultSection('Autoconf', checks.Autoconf, detailsAutoconf),
 		resultSection('Autodiscover', checks.Autodiscover, detailsAutodiscover),
@@ -3344,6 +3891,46 @@ const dnsbl = async () => {
 	)
 }
 
+// msgResultRows returns table rows for a delivery result of a queued or retired
+// message, with the hosts tried during the delivery attempt.
+const msgResultRows = (r: api.MsgResult, nowSecs: number) => [
+	dom.tr(
+		dom.td(age(r.Start, false, nowSecs)),
+		dom.td(Math.round(r.Duration/1000000)+'ms'),
+		dom.td(r.Success ? '✓' : ''),
+		dom.td(''+ (r.Code || '')),
+		dom.td(r.Secode),
+		dom.td(r.Error),
+	),
+	(r.Hosts || []).length === 0 ? [] : dom.tr(
+		dom.td(attr.colspan('6'), style({paddingLeft: '2em'}),
+			dom.table(
+				dom.thead(
+					dom.tr(
+						dom.th('Host'), dom.th('IP'), dom.th('Duration'), dom.th('Success'), dom.th('Code'), dom.th('Secode'),
+						dom.th('TLS', attr.title('Whether TLS was used, the TLS mode with verification mechanisms, and TLS failures as reported in TLS reports.')),
+						dom.th('Error'),
+					),
+				),
+				dom.tbody(
+					(r.Hosts || []).map(h =>
+						dom.tr(
+							dom.td(h.Host),
+							dom.td(h.IP),
+							dom.td(Math.round(h.Duration/1000000)+'ms'),
+							dom.td(h.Success ? '✓' : ''),
+							dom.td(''+ (h.Code || '')),
+							dom.td(h.Secode),
+							dom.td((h.TLS ? 'yes' : 'no') + (h.TLSMode ? ', '+h.TLSMode : '') + ((h.TLSFailures || []).length > 0 ? ', failures: '+(h.TLSFailures || []).join(', ') : '')),
+							dom.td(h.Error),
+						)
+					),
+				),
+			),
+		),
+	),
+]
+
 const queueList = async () => {
 	let filter: api.Filter = {Max: parseInt(localStorageGet('adminpaginationsize') || '') || 100, IDs: [], Account: '', From: '', To: '', Hold: null, Submitted: '', NextAttempt: '', Transport: null}
 	let sort: api.Sort = {Field: "NextAttempt", LastID: 0, Last: null, Asc: true}
@@ -3412,8 +3999,14 @@ const queueList = async () => {
 			dom.h1('Details'),
 			dom.table(
 				dom.tr(dom.td('Message subject'), dom.td(m.Subject)),
+				m.HoldReason ? dom.tr(dom.td('Held for review'), dom.td(m.HoldReason)) : [],
 			),
 			dom.br(),
+			dom.clickbutton('View message', attr.title('Show the message source, e.g. to review a message held for review before releasing or failing it. At most 1MB is shown.'), async function click(e: MouseEvent) {
+				const src = await check(e.target! as HTMLButtonElement, client.QueueMessageSource(m.ID))
+				popup(dom.h1('Message'), dom.pre(dom._class('literal'), src))
+			}),
+			dom.br(),
 			dom.h2('Results'),
 			dom.table(
 				dom.thead(
@@ -3423,16 +4016,7 @@ const queueList = async () => {
 				),
 				dom.tbody(
 					(m.Results || []).length === 0 ? dom.tr(dom.td(attr.colspan('6'), 'No results.')) : [],
-					(m.Results || []).map(r =>
-						dom.tr(
-							dom.td(age(r.Start, false, nowSecs)),
-							dom.td(Math.round(r.Duration/1000000)+'ms'),
-							dom.td(r.Success ? '✓' : ''),
-							dom.td(''+ (r.Code || '')),
-							dom.td(r.Secode),
-							dom.td(r.Error),
-						)
-					),
+					(m.Results || []).map(r => msgResultRows(r, nowSecs)),
 				),
 			),
 		)
@@ -3459,12 +4043,12 @@ const queueList = async () => {
 					dom.td(prewrap(m.RecipientLocalpart, "@", ipdomainString(m.RecipientDomain))), // todo: escaping of localpart
 					dom.td(formatSize(m.Size)),
 					dom.td(''+m.Attempts),
-					dom.td(m.Hold ? 'Hold' : ''),
+					dom.td(m.Hold ? (m.HoldReason ? dom.span('Review', attr.title('Held for review: '+m.HoldReason)) : 'Hold') : ''),
 					dom.td(age(new Date(m.NextAttempt), true, nowSecs)),
 					dom.td(m.LastAttempt ? age(new Date(m.LastAttempt), false, nowSecs) : '-'),
 					dom.td(m.Results && m.Results.length > 0 ? m.Results[m.Results.length-1].Error : []),
 					dom.td(m.Transport || '(default)'),
-					dom.td(m.RequireTLS === true ? 'Yes' : (m.RequireTLS === false ? 'No' : '')),
+					dom.td(m.RequireVerifiedTLS ? 'Verified' : (m.RequireTLS === true ? 'Yes' : (m.RequireTLS === false ? 'No' : ''))),
 					dom.td(
 						dom.clickbutton('Details', function click() {
 							popupDetails(m)
@@ -3881,16 +4465,7 @@ const retiredList = async () => {
 				),
 				dom.tbody(
 					(m.Results || []).length === 0 ? dom.tr(dom.td(attr.colspan('6'), 'No results.')) : [],
-					(m.Results || []).map(r =>
-						dom.tr(
-							dom.td(age(r.Start, false, nowSecs)),
-							dom.td(Math.round(r.Duration/1000000)+'ms'),
-							dom.td(r.Success ? '✓' : ''),
-							dom.td(''+ (r.Code || '')),
-							dom.td(r.Secode),
-							dom.td(r.Error),
-						)
-					),
+					(m.Results || []).map(r => msgResultRows(r, nowSecs)),
 				),
 			),
 		)
@@ -3916,7 +4491,7 @@ const retiredList = async () => {
 					dom.td(m.LastAttempt ? age(new Date(m.LastAttempt), false, nowSecs) : '-'),
 					dom.td(m.Results && m.Results.length > 0 ? m.Results[m.Results.length-1].Error : []),
 					dom.td(m.Transport || ''),
-					dom.td(m.RequireTLS === true ? 'Yes' : (m.RequireTLS === false ? 'No' : '')),
+					dom.td(m.RequireVerifiedTLS ? 'Verified' : (m.RequireTLS === true ? 'Yes' : (m.RequireTLS === false ? 'No' : ''))),
 					dom.td(
 						dom.clickbutton('Details', function click() {
 							popupDetails(m)
@@ -5347,6 +5922,7 @@ const init = async () => {
 	let curhash: string | undefined
 
 	[moxversion, moxgoos, moxgoarch] = await client.Version()
+	tenant = await client.Tenant()
 
 	const hashChange = async () => {
 		if (curhash === window.location.hash) {
@@ -5366,6 +5942,14 @@ const init = async () => {
 				root = await config()
 			} else if (h === 'loglevels') {
 				root = await loglevels()
+			} else if (h === 'webauthn') {
+				root = await webauthnCredentials()
+			} else if (h === 'sessions') {
+				root = await sessions()
+			} else if (h === 'tls') {
+				root = await tlsCertificates()
+			} else if (h === 'rejected') {
+				root = await rejectedList()
 			} else if (h === 'accounts') {
 				root = await accounts()
 			} else if (h === 'accounts/loginattempts') {
@@ -3,10 +3,17 @@ package webadmin
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -15,11 +22,13 @@ import (
 	"reflect"
 	"runtime/debug"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/mjl-/bstore"
 	"github.com/mjl-/sherpa"
 
 	"github.com/mjl-/mox/config"
@@ -28,7 +37,9 @@ import (
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/mtasts"
 	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/rejectdb"
 	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/totp"
 	"github.com/mjl-/mox/webauth"
 )
 
@@ -107,18 +118,18 @@ func TestAdminAuth(t *testing.T) {
 	tcheck(t, err, "sherpa handler")
 
 	respRec := httptest.NewRecorder()
-	reqInfo := requestInfo{"", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
+	reqInfo := requestInfo{"", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}, ""}
 	ctx := context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
 
 	// Missing login token.
-	tneedErrorCode(t, "user:error", func() { api.Login(ctx, "", "moxtest123") })
+	tneedErrorCode(t, "user:error", func() { api.Login(ctx, "", "moxtest123", "") })
 
 	// Login with loginToken.
 	loginCookie := &http.Cookie{Name: "webadminlogin"}
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
 
-	csrfToken := api.Login(ctx, loginCookie.Value, "moxtest123")
+	csrfToken := api.Login(ctx, loginCookie.Value, "moxtest123", "")
 	var sessionCookie *http.Cookie
 	for _, c := range respRec.Result().Cookies() {
 		if c.Name == "webadminsession" {
@@ -133,7 +144,63 @@ func TestAdminAuth(t *testing.T) {
 	// Valid loginToken, but bad credentials.
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "badauth") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "badauth", "") })
+
+	// Two-factor authentication with TOTP file.
+	totpLogin := func(expErrCode, totpCode string) {
+		t.Helper()
+		reqInfo := requestInfo{"", httptest.NewRecorder(), &http.Request{RemoteAddr: "1.1.1.2:1234"}, ""}
+		ctx := context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
+		cookie := &http.Cookie{Name: "webadminlogin", Value: api.LoginPrep(ctx)}
+		reqInfo.Request.Header = http.Header{"Cookie": []string{cookie.String()}}
+		if expErrCode != "" {
+			tneedErrorCode(t, expErrCode, func() { api.Login(ctx, cookie.Value, "moxtest123", totpCode) })
+		} else {
+			api.Login(ctx, cookie.Value, "moxtest123", totpCode)
+		}
+	}
+	mox.Conf.Static.AdminTOTPFile = "admintotp"
+	mox.Conf.Static.AdminRequireTOTP = true
+	totpLogin("user:loginFailed", "") // File not present, but required.
+	mox.Conf.Static.AdminRequireTOTP = false
+	totpLogin("", "")
+
+	totpPath := mox.ConfigDirPath(mox.Conf.Static.AdminTOTPFile)
+	secret := totp.NewSecret()
+	recoveryCodes := totp.NewRecoveryCodes(2)
+	err = webauth.AdminTOTPWrite(totpPath, webauth.AdminTOTP{Secret: secret, RecoveryCodeHashes: []string{totp.RecoveryCodeHash(recoveryCodes[0]), totp.RecoveryCodeHash(recoveryCodes[1])}})
+	tcheck(t, err, "write totp file")
+	defer os.Remove(totpPath)
+	totpLogin("user:totpRequired", "")
+	totpLogin("user:loginFailed", "bogus")
+	totpCode, err := totp.Code(secret, time.Now())
+	tcheck(t, err, "totp code")
+	totpLogin("", totpCode)
+	totpLogin("user:loginFailed", totpCode) // Codes cannot be reused.
+	totpLogin("", recoveryCodes[1])
+	totpLogin("user:loginFailed", recoveryCodes[1])
+	at, err := webauth.AdminTOTPRead(totpPath)
+	tcheck(t, err, "read totp file")
+	tcompare(t, at.RecoveryCodeHashes, []string{totp.RecoveryCodeHash(recoveryCodes[0])})
+	mox.Conf.Static.AdminTOTPFile = ""
+
+	// WebAuthn, without registered credentials.
+	webauthnReqInfo := requestInfo{"", httptest.NewRecorder(), &http.Request{RemoteAddr: "1.1.1.3:1234", Host: "mox.example:1080"}, ""}
+	webauthnCtx := context.WithValue(ctxbg, requestInfoCtxKey, webauthnReqInfo)
+	tcompare(t, len(api.WebAuthnCredentials(webauthnCtx)), 0)
+	tneedErrorCode(t, "user:error", func() { api.WebAuthnLoginStart(webauthnCtx) })
+	opts := api.WebAuthnRegisterStart(webauthnCtx)
+	tcompare(t, opts.RPID, "mox.example")
+	tneedErrorCode(t, "user:error", func() { api.WebAuthnRegisterFinish(webauthnCtx, "", opts.Challenge, "", "") })
+	tneedErrorCode(t, "user:error", func() { api.WebAuthnRegisterFinish(webauthnCtx, "key", opts.Challenge, "e30", "") })
+	// Challenge was used.
+	tneedErrorCode(t, "user:error", func() { api.WebAuthnRegisterFinish(webauthnCtx, "key", opts.Challenge, "e30", "") })
+	tneedErrorCode(t, "user:error", func() { api.WebAuthnCredentialRemove(webauthnCtx, 1) })
+	webauthnCookie := &http.Cookie{Name: "webadminlogin", Value: api.LoginPrep(webauthnCtx)}
+	webauthnReqInfo.Request.Header = http.Header{"Cookie": []string{webauthnCookie.String()}}
+	tneedErrorCode(t, "user:loginFailed", func() {
+		api.LoginWebAuthn(webauthnCtx, webauthnCookie.Value, opts.Challenge, "AAAA", "e30", "AAAA", "AAAA")
+	})
 
 	type httpHeaders [][2]string
 	ctJSON := [2]string{"Content-Type", "application/json; charset=utf-8"}
@@ -208,12 +275,35 @@ func TestAdminAuth(t *testing.T) {
 	testHTTP("POST", "/api/Bogus", httpHeaders{hdrCSRFBad, hdrSessionOK}, http.StatusOK, nil, badAuth)
 	testHTTP("POST", "/api/Bogus", httpHeaders{hdrCSRFOK, hdrSessionBad}, http.StatusOK, nil, badAuth)
 	testHTTPAuthAPI("GET", "/api/Transports", http.StatusMethodNotAllowed, nil, nil)
+
+	// API documentation is available without authentication.
+	testHTTP("GET", "/api/sherpadoc.json", httpHeaders{}, http.StatusOK, httpHeaders{ctJSON}, nil)
+	testHTTP("POST", "/api/sherpadoc.json", httpHeaders{}, http.StatusMethodNotAllowed, nil, nil)
 	testHTTPAuthAPI("POST", "/api/Transports", http.StatusOK, httpHeaders{ctJSON}, nil)
 
 	// Logout needs session token.
 	reqInfo.SessionToken = store.SessionToken(strings.SplitN(sessionCookie.Value, " ", 2)[0])
 	ctx = context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
 
+	// Multiple sessions exist from the logins above.
+	sessions, currentID := api.Sessions(ctx)
+	if currentID == 0 || len(sessions) < 3 {
+		t.Fatalf("got %d sessions, current %d, expected at least 3 and a current session", len(sessions), currentID)
+	}
+	tneedErrorCode(t, "user:error", func() { api.SessionRevoke(ctx, currentID) })
+	for _, s := range sessions {
+		if s.ID != currentID {
+			api.SessionRevoke(ctx, s.ID)
+			tneedErrorCode(t, "user:error", func() { api.SessionRevoke(ctx, s.ID) })
+			break
+		}
+	}
+	n := api.SessionsRevokeOthers(ctx)
+	tcompare(t, n, len(sessions)-2)
+	sessions, _ = api.Sessions(ctx)
+	tcompare(t, len(sessions), 1)
+	tcompare(t, sessions[0].RemoteIP, "192.0.2.1") // From the httptest requests above.
+
 	api.Logout(ctx)
 	tneedErrorCode(t, "server:error", func() { api.Logout(ctx) })
 }
@@ -323,17 +413,21 @@ func TestAdmin(t *testing.T) {
 		api.DomainDKIMAdd(ctxbg, "bogus.example", "testsel", "ed25519", "sha256", true, true, true, nil, 24*time.Hour)
 	})
 	conf := api.DomainConfig(ctxbg, "mox.example")
-	api.DomainDKIMSave(ctxbg, "mox.example", conf.DKIM.Selectors, conf.DKIM.Sign)
-	api.DomainDKIMSave(ctxbg, "mox.example", conf.DKIM.Selectors, []string{"testsel"})
-	tneedErrorCode(t, "user:error", func() { api.DomainDKIMSave(ctxbg, "mox.example", conf.DKIM.Selectors, []string{"bogus"}) })
-	tneedErrorCode(t, "user:error", func() { api.DomainDKIMSave(ctxbg, "mox.example", nil, []string{}) }) // Cannot remove selectors with save.
-	tneedErrorCode(t, "user:error", func() { api.DomainDKIMSave(ctxbg, "bogus.example", nil, []string{}) })
+	api.DomainDKIMSave(ctxbg, "mox.example", conf.DKIM.Selectors, conf.DKIM.Sign, false, false)
+	api.DomainDKIMSave(ctxbg, "mox.example", conf.DKIM.Selectors, []string{"testsel"}, true, false)
+	tneedErrorCode(t, "user:error", func() { api.DomainDKIMSave(ctxbg, "mox.example", conf.DKIM.Selectors, []string{"bogus"}, false, false) })
+	tneedErrorCode(t, "user:error", func() { api.DomainDKIMSave(ctxbg, "mox.example", nil, []string{}, false, false) }) // Cannot remove selectors with save.
+	tneedErrorCode(t, "user:error", func() { api.DomainDKIMSave(ctxbg, "bogus.example", nil, []string{}, false, false) })
 	moreSel := map[string]config.Selector{
 		"testsel":  conf.DKIM.Selectors["testsel"],
 		"testsel2": conf.DKIM.Selectors["testsel2"],
 	}
-	tneedErrorCode(t, "user:error", func() { api.DomainDKIMSave(ctxbg, "mox.example", moreSel, []string{}) }) // Cannot add selectors with save.
+	tneedErrorCode(t, "user:error", func() { api.DomainDKIMSave(ctxbg, "mox.example", moreSel, []string{}, false, false) }) // Cannot add selectors with save.
 	api.DomainDKIMRemove(ctxbg, "mox.example", "testsel")
+	if conf := api.DomainConfig(ctxbg, "mox.example"); !conf.DKIM.SignMailFrom || conf.DKIM.SignHostname {
+		t.Fatalf("dkim sign options not preserved after removing selector: %#v", conf.DKIM)
+	}
+	api.DomainDKIMSave(ctxbg, "mox.example", nil, nil, false, false)
 	tneedErrorCode(t, "user:error", func() { api.DomainDKIMRemove(ctxbg, "mox.example", "testsel") }) // Already removed.
 	tneedErrorCode(t, "user:error", func() { api.DomainDKIMRemove(ctxbg, "bogus.example", "testsel") })
 
@@ -447,3 +541,164 @@ func TestCheckDomain(t *testing.T) {
 	Admin{}.Domains(ctxbg)             // todo: check results
 	dnsblsStatus(ctxbg, log, resolver) // todo: check results
 }
+
+// fakeKeyCert returns a PEM-encoded self-signed certificate and private key for name.
+func fakeKeyCert(t *testing.T, name string, notAfter time.Time) (certPEM, keyPEM []byte) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	tcheck(t, err, "generate key")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1), // Required field...
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	certBuf, err := x509.CreateCertificate(cryptorand.Reader, template, template, privKey.Public(), privKey)
+	tcheck(t, err, "making certificate")
+	keyBuf, err := x509.MarshalPKCS8PrivateKey(privKey)
+	tcheck(t, err, "marshal private key")
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBuf})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBuf})
+	return
+}
+
+func TestTLSCertificates(t *testing.T) {
+	os.RemoveAll("../testdata/webadmin/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/webadmin/mox.conf")
+	mox.ConfigDynamicPath = filepath.Join(filepath.Dir(mox.ConfigStaticPath), "domains.conf")
+	mox.MustLoadConfig(true, false)
+
+	api := Admin{}
+
+	tcompare(t, len(api.TLSCertificates(ctxbg)), 0)
+	tneedErrorCode(t, "user:error", func() { api.TLSCertificateRenew(ctxbg, "bogus", "mox.example") })
+
+	// Listener with certificate from files.
+	certPath := mox.ConfigDirPath("tls-cert.pem")
+	keyPath := mox.ConfigDirPath("tls-key.pem")
+	defer os.Remove(certPath)
+	defer os.Remove(keyPath)
+	certPEM, keyPEM := fakeKeyCert(t, "mox.example", time.Now().Add(time.Hour))
+	err := os.WriteFile(certPath, certPEM, 0660)
+	tcheck(t, err, "write cert")
+	err = os.WriteFile(keyPath, keyPEM, 0660)
+	tcheck(t, err, "write key")
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	tcheck(t, err, "parse key pair")
+
+	l := mox.Conf.Static.Listeners["local"]
+	l.TLS = &config.TLS{
+		KeyCerts:       []config.KeyCert{{CertFile: "tls-cert.pem", KeyFile: "tls-key.pem"}},
+		KeyCertsLoaded: &atomic.Pointer[[]tls.Certificate]{},
+	}
+	l.TLS.KeyCertsLoaded.Store(&[]tls.Certificate{cert})
+	mox.Conf.Static.Listeners["local"] = l
+	defer func() {
+		l.TLS = nil
+		mox.Conf.Static.Listeners["local"] = l
+	}()
+
+	certs := api.TLSCertificates(ctxbg)
+	tcompare(t, len(certs), 1)
+	tcompare(t, certs[0].Listener, "local")
+	tcompare(t, certs[0].DNSNames, []string{"mox.example"})
+	tcompare(t, certs[0].NotAfter, cert.Leaf.NotAfter)
+
+	// Replace with new certificate.
+	ncertPEM, nkeyPEM := fakeKeyCert(t, "mail.mox.example", time.Now().Add(2*time.Hour))
+	tneedErrorCode(t, "user:error", func() { api.TLSKeyCertReplace(ctxbg, "bogus", 0, string(ncertPEM), string(nkeyPEM)) })
+	tneedErrorCode(t, "user:error", func() { api.TLSKeyCertReplace(ctxbg, "local", 1, string(ncertPEM), string(nkeyPEM)) })
+	tneedErrorCode(t, "user:error", func() { api.TLSKeyCertReplace(ctxbg, "local", 0, string(ncertPEM), string(keyPEM)) }) // Mismatching key.
+	expCertPEM, expKeyPEM := fakeKeyCert(t, "mox.example", time.Now().Add(-time.Minute))
+	tneedErrorCode(t, "user:error", func() { api.TLSKeyCertReplace(ctxbg, "local", 0, string(expCertPEM), string(expKeyPEM)) }) // Expired.
+
+	api.TLSKeyCertReplace(ctxbg, "local", 0, string(ncertPEM), string(nkeyPEM))
+	certs = api.TLSCertificates(ctxbg)
+	tcompare(t, certs[0].DNSNames, []string{"mail.mox.example"})
+	buf, err := os.ReadFile(certPath)
+	tcheck(t, err, "read cert file")
+	tcompare(t, buf, ncertPEM)
+	buf, err = os.ReadFile(keyPath)
+	tcheck(t, err, "read key file")
+	tcompare(t, buf, nkeyPEM)
+
+	// New certificate is used for connections.
+	tcompare(t, (*l.TLS.KeyCertsLoaded.Load())[0].Leaf.DNSNames, []string{"mail.mox.example"})
+}
+
+func TestRejected(t *testing.T) {
+	os.RemoveAll("../testdata/webadmin/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/webadmin/mox.conf")
+	mox.ConfigDynamicPath = filepath.Join(filepath.Dir(mox.ConfigStaticPath), "domains.conf")
+	mox.MustLoadConfig(true, false)
+	err := store.Init(ctxbg)
+	tcheck(t, err, "store init")
+	defer func() {
+		err := store.Close()
+		tcheck(t, err, "store close")
+	}()
+	switchStop := store.Switchboard()
+	defer switchStop()
+	err = rejectdb.Init()
+	tcheck(t, err, "rejectdb init")
+	defer rejectdb.Close()
+
+	api := Admin{}
+
+	tcompare(t, len(api.RejectedList(ctxbg, "")), 0)
+	tneedErrorCode(t, "user:error", func() { api.RejectedMessage(ctxbg, 1) })
+	tneedErrorCode(t, "user:error", func() { api.RejectedDeliver(ctxbg, 1) })
+
+	msg := strings.ReplaceAll("From: <remote@remote.example>\nSubject: test\n\ntest\n", "\n", "\r\n")
+	add := func(rcptTo ...string) rejectdb.Message {
+		t.Helper()
+		m := rejectdb.Message{
+			Domain:   "mox.example",
+			RemoteIP: "192.0.2.1",
+			EHLO:     "remote.example",
+			MailFrom: "remote@remote.example",
+			RcptTo:   rcptTo,
+			MsgFrom:  "remote@remote.example",
+			Code:     550,
+			Secode:   "7.1",
+			Errmsg:   "rejected",
+			Size:     int64(len(msg)),
+		}
+		err := rejectdb.Add(ctxbg, pkglog, config.RejectedRetention{}, &m, strings.NewReader(msg))
+		tcheck(t, err, "add rejected message")
+		return m
+	}
+	m0 := add("mjl@mox.example", "mjl2@mox.example", "unknown@mox.example")
+	m1 := add("unknown@mox.example")
+
+	l := api.RejectedList(ctxbg, "mox.example")
+	tcompare(t, len(l), 2)
+	tcompare(t, l[0].ID, m1.ID)
+	tcompare(t, len(api.RejectedList(ctxbg, "other.example")), 0)
+
+	xm, data := api.RejectedMessage(ctxbg, m0.ID)
+	tcompare(t, xm.RcptTo, m0.RcptTo)
+	tcompare(t, data, msg)
+
+	// Delivered to known recipients, unknown recipients skipped.
+	delivered := api.RejectedDeliver(ctxbg, m0.ID)
+	tcompare(t, delivered, []string{"mjl@mox.example", "mjl2@mox.example"})
+	xm, _ = api.RejectedMessage(ctxbg, m0.ID)
+	if xm.Delivered.IsZero() {
+		t.Fatalf("message not marked as delivered")
+	}
+	acc, err := store.OpenAccount(pkglog, "mjl", false)
+	tcheck(t, err, "open account")
+	n, err := bstore.QueryDB[store.Message](ctxbg, acc.DB).FilterEqual("Expunged", false).Count()
+	tcheck(t, err, "count messages")
+	tcompare(t, n, 2)
+	err = acc.Close()
+	tcheck(t, err, "close account")
+	acc.WaitClosed()
+
+	// No recipient with account.
+	tneedErrorCode(t, "user:error", func() { api.RejectedDeliver(ctxbg, m1.ID) })
+
+	api.RejectedRemove(ctxbg, []int64{m0.ID, m1.ID})
+	tcompare(t, len(api.RejectedList(ctxbg, "")), 0)
+	tneedErrorCode(t, "user:error", func() { api.RejectedRemove(ctxbg, []int64{m0.ID}) })
+}
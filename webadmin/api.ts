@@ -2,6 +2,47 @@
 
 namespace api {
 
+// WebAuthnLoginOptions holds parameters for logging in with a security key or
+// passkey through the WebAuthn API of the browser. Binary values are
+// raw-url-base64-encoded.
+export interface WebAuthnLoginOptions {
+	Challenge: string
+	RPID: string  // Relying party ID, the host name.
+	CredentialIDs?: string[] | null  // Credentials registered for RPID.
+}
+
+// WebAuthnRegisterOptions holds parameters for registering a security key or
+// passkey through the WebAuthn API of the browser. Binary values are
+// raw-url-base64-encoded.
+export interface WebAuthnRegisterOptions {
+	Challenge: string
+	RPID: string  // Relying party ID, the host name.
+	UserID: string
+	ExcludeCredentialIDs?: string[] | null  // Credentials already registered for RPID.
+	Algorithms?: number[] | null  // COSE algorithm identifiers, in order of preference.
+}
+
+// AdminWebAuthnCredential is a security key or passkey registered for logging in
+// to the admin web interface, as alternative to the admin password.
+export interface AdminWebAuthnCredential {
+	ID: number
+	Created: Date
+	Name: string  // Descriptive name to identify the credential, e.g. the type of security key.
+	RPID: string  // Relying party ID the credential is registered for, the host name the admin web interface was accessed at. Credentials can only be used at this host name.
+	LastUsed: Date  // Zero if never used.
+}
+
+// AdminSession is a login session for the admin web interface.
+export interface AdminSession {
+	ID: number
+	Created: Date  // Of login.
+	Expires: Date  // Extended when used.
+	Tenant: string  // For sessions of tenant admins, the name of the tenant. The session only gives access to the domains and accounts of the tenant. PasswordHash is of the password file of the tenant.
+	LastUsed: Date  // Of most recent use, for showing active sessions.
+	RemoteIP: string
+	UserAgent: string
+}
+
 // CheckResult is the analysis of a domain, its actual configuration (DNS, TLS,
 // connectivity) and the mox configuration. It includes configuration instructions
 // (e.g. DNS records), and warnings and errors encountered.
@@ -18,6 +59,7 @@ export interface CheckResult {
 	HostTLSRPT: TLSRPTCheckResult
 	DomainTLSRPT: TLSRPTCheckResult
 	MTASTS: MTASTSCheckResult
+	BIMI: BIMICheckResult
 	SRVConf: SRVConfCheckResult
 	Autoconf: AutoconfCheckResult
 	Autodiscover: AutodiscoverCheckResult
@@ -227,6 +269,21 @@ export interface STSMX {
 	Domain: Domain
 }
 
+export interface BIMICheckResult {
+	Domain: string
+	TXT: string
+	Record?: BIMIRecord | null
+	Errors?: string[] | null
+	Warnings?: string[] | null
+	Instructions?: string[] | null
+}
+
+export interface BIMIRecord {
+	Version: string  // "BIMI1"
+	Location: string  // "l=", HTTPS URL to SVG logo. Empty for a "declination to publish" record.
+	Authority: string  // "a=", optional HTTPS URL to PEM-encoded Verified Mark Certificate.
+}
+
 export interface SRVConfCheckResult {
 	SRVs?: { [key: string]: SRV[] | null }  // Service (e.g. "_imaps") to records.
 	Errors?: string[] | null
@@ -269,6 +326,8 @@ export interface ConfigDomain {
 	Disabled: boolean
 	Description: string
 	ClientSettingsDomain: string
+	ACME: string
+	AliasOf: string
 	LocalpartCatchallSeparator: string
 	LocalpartCatchallSeparators?: string[] | null
 	LocalpartCaseSensitive: boolean
@@ -276,8 +335,17 @@ export interface ConfigDomain {
 	DMARC?: DMARC | null
 	MTASTS?: MTASTS | null
 	TLSRPT?: TLSRPT | null
+	BIMI?: BIMI | null
 	Routes?: Route[] | null
 	Aliases?: { [key: string]: Alias }
+	Lists?: { [key: string]: List }
+	DestinationPatterns?: DestinationPattern[] | null
+	Tenant: string
+	VirusScan: string
+	Subdomains?: Subdomains | null
+	RejectedRetention?: RejectedRetention | null
+	RejectText?: RejectText | null
+	Retire?: DomainRetire | null
 	Domain: Domain
 	LocalpartCatchallSeparatorsEffective?: string[] | null  // Either LocalpartCatchallSeparators, the value of LocalpartCatchallSeparator, or empty.
 }
@@ -285,6 +353,8 @@ export interface ConfigDomain {
 export interface DKIM {
 	Selectors?: { [key: string]: Selector }
 	Sign?: string[] | null
+	SignMailFrom: boolean
+	SignHostname: boolean
 }
 
 export interface Selector {
@@ -329,6 +399,13 @@ export interface TLSRPT {
 	DNSDomain: Domain  // Effective domain, always set based on Domain field or Domain where this is configured.
 }
 
+export interface BIMI {
+	Selector: string
+	Location: string
+	Authority: string
+	SelectorHeader: boolean
+}
+
 export interface Route {
 	FromDomain?: string[] | null
 	ToDomain?: string[] | null
@@ -366,6 +443,8 @@ export interface Destination {
 	SMTPError: string
 	MessageAuthRequiredSMTPError: string
 	FullName: string
+	ForwardTo?: string[] | null
+	ForwardKeepCopy: boolean
 }
 
 export interface Ruleset {
@@ -373,22 +452,86 @@ export interface Ruleset {
 	MsgFromRegexp: string
 	VerifiedDomain: string
 	HeadersRegexp?: { [key: string]: string }
+	MsgFromPatterns?: string[] | null
+	SPFResult: string
+	DKIMResult: string
+	DMARCResult: string
+	MinSize: number
+	MaxSize: number
+	Attachments: string
+	ListID: string
 	IsForward: boolean  // todo: once we implement ARC, we can use dkim domains that we cannot verify but that the arc-verified forwarding mail server was able to verify.
 	ListAllowDomain: string
 	AcceptRejectsToMailbox: string
 	Mailbox: string
+	MarkSeen: boolean
+	ForwardTo?: string[] | null
+	Discard: boolean
 	Comment: string
 	VerifiedDNSDomain: Domain
 	ListAllowDNSDomain: Domain
 }
 
+export interface List {
+	Account: string
+	Description: string
+	SubjectPrefix: string
+	PostPublic: boolean
+	Moderated: boolean
+	LocalpartStr: string  // In encoded form.
+	Domain: Domain
+}
+
+export interface DestinationPattern {
+	Localpart: string
+	LocalpartRegexp: string
+	Account: string
+	Mailbox: string
+}
+
+export interface Subdomains {
+	Routes?: SubdomainRoute[] | null
+	DMARCPolicy: string
+}
+
+export interface SubdomainRoute {
+	Subdomain: string
+	Deliver: string
+}
+
+export interface RejectedRetention {
+	MaxAge: number
+	MaxSize: number
+}
+
+export interface RejectText {
+	ContactURL: string
+	Default: string
+	Reasons?: { [key: string]: string }
+}
+
+export interface DomainRetire {
+	NewDomain: string
+	Message: string
+	RejectAfter: string
+	LoginUntil: string
+}
+
 export interface Account {
 	OutgoingWebhook?: OutgoingWebhook | null
 	IncomingWebhook?: IncomingWebhook | null
 	FromIDLoginAddresses?: string[] | null
 	KeepRetiredMessagePeriod: number
 	KeepRetiredWebhookPeriod: number
+	DeliveryPriority: number
+	AttachmentLinks?: AttachmentLinks | null
+	WeeklyDigest: boolean
+	SubaddressMailboxes: boolean
+	Notifications?: Notification[] | null
 	LoginDisabled: string
+	ReadOnly: boolean
+	SubmissionDisabled: string
+	IMAPReferralHost: string
 	Domain: string
 	Description: string
 	FullName: string
@@ -397,11 +540,16 @@ export interface Account {
 	QuotaMessageSize: number
 	RejectsMailbox: string
 	KeepRejects: boolean
+	RejectsRescueAllow: string
+	MailboxRetention?: MailboxRetention[] | null
+	AutoArchive?: AutoArchive | null
 	AutomaticJunkFlags: AutomaticJunkFlags
 	JunkFilter?: JunkFilter | null  // todo: sane defaults for junkfilter
 	MaxOutgoingMessagesPerDay: number
 	MaxFirstTimeRecipientsPerDay: number
+	HoldOverSendLimits: boolean
 	NoFirstTimeSenderDelay: boolean
+	NoAutoCreateMailboxes: boolean
 	NoCustomPassword: boolean
 	IMAPCapabilitiesDisabled?: string[] | null
 	Routes?: Route[] | null
@@ -420,10 +568,39 @@ export interface IncomingWebhook {
 	Authorization: string
 }
 
+export interface AttachmentLinks {
+	MinSize: number
+	ValidityDays: number
+}
+
+// Notification is a push notification service to send notifications about
+// incoming deliveries to. See package notify.
+export interface Notification {
+	Type: string
+	URL: string
+	Token: string
+	Mailboxes?: string[] | null
+	FromRegexp: string
+	SubjectRegexp: string
+}
+
 export interface SubjectPass {
 	Period: number  // todo: have a reasonable default for this?
 }
 
+// MailboxRetention is a policy for removing old messages from a mailbox.
+export interface MailboxRetention {
+	Mailbox: string
+	Period: number
+}
+
+// AutoArchive is a policy for moving old messages from the Inbox to archive
+// mailboxes.
+export interface AutoArchive {
+	Period: number
+	Mailbox: string
+}
+
 export interface AutomaticJunkFlags {
 	Enabled: boolean
 	JunkMailboxRegexp: string
@@ -690,6 +867,7 @@ export interface Msg {
 	BaseID: number  // A message for multiple recipients will get a BaseID that is identical to the first Msg.ID queued. The message contents will be identical for each recipient, including MsgPrefix. If other properties are identical too, including recipient domain, multiple Msgs may be delivered in a single SMTP transaction. For messages with a single recipient, this field will be 0.
 	Queued: Date
 	Hold: boolean  // If set, delivery won't be attempted.
+	HoldReason: string  // If set, the message was automatically put on hold for review by the admin, e.g. because the sender reached a sending limit. Cleared when the message is taken off hold.
 	SenderAccount: string  // Failures are delivered back to this local account. Also used for routing.
 	SenderLocalpart: Localpart  // Should be a local user and domain.
 	SenderDomain: IPDomain
@@ -703,11 +881,13 @@ export interface Msg {
 	DialedIPs?: { [key: string]: IP[] | null }  // For each host, the IPs that were dialed. Used for IP selection for later attempts.
 	NextAttempt: Date  // For scheduling.
 	LastAttempt?: Date | null
-	Results?: MsgResult[] | null
+	Results?: MsgResult[] | null  // Most recent results only, the full history is in the journal, see AttemptList.
+	Priority: number  // Priority for delivery, from -9 (lowest) to 9 (highest), 0 is normal. When the number of concurrent deliveries is limited, messages with higher priority are delivered first. Set from the SMTP MT-PRIORITY extension or the account default.
 	Has8bit: boolean  // Whether message contains bytes with high bit set, determines whether 8BITMIME SMTP extension is needed.
 	SMTPUTF8: boolean  // Whether message requires use of SMTPUTF8.
 	IsDMARCReport: boolean  // Delivery failures for DMARC reports are handled differently.
 	IsTLSReport: boolean  // Delivery failures for TLS reports are handled differently.
+	IsForward: boolean  // Incoming message forwarded to another address. Delivery failures don't add the recipient to the suppression list of the account, and no webhooks are sent.
 	Size: number  // Full size of message, combined MsgPrefix with contents of message file.
 	MessageID: string  // Message-ID header, including <>. Used when composing a DSN, in its References header.
 	MsgPrefix?: string | null  // Data to send before the contents from the file, typically with headers like DKIM-Signature.
@@ -715,7 +895,12 @@ export interface Msg {
 	DSNUTF8?: string | null  // If set, this message is a DSN and this is a version using utf-8, for the case the remote MTA supports smtputf8. In this case, Size and MsgPrefix are not relevant.
 	Transport: string  // If non-empty, the transport to use for this message. Can be set through cli or admin interface. If empty (the default for a submitted message), regular routing rules apply.
 	RequireTLS?: boolean | null  // RequireTLS influences TLS verification during delivery.  If nil, the recipient domain policy is followed (MTA-STS and/or DANE), falling back to optional opportunistic non-verified STARTTLS.  If RequireTLS is true (through SMTP REQUIRETLS extension or webmail submit), MTA-STS or DANE is required, as well as REQUIRETLS support by the next hop server.  If RequireTLS is false (through messag header "TLS-Required: No"), the recipient domain's policy is ignored if it does not lead to a successful TLS connection, i.e. falling back to SMTP delivery with unverified STARTTLS or plain text.
+	RequireVerifiedTLS: boolean  // If set, direct delivery requires STARTTLS with a verified certificate, through DANE or otherwise PKIX/WebPKI verification of the MX host name, also when the recipient domain has no MTA-STS or DANE policy. A "TLS-Required: No" header is ignored. Can be set during submission through the webapi, and with "mox queue requireverifiedtls". Can also be enabled per destination domain with a route to a direct transport with RequireVerifiedTLS.
 	FutureReleaseRequest: string  // For DSNs, where the original FUTURERELEASE value must be included as per-message field. This field should be of the form "for;" plus interval, or "until;" plus utc date-time.
+	DSNNotify: string  // Parameters from the SMTP DSN extension, for messages submitted over SMTP. ../rfc/3461; "NEVER", or comma-separated "SUCCESS", "FAILURE" and/or "DELAY". If empty, DSNs are sent for failures and delays.
+	DSNRet: string  // "FULL" or "HDRS", whether to include the full original message or only its headers in failure DSNs. If empty, only headers are included.
+	DSNEnvID: string  // Envelope identifier, included in DSNs and passed on to the next hop.
+	DSNOrigRecipient: string  // Original recipient address (ORCPT), included in DSNs and passed on to the next hop.
 	Extra?: { [key: string]: string }  // Extra information, for transactional email.
 }
 
@@ -733,6 +918,24 @@ export interface MsgResult {
 	Code: number
 	Secode: string
 	Error: string
+	Hosts?: MsgResultHost[] | null  // Hosts tried during a direct delivery attempt, in order.
+}
+
+// MsgResultHost is the result of delivering to a single host, e.g. one of the MX
+// targets, during a delivery attempt. The same host can be tried again without TLS
+// after a TLS failure.
+export interface MsgResultHost {
+	Host: string  // Host name or IP address.
+	IP: string  // Remote IP connected to, empty if no connection was made.
+	Start: Date
+	Duration: number
+	Success: boolean
+	Code: number
+	Secode: string
+	Error: string
+	TLS: boolean  // Whether the SMTP connection was protected with TLS.
+	TLSMode: string  // E.g. "opportunistic", "requiredstarttls+mtasts", "requiredstarttls+dane", "skip".
+	TLSFailures?: string[] | null  // TLSRPT result types of TLS failures for the host, e.g. "certificate-expired".
 }
 
 // RetiredFilter filters messages to list or operate on. Used by admin web interface
@@ -777,16 +980,18 @@ export interface MsgRetired {
 	MaxAttempts: number  // Max number of attempts before giving up. If 0, then the default of 8 attempts is used instead.
 	DialedIPs?: { [key: string]: IP[] | null }  // For each host, the IPs that were dialed. Used for IP selection for later attempts.
 	LastAttempt?: Date | null
-	Results?: MsgResult[] | null
+	Results?: MsgResult[] | null  // Last result only, the full history is in the journal, see AttemptList.
 	Has8bit: boolean  // Whether message contains bytes with high bit set, determines whether 8BITMIME SMTP extension is needed.
 	SMTPUTF8: boolean  // Whether message requires use of SMTPUTF8.
 	IsDMARCReport: boolean  // Delivery failures for DMARC reports are handled differently.
 	IsTLSReport: boolean  // Delivery failures for TLS reports are handled differently.
+	IsForward: boolean  // Incoming message forwarded to another address.
 	Size: number  // Full size of message, combined MsgPrefix with contents of message file.
 	MessageID: string  // Used when composing a DSN, in its References header.
 	Subject: string  // For context about delivery.
 	Transport: string
 	RequireTLS?: boolean | null
+	RequireVerifiedTLS: boolean
 	FutureReleaseRequest: string
 	Extra?: { [key: string]: string }  // Extra information, for transactional email.
 	LastActivity: Date
@@ -961,6 +1166,8 @@ export interface TransportSMTP {
 	STARTTLSInsecureSkipVerify: boolean
 	NoSTARTTLS: boolean
 	Auth?: SMTPAuth | null
+	Weight: number
+	Hosts?: TransportSMTPHost[] | null
 }
 
 // SMTPAuth hold authentication credentials used when delivering messages
@@ -971,15 +1178,25 @@ export interface SMTPAuth {
 	Mechanisms?: string[] | null
 }
 
+// TransportSMTPHost is an additional host for a submission/smtp transport.
+export interface TransportSMTPHost {
+	Host: string
+	Port: number
+	Priority: number
+	Weight: number
+}
+
 export interface TransportSocks {
 	Address: string
 	RemoteIPs?: string[] | null
 	RemoteHostname: string
+	RemoteResolve: boolean
 }
 
 export interface TransportDirect {
 	DisableIPv4: boolean
 	DisableIPv6: boolean
+	RequireVerifiedTLS: boolean
 }
 
 // TransportFail is a transport that fails all delivery attempts.
@@ -1062,13 +1279,31 @@ export interface TLSRPTSuppressAddress {
 export interface Dynamic {
 	Domains?: { [key: string]: ConfigDomain }
 	Accounts?: { [key: string]: Account }
+	Tenants?: { [key: string]: Tenant }
 	WebDomainRedirects?: { [key: string]: string }
 	WebHandlers?: WebHandler[] | null
 	Routes?: Route[] | null
 	MonitorDNSBLs?: string[] | null
+	Canaries?: { [key: string]: Canary }
 	MonitorDNSBLZones?: Domain[] | null
 }
 
+// Tenant is a group of domains and their accounts, with delegated administration.
+export interface Tenant {
+	Description: string
+	MaxOutgoingMessagesPerDay: number
+	MaxFirstTimeRecipientsPerDay: number
+	OutgoingIPs?: string[] | null
+}
+
+// Canary is a periodic self-test of outgoing delivery, see Dynamic.Canaries.
+export interface Canary {
+	From: string
+	To: string
+	Interval: number
+	Deadline: number
+}
+
 // TLSPublicKey is a public key for use with TLS client authentication based on the
 // public key of the certificate.
 export interface TLSPublicKey {
@@ -1098,12 +1333,69 @@ export interface LoginAttempt {
 	LocalIP: string
 	TLS: string  // Empty if no TLS, otherwise contains version, algorithm, properties, etc.
 	TLSPubKeyFingerprint: string
-	Protocol: string  // "submission", "imap", "webmail", "webaccount", "webadmin"
+	Protocol: string  // "submission", "imap", "pop3", "managesieve", "webmail", "webaccount", "webadmin"
 	UserAgent: string  // From HTTP header, or IMAP ID command.
 	AuthMech: string  // "plain", "login", "cram-md5", "scram-sha-256-plus", "(unrecognized)", etc
 	Result: AuthResult
 }
 
+// AuthLockout tracks failed authentication attempts for an IP or account, and
+// whether it is locked out, see config.AuthLockout.
+export interface AuthLockout {
+	Key: string  // "ip <ip>" or "account <name>".
+	IP: string  // IPv4 address or IPv6 /64 network, for IP lockouts.
+	Account: string  // Account name, for account lockouts. "(admin)" for the admin web interface.
+	Failures: number  // Failed attempts since WindowStart.
+	WindowStart: Date
+	Last: Date  // Last failed attempt.
+	Until: Date  // If in the future, authentication attempts are refused until this time.
+}
+
+// TLSCertificate is a TLS certificate used by listeners, either requested
+// through ACME or configured with key and certificate files.
+export interface TLSCertificate {
+	Listeners?: string[] | null  // Names of listeners using the certificate.
+	ACME: string  // Name of ACME provider, empty for certificates from files.
+	Hostname: string  // For ACME, the host name the certificate is for.
+	KeyType: string  // For ACME, "ecdsa" or "rsa".
+	Listener: string  // For certificates from files, listener with the KeyCerts.
+	Index: number  // For certificates from files, index in KeyCerts.
+	CertFile: string
+	KeyFile: string
+	Present: boolean  // ACME certificates are requested when first needed.
+	DNSNames?: string[] | null
+	Issuer: string
+	NotBefore: Date
+	NotAfter: Date
+	RenewAfter: Date  // For ACME, when automatic renewal starts.
+	Renewing: boolean  // For ACME, whether renewal started through the admin interface is in progress.
+	LastError: string  // For ACME, last error requesting a certificate, e.g. failed validation.
+	LastErrorTime: Date
+	NextAttempt: Date  // For ACME, after failed requests, no new certificate is requested before this time.
+	Error: string  // Error getting the certificate.
+}
+
+// Message is an incoming message that was rejected with a permanent error
+// after the DATA command. If a message was rejected for recipients at multiple
+// domains, a Message is stored for each domain with retention configured.
+export interface Message {
+	ID: number
+	Received: Date
+	Domain: string  // Recipient domain, unicode.
+	RemoteIP: string
+	EHLO: string
+	MailFrom: string  // SMTP MAIL FROM, empty for the null reverse path.
+	RcptTo?: string[] | null  // SMTP RCPT TO addresses at Domain that rejected the message.
+	MsgFrom: string  // Address in message From header, if it could be parsed.
+	Subject: string
+	MessageID: string
+	Code: number  // SMTP response code, e.g. 550.
+	Secode: string  // Enhanced status code without class, e.g. "7.1".
+	Errmsg: string  // Error message in SMTP response.
+	Size: number
+	Delivered: Date  // Last time the message was delivered to the recipients by an admin, zero if never.
+}
+
 export type CSRFToken = string
 
 // Policy as used in DMARC DNS record for "p=" or "sp=".
@@ -1157,15 +1449,22 @@ export enum AuthResult {
 	AuthBadChannelBinding = "badchanbind",
 	AuthBadProtocol = "badprotocol",
 	AuthLoginDisabled = "logindisabled",
+	AuthTOTPRequired = "totprequired",  // Valid password, but two-factor authentication code missing.
+	AuthBadTOTP = "badtotp",
+	AuthReferral = "referral",  // Account moved, client referred to other host.
 	AuthError = "error",
 	AuthAborted = "aborted",
 }
 
-export const structTypes: {[typename: string]: boolean} = {"Account":true,"Address":true,"AddressAlias":true,"Alias":true,"AliasAddress":true,"AuthResults":true,"AutoconfCheckResult":true,"AutodiscoverCheckResult":true,"AutodiscoverSRV":true,"AutomaticJunkFlags":true,"Canonicalization":true,"CheckResult":true,"ClientConfigs":true,"ClientConfigsEntry":true,"ConfigDomain":true,"DANECheckResult":true,"DKIM":true,"DKIMAuthResult":true,"DKIMCheckResult":true,"DKIMRecord":true,"DMARC":true,"DMARCCheckResult":true,"DMARCRecord":true,"DMARCSummary":true,"DNSSECResult":true,"DateRange":true,"Destination":true,"Directive":true,"Domain":true,"DomainFeedback":true,"Dynamic":true,"Evaluation":true,"EvaluationStat":true,"Extension":true,"FailureDetails":true,"Filter":true,"HoldRule":true,"Hook":true,"HookFilter":true,"HookResult":true,"HookRetired":true,"HookRetiredFilter":true,"HookRetiredSort":true,"HookSort":true,"IPDomain":true,"IPRevCheckResult":true,"Identifiers":true,"IncomingWebhook":true,"JunkFilter":true,"LoginAttempt":true,"MTASTS":true,"MTASTSCheckResult":true,"MTASTSRecord":true,"MX":true,"MXCheckResult":true,"Modifier":true,"Msg":true,"MsgResult":true,"MsgRetired":true,"OutgoingWebhook":true,"Pair":true,"Policy":true,"PolicyEvaluated":true,"PolicyOverrideReason":true,"PolicyPublished":true,"PolicyRecord":true,"Record":true,"Report":true,"ReportMetadata":true,"ReportRecord":true,"Result":true,"ResultPolicy":true,"RetiredFilter":true,"RetiredSort":true,"Reverse":true,"Route":true,"Row":true,"Ruleset":true,"SMTPAuth":true,"SPFAuthResult":true,"SPFCheckResult":true,"SPFRecord":true,"SRV":true,"SRVConfCheckResult":true,"STSMX":true,"Selector":true,"Sort":true,"SubjectPass":true,"Summary":true,"SuppressAddress":true,"TLSCheckResult":true,"TLSPublicKey":true,"TLSRPT":true,"TLSRPTCheckResult":true,"TLSRPTDateRange":true,"TLSRPTRecord":true,"TLSRPTSummary":true,"TLSRPTSuppressAddress":true,"TLSReportRecord":true,"TLSResult":true,"Transport":true,"TransportDirect":true,"TransportFail":true,"TransportSMTP":true,"TransportSocks":true,"URI":true,"WebForward":true,"WebHandler":true,"WebInternal":true,"WebRedirect":true,"WebStatic":true,"WebserverConfig":true}
+export const structTypes: {[typename: string]: boolean} = {"Account":true,"Address":true,"AddressAlias":true,"AdminSession":true,"AdminWebAuthnCredential":true,"Alias":true,"AliasAddress":true,"AttachmentLinks":true,"AuthLockout":true,"AuthResults":true,"AutoArchive":true,"AutoconfCheckResult":true,"AutodiscoverCheckResult":true,"AutodiscoverSRV":true,"AutomaticJunkFlags":true,"BIMI":true,"BIMICheckResult":true,"BIMIRecord":true,"Canary":true,"Canonicalization":true,"CheckResult":true,"ClientConfigs":true,"ClientConfigsEntry":true,"ConfigDomain":true,"DANECheckResult":true,"DKIM":true,"DKIMAuthResult":true,"DKIMCheckResult":true,"DKIMRecord":true,"DMARC":true,"DMARCCheckResult":true,"DMARCRecord":true,"DMARCSummary":true,"DNSSECResult":true,"DateRange":true,"Destination":true,"DestinationPattern":true,"Directive":true,"Domain":true,"DomainFeedback":true,"DomainRetire":true,"Dynamic":true,"Evaluation":true,"EvaluationStat":true,"Extension":true,"FailureDetails":true,"Filter":true,"HoldRule":true,"Hook":true,"HookFilter":true,"HookResult":true,"HookRetired":true,"HookRetiredFilter":true,"HookRetiredSort":true,"HookSort":true,"IPDomain":true,"IPRevCheckResult":true,"Identifiers":true,"IncomingWebhook":true,"JunkFilter":true,"List":true,"LoginAttempt":true,"MTASTS":true,"MTASTSCheckResult":true,"MTASTSRecord":true,"MX":true,"MXCheckResult":true,"MailboxRetention":true,"Message":true,"Modifier":true,"Msg":true,"MsgResult":true,"MsgResultHost":true,"MsgRetired":true,"Notification":true,"OutgoingWebhook":true,"Pair":true,"Policy":true,"PolicyEvaluated":true,"PolicyOverrideReason":true,"PolicyPublished":true,"PolicyRecord":true,"Record":true,"RejectText":true,"RejectedRetention":true,"Report":true,"ReportMetadata":true,"ReportRecord":true,"Result":true,"ResultPolicy":true,"RetiredFilter":true,"RetiredSort":true,"Reverse":true,"Route":true,"Row":true,"Ruleset":true,"SMTPAuth":true,"SPFAuthResult":true,"SPFCheckResult":true,"SPFRecord":true,"SRV":true,"SRVConfCheckResult":true,"STSMX":true,"Selector":true,"Sort":true,"SubdomainRoute":true,"Subdomains":true,"SubjectPass":true,"Summary":true,"SuppressAddress":true,"TLSCertificate":true,"TLSCheckResult":true,"TLSPublicKey":true,"TLSRPT":true,"TLSRPTCheckResult":true,"TLSRPTDateRange":true,"TLSRPTRecord":true,"TLSRPTSummary":true,"TLSRPTSuppressAddress":true,"TLSReportRecord":true,"TLSResult":true,"Tenant":true,"Transport":true,"TransportDirect":true,"TransportFail":true,"TransportSMTP":true,"TransportSMTPHost":true,"TransportSocks":true,"URI":true,"WebAuthnLoginOptions":true,"WebAuthnRegisterOptions":true,"WebForward":true,"WebHandler":true,"WebInternal":true,"WebRedirect":true,"WebStatic":true,"WebserverConfig":true}
 export const stringsTypes: {[typename: string]: boolean} = {"Align":true,"AuthResult":true,"CSRFToken":true,"DMARCPolicy":true,"IP":true,"Localpart":true,"Mode":true,"RUA":true}
 export const intsTypes: {[typename: string]: boolean} = {}
 export const types: TypenameMap = {
-	"CheckResult": {"Name":"CheckResult","Docs":"","Fields":[{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"DNSSEC","Docs":"","Typewords":["DNSSECResult"]},{"Name":"IPRev","Docs":"","Typewords":["IPRevCheckResult"]},{"Name":"MX","Docs":"","Typewords":["MXCheckResult"]},{"Name":"TLS","Docs":"","Typewords":["TLSCheckResult"]},{"Name":"DANE","Docs":"","Typewords":["DANECheckResult"]},{"Name":"SPF","Docs":"","Typewords":["SPFCheckResult"]},{"Name":"DKIM","Docs":"","Typewords":["DKIMCheckResult"]},{"Name":"DMARC","Docs":"","Typewords":["DMARCCheckResult"]},{"Name":"HostTLSRPT","Docs":"","Typewords":["TLSRPTCheckResult"]},{"Name":"DomainTLSRPT","Docs":"","Typewords":["TLSRPTCheckResult"]},{"Name":"MTASTS","Docs":"","Typewords":["MTASTSCheckResult"]},{"Name":"SRVConf","Docs":"","Typewords":["SRVConfCheckResult"]},{"Name":"Autoconf","Docs":"","Typewords":["AutoconfCheckResult"]},{"Name":"Autodiscover","Docs":"","Typewords":["AutodiscoverCheckResult"]}]},
+	"WebAuthnLoginOptions": {"Name":"WebAuthnLoginOptions","Docs":"","Fields":[{"Name":"Challenge","Docs":"","Typewords":["string"]},{"Name":"RPID","Docs":"","Typewords":["string"]},{"Name":"CredentialIDs","Docs":"","Typewords":["[]","string"]}]},
+	"WebAuthnRegisterOptions": {"Name":"WebAuthnRegisterOptions","Docs":"","Fields":[{"Name":"Challenge","Docs":"","Typewords":["string"]},{"Name":"RPID","Docs":"","Typewords":["string"]},{"Name":"UserID","Docs":"","Typewords":["string"]},{"Name":"ExcludeCredentialIDs","Docs":"","Typewords":["[]","string"]},{"Name":"Algorithms","Docs":"","Typewords":["[]","int32"]}]},
+	"AdminWebAuthnCredential": {"Name":"AdminWebAuthnCredential","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"RPID","Docs":"","Typewords":["string"]},{"Name":"LastUsed","Docs":"","Typewords":["timestamp"]}]},
+	"AdminSession": {"Name":"AdminSession","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Expires","Docs":"","Typewords":["timestamp"]},{"Name":"Tenant","Docs":"","Typewords":["string"]},{"Name":"LastUsed","Docs":"","Typewords":["timestamp"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"UserAgent","Docs":"","Typewords":["string"]}]},
+	"CheckResult": {"Name":"CheckResult","Docs":"","Fields":[{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"DNSSEC","Docs":"","Typewords":["DNSSECResult"]},{"Name":"IPRev","Docs":"","Typewords":["IPRevCheckResult"]},{"Name":"MX","Docs":"","Typewords":["MXCheckResult"]},{"Name":"TLS","Docs":"","Typewords":["TLSCheckResult"]},{"Name":"DANE","Docs":"","Typewords":["DANECheckResult"]},{"Name":"SPF","Docs":"","Typewords":["SPFCheckResult"]},{"Name":"DKIM","Docs":"","Typewords":["DKIMCheckResult"]},{"Name":"DMARC","Docs":"","Typewords":["DMARCCheckResult"]},{"Name":"HostTLSRPT","Docs":"","Typewords":["TLSRPTCheckResult"]},{"Name":"DomainTLSRPT","Docs":"","Typewords":["TLSRPTCheckResult"]},{"Name":"MTASTS","Docs":"","Typewords":["MTASTSCheckResult"]},{"Name":"BIMI","Docs":"","Typewords":["BIMICheckResult"]},{"Name":"SRVConf","Docs":"","Typewords":["SRVConfCheckResult"]},{"Name":"Autoconf","Docs":"","Typewords":["AutoconfCheckResult"]},{"Name":"Autodiscover","Docs":"","Typewords":["AutodiscoverCheckResult"]}]},
 	"DNSSECResult": {"Name":"DNSSECResult","Docs":"","Fields":[{"Name":"Errors","Docs":"","Typewords":["[]","string"]},{"Name":"Warnings","Docs":"","Typewords":["[]","string"]},{"Name":"Instructions","Docs":"","Typewords":["[]","string"]}]},
 	"IPRevCheckResult": {"Name":"IPRevCheckResult","Docs":"","Fields":[{"Name":"Hostname","Docs":"","Typewords":["Domain"]},{"Name":"IPNames","Docs":"","Typewords":["{}","[]","string"]},{"Name":"Errors","Docs":"","Typewords":["[]","string"]},{"Name":"Warnings","Docs":"","Typewords":["[]","string"]},{"Name":"Instructions","Docs":"","Typewords":["[]","string"]}]},
 	"Domain": {"Name":"Domain","Docs":"","Fields":[{"Name":"ASCII","Docs":"","Typewords":["string"]},{"Name":"Unicode","Docs":"","Typewords":["string"]}]},
@@ -1191,28 +1490,42 @@ export const types: TypenameMap = {
 	"Pair": {"Name":"Pair","Docs":"","Fields":[{"Name":"Key","Docs":"","Typewords":["string"]},{"Name":"Value","Docs":"","Typewords":["string"]}]},
 	"Policy": {"Name":"Policy","Docs":"","Fields":[{"Name":"Version","Docs":"","Typewords":["string"]},{"Name":"Mode","Docs":"","Typewords":["Mode"]},{"Name":"MX","Docs":"","Typewords":["[]","STSMX"]},{"Name":"MaxAgeSeconds","Docs":"","Typewords":["int32"]},{"Name":"Extensions","Docs":"","Typewords":["[]","Pair"]}]},
 	"STSMX": {"Name":"STSMX","Docs":"","Fields":[{"Name":"Wildcard","Docs":"","Typewords":["bool"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]}]},
+	"BIMICheckResult": {"Name":"BIMICheckResult","Docs":"","Fields":[{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"TXT","Docs":"","Typewords":["string"]},{"Name":"Record","Docs":"","Typewords":["nullable","BIMIRecord"]},{"Name":"Errors","Docs":"","Typewords":["[]","string"]},{"Name":"Warnings","Docs":"","Typewords":["[]","string"]},{"Name":"Instructions","Docs":"","Typewords":["[]","string"]}]},
+	"BIMIRecord": {"Name":"BIMIRecord","Docs":"","Fields":[{"Name":"Version","Docs":"","Typewords":["string"]},{"Name":"Location","Docs":"","Typewords":["string"]},{"Name":"Authority","Docs":"","Typewords":["string"]}]},
 	"SRVConfCheckResult": {"Name":"SRVConfCheckResult","Docs":"","Fields":[{"Name":"SRVs","Docs":"","Typewords":["{}","[]","SRV"]},{"Name":"Errors","Docs":"","Typewords":["[]","string"]},{"Name":"Warnings","Docs":"","Typewords":["[]","string"]},{"Name":"Instructions","Docs":"","Typewords":["[]","string"]}]},
 	"SRV": {"Name":"SRV","Docs":"","Fields":[{"Name":"Target","Docs":"","Typewords":["string"]},{"Name":"Port","Docs":"","Typewords":["uint16"]},{"Name":"Priority","Docs":"","Typewords":["uint16"]},{"Name":"Weight","Docs":"","Typewords":["uint16"]}]},
 	"AutoconfCheckResult": {"Name":"AutoconfCheckResult","Docs":"","Fields":[{"Name":"ClientSettingsDomainIPs","Docs":"","Typewords":["[]","string"]},{"Name":"IPs","Docs":"","Typewords":["[]","string"]},{"Name":"Errors","Docs":"","Typewords":["[]","string"]},{"Name":"Warnings","Docs":"","Typewords":["[]","string"]},{"Name":"Instructions","Docs":"","Typewords":["[]","string"]}]},
 	"AutodiscoverCheckResult": {"Name":"AutodiscoverCheckResult","Docs":"","Fields":[{"Name":"Records","Docs":"","Typewords":["[]","AutodiscoverSRV"]},{"Name":"Errors","Docs":"","Typewords":["[]","string"]},{"Name":"Warnings","Docs":"","Typewords":["[]","string"]},{"Name":"Instructions","Docs":"","Typewords":["[]","string"]}]},
 	"AutodiscoverSRV": {"Name":"AutodiscoverSRV","Docs":"","Fields":[{"Name":"Target","Docs":"","Typewords":["string"]},{"Name":"Port","Docs":"","Typewords":["uint16"]},{"Name":"Priority","Docs":"","Typewords":["uint16"]},{"Name":"Weight","Docs":"","Typewords":["uint16"]},{"Name":"IPs","Docs":"","Typewords":["[]","string"]}]},
-	"ConfigDomain": {"Name":"ConfigDomain","Docs":"","Fields":[{"Name":"Disabled","Docs":"","Typewords":["bool"]},{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"ClientSettingsDomain","Docs":"","Typewords":["string"]},{"Name":"LocalpartCatchallSeparator","Docs":"","Typewords":["string"]},{"Name":"LocalpartCatchallSeparators","Docs":"","Typewords":["[]","string"]},{"Name":"LocalpartCaseSensitive","Docs":"","Typewords":["bool"]},{"Name":"DKIM","Docs":"","Typewords":["DKIM"]},{"Name":"DMARC","Docs":"","Typewords":["nullable","DMARC"]},{"Name":"MTASTS","Docs":"","Typewords":["nullable","MTASTS"]},{"Name":"TLSRPT","Docs":"","Typewords":["nullable","TLSRPT"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"Aliases","Docs":"","Typewords":["{}","Alias"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]},{"Name":"LocalpartCatchallSeparatorsEffective","Docs":"","Typewords":["[]","string"]}]},
-	"DKIM": {"Name":"DKIM","Docs":"","Fields":[{"Name":"Selectors","Docs":"","Typewords":["{}","Selector"]},{"Name":"Sign","Docs":"","Typewords":["[]","string"]}]},
+	"ConfigDomain": {"Name":"ConfigDomain","Docs":"","Fields":[{"Name":"Disabled","Docs":"","Typewords":["bool"]},{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"ClientSettingsDomain","Docs":"","Typewords":["string"]},{"Name":"ACME","Docs":"","Typewords":["string"]},{"Name":"AliasOf","Docs":"","Typewords":["string"]},{"Name":"LocalpartCatchallSeparator","Docs":"","Typewords":["string"]},{"Name":"LocalpartCatchallSeparators","Docs":"","Typewords":["[]","string"]},{"Name":"LocalpartCaseSensitive","Docs":"","Typewords":["bool"]},{"Name":"DKIM","Docs":"","Typewords":["DKIM"]},{"Name":"DMARC","Docs":"","Typewords":["nullable","DMARC"]},{"Name":"MTASTS","Docs":"","Typewords":["nullable","MTASTS"]},{"Name":"TLSRPT","Docs":"","Typewords":["nullable","TLSRPT"]},{"Name":"BIMI","Docs":"","Typewords":["nullable","BIMI"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"Aliases","Docs":"","Typewords":["{}","Alias"]},{"Name":"Lists","Docs":"","Typewords":["{}","List"]},{"Name":"DestinationPatterns","Docs":"","Typewords":["[]","DestinationPattern"]},{"Name":"Tenant","Docs":"","Typewords":["string"]},{"Name":"VirusScan","Docs":"","Typewords":["string"]},{"Name":"Subdomains","Docs":"","Typewords":["nullable","Subdomains"]},{"Name":"RejectedRetention","Docs":"","Typewords":["nullable","RejectedRetention"]},{"Name":"RejectText","Docs":"","Typewords":["nullable","RejectText"]},{"Name":"Retire","Docs":"","Typewords":["nullable","DomainRetire"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]},{"Name":"LocalpartCatchallSeparatorsEffective","Docs":"","Typewords":["[]","string"]}]},
+	"DKIM": {"Name":"DKIM","Docs":"","Fields":[{"Name":"Selectors","Docs":"","Typewords":["{}","Selector"]},{"Name":"Sign","Docs":"","Typewords":["[]","string"]},{"Name":"SignMailFrom","Docs":"","Typewords":["bool"]},{"Name":"SignHostname","Docs":"","Typewords":["bool"]}]},
 	"Selector": {"Name":"Selector","Docs":"","Fields":[{"Name":"Hash","Docs":"","Typewords":["string"]},{"Name":"HashEffective","Docs":"","Typewords":["string"]},{"Name":"Canonicalization","Docs":"","Typewords":["Canonicalization"]},{"Name":"Headers","Docs":"","Typewords":["[]","string"]},{"Name":"HeadersEffective","Docs":"","Typewords":["[]","string"]},{"Name":"DontSealHeaders","Docs":"","Typewords":["bool"]},{"Name":"Expiration","Docs":"","Typewords":["string"]},{"Name":"PrivateKeyFile","Docs":"","Typewords":["string"]},{"Name":"Algorithm","Docs":"","Typewords":["string"]}]},
 	"Canonicalization": {"Name":"Canonicalization","Docs":"","Fields":[{"Name":"HeaderRelaxed","Docs":"","Typewords":["bool"]},{"Name":"BodyRelaxed","Docs":"","Typewords":["bool"]}]},
 	"DMARC": {"Name":"DMARC","Docs":"","Fields":[{"Name":"Localpart","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"ParsedLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]}]},
 	"MTASTS": {"Name":"MTASTS","Docs":"","Fields":[{"Name":"PolicyID","Docs":"","Typewords":["string"]},{"Name":"Mode","Docs":"","Typewords":["Mode"]},{"Name":"MaxAge","Docs":"","Typewords":["int64"]},{"Name":"MX","Docs":"","Typewords":["[]","string"]}]},
 	"TLSRPT": {"Name":"TLSRPT","Docs":"","Fields":[{"Name":"Localpart","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"ParsedLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]}]},
+	"BIMI": {"Name":"BIMI","Docs":"","Fields":[{"Name":"Selector","Docs":"","Typewords":["string"]},{"Name":"Location","Docs":"","Typewords":["string"]},{"Name":"Authority","Docs":"","Typewords":["string"]},{"Name":"SelectorHeader","Docs":"","Typewords":["bool"]}]},
 	"Route": {"Name":"Route","Docs":"","Fields":[{"Name":"FromDomain","Docs":"","Typewords":["[]","string"]},{"Name":"ToDomain","Docs":"","Typewords":["[]","string"]},{"Name":"MinimumAttempts","Docs":"","Typewords":["int32"]},{"Name":"Transport","Docs":"","Typewords":["string"]},{"Name":"FromDomainASCII","Docs":"","Typewords":["[]","string"]},{"Name":"ToDomainASCII","Docs":"","Typewords":["[]","string"]}]},
 	"Alias": {"Name":"Alias","Docs":"","Fields":[{"Name":"Addresses","Docs":"","Typewords":["[]","string"]},{"Name":"PostPublic","Docs":"","Typewords":["bool"]},{"Name":"ListMembers","Docs":"","Typewords":["bool"]},{"Name":"AllowMsgFrom","Docs":"","Typewords":["bool"]},{"Name":"LocalpartStr","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]},{"Name":"ParsedAddresses","Docs":"","Typewords":["[]","AliasAddress"]}]},
 	"AliasAddress": {"Name":"AliasAddress","Docs":"","Fields":[{"Name":"Address","Docs":"","Typewords":["Address"]},{"Name":"AccountName","Docs":"","Typewords":["string"]},{"Name":"Destination","Docs":"","Typewords":["Destination"]}]},
 	"Address": {"Name":"Address","Docs":"","Fields":[{"Name":"Localpart","Docs":"","Typewords":["Localpart"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]}]},
-	"Destination": {"Name":"Destination","Docs":"","Fields":[{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Rulesets","Docs":"","Typewords":["[]","Ruleset"]},{"Name":"SMTPError","Docs":"","Typewords":["string"]},{"Name":"MessageAuthRequiredSMTPError","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]}]},
-	"Ruleset": {"Name":"Ruleset","Docs":"","Fields":[{"Name":"SMTPMailFromRegexp","Docs":"","Typewords":["string"]},{"Name":"MsgFromRegexp","Docs":"","Typewords":["string"]},{"Name":"VerifiedDomain","Docs":"","Typewords":["string"]},{"Name":"HeadersRegexp","Docs":"","Typewords":["{}","string"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"ListAllowDomain","Docs":"","Typewords":["string"]},{"Name":"AcceptRejectsToMailbox","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Comment","Docs":"","Typewords":["string"]},{"Name":"VerifiedDNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"ListAllowDNSDomain","Docs":"","Typewords":["Domain"]}]},
-	"Account": {"Name":"Account","Docs":"","Fields":[{"Name":"OutgoingWebhook","Docs":"","Typewords":["nullable","OutgoingWebhook"]},{"Name":"IncomingWebhook","Docs":"","Typewords":["nullable","IncomingWebhook"]},{"Name":"FromIDLoginAddresses","Docs":"","Typewords":["[]","string"]},{"Name":"KeepRetiredMessagePeriod","Docs":"","Typewords":["int64"]},{"Name":"KeepRetiredWebhookPeriod","Docs":"","Typewords":["int64"]},{"Name":"LoginDisabled","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]},{"Name":"Destinations","Docs":"","Typewords":["{}","Destination"]},{"Name":"SubjectPass","Docs":"","Typewords":["SubjectPass"]},{"Name":"QuotaMessageSize","Docs":"","Typewords":["int64"]},{"Name":"RejectsMailbox","Docs":"","Typewords":["string"]},{"Name":"KeepRejects","Docs":"","Typewords":["bool"]},{"Name":"AutomaticJunkFlags","Docs":"","Typewords":["AutomaticJunkFlags"]},{"Name":"JunkFilter","Docs":"","Typewords":["nullable","JunkFilter"]},{"Name":"MaxOutgoingMessagesPerDay","Docs":"","Typewords":["int32"]},{"Name":"MaxFirstTimeRecipientsPerDay","Docs":"","Typewords":["int32"]},{"Name":"NoFirstTimeSenderDelay","Docs":"","Typewords":["bool"]},{"Name":"NoCustomPassword","Docs":"","Typewords":["bool"]},{"Name":"IMAPCapabilitiesDisabled","Docs":"","Typewords":["[]","string"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"Aliases","Docs":"","Typewords":["[]","AddressAlias"]}]},
+	"Destination": {"Name":"Destination","Docs":"","Fields":[{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Rulesets","Docs":"","Typewords":["[]","Ruleset"]},{"Name":"SMTPError","Docs":"","Typewords":["string"]},{"Name":"MessageAuthRequiredSMTPError","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]},{"Name":"ForwardTo","Docs":"","Typewords":["[]","string"]},{"Name":"ForwardKeepCopy","Docs":"","Typewords":["bool"]}]},
+	"Ruleset": {"Name":"Ruleset","Docs":"","Fields":[{"Name":"SMTPMailFromRegexp","Docs":"","Typewords":["string"]},{"Name":"MsgFromRegexp","Docs":"","Typewords":["string"]},{"Name":"VerifiedDomain","Docs":"","Typewords":["string"]},{"Name":"HeadersRegexp","Docs":"","Typewords":["{}","string"]},{"Name":"MsgFromPatterns","Docs":"","Typewords":["[]","string"]},{"Name":"SPFResult","Docs":"","Typewords":["string"]},{"Name":"DKIMResult","Docs":"","Typewords":["string"]},{"Name":"DMARCResult","Docs":"","Typewords":["string"]},{"Name":"MinSize","Docs":"","Typewords":["int64"]},{"Name":"MaxSize","Docs":"","Typewords":["int64"]},{"Name":"Attachments","Docs":"","Typewords":["string"]},{"Name":"ListID","Docs":"","Typewords":["string"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"ListAllowDomain","Docs":"","Typewords":["string"]},{"Name":"AcceptRejectsToMailbox","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"MarkSeen","Docs":"","Typewords":["bool"]},{"Name":"ForwardTo","Docs":"","Typewords":["[]","string"]},{"Name":"Discard","Docs":"","Typewords":["bool"]},{"Name":"Comment","Docs":"","Typewords":["string"]},{"Name":"VerifiedDNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"ListAllowDNSDomain","Docs":"","Typewords":["Domain"]}]},
+	"List": {"Name":"List","Docs":"","Fields":[{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"SubjectPrefix","Docs":"","Typewords":["string"]},{"Name":"PostPublic","Docs":"","Typewords":["bool"]},{"Name":"Moderated","Docs":"","Typewords":["bool"]},{"Name":"LocalpartStr","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]}]},
+	"DestinationPattern": {"Name":"DestinationPattern","Docs":"","Fields":[{"Name":"Localpart","Docs":"","Typewords":["string"]},{"Name":"LocalpartRegexp","Docs":"","Typewords":["string"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]}]},
+	"Subdomains": {"Name":"Subdomains","Docs":"","Fields":[{"Name":"Routes","Docs":"","Typewords":["[]","SubdomainRoute"]},{"Name":"DMARCPolicy","Docs":"","Typewords":["string"]}]},
+	"SubdomainRoute": {"Name":"SubdomainRoute","Docs":"","Fields":[{"Name":"Subdomain","Docs":"","Typewords":["string"]},{"Name":"Deliver","Docs":"","Typewords":["string"]}]},
+	"RejectedRetention": {"Name":"RejectedRetention","Docs":"","Fields":[{"Name":"MaxAge","Docs":"","Typewords":["int64"]},{"Name":"MaxSize","Docs":"","Typewords":["int64"]}]},
+	"RejectText": {"Name":"RejectText","Docs":"","Fields":[{"Name":"ContactURL","Docs":"","Typewords":["string"]},{"Name":"Default","Docs":"","Typewords":["string"]},{"Name":"Reasons","Docs":"","Typewords":["{}","string"]}]},
+	"DomainRetire": {"Name":"DomainRetire","Docs":"","Fields":[{"Name":"NewDomain","Docs":"","Typewords":["string"]},{"Name":"Message","Docs":"","Typewords":["string"]},{"Name":"RejectAfter","Docs":"","Typewords":["string"]},{"Name":"LoginUntil","Docs":"","Typewords":["string"]}]},
+	"Account": {"Name":"Account","Docs":"","Fields":[{"Name":"OutgoingWebhook","Docs":"","Typewords":["nullable","OutgoingWebhook"]},{"Name":"IncomingWebhook","Docs":"","Typewords":["nullable","IncomingWebhook"]},{"Name":"FromIDLoginAddresses","Docs":"","Typewords":["[]","string"]},{"Name":"KeepRetiredMessagePeriod","Docs":"","Typewords":["int64"]},{"Name":"KeepRetiredWebhookPeriod","Docs":"","Typewords":["int64"]},{"Name":"DeliveryPriority","Docs":"","Typewords":["int32"]},{"Name":"AttachmentLinks","Docs":"","Typewords":["nullable","AttachmentLinks"]},{"Name":"WeeklyDigest","Docs":"","Typewords":["bool"]},{"Name":"SubaddressMailboxes","Docs":"","Typewords":["bool"]},{"Name":"Notifications","Docs":"","Typewords":["[]","Notification"]},{"Name":"LoginDisabled","Docs":"","Typewords":["string"]},{"Name":"ReadOnly","Docs":"","Typewords":["bool"]},{"Name":"SubmissionDisabled","Docs":"","Typewords":["string"]},{"Name":"IMAPReferralHost","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]},{"Name":"Destinations","Docs":"","Typewords":["{}","Destination"]},{"Name":"SubjectPass","Docs":"","Typewords":["SubjectPass"]},{"Name":"QuotaMessageSize","Docs":"","Typewords":["int64"]},{"Name":"RejectsMailbox","Docs":"","Typewords":["string"]},{"Name":"KeepRejects","Docs":"","Typewords":["bool"]},{"Name":"RejectsRescueAllow","Docs":"","Typewords":["string"]},{"Name":"MailboxRetention","Docs":"","Typewords":["[]","MailboxRetention"]},{"Name":"AutoArchive","Docs":"","Typewords":["nullable","AutoArchive"]},{"Name":"AutomaticJunkFlags","Docs":"","Typewords":["AutomaticJunkFlags"]},{"Name":"JunkFilter","Docs":"","Typewords":["nullable","JunkFilter"]},{"Name":"MaxOutgoingMessagesPerDay","Docs":"","Typewords":["int32"]},{"Name":"MaxFirstTimeRecipientsPerDay","Docs":"","Typewords":["int32"]},{"Name":"HoldOverSendLimits","Docs":"","Typewords":["bool"]},{"Name":"NoFirstTimeSenderDelay","Docs":"","Typewords":["bool"]},{"Name":"NoAutoCreateMailboxes","Docs":"","Typewords":["bool"]},{"Name":"NoCustomPassword","Docs":"","Typewords":["bool"]},{"Name":"IMAPCapabilitiesDisabled","Docs":"","Typewords":["[]","string"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"Aliases","Docs":"","Typewords":["[]","AddressAlias"]}]},
 	"OutgoingWebhook": {"Name":"OutgoingWebhook","Docs":"","Fields":[{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"Authorization","Docs":"","Typewords":["string"]},{"Name":"Events","Docs":"","Typewords":["[]","string"]}]},
 	"IncomingWebhook": {"Name":"IncomingWebhook","Docs":"","Fields":[{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"Authorization","Docs":"","Typewords":["string"]}]},
+	"AttachmentLinks": {"Name":"AttachmentLinks","Docs":"","Fields":[{"Name":"MinSize","Docs":"","Typewords":["int64"]},{"Name":"ValidityDays","Docs":"","Typewords":["int32"]}]},
+	"Notification": {"Name":"Notification","Docs":"","Fields":[{"Name":"Type","Docs":"","Typewords":["string"]},{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"Token","Docs":"","Typewords":["string"]},{"Name":"Mailboxes","Docs":"","Typewords":["[]","string"]},{"Name":"FromRegexp","Docs":"","Typewords":["string"]},{"Name":"SubjectRegexp","Docs":"","Typewords":["string"]}]},
 	"SubjectPass": {"Name":"SubjectPass","Docs":"","Fields":[{"Name":"Period","Docs":"","Typewords":["int64"]}]},
+	"MailboxRetention": {"Name":"MailboxRetention","Docs":"","Fields":[{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Period","Docs":"","Typewords":["int64"]}]},
+	"AutoArchive": {"Name":"AutoArchive","Docs":"","Fields":[{"Name":"Period","Docs":"","Typewords":["int64"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]}]},
 	"AutomaticJunkFlags": {"Name":"AutomaticJunkFlags","Docs":"","Fields":[{"Name":"Enabled","Docs":"","Typewords":["bool"]},{"Name":"JunkMailboxRegexp","Docs":"","Typewords":["string"]},{"Name":"NeutralMailboxRegexp","Docs":"","Typewords":["string"]},{"Name":"NotJunkMailboxRegexp","Docs":"","Typewords":["string"]}]},
 	"JunkFilter": {"Name":"JunkFilter","Docs":"","Fields":[{"Name":"Threshold","Docs":"","Typewords":["float64"]},{"Name":"Onegrams","Docs":"","Typewords":["bool"]},{"Name":"Twograms","Docs":"","Typewords":["bool"]},{"Name":"Threegrams","Docs":"","Typewords":["bool"]},{"Name":"MaxPower","Docs":"","Typewords":["float64"]},{"Name":"TopWords","Docs":"","Typewords":["int32"]},{"Name":"IgnoreWords","Docs":"","Typewords":["float64"]},{"Name":"RareWords","Docs":"","Typewords":["int32"]}]},
 	"AddressAlias": {"Name":"AddressAlias","Docs":"","Fields":[{"Name":"SubscriptionAddress","Docs":"","Typewords":["string"]},{"Name":"Alias","Docs":"","Typewords":["Alias"]},{"Name":"MemberAddresses","Docs":"","Typewords":["[]","string"]}]},
@@ -1244,12 +1557,13 @@ export const types: TypenameMap = {
 	"HoldRule": {"Name":"HoldRule","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"SenderDomain","Docs":"","Typewords":["Domain"]},{"Name":"RecipientDomain","Docs":"","Typewords":["Domain"]},{"Name":"SenderDomainStr","Docs":"","Typewords":["string"]},{"Name":"RecipientDomainStr","Docs":"","Typewords":["string"]}]},
 	"Filter": {"Name":"Filter","Docs":"","Fields":[{"Name":"Max","Docs":"","Typewords":["int32"]},{"Name":"IDs","Docs":"","Typewords":["[]","int64"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"From","Docs":"","Typewords":["string"]},{"Name":"To","Docs":"","Typewords":["string"]},{"Name":"Hold","Docs":"","Typewords":["nullable","bool"]},{"Name":"Submitted","Docs":"","Typewords":["string"]},{"Name":"NextAttempt","Docs":"","Typewords":["string"]},{"Name":"Transport","Docs":"","Typewords":["nullable","string"]}]},
 	"Sort": {"Name":"Sort","Docs":"","Fields":[{"Name":"Field","Docs":"","Typewords":["string"]},{"Name":"LastID","Docs":"","Typewords":["int64"]},{"Name":"Last","Docs":"","Typewords":["any"]},{"Name":"Asc","Docs":"","Typewords":["bool"]}]},
-	"Msg": {"Name":"Msg","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"BaseID","Docs":"","Typewords":["int64"]},{"Name":"Queued","Docs":"","Typewords":["timestamp"]},{"Name":"Hold","Docs":"","Typewords":["bool"]},{"Name":"SenderAccount","Docs":"","Typewords":["string"]},{"Name":"SenderLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"SenderDomain","Docs":"","Typewords":["IPDomain"]},{"Name":"SenderDomainStr","Docs":"","Typewords":["string"]},{"Name":"FromID","Docs":"","Typewords":["string"]},{"Name":"RecipientLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"RecipientDomain","Docs":"","Typewords":["IPDomain"]},{"Name":"RecipientDomainStr","Docs":"","Typewords":["string"]},{"Name":"Attempts","Docs":"","Typewords":["int32"]},{"Name":"MaxAttempts","Docs":"","Typewords":["int32"]},{"Name":"DialedIPs","Docs":"","Typewords":["{}","[]","IP"]},{"Name":"NextAttempt","Docs":"","Typewords":["timestamp"]},{"Name":"LastAttempt","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"Results","Docs":"","Typewords":["[]","MsgResult"]},{"Name":"Has8bit","Docs":"","Typewords":["bool"]},{"Name":"SMTPUTF8","Docs":"","Typewords":["bool"]},{"Name":"IsDMARCReport","Docs":"","Typewords":["bool"]},{"Name":"IsTLSReport","Docs":"","Typewords":["bool"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"MsgPrefix","Docs":"","Typewords":["nullable","string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"DSNUTF8","Docs":"","Typewords":["nullable","string"]},{"Name":"Transport","Docs":"","Typewords":["string"]},{"Name":"RequireTLS","Docs":"","Typewords":["nullable","bool"]},{"Name":"FutureReleaseRequest","Docs":"","Typewords":["string"]},{"Name":"Extra","Docs":"","Typewords":["{}","string"]}]},
+	"Msg": {"Name":"Msg","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"BaseID","Docs":"","Typewords":["int64"]},{"Name":"Queued","Docs":"","Typewords":["timestamp"]},{"Name":"Hold","Docs":"","Typewords":["bool"]},{"Name":"HoldReason","Docs":"","Typewords":["string"]},{"Name":"SenderAccount","Docs":"","Typewords":["string"]},{"Name":"SenderLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"SenderDomain","Docs":"","Typewords":["IPDomain"]},{"Name":"SenderDomainStr","Docs":"","Typewords":["string"]},{"Name":"FromID","Docs":"","Typewords":["string"]},{"Name":"RecipientLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"RecipientDomain","Docs":"","Typewords":["IPDomain"]},{"Name":"RecipientDomainStr","Docs":"","Typewords":["string"]},{"Name":"Attempts","Docs":"","Typewords":["int32"]},{"Name":"MaxAttempts","Docs":"","Typewords":["int32"]},{"Name":"DialedIPs","Docs":"","Typewords":["{}","[]","IP"]},{"Name":"NextAttempt","Docs":"","Typewords":["timestamp"]},{"Name":"LastAttempt","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"Results","Docs":"","Typewords":["[]","MsgResult"]},{"Name":"Priority","Docs":"","Typewords":["int32"]},{"Name":"Has8bit","Docs":"","Typewords":["bool"]},{"Name":"SMTPUTF8","Docs":"","Typewords":["bool"]},{"Name":"IsDMARCReport","Docs":"","Typewords":["bool"]},{"Name":"IsTLSReport","Docs":"","Typewords":["bool"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"MsgPrefix","Docs":"","Typewords":["nullable","string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"DSNUTF8","Docs":"","Typewords":["nullable","string"]},{"Name":"Transport","Docs":"","Typewords":["string"]},{"Name":"RequireTLS","Docs":"","Typewords":["nullable","bool"]},{"Name":"RequireVerifiedTLS","Docs":"","Typewords":["bool"]},{"Name":"FutureReleaseRequest","Docs":"","Typewords":["string"]},{"Name":"DSNNotify","Docs":"","Typewords":["string"]},{"Name":"DSNRet","Docs":"","Typewords":["string"]},{"Name":"DSNEnvID","Docs":"","Typewords":["string"]},{"Name":"DSNOrigRecipient","Docs":"","Typewords":["string"]},{"Name":"Extra","Docs":"","Typewords":["{}","string"]}]},
 	"IPDomain": {"Name":"IPDomain","Docs":"","Fields":[{"Name":"IP","Docs":"","Typewords":["IP"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]}]},
-	"MsgResult": {"Name":"MsgResult","Docs":"","Fields":[{"Name":"Start","Docs":"","Typewords":["timestamp"]},{"Name":"Duration","Docs":"","Typewords":["int64"]},{"Name":"Success","Docs":"","Typewords":["bool"]},{"Name":"Code","Docs":"","Typewords":["int32"]},{"Name":"Secode","Docs":"","Typewords":["string"]},{"Name":"Error","Docs":"","Typewords":["string"]}]},
+	"MsgResult": {"Name":"MsgResult","Docs":"","Fields":[{"Name":"Start","Docs":"","Typewords":["timestamp"]},{"Name":"Duration","Docs":"","Typewords":["int64"]},{"Name":"Success","Docs":"","Typewords":["bool"]},{"Name":"Code","Docs":"","Typewords":["int32"]},{"Name":"Secode","Docs":"","Typewords":["string"]},{"Name":"Error","Docs":"","Typewords":["string"]},{"Name":"Hosts","Docs":"","Typewords":["[]","MsgResultHost"]}]},
+	"MsgResultHost": {"Name":"MsgResultHost","Docs":"","Fields":[{"Name":"Host","Docs":"","Typewords":["string"]},{"Name":"IP","Docs":"","Typewords":["string"]},{"Name":"Start","Docs":"","Typewords":["timestamp"]},{"Name":"Duration","Docs":"","Typewords":["int64"]},{"Name":"Success","Docs":"","Typewords":["bool"]},{"Name":"Code","Docs":"","Typewords":["int32"]},{"Name":"Secode","Docs":"","Typewords":["string"]},{"Name":"Error","Docs":"","Typewords":["string"]},{"Name":"TLS","Docs":"","Typewords":["bool"]},{"Name":"TLSMode","Docs":"","Typewords":["string"]},{"Name":"TLSFailures","Docs":"","Typewords":["[]","string"]}]},
 	"RetiredFilter": {"Name":"RetiredFilter","Docs":"","Fields":[{"Name":"Max","Docs":"","Typewords":["int32"]},{"Name":"IDs","Docs":"","Typewords":["[]","int64"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"From","Docs":"","Typewords":["string"]},{"Name":"To","Docs":"","Typewords":["string"]},{"Name":"Submitted","Docs":"","Typewords":["string"]},{"Name":"LastActivity","Docs":"","Typewords":["string"]},{"Name":"Transport","Docs":"","Typewords":["nullable","string"]},{"Name":"Success","Docs":"","Typewords":["nullable","bool"]}]},
 	"RetiredSort": {"Name":"RetiredSort","Docs":"","Fields":[{"Name":"Field","Docs":"","Typewords":["string"]},{"Name":"LastID","Docs":"","Typewords":["int64"]},{"Name":"Last","Docs":"","Typewords":["any"]},{"Name":"Asc","Docs":"","Typewords":["bool"]}]},
-	"MsgRetired": {"Name":"MsgRetired","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"BaseID","Docs":"","Typewords":["int64"]},{"Name":"Queued","Docs":"","Typewords":["timestamp"]},{"Name":"SenderAccount","Docs":"","Typewords":["string"]},{"Name":"SenderLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"SenderDomainStr","Docs":"","Typewords":["string"]},{"Name":"FromID","Docs":"","Typewords":["string"]},{"Name":"RecipientLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"RecipientDomain","Docs":"","Typewords":["IPDomain"]},{"Name":"RecipientDomainStr","Docs":"","Typewords":["string"]},{"Name":"Attempts","Docs":"","Typewords":["int32"]},{"Name":"MaxAttempts","Docs":"","Typewords":["int32"]},{"Name":"DialedIPs","Docs":"","Typewords":["{}","[]","IP"]},{"Name":"LastAttempt","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"Results","Docs":"","Typewords":["[]","MsgResult"]},{"Name":"Has8bit","Docs":"","Typewords":["bool"]},{"Name":"SMTPUTF8","Docs":"","Typewords":["bool"]},{"Name":"IsDMARCReport","Docs":"","Typewords":["bool"]},{"Name":"IsTLSReport","Docs":"","Typewords":["bool"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"Transport","Docs":"","Typewords":["string"]},{"Name":"RequireTLS","Docs":"","Typewords":["nullable","bool"]},{"Name":"FutureReleaseRequest","Docs":"","Typewords":["string"]},{"Name":"Extra","Docs":"","Typewords":["{}","string"]},{"Name":"LastActivity","Docs":"","Typewords":["timestamp"]},{"Name":"RecipientAddress","Docs":"","Typewords":["string"]},{"Name":"Success","Docs":"","Typewords":["bool"]},{"Name":"KeepUntil","Docs":"","Typewords":["timestamp"]}]},
+	"MsgRetired": {"Name":"MsgRetired","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"BaseID","Docs":"","Typewords":["int64"]},{"Name":"Queued","Docs":"","Typewords":["timestamp"]},{"Name":"SenderAccount","Docs":"","Typewords":["string"]},{"Name":"SenderLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"SenderDomainStr","Docs":"","Typewords":["string"]},{"Name":"FromID","Docs":"","Typewords":["string"]},{"Name":"RecipientLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"RecipientDomain","Docs":"","Typewords":["IPDomain"]},{"Name":"RecipientDomainStr","Docs":"","Typewords":["string"]},{"Name":"Attempts","Docs":"","Typewords":["int32"]},{"Name":"MaxAttempts","Docs":"","Typewords":["int32"]},{"Name":"DialedIPs","Docs":"","Typewords":["{}","[]","IP"]},{"Name":"LastAttempt","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"Results","Docs":"","Typewords":["[]","MsgResult"]},{"Name":"Has8bit","Docs":"","Typewords":["bool"]},{"Name":"SMTPUTF8","Docs":"","Typewords":["bool"]},{"Name":"IsDMARCReport","Docs":"","Typewords":["bool"]},{"Name":"IsTLSReport","Docs":"","Typewords":["bool"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"Transport","Docs":"","Typewords":["string"]},{"Name":"RequireTLS","Docs":"","Typewords":["nullable","bool"]},{"Name":"RequireVerifiedTLS","Docs":"","Typewords":["bool"]},{"Name":"FutureReleaseRequest","Docs":"","Typewords":["string"]},{"Name":"Extra","Docs":"","Typewords":["{}","string"]},{"Name":"LastActivity","Docs":"","Typewords":["timestamp"]},{"Name":"RecipientAddress","Docs":"","Typewords":["string"]},{"Name":"Success","Docs":"","Typewords":["bool"]},{"Name":"KeepUntil","Docs":"","Typewords":["timestamp"]}]},
 	"HookFilter": {"Name":"HookFilter","Docs":"","Fields":[{"Name":"Max","Docs":"","Typewords":["int32"]},{"Name":"IDs","Docs":"","Typewords":["[]","int64"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Submitted","Docs":"","Typewords":["string"]},{"Name":"NextAttempt","Docs":"","Typewords":["string"]},{"Name":"Event","Docs":"","Typewords":["string"]}]},
 	"HookSort": {"Name":"HookSort","Docs":"","Fields":[{"Name":"Field","Docs":"","Typewords":["string"]},{"Name":"LastID","Docs":"","Typewords":["int64"]},{"Name":"Last","Docs":"","Typewords":["any"]},{"Name":"Asc","Docs":"","Typewords":["bool"]}]},
 	"Hook": {"Name":"Hook","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"QueueMsgID","Docs":"","Typewords":["int64"]},{"Name":"FromID","Docs":"","Typewords":["string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"Extra","Docs":"","Typewords":["{}","string"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"Authorization","Docs":"","Typewords":["string"]},{"Name":"IsIncoming","Docs":"","Typewords":["bool"]},{"Name":"OutgoingEvent","Docs":"","Typewords":["string"]},{"Name":"Payload","Docs":"","Typewords":["string"]},{"Name":"Submitted","Docs":"","Typewords":["timestamp"]},{"Name":"Attempts","Docs":"","Typewords":["int32"]},{"Name":"NextAttempt","Docs":"","Typewords":["timestamp"]},{"Name":"Results","Docs":"","Typewords":["[]","HookResult"]}]},
@@ -1264,19 +1578,25 @@ export const types: TypenameMap = {
 	"WebForward": {"Name":"WebForward","Docs":"","Fields":[{"Name":"StripPath","Docs":"","Typewords":["bool"]},{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"ResponseHeaders","Docs":"","Typewords":["{}","string"]}]},
 	"WebInternal": {"Name":"WebInternal","Docs":"","Fields":[{"Name":"BasePath","Docs":"","Typewords":["string"]},{"Name":"Service","Docs":"","Typewords":["string"]}]},
 	"Transport": {"Name":"Transport","Docs":"","Fields":[{"Name":"Submissions","Docs":"","Typewords":["nullable","TransportSMTP"]},{"Name":"Submission","Docs":"","Typewords":["nullable","TransportSMTP"]},{"Name":"SMTP","Docs":"","Typewords":["nullable","TransportSMTP"]},{"Name":"Socks","Docs":"","Typewords":["nullable","TransportSocks"]},{"Name":"Direct","Docs":"","Typewords":["nullable","TransportDirect"]},{"Name":"Fail","Docs":"","Typewords":["nullable","TransportFail"]}]},
-	"TransportSMTP": {"Name":"TransportSMTP","Docs":"","Fields":[{"Name":"Host","Docs":"","Typewords":["string"]},{"Name":"Port","Docs":"","Typewords":["int32"]},{"Name":"STARTTLSInsecureSkipVerify","Docs":"","Typewords":["bool"]},{"Name":"NoSTARTTLS","Docs":"","Typewords":["bool"]},{"Name":"Auth","Docs":"","Typewords":["nullable","SMTPAuth"]}]},
+	"TransportSMTP": {"Name":"TransportSMTP","Docs":"","Fields":[{"Name":"Host","Docs":"","Typewords":["string"]},{"Name":"Port","Docs":"","Typewords":["int32"]},{"Name":"STARTTLSInsecureSkipVerify","Docs":"","Typewords":["bool"]},{"Name":"NoSTARTTLS","Docs":"","Typewords":["bool"]},{"Name":"Auth","Docs":"","Typewords":["nullable","SMTPAuth"]},{"Name":"Weight","Docs":"","Typewords":["int32"]},{"Name":"Hosts","Docs":"","Typewords":["[]","TransportSMTPHost"]}]},
 	"SMTPAuth": {"Name":"SMTPAuth","Docs":"","Fields":[{"Name":"Username","Docs":"","Typewords":["string"]},{"Name":"Password","Docs":"","Typewords":["string"]},{"Name":"Mechanisms","Docs":"","Typewords":["[]","string"]}]},
-	"TransportSocks": {"Name":"TransportSocks","Docs":"","Fields":[{"Name":"Address","Docs":"","Typewords":["string"]},{"Name":"RemoteIPs","Docs":"","Typewords":["[]","string"]},{"Name":"RemoteHostname","Docs":"","Typewords":["string"]}]},
-	"TransportDirect": {"Name":"TransportDirect","Docs":"","Fields":[{"Name":"DisableIPv4","Docs":"","Typewords":["bool"]},{"Name":"DisableIPv6","Docs":"","Typewords":["bool"]}]},
+	"TransportSMTPHost": {"Name":"TransportSMTPHost","Docs":"","Fields":[{"Name":"Host","Docs":"","Typewords":["string"]},{"Name":"Port","Docs":"","Typewords":["int32"]},{"Name":"Priority","Docs":"","Typewords":["int32"]},{"Name":"Weight","Docs":"","Typewords":["int32"]}]},
+	"TransportSocks": {"Name":"TransportSocks","Docs":"","Fields":[{"Name":"Address","Docs":"","Typewords":["string"]},{"Name":"RemoteIPs","Docs":"","Typewords":["[]","string"]},{"Name":"RemoteHostname","Docs":"","Typewords":["string"]},{"Name":"RemoteResolve","Docs":"","Typewords":["bool"]}]},
+	"TransportDirect": {"Name":"TransportDirect","Docs":"","Fields":[{"Name":"DisableIPv4","Docs":"","Typewords":["bool"]},{"Name":"DisableIPv6","Docs":"","Typewords":["bool"]},{"Name":"RequireVerifiedTLS","Docs":"","Typewords":["bool"]}]},
 	"TransportFail": {"Name":"TransportFail","Docs":"","Fields":[{"Name":"SMTPCode","Docs":"","Typewords":["int32"]},{"Name":"SMTPMessage","Docs":"","Typewords":["string"]},{"Name":"Code","Docs":"","Typewords":["int32"]},{"Name":"Message","Docs":"","Typewords":["string"]}]},
 	"EvaluationStat": {"Name":"EvaluationStat","Docs":"","Fields":[{"Name":"Domain","Docs":"","Typewords":["Domain"]},{"Name":"Dispositions","Docs":"","Typewords":["[]","string"]},{"Name":"Count","Docs":"","Typewords":["int32"]},{"Name":"SendReport","Docs":"","Typewords":["bool"]}]},
 	"Evaluation": {"Name":"Evaluation","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"PolicyDomain","Docs":"","Typewords":["string"]},{"Name":"Evaluated","Docs":"","Typewords":["timestamp"]},{"Name":"Optional","Docs":"","Typewords":["bool"]},{"Name":"IntervalHours","Docs":"","Typewords":["int32"]},{"Name":"Addresses","Docs":"","Typewords":["[]","string"]},{"Name":"PolicyPublished","Docs":"","Typewords":["PolicyPublished"]},{"Name":"SourceIP","Docs":"","Typewords":["string"]},{"Name":"Disposition","Docs":"","Typewords":["string"]},{"Name":"AlignedDKIMPass","Docs":"","Typewords":["bool"]},{"Name":"AlignedSPFPass","Docs":"","Typewords":["bool"]},{"Name":"OverrideReasons","Docs":"","Typewords":["[]","PolicyOverrideReason"]},{"Name":"EnvelopeTo","Docs":"","Typewords":["string"]},{"Name":"EnvelopeFrom","Docs":"","Typewords":["string"]},{"Name":"HeaderFrom","Docs":"","Typewords":["string"]},{"Name":"DKIMResults","Docs":"","Typewords":["[]","DKIMAuthResult"]},{"Name":"SPFResults","Docs":"","Typewords":["[]","SPFAuthResult"]}]},
 	"SuppressAddress": {"Name":"SuppressAddress","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Inserted","Docs":"","Typewords":["timestamp"]},{"Name":"ReportingAddress","Docs":"","Typewords":["string"]},{"Name":"Until","Docs":"","Typewords":["timestamp"]},{"Name":"Comment","Docs":"","Typewords":["string"]}]},
 	"TLSResult": {"Name":"TLSResult","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"PolicyDomain","Docs":"","Typewords":["string"]},{"Name":"DayUTC","Docs":"","Typewords":["string"]},{"Name":"RecipientDomain","Docs":"","Typewords":["string"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Updated","Docs":"","Typewords":["timestamp"]},{"Name":"IsHost","Docs":"","Typewords":["bool"]},{"Name":"SendReport","Docs":"","Typewords":["bool"]},{"Name":"SentToRecipientDomain","Docs":"","Typewords":["bool"]},{"Name":"RecipientDomainReportingAddresses","Docs":"","Typewords":["[]","string"]},{"Name":"SentToPolicyDomain","Docs":"","Typewords":["bool"]},{"Name":"Results","Docs":"","Typewords":["[]","Result"]}]},
 	"TLSRPTSuppressAddress": {"Name":"TLSRPTSuppressAddress","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Inserted","Docs":"","Typewords":["timestamp"]},{"Name":"ReportingAddress","Docs":"","Typewords":["string"]},{"Name":"Until","Docs":"","Typewords":["timestamp"]},{"Name":"Comment","Docs":"","Typewords":["string"]}]},
-	"Dynamic": {"Name":"Dynamic","Docs":"","Fields":[{"Name":"Domains","Docs":"","Typewords":["{}","ConfigDomain"]},{"Name":"Accounts","Docs":"","Typewords":["{}","Account"]},{"Name":"WebDomainRedirects","Docs":"","Typewords":["{}","string"]},{"Name":"WebHandlers","Docs":"","Typewords":["[]","WebHandler"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"MonitorDNSBLs","Docs":"","Typewords":["[]","string"]},{"Name":"MonitorDNSBLZones","Docs":"","Typewords":["[]","Domain"]}]},
+	"Dynamic": {"Name":"Dynamic","Docs":"","Fields":[{"Name":"Domains","Docs":"","Typewords":["{}","ConfigDomain"]},{"Name":"Accounts","Docs":"","Typewords":["{}","Account"]},{"Name":"Tenants","Docs":"","Typewords":["{}","Tenant"]},{"Name":"WebDomainRedirects","Docs":"","Typewords":["{}","string"]},{"Name":"WebHandlers","Docs":"","Typewords":["[]","WebHandler"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"MonitorDNSBLs","Docs":"","Typewords":["[]","string"]},{"Name":"Canaries","Docs":"","Typewords":["{}","Canary"]},{"Name":"MonitorDNSBLZones","Docs":"","Typewords":["[]","Domain"]}]},
+	"Tenant": {"Name":"Tenant","Docs":"","Fields":[{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"MaxOutgoingMessagesPerDay","Docs":"","Typewords":["int32"]},{"Name":"MaxFirstTimeRecipientsPerDay","Docs":"","Typewords":["int32"]},{"Name":"OutgoingIPs","Docs":"","Typewords":["[]","string"]}]},
+	"Canary": {"Name":"Canary","Docs":"","Fields":[{"Name":"From","Docs":"","Typewords":["string"]},{"Name":"To","Docs":"","Typewords":["string"]},{"Name":"Interval","Docs":"","Typewords":["int64"]},{"Name":"Deadline","Docs":"","Typewords":["int64"]}]},
 	"TLSPublicKey": {"Name":"TLSPublicKey","Docs":"","Fields":[{"Name":"Fingerprint","Docs":"","Typewords":["string"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Type","Docs":"","Typewords":["string"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"NoIMAPPreauth","Docs":"","Typewords":["bool"]},{"Name":"CertDER","Docs":"","Typewords":["nullable","string"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"LoginAddress","Docs":"","Typewords":["string"]}]},
 	"LoginAttempt": {"Name":"LoginAttempt","Docs":"","Fields":[{"Name":"Key","Docs":"","Typewords":["nullable","string"]},{"Name":"Last","Docs":"","Typewords":["timestamp"]},{"Name":"First","Docs":"","Typewords":["timestamp"]},{"Name":"Count","Docs":"","Typewords":["int64"]},{"Name":"AccountName","Docs":"","Typewords":["string"]},{"Name":"LoginAddress","Docs":"","Typewords":["string"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"LocalIP","Docs":"","Typewords":["string"]},{"Name":"TLS","Docs":"","Typewords":["string"]},{"Name":"TLSPubKeyFingerprint","Docs":"","Typewords":["string"]},{"Name":"Protocol","Docs":"","Typewords":["string"]},{"Name":"UserAgent","Docs":"","Typewords":["string"]},{"Name":"AuthMech","Docs":"","Typewords":["string"]},{"Name":"Result","Docs":"","Typewords":["AuthResult"]}]},
+	"AuthLockout": {"Name":"AuthLockout","Docs":"","Fields":[{"Name":"Key","Docs":"","Typewords":["string"]},{"Name":"IP","Docs":"","Typewords":["string"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Failures","Docs":"","Typewords":["int32"]},{"Name":"WindowStart","Docs":"","Typewords":["timestamp"]},{"Name":"Last","Docs":"","Typewords":["timestamp"]},{"Name":"Until","Docs":"","Typewords":["timestamp"]}]},
+	"TLSCertificate": {"Name":"TLSCertificate","Docs":"","Fields":[{"Name":"Listeners","Docs":"","Typewords":["[]","string"]},{"Name":"ACME","Docs":"","Typewords":["string"]},{"Name":"Hostname","Docs":"","Typewords":["string"]},{"Name":"KeyType","Docs":"","Typewords":["string"]},{"Name":"Listener","Docs":"","Typewords":["string"]},{"Name":"Index","Docs":"","Typewords":["int32"]},{"Name":"CertFile","Docs":"","Typewords":["string"]},{"Name":"KeyFile","Docs":"","Typewords":["string"]},{"Name":"Present","Docs":"","Typewords":["bool"]},{"Name":"DNSNames","Docs":"","Typewords":["[]","string"]},{"Name":"Issuer","Docs":"","Typewords":["string"]},{"Name":"NotBefore","Docs":"","Typewords":["timestamp"]},{"Name":"NotAfter","Docs":"","Typewords":["timestamp"]},{"Name":"RenewAfter","Docs":"","Typewords":["timestamp"]},{"Name":"Renewing","Docs":"","Typewords":["bool"]},{"Name":"LastError","Docs":"","Typewords":["string"]},{"Name":"LastErrorTime","Docs":"","Typewords":["timestamp"]},{"Name":"NextAttempt","Docs":"","Typewords":["timestamp"]},{"Name":"Error","Docs":"","Typewords":["string"]}]},
+	"Message": {"Name":"Message","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Received","Docs":"","Typewords":["timestamp"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"EHLO","Docs":"","Typewords":["string"]},{"Name":"MailFrom","Docs":"","Typewords":["string"]},{"Name":"RcptTo","Docs":"","Typewords":["[]","string"]},{"Name":"MsgFrom","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"Code","Docs":"","Typewords":["int32"]},{"Name":"Secode","Docs":"","Typewords":["string"]},{"Name":"Errmsg","Docs":"","Typewords":["string"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"Delivered","Docs":"","Typewords":["timestamp"]}]},
 	"CSRFToken": {"Name":"CSRFToken","Docs":"","Values":null},
 	"DMARCPolicy": {"Name":"DMARCPolicy","Docs":"","Values":[{"Name":"PolicyEmpty","Value":"","Docs":""},{"Name":"PolicyNone","Value":"none","Docs":""},{"Name":"PolicyQuarantine","Value":"quarantine","Docs":""},{"Name":"PolicyReject","Value":"reject","Docs":""}]},
 	"Align": {"Name":"Align","Docs":"","Values":[{"Name":"AlignStrict","Value":"s","Docs":""},{"Name":"AlignRelaxed","Value":"r","Docs":""}]},
@@ -1284,10 +1604,14 @@ export const types: TypenameMap = {
 	"Mode": {"Name":"Mode","Docs":"","Values":[{"Name":"ModeEnforce","Value":"enforce","Docs":""},{"Name":"ModeTesting","Value":"testing","Docs":""},{"Name":"ModeNone","Value":"none","Docs":""}]},
 	"Localpart": {"Name":"Localpart","Docs":"","Values":null},
 	"IP": {"Name":"IP","Docs":"","Values":[]},
-	"AuthResult": {"Name":"AuthResult","Docs":"","Values":[{"Name":"AuthSuccess","Value":"ok","Docs":""},{"Name":"AuthBadUser","Value":"baduser","Docs":""},{"Name":"AuthBadPassword","Value":"badpassword","Docs":""},{"Name":"AuthBadCredentials","Value":"badcreds","Docs":""},{"Name":"AuthBadChannelBinding","Value":"badchanbind","Docs":""},{"Name":"AuthBadProtocol","Value":"badprotocol","Docs":""},{"Name":"AuthLoginDisabled","Value":"logindisabled","Docs":""},{"Name":"AuthError","Value":"error","Docs":""},{"Name":"AuthAborted","Value":"aborted","Docs":""}]},
+	"AuthResult": {"Name":"AuthResult","Docs":"","Values":[{"Name":"AuthSuccess","Value":"ok","Docs":""},{"Name":"AuthBadUser","Value":"baduser","Docs":""},{"Name":"AuthBadPassword","Value":"badpassword","Docs":""},{"Name":"AuthBadCredentials","Value":"badcreds","Docs":""},{"Name":"AuthBadChannelBinding","Value":"badchanbind","Docs":""},{"Name":"AuthBadProtocol","Value":"badprotocol","Docs":""},{"Name":"AuthLoginDisabled","Value":"logindisabled","Docs":""},{"Name":"AuthTOTPRequired","Value":"totprequired","Docs":""},{"Name":"AuthBadTOTP","Value":"badtotp","Docs":""},{"Name":"AuthReferral","Value":"referral","Docs":""},{"Name":"AuthError","Value":"error","Docs":""},{"Name":"AuthAborted","Value":"aborted","Docs":""}]},
 }
 
 export const parser = {
+	WebAuthnLoginOptions: (v: any) => parse("WebAuthnLoginOptions", v) as WebAuthnLoginOptions,
+	WebAuthnRegisterOptions: (v: any) => parse("WebAuthnRegisterOptions", v) as WebAuthnRegisterOptions,
+	AdminWebAuthnCredential: (v: any) => parse("AdminWebAuthnCredential", v) as AdminWebAuthnCredential,
+	AdminSession: (v: any) => parse("AdminSession", v) as AdminSession,
 	CheckResult: (v: any) => parse("CheckResult", v) as CheckResult,
 	DNSSECResult: (v: any) => parse("DNSSECResult", v) as DNSSECResult,
 	IPRevCheckResult: (v: any) => parse("IPRevCheckResult", v) as IPRevCheckResult,
@@ -1314,6 +1638,8 @@ export const parser = {
 	Pair: (v: any) => parse("Pair", v) as Pair,
 	Policy: (v: any) => parse("Policy", v) as Policy,
 	STSMX: (v: any) => parse("STSMX", v) as STSMX,
+	BIMICheckResult: (v: any) => parse("BIMICheckResult", v) as BIMICheckResult,
+	BIMIRecord: (v: any) => parse("BIMIRecord", v) as BIMIRecord,
 	SRVConfCheckResult: (v: any) => parse("SRVConfCheckResult", v) as SRVConfCheckResult,
 	SRV: (v: any) => parse("SRV", v) as SRV,
 	AutoconfCheckResult: (v: any) => parse("AutoconfCheckResult", v) as AutoconfCheckResult,
@@ -1326,16 +1652,28 @@ export const parser = {
 	DMARC: (v: any) => parse("DMARC", v) as DMARC,
 	MTASTS: (v: any) => parse("MTASTS", v) as MTASTS,
 	TLSRPT: (v: any) => parse("TLSRPT", v) as TLSRPT,
+	BIMI: (v: any) => parse("BIMI", v) as BIMI,
 	Route: (v: any) => parse("Route", v) as Route,
 	Alias: (v: any) => parse("Alias", v) as Alias,
 	AliasAddress: (v: any) => parse("AliasAddress", v) as AliasAddress,
 	Address: (v: any) => parse("Address", v) as Address,
 	Destination: (v: any) => parse("Destination", v) as Destination,
 	Ruleset: (v: any) => parse("Ruleset", v) as Ruleset,
+	List: (v: any) => parse("List", v) as List,
+	DestinationPattern: (v: any) => parse("DestinationPattern", v) as DestinationPattern,
+	Subdomains: (v: any) => parse("Subdomains", v) as Subdomains,
+	SubdomainRoute: (v: any) => parse("SubdomainRoute", v) as SubdomainRoute,
+	RejectedRetention: (v: any) => parse("RejectedRetention", v) as RejectedRetention,
+	RejectText: (v: any) => parse("RejectText", v) as RejectText,
+	DomainRetire: (v: any) => parse("DomainRetire", v) as DomainRetire,
 	Account: (v: any) => parse("Account", v) as Account,
 	OutgoingWebhook: (v: any) => parse("OutgoingWebhook", v) as OutgoingWebhook,
 	IncomingWebhook: (v: any) => parse("IncomingWebhook", v) as IncomingWebhook,
+	AttachmentLinks: (v: any) => parse("AttachmentLinks", v) as AttachmentLinks,
+	Notification: (v: any) => parse("Notification", v) as Notification,
 	SubjectPass: (v: any) => parse("SubjectPass", v) as SubjectPass,
+	MailboxRetention: (v: any) => parse("MailboxRetention", v) as MailboxRetention,
+	AutoArchive: (v: any) => parse("AutoArchive", v) as AutoArchive,
 	AutomaticJunkFlags: (v: any) => parse("AutomaticJunkFlags", v) as AutomaticJunkFlags,
 	JunkFilter: (v: any) => parse("JunkFilter", v) as JunkFilter,
 	AddressAlias: (v: any) => parse("AddressAlias", v) as AddressAlias,
@@ -1370,6 +1708,7 @@ export const parser = {
 	Msg: (v: any) => parse("Msg", v) as Msg,
 	IPDomain: (v: any) => parse("IPDomain", v) as IPDomain,
 	MsgResult: (v: any) => parse("MsgResult", v) as MsgResult,
+	MsgResultHost: (v: any) => parse("MsgResultHost", v) as MsgResultHost,
 	RetiredFilter: (v: any) => parse("RetiredFilter", v) as RetiredFilter,
 	RetiredSort: (v: any) => parse("RetiredSort", v) as RetiredSort,
 	MsgRetired: (v: any) => parse("MsgRetired", v) as MsgRetired,
@@ -1389,6 +1728,7 @@ export const parser = {
 	Transport: (v: any) => parse("Transport", v) as Transport,
 	TransportSMTP: (v: any) => parse("TransportSMTP", v) as TransportSMTP,
 	SMTPAuth: (v: any) => parse("SMTPAuth", v) as SMTPAuth,
+	TransportSMTPHost: (v: any) => parse("TransportSMTPHost", v) as TransportSMTPHost,
 	TransportSocks: (v: any) => parse("TransportSocks", v) as TransportSocks,
 	TransportDirect: (v: any) => parse("TransportDirect", v) as TransportDirect,
 	TransportFail: (v: any) => parse("TransportFail", v) as TransportFail,
@@ -1398,8 +1738,13 @@ export const parser = {
 	TLSResult: (v: any) => parse("TLSResult", v) as TLSResult,
 	TLSRPTSuppressAddress: (v: any) => parse("TLSRPTSuppressAddress", v) as TLSRPTSuppressAddress,
 	Dynamic: (v: any) => parse("Dynamic", v) as Dynamic,
+	Tenant: (v: any) => parse("Tenant", v) as Tenant,
+	Canary: (v: any) => parse("Canary", v) as Canary,
 	TLSPublicKey: (v: any) => parse("TLSPublicKey", v) as TLSPublicKey,
 	LoginAttempt: (v: any) => parse("LoginAttempt", v) as LoginAttempt,
+	AuthLockout: (v: any) => parse("AuthLockout", v) as AuthLockout,
+	TLSCertificate: (v: any) => parse("TLSCertificate", v) as TLSCertificate,
+	Message: (v: any) => parse("Message", v) as Message,
 	CSRFToken: (v: any) => parse("CSRFToken", v) as CSRFToken,
 	DMARCPolicy: (v: any) => parse("DMARCPolicy", v) as DMARCPolicy,
 	Align: (v: any) => parse("Align", v) as Align,
@@ -1451,12 +1796,14 @@ export class Client {
 	}
 
 	// Login returns a session token for the credentials, or fails with error code
-	// "user:badLogin". Call LoginPrep to get a loginToken.
-	async Login(loginToken: string, password: string): Promise<CSRFToken> {
+	// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+	// authentication is enabled and totp is empty, the call fails with error code
+	// "user:totpRequired", and must be repeated with a TOTP code or recovery code.
+	async Login(loginToken: string, password: string, totp: string): Promise<CSRFToken> {
 		const fn: string = "Login"
-		const paramTypes: string[][] = [["string"],["string"]]
+		const paramTypes: string[][] = [["string"],["string"],["string"]]
 		const returnTypes: string[][] = [["CSRFToken"]]
-		const params: any[] = [loginToken, password]
+		const params: any[] = [loginToken, password, totp]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
 	}
 
@@ -1469,6 +1816,100 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// WebAuthnLoginStart returns options for logging in with a registered security key
+	// or passkey instead of the admin password. The assertion from the browser must be
+	// passed to LoginWebAuthn.
+	async WebAuthnLoginStart(): Promise<WebAuthnLoginOptions> {
+		const fn: string = "WebAuthnLoginStart"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["WebAuthnLoginOptions"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as WebAuthnLoginOptions
+	}
+
+	// LoginWebAuthn returns a session token for a WebAuthn assertion by a registered
+	// security key or passkey, or fails with error code "user:loginFailed". Call
+	// LoginPrep to get a loginToken, and WebAuthnLoginStart for the challenge. Binary
+	// values are raw-url-base64-encoded.
+	async LoginWebAuthn(loginToken: string, challenge: string, credentialID: string, clientDataJSON: string, authenticatorData: string, signature: string): Promise<CSRFToken> {
+		const fn: string = "LoginWebAuthn"
+		const paramTypes: string[][] = [["string"],["string"],["string"],["string"],["string"],["string"]]
+		const returnTypes: string[][] = [["CSRFToken"]]
+		const params: any[] = [loginToken, challenge, credentialID, clientDataJSON, authenticatorData, signature]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
+	}
+
+	// WebAuthnRegisterStart returns options for registering a security key or passkey
+	// for logging in, at the host name the admin web interface is accessed at. The
+	// response from the browser must be passed to WebAuthnRegisterFinish.
+	async WebAuthnRegisterStart(): Promise<WebAuthnRegisterOptions> {
+		const fn: string = "WebAuthnRegisterStart"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["WebAuthnRegisterOptions"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as WebAuthnRegisterOptions
+	}
+
+	// WebAuthnRegisterFinish verifies and stores a new security key or passkey, with
+	// the response from the browser for the options from WebAuthnRegisterStart.
+	// Binary values are raw-url-base64-encoded.
+	async WebAuthnRegisterFinish(name: string, challenge: string, clientDataJSON: string, attestationObject: string): Promise<void> {
+		const fn: string = "WebAuthnRegisterFinish"
+		const paramTypes: string[][] = [["string"],["string"],["string"],["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [name, challenge, clientDataJSON, attestationObject]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// WebAuthnCredentials returns the security keys and passkeys registered for
+	// logging in.
+	async WebAuthnCredentials(): Promise<AdminWebAuthnCredential[] | null> {
+		const fn: string = "WebAuthnCredentials"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","AdminWebAuthnCredential"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as AdminWebAuthnCredential[] | null
+	}
+
+	// WebAuthnCredentialRemove removes a registered security key or passkey.
+	async WebAuthnCredentialRemove(id: number): Promise<void> {
+		const fn: string = "WebAuthnCredentialRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// Sessions returns the active admin sessions, most recently used first, and the
+	// ID of the session of the request.
+	async Sessions(): Promise<[AdminSession[] | null, number]> {
+		const fn: string = "Sessions"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","AdminSession"],["int64"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [AdminSession[] | null, number]
+	}
+
+	// SessionRevoke removes an admin session, it can no longer be used. The session
+	// of the request cannot be revoked, use Logout instead.
+	async SessionRevoke(id: number): Promise<void> {
+		const fn: string = "SessionRevoke"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// SessionsRevokeOthers removes all admin sessions except the session of the
+	// request, and returns the number of revoked sessions.
+	async SessionsRevokeOthers(): Promise<number> {
+		const fn: string = "SessionsRevokeOthers"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["int32"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as number
+	}
+
 	// Version returns the version, goos and goarch.
 	async Version(): Promise<[string, string, string]> {
 		const fn: string = "Version"
@@ -1488,7 +1929,8 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CheckResult
 	}
 
-	// Domains returns all configured domain names.
+	// Domains returns all configured domain names, or only those of the tenant for
+	// tenant sessions.
 	async Domains(): Promise<ConfigDomain[] | null> {
 		const fn: string = "Domains"
 		const paramTypes: string[][] = []
@@ -1533,7 +1975,8 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [{ [key: string]: string }, { [key: string]: Alias }]
 	}
 
-	// Accounts returns the names of all configured and all disabled accounts.
+	// Accounts returns the names of all configured and all disabled accounts, or
+	// only those of the tenant for tenant sessions.
 	async Accounts(): Promise<[string[] | null, string[] | null]> {
 		const fn: string = "Accounts"
 		const paramTypes: string[][] = []
@@ -1814,6 +2257,16 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Msg[] | null
 	}
 
+	// QueueMessageSource returns the source of a message in the queue, e.g. for
+	// reviewing a message held for review. At most 1MB is returned.
+	async QueueMessageSource(id: number): Promise<string> {
+		const fn: string = "QueueMessageSource"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
+
 	// QueueNextAttemptSet sets a new time for next delivery attempt of matching
 	// messages from the queue.
 	async QueueNextAttemptSet(filter: Filter, minutes: number): Promise<number> {
@@ -2278,12 +2731,15 @@ export class Client {
 
 	// DomainDKIMSave saves the settings of selectors, and which to enable for
 	// signing, for a domain. All currently configured selectors must be present,
-	// selectors cannot be added/removed with this function.
-	async DomainDKIMSave(domainName: string, selectors: { [key: string]: Selector }, sign: string[] | null): Promise<void> {
+	// selectors cannot be added/removed with this function. If signMailFrom is set,
+	// submitted messages are also signed for the SMTP MAIL FROM domain if it is
+	// different. If signHostname is set, submitted messages are also signed for the
+	// domain of the hostname.
+	async DomainDKIMSave(domainName: string, selectors: { [key: string]: Selector }, sign: string[] | null, signMailFrom: boolean, signHostname: boolean): Promise<void> {
 		const fn: string = "DomainDKIMSave"
-		const paramTypes: string[][] = [["string"],["{}","Selector"],["[]","string"]]
+		const paramTypes: string[][] = [["string"],["{}","Selector"],["[]","string"],["bool"],["bool"]]
 		const returnTypes: string[][] = []
-		const params: any[] = [domainName, selectors, sign]
+		const params: any[] = [domainName, selectors, sign, signMailFrom, signHostname]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
@@ -2353,6 +2809,119 @@ export class Client {
 		const params: any[] = [accountName, limit]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as LoginAttempt[] | null
 	}
+
+	// AuthLockouts returns IPs and accounts with recent failed authentication
+	// attempts, and whether they are locked out.
+	async AuthLockouts(): Promise<AuthLockout[] | null> {
+		const fn: string = "AuthLockouts"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","AuthLockout"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as AuthLockout[] | null
+	}
+
+	// AuthLockoutClear clears failed authentication attempts and a lockout for an IP
+	// or account name. If empty, all are cleared.
+	async AuthLockoutClear(ipOrAccount: string): Promise<void> {
+		const fn: string = "AuthLockoutClear"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [ipOrAccount]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// TLSCertificates returns the TLS certificates of all listeners, both from ACME
+	// and from files.
+	async TLSCertificates(): Promise<TLSCertificate[] | null> {
+		const fn: string = "TLSCertificates"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","TLSCertificate"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as TLSCertificate[] | null
+	}
+
+	// TLSCertificateRenew starts requesting new certificates through ACME for the
+	// host name, regardless of the expiration time of the current certificates. The
+	// request is done in the background, progress is visible in TLSCertificates.
+	async TLSCertificateRenew(acmeName: string, hostname: string): Promise<void> {
+		const fn: string = "TLSCertificateRenew"
+		const paramTypes: string[][] = [["string"],["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [acmeName, hostname]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// TLSKeyCertReplace replaces the certificate and private key configured for a
+	// listener, writing the key and certificate files. The new certificate is used for
+	// new connections immediately.
+	async TLSKeyCertReplace(listenerName: string, index: number, certPEM: string, keyPEM: string): Promise<void> {
+		const fn: string = "TLSKeyCertReplace"
+		const paramTypes: string[][] = [["string"],["int32"],["string"],["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [listenerName, index, certPEM, keyPEM]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// RejectedList returns the retained messages that were rejected during the SMTP
+	// transaction, most recent first, optionally only for a domain.
+	async RejectedList(domain: string): Promise<Message[] | null> {
+		const fn: string = "RejectedList"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["[]","Message"]]
+		const params: any[] = [domain]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Message[] | null
+	}
+
+	// RejectedMessage returns a retained rejected message and its contents.
+	async RejectedMessage(id: number): Promise<[Message, string]> {
+		const fn: string = "RejectedMessage"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = [["Message"],["string"]]
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [Message, string]
+	}
+
+	// RejectedRemove removes retained rejected messages.
+	async RejectedRemove(ids: number[] | null): Promise<void> {
+		const fn: string = "RejectedRemove"
+		const paramTypes: string[][] = [["[]","int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [ids]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// RejectedDeliver delivers a retained rejected message to the accounts of its
+	// recipients, without the checks that caused the rejection. Typically used after
+	// adjusting the policy. The addresses the message was delivered to are returned.
+	async RejectedDeliver(id: number): Promise<string[] | null> {
+		const fn: string = "RejectedDeliver"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = [["[]","string"]]
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string[] | null
+	}
+
+	// LoginTenant returns a session token for the admin of a tenant, or fails with
+	// error code "user:loginFailed". Call LoginPrep to get a loginToken. The session
+	// only gives access to the domains and accounts of the tenant.
+	async LoginTenant(loginToken: string, tenant: string, password: string): Promise<CSRFToken> {
+		const fn: string = "LoginTenant"
+		const paramTypes: string[][] = [["string"],["string"],["string"]]
+		const returnTypes: string[][] = [["CSRFToken"]]
+		const params: any[] = [loginToken, tenant, password]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
+	}
+
+	// Tenant returns the name of the tenant of the session, or an empty string for
+	// the admin. The web interface only shows the domains and accounts of the
+	// tenant for tenant sessions.
+	async Tenant(): Promise<string> {
+		const fn: string = "Tenant"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
 }
 
 export const defaultBaseURL = (function() {
@@ -0,0 +1,139 @@
+package webadmin
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mjl-/sherpa"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webauth"
+)
+
+// tenantFunctions are the API functions that can be called by tenant admins. Each
+// function that takes a domain, account or address checks that it belongs to the
+// tenant. Functions for server-wide settings, like the queue, routes, transports
+// and the webserver, and for adding and removing domains, are not available to
+// tenant admins.
+var tenantFunctions = map[string]bool{
+	"Logout":                         true,
+	"Tenant":                         true,
+	"Version":                        true,
+	"ParseDomain":                    true,
+	"Domains":                        true,
+	"Domain":                         true,
+	"DomainConfig":                   true,
+	"DomainLocalparts":               true,
+	"DomainRecords":                  true,
+	"CheckDomain":                    true,
+	"ClientConfigsDomain":            true,
+	"DomainDescriptionSave":          true,
+	"DomainClientSettingsDomainSave": true,
+	"DomainLocalpartConfigSave":      true,
+	"DomainDMARCAddressSave":         true,
+	"DomainTLSRPTAddressSave":        true,
+	"DomainMTASTSSave":               true,
+	"DomainDKIMAdd":                  true,
+	"DomainDKIMRemove":               true,
+	"DomainDKIMSave":                 true,
+	"DomainDisabledSave":             true,
+	"DMARCSummaries":                 true,
+	"TLSRPTSummaries":                true,
+	"AliasAdd":                       true,
+	"AliasUpdate":                    true,
+	"AliasRemove":                    true,
+	"AliasAddressesAdd":              true,
+	"AliasAddressesRemove":           true,
+	"Accounts":                       true,
+	"Account":                        true,
+	"AccountAdd":                     true,
+	"AccountRemove":                  true,
+	"AddressAdd":                     true,
+	"AddressRemove":                  true,
+	"SetPassword":                    true,
+	"AccountSettingsSave":            true,
+	"AccountLoginDisabledSave":       true,
+	"TLSPublicKeys":                  true,
+	"LoginAttempts":                  true,
+}
+
+// reqTenant returns the tenant of the admin session of the request, or an empty
+// string for the admin.
+func reqTenant(ctx context.Context) string {
+	// Tests call functions without request info.
+	reqInfo, ok := ctx.Value(requestInfoCtxKey).(requestInfo)
+	if !ok {
+		return ""
+	}
+	return reqInfo.Tenant
+}
+
+// xcheckTenantDomain aborts the request if the session is for a tenant and the
+// domain does not belong to the tenant.
+func xcheckTenantDomain(ctx context.Context, domain string) {
+	tenant := reqTenant(ctx)
+	if tenant == "" {
+		return
+	}
+	d, err := dns.ParseDomain(domain)
+	xcheckuserf(ctx, err, "parse domain")
+	if dc, ok := mox.Conf.Domain(d); !ok || dc.Tenant != tenant {
+		xcheckuserf(ctx, errors.New("no such domain"), "looking up domain")
+	}
+}
+
+// xcheckTenantAccount aborts the request if the session is for a tenant and the
+// account does not belong to the tenant.
+func xcheckTenantAccount(ctx context.Context, accountName string) {
+	tenant := reqTenant(ctx)
+	if tenant == "" {
+		return
+	}
+	if t, _, ok := mox.Conf.AccountTenant(accountName); !ok || t != tenant {
+		xcheckuserf(ctx, errors.New("no such account"), "looking up account")
+	}
+}
+
+// xcheckTenantAddress aborts the request if the session is for a tenant and the
+// domain of the address does not belong to the tenant. An address can also be a
+// catchall address, "@" followed by a domain.
+func xcheckTenantAddress(ctx context.Context, address string) {
+	if reqTenant(ctx) == "" {
+		return
+	}
+	if len(address) > 1 && address[0] == '@' {
+		xcheckTenantDomain(ctx, address[1:])
+		return
+	}
+	addr, err := smtp.ParseAddress(address)
+	xcheckuserf(ctx, err, "parse address")
+	xcheckTenantDomain(ctx, addr.Domain.Name())
+}
+
+// LoginTenant returns a session token for the admin of a tenant, or fails with
+// error code "user:loginFailed". Call LoginPrep to get a loginToken. The session
+// only gives access to the domains and accounts of the tenant.
+func (w Admin) LoginTenant(ctx context.Context, loginToken, tenant, password string) store.CSRFToken {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	if tenant == "" {
+		panic(&sherpa.Error{Code: "user:loginFailed", Message: "invalid credentials"})
+	}
+	csrfToken, err := webauth.Login(ctx, log, webauth.Admin, "webadmin", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, tenant, password, "")
+	if _, ok := err.(*sherpa.Error); ok {
+		panic(err)
+	}
+	xcheckf(ctx, err, "login")
+	return csrfToken
+}
+
+// Tenant returns the name of the tenant of the session, or an empty string for
+// the admin. The web interface only shows the domains and accounts of the
+// tenant for tenant sessions.
+func (Admin) Tenant(ctx context.Context) string {
+	return reqTenant(ctx)
+}
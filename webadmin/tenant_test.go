@@ -0,0 +1,135 @@
+package webadmin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+)
+
+func TestTenant(t *testing.T) {
+	os.RemoveAll("../testdata/webadmintenant/data")
+	defer os.RemoveAll("../testdata/webadmintenant/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/webadmintenant/mox.conf")
+	mox.ConfigDynamicPath = filepath.Join(filepath.Dir(mox.ConfigStaticPath), "domains.conf")
+	mox.MustLoadConfig(true, false)
+	err := store.Init(ctxbg)
+	tcheck(t, err, "store init")
+	defer func() {
+		err := store.Close()
+		tcheck(t, err, "store close")
+	}()
+
+	tenant, tc, ok := mox.Conf.AccountTenant("acme")
+	tcompare(t, ok, true)
+	tcompare(t, tenant, "acme")
+	tcompare(t, tc.IPs[0].String(), "192.0.2.1")
+	_, _, ok = mox.Conf.AccountTenant("mjl")
+	tcompare(t, ok, false)
+
+	pwhash, err := bcrypt.GenerateFromPassword([]byte("acmetest123"), bcrypt.DefaultCost)
+	tcheck(t, err, "generate bcrypt hash")
+	err = os.WriteFile(mox.TenantAdminPasswordFile("acme"), pwhash, 0660)
+	tcheck(t, err, "write tenant password file")
+	defer os.Remove(mox.TenantAdminPasswordFile("acme"))
+
+	api := Admin{cookiePath: "/admin/"}
+	apiHandler, err := makeSherpaHandler(api.cookiePath, false)
+	tcheck(t, err, "sherpa handler")
+
+	login := func(expErrCode, tenant, password string) (store.CSRFToken, *http.Cookie) {
+		t.Helper()
+		respRec := httptest.NewRecorder()
+		reqInfo := requestInfo{"", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}, ""}
+		ctx := context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
+		cookie := &http.Cookie{Name: "webadminlogin", Value: api.LoginPrep(ctx)}
+		reqInfo.Request.Header = http.Header{"Cookie": []string{cookie.String()}}
+		if expErrCode != "" {
+			tneedErrorCode(t, expErrCode, func() { api.LoginTenant(ctx, cookie.Value, tenant, password) })
+			return "", nil
+		}
+		csrfToken := api.LoginTenant(ctx, cookie.Value, tenant, password)
+		for _, c := range respRec.Result().Cookies() {
+			if c.Name == "webadminsession" {
+				return csrfToken, c
+			}
+		}
+		t.Fatalf("missing session cookie")
+		return "", nil
+	}
+
+	login("user:loginFailed", "acme", "badpassword")
+	login("user:loginFailed", "bogus", "acmetest123")
+	login("user:loginFailed", "", "acmetest123")
+	csrfToken, sessionCookie := login("", "acme", "acmetest123")
+
+	testHTTP := func(path string, expStatusCode int, check func(body string)) {
+		t.Helper()
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{"params":[]}`))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Cookie", (&http.Cookie{Name: "webadminsession", Value: sessionCookie.Value}).String())
+		req.Header.Add("x-mox-csrf", string(csrfToken))
+		rr := httptest.NewRecorder()
+		handle(apiHandler, false, rr, req)
+		if rr.Code != expStatusCode {
+			t.Fatalf("got status %d, expected %d (%s)", rr.Code, expStatusCode, rr.Body.String())
+		}
+		if check != nil {
+			check(rr.Body.String())
+		}
+	}
+
+	// Server-wide functions are not available to tenant admins.
+	testHTTP("/api/Transports", http.StatusForbidden, nil)
+	testHTTP("/api/QueueSize", http.StatusForbidden, nil)
+	testHTTP("/api/DomainAdd", http.StatusForbidden, nil)
+	testHTTP("/api/Tenant", http.StatusOK, func(body string) {
+		tcompare(t, body, `{"result":"acme"}`+"\n")
+	})
+	testHTTP("/api/Domains", http.StatusOK, func(body string) {
+		if !strings.Contains(body, "acme.example") || strings.Contains(body, "mox.example") {
+			t.Fatalf("unexpected domains for tenant: %s", body)
+		}
+	})
+
+	// Functions only give access to domains and accounts of the tenant.
+	reqInfo := requestInfo{"", httptest.NewRecorder(), &http.Request{RemoteAddr: "127.0.0.1:1234"}, "acme"}
+	ctx := context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
+	tcompare(t, api.Tenant(ctx), "acme")
+	tcompare(t, len(api.Domains(ctx)), 1)
+	accounts, _ := api.Accounts(ctx)
+	tcompare(t, accounts, []string{"acme"})
+	api.DomainConfig(ctx, "acme.example")
+	api.Account(ctx, "acme")
+	tneedErrorCode(t, "user:error", func() { api.DomainConfig(ctx, "mox.example") })
+	tneedErrorCode(t, "user:error", func() { api.Account(ctx, "mjl") })
+	tneedErrorCode(t, "user:error", func() { api.SetPassword(ctx, "mjl", "test1234") })
+	tneedErrorCode(t, "user:error", func() { api.AddressAdd(ctx, "info@mox.example", "acme") })
+	tneedErrorCode(t, "user:error", func() { api.AddressRemove(ctx, "mjl@mox.example") })
+	tneedErrorCode(t, "user:error", func() { api.AccountAdd(ctx, "other", "other@mox.example") })
+	tneedErrorCode(t, "user:error", func() { api.LoginAttempts(ctx, "", 10) })
+	tneedErrorCode(t, "user:error", func() {
+		api.AliasAdd(ctx, "team", "acme.example", config.Alias{Addresses: []string{"info@acme.example", "mjl@mox.example"}})
+	})
+	tneedErrorCode(t, "user:error", func() { api.AliasAddressesAdd(ctx, "team", "acme.example", []string{"mjl@mox.example"}) })
+	tneedErrorCode(t, "user:error", func() { api.DomainDMARCAddressSave(ctx, "acme.example", "dmarcreports", "", "mjl", "DMARC") })
+	tneedErrorCode(t, "user:error", func() { api.DomainTLSRPTAddressSave(ctx, "acme.example", "tlsreports", "", "mjl", "TLSRPT") })
+	tneedErrorCode(t, "user:error", func() {
+		api.DomainTLSRPTAddressSave(ctx, "acme.example", "tlsreports", "mox.example", "acme", "TLSRPT")
+	})
+
+	// Admin sees everything, but cannot add addresses of a tenant domain to an
+	// account of another tenant.
+	tcompare(t, api.Tenant(ctxbg), "")
+	tcompare(t, len(api.Domains(ctxbg)), 2)
+	tneedErrorCode(t, "user:error", func() { api.AddressAdd(ctxbg, "mjl@acme.example", "mjl") })
+}
@@ -146,6 +146,7 @@ func badResponse(hresp *http.Response) error {
 //
 // Error codes:
 //
+//   - submissionDisabled, if submission has been disabled for the account.
 //   - badAddress, if an email address is invalid.
 //   - missingBody, if no text and no html body was specified.
 //   - multipleFrom, if multiple from addresses were specified.
@@ -34,6 +34,11 @@ an interval to "keep retired messages delivered from the queue". This allows
 incoming DSNs to be matched to the original outgoing messages, and enables
 automatic suppression list management.
 
+Instead of the account password, an API token created on the account web page
+can be used as password. Tokens with scope "send" can only call Send, tokens
+with scope "read" can only call the methods that retrieve messages and the
+suppression list. Other calls fail with error code "forbidden".
+
 HTTP response status 200 OK indicates a successful method call, status 400
 indicates an error.  The response body of an error is a JSON object with a
 human-readable "Message" field, and a "Code" field for programmatic handling
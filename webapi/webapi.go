@@ -33,7 +33,8 @@ type Methods interface {
 type Error struct {
 	// For programmatic handling. Common values: "user" for generic error by user,
 	// "server" for a server-side processing error, "badAddress" for malformed email
-	// addresses.
+	// addresses, "forbidden" for methods not allowed by the scope of the API token
+	// used for authentication.
 	Code string
 
 	// Human readable error message.
@@ -137,6 +138,12 @@ type SendRequest struct {
 	// insecure delivery. Optional.
 	RequireTLS *bool
 
+	// If true, direct delivery requires STARTTLS with a verified certificate, through
+	// DANE or otherwise PKIX/WebPKI verification of the MX host name, also when the
+	// recipient domain has no MTA-STS or DANE policy. Delivery fails, with a DSN, if
+	// verified TLS is not possible. Optional.
+	RequireVerifiedTLS bool
+
 	// If set, it should be a time in the future at which the first delivery attempt
 	// starts. Optional.
 	FutureRelease *time.Time
@@ -32,7 +32,6 @@ import (
 
 	"github.com/mjl-/bstore"
 
-	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
@@ -55,7 +54,7 @@ var (
 	metricSubmission = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "mox_webapi_submission_total",
-			Help: "Webapi message submission results, known values (those ending with error are server errors): ok, badfrom, messagelimiterror, recipientlimiterror, queueerror, storesenterror, domaindisabled.",
+			Help: "Webapi message submission results, known values (those ending with error are server errors): ok, badfrom, messagelimiterror, recipientlimiterror, holdforreview, queueerror, storesenterror, domaindisabled, disabled.",
 		},
 		[]string{
 			"result",
@@ -272,6 +271,17 @@ type server struct {
 
 var _ webapi.Methods = server{}
 
+// apiTokenMethods are the methods that can be called when authenticated with an
+// API token instead of a password, with the required token scope.
+var apiTokenMethods = map[string]store.APITokenScope{
+	"Send":               store.APITokenScopeSend,
+	"SuppressionList":    store.APITokenScopeRead,
+	"SuppressionPresent": store.APITokenScopeRead,
+	"MessageGet":         store.APITokenScopeRead,
+	"MessageRawGet":      store.APITokenScopeRead,
+	"MessagePartGet":     store.APITokenScopeRead,
+}
+
 // ServeHTTP implements http.Handler.
 func (s server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log := pkglog.WithContext(r.Context()) // Take cid from webserver.
@@ -384,7 +394,7 @@ func (s server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "500 - internal server error - cannot find remote ip", http.StatusInternalServerError)
 		return
 	}
-	if !mox.LimiterFailedAuth.CanAdd(clientIP, t0, 1) {
+	if !mox.LimiterFailedAuth.CanAdd(clientIP, t0, 1) || store.AuthLockedOut(clientIP, "") {
 		metrics.AuthenticationRatelimitedInc("webapi")
 		log.Debug("refusing connection due to many auth failures", slog.Any("clientip", clientIP))
 		http.Error(w, "429 - too many auth attempts", http.StatusTooManyRequests)
@@ -429,7 +439,8 @@ func (s server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	var err error
-	acc, la.AccountName, err = store.OpenEmailAuth(log, email, password, true)
+	var scope store.APITokenScope
+	acc, la.AccountName, scope, err = store.OpenEmailAuthToken(log, email, password, true)
 	if err != nil {
 		mox.LimiterFailedAuth.Add(clientIP, t0, 1)
 		if errors.Is(err, mox.ErrDomainNotFound) || errors.Is(err, mox.ErrAddressNotFound) || errors.Is(err, store.ErrUnknownCredentials) || errors.Is(err, store.ErrLoginDisabled) {
@@ -452,6 +463,11 @@ func (s server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	la.Result = store.AuthSuccess
 	mox.LimiterFailedAuth.Reset(clientIP, t0)
 
+	if scope != "" && apiTokenMethods[fn] != scope {
+		writeError(webapi.Error{Code: "forbidden", Message: fmt.Sprintf("method not allowed for api token with scope %q", scope)})
+		return
+	}
+
 	ct := r.Header.Get("Content-Type")
 	ct, _, err = mime.ParseMediaType(ct)
 	if err != nil {
@@ -619,6 +635,11 @@ func (s server) Send(ctx context.Context, req webapi.SendRequest) (resp webapi.S
 
 	accConf, _ := acc.Conf()
 
+	if accConf.SubmissionDisabled != "" {
+		metricSubmission.WithLabelValues("disabled").Inc()
+		return resp, webapi.Error{Code: "submissionDisabled", Message: "submission disabled: " + accConf.SubmissionDisabled}
+	}
+
 	if m.Text == "" && m.HTML == "" {
 		return resp, webapi.Error{Code: "missingBody", Message: "at least text or html body required"}
 	}
@@ -650,18 +671,35 @@ func (s server) Send(ctx context.Context, req webapi.SendRequest) (resp webapi.S
 		return resp, webapi.Error{Code: "noRecipients", Message: "no recipients"}
 	}
 
-	// Check outgoing message rate limit.
+	// Check outgoing message rate limit. If a limit is reached, the message is
+	// refused, or held in the queue for review if the account is configured to.
+	var limitErr webapi.Error
+	var limitMetric, limitReason string
 	xdbread(ctx, acc, func(tx *bstore.Tx) {
 		msglimit, rcptlimit, err := acc.SendLimitReached(tx, recipients)
+		xcheckf(err, "checking send limit")
 		if msglimit >= 0 {
-			metricSubmission.WithLabelValues("messagelimiterror").Inc()
-			panic(webapi.Error{Code: "messageLimitReached", Message: "outgoing message rate limit reached"})
+			limitErr = webapi.Error{Code: "messageLimitReached", Message: "outgoing message rate limit reached"}
+			limitMetric = "messagelimiterror"
+			limitReason = fmt.Sprintf("max number of messages (%d) over past 24h reached", msglimit)
 		} else if rcptlimit >= 0 {
-			metricSubmission.WithLabelValues("recipientlimiterror").Inc()
-			panic(webapi.Error{Code: "recipientLimitReached", Message: "outgoing new recipient rate limit reached"})
+			limitErr = webapi.Error{Code: "recipientLimitReached", Message: "outgoing new recipient rate limit reached"}
+			limitMetric = "recipientlimiterror"
+			limitReason = fmt.Sprintf("max number of new/first-time recipients (%d) over past 24h reached", rcptlimit)
 		}
-		xcheckf(err, "checking send limit")
 	})
+	var holdReason string
+	if limitErr.Code != "" {
+		hold, err := queue.HoldForReview(ctx, acc.Name)
+		xcheckf(err, "checking messages held for review")
+		if !hold {
+			metricSubmission.WithLabelValues(limitMetric).Inc()
+			panic(limitErr)
+		}
+		metricSubmission.WithLabelValues("holdforreview").Inc()
+		log.Info("holding submitted message in queue for review", slog.String("reason", limitReason))
+		holdReason = limitReason
+	}
 
 	// If we have a non-ascii localpart, we will be sending with smtputf8. We'll go
 	// full utf-8 then.
@@ -981,15 +1019,14 @@ func (s server) Send(ctx context.Context, req webapi.SendRequest) (resp webapi.S
 	if confDom.Disabled {
 		xcheckuserf(mox.ErrDomainDisabled, "checking domain")
 	}
-	selectors := mox.DKIMSelectors(confDom.DKIM)
-	if len(selectors) > 0 {
-		dkimHeaders, err := dkim.Sign(ctx, log.Logger, from.Address.Localpart, fd, selectors, smtputf8, dataFile)
-		if err != nil {
-			metricServerErrors.WithLabelValues("dkimsign").Inc()
-		}
-		xcheckf(err, "sign dkim")
-
-		msgPrefix = dkimHeaders
+	bimiHeader, selectors := mox.BIMISelectorHeader(confDom, mox.DKIMSelectors(confDom.DKIM))
+	dkimHeaders, err := mox.DKIMSignSubmission(ctx, log, confDom, from.Address, from.Address.Path(), selectors, smtputf8, store.FileMsgReader([]byte(bimiHeader), dataFile))
+	if err != nil {
+		metricServerErrors.WithLabelValues("dkimsign").Inc()
+	}
+	xcheckf(err, "sign dkim")
+	if dkimHeaders != "" {
+		msgPrefix = dkimHeaders + bimiHeader
 	}
 
 	loginAddr, err := smtp.ParseAddress(reqInfo.LoginAddress)
@@ -1019,7 +1056,11 @@ func (s server) Send(ctx context.Context, req webapi.SendRequest) (resp webapi.S
 		msgSize := int64(len(rcptMsgPrefix)) + xc.Size
 		qm := queue.MakeMsg(fp, rcpt, xc.Has8bit, xc.SMTPUTF8, msgSize, m.MessageID, []byte(rcptMsgPrefix), req.RequireTLS, now, m.Subject)
 		qm.FromID = fromIDs[i]
+		qm.RequireVerifiedTLS = req.RequireVerifiedTLS
 		qm.Extra = req.Extra
+		qm.Priority = accConf.DeliveryPriority
+		qm.Hold = holdReason != ""
+		qm.HoldReason = holdReason
 		if req.FutureRelease != nil {
 			ival := time.Until(*req.FutureRelease)
 			if ival > queue.FutureReleaseIntervalMax {
@@ -177,6 +177,23 @@ func TestServer(t *testing.T) {
 	// "request" must be JSON object.
 	testHTTPHdrsBody(s, "POST", "/v0/Send", formAuth, "request=[]", http.StatusBadRequest, false, "application/json; charset=utf-8", "protocol")
 
+	// API tokens only allow methods for their scope.
+	readToken, err := store.APITokenAdd(ctxbg, &store.APIToken{Account: "mjl", Name: "read", Scope: store.APITokenScopeRead})
+	tcheckf(t, err, "add api token")
+	sendToken, err := store.APITokenAdd(ctxbg, &store.APIToken{Account: "mjl", Name: "send", Scope: store.APITokenScopeSend})
+	tcheckf(t, err, "add api token")
+	tokenAuth := func(token string) map[string]string {
+		return map[string]string{
+			"Content-Type":  "application/x-www-form-urlencoded",
+			"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("mjl@mox.example:"+token)),
+		}
+	}
+	testHTTPHdrsBody(s, "POST", "/v0/SuppressionList", tokenAuth(readToken+"x"), "request={}", http.StatusUnauthorized, false, "", "")
+	testHTTPHdrsBody(s, "POST", "/v0/SuppressionList", tokenAuth(readToken), "request={}", http.StatusOK, false, "application/json; charset=utf-8", "")
+	testHTTPHdrsBody(s, "POST", "/v0/Send", tokenAuth(readToken), "request={}", http.StatusBadRequest, false, "application/json; charset=utf-8", "forbidden")
+	testHTTPHdrsBody(s, "POST", "/v0/MessageDelete", tokenAuth(readToken), "request={}", http.StatusBadRequest, false, "application/json; charset=utf-8", "forbidden")
+	testHTTPHdrsBody(s, "POST", "/v0/SuppressionList", tokenAuth(sendToken), "request={}", http.StatusBadRequest, false, "application/json; charset=utf-8", "forbidden")
+
 	// Send message. Look for the message in the queue.
 	now := time.Now()
 	yes := true
@@ -216,9 +233,10 @@ func TestServer(t *testing.T) {
 				Data: base64.StdEncoding.EncodeToString([]byte("%PDF-")), // Should be detected as PDF.
 			},
 		},
-		RequireTLS:    &yes,
-		FutureRelease: &now,
-		SaveSent:      true,
+		RequireTLS:         &yes,
+		RequireVerifiedTLS: true,
+		FutureRelease:      &now,
+		SaveSent:           true,
 	}
 	sendResp, err := client.Send(ctxbg, sendReq)
 	tcheckf(t, err, "send message")
@@ -231,7 +249,11 @@ func TestServer(t *testing.T) {
 	tcompare(t, subs[3].Address, "mjl+bcc@mox.example")
 	tcompare(t, subs[3].QueueMsgID, subs[0].QueueMsgID+3)
 	tcompare(t, subs[0].FromID, "")
-	// todo: look in queue for parameters. parse the message.
+	qmsgs, err := queue.List(ctxbg, queue.Filter{IDs: []int64{subs[0].QueueMsgID}}, queue.Sort{})
+	tcheckf(t, err, "list queue")
+	tcompare(t, len(qmsgs), 1)
+	tcompare(t, qmsgs[0].RequireVerifiedTLS, true)
+	// todo: look in queue for more parameters. parse the message.
 
 	// Send a custom multipart/form-data POST, with different request parameters, and
 	// additional files.
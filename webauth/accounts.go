@@ -14,7 +14,7 @@ var Accounts SessionAuth = accountSessionAuth{}
 
 type accountSessionAuth struct{}
 
-func (accountSessionAuth) login(ctx context.Context, log mlog.Log, username, password string) (valid, disabled bool, accName string, rerr error) {
+func (accountSessionAuth) login(ctx context.Context, log mlog.Log, username, password, totpCode string) (valid, disabled bool, accName string, rerr error) {
 	acc, accName, err := store.OpenEmailAuth(log, username, password, true)
 	if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
 		return false, false, accName, nil
@@ -27,15 +27,18 @@ func (accountSessionAuth) login(ctx context.Context, log mlog.Log, username, pas
 		err := acc.Close()
 		log.Check(err, "closing account")
 	}()
+	if err := acc.TOTPCheck(ctx, totpCode); err != nil {
+		return false, false, accName, err
+	}
 	return true, false, accName, nil
 }
 
-func (accountSessionAuth) add(ctx context.Context, log mlog.Log, accountName string, loginAddress string) (sessionToken store.SessionToken, csrfToken store.CSRFToken, rerr error) {
-	return store.SessionAdd(ctx, log, accountName, loginAddress)
+func (accountSessionAuth) add(ctx context.Context, log mlog.Log, accountName, loginAddress, remoteIP, userAgent string) (sessionToken store.SessionToken, csrfToken store.CSRFToken, rerr error) {
+	return store.SessionAdd(ctx, log, accountName, loginAddress, remoteIP, userAgent)
 }
 
-func (accountSessionAuth) use(ctx context.Context, log mlog.Log, accountName string, sessionToken store.SessionToken, csrfToken store.CSRFToken) (loginAddress string, rerr error) {
-	ls, err := store.SessionUse(ctx, log, accountName, sessionToken, csrfToken)
+func (accountSessionAuth) use(ctx context.Context, log mlog.Log, accountName string, sessionToken store.SessionToken, csrfToken store.CSRFToken, remoteIP, userAgent string) (loginAddress string, rerr error) {
+	ls, err := store.SessionUse(ctx, log, accountName, sessionToken, csrfToken, remoteIP, userAgent)
 	if err != nil {
 		return "", err
 	}
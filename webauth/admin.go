@@ -3,9 +3,13 @@ package webauth
 import (
 	"context"
 	cryptorand "crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -13,74 +17,213 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/text/secure/precis"
 
+	"github.com/mjl-/bstore"
+
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/totp"
 )
 
-// Admin is for admin logins, with authentication by password, and sessions only
-// stored in memory only, with lifetime 12 hour after last use, with a maximum of
-// 10 active sessions.
-var Admin SessionAuth = &adminSessionAuth{
-	sessions: map[store.SessionToken]adminSession{},
-}
+// Admin is for admin logins, with authentication by password (or WebAuthn, see
+// AdminLoginWebAuthn), and sessions stored in the database, with lifetime 12 hour
+// after last use, with a maximum of 10 active sessions. Sessions become invalid
+// when the admin password changes.
+//
+// Tenant admins log in with the tenant name as username, and the password of the
+// tenant. For tenant sessions, the tenant name is returned as login address by
+// Check.
+var Admin SessionAuth = &adminSessionAuth{}
 
 // Good chance of fitting one working day.
 const adminSessionLifetime = 12 * time.Hour
 
-type adminSession struct {
-	sessionToken store.SessionToken
-	csrfToken    store.CSRFToken
-	expires      time.Time
-}
+const adminSessionsMax = 10
+
+// Writes for extending the lifetime of admin sessions are skipped if the previous
+// use was recent, to prevent a database write for each request.
+const adminSessionWriteDelay = time.Minute
 
 type adminSessionAuth struct {
 	sync.Mutex
-	sessions map[store.SessionToken]adminSession
+
+	totpLastStep int64 // Of last used TOTP code, to prevent reuse.
+}
+
+// AdminTOTP holds the secret for two-factor authentication for the admin web
+// interface, and hashes of unused recovery codes.
+type AdminTOTP struct {
+	Secret             string   // Base32.
+	RecoveryCodeHashes []string // See totp.RecoveryCodeHash.
+}
+
+// AdminTOTPRead reads the admin TOTP file at path. The file has the secret on the
+// first line, and a recovery code hash on each following line. If the file does
+// not exist, an error matching fs.ErrNotExist is returned.
+func AdminTOTPRead(path string) (AdminTOTP, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return AdminTOTP{}, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	t := AdminTOTP{Secret: strings.TrimSpace(lines[0])}
+	if t.Secret == "" {
+		return AdminTOTP{}, fmt.Errorf("missing secret in admin totp file")
+	}
+	for _, l := range lines[1:] {
+		if l = strings.TrimSpace(l); l != "" {
+			t.RecoveryCodeHashes = append(t.RecoveryCodeHashes, l)
+		}
+	}
+	return t, nil
+}
+
+// AdminTOTPWrite writes the admin TOTP file, see AdminTOTPRead.
+func AdminTOTPWrite(path string, t AdminTOTP) error {
+	s := strings.Join(append([]string{t.Secret}, t.RecoveryCodeHashes...), "\n") + "\n"
+	return os.WriteFile(path, []byte(s), 0660)
+}
+
+// tenantSessionName returns the name used for tenant admin sessions, e.g. in
+// cookies and for authentication lockouts.
+func tenantSessionName(tenant string) string {
+	return "(tenant " + tenant + ")"
 }
 
-func (a *adminSessionAuth) login(ctx context.Context, log mlog.Log, username, password string) (valid, disabled bool, name string, rerr error) {
+func (a *adminSessionAuth) login(ctx context.Context, log mlog.Log, username, password, totpCode string) (valid, disabled bool, name string, rerr error) {
 	a.Lock()
 	defer a.Unlock()
 
-	p := mox.ConfigDirPath(mox.Conf.Static.AdminPasswordFile)
-	buf, err := os.ReadFile(p)
+	passwordhash, err := readAdminPasswordHash()
+	if username != "" {
+		if _, ok := mox.Conf.Tenant(username); !ok {
+			return false, false, "", nil
+		}
+		passwordhash, err = readTenantPasswordHash(username)
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			return false, false, "", nil
+		}
+	}
 	if err != nil {
-		return false, false, "", fmt.Errorf("reading password file: %v", err)
+		return false, false, "", err
 	}
-	passwordhash := strings.TrimSpace(string(buf))
 	// Transform with precis, if valid. ../rfc/8265:679
 	pw, err := precis.OpaqueString.String(password)
 	if err == nil {
 		password = pw
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(passwordhash), []byte(password)); err != nil {
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordhash), []byte(password)); err != nil && username != "" {
+		return false, false, tenantSessionName(username), nil
+	} else if err != nil {
 		return false, false, "", nil
 	}
 
+	// Two-factor authentication is only available for the admin.
+	if username != "" {
+		return true, false, tenantSessionName(username), nil
+	}
+
+	if err := a.checkTOTP(totpCode); err != nil && errors.Is(err, errAdminTOTPNotSetUp) {
+		return false, true, "(admin)", err
+	} else if err != nil {
+		return false, false, "(admin)", err
+	}
 	return true, false, "(admin)", nil
 }
 
-func (a *adminSessionAuth) add(ctx context.Context, log mlog.Log, accountName string, loginAddress string) (sessionToken store.SessionToken, csrfToken store.CSRFToken, rerr error) {
-	a.Lock()
-	defer a.Unlock()
+var errAdminTOTPNotSetUp = errors.New(`two-factor authentication is required but not set up, run "mox setadmintotp"`)
 
-	// Cleanup expired sessions.
-	for st, s := range a.sessions {
-		if time.Until(s.expires) < 0 {
-			delete(a.sessions, st)
+// checkTOTP verifies the TOTP or recovery code for the admin, if a TOTP file
+// exists. Must be called with lock held.
+func (a *adminSessionAuth) checkTOTP(code string) error {
+	if mox.Conf.Static.AdminTOTPFile == "" {
+		return nil
+	}
+	p := mox.ConfigDirPath(mox.Conf.Static.AdminTOTPFile)
+	t, err := AdminTOTPRead(p)
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		if mox.Conf.Static.AdminRequireTOTP {
+			return errAdminTOTPNotSetUp
 		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("reading admin totp file: %v", err)
+	}
+	if code == "" {
+		return store.ErrTOTPRequired
 	}
 
-	// Ensure we have at most 10 sessions.
-	if len(a.sessions) > 10 {
-		var oldest *store.SessionToken
-		for _, s := range a.sessions {
-			if oldest == nil || s.expires.Before(a.sessions[*oldest].expires) {
-				oldest = &s.sessionToken
-			}
-		}
-		delete(a.sessions, *oldest)
+	step, ok, err := totp.Verify(t.Secret, code, time.Now(), a.totpLastStep)
+	if err != nil {
+		return fmt.Errorf("verifying totp code: %v", err)
+	} else if ok {
+		a.totpLastStep = step
+		return nil
+	}
+
+	h := totp.RecoveryCodeHash(code)
+	i := slices.Index(t.RecoveryCodeHashes, h)
+	if i < 0 {
+		return store.ErrTOTPInvalid
+	}
+	t.RecoveryCodeHashes = slices.Delete(t.RecoveryCodeHashes, i, i+1)
+	if err := AdminTOTPWrite(p, t); err != nil {
+		return fmt.Errorf("removing used recovery code from admin totp file: %v", err)
+	}
+	return nil
+}
+
+// readAdminPasswordHash returns the bcrypt hash of the admin password, from the
+// admin password file, or as resolved when loading the config if the file is a
+// secret reference.
+func readAdminPasswordHash() (string, error) {
+	if mox.Conf.Static.AdminPasswordHash != "" {
+		return mox.Conf.Static.AdminPasswordHash, nil
+	}
+	buf, err := os.ReadFile(mox.ConfigDirPath(mox.Conf.Static.AdminPasswordFile))
+	if err != nil {
+		return "", fmt.Errorf("reading password file: %v", err)
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+// readTenantPasswordHash returns the bcrypt hash of the admin password of the
+// tenant.
+func readTenantPasswordHash(tenant string) (string, error) {
+	buf, err := os.ReadFile(mox.TenantAdminPasswordFile(tenant))
+	if err != nil {
+		return "", fmt.Errorf("reading tenant password file: %w", err)
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+// adminPasswordHash returns a hash of the admin password file, or the password
+// file of the tenant if not empty, stored with sessions so they can be
+// invalidated when the password changes.
+func adminPasswordHash(tenant string) (string, error) {
+	var pwhash string
+	var err error
+	if tenant == "" {
+		pwhash, err = readAdminPasswordHash()
+	} else {
+		pwhash, err = readTenantPasswordHash(tenant)
+	}
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256([]byte(pwhash))
+	return base64.RawURLEncoding.EncodeToString(h[:]), nil
+}
+
+func (a *adminSessionAuth) add(ctx context.Context, log mlog.Log, accountName, loginAddress, remoteIP, userAgent string) (sessionToken store.SessionToken, csrfToken store.CSRFToken, rerr error) {
+	// For tenant logins, the login address is the username, i.e. the tenant name.
+	var tenant string
+	if accountName != "(admin)" {
+		tenant = loginAddress
+	}
+	pwhash, err := adminPasswordHash(tenant)
+	if err != nil {
+		return "", "", err
 	}
 
 	// Generate new tokens.
@@ -90,35 +233,65 @@ func (a *adminSessionAuth) add(ctx context.Context, log mlog.Log, accountName st
 	sessionToken = store.SessionToken(base64.RawURLEncoding.EncodeToString(sessionData[:]))
 	csrfToken = store.CSRFToken(base64.RawURLEncoding.EncodeToString(csrfData[:]))
 
-	// Register session.
-	a.sessions[sessionToken] = adminSession{sessionToken, csrfToken, time.Now().Add(adminSessionLifetime)}
+	// Register session, removing the oldest if there are too many.
+	s := store.AdminSession{
+		Expires:      time.Now().Add(adminSessionLifetime),
+		SessionToken: string(sessionToken),
+		CSRFToken:    string(csrfToken),
+		PasswordHash: pwhash,
+		Tenant:       tenant,
+		LastUsed:     time.Now(),
+		RemoteIP:     remoteIP,
+		UserAgent:    userAgent,
+	}
+	if err := store.AdminSessionAdd(ctx, &s, adminSessionsMax); err != nil {
+		return "", "", fmt.Errorf("adding session: %v", err)
+	}
 	return sessionToken, csrfToken, nil
 }
 
-func (a *adminSessionAuth) use(ctx context.Context, log mlog.Log, accountName string, sessionToken store.SessionToken, csrfToken store.CSRFToken) (loginAddress string, rerr error) {
-	a.Lock()
-	defer a.Unlock()
-
-	s, ok := a.sessions[sessionToken]
-	if !ok {
-		return "", fmt.Errorf("unknown session (due to server restart or 10 new admin sessions)")
-	} else if time.Until(s.expires) < 0 {
+func (a *adminSessionAuth) use(ctx context.Context, log mlog.Log, accountName string, sessionToken store.SessionToken, csrfToken store.CSRFToken, remoteIP, userAgent string) (loginAddress string, rerr error) {
+	s, err := store.AdminSessionGet(ctx, sessionToken)
+	if err == bstore.ErrAbsent {
+		return "", fmt.Errorf("unknown session (revoked, or removed after 10 new admin sessions)")
+	} else if err != nil {
+		return "", fmt.Errorf("looking up session: %v", err)
+	} else if time.Until(s.Expires) < 0 {
 		return "", fmt.Errorf("session expired (after 12 hours inactivity)")
-	} else if csrfToken != "" && csrfToken != s.csrfToken {
+	} else if csrfToken != "" && string(csrfToken) != s.CSRFToken {
 		return "", fmt.Errorf("mismatch between csrf and session tokens")
 	}
-	s.expires = time.Now().Add(adminSessionLifetime)
-	a.sessions[sessionToken] = s
-	return "", nil
+	if s.Tenant != "" && accountName != tenantSessionName(s.Tenant) || s.Tenant == "" && accountName != "(admin)" {
+		return "", fmt.Errorf("mismatch between session and name in cookie")
+	} else if s.Tenant != "" {
+		if _, ok := mox.Conf.Tenant(s.Tenant); !ok {
+			return "", fmt.Errorf("tenant no longer exists")
+		}
+	}
+	if pwhash, err := adminPasswordHash(s.Tenant); err != nil {
+		return "", err
+	} else if pwhash != s.PasswordHash {
+		return "", fmt.Errorf("session no longer valid after admin password change")
+	}
+
+	if time.Since(s.LastUsed) >= adminSessionWriteDelay || s.RemoteIP != remoteIP || s.UserAgent != userAgent {
+		s.Expires = time.Now().Add(adminSessionLifetime)
+		s.LastUsed = time.Now()
+		s.RemoteIP = remoteIP
+		s.UserAgent = userAgent
+		if err := store.AdminSessionUpdate(ctx, &s); err != nil {
+			return "", fmt.Errorf("updating session: %v", err)
+		}
+	}
+	return s.Tenant, nil
 }
 
 func (a *adminSessionAuth) remove(ctx context.Context, log mlog.Log, accountName string, sessionToken store.SessionToken) error {
-	a.Lock()
-	defer a.Unlock()
-
-	if _, ok := a.sessions[sessionToken]; !ok {
+	s, err := store.AdminSessionGet(ctx, sessionToken)
+	if err == bstore.ErrAbsent {
 		return fmt.Errorf("unknown session")
+	} else if err != nil {
+		return fmt.Errorf("looking up session: %v", err)
 	}
-	delete(a.sessions, sessionToken)
-	return nil
+	return store.AdminSessionRemove(ctx, s.ID)
 }
@@ -0,0 +1,107 @@
+package webauth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webauthn"
+)
+
+// Outstanding WebAuthn challenges for the admin web interface, for registration
+// and login. Challenges expire, and can only be used once. The number of
+// challenges is limited, the oldest is dropped when a new challenge is needed.
+var webauthnChallenges = struct {
+	sync.Mutex
+	m map[string]time.Time // Raw-url-base64 challenge to expiration time.
+}{m: map[string]time.Time{}}
+
+const webauthnChallengeLifetime = 5 * time.Minute
+const webauthnChallengesMax = 100
+
+// WebAuthnChallenge returns a new challenge for a WebAuthn registration or login
+// ceremony for the admin web interface, to be verified with WebAuthnChallengeUse.
+func WebAuthnChallenge() []byte {
+	webauthnChallenges.Lock()
+	defer webauthnChallenges.Unlock()
+
+	now := time.Now()
+	var oldest string
+	for c, exp := range webauthnChallenges.m {
+		if now.After(exp) {
+			delete(webauthnChallenges.m, c)
+		} else if oldest == "" || exp.Before(webauthnChallenges.m[oldest]) {
+			oldest = c
+		}
+	}
+	if len(webauthnChallenges.m) >= webauthnChallengesMax {
+		delete(webauthnChallenges.m, oldest)
+	}
+
+	challenge := webauthn.NewChallenge()
+	webauthnChallenges.m[base64.RawURLEncoding.EncodeToString(challenge)] = now.Add(webauthnChallengeLifetime)
+	return challenge
+}
+
+// WebAuthnChallengeUse returns whether challenge was handed out by
+// WebAuthnChallenge and has not expired. A challenge can only be used once.
+func WebAuthnChallengeUse(challenge []byte) bool {
+	webauthnChallenges.Lock()
+	defer webauthnChallenges.Unlock()
+
+	k := base64.RawURLEncoding.EncodeToString(challenge)
+	exp, ok := webauthnChallenges.m[k]
+	delete(webauthnChallenges.m, k)
+	return ok && time.Now().Before(exp)
+}
+
+// WebAuthnRP returns the WebAuthn relying party ID and origin for a request to
+// the admin web interface. The relying party ID is the host name the web
+// interface is accessed at, credentials are only valid for that host name.
+func WebAuthnRP(isForwarded bool, r *http.Request) (rpID, origin string) {
+	rpID = r.Host
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		rpID = host
+	}
+	scheme := "http"
+	if isHTTPS(isForwarded, r) {
+		scheme = "https"
+	}
+	return rpID, scheme + "://" + r.Host
+}
+
+// AdminLoginWebAuthn handles a login attempt for the admin web interface with a
+// WebAuthn assertion from a registered security key or passkey, instead of the
+// admin password. The challenge must have been returned by WebAuthnChallenge.
+// Other parameters are from the response of the browser, and behaviour is like
+// Login.
+func AdminLoginWebAuthn(ctx context.Context, log mlog.Log, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request, loginToken string, challenge, credentialID, clientDataJSON, authenticatorData, signature []byte) (store.CSRFToken, error) {
+	return login(ctx, log, Admin, "webadmin", cookiePath, isForwarded, w, r, loginToken, "", "webauthn", func() (bool, bool, string, error) {
+		if !WebAuthnChallengeUse(challenge) {
+			return false, false, "", nil
+		}
+		rpID, origin := WebAuthnRP(isForwarded, r)
+		err := store.AdminWebAuthnCredentialUse(ctx, base64.RawURLEncoding.EncodeToString(credentialID), func(c store.AdminWebAuthnCredential) (uint32, error) {
+			if c.RPID != rpID {
+				return 0, fmt.Errorf("%w: credential registered for other host name", webauthn.ErrVerify)
+			}
+			return webauthn.VerifyAssertion(rpID, origin, challenge, clientDataJSON, authenticatorData, signature, c.PublicKey, c.SignCount, false)
+		})
+		if err == bstore.ErrAbsent || errors.Is(err, webauthn.ErrVerify) || errors.Is(err, webauthn.ErrUnsupported) {
+			log.Debugx("webauthn login failed", err)
+			return false, false, "(admin)", nil
+		} else if err != nil {
+			return false, false, "(admin)", err
+		}
+		return true, false, "(admin)", nil
+	})
+}
@@ -24,8 +24,12 @@ password change, and keeps the frontend free from handling long-term vs
 short-term sessions.
 
 Sessions for the admin interface have a lifetime of 12 hours after last use,
-are only stored in memory (don't survive a server restart), and only 10
-sessions can exist at a time (the oldest session is dropped).
+are stored in the database (do survive a server restart), only 10 sessions can
+exist at a time (the least recently used session is dropped), and become invalid
+when the admin password is changed.
+
+The remote IP and user agent of the most recent use of a session are stored,
+for showing active sessions in the web interfaces, where they can be revoked.
 
 Sessions for the account and mail interfaces have a lifetime of 24 hours after
 last use, are kept in memory and stored in the database (do survive a server
@@ -37,6 +41,7 @@ package webauth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
@@ -61,18 +66,22 @@ var BadAuthDelay = time.Second
 // SessionAuth handles login and session storage, used for both account and
 // admin authentication.
 type SessionAuth interface {
-	// Login verifies the password. Valid indicates the attempt was successful. If
-	// disabled is true, the error must be non-nil and contain details.
-	login(ctx context.Context, log mlog.Log, username, password string) (valid bool, disabled bool, accountName string, rerr error)
+	// Login verifies the password, and the TOTP code if two-factor authentication is
+	// enabled. Valid indicates the attempt was successful. If disabled is true, the
+	// error must be non-nil and contain details. If the password is valid but a TOTP
+	// code is missing or invalid, store.ErrTOTPRequired or store.ErrTOTPInvalid is
+	// returned.
+	login(ctx context.Context, log mlog.Log, username, password, totpCode string) (valid bool, disabled bool, accountName string, rerr error)
 
-	// Add a new session for account and login address.
-	add(ctx context.Context, log mlog.Log, accountName string, loginAddress string) (sessionToken store.SessionToken, csrfToken store.CSRFToken, rerr error)
+	// Add a new session for account and login address. The remote IP and user agent
+	// of the login are stored for showing active sessions.
+	add(ctx context.Context, log mlog.Log, accountName, loginAddress, remoteIP, userAgent string) (sessionToken store.SessionToken, csrfToken store.CSRFToken, rerr error)
 
 	// Use an existing session. If csrfToken is empty, no CSRF check must be done.
 	// Otherwise the CSRF token must be associated with the session token, as returned
 	// by add. If the token is not valid (e.g. expired, unknown, malformed), an error
-	// must be returned.
-	use(ctx context.Context, log mlog.Log, accountName string, sessionToken store.SessionToken, csrfToken store.CSRFToken) (loginAddress string, rerr error)
+	// must be returned. The remote IP and user agent are stored as most recent use.
+	use(ctx context.Context, log mlog.Log, accountName string, sessionToken store.SessionToken, csrfToken store.CSRFToken, remoteIP, userAgent string) (loginAddress string, rerr error)
 
 	// Removes a session, invalidating any future use. Must return an error if the
 	// session is not valid.
@@ -182,7 +191,7 @@ func Check(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind stri
 	}
 	la.AccountName = accountName
 
-	loginAddress, err = sessionAuth.use(ctx, log, accountName, sessionToken, csrfToken)
+	loginAddress, err = sessionAuth.use(ctx, log, accountName, sessionToken, csrfToken, ip.String(), r.UserAgent())
 	if err != nil {
 		la.Result = store.AuthBadCredentials
 		time.Sleep(BadAuthDelay)
@@ -243,8 +252,19 @@ func LoginPrep(ctx context.Context, log mlog.Log, kind, cookiePath string, isFor
 // response and returning the associated CSRF token.
 //
 // In case of a user error, a *sherpa.Error is returned that sherpa handlers can
-// pass to panic. For bad credentials, the error code is "user:loginFailed".
-func Login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request, loginToken, username, password string) (store.CSRFToken, error) {
+// pass to panic. For bad credentials, the error code is "user:loginFailed". If
+// two-factor authentication is enabled and totpCode is empty, the error code is
+// "user:totpRequired", and the login must be retried with a TOTP code (or recovery
+// code).
+func Login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request, loginToken, username, password, totpCode string) (store.CSRFToken, error) {
+	username = norm.NFC.String(username)
+	return login(ctx, log, sessionAuth, kind, cookiePath, isForwarded, w, r, loginToken, username, "weblogin", func() (bool, bool, string, error) {
+		return sessionAuth.login(ctx, log, username, password, strings.TrimSpace(totpCode))
+	})
+}
+
+// login handles a login attempt, with credentials verified by check, see Login.
+func login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request, loginToken, username, authMech string, check func() (valid, disabled bool, accountName string, rerr error)) (store.CSRFToken, error) {
 	loginCookie, _ := r.Cookie(kind + "login")
 	if loginCookie == nil || loginCookie.Value != loginToken {
 		msg := "missing login token cookie"
@@ -259,14 +279,18 @@ func Login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, coo
 		return "", fmt.Errorf("cannot find ip for rate limit check (missing x-forwarded-for header?)")
 	}
 	start := time.Now()
-	if !mox.LimiterFailedAuth.Add(ip, start, 1) {
+	if !mox.LimiterFailedAuth.Add(ip, start, 1) || store.AuthLockedOut(ip, "") {
 		metrics.AuthenticationRatelimitedInc(kind)
 		return "", &sherpa.Error{Code: "user:error", Message: "too many authentication attempts"}
 	}
 
-	username = norm.NFC.String(username)
-	valid, disabled, accountName, err := sessionAuth.login(ctx, log, username, password)
-	la := loginAttempt(ip.String(), r, kind, "weblogin")
+	valid, disabled, accountName, err := check()
+	if accountName != "" && store.AuthLockedOut(nil, accountName) {
+		// Locked out after too many failed attempts, we don't reveal whether the
+		// credentials were valid.
+		valid, disabled, err = false, false, nil
+	}
+	la := loginAttempt(ip.String(), r, kind, authMech)
 	la.LoginAddress = username
 	la.AccountName = accountName
 	defer func() {
@@ -275,6 +299,13 @@ func Login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, coo
 	if disabled {
 		la.Result = store.AuthLoginDisabled
 		return "", &sherpa.Error{Code: "user:loginFailed", Message: err.Error()}
+	} else if errors.Is(err, store.ErrTOTPRequired) {
+		la.Result = store.AuthTOTPRequired
+		return "", &sherpa.Error{Code: "user:totpRequired", Message: err.Error()}
+	} else if errors.Is(err, store.ErrTOTPInvalid) {
+		time.Sleep(BadAuthDelay)
+		la.Result = store.AuthBadTOTP
+		return "", &sherpa.Error{Code: "user:loginFailed", Message: "invalid credentials"}
 	} else if err != nil {
 		la.Result = store.AuthError
 		return "", fmt.Errorf("evaluating login attempt: %v", err)
@@ -286,7 +317,7 @@ func Login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, coo
 	la.Result = store.AuthSuccess
 	mox.LimiterFailedAuth.Reset(ip, start)
 
-	sessionToken, csrfToken, err := sessionAuth.add(ctx, log, accountName, username)
+	sessionToken, csrfToken, err := sessionAuth.add(ctx, log, accountName, username, ip.String(), r.UserAgent())
 	if err != nil {
 		la.Result = store.AuthError
 		log.Errorx("adding session after login", err)
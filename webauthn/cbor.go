@@ -0,0 +1,138 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// errCBOR is returned for malformed or unsupported CBOR data.
+var errCBOR = errors.New("bad cbor")
+
+// Maximum nesting of arrays and maps, authenticator data is shallow.
+const cborMaxDepth = 8
+
+// cborDecode decodes a single CBOR data item from buf, returning the value and
+// the remaining bytes. Only the subset of CBOR used by WebAuthn is supported,
+// ../rfc/8949: Definite-length unsigned and negative integers (as int64), byte
+// strings ([]byte), text strings (string), arrays ([]any), maps (map[any]any),
+// booleans and null. Floats are skipped and returned as nil. Tags and
+// indefinite-length items are rejected.
+func cborDecode(buf []byte) (v any, rest []byte, rerr error) {
+	return cborDecodeDepth(buf, 0)
+}
+
+func cborDecodeDepth(buf []byte, depth int) (v any, rest []byte, rerr error) {
+	if depth > cborMaxDepth {
+		return nil, nil, fmt.Errorf("%w: nested too deep", errCBOR)
+	}
+	if len(buf) == 0 {
+		return nil, nil, fmt.Errorf("%w: missing data", errCBOR)
+	}
+	major := buf[0] >> 5
+	info := buf[0] & 0x1f
+	buf = buf[1:]
+
+	// Read the argument, a length or value, depending on major type.
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 24:
+		if len(buf) < 1 {
+			return nil, nil, fmt.Errorf("%w: short argument", errCBOR)
+		}
+		arg, buf = uint64(buf[0]), buf[1:]
+	case info == 25:
+		if len(buf) < 2 {
+			return nil, nil, fmt.Errorf("%w: short argument", errCBOR)
+		}
+		arg, buf = uint64(binary.BigEndian.Uint16(buf)), buf[2:]
+	case info == 26:
+		if len(buf) < 4 {
+			return nil, nil, fmt.Errorf("%w: short argument", errCBOR)
+		}
+		arg, buf = uint64(binary.BigEndian.Uint32(buf)), buf[4:]
+	case info == 27:
+		if len(buf) < 8 {
+			return nil, nil, fmt.Errorf("%w: short argument", errCBOR)
+		}
+		arg, buf = binary.BigEndian.Uint64(buf), buf[8:]
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported additional information %d", errCBOR, info)
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer too large", errCBOR)
+		}
+		return int64(arg), buf, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer too large", errCBOR)
+		}
+		return -1 - int64(arg), buf, nil
+	case 2, 3:
+		if arg > uint64(len(buf)) {
+			return nil, nil, fmt.Errorf("%w: string longer than data", errCBOR)
+		}
+		if major == 2 {
+			return buf[:arg], buf[arg:], nil
+		}
+		return string(buf[:arg]), buf[arg:], nil
+	case 4:
+		if arg > uint64(len(buf)) {
+			return nil, nil, fmt.Errorf("%w: array longer than data", errCBOR)
+		}
+		l := make([]any, arg)
+		for i := range l {
+			var err error
+			l[i], buf, err = cborDecodeDepth(buf, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return l, buf, nil
+	case 5:
+		if arg > uint64(len(buf)) {
+			return nil, nil, fmt.Errorf("%w: map longer than data", errCBOR)
+		}
+		m := map[any]any{}
+		for range arg {
+			k, nbuf, err := cborDecodeDepth(buf, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: unsupported map key type %T", errCBOR, k)
+			}
+			v, nbuf, err := cborDecodeDepth(nbuf, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+			buf = nbuf
+		}
+		return m, buf, nil
+	case 6:
+		return nil, nil, fmt.Errorf("%w: tags not supported", errCBOR)
+	case 7:
+		switch info {
+		case 20:
+			return false, buf, nil
+		case 21:
+			return true, buf, nil
+		case 22, 23:
+			return nil, buf, nil
+		case 25, 26, 27:
+			// Float, argument already consumed.
+			return nil, buf, nil
+		}
+		return nil, nil, fmt.Errorf("%w: unsupported simple value %d", errCBOR, info)
+	}
+	panic("unreachable")
+}
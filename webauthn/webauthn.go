@@ -0,0 +1,286 @@
+// Package webauthn implements the server side ("relying party") of WebAuthn, for
+// logging in with security keys and passkeys.
+//
+// Only what is needed for authentication is implemented: Verifying registration
+// of a new credential and verifying assertions (signatures) during login.
+// Attestation statements are not verified, credentials should be requested with
+// attestation "none". Public keys with algorithms ES256 (ECDSA with P-256), EdDSA
+// (Ed25519) and RS256 (RSA PKCS#1 v1.5 with SHA-256) are supported.
+//
+// WebAuthn is specified by the W3C, see https://www.w3.org/TR/webauthn-2/. It
+// uses CBOR (RFC 8949) and COSE keys (RFC 8152).
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	// ErrVerify is returned when verifying a registration or assertion failed, e.g.
+	// due to a mismatching challenge, origin or relying party, or a bad signature.
+	ErrVerify = errors.New("webauthn verification failed")
+
+	// ErrUnsupported is returned for credentials with an unsupported public key
+	// type or algorithm.
+	ErrUnsupported = errors.New("unsupported webauthn credential")
+)
+
+// COSE algorithm identifiers. ../rfc/8152
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Algorithms lists the supported COSE algorithms, in order of preference, for the
+// "pubKeyCredParams" in credential creation options.
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// Flags in authenticator data.
+const (
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+	flagExtensionData    = 0x80
+)
+
+// NewChallenge returns a new random challenge, to be sent to the client and
+// passed to VerifyRegistration or VerifyAssertion after the client responds.
+func NewChallenge() []byte {
+	buf := make([]byte, 32)
+	cryptorand.Read(buf)
+	return buf
+}
+
+// Credential is a verified new credential, to be stored for verifying assertions
+// during future logins.
+type Credential struct {
+	ID        []byte // Credential ID, chosen by authenticator.
+	PublicKey []byte // COSE key, CBOR-encoded.
+	SignCount uint32 // Signature counter, zero if the authenticator does not implement it.
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// checkClientData verifies the client data is for the ceremony of type typ, with
+// the expected challenge and origin.
+func checkClientData(clientDataJSON []byte, typ string, challenge []byte, origin string) error {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return fmt.Errorf("%w: parsing client data: %v", ErrVerify, err)
+	}
+	if cd.Type != typ {
+		return fmt.Errorf("%w: client data has type %q, expected %q", ErrVerify, cd.Type, typ)
+	}
+	if cd.Challenge != base64.RawURLEncoding.EncodeToString(challenge) {
+		return fmt.Errorf("%w: challenge mismatch", ErrVerify)
+	}
+	if cd.Origin != origin {
+		return fmt.Errorf("%w: client data has origin %q, expected %q", ErrVerify, cd.Origin, origin)
+	}
+	return nil
+}
+
+type authData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte // Only for registration.
+	publicKey    []byte // Only for registration, COSE key.
+}
+
+// parseAuthData parses and checks authenticator data, verifying the relying party
+// ID hash and user presence.
+func parseAuthData(buf []byte, rpID string, requireUV bool) (authData, error) {
+	var ad authData
+	if len(buf) < 37 {
+		return ad, fmt.Errorf("%w: authenticator data too short", ErrVerify)
+	}
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(buf[:32], rpIDHash[:]) {
+		return ad, fmt.Errorf("%w: authenticator data for other relying party", ErrVerify)
+	}
+	ad.flags = buf[32]
+	ad.signCount = binary.BigEndian.Uint32(buf[33:37])
+	if ad.flags&flagUserPresent == 0 {
+		return ad, fmt.Errorf("%w: user not present", ErrVerify)
+	}
+	if requireUV && ad.flags&flagUserVerified == 0 {
+		return ad, fmt.Errorf("%w: user not verified", ErrVerify)
+	}
+	rest := buf[37:]
+	if ad.flags&flagAttestedCredData != 0 {
+		// AAGUID, credential ID length, credential ID, public key.
+		if len(rest) < 18 {
+			return ad, fmt.Errorf("%w: attested credential data too short", ErrVerify)
+		}
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n == 0 || n > 1023 || n > len(rest) {
+			return ad, fmt.Errorf("%w: bad credential id length %d", ErrVerify, n)
+		}
+		ad.credentialID = rest[:n]
+		rest = rest[n:]
+		_, nrest, err := cborDecode(rest)
+		if err != nil {
+			return ad, fmt.Errorf("%w: parsing credential public key: %v", ErrVerify, err)
+		}
+		ad.publicKey = rest[:len(rest)-len(nrest)]
+		rest = nrest
+	}
+	if ad.flags&flagExtensionData != 0 {
+		var err error
+		_, rest, err = cborDecode(rest)
+		if err != nil {
+			return ad, fmt.Errorf("%w: parsing extension data: %v", ErrVerify, err)
+		}
+	}
+	if len(rest) != 0 {
+		return ad, fmt.Errorf("%w: trailing data after authenticator data", ErrVerify)
+	}
+	return ad, nil
+}
+
+// VerifyRegistration verifies the response of a client to a credential creation
+// request ("navigator.credentials.create"). The clientDataJSON and
+// attestationObject are from the response. The challenge must be the one sent to
+// the client. The relying party ID (rpID) is typically the hostname, and origin is
+// the origin of the web page, e.g. "https://" + the hostname. If requireUV is
+// set, the authenticator must have verified the user, e.g. with a PIN or
+// biometrics.
+func VerifyRegistration(rpID, origin string, challenge, clientDataJSON, attestationObject []byte, requireUV bool) (Credential, error) {
+	if err := checkClientData(clientDataJSON, "webauthn.create", challenge, origin); err != nil {
+		return Credential{}, err
+	}
+
+	v, rest, err := cborDecode(attestationObject)
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: parsing attestation object: %v", ErrVerify, err)
+	} else if len(rest) != 0 {
+		return Credential{}, fmt.Errorf("%w: trailing data after attestation object", ErrVerify)
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: attestation object not a map", ErrVerify)
+	}
+	// We don't verify attestation statements, they only say something about the
+	// authenticator, and we request attestation "none".
+	authDataBuf, ok := m["authData"].([]byte)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: missing authData in attestation object", ErrVerify)
+	}
+	ad, err := parseAuthData(authDataBuf, rpID, requireUV)
+	if err != nil {
+		return Credential{}, err
+	}
+	if ad.credentialID == nil {
+		return Credential{}, fmt.Errorf("%w: missing attested credential data", ErrVerify)
+	}
+	if _, _, err := parsePublicKey(ad.publicKey); err != nil {
+		return Credential{}, err
+	}
+	return Credential{ad.credentialID, ad.publicKey, ad.signCount}, nil
+}
+
+// VerifyAssertion verifies the response of a client to an authentication request
+// ("navigator.credentials.get"), for a previously registered credential with
+// publicKey and signCount. Parameters rpID, origin, challenge and requireUV are
+// like for VerifyRegistration. The new signature counter is returned, to be
+// stored. If the authenticator implements a signature counter, the counter must
+// have increased, otherwise the credential may have been cloned.
+func VerifyAssertion(rpID, origin string, challenge, clientDataJSON, authenticatorData, signature, publicKey []byte, signCount uint32, requireUV bool) (uint32, error) {
+	if err := checkClientData(clientDataJSON, "webauthn.get", challenge, origin); err != nil {
+		return 0, err
+	}
+	ad, err := parseAuthData(authenticatorData, rpID, requireUV)
+	if err != nil {
+		return 0, err
+	}
+	pubKey, alg, err := parsePublicKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	msg := append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+	digest := sha256.Sum256(msg)
+	var valid bool
+	switch alg {
+	case AlgES256:
+		valid = ecdsa.VerifyASN1(pubKey.(*ecdsa.PublicKey), digest[:], signature)
+	case AlgEdDSA:
+		valid = ed25519.Verify(pubKey.(ed25519.PublicKey), msg, signature)
+	case AlgRS256:
+		valid = rsa.VerifyPKCS1v15(pubKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	}
+	if !valid {
+		return 0, fmt.Errorf("%w: bad signature", ErrVerify)
+	}
+
+	if (ad.signCount != 0 || signCount != 0) && ad.signCount <= signCount {
+		return 0, fmt.Errorf("%w: signature counter did not increase, credential may be cloned", ErrVerify)
+	}
+	return ad.signCount, nil
+}
+
+// parsePublicKey parses a CBOR-encoded COSE key. ../rfc/8152
+func parsePublicKey(buf []byte) (crypto.PublicKey, int, error) {
+	v, _, err := cborDecode(buf)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: parsing public key: %v", ErrUnsupported, err)
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: public key not a map", ErrUnsupported)
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	crv, _ := m[int64(-1)].(int64)
+	switch {
+	case kty == 2 && alg == AlgES256 && crv == 1:
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, fmt.Errorf("%w: bad ec2 public key", ErrUnsupported)
+		}
+		pk := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pk.Curve.IsOnCurve(pk.X, pk.Y) {
+			return nil, 0, fmt.Errorf("%w: ec2 public key not on curve", ErrUnsupported)
+		}
+		return pk, AlgES256, nil
+	case kty == 1 && alg == AlgEdDSA && crv == 6:
+		x, _ := m[int64(-2)].([]byte)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, fmt.Errorf("%w: bad okp public key", ErrUnsupported)
+		}
+		return ed25519.PublicKey(x), AlgEdDSA, nil
+	case kty == 3 && alg == AlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 2048/8 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, fmt.Errorf("%w: bad rsa public key", ErrUnsupported)
+		}
+		var exp int
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, AlgRS256, nil
+	}
+	return nil, 0, fmt.Errorf("%w: key type %d with algorithm %d", ErrUnsupported, kty, alg)
+}
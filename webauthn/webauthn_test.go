@@ -0,0 +1,225 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("got %#v, expected %#v", got, exp)
+	}
+}
+
+// cborEncode encodes the few types needed for the tests.
+func cborEncode(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 256:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 65536:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		}
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	switch x := v.(type) {
+	case int:
+		if x >= 0 {
+			return head(0, uint64(x))
+		}
+		return head(1, uint64(-1-x))
+	case []byte:
+		return append(head(2, uint64(len(x))), x...)
+	case string:
+		return append(head(3, uint64(len(x))), x...)
+	case [][2]any:
+		// Map with ordered keys.
+		buf := head(5, uint64(len(x)))
+		for _, kv := range x {
+			buf = append(buf, cborEncode(kv[0])...)
+			buf = append(buf, cborEncode(kv[1])...)
+		}
+		return buf
+	}
+	panic("unsupported type")
+}
+
+// authenticator is a fake authenticator for a single credential.
+type authenticator struct {
+	rpID      string
+	credID    []byte
+	coseKey   []byte
+	sign      func(msg []byte) []byte
+	signCount uint32
+	flags     byte
+}
+
+func newAuthenticator(rpID string, ed bool) *authenticator {
+	a := &authenticator{rpID: rpID, credID: NewChallenge(), flags: flagUserPresent | flagUserVerified}
+	if ed {
+		pub, priv, _ := ed25519.GenerateKey(cryptorand.Reader)
+		a.coseKey = cborEncode([][2]any{{1, 1}, {3, AlgEdDSA}, {-1, 6}, {-2, []byte(pub)}})
+		a.sign = func(msg []byte) []byte { return ed25519.Sign(priv, msg) }
+	} else {
+		priv, _ := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+		x := priv.X.FillBytes(make([]byte, 32))
+		y := priv.Y.FillBytes(make([]byte, 32))
+		a.coseKey = cborEncode([][2]any{{1, 2}, {3, AlgES256}, {-1, 1}, {-2, x}, {-3, y}})
+		a.sign = func(msg []byte) []byte {
+			h := sha256.Sum256(msg)
+			sig, err := ecdsa.SignASN1(cryptorand.Reader, priv, h[:])
+			if err != nil {
+				panic(err)
+			}
+			return sig
+		}
+	}
+	return a
+}
+
+func (a *authenticator) authData(attested bool) []byte {
+	h := sha256.Sum256([]byte(a.rpID))
+	flags := a.flags
+	if attested {
+		flags |= flagAttestedCredData
+	}
+	buf := append(h[:], flags)
+	buf = binary.BigEndian.AppendUint32(buf, a.signCount)
+	if attested {
+		buf = append(buf, make([]byte, 16)...) // AAGUID
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(a.credID)))
+		buf = append(buf, a.credID...)
+		buf = append(buf, a.coseKey...)
+	}
+	return buf
+}
+
+func clientDataJSON(typ string, challenge []byte, origin string) []byte {
+	buf, err := json.Marshal(clientData{typ, base64.RawURLEncoding.EncodeToString(challenge), origin})
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+func (a *authenticator) create(challenge []byte, origin string) (clientData, attestationObject []byte) {
+	clientData = clientDataJSON("webauthn.create", challenge, origin)
+	attestationObject = cborEncode([][2]any{{"fmt", "none"}, {"attStmt", [][2]any{}}, {"authData", a.authData(true)}})
+	return
+}
+
+func (a *authenticator) get(challenge []byte, origin string) (clientData, authData, sig []byte) {
+	a.signCount++
+	clientData = clientDataJSON("webauthn.get", challenge, origin)
+	authData = a.authData(false)
+	h := sha256.Sum256(clientData)
+	sig = a.sign(append(append([]byte{}, authData...), h[:]...))
+	return
+}
+
+func TestWebAuthn(t *testing.T) {
+	const rpID = "mox.example"
+	const origin = "https://mox.example"
+
+	for _, ed := range []bool{false, true} {
+		a := newAuthenticator(rpID, ed)
+
+		challenge := NewChallenge()
+		cd, ao := a.create(challenge, origin)
+		cred, err := VerifyRegistration(rpID, origin, challenge, cd, ao, true)
+		tcheck(t, err, "verify registration")
+		tcompare(t, cred.ID, a.credID)
+		tcompare(t, cred.PublicKey, a.coseKey)
+
+		_, err = VerifyRegistration(rpID, origin, NewChallenge(), cd, ao, false)
+		if !errors.Is(err, ErrVerify) {
+			t.Fatalf("got %v, expected ErrVerify for other challenge", err)
+		}
+		_, err = VerifyRegistration(rpID, "https://other.example", challenge, cd, ao, false)
+		if !errors.Is(err, ErrVerify) {
+			t.Fatalf("got %v, expected ErrVerify for other origin", err)
+		}
+		_, err = VerifyRegistration("other.example", origin, challenge, cd, ao, false)
+		if !errors.Is(err, ErrVerify) {
+			t.Fatalf("got %v, expected ErrVerify for other rp id", err)
+		}
+
+		challenge = NewChallenge()
+		cd, ad, sig := a.get(challenge, origin)
+		signCount, err := VerifyAssertion(rpID, origin, challenge, cd, ad, sig, cred.PublicKey, cred.SignCount, true)
+		tcheck(t, err, "verify assertion")
+		tcompare(t, signCount, uint32(1))
+
+		// Replay, counter does not increase.
+		_, err = VerifyAssertion(rpID, origin, challenge, cd, ad, sig, cred.PublicKey, signCount, true)
+		if !errors.Is(err, ErrVerify) {
+			t.Fatalf("got %v, expected ErrVerify for replay", err)
+		}
+
+		// Bad signature.
+		challenge = NewChallenge()
+		cd, ad, sig = a.get(challenge, origin)
+		sig[len(sig)-1] ^= 1
+		_, err = VerifyAssertion(rpID, origin, challenge, cd, ad, sig, cred.PublicKey, signCount, true)
+		if !errors.Is(err, ErrVerify) {
+			t.Fatalf("got %v, expected ErrVerify for bad signature", err)
+		}
+
+		// Type of client data must match.
+		_, err = VerifyAssertion(rpID, origin, challenge, clientDataJSON("webauthn.create", challenge, origin), ad, sig, cred.PublicKey, signCount, true)
+		if !errors.Is(err, ErrVerify) {
+			t.Fatalf("got %v, expected ErrVerify for bad client data type", err)
+		}
+
+		// User verification.
+		a.flags = flagUserPresent
+		challenge = NewChallenge()
+		cd, ad, sig = a.get(challenge, origin)
+		_, err = VerifyAssertion(rpID, origin, challenge, cd, ad, sig, cred.PublicKey, signCount, true)
+		if !errors.Is(err, ErrVerify) {
+			t.Fatalf("got %v, expected ErrVerify for missing user verification", err)
+		}
+		_, err = VerifyAssertion(rpID, origin, challenge, cd, ad, sig, cred.PublicKey, signCount, false)
+		tcheck(t, err, "verify assertion without user verification")
+	}
+}
+
+func TestCBOR(t *testing.T) {
+	v, rest, err := cborDecode(cborEncode([][2]any{{1, -257}, {"k", []byte("v")}, {-2, "text"}}))
+	tcheck(t, err, "decode")
+	tcompare(t, len(rest), 0)
+	tcompare(t, v, map[any]any{int64(1): int64(-257), "k": []byte("v"), int64(-2): "text"})
+
+	bad := func(buf []byte) {
+		t.Helper()
+		_, _, err := cborDecode(buf)
+		if !errors.Is(err, errCBOR) {
+			t.Fatalf("got %v, expected errCBOR", err)
+		}
+	}
+	bad(nil)
+	bad([]byte{0x5f})                         // Indefinite-length byte string.
+	bad([]byte{0x42, 0x01})                   // Short byte string.
+	bad([]byte{0xc1, 0x00})                   // Tag.
+	bad([]byte{0xa1, 0x41, 0, 0})             // Map with bytes as key.
+	bad([]byte{0x9a, 0xff, 0xff, 0xff, 0xff}) // Array longer than data.
+}
@@ -35,7 +35,6 @@ import (
 
 	"github.com/mjl-/mox/admin"
 	"github.com/mjl-/mox/config"
-	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
@@ -72,6 +71,12 @@ func mustParseAPI(api string, buf []byte) (doc sherpadoc.Section) {
 
 var webmailDoc = mustParseAPI("webmail", webmailapiJSON)
 
+// APIDocJSON returns the sherpadoc documentation of the webmail API in JSON, as
+// served at api/sherpadoc.json. The returned slice must not be modified.
+func APIDocJSON() []byte {
+	return webmailapiJSON
+}
+
 var sherpaHandlerOpts *sherpa.HandlerOpts
 
 func makeSherpaHandler(maxMessageSize int64, cookiePath string, isForwarded bool) (http.Handler, error) {
@@ -108,12 +113,14 @@ func (w Webmail) LoginPrep(ctx context.Context) string {
 }
 
 // Login returns a session token for the credentials, or fails with error code
-// "user:badLogin". Call LoginPrep to get a loginToken.
-func (w Webmail) Login(ctx context.Context, loginToken, username, password string) store.CSRFToken {
+// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+// authentication is enabled and totp is empty, the call fails with error code
+// "user:totpRequired", and must be repeated with a TOTP code or recovery code.
+func (w Webmail) Login(ctx context.Context, loginToken, username, password, totp string) store.CSRFToken {
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 	log := reqInfo.Log
 
-	csrfToken, err := webauth.Login(ctx, log, webauth.Accounts, "webmail", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, username, password)
+	csrfToken, err := webauth.Login(ctx, log, webauth.Accounts, "webmail", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, username, password, totp)
 	if _, ok := err.(*sherpa.Error); ok {
 		panic(err)
 	}
@@ -574,6 +581,79 @@ func xmessageID(ctx context.Context, tx *bstore.Tx, messageID int64) store.Messa
 	return m
 }
 
+// xparseAttachment parses the data URI of an attachment, returning its media
+// type, filename and base64-encoded data.
+func xparseAttachment(ctx context.Context, a File) (ct, filename, base64Data string) {
+	s := a.DataURI
+	if !strings.HasPrefix(s, "data:") {
+		xcheckuserf(ctx, errors.New("missing data: in datauri"), "parsing attachment")
+	}
+	s = s[len("data:"):]
+	t := strings.SplitN(s, ",", 2)
+	if len(t) != 2 {
+		xcheckuserf(ctx, errors.New("missing comma in datauri"), "parsing attachment")
+	}
+	if !strings.HasSuffix(t[0], "base64") {
+		xcheckuserf(ctx, errors.New("missing base64 in datauri"), "parsing attachment")
+	}
+	ct = strings.TrimSuffix(t[0], "base64")
+	ct = strings.TrimSuffix(ct, ";")
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	filename = a.Filename
+	if filename == "" {
+		filename = "unnamed.bin"
+	}
+	return ct, filename, t[1]
+}
+
+// xattachmentLinks stores attachments of at least the configured size as
+// attachment links, returning the remaining attachments, and the text body with
+// the download links added. The links start with baseURL, the URL of webmail.
+// Only used for messages composed in webmail, messages submitted over SMTP or the
+// webapi are sent with their attachments as is.
+func xattachmentLinks(ctx context.Context, log mlog.Log, baseURL, accountName string, conf config.AttachmentLinks, attachments []File, textBody string) ([]File, string) {
+	days := conf.ValidityDays
+	if days == 0 {
+		days = 14
+	}
+	expires := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+
+	var keep []File
+	var links []string
+	for _, a := range attachments {
+		ct, filename, data := xparseAttachment(ctx, a)
+		if int64(base64.StdEncoding.DecodedLen(len(data))) < conf.MinSize {
+			keep = append(keep, a)
+			continue
+		}
+
+		_, err := io.Copy(io.Discard, base64.NewDecoder(base64.StdEncoding, strings.NewReader(data)))
+		xcheckuserf(ctx, err, "parsing attachment as base64")
+
+		al := store.AttachmentLink{
+			Account:     accountName,
+			Filename:    filename,
+			ContentType: ct,
+			Expires:     expires,
+		}
+		err = store.AttachmentLinkAdd(ctx, log, &al, base64.NewDecoder(base64.StdEncoding, strings.NewReader(data)))
+		xcheckf(ctx, err, "storing attachment for link")
+		log.Info("attachment replaced with link", slog.Int64("attachmentlinkid", al.ID), slog.Int64("size", al.Size))
+		links = append(links, fmt.Sprintf("- %s (%.1f MB)\n  %s", filename, float64(al.Size)/(1024*1024), baseURL+"attachment/"+al.Token))
+	}
+	if len(links) == 0 {
+		return attachments, textBody
+	}
+
+	if textBody != "" && !strings.HasSuffix(textBody, "\n") {
+		textBody += "\n"
+	}
+	textBody += fmt.Sprintf("\nLarge attachments, available for download until %s:\n\n%s\n", expires.Format("2 Jan 2006"), strings.Join(links, "\n"))
+	return keep, textBody
+}
+
 func xrandomID(ctx context.Context, n int) string {
 	return base64.RawURLEncoding.EncodeToString(xrandom(ctx, n))
 }
@@ -598,6 +678,11 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 
 	log.Debug("message submit")
 
+	if accConf, _ := acc.Conf(); accConf.SubmissionDisabled != "" {
+		metricSubmission.WithLabelValues("disabled").Inc()
+		xcheckuserf(ctx, errors.New(accConf.SubmissionDisabled), "submission disabled")
+	}
+
 	// Similar between ../smtpserver/server.go:/submit\( and ../webmail/api.go:/MessageSubmit\( and ../webapisrv/server.go:/Send\(
 
 	// todo: consider making this an HTTP POST, so we can upload as regular form, which is probably more efficient for encoding for the client and we can stream the data in. also not unlike the webapi Submit method.
@@ -663,22 +748,47 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 		xcheckuserf(ctx, errors.New("no recipients"), "composing message")
 	}
 
-	// Check outgoing message rate limit.
+	// Check outgoing message rate limit. If a limit is reached, the message is
+	// refused, or held in the queue for review if the account is configured to.
+	var limitErr error
+	var limitMetric, limitReason string
 	xdbread(ctx, acc, func(tx *bstore.Tx) {
 		rcpts := make([]smtp.Path, len(recipients))
 		for i, r := range recipients {
 			rcpts[i] = smtp.Path{Localpart: r.Localpart, IPDomain: dns.IPDomain{Domain: r.Domain}}
 		}
 		msglimit, rcptlimit, err := acc.SendLimitReached(tx, rcpts)
+		xcheckf(ctx, err, "checking send limit")
 		if msglimit >= 0 {
-			metricSubmission.WithLabelValues("messagelimiterror").Inc()
-			xcheckuserf(ctx, errors.New("message limit reached"), "checking outgoing rate")
+			limitErr = errors.New("message limit reached")
+			limitMetric = "messagelimiterror"
+			limitReason = fmt.Sprintf("max number of messages (%d) over past 24h reached", msglimit)
 		} else if rcptlimit >= 0 {
-			metricSubmission.WithLabelValues("recipientlimiterror").Inc()
-			xcheckuserf(ctx, errors.New("recipient limit reached"), "checking outgoing rate")
+			limitErr = errors.New("recipient limit reached")
+			limitMetric = "recipientlimiterror"
+			limitReason = fmt.Sprintf("max number of new/first-time recipients (%d) over past 24h reached", rcptlimit)
 		}
-		xcheckf(ctx, err, "checking send limit")
 	})
+	var holdReason string
+	if limitErr != nil {
+		hold, err := queue.HoldForReview(ctx, acc.Name)
+		xcheckf(ctx, err, "checking messages held for review")
+		if !hold {
+			metricSubmission.WithLabelValues(limitMetric).Inc()
+			xcheckuserf(ctx, limitErr, "checking outgoing rate")
+		}
+		metricSubmission.WithLabelValues("holdforreview").Inc()
+		log.Info("holding submitted message in queue for review", slog.String("reason", limitReason))
+		holdReason = limitReason
+	}
+
+	// Replace large attachments with links if configured.
+	if accConf, _ := acc.Conf(); accConf.AttachmentLinks != nil && len(m.Attachments) > 0 {
+		// Links are to this webmail, always over https at the configured hostname, not the
+		// (possibly internal) host or scheme of the request.
+		baseURL := "https://" + mox.Conf.Static.HostnameDomain.Name() + w.cookiePath
+		m.Attachments, m.TextBody = xattachmentLinks(ctx, log, baseURL, acc.Name, *accConf.AttachmentLinks, m.Attachments, m.TextBody)
+	}
 
 	// We only use smtputf8 if we have to, with a utf-8 localpart. For IDNA, we use ASCII domains.
 	smtputf8 := false
@@ -845,35 +955,15 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 		}
 
 		for _, a := range m.Attachments {
-			s := a.DataURI
-			if !strings.HasPrefix(s, "data:") {
-				xcheckuserf(ctx, errors.New("missing data: in datauri"), "parsing attachment")
-			}
-			s = s[len("data:"):]
-			t := strings.SplitN(s, ",", 2)
-			if len(t) != 2 {
-				xcheckuserf(ctx, errors.New("missing comma in datauri"), "parsing attachment")
-			}
-			if !strings.HasSuffix(t[0], "base64") {
-				xcheckuserf(ctx, errors.New("missing base64 in datauri"), "parsing attachment")
-			}
-			ct := strings.TrimSuffix(t[0], "base64")
-			ct = strings.TrimSuffix(ct, ";")
-			if ct == "" {
-				ct = "application/octet-stream"
-			}
-			filename := a.Filename
-			if filename == "" {
-				filename = "unnamed.bin"
-			}
+			ct, filename, data := xparseAttachment(ctx, a)
 			params := map[string]string{"name": filename}
 			ct = mime.FormatMediaType(ct, params)
 
 			// Ensure base64 is valid, then we'll write the original string.
-			_, err := io.Copy(io.Discard, base64.NewDecoder(base64.StdEncoding, strings.NewReader(t[1])))
+			_, err := io.Copy(io.Discard, base64.NewDecoder(base64.StdEncoding, strings.NewReader(data)))
 			xcheckuserf(ctx, err, "parsing attachment as base64")
 
-			xaddAttachmentBase64(ct, filename, []byte(t[1]))
+			xaddAttachmentBase64(ct, filename, []byte(data))
 		}
 
 		if len(m.ForwardAttachments.Paths) > 0 {
@@ -938,15 +1028,14 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 	if confDom.Disabled {
 		xcheckuserf(ctx, mox.ErrDomainDisabled, "checking domain")
 	}
-	selectors := mox.DKIMSelectors(confDom.DKIM)
-	if len(selectors) > 0 {
-		dkimHeaders, err := dkim.Sign(ctx, log.Logger, fromAddr.Address.Localpart, fd, selectors, smtputf8, dataFile)
-		if err != nil {
-			metricServerErrors.WithLabelValues("dkimsign").Inc()
-		}
-		xcheckf(ctx, err, "sign dkim")
-
-		msgPrefix = dkimHeaders
+	bimiHeader, selectors := mox.BIMISelectorHeader(confDom, mox.DKIMSelectors(confDom.DKIM))
+	dkimHeaders, err := mox.DKIMSignSubmission(ctx, log, confDom, fromAddr.Address, fromAddr.Address.Path(), selectors, smtputf8, store.FileMsgReader([]byte(bimiHeader), dataFile))
+	if err != nil {
+		metricServerErrors.WithLabelValues("dkimsign").Inc()
+	}
+	xcheckf(ctx, err, "sign dkim")
+	if dkimHeaders != "" {
+		msgPrefix = dkimHeaders + bimiHeader
 	}
 
 	accConf, _ := acc.Conf()
@@ -993,6 +1082,9 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 			// todo: possibly add a header to the message stored in the Sent mailbox to indicate it was scheduled for later delivery.
 		}
 		qm.FromID = fromID
+		qm.Priority = accConf.DeliveryPriority
+		qm.Hold = holdReason != ""
+		qm.HoldReason = holdReason
 		// no qm.Extra from webmail
 		qml[i] = qm
 	}
@@ -1178,6 +1270,47 @@ func (Webmail) MessageDelete(ctx context.Context, messageIDs []int64) {
 	xops.MessageDelete(ctx, log, acc, messageIDs)
 }
 
+// MessageShareCreate creates a link to share a message with others, valid for
+// the number of days (1-30). If raw is set, the link gives a download of the
+// message as .eml file, otherwise a plain text rendering. Links can be revoked from
+// the account web interface, where accesses can be inspected too. The returned
+// path is relative to the webmail URL.
+func (Webmail) MessageShareCreate(ctx context.Context, messageID int64, days int, raw bool) (path string) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+	log := reqInfo.Log
+
+	if days < 1 || time.Duration(days)*24*time.Hour > store.MessageShareValidityMax {
+		xcheckuserf(ctx, fmt.Errorf("days must be between 1 and %d", store.MessageShareValidityMax/(24*time.Hour)), "checking validity")
+	}
+
+	var m store.Message
+	err := acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+		m = xmessageID(ctx, tx, messageID)
+		return nil
+	})
+	xcheckf(ctx, err, "get message")
+	var subject string
+	var p message.Part
+	if err := json.Unmarshal(m.ParsedBuf, &p); err != nil {
+		log.Debugx("loading parsed message for subject of share link", err)
+	} else if p.Envelope != nil {
+		subject = p.Envelope.Subject
+	}
+
+	ms := store.MessageShare{
+		Account:   acc.Name,
+		MessageID: m.ID,
+		Subject:   subject,
+		Raw:       raw,
+		Expires:   time.Now().Add(time.Duration(days) * 24 * time.Hour),
+	}
+	err = store.MessageShareAdd(ctx, &ms)
+	xcheckuserf(ctx, err, "adding share link")
+	log.Info("share link created", slog.Int64("msgid", m.ID), slog.Int64("shareid", ms.ID), slog.Time("expires", ms.Expires))
+	return "share/" + ms.Token
+}
+
 // FlagsAdd adds flags, either system flags like \Seen or custom keywords. The
 // flags should be lower-case, but will be converted and verified.
 func (Webmail) FlagsAdd(ctx context.Context, messageIDs []int64, flaglist []string) {
@@ -2057,6 +2190,53 @@ func (Webmail) RulesetMailboxNever(ctx context.Context, mailboxID int64, toMailb
 	xcheckf(ctx, err, "storing user response")
 }
 
+// SenderAllowSuggest returns the From address and domain of a message that was
+// just moved out of the rejects mailbox mbSrcID, to offer adding a sender allow
+// entry. Empty strings are returned if no entry should be suggested, e.g. because
+// the account adds entries automatically, or an entry already exists.
+func (Webmail) SenderAllowSuggest(ctx context.Context, msgID, mbSrcID int64) (address, domain string) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	conf, _ := acc.Conf()
+	if conf.RejectsMailbox == "" || conf.RejectsRescueAllow != "" {
+		return
+	}
+
+	xdbread(ctx, acc, func(tx *bstore.Tx) {
+		m := xmessageID(ctx, tx, msgID)
+		mbSrc := xmailboxID(ctx, tx, mbSrcID)
+		if mbSrc.Name != conf.RejectsMailbox || m.MailboxID == mbSrc.ID || m.MsgFromDomain == "" {
+			return
+		}
+
+		allowed, err := store.SenderAllowed(tx, m.MsgFromLocalpart, m.MsgFromDomain)
+		xcheckf(ctx, err, "looking up sender allow entries")
+		if !allowed {
+			address = m.MsgFromLocalpart.String() + "@" + m.MsgFromDomain
+			domain = m.MsgFromDomain
+		}
+	})
+	return
+}
+
+// SenderAllowAdd adds a sender allow entry for the From address of a message, or
+// for its domain. Future messages from the sender with a verified From address are
+// accepted without junk filtering.
+func (Webmail) SenderAllowAdd(ctx context.Context, msgID int64, domain bool) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	xdbwrite(ctx, acc, func(tx *bstore.Tx) {
+		m := xmessageID(ctx, tx, msgID)
+		if m.MsgFromDomain == "" {
+			xcheckuserf(ctx, errors.New("message has no from address"), "adding sender allow entry")
+		}
+		_, err := store.SenderAllowAdd(tx, m, domain)
+		xcheckf(ctx, err, "adding sender allow entry")
+	})
+}
+
 func slicesAny[T any](l []T) []any {
 	r := make([]any, len(l))
 	for i, v := range l {
@@ -3,17 +3,21 @@ package webmail
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"runtime/debug"
 	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mjl-/bstore"
 	"github.com/mjl-/sherpa"
 
+	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
@@ -105,7 +109,7 @@ func TestAPI(t *testing.T) {
 	loginctx := context.WithValue(ctxbg, requestInfoCtxKey, loginReqInfo)
 
 	// Missing login token.
-	tneedErrorCode(t, "user:error", func() { api.Login(loginctx, "", "mjl@mox.example", pw0) })
+	tneedErrorCode(t, "user:error", func() { api.Login(loginctx, "", "mjl@mox.example", pw0, "") })
 
 	// Login with loginToken.
 	loginCookie := &http.Cookie{Name: "webmaillogin"}
@@ -130,7 +134,7 @@ func TestAPI(t *testing.T) {
 			}
 		}()
 
-		api.Login(loginctx, loginCookie.Value, username, password)
+		api.Login(loginctx, loginCookie.Value, username, password, "")
 	}
 	testLogin("mjl@mox.example", pw0)
 	testLogin("mjl@mox.example", pw1)
@@ -161,8 +165,8 @@ func TestAPI(t *testing.T) {
 	loginCookie2 := &http.Cookie{Name: "webmaillogin"}
 	loginCookie2.Value = api.LoginPrep(loginctx2)
 	loginReqInfo2.Request.Header = http.Header{"Cookie": []string{loginCookie2.String()}}
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(loginctx2, loginCookie2.Value, "disabled@mox.example", "test1234") })
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(loginctx2, loginCookie2.Value, "disabled@mox.example", "bogus") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(loginctx2, loginCookie2.Value, "disabled@mox.example", "test1234", "") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(loginctx2, loginCookie2.Value, "disabled@mox.example", "bogus", "") })
 
 	// Context with different IP, for clear rate limit history.
 	reqInfo := requestInfo{log, "mjl@mox.example", acc, "", nil, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
@@ -408,6 +412,54 @@ func TestAPI(t *testing.T) {
 	})
 	// todo: check forwarded flag, check it has the right attachments.
 
+	// Large attachments are replaced with links.
+	accConf := mox.Conf.Dynamic.Accounts["mjl"]
+	accConf.AttachmentLinks = &config.AttachmentLinks{MinSize: 10}
+	mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	api.MessageSubmit(ctx, SubmitMessage{
+		From:     "mjl@mox.example",
+		To:       []string{"mjl+to@mox.example"},
+		Subject:  "large attachment",
+		TextBody: "see attached",
+		Attachments: []File{
+			{
+				Filename: "small.png",
+				DataURI:  "data:image/png;base64,iVBO",
+			},
+			{
+				Filename: "large.png",
+				DataURI:  "data:image/png;base64,iVBORw0KGgoAAAANSUhEUg==",
+			},
+		},
+	})
+	accConf.AttachmentLinks = nil
+	mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	attLinks, err := bstore.QueryDB[store.AttachmentLink](ctxbg, store.AuthDB).List()
+	tcheck(t, err, "listing attachment links")
+	tcompare(t, len(attLinks), 1)
+	tcompare(t, attLinks[0].Filename, "large.png")
+	tcompare(t, attLinks[0].Size, int64(16))
+	sentMsg, err := bstore.QueryDB[store.Message](ctxbg, acc.DB).FilterNonzero(store.Message{MailboxID: sent.ID}).SortDesc("ID").Limit(1).Get()
+	tcheck(t, err, "get sent message")
+	sentMsgr := acc.MessageReader(sentMsg)
+	sentBuf, err := io.ReadAll(sentMsgr)
+	tcheck(t, err, "read sent message")
+	sentMsgr.Close()
+	if !strings.Contains(string(sentBuf), "https://mox.example/webmail/attachment/") || strings.Contains(string(sentBuf), `filename=large.png`) || !strings.Contains(string(sentBuf), `filename=small.png`) {
+		t.Fatalf("sent message does not have attachment link instead of attachment: %s", sentBuf)
+	}
+
+	// Expired links are removed by the periodic cleanup.
+	n, err := store.AttachmentLinkCleanup(ctxbg, pkglog)
+	tcheck(t, err, "attachment link cleanup")
+	tcompare(t, n, 0)
+	attLinks[0].Expires = time.Now().Add(-time.Minute)
+	err = store.AuthDB.Update(ctxbg, &attLinks[0])
+	tcheck(t, err, "expire attachment link")
+	n, err = store.AttachmentLinkCleanup(ctxbg, pkglog)
+	tcheck(t, err, "attachment link cleanup")
+	tcompare(t, n, 1)
+
 	// Send from utf8 localpart.
 	api.MessageSubmit(ctx, SubmitMessage{
 		From:     "møx@mox.example",
@@ -585,4 +637,23 @@ func TestAPI(t *testing.T) {
 	// For List-Id.
 	tdeliver(t, acc, inboxHTML)
 	testSuggest(inboxHTML.ID, "list.mox.example", "")
+
+	// Sender allow entries, suggested when moving out of the rejects mailbox.
+	address, _ := api.SenderAllowSuggest(ctx, inboxText.ID, testbox1.ID)
+	tcompare(t, address, "") // Not the rejects mailbox.
+	accConf = mox.Conf.Dynamic.Accounts[acc.Name]
+	accConf.RejectsMailbox = "Testbox1"
+	mox.Conf.Dynamic.Accounts[acc.Name] = accConf
+	address, domain := api.SenderAllowSuggest(ctx, inboxText.ID, testbox1.ID)
+	tcompare(t, address, "mjl@mox.example")
+	tcompare(t, domain, "mox.example")
+	api.SenderAllowAdd(ctx, inboxText.ID, true)
+	address, _ = api.SenderAllowSuggest(ctx, inboxText.ID, testbox1.ID)
+	tcompare(t, address, "") // Already allowed.
+	allows, err := bstore.QueryDB[store.SenderAllow](ctx, acc.DB).List()
+	tcheck(t, err, "list sender allow entries")
+	tcompare(t, len(allows), 1)
+	tcompare(t, allows[0].Address(), "mox.example")
+	accConf.RejectsMailbox = ""
+	mox.Conf.Dynamic.Accounts[acc.Name] = accConf
 }
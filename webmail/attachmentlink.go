@@ -0,0 +1,75 @@
+package webmail
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webauth"
+)
+
+// serveAttachmentLink serves a large attachment that was replaced by a link in an
+// outgoing message, /attachment/<token>. Like share links, the token is the only
+// authentication, and failed lookups count towards the rate limit of failed
+// authentication attempts.
+func serveAttachmentLink(ctx context.Context, log mlog.Log, isForwarded bool, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "405 - method not allowed - use get", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.URL.Path, "/attachment/")
+	if token == "" || strings.Contains(token, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	ip := webauth.ClientIP(log, isForwarded, r)
+	if ip == nil {
+		http.Error(w, "400 - bad request - cannot find ip (missing x-forwarded-for header?)", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if !mox.LimiterFailedAuth.CanAdd(ip, now, 1) {
+		http.Error(w, "429 - too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	al, f, err := store.AttachmentLinkUse(ctx, token)
+	if err != nil {
+		if errors.Is(err, store.ErrAttachmentLinkUnknown) {
+			mox.LimiterFailedAuth.Add(ip, now, 1)
+			http.NotFound(w, r)
+		} else {
+			log.Errorx("looking up attachment link", err)
+			http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer func() {
+		err := f.Close()
+		log.Check(err, "closing attachment file")
+	}()
+	log.Debug("attachment link accessed", slog.String("account", al.Account), slog.Int64("attachmentlinkid", al.ID), slog.Any("remoteip", ip))
+
+	// Always a download, the content must not be interpreted by the browser in our origin.
+	ct := al.ContentType
+	if _, _, err := mime.ParseMediaType(ct); err != nil {
+		ct = "application/octet-stream"
+	}
+	h := w.Header()
+	h.Set("Content-Type", ct)
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": al.Filename}))
+	h.Set("Content-Security-Policy", "sandbox; frame-ancestors 'none'; default-src 'none'")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("Cache-Control", "no-store, max-age=0")
+	http.ServeContent(w, r, "", al.Created, f)
+}
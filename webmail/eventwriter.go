@@ -75,7 +75,7 @@ func (ew *eventWriter) write(name string, v any) error {
 // function still returns immediately.
 func (ew *eventWriter) xsendEvent(ctx context.Context, log mlog.Log, name string, v any) {
 	if name != "fatalErr" {
-		if _, err := store.SessionUse(ctx, log, ew.accountName, ew.sessionToken, ""); err != nil {
+		if _, err := store.SessionUse(ctx, log, ew.accountName, ew.sessionToken, "", "", ""); err != nil {
 			ew.xsendEvent(ctx, log, "fatalErr", "session no longer valid")
 			return
 		}
@@ -0,0 +1,354 @@
+package webmail
+
+import (
+	"context"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxvar"
+)
+
+// The image proxy fetches remote images referenced in HTML messages on behalf of
+// the webmail user, so the remote server does not learn the IP address, browser
+// and cookies of the user, or exact time of viewing in case of repeated views of a
+// cached image. HTML messages shown in "htmlproxy" mode have the URLs of their
+// images rewritten to signed URLs of the proxy. The signature ensures the proxy
+// can only be used for images in messages shown to a logged in user, not as an
+// open proxy.
+
+var metricImageProxy = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_webmail_imageproxy_total",
+		Help: "Webmail image proxy requests, known results: cached, fetched, badsig, blocked, error.",
+	},
+	[]string{
+		"result",
+	},
+)
+
+const (
+	imageProxyMaxSize     = 5 * 1024 * 1024  // Max size of a single proxied image.
+	imageProxyCacheMax    = 64 * 1024 * 1024 // Max total size of cached images.
+	imageProxyCacheTTL    = time.Hour        // Time images are kept in cache.
+	imageProxyLinkTTL     = 24 * time.Hour   // Validity of signed proxy URLs.
+	imageProxyTimeout     = 15 * time.Second // Timeout for fetching a remote image.
+	imageProxyMaxRedirect = 5
+)
+
+// Key for signing proxy URLs. Generated on startup, URLs are only used while
+// viewing a message.
+var imageProxyKey = func() []byte {
+	buf := make([]byte, 32)
+	cryptorand.Read(buf)
+	return buf
+}()
+
+// For tests, to fetch from a local test server. Atomic, it is read by the dialer
+// from other goroutines.
+var imageProxyAllowPrivateIPs atomic.Bool
+
+func imageProxySign(rawURL string, expires int64) string {
+	mac := hmac.New(sha256.New, imageProxyKey)
+	fmt.Fprintf(mac, "%d\n%s", expires, rawURL)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:18])
+}
+
+// imageProxyPath returns the signed path to the image proxy, relative to the html
+// endpoint of a message ("msg/<id>/htmlproxy").
+func imageProxyPath(rawURL string) string {
+	expires := time.Now().Add(imageProxyLinkTTL).Unix()
+	q := url.Values{}
+	q.Set("u", rawURL)
+	q.Set("e", fmt.Sprintf("%d", expires))
+	q.Set("s", imageProxySign(rawURL, expires))
+	return "../../imageproxy?" + q.Encode()
+}
+
+// Attributes of elements that load images. We don't proxy CSS "url()" values.
+var imageProxyAttrs = map[string]map[string]bool{
+	"img":   {"src": true},
+	"input": {"src": true},
+	"video": {"poster": true},
+	"body":  {"background": true},
+	"table": {"background": true},
+	"td":    {"background": true},
+	"th":    {"background": true},
+}
+
+// proxyImagesNode rewrites http(s) image URLs to signed URLs of the image proxy,
+// and removes srcset attributes.
+func proxyImagesNode(node *html.Node) {
+	if node.Type == html.ElementNode {
+		attrs := imageProxyAttrs[node.Data]
+		i := 0
+		for i < len(node.Attr) {
+			a := node.Attr[i]
+			if a.Namespace == "" && a.Key == "srcset" {
+				copy(node.Attr[i:], node.Attr[i+1:])
+				node.Attr = node.Attr[:len(node.Attr)-1]
+				continue
+			}
+			if a.Namespace == "" && attrs[a.Key] {
+				if v := strings.TrimSpace(a.Val); caselessPrefix(v, "http://") || caselessPrefix(v, "https://") {
+					node.Attr[i].Val = imageProxyPath(v)
+				}
+			}
+			i++
+		}
+	}
+	for node = node.FirstChild; node != nil; node = node.NextSibling {
+		proxyImagesNode(node)
+	}
+}
+
+type imageProxyEntry struct {
+	contentType string
+	data        []byte
+	expires     time.Time
+}
+
+var imageProxyCache = struct {
+	sync.Mutex
+	entries map[string]imageProxyEntry
+	size    int64
+}{entries: map[string]imageProxyEntry{}}
+
+func imageProxyCacheGet(rawURL string) (imageProxyEntry, bool) {
+	imageProxyCache.Lock()
+	defer imageProxyCache.Unlock()
+	e, ok := imageProxyCache.entries[rawURL]
+	if ok && time.Now().After(e.expires) {
+		delete(imageProxyCache.entries, rawURL)
+		imageProxyCache.size -= int64(len(e.data))
+		return imageProxyEntry{}, false
+	}
+	return e, ok
+}
+
+func imageProxyCacheAdd(rawURL string, e imageProxyEntry) {
+	imageProxyCache.Lock()
+	defer imageProxyCache.Unlock()
+	if old, ok := imageProxyCache.entries[rawURL]; ok {
+		imageProxyCache.size -= int64(len(old.data))
+	}
+	// Make room, first removing expired entries, then arbitrary entries.
+	now := time.Now()
+	for k, x := range imageProxyCache.entries {
+		if imageProxyCache.size+int64(len(e.data)) <= imageProxyCacheMax {
+			break
+		}
+		if now.After(x.expires) {
+			delete(imageProxyCache.entries, k)
+			imageProxyCache.size -= int64(len(x.data))
+		}
+	}
+	for k, x := range imageProxyCache.entries {
+		if imageProxyCache.size+int64(len(e.data)) <= imageProxyCacheMax {
+			break
+		}
+		delete(imageProxyCache.entries, k)
+		imageProxyCache.size -= int64(len(x.data))
+	}
+	imageProxyCache.entries[rawURL] = e
+	imageProxyCache.size += int64(len(e.data))
+}
+
+var errImageProxyBlocked = errors.New("connecting to internal ip address not allowed")
+
+// Special-purpose networks that are not globally reachable, or embed IPv4
+// addresses that may not be. From the IANA IPv4 and IPv6 Special-Purpose Address
+// Registries. ../rfc/6890
+var imageProxyNonGlobalNets = func() (l []netip.Prefix) {
+	for _, s := range []string{
+		"0.0.0.0/8",       // "This network".
+		"10.0.0.0/8",      // Private. ../rfc/1918
+		"100.64.0.0/10",   // Shared address space, carrier-grade NAT. ../rfc/6598
+		"127.0.0.0/8",     // Loopback.
+		"169.254.0.0/16",  // Link-local.
+		"172.16.0.0/12",   // Private.
+		"192.0.0.0/24",    // IETF protocol assignments.
+		"192.0.2.0/24",    // Documentation, TEST-NET-1.
+		"192.88.99.0/24",  // Deprecated 6to4 relay anycast.
+		"192.168.0.0/16",  // Private.
+		"198.18.0.0/15",   // Benchmarking.
+		"198.51.100.0/24", // Documentation, TEST-NET-2.
+		"203.0.113.0/24",  // Documentation, TEST-NET-3.
+		"224.0.0.0/4",     // Multicast.
+		"240.0.0.0/4",     // Reserved, including limited broadcast.
+		"2001::/23",       // IETF protocol assignments, including Teredo.
+		"2001:db8::/32",   // Documentation.
+		"2002::/16",       // 6to4, embeds IPv4 address.
+		"3fff::/20",       // Documentation. ../rfc/9637
+	} {
+		l = append(l, netip.MustParsePrefix(s))
+	}
+	return l
+}()
+
+// IPv6 global unicast addresses. Others, like unique local (fc00::/7), link-local,
+// multicast and NAT64 (64:ff9b::/96) addresses, are not globally reachable or
+// embed IPv4 addresses that may not be.
+var imageProxyGlobalUnicast6 = netip.MustParsePrefix("2000::/3")
+
+// imageProxyGlobalIP returns whether ip is a globally reachable unicast address,
+// i.e. not an internal address the proxy must not connect to.
+func imageProxyGlobalIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.Is6() && !imageProxyGlobalUnicast6.Contains(ip) {
+		return false
+	}
+	for _, n := range imageProxyNonGlobalNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// imageProxyClient fetches images without cookies, and refuses to connect to
+// internal IPs, so the proxy cannot be used to reach internal services.
+var imageProxyClient = &http.Client{
+	Timeout: imageProxyTimeout,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: imageProxyTimeout,
+			Control: func(network, address string, c syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				ip, err := netip.ParseAddr(host)
+				if err != nil {
+					return fmt.Errorf("%w: bad ip %q", errImageProxyBlocked, host)
+				}
+				if !imageProxyAllowPrivateIPs.Load() && !imageProxyGlobalIP(ip) {
+					return errImageProxyBlocked
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   imageProxyTimeout,
+		ResponseHeaderTimeout: imageProxyTimeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= imageProxyMaxRedirect {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return errors.New("redirect to non-http url")
+		}
+		return nil
+	},
+}
+
+// serveImageProxy serves a remote image for a signed proxy URL, from cache if
+// possible. Only images are returned.
+func serveImageProxy(ctx context.Context, log mlog.Log, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "405 - method not allowed - use get", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	rawURL := q.Get("u")
+	expires, err := strconv.ParseInt(q.Get("e"), 10, 64)
+	if err != nil || time.Now().Unix() > expires || !hmac.Equal([]byte(q.Get("s")), []byte(imageProxySign(rawURL, expires))) {
+		metricImageProxy.WithLabelValues("badsig").Inc()
+		http.Error(w, "403 - forbidden - bad or expired signature", http.StatusForbidden)
+		return
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		metricImageProxy.WithLabelValues("error").Inc()
+		http.Error(w, "400 - bad request - bad url", http.StatusBadRequest)
+		return
+	}
+
+	e, ok := imageProxyCacheGet(rawURL)
+	if ok {
+		metricImageProxy.WithLabelValues("cached").Inc()
+	} else {
+		var result string
+		e, result, err = imageProxyFetch(ctx, u)
+		metricImageProxy.WithLabelValues(result).Inc()
+		if err != nil {
+			log.Debugx("fetching image for proxy", err, slog.String("url", rawURL))
+			http.Error(w, "502 - bad gateway - fetching image failed", http.StatusBadGateway)
+			return
+		}
+		imageProxyCacheAdd(rawURL, e)
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", e.contentType)
+	h.Set("Content-Length", fmt.Sprintf("%d", len(e.data)))
+	h.Set("Content-Security-Policy", "sandbox; default-src 'none'")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("Cache-Control", "private, max-age=3600")
+	_, err = w.Write(e.data)
+	log.Check(err, "writing proxied image")
+}
+
+// imageProxyFetch fetches an image. Request headers of the user are not passed
+// on, and response cookies are ignored.
+func imageProxyFetch(ctx context.Context, u *url.URL) (e imageProxyEntry, result string, rerr error) {
+	ctx, cancel := context.WithTimeout(ctx, imageProxyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return e, "error", err
+	}
+	req.Header.Set("User-Agent", "mox/"+moxvar.Version+" (image proxy; +"+mox.Conf.Static.HostnameDomain.ASCII+")")
+	req.Header.Set("Accept", "image/*")
+	resp, err := imageProxyClient.Do(req)
+	if err != nil {
+		if errors.Is(err, errImageProxyBlocked) {
+			return e, "blocked", err
+		}
+		return e, "error", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return e, "error", fmt.Errorf("http response status %d", resp.StatusCode)
+	}
+	// SVG images can have scripts, we don't pass them on.
+	ct := strings.ToLower(strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]))
+	if !strings.HasPrefix(ct, "image/") || strings.Contains(ct, "svg") {
+		return e, "blocked", fmt.Errorf("content-type %q is not an allowed image type", ct)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, imageProxyMaxSize+1))
+	if err != nil {
+		return e, "error", fmt.Errorf("reading image: %v", err)
+	}
+	if len(data) > imageProxyMaxSize {
+		return e, "blocked", fmt.Errorf("image larger than max size %d", imageProxyMaxSize)
+	}
+	return imageProxyEntry{ct, data, time.Now().Add(imageProxyCacheTTL)}, "fetched", nil
+}
@@ -0,0 +1,127 @@
+package webmail
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestImageProxy(t *testing.T) {
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/webmail/mox.conf")
+	mox.MustLoadConfig(true, false)
+
+	png := []byte("\x89PNG\r\n\x1a\nfake")
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.Header.Get("Cookie") != "" || r.Header.Get("Referer") != "" {
+			t.Errorf("request to remote server has cookie or referer header")
+		}
+		http.SetCookie(w, &http.Cookie{Name: "track", Value: "me"})
+		switch r.URL.Path {
+		case "/image.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+		case "/image.svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write([]byte("<svg/>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	// Rewriting of image URLs.
+	node, err := html.Parse(strings.NewReader(`<img src="` + srv.URL + `/image.png" srcset="x.png 2x"><img src="data:image/png;base64,AAAA"><a href="https://other.example">x</a>`))
+	tcheck(t, err, "parse html")
+	proxyImagesNode(node)
+	var b bytes.Buffer
+	err = html.Render(&b, node)
+	tcheck(t, err, "render html")
+	s := b.String()
+	if strings.Contains(s, "srcset") || !strings.Contains(s, `src="../../imageproxy?`) || !strings.Contains(s, "data:image/png") || !strings.Contains(s, `href="https://other.example"`) {
+		t.Fatalf("unexpected rewritten html: %s", s)
+	}
+
+	testProxy := func(rawURL string, sign bool, expCode int) *httptest.ResponseRecorder {
+		t.Helper()
+		p := strings.TrimPrefix(imageProxyPath(rawURL), "../..")
+		if !sign {
+			p = strings.Replace(p, "s=", "s=x", 1)
+		}
+		req := httptest.NewRequest("GET", p, nil)
+		req.Header.Set("Cookie", "webmailsession=secret")
+		rr := httptest.NewRecorder()
+		handle(nil, false, "", rr, req)
+		if rr.Code != expCode {
+			t.Fatalf("got status %d, expected %d (%s)", rr.Code, expCode, rr.Body.String())
+		}
+		return rr
+	}
+
+	// Internal IPs are blocked.
+	testProxy(srv.URL+"/image.png", true, http.StatusBadGateway)
+	tcompare(t, hits, 0)
+
+	imageProxyAllowPrivateIPs.Store(true)
+	defer imageProxyAllowPrivateIPs.Store(false)
+
+	testProxy(srv.URL+"/image.png", false, http.StatusForbidden)
+	rr := testProxy(srv.URL+"/image.png", true, http.StatusOK)
+	tcompare(t, rr.Body.Bytes(), png)
+	tcompare(t, rr.Header().Get("Content-Type"), "image/png")
+	tcompare(t, rr.Header().Get("Set-Cookie"), "")
+	tcompare(t, hits, 1)
+	// From cache.
+	testProxy(srv.URL+"/image.png", true, http.StatusOK)
+	tcompare(t, hits, 1)
+
+	testProxy(srv.URL+"/image.svg", true, http.StatusBadGateway)
+	testProxy(srv.URL+"/notfound.png", true, http.StatusBadGateway)
+	testProxy("file:///etc/passwd", true, http.StatusBadRequest)
+
+	// Expired signature.
+	q := url.Values{}
+	q.Set("u", srv.URL+"/image.png")
+	q.Set("e", "1")
+	q.Set("s", imageProxySign(srv.URL+"/image.png", 1))
+	req := httptest.NewRequest("GET", "/imageproxy?"+q.Encode(), nil)
+	rec := httptest.NewRecorder()
+	handle(nil, false, "", rec, req)
+	tcompare(t, rec.Code, http.StatusForbidden)
+}
+
+func TestImageProxyGlobalIP(t *testing.T) {
+	test := func(s string, exp bool) {
+		t.Helper()
+		tcompare(t, imageProxyGlobalIP(netip.MustParseAddr(s)), exp)
+	}
+	test("1.1.1.1", true)
+	test("192.0.0.1", false)
+	test("100.64.0.1", false)
+	test("198.18.0.1", false)
+	test("198.19.255.255", false)
+	test("10.1.2.3", false)
+	test("127.0.0.1", false)
+	test("169.254.169.254", false)
+	test("0.0.0.0", false)
+	test("255.255.255.255", false)
+	test("::ffff:10.0.0.1", false)
+	test("::ffff:1.1.1.1", true)
+	test("2a01:4f8::1", true)
+	test("::1", false)
+	test("fd00::1", false)
+	test("fe80::1", false)
+	test("64:ff9b::a00:1", false)
+	test("2002:a00:1::1", false)
+	test("2001:db8::1", false)
+}
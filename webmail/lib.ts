@@ -443,6 +443,46 @@ const addressList = (allAddrs: boolean, l: api.MessageAddress[]) => {
 // loadMsgheaderView loads the common message headers into msgheaderelem.
 // if refineKeyword is set, labels are shown and a click causes a call to
 // refineKeyword.
+// Short description and details of the signature of a message, as verified
+// during delivery.
+const signatureText = (m: api.Message): [string, string] => {
+	if (m.Signed === 'pgp') {
+		switch (m.SignatureResult) {
+		case 'pass':
+			return ['Valid PGP signature', 'PGP signature was verified during delivery, signed by '+m.SignatureSigner+' with a key from your keyring.']
+		case 'policy':
+			return ['PGP signature not for sender', 'PGP signature is valid, but the key of signer '+(m.SignatureSigner || '(unknown)')+' does not have a user ID for the From address.']
+		case 'fail':
+			return ['Bad PGP signature', 'PGP signature does not match the message, the message may have been modified.']
+		case 'neutral':
+			return ['Unverified PGP signature', 'Key of the signer is not in your OpenPGP keyring, the PGP signature could not be verified. Keys can be added to the keyring in the account settings.']
+		case 'permerror':
+			return ['Unverified PGP signature', 'PGP signature could not be verified, it is malformed or uses an unsupported algorithm.']
+		}
+		return ['PGP signature', 'Message has a PGP signature, it was not verified during delivery.']
+	}
+	switch (m.SignatureResult) {
+	case 'pass':
+		return ['Valid S/MIME signature', 'S/MIME signature was verified during delivery, signed by '+m.SignatureSigner+' with a trusted certificate.']
+	case 'policy':
+		return ['S/MIME signature not trusted', 'S/MIME signature is valid, but the certificate of signer '+m.SignatureSigner+' is not trusted, has expired, or is not for the From address.']
+	case 'fail':
+		return ['Bad S/MIME signature', 'S/MIME signature does not match the message, the message may have been modified.']
+	case 'permerror':
+		return ['Unverified S/MIME signature', 'S/MIME signature could not be verified, it is malformed or uses an unsupported algorithm.']
+	}
+	return ['Message has a signature', 'Signature was not verified during delivery.']
+}
+
+// Explanation of the junk filter classification during delivery, for a tooltip.
+const junkClassificationText = (jc: api.JunkClassification): string => {
+	const words = (l: api.JunkWord[] | null) => (l || []).map(w => w.Score.toFixed(3) + ' ' + w.Word).join('\n') || '(none)'
+	return 'Content classified as ' + (jc.Junk ? 'junk' : 'not junk') + ' by the junk filter during delivery.\n' +
+		'Spam probability ' + jc.Probability.toFixed(3) + ', threshold ' + jc.Threshold.toFixed(3) + (jc.Remark ? ' (' + jc.Remark + ')' : '') + '.\n' +
+		(jc.Significant ? '' : 'Not significant, too few known words to make a decision.\n') +
+		'\nSpam words:\n' + words(jc.Spams) + '\n\nHam words:\n' + words(jc.Hams)
+}
+
 const loadMsgheaderView = (msgheaderelem: HTMLTableSectionElement, mi: api.MessageItem, moreHeaders: string[], refineKeyword: null | ((kw: string) => Promise<void>), allAddrs: boolean) => {
 	const msgenv = mi.Envelope
 	const received = mi.Message.Received
@@ -492,8 +532,9 @@ const loadMsgheaderView = (msgheaderelem: HTMLTableSectionElement, mi: api.Messa
 						mi.Message.ReceivedTLSVersion === 1 ? dom.span(msgAttrStyle, css('msgAttrNoTLS', {borderBottom: '1.5px solid', borderBottomColor: styles.underlineRed}), 'Without TLS', attr.title('Message received (last hop) without TLS.')) : [],
 						mi.Message.ReceivedTLSVersion > 1 && !mi.Message.ReceivedRequireTLS ? dom.span(msgAttrStyle, css('msgAttrTLS', {borderBottom: '1.5px solid', borderBottomColor: styles.underlineGreen}), 'With TLS', attr.title('Message received (last hop) with TLS.')) : [],
 						mi.Message.ReceivedRequireTLS ? dom.span(css('msgAttrRequireTLS', {padding: '.1em .3em', fontSize: '.9em', backgroundColor: styles.successBackground, border: '1px solid', borderColor: styles.borderColor, borderRadius: '3px'}), 'With RequireTLS', attr.title('Transported with RequireTLS, ensuring TLS along the entire delivery path from sender to recipient, with TLS certificate verification through MTA-STS and/or DANE.')) : [],
-						mi.IsSigned ? dom.span(msgAttrStyle, css('msgAttrSigned', {backgroundColor: styles.colorMild, color: styles.backgroundColorMild, borderRadius: '.15em'}), 'Message has a signature') : [],
+						mi.IsSigned ? dom.span(msgAttrStyle, css('msgAttrSigned', {backgroundColor: styles.colorMild, color: styles.backgroundColorMild, borderRadius: '.15em'}), mi.Message.SignatureResult === 'fail' ? css('msgAttrSignedBad', {backgroundColor: styles.underlineRed}) : [], signatureText(mi.Message)[0], attr.title(signatureText(mi.Message)[1])) : [],
 						mi.IsEncrypted ? dom.span(msgAttrStyle, css('msgAttrEncrypted', {backgroundColor: styles.colorMild, color: styles.backgroundColorMild, borderRadius: '.15em'}), 'Message is encrypted') : [],
+						mi.Message.JunkClassification && (mi.Message.Junk || mi.Message.JunkClassification.Junk) ? dom.span(msgAttrStyle, css('msgAttrJunk', {borderBottom: '1.5px solid', borderBottomColor: styles.underlineRed}), 'Junk score ' + mi.Message.JunkClassification.Probability.toFixed(2), attr.title(junkClassificationText(mi.Message.JunkClassification))) : [],
 						refineKeyword ? (mi.Message.Keywords || []).map(kw =>
 							dom.clickbutton(styleClasses.keyword, dom._class('keywordButton'), kw, async function click() {
 								await refineKeyword(kw)
@@ -32,6 +32,8 @@ const init = () => {
 		iframepath = 'text'
 	} else if (w === 'msghtml') {
 		iframepath = 'html'
+	} else if (w === 'msghtmlproxy') {
+		iframepath = 'htmlproxy'
 	} else if (w === 'msghtmlexternal') {
 		iframepath = 'htmlexternal'
 	} else {
@@ -0,0 +1,165 @@
+package webmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webauth"
+)
+
+// serveShare serves a message through a share link, /share/<token>. The token is
+// the only authentication. Failed lookups count as failed authentication attempts
+// for rate limiting. Each use is logged with the share link, for the account owner
+// to inspect.
+func serveShare(ctx context.Context, log mlog.Log, isForwarded bool, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "405 - method not allowed - use get", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.URL.Path, "/share/")
+	if token == "" || strings.Contains(token, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	ip := webauth.ClientIP(log, isForwarded, r)
+	if ip == nil {
+		http.Error(w, "400 - bad request - cannot find ip (missing x-forwarded-for header?)", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if !mox.LimiterFailedAuth.CanAdd(ip, now, 1) {
+		http.Error(w, "429 - too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	ms, err := store.MessageShareUse(ctx, token, ip.String(), r.UserAgent())
+	if err != nil {
+		if errors.Is(err, store.ErrMessageShareUnknown) {
+			mox.LimiterFailedAuth.Add(ip, now, 1)
+			log.Debug("share link unknown or expired", slog.Any("remoteip", ip))
+			http.NotFound(w, r)
+		} else {
+			log.Errorx("looking up share link", err)
+			http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	log = log.With(slog.String("account", ms.Account), slog.Int64("msgid", ms.MessageID), slog.Int64("shareid", ms.ID))
+	log.Info("share link accessed", slog.Any("remoteip", ip), slog.Bool("raw", ms.Raw))
+
+	acc, err := store.OpenAccount(log, ms.Account, false)
+	if err != nil {
+		log.Errorx("open account for share link", err)
+		http.NotFound(w, r)
+		return
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	m := store.Message{ID: ms.MessageID}
+	err = acc.DB.Get(ctx, &m)
+	if err == bstore.ErrAbsent || err == nil && m.Expunged {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Errorx("get message for share link", err)
+		http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	state := msgState{acc: acc, log: log}
+	defer state.clear()
+	pm, err := parsedMessage(log, &m, &state, true, false, false)
+	if err != nil {
+		log.Errorx("parsing message for share link", err)
+		http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Strict headers, we never want to have the browser interpret the message.
+	h := w.Header()
+	h.Set("Content-Security-Policy", "sandbox; frame-ancestors 'none'; default-src 'none'")
+	h.Set("X-Frame-Options", "deny")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("Cache-Control", "no-store, max-age=0")
+
+	if ms.Raw {
+		ct := "message/rfc822"
+		params := map[string]string{}
+		if smtputf8, err := pm.Part.NeedsSMTPUTF8(); err != nil {
+			log.Errorx("checking for smtputf8 for content-type", err)
+			http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+			return
+		} else if smtputf8 {
+			ct = "message/global"
+			params["charset"] = "utf-8"
+		}
+		h.Set("Content-Type", mime.FormatMediaType(ct, params))
+		filename := fmt.Sprintf("email-%s.eml", m.Received.Format("20060102-150405"))
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		_, err := io.Copy(w, &moxio.AtReader{R: state.msgr})
+		log.Check(err, "writing raw message for share link")
+		return
+	}
+
+	formatAddrs := func(l []message.Address) string {
+		var r []string
+		for _, a := range l {
+			s := a.User + "@" + a.Host
+			if a.Name != "" {
+				s = fmt.Sprintf("%s <%s>", a.Name, s)
+			}
+			r = append(r, s)
+		}
+		return strings.Join(r, ", ")
+	}
+
+	var b strings.Builder
+	if env := pm.Part.Envelope; env != nil {
+		hdr := func(k, v string) {
+			if v != "" {
+				fmt.Fprintf(&b, "%s: %s\n", k, v)
+			}
+		}
+		hdr("From", formatAddrs(env.From))
+		hdr("To", formatAddrs(env.To))
+		hdr("Cc", formatAddrs(env.CC))
+		if !env.Date.IsZero() {
+			hdr("Date", env.Date.Format(message.RFC5322Z))
+		}
+		hdr("Subject", env.Subject)
+		b.WriteString("\n")
+	}
+	for i, text := range pm.Texts {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString(text)
+	}
+	if len(pm.Texts) == 0 {
+		b.WriteString("(message has no text content, not shown)\n")
+	}
+
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	_, err = io.WriteString(w, b.String())
+	log.Check(err, "writing message for share link")
+}
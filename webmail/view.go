@@ -535,7 +535,7 @@ func serveEvents(ctx context.Context, log mlog.Log, accountPath string, w http.R
 		http.Error(w, "400 - bad request - bad token", http.StatusBadRequest)
 		return
 	}
-	if _, err := store.SessionUse(ctx, log, accName, sessionToken, ""); err != nil {
+	if _, err := store.SessionUse(ctx, log, accName, sessionToken, "", "", ""); err != nil {
 		http.Error(w, "400 - bad request - bad session token", http.StatusBadRequest)
 		return
 	}
@@ -59,7 +59,7 @@ func TestView(t *testing.T) {
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
 
-	api.Login(ctx, loginCookie.Value, "mjl@mox.example", "test1234")
+	api.Login(ctx, loginCookie.Value, "mjl@mox.example", "test1234", "")
 	var sessionCookie *http.Cookie
 	for _, c := range respRec.Result().Cookies() {
 		if c.Name == "webmailsession" {
@@ -85,7 +85,7 @@ var (
 	metricSubmission = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "mox_webmail_submission_total",
-			Help: "Webmail message submission results, known values (those ending with error are server errors): ok, badfrom, messagelimiterror, recipientlimiterror, queueerror, storesenterror, domaindisabled.",
+			Help: "Webmail message submission results, known values (those ending with error are server errors): ok, badfrom, messagelimiterror, recipientlimiterror, holdforreview, queueerror, storesenterror, domaindisabled, disabled.",
 		},
 		[]string{
 			"result",
@@ -275,6 +275,17 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 		}
 		return
 
+	case "/api/sherpadoc.json":
+		// API documentation, for generating clients, e.g. with "mox genapi".
+		switch r.Method {
+		case "GET", "HEAD":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Write(webmailapiJSON)
+		default:
+			http.Error(w, "405 - method not allowed - use get", http.StatusMethodNotAllowed)
+		}
+		return
+
 	case "/licenses.txt":
 		switch r.Method {
 		case "GET", "HEAD":
@@ -305,6 +316,24 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 		return
 	}
 
+	// Share links for messages are authenticated through the token in the URL only.
+	if strings.HasPrefix(r.URL.Path, "/share/") {
+		serveShare(ctx, log, isForwarded, w, r)
+		return
+	}
+	// The image proxy is authenticated through the signature in the URL.
+	if r.URL.Path == "/imageproxy" {
+		serveImageProxy(ctx, log, w, r)
+		return
+	}
+
+	// Links to large attachments, replaced in outgoing messages, also only need the
+	// token in the URL.
+	if strings.HasPrefix(r.URL.Path, "/attachment/") {
+		serveAttachmentLink(ctx, log, isForwarded, w, r)
+		return
+	}
+
 	isAPI := strings.HasPrefix(r.URL.Path, "/api/")
 	// Only allow POST for calls, they will not work cross-domain without CORS.
 	if isAPI && r.URL.Path != "/api/" && r.Method != "POST" {
@@ -351,7 +380,7 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 	// We are now expecting the following URLs:
 	// .../export
 	// .../msg/<msgid>/{attachments.zip,parsedmessage.js,raw}
-	// .../msg/<msgid>/{,msg}{text,html,htmlexternal}
+	// .../msg/<msgid>/{,msg}{text,html,htmlproxy,htmlexternal}
 	// .../msg/<msgid>/{view,viewtext,download}/<partid>
 
 	if r.URL.Path == "/export" {
@@ -659,7 +688,7 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 		_, err := io.Copy(w, &moxio.AtReader{R: msgr})
 		log.Check(err, "writing raw")
 
-	case len(t) == 2 && (t[1] == "msgtext" || t[1] == "msghtml" || t[1] == "msghtmlexternal"):
+	case len(t) == 2 && (t[1] == "msgtext" || t[1] == "msghtml" || t[1] == "msghtmlproxy" || t[1] == "msghtmlexternal"):
 		// msg.html has a javascript tag with message data, and javascript to render the
 		// message header like the regular webmail.html and to load the message body in a
 		// separate iframe with a separate request with stronger CSP.
@@ -759,9 +788,10 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 		fallback := webmailtextHTML
 		serveContentFallback(log, w, r, path, fallback, true)
 
-	case len(t) == 2 && (t[1] == "html" || t[1] == "htmlexternal"):
+	case len(t) == 2 && (t[1] == "html" || t[1] == "htmlproxy" || t[1] == "htmlexternal"):
 		// Returns the first HTML part, with "cid:" URIs replaced with an inlined datauri
-		// if the referenced Content-ID attachment can be found.
+		// if the referenced Content-ID attachment can be found. For "htmlproxy", remote
+		// images are loaded through the image proxy.
 		_, _, _, _, p, cleanup, ok := xprepare()
 		if !ok {
 			return
@@ -775,7 +805,8 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 			// inner height so we load it as different origin, which should be safer.
 			sameorigin := r.URL.Query().Get("sameorigin") == "true"
 			allowExternal := strings.HasSuffix(t[1], "external")
-			headers(sameorigin, allowExternal, false, false)
+			allowSelfImg := t[1] == "htmlproxy"
+			headers(sameorigin, allowExternal, false, allowSelfImg)
 
 			h.Set("Content-Type", "text/html; charset=utf-8")
 			h.Set("Cache-Control", "no-store, max-age=0")
@@ -792,7 +823,7 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 			switch mt {
 			case "TEXT/HTML":
 				done = true
-				err := inlineSanitizeHTML(log, setHeaders, w, p, parents)
+				err := inlineSanitizeHTML(log, setHeaders, w, p, parents, t[1] == "htmlproxy")
 				if err != nil {
 					http.Error(w, "400 - bad request - "+err.Error(), http.StatusBadRequest)
 				}
@@ -873,8 +904,9 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 // scripts. If the HTML becomes too large, an error is returned. Before writing
 // HTML, setHeaders is called to write the required headers for content-type and
 // CSP. On error, setHeader is not called, no output is written and the caller
-// should write an error response.
-func inlineSanitizeHTML(log mlog.Log, setHeaders func(), w io.Writer, p *message.Part, parents []*message.Part) error {
+// should write an error response. If proxyImages is set, remote images are
+// rewritten to URLs of the image proxy.
+func inlineSanitizeHTML(log mlog.Log, setHeaders func(), w io.Writer, p *message.Part, parents []*message.Part, proxyImages bool) error {
 	node, err := html.Parse(p.ReaderUTF8OrBinary())
 	if err != nil {
 		return fmt.Errorf("parsing html: %v", err)
@@ -886,6 +918,9 @@ func inlineSanitizeHTML(log mlog.Log, setHeaders func(), w io.Writer, p *message
 		return fmt.Errorf("inline cid uris in html nodes: %w", err)
 	}
 	sanitizeNode(node)
+	if proxyImages {
+		proxyImagesNode(node)
+	}
 	setHeaders()
 	err = html.Render(w, node)
 	log.Check(err, "writing html")
@@ -257,6 +257,8 @@ const login = async (reason: string) => {
 		let autosize: HTMLElement
 		let username: HTMLInputElement
 		let password: HTMLInputElement
+		let totpLabel: HTMLElement
+		let totp: HTMLInputElement
 		const root = dom.div(
 			css('loginOverlay', {position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: styles.overlayOpaqueBackgroundColor, display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: zindexes.login, animation: 'fadein .15s ease-in'}),
 			dom.div(
@@ -286,7 +288,7 @@ const login = async (reason: string) => {
 							try {
 								fieldset.disabled = true
 								const loginToken = await client.LoginPrep()
-								const token = await client.Login(loginToken, username.value, password.value)
+								const token = await client.Login(loginToken, username.value, password.value, totp.value)
 								try {
 									window.localStorage.setItem('webmailcsrftoken', token)
 								} catch (err) {
@@ -300,6 +302,14 @@ const login = async (reason: string) => {
 								resolve(token)
 							} catch (err) {
 								console.log('login error', err)
+								if ((err as any).code === 'user:totpRequired') {
+									// Password was correct, ask for two-factor authentication code and retry.
+									totpLabel.style.display = 'block'
+									totp.required = true
+									fieldset.disabled = false
+									totp.focus()
+									return
+								}
 								window.alert('Error: ' + errmsg(err))
 							} finally {
 								fieldset.disabled = false
@@ -325,6 +335,11 @@ const login = async (reason: string) => {
 								dom.div('Password', style({marginBottom: '.5ex'})),
 								password=dom.input(attr.type('password'), attr.autocomplete('current-password'), attr.required('')),
 							),
+							totpLabel=dom.label(
+								style({display: 'none', marginBottom: '2ex'}),
+								dom.div('Two-factor authentication code', style({marginBottom: '.5ex'})),
+								totp=dom.input(attr.autocomplete('one-time-code'), attr.title('Code from authenticator app, or a recovery code.')),
+							),
 							dom.div(
 								style({textAlign: 'center'}),
 								dom.submitbutton('Login'),
@@ -2315,6 +2330,11 @@ const movePopover = (e: MouseEvent, mailboxes: api.Mailbox[], msgs: api.Message[
 // otherwise we make a rule based on message "from" address.
 const moveAskRuleset = async (msgID: number, mbSrcID: number, mbDst: api.Mailbox, mailboxes: api.Mailbox[]) => {
 	const mbSrc = mailboxes.find(mb => mb.ID === mbSrcID)
+	if (mbSrc && mbSrc.Name === rejectsMailbox) {
+		// Rescued from the rejects mailbox, offer to allow the sender instead.
+		await moveAskSenderAllow(msgID, mbSrc.ID)
+		return
+	}
 	if (!mbSrc || isSpecialUse(mbDst) || isSpecialUse(mbSrc)) {
 		return
 	}
@@ -2393,6 +2413,37 @@ const moveAskRuleset = async (msgID: number, mbSrcID: number, mbDst: api.Mailbox
 	)
 }
 
+// A message was moved out of the rejects mailbox. Unless the account adds sender
+// allow entries automatically, or there already is one, ask the user to allow the
+// sender address or domain, so future messages are accepted.
+const moveAskSenderAllow = async (msgID: number, mbSrcID: number) => {
+	const [address, domain] = await withStatus('Checking sender allow entries', client.SenderAllowSuggest(msgID, mbSrcID))
+	if (!address) {
+		return
+	}
+	const remove = popup(
+		dom.h1('Allow sender?'),
+		dom.p(
+			style({maxWidth: '30em'}),
+			'Would you like to always accept future messages from address "', address, '", or from all addresses of domain "', domain, '"? Only messages with a verified From address (through SPF and/or DKIM) are accepted this way, without junk filtering.',
+		),
+		dom.br(),
+		dom.div(
+			dom.clickbutton('Allow address', async function click() {
+				await withStatus('Adding sender allow entry', client.SenderAllowAdd(msgID, false))
+				remove()
+			}), ' ',
+			dom.clickbutton('Allow domain', async function click() {
+				await withStatus('Adding sender allow entry', client.SenderAllowAdd(msgID, true))
+				remove()
+			}), ' ',
+			dom.clickbutton('Not now', async function click() {
+				remove()
+			}),
+		),
+	)
+}
+
 const isSpecialUse = (mb: api.Mailbox) => mb.Archive || mb.Draft || mb.Junk || mb.Sent || mb.Trash
 
 // MsgitemView is a message-line in the list of messages. Selecting it loads and displays the message, a MsgView.
@@ -3075,7 +3126,7 @@ const newMsgView = (miv: MsgitemView, msglistView: MsglistView, listMailboxes: l
 		let path: number[] | null = null
 		if (urlType === 'text' && pm.TextPaths && pm.TextPaths.length > 0) {
 			path = pm.TextPaths[0]
-		} else if ((urlType === 'html' || urlType === 'htmlexternal') && pm.HTMLPath) {
+		} else if ((urlType === 'html' || urlType === 'htmlproxy' || urlType === 'htmlexternal') && pm.HTMLPath) {
 			path = pm.HTMLPath
 		}
 		if (!path) {
@@ -3127,9 +3178,9 @@ const newMsgView = (miv: MsgitemView, msglistView: MsglistView, listMailboxes: l
 		loadHeaderDetails(pm)
 	}
 
-	let textbtn: HTMLButtonElement, htmlbtn: HTMLButtonElement, htmlextbtn: HTMLButtonElement
+	let textbtn: HTMLButtonElement, htmlbtn: HTMLButtonElement, htmlproxybtn: HTMLButtonElement, htmlextbtn: HTMLButtonElement
 	const activeBtn = (b: HTMLButtonElement) => {
-		for (const xb of [textbtn, htmlbtn, htmlextbtn]) {
+		for (const xb of [textbtn, htmlbtn, htmlproxybtn, htmlextbtn]) {
 			if (xb) {
 				xb.classList.toggle('active', xb === b)
 			}
@@ -3159,6 +3210,14 @@ const newMsgView = (miv: MsgitemView, msglistView: MsglistView, listMailboxes: l
 		activeBtn(htmlbtn)
 		await fromAddressSettingsSave(api.ViewMode.ModeHTML)
 	}
+	const cmdShowHTMLProxy = async () => {
+		if (!htmlbtn || !htmlproxybtn) {
+			return
+		}
+		loadHTMLproxy()
+		activeBtn(htmlproxybtn)
+		await fromAddressSettingsSave(api.ViewMode.ModeHTMLProxy)
+	}
 	const cmdShowHTMLExternal = async () => {
 		if (!htmlbtn || !htmlextbtn) {
 			return
@@ -3169,6 +3228,8 @@ const newMsgView = (miv: MsgitemView, msglistView: MsglistView, listMailboxes: l
 	}
 	const cmdShowHTMLCycle = async () => {
 		if (urlType === 'html') {
+			await cmdShowHTMLProxy()
+		} else if (urlType === 'htmlproxy') {
 			await cmdShowHTMLExternal()
 		} else {
 			await cmdShowHTML()
@@ -3228,7 +3289,7 @@ const newMsgView = (miv: MsgitemView, msglistView: MsglistView, listMailboxes: l
 		M: msglistView.cmdMarkUnread,
 	}
 
-	let urlType: string // text, html, htmlexternal; for opening in new tab/print
+	let urlType: string // text, html, htmlproxy, htmlexternal; for opening in new tab/print
 
 	let msgbuttonElem: HTMLElement, msgheaderElem: HTMLTableSectionElement, msgattachmentElem: HTMLElement, msgmodeElem: HTMLElement
 	let msgheaderFullElem: HTMLTableElement // Full headers, when enabled.
@@ -3301,6 +3362,9 @@ const newMsgView = (miv: MsgitemView, msglistView: MsglistView, listMailboxes: l
 								dom.clickbutton('Export as ...', function click(e: {target: HTMLElement}) {
 									popoverExport(e.target, '', [m.ID])
 								}),
+								dom.clickbutton('Share link ...', attr.title('Create a link to this message that anyone can open without logging in, until it expires. Links can be revoked in the account settings.'), function click(e: {target: HTMLElement}) {
+									popoverShare(e.target, m.ID)
+								}),
 								dom.clickbutton('Show raw original message in new tab', clickCmd(cmdOpenRaw, shortcuts)),
 								dom.clickbutton('Show currently displayed part as decoded text', clickCmd(cmdOpenRawPart, shortcuts)),
 								dom.clickbutton('Show internals in popup', clickCmd(cmdShowInternals, shortcuts)),
@@ -3553,6 +3617,18 @@ const newMsgView = (miv: MsgitemView, msglistView: MsglistView, listMailboxes: l
 		)
 		renderAttachments() // Rerender opaciy on inline images.
 	}
+	const loadHTMLproxy = (): void => {
+		urlType = 'htmlproxy'
+		dom._kids(msgcontentElem,
+			dom.iframe(
+				attr.tabindex('0'),
+				attr.title('HTML version of message with images inlined, and remote images loaded through the mail server, without revealing your IP address to the sender.'),
+				attr.src('msg/'+m.ID+'/'+urlType),
+				css('msgIframeHTML', {position: 'absolute', width: '100%', height: '100%'}),
+			)
+		)
+		renderAttachments() // Rerender opaciy on inline images.
+	}
 	const loadHTMLexternal = (): void => {
 		urlType = 'htmlexternal'
 		dom._kids(msgcontentElem,
@@ -3625,11 +3701,15 @@ const newMsgView = (miv: MsgitemView, msglistView: MsglistView, listMailboxes: l
 					!haveText ? dom.span('HTML-only message', attr.title(htmlNote), msgModeWarningStyle, style({marginRight: '.25em'})) : [],
 					dom.span(dom._class('btngroup'),
 						haveText ? textbtn=dom.clickbutton(text ? dom._class('active') : [], 'Text', clickCmd(cmdShowText, shortcuts)) : [],
-						htmlbtn=dom.clickbutton(text || !text && pm.ViewMode == api.ViewMode.ModeHTMLExt ? [] : dom._class('active'), 'HTML', attr.title(htmlNote), async function click() {
-							// Shortcuts has a function that cycles through html and htmlexternal.
+						htmlbtn=dom.clickbutton(text || !text && (pm.ViewMode == api.ViewMode.ModeHTMLExt || pm.ViewMode == api.ViewMode.ModeHTMLProxy) ? [] : dom._class('active'), 'HTML', attr.title(htmlNote), async function click() {
+							// Shortcuts has a function that cycles through html, htmlproxy and htmlexternal.
 							showShortcut('T')
 							await cmdShowHTML()
 						}),
+						htmlproxybtn=dom.clickbutton(text || !text && pm.ViewMode != api.ViewMode.ModeHTMLProxy ? [] : dom._class('active'), 'HTML with proxied images', attr.title('Remote images are loaded through the mail server, so the sender does not learn your IP address, and cannot use cookies to track you. Other external resources are not loaded. '+htmlNote), async function click() {
+							showShortcut('T')
+							await cmdShowHTMLProxy()
+						}),
 						htmlextbtn=dom.clickbutton(text || !text && pm.ViewMode != api.ViewMode.ModeHTMLExt ? [] : dom._class('active'), 'HTML with external resources', attr.title(htmlNote), clickCmd(cmdShowHTMLExternal, shortcuts)),
 					),
 				)
@@ -3638,6 +3718,8 @@ const newMsgView = (miv: MsgitemView, msglistView: MsglistView, listMailboxes: l
 				loadText(pm)
 			} else if (pm.ViewMode == api.ViewMode.ModeHTMLExt) {
 				loadHTMLexternal()
+			} else if (pm.ViewMode == api.ViewMode.ModeHTMLProxy) {
+				loadHTMLproxy()
 			} else {
 				loadHTML()
 			}
@@ -5190,6 +5272,39 @@ const popoverExport = (reference: HTMLElement, mailboxName: string, messageIDs:
 	)
 }
 
+const popoverShare = (reference: HTMLElement, messageID: number) => {
+	let fieldset: HTMLFieldSetElement
+	let days: HTMLInputElement
+	let raw: HTMLInputElement
+	let result: HTMLElement
+	popover(reference, {},
+		dom.h1('Share link'),
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+				const path = await withStatus('Creating share link', client.MessageShareCreate(messageID, parseInt(days.value), raw.checked), fieldset)
+				const url = new URL(path, window.location.href).toString()
+				let urlInput: HTMLInputElement
+				dom._kids(result,
+					dom.div('Anyone with this link can view the message. Revoke it in the account settings.'),
+					urlInput=dom.input(attr.value(url), style({width: '100%'})),
+				)
+				urlInput.readOnly = true
+				urlInput.select()
+			},
+			fieldset=dom.fieldset(
+				dom.div(css('shareFields', {display: 'flex', flexDirection: 'column', gap: '.5ex'}),
+					dom.label('Valid for days ', days=dom.input(attr.type('number'), attr.min('1'), attr.max('30'), attr.value('7'), attr.required(''), style({width: '4em'}))),
+					dom.label(raw=dom.input(attr.type('checkbox')), ' Raw message (.eml download) instead of text'),
+					dom.div(dom.submitbutton('Create link')),
+				),
+			),
+		),
+		result=dom.div(style({marginTop: '1ex', maxWidth: '30em'})),
+	)
+}
+
 const newMailboxView = (xmb: api.Mailbox, mailboxlistView: MailboxlistView, otherMailbox: otherMailbox): MailboxView => {
 	const plusbox = '⊞'
 	const minusbox = '⊟'
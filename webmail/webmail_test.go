@@ -340,7 +340,7 @@ func TestWebmail(t *testing.T) {
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
 
-	csrfToken := api.Login(ctx, loginCookie.Value, "mjl@mox.example", "test1234")
+	csrfToken := api.Login(ctx, loginCookie.Value, "mjl@mox.example", "test1234", "")
 	var sessionCookie *http.Cookie
 	for _, c := range respRec.Result().Cookies() {
 		if c.Name == "webmailsession" {
@@ -637,6 +637,11 @@ func TestWebmail(t *testing.T) {
 		"Content-Security-Policy",
 		"sandbox allow-popups allow-popups-to-escape-sandbox; frame-ancestors 'self'; default-src 'none'; img-src data:; style-src 'unsafe-inline'",
 	}
+	// HTML with remote images through the image proxy.
+	cspHTMLProxy := [2]string{
+		"Content-Security-Policy",
+		"sandbox allow-popups allow-popups-to-escape-sandbox; frame-ancestors 'self'; default-src 'none'; img-src data: 'self'; style-src 'unsafe-inline'",
+	}
 	// HTML when in separate message tab, needs allow-same-origin for iframe inner height.
 	cspHTMLSameOrigin := [2]string{
 		"Content-Security-Policy",
@@ -664,7 +669,9 @@ func TestWebmail(t *testing.T) {
 	}
 	testHTTPAuthREST("GET", pathInboxAltRel+"/text", http.StatusOK, httpHeaders{ctHTML, cspTextImg}, nil)
 	testHTTPAuthREST("GET", pathInboxAltRel+"/html", http.StatusOK, httpHeaders{ctHTML, cspHTML}, nil)
+	testHTTPAuthREST("GET", pathInboxAltRel+"/htmlproxy", http.StatusOK, httpHeaders{ctHTML, cspHTMLProxy}, nil)
 	testHTTPAuthREST("GET", pathInboxAltRel+"/htmlexternal", http.StatusOK, httpHeaders{ctHTML, cspHTMLExternal}, nil)
+	testHTTPAuthREST("GET", pathInboxAltRel+"/msghtmlproxy", http.StatusOK, httpHeaders{ctHTML, cspMsgHTML}, nil)
 	testHTTPAuthREST("GET", pathInboxAltRel+"/msgtext", http.StatusOK, httpHeaders{ctHTML, cspText}, nil)
 	testHTTPAuthREST("GET", pathInboxAltRel+"/msghtml", http.StatusOK, httpHeaders{ctHTML, cspMsgHTML}, nil)
 	testHTTPAuthREST("GET", pathInboxAltRel+"/msghtmlexternal", http.StatusOK, httpHeaders{ctHTML, cspMsgHTMLExternal}, nil)
@@ -673,7 +680,7 @@ func TestWebmail(t *testing.T) {
 	testHTTPAuthREST("GET", pathInboxAltRel+"/htmlexternal?sameorigin=true", http.StatusOK, httpHeaders{ctHTML, cspHTMLExternalSameOrigin}, nil)
 
 	// No HTML part.
-	for _, elem := range []string{"html", "htmlexternal", "msghtml", "msghtmlexternal"} {
+	for _, elem := range []string{"html", "htmlproxy", "htmlexternal", "msghtml", "msghtmlproxy", "msghtmlexternal"} {
 		testHTTPAuthREST("GET", pathInboxText+"/"+elem, http.StatusBadRequest, nil, nil)
 
 	}
@@ -694,6 +701,53 @@ func TestWebmail(t *testing.T) {
 		testHTTPAuthREST("GET", pathInboxAltRel+"/"+elem+"/1", http.StatusNotFound, nil, nil)
 	}
 
+	// Share links.
+	tneedError(t, func() { api.MessageShareCreate(ctx, inboxText.ID, 0, false) })  // Too short.
+	tneedError(t, func() { api.MessageShareCreate(ctx, inboxText.ID, 31, false) }) // Too long.
+	tneedError(t, func() { api.MessageShareCreate(ctx, 999, 1, false) })           // Unknown message.
+	sharePath := api.MessageShareCreate(ctx, inboxText.ID, 1, false)
+	shareRawPath := api.MessageShareCreate(ctx, inboxText.ID, 1, true)
+	if !strings.HasPrefix(sharePath, "share/") {
+		t.Fatalf("got share path %q, expected share/ prefix", sharePath)
+	}
+	ctDownload := [2]string{"Content-Disposition", fmt.Sprintf(`attachment; filename=email-%s.eml`, inboxText.m.Received.Format("20060102-150405"))}
+	testHTTP("GET", "/"+sharePath, nil, http.StatusOK, httpHeaders{ctText}, func(resp *http.Response) {
+		buf, err := io.ReadAll(resp.Body)
+		tcheck(t, err, "read body")
+		if !strings.Contains(string(buf), "Subject: text message") {
+			t.Fatalf("missing subject in shared message: %q", buf)
+		}
+	})
+	testHTTP("POST", "/"+sharePath, nil, http.StatusMethodNotAllowed, nil, nil)
+	testHTTP("GET", "/"+shareRawPath, nil, http.StatusOK, httpHeaders{ctMessageGlobal, ctDownload}, nil)
+	testHTTP("GET", "/share/bogus", nil, http.StatusNotFound, nil, nil)
+	testHTTP("GET", "/share/", nil, http.StatusNotFound, nil, nil)
+	shares, accesses, err := store.MessageShareList(ctxbg, "mjl")
+	tcheck(t, err, "list share links")
+	tcompare(t, len(shares), 2)
+	tcompare(t, shares[1].Accesses, 1)
+	tcompare(t, len(accesses[1]), 1)
+	err = store.MessageShareRemove(ctxbg, "mjl", shares[1].ID)
+	tcheck(t, err, "revoke share link")
+	testHTTP("GET", "/"+sharePath, nil, http.StatusNotFound, nil, nil)
+	// Share link no longer works once the message is removed.
+	api.MessageDelete(ctx, []int64{inboxText.ID})
+	testHTTP("GET", "/"+shareRawPath, nil, http.StatusNotFound, nil, nil)
+
+	// Attachment links.
+	al := store.AttachmentLink{Account: "mjl", Filename: "test.txt", ContentType: "text/html", Expires: time.Now().Add(time.Hour)}
+	err = store.AttachmentLinkAdd(ctxbg, log, &al, strings.NewReader("<b>hi</b>"))
+	tcheck(t, err, "add attachment link")
+	ctHTMLNoCharset := [2]string{"Content-Type", "text/html"}
+	cdAttachment := [2]string{"Content-Disposition", "attachment; filename=test.txt"}
+	testHTTP("GET", "/attachment/"+al.Token, nil, http.StatusOK, httpHeaders{ctHTMLNoCharset, cdAttachment}, func(resp *http.Response) {
+		buf, err := io.ReadAll(resp.Body)
+		tcheck(t, err, "read body")
+		tcompare(t, string(buf), "<b>hi</b>")
+	})
+	testHTTP("POST", "/attachment/"+al.Token, nil, http.StatusMethodNotAllowed, nil, nil)
+	testHTTP("GET", "/attachment/bogus", nil, http.StatusNotFound, nil, nil)
+
 	// Logout invalidates the session. Must work exactly once.
 	// Normally the generic /api/ auth check returns a user error. We bypass it and
 	// check for the server error.
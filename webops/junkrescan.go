@@ -0,0 +1,268 @@
+package webops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/junk"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+)
+
+// ErrJunkRescanRunning is returned when starting a junk rescan for an account
+// that already has a rescan in progress.
+var ErrJunkRescanRunning = errors.New("junk rescan already in progress")
+
+// JunkRescanMaxMessages is the maximum number of most recent messages evaluated
+// in a junk rescan.
+const JunkRescanMaxMessages = 10000
+
+// Pause between evaluating messages in a background junk rescan, to limit the
+// load on the server. Variable for tests.
+var junkRescanPause = 20 * time.Millisecond
+
+// JunkRescanMove is a message that a junk rescan moves to another mailbox, or
+// would move in a dry run.
+type JunkRescanMove struct {
+	MessageID   int64
+	Received    time.Time
+	From        string // Address in message From header.
+	Subject     string
+	Mailbox     string  // Current mailbox.
+	DestMailbox string  // Inbox or the Junk mailbox.
+	Reason      string  // "allowed sender" or "junk filter".
+	Probability float64 // Junk probability by the junk filter, only for reason "junk filter".
+}
+
+// Accounts with a junk rescan in progress.
+var junkRescans = struct {
+	sync.Mutex
+	accounts map[string]bool
+}{accounts: map[string]bool{}}
+
+// JunkRescanRunning returns whether a background junk rescan is in progress for
+// the account.
+func JunkRescanRunning(accountName string) bool {
+	junkRescans.Lock()
+	defer junkRescans.Unlock()
+	return junkRescans.accounts[accountName]
+}
+
+// JunkRescanStart starts a background junk rescan of the account for messages
+// received since, see JunkRescan. Returns ErrJunkRescanRunning if a rescan is
+// already in progress for the account. The result is logged.
+func JunkRescanStart(log mlog.Log, accountName string, since time.Time) error {
+	junkRescans.Lock()
+	defer junkRescans.Unlock()
+	if junkRescans.accounts[accountName] {
+		return ErrJunkRescanRunning
+	}
+	junkRescans.accounts[accountName] = true
+
+	go func() {
+		defer func() {
+			junkRescans.Lock()
+			delete(junkRescans.accounts, accountName)
+			junkRescans.Unlock()
+		}()
+		defer func() {
+			x := recover()
+			if x != nil {
+				log.Error("unhandled panic in junk rescan", slog.Any("err", x))
+				debug.PrintStack()
+				metrics.PanicInc(metrics.Store)
+			}
+		}()
+
+		log = log.With(slog.String("account", accountName))
+		acc, err := store.OpenAccount(log, accountName, false)
+		if err != nil {
+			log.Errorx("open account for junk rescan", err)
+			return
+		}
+		defer func() {
+			err := acc.Close()
+			log.Check(err, "closing account after junk rescan")
+		}()
+
+		moves, err := JunkRescan(mox.Shutdown, log, acc, since, false, junkRescanPause)
+		if err != nil {
+			log.Errorx("junk rescan", err)
+		}
+		log.Info("junk rescan finished", slog.Int("moved", len(moves)))
+	}()
+	return nil
+}
+
+// JunkRescan evaluates the most recent messages received since in the Inbox and
+// the Junk mailbox against the current junk filter and sender allow list, and
+// moves messages that would now be classified differently: junk from the Inbox to
+// the Junk mailbox, and non-junk from the Junk mailbox to the Inbox. Messages from
+// allowed senders with verified From address are non-junk. Messages for which the
+// junk filter has no significant result are left alone.
+//
+// For a dry run, no messages are moved, but the moves that would be made are
+// returned. For non-dry runs, pause is slept between evaluating messages, to limit
+// the load on the server.
+//
+// The caller must not hold the account wlock.
+func JunkRescan(ctx context.Context, log mlog.Log, acc *store.Account, since time.Time, dryRun bool, pause time.Duration) (moves []JunkRescanMove, rerr error) {
+	var jf *junk.Filter
+	var threshold float64
+	if f, jfconf, err := acc.OpenJunkFilter(ctx, log); err == nil {
+		jf = f
+		threshold = jfconf.Threshold
+		defer func() {
+			err := jf.CloseDiscard()
+			log.Check(err, "closing junk filter after rescan")
+		}()
+	} else if !errors.Is(err, store.ErrNoJunkFilter) {
+		return nil, fmt.Errorf("open junk filter: %v", err)
+	}
+
+	var mbInbox, mbJunk store.Mailbox
+	var msgs []store.Message
+	err := acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+		var err error
+		mbInbox, err = bstore.QueryTx[store.Mailbox](tx).FilterNonzero(store.Mailbox{Name: "Inbox"}).FilterEqual("Expunged", false).Get()
+		if err != nil {
+			return fmt.Errorf("get inbox: %v", err)
+		}
+		mbJunk, err = bstore.QueryTx[store.Mailbox](tx).FilterEqual("Junk", true).FilterEqual("Expunged", false).Get()
+		if err == bstore.ErrAbsent {
+			return errors.New("no junk mailbox")
+		} else if err != nil {
+			return fmt.Errorf("get junk mailbox: %v", err)
+		}
+
+		q := bstore.QueryTx[store.Message](tx)
+		q.FilterEqual("MailboxID", mbInbox.ID, mbJunk.ID)
+		q.FilterEqual("Expunged", false)
+		q.FilterGreaterEqual("Received", since)
+		q.SortDesc("Received")
+		q.Limit(JunkRescanMaxMessages)
+		msgs, err = q.List()
+		if err != nil {
+			return fmt.Errorf("listing messages: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range msgs {
+		if ctx.Err() != nil {
+			return moves, ctx.Err()
+		}
+		if !dryRun && pause > 0 {
+			time.Sleep(pause)
+		}
+
+		mv, isJunk, ok, err := junkRescanEvaluate(ctx, acc, jf, threshold, m)
+		if err != nil {
+			log.Errorx("evaluating message for junk rescan", err, slog.Int64("msgid", m.ID))
+			continue
+		} else if !ok || isJunk == (m.MailboxID == mbJunk.ID) {
+			continue
+		}
+		mv.Mailbox, mv.DestMailbox = mbInbox.Name, mbJunk.Name
+		mbDst := mbJunk
+		if !isJunk {
+			mv.Mailbox, mv.DestMailbox = mbJunk.Name, mbInbox.Name
+			mbDst = mbInbox
+		}
+		if !dryRun {
+			if err := junkRescanMove(ctx, log, acc, m.ID, mbDst.ID); err != nil {
+				log.Errorx("moving message for junk rescan", err, slog.Int64("msgid", m.ID))
+				continue
+			}
+		}
+		moves = append(moves, mv)
+	}
+	return moves, nil
+}
+
+// junkRescanEvaluate returns whether m is junk according to the sender allow list
+// and junk filter. If ok is false, no decision could be made.
+func junkRescanEvaluate(ctx context.Context, acc *store.Account, jf *junk.Filter, threshold float64, m store.Message) (mv JunkRescanMove, isJunk, ok bool, rerr error) {
+	mv = JunkRescanMove{MessageID: m.ID, Received: m.Received}
+	if m.MsgFromDomain != "" {
+		mv.From = m.MsgFromLocalpart.String() + "@" + m.MsgFromDomain
+	}
+	p, err := m.LoadPart(acc.MessageReader(m))
+	if err != nil {
+		return mv, false, false, fmt.Errorf("load message part: %v", err)
+	}
+	if p.Envelope != nil {
+		mv.Subject = p.Envelope.Subject
+	}
+
+	if m.MsgFromValidated {
+		var allowed bool
+		err := acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+			var err error
+			allowed, err = store.SenderAllowed(tx, m.MsgFromLocalpart, m.MsgFromDomain)
+			return err
+		})
+		if err != nil {
+			return mv, false, false, fmt.Errorf("checking sender allow list: %v", err)
+		} else if allowed {
+			mv.Reason = "allowed sender"
+			return mv, false, true, nil
+		}
+	}
+
+	if jf == nil {
+		return mv, false, false, nil
+	}
+	result, err := jf.ClassifyMessage(ctx, p)
+	if err != nil {
+		return mv, false, false, fmt.Errorf("classify message: %v", err)
+	} else if !result.Significant {
+		return mv, false, false, nil
+	}
+	mv.Reason = "junk filter"
+	mv.Probability = result.Probability
+	return mv, result.Probability >= threshold, true, nil
+}
+
+// junkRescanMove moves a single message, turning the panics of XOps into an error.
+func junkRescanMove(ctx context.Context, log mlog.Log, acc *store.Account, messageID, mailboxID int64) (rerr error) {
+	type xerror struct{ err error }
+	xcheckf := func(ctx context.Context, err error, format string, args ...any) {
+		if err != nil {
+			panic(xerror{fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), err)})
+		}
+	}
+	x := XOps{
+		DBWrite: func(ctx context.Context, acc *store.Account, fn func(tx *bstore.Tx)) {
+			err := acc.DB.Write(ctx, func(tx *bstore.Tx) error {
+				fn(tx)
+				return nil
+			})
+			xcheckf(ctx, err, "transaction")
+		},
+		Checkf:     xcheckf,
+		Checkuserf: xcheckf,
+	}
+	defer func() {
+		x := recover()
+		if xerr, ok := x.(xerror); ok {
+			rerr = xerr.err
+		} else if x != nil {
+			panic(x)
+		}
+	}()
+	x.MessageMove(ctx, log, acc, []int64{messageID}, "", mailboxID)
+	return nil
+}
@@ -8,17 +8,13 @@ import (
 	"io"
 	"log/slog"
 	"os"
-	"path/filepath"
 	"slices"
-	"sort"
-	"time"
 
 	"github.com/mjl-/bstore"
 
 	"github.com/mjl-/mox/junk"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/mlog"
-	"github.com/mjl-/mox/moxio"
 	"github.com/mjl-/mox/store"
 )
 
@@ -384,38 +380,8 @@ func (x XOps) MessageMove(ctx context.Context, log mlog.Log, acc *store.Account,
 }
 
 // MessageMoveTx moves message to a new mailbox, which must be different than their
-// current mailbox. Moving a message is done by changing the MailboxID and
-// assigning an appriorate new UID, and then inserting a replacement Message record
-// with new ID that is marked expunged in the original mailbox, along with a
-// MessageErase record so the message gets erased when all sessions stopped
-// referencing the message.
+// current mailbox. See store.Account.MessageMove.
 func (x XOps) MessageMoveTx(ctx context.Context, log mlog.Log, acc *store.Account, tx *bstore.Tx, messageIDs []int64, mbDst store.Mailbox, modseq *store.ModSeq) ([]int64, []store.Change) {
-	var newIDs []int64
-	var commit bool
-	defer func() {
-		if commit {
-			return
-		}
-		for _, id := range newIDs {
-			p := acc.MessagePath(id)
-			err := os.Remove(p)
-			log.Check(err, "removing delivered message after failure", slog.String("path", p))
-		}
-		newIDs = nil
-	}()
-
-	// n adds, 1 remove, 2 mailboxcounts, 1 mailboxkeywords, optimistic that messages are in a single source mailbox.
-	changes := make([]store.Change, 0, len(messageIDs)+4)
-
-	var err error
-	if *modseq == 0 {
-		*modseq, err = acc.NextModSeq(tx)
-		x.Checkf(ctx, err, "assigning next modseq")
-	}
-
-	mbDst.ModSeq = *modseq
-
-	// Get messages. group them by mailbox.
 	l := make([]store.Message, len(messageIDs))
 	for i, id := range messageIDs {
 		l[i] = x.messageID(ctx, tx, id)
@@ -425,140 +391,8 @@ func (x XOps) MessageMoveTx(ctx context.Context, log mlog.Log, acc *store.Accoun
 		}
 	}
 
-	// Sort (group) by mailbox, sort by UID.
-	sort.Slice(l, func(i, j int) bool {
-		if l[i].MailboxID != l[j].MailboxID {
-			return l[i].MailboxID < l[j].MailboxID
-		}
-		return l[i].UID < l[j].UID
-	})
-
-	var jf *junk.Filter
-	defer func() {
-		if jf != nil {
-			err := jf.CloseDiscard()
-			log.Check(err, "close junk filter")
-		}
-	}()
-
-	accConf, _ := acc.Conf()
-
-	var mbSrc store.Mailbox
-	var changeRemoveUIDs store.ChangeRemoveUIDs
-	xflushMailbox := func() {
-		changeRemoveUIDs.UIDNext = mbSrc.UIDNext
-		changeRemoveUIDs.MessageCountIMAP = mbSrc.MessageCountIMAP()
-		changeRemoveUIDs.Unseen = uint32(mbSrc.MailboxCounts.Unseen)
-		changes = append(changes, changeRemoveUIDs, mbSrc.ChangeCounts())
-
-		err = tx.Update(&mbSrc)
-		x.Checkf(ctx, err, "updating source mailbox counts")
-	}
-
-	nkeywords := len(mbDst.Keywords)
-	now := time.Now()
-
-	syncDirs := map[string]struct{}{}
-
-	for _, om := range l {
-		if om.MailboxID != mbSrc.ID {
-			if mbSrc.ID != 0 {
-				xflushMailbox()
-			}
-			mbSrc = x.mailboxID(ctx, tx, om.MailboxID)
-			mbSrc.ModSeq = *modseq
-			changeRemoveUIDs = store.ChangeRemoveUIDs{MailboxID: mbSrc.ID, ModSeq: *modseq}
-		}
-
-		nm := om
-		nm.MailboxID = mbDst.ID
-		nm.UID = mbDst.UIDNext
-		err := mbDst.UIDNextAdd(1)
-		x.Checkf(ctx, err, "adding uid")
-		nm.ModSeq = *modseq
-		nm.CreateSeq = *modseq
-		nm.SaveDate = &now
-		if nm.IsReject && nm.MailboxDestinedID != 0 {
-			// Incorrectly delivered to Rejects mailbox. Adjust MailboxOrigID so this message
-			// is used for reputation calculation during future deliveries.
-			nm.MailboxOrigID = nm.MailboxDestinedID
-			nm.IsReject = false
-			nm.Seen = false
-		}
-		if mbDst.Trash {
-			nm.Seen = true
-		}
-
-		nm.JunkFlagsForMailbox(mbDst, accConf)
-
-		err = tx.Update(&nm)
-		x.Checkf(ctx, err, "updating message with new mailbox")
-
-		mbDst.Add(nm.MailboxCounts())
-
-		mbSrc.Sub(om.MailboxCounts())
-		om.ID = 0
-		om.Expunged = true
-		om.ModSeq = *modseq
-		om.TrainedJunk = nil
-		err = tx.Insert(&om)
-		x.Checkf(ctx, err, "inserting expunged message in old mailbox")
-
-		dstPath := acc.MessagePath(om.ID)
-		dstDir := filepath.Dir(dstPath)
-		if _, ok := syncDirs[dstDir]; !ok {
-			os.MkdirAll(dstDir, 0770)
-			syncDirs[dstDir] = struct{}{}
-		}
-
-		err = moxio.LinkOrCopy(log, dstPath, acc.MessagePath(nm.ID), nil, false)
-		x.Checkf(ctx, err, "duplicating message in old mailbox for current sessions")
-		newIDs = append(newIDs, nm.ID)
-		// We don't sync the directory. In case of a crash and files disappearing, the
-		// eraser will simply not find the file at next startup.
-
-		err = tx.Insert(&store.MessageErase{ID: om.ID, SkipUpdateDiskUsage: true})
-		x.Checkf(ctx, err, "insert message erase")
-
-		mbDst.Keywords, _ = store.MergeKeywords(mbDst.Keywords, nm.Keywords)
-
-		if accConf.JunkFilter != nil && nm.NeedsTraining() {
-			// Lazily open junk filter.
-			if jf == nil {
-				jf, _, err = acc.OpenJunkFilter(ctx, log)
-				x.Checkf(ctx, err, "open junk filter")
-			}
-			err := acc.RetrainMessage(ctx, log, tx, jf, &nm)
-			x.Checkf(ctx, err, "retrain message after moving")
-		}
-
-		changeRemoveUIDs.UIDs = append(changeRemoveUIDs.UIDs, om.UID)
-		changeRemoveUIDs.MsgIDs = append(changeRemoveUIDs.MsgIDs, om.ID)
-		changes = append(changes, nm.ChangeAddUID(mbDst))
-	}
-
-	for dir := range syncDirs {
-		err := moxio.SyncDir(log, dir)
-		x.Checkf(ctx, err, "sync directory")
-	}
-
-	xflushMailbox()
-
-	changes = append(changes, mbDst.ChangeCounts())
-	if nkeywords > len(mbDst.Keywords) {
-		changes = append(changes, mbDst.ChangeKeywords())
-	}
-
-	err = tx.Update(&mbDst)
-	x.Checkf(ctx, err, "updating destination mailbox with uidnext and modseq")
-
-	if jf != nil {
-		err := jf.Close()
-		x.Checkf(ctx, err, "saving junk filter")
-		jf = nil
-	}
-
-	commit = true
+	newIDs, changes, err := acc.MessageMove(ctx, log, tx, &mbDst, modseq, l...)
+	x.Checkf(ctx, err, "moving messages")
 	return newIDs, changes
 }
 
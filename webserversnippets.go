@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/mjl-/mox/http"
+	"github.com/mjl-/mox/mox-"
+)
+
+func cmdConfigWebserverSnippets(c *cmd) {
+	c.params = "[-watch] [-proxy url] dir"
+	c.help = `Export configuration snippets and static files for an existing webserver.
+
+For setups where an existing webserver handles ports 80 and 443 (see "mox
+quickstart -existing-webserver"), the MTA-STS policies and autoconfig files of
+all configured domains can be served as static files by that webserver, instead
+of forwarding the requests to mox.
+
+Static files are written to dir/static/<hostname>/, for the mta-sts.<domain>
+and autoconfig.<domain> hostnames. Configuration snippets with a virtual host
+for each hostname are written to dir/nginx.conf, dir/Caddyfile and
+dir/apache.conf, for including in the webserver configuration. Paths to TLS
+certificates must still be configured for nginx and apache. Autodiscover
+requests include the email address and are forwarded to mox at the proxy URL,
+by default the address of the listener serving autoconfig without TLS.
+
+With -watch, the command keeps running and regenerates the files when the
+domains configuration changes. The webserver may need a reload to pick up new
+virtual hosts.
+`
+	var watch bool
+	var proxyURL string
+	c.flag.BoolVar(&watch, "watch", false, "keep running and regenerate files when domains.conf changes")
+	c.flag.StringVar(&proxyURL, "proxy", "", "url to forward autodiscover requests to, e.g. http://127.0.0.1:81; default is based on the listener config")
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+	dir := args[0]
+
+	mustLoadConfig()
+	err := http.WebserverExport(c.log, dir, proxyURL)
+	xcheckf(err, "exporting webserver files")
+	if !watch {
+		return
+	}
+
+	fi, err := os.Stat(mox.ConfigDynamicPath)
+	xcheckf(err, "stat domains config")
+	mtime := fi.ModTime()
+	for {
+		time.Sleep(5 * time.Second)
+		fi, err := os.Stat(mox.ConfigDynamicPath)
+		if err != nil {
+			log.Printf("stat domains config: %v", err)
+			continue
+		} else if fi.ModTime().Equal(mtime) {
+			continue
+		}
+		// Domains config is reloaded on access.
+		if err := http.WebserverExport(c.log, dir, proxyURL); err != nil {
+			log.Printf("exporting webserver files: %v", err)
+			continue
+		}
+		mtime = fi.ModTime()
+		log.Printf("domains config changed, webserver files regenerated")
+	}
+}
@@ -0,0 +1,56 @@
+// Package wkd implements publishing OpenPGP public keys through the Web Key
+// Directory (WKD) and OPENPGPKEY DNS records (RFC 7929). Keys are parsed with
+// package openpgp.
+package wkd
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+)
+
+const zbase32Alphabet = "ybndrfg8ejkmcpqxot1uwisza345h769"
+
+// zbase32 encodes buf with the z-base-32 encoding, as used by WKD.
+func zbase32(buf []byte) string {
+	var r strings.Builder
+	var bits, nbits uint
+	for _, b := range buf {
+		bits = bits<<8 | uint(b)
+		nbits += 8
+		for nbits >= 5 {
+			nbits -= 5
+			r.WriteByte(zbase32Alphabet[(bits>>nbits)&0x1f])
+		}
+	}
+	if nbits > 0 {
+		r.WriteByte(zbase32Alphabet[(bits<<(5-nbits))&0x1f])
+	}
+	return r.String()
+}
+
+// Hash returns the WKD hash for a localpart: the z-base-32 encoded SHA-1 hash of
+// the lower-cased localpart. It is used in the URL path for the key.
+func Hash(localpart smtp.Localpart) string {
+	sum := sha1.Sum([]byte(strings.ToLower(string(localpart))))
+	return zbase32(sum[:])
+}
+
+// URL returns the URL for a key using the "advanced" method, at the openpgpkey
+// subdomain.
+func URL(addr smtp.Address) string {
+	return fmt.Sprintf("https://openpgpkey.%s/.well-known/openpgpkey/%s/hu/%s", addr.Domain.ASCII, addr.Domain.ASCII, Hash(addr.Localpart))
+}
+
+// DNSName returns the DNS name for an OPENPGPKEY record for the address: the
+// hex-encoded first 28 bytes of the SHA-256 hash of the localpart, under
+// _openpgpkey. ../rfc/7929
+func DNSName(localpart smtp.Localpart, domain dns.Domain) string {
+	sum := sha256.Sum256([]byte(localpart))
+	return hex.EncodeToString(sum[:28]) + "._openpgpkey." + domain.ASCII + "."
+}
@@ -0,0 +1,24 @@
+package wkd
+
+import (
+	"testing"
+
+	"github.com/mjl-/mox/dns"
+)
+
+func TestHash(t *testing.T) {
+	// Example from draft-koch-openpgp-webkey-service.
+	if h := Hash("Joe.Doe"); h != "iy9q119eutrkn8s1mk4r39qejnbu3n5q" {
+		t.Fatalf("got hash %s", h)
+	}
+	// From "gpg --with-wkd-hash".
+	if h := Hash("mjl"); h != "5q37smfks8u59hc7muif74478m1hmgqg" {
+		t.Fatalf("got hash %s", h)
+	}
+
+	// Example from RFC 7929.
+	name := DNSName("hugh", dns.Domain{ASCII: "example.com"})
+	if name != "c93f1e400f26708f98cb19d936620da35eec8f72e57f9eec01c1afd6._openpgpkey.example.com." {
+		t.Fatalf("got dns name %s", name)
+	}
+}